@@ -0,0 +1,726 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/cockroachdb/pebble/vfs/atomicfs"
+)
+
+// A backup directory holds generations of backups of a DB, each of them a
+// consistent snapshot of the DB like a checkpoint. The sstables of all the
+// generations, and the blob files holding their separated values, are stored
+// once, in the tables directory, and each generation is a directory with the
+// MANIFEST, OPTIONS and WALs of the snapshot and a BACKUP file that lists its
+// sstables and blob files:
+//
+//	<backup-dir>/tables/000005.sst
+//	<backup-dir>/tables/000006.blob
+//	<backup-dir>/tables/000007.sst
+//	<backup-dir>/generation-000001/{BACKUP,MANIFEST-000001,OPTIONS-000003,...}
+//	<backup-dir>/generation-000002/{BACKUP,MANIFEST-000001,OPTIONS-000003,...}
+//
+// The BACKUP file is written last, so a generation whose backup failed or was
+// interrupted has none and is ignored.
+const (
+	backupTablesDir        = "tables"
+	backupGenerationPrefix = "generation-"
+	backupMetadataFile     = "BACKUP"
+	backupMetadataHeader   = "pebble-backup v1"
+)
+
+// castagnoliTable is the table of the CRC-32C checksums of the sstables in
+// backups.
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// BackupGeneration describes a generation of a backup directory (see
+// DB.Backup).
+type BackupGeneration struct {
+	// Num is the number of the generation. Generations are numbered in the
+	// order they are created, starting at 1.
+	Num uint64
+	// CreatedAt is the time the backup of the generation started.
+	CreatedAt time.Time
+	// Tables are the sstables of the generation, sorted by file number.
+	Tables []BackupTable
+	// BlobFiles are the blob files referenced by the sstables of the
+	// generation (see Options.Experimental.ValueSeparation), sorted by file
+	// number.
+	BlobFiles []BackupTable
+	// Files are the other files of the generation, such as the MANIFEST,
+	// OPTIONS and WALs, and their sizes.
+	Files map[string]int64
+	// CopiedTables and CopiedBytes are the number of sstables and blob files,
+	// and their size, that were copied by the backup of the generation because
+	// they weren't in earlier generations.
+	CopiedTables int
+	CopiedBytes  int64
+}
+
+// BackupTable describes an sstable, or a blob file, of a backup generation.
+type BackupTable struct {
+	FileNum base.DiskFileNum
+	Size    int64
+	// Checksum is the CRC-32C (Castagnoli) checksum of the file.
+	Checksum uint32
+}
+
+// Backup adds a generation to the backup directory backupDir, which is
+// created if it doesn't exist, and returns it. The generation is a consistent
+// snapshot of the DB, as constructed by Checkpoint, except that only the
+// sstables and blob files that aren't in previous generations of the
+// directory are copied.
+// The backup directory must only hold backups of this DB, and must not be
+// modified by other calls to Backup or by PruneBackups while the backup
+// runs.
+//
+// The files are always copied, never hard linked. The WithFlushedWAL,
+// WithCopyRateLimit, WithProgress and WithContext options apply to backups as
+// they do to checkpoints; WithRestrictToSpans isn't supported. The progress
+// doesn't include the sstables captured by previous generations, which aren't
+// copied. Backups of DBs with sstables on remote storage aren't supported.
+func (d *DB) Backup(
+	backupDir string, opts ...CheckpointOption,
+) (
+	_ BackupGeneration,
+	bkErr error, /* used in deferred cleanup */
+) {
+	opt := &checkpointOptions{}
+	for _, fn := range opts {
+		fn(opt)
+	}
+	if len(opt.restrictToSpans) > 0 {
+		return BackupGeneration{}, errors.New("pebble: backups cannot be restricted to spans")
+	}
+
+	// Collect the sstables and blob files captured by previous generations.
+	generations, err := ListBackups(d.opts.FS, backupDir)
+	if err != nil {
+		return BackupGeneration{}, err
+	}
+	captured := make(map[base.DiskFileNum]BackupTable)
+	var gen BackupGeneration
+	gen.Num = 1
+	for _, g := range generations {
+		for _, t := range g.Tables {
+			captured[t.FileNum] = t
+		}
+		for _, t := range g.BlobFiles {
+			captured[t.FileNum] = t
+		}
+		gen.Num = g.Num + 1
+	}
+	// Strip the monotonic clock reading, which isn't persisted.
+	gen.CreatedAt = d.timeNow().Round(0)
+	gen.Files = make(map[string]int64)
+
+	if opt.flushWAL && !d.opts.DisableWAL {
+		// Write an empty log-data record to flush and sync the WAL.
+		if err := d.LogData(nil /* data */, Sync); err != nil {
+			return BackupGeneration{}, err
+		}
+	}
+
+	// Disable file deletions, and capture the state of the DB as Checkpoint
+	// does.
+	d.mu.Lock()
+	d.disableFileDeletions()
+	defer func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.enableFileDeletions()
+	}()
+	d.mu.versions.logLock()
+	memQueue := d.mu.mem.queue
+	current := d.mu.versions.currentVersion()
+	formatVers := d.FormatMajorVersion()
+	manifestFileNum := d.mu.versions.manifestFileNum
+	manifestSize := d.mu.versions.manifest.Size()
+	optionsFileNum := d.optionsFileNum
+	blobFiles := make(map[base.DiskFileNum]manifest.BlobFileMetadata)
+	for _, meta := range d.mu.versions.blobFileMetadataLocked() {
+		blobFiles[meta.FileNum] = meta
+	}
+	d.mu.versions.logUnlock()
+	d.mu.Unlock()
+
+	fs := vfs.NewSyncingFS(d.opts.FS, vfs.SyncingFileOptions{
+		NoSyncOnClose: d.opts.NoSyncOnClose,
+		BytesPerSync:  d.opts.BytesPerSync,
+	})
+	c := newCheckpointCopier(fs, opt)
+
+	// Collect the sstables and blob files of the generation, and those of them
+	// to copy.
+	type backupCopy struct {
+		path  string
+		table BackupTable
+		blob  bool
+	}
+	var toCopy []backupCopy
+	seen := make(map[base.DiskFileNum]struct{})
+	for l := range current.Levels {
+		iter := current.Levels[l].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			for _, ref := range f.BlobReferences {
+				if _, ok := seen[ref.FileNum]; ok {
+					continue
+				}
+				seen[ref.FileNum] = struct{}{}
+				if t, ok := captured[ref.FileNum]; ok {
+					gen.BlobFiles = append(gen.BlobFiles, t)
+					continue
+				}
+				meta, ok := blobFiles[ref.FileNum]
+				if !ok {
+					return BackupGeneration{}, errors.AssertionFailedf(
+						"pebble: sstable %s references unknown blob file %s", f.FileNum, ref.FileNum)
+				}
+				objMeta, err := d.objProvider.Lookup(fileTypeBlob, ref.FileNum)
+				if err != nil {
+					return BackupGeneration{}, err
+				}
+				t := BackupTable{FileNum: ref.FileNum, Size: int64(meta.Size)}
+				toCopy = append(toCopy, backupCopy{path: d.objProvider.Path(objMeta), table: t, blob: true})
+				c.addFile(t.Size)
+			}
+			fileNum := f.FileBacking.DiskFileNum
+			if _, ok := seen[fileNum]; ok {
+				// A backing shared by virtual sstables.
+				continue
+			}
+			seen[fileNum] = struct{}{}
+			objMeta, err := d.objProvider.Lookup(fileTypeTable, fileNum)
+			if err != nil {
+				return BackupGeneration{}, err
+			}
+			if objMeta.IsRemote() {
+				return BackupGeneration{}, errors.Errorf(
+					"pebble: backups of sstables on remote storage are not supported (sstable %s)", fileNum)
+			}
+			if t, ok := captured[fileNum]; ok {
+				gen.Tables = append(gen.Tables, t)
+				continue
+			}
+			t := BackupTable{FileNum: fileNum, Size: int64(f.FileBacking.Size)}
+			toCopy = append(toCopy, backupCopy{path: d.objProvider.Path(objMeta), table: t})
+			c.addFile(t.Size)
+		}
+	}
+
+	genDir := fs.PathJoin(backupDir, fmt.Sprintf("%s%06d", backupGenerationPrefix, gen.Num))
+	tablesDir := fs.PathJoin(backupDir, backupTablesDir)
+	// A directory left by a failed backup has no BACKUP file, and isn't
+	// listed.
+	if err := fs.RemoveAll(genDir); err != nil {
+		return BackupGeneration{}, err
+	}
+	var dir, tdir vfs.File
+	var copied []string
+	defer func() {
+		if dir != nil {
+			_ = dir.Close()
+		}
+		if tdir != nil {
+			_ = tdir.Close()
+		}
+		if bkErr != nil {
+			// Attempt to cleanup on error.
+			_ = fs.RemoveAll(genDir)
+			for _, path := range copied {
+				_ = fs.Remove(path)
+			}
+		}
+	}()
+	if dir, bkErr = mkdirAllAndSyncParents(fs, genDir); bkErr != nil {
+		return BackupGeneration{}, bkErr
+	}
+	if tdir, bkErr = mkdirAllAndSyncParents(fs, tablesDir); bkErr != nil {
+		return BackupGeneration{}, bkErr
+	}
+
+	// Copy the new sstables and blob files.
+	for _, bc := range toCopy {
+		dst := fs.PathJoin(tablesDir, fs.PathBase(bc.path))
+		copied = append(copied, dst)
+		bc.table.Checksum, bkErr = c.copyWithChecksum(bc.path, dst, bc.table.Size)
+		if bkErr != nil {
+			return BackupGeneration{}, bkErr
+		}
+		if bc.blob {
+			gen.BlobFiles = append(gen.BlobFiles, bc.table)
+		} else {
+			gen.Tables = append(gen.Tables, bc.table)
+		}
+		gen.CopiedTables++
+		gen.CopiedBytes += bc.table.Size
+	}
+	if bkErr = tdir.Sync(); bkErr != nil {
+		return BackupGeneration{}, bkErr
+	}
+	for _, tables := range [][]BackupTable{gen.Tables, gen.BlobFiles} {
+		sort.Slice(tables, func(i, j int) bool {
+			return tables[i].FileNum.FileNum() < tables[j].FileNum.FileNum()
+		})
+	}
+
+	// Copy the OPTIONS, the MANIFEST and the WALs, and set the format major
+	// version marker.
+	recordFile := func(name string) error {
+		info, err := fs.Stat(fs.PathJoin(genDir, name))
+		if err != nil {
+			return err
+		}
+		gen.Files[name] = info.Size()
+		return nil
+	}
+	copyFile := func(srcPath string) error {
+		size, err := c.statFile(srcPath)
+		if err != nil {
+			return err
+		}
+		c.addFile(size)
+		if err := c.copy(srcPath, fs.PathJoin(genDir, fs.PathBase(srcPath)), size); err != nil {
+			return err
+		}
+		return recordFile(fs.PathBase(srcPath))
+	}
+	if bkErr = copyFile(base.MakeFilepath(fs, d.dirname, fileTypeOptions, optionsFileNum)); bkErr != nil {
+		return BackupGeneration{}, bkErr
+	}
+	versionMarker, _, bkErr := atomicfs.LocateMarker(fs, genDir, formatVersionMarkerName)
+	if bkErr != nil {
+		return BackupGeneration{}, bkErr
+	}
+	if bkErr = versionMarker.Move(formatVers.String()); bkErr != nil {
+		return BackupGeneration{}, bkErr
+	}
+	if bkErr = versionMarker.Close(); bkErr != nil {
+		return BackupGeneration{}, bkErr
+	}
+	if bkErr = c.err(); bkErr != nil {
+		return BackupGeneration{}, bkErr
+	}
+	bkErr = d.writeCheckpointManifest(
+		fs, formatVers, genDir, dir, manifestFileNum.DiskFileNum(), manifestSize,
+		nil /* excludedFiles */, nil, /* removeBackingTables */
+	)
+	if bkErr != nil {
+		return BackupGeneration{}, bkErr
+	}
+	if bkErr = recordFile(base.MakeFilename(fileTypeManifest, manifestFileNum.DiskFileNum())); bkErr != nil {
+		return BackupGeneration{}, bkErr
+	}
+	for i := range memQueue {
+		logNum := memQueue[i].logNum
+		if logNum == 0 {
+			continue
+		}
+		if bkErr = copyFile(base.MakeFilepath(fs, d.walDirname, fileTypeLog, logNum.DiskFileNum())); bkErr != nil {
+			return BackupGeneration{}, bkErr
+		}
+	}
+
+	// Finally, write the BACKUP file, which makes the generation part of the
+	// backup.
+	if bkErr = writeBackupMetadata(fs, genDir, &gen); bkErr != nil {
+		return BackupGeneration{}, bkErr
+	}
+	if bkErr = dir.Sync(); bkErr != nil {
+		return BackupGeneration{}, bkErr
+	}
+	return gen, nil
+}
+
+// ListBackups returns the generations of the backup directory backupDir, in
+// the order they were created. Generations whose backup failed or is in
+// progress aren't included. It returns no generations if backupDir doesn't
+// exist.
+func ListBackups(fs vfs.FS, backupDir string) ([]BackupGeneration, error) {
+	names, err := fs.List(backupDir)
+	if err != nil {
+		if oserror.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var generations []BackupGeneration
+	for _, name := range names {
+		num, ok := parseBackupGenerationDir(name)
+		if !ok {
+			continue
+		}
+		gen, err := readBackupMetadata(fs, fs.PathJoin(backupDir, name))
+		if err != nil {
+			if oserror.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		if gen.Num != num {
+			return nil, errors.Errorf("pebble: backup generation %s has number %d", name, gen.Num)
+		}
+		generations = append(generations, gen)
+	}
+	sort.Slice(generations, func(i, j int) bool { return generations[i].Num < generations[j].Num })
+	return generations, nil
+}
+
+// VerifyBackup checks that the files of a generation of the backup directory
+// backupDir exist with the expected sizes, and that the checksums of its
+// sstables and blob files match.
+func VerifyBackup(fs vfs.FS, backupDir string, generation uint64) error {
+	genDir := backupGenerationDir(fs, backupDir, generation)
+	gen, err := readBackupMetadata(fs, genDir)
+	if err != nil {
+		return errors.Wrapf(err, "pebble: backup generation %d", generation)
+	}
+	for name, size := range gen.Files {
+		info, err := fs.Stat(fs.PathJoin(genDir, name))
+		if err != nil {
+			return errors.Wrapf(err, "pebble: backup generation %d", generation)
+		}
+		if info.Size() != size {
+			return errors.Errorf("pebble: backup generation %d: %s has size %d, expected %d",
+				generation, name, info.Size(), size)
+		}
+	}
+	buf := make([]byte, checkpointCopyChunkSize)
+	verify := func(fileType base.FileType, t BackupTable) error {
+		path := base.MakeFilepath(fs, fs.PathJoin(backupDir, backupTablesDir), fileType, t.FileNum)
+		size, sum, err := checksumFile(fs, path, buf)
+		if err != nil {
+			return errors.Wrapf(err, "pebble: backup generation %d", generation)
+		}
+		if size != t.Size {
+			return errors.Errorf("pebble: backup generation %d: %s has size %d, expected %d",
+				generation, fs.PathBase(path), size, t.Size)
+		}
+		if sum != t.Checksum {
+			return errors.Errorf("pebble: backup generation %d: %s has checksum %08x, expected %08x",
+				generation, fs.PathBase(path), sum, t.Checksum)
+		}
+		return nil
+	}
+	for _, t := range gen.Tables {
+		if err := verify(fileTypeTable, t); err != nil {
+			return err
+		}
+	}
+	for _, t := range gen.BlobFiles {
+		if err := verify(fileTypeBlob, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PruneBackups removes all but the keep most recent generations of the
+// backup directory backupDir, along with the sstables and blob files no
+// remaining generation references and the directories of failed backups, and returns the numbers
+// of the removed generations. It must not run concurrently with a backup into
+// the directory.
+func PruneBackups(fs vfs.FS, backupDir string, keep int) ([]uint64, error) {
+	if keep < 1 {
+		return nil, errors.New("pebble: at least one backup generation must be kept")
+	}
+	generations, err := ListBackups(fs, backupDir)
+	if err != nil {
+		return nil, err
+	}
+	var removed []uint64
+	complete := make(map[uint64]struct{})
+	for i, g := range generations {
+		if i < len(generations)-keep {
+			removed = append(removed, g.Num)
+			continue
+		}
+		complete[g.Num] = struct{}{}
+	}
+	// Remove the pruned generations, and the directories of failed backups.
+	// The BACKUP file is removed first, so that a partially removed generation
+	// isn't listed.
+	names, err := fs.List(backupDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		num, ok := parseBackupGenerationDir(name)
+		if !ok {
+			continue
+		}
+		if _, ok := complete[num]; ok {
+			continue
+		}
+		genDir := fs.PathJoin(backupDir, name)
+		if err := fs.Remove(fs.PathJoin(genDir, backupMetadataFile)); err != nil && !oserror.IsNotExist(err) {
+			return nil, err
+		}
+		if err := fs.RemoveAll(genDir); err != nil {
+			return nil, err
+		}
+	}
+
+	// Remove the sstables and blob files that are no longer referenced.
+	referenced := make(map[base.DiskFileNum]struct{})
+	for _, g := range generations[len(removed):] {
+		for _, t := range g.Tables {
+			referenced[t.FileNum] = struct{}{}
+		}
+		for _, t := range g.BlobFiles {
+			referenced[t.FileNum] = struct{}{}
+		}
+	}
+	tablesDir := fs.PathJoin(backupDir, backupTablesDir)
+	tables, err := fs.List(tablesDir)
+	if err != nil && !oserror.IsNotExist(err) {
+		return nil, err
+	}
+	for _, name := range tables {
+		fileType, fileNum, ok := base.ParseFilename(fs, name)
+		if !ok || (fileType != fileTypeTable && fileType != fileTypeBlob) {
+			continue
+		}
+		if _, ok := referenced[fileNum]; !ok {
+			if err := fs.Remove(fs.PathJoin(tablesDir, name)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return removed, nil
+}
+
+// RestoreBackup copies a generation of the backup directory backupDir into
+// destDir, which must not exist, as a DB that can be opened. The restore can
+// be restricted to key ranges with WithRestoreSpans.
+func RestoreBackup(
+	fs vfs.FS, backupDir string, generation uint64, destDir string, opts ...RestoreOption,
+) (err error) {
+	r, err := newRestorer(fs, destDir, opts)
+	if err != nil {
+		return err
+	}
+	genDir := backupGenerationDir(fs, backupDir, generation)
+	gen, err := readBackupMetadata(fs, genDir)
+	if err != nil {
+		return errors.Wrapf(err, "pebble: backup generation %d", generation)
+	}
+	if _, err := fs.Stat(destDir); !oserror.IsNotExist(err) {
+		if err == nil {
+			return errors.Errorf("pebble: %s already exists", destDir)
+		}
+		return err
+	}
+	dir, err := mkdirAllAndSyncParents(fs, destDir)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = dir.Close()
+		if err != nil {
+			_ = fs.RemoveAll(destDir)
+		}
+	}()
+	// Restore all the files of the generation, including the markers, except
+	// for the BACKUP file.
+	names, err := fs.List(genDir)
+	if err != nil {
+		return err
+	}
+	var files []restoreFile
+	for _, name := range names {
+		if name == backupMetadataFile {
+			continue
+		}
+		src := fs.PathJoin(genDir, name)
+		size, err := r.c.statFile(src)
+		if err != nil {
+			return err
+		}
+		files = append(files, restoreFile{name: name, size: size, copy: func(c *checkpointCopier, dst string) error {
+			return c.copy(src, dst, size)
+		}})
+	}
+	tables := make(map[base.DiskFileNum]restoreFile, len(gen.Tables))
+	blobFiles := make(map[base.DiskFileNum]restoreFile, len(gen.BlobFiles))
+	for _, t := range gen.Tables {
+		tables[t.FileNum] = backupRestoreFile(fs, backupDir, fileTypeTable, t)
+	}
+	for _, t := range gen.BlobFiles {
+		blobFiles[t.FileNum] = backupRestoreFile(fs, backupDir, fileTypeBlob, t)
+	}
+	if err := r.restoreFiles(files, tables, blobFiles); err != nil {
+		return err
+	}
+	if err := dir.Sync(); err != nil {
+		return err
+	}
+	return r.excise()
+}
+
+// backupRestoreFile returns the restoreFile of an sstable or blob file stored
+// in the tables directory of the backup directory backupDir.
+func backupRestoreFile(
+	fs vfs.FS, backupDir string, fileType base.FileType, t BackupTable,
+) restoreFile {
+	name := base.MakeFilename(fileType, t.FileNum)
+	src := fs.PathJoin(backupDir, backupTablesDir, name)
+	size := t.Size
+	return restoreFile{name: name, size: size, copy: func(c *checkpointCopier, dst string) error {
+		return c.copy(src, dst, size)
+	}}
+}
+
+func backupGenerationDir(fs vfs.FS, backupDir string, generation uint64) string {
+	return fs.PathJoin(backupDir, fmt.Sprintf("%s%06d", backupGenerationPrefix, generation))
+}
+
+func parseBackupGenerationDir(name string) (uint64, bool) {
+	if !strings.HasPrefix(name, backupGenerationPrefix) {
+		return 0, false
+	}
+	num, err := strconv.ParseUint(name[len(backupGenerationPrefix):], 10, 64)
+	return num, err == nil
+}
+
+// checksumFile returns the size and the CRC-32C checksum of the file at path.
+func checksumFile(fs vfs.FS, path string, buf []byte) (int64, uint32, error) {
+	f, err := fs.Open(path, vfs.SequentialReadsOption)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	var size int64
+	var sum uint32
+	for {
+		n, err := f.Read(buf)
+		size += int64(n)
+		sum = crc32.Update(sum, castagnoliTable, buf[:n])
+		if err == io.EOF {
+			return size, sum, nil
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+}
+
+// writeBackupMetadata writes the BACKUP file of a generation. The file is
+// written under a temporary name and renamed, so that it's either complete or
+// absent.
+func writeBackupMetadata(fs vfs.FS, genDir string, gen *BackupGeneration) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s\n", backupMetadataHeader)
+	fmt.Fprintf(&buf, "generation %d\n", gen.Num)
+	fmt.Fprintf(&buf, "created %d\n", gen.CreatedAt.UnixNano())
+	fmt.Fprintf(&buf, "copied %d %d\n", gen.CopiedTables, gen.CopiedBytes)
+	for _, t := range gen.Tables {
+		fmt.Fprintf(&buf, "table %s %d %08x\n", t.FileNum, t.Size, t.Checksum)
+	}
+	for _, t := range gen.BlobFiles {
+		fmt.Fprintf(&buf, "blob %s %d %08x\n", t.FileNum, t.Size, t.Checksum)
+	}
+	names := make([]string, 0, len(gen.Files))
+	for name := range gen.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&buf, "file %s %d\n", name, gen.Files[name])
+	}
+
+	tmpPath := fs.PathJoin(genDir, backupMetadataFile+".tmp")
+	f, err := fs.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := errors.CombineErrors(f.Sync(), f.Close()); err != nil {
+		return err
+	}
+	return fs.Rename(tmpPath, fs.PathJoin(genDir, backupMetadataFile))
+}
+
+// readBackupMetadata reads the BACKUP file of a generation.
+func readBackupMetadata(fs vfs.FS, genDir string) (BackupGeneration, error) {
+	path := fs.PathJoin(genDir, backupMetadataFile)
+	f, err := fs.Open(path)
+	if err != nil {
+		return BackupGeneration{}, err
+	}
+	defer f.Close()
+
+	gen := BackupGeneration{Files: make(map[string]int64)}
+	s := bufio.NewScanner(f)
+	if !s.Scan() || s.Text() != backupMetadataHeader {
+		return BackupGeneration{}, base.CorruptionErrorf("pebble: invalid backup metadata %s", path)
+	}
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		var err error
+		switch {
+		case fields[0] == "generation" && len(fields) == 2:
+			gen.Num, err = strconv.ParseUint(fields[1], 10, 64)
+		case fields[0] == "created" && len(fields) == 2:
+			var nanos int64
+			nanos, err = strconv.ParseInt(fields[1], 10, 64)
+			gen.CreatedAt = time.Unix(0, nanos)
+		case fields[0] == "copied" && len(fields) == 3:
+			gen.CopiedTables, err = strconv.Atoi(fields[1])
+			if err == nil {
+				gen.CopiedBytes, err = strconv.ParseInt(fields[2], 10, 64)
+			}
+		case (fields[0] == "table" || fields[0] == "blob") && len(fields) == 4:
+			var fileNum uint64
+			var t BackupTable
+			var sum uint64
+			fileNum, err = strconv.ParseUint(fields[1], 10, 64)
+			if err == nil {
+				t.Size, err = strconv.ParseInt(fields[2], 10, 64)
+			}
+			if err == nil {
+				sum, err = strconv.ParseUint(fields[3], 16, 32)
+			}
+			t.FileNum = base.FileNum(fileNum).DiskFileNum()
+			t.Checksum = uint32(sum)
+			if fields[0] == "blob" {
+				gen.BlobFiles = append(gen.BlobFiles, t)
+			} else {
+				gen.Tables = append(gen.Tables, t)
+			}
+		case fields[0] == "file" && len(fields) == 3:
+			gen.Files[fields[1]], err = strconv.ParseInt(fields[2], 10, 64)
+		default:
+			err = errors.Errorf("unknown line %q", s.Text())
+		}
+		if err != nil {
+			return BackupGeneration{}, base.CorruptionErrorf("pebble: invalid backup metadata %s: %v", path, err)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return BackupGeneration{}, err
+	}
+	return gen, nil
+}
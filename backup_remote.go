@@ -0,0 +1,702 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
+	"github.com/cockroachdb/pebble/objstorage/remote"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/cockroachdb/pebble/vfs/atomicfs"
+)
+
+// A remote backup is a consistent snapshot of a DB, as constructed by
+// Checkpoint, uploaded to remote storage under a prefix. The sstables, and the
+// blob files holding their separated values, are uploaded under the tables/
+// sub-prefix, each along with a checksum object that's written once the file
+// is completely uploaded, and the MANIFEST, OPTIONS and WALs directly under
+// the prefix:
+//
+//	<prefix>tables/000005.sst
+//	<prefix>tables/000005.sst.checksum
+//	<prefix>tables/000006.blob
+//	<prefix>tables/000006.blob.checksum
+//	<prefix>MANIFEST-000001
+//	<prefix>OPTIONS-000003
+//	<prefix>000004.log
+//	<prefix>BACKUP
+//
+// The BACKUP object, which lists all the files of the backup along with their
+// checksums, is written last, so a backup without one is incomplete. The
+// checksum objects let a backup that failed or was interrupted be resumed
+// without uploading the files it already uploaded again.
+const (
+	remoteBackupTablesPrefix   = "tables/"
+	remoteBackupChecksumSuffix = ".checksum"
+	remoteBackupMetadataHeader = "pebble-remote-backup v1"
+)
+
+// RemoteBackup describes a backup of a DB on remote storage (see
+// DB.BackupToRemote).
+type RemoteBackup struct {
+	// CreatedAt is the time the backup started.
+	CreatedAt time.Time
+	// FormatMajorVersion is the format major version of the DB.
+	FormatMajorVersion FormatMajorVersion
+	// ManifestFileNum is the file number of the MANIFEST of the backup.
+	ManifestFileNum base.DiskFileNum
+	// Files are the files of the backup, sorted by name. The names of the
+	// sstables and blob files include the tables/ sub-prefix.
+	Files []RemoteBackupFile
+	// UploadedFiles and UploadedBytes are the number of files, and their size,
+	// that were uploaded by the last attempt of the backup. The sstables and
+	// blob files uploaded by earlier, interrupted, attempts are not included.
+	UploadedFiles int
+	UploadedBytes int64
+}
+
+// RemoteBackupFile describes a file of a remote backup.
+type RemoteBackupFile struct {
+	// Name is the name of the file's object, relative to the prefix of the
+	// backup.
+	Name string
+	Size int64
+	// Checksum is the CRC-32C (Castagnoli) checksum of the file.
+	Checksum uint32
+}
+
+// BackupToRemote uploads a consistent snapshot of the DB, as constructed by
+// Checkpoint, to the remote storage dest under prefix, and returns a
+// description of it. The sstables, blob files, MANIFEST, OPTIONS and the WALs
+// holding the writes not yet flushed are streamed to dest without being
+// staged on local disk.
+//
+// If a backup under prefix failed or was interrupted, the sstables and blob
+// files it uploaded completely are not uploaded again, and the objects it left that
+// aren't part of the new backup are removed. It fails if prefix holds a
+// complete backup.
+//
+// The WithFlushedWAL, WithCopyRateLimit, WithProgress and WithContext options
+// apply to remote backups as they do to checkpoints; WithRestrictToSpans isn't
+// supported. Backups of DBs with sstables on remote storage aren't supported.
+func (d *DB) BackupToRemote(
+	dest remote.Storage, prefix string, opts ...CheckpointOption,
+) (RemoteBackup, error) {
+	opt := &checkpointOptions{}
+	for _, fn := range opts {
+		fn(opt)
+	}
+	if len(opt.restrictToSpans) > 0 {
+		return RemoteBackup{}, errors.New("pebble: backups cannot be restricted to spans")
+	}
+	ctx := opt.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if _, err := dest.Size(prefix + backupMetadataFile); err == nil {
+		return RemoteBackup{}, errors.Errorf("pebble: a backup already exists under %q", prefix)
+	} else if !dest.IsNotExistError(err) {
+		return RemoteBackup{}, err
+	}
+	// Collect the sstables and blob files uploaded by previous attempts, along
+	// with their checksums.
+	existing, err := dest.List(prefix+remoteBackupTablesPrefix, "" /* delimiter */)
+	if err != nil {
+		return RemoteBackup{}, err
+	}
+	// Storage implementations are supposed to trim the prefix from the listed
+	// names, but not all of them do.
+	for i := range existing {
+		existing[i] = strings.TrimPrefix(existing[i], prefix+remoteBackupTablesPrefix)
+	}
+	uploaded := make(map[string]RemoteBackupFile)
+	for _, name := range existing {
+		if !strings.HasSuffix(name, remoteBackupChecksumSuffix) {
+			continue
+		}
+		f, err := readRemoteBackupChecksum(ctx, dest, prefix+remoteBackupTablesPrefix+name)
+		if err != nil {
+			return RemoteBackup{}, err
+		}
+		uploaded[f.Name] = f
+	}
+
+	// Strip the monotonic clock reading, which isn't persisted.
+	b := RemoteBackup{CreatedAt: d.timeNow().Round(0)}
+	if opt.flushWAL && !d.opts.DisableWAL {
+		// Write an empty log-data record to flush and sync the WAL.
+		if err := d.LogData(nil /* data */, Sync); err != nil {
+			return RemoteBackup{}, err
+		}
+	}
+
+	// Disable file deletions, and capture the state of the DB as Checkpoint
+	// does.
+	d.mu.Lock()
+	d.disableFileDeletions()
+	defer func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.enableFileDeletions()
+	}()
+	d.mu.versions.logLock()
+	memQueue := d.mu.mem.queue
+	current := d.mu.versions.currentVersion()
+	b.FormatMajorVersion = d.FormatMajorVersion()
+	b.ManifestFileNum = d.mu.versions.manifestFileNum.DiskFileNum()
+	manifestSize := d.mu.versions.manifest.Size()
+	optionsFileNum := d.optionsFileNum
+	blobFiles := make(map[base.DiskFileNum]manifest.BlobFileMetadata)
+	for _, meta := range d.mu.versions.blobFileMetadataLocked() {
+		blobFiles[meta.FileNum] = meta
+	}
+	d.mu.versions.logUnlock()
+	d.mu.Unlock()
+
+	fs := d.opts.FS
+	c := newCheckpointCopier(fs, opt)
+	type backupUpload struct {
+		path string
+		file RemoteBackupFile
+	}
+	var toUpload []backupUpload
+	tables := make(map[string]struct{})
+	for l := range current.Levels {
+		iter := current.Levels[l].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			for _, ref := range f.BlobReferences {
+				name := remoteBackupTablesPrefix + base.MakeFilename(fileTypeBlob, ref.FileNum)
+				if _, ok := tables[name]; ok {
+					continue
+				}
+				tables[name] = struct{}{}
+				meta, ok := blobFiles[ref.FileNum]
+				if !ok {
+					return RemoteBackup{}, errors.AssertionFailedf(
+						"pebble: sstable %s references unknown blob file %s", f.FileNum, ref.FileNum)
+				}
+				objMeta, err := d.objProvider.Lookup(fileTypeBlob, ref.FileNum)
+				if err != nil {
+					return RemoteBackup{}, err
+				}
+				size := int64(meta.Size)
+				if u, ok := uploaded[name]; ok && u.Size == size {
+					b.Files = append(b.Files, u)
+					continue
+				}
+				toUpload = append(toUpload, backupUpload{
+					path: d.objProvider.Path(objMeta),
+					file: RemoteBackupFile{Name: name, Size: size},
+				})
+				c.addFile(size)
+			}
+			fileNum := f.FileBacking.DiskFileNum
+			name := remoteBackupTablesPrefix + base.MakeFilename(fileTypeTable, fileNum)
+			if _, ok := tables[name]; ok {
+				// A backing shared by virtual sstables.
+				continue
+			}
+			tables[name] = struct{}{}
+			objMeta, err := d.objProvider.Lookup(fileTypeTable, fileNum)
+			if err != nil {
+				return RemoteBackup{}, err
+			}
+			if objMeta.IsRemote() {
+				return RemoteBackup{}, errors.Errorf(
+					"pebble: backups of sstables on remote storage are not supported (sstable %s)", fileNum)
+			}
+			size := int64(f.FileBacking.Size)
+			if u, ok := uploaded[name]; ok && u.Size == size {
+				b.Files = append(b.Files, u)
+				continue
+			}
+			toUpload = append(toUpload, backupUpload{
+				path: d.objProvider.Path(objMeta),
+				file: RemoteBackupFile{Name: name, Size: size},
+			})
+			c.addFile(size)
+		}
+	}
+
+	// Upload the sstables and blob files, each followed by its checksum object.
+	for _, u := range toUpload {
+		if err := c.uploadFile(dest, u.path, prefix+u.file.Name, &u.file); err != nil {
+			return RemoteBackup{}, err
+		}
+		if err := writeRemoteBackupChecksum(dest, prefix+u.file.Name+remoteBackupChecksumSuffix, u.file); err != nil {
+			return RemoteBackup{}, err
+		}
+		b.Files = append(b.Files, u.file)
+		b.UploadedFiles++
+		b.UploadedBytes += u.file.Size
+	}
+	// Remove the objects left by previous attempts that aren't part of the
+	// backup, such as sstables that were compacted away since.
+	for _, name := range existing {
+		if _, ok := tables[remoteBackupTablesPrefix+strings.TrimSuffix(name, remoteBackupChecksumSuffix)]; ok {
+			continue
+		}
+		if err := dest.Delete(prefix + remoteBackupTablesPrefix + name); err != nil && !dest.IsNotExistError(err) {
+			return RemoteBackup{}, err
+		}
+	}
+
+	// Upload the OPTIONS, the prefix of the MANIFEST that describes the
+	// captured version, and the WALs.
+	uploadFile := func(srcPath string) error {
+		size, err := c.statFile(srcPath)
+		if err != nil {
+			return err
+		}
+		c.addFile(size)
+		f := RemoteBackupFile{Name: fs.PathBase(srcPath), Size: size}
+		if err := c.uploadFile(dest, srcPath, prefix+f.Name, &f); err != nil {
+			return err
+		}
+		b.Files = append(b.Files, f)
+		b.UploadedFiles++
+		b.UploadedBytes += f.Size
+		return nil
+	}
+	if err := uploadFile(base.MakeFilepath(fs, d.dirname, fileTypeOptions, optionsFileNum)); err != nil {
+		return RemoteBackup{}, err
+	}
+	if err := func() error {
+		srcPath := base.MakeFilepath(fs, d.dirname, fileTypeManifest, b.ManifestFileNum)
+		src, err := fs.Open(srcPath, vfs.SequentialReadsOption)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		c.addFile(manifestSize)
+		f := RemoteBackupFile{Name: fs.PathBase(srcPath), Size: manifestSize}
+		if err := c.upload(&io.LimitedReader{R: src, N: manifestSize}, dest, prefix+f.Name, &f); err != nil {
+			return err
+		}
+		b.Files = append(b.Files, f)
+		b.UploadedFiles++
+		b.UploadedBytes += f.Size
+		return nil
+	}(); err != nil {
+		return RemoteBackup{}, err
+	}
+	for i := range memQueue {
+		logNum := memQueue[i].logNum
+		if logNum == 0 {
+			continue
+		}
+		if err := uploadFile(base.MakeFilepath(fs, d.walDirname, fileTypeLog, logNum.DiskFileNum())); err != nil {
+			return RemoteBackup{}, err
+		}
+	}
+	sort.Slice(b.Files, func(i, j int) bool { return b.Files[i].Name < b.Files[j].Name })
+
+	// Finally, write the BACKUP object, which completes the backup.
+	if err := writeRemoteBackupMetadata(dest, prefix+backupMetadataFile, &b); err != nil {
+		return RemoteBackup{}, err
+	}
+	return b, nil
+}
+
+// uploadFile uploads the file at src to the object objName of storage,
+// setting the size and checksum of f.
+func (c *checkpointCopier) uploadFile(
+	storage remote.Storage, src, objName string, f *RemoteBackupFile,
+) error {
+	in, err := c.fs.Open(src, vfs.SequentialReadsOption)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return c.upload(in, storage, objName, f)
+}
+
+// upload uploads the data read from in to the object objName of storage,
+// setting the size and checksum of f. f.Size is the expected size of the data,
+// as for copy.
+func (c *checkpointCopier) upload(
+	in io.Reader, storage remote.Storage, objName string, f *RemoteBackupFile,
+) error {
+	if err := c.err(); err != nil {
+		return err
+	}
+	w, err := objstorageprovider.CreateRemoteWritable(storage, objName, objstorageprovider.RemoteUploadOptions{})
+	if err != nil {
+		return err
+	}
+	var size int64
+	sum, err := c.copyData(in, func(p []byte) error {
+		size += int64(len(p))
+		return w.Write(p)
+	}, f.Size)
+	if err != nil {
+		w.Abort()
+		return err
+	}
+	if err := w.Finish(); err != nil {
+		return err
+	}
+	f.Size, f.Checksum = size, sum
+	c.fileDone(0)
+	return nil
+}
+
+// ReadRemoteBackup returns the description of the backup under prefix in
+// storage. It returns an error satisfying storage.IsNotExistError if there's
+// no complete backup under prefix.
+func ReadRemoteBackup(ctx context.Context, storage remote.Storage, prefix string) (RemoteBackup, error) {
+	data, err := readRemoteObject(ctx, storage, prefix+backupMetadataFile)
+	if err != nil {
+		return RemoteBackup{}, err
+	}
+	b := RemoteBackup{}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	if !s.Scan() || s.Text() != remoteBackupMetadataHeader {
+		return RemoteBackup{}, base.CorruptionErrorf("pebble: invalid backup metadata under %q", prefix)
+	}
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		var err error
+		var n uint64
+		switch {
+		case fields[0] == "format" && len(fields) == 2:
+			n, err = strconv.ParseUint(fields[1], 10, 64)
+			b.FormatMajorVersion = FormatMajorVersion(n)
+		case fields[0] == "manifest" && len(fields) == 2:
+			n, err = strconv.ParseUint(fields[1], 10, 64)
+			b.ManifestFileNum = base.FileNum(n).DiskFileNum()
+		case fields[0] == "created" && len(fields) == 2:
+			var nanos int64
+			nanos, err = strconv.ParseInt(fields[1], 10, 64)
+			b.CreatedAt = time.Unix(0, nanos)
+		case fields[0] == "uploaded" && len(fields) == 3:
+			b.UploadedFiles, err = strconv.Atoi(fields[1])
+			if err == nil {
+				b.UploadedBytes, err = strconv.ParseInt(fields[2], 10, 64)
+			}
+		case fields[0] == "file" && len(fields) == 4:
+			var f RemoteBackupFile
+			f, err = parseRemoteBackupFile(fields[1:])
+			b.Files = append(b.Files, f)
+		default:
+			err = errors.Errorf("unknown line %q", s.Text())
+		}
+		if err != nil {
+			return RemoteBackup{}, base.CorruptionErrorf("pebble: invalid backup metadata under %q: %v", prefix, err)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return RemoteBackup{}, err
+	}
+	return b, nil
+}
+
+// VerifyRemoteBackup reads back all the files of the backup under prefix in
+// storage, and checks that they have the expected sizes and checksums.
+func VerifyRemoteBackup(ctx context.Context, storage remote.Storage, prefix string) error {
+	b, err := ReadRemoteBackup(ctx, storage, prefix)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, checkpointCopyChunkSize)
+	for _, f := range b.Files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		size, sum, err := checksumRemoteObject(ctx, storage, prefix+f.Name, buf)
+		if err != nil {
+			return errors.Wrapf(err, "pebble: backup %s", f.Name)
+		}
+		if err := checkRemoteBackupFile(f, size, sum); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestoreRemoteBackup downloads the backup under prefix in storage into
+// destDir, which must not exist, as a DB that can be opened. The sizes and
+// checksums of the files are checked as they're downloaded. The restore can be
+// restricted to key ranges with WithRestoreSpans.
+func RestoreRemoteBackup(
+	ctx context.Context,
+	storage remote.Storage,
+	prefix string,
+	fs vfs.FS,
+	destDir string,
+	opts ...RestoreOption,
+) (err error) {
+	r, err := newRestorer(fs, destDir, opts)
+	if err != nil {
+		return err
+	}
+	r.c.opt.ctx = ctx
+	b, err := ReadRemoteBackup(ctx, storage, prefix)
+	if err != nil {
+		return err
+	}
+	if _, err := fs.Stat(destDir); !oserror.IsNotExist(err) {
+		if err == nil {
+			return errors.Errorf("pebble: %s already exists", destDir)
+		}
+		return err
+	}
+	dir, err := mkdirAllAndSyncParents(fs, destDir)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = dir.Close()
+		if err != nil {
+			_ = fs.RemoveAll(destDir)
+		}
+	}()
+	var files []restoreFile
+	tables := make(map[base.DiskFileNum]restoreFile)
+	blobFiles := make(map[base.DiskFileNum]restoreFile)
+	for _, f := range b.Files {
+		f := f
+		rf := restoreFile{
+			name: strings.TrimPrefix(f.Name, remoteBackupTablesPrefix),
+			size: f.Size,
+			copy: func(c *checkpointCopier, dst string) error {
+				return c.download(ctx, storage, prefix+f.Name, dst, f)
+			},
+		}
+		if !strings.HasPrefix(f.Name, remoteBackupTablesPrefix) {
+			files = append(files, rf)
+			continue
+		}
+		fileType, fileNum, ok := base.ParseFilename(fs, rf.name)
+		switch {
+		case ok && fileType == fileTypeTable:
+			tables[fileNum] = rf
+		case ok && fileType == fileTypeBlob:
+			blobFiles[fileNum] = rf
+		default:
+			return base.CorruptionErrorf("pebble: invalid backup sstable %s", f.Name)
+		}
+	}
+	if err := r.restoreFiles(files, tables, blobFiles); err != nil {
+		return err
+	}
+
+	// Set the format major version and the MANIFEST of the DB.
+	versionMarker, _, err := atomicfs.LocateMarker(fs, destDir, formatVersionMarkerName)
+	if err != nil {
+		return err
+	}
+	if err := versionMarker.Move(b.FormatMajorVersion.String()); err != nil {
+		_ = versionMarker.Close()
+		return err
+	}
+	if err := versionMarker.Close(); err != nil {
+		return err
+	}
+	manifestMarker, _, err := atomicfs.LocateMarker(fs, destDir, manifestMarkerName)
+	if err != nil {
+		return err
+	}
+	if err := setCurrentFunc(b.FormatMajorVersion, manifestMarker, fs, destDir, dir)(b.ManifestFileNum.FileNum()); err != nil {
+		_ = manifestMarker.Close()
+		return err
+	}
+	if err := manifestMarker.Close(); err != nil {
+		return err
+	}
+	if err := dir.Sync(); err != nil {
+		return err
+	}
+	return r.excise()
+}
+
+// download downloads the object objName of storage to dst, checking that it
+// has the size and checksum of f.
+func (c *checkpointCopier) download(
+	ctx context.Context, storage remote.Storage, objName, dst string, f RemoteBackupFile,
+) error {
+	if err := c.err(); err != nil {
+		return err
+	}
+	r, size, err := storage.ReadObject(ctx, objName)
+	if err != nil {
+		return errors.Wrapf(err, "pebble: backup %s", f.Name)
+	}
+	defer r.Close()
+	out, err := c.fs.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	sum, err := c.copyData(&remoteObjectReader{ctx: ctx, r: r, size: size}, func(p []byte) error {
+		_, err := out.Write(p)
+		return err
+	}, f.Size)
+	if err != nil {
+		return errors.Wrapf(err, "pebble: backup %s", f.Name)
+	}
+	if err := checkRemoteBackupFile(f, size, sum); err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	c.fileDone(0)
+	return nil
+}
+
+// remoteObjectReader reads a remote object sequentially.
+type remoteObjectReader struct {
+	ctx       context.Context
+	r         remote.ObjectReader
+	ofs, size int64
+}
+
+// Read implements io.Reader.
+func (r *remoteObjectReader) Read(p []byte) (int, error) {
+	if r.ofs >= r.size {
+		return 0, io.EOF
+	}
+	if n := r.size - r.ofs; int64(len(p)) > n {
+		p = p[:n]
+	}
+	if err := r.r.ReadAt(r.ctx, p, r.ofs); err != nil {
+		return 0, err
+	}
+	r.ofs += int64(len(p))
+	return len(p), nil
+}
+
+func checkRemoteBackupFile(f RemoteBackupFile, size int64, sum uint32) error {
+	if size != f.Size {
+		return errors.Errorf("pebble: backup %s has size %d, expected %d", f.Name, size, f.Size)
+	}
+	if sum != f.Checksum {
+		return errors.Errorf("pebble: backup %s has checksum %08x, expected %08x", f.Name, sum, f.Checksum)
+	}
+	return nil
+}
+
+// checksumRemoteObject returns the size and the CRC-32C checksum of the object
+// objName.
+func checksumRemoteObject(
+	ctx context.Context, storage remote.Storage, objName string, buf []byte,
+) (int64, uint32, error) {
+	r, size, err := storage.ReadObject(ctx, objName)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer r.Close()
+	in := &remoteObjectReader{ctx: ctx, r: r, size: size}
+	var sum uint32
+	for {
+		n, err := in.Read(buf)
+		sum = crc32.Update(sum, castagnoliTable, buf[:n])
+		if err == io.EOF {
+			return size, sum, nil
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+}
+
+// readRemoteObject reads the whole object objName.
+func readRemoteObject(ctx context.Context, storage remote.Storage, objName string) ([]byte, error) {
+	r, size, err := storage.ReadObject(ctx, objName)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data := make([]byte, size)
+	if err := r.ReadAt(ctx, data, 0); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// writeRemoteObject writes the object objName with the given data.
+func writeRemoteObject(storage remote.Storage, objName string, data []byte) error {
+	w, err := storage.CreateObject(objName)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// writeRemoteBackupChecksum writes the checksum object of an uploaded sstable.
+func writeRemoteBackupChecksum(storage remote.Storage, objName string, f RemoteBackupFile) error {
+	return writeRemoteObject(storage, objName, []byte(fmt.Sprintf("%s %d %08x\n", f.Name, f.Size, f.Checksum)))
+}
+
+// readRemoteBackupChecksum reads the checksum object of an uploaded sstable.
+func readRemoteBackupChecksum(
+	ctx context.Context, storage remote.Storage, objName string,
+) (RemoteBackupFile, error) {
+	data, err := readRemoteObject(ctx, storage, objName)
+	if err != nil {
+		return RemoteBackupFile{}, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 3 {
+		return RemoteBackupFile{}, base.CorruptionErrorf("pebble: invalid backup checksum %s", objName)
+	}
+	f, err := parseRemoteBackupFile(fields)
+	if err != nil {
+		return RemoteBackupFile{}, base.CorruptionErrorf("pebble: invalid backup checksum %s: %v", objName, err)
+	}
+	return f, nil
+}
+
+// parseRemoteBackupFile parses the name, size and hex checksum of a file.
+func parseRemoteBackupFile(fields []string) (RemoteBackupFile, error) {
+	f := RemoteBackupFile{Name: fields[0]}
+	var err error
+	f.Size, err = strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return RemoteBackupFile{}, err
+	}
+	sum, err := strconv.ParseUint(fields[2], 16, 32)
+	if err != nil {
+		return RemoteBackupFile{}, err
+	}
+	f.Checksum = uint32(sum)
+	return f, nil
+}
+
+// writeRemoteBackupMetadata writes the BACKUP object of a remote backup.
+func writeRemoteBackupMetadata(storage remote.Storage, objName string, b *RemoteBackup) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s\n", remoteBackupMetadataHeader)
+	fmt.Fprintf(&buf, "format %d\n", b.FormatMajorVersion)
+	fmt.Fprintf(&buf, "manifest %s\n", b.ManifestFileNum)
+	fmt.Fprintf(&buf, "created %d\n", b.CreatedAt.UnixNano())
+	fmt.Fprintf(&buf, "uploaded %d %d\n", b.UploadedFiles, b.UploadedBytes)
+	for _, f := range b.Files {
+		fmt.Fprintf(&buf, "file %s %d %08x\n", f.Name, f.Size, f.Checksum)
+	}
+	return writeRemoteObject(storage, objName, buf.Bytes())
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/objstorage/remote"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+// failingUploadStorage wraps a remote.Storage, failing the creation of objects
+// once a number of them have been created.
+type failingUploadStorage struct {
+	remote.Storage
+	remaining *int
+}
+
+func (s failingUploadStorage) CreateObject(objName string) (io.WriteCloser, error) {
+	if *s.remaining == 0 {
+		return nil, errors.New("injected upload failure")
+	}
+	*s.remaining--
+	return s.Storage.CreateObject(objName)
+}
+
+func TestBackupToRemote(t *testing.T) {
+	ctx := context.Background()
+	fs := vfs.NewMem()
+	d, err := Open("db", &Options{FS: fs, DisableAutomaticCompactions: true})
+	require.NoError(t, err)
+	defer func() {
+		if d != nil {
+			require.NoError(t, d.Close())
+		}
+	}()
+	for _, prefix := range []string{"a", "b", "c"} {
+		for i := 0; i < 100; i++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("%s-%03d", prefix, i)), []byte(prefix), nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+	require.NoError(t, d.Set([]byte("d"), []byte("d"), nil))
+
+	// A backup that fails after uploading an sstable (and its checksum) is
+	// resumed without uploading it again.
+	storage := remote.NewInMem()
+	remaining := 2
+	_, err = d.BackupToRemote(failingUploadStorage{Storage: storage, remaining: &remaining}, "bk/")
+	require.Error(t, err)
+	_, err = ReadRemoteBackup(ctx, storage, "bk/")
+	require.True(t, storage.IsNotExistError(err))
+
+	b, err := d.BackupToRemote(storage, "bk/", WithFlushedWAL())
+	require.NoError(t, err)
+	// 3 sstables, the OPTIONS, the MANIFEST and the WAL.
+	require.Len(t, b.Files, 6)
+	require.Equal(t, 5, b.UploadedFiles)
+	read, err := ReadRemoteBackup(ctx, storage, "bk/")
+	require.NoError(t, err)
+	require.Equal(t, b, read)
+	require.NoError(t, VerifyRemoteBackup(ctx, storage, "bk/"))
+
+	// A complete backup isn't overwritten.
+	_, err = d.BackupToRemote(storage, "bk/")
+	require.Error(t, err)
+
+	// The restored backup holds all the writes.
+	require.NoError(t, RestoreRemoteBackup(ctx, storage, "bk/", fs, "restored"))
+	require.NoError(t, d.Close())
+	d = nil
+	r, err := Open("restored", &Options{FS: fs})
+	require.NoError(t, err)
+	for _, prefix := range []string{"a", "b", "c"} {
+		verifyGet(t, r, []byte(prefix+"-042"), []byte(prefix))
+	}
+	verifyGet(t, r, []byte("d"), []byte("d"))
+	require.NoError(t, r.Close())
+
+	// Corrupted files are detected, both by verification and restores.
+	name := "bk/" + b.Files[0].Name
+	data, err := readRemoteObject(ctx, storage, name)
+	require.NoError(t, err)
+	data[10] ^= 0xff
+	require.NoError(t, writeRemoteObject(storage, name, data))
+	require.Error(t, VerifyRemoteBackup(ctx, storage, "bk/"))
+	require.Error(t, RestoreRemoteBackup(ctx, storage, "bk/", fs, "restored2"))
+	_, err = fs.Stat("restored2")
+	require.True(t, oserror.IsNotExist(err))
+}
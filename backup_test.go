@@ -0,0 +1,108 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackup(t *testing.T) {
+	fs := vfs.NewMem()
+	d, err := Open("db", &Options{FS: fs, DisableAutomaticCompactions: true})
+	require.NoError(t, err)
+	defer func() {
+		if d != nil {
+			require.NoError(t, d.Close())
+		}
+	}()
+
+	write := func(prefix string) {
+		for i := 0; i < 100; i++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("%s-%03d", prefix, i)), []byte(prefix), nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+	backupTables := func() []string {
+		names, err := fs.List("backup/tables")
+		require.NoError(t, err)
+		sort.Strings(names)
+		return names
+	}
+
+	// The first backup copies all the sstables.
+	write("a")
+	write("b")
+	gen1, err := d.Backup("backup")
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), gen1.Num)
+	require.Len(t, gen1.Tables, 2)
+	require.Equal(t, 2, gen1.CopiedTables)
+
+	// The second backup only copies the new sstable, and the writes that are
+	// only in the WAL.
+	write("c")
+	require.NoError(t, d.Set([]byte("d"), []byte("d"), nil))
+	gen2, err := d.Backup("backup", WithFlushedWAL())
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), gen2.Num)
+	require.Len(t, gen2.Tables, 3)
+	require.Equal(t, 1, gen2.CopiedTables)
+	require.Len(t, backupTables(), 3)
+
+	generations, err := ListBackups(fs, "backup")
+	require.NoError(t, err)
+	require.Equal(t, []BackupGeneration{gen1, gen2}, generations)
+	require.NoError(t, VerifyBackup(fs, "backup", 1))
+	require.NoError(t, VerifyBackup(fs, "backup", 2))
+
+	// A generation whose backup didn't complete isn't listed.
+	require.NoError(t, fs.MkdirAll("backup/generation-000009", 0755))
+	generations, err = ListBackups(fs, "backup")
+	require.NoError(t, err)
+	require.Len(t, generations, 2)
+
+	// After a compaction, pruning all but the latest generation removes the
+	// sstables that were compacted away.
+	require.NoError(t, d.Compact([]byte("a"), []byte("e"), false))
+	gen3, err := d.Backup("backup")
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), gen3.Num)
+	require.Len(t, backupTables(), 4)
+	removed, err := PruneBackups(fs, "backup", 1)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1, 2}, removed)
+	generations, err = ListBackups(fs, "backup")
+	require.NoError(t, err)
+	require.Equal(t, []BackupGeneration{gen3}, generations)
+	require.Len(t, backupTables(), len(gen3.Tables))
+	_, err = fs.Stat("backup/generation-000009")
+	require.Error(t, err)
+
+	// The restored generation holds all the writes.
+	require.NoError(t, RestoreBackup(fs, "backup", gen3.Num, "restored"))
+	require.NoError(t, d.Close())
+	d = nil
+	r, err := Open("restored", &Options{FS: fs})
+	require.NoError(t, err)
+	for _, prefix := range []string{"a", "b", "c"} {
+		verifyGet(t, r, []byte(prefix+"-042"), []byte(prefix))
+	}
+	verifyGet(t, r, []byte("d"), []byte("d"))
+	require.NoError(t, r.Close())
+
+	// Corrupted sstables are detected.
+	table := "backup/tables/" + backupTables()[0]
+	f, err := fs.OpenReadWrite(table)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte{0xff}, 10)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.Error(t, VerifyBackup(fs, "backup", gen3.Num))
+}
@@ -5,6 +5,7 @@
 package pebble
 
 import (
+	"bytes"
 	"context"
 	"encoding/binary"
 	"fmt"
@@ -19,6 +20,7 @@ import (
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/batchskl"
+	"github.com/cockroachdb/pebble/internal/crc"
 	"github.com/cockroachdb/pebble/internal/humanize"
 	"github.com/cockroachdb/pebble/internal/keyspan"
 	"github.com/cockroachdb/pebble/internal/private"
@@ -260,6 +262,19 @@ type Batch struct {
 	// memtable.
 	flushable *flushableBatch
 
+	// arena is the arena from which the batch's representation is allocated,
+	// if any. See BatchArena.
+	arena *BatchArena
+
+	// noWAL indicates that the batch is committed without being written to
+	// the WAL (see WriteOptions.DisableWAL).
+	noWAL bool
+
+	// idempotencyToken is the batch's idempotency token, if any. The token is
+	// also present in the batch's repr as a LogData record so that it is
+	// persisted in the WAL (see Batch.SetIdempotencyToken).
+	idempotencyToken []byte
+
 	// ingestedSSTBatch indicates that the batch contains one or more key kinds
 	// of InternalKeyKindIngestSST. If the batch contains key kinds of IngestSST
 	// then it will only contain key kinds of IngestSST.
@@ -335,10 +350,21 @@ type BatchCommitStats struct {
 	// WALRotationDuration is the wait time for WAL rotation, which includes
 	// syncing and closing the old WAL and creating (or reusing) a new one.
 	WALRotationDuration time.Duration
+	// WALQuotaWaitDuration is the wait caused by the un-flushed WAL data
+	// approaching or exceeding Options.MaxUnflushedWALBytes (due to not
+	// flushing fast enough).
+	WALQuotaWaitDuration time.Duration
 	// CommitWaitDuration is the wait for publishing the seqnum plus the
 	// duration for the WAL sync (if requested). The former should be tiny and
 	// one can assume that this is all due to the WAL sync.
 	CommitWaitDuration time.Duration
+	// AdmissionWaitDuration is the wait for write admission control, which
+	// delays writes of low priority (see WriteOptions.Priority).
+	AdmissionWaitDuration time.Duration
+	// CommitLaneWaitDuration is the wait of a bulk write for latency-sensitive
+	// writes to pass through the commit pipeline (see
+	// WriteOptions.LatencySensitive).
+	CommitLaneWaitDuration time.Duration
 }
 
 var _ Reader = (*Batch)(nil)
@@ -398,6 +424,13 @@ func (b *Batch) release() {
 		return
 	}
 	b.db = nil
+	if b.arena != nil {
+		// The representation belongs to the arena and must not be reused by
+		// another batch.
+		b.arena.release(b)
+		b.arena = nil
+		b.data = nil
+	}
 
 	// NB: This is ugly (it would be cleaner if we could just assign a Batch{}),
 	// but necessary so that we can use atomic.StoreUint32 for the Batch.applied
@@ -526,6 +559,10 @@ func (b *Batch) Apply(batch *Batch, _ *WriteOptions) error {
 // Get gets the value for the given key. It returns ErrNotFound if the Batch
 // does not contain the key.
 //
+// Get reads through the batch to the DB: MERGE operands written to the batch
+// are combined, using the DB's configured Merger, with the operands and base
+// value committed to the DB unless the key is deleted within the batch.
+//
 // The caller should not modify the contents of the returned slice, but it is
 // safe to modify the contents of the argument after Get returns. The returned
 // slice will remain valid until the returned Closer is closed. On success, the
@@ -1001,6 +1038,66 @@ func (b *Batch) LogData(data []byte, _ *WriteOptions) error {
 	return nil
 }
 
+// idempotencyTokenPrefix is the prefix of the LogData record that holds a
+// batch's idempotency token.
+var idempotencyTokenPrefix = []byte("\x00pebble.idempotency-token\x00")
+
+// SetIdempotencyToken attaches a caller-provided idempotency token to the
+// batch. When the batch is applied to a DB, it is a no-op if a batch carrying
+// the same token has been applied since the DB's last flush, including batches
+// recovered from the WAL when the DB was opened. This allows a replication
+// layer to re-apply batches after a crash without applying them twice. If the
+// earlier batch is still being committed, applying the duplicate waits for it
+// to commit, and for the WAL to be synced if the duplicate is applied with
+// WriteOptions.Sync.
+//
+// The token is written to the WAL as a LogData record with a reserved prefix,
+// so it is also visible to readers of the WAL. Tokens are not persisted for
+// batches applied with WriteOptions.DisableWAL, or when the WAL is disabled.
+// A batch may carry at most one token.
+//
+// It is safe to modify the contents of the argument after SetIdempotencyToken
+// returns.
+func (b *Batch) SetIdempotencyToken(token []byte) error {
+	if len(token) == 0 {
+		return errors.New("pebble: empty idempotency token")
+	}
+	if b.idempotencyToken != nil {
+		return errors.New("pebble: batch already has an idempotency token")
+	}
+	if b.ingestedSSTBatch {
+		panic("pebble: invalid batch application")
+	}
+	data := make([]byte, 0, len(idempotencyTokenPrefix)+len(token))
+	data = append(append(data, idempotencyTokenPrefix...), token...)
+	if err := b.LogData(data, nil); err != nil {
+		return err
+	}
+	b.idempotencyToken = data[len(idempotencyTokenPrefix):]
+	return nil
+}
+
+// IdempotencyToken returns the batch's idempotency token, or nil if the batch
+// does not have one.
+func (b *Batch) IdempotencyToken() []byte {
+	return b.idempotencyToken
+}
+
+// findIdempotencyToken returns the idempotency token contained in the batch
+// representation, or nil if there is none.
+func findIdempotencyToken(repr []byte) []byte {
+	for r, _ := ReadBatch(repr); len(r) > 0; {
+		kind, data, _, ok := r.Next()
+		if !ok {
+			break
+		}
+		if kind == InternalKeyKindLogData && bytes.HasPrefix(data, idempotencyTokenPrefix) {
+			return data[len(idempotencyTokenPrefix):]
+		}
+	}
+	return nil
+}
+
 // IngestSST adds the FileNum for an sstable to the batch. The data will only be
 // written to the WAL (not added to memtables or sstables).
 func (b *Batch) ingestSST(fileNum base.FileNum) {
@@ -1064,6 +1161,98 @@ func (b *Batch) SetRepr(data []byte) error {
 	return nil
 }
 
+// BatchEncodingVersion is the version of the batch wire format produced by
+// Batch.Encode.
+const BatchEncodingVersion = 1
+
+// batchEncodingTrailerLen is the length of the checksum trailing an encoded
+// batch.
+const batchEncodingTrailerLen = 4
+
+// Encode appends a versioned, checksummed encoding of the batch's contents to
+// dst and returns the extended buffer. The encoding includes all of the
+// batch's records, including range deletions, range keys and LogData
+// records, along with the batch's sequence number. The encoding is stable:
+// it may be persisted or sent to another process and decoded with
+// Batch.Decode by any version of Pebble that supports BatchEncodingVersion.
+//
+// The encoded form is:
+//
+//	+-------------+------------------------------+--------------+
+//	| version (1) | batch header & records (...) | checksum (4) |
+//	+-------------+------------------------------+--------------+
+//
+// where the checksum is a CRC-32C over the version and the batch contents.
+// Batches containing ingested sstables cannot be encoded.
+func (b *Batch) Encode(dst []byte) ([]byte, error) {
+	if b.ingestedSSTBatch {
+		return dst, errors.New("pebble: cannot encode a batch of ingested sstables")
+	}
+	repr := b.Repr()
+	start := len(dst)
+	dst = append(dst, BatchEncodingVersion)
+	dst = append(dst, repr...)
+	return binary.LittleEndian.AppendUint32(dst, crc.New(dst[start:]).Value()), nil
+}
+
+// Decode reconstructs a batch from an encoding produced by Batch.Encode. The
+// receiver must be empty. The records are validated and copied into the
+// batch, so it is safe to modify data after Decode returns. If the batch is
+// indexed, the decoded records are indexed. The batch's sequence number is
+// restored from the encoding and is available through Batch.SeqNum; it is
+// overwritten when the batch is committed. The batch's idempotency token, if
+// any, is also restored.
+func (b *Batch) Decode(data []byte) error {
+	if !b.Empty() {
+		return errors.New("pebble: cannot decode into a non-empty batch")
+	}
+	if len(data) < 1+batchHeaderLen+batchEncodingTrailerLen {
+		return base.CorruptionErrorf("pebble: encoded batch is too short (%d bytes)", errors.Safe(len(data)))
+	}
+	if v := data[0]; v != BatchEncodingVersion {
+		return errors.Errorf("pebble: unsupported batch encoding version %d", errors.Safe(v))
+	}
+	n := len(data) - batchEncodingTrailerLen
+	if checksum := binary.LittleEndian.Uint32(data[n:]); checksum != crc.New(data[:n]).Value() {
+		return base.CorruptionErrorf("pebble: encoded batch checksum mismatch")
+	}
+	repr := data[1:n]
+
+	// Validate every record before modifying the receiver, so that a corrupt
+	// encoding leaves the batch untouched.
+	r, count := ReadBatch(repr)
+	var found uint32
+	for len(r) > 0 {
+		kind, _, _, ok := r.Next()
+		if !ok {
+			return base.CorruptionErrorf("pebble: corrupt encoded batch record")
+		}
+		switch kind {
+		case InternalKeyKindIngestSST:
+			return base.CorruptionErrorf("pebble: invalid key kind %s in encoded batch", kind)
+		case InternalKeyKindLogData:
+			// LogData records are not included in the batch count.
+		default:
+			found++
+		}
+	}
+	if found != count {
+		return base.CorruptionErrorf("pebble: encoded batch count %d does not match %d records",
+			errors.Safe(count), errors.Safe(found))
+	}
+
+	src := Batch{data: repr, count: uint64(count)}
+	if err := b.Apply(&src, nil); err != nil {
+		return err
+	}
+	b.setSeqNum(src.SeqNum())
+	b.idempotencyToken = findIdempotencyToken(b.data)
+	// Apply does not compute the range key counts or the minimum format major
+	// version for unindexed batches that aren't associated with a DB.
+	b.refreshMemTableSize()
+	return nil
+}
+
 // NewIter returns an iterator that is unpositioned (Iterator.Valid() will
 // return false). The iterator can be positioned via a call to SeekGE,
 // SeekPrefixGE, SeekLT, First or Last. Only indexed batches support iterators.
@@ -1326,9 +1515,11 @@ func (b *Batch) Reset() {
 	b.commitStats = BatchCommitStats{}
 	b.commitErr = nil
 	b.applied.Store(false)
+	b.noWAL = false
+	b.idempotencyToken = nil
 	b.minimumFormatMajorVersion = 0
 	if b.data != nil {
-		if cap(b.data) > batchMaxRetainedSize {
+		if cap(b.data) > batchMaxRetainedSize && b.arena == nil {
 			// If the capacity of the buffer is larger than our maximum
 			// retention size, don't re-use it. Let it be GC-ed instead.
 			// This prevents the memory from an unusually large batch from
@@ -1463,6 +1654,90 @@ func (b *Batch) CommitStats() BatchCommitStats {
 	return b.commitStats
 }
 
+// BatchKindStats holds the number of records of one kind of operation in a
+// batch and the total size of their keys and values.
+type BatchKindStats struct {
+	Count      uint64
+	KeyBytes   uint64
+	ValueBytes uint64
+}
+
+// BatchContentStats summarizes the records of a batch by kind of operation.
+type BatchContentStats struct {
+	// Sets holds SET and SETWITHDEL records.
+	Sets BatchKindStats
+	// Deletes holds DEL, SINGLEDEL and DELSIZED records.
+	Deletes BatchKindStats
+	// RangeDeletes holds RANGEDEL records. The end keys of the ranges are
+	// included in ValueBytes.
+	RangeDeletes BatchKindStats
+	// RangeKeys holds RANGEKEYSET, RANGEKEYUNSET and RANGEKEYDEL records. The
+	// encoded end keys, suffixes and values are included in ValueBytes.
+	RangeKeys BatchKindStats
+	// Merges holds MERGE records.
+	Merges BatchKindStats
+	// LogData holds LOGDATA records, which are not included in Batch.Count. The
+	// log data is included in KeyBytes.
+	LogData BatchKindStats
+}
+
+// ContentStats returns counts and byte totals of the records in the batch by
+// kind of operation. It iterates over the batch's records, so its cost is
+// linear in the size of the batch. An error is returned if the batch is
+// corrupt.
+func (b *Batch) ContentStats() (BatchContentStats, error) {
+	var stats BatchContentStats
+	err := b.ForEachRecord(func(kind InternalKeyKind, key, value []byte) error {
+		var ks *BatchKindStats
+		switch kind {
+		case InternalKeyKindSet, InternalKeyKindSetWithDelete:
+			ks = &stats.Sets
+		case InternalKeyKindDelete, InternalKeyKindSingleDelete, InternalKeyKindDeleteSized:
+			ks = &stats.Deletes
+		case InternalKeyKindRangeDelete:
+			ks = &stats.RangeDeletes
+		case InternalKeyKindRangeKeySet, InternalKeyKindRangeKeyUnset, InternalKeyKindRangeKeyDelete:
+			ks = &stats.RangeKeys
+		case InternalKeyKindMerge:
+			ks = &stats.Merges
+		case InternalKeyKindLogData:
+			ks = &stats.LogData
+		default:
+			return nil
+		}
+		ks.Count++
+		ks.KeyBytes += uint64(len(key))
+		ks.ValueBytes += uint64(len(value))
+		return nil
+	})
+	return stats, err
+}
+
+// ForEachRecord calls fn for each record in the batch, in the order in which
+// the records were added, stopping at the first error returned by fn. The key
+// and value passed to fn point into the batch and must not be used after the
+// batch is mutated. For LOGDATA records the log data is passed as the key.
+// Unlike iterators over an indexed batch, ForEachRecord returns every record,
+// including records shadowed by later records for the same key, and it may be
+// used on batches that are not indexed. An error is returned if the batch is
+// corrupt.
+func (b *Batch) ForEachRecord(fn func(kind InternalKeyKind, key, value []byte) error) error {
+	if len(b.data) == 0 {
+		return nil
+	}
+	r := b.Reader()
+	for len(r) > 0 {
+		kind, key, value, ok := r.Next()
+		if !ok {
+			return base.CorruptionErrorf("pebble: invalid batch")
+		}
+		if err := fn(kind, key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // BatchReader iterates over the entries contained in a batch.
 type BatchReader []byte
 
@@ -0,0 +1,142 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"unsafe"
+
+	"github.com/cockroachdb/pebble/internal/rawalloc"
+)
+
+// BatchArena is a reusable region of memory from which batches allocate their
+// representations. Batches allocated from an arena write their records
+// directly into the arena's memory, avoiding the allocations and copies
+// performed as a heap-allocated batch grows. Batches are allocated from the
+// arena consecutively. The memory of a batch is reclaimed when the batch is
+// closed before another batch is allocated, or when the arena is reset.
+//
+// If the batches allocated between two calls to Reset require more memory than
+// the arena holds, the excess is allocated from the heap and the arena is
+// grown by the next call to Reset, so that a workload that repeatedly builds
+// batches of similar sizes settles into performing no allocations.
+//
+// A BatchArena is not safe for concurrent use. Batches allocated from an arena
+// must be built and closed by the arena's user, and a batch may only be
+// modified until the next batch is allocated from the same arena.
+type BatchArena struct {
+	buf []byte
+	// off is the offset within buf of the representation of the most recently
+	// allocated batch.
+	off int
+	// last is the most recently allocated batch, which may still be growing.
+	last *Batch
+	// peak is the amount of memory required to hold the representations of
+	// all of the batches allocated since the last Reset.
+	peak int
+}
+
+// NewBatchArena returns a new BatchArena holding size bytes.
+func NewBatchArena(size int) *BatchArena {
+	return &BatchArena{buf: rawalloc.New(size, size)}
+}
+
+// Reset makes all of the arena's memory available to new batches, growing the
+// arena if the batches allocated since the last Reset did not fit. The batches
+// allocated from the arena since the last Reset must no longer be in use.
+func (a *BatchArena) Reset() {
+	a.seal()
+	// Batches transiently reserve space for the maximum varint lengths of a
+	// record's key and value, so the arena is grown with headroom.
+	if need := a.peak + 2*maxVarintLen32; a.buf == nil || need > len(a.buf) {
+		n := len(a.buf)
+		if n < batchInitialSize {
+			n = batchInitialSize
+		}
+		for n < need {
+			n *= 2
+		}
+		a.buf = rawalloc.New(n, n)
+	}
+	a.off = 0
+	a.peak = 0
+}
+
+// alloc allocates the representation of b from the arena.
+func (a *BatchArena) alloc(b *Batch) {
+	a.seal()
+	b.arena = a
+	a.last = b
+	if len(a.buf)-a.off < batchHeaderLen {
+		// The arena is exhausted. The batch allocates its representation from
+		// the heap, and is accounted for when it is sealed.
+		return
+	}
+	b.data = a.buf[a.off : a.off+batchHeaderLen : len(a.buf)]
+	b.setCount(0)
+	b.setSeqNum(0)
+}
+
+// seal finalizes the size of the most recently allocated batch, preventing it
+// from growing into the memory of subsequently allocated batches.
+func (a *BatchArena) seal() {
+	if a.last == nil {
+		return
+	}
+	b := a.last
+	a.last = nil
+	n := len(b.data)
+	a.updatePeak(n)
+	if a.contains(b) {
+		a.off += n
+		b.data = b.data[:n:n]
+	}
+}
+
+// release is called when b is closed.
+func (a *BatchArena) release(b *Batch) {
+	if a.last == b {
+		// The batch's memory is reclaimed by the next batch.
+		a.updatePeak(len(b.data))
+		a.last = nil
+	}
+}
+
+// retain is called when the representation of b is retained by the DB after
+// b is committed, which occurs for batches that are too large to be added to
+// a memtable. The arena's memory is abandoned to the DB.
+func (a *BatchArena) retain(b *Batch) {
+	if a.last == b {
+		a.updatePeak(len(b.data))
+		a.last = nil
+	}
+	if a.contains(b) {
+		a.buf = nil
+		a.off = 0
+	}
+}
+
+func (a *BatchArena) updatePeak(n int) {
+	if a.off+n > a.peak {
+		a.peak = a.off + n
+	}
+}
+
+// contains returns true if b's representation is allocated from the arena.
+func (a *BatchArena) contains(b *Batch) bool {
+	if cap(b.data) == 0 || len(a.buf) == 0 {
+		return false
+	}
+	p := uintptr(unsafe.Pointer(&b.data[:1][0]))
+	start := uintptr(unsafe.Pointer(&a.buf[0]))
+	return p >= start && p < start+uintptr(len(a.buf))
+}
+
+// NewBatchWithArena returns a new empty write-only batch whose representation
+// is allocated from the provided arena. See BatchArena.
+func (d *DB) NewBatchWithArena(a *BatchArena) *Batch {
+	b := newBatch(d)
+	a.alloc(b)
+	return b
+}
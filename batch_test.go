@@ -203,6 +203,184 @@ func TestBatchIngestSST(t *testing.T) {
 	require.Equal(t, b.ingestedSSTBatch, true)
 }
 
+func TestBatchContentStats(t *testing.T) {
+	var b Batch
+	stats, err := b.ContentStats()
+	require.NoError(t, err)
+	require.Equal(t, BatchContentStats{}, stats)
+
+	require.NoError(t, b.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, b.Set([]byte("a"), []byte("22"), nil))
+	require.NoError(t, b.Merge([]byte("b"), []byte("333"), nil))
+	require.NoError(t, b.Delete([]byte("c"), nil))
+	require.NoError(t, b.SingleDelete([]byte("dd"), nil))
+	require.NoError(t, b.DeleteSized([]byte("e"), 10, nil))
+	require.NoError(t, b.DeleteRange([]byte("f"), []byte("gg"), nil))
+	require.NoError(t, b.RangeKeySet([]byte("h"), []byte("i"), nil, []byte("v"), nil))
+	require.NoError(t, b.RangeKeyDelete([]byte("h"), []byte("i"), nil))
+	require.NoError(t, b.LogData([]byte("log"), nil))
+
+	stats, err = b.ContentStats()
+	require.NoError(t, err)
+	require.Equal(t, BatchKindStats{Count: 2, KeyBytes: 2, ValueBytes: 3}, stats.Sets)
+	require.Equal(t, BatchKindStats{Count: 1, KeyBytes: 1, ValueBytes: 3}, stats.Merges)
+	require.Equal(t, uint64(3), stats.Deletes.Count)
+	require.Equal(t, uint64(4), stats.Deletes.KeyBytes)
+	require.Equal(t, BatchKindStats{Count: 1, KeyBytes: 1, ValueBytes: 2}, stats.RangeDeletes)
+	require.Equal(t, uint64(2), stats.RangeKeys.Count)
+	require.Equal(t, BatchKindStats{Count: 1, KeyBytes: 3}, stats.LogData)
+
+	// ForEachRecord visits every record in order, including shadowed records
+	// and log data.
+	var buf strings.Builder
+	require.NoError(t, b.ForEachRecord(func(kind InternalKeyKind, key, value []byte) error {
+		fmt.Fprintf(&buf, "%s:%s=%q\n", kind, key, value)
+		return nil
+	}))
+	require.Equal(t, `SET:a="1"
+SET:a="22"
+MERGE:b="333"
+DEL:c=""
+SINGLEDEL:dd=""
+DELSIZED:e="\v"
+RANGEDEL:f="gg"
+RANGEKEYSET:h="\x01i\x00\x01v"
+RANGEKEYDEL:h="i"
+LOGDATA:log=""
+`, buf.String())
+
+	// Errors returned by the callback stop the iteration.
+	var n int
+	errStop := errors.New("stop")
+	require.ErrorIs(t, b.ForEachRecord(func(InternalKeyKind, []byte, []byte) error {
+		n++
+		return errStop
+	}), errStop)
+	require.Equal(t, 1, n)
+
+	// A corrupt batch returns an error.
+	b.data = b.data[:len(b.data)-1]
+	_, err = b.ContentStats()
+	require.Error(t, err)
+}
+
+func TestBatchEncodeDecode(t *testing.T) {
+	var b Batch
+	require.NoError(t, b.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, b.Merge([]byte("b"), []byte("2"), nil))
+	require.NoError(t, b.Delete([]byte("c"), nil))
+	require.NoError(t, b.DeleteRange([]byte("d"), []byte("e"), nil))
+	require.NoError(t, b.RangeKeySet([]byte("f"), []byte("g"), []byte("@1"), []byte("3"), nil))
+	require.NoError(t, b.LogData([]byte("log"), nil))
+	b.setSeqNum(42)
+
+	enc, err := b.Encode([]byte("prefix"))
+	require.NoError(t, err)
+	require.Equal(t, "prefix", string(enc[:6]))
+	enc = enc[6:]
+
+	var unindexed Batch
+	require.NoError(t, unindexed.Decode(enc))
+	require.Equal(t, b.Repr(), unindexed.Repr())
+	require.Equal(t, uint32(5), unindexed.Count())
+	require.Equal(t, uint64(42), unindexed.SeqNum())
+	require.Equal(t, uint64(1), unindexed.countRangeDels)
+	require.Equal(t, uint64(1), unindexed.countRangeKeys)
+	require.Equal(t, FormatRangeKeys, unindexed.minimumFormatMajorVersion)
+
+	// The decoded batch must not alias the encoding.
+	copied := append([]byte(nil), enc...)
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	indexed := d.NewIndexedBatch()
+	require.NoError(t, indexed.Decode(copied))
+	for i := range copied {
+		copied[i] = 0
+	}
+	v, closer, err := indexed.Get([]byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, "1", string(v))
+	require.NoError(t, closer.Close())
+	_, _, err = indexed.Get([]byte("c"))
+	require.ErrorIs(t, err, ErrNotFound)
+
+	// Decoding into a non-empty batch is an error.
+	require.Error(t, unindexed.Decode(enc))
+
+	// Corrupt encodings are rejected and leave the receiver untouched.
+	for _, tc := range []struct {
+		name   string
+		mutate func([]byte) []byte
+	}{
+		{"truncated", func(d []byte) []byte { return d[:len(d)-1] }},
+		{"too-short", func(d []byte) []byte { return d[:batchHeaderLen] }},
+		{"version", func(d []byte) []byte { d[0] = BatchEncodingVersion + 1; return d }},
+		{"checksum", func(d []byte) []byte { d[len(d)-6] ^= 0xff; return d }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var b Batch
+			require.Error(t, b.Decode(tc.mutate(append([]byte(nil), enc...))))
+			require.True(t, b.Empty())
+		})
+	}
+
+	// Batches of ingested sstables cannot be encoded.
+	var ingest Batch
+	ingest.ingestSST(1)
+	_, err = ingest.Encode(nil)
+	require.Error(t, err)
+}
+
+func TestBatchArena(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	a := NewBatchArena(256)
+	b1 := d.NewBatchWithArena(a)
+	require.True(t, a.contains(b1))
+	require.NoError(t, b1.Set([]byte("a"), []byte("1"), nil))
+
+	// Allocating another batch seals the first: further writes to it must not
+	// overwrite the second batch.
+	b2 := d.NewBatchWithArena(a)
+	require.True(t, a.contains(b2))
+	require.NoError(t, b2.Set([]byte("b"), []byte("2"), nil))
+	require.NoError(t, b1.Set([]byte("c"), []byte("3"), nil))
+	require.False(t, a.contains(b1))
+	require.NoError(t, d.Apply(b1, nil))
+	require.NoError(t, d.Apply(b2, nil))
+	verifyGet(t, d, []byte("a"), []byte("1"))
+	verifyGet(t, d, []byte("b"), []byte("2"))
+	verifyGet(t, d, []byte("c"), []byte("3"))
+
+	// Closing the most recently allocated batch reclaims its memory.
+	off := a.off
+	require.NoError(t, b2.Close())
+	b3 := d.NewBatchWithArena(a)
+	require.Equal(t, off, a.off)
+	require.NoError(t, b1.Close())
+	require.NoError(t, b3.Close())
+
+	// Batches that overflow the arena are allocated from the heap, and the
+	// arena grows on Reset.
+	a.Reset()
+	b4 := d.NewBatchWithArena(a)
+	value := bytes.Repeat([]byte("x"), 1000)
+	require.NoError(t, b4.Set([]byte("d"), value, nil))
+	require.False(t, a.contains(b4))
+	require.NoError(t, d.Apply(b4, nil))
+	require.NoError(t, b4.Close())
+	a.Reset()
+	require.GreaterOrEqual(t, len(a.buf), 1000)
+	b5 := d.NewBatchWithArena(a)
+	require.NoError(t, b5.Set([]byte("d"), value, nil))
+	require.True(t, a.contains(b5))
+	require.NoError(t, b5.Close())
+	verifyGet(t, d, []byte("d"), value)
+}
+
 func TestBatchLen(t *testing.T) {
 	var b Batch
 
@@ -875,6 +1053,50 @@ func TestBatchIter(t *testing.T) {
 	}
 }
 
+func TestBatchIterMerge(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Spread the committed operands across an sstable and the memtable.
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Merge([]byte("b"), []byte("1"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Merge([]byte("a"), []byte("2"), nil))
+	require.NoError(t, d.Merge([]byte("b"), []byte("2"), nil))
+
+	b := d.NewIndexedBatch()
+	defer b.Close()
+	require.NoError(t, b.Merge([]byte("a"), []byte("3"), nil))
+	require.NoError(t, b.Delete([]byte("b"), nil))
+	require.NoError(t, b.Merge([]byte("b"), []byte("3"), nil))
+	require.NoError(t, b.Merge([]byte("c"), []byte("3"), nil))
+
+	iter, err := b.NewIter(nil)
+	require.NoError(t, err)
+	collect := func() (fwd, rev string) {
+		for valid := iter.First(); valid; valid = iter.Next() {
+			fwd += fmt.Sprintf("%s:%s ", iter.Key(), iter.Value())
+		}
+		for valid := iter.Last(); valid; valid = iter.Prev() {
+			rev += fmt.Sprintf("%s:%s ", iter.Key(), iter.Value())
+		}
+		return fwd, rev
+	}
+	fwd, rev := collect()
+	require.Equal(t, "a:123 b:3 c:3 ", fwd)
+	require.Equal(t, "c:3 b:3 a:123 ", rev)
+
+	// Operands added to the batch after the iterator was created become
+	// visible once the iterator's view of the batch is refreshed.
+	require.NoError(t, b.Merge([]byte("a"), []byte("4"), nil))
+	iter.SetOptions(&IterOptions{})
+	fwd, rev = collect()
+	require.Equal(t, "a:1234 b:3 c:3 ", fwd)
+	require.Equal(t, "c:3 b:3 a:1234 ", rev)
+	require.NoError(t, iter.Close())
+}
+
 func TestBatchRangeOps(t *testing.T) {
 	var b *Batch
 
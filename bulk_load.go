@@ -0,0 +1,378 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// errBulkLoaderFinished is returned by the methods of a BulkLoader after it
+// has been finished or aborted.
+var errBulkLoaderFinished = errors.New("pebble: bulk loader already finished")
+
+// BulkLoader loads keys written in increasing order into the DB, bypassing the
+// WAL and the memtables. The keys are written directly into sstables sized for
+// the bottommost level, which are ingested into the DB by Finish, into the
+// lowest level of the LSM that the loaded key range does not overlap (the
+// bottommost level when loading into an empty key range). The loaded keys
+// become visible atomically when Finish returns, with sequence numbers higher
+// than those of all of the keys written before.
+//
+// Point keys (Set, Delete, Merge), range deletions and range keys may be
+// loaded. Each of them must be added at or after the start key of the one
+// added before, and point keys must be strictly increasing. Range deletions
+// must not overlap each other, and neither must range keys. The range
+// deletions and range key deletions of a load apply to the keys written
+// before the load, and not to the keys of the load.
+//
+// A BulkLoader is not safe for concurrent use. A BulkLoader that is neither
+// finished nor aborted leaves temporary files in the DB directory, which are
+// removed when the DB is next opened.
+type BulkLoader struct {
+	d     *DB
+	opts  sstable.WriterOptions
+	w     *sstable.Writer
+	paths []string
+	// lastKey is a copy of the last point key, or start key of a span,
+	// written.
+	lastKey []byte
+	// hasLastKey is set once a key has been written.
+	hasLastKey bool
+	// lastPointKey is a copy of the last point key written.
+	lastPointKey []byte
+	// hasLastPointKey is set once a point key has been written.
+	hasLastPointKey bool
+	// rangeDel and rangeKey are the last range deletion and range key
+	// written, which are added to the sstable once the following one is
+	// written, or the sstable is finished. When the sstable is finished, they
+	// are truncated to the bounds of the sstable, and their remainder is
+	// written to the next sstable.
+	rangeDel, rangeKey bulkLoadSpan
+	// split is set when the sstable has reached its target size. The sstable
+	// is finished before the next key greater than lastKey is written, so
+	// that the sstables of the load don't overlap.
+	split bool
+	err   error
+}
+
+// bulkLoadSpan is a range deletion or range key written by a BulkLoader.
+type bulkLoadSpan struct {
+	kind          InternalKeyKind
+	start, end    []byte
+	suffix, value []byte
+}
+
+func (s *bulkLoadSpan) empty() bool {
+	return s.start == nil
+}
+
+// write adds [s.start, end) to the sstable written by w.
+func (s *bulkLoadSpan) write(w *sstable.Writer, end []byte) error {
+	switch s.kind {
+	case InternalKeyKindRangeDelete:
+		return w.DeleteRange(s.start, end)
+	case InternalKeyKindRangeKeySet:
+		return w.RangeKeySet(s.start, end, s.suffix, s.value)
+	case InternalKeyKindRangeKeyUnset:
+		return w.RangeKeyUnset(s.start, end, s.suffix)
+	default:
+		return w.RangeKeyDelete(s.start, end)
+	}
+}
+
+// NewBulkLoader returns a BulkLoader that loads keys into the DB.
+func (d *DB) NewBulkLoader() *BulkLoader {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	l := &BulkLoader{
+		d:    d,
+		opts: d.opts.MakeWriterOptions(numLevels-1, d.FormatMajorVersion().MaxTableFormat()),
+	}
+	// The sstables may be ingested above the bottommost level, where the
+	// deletions they hold must not be marked obsolete.
+	l.opts.WritingToLowestLevel = false
+	l.err = d.checkWritable()
+	return l
+}
+
+// Set adds a key with the given value to the load. The key must be greater
+// than all of the point keys previously added to the load. The key and value
+// may be modified by the caller once Set returns.
+func (l *BulkLoader) Set(key, value []byte) error {
+	return l.addPoint(InternalKeyKindSet, key, value)
+}
+
+// Delete adds a deletion of the key to the load. The key must be greater than
+// all of the point keys previously added to the load. The key may be modified
+// by the caller once Delete returns.
+func (l *BulkLoader) Delete(key []byte) error {
+	return l.addPoint(InternalKeyKindDelete, key, nil)
+}
+
+// Merge adds a merge of the value into the key to the load. The key must be
+// greater than all of the point keys previously added to the load. The key
+// and value may be modified by the caller once Merge returns.
+func (l *BulkLoader) Merge(key, value []byte) error {
+	return l.addPoint(InternalKeyKindMerge, key, value)
+}
+
+// DeleteRange adds a deletion of the keys in [start, end) written before the
+// load to the load. The range must not overlap the range deletions previously
+// added to the load. The keys may be modified by the caller once DeleteRange
+// returns.
+func (l *BulkLoader) DeleteRange(start, end []byte) error {
+	return l.addSpan(bulkLoadSpan{kind: InternalKeyKindRangeDelete, start: start, end: end})
+}
+
+// RangeKeySet adds a range key setting the suffix to the value over [start,
+// end) to the load. The range must not overlap the range keys previously
+// added to the load. The arguments may be modified by the caller once
+// RangeKeySet returns.
+func (l *BulkLoader) RangeKeySet(start, end, suffix, value []byte) error {
+	return l.addSpan(bulkLoadSpan{
+		kind:   InternalKeyKindRangeKeySet,
+		start:  start,
+		end:    end,
+		suffix: suffix,
+		value:  value,
+	})
+}
+
+// RangeKeyUnset adds a range key unsetting the suffix over [start, end) to the
+// load. The range must not overlap the range keys previously added to the
+// load. The arguments may be modified by the caller once RangeKeyUnset
+// returns.
+func (l *BulkLoader) RangeKeyUnset(start, end, suffix []byte) error {
+	return l.addSpan(bulkLoadSpan{
+		kind:   InternalKeyKindRangeKeyUnset,
+		start:  start,
+		end:    end,
+		suffix: suffix,
+	})
+}
+
+// RangeKeyDelete adds a deletion of the range keys in [start, end) written
+// before the load to the load. The range must not overlap the range keys
+// previously added to the load. The keys may be modified by the caller once
+// RangeKeyDelete returns.
+func (l *BulkLoader) RangeKeyDelete(start, end []byte) error {
+	return l.addSpan(bulkLoadSpan{kind: InternalKeyKindRangeKeyDelete, start: start, end: end})
+}
+
+func (l *BulkLoader) addPoint(kind InternalKeyKind, key, value []byte) error {
+	if l.err != nil {
+		return l.err
+	}
+	if l.hasLastPointKey && l.d.cmp(key, l.lastPointKey) <= 0 {
+		return errors.Errorf("pebble: bulk load keys must be strictly increasing: %s after %s",
+			l.d.opts.Comparer.FormatKey(key), l.d.opts.Comparer.FormatKey(l.lastPointKey))
+	}
+	if err := l.checkOrder(key); err != nil {
+		return err
+	}
+	if l.err = l.advance(key); l.err != nil {
+		return l.err
+	}
+	switch kind {
+	case InternalKeyKindSet:
+		l.err = l.w.Set(key, value)
+	case InternalKeyKindDelete:
+		l.err = l.w.Delete(key)
+	case InternalKeyKindMerge:
+		l.err = l.w.Merge(key, value)
+	}
+	if l.err != nil {
+		return l.err
+	}
+	l.lastPointKey = append(l.lastPointKey[:0], key...)
+	l.hasLastPointKey = true
+	if l.w.EstimatedSize() >= uint64(l.d.opts.Level(numLevels-1).TargetFileSize) {
+		l.split = true
+	}
+	return nil
+}
+
+func (l *BulkLoader) addSpan(s bulkLoadSpan) error {
+	if l.err != nil {
+		return l.err
+	}
+	if l.d.cmp(s.start, s.end) >= 0 {
+		return errors.Errorf("pebble: bulk load span start %s is not less than end %s",
+			l.d.opts.Comparer.FormatKey(s.start), l.d.opts.Comparer.FormatKey(s.end))
+	}
+	prev := &l.rangeDel
+	if s.kind != InternalKeyKindRangeDelete {
+		if l.d.FormatMajorVersion() < FormatRangeKeys {
+			return errors.Errorf(
+				"pebble: range keys require at least format major version %d (current: %d)",
+				FormatRangeKeys, l.d.FormatMajorVersion())
+		}
+		if l.d.split == nil {
+			return errNoSplit
+		}
+		prev = &l.rangeKey
+	}
+	if !prev.empty() && l.d.cmp(s.start, prev.end) < 0 {
+		return errors.Errorf("pebble: bulk load spans must not overlap: %s-%s after %s-%s",
+			l.d.opts.Comparer.FormatKey(s.start), l.d.opts.Comparer.FormatKey(s.end),
+			l.d.opts.Comparer.FormatKey(prev.start), l.d.opts.Comparer.FormatKey(prev.end))
+	}
+	if err := l.checkOrder(s.start); err != nil {
+		return err
+	}
+	if l.err = l.advance(s.start); l.err != nil {
+		return l.err
+	}
+	if !prev.empty() {
+		if l.err = prev.write(l.w, prev.end); l.err != nil {
+			return l.err
+		}
+	}
+	// The span is copied, as it's written to the sstable later.
+	*prev = bulkLoadSpan{
+		kind:   s.kind,
+		start:  append([]byte(nil), s.start...),
+		end:    append([]byte(nil), s.end...),
+		suffix: append([]byte(nil), s.suffix...),
+		value:  append([]byte(nil), s.value...),
+	}
+	return nil
+}
+
+// checkOrder checks that key, the next point key or span start key written,
+// is not less than the last one.
+func (l *BulkLoader) checkOrder(key []byte) error {
+	if l.hasLastKey && l.d.cmp(key, l.lastKey) < 0 {
+		return errors.Errorf("pebble: bulk load keys must be increasing: %s after %s",
+			l.d.opts.Comparer.FormatKey(key), l.d.opts.Comparer.FormatKey(l.lastKey))
+	}
+	return nil
+}
+
+// advance prepares the sstable that key, the next point key or span start key
+// written, is written to, finishing the current sstable first if it has
+// reached its target size.
+func (l *BulkLoader) advance(key []byte) error {
+	if l.split && l.d.cmp(key, l.lastKey) > 0 {
+		if err := l.splitTable(key); err != nil {
+			return err
+		}
+	}
+	if l.w == nil {
+		if err := l.newTable(); err != nil {
+			return err
+		}
+	}
+	l.lastKey = append(l.lastKey[:0], key...)
+	l.hasLastKey = true
+	return nil
+}
+
+// splitTable finishes the current sstable of the load before key, truncating
+// the spans extending past key, whose remainder is carried over to the next
+// sstable.
+func (l *BulkLoader) splitTable(key []byte) error {
+	for _, s := range []*bulkLoadSpan{&l.rangeDel, &l.rangeKey} {
+		if s.empty() {
+			continue
+		}
+		if l.d.cmp(s.end, key) <= 0 {
+			if err := s.write(l.w, s.end); err != nil {
+				return err
+			}
+			*s = bulkLoadSpan{}
+			continue
+		}
+		if err := s.write(l.w, key); err != nil {
+			return err
+		}
+		s.start = append([]byte(nil), key...)
+	}
+	l.split = false
+	return l.closeTable()
+}
+
+// newTable creates the next sstable of the load in a temporary file in the
+// DB directory.
+func (l *BulkLoader) newTable() error {
+	l.d.mu.Lock()
+	fileNum := l.d.mu.versions.getNextFileNum()
+	l.d.mu.Unlock()
+	path := base.MakeFilepath(l.d.opts.FS, l.d.dirname, fileTypeTemp, fileNum.DiskFileNum())
+	f, err := l.d.opts.FS.Create(path)
+	if err != nil {
+		return err
+	}
+	l.paths = append(l.paths, path)
+	l.w = sstable.NewWriter(objstorageprovider.NewFileWritable(f), l.opts)
+	return nil
+}
+
+// closeTable finishes the current sstable of the load.
+func (l *BulkLoader) closeTable() error {
+	w := l.w
+	l.w = nil
+	return w.Close()
+}
+
+// Finish ingests the loaded keys into the DB, making them visible. The
+// BulkLoader may not be used after Finish returns. If Finish returns an
+// error, none of the loaded keys are visible.
+func (l *BulkLoader) Finish() error {
+	if l.err != nil {
+		return l.err
+	}
+	if l.w != nil {
+		for _, s := range []*bulkLoadSpan{&l.rangeDel, &l.rangeKey} {
+			if !s.empty() {
+				if l.err = s.write(l.w, s.end); l.err != nil {
+					return l.err
+				}
+			}
+		}
+		if l.err = l.closeTable(); l.err != nil {
+			return l.err
+		}
+	}
+	var err error
+	if len(l.paths) > 0 {
+		// A successful ingestion links or copies the sstables into the DB and
+		// removes the temporary files.
+		if err = l.d.Ingest(l.paths); err != nil {
+			err = firstError(err, l.removeTables())
+		}
+	}
+	l.err = errBulkLoaderFinished
+	return err
+}
+
+// Abort abandons the load, discarding the loaded keys. The BulkLoader may not
+// be used after Abort returns.
+func (l *BulkLoader) Abort() error {
+	if errors.Is(l.err, errBulkLoaderFinished) {
+		return l.err
+	}
+	if l.w != nil {
+		// The table is being discarded, so an error closing it is irrelevant.
+		_ = l.closeTable()
+	}
+	err := l.removeTables()
+	l.err = errBulkLoaderFinished
+	return err
+}
+
+// removeTables removes the temporary files of the load.
+func (l *BulkLoader) removeTables() error {
+	var err error
+	for _, path := range l.paths {
+		err = firstError(err, l.d.opts.FS.Remove(path))
+	}
+	l.paths = nil
+	return err
+}
@@ -0,0 +1,176 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBulkLoader(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{FS: mem, Levels: make([]LevelOptions, numLevels)}
+	for i := range opts.Levels {
+		opts.Levels[i].TargetFileSize = 16 << 10
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+	tempFiles := func() int {
+		ls, err := mem.List("")
+		require.NoError(t, err)
+		var n int
+		for _, name := range ls {
+			if ft, _, ok := base.ParseFilename(mem, name); ok && ft == fileTypeTemp {
+				n++
+			}
+		}
+		return n
+	}
+
+	require.NoError(t, d.Set([]byte("a"), []byte("old"), nil))
+	l := d.NewBulkLoader()
+	rng := rand.New(rand.NewSource(1))
+	value := make([]byte, 100)
+	var value500 []byte
+	for i := 0; i < 1000; i++ {
+		rng.Read(value)
+		if i == 500 {
+			value500 = append([]byte(nil), value...)
+		}
+		require.NoError(t, l.Set([]byte(fmt.Sprintf("key%04d", i)), value))
+	}
+	require.Error(t, l.Set([]byte("key0999"), value))
+	require.Error(t, l.Set([]byte("a"), value))
+	require.NoError(t, l.Set([]byte("key1000"), value))
+	require.Positive(t, tempFiles())
+
+	// The loaded keys are not visible until the load is finished.
+	_, _, err = d.Get([]byte("key0000"))
+	require.ErrorIs(t, err, ErrNotFound)
+	require.NoError(t, l.Finish())
+	require.ErrorIs(t, l.Set([]byte("key1001"), value), errBulkLoaderFinished)
+	require.Zero(t, tempFiles())
+
+	v, closer, err := d.Get([]byte("key0500"))
+	require.NoError(t, err)
+	require.Equal(t, value500, v)
+	require.NoError(t, closer.Close())
+
+	// The loaded keys are written directly into multiple sstables in the
+	// bottommost level.
+	m := d.Metrics()
+	require.Greater(t, m.Levels[numLevels-1].NumFiles, int64(1))
+	for level := 0; level < numLevels-1; level++ {
+		require.Zero(t, m.Levels[level].NumFiles)
+	}
+
+	// An aborted load leaves no trace.
+	l = d.NewBulkLoader()
+	require.NoError(t, l.Set([]byte("zzz"), value))
+	require.NoError(t, l.Abort())
+	require.Zero(t, tempFiles())
+	_, _, err = d.Get([]byte("zzz"))
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestBulkLoaderKeyKinds(t *testing.T) {
+	opts := &Options{
+		FS:                 vfs.NewMem(),
+		Comparer:           testkeys.Comparer,
+		FormatMajorVersion: internalFormatNewest,
+		Levels:             make([]LevelOptions, numLevels),
+	}
+	for i := range opts.Levels {
+		opts.Levels[i].TargetFileSize = 16 << 10
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	for _, k := range []string{"a", "b", "c", "k5", "m"} {
+		require.NoError(t, d.Set([]byte(k), []byte("1"), nil))
+	}
+	require.NoError(t, d.RangeKeySet([]byte("p"), []byte("t"), []byte("@1"), []byte("v"), nil))
+	require.NoError(t, d.Flush())
+
+	l := d.NewBulkLoader()
+	require.NoError(t, l.DeleteRange([]byte("a"), []byte("c")))
+	require.NoError(t, l.Set([]byte("b"), []byte("2")))
+	require.NoError(t, l.Delete([]byte("c")))
+	// The range deletion spans several sstables of the load.
+	require.NoError(t, l.DeleteRange([]byte("k"), []byte("l")))
+	rng := rand.New(rand.NewSource(1))
+	value := make([]byte, 100)
+	for i := 0; i < 1000; i++ {
+		rng.Read(value)
+		require.NoError(t, l.Set([]byte(fmt.Sprintf("k%04d", i)), value))
+	}
+	require.NoError(t, l.Merge([]byte("m"), []byte("2")))
+	require.NoError(t, l.RangeKeyUnset([]byte("q"), []byte("r"), []byte("@1")))
+	require.NoError(t, l.RangeKeySet([]byte("s"), []byte("u"), []byte("@2"), []byte("w")))
+
+	// Range deletions and range keys may not overlap the ones added before,
+	// and keys may not be added before the last one.
+	require.Error(t, l.DeleteRange([]byte("kz"), []byte("n")))
+	require.Error(t, l.RangeKeyDelete([]byte("t"), []byte("v")))
+	require.Error(t, l.DeleteRange([]byte("n"), []byte("n")))
+	require.Error(t, l.Set([]byte("m0"), nil))
+	require.NoError(t, l.Finish())
+	// The flushed table and the several loaded ones.
+	require.Greater(t, d.Metrics().Total().NumFiles, int64(2))
+
+	var buf strings.Builder
+	formatKeys := func(keys []RangeKeyData) {
+		for _, k := range keys {
+			fmt.Fprintf(&buf, " %s=%s", k.Suffix, k.Value)
+		}
+	}
+	iter, err := d.NewIter(&IterOptions{KeyTypes: IterKeyTypePointsAndRanges})
+	require.NoError(t, err)
+	for valid := iter.First(); valid; valid = iter.Next() {
+		if hasPoint, hasRange := iter.HasPointAndRange(); hasPoint {
+			if len(iter.Key()) == 5 && iter.Key()[0] == 'k' {
+				// Only count the loaded keys within the range deletion.
+				continue
+			}
+			fmt.Fprintf(&buf, "%s=%s\n", iter.Key(), iter.Value())
+		} else if hasRange && iter.RangeKeyChanged() {
+			start, end := iter.RangeBounds()
+			fmt.Fprintf(&buf, "[%s-%s)", start, end)
+			formatKeys(iter.RangeKeys())
+			buf.WriteString("\n")
+		}
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, `b=2
+m=12
+[p-q) @1=v
+[r-s) @1=v
+[s-t) @2=w @1=v
+[t-u) @2=w
+`, buf.String())
+
+	// The loaded keys within the range deletion aren't deleted.
+	iter, err = d.NewIter(&IterOptions{LowerBound: []byte("k"), UpperBound: []byte("l")})
+	require.NoError(t, err)
+	var n int
+	for valid := iter.First(); valid; valid = iter.Next() {
+		n++
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, 1000, n)
+}
@@ -4,7 +4,10 @@
 
 package pebble
 
-import "github.com/cockroachdb/pebble/internal/cache"
+import (
+	"github.com/cockroachdb/pebble/internal/cache"
+	"github.com/cockroachdb/pebble/vfs"
+)
 
 // Cache exports the cache.Cache type.
 type Cache = cache.Cache
@@ -21,3 +24,35 @@ type Cache = cache.Cache
 func NewCache(size int64) *cache.Cache {
 	return cache.New(size)
 }
+
+// CachePolicy exports the cache.Policy type, a replacement policy of the block
+// cache.
+type CachePolicy = cache.Policy
+
+// The replacement policies of the block cache. See the cache package for their
+// descriptions.
+const (
+	CachePolicyClockPro = cache.ClockPro
+	CachePolicyS3FIFO   = cache.S3FIFO
+)
+
+// NewCacheWithPolicy creates a new cache like NewCache, which uses the
+// specified replacement policy rather than the default CLOCK-Pro policy. The
+// policy that performs best depends on the workload; for example, S3-FIFO may
+// better protect the working set of point lookups from interleaved scans.
+func NewCacheWithPolicy(size int64, policy CachePolicy) *cache.Cache {
+	return cache.NewWithPolicy(size, policy)
+}
+
+// NewCacheWithSecondary creates a new cache like NewCache, with a secondary
+// tier of secondarySize bytes stored in the file at path on fs, typically on
+// local NVMe. Blocks evicted from the in-memory cache are written to the
+// secondary cache and re-admitted from it on a miss, which improves read
+// latency for working sets larger than memory, particularly when sstables
+// reside on remote storage. The file is removed when the cache's last
+// reference is released.
+func NewCacheWithSecondary(
+	size int64, fs vfs.FS, path string, secondarySize int64,
+) (*cache.Cache, error) {
+	return cache.NewWithSecondary(size, fs, path, secondarySize)
+}
@@ -5,11 +5,15 @@
 package pebble
 
 import (
+	"context"
+	"hash/crc32"
 	"io"
 	"os"
 
 	"github.com/cockroachdb/errors/oserror"
 	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/internal/rate"
 	"github.com/cockroachdb/pebble/record"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/cockroachdb/pebble/vfs/atomicfs"
@@ -24,6 +28,13 @@ type checkpointOptions struct {
 
 	// If set, any SSTs that don't overlap with these spans are excluded from a checkpoint.
 	restrictToSpans []CheckpointSpan
+
+	// copyBytesPerSec limits the rate of the copies, if positive.
+	copyBytesPerSec int64
+	// progress, if set, is invoked as files are linked or copied.
+	progress func(CheckpointProgress)
+	// ctx, if set, cancels the checkpoint when it's done.
+	ctx context.Context
 }
 
 // CheckpointOption set optional parameters used by `DB.Checkpoint`.
@@ -57,6 +68,48 @@ func WithRestrictToSpans(spans []CheckpointSpan) CheckpointOption {
 	}
 }
 
+// WithCopyRateLimit limits the rate at which files are copied into the
+// checkpoint, to bytesPerSec bytes per second. The sstables and OPTIONS are
+// hard linked when possible, but they're copied when the checkpoint is on
+// another filesystem than the DB; the MANIFEST and the WALs are always copied.
+// The default is to copy files as fast as possible.
+func WithCopyRateLimit(bytesPerSec int64) CheckpointOption {
+	return func(opt *checkpointOptions) {
+		opt.copyBytesPerSec = bytesPerSec
+	}
+}
+
+// WithProgress sets a function invoked with the progress of the checkpoint
+// after each file is linked or copied, and periodically while files are
+// copied. It's invoked synchronously by DB.Checkpoint.
+func WithProgress(fn func(CheckpointProgress)) CheckpointOption {
+	return func(opt *checkpointOptions) {
+		opt.progress = fn
+	}
+}
+
+// WithContext makes the checkpoint abandoned when ctx is done: the partial
+// checkpoint is removed and DB.Checkpoint returns ctx.Err(). The context is
+// checked between files, and periodically while files are copied.
+func WithContext(ctx context.Context) CheckpointOption {
+	return func(opt *checkpointOptions) {
+		opt.ctx = ctx
+	}
+}
+
+// CheckpointProgress is the progress of a checkpoint (see WithProgress).
+type CheckpointProgress struct {
+	// Files is the number of files linked or copied so far, out of
+	// TotalFiles.
+	Files, TotalFiles int
+	// Bytes is the size of the files linked or copied so far, out of
+	// TotalBytes. TotalBytes may grow slightly while the WALs, which are still
+	// written to, are copied.
+	Bytes, TotalBytes int64
+	// CopiedBytes is the number of bytes copied so far, rather than linked.
+	CopiedBytes int64
+}
+
 // CheckpointSpan is a key range [Start, End) (inclusive on Start, exclusive on
 // End) of interest for a checkpoint.
 type CheckpointSpan struct {
@@ -131,7 +184,9 @@ func mkdirAllAndSyncParents(fs vfs.FS, destDir string) (vfs.File, error) {
 
 // Checkpoint constructs a snapshot of the DB instance in the specified
 // directory. The WAL, MANIFEST, OPTIONS, and sstables will be copied into the
-// snapshot. Hard links will be used when possible. Beware of the significant
+// snapshot. Hard links will be used when possible, and files are copied
+// otherwise, e.g. when the checkpoint is on another filesystem than the DB (see
+// WithCopyRateLimit, WithProgress and WithContext). Beware of the significant
 // space overhead for a checkpoint if hard links are disabled. Also beware that
 // even if hard links are used, the space overhead for the checkpoint will
 // increase over time as the DB performs compactions.
@@ -195,6 +250,10 @@ func (d *DB) Checkpoint(
 	for diskFileNum := range d.mu.versions.fileBackingMap {
 		virtualBackingFiles[diskFileNum] = struct{}{}
 	}
+	blobFiles := make(map[base.DiskFileNum]manifest.BlobFileMetadata)
+	for _, meta := range d.mu.versions.blobFileMetadataLocked() {
+		blobFiles[meta.FileNum] = meta
+	}
 	// Release the manifest and DB.mu so we don't block other operations on
 	// the database.
 	d.mu.versions.logUnlock()
@@ -223,43 +282,24 @@ func (d *DB) Checkpoint(
 		return ckErr
 	}
 
-	{
-		// Link or copy the OPTIONS.
-		srcPath := base.MakeFilepath(fs, d.dirname, fileTypeOptions, optionsFileNum)
-		destPath := fs.PathJoin(destDir, fs.PathBase(srcPath))
-		ckErr = vfs.LinkOrCopy(fs, srcPath, destPath)
-		if ckErr != nil {
-			return ckErr
-		}
-	}
-
-	{
-		// Set the format major version in the destination directory.
-		var versionMarker *atomicfs.Marker
-		versionMarker, _, ckErr = atomicfs.LocateMarker(fs, destDir, formatVersionMarkerName)
-		if ckErr != nil {
-			return ckErr
-		}
-
-		// We use the marker to encode the active format version in the
-		// marker filename. Unlike other uses of the atomic marker,
-		// there is no file with the filename `formatVers.String()` on
-		// the filesystem.
-		ckErr = versionMarker.Move(formatVers.String())
-		if ckErr != nil {
-			return ckErr
-		}
-		ckErr = versionMarker.Close()
-		if ckErr != nil {
-			return ckErr
-		}
+	// Collect the files of the checkpoint, so that the progress can be
+	// reported relative to their total size.
+	c := newCheckpointCopier(fs, opt)
+	optionsPath := base.MakeFilepath(fs, d.dirname, fileTypeOptions, optionsFileNum)
+	optionsSize, ckErr := c.statFile(optionsPath)
+	if ckErr != nil {
+		return ckErr
 	}
-
+	c.addFile(optionsSize)
 	var excludedFiles map[deletedFileEntry]*fileMetadata
 	// Set of FileBacking.DiskFileNum which will be required by virtual sstables
 	// in the checkpoint.
 	requiredVirtualBackingFiles := make(map[base.DiskFileNum]struct{})
-	// Link or copy the sstables.
+	type checkpointTable struct {
+		path string
+		size int64
+	}
+	var tables []checkpointTable
 	for l := range current.Levels {
 		iter := current.Levels[l].Iter()
 		for f := iter.First(); f != nil; f = iter.Next() {
@@ -283,13 +323,83 @@ func (d *DB) Checkpoint(
 			}
 
 			srcPath := base.MakeFilepath(fs, d.dirname, fileTypeTable, fileBacking.DiskFileNum)
-			destPath := fs.PathJoin(destDir, fs.PathBase(srcPath))
-			ckErr = vfs.LinkOrCopy(fs, srcPath, destPath)
-			if ckErr != nil {
-				return ckErr
+			if objMeta, err := d.objProvider.Lookup(fileTypeTable, fileBacking.DiskFileNum); err == nil && !objMeta.IsRemote() {
+				// The sstable may be in the directory of its level (see
+				// LevelOptions.Dir).
+				srcPath = d.objProvider.Path(objMeta)
+			}
+			tables = append(tables, checkpointTable{path: srcPath, size: int64(fileBacking.Size)})
+			c.addFile(int64(fileBacking.Size))
+
+			// Include the blob files holding the values of the sstable.
+			for _, ref := range f.BlobReferences {
+				meta, ok := blobFiles[ref.FileNum]
+				if !ok {
+					continue
+				}
+				delete(blobFiles, ref.FileNum)
+				srcPath := base.MakeFilepath(fs, d.dirname, fileTypeBlob, ref.FileNum)
+				if objMeta, err := d.objProvider.Lookup(fileTypeBlob, ref.FileNum); err == nil {
+					srcPath = d.objProvider.Path(objMeta)
+				}
+				tables = append(tables, checkpointTable{path: srcPath, size: int64(meta.Size)})
+				c.addFile(int64(meta.Size))
 			}
 		}
 	}
+	c.addFile(manifestSize)
+	var walPaths []string
+	var walSizes []int64
+	for i := range memQueue {
+		logNum := memQueue[i].logNum
+		if logNum == 0 {
+			continue
+		}
+		srcPath := base.MakeFilepath(fs, d.walDirname, fileTypeLog, logNum.DiskFileNum())
+		size, err := c.statFile(srcPath)
+		if err != nil {
+			return err
+		}
+		walPaths = append(walPaths, srcPath)
+		walSizes = append(walSizes, size)
+		c.addFile(size)
+	}
+
+	// Link or copy the OPTIONS.
+	ckErr = c.linkOrCopy(optionsPath, fs.PathJoin(destDir, fs.PathBase(optionsPath)), optionsSize)
+	if ckErr != nil {
+		return ckErr
+	}
+
+	{
+		// Set the format major version in the destination directory.
+		var versionMarker *atomicfs.Marker
+		versionMarker, _, ckErr = atomicfs.LocateMarker(fs, destDir, formatVersionMarkerName)
+		if ckErr != nil {
+			return ckErr
+		}
+
+		// We use the marker to encode the active format version in the
+		// marker filename. Unlike other uses of the atomic marker,
+		// there is no file with the filename `formatVers.String()` on
+		// the filesystem.
+		ckErr = versionMarker.Move(formatVers.String())
+		if ckErr != nil {
+			return ckErr
+		}
+		ckErr = versionMarker.Close()
+		if ckErr != nil {
+			return ckErr
+		}
+	}
+
+	// Link or copy the sstables.
+	for _, t := range tables {
+		ckErr = c.linkOrCopy(t.path, fs.PathJoin(destDir, fs.PathBase(t.path)), t.size)
+		if ckErr != nil {
+			return ckErr
+		}
+	}
 
 	var removeBackingTables []base.DiskFileNum
 	for diskFileNum := range virtualBackingFiles {
@@ -300,6 +410,9 @@ func (d *DB) Checkpoint(
 		}
 	}
 
+	if ckErr = c.err(); ckErr != nil {
+		return ckErr
+	}
 	ckErr = d.writeCheckpointManifest(
 		fs, formatVers, destDir, dir, manifestFileNum.DiskFileNum(), manifestSize,
 		excludedFiles, removeBackingTables,
@@ -307,18 +420,14 @@ func (d *DB) Checkpoint(
 	if ckErr != nil {
 		return ckErr
 	}
+	c.progress.CopiedBytes += manifestSize
+	c.fileDone(manifestSize)
 
 	// Copy the WAL files. We copy rather than link because WAL file recycling
 	// will cause the WAL files to be reused which would invalidate the
 	// checkpoint.
-	for i := range memQueue {
-		logNum := memQueue[i].logNum
-		if logNum == 0 {
-			continue
-		}
-		srcPath := base.MakeFilepath(fs, d.walDirname, fileTypeLog, logNum.DiskFileNum())
-		destPath := fs.PathJoin(destDir, fs.PathBase(srcPath))
-		ckErr = vfs.Copy(fs, srcPath, destPath)
+	for i, srcPath := range walPaths {
+		ckErr = c.copy(srcPath, fs.PathJoin(destDir, fs.PathBase(srcPath)), walSizes[i])
 		if ckErr != nil {
 			return ckErr
 		}
@@ -352,80 +461,270 @@ func (d *DB) writeCheckpointManifest(
 	// copy.
 	// If some files are excluded from the checkpoint, also append a block that
 	// records those files as deleted.
-	if err := func() error {
-		srcPath := base.MakeFilepath(fs, d.dirname, fileTypeManifest, manifestFileNum)
-		destPath := fs.PathJoin(destDirPath, fs.PathBase(srcPath))
-		src, err := fs.Open(srcPath, vfs.SequentialReadsOption)
+	srcPath := base.MakeFilepath(fs, d.dirname, fileTypeManifest, manifestFileNum)
+	destPath := fs.PathJoin(destDirPath, fs.PathBase(srcPath))
+	err := copyManifest(fs, srcPath, destPath, manifestFileNum, manifestSize, excludedFiles, removeBackingTables)
+	if err != nil {
+		return err
+	}
+
+	// Recent format versions use an atomic marker for setting the
+	// active manifest. Older versions use the CURRENT file. The
+	// setCurrentFunc function will return a closure that will
+	// take the appropriate action for the database's format
+	// version.
+	var manifestMarker *atomicfs.Marker
+	manifestMarker, _, err = atomicfs.LocateMarker(fs, destDirPath, manifestMarkerName)
+	if err != nil {
+		return err
+	}
+	if err := setCurrentFunc(formatVers, manifestMarker, fs, destDirPath, destDir)(manifestFileNum.FileNum()); err != nil {
+		return err
+	}
+	return manifestMarker.Close()
+}
+
+// copyManifest copies the first size bytes of the MANIFEST at srcPath to
+// destPath. If excludedFiles is set, a version edit that removes them, along
+// with removeBackingTables, is appended to the copy.
+func copyManifest(
+	fs vfs.FS,
+	srcPath, destPath string,
+	manifestFileNum base.DiskFileNum,
+	manifestSize int64,
+	excludedFiles map[deletedFileEntry]*fileMetadata,
+	removeBackingTables []base.DiskFileNum,
+) error {
+	src, err := fs.Open(srcPath, vfs.SequentialReadsOption)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := fs.Create(destPath)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	// Copy all existing records. We need to copy at the record level in case we
+	// need to append another record with the excluded files (we cannot simply
+	// append a record after a raw data copy; see
+	// https://github.com/cockroachdb/cockroach/issues/100935).
+	r := record.NewReader(&io.LimitedReader{R: src, N: manifestSize}, manifestFileNum.FileNum())
+	w := record.NewWriter(dst)
+	for {
+		rr, err := r.Next()
 		if err != nil {
+			if err == io.EOF {
+				break
+			}
 			return err
 		}
-		defer src.Close()
 
-		dst, err := fs.Create(destPath)
+		rw, err := w.Next()
 		if err != nil {
 			return err
 		}
-		defer dst.Close()
-
-		// Copy all existing records. We need to copy at the record level in case we
-		// need to append another record with the excluded files (we cannot simply
-		// append a record after a raw data copy; see
-		// https://github.com/cockroachdb/cockroach/issues/100935).
-		r := record.NewReader(&io.LimitedReader{R: src, N: manifestSize}, manifestFileNum.FileNum())
-		w := record.NewWriter(dst)
-		for {
-			rr, err := r.Next()
-			if err != nil {
-				if err == io.EOF {
-					break
-				}
-				return err
-			}
-
-			rw, err := w.Next()
-			if err != nil {
-				return err
-			}
-			if _, err := io.Copy(rw, rr); err != nil {
-				return err
-			}
+		if _, err := io.Copy(rw, rr); err != nil {
+			return err
 		}
+	}
 
-		if len(excludedFiles) > 0 {
-			// Write out an additional VersionEdit that deletes the excluded SST files.
-			ve := versionEdit{
-				DeletedFiles:         excludedFiles,
-				RemovedBackingTables: removeBackingTables,
-			}
+	if len(excludedFiles) > 0 {
+		// Write out an additional VersionEdit that deletes the excluded SST files.
+		ve := versionEdit{
+			DeletedFiles:         excludedFiles,
+			RemovedBackingTables: removeBackingTables,
+		}
 
-			rw, err := w.Next()
-			if err != nil {
-				return err
-			}
-			if err := ve.Encode(rw); err != nil {
-				return err
-			}
+		rw, err := w.Next()
+		if err != nil {
+			return err
 		}
-		if err := w.Close(); err != nil {
+		if err := ve.Encode(rw); err != nil {
 			return err
 		}
-		return dst.Sync()
-	}(); err != nil {
+	}
+	if err := w.Close(); err != nil {
 		return err
 	}
+	return dst.Sync()
+}
 
-	// Recent format versions use an atomic marker for setting the
-	// active manifest. Older versions use the CURRENT file. The
-	// setCurrentFunc function will return a closure that will
-	// take the appropriate action for the database's format
-	// version.
-	var manifestMarker *atomicfs.Marker
-	manifestMarker, _, err := atomicfs.LocateMarker(fs, destDirPath, manifestMarkerName)
+// checkpointCopyChunkSize bounds the size of the chunks in which files are
+// copied into a checkpoint, between which the rate limit is applied, the
+// progress is reported and the cancelation is checked.
+const checkpointCopyChunkSize = 1 << 20 /* 1MB */
+
+// checkpointCopier links or copies the files of a checkpoint, applying the
+// rate limit, the progress reporting and the cancelation of the checkpoint
+// options.
+type checkpointCopier struct {
+	fs      vfs.FS
+	opt     *checkpointOptions
+	limiter *rate.Limiter
+	buf     []byte
+
+	progress CheckpointProgress
+}
+
+func newCheckpointCopier(fs vfs.FS, opt *checkpointOptions) *checkpointCopier {
+	c := &checkpointCopier{fs: fs, opt: opt}
+	chunkSize := int64(checkpointCopyChunkSize)
+	if opt.copyBytesPerSec > 0 {
+		// Copy in chunks of a quarter of a second's worth of bytes, so that the
+		// limit is applied smoothly.
+		if chunkSize > opt.copyBytesPerSec/4 {
+			chunkSize = opt.copyBytesPerSec / 4
+		}
+		if chunkSize < 4<<10 {
+			chunkSize = 4 << 10
+		}
+		c.limiter = rate.NewLimiter(float64(opt.copyBytesPerSec), float64(chunkSize))
+	}
+	c.buf = make([]byte, chunkSize)
+	return c
+}
+
+// err returns the error of the context of the checkpoint, if it's done.
+func (c *checkpointCopier) err() error {
+	if c.opt.ctx == nil {
+		return nil
+	}
+	return c.opt.ctx.Err()
+}
+
+func (c *checkpointCopier) statFile(path string) (int64, error) {
+	info, err := c.fs.Stat(path)
 	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// addFile adds a file of the specified size to the files of the checkpoint.
+func (c *checkpointCopier) addFile(size int64) {
+	c.progress.TotalFiles++
+	c.progress.TotalBytes += size
+}
+
+// fileDone records that a file of the specified size has been linked or
+// copied, and reports the progress.
+func (c *checkpointCopier) fileDone(size int64) {
+	c.progress.Files++
+	c.progress.Bytes += size
+	c.report()
+}
+
+func (c *checkpointCopier) report() {
+	if c.opt.progress != nil {
+		c.opt.progress(c.progress)
+	}
+}
+
+// linkOrCopy creates dst as a hard link to src, falling back to copying src
+// if creating the link fails for a reason copying may fix, such as dst being
+// on another filesystem (see vfs.LinkOrCopy). size is the size of src.
+func (c *checkpointCopier) linkOrCopy(src, dst string, size int64) error {
+	if err := c.err(); err != nil {
 		return err
 	}
-	if err := setCurrentFunc(formatVers, manifestMarker, fs, destDirPath, destDir)(manifestFileNum.FileNum()); err != nil {
+	err := c.fs.Link(src, dst)
+	if err == nil {
+		c.fileDone(size)
+		return nil
+	}
+	if oserror.IsExist(err) || oserror.IsNotExist(err) || oserror.IsPermission(err) {
 		return err
 	}
-	return manifestMarker.Close()
+	return c.copy(src, dst, size)
+}
+
+// copy copies src to dst. size is the size of src accounted for in the total
+// size of the checkpoint, which is corrected if src turns out to be larger (as
+// a WAL may be) or smaller.
+func (c *checkpointCopier) copy(src, dst string, size int64) error {
+	_, err := c.copyWithChecksum(src, dst, size)
+	return err
+}
+
+// copyWithChecksum is like copy, and also returns the CRC-32C (Castagnoli)
+// checksum of the copied data.
+func (c *checkpointCopier) copyWithChecksum(src, dst string, size int64) (uint32, error) {
+	if err := c.err(); err != nil {
+		return 0, err
+	}
+	in, err := c.fs.Open(src, vfs.SequentialReadsOption)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	out, err := c.fs.Create(dst)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+
+	sum, err := c.copyData(in, func(p []byte) error {
+		_, err := out.Write(p)
+		return err
+	}, size)
+	if err != nil {
+		return 0, err
+	}
+	if err := out.Sync(); err != nil {
+		return 0, err
+	}
+	c.fileDone(0)
+	return sum, nil
+}
+
+// copyData passes the data read from in to write in chunks, applying the rate
+// limit, reporting the progress and checking for cancelation between chunks,
+// and returns the CRC-32C checksum of the data. size is the expected size of
+// the data, as for copy.
+func (c *checkpointCopier) copyData(
+	in io.Reader, write func([]byte) error, size int64,
+) (uint32, error) {
+	// Bytes of the file are accounted for in Bytes as they're copied, and
+	// only the file count is left for fileDone.
+	var copied int64
+	var sum uint32
+	for {
+		n, readErr := io.ReadFull(in, c.buf)
+		if n > 0 {
+			if c.limiter != nil {
+				c.limiter.Wait(float64(n))
+			}
+			// Compute the checksum first, as write may modify the buffer (see
+			// objstorage.Writable).
+			sum = crc32.Update(sum, castagnoliTable, c.buf[:n])
+			if err := write(c.buf[:n]); err != nil {
+				return 0, err
+			}
+			copied += int64(n)
+			c.progress.Bytes += int64(n)
+			c.progress.CopiedBytes += int64(n)
+			if copied > size {
+				c.progress.TotalBytes += copied - size
+				size = copied
+			}
+			if readErr == nil {
+				c.report()
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return 0, readErr
+		}
+		if err := c.err(); err != nil {
+			return 0, err
+		}
+	}
+	c.progress.TotalBytes -= size - copied
+	return sum, nil
 }
@@ -5,15 +5,19 @@
 package pebble
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"math/rand"
+	"os"
 	"sort"
 	"strings"
 	"sync"
 	"testing"
 
 	"github.com/cockroachdb/datadriven"
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/errors/oserror"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
@@ -348,3 +352,71 @@ func TestCheckpointManyFiles(t *testing.T) {
 		require.Equal(t, 10, n)
 	}
 }
+
+// noLinkFS is a vfs.FS on which hard links fail, as they do across
+// filesystems.
+type noLinkFS struct {
+	vfs.FS
+}
+
+func (fs noLinkFS) Link(oldname, newname string) error {
+	return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: errors.New("cross-device link")}
+}
+
+func TestCheckpointCopyFallback(t *testing.T) {
+	fs := noLinkFS{FS: vfs.NewMem()}
+	d, err := Open("db", &Options{FS: fs, Logger: testLogger{t: t}})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 100; j++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("key%d-%03d", i, j)), bytes.Repeat([]byte("v"), 1000), nil))
+		}
+		if i < 2 {
+			require.NoError(t, d.Flush())
+		}
+	}
+
+	// The files are copied, and the progress is reported.
+	var progress []CheckpointProgress
+	require.NoError(t, d.Checkpoint("checkpoint",
+		WithCopyRateLimit(1<<30),
+		WithProgress(func(p CheckpointProgress) { progress = append(progress, p) }),
+	))
+	require.NotEmpty(t, progress)
+	for i := 1; i < len(progress); i++ {
+		require.GreaterOrEqual(t, progress[i].Files, progress[i-1].Files)
+		require.Greater(t, progress[i].Bytes, progress[i-1].Bytes)
+	}
+	last := progress[len(progress)-1]
+	// OPTIONS, 2 sstables, MANIFEST and WAL.
+	require.Equal(t, 5, last.TotalFiles)
+	require.Equal(t, last.TotalFiles, last.Files)
+	require.Equal(t, last.TotalBytes, last.Bytes)
+	require.Equal(t, last.TotalBytes, last.CopiedBytes)
+
+	d2, err := Open("checkpoint", &Options{FS: fs, Logger: testLogger{t: t}})
+	require.NoError(t, err)
+	iter, _ := d2.NewIter(nil)
+	n := 0
+	for valid := iter.First(); valid; valid = iter.Next() {
+		n++
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, 300, n)
+	require.NoError(t, d2.Close())
+
+	// A canceled checkpoint is removed.
+	ctx, cancel := context.WithCancel(context.Background())
+	err = d.Checkpoint("canceled",
+		WithContext(ctx),
+		WithProgress(func(p CheckpointProgress) {
+			if p.Files == 2 {
+				cancel()
+			}
+		}),
+	)
+	require.True(t, errors.Is(err, context.Canceled))
+	_, err = fs.Stat("canceled")
+	require.True(t, oserror.IsNotExist(err))
+}
@@ -8,12 +8,16 @@ import (
 	"context"
 	"runtime/pprof"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/errors/oserror"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/invariants"
+	"github.com/cockroachdb/pebble/internal/rate"
 	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/vfs"
 	"github.com/cockroachdb/tokenbucket"
 )
 
@@ -30,7 +34,16 @@ type cleanupManager struct {
 	opts            *Options
 	objProvider     objstorage.Provider
 	onTableDeleteFn func(fileSize uint64)
-	deletePacer     *deletionPacer
+	// onWALDeleteFn is invoked once an obsolete WAL file is deleted, or has
+	// failed to be archived.
+	onWALDeleteFn func(fileNum base.DiskFileNum)
+	deletePacer   *deletionPacer
+	// holePunchLimiter paces the deallocation of obsolete sstables by hole
+	// punching, or is nil if Options.HolePunchDeletion is not enabled.
+	holePunchLimiter *rate.Limiter
+	// closing is set when the manager is closed, upon which hole punching is
+	// abandoned.
+	closing atomic.Bool
 
 	// jobsCh is used as the cleanup job queue.
 	jobsCh chan *cleanupJob
@@ -69,15 +82,20 @@ func openCleanupManager(
 	opts *Options,
 	objProvider objstorage.Provider,
 	onTableDeleteFn func(fileSize uint64),
+	onWALDeleteFn func(fileNum base.DiskFileNum),
 	getDeletePacerInfo func() deletionPacerInfo,
 ) *cleanupManager {
 	cm := &cleanupManager{
 		opts:            opts,
 		objProvider:     objProvider,
 		onTableDeleteFn: onTableDeleteFn,
+		onWALDeleteFn:   onWALDeleteFn,
 		deletePacer:     newDeletionPacer(time.Now(), int64(opts.TargetByteDeletionRate), getDeletePacerInfo),
 		jobsCh:          make(chan *cleanupJob, jobsQueueDepth),
 	}
+	if bytesPerSec := opts.HolePunchDeletion.BytesPerSec; bytesPerSec > 0 {
+		cm.holePunchLimiter = rate.NewLimiter(float64(bytesPerSec), float64(holePunchChunkSize(bytesPerSec)))
+	}
 	cm.mu.completedJobsCond.L = &cm.mu.Mutex
 	cm.waitGroup.Add(1)
 
@@ -93,6 +111,7 @@ func openCleanupManager(
 // Close stops the background goroutine, waiting until all queued jobs are completed.
 // Delete pacing is disabled for the remaining jobs.
 func (cm *cleanupManager) Close() {
+	cm.closing.Store(true)
 	close(cm.jobsCh)
 	cm.waitGroup.Wait()
 }
@@ -154,13 +173,19 @@ func (cm *cleanupManager) mainLoop() {
 	tb.Init(1.0, 1.0)
 	for job := range cm.jobsCh {
 		for _, of := range job.obsoleteFiles {
-			if of.fileType != fileTypeTable {
-				path := base.MakeFilepath(cm.opts.FS, of.dir, of.fileType, of.fileNum)
-				cm.deleteObsoleteFile(of.fileType, job.jobID, path, of.fileNum, of.fileSize)
-			} else {
+			switch of.fileType {
+			case fileTypeTable:
 				cm.maybePace(&tb, of.fileType, of.fileNum, of.fileSize)
 				cm.onTableDeleteFn(of.fileSize)
-				cm.deleteObsoleteObject(fileTypeTable, job.jobID, of.fileNum)
+				cm.deleteObsoleteObject(fileTypeTable, job.jobID, of.fileNum, of.fileSize)
+			case fileTypeBlob:
+				cm.deleteObsoleteObject(fileTypeBlob, job.jobID, of.fileNum, of.fileSize)
+			default:
+				path := base.MakeFilepath(cm.opts.FS, of.dir, of.fileType, of.fileNum)
+				cm.deleteObsoleteFile(of.fileType, job.jobID, path, of.fileNum, of.fileSize)
+				if of.fileType == fileTypeLog {
+					cm.onWALDeleteFn(of.fileNum)
+				}
 			}
 		}
 		cm.mu.Lock()
@@ -216,6 +241,12 @@ func (cm *cleanupManager) maybePace(
 func (cm *cleanupManager) deleteObsoleteFile(
 	fileType fileType, jobID int, path string, fileNum base.DiskFileNum, fileSize uint64,
 ) {
+	if fileType == fileTypeLog && cm.opts.WALArchive.enabled() {
+		if err := cm.archiveWAL(jobID, path, fileNum, fileSize); err != nil {
+			cm.opts.Logger.Infof("WAL archive: failed to archive %s: %s", path, err)
+			return
+		}
+	}
 	// TODO(peter): need to handle this error, probably by re-adding the
 	// file that couldn't be deleted to one of the obsolete slices map.
 	err := cm.opts.Cleaner.Clean(cm.opts.FS, fileType, path)
@@ -238,15 +269,15 @@ func (cm *cleanupManager) deleteObsoleteFile(
 			FileNum: fileNum.FileNum(),
 			Err:     err,
 		})
-	case fileTypeTable:
+	case fileTypeTable, fileTypeBlob:
 		panic("invalid deletion of object file")
 	}
 }
 
 func (cm *cleanupManager) deleteObsoleteObject(
-	fileType fileType, jobID int, fileNum base.DiskFileNum,
+	fileType fileType, jobID int, fileNum base.DiskFileNum, fileSize uint64,
 ) {
-	if fileType != fileTypeTable {
+	if fileType != fileTypeTable && fileType != fileTypeBlob {
 		panic("not an object")
 	}
 
@@ -256,6 +287,9 @@ func (cm *cleanupManager) deleteObsoleteObject(
 		path = "<nil>"
 	} else {
 		path = cm.objProvider.Path(meta)
+		if !meta.IsRemote() {
+			cm.maybePunchHoles(path, fileSize)
+		}
 		err = cm.objProvider.Remove(fileType, fileNum)
 	}
 	if cm.objProvider.IsNotExistError(err) {
@@ -273,6 +307,63 @@ func (cm *cleanupManager) deleteObsoleteObject(
 	}
 }
 
+// holePunchChunkSize returns the number of bytes deallocated at a time when
+// deallocating an obsolete sstable at the given rate, so that each chunk takes
+// at most 1/4s.
+func holePunchChunkSize(bytesPerSec int64) int64 {
+	const minChunkSize, maxChunkSize = 64 << 10, 4 << 20
+	n := bytesPerSec / 4
+	if n < minChunkSize {
+		n = minChunkSize
+	} else if n > maxChunkSize {
+		n = maxChunkSize
+	}
+	return n
+}
+
+// maybePunchHoles deallocates the local sstable at path incrementally, at the
+// rate of Options.HolePunchDeletion.BytesPerSec, before it is unlinked. See
+// Options.HolePunchDeletion. It is always called from the background
+// goroutine.
+func (cm *cleanupManager) maybePunchHoles(path string, fileSize uint64) {
+	if cm.holePunchLimiter == nil || int64(fileSize) < cm.opts.HolePunchDeletion.MinFileSize {
+		return
+	}
+	// The ArchiveCleaner and custom cleaners may keep the file.
+	if _, ok := cm.opts.Cleaner.(DeleteCleaner); !ok {
+		return
+	}
+	// Punching holes would corrupt the other hard links of the file.
+	info, err := cm.opts.FS.Stat(path)
+	if err != nil {
+		return
+	}
+	if links, ok := vfs.LinkCount(info); !ok || links != 1 {
+		return
+	}
+	f, err := cm.opts.FS.OpenReadWrite(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	size := info.Size()
+	chunkSize := holePunchChunkSize(cm.opts.HolePunchDeletion.BytesPerSec)
+	for off := int64(0); off < size && !cm.closing.Load(); off += chunkSize {
+		n := chunkSize
+		if n > size-off {
+			n = size - off
+		}
+		cm.holePunchLimiter.Wait(float64(n))
+		if err := vfs.PunchHole(f, off, n); err != nil {
+			if !errors.Is(err, vfs.ErrUnsupported) {
+				cm.opts.Logger.Infof("hole punching %s failed: %s", path, err)
+			}
+			return
+		}
+	}
+}
+
 // maybeLogLocked issues a log if the job queue gets 75% full and issues a log
 // when the job queue gets back to less than 10% full.
 //
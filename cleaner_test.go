@@ -5,13 +5,17 @@
 package pebble
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"sort"
 	"strings"
 	"testing"
 
 	"github.com/cockroachdb/datadriven"
+	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/rate"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
 )
@@ -135,3 +139,66 @@ func TestCleaner(t *testing.T) {
 		}
 	})
 }
+
+func TestCleanerPunchHoles(t *testing.T) {
+	fs := vfs.Default
+	dir := t.TempDir()
+	opts := (&Options{FS: fs}).EnsureDefaults()
+	opts.HolePunchDeletion.MinFileSize = 1 << 10
+	opts.HolePunchDeletion.BytesPerSec = 1 << 30
+	cm := &cleanupManager{opts: opts}
+	cm.holePunchLimiter = rate.NewLimiter(float64(opts.HolePunchDeletion.BytesPerSec),
+		float64(holePunchChunkSize(opts.HolePunchDeletion.BytesPerSec)))
+
+	const size = 10<<20 + 123
+	data := bytes.Repeat([]byte("x"), size)
+	writeFile := func(name string) string {
+		path := fs.PathJoin(dir, name)
+		f, err := fs.Create(path)
+		require.NoError(t, err)
+		_, err = f.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, f.Sync())
+		require.NoError(t, f.Close())
+		return path
+	}
+	readFile := func(path string) []byte {
+		f, err := fs.Open(path)
+		require.NoError(t, err)
+		defer f.Close()
+		b, err := io.ReadAll(f)
+		require.NoError(t, err)
+		return b
+	}
+
+	// The file is deallocated, and its size is unchanged.
+	path := writeFile("000001.sst")
+	f, err := fs.OpenReadWrite(path)
+	require.NoError(t, err)
+	err = vfs.PunchHole(f, 0, 1)
+	require.NoError(t, f.Close())
+	if errors.Is(err, vfs.ErrUnsupported) {
+		t.Skip("hole punching not supported")
+	}
+	require.NoError(t, err)
+	cm.maybePunchHoles(path, size)
+	require.Equal(t, make([]byte, size), readFile(path))
+
+	// A file with another hard link is left intact.
+	path = writeFile("000002.sst")
+	require.NoError(t, fs.Link(path, fs.PathJoin(dir, "checkpoint.sst")))
+	cm.maybePunchHoles(path, size)
+	require.Equal(t, data, readFile(path))
+
+	// A file smaller than MinFileSize is left intact.
+	path = writeFile("000003.sst")
+	opts.HolePunchDeletion.MinFileSize = 2 * size
+	cm.maybePunchHoles(path, size)
+	require.Equal(t, data, readFile(path))
+
+	// A file that may be archived is left intact.
+	opts.HolePunchDeletion.MinFileSize = 0
+	opts.Cleaner = ArchiveCleaner{}
+	cm.maybePunchHoles(path, size)
+	require.Equal(t, data, readFile(path))
+}
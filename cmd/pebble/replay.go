@@ -25,6 +25,7 @@ import (
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/cache"
 	"github.com/cockroachdb/pebble/replay"
+	"github.com/cockroachdb/pebble/ribbon"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/spf13/cobra"
 )
@@ -309,6 +310,8 @@ func (c *replayConfig) parseHooks() *pebble.ParseHooks {
 				return nil, nil
 			case "rocksdb.BuiltinBloomFilter":
 				return bloom.FilterPolicy(10), nil
+			case "pebble.RibbonFilter":
+				return ribbon.FilterPolicy(10), nil
 			default:
 				return nil, errors.Errorf("invalid filter policy name %q", name)
 			}
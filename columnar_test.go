@@ -0,0 +1,60 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestColumnarSchemaOption(t *testing.T) {
+	opts := &Options{FS: vfs.NewMem()}
+	opts.Experimental.ColumnarSchema = &ColumnarSchema{
+		Name: "test-row",
+		Columns: []sstable.ColumnSpec{
+			{Width: 8, Encoding: sstable.ColumnEncodingDelta},
+			{Width: 2, Encoding: sstable.ColumnEncodingDictionary},
+		},
+	}
+	// Columnar data blocks require ExperimentalFormatBlockExtensions.
+	_, err := Open("", opts)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "ColumnarSchema requires FormatMajorVersion")
+	opts.FormatMajorVersion = ExperimentalFormatBlockExtensions
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	value := func(i int) []byte {
+		v := binary.BigEndian.AppendUint64(nil, uint64(1000+i))
+		return append(v, []string{"ab", "cd"}[i%2]...)
+	}
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("key%06d", i)), value(i), nil))
+	}
+	require.NoError(t, d.Set([]byte("key999999"), []byte("non-conforming"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Close())
+
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	iter, _ := d.NewIter(nil)
+	i := 0
+	for valid := iter.First(); valid; valid = iter.Next() {
+		if i == 1000 {
+			require.Equal(t, "non-conforming", string(iter.Value()))
+		} else {
+			require.Equal(t, value(i), iter.Value())
+		}
+		i++
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, 1001, i)
+	require.NoError(t, d.Close())
+}
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/cockroachdb/pebble/record"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // commitQueue is a lock-free fixed-size single-producer, multi-consumer
@@ -134,6 +135,127 @@ type commitEnv struct {
 	// the memtable the batch should be applied to. Serial execution enforced by
 	// commitPipeline.mu.
 	write func(b *Batch, wg *sync.WaitGroup, err *error) (*memTable, error)
+	// The maximum number of batches that may be committing concurrently. If
+	// zero, or larger than record.SyncConcurrency-1, it defaults to
+	// record.SyncConcurrency-1.
+	maxConcurrency int
+	// Whether to record the latencies of the stages of the commit pipeline in
+	// commitPipeline.metrics.
+	recordMetrics bool
+}
+
+// commitPipelineMetrics holds histograms of the latencies of the stages of
+// the commit pipeline, recorded in nanoseconds. Prometheus histograms are safe
+// for concurrent use, so they are updated without synchronization.
+type commitPipelineMetrics struct {
+	// enqueueLatency is the time from the start of a commit until the batch
+	// holds commitPipeline.mu, including the wait for the semaphores.
+	enqueueLatency prometheus.Histogram
+	// walWriteLatency is the time spent in commitEnv.write, including any
+	// write stall and WAL rotation.
+	walWriteLatency prometheus.Histogram
+	// applyLatency is the time spent applying a batch to the memtable.
+	applyLatency prometheus.Histogram
+	// publishLatency is the time spent publishing the visible sequence number
+	// of a batch and the batches ahead of it, excluding commitWaitLatency.
+	publishLatency prometheus.Histogram
+	// commitWaitLatency is the time spent waiting for another goroutine to
+	// publish a batch plus, for synced commits, waiting for the WAL sync.
+	commitWaitLatency prometheus.Histogram
+}
+
+func newCommitPipelineMetrics() *commitPipelineMetrics {
+	newHistogram := func() prometheus.Histogram {
+		return prometheus.NewHistogram(prometheus.HistogramOpts{Buckets: CommitLatencyBuckets})
+	}
+	return &commitPipelineMetrics{
+		enqueueLatency:    newHistogram(),
+		walWriteLatency:   newHistogram(),
+		applyLatency:      newHistogram(),
+		publishLatency:    newHistogram(),
+		commitWaitLatency: newHistogram(),
+	}
+}
+
+// commitLane classifies a batch for ordering in the commit pipeline.
+type commitLane int8
+
+const (
+	// commitLaneDefault batches neither yield to nor take precedence over other
+	// batches.
+	commitLaneDefault commitLane = iota
+	// commitLaneLatencySensitive batches take precedence over bulk batches.
+	commitLaneLatencySensitive
+	// commitLaneBulk batches do not enter the commit pipeline while a
+	// latency-sensitive batch is in progress, up to a bound.
+	commitLaneBulk
+)
+
+// maxLatencySensitiveBeforeBulk is the number of latency-sensitive batches
+// that may complete while a bulk batch waits before the bulk batch enters the
+// commit pipeline regardless. It bounds the wait of bulk batches under a
+// steady stream of latency-sensitive batches.
+const maxLatencySensitiveBeforeBulk = 16
+
+// commitLanes tracks the latency-sensitive batches in progress and holds back
+// bulk batches until there are none, or until maxLatencySensitiveBeforeBulk
+// latency-sensitive batches have completed while they wait. Bulk batches wait
+// before acquiring the commit pipeline semaphores so they do not occupy
+// capacity in the pipeline while they wait.
+//
+// A latency-sensitive batch is in progress until it has been written to the
+// WAL or, if it waits for the WAL to be synced, until the sync completes. Bulk
+// batches are therefore not written to the WAL while a latency-sensitive
+// batch waits for its sync, and do not add to the data it syncs. Bulk batches
+// that have already passed the wait are not preempted: batches are written to
+// the WAL in the order they acquire commitPipeline.mu, and a sync persists
+// every batch written before it.
+type commitLanes struct {
+	// latencySensitive is the number of latency-sensitive batches in
+	// progress. It is modified atomically so that latency-sensitive batches
+	// only acquire mu when they need to wake waiting bulk batches.
+	latencySensitive atomic.Int32
+	// completed is the number of latency-sensitive batches that have
+	// completed.
+	completed atomic.Uint64
+	// bulkWaiting is the number of bulk batches waiting on cond.
+	bulkWaiting atomic.Int32
+	mu          sync.Mutex
+	// cond is signaled when a latency-sensitive batch completes while bulk
+	// batches are waiting, and when latencySensitive drops to zero.
+	cond sync.Cond
+}
+
+// waitForLatencySensitive blocks until no latency-sensitive batches are in
+// progress, or until maxLatencySensitiveBeforeBulk latency-sensitive batches
+// have completed during the wait.
+func (l *commitLanes) waitForLatencySensitive() {
+	if l.latencySensitive.Load() == 0 {
+		return
+	}
+	l.mu.Lock()
+	l.bulkWaiting.Add(1)
+	start := l.completed.Load()
+	for l.latencySensitive.Load() > 0 && l.completed.Load()-start < maxLatencySensitiveBeforeBulk {
+		l.cond.Wait()
+	}
+	l.bulkWaiting.Add(-1)
+	l.mu.Unlock()
+}
+
+// latencySensitiveDone is called when a latency-sensitive batch is no longer
+// in progress.
+func (l *commitLanes) latencySensitiveDone() {
+	remaining := l.latencySensitive.Add(-1)
+	l.completed.Add(1)
+	if remaining == 0 || l.bulkWaiting.Load() > 0 {
+		// Acquire mu so that the broadcast cannot be lost between a bulk
+		// batch's check of latencySensitive and completed and its wait on
+		// cond.
+		l.mu.Lock()
+		l.cond.Broadcast()
+		l.mu.Unlock()
+	}
 }
 
 // A commitPipeline manages the stages of committing a set of mutations
@@ -239,12 +361,20 @@ type commitPipeline struct {
 	commitQueueSem chan struct{}
 	logSyncQSem    chan struct{}
 	ingestSem      chan struct{}
+	lanes          commitLanes
+	// metrics is nil unless commitEnv.recordMetrics is set, in which case
+	// the latencies of the pipeline stages are recorded.
+	metrics *commitPipelineMetrics
 	// The mutex to use for synchronizing access to logSeqNum and serializing
 	// calls to commitEnv.write().
 	mu sync.Mutex
 }
 
 func newCommitPipeline(env commitEnv) *commitPipeline {
+	maxConcurrency := record.SyncConcurrency - 1
+	if env.maxConcurrency > 0 && env.maxConcurrency < maxConcurrency {
+		maxConcurrency = env.maxConcurrency
+	}
 	p := &commitPipeline{
 		env: env,
 		// The capacity of both commitQueue.slots and syncQueue.slots is set to
@@ -260,11 +390,16 @@ func newCommitPipeline(env commitEnv) *commitPipeline {
 		//
 		// NB: the commit concurrency is one less than SyncConcurrency because we
 		// have to allow one "slot" for a concurrent WAL rotation which will close
-		// and sync the WAL.
-		commitQueueSem: make(chan struct{}, record.SyncConcurrency-1),
+		// and sync the WAL. The commit concurrency may be further limited by
+		// commitEnv.maxConcurrency.
+		commitQueueSem: make(chan struct{}, maxConcurrency),
 		logSyncQSem:    make(chan struct{}, record.SyncConcurrency-1),
 		ingestSem:      make(chan struct{}, 1),
 	}
+	if env.recordMetrics {
+		p.metrics = newCommitPipelineMetrics()
+	}
+	p.lanes.cond.L = &p.lanes.mu
 	return p
 }
 
@@ -288,19 +423,30 @@ func (p *commitPipeline) directWrite(b *Batch) error {
 // Commit the specified batch, writing it to the WAL, optionally syncing the
 // WAL, and applying the batch to the memtable. Upon successful return the
 // batch's mutations will be visible for reading.
+// The lane determines the batch's precedence over other batches waiting to
+// enter the pipeline.
 // REQUIRES: noSyncWait => syncWAL
-func (p *commitPipeline) Commit(b *Batch, syncWAL bool, noSyncWait bool) error {
+func (p *commitPipeline) Commit(b *Batch, syncWAL bool, noSyncWait bool, lane commitLane) error {
 	if b.Empty() {
 		return nil
 	}
 
 	commitStartTime := time.Now()
+	semaphoreStartTime := commitStartTime
+	switch lane {
+	case commitLaneLatencySensitive:
+		p.lanes.latencySensitive.Add(1)
+	case commitLaneBulk:
+		p.lanes.waitForLatencySensitive()
+		semaphoreStartTime = time.Now()
+		b.commitStats.CommitLaneWaitDuration = semaphoreStartTime.Sub(commitStartTime)
+	}
 	// Acquire semaphores.
 	p.commitQueueSem <- struct{}{}
 	if syncWAL {
 		p.logSyncQSem <- struct{}{}
 	}
-	b.commitStats.SemaphoreWaitDuration = time.Since(commitStartTime)
+	b.commitStats.SemaphoreWaitDuration = time.Since(semaphoreStartTime)
 
 	// Prepare the batch for committing: enqueuing the batch in the pending
 	// queue, determining the batch sequence number and writing the data to the
@@ -309,6 +455,12 @@ func (p *commitPipeline) Commit(b *Batch, syncWAL bool, noSyncWait bool) error {
 	// NB: We set Batch.commitErr on error so that the batch won't be a candidate
 	// for reuse. See Batch.release().
 	mem, err := p.prepare(b, syncWAL, noSyncWait)
+	// A latency-sensitive batch that waits for the WAL sync holds back bulk
+	// batches until the sync completes, which publish waits for.
+	waitForSync := lane == commitLaneLatencySensitive && syncWAL && !noSyncWait
+	if lane == commitLaneLatencySensitive && (err != nil || !waitForSync) {
+		p.lanes.latencySensitiveDone()
+	}
 	if err != nil {
 		b.db = nil // prevent batch reuse on error
 		// NB: we are not doing <-p.commitQueueSem since the batch is still
@@ -318,16 +470,29 @@ func (p *commitPipeline) Commit(b *Batch, syncWAL bool, noSyncWait bool) error {
 	}
 
 	// Apply the batch to the memtable.
+	var applyStartTime time.Time
+	if p.metrics != nil {
+		applyStartTime = time.Now()
+	}
 	if err := p.env.apply(b, mem); err != nil {
 		b.db = nil // prevent batch reuse on error
+		if waitForSync {
+			p.lanes.latencySensitiveDone()
+		}
 		// NB: we are not doing <-p.commitQueueSem since the batch is still
 		// sitting in the pending queue. We should consider fixing this by also
 		// removing the batch from the pending queue.
 		return err
 	}
+	if p.metrics != nil {
+		p.metrics.applyLatency.Observe(float64(time.Since(applyStartTime)))
+	}
 
 	// Publish the batch sequence number.
 	p.publish(b)
+	if waitForSync {
+		p.lanes.latencySensitiveDone()
+	}
 
 	<-p.commitQueueSem
 
@@ -446,7 +611,16 @@ func (p *commitPipeline) prepare(b *Batch, syncWAL bool, noSyncWait bool) (*memT
 		b.commit.Add(2)
 	}
 
-	p.mu.Lock()
+	var writeStartTime time.Time
+	if p.metrics != nil {
+		lockStartTime := time.Now()
+		p.mu.Lock()
+		writeStartTime = time.Now()
+		p.metrics.enqueueLatency.Observe(float64(b.commitStats.CommitLaneWaitDuration +
+			b.commitStats.SemaphoreWaitDuration + writeStartTime.Sub(lockStartTime)))
+	} else {
+		p.mu.Lock()
+	}
 
 	// Enqueue the batch in the pending queue. Note that while the pending queue
 	// is lock-free, we want the order of batches to be the same as the sequence
@@ -462,11 +636,18 @@ func (p *commitPipeline) prepare(b *Batch, syncWAL bool, noSyncWait bool) (*memT
 	mem, err := p.env.write(b, syncWG, syncErr)
 
 	p.mu.Unlock()
+	if p.metrics != nil {
+		p.metrics.walWriteLatency.Observe(float64(time.Since(writeStartTime)))
+	}
 
 	return mem, err
 }
 
 func (p *commitPipeline) publish(b *Batch) {
+	var publishStartTime time.Time
+	if p.metrics != nil {
+		publishStartTime = time.Now()
+	}
 	// Mark the batch as applied.
 	b.applied.Store(true)
 
@@ -483,8 +664,15 @@ func (p *commitPipeline) publish(b *Batch) {
 			// Wait for another goroutine to publish us. We might also be waiting for
 			// the WAL sync to finish.
 			now := time.Now()
+			if p.metrics != nil {
+				p.metrics.publishLatency.Observe(float64(now.Sub(publishStartTime)))
+			}
 			b.commit.Wait()
-			b.commitStats.CommitWaitDuration += time.Since(now)
+			waitDuration := time.Since(now)
+			if p.metrics != nil {
+				p.metrics.commitWaitLatency.Observe(float64(waitDuration))
+			}
+			b.commitStats.CommitWaitDuration += waitDuration
 			break
 		}
 		if !t.applied.Load() {
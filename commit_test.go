@@ -18,6 +18,7 @@ import (
 	"github.com/cockroachdb/pebble/record"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/prometheus/client_golang/prometheus"
+	prometheusgo "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/exp/rand"
 )
@@ -102,7 +103,7 @@ func TestCommitPipeline(t *testing.T) {
 			defer wg.Done()
 			var b Batch
 			_ = b.Set([]byte(fmt.Sprint(i)), nil, nil)
-			_ = p.Commit(&b, false, false)
+			_ = p.Commit(&b, false, false, commitLaneDefault)
 		}(i)
 	}
 	wg.Wait()
@@ -122,6 +123,332 @@ func TestCommitPipeline(t *testing.T) {
 	}
 }
 
+func TestCommitPipelineMaxConcurrency(t *testing.T) {
+	var logSeqNum, visibleSeqNum atomic.Uint64
+	release := make(chan struct{})
+	p := newCommitPipeline(commitEnv{
+		logSeqNum:     &logSeqNum,
+		visibleSeqNum: &visibleSeqNum,
+		apply: func(b *Batch, mem *memTable) error {
+			return nil
+		},
+		write: func(b *Batch, wg *sync.WaitGroup, err *error) (*memTable, error) {
+			<-release
+			return nil, nil
+		},
+		maxConcurrency: 2,
+		recordMetrics:  true,
+	})
+	require.Equal(t, 2, cap(p.commitQueueSem))
+
+	// Start three commits. The first blocks writing to the WAL and the second
+	// on commitPipeline.mu, so the third must wait for a commit to finish.
+	const n = 3
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			var b Batch
+			_ = b.Set([]byte(fmt.Sprint(i)), nil, nil)
+			require.NoError(t, p.Commit(&b, false, false, commitLaneDefault))
+		}(i)
+	}
+	require.Eventually(t, func() bool {
+		return len(p.commitQueueSem) == 2
+	}, 10*time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	require.Equal(t, 2, len(p.commitQueueSem))
+	for i := 0; i < n; i++ {
+		release <- struct{}{}
+	}
+	wg.Wait()
+	require.Equal(t, 0, len(p.commitQueueSem))
+	require.Equal(t, uint64(n), visibleSeqNum.Load())
+
+	// Each commit is recorded in every stage's histogram.
+	for _, h := range []prometheus.Histogram{
+		p.metrics.enqueueLatency,
+		p.metrics.walWriteLatency,
+		p.metrics.applyLatency,
+		p.metrics.publishLatency,
+		p.metrics.commitWaitLatency,
+	} {
+		var m prometheusgo.Metric
+		require.NoError(t, h.Write(&m))
+		require.Equal(t, uint64(n), m.GetHistogram().GetSampleCount())
+	}
+
+	// A zero or too large maxConcurrency uses the default, and metrics are
+	// only recorded when requested.
+	for _, c := range []int{0, record.SyncConcurrency} {
+		p := newCommitPipeline(commitEnv{maxConcurrency: c})
+		require.Equal(t, record.SyncConcurrency-1, cap(p.commitQueueSem))
+		require.Nil(t, p.metrics)
+	}
+}
+
+func TestCommitPipelineLanes(t *testing.T) {
+	var logSeqNum, visibleSeqNum atomic.Uint64
+	var mu sync.Mutex
+	var written []string
+	release := make(chan struct{})
+	p := newCommitPipeline(commitEnv{
+		logSeqNum:     &logSeqNum,
+		visibleSeqNum: &visibleSeqNum,
+		apply: func(b *Batch, mem *memTable) error {
+			return nil
+		},
+		write: func(b *Batch, wg *sync.WaitGroup, err *error) (*memTable, error) {
+			<-release
+			mu.Lock()
+			defer mu.Unlock()
+			r := b.Reader()
+			_, ukey, _, _ := r.Next()
+			written = append(written, string(ukey))
+			return nil, nil
+		},
+	})
+
+	var wg sync.WaitGroup
+	commit := func(key string, lane commitLane) *Batch {
+		b := &Batch{}
+		require.NoError(t, b.Set([]byte(key), nil, nil))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := p.Commit(b, false, false, lane); err != nil {
+				t.Error(err)
+			}
+		}()
+		return b
+	}
+	waitForDepth := func(n int) {
+		require.Eventually(t, func() bool {
+			return len(p.commitQueueSem) == n
+		}, 10*time.Second, time.Millisecond)
+	}
+
+	// A latency-sensitive batch blocks writing to the WAL. A bulk batch must
+	// not enter the pipeline while it is waiting, but a default batch may.
+	commit("a", commitLaneLatencySensitive)
+	waitForDepth(1)
+	bulk := commit("c", commitLaneBulk)
+	commit("b", commitLaneDefault)
+	waitForDepth(2)
+	// A second latency-sensitive batch arriving while the bulk batch waits is
+	// also written ahead of it.
+	commit("d", commitLaneLatencySensitive)
+	waitForDepth(3)
+	time.Sleep(10 * time.Millisecond)
+	require.Equal(t, 3, len(p.commitQueueSem))
+
+	for i := 0; i < 4; i++ {
+		release <- struct{}{}
+	}
+	wg.Wait()
+	require.Equal(t, uint64(4), visibleSeqNum.Load())
+	require.Equal(t, 4, len(written))
+	require.Equal(t, "a", written[0])
+	indexOf := func(key string) int {
+		for i := range written {
+			if written[i] == key {
+				return i
+			}
+		}
+		t.Fatalf("%s was not written", key)
+		return -1
+	}
+	require.Greater(t, indexOf("c"), indexOf("d"))
+	require.Greater(t, bulk.CommitStats().CommitLaneWaitDuration, time.Duration(0))
+}
+
+// TestCommitPipelineLanesInFlightBulk verifies the documented limit of
+// latency-sensitive writes: a bulk batch that entered the commit pipeline
+// before a latency-sensitive batch arrived is not preempted, and the
+// latency-sensitive batch is written to the WAL after it.
+func TestCommitPipelineLanesInFlightBulk(t *testing.T) {
+	var logSeqNum, visibleSeqNum atomic.Uint64
+	var mu sync.Mutex
+	var written []string
+	writing := make(chan struct{}, 2)
+	release := make(chan struct{})
+	p := newCommitPipeline(commitEnv{
+		logSeqNum:     &logSeqNum,
+		visibleSeqNum: &visibleSeqNum,
+		apply: func(b *Batch, mem *memTable) error {
+			return nil
+		},
+		write: func(b *Batch, wg *sync.WaitGroup, err *error) (*memTable, error) {
+			writing <- struct{}{}
+			<-release
+			mu.Lock()
+			defer mu.Unlock()
+			r := b.Reader()
+			_, ukey, _, _ := r.Next()
+			written = append(written, string(ukey))
+			return nil, nil
+		},
+	})
+
+	var wg sync.WaitGroup
+	commit := func(key string, lane commitLane) {
+		b := &Batch{}
+		require.NoError(t, b.Set([]byte(key), nil, nil))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := p.Commit(b, false, false, lane); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	// The bulk batch is being written to the WAL when the latency-sensitive
+	// batch arrives. The latency-sensitive batch enters the pipeline but cannot
+	// be written until the bulk write completes.
+	commit("bulk", commitLaneBulk)
+	<-writing
+	commit("ls", commitLaneLatencySensitive)
+	require.Eventually(t, func() bool {
+		return len(p.commitQueueSem) == 2
+	}, 10*time.Second, time.Millisecond)
+	select {
+	case <-writing:
+		t.Fatal("latency-sensitive batch written while a bulk write was in progress")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	release <- struct{}{}
+	<-writing
+	release <- struct{}{}
+	wg.Wait()
+	require.Equal(t, []string{"bulk", "ls"}, written)
+}
+
+// TestCommitPipelineLanesBounded verifies that a bulk batch enters the commit
+// pipeline once maxLatencySensitiveBeforeBulk latency-sensitive batches have
+// completed, even if more latency-sensitive batches are in progress.
+func TestCommitPipelineLanesBounded(t *testing.T) {
+	var logSeqNum, visibleSeqNum atomic.Uint64
+	release := make(chan struct{})
+	p := newCommitPipeline(commitEnv{
+		logSeqNum:     &logSeqNum,
+		visibleSeqNum: &visibleSeqNum,
+		apply: func(b *Batch, mem *memTable) error {
+			return nil
+		},
+		write: func(b *Batch, wg *sync.WaitGroup, err *error) (*memTable, error) {
+			<-release
+			return nil, nil
+		},
+	})
+
+	var wg sync.WaitGroup
+	commit := func(lane commitLane) {
+		b := &Batch{}
+		require.NoError(t, b.Set([]byte("k"), nil, nil))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := p.Commit(b, false, false, lane); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	waitForDepth := func(n int) {
+		require.Eventually(t, func() bool {
+			return len(p.commitQueueSem) == n
+		}, 10*time.Second, time.Millisecond)
+	}
+
+	commit(commitLaneLatencySensitive)
+	waitForDepth(1)
+	commit(commitLaneBulk)
+	require.Eventually(t, func() bool {
+		return p.lanes.bulkWaiting.Load() == 1
+	}, 10*time.Second, time.Millisecond)
+
+	// Each latency-sensitive batch enters the pipeline before the previous
+	// one is written, so a latency-sensitive batch is always in progress.
+	for i := 0; i < maxLatencySensitiveBeforeBulk; i++ {
+		commit(commitLaneLatencySensitive)
+		waitForDepth(2)
+		require.Equal(t, int32(1), p.lanes.bulkWaiting.Load())
+		release <- struct{}{}
+		if i < maxLatencySensitiveBeforeBulk-1 {
+			waitForDepth(1)
+		}
+	}
+	// The bulk batch entered the pipeline alongside the last latency-sensitive
+	// batch.
+	waitForDepth(2)
+	require.Equal(t, int32(1), p.lanes.latencySensitive.Load())
+	require.Equal(t, int32(0), p.lanes.bulkWaiting.Load())
+
+	release <- struct{}{}
+	release <- struct{}{}
+	wg.Wait()
+	require.Equal(t, uint64(maxLatencySensitiveBeforeBulk+2), visibleSeqNum.Load())
+}
+
+// TestCommitPipelineLanesSync verifies that a bulk batch is not written to the
+// WAL while a latency-sensitive batch waits for its WAL sync, so that the sync
+// does not have to persist the bulk batch.
+func TestCommitPipelineLanesSync(t *testing.T) {
+	var logSeqNum, visibleSeqNum atomic.Uint64
+	var mu sync.Mutex
+	var written []string
+	syncs := make(chan *sync.WaitGroup, 1)
+	p := newCommitPipeline(commitEnv{
+		logSeqNum:     &logSeqNum,
+		visibleSeqNum: &visibleSeqNum,
+		apply: func(b *Batch, mem *memTable) error {
+			return nil
+		},
+		write: func(b *Batch, wg *sync.WaitGroup, err *error) (*memTable, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			r := b.Reader()
+			_, ukey, _, _ := r.Next()
+			written = append(written, string(ukey))
+			if wg != nil {
+				syncs <- wg
+			}
+			return nil, nil
+		},
+	})
+
+	var wg sync.WaitGroup
+	commit := func(key string, syncWAL bool, lane commitLane) {
+		b := &Batch{}
+		require.NoError(t, b.Set([]byte(key), nil, nil))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := p.Commit(b, syncWAL, false, lane); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	commit("ls", true, commitLaneLatencySensitive)
+	syncWG := <-syncs
+	commit("bulk", false, commitLaneBulk)
+	require.Eventually(t, func() bool {
+		return p.lanes.bulkWaiting.Load() == 1
+	}, 10*time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	mu.Lock()
+	require.Equal(t, []string{"ls"}, written)
+	mu.Unlock()
+
+	// Completing the sync lets the bulk batch proceed.
+	syncWG.Done()
+	wg.Wait()
+	require.Equal(t, []string{"ls", "bulk"}, written)
+}
+
 func TestCommitPipelineSync(t *testing.T) {
 	n := 10000
 	if invariants.RaceEnabled {
@@ -145,7 +472,7 @@ func TestCommitPipelineSync(t *testing.T) {
 					defer wg.Done()
 					var b Batch
 					require.NoError(t, b.Set([]byte(fmt.Sprint(i)), nil, nil))
-					require.NoError(t, p.Commit(&b, true, noSyncWait))
+					require.NoError(t, p.Commit(&b, true, noSyncWait, commitLaneDefault))
 					if noSyncWait {
 						require.NoError(t, b.SyncWait())
 					}
@@ -267,7 +594,7 @@ func TestCommitPipelineWALClose(t *testing.T) {
 				errCh <- err
 				return
 			}
-			errCh <- p.Commit(b, true /* sync */, false)
+			errCh <- p.Commit(b, true /* sync */, false, commitLaneDefault)
 		}(i)
 	}
 
@@ -338,7 +665,7 @@ func BenchmarkCommitPipeline(b *testing.B) {
 							batch := newBatch(nil)
 							binary.BigEndian.PutUint64(buf, rng.Uint64())
 							batch.Set(buf, buf, nil)
-							if err := p.Commit(batch, true /* sync */, noSyncWait); err != nil {
+							if err := p.Commit(batch, true /* sync */, noSyncWait, commitLaneDefault); err != nil {
 								b.Fatal(err)
 							}
 							if noSyncWait {
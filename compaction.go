@@ -10,14 +10,17 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"runtime"
 	"runtime/pprof"
 	"sort"
 	"strings"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/blob"
 	"github.com/cockroachdb/pebble/internal/keyspan"
 	"github.com/cockroachdb/pebble/internal/manifest"
 	"github.com/cockroachdb/pebble/internal/private"
@@ -458,6 +461,11 @@ const (
 	compactionKindRead
 	compactionKindRewrite
 	compactionKindIngestedFlushable
+	compactionKindTTL
+	compactionKindPeriodic
+	compactionKindTombstoneDensity
+	compactionKindTiering
+	compactionKindBlobRewrite
 )
 
 func (k compactionKind) String() string {
@@ -478,6 +486,16 @@ func (k compactionKind) String() string {
 		return "rewrite"
 	case compactionKindIngestedFlushable:
 		return "ingested-flushable"
+	case compactionKindTTL:
+		return "ttl"
+	case compactionKindPeriodic:
+		return "periodic"
+	case compactionKindTombstoneDensity:
+		return "tombstone-density"
+	case compactionKindTiering:
+		return "tiering"
+	case compactionKindBlobRewrite:
+		return "blob-rewrite"
 	}
 	return "?"
 }
@@ -570,6 +588,19 @@ type compaction struct {
 
 	score float64
 
+	// snapshotElision is true if this is an elision-only compaction scheduled
+	// from d.mu.compact.snapshotElisionQueue.
+	snapshotElision bool
+
+	// demote is true if this is a tiering compaction that creates its outputs
+	// on remote storage.
+	demote bool
+
+	// rewriteBlobFiles holds the blob files garbage collected by a blob
+	// rewrite compaction. The compaction writes the values of its inputs that
+	// are stored in these blob files to new blob files.
+	rewriteBlobFiles map[base.DiskFileNum]struct{}
+
 	// startLevel is the level that is being compacted. Inputs from startLevel
 	// and outputLevel will be merged to produce a set of outputLevel files.
 	startLevel *compactionLevel
@@ -601,6 +632,9 @@ type compaction struct {
 
 	// flushing contains the flushables (aka memtables) that are being flushed.
 	flushing flushableList
+	// flushOutputProperties holds the properties of the sstables written by a
+	// flush, keyed by file number.
+	flushOutputProperties map[base.FileNum]*sstable.Properties
 	// bytesIterated contains the number of bytes that have been flushed/compacted.
 	bytesIterated uint64
 	// bytesWritten contains the number of bytes that have been written to outputs.
@@ -730,17 +764,43 @@ func newCompaction(pc *pickedCompaction, opts *Options, beganAt time.Time) *comp
 	c.setupInuseKeyRanges()
 
 	c.kind = pc.kind
+	c.demote = pc.demote
+	c.rewriteBlobFiles = pc.rewriteBlobFiles
 	if c.kind == compactionKindDefault && c.outputLevel.files.Empty() && !c.hasExtraLevelData() &&
-		c.startLevel.files.Len() == 1 && c.grandparents.SizeSum() <= c.maxOverlapBytes {
+		c.startLevel.files.Len() == 1 && c.grandparents.SizeSum() <= c.maxOverlapBytes &&
+		opts.Level(c.startLevel.level).sameTableLayout(opts.Level(c.outputLevel.level)) &&
+		opts.preferSharedStorage(c.startLevel.level) == opts.preferSharedStorage(c.outputLevel.level) &&
+		opts.Level(c.startLevel.level).Dir == opts.Level(c.outputLevel.level).Dir {
 		// This compaction can be converted into a trivial move from one level
 		// to the next. We avoid such a move if there is lots of overlapping
 		// grandparent data. Otherwise, the move could create a parent file
-		// that will require a very expensive merge later on.
+		// that will require a very expensive merge later on. We also avoid
+		// such a move if the output level is configured with a different
+		// compression or block size, or is tiered onto shared storage or
+		// placed in a directory the start level is not, so that the file is
+		// rewritten in the output level's layout and storage.
 		c.kind = compactionKindMove
 	}
 	return c
 }
 
+// newCompactionLocked constructs a compaction from the picked compaction. A
+// compaction that would move a file without rewriting it is instead run as a
+// default compaction while a DeleteRangeIf is in progress, so that the keys
+// in the file are subject to the predicate deletion, or if all of the keys of
+// the file have expired under Options.TTL, so that they are removed rather
+// than moved.
+//
+// d.mu must be held when calling this.
+func (d *DB) newCompactionLocked(pc *pickedCompaction) *compaction {
+	c := newCompaction(pc, d.opts, d.timeNow())
+	if c.kind == compactionKindMove &&
+		(len(d.mu.compact.predicateDeletions) > 0 || d.expiredInputsLocked(c)) {
+		c.kind = compactionKindDefault
+	}
+	return c
+}
+
 func newDeleteOnlyCompaction(
 	opts *Options, cur *version, inputs []compactionLevel, beganAt time.Time,
 ) *compaction {
@@ -870,6 +930,20 @@ func newFlush(
 		}
 	}
 
+	// The keys of the spans already flushed out of the flushables must not be
+	// flushed again.
+	if len(cur.FlushedSpans) > 0 {
+		c.flushing = make(flushableList, len(flushing))
+		for i, f := range flushing {
+			c.flushing[i] = &flushableEntry{
+				flushable: flushableView(c.cmp, cur, f),
+				logNum:    f.logNum,
+				logSeqNum: f.logSeqNum,
+			}
+		}
+		flushing = c.flushing
+	}
+
 	if cur.L0Sublevels != nil {
 		c.l0Limits = cur.L0Sublevels.FlushSplitKeys()
 	}
@@ -1592,6 +1666,9 @@ type manualCompaction struct {
 	start       []byte
 	end         []byte
 	split       bool
+	priority    ManualCompactionPriority
+	// c is the compaction once it has been scheduled.
+	c *compaction
 }
 
 type readCompaction struct {
@@ -1701,6 +1778,7 @@ func (d *DB) clearCompactingState(c *compaction, rollback bool) {
 func (d *DB) calculateDiskAvailableBytes() uint64 {
 	if space, err := d.opts.FS.GetDiskUsage(d.dirname); err == nil {
 		d.diskAvailBytes.Store(space.AvailBytes)
+		d.updateDiskSpaceState(space.AvailBytes)
 		return space.AvailBytes
 	} else if !errors.Is(err, vfs.ErrUnsupported) {
 		d.opts.EventListener.BackgroundError(err)
@@ -1734,11 +1812,23 @@ func (d *DB) onObsoleteTableDelete(fileSize uint64) {
 	d.mu.Unlock()
 }
 
+func (d *DB) onObsoleteWALDelete(fileNum base.DiskFileNum) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, fi := range d.mu.log.archiving {
+		if fi.fileNum == fileNum {
+			d.mu.log.archiving = append(d.mu.log.archiving[:i], d.mu.log.archiving[i+1:]...)
+			return
+		}
+	}
+}
+
 // maybeScheduleFlush schedules a flush if necessary.
 //
 // d.mu must be held when calling this.
 func (d *DB) maybeScheduleFlush() {
-	if d.mu.compact.flushing || d.closed.Load() != nil || d.opts.ReadOnly {
+	d.maybeBuildMemTableFiltersLocked()
+	if d.mu.compact.flushing || d.closed.Load() != nil || d.opts.ReadOnly || d.degradedErr() != nil {
 		return
 	}
 	if len(d.mu.mem.queue) <= 1 {
@@ -1845,6 +1935,7 @@ func (d *DB) flush() {
 			// TODO(peter): count consecutive flush errors and backoff.
 			d.opts.EventListener.BackgroundError(err)
 		}
+		d.mu.compact.flushDiskFull = err != nil && errors.Is(err, syscall.ENOSPC)
 		d.mu.compact.flushing = false
 		d.mu.compact.noOngoingFlushStartTime = time.Now()
 		workDuration := d.mu.compact.noOngoingFlushStartTime.Sub(flushingWorkStart)
@@ -2019,6 +2110,9 @@ func (d *DB) flush1() (bytesFlushed uint64, err error) {
 	if c.kind != compactionKindIngestedFlushable {
 		ve, pendingOutputs, stats, err = d.runCompaction(jobID, c)
 	}
+	if d.invalidateRowCache(stats) {
+		defer d.rowCache.endRange()
+	}
 
 	// Acquire logLock. This will be released either on an error, by way of
 	// logUnlock, or through a call to logAndApply if there is no error.
@@ -2041,6 +2135,7 @@ func (d *DB) flush1() (bytesFlushed uint64, err error) {
 		for i := range ve.NewFiles {
 			e := &ve.NewFiles[i]
 			info.Output = append(info.Output, e.Meta.TableInfo())
+			info.OutputProperties = append(info.OutputProperties, c.flushOutputProperties[e.Meta.FileNum])
 			// Ingested tables are not necessarily flushed to L0. Record the level of
 			// each ingested file explicitly.
 			if ingest {
@@ -2088,6 +2183,7 @@ func (d *DB) flush1() (bytesFlushed uint64, err error) {
 				)
 			}
 			d.mu.versions.updateObsoleteTableMetricsLocked()
+			d.mu.versions.addUnappliedBlobFilesLocked(ve)
 		}
 	} else {
 		// We won't be performing the logAndApply step because of the error,
@@ -2110,6 +2206,7 @@ func (d *DB) flush1() (bytesFlushed uint64, err error) {
 		flushed = d.mu.mem.queue[:n]
 		d.mu.mem.queue = d.mu.mem.queue[n:]
 		d.updateReadStateLocked(d.opts.DebugCheck)
+		d.pruneIdempotencyTokensLocked()
 		d.updateTableStatsLocked(ve.NewFiles)
 		if ingest {
 			d.mu.versions.metrics.Flush.AsIngestCount++
@@ -2169,12 +2266,133 @@ func (d *DB) flush1() (bytesFlushed uint64, err error) {
 
 	// Mark all the memtables we flushed as flushed.
 	for i := range flushed {
+		flushed[i].flushInfo = &info
 		close(flushed[i].flushed)
 	}
 
 	return bytesFlushed, err
 }
 
+// flushRangeLocked flushes the keys within [start, end) of the flushables up
+// to mem, which must be immutable, out of the memtables. The flushed span is
+// recorded in the version, for reads of the flushables, and the flushes of
+// their remaining keys, to skip the keys flushed (see flushableView).
+//
+// d.mu must be held when calling this, but the mutex may be dropped and
+// re-acquired during the course of this method.
+func (d *DB) flushRangeLocked(mem *flushableEntry, start, end []byte) error {
+	var seqNum uint64
+	for i := range d.mu.mem.queue {
+		if d.mu.mem.queue[i] == mem {
+			seqNum = d.mu.mem.queue[i+1].logSeqNum
+			break
+		}
+	}
+	// Wait for the writes sequenced before the rotation of mem to be applied to
+	// the memtables, and for the flush in progress to complete. The spin loop
+	// mirrors the one of commitPipeline.AllocateSeqNum.
+	d.mu.Unlock()
+	for d.mu.versions.visibleSeqNum.Load() < seqNum {
+		runtime.Gosched()
+	}
+	d.mu.Lock()
+	for d.mu.compact.flushing {
+		d.mu.compact.cond.Wait()
+	}
+	defer func() {
+		d.mu.compact.flushing = false
+		d.maybeScheduleFlush()
+		d.maybeScheduleCompaction()
+		d.mu.compact.cond.Broadcast()
+	}()
+	d.mu.compact.flushing = true
+
+	// The flushes that completed in the meantime may have flushed mem.
+	var flushing flushableList
+	for _, f := range d.mu.mem.queue {
+		if f.logSeqNum >= seqNum {
+			break
+		}
+		flushing = append(flushing, flushRangeView(d.cmp, f, start, end))
+	}
+	if len(flushing) == 0 {
+		return nil
+	}
+
+	c := newFlush(d.opts, d.mu.versions.currentVersion(),
+		d.mu.versions.picker.getBaseLevel(), flushing, d.timeNow())
+	d.addInProgressCompaction(c)
+
+	jobID := d.mu.nextJobID
+	d.mu.nextJobID++
+	d.opts.EventListener.FlushBegin(FlushInfo{
+		JobID: jobID,
+		Input: len(flushing),
+	})
+	startTime := d.timeNow()
+
+	ve, pendingOutputs, stats, err := d.runCompaction(jobID, c)
+	if d.invalidateRowCache(stats) {
+		defer d.rowCache.endRange()
+	}
+
+	d.mu.versions.logLock()
+	info := FlushInfo{
+		JobID:    jobID,
+		Input:    len(flushing),
+		Duration: d.timeNow().Sub(startTime),
+		Done:     true,
+		Err:      err,
+	}
+	if err == nil && len(ve.NewFiles) == 0 {
+		// No key within the span was written, so the memtables are left as
+		// is.
+		info.Err = errEmptyTable
+		d.mu.versions.logUnlock()
+	} else if err == nil {
+		for i := range ve.NewFiles {
+			e := &ve.NewFiles[i]
+			info.Output = append(info.Output, e.Meta.TableInfo())
+			info.OutputProperties = append(info.OutputProperties, c.flushOutputProperties[e.Meta.FileNum])
+		}
+		ve.FlushedSpans = []manifest.FlushedSpan{{
+			Start:  start,
+			End:    end,
+			SeqNum: seqNum,
+			LogNum: mem.logNum,
+		}}
+		// The WALs of the memtables aren't flushed.
+		c.metrics[0].BytesIn = c.metrics[0].BytesFlushed
+		err = d.mu.versions.logAndApply(jobID, ve, c.metrics, false, /* forceRotation */
+			func() []compactionInfo { return d.getInProgressCompactionInfoLocked(c) })
+		if err != nil {
+			info.Err = err
+			for _, f := range pendingOutputs {
+				d.mu.versions.obsoleteTables = append(
+					d.mu.versions.obsoleteTables,
+					fileInfo{f.FileNum.DiskFileNum(), f.Size},
+				)
+			}
+			d.mu.versions.updateObsoleteTableMetricsLocked()
+			d.mu.versions.addUnappliedBlobFilesLocked(ve)
+		}
+	} else {
+		d.mu.versions.logUnlock()
+	}
+
+	d.clearCompactingState(c, err != nil)
+	delete(d.mu.compact.inProgress, c)
+	d.mu.versions.incrementCompactions(c.kind, c.extraLevels)
+	if err == nil {
+		d.updateReadStateLocked(d.opts.DebugCheck)
+		d.updateTableStatsLocked(ve.NewFiles)
+	}
+	info.TotalDuration = d.timeNow().Sub(startTime)
+	d.opts.EventListener.FlushEnd(info)
+	d.deleteObsoleteFiles(jobID)
+	return err
+}
+
 // maybeScheduleCompactionAsync should be used when
 // we want to possibly schedule a compaction, but don't
 // want to eat the cost of running maybeScheduleCompaction.
@@ -2203,6 +2421,47 @@ func pickElisionOnly(picker compactionPicker, env compactionEnv) *pickedCompacti
 	return picker.pickElisionOnlyCompaction(env)
 }
 
+// periodicCompactionCheckInterval is the interval at which the DB checks for
+// sstables that are due for a periodic compaction.
+const periodicCompactionCheckInterval = time.Minute
+
+// compactionCheckInterval returns the interval at which compactions whose
+// need depends on the passage of time (TTL, periodic and tiering compactions)
+// must be checked for, or zero if none are configured.
+func (o *Options) compactionCheckInterval() time.Duration {
+	var interval time.Duration
+	if o.TTL.enabled() {
+		interval = o.TTL.CheckInterval
+	}
+	if o.PeriodicCompactionInterval > 0 &&
+		(interval == 0 || interval > periodicCompactionCheckInterval) {
+		interval = periodicCompactionCheckInterval
+	}
+	if o.Experimental.Tiering.enabled() &&
+		(interval == 0 || interval > o.Experimental.Tiering.CheckInterval) {
+		interval = o.Experimental.Tiering.CheckInterval
+	}
+	return interval
+}
+
+// compactionCheckLoop schedules compactions at the provided interval, so
+// that TTL, periodic and tiering compactions run even when nothing else
+// triggers a compaction. It exits when the DB is closed.
+func (d *DB) compactionCheckLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.closedCh:
+			return
+		case <-ticker.C:
+			d.mu.Lock()
+			d.maybeScheduleCompaction()
+			d.mu.Unlock()
+		}
+	}
+}
+
 // maybeScheduleCompactionPicker schedules a compaction if necessary,
 // calling `pickFunc` to pick automatic compactions.
 //
@@ -2210,11 +2469,13 @@ func pickElisionOnly(picker compactionPicker, env compactionEnv) *pickedCompacti
 func (d *DB) maybeScheduleCompactionPicker(
 	pickFunc func(compactionPicker, compactionEnv) *pickedCompaction,
 ) {
-	if d.closed.Load() != nil || d.opts.ReadOnly {
+	if d.closed.Load() != nil || d.opts.ReadOnly || d.degradedErr() != nil {
 		return
 	}
+	d.maybeScheduleSnapshotElisionCompactionsLocked()
+
 	maxConcurrentCompactions := d.opts.MaxConcurrentCompactions()
-	if d.mu.compact.compactingCount >= maxConcurrentCompactions {
+	if d.compactingCountLocked() >= maxConcurrentCompactions {
 		if len(d.mu.compact.manual) > 0 {
 			// Inability to run head blocks later manual compactions.
 			d.mu.compact.manual[0].retries++
@@ -2236,18 +2497,20 @@ func (d *DB) maybeScheduleCompactionPicker(
 	}
 
 	env := compactionEnv{
-		earliestSnapshotSeqNum:  d.mu.snapshots.earliest(),
+		earliestSnapshotSeqNum:  d.earliestSnapshotLocked(),
 		earliestUnflushedSeqNum: d.getEarliestUnflushedSeqNumLocked(),
+		now:                     d.timeNow(),
+		diskSpaceLow:            d.diskSpaceLow(),
 	}
 
 	// Check for delete-only compactions first, because they're expected to be
 	// cheap and reduce future compaction work.
 	if !d.opts.private.disableDeleteOnlyCompactions &&
 		len(d.mu.compact.deletionHints) > 0 &&
-		d.mu.compact.compactingCount < maxConcurrentCompactions &&
-		!d.opts.DisableAutomaticCompactions {
+		d.compactingCountLocked() < maxConcurrentCompactions &&
+		!d.automaticCompactionsDisabledLocked() {
 		v := d.mu.versions.currentVersion()
-		snapshots := d.mu.snapshots.toSlice()
+		snapshots := d.compactionSnapshotsLocked()
 		inputs, unresolvedHints := checkDeleteCompactionHints(d.cmp, v, d.mu.compact.deletionHints, snapshots)
 		d.mu.compact.deletionHints = unresolvedHints
 
@@ -2259,12 +2522,150 @@ func (d *DB) maybeScheduleCompactionPicker(
 		}
 	}
 
-	for len(d.mu.compact.manual) > 0 && d.mu.compact.compactingCount < maxConcurrentCompactions {
+	// High priority manual compactions are scheduled ahead of automatic
+	// compactions, and low priority ones after them, unless the free disk
+	// space is below Options.DiskSpaceSoftLimit.
+	d.scheduleManualCompactionsLocked(env, false /* includeLowPriority */)
+
+	if minLiveRatio := d.opts.Experimental.ValueSeparation.MinLiveRatio; minLiveRatio > 0 {
+		env.blobFilesToRewrite = d.mu.versions.blobFilesToRewriteLocked(minLiveRatio)
+	}
+	for !d.automaticCompactionsDisabledLocked() && d.compactingCountLocked() < maxConcurrentCompactions {
+		env.inProgressCompactions = d.getInProgressCompactionInfoLocked(nil)
+		env.readCompactionEnv = readCompactionEnv{
+			readCompactions:          &d.mu.compact.readCompactions,
+			flushing:                 d.mu.compact.flushing || d.passedFlushThreshold(),
+			rescheduleReadCompaction: &d.mu.compact.rescheduleReadCompaction,
+		}
+		env.hotRanges = &d.mu.compact.hotRanges
+		env.tiering = d.tieringEnvLocked(env.now)
+		pc := pickFunc(d.mu.versions.picker, env)
+		if env.tiering != nil && env.tiering.scanned {
+			d.mu.compact.nextTieringCheck = env.now.Add(d.opts.Experimental.Tiering.CheckInterval)
+		}
+		if pc == nil {
+			break
+		}
+		c := d.newCompactionLocked(pc)
+		d.mu.compact.compactingCount++
+		d.addInProgressCompaction(c)
+		go d.compact(c, nil)
+	}
+
+	d.scheduleManualCompactionsLocked(env, !env.diskSpaceLow /* includeLowPriority */)
+}
+
+// automaticCompactionsDisabledLocked returns true if automatic compactions
+// are disabled by Options.DisableAutomaticCompactions or paused by
+// DB.PauseCompactions.
+//
+// d.mu must be held when calling this.
+func (d *DB) automaticCompactionsDisabledLocked() bool {
+	return d.opts.DisableAutomaticCompactions || d.mu.compact.paused
+}
+
+// compactingCountLocked returns the number of ongoing compactions that count
+// against Options.MaxConcurrentCompactions.
+//
+// d.mu must be held when calling this.
+func (d *DB) compactingCountLocked() int {
+	return d.mu.compact.compactingCount - d.mu.compact.snapshotElisionCount
+}
+
+// queueSnapshotElisionCompactionsLocked queues bottommost files for
+// elision-only compactions after the earliest snapshot advanced from
+// prevEarliest to earliest. Files with a LargestSeqNum in [prevEarliest,
+// earliest) had their obsolete keys pinned by the closed snapshots, and are
+// now eligible to have them elided.
+//
+// d.mu must be held when calling this.
+func (d *DB) queueSnapshotElisionCompactionsLocked(prevEarliest, earliest uint64) {
+	if d.opts.Experimental.SnapshotElisionConcurrency <= 0 ||
+		d.opts.private.disableElisionOnlyCompactions {
+		return
+	}
+	queued := make(map[*fileMetadata]struct{}, len(d.mu.compact.snapshotElisionQueue))
+	for _, f := range d.mu.compact.snapshotElisionQueue {
+		queued[f] = struct{}{}
+	}
+	iter := d.mu.versions.currentVersion().Levels[numLevels-1].Iter()
+	for f := iter.First(); f != nil; f = iter.Next() {
+		if f.LargestSeqNum < prevEarliest || f.LargestSeqNum >= earliest {
+			continue
+		}
+		if f.IsCompacting() || !f.StatsValid() || !elisionOnlyWorthwhile(f) {
+			continue
+		}
+		if _, ok := queued[f]; !ok {
+			d.mu.compact.snapshotElisionQueue = append(d.mu.compact.snapshotElisionQueue, f)
+		}
+	}
+}
+
+// maybeScheduleSnapshotElisionCompactionsLocked schedules elision-only
+// compactions of the files in d.mu.compact.snapshotElisionQueue, up to
+// Options.Experimental.SnapshotElisionConcurrency at a time.
+//
+// d.mu must be held when calling this.
+func (d *DB) maybeScheduleSnapshotElisionCompactionsLocked() {
+	if len(d.mu.compact.snapshotElisionQueue) == 0 || d.automaticCompactionsDisabledLocked() ||
+		d.mu.compact.snapshotElisionCount >= d.opts.Experimental.SnapshotElisionConcurrency {
+		return
+	}
+
+	d.mu.versions.logLock()
+	defer d.mu.versions.logUnlock()
+	if d.closed.Load() != nil {
+		return
+	}
+
+	env := compactionEnv{
+		earliestSnapshotSeqNum:  d.earliestSnapshotLocked(),
+		earliestUnflushedSeqNum: d.getEarliestUnflushedSeqNumLocked(),
+		now:                     d.timeNow(),
+	}
+	for len(d.mu.compact.snapshotElisionQueue) > 0 &&
+		d.mu.compact.snapshotElisionCount < d.opts.Experimental.SnapshotElisionConcurrency {
+		f := d.mu.compact.snapshotElisionQueue[0]
+		d.mu.compact.snapshotElisionQueue = d.mu.compact.snapshotElisionQueue[1:]
+		// Files that have since been compacted, or are being compacted, are
+		// dropped from the queue.
+		env.inProgressCompactions = d.getInProgressCompactionInfoLocked(nil)
+		pc := d.mu.versions.picker.pickSnapshotElisionCompaction(env, f)
+		if pc == nil {
+			continue
+		}
+		c := d.newCompactionLocked(pc)
+		c.snapshotElision = true
+		d.mu.compact.compactingCount++
+		d.mu.compact.snapshotElisionCount++
+		d.addInProgressCompaction(c)
+		go d.compact(c, nil)
+	}
+}
+
+// scheduleManualCompactionsLocked schedules queued manual compactions in
+// order. Unless includeLowPriority is set, it stops at the first low priority
+// compaction.
+func (d *DB) scheduleManualCompactionsLocked(env compactionEnv, includeLowPriority bool) {
+	maxConcurrentCompactions := d.opts.MaxConcurrentCompactions()
+	for len(d.mu.compact.manual) > 0 && d.compactingCountLocked() < maxConcurrentCompactions {
 		manual := d.mu.compact.manual[0]
+		if manual.priority == ManualCompactionPriorityLow && !includeLowPriority {
+			break
+		}
 		env.inProgressCompactions = d.getInProgressCompactionInfoLocked(nil)
 		pc, retryLater := d.mu.versions.picker.pickManual(env, manual)
 		if pc != nil {
-			c := newCompaction(pc, d.opts, d.timeNow())
+			c := d.newCompactionLocked(pc)
+			if c.kind == compactionKindMove && d.opts.CompactionFilter != nil {
+				// Manual compactions rewrite the files they would otherwise
+				// move, so that their keys are filtered. Automatic compactions
+				// leave them to be filtered by a later rewrite, to avoid the
+				// write amplification.
+				c.kind = compactionKindDefault
+			}
+			manual.c = c
 			d.mu.compact.manual = d.mu.compact.manual[1:]
 			d.mu.compact.compactingCount++
 			d.addInProgressCompaction(c)
@@ -2279,23 +2680,6 @@ func (d *DB) maybeScheduleCompactionPicker(
 			break
 		}
 	}
-
-	for !d.opts.DisableAutomaticCompactions && d.mu.compact.compactingCount < maxConcurrentCompactions {
-		env.inProgressCompactions = d.getInProgressCompactionInfoLocked(nil)
-		env.readCompactionEnv = readCompactionEnv{
-			readCompactions:          &d.mu.compact.readCompactions,
-			flushing:                 d.mu.compact.flushing || d.passedFlushThreshold(),
-			rescheduleReadCompaction: &d.mu.compact.rescheduleReadCompaction,
-		}
-		pc := pickFunc(d.mu.versions.picker, env)
-		if pc == nil {
-			break
-		}
-		c := newCompaction(pc, d.opts, d.timeNow())
-		d.mu.compact.compactingCount++
-		d.addInProgressCompaction(c)
-		go d.compact(c, nil)
-	}
 }
 
 // deleteCompactionHintType indicates whether the deleteCompactionHint was
@@ -2581,6 +2965,9 @@ func (d *DB) compact(c *compaction, errChannel chan error) {
 			d.opts.EventListener.BackgroundError(err)
 		}
 		d.mu.compact.compactingCount--
+		if c.snapshotElision {
+			d.mu.compact.snapshotElisionCount--
+		}
 		delete(d.mu.compact.inProgress, c)
 		// Add this compaction's duration to the cumulative duration. NB: This
 		// must be atomic with the above removal of c from
@@ -2613,6 +3000,9 @@ func (d *DB) compact1(c *compaction, errChannel chan error) (err error) {
 	startTime := d.timeNow()
 
 	ve, pendingOutputs, stats, err := d.runCompaction(jobID, c)
+	if d.invalidateRowCache(stats) {
+		defer d.rowCache.endRange()
+	}
 
 	info.Duration = d.timeNow().Sub(startTime)
 	if err == nil {
@@ -2651,6 +3041,7 @@ func (d *DB) compact1(c *compaction, errChannel chan error) (err error) {
 				)
 			}
 			d.mu.versions.updateObsoleteTableMetricsLocked()
+			d.mu.versions.addUnappliedBlobFilesLocked(ve)
 		}
 	}
 
@@ -2688,10 +3079,24 @@ func (d *DB) compact1(c *compaction, errChannel chan error) (err error) {
 	return err
 }
 
+// invalidateRowCache invalidates the row cache if the flush or compaction with
+// the given stats deleted keys or changed their values, which changes the
+// values read by Get once its outputs are installed. If it returns true, the
+// caller must end the invalidation with rowCache.endRange once the outputs are
+// visible, or the flush or compaction failed.
+func (d *DB) invalidateRowCache(stats compactStats) bool {
+	if d.rowCache == nil || stats.countRewrittenKeys == 0 {
+		return false
+	}
+	d.rowCache.beginRange()
+	return true
+}
+
 type compactStats struct {
 	cumulativePinnedKeys uint64
 	cumulativePinnedSize uint64
 	countMissizedDels    uint64
+	countRewrittenKeys   uint64
 }
 
 // runCompactions runs a compaction that produces new on-disk tables from
@@ -2780,14 +3185,34 @@ func (d *DB) runCompaction(
 		}
 	}()
 
-	snapshots := d.mu.snapshots.toSlice()
+	snapshots := d.compactionSnapshotsLocked()
 	formatVers := d.FormatMajorVersion()
+	predicateDeletions := append([]*predicateDeletion(nil), d.mu.compact.predicateDeletions...)
+	// The level options may be changed by DB.SetLevelSizing while d.mu is
+	// not held.
+	writerOpts := compactionWriterOptions(d.opts, formatVers, c.outputLevel.level)
+	if size := d.opts.Level(c.outputLevel.level).ZstdDictionarySize; size > 0 &&
+		writerOpts.Compression == ZstdCompression && formatVers >= ExperimentalFormatZstdDictionaries {
+		writerOpts.TrainZstdDictionarySize = size
+	}
 
 	// Release the d.mu lock while doing I/O.
 	// Note the unusual order: Unlock and then Lock.
 	d.mu.Unlock()
 	defer d.mu.Lock()
 
+	// Offload the compaction to a remote worker if possible, falling back to
+	// running it locally.
+	if len(predicateDeletions) == 0 {
+		ve, pendingOutputs, err := d.runRemoteCompaction(jobID, c, snapshots, formatVers)
+		if err == nil {
+			return ve, pendingOutputs, stats, nil
+		}
+		if !errors.Is(err, errRemoteCompactionIneligible) {
+			d.opts.Logger.Infof("[JOB %d] remote compaction failed, compacting locally: %v", jobID, err)
+		}
+	}
+
 	// Compactions use a pool of buffers to read blocks, avoiding polluting the
 	// block cache with blocks that will not be read again. We initialize the
 	// buffer pool with a size 12. This initial size does not need to be
@@ -2818,10 +3243,41 @@ func (d *DB) runCompaction(
 	if err != nil {
 		return nil, pendingOutputs, stats, err
 	}
-	c.allowedZeroSeqNum = c.allowZeroSeqNum()
+	// Keys are never zeroed while a DeleteRangeIf is in progress, because a
+	// zeroed sequence number would make keys written after the DeleteRangeIf
+	// indistinguishable from the keys it deletes.
+	c.allowedZeroSeqNum = c.allowZeroSeqNum() && len(predicateDeletions) == 0
 	iter := newCompactionIter(c.cmp, c.equal, c.formatKey, d.merge, iiter, snapshots,
 		&c.rangeDelFrag, &c.rangeKeyFrag, c.allowedZeroSeqNum, c.elideTombstone,
 		c.elideRangeTombstone, d.FormatMajorVersion())
+	iter.predicateDeletions = predicateDeletions
+	if filter := d.compactionFilter(c); filter != nil {
+		iter.filter = filter
+		iter.filterLevel = c.outputLevel.level
+	}
+	// blobs is set if the compaction separates large values into blob files,
+	// in which case it also carries the blob handles of its inputs over to its
+	// outputs, except for the handles of the blob files it rewrites.
+	var blobs *blobOutputs
+	if d.separateValues(c, formatVers) && writerOpts.TableFormat >= sstable.TableFormatPebblev3 {
+		blobs = newBlobOutputs(d, writerOpts)
+		rewrite := c.rewriteBlobFiles
+		iter.keepBlobHandle = func(v LazyValue) bool {
+			if !d.blobFiles.isBlobHandle(v) {
+				return false
+			}
+			if len(rewrite) == 0 {
+				return true
+			}
+			fileNum, err := blob.DecodeHandleFileNum(v.ValueOrHandle)
+			if err != nil {
+				// Fetching the value surfaces the error.
+				return false
+			}
+			_, ok := rewrite[fileNum]
+			return !ok
+		}
+	}
 
 	var (
 		createdFiles    []base.DiskFileNum
@@ -2841,6 +3297,9 @@ func (d *DB) runCompaction(
 			for _, fileNum := range createdFiles {
 				_ = d.objProvider.Remove(fileTypeTable, fileNum)
 			}
+			if blobs != nil {
+				blobs.abort()
+			}
 		}
 		for _, closer := range c.closers {
 			retErr = firstError(retErr, closer.Close())
@@ -2870,25 +3329,6 @@ func (d *DB) runCompaction(
 		c.metrics[c.extraLevels[0].level] = &LevelMetrics{}
 	}
 
-	// The table is typically written at the maximum allowable format implied by
-	// the current format major version of the DB.
-	tableFormat := formatVers.MaxTableFormat()
-
-	// In format major versions with maximum table formats of Pebblev3, value
-	// blocks were conditional on an experimental setting. In format major
-	// versions with maximum table formats of Pebblev4 and higher, value blocks
-	// are always enabled.
-	if tableFormat == sstable.TableFormatPebblev3 &&
-		(d.opts.Experimental.EnableValueBlocks == nil || !d.opts.Experimental.EnableValueBlocks()) {
-		tableFormat = sstable.TableFormatPebblev2
-	}
-
-	writerOpts := d.opts.MakeWriterOptions(c.outputLevel.level, tableFormat)
-	if formatVers < FormatBlockPropertyCollector {
-		// Cannot yet write block properties.
-		writerOpts.BlockPropertyCollectors = nil
-	}
-
 	// prevPointKey is a sstable.WriterOption that provides access to
 	// the last point key written to a writer's sstable. When a new
 	// output begins in newOutput, prevPointKey is updated to point to
@@ -2929,16 +3369,19 @@ func (d *DB) runCompaction(
 				ctx = objiotracing.WithReason(ctx, objiotracing.ForCompaction)
 			}
 		}
-		// Prefer shared storage if present.
+		// Prefer shared storage if present, unless the output level is above
+		// CreateOnSharedMinLevel.
 		//
 		// TODO(bilal): This might be inefficient for short-lived files in higher
 		// levels if we're only writing to shared storage and not double-writing
 		// to local storage. Either implement double-writing functionality, or
-		// set PreferSharedStorage to c.outputLevel.level >= 5. The latter needs
-		// some careful handling around move compactions to ensure all files in
-		// lower levels are in shared storage.
+		// set CreateOnSharedMinLevel.
 		createOpts := objstorage.CreateOptions{
-			PreferSharedStorage: true,
+			PreferSharedStorage: d.opts.preferSharedStorage(c.outputLevel.level),
+			LocalDir:            d.opts.tableDir(d.dirname, c.outputLevel.level),
+		}
+		if c.kind == compactionKindTiering {
+			createOpts.PreferSharedStorage = c.demote
 		}
 		writable, objMeta, err := d.objProvider.Create(ctx, fileTypeTable, fileNum.DiskFileNum(), createOpts)
 		if err != nil {
@@ -2955,6 +3398,15 @@ func (d *DB) runCompaction(
 			Path:    d.objProvider.Path(objMeta),
 			FileNum: fileNum,
 		})
+		ioClass := IOClassCompactionWrite
+		if c.flushing != nil {
+			ioClass = IOClassFlushWrite
+		}
+		writable = &ioScheduledWritable{
+			Writable:  writable,
+			scheduler: d.ioScheduler,
+			class:     ioClass,
+		}
 		if c.kind != compactionKindFlush {
 			writable = &compactionWritable{
 				Writable: writable,
@@ -2973,6 +3425,9 @@ func (d *DB) runCompaction(
 			d.opts.Experimental.MaxWriterConcurrency > 0 &&
 				(cpuWorkHandle.Permitted() || d.opts.Experimental.ForceWriterParallelism)
 
+		if writerOpts.TrainZstdDictionarySize > 0 {
+			writerOpts.ZstdDictionary = d.zstdDicts.get(c.outputLevel.level)
+		}
 		tw = sstable.NewWriter(writable, writerOpts, cacheOpts, &prevPointKey)
 
 		fileMeta.CreationTime = time.Now().Unix()
@@ -3100,10 +3555,16 @@ func (d *DB) runCompaction(
 			return err
 		}
 		tw = nil
+		if writerMeta.TrainedZstdDictionary != nil {
+			d.zstdDicts.set(c.outputLevel.level, writerMeta.TrainedZstdDictionary)
+		}
 		meta := ve.NewFiles[len(ve.NewFiles)-1].Meta
 		meta.Size = writerMeta.Size
 		meta.SmallestSeqNum = writerMeta.SmallestSeqNum
 		meta.LargestSeqNum = writerMeta.LargestSeqNum
+		if blobs != nil {
+			meta.BlobReferences = blobs.finishTable()
+		}
 		meta.InitPhysicalBacking()
 
 		// If the file didn't contain any range deletions, we can fill its
@@ -3118,6 +3579,11 @@ func (d *DB) runCompaction(
 		} else {
 			outputMetrics.TablesFlushed++
 			outputMetrics.BytesFlushed += meta.Size
+			if c.flushOutputProperties == nil {
+				c.flushOutputProperties = make(map[base.FileNum]*sstable.Properties)
+			}
+			props := writerMeta.Properties
+			c.flushOutputProperties[meta.FileNum] = &props
 		}
 		outputMetrics.Size += int64(meta.Size)
 		outputMetrics.NumFiles++
@@ -3339,7 +3805,12 @@ func (d *DB) runCompaction(
 					return nil, pendingOutputs, stats, err
 				}
 			}
-			if err := tw.AddWithForceObsolete(*key, val, iter.forceObsoleteDueToRangeDel); err != nil {
+			if blobs != nil {
+				err = blobs.add(tw, iter, *key, val)
+			} else {
+				err = tw.AddWithForceObsolete(*key, val, iter.forceObsoleteDueToRangeDel)
+			}
+			if err != nil {
 				return nil, pendingOutputs, stats, err
 			}
 			if iter.snapshotPinned {
@@ -3348,7 +3819,11 @@ func (d *DB) runCompaction(
 				// its elision. Increment the stats.
 				pinnedCount++
 				pinnedKeySize += uint64(len(key.UserKey)) + base.InternalTrailerLen
-				pinnedValueSize += uint64(len(val))
+				if iter.valueIsBlob {
+					pinnedValueSize += uint64(iter.valueAttr.ValueLen)
+				} else {
+					pinnedValueSize += uint64(len(val))
+				}
 			}
 		}
 
@@ -3396,6 +3871,14 @@ func (d *DB) runCompaction(
 	// keys that encoded an incorrect size. Propagate it up as a part of
 	// compactStats.
 	stats.countMissizedDels = iter.stats.countMissizedDels
+	stats.countRewrittenKeys = iter.stats.countRewrittenKeys
+
+	if blobs != nil {
+		if err := blobs.finishBlobFile(); err != nil {
+			return nil, pendingOutputs, stats, err
+		}
+		ve.NewBlobFiles = blobs.finished
+	}
 
 	if err := d.objProvider.Sync(); err != nil {
 		return nil, pendingOutputs, stats, err
@@ -3408,6 +3891,32 @@ func (d *DB) runCompaction(
 	return ve, pendingOutputs, stats, nil
 }
 
+// compactionWriterOptions returns the options used to write the output
+// sstables of a flush or compaction into the provided level.
+func compactionWriterOptions(
+	opts *Options, formatVers FormatMajorVersion, level int,
+) sstable.WriterOptions {
+	// The table is typically written at the maximum allowable format implied by
+	// the current format major version of the DB.
+	tableFormat := formatVers.MaxTableFormat()
+
+	// In format major versions with maximum table formats of Pebblev3, value
+	// blocks were conditional on an experimental setting. In format major
+	// versions with maximum table formats of Pebblev4 and higher, value blocks
+	// are always enabled.
+	if tableFormat == sstable.TableFormatPebblev3 &&
+		(opts.Experimental.EnableValueBlocks == nil || !opts.Experimental.EnableValueBlocks()) {
+		tableFormat = sstable.TableFormatPebblev2
+	}
+
+	writerOpts := opts.MakeWriterOptions(level, tableFormat)
+	if formatVers < FormatBlockPropertyCollector {
+		// Cannot yet write block properties.
+		writerOpts.BlockPropertyCollectors = nil
+	}
+	return writerOpts
+}
+
 // validateVersionEdit validates that start and end keys across new and deleted
 // files in a versionEdit pass the given validation function.
 func validateVersionEdit(
@@ -3476,6 +3985,7 @@ func (d *DB) scanObsoleteFiles(list []string) {
 
 	var obsoleteLogs []fileInfo
 	var obsoleteTables []fileInfo
+	var obsoleteBlobFiles []fileInfo
 	var obsoleteManifests []fileInfo
 	var obsoleteOptions []fileInfo
 
@@ -3512,7 +4022,7 @@ func (d *DB) scanObsoleteFiles(list []string) {
 				fi.fileSize = uint64(stat.Size())
 			}
 			obsoleteOptions = append(obsoleteOptions, fi)
-		case fileTypeTable:
+		case fileTypeTable, fileTypeBlob:
 			// Objects are handled through the objstorage provider below.
 		default:
 			// Don't delete files we don't know about.
@@ -3534,6 +4044,18 @@ func (d *DB) scanObsoleteFiles(list []string) {
 			}
 			obsoleteTables = append(obsoleteTables, fileInfo)
 
+		case fileTypeBlob:
+			if _, ok := liveFileNums[obj.DiskFileNum]; ok {
+				continue
+			}
+			fileInfo := fileInfo{
+				fileNum: obj.DiskFileNum,
+			}
+			if size, err := d.objProvider.Size(obj); err == nil {
+				fileInfo.fileSize = uint64(size)
+			}
+			obsoleteBlobFiles = append(obsoleteBlobFiles, fileInfo)
+
 		default:
 			// Ignore object types we don't know about.
 		}
@@ -3543,6 +4065,7 @@ func (d *DB) scanObsoleteFiles(list []string) {
 	d.mu.versions.metrics.WAL.Files = int64(len(d.mu.log.queue))
 	d.mu.versions.obsoleteTables = mergeFileInfo(d.mu.versions.obsoleteTables, obsoleteTables)
 	d.mu.versions.updateObsoleteTableMetricsLocked()
+	d.mu.versions.obsoleteBlobFiles = mergeFileInfo(d.mu.versions.obsoleteBlobFiles, obsoleteBlobFiles)
 	d.mu.versions.obsoleteManifests = merge(d.mu.versions.obsoleteManifests, obsoleteManifests)
 	d.mu.versions.obsoleteOptions = merge(d.mu.versions.obsoleteOptions, obsoleteOptions)
 }
@@ -3608,6 +4131,11 @@ func (d *DB) deleteObsoleteFiles(jobID int) {
 			break
 		}
 	}
+	if d.opts.WALArchive.enabled() {
+		// The obsolete logs remain readable by WALTailers at their live path
+		// until they're archived.
+		d.mu.log.archiving = append(d.mu.log.archiving, obsoleteLogs...)
+	}
 
 	obsoleteTables := append([]fileInfo(nil), d.mu.versions.obsoleteTables...)
 	d.mu.versions.obsoleteTables = nil
@@ -3616,6 +4144,9 @@ func (d *DB) deleteObsoleteFiles(jobID int) {
 		delete(d.mu.versions.zombieTables, tbl.fileNum)
 	}
 
+	obsoleteBlobFiles := d.mu.versions.obsoleteBlobFiles
+	d.mu.versions.obsoleteBlobFiles = nil
+
 	// Sort the manifests cause we want to delete some contiguous prefix
 	// of the older manifests.
 	sort.Slice(d.mu.versions.obsoleteManifests, func(i, j int) bool {
@@ -3641,17 +4172,20 @@ func (d *DB) deleteObsoleteFiles(jobID int) {
 	d.mu.Unlock()
 	defer d.mu.Lock()
 
-	files := [4]struct {
+	files := [5]struct {
 		fileType fileType
 		obsolete []fileInfo
 	}{
 		{fileTypeLog, obsoleteLogs},
 		{fileTypeTable, obsoleteTables},
+		{fileTypeBlob, obsoleteBlobFiles},
 		{fileTypeManifest, obsoleteManifests},
 		{fileTypeOptions, obsoleteOptions},
 	}
 	_, noRecycle := d.opts.Cleaner.(base.NeedsFileContents)
-	filesToDelete := make([]obsoleteFile, 0, len(obsoleteLogs)+len(obsoleteTables)+len(obsoleteManifests)+len(obsoleteOptions))
+	// Archived WAL files must not be recycled, as recycling overwrites them.
+	noRecycle = noRecycle || d.opts.WALArchive.enabled() || d.opts.DisableWALRecycling
+	filesToDelete := make([]obsoleteFile, 0, len(obsoleteLogs)+len(obsoleteTables)+len(obsoleteBlobFiles)+len(obsoleteManifests)+len(obsoleteOptions))
 	for _, f := range files {
 		// We sort to make the order of deletions deterministic, which is nice for
 		// tests.
@@ -3668,6 +4202,8 @@ func (d *DB) deleteObsoleteFiles(jobID int) {
 				dir = d.walDirname
 			case fileTypeTable:
 				d.tableCache.evict(fi.fileNum)
+			case fileTypeBlob:
+				d.blobFiles.evict(fi.fileNum)
 			}
 
 			filesToDelete = append(filesToDelete, obsoleteFile{
@@ -3693,7 +4229,7 @@ func (d *DB) maybeScheduleObsoleteTableDeletion() {
 }
 
 func (d *DB) maybeScheduleObsoleteTableDeletionLocked() {
-	if len(d.mu.versions.obsoleteTables) > 0 {
+	if len(d.mu.versions.obsoleteTables) > 0 || len(d.mu.versions.obsoleteBlobFiles) > 0 {
 		jobID := d.mu.nextJobID
 		d.mu.nextJobID++
 		d.deleteObsoleteFiles(jobID)
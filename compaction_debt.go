@@ -0,0 +1,175 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"math"
+	"time"
+)
+
+// l0TrajectoryWindow is the period over which the rate of change of the
+// number of L0 files and sublevels is observed.
+const l0TrajectoryWindow = time.Minute
+
+// l0TrajectorySamples is the maximum number of L0 samples retained.
+const l0TrajectorySamples = 64
+
+// CompactionDebtEstimate describes the compaction debt of a DB, the estimated
+// time to compact it, and the projected growth of L0. It may be used by
+// load-balancing layers to shed traffic before writes stall.
+type CompactionDebtEstimate struct {
+	// Debt is an estimate of the number of bytes that need to be compacted
+	// for the LSM to reach a stable state. It is equal to
+	// Metrics.Compact.EstimatedDebt.
+	Debt uint64
+	// Throughput is the observed rate, in bytes per second, at which
+	// compactions have written sstables since the DB was opened. It is zero
+	// if no compaction has completed.
+	Throughput float64
+	// TimeToDrain is the estimated time to compact Debt at Throughput. It is
+	// zero if there is no debt, and math.MaxInt64 if Throughput is zero.
+	TimeToDrain time.Duration
+
+	// L0Files and L0Sublevels are the current number of files and sublevels
+	// in L0.
+	L0Files     int
+	L0Sublevels int
+	// L0FilesRate and L0SublevelsRate are the observed rates of change, per
+	// second, of the number of files and sublevels in L0 over the last
+	// minute. Positive rates indicate that L0 is growing.
+	L0FilesRate     float64
+	L0SublevelsRate float64
+	// TimeToL0Stall is the projected time until the number of L0 sublevels
+	// reaches Options.L0StopWritesThreshold, at which point writes stall. It
+	// is zero if writes are already stalled, and math.MaxInt64 if L0 is not
+	// growing.
+	TimeToL0Stall time.Duration
+}
+
+// ProjectedL0Files returns the projected number of L0 files after the
+// duration d, assuming the number of files continues to change at
+// L0FilesRate.
+func (e CompactionDebtEstimate) ProjectedL0Files(d time.Duration) int {
+	n := float64(e.L0Files) + e.L0FilesRate*d.Seconds()
+	if n < 0 {
+		return 0
+	}
+	return int(math.Round(n))
+}
+
+// CompactionDebt returns an estimate of the current compaction debt, the time
+// to compact it at the observed compaction throughput, and the projected
+// trajectory of L0.
+func (d *DB) CompactionDebt() CompactionDebtEstimate {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var e CompactionDebtEstimate
+	e.Debt = d.mu.versions.picker.estimatedCompactionDebt(0)
+	var bytesCompacted uint64
+	for _, m := range d.mu.versions.metrics.Levels {
+		bytesCompacted += m.BytesCompacted
+	}
+	if secs := d.mu.compact.duration.Seconds(); secs > 0 {
+		e.Throughput = float64(bytesCompacted) / secs
+	}
+	switch {
+	case e.Debt == 0:
+	case e.Throughput == 0:
+		e.TimeToDrain = math.MaxInt64
+	default:
+		e.TimeToDrain = durationFromSeconds(float64(e.Debt) / e.Throughput)
+	}
+
+	vers := d.mu.versions.currentVersion()
+	e.L0Files = vers.Levels[0].Len()
+	e.L0Sublevels = len(vers.L0Sublevels.Levels)
+	e.L0FilesRate, e.L0SublevelsRate = d.mu.compact.l0Trajectory.rates(e.L0Files, e.L0Sublevels)
+	switch remaining := d.opts.L0StopWritesThreshold - e.L0Sublevels; {
+	case remaining <= 0:
+	case e.L0SublevelsRate <= 0:
+		e.TimeToL0Stall = math.MaxInt64
+	default:
+		e.TimeToL0Stall = durationFromSeconds(float64(remaining) / e.L0SublevelsRate)
+	}
+	return e
+}
+
+// durationFromSeconds converts secs to a duration, saturating at
+// math.MaxInt64.
+func durationFromSeconds(secs float64) time.Duration {
+	if secs >= float64(math.MaxInt64)/float64(time.Second) {
+		return math.MaxInt64
+	}
+	return time.Duration(secs * float64(time.Second))
+}
+
+// l0Sample records the number of L0 files and sublevels at a point in time.
+type l0Sample struct {
+	time      time.Time
+	files     int
+	sublevels int
+}
+
+// l0Trajectory is a ring buffer of L0 samples, used to observe the rate at
+// which L0 grows or shrinks. A sample is recorded only when the number of L0
+// files or sublevels changes.
+type l0Trajectory struct {
+	// timeNow is the clock used to timestamp samples. It is separate from
+	// DB.timeNow so that sampling does not perturb clocks injected by tests.
+	timeNow func() time.Time
+	samples [l0TrajectorySamples]l0Sample
+	// start is the index of the oldest sample, and n the number of samples.
+	start, n int
+}
+
+func (t *l0Trajectory) record(files, sublevels int) {
+	if t.n > 0 {
+		last := &t.samples[(t.start+t.n-1)%l0TrajectorySamples]
+		if last.files == files && last.sublevels == sublevels {
+			return
+		}
+	}
+	s := l0Sample{time: t.timeNow(), files: files, sublevels: sublevels}
+	if t.n < l0TrajectorySamples {
+		t.samples[(t.start+t.n)%l0TrajectorySamples] = s
+		t.n++
+		return
+	}
+	t.samples[t.start] = s
+	t.start = (t.start + 1) % l0TrajectorySamples
+}
+
+// rates returns the rates of change, per second, of the number of L0 files
+// and sublevels over l0TrajectoryWindow, given the current counts. If the
+// samples span less than the window, the rates are computed since the oldest
+// sample.
+func (t *l0Trajectory) rates(files, sublevels int) (filesRate, sublevelsRate float64) {
+	if t.n == 0 {
+		return 0, 0
+	}
+	// Find the sample in effect at the start of the window.
+	now := t.timeNow()
+	windowStart := now.Add(-l0TrajectoryWindow)
+	base := t.samples[t.start]
+	for i := 1; i < t.n; i++ {
+		s := t.samples[(t.start+i)%l0TrajectorySamples]
+		if s.time.After(windowStart) {
+			break
+		}
+		base = s
+	}
+	if base.time.Before(windowStart) {
+		base.time = windowStart
+	}
+	secs := now.Sub(base.time).Seconds()
+	if secs <= 0 {
+		return 0, 0
+	}
+	return float64(files-base.files) / secs, float64(sublevels-base.sublevels) / secs
+}
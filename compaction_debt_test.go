@@ -0,0 +1,89 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompactionDebt(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+		L0CompactionThreshold:       2,
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	writeKeys := func() {
+		for j := 0; j < 100; j++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("key%03d", j)), []byte("val"), nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+	writeKeys()
+	require.NoError(t, d.Compact([]byte("key"), []byte("kez"), false))
+
+	// Replace the clock used to observe L0 with one that only advances when
+	// instructed.
+	now := time.Now()
+	d.mu.Lock()
+	d.mu.compact.l0Trajectory.timeNow = func() time.Time { return now }
+	d.mu.Unlock()
+	advance := func(dur time.Duration) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		now = now.Add(dur)
+	}
+
+	e := d.CompactionDebt()
+	require.Zero(t, e.Debt)
+	require.Zero(t, e.TimeToDrain)
+	require.Zero(t, e.L0Files)
+	require.Equal(t, time.Duration(math.MaxInt64), e.TimeToL0Stall)
+
+	// Flush an overlapping sstable into L0 every 10 seconds.
+	for i := 0; i < 3; i++ {
+		advance(10 * time.Second)
+		writeKeys()
+	}
+	e = d.CompactionDebt()
+	require.NotZero(t, e.Debt)
+	require.Zero(t, e.Throughput)
+	require.Equal(t, time.Duration(math.MaxInt64), e.TimeToDrain)
+	require.Equal(t, 3, e.L0Files)
+	require.Equal(t, 3, e.L0Sublevels)
+	require.InDelta(t, 0.1, e.L0FilesRate, 0.001)
+	require.InDelta(t, 0.1, e.L0SublevelsRate, 0.001)
+	require.InDelta(t, float64(90*time.Second), float64(e.TimeToL0Stall), float64(time.Second))
+	require.Equal(t, 9, e.ProjectedL0Files(time.Minute))
+
+	// Once L0 stops changing for longer than the observation window, it is
+	// no longer projected to grow.
+	advance(2 * time.Minute)
+	e = d.CompactionDebt()
+	require.Zero(t, e.L0FilesRate)
+	require.Equal(t, time.Duration(math.MaxInt64), e.TimeToL0Stall)
+	require.Equal(t, 3, e.ProjectedL0Files(time.Hour))
+
+	// Compacting L0 drains the debt.
+	advance(time.Second)
+	require.NoError(t, d.Compact([]byte("key"), []byte("kez"), false))
+	advance(30 * time.Second)
+	e = d.CompactionDebt()
+	require.Zero(t, e.Debt)
+	require.Zero(t, e.TimeToDrain)
+	require.Zero(t, e.L0Files)
+	require.Less(t, e.L0FilesRate, 0.0)
+	require.Zero(t, e.ProjectedL0Files(time.Hour))
+}
@@ -0,0 +1,65 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+// CompactionFilterDecision is the decision returned by a CompactionFilter for
+// a key-value pair.
+type CompactionFilterDecision int8
+
+const (
+	// CompactionFilterKeep retains the key-value pair unchanged.
+	CompactionFilterKeep CompactionFilterDecision = iota
+	// CompactionFilterRemove deletes the key. The key is replaced by a point
+	// tombstone, so that it also shadows any older versions of the key that
+	// are not part of the compaction. The tombstone is elided when nothing
+	// below the compaction's output level can contain the key.
+	CompactionFilterRemove
+	// CompactionFilterChangeValue replaces the value of the key with the value
+	// returned by the filter.
+	CompactionFilterChangeValue
+)
+
+// String implements fmt.Stringer.
+func (d CompactionFilterDecision) String() string {
+	switch d {
+	case CompactionFilterKeep:
+		return "keep"
+	case CompactionFilterRemove:
+		return "remove"
+	case CompactionFilterChangeValue:
+		return "change-value"
+	default:
+		return "unknown"
+	}
+}
+
+// CompactionFilter is invoked for point key-value pairs as they are rewritten
+// by a compaction into the provided output level. It returns whether to keep
+// the pair, remove the key, or change its value, in which case newValue holds
+// the new value. The key and value must not be retained or modified; newValue
+// is copied before the filter is next invoked.
+//
+// The filter is subject to the following restrictions:
+//
+//   - It is only invoked for the most recent version of a key written with SET
+//     within a compaction. MERGE operands, the result of merging them, and
+//     tombstones are never filtered.
+//   - It is not invoked for keys that are visible to an open snapshot, so a
+//     snapshot never observes a filtered value. Older versions of the key that
+//     are visible to snapshots are retained, even if the newest version is
+//     removed.
+//   - It is not invoked during flushes. A key is only filtered once a
+//     compaction rewrites it, so filtering is an eventual process: readers may
+//     observe a key the filter would remove until then. Automatic compactions
+//     may move a file to another level without rewriting it, but manual
+//     compactions (i.e. DB.Compact) always rewrite the files they compact, so
+//     that their keys are filtered.
+//
+// A value changed by the filter is passed to the filter again whenever the key
+// is compacted later. The filter is invoked concurrently from multiple
+// compactions, and must be safe for concurrent use.
+type CompactionFilter func(
+	level int, key, value []byte,
+) (decision CompactionFilterDecision, newValue []byte)
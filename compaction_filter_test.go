@@ -0,0 +1,129 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompactionFilter(t *testing.T) {
+	var mu sync.Mutex
+	filtered := make(map[string]int)
+	opts := &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+		CompactionFilter: func(level int, key, value []byte) (CompactionFilterDecision, []byte) {
+			mu.Lock()
+			defer mu.Unlock()
+			require.Equal(t, numLevels-1, level)
+			filtered[string(key)]++
+			switch {
+			case bytes.Equal(value, []byte("drop")):
+				return CompactionFilterRemove, nil
+			case bytes.Equal(value, []byte("change")):
+				return CompactionFilterChangeValue, []byte("changed")
+			}
+			return CompactionFilterKeep, nil
+		},
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	require.NoError(t, d.Set([]byte("e"), []byte("drop"), nil))
+	snap := d.NewSnapshot()
+	require.NoError(t, d.Set([]byte("a"), []byte("keep"), nil))
+	require.NoError(t, d.Set([]byte("b"), []byte("drop"), nil))
+	require.NoError(t, d.Set([]byte("c"), []byte("change"), nil))
+	require.NoError(t, d.Merge([]byte("d"), []byte("drop"), nil))
+	require.NoError(t, d.Set([]byte("f"), []byte("drop"), nil))
+
+	// Flushes do not invoke the filter. Flush two overlapping files so that
+	// compacting them rewrites the keys rather than moving the files.
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Set([]byte("a"), []byte("keep"), nil))
+	require.NoError(t, d.Set([]byte("z"), []byte("keep"), nil))
+	require.NoError(t, d.Flush())
+	require.Empty(t, filtered)
+	verifyGet(t, d, []byte("b"), []byte("drop"))
+
+	require.NoError(t, d.Compact([]byte("a"), []byte("z"), false))
+	verifyGet(t, d, []byte("a"), []byte("keep"))
+	verifyGetNotFound(t, d, []byte("b"))
+	verifyGet(t, d, []byte("c"), []byte("changed"))
+	// MERGE operands are not filtered.
+	verifyGet(t, d, []byte("d"), []byte("drop"))
+	// Keys visible to the snapshot are not filtered, but later keys are.
+	verifyGet(t, d, []byte("e"), []byte("drop"))
+	verifyGetNotFound(t, d, []byte("f"))
+	v, closer, err := snap.Get([]byte("e"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("drop"), v)
+	require.NoError(t, closer.Close())
+	require.Equal(t, map[string]int{"a": 1, "b": 1, "c": 1, "f": 1, "z": 1}, filtered)
+
+	// Once the snapshot is closed the key is filtered by the next compaction
+	// that rewrites it, and the changed value is passed to the filter again.
+	require.NoError(t, snap.Close())
+	require.NoError(t, d.manualCompact(context.Background(), []byte("a"), []byte("z"), numLevels-1,
+		&CompactOptions{}, &ManualCompactionProgress{}))
+	verifyGetNotFound(t, d, []byte("e"))
+	verifyGet(t, d, []byte("c"), []byte("changed"))
+	require.Equal(t, map[string]int{"a": 2, "b": 1, "c": 2, "e": 1, "f": 1, "z": 2}, filtered)
+}
+
+// TestCompactionFilterMove verifies that a file that would otherwise be moved
+// to the next level is rewritten so that its keys are filtered.
+func TestCompactionFilterMove(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+		CompactionFilter: func(level int, key, value []byte) (CompactionFilterDecision, []byte) {
+			if bytes.Equal(value, []byte("drop")) {
+				return CompactionFilterRemove, nil
+			}
+			return CompactionFilterKeep, nil
+		},
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	require.NoError(t, d.Set([]byte("a"), []byte("keep"), nil))
+	require.NoError(t, d.Set([]byte("b"), []byte("drop"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("a"), []byte("b\x00"), false))
+	verifyGet(t, d, []byte("a"), []byte("keep"))
+	verifyGetNotFound(t, d, []byte("b"))
+	require.Zero(t, d.Metrics().Compact.MoveCount)
+
+	// Automatic compactions move the file without filtering its keys.
+	d.mu.Lock()
+	d.opts.DisableAutomaticCompactions = false
+	d.opts.L0CompactionThreshold = 1
+	d.mu.Unlock()
+	require.NoError(t, d.Set([]byte("c"), []byte("drop"), nil))
+	require.NoError(t, d.Flush())
+	require.Eventually(t, func() bool {
+		return d.Metrics().Compact.MoveCount == 1
+	}, 10*time.Second, time.Millisecond)
+	verifyGet(t, d, []byte("c"), []byte("drop"))
+}
+
+func TestCompactionFilterDecisionString(t *testing.T) {
+	require.Equal(t, "keep", CompactionFilterKeep.String())
+	require.Equal(t, "remove", CompactionFilterRemove.String())
+	require.Equal(t, "change-value", CompactionFilterChangeValue.String())
+}
@@ -263,9 +263,38 @@ type compactionIter struct {
 	// The on-disk format major version. This informs the types of keys that
 	// may be written to disk during a compaction.
 	formatVersion FormatMajorVersion
-	stats         struct {
+	// predicateDeletions holds the in-progress DeleteRangeIf operations
+	// observed when the compaction began. A SET that is deleted by one of the
+	// predicate deletions is transformed into a DEL.
+	predicateDeletions []*predicateDeletion
+	// filter is the user's compaction filter, or nil if keys are not filtered
+	// by this compaction. filterLevel is the level passed to the filter and
+	// filterBuf holds the value returned by the filter.
+	filter      CompactionFilter
+	filterLevel int
+	filterBuf   []byte
+	// keepBlobHandle, if set, returns true if the compaction carries the
+	// handle of a value stored in a blob file over to its output, rather than
+	// the value. iterIsBlob is true if iterValue holds such a handle, in which
+	// case iterBlob holds the handle's fetcher and the value's attribute and
+	// length, and iterBlobBuf holds the value once it's fetched.
+	keepBlobHandle func(v LazyValue) bool
+	iterIsBlob     bool
+	iterBlob       base.LazyFetcher
+	iterBlobBuf    []byte
+	iterBlobValue  []byte
+	// valueIsBlob is true if the value returned by the last call to First or
+	// Next is the handle of a value stored in a blob file, in which case
+	// valueAttr holds the value's attribute and length.
+	valueIsBlob bool
+	valueAttr   base.AttributeAndLen
+	cmp         Compare
+	stats       struct {
 		// count of DELSIZED keys that were missized.
 		countMissizedDels uint64
+		// count of SETs deleted by a predicate deletion or the compaction
+		// filter, or whose value was changed by the compaction filter.
+		countRewrittenKeys uint64
 	}
 }
 
@@ -285,6 +314,7 @@ func newCompactionIter(
 ) *compactionIter {
 	i := &compactionIter{
 		equal:               equal,
+		cmp:                 cmp,
 		merge:               merge,
 		iter:                iter,
 		snapshots:           snapshots,
@@ -311,7 +341,7 @@ func (i *compactionIter) First() (*InternalKey, []byte) {
 	}
 	var iterValue LazyValue
 	i.iterKey, iterValue = i.iter.First()
-	i.iterValue, _, i.err = iterValue.Value(nil)
+	i.setIterValue(iterValue)
 	if i.err != nil {
 		return nil, nil
 	}
@@ -352,6 +382,7 @@ func (i *compactionIter) Next() (*InternalKey, []byte) {
 
 	i.pos = iterPosCurForward
 	i.valid = false
+	i.valueIsBlob = false
 
 	for i.iterKey != nil {
 		// If we entered a new snapshot stripe with the same key, any key we
@@ -461,6 +492,30 @@ func (i *compactionIter) Next() (*InternalKey, []byte) {
 			}
 
 		case InternalKeyKindSet, InternalKeyKindSetWithDelete:
+			deleted := i.deletedByPredicate() || i.removedByFilter()
+			if i.err != nil {
+				i.valid = false
+				return nil, nil
+			}
+			if deleted {
+				i.stats.countRewrittenKeys++
+				// The SET is deleted by a DeleteRangeIf or by the compaction
+				// filter. Emit a DEL in its place so that the deletion also
+				// shadows any older versions of the key outside of the
+				// compaction, or elide the key entirely if the tombstone
+				// itself can be elided.
+				if i.elideTombstone(i.iterKey.UserKey) && i.curSnapshotIdx == 0 {
+					i.saveKey()
+					i.skipInStripe()
+					continue
+				}
+				i.saveKey()
+				i.key.SetKind(InternalKeyKindDelete)
+				i.value = nil
+				i.valid = true
+				i.skip = true
+				return &i.key, i.value
+			}
 			// The key we emit for this entry is a function of the current key
 			// kind, and whether this entry is followed by a DEL/SINGLEDEL
 			// entry. setNext() does the work to move the iterator forward,
@@ -526,6 +581,86 @@ func (i *compactionIter) Next() (*InternalKey, []byte) {
 	return nil, nil
 }
 
+// deletedByPredicate returns true if the current point key is deleted by one
+// of the compaction's predicate deletions.
+func (i *compactionIter) deletedByPredicate() bool {
+	for _, pd := range i.predicateDeletions {
+		if !pd.covers(i.cmp, i.iterKey, i.curSnapshotSeqNum) {
+			continue
+		}
+		value, ok := i.fetchIterValue()
+		if !ok {
+			return false
+		}
+		if pd.match(i.iterKey.UserKey, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// removedByFilter invokes the compaction filter on the current SET, returning
+// true if the filter removes the key. If the filter changes the value,
+// i.iterValue is replaced by the new value.
+func (i *compactionIter) removedByFilter() bool {
+	// Keys visible to an open snapshot are never filtered.
+	if i.filter == nil || i.curSnapshotSeqNum != InternalKeySeqNumMax {
+		return false
+	}
+	value, ok := i.fetchIterValue()
+	if !ok {
+		return false
+	}
+	decision, newValue := i.filter(i.filterLevel, i.iterKey.UserKey, value)
+	switch decision {
+	case CompactionFilterRemove:
+		return true
+	case CompactionFilterChangeValue:
+		i.stats.countRewrittenKeys++
+		i.filterBuf = append(i.filterBuf[:0], newValue...)
+		i.iterValue = i.filterBuf
+		i.iterIsBlob = false
+	}
+	return false
+}
+
+// setIterValue sets iterValue to the value of the current input key. The
+// handle of a value stored in a blob file is kept in place of the value if
+// keepBlobHandle allows it.
+func (i *compactionIter) setIterValue(v LazyValue) {
+	i.iterIsBlob = false
+	if i.keepBlobHandle != nil && i.iterKey != nil && i.keepBlobHandle(v) {
+		i.iterIsBlob = true
+		i.iterValue = v.ValueOrHandle
+		i.iterBlob = *v.Fetcher
+		i.iterBlobValue = nil
+		return
+	}
+	i.iterValue, _, i.err = v.Value(nil)
+}
+
+// fetchIterValue returns the value of the current input key, fetching it
+// from its blob file if iterValue holds its handle. It returns false if the
+// value could not be fetched, in which case i.err is set.
+func (i *compactionIter) fetchIterValue() ([]byte, bool) {
+	if !i.iterIsBlob {
+		return i.iterValue, true
+	}
+	if i.iterBlobValue == nil {
+		var callerOwned bool
+		i.iterBlobValue, callerOwned, i.err = i.iterBlob.Fetcher.Fetch(
+			i.iterValue, i.iterBlob.Attribute.ValueLen, i.iterBlobBuf)
+		if i.err != nil {
+			i.iterBlobValue = nil
+			return nil, false
+		}
+		if callerOwned {
+			i.iterBlobBuf = i.iterBlobValue[:0]
+		}
+	}
+	return i.iterBlobValue, true
+}
+
 func (i *compactionIter) closeValueCloser() error {
 	if i.valueCloser == nil {
 		return nil
@@ -568,7 +703,7 @@ func (i *compactionIter) skipInStripe() {
 func (i *compactionIter) iterNext() bool {
 	var iterValue LazyValue
 	i.iterKey, iterValue = i.iter.Next()
-	i.iterValue, _, i.err = iterValue.Value(nil)
+	i.setIterValue(iterValue)
 	if i.err != nil {
 		i.iterKey = nil
 	}
@@ -664,6 +799,8 @@ func (i *compactionIter) setNext() {
 	i.saveKey()
 	i.value = i.iterValue
 	i.valid = true
+	i.valueIsBlob = i.iterIsBlob
+	i.valueAttr = i.iterBlob.Attribute
 	i.maybeZeroSeqnum(i.curSnapshotIdx)
 
 	// There are two cases where we can early return and skip the remaining
@@ -795,7 +932,12 @@ func (i *compactionIter) mergeNext(valueMerger ValueMerger) stripeChangeType {
 			// value and return. We change the kind of the resulting key to a
 			// Set so that it shadows keys in lower levels. That is:
 			// MERGE + (SET*) -> SET.
-			i.err = valueMerger.MergeOlder(i.iterValue)
+			value, ok := i.fetchIterValue()
+			if !ok {
+				i.valid = false
+				return sameStripeSkippable
+			}
+			i.err = valueMerger.MergeOlder(value)
 			if i.err != nil {
 				i.valid = false
 				return sameStripeSkippable
@@ -967,6 +1109,9 @@ func (i *compactionIter) deleteSizedNext() (*base.InternalKey, []byte) {
 				return nil, nil
 			}
 			elidedSize := uint64(len(i.iterKey.UserKey)) + uint64(len(i.iterValue))
+			if i.iterIsBlob {
+				elidedSize = uint64(len(i.iterKey.UserKey)) + uint64(i.iterBlob.Attribute.ValueLen)
+			}
 			if elidedSize != expectedSize {
 				// The original DELSIZED key was missized. It's unclear what to
 				// do. The user-provided size was wrong, so it's unlikely to be
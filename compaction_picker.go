@@ -10,6 +10,7 @@ import (
 	"math"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/humanize"
@@ -25,6 +26,23 @@ type compactionEnv struct {
 	earliestSnapshotSeqNum  uint64
 	inProgressCompactions   []compactionInfo
 	readCompactionEnv       readCompactionEnv
+	// hotRanges holds the key ranges suggested by DB.SuggestCompactRange.
+	// The picker removes ranges whose read amplification has been reduced to
+	// a single sstable.
+	hotRanges *[]KeyRange
+	// now is the time at which the compaction is picked, used to determine
+	// whether keys have expired under Options.TTL.
+	now time.Time
+	// tiering, if set, holds the state used to pick compactions that migrate
+	// sstables under Options.Experimental.Tiering.
+	tiering *tieringEnv
+	// blobFilesToRewrite holds the blob files whose fraction of live values
+	// is below Options.Experimental.ValueSeparation.MinLiveRatio.
+	blobFilesToRewrite map[base.DiskFileNum]struct{}
+	// diskSpaceLow is true if the free disk space is below
+	// Options.DiskSpaceSoftLimit, in which case read-triggered compactions are
+	// not picked.
+	diskSpaceLow bool
 }
 
 type compactionPicker interface {
@@ -35,6 +53,7 @@ type compactionPicker interface {
 	pickAuto(env compactionEnv) (pc *pickedCompaction)
 	pickManual(env compactionEnv, manual *manualCompaction) (c *pickedCompaction, retryLater bool)
 	pickElisionOnlyCompaction(env compactionEnv) (pc *pickedCompaction)
+	pickSnapshotElisionCompaction(env compactionEnv, f *fileMetadata) (pc *pickedCompaction)
 	pickRewriteCompaction(env compactionEnv) (pc *pickedCompaction)
 	pickReadTriggeredCompaction(env compactionEnv) (pc *pickedCompaction)
 	forceBaseLevel1()
@@ -154,6 +173,12 @@ type pickedCompaction struct {
 
 	// kind indicates the kind of compaction.
 	kind compactionKind
+	// demote is true if a tiering compaction creates its outputs on remote
+	// storage, and false if it creates them on local storage.
+	demote bool
+	// rewriteBlobFiles holds the blob files garbage collected by a blob
+	// rewrite compaction.
+	rewriteBlobFiles map[base.DiskFileNum]struct{}
 
 	// startLevel is the level that is being compacted. Inputs from startLevel
 	// and outputLevel will be merged to produce a set of outputLevel files.
@@ -1095,7 +1120,7 @@ func (p *compactionPickerByScore) calculateL0Score(
 }
 
 func (p *compactionPickerByScore) pickFile(
-	level, outputLevel int, earliestSnapshotSeqNum uint64,
+	level, outputLevel int, earliestSnapshotSeqNum uint64, hotRanges []KeyRange,
 ) (manifest.LevelFile, bool) {
 	// Select the file within the level to compact. We want to minimize write
 	// amplification, but also ensure that deletes are propagated to the
@@ -1115,6 +1140,10 @@ func (p *compactionPickerByScore) pickFile(
 	// differs from RocksDB which only compensates for point tombstones and
 	// only if they exceed the number of non-deletion entries in table.
 	//
+	// Files overlapping a hot key range suggested through
+	// DB.SuggestCompactRange are preferred over all other files, so that
+	// compactions reduce the read amplification of hot key ranges first.
+	//
 	// TODO(peter): For concurrent compactions, we may want to try harder to
 	// pick a seed file whose resulting compaction bounds do not overlap with
 	// an in-progress compaction.
@@ -1123,8 +1152,9 @@ func (p *compactionPickerByScore) pickFile(
 	startIter := p.vers.Levels[level].Iter()
 	outputIter := p.vers.Levels[outputLevel].Iter()
 
-	var file manifest.LevelFile
+	var file, hotFile manifest.LevelFile
 	smallestRatio := uint64(math.MaxUint64)
+	smallestHotRatio := uint64(math.MaxUint64)
 
 	outputFile := outputIter.First()
 
@@ -1206,10 +1236,27 @@ func (p *compactionPickerByScore) pickFile(
 			smallestRatio = scaledRatio
 			file = startIter.Take()
 		}
+		if scaledRatio < smallestHotRatio && overlapsKeyRanges(cmp, hotRanges, f) {
+			smallestHotRatio = scaledRatio
+			hotFile = startIter.Take()
+		}
+	}
+	if hotFile.FileMetadata != nil {
+		return hotFile, true
 	}
 	return file, file.FileMetadata != nil
 }
 
+// overlapsKeyRanges returns true if the file f overlaps any of the key ranges.
+func overlapsKeyRanges(cmp Compare, ranges []KeyRange, f *fileMetadata) bool {
+	for i := range ranges {
+		if ranges[i].Overlaps(cmp, f) {
+			return true
+		}
+	}
+	return false
+}
+
 // pickAuto picks the best compaction, if any.
 //
 // On each call, pickAuto computes per-level size adjustments based on
@@ -1220,6 +1267,12 @@ func (p *compactionPickerByScore) pickFile(
 // If a score-based compaction cannot be found, pickAuto falls back to looking
 // for an elision-only compaction to remove obsolete keys.
 func (p *compactionPickerByScore) pickAuto(env compactionEnv) (pc *pickedCompaction) {
+	if p.opts.CompactionPolicy != nil {
+		if pc, ok := p.pickPolicyCompaction(env); ok {
+			return pc
+		}
+	}
+
 	// Compaction concurrency is controlled by L0 read-amp. We allow one
 	// additional compaction per L0CompactionConcurrency sublevels, as well as
 	// one additional compaction per CompactionDebtConcurrency bytes of
@@ -1321,7 +1374,11 @@ func (p *compactionPickerByScore) pickAuto(env compactionEnv) (pc *pickedCompact
 
 		// info.level > 0
 		var ok bool
-		info.file, ok = p.pickFile(info.level, info.outputLevel, env.earliestSnapshotSeqNum)
+		var hotRanges []KeyRange
+		if env.hotRanges != nil {
+			hotRanges = *env.hotRanges
+		}
+		info.file, ok = p.pickFile(info.level, info.outputLevel, env.earliestSnapshotSeqNum, hotRanges)
 		if !ok {
 			continue
 		}
@@ -1338,6 +1395,11 @@ func (p *compactionPickerByScore) pickAuto(env compactionEnv) (pc *pickedCompact
 		}
 	}
 
+	// Check for hot key ranges whose reads must consult multiple sstables.
+	if pc := p.pickHotRangeCompaction(env); pc != nil {
+		return pc
+	}
+
 	// Check for L6 files with tombstones that may be elided. These files may
 	// exist if a snapshot prevented the elision of a tombstone or because of
 	// a move compaction. These are low-priority compactions because they
@@ -1346,6 +1408,33 @@ func (p *compactionPickerByScore) pickAuto(env compactionEnv) (pc *pickedCompact
 		return pc
 	}
 
+	// Check for files whose keys have all expired under Options.TTL. Like
+	// elision-only compactions, these only reclaim disk space.
+	if pc := p.pickTTLCompaction(env); pc != nil {
+		return pc
+	}
+
+	// Check for files that have not been rewritten within
+	// Options.PeriodicCompactionInterval.
+	if pc := p.pickPeriodicCompaction(env); pc != nil {
+		return pc
+	}
+
+	// Check for files whose entries are mostly tombstones.
+	if pc := p.pickTombstoneDensityCompaction(env); pc != nil {
+		return pc
+	}
+
+	// Check for files to migrate between local and remote storage.
+	if pc := p.pickTieringCompaction(env); pc != nil {
+		return pc
+	}
+
+	// Check for files referencing blob files to garbage collect.
+	if pc := p.pickBlobRewriteCompaction(env); pc != nil {
+		return pc
+	}
+
 	if pc := p.pickReadTriggeredCompaction(env); pc != nil {
 		return pc
 	}
@@ -1390,6 +1479,28 @@ func (p *compactionPickerByScore) pickAuto(env compactionEnv) (pc *pickedCompact
 	return nil
 }
 
+// elisionOnlyWorthwhile returns true if the bottommost file f contains enough
+// obsolete keys to be worth an elision-only compaction. f's stats must be
+// valid.
+func elisionOnlyWorthwhile(f *fileMetadata) bool {
+	// Bottommost files are large and not worthwhile to compact just
+	// to remove a few tombstones. Consider a file ineligible if its
+	// own range deletions delete less than 10% of its data and its
+	// deletion tombstones make up less than 10% of its entries.
+	//
+	// TODO(jackson): This does not account for duplicate user keys
+	// which may be collapsed. Ideally, we would have 'obsolete keys'
+	// statistics that would include tombstones, the keys that are
+	// dropped by tombstones and duplicated user keys. See #847.
+	//
+	// Note that tables that contain exclusively range keys (i.e. no point keys,
+	// `NumEntries` and `RangeDeletionsBytesEstimate` are both zero) are excluded
+	// from elision-only compactions.
+	// TODO(travers): Consider an alternative heuristic for elision of range-keys.
+	return f.Stats.RangeDeletionsBytesEstimate*10 >= f.Size ||
+		f.Stats.NumDeletions*10 > f.Stats.NumEntries
+}
+
 // elisionOnlyAnnotator implements the manifest.Annotator interface,
 // annotating B-Tree nodes with the *fileMetadata of a file meeting the
 // obsolete keys criteria for an elision-only compaction within the subtree.
@@ -1412,22 +1523,7 @@ func (a elisionOnlyAnnotator) Accumulate(f *fileMetadata, dst interface{}) (inte
 	if !f.StatsValid() {
 		return dst, false
 	}
-	// Bottommost files are large and not worthwhile to compact just
-	// to remove a few tombstones. Consider a file ineligible if its
-	// own range deletions delete less than 10% of its data and its
-	// deletion tombstones make up less than 10% of its entries.
-	//
-	// TODO(jackson): This does not account for duplicate user keys
-	// which may be collapsed. Ideally, we would have 'obsolete keys'
-	// statistics that would include tombstones, the keys that are
-	// dropped by tombstones and duplicated user keys. See #847.
-	//
-	// Note that tables that contain exclusively range keys (i.e. no point keys,
-	// `NumEntries` and `RangeDeletionsBytesEstimate` are both zero) are excluded
-	// from elision-only compactions.
-	// TODO(travers): Consider an alternative heuristic for elision of range-keys.
-	if f.Stats.RangeDeletionsBytesEstimate*10 < f.Size &&
-		f.Stats.NumDeletions*10 <= f.Stats.NumEntries {
+	if !elisionOnlyWorthwhile(f) {
 		return dst, true
 	}
 	if dst == nil {
@@ -1455,6 +1551,51 @@ func (a elisionOnlyAnnotator) Merge(v interface{}, accum interface{}) interface{
 	return accumV
 }
 
+// ttlAnnotator implements the manifest.Annotator interface, annotating B-Tree
+// nodes with the *fileMetadata of the file with the lowest
+// Stats.TimestampUpperBound within the subtree, that is the file whose keys
+// expire first under Options.TTL. Files without timestamped keys are ignored.
+type ttlAnnotator struct{}
+
+var _ manifest.Annotator = ttlAnnotator{}
+
+func (a ttlAnnotator) Zero(interface{}) interface{} {
+	return nil
+}
+
+func (a ttlAnnotator) Accumulate(f *fileMetadata, dst interface{}) (interface{}, bool) {
+	if f.IsCompacting() {
+		return dst, true
+	}
+	if !f.StatsValid() {
+		return dst, false
+	}
+	if f.Stats.TimestampUpperBound == 0 {
+		return dst, true
+	}
+	if dst == nil {
+		return f, true
+	} else if dstV := dst.(*fileMetadata); dstV.Stats.TimestampUpperBound > f.Stats.TimestampUpperBound {
+		return f, true
+	}
+	return dst, true
+}
+
+func (a ttlAnnotator) Merge(v interface{}, accum interface{}) interface{} {
+	if v == nil {
+		return accum
+	}
+	if accum == nil {
+		return v
+	}
+	f := v.(*fileMetadata)
+	accumV := accum.(*fileMetadata)
+	if accumV.Stats.TimestampUpperBound > f.Stats.TimestampUpperBound {
+		return f
+	}
+	return accumV
+}
+
 // markedForCompactionAnnotator implements the manifest.Annotator interface,
 // annotating B-Tree nodes with the *fileMetadata of a file that is marked for
 // compaction within the subtree. If multiple files meet the criteria, it
@@ -1515,7 +1656,35 @@ func (p *compactionPickerByScore) pickElisionOnlyCompaction(
 	if lf == nil {
 		panic(fmt.Sprintf("file %s not found in level %d as expected", candidate.FileNum, numLevels-1))
 	}
+	return p.newElisionOnlyCompaction(env, lf)
+}
+
+// pickSnapshotElisionCompaction constructs an elision-only compaction of the
+// bottommost file f, which was queued when the earliest snapshot advanced past
+// its LargestSeqNum. It returns nil if f is no longer part of the bottommost
+// level of the current version or is already being compacted.
+func (p *compactionPickerByScore) pickSnapshotElisionCompaction(
+	env compactionEnv, f *fileMetadata,
+) (pc *pickedCompaction) {
+	if p.opts.private.disableElisionOnlyCompactions {
+		return nil
+	}
+	if f.IsCompacting() || f.LargestSeqNum >= env.earliestSnapshotSeqNum {
+		return nil
+	}
+	lf := p.vers.Levels[numLevels-1].Find(p.opts.Comparer.Compare, f)
+	if lf == nil {
+		return nil
+	}
+	return p.newElisionOnlyCompaction(env, lf)
+}
 
+// newElisionOnlyCompaction constructs an elision-only compaction of the
+// bottommost file lf, or returns nil if the compaction would conflict with an
+// in-progress compaction.
+func (p *compactionPickerByScore) newElisionOnlyCompaction(
+	env compactionEnv, lf *manifest.LevelFile,
+) (pc *pickedCompaction) {
 	// Construct a picked compaction of the elision candidate's atomic
 	// compaction unit.
 	pc = newPickedCompaction(p.opts, p.vers, numLevels-1, numLevels-1, p.baseLevel)
@@ -1533,6 +1702,324 @@ func (p *compactionPickerByScore) pickElisionOnlyCompaction(
 	return nil
 }
 
+// pickTTLCompaction looks for compactions of sstables in L1 and below whose
+// keys have all expired under Options.TTL. Files in the bottommost level are
+// rewritten in place, and files in other levels are compacted into the next
+// level, where the expired keys are removed. Files in L0 are left to be
+// compacted by score-based compactions.
+func (p *compactionPickerByScore) pickTTLCompaction(env compactionEnv) (pc *pickedCompaction) {
+	ttl := &p.opts.TTL
+	if !ttl.enabled() {
+		return nil
+	}
+	for l := numLevels - 1; l > 0; l-- {
+		v := p.vers.Levels[l].Annotation(ttlAnnotator{})
+		if v == nil {
+			continue
+		}
+		candidate := v.(*fileMetadata)
+		if candidate.IsCompacting() || !ttl.expired(candidate.Stats.TimestampUpperBound, env.now) {
+			continue
+		}
+		if pc := p.pickFileCompaction(env, l, candidate, compactionKindTTL); pc != nil {
+			return pc
+		}
+	}
+	return nil
+}
+
+// oldestFileAnnotator implements the manifest.Annotator interface, annotating
+// B-Tree nodes with the *fileMetadata of the file with the lowest CreationTime
+// within the subtree. Files whose creation time is unknown are ignored.
+type oldestFileAnnotator struct{}
+
+var _ manifest.Annotator = oldestFileAnnotator{}
+
+func (a oldestFileAnnotator) Zero(interface{}) interface{} {
+	return nil
+}
+
+func (a oldestFileAnnotator) Accumulate(f *fileMetadata, dst interface{}) (interface{}, bool) {
+	if f.IsCompacting() || f.CreationTime == 0 {
+		return dst, true
+	}
+	if dst == nil {
+		return f, true
+	} else if dstV := dst.(*fileMetadata); dstV.CreationTime > f.CreationTime {
+		return f, true
+	}
+	return dst, true
+}
+
+func (a oldestFileAnnotator) Merge(v interface{}, accum interface{}) interface{} {
+	if v == nil {
+		return accum
+	}
+	if accum == nil {
+		return v
+	}
+	f := v.(*fileMetadata)
+	accumV := accum.(*fileMetadata)
+	if accumV.CreationTime > f.CreationTime {
+		return f
+	}
+	return accumV
+}
+
+// pickPeriodicCompaction looks for compactions of sstables in L1 and below
+// that have not been rewritten within Options.PeriodicCompactionInterval.
+func (p *compactionPickerByScore) pickPeriodicCompaction(
+	env compactionEnv,
+) (pc *pickedCompaction) {
+	interval := p.opts.PeriodicCompactionInterval
+	if interval <= 0 {
+		return nil
+	}
+	for l := numLevels - 1; l > 0; l-- {
+		v := p.vers.Levels[l].Annotation(oldestFileAnnotator{})
+		if v == nil {
+			continue
+		}
+		candidate := v.(*fileMetadata)
+		if candidate.IsCompacting() || time.Unix(candidate.CreationTime, 0).Add(interval).After(env.now) {
+			continue
+		}
+		if pc := p.pickFileCompaction(env, l, candidate, compactionKindPeriodic); pc != nil {
+			return pc
+		}
+	}
+	return nil
+}
+
+// tombstoneDensityMinDeletions is the minimum number of tombstones an sstable
+// must contain to be considered for a tombstone density compaction, so that
+// sstables holding a handful of tombstones don't trigger the rewrite of the
+// data beneath them.
+const tombstoneDensityMinDeletions = 1000
+
+// tombstoneDensity returns the fraction of the entries in f that are point or
+// range tombstones.
+func tombstoneDensity(f *fileMetadata) float64 {
+	if f.Stats.NumEntries == 0 {
+		return 0
+	}
+	return float64(f.Stats.NumDeletions) / float64(f.Stats.NumEntries)
+}
+
+// tombstoneDensityAnnotator implements the manifest.Annotator interface,
+// annotating B-Tree nodes with the *fileMetadata of the file with the highest
+// tombstone density within the subtree, among the files with at least
+// tombstoneDensityMinDeletions tombstones and a density of at least threshold.
+type tombstoneDensityAnnotator struct {
+	threshold float64
+}
+
+var _ manifest.Annotator = tombstoneDensityAnnotator{}
+
+func (a tombstoneDensityAnnotator) Zero(interface{}) interface{} {
+	return nil
+}
+
+func (a tombstoneDensityAnnotator) Accumulate(
+	f *fileMetadata, dst interface{},
+) (interface{}, bool) {
+	if f.IsCompacting() {
+		return dst, true
+	}
+	if !f.StatsValid() {
+		return dst, false
+	}
+	if f.Stats.NumDeletions < tombstoneDensityMinDeletions || tombstoneDensity(f) < a.threshold {
+		return dst, true
+	}
+	if dst == nil || tombstoneDensity(dst.(*fileMetadata)) < tombstoneDensity(f) {
+		return f, true
+	}
+	return dst, true
+}
+
+func (a tombstoneDensityAnnotator) Merge(v interface{}, accum interface{}) interface{} {
+	if v == nil {
+		return accum
+	}
+	if accum == nil {
+		return v
+	}
+	f := v.(*fileMetadata)
+	if tombstoneDensity(accum.(*fileMetadata)) < tombstoneDensity(f) {
+		return f
+	}
+	return accum
+}
+
+// pickTombstoneDensityCompaction looks for compactions of sstables in L1
+// through L5 whose tombstone density exceeds
+// Options.TombstoneDensityCompactionThreshold, and compacts them into the next
+// level.
+func (p *compactionPickerByScore) pickTombstoneDensityCompaction(
+	env compactionEnv,
+) (pc *pickedCompaction) {
+	threshold := p.opts.TombstoneDensityCompactionThreshold
+	if threshold <= 0 {
+		return nil
+	}
+	for l := numLevels - 2; l > 0; l-- {
+		v := p.vers.Levels[l].Annotation(tombstoneDensityAnnotator{threshold: threshold})
+		if v == nil {
+			continue
+		}
+		candidate := v.(*fileMetadata)
+		if candidate.IsCompacting() {
+			continue
+		}
+		if pc := p.pickFileCompaction(env, l, candidate, compactionKindTombstoneDensity); pc != nil {
+			return pc
+		}
+	}
+	return nil
+}
+
+// pickHotRangeCompaction looks for a compaction that reduces the read
+// amplification of a hot key range suggested through DB.SuggestCompactRange.
+// It compacts the files overlapping the range in the highest level containing
+// them into the next level. Ranges that overlap at most one sstable are
+// removed from env.hotRanges.
+func (p *compactionPickerByScore) pickHotRangeCompaction(
+	env compactionEnv,
+) (pc *pickedCompaction) {
+	if env.hotRanges == nil {
+		return nil
+	}
+	cmp := p.opts.Comparer.Compare
+	hotRanges := (*env.hotRanges)[:0]
+	for _, kr := range *env.hotRanges {
+		// Determine the highest level overlapping the range, and the number of
+		// sstables that may need to be consulted to read a key in the range.
+		level := -1
+		readAmp := 0
+		for l := 0; l < numLevels; l++ {
+			overlaps := p.vers.Overlaps(l, cmp, kr.Start, kr.End, true /* exclusiveEnd */)
+			if overlaps.Empty() {
+				continue
+			}
+			if level == -1 {
+				level = l
+			}
+			if l == 0 {
+				readAmp += overlaps.Len()
+			} else {
+				readAmp++
+			}
+		}
+		if readAmp <= 1 {
+			continue
+		}
+		hotRanges = append(hotRanges, kr)
+		if pc != nil {
+			continue
+		}
+		manual := &manualCompaction{level: level, start: kr.Start, end: kr.End}
+		if pc, _ = p.pickManual(env, manual); pc != nil {
+			pc.kind = compactionKindRead
+		}
+	}
+	*env.hotRanges = hotRanges
+	return pc
+}
+
+// pickTieringCompaction looks for sstables in the bottommost level to migrate
+// between local and remote storage under Options.Experimental.Tiering, and
+// rewrites them in place onto the other storage.
+func (p *compactionPickerByScore) pickTieringCompaction(env compactionEnv) (pc *pickedCompaction) {
+	if env.tiering == nil {
+		return nil
+	}
+	policy := &p.opts.Experimental.Tiering
+	iter := p.vers.Levels[numLevels-1].Iter()
+	for f := iter.First(); f != nil; f = iter.Next() {
+		if f.IsCompacting() {
+			continue
+		}
+		remote, ok := env.tiering.isRemote(f)
+		if !ok {
+			continue
+		}
+		if remote && !policy.promotable(f) ||
+			!remote && !policy.demotable(f, env.tiering.readsTrackedSince, env.now) {
+			continue
+		}
+		if pc := p.pickFileCompaction(env, numLevels-1, f, compactionKindTiering); pc != nil {
+			pc.demote = !remote
+			return pc
+		}
+	}
+	env.tiering.scanned = true
+	return nil
+}
+
+// pickBlobRewriteCompaction looks for an sstable referencing one of the blob
+// files to garbage collect, and rewrites it in place, writing the values it
+// references in these blob files to new blob files.
+func (p *compactionPickerByScore) pickBlobRewriteCompaction(
+	env compactionEnv,
+) (pc *pickedCompaction) {
+	if len(env.blobFilesToRewrite) == 0 {
+		return nil
+	}
+	for l := numLevels - 1; l >= 0; l-- {
+		iter := p.vers.Levels[l].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if f.IsCompacting() || !referencesBlobFiles(f, env.blobFilesToRewrite) {
+				continue
+			}
+			if pc := p.pickInPlaceRewrite(env, l, f, compactionKindBlobRewrite); pc != nil {
+				pc.rewriteBlobFiles = env.blobFilesToRewrite
+				return pc
+			}
+		}
+	}
+	return nil
+}
+
+// referencesBlobFiles returns true if f references one of the blob files.
+func referencesBlobFiles(f *fileMetadata, blobFiles map[base.DiskFileNum]struct{}) bool {
+	for _, ref := range f.BlobReferences {
+		if _, ok := blobFiles[ref.FileNum]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// pickFileCompaction constructs a compaction of the provided file in level l,
+// which must be L1 or below, together with its atomic compaction unit. A file
+// in the bottommost level is rewritten in place, and a file in any other level
+// is compacted into the next level. It returns nil if the compaction cannot
+// be run because some of its inputs are already being compacted.
+func (p *compactionPickerByScore) pickFileCompaction(
+	env compactionEnv, l int, f *fileMetadata, kind compactionKind,
+) (pc *pickedCompaction) {
+	lf := p.vers.Levels[l].Find(p.opts.Comparer.Compare, f)
+	if lf == nil {
+		panic(fmt.Sprintf("file %s not found in level %d as expected", f.FileNum, l))
+	}
+	outputLevel := l
+	if l < numLevels-1 {
+		outputLevel = defaultOutputLevel(l, p.baseLevel)
+	}
+	pc = newPickedCompaction(p.opts, p.vers, l, outputLevel, p.baseLevel)
+	pc.kind = kind
+	pc.startLevel.files = lf.Slice()
+	if !pc.setupInputs(p.opts, p.diskAvailBytes(), pc.startLevel) {
+		return nil
+	}
+	// Fail-safe to protect against compacting the same sstable concurrently.
+	if inputRangeAlreadyCompacting(env, pc) {
+		return nil
+	}
+	return pc
+}
+
 // pickRewriteCompaction attempts to construct a compaction that
 // rewrites a file marked for compaction. pickRewriteCompaction will
 // pull in adjacent files in the file's atomic compaction unit if
@@ -1550,52 +2037,64 @@ func (p *compactionPickerByScore) pickRewriteCompaction(env compactionEnv) (pc *
 			// Try the next level.
 			continue
 		}
-		lf := p.vers.Levels[l].Find(p.opts.Comparer.Compare, candidate)
-		if lf == nil {
-			panic(fmt.Sprintf("file %s not found in level %d as expected", candidate.FileNum, numLevels-1))
-		}
-
-		inputs := lf.Slice()
-		// L0 files generated by a flush have never been split such that
-		// adjacent files can contain the same user key. So we do not need to
-		// rewrite an atomic compaction unit for L0. Note that there is nothing
-		// preventing two different flushes from producing files that are
-		// non-overlapping from an InternalKey perspective, but span the same
-		// user key. However, such files cannot be in the same L0 sublevel,
-		// since each sublevel requires non-overlapping user keys (unlike other
-		// levels).
-		if l > 0 {
-			// Find this file's atomic compaction unit. This is only relevant
-			// for levels L1+.
-			var isCompacting bool
-			inputs, isCompacting = expandToAtomicUnit(
-				p.opts.Comparer.Compare,
-				inputs,
-				false, /* disableIsCompacting */
-			)
-			if isCompacting {
-				// Try the next level.
-				continue
-			}
-		}
-
-		pc = newPickedCompaction(p.opts, p.vers, l, l, p.baseLevel)
-		pc.outputLevel.level = l
-		pc.kind = compactionKindRewrite
-		pc.startLevel.files = inputs
-		pc.smallest, pc.largest = manifest.KeyRange(pc.cmp, pc.startLevel.files.Iter())
-
-		// Fail-safe to protect against compacting the same sstable concurrently.
-		if !inputRangeAlreadyCompacting(env, pc) {
-			if pc.startLevel.level == 0 {
-				pc.l0SublevelInfo = generateSublevelInfo(pc.cmp, pc.startLevel.files)
-			}
+		if pc := p.pickInPlaceRewrite(env, l, candidate, compactionKindRewrite); pc != nil {
 			return pc
 		}
 	}
 	return nil
 }
 
+// pickInPlaceRewrite constructs a compaction of the kind provided that
+// rewrites the file f of level l into the same level, together with its
+// atomic compaction unit. It returns nil if the compaction cannot be run
+// because some of its inputs are already being compacted.
+func (p *compactionPickerByScore) pickInPlaceRewrite(
+	env compactionEnv, l int, f *fileMetadata, kind compactionKind,
+) (pc *pickedCompaction) {
+	lf := p.vers.Levels[l].Find(p.opts.Comparer.Compare, f)
+	if lf == nil {
+		panic(fmt.Sprintf("file %s not found in level %d as expected", f.FileNum, l))
+	}
+
+	inputs := lf.Slice()
+	// L0 files generated by a flush have never been split such that
+	// adjacent files can contain the same user key. So we do not need to
+	// rewrite an atomic compaction unit for L0. Note that there is nothing
+	// preventing two different flushes from producing files that are
+	// non-overlapping from an InternalKey perspective, but span the same
+	// user key. However, such files cannot be in the same L0 sublevel,
+	// since each sublevel requires non-overlapping user keys (unlike other
+	// levels).
+	if l > 0 {
+		// Find this file's atomic compaction unit. This is only relevant
+		// for levels L1+.
+		var isCompacting bool
+		inputs, isCompacting = expandToAtomicUnit(
+			p.opts.Comparer.Compare,
+			inputs,
+			false, /* disableIsCompacting */
+		)
+		if isCompacting {
+			return nil
+		}
+	}
+
+	pc = newPickedCompaction(p.opts, p.vers, l, l, p.baseLevel)
+	pc.outputLevel.level = l
+	pc.kind = kind
+	pc.startLevel.files = inputs
+	pc.smallest, pc.largest = manifest.KeyRange(pc.cmp, pc.startLevel.files.Iter())
+
+	// Fail-safe to protect against compacting the same sstable concurrently.
+	if inputRangeAlreadyCompacting(env, pc) {
+		return nil
+	}
+	if pc.startLevel.level == 0 {
+		pc.l0SublevelInfo = generateSublevelInfo(pc.cmp, pc.startLevel.files)
+	}
+	return pc
+}
+
 // pickAutoLPositive picks an automatic compaction for the candidate
 // file in a positive-numbered level. This function must not be used for
 // L0.
@@ -1726,6 +2225,10 @@ func (p *compactionPickerByScore) pickManual(
 		return nil, false
 	}
 
+	if manual.level == numLevels-1 {
+		return p.pickManualBottommost(env, manual)
+	}
+
 	outputLevel := manual.level + 1
 	if manual.level == 0 {
 		outputLevel = p.baseLevel
@@ -1764,6 +2267,35 @@ func (p *compactionPickerByScore) pickManual(
 	return pc, false
 }
 
+// pickManualBottommost picks a manual compaction that rewrites the bottommost
+// level files overlapping the manual compaction's key range in place.
+func (p *compactionPickerByScore) pickManualBottommost(
+	env compactionEnv, manual *manualCompaction,
+) (pc *pickedCompaction, retryLater bool) {
+	cmp := p.opts.Comparer.Compare
+	if conflictsWithInProgress(manual, manual.level, env.inProgressCompactions, cmp) {
+		return nil, true
+	}
+	manual.outputLevel = manual.level
+	files := p.vers.Overlaps(manual.level, cmp, manual.start, manual.end, false)
+	if files.Empty() {
+		return nil, false
+	}
+	pc = newPickedCompaction(p.opts, p.vers, manual.level, manual.level, p.baseLevel)
+	pc.kind = compactionKindRewrite
+	var isCompacting bool
+	pc.startLevel.files, isCompacting = expandToAtomicUnit(cmp, files, false /* disableIsCompacting */)
+	if isCompacting {
+		return nil, true
+	}
+	pc.smallest, pc.largest = manifest.KeyRange(pc.cmp, pc.startLevel.files.Iter())
+	// Fail-safe to protect against compacting the same sstable concurrently.
+	if inputRangeAlreadyCompacting(env, pc) {
+		return nil, true
+	}
+	return pc, false
+}
+
 func pickManualHelper(
 	opts *Options,
 	manual *manualCompaction,
@@ -1792,7 +2324,8 @@ func (p *compactionPickerByScore) pickReadTriggeredCompaction(
 	// If a flush is in-progress or expected to happen soon, it means more writes are taking place. We would
 	// soon be scheduling more write focussed compactions. In this case, skip read compactions as they are
 	// lower priority.
-	if env.readCompactionEnv.flushing || env.readCompactionEnv.readCompactions == nil {
+	if env.readCompactionEnv.flushing || env.readCompactionEnv.readCompactions == nil ||
+		env.diskSpaceLow {
 		return nil
 	}
 	for env.readCompactionEnv.readCompactions.size > 0 {
@@ -1388,7 +1388,7 @@ func TestCompactionPickerPickFile(t *testing.T) {
 			var ok bool
 			d.maybeScheduleCompactionPicker(func(untypedPicker compactionPicker, env compactionEnv) *pickedCompaction {
 				p := untypedPicker.(*compactionPickerByScore)
-				lf, ok = p.pickFile(level, level+1, env.earliestSnapshotSeqNum)
+				lf, ok = p.pickFile(level, level+1, env.earliestSnapshotSeqNum, nil /* hotRanges */)
 				return nil
 			})
 			if !ok {
@@ -0,0 +1,104 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+// PlannedCompaction describes a compaction that the compaction picker would
+// schedule. See DB.PlanCompactions.
+type PlannedCompaction struct {
+	// Reason is the reason for the compaction, as reported in
+	// CompactionInfo.Reason.
+	Reason string
+	// Input contains the input tables for the compaction organized by level.
+	Input []LevelInfo
+	// OutputLevel is the level the compaction writes its outputs into.
+	OutputLevel int
+	// Score is the score of the start level that caused the compaction to be
+	// picked. It is zero for compactions not driven by level scores.
+	Score float64
+	// InputBytes is the total size of the input tables.
+	InputBytes uint64
+	// EstimatedWriteBytes is an upper bound on the number of bytes the
+	// compaction writes. It is zero for move compactions, which do not
+	// rewrite their input, and equal to InputBytes otherwise, ignoring the
+	// keys the compaction may drop.
+	EstimatedWriteBytes uint64
+}
+
+// PlanCompactions returns the automatic compactions that the compaction
+// picker would schedule right now, in the order it would pick them, without
+// running them. Compactions are planned up to MaxConcurrentCompactions,
+// including the compactions already running, as if every planned compaction
+// were started. Manual compactions are not included. Compactions are planned
+// even if automatic compactions are disabled or paused, so that the picker's
+// decisions may be inspected against an LSM that is not being compacted.
+func (d *DB) PlanCompactions() []PlannedCompaction {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.mu.versions.logLock()
+	defer d.mu.versions.logUnlock()
+
+	env := compactionEnv{
+		earliestSnapshotSeqNum:  d.earliestSnapshotLocked(),
+		earliestUnflushedSeqNum: d.getEarliestUnflushedSeqNumLocked(),
+		now:                     d.timeNow(),
+	}
+	// The picker consumes read compactions and prunes hot ranges, so it is
+	// handed copies of them.
+	readCompactions := d.mu.compact.readCompactions
+	var rescheduleReadCompaction bool
+	hotRanges := append([]KeyRange(nil), d.mu.compact.hotRanges...)
+	env.readCompactionEnv = readCompactionEnv{
+		readCompactions:          &readCompactions,
+		flushing:                 d.mu.compact.flushing || d.passedFlushThreshold(),
+		rescheduleReadCompaction: &rescheduleReadCompaction,
+	}
+	env.hotRanges = &hotRanges
+
+	// Each planned compaction is marked as in progress, like a scheduled
+	// compaction, so that the compactions planned after it are picked
+	// against the remaining files. d.mu is held throughout, so the markers
+	// are never observed, and they are cleared before returning.
+	var planned []*compaction
+	defer func() {
+		for _, c := range planned {
+			delete(d.mu.compact.inProgress, c)
+			d.clearCompactingState(c, true /* rollback */)
+		}
+	}()
+	var plans []PlannedCompaction
+	for d.compactingCountLocked()+len(planned) < d.opts.MaxConcurrentCompactions() {
+		env.inProgressCompactions = d.getInProgressCompactionInfoLocked(nil)
+		pc := d.mu.versions.picker.pickAuto(env)
+		if pc == nil {
+			break
+		}
+		c := d.newCompactionLocked(pc)
+		d.addInProgressCompaction(c)
+		planned = append(planned, c)
+		plans = append(plans, c.makePlan())
+	}
+	return plans
+}
+
+// makePlan returns the PlannedCompaction describing c.
+func (c *compaction) makePlan() PlannedCompaction {
+	info := c.makeInfo(0 /* jobID */)
+	p := PlannedCompaction{
+		Reason:      info.Reason,
+		Input:       info.Input,
+		OutputLevel: info.Output.Level,
+		Score:       c.score,
+	}
+	for _, cl := range c.inputs {
+		p.InputBytes += cl.files.SizeSum()
+	}
+	if c.kind != compactionKindMove {
+		p.EstimatedWriteBytes = p.InputBytes
+	}
+	return p
+}
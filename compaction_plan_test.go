@@ -0,0 +1,58 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanCompactions(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+		L0CompactionThreshold:       2,
+		MaxConcurrentCompactions:    func() int { return 2 },
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	require.Empty(t, d.PlanCompactions())
+
+	// Flush overlapping sstables into L0.
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 10; j++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("key%02d", j)), []byte("val"), nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+
+	plans := d.PlanCompactions()
+	require.Len(t, plans, 1)
+	p := plans[0]
+	require.Equal(t, "default", p.Reason)
+	require.Equal(t, 0, p.Input[0].Level)
+	require.Len(t, p.Input[0].Tables, 3)
+	require.Equal(t, d.mu.versions.picker.getBaseLevel(), p.OutputLevel)
+	require.Greater(t, p.Score, 1.0)
+	require.NotZero(t, p.InputBytes)
+	require.Equal(t, p.InputBytes, p.EstimatedWriteBytes)
+
+	// Planning leaves no compaction state behind, so planning again yields
+	// the same compactions.
+	d.mu.Lock()
+	require.Empty(t, d.mu.compact.inProgress)
+	iter := d.mu.versions.currentVersion().Levels[0].Iter()
+	for f := iter.First(); f != nil; f = iter.Next() {
+		require.False(t, f.IsCompacting())
+	}
+	d.mu.Unlock()
+	require.Equal(t, plans, d.PlanCompactions())
+}
@@ -0,0 +1,202 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/manifest"
+)
+
+// CompactionPolicy allows an embedder to replace or augment the heuristics
+// that pick automatic compactions. Pebble consults the policy whenever it
+// looks for an automatic compaction to schedule, before applying its own
+// heuristics. The policy is not consulted for flushes, manual compactions,
+// delete-only compactions or compactions that are downloading or excising
+// data, and the number of concurrent compactions remains bounded by
+// Options.MaxConcurrentCompactions.
+//
+// A policy is invoked with DB.mu held, so it must be fast and must not call
+// back into the DB.
+type CompactionPolicy interface {
+	// PickCompaction returns the compaction to run, together with a decision
+	// describing how the candidate is to be used. The view is only valid for
+	// the duration of the call.
+	PickCompaction(view *CompactionPolicyView) (CompactionCandidate, CompactionPolicyDecision)
+}
+
+// CompactionPolicyDecision is returned by a CompactionPolicy to describe how
+// pebble should proceed.
+type CompactionPolicyDecision int8
+
+const (
+	// CompactionPolicyDefault ignores the returned candidate and picks a
+	// compaction using pebble's built-in heuristics.
+	CompactionPolicyDefault CompactionPolicyDecision = iota
+	// CompactionPolicyPick runs the returned candidate. If the candidate is
+	// invalid, it is logged and pebble falls back to its built-in heuristics.
+	// If any of the candidate's inputs are already being compacted, no
+	// compaction is scheduled and the policy is consulted again later.
+	CompactionPolicyPick
+	// CompactionPolicyNone schedules no automatic compaction.
+	CompactionPolicyNone
+)
+
+// String implements fmt.Stringer.
+func (d CompactionPolicyDecision) String() string {
+	switch d {
+	case CompactionPolicyDefault:
+		return "default"
+	case CompactionPolicyPick:
+		return "pick"
+	case CompactionPolicyNone:
+		return "none"
+	default:
+		return "unknown"
+	}
+}
+
+// CompactionCandidate describes a compaction returned by a CompactionPolicy.
+// Pebble expands the candidate as it does its own compactions: it adds the
+// files in StartLevel between and overlapping the candidate's files, and the
+// files in the output level that overlap them.
+type CompactionCandidate struct {
+	// StartLevel is the level containing Files. Files in L1 through L5 are
+	// compacted into the next level, files in L0 into the base level, and
+	// files in L6 are rewritten in place.
+	StartLevel int
+	// Files holds the file numbers of the files to compact. It must be
+	// non-empty, and each file must be in StartLevel.
+	Files []FileNum
+}
+
+// CompactionPolicyFile describes an sstable in a CompactionPolicyView.
+type CompactionPolicyFile struct {
+	TableInfo
+	// Compacting is true if the file is being compacted.
+	Compacting bool
+}
+
+// CompactionPolicyView is a read-only view of the LSM passed to a
+// CompactionPolicy.
+type CompactionPolicyView struct {
+	p   *compactionPickerByScore
+	env *compactionEnv
+}
+
+// BaseLevel returns the level into which L0 is compacted.
+func (v *CompactionPolicyView) BaseLevel() int {
+	return v.p.baseLevel
+}
+
+// InProgressCompactions returns the number of compactions and flushes that are
+// in progress.
+func (v *CompactionPolicyView) InProgressCompactions() int {
+	return len(v.env.inProgressCompactions)
+}
+
+// Scores returns the scores pebble's built-in heuristics assign to each
+// level. A level with a score of at least 1 is due for a compaction.
+func (v *CompactionPolicyView) Scores() [numLevels]float64 {
+	return v.p.getScores(v.env.inProgressCompactions)
+}
+
+// Files returns the files in the provided level, in the order of their keys
+// for levels other than L0, and in the order of their sequence numbers for L0.
+func (v *CompactionPolicyView) Files(level int) []CompactionPolicyFile {
+	lm := v.p.vers.Levels[level]
+	files := make([]CompactionPolicyFile, 0, lm.Len())
+	iter := lm.Iter()
+	for f := iter.First(); f != nil; f = iter.Next() {
+		files = append(files, CompactionPolicyFile{
+			TableInfo:  f.TableInfo(),
+			Compacting: f.IsCompacting(),
+		})
+	}
+	return files
+}
+
+// pickPolicyCompaction consults Options.CompactionPolicy. It returns true if
+// the policy's decision is final, in which case pc is the compaction to run
+// or nil if none is to be run, and false if the built-in heuristics should
+// pick a compaction.
+func (p *compactionPickerByScore) pickPolicyCompaction(
+	env compactionEnv,
+) (pc *pickedCompaction, ok bool) {
+	c, decision := p.opts.CompactionPolicy.PickCompaction(&CompactionPolicyView{p: p, env: &env})
+	switch decision {
+	case CompactionPolicyPick:
+	case CompactionPolicyNone:
+		return nil, true
+	default:
+		return nil, false
+	}
+	pc, err := p.newPolicyCompaction(c)
+	if err != nil {
+		p.opts.Logger.Infof("pebble: ignoring invalid compaction candidate: %v", err)
+		return nil, false
+	}
+	// Fail-safe to protect against compacting the same sstable concurrently.
+	if pc == nil || inputRangeAlreadyCompacting(env, pc) {
+		return nil, true
+	}
+	return pc, true
+}
+
+// newPolicyCompaction validates the candidate returned by a CompactionPolicy
+// and constructs a picked compaction from it. It returns nil if the candidate
+// is valid but some of its inputs are being compacted.
+func (p *compactionPickerByScore) newPolicyCompaction(
+	c CompactionCandidate,
+) (*pickedCompaction, error) {
+	if c.StartLevel < 0 || c.StartLevel >= numLevels {
+		return nil, errors.Errorf("invalid start level %d", c.StartLevel)
+	}
+	if c.StartLevel > 0 && c.StartLevel < p.baseLevel {
+		return nil, errors.Errorf("start level L%d is above the base level L%d", c.StartLevel, p.baseLevel)
+	}
+	if len(c.Files) == 0 {
+		return nil, errors.New("no files")
+	}
+	want := make(map[FileNum]struct{}, len(c.Files))
+	for _, fileNum := range c.Files {
+		want[fileNum] = struct{}{}
+	}
+	cmp := p.opts.Comparer.Compare
+	var picked []*fileMetadata
+	iter := p.vers.Levels[c.StartLevel].Iter()
+	for f := iter.First(); f != nil; f = iter.Next() {
+		if _, ok := want[f.FileNum]; ok {
+			if f.IsCompacting() {
+				return nil, nil
+			}
+			picked = append(picked, f)
+			delete(want, f.FileNum)
+		}
+	}
+	for _, fileNum := range c.Files {
+		if _, ok := want[fileNum]; ok {
+			return nil, errors.Errorf("file %s not found in L%d", fileNum, c.StartLevel)
+		}
+	}
+
+	pickedSlice := manifest.NewLevelSliceKeySorted(cmp, picked)
+	smallest, largest := manifest.KeyRange(cmp, pickedSlice.Iter())
+	files := p.vers.Overlaps(c.StartLevel, cmp, smallest.UserKey, largest.UserKey,
+		largest.IsExclusiveSentinel())
+
+	outputLevel := c.StartLevel
+	if c.StartLevel < numLevels-1 {
+		outputLevel = defaultOutputLevel(c.StartLevel, p.baseLevel)
+	}
+	pc := newPickedCompaction(p.opts, p.vers, c.StartLevel, outputLevel, p.baseLevel)
+	if c.StartLevel == outputLevel {
+		pc.kind = compactionKindRewrite
+	}
+	pc.startLevel.files = files
+	if !pc.setupInputs(p.opts, p.diskAvailBytes(), pc.startLevel) {
+		return nil, nil
+	}
+	return pc, nil
+}
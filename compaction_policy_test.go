@@ -0,0 +1,141 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+// testCompactionPolicy is a CompactionPolicy that returns the candidate and
+// decision it is configured with.
+type testCompactionPolicy struct {
+	mu        sync.Mutex
+	candidate CompactionCandidate
+	decision  CompactionPolicyDecision
+	calls     int
+	l0Files   int
+}
+
+func (p *testCompactionPolicy) PickCompaction(
+	view *CompactionPolicyView,
+) (CompactionCandidate, CompactionPolicyDecision) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	p.l0Files = len(view.Files(0))
+	c, d := p.candidate, p.decision
+	if d == CompactionPolicyPick {
+		// Only pick the candidate once.
+		p.decision = CompactionPolicyNone
+	}
+	return c, d
+}
+
+func (p *testCompactionPolicy) set(c CompactionCandidate, d CompactionPolicyDecision) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.candidate, p.decision = c, d
+}
+
+type testCompactionPolicyLogger struct {
+	mu  sync.Mutex
+	buf strings.Builder
+}
+
+func (l *testCompactionPolicyLogger) Infof(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(&l.buf, format+"\n", args...)
+}
+
+func (l *testCompactionPolicyLogger) Fatalf(format string, args ...interface{}) {
+	panic(fmt.Sprintf(format, args...))
+}
+
+func (l *testCompactionPolicyLogger) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.String()
+}
+
+func TestCompactionPolicy(t *testing.T) {
+	policy := &testCompactionPolicy{decision: CompactionPolicyNone}
+	logger := &testCompactionPolicyLogger{}
+	d, err := Open("", &Options{
+		FS:                    vfs.NewMem(),
+		L0CompactionThreshold: 1,
+		CompactionPolicy:      policy,
+		Logger:                logger,
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+	waitForCompactions := func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		for d.mu.compact.compactingCount > 0 {
+			d.mu.compact.cond.Wait()
+		}
+	}
+	scheduleCompaction := func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.maybeScheduleCompaction()
+	}
+
+	// A policy that declines to compact replaces the built-in heuristics, so
+	// L0 is not compacted although it is above its compaction threshold.
+	for _, k := range []string{"a", "b", "c"} {
+		require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+		require.NoError(t, d.Flush())
+	}
+	waitForCompactions()
+	require.Zero(t, d.Metrics().Compact.Count)
+	policy.mu.Lock()
+	require.Greater(t, policy.calls, 0)
+	require.Equal(t, 3, policy.l0Files)
+	policy.mu.Unlock()
+
+	// The policy picks a single L0 file. Pebble adds the overlapping files,
+	// which in this case is only the picked file.
+	d.mu.Lock()
+	l0 := d.mu.versions.currentVersion().Levels[0].Slice()
+	d.mu.Unlock()
+	iter := l0.Iter()
+	picked := iter.First().FileNum
+	policy.set(CompactionCandidate{StartLevel: 0, Files: []FileNum{picked}}, CompactionPolicyPick)
+	scheduleCompaction()
+	require.Eventually(t, func() bool {
+		return d.Metrics().Compact.Count == 1
+	}, 10*time.Second, time.Millisecond)
+	waitForCompactions()
+	require.Equal(t, int64(2), d.Metrics().Levels[0].NumFiles)
+
+	// An invalid candidate is logged, and the built-in heuristics compact the
+	// remaining L0 files.
+	policy.set(CompactionCandidate{StartLevel: 0, Files: []FileNum{picked}}, CompactionPolicyPick)
+	scheduleCompaction()
+	require.Eventually(t, func() bool {
+		return d.Metrics().Levels[0].NumFiles == 0
+	}, 10*time.Second, time.Millisecond)
+	require.Contains(t, logger.String(), fmt.Sprintf("file %s not found in L0", picked))
+	for _, k := range []string{"a", "b", "c"} {
+		verifyGet(t, d, []byte(k), []byte(k))
+	}
+}
+
+func TestCompactionPolicyDecisionString(t *testing.T) {
+	require.Equal(t, "default", CompactionPolicyDefault.String())
+	require.Equal(t, "pick", CompactionPolicyPick.String())
+	require.Equal(t, "none", CompactionPolicyNone.String())
+}
@@ -0,0 +1,48 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+// newCompactionWriteScheduler returns the IOScheduler used when
+// Options.IOScheduler is not set. It is a FairIOScheduler that limits the
+// bandwidth of sstable writes by flushes and compactions combined, as
+// configured by Options.CompactionWriteRateLimit and
+// DB.SetCompactionWriteRateLimit, and does not limit reads. Flushes are
+// weighted above compactions when both are writing, since throttling flushes
+// stalls writes.
+func newCompactionWriteScheduler(bytesPerSec int64) *FairIOScheduler {
+	if bytesPerSec < 0 {
+		bytesPerSec = 0
+	}
+	s, err := NewFairIOScheduler(FairIOSchedulerOptions{
+		Bandwidth: bytesPerSec,
+		Weights: [NumIOClasses]float64{
+			IOClassFlushWrite:      2,
+			IOClassCompactionWrite: 1,
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// SetCompactionWriteRateLimit sets the rate, in bytes per second, at which
+// flushes and compactions write sstables. A value of 0 removes the limit. The
+// new limit applies to flushes and compactions that are already running. See
+// Options.CompactionWriteRateLimit. It has no effect if Options.IOScheduler is
+// set.
+func (d *DB) SetCompactionWriteRateLimit(bytesPerSec int64) {
+	if bytesPerSec < 0 {
+		bytesPerSec = 0
+	}
+	d.compactionLimiter.SetBandwidth(bytesPerSec)
+}
+
+// CompactionWriteRateLimit returns the current rate limit, in bytes per
+// second, for sstable writes by flushes and compactions, or 0 if writes are
+// not limited.
+func (d *DB) CompactionWriteRateLimit() int64 {
+	return d.compactionLimiter.Bandwidth()
+}
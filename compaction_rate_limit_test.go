@@ -0,0 +1,65 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompactionWriteRateLimit(t *testing.T) {
+	const bytesPerSec = 1 << 10
+	d, err := Open("", &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+		CompactionWriteRateLimit:    bytesPerSec,
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+	require.Equal(t, int64(bytesPerSec), d.CompactionWriteRateLimit())
+
+	// Replace the scheduler's clock with one that advances only when a
+	// limiter sleeps.
+	sleptSoFar := useFakeClock(d.compactionLimiter)
+
+	rng := rand.New(rand.NewSource(1))
+	writeData := func() {
+		for i := 0; i < 2000; i++ {
+			key := []byte(fmt.Sprintf("key%04d", i))
+			value := make([]byte, 1000)
+			rng.Read(value)
+			require.NoError(t, d.Set(key, value, nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+
+	// Writes beyond the burst are throttled to the configured rate.
+	writeData()
+	written := d.Metrics().Levels[0].Size
+	require.Greater(t, written, int64(ioSchedulerBurst))
+	expected := time.Duration(written-ioSchedulerBurst) * time.Second / bytesPerSec
+	require.InDelta(t, float64(expected), float64(sleptSoFar()), float64(time.Second))
+
+	// Removing the limit stops throttling.
+	d.SetCompactionWriteRateLimit(0)
+	require.Zero(t, d.CompactionWriteRateLimit())
+	before := sleptSoFar()
+	writeData()
+	require.NoError(t, d.Compact([]byte("key"), []byte("kez"), false))
+	require.Equal(t, before, sleptSoFar())
+
+	// Raising the limit again throttles compactions.
+	d.SetCompactionWriteRateLimit(bytesPerSec)
+	writeData()
+	require.NoError(t, d.Compact([]byte("key"), []byte("kez"), false))
+	require.Greater(t, sleptSoFar(), before)
+}
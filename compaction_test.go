@@ -101,6 +101,12 @@ func (p *compactionPickerForTesting) pickElisionOnlyCompaction(
 	return nil
 }
 
+func (p *compactionPickerForTesting) pickSnapshotElisionCompaction(
+	env compactionEnv, f *fileMetadata,
+) (pc *pickedCompaction) {
+	return nil
+}
+
 func (p *compactionPickerForTesting) pickRewriteCompaction(
 	env compactionEnv,
 ) (pc *pickedCompaction) {
@@ -1861,6 +1867,45 @@ func TestCompactionOutputLevel(t *testing.T) {
 		})
 }
 
+func TestCompactionPerLevelTableLayout(t *testing.T) {
+	opts := &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+		Levels: []LevelOptions{
+			{Compression: NoCompression, BlockSize: 1 << 10},
+			{Compression: ZstdCompression, BlockSize: 16 << 10},
+		},
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key%04d", i))
+		require.NoError(t, d.Set(key, bytes.Repeat([]byte("v"), 100), nil))
+	}
+	require.NoError(t, d.Flush())
+	tables, err := d.SSTables(WithProperties())
+	require.NoError(t, err)
+	require.Len(t, tables[0], 1)
+	require.Equal(t, "NoCompression", tables[0][0].Properties.CompressionName)
+	l0Blocks := tables[0][0].Properties.NumDataBlocks
+
+	// The compaction into L6 would be a move, but L6 is configured with a
+	// different compression and block size, so the sstable is rewritten.
+	require.NoError(t, d.Compact([]byte("key"), []byte("kez"), false))
+	require.Zero(t, d.Metrics().Compact.MoveCount)
+	tables, err = d.SSTables(WithProperties())
+	require.NoError(t, err)
+	require.Empty(t, tables[0])
+	require.Len(t, tables[numLevels-1], 1)
+	props := tables[numLevels-1][0].Properties
+	require.Equal(t, "ZSTD", props.CompressionName)
+	require.Less(t, props.NumDataBlocks, l0Blocks)
+}
+
 func TestCompactionAtomicUnitBounds(t *testing.T) {
 	cmp := DefaultComparer.Compare
 	var files manifest.LevelSlice
@@ -3649,6 +3694,97 @@ func Test_calculateInuseKeyRanges(t *testing.T) {
 	}
 }
 
+func Test_splitManualCompactionRange(t *testing.T) {
+	opts := (*Options)(nil).EnsureDefaults()
+	cmp := base.DefaultComparer.Compare
+	newFileMeta := func(fileNum FileNum, size uint64, smallest, largest string) *fileMetadata {
+		m := (&fileMetadata{
+			FileNum: fileNum,
+			Size:    size,
+		}).ExtendPointKeyBounds(cmp, base.ParseInternalKey(smallest), base.ParseInternalKey(largest))
+		m.InitPhysicalBacking()
+		return m
+	}
+	kr := manifest.UserKeyRange{Start: []byte("a"), End: []byte("z")}
+	tests := []struct {
+		name        string
+		v           *version
+		targetBytes uint64
+		want        []manifest.UserKeyRange
+	}{
+		{
+			name: "split at every file",
+			v: newVersion(opts, [numLevels][]*fileMetadata{
+				1: {
+					newFileMeta(1, 10, "a.SET.1", "c.SET.1"),
+					newFileMeta(2, 10, "e.SET.1", "g.SET.1"),
+					newFileMeta(3, 10, "i.SET.1", "k.SET.1"),
+				},
+			}),
+			targetBytes: 10,
+			want: []manifest.UserKeyRange{
+				{Start: []byte("a"), End: []byte("c")},
+				{Start: []byte("e"), End: []byte("g")},
+				{Start: []byte("i"), End: []byte("z")},
+			},
+		},
+		{
+			name: "accumulate target bytes",
+			v: newVersion(opts, [numLevels][]*fileMetadata{
+				1: {
+					newFileMeta(1, 10, "a.SET.1", "c.SET.1"),
+					newFileMeta(2, 10, "e.SET.1", "g.SET.1"),
+					newFileMeta(3, 10, "i.SET.1", "k.SET.1"),
+				},
+			}),
+			targetBytes: 20,
+			want: []manifest.UserKeyRange{
+				{Start: []byte("a"), End: []byte("g")},
+				{Start: []byte("i"), End: []byte("z")},
+			},
+		},
+		{
+			name: "no split within shared user key",
+			v: newVersion(opts, [numLevels][]*fileMetadata{
+				1: {
+					newFileMeta(1, 10, "a.SET.3", "c.SET.3"),
+					newFileMeta(2, 10, "c.SET.2", "g.SET.1"),
+					newFileMeta(3, 10, "i.SET.1", "k.SET.1"),
+				},
+			}),
+			targetBytes: 10,
+			want: []manifest.UserKeyRange{
+				{Start: []byte("a"), End: []byte("g")},
+				{Start: []byte("i"), End: []byte("z")},
+			},
+		},
+		{
+			name: "no split within output level file",
+			v: newVersion(opts, [numLevels][]*fileMetadata{
+				1: {
+					newFileMeta(1, 10, "a.SET.2", "c.SET.2"),
+					newFileMeta(2, 10, "e.SET.2", "g.SET.2"),
+					newFileMeta(3, 10, "i.SET.2", "k.SET.2"),
+				},
+				2: {
+					newFileMeta(4, 10, "b.SET.1", "f.SET.1"),
+				},
+			}),
+			targetBytes: 10,
+			want: []manifest.UserKeyRange{
+				{Start: []byte("a"), End: []byte("g")},
+				{Start: []byte("i"), End: []byte("z")},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitManualCompactionRange(tt.v, cmp, 1, 2, kr, tt.targetBytes)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
 func TestMarkedForCompaction(t *testing.T) {
 	var mem vfs.FS = vfs.NewMem()
 	var d *DB
@@ -3876,6 +4012,58 @@ func TestCompaction_LogAndApplyFails(t *testing.T) {
 	}
 }
 
+// TestCreateOnSharedMinLevel tests that only sstables written into levels at
+// or below CreateOnSharedMinLevel are created on shared storage.
+func TestCreateOnSharedMinLevel(t *testing.T) {
+	var opts Options
+	opts.FS = vfs.NewMem()
+	opts.Experimental.RemoteStorage = remote.MakeSimpleFactory(map[remote.Locator]remote.Storage{
+		"": remote.NewInMem(),
+	})
+	opts.Experimental.CreateOnShared = true
+	opts.Experimental.CreateOnSharedMinLevel = numLevels - 1
+	opts.DisableAutomaticCompactions = true
+
+	d, err := Open("", &opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	require.NoError(t, d.SetCreatorID(1))
+	// Compact through every level, so that the file would otherwise move
+	// from L5 into L6.
+	d.mu.Lock()
+	d.mu.versions.dynamicBaseLevel = false
+	d.mu.Unlock()
+
+	isShared := func(level int) []bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		var shared []bool
+		iter := d.mu.versions.currentVersion().Levels[level].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			objMeta, err := d.objProvider.Lookup(fileTypeTable, f.FileBacking.DiskFileNum)
+			require.NoError(t, err)
+			shared = append(shared, objMeta.IsShared())
+		}
+		return shared
+	}
+
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Set([]byte("b"), []byte("2"), nil))
+	require.NoError(t, d.Flush())
+	require.Equal(t, []bool{false}, isShared(0))
+
+	// The file reaches the bottommost level through a compaction that
+	// rewrites it onto shared storage, rather than a move. Each manual
+	// compaction compacts the file one level down.
+	for level := 0; level < numLevels-1; level++ {
+		require.NoError(t, d.Compact([]byte("a"), []byte("c"), false))
+	}
+	for level := 0; level < numLevels-1; level++ {
+		require.Empty(t, isShared(level))
+	}
+	require.Equal(t, []bool{true}, isShared(numLevels-1))
+}
+
 // TestSharedObjectDeletePacing tests that we don't throttle shared object
 // deletes (see the TargetBytesDeletionRate option).
 func TestSharedObjectDeletePacing(t *testing.T) {
@@ -3934,3 +4122,443 @@ func TestSharedObjectDeletePacing(t *testing.T) {
 	require.NoError(t, err)
 	d.Close()
 }
+
+func TestPeriodicCompaction(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                         vfs.NewMem(),
+		PeriodicCompactionInterval: 24 * time.Hour,
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+	var offset atomic.Int64
+	d.mu.Lock()
+	d.timeNow = func() time.Time { return time.Now().Add(time.Duration(offset.Load())) }
+	d.mu.Unlock()
+
+	require.NoError(t, d.Set([]byte("a"), []byte("a"), nil))
+	require.NoError(t, d.Set([]byte("b"), []byte("b"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("a"), []byte("c"), false))
+	bottommostFile := func() base.FileNum {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		files := d.mu.versions.currentVersion().Levels[numLevels-1].Slice()
+		require.Equal(t, 1, files.Len())
+		iter := files.Iter()
+		return iter.First().FileNum
+	}
+	fileNum := bottommostFile()
+
+	// A file younger than the interval is not compacted.
+	d.mu.Lock()
+	d.maybeScheduleCompaction()
+	for d.mu.compact.compactingCount > 0 {
+		d.mu.compact.cond.Wait()
+	}
+	d.mu.Unlock()
+	require.Zero(t, d.Metrics().Compact.PeriodicCount)
+
+	// Once the file is older than the interval, it is rewritten in place.
+	offset.Store(int64(25 * time.Hour))
+	d.mu.Lock()
+	d.maybeScheduleCompaction()
+	d.mu.Unlock()
+	require.Eventually(t, func() bool {
+		return d.Metrics().Compact.PeriodicCount > 0
+	}, 10*time.Second, time.Millisecond)
+	// The rewritten file is recorded with the real creation time, so stop the
+	// clock from running ahead before waiting for compactions to complete.
+	offset.Store(0)
+	d.mu.Lock()
+	for d.mu.compact.compactingCount > 0 {
+		d.mu.compact.cond.Wait()
+	}
+	d.mu.Unlock()
+	require.NotEqual(t, fileNum, bottommostFile())
+	verifyGet(t, d, []byte("a"), []byte("a"))
+	verifyGet(t, d, []byte("b"), []byte("b"))
+}
+
+func TestManualCompactionProgress(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	for _, k := range []string{"a", "b", "c"} {
+		require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+		require.NoError(t, d.Flush())
+	}
+	l0Size := uint64(d.Metrics().Levels[0].Size)
+
+	var reports []ManualCompactionProgress
+	require.NoError(t, d.CompactWithOptions(context.Background(), []byte("a"), []byte("d"), CompactOptions{
+		Priority: ManualCompactionPriorityLow,
+		Progress: func(p ManualCompactionProgress) {
+			reports = append(reports, p)
+		},
+	}))
+	require.Equal(t, []ManualCompactionProgress{
+		{Level: 0, FilesCompleted: 3, BytesCompleted: l0Size},
+	}, reports)
+	require.Equal(t, int64(0), d.Metrics().Levels[0].NumFiles)
+}
+
+func TestManualCompactionCancel(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	d, err := Open("", &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+		EventListener: &EventListener{
+			TableCreated: func(info TableCreateInfo) {
+				if info.Reason == "compacting" {
+					started <- struct{}{}
+					<-release
+				}
+			},
+		},
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	require.NoError(t, d.Set([]byte("a"), []byte("a"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Set([]byte("b"), []byte("b"), nil))
+	require.NoError(t, d.Flush())
+
+	// Canceling the context returns immediately and cancels the running
+	// compaction, which leaves the LSM unchanged.
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- d.CompactWithOptions(ctx, []byte("a"), []byte("c"), CompactOptions{})
+	}()
+	<-started
+	cancel()
+	require.ErrorIs(t, <-errCh, context.Canceled)
+	close(release)
+	d.mu.Lock()
+	for d.mu.compact.compactingCount > 0 {
+		d.mu.compact.cond.Wait()
+	}
+	d.mu.Unlock()
+	require.Equal(t, int64(2), d.Metrics().Levels[0].NumFiles)
+	verifyGet(t, d, []byte("a"), []byte("a"))
+	verifyGet(t, d, []byte("b"), []byte("b"))
+
+	// A queued compaction is dropped.
+	d.mu.Lock()
+	manual := &manualCompaction{done: make(chan error, 1), start: []byte("a"), end: []byte("c")}
+	d.mu.compact.manual = append(d.mu.compact.manual, manual)
+	d.mu.Unlock()
+	d.cancelManualCompactions([]*manualCompaction{manual})
+	d.mu.Lock()
+	require.Empty(t, d.mu.compact.manual)
+	d.mu.Unlock()
+}
+
+func TestManualCompactionPriority(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+	require.NoError(t, d.Set([]byte("a"), []byte("a"), nil))
+	require.NoError(t, d.Flush())
+
+	// Low priority manual compactions are only scheduled after automatic
+	// compactions have been picked.
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	manual := &manualCompaction{
+		done:     make(chan error, 1),
+		start:    []byte("a"),
+		end:      []byte("b"),
+		priority: ManualCompactionPriorityLow,
+	}
+	d.mu.compact.manual = append(d.mu.compact.manual, manual)
+	env := compactionEnv{
+		earliestSnapshotSeqNum:  d.mu.snapshots.earliest(),
+		earliestUnflushedSeqNum: d.getEarliestUnflushedSeqNumLocked(),
+	}
+	d.mu.versions.logLock()
+	d.scheduleManualCompactionsLocked(env, false /* includeLowPriority */)
+	d.mu.versions.logUnlock()
+	require.Equal(t, []*manualCompaction{manual}, d.mu.compact.manual)
+	require.Nil(t, manual.c)
+
+	d.mu.versions.logLock()
+	d.scheduleManualCompactionsLocked(env, true /* includeLowPriority */)
+	d.mu.versions.logUnlock()
+	require.Empty(t, d.mu.compact.manual)
+	require.NotNil(t, manual.c)
+	for d.mu.compact.compactingCount > 0 {
+		d.mu.compact.cond.Wait()
+	}
+	require.NoError(t, <-manual.done)
+	require.Equal(t, "low", ManualCompactionPriorityLow.String())
+}
+
+func TestTombstoneDensityCompaction(t *testing.T) {
+	d, err := Open("", &Options{
+		FS: vfs.NewMem(),
+		// A small LBase size moves the base level up from L6, which allows
+		// ingesting into L5.
+		LBaseMaxBytes:                       64 << 10,
+		TombstoneDensityCompactionThreshold: 0.5,
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	// Write many small keys into L6. The large L6 and the small values keep
+	// the score of L5, which accounts for the data the tombstones are
+	// expected to drop, below the threshold for score-based compactions.
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key%06d", i)) }
+	rng := rand.New(rand.NewSource(1))
+	b := d.NewBatch()
+	for i := 0; i < 100000; i++ {
+		value := make([]byte, 8)
+		rng.Read(value)
+		require.NoError(t, b.Set(key(i), value, nil))
+	}
+	require.NoError(t, b.Commit(nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact(key(0), key(100000), false))
+
+	// Ingest an sstable that is 75% tombstones. It overlaps the bottommost
+	// sstable, so it is ingested into L5, from where it is compacted into L6
+	// once its table stats are loaded.
+	f, err := d.opts.FS.Create("ext")
+	require.NoError(t, err)
+	w := sstable.NewWriter(objstorageprovider.NewFileWritable(f), sstable.WriterOptions{})
+	for i := 0; i < 2000; i++ {
+		if i < 1500 {
+			require.NoError(t, w.Delete(key(i)))
+		} else {
+			require.NoError(t, w.Set(key(i), []byte("new")))
+		}
+	}
+	require.NoError(t, w.Close())
+	require.NoError(t, d.Ingest([]string{"ext"}))
+
+	require.Eventually(t, func() bool {
+		return d.Metrics().Compact.TombstoneDensityCount == 1
+	}, 10*time.Second, time.Millisecond)
+	d.mu.Lock()
+	for d.mu.compact.compactingCount > 0 {
+		d.mu.compact.cond.Wait()
+	}
+	d.mu.Unlock()
+	m := d.Metrics()
+	require.Zero(t, m.Levels[numLevels-2].NumFiles)
+	require.Equal(t, int64(1), m.Levels[numLevels-1].NumFiles)
+	verifyGetNotFound(t, d, key(0))
+	verifyGet(t, d, key(1500), []byte("new"))
+}
+
+func TestTombstoneDensityAnnotator(t *testing.T) {
+	newFile := func(entries, deletions uint64) *fileMetadata {
+		f := &fileMetadata{}
+		f.Stats.NumEntries = entries
+		f.Stats.NumDeletions = deletions
+		f.StatsMarkValid()
+		return f
+	}
+	a := tombstoneDensityAnnotator{threshold: 0.5}
+	sparse := newFile(10000, 1000)
+	dense := newFile(2000, 1500)
+	denser := newFile(2000, 1800)
+	small := newFile(10, 10)
+
+	v, ok := a.Accumulate(sparse, a.Zero(nil))
+	require.True(t, ok)
+	require.Nil(t, v)
+	v, _ = a.Accumulate(small, v)
+	require.Nil(t, v)
+	v, _ = a.Accumulate(dense, v)
+	require.Equal(t, dense, v)
+	require.Equal(t, denser, a.Merge(denser, v))
+	require.Equal(t, denser, a.Merge(dense, denser))
+	_, ok = a.Accumulate(&fileMetadata{}, nil)
+	require.False(t, ok)
+}
+
+func TestSnapshotElisionCompactions(t *testing.T) {
+	var blocking atomic.Bool
+	var started atomic.Int32
+	release := make(chan struct{})
+	opts := &Options{
+		FS: vfs.NewMem(),
+		EventListener: &EventListener{
+			TableCreated: func(info TableCreateInfo) {
+				if blocking.Load() && info.Reason == "compacting" {
+					started.Add(1)
+					<-release
+				}
+			},
+		},
+	}
+	opts.Experimental.SnapshotElisionConcurrency = 3
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	// Write three bottommost files, and then delete half of each file's keys
+	// while a snapshot pins the deleted keys.
+	key := func(r, i int) []byte { return []byte(fmt.Sprintf("%c%03d", 'a'+r, i)) }
+	writeRange := func(r int, del bool) {
+		for i := 0; i < 100; i++ {
+			if !del {
+				require.NoError(t, d.Set(key(r, i), []byte("val"), nil))
+			} else if i%2 == 0 {
+				require.NoError(t, d.Delete(key(r, i), nil))
+			}
+		}
+		require.NoError(t, d.Flush())
+		require.NoError(t, d.Compact(key(r, 0), key(r, 100), false))
+	}
+	for r := 0; r < 3; r++ {
+		writeRange(r, false)
+	}
+	snap := d.NewSnapshot()
+	for r := 0; r < 3; r++ {
+		writeRange(r, true)
+	}
+	d.mu.Lock()
+	d.waitTableStats()
+	require.Equal(t, 3, d.mu.versions.currentVersion().Levels[numLevels-1].Len())
+	d.mu.Unlock()
+	require.Zero(t, d.Metrics().Compact.ElisionOnlyCount)
+
+	// Closing the snapshot queues all three files, which are compacted
+	// concurrently even though MaxConcurrentCompactions is 1.
+	blocking.Store(true)
+	require.NoError(t, snap.Close())
+	require.Eventually(t, func() bool {
+		return started.Load() == 3
+	}, 10*time.Second, time.Millisecond)
+	d.mu.Lock()
+	require.Equal(t, 3, d.mu.compact.snapshotElisionCount)
+	require.Empty(t, d.mu.compact.snapshotElisionQueue)
+	d.mu.Unlock()
+
+	close(release)
+	d.mu.Lock()
+	for d.mu.compact.compactingCount > 0 {
+		d.mu.compact.cond.Wait()
+	}
+	require.Zero(t, d.mu.compact.snapshotElisionCount)
+	d.waitTableStats()
+	var entries uint64
+	iter := d.mu.versions.currentVersion().Levels[numLevels-1].Iter()
+	for f := iter.First(); f != nil; f = iter.Next() {
+		entries += f.Stats.NumEntries
+	}
+	d.mu.Unlock()
+	require.Equal(t, uint64(150), entries)
+	require.Equal(t, int64(3), d.Metrics().Compact.ElisionOnlyCount)
+	for r := 0; r < 3; r++ {
+		verifyGetNotFound(t, d, key(r, 0))
+		verifyGet(t, d, key(r, 1), []byte("val"))
+	}
+}
+
+func TestPauseCompactions(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                    vfs.NewMem(),
+		L0CompactionThreshold: 2,
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	require.NoError(t, d.PauseCompactions())
+	// Pausing again is a no-op.
+	require.NoError(t, d.PauseCompactions())
+
+	// Flushes still run while compactions are paused.
+	for i := 0; i < 4; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("key%d", i)), []byte("val"), nil))
+		require.NoError(t, d.Flush())
+	}
+	m := d.Metrics()
+	require.True(t, m.Compact.Paused)
+	require.Zero(t, m.Compact.Count)
+	require.Equal(t, int64(4), m.Levels[0].NumFiles)
+
+	// Manual compactions are still performed.
+	require.NoError(t, d.Compact([]byte("key0"), []byte("key1\x00"), false))
+	require.NotZero(t, d.Metrics().Compact.Count)
+	require.Equal(t, int64(2), d.Metrics().Levels[0].NumFiles)
+
+	d.ResumeCompactions()
+	require.Eventually(t, func() bool {
+		return d.Metrics().Levels[0].NumFiles == 0
+	}, 10*time.Second, time.Millisecond)
+	require.False(t, d.Metrics().Compact.Paused)
+}
+
+func TestSuggestCompactRange(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                    vfs.NewMem(),
+		L0CompactionThreshold: 100,
+		L0StopWritesThreshold: 100,
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	// Write three overlapping L0 sstables, which are not compacted because
+	// of the high L0CompactionThreshold.
+	for i := 0; i < 3; i++ {
+		for _, k := range []string{"a", "m", "z"} {
+			require.NoError(t, d.Set([]byte(k), []byte(fmt.Sprint(i)), nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+	require.Equal(t, int64(3), d.Metrics().Levels[0].NumFiles)
+	require.Error(t, d.SuggestCompactRange([]byte("b"), []byte("a")))
+
+	// Marking a range as hot compacts it until reads within it consult a
+	// single sstable, after which the range is forgotten.
+	require.NoError(t, d.SuggestCompactRange([]byte("a"), []byte("b")))
+	d.mu.Lock()
+	for d.mu.compact.compactingCount > 0 {
+		d.mu.compact.cond.Wait()
+	}
+	require.Empty(t, d.mu.compact.hotRanges)
+	d.mu.Unlock()
+	m := d.Metrics()
+	require.Equal(t, int64(1), m.Compact.ReadCount)
+	require.Zero(t, m.Levels[0].NumFiles)
+	require.Equal(t, int64(1), m.Levels[numLevels-1].NumFiles)
+	verifyGet(t, d, []byte("m"), []byte("2"))
+
+	// Only the most recent suggestions are retained.
+	require.NoError(t, d.PauseCompactions())
+	for i := 0; i < 2*maxHotRanges; i++ {
+		require.NoError(t, d.SuggestCompactRange([]byte(fmt.Sprintf("k%02d", i)), []byte(fmt.Sprintf("k%02d\x00", i))))
+	}
+	d.mu.Lock()
+	require.Len(t, d.mu.compact.hotRanges, maxHotRanges)
+	require.Equal(t, []byte("k16"), d.mu.compact.hotRanges[0].Start)
+	d.mu.Unlock()
+}
@@ -6,6 +6,7 @@ package pebble
 
 import (
 	"bytes"
+	"context"
 	crand "crypto/rand"
 	"fmt"
 	"io"
@@ -790,7 +791,8 @@ func runCompactCmd(td *datadriven.TestData, d *DB) error {
 		if err != nil {
 			return err
 		}
-		return d.manualCompact(iStart.UserKey, iEnd.UserKey, level, parallelize)
+		return d.manualCompact(context.Background(), iStart.UserKey, iEnd.UserKey, level,
+			&CompactOptions{Parallelize: parallelize}, &ManualCompactionProgress{})
 	}
 	return d.Compact([]byte(parts[0]), []byte(parts[1]), parallelize)
 }
@@ -1345,7 +1347,7 @@ func runForceIngestCmd(td *datadriven.TestData, d *DB) error {
 			}
 		}
 	}
-	_, err := d.ingest(paths, func(
+	_, err := d.ingest(paths, nil /* spans */, func(
 		tableNewIters,
 		keyspan.TableNewSpanIter,
 		IterOptions,
@@ -1356,7 +1358,7 @@ func runForceIngestCmd(td *datadriven.TestData, d *DB) error {
 		*fileMetadata,
 	) (int, error) {
 		return level, nil
-	}, nil /* shared */, KeyRange{}, nil /* external */)
+	}, nil /* shared */, KeyRange{}, nil /* external */, IngestOptions{})
 	return err
 }
 
@@ -52,10 +52,27 @@ var (
 	// ErrReadOnly is returned when a write operation is performed on a read-only
 	// database.
 	ErrReadOnly = errors.New("pebble: read-only")
+	// ErrWriteStallTimeout is returned when a write waits for a write stall to
+	// end for longer than Options.MaxWriteStallDuration. Use
+	// errors.Is(err, ErrWriteStallTimeout) to check for this error.
+	ErrWriteStallTimeout = errors.New("pebble: write stall timeout")
+	// ErrDegraded is returned by writes to a DB that entered degraded mode
+	// upon detecting a failing disk (see Options.Experimental.DegradedMode).
+	// It is marked as ErrReadOnly: errors.Is(err, ErrReadOnly) holds for it
+	// too.
+	ErrDegraded = errors.Mark(errors.New("pebble: degraded read-only mode"), ErrReadOnly)
+	// ErrDiskFull is returned by writes while the free disk space is below
+	// Options.DiskSpaceHardLimit. Use errors.Is(err, ErrDiskFull) to check for
+	// this error.
+	ErrDiskFull = errors.New("pebble: disk full")
 	// errNoSplit indicates that the user is trying to perform a range key
 	// operation but the configured Comparer does not provide a Split
 	// implementation.
 	errNoSplit = errors.New("pebble: Comparer.Split required for range key operations")
+	// errWriteWouldWait is returned by a write applied without waiting when
+	// it would have to wait for admission, a write stall or the WAL quota
+	// before entering the commit pipeline.
+	errWriteWouldWait = errors.New("pebble: write would wait")
 )
 
 // Reader is a readable key/value store.
@@ -262,12 +279,24 @@ type DB struct {
 	// memtable allocation will reuse this memtable if it has not already been
 	// recycled.
 	memTableRecycle atomic.Pointer[memTable]
+	// memoryShrink tracks the memory released by ShrinkMemory.
+	memoryShrink memoryShrink
 
 	// The size of the current log file (i.e. db.mu.log.queue[len(queue)-1].
 	logSize atomic.Uint64
 
 	// The number of bytes available on disk.
 	diskAvailBytes atomic.Uint64
+	// diskSpaceState is the DiskSpaceState of diskAvailBytes relative to
+	// Options.DiskSpaceSoftLimit and Options.DiskSpaceHardLimit.
+	diskSpaceState atomic.Int32
+
+	// diskHealth, if set, detects a failing disk, upon which the DB enters
+	// degraded mode. See Options.Experimental.DegradedMode.
+	diskHealth *diskHealthMonitor
+
+	// diskOpTracer, if set, times the filesystem operations of the DB.
+	diskOpTracer *diskOpTracer
 
 	cacheID        uint64
 	dirname        string
@@ -285,10 +314,21 @@ type DB struct {
 	optionsFileNum base.DiskFileNum
 	// The on-disk size of the current OPTIONS file.
 	optionsFileSize uint64
+	// zstdDicts holds the zstd dictionaries with which sstables are
+	// compressed, for levels with LevelOptions.ZstdDictionarySize set.
+	zstdDicts zstdDictionaries
 
 	// objProvider is used to access and manage SSTs.
 	objProvider objstorage.Provider
 
+	// compactionLimiter throttles sstable writes by flushes and compactions to
+	// Options.CompactionWriteRateLimit.
+	compactionLimiter *FairIOScheduler
+	// ioScheduler grants bandwidth to sstable writes by flushes and
+	// compactions. It is Options.IOScheduler if set, and compactionLimiter
+	// otherwise.
+	ioScheduler IOScheduler
+
 	fileLock *Lock
 	dataDir  vfs.File
 	walDir   vfs.File
@@ -296,6 +336,17 @@ type DB struct {
 	tableCache           *tableCacheContainer
 	newIters             tableNewIters
 	tableNewRangeKeyIter keyspan.TableNewSpanIter
+	// blobFiles fetches the values stored in blob files. See
+	// Options.Experimental.ValueSeparation.
+	blobFiles *blobFileCache
+	// rowCache caches the values returned by Get. It's nil unless
+	// Options.RowCacheSize is positive.
+	rowCache *rowCache
+	// subscriptions buffers the committed batches for the open Subscriptions.
+	subscriptions subscriptionBuffer
+	// catchUpMu serializes the catch-ups of a DB opened by OpenSecondary with
+	// its primary.
+	catchUpMu sync.Mutex
 
 	commit *commitPipeline
 
@@ -314,6 +365,32 @@ type DB struct {
 	closed   *atomic.Value
 	closedCh chan struct{}
 
+	// asyncCommits holds the state for batches committed through ApplyAsync.
+	// A single goroutine, started on the first call to ApplyAsync, waits for
+	// each batch to become durable in commit order and invokes its callback.
+	asyncCommits struct {
+		mu sync.Mutex
+		// cond is signaled when a batch is queued, when an in-progress
+		// ApplyAsync completes, and when the DB is closed.
+		cond sync.Cond
+		// queue holds the batches whose callbacks have not been invoked, in
+		// commit order.
+		queue []asyncCommit
+		// pending is the number of ApplyAsync calls that are applying a batch
+		// and have not yet queued it, plus one while the goroutine invoking
+		// the callbacks applies a deferred batch.
+		pending int
+		// deferred is the number of queued batches that have yet to be
+		// applied (see asyncCommit.deferred).
+		deferred int
+		// closed is set when the DB begins closing, after which ApplyAsync
+		// returns ErrClosed.
+		closed bool
+		// done is closed when the goroutine invoking callbacks exits. It is nil
+		// if the goroutine was never started.
+		done chan struct{}
+	}
+
 	cleanupManager *cleanupManager
 	// testingAlwaysWaitForCleanup is set by some tests to force waiting for
 	// obsolete file deletion (to make events deterministic).
@@ -377,6 +454,14 @@ type DB struct {
 			// delimeter between flushed and unflushed logs is
 			// versionSet.minUnflushedLogNum.
 			queue []fileInfo
+			// archiving holds the obsolete logs removed from the queue that are
+			// yet to be archived (see Options.WALArchive) and deleted by the
+			// cleanup manager.
+			archiving []fileInfo
+			// replicating holds the WAL file numbers of the ingestions and
+			// excises being recorded in the WAL archive (see
+			// ReplicationSource).
+			replicating []base.DiskFileNum
 			// The number of input bytes to the log. This is the raw size of the
 			// batches written to the WAL, without the overhead of the record
 			// envelopes.
@@ -386,6 +471,15 @@ type DB struct {
 			// commitPipeline.mu and DB.mu to be held when rotating the WAL/memtable
 			// (i.e. makeRoomForWrite).
 			*record.LogWriter
+			// cipher encrypts the records written to the LogWriter, if
+			// Options.WALKeyManager is set. Like the LogWriter, it is protected by
+			// commitPipeline.mu.
+			cipher *walCipher
+			// commitTime is the last commit time (in milliseconds since the Unix
+			// epoch) written to the current WAL file, if
+			// Options.WALRecordCommitTimes is set. Protected by
+			// commitPipeline.mu.
+			commitTime int64
 			// Can be nil.
 			metrics struct {
 				fsyncLatency prometheus.Histogram
@@ -408,6 +502,16 @@ type DB struct {
 			// footprint of memtables when lots of DB instances are used concurrently
 			// in test environments.
 			nextSize int
+			// adaptiveSize is the target memtable size when adaptive memtable
+			// sizing is enabled by Options.Experimental.MaxMemTableSize. It
+			// varies between Options.MemTableSize and MaxMemTableSize. See
+			// DB.adaptMemTableSizeLocked.
+			adaptiveSize int
+			// createdAt is the time at which the mutable memtable was created.
+			createdAt time.Time
+			// buildingFilters is the number of immutable memtable filters being
+			// built. See DB.maybeBuildMemTableFiltersLocked.
+			buildingFilters int
 		}
 
 		compact struct {
@@ -419,9 +523,28 @@ type DB struct {
 			flushing bool
 			// The number of ongoing compactions.
 			compactingCount int
+			// True when automatic compactions have been paused by
+			// DB.PauseCompactions, and the time at which they were paused.
+			paused   bool
+			pausedAt time.Time
+			// The number of ongoing snapshot elision compactions. These are
+			// included in compactingCount, but are limited by
+			// Options.Experimental.SnapshotElisionConcurrency rather than
+			// Options.MaxConcurrentCompactions.
+			snapshotElisionCount int
+			// The queue of bottommost files to compact once the snapshots
+			// that pinned their obsolete keys have been closed.
+			snapshotElisionQueue []*fileMetadata
 			// The list of deletion hints, suggesting ranges for delete-only
 			// compactions.
 			deletionHints []deleteCompactionHint
+			// The list of in-progress DeleteRangeIf operations. Flushes and
+			// compactions delete point keys matching any of the predicate
+			// deletions.
+			predicateDeletions []*predicateDeletion
+			// flushDiskFull is true if the most recent flush failed because
+			// the disk is full.
+			flushDiskFull bool
 			// The list of manual compactions. The next manual compaction to perform
 			// is at the start of the list. New entries are added to the end.
 			manual []*manualCompaction
@@ -440,6 +563,19 @@ type DB struct {
 			// compactions which we might have to perform.
 			readCompactions readCompactionQueue
 
+			// l0Trajectory records the number of L0 files and sublevels over
+			// time. See DB.CompactionDebt.
+			l0Trajectory l0Trajectory
+
+			// hotRanges holds the key ranges suggested by DB.SuggestCompactRange,
+			// in the order they were suggested.
+			hotRanges []KeyRange
+
+			// nextTieringCheck is the time at which the picker next scans
+			// the bottommost level for sstables to migrate under
+			// Options.Experimental.Tiering.
+			nextTieringCheck time.Time
+
 			// The cumulative duration of all completed compactions since Open.
 			// Does not include flushes.
 			duration time.Duration
@@ -459,12 +595,36 @@ type DB struct {
 			// The list of active snapshots.
 			snapshotList
 
+			// garbageHorizon is the sequence number below which no reader
+			// needs the versions of keys, as declared through
+			// DB.SetGarbageHorizon. Flushes and compactions ignore snapshots
+			// below it.
+			garbageHorizon uint64
+
 			// The cumulative count and size of snapshot-pinned keys written to
 			// sstables.
 			cumulativePinnedCount uint64
 			cumulativePinnedSize  uint64
 		}
 
+		// writeStall describes the write stall in progress, if any. See
+		// DB.makeRoomForWrite.
+		writeStall struct {
+			active bool
+			cause  WriteStallCause
+		}
+
+		idempotencyTokens struct {
+			// m maps the idempotency tokens of batches applied since the last
+			// flush to the sequence numbers of the batches. Batches that are
+			// still being committed map to InternalKeySeqNumMax. Tokens are
+			// pruned once the memtables containing their batches have been
+			// flushed. See Batch.SetIdempotencyToken.
+			m map[string]uint64
+			// cond is signaled when a batch with a token finishes committing.
+			cond sync.Cond
+		}
+
 		tableStats struct {
 			// Condition variable used to signal the completion of a
 			// job to collect table stats.
@@ -494,6 +654,23 @@ type DB struct {
 			// validating is set to true when validation is running.
 			validating bool
 		}
+
+		tableWarming struct {
+			// cond is a condition variable used to signal the completion of a
+			// job to read sstables into the secondary cache.
+			cond sync.Cond
+			// pending is a slice of the backings of ingested remote sstables
+			// waiting to be read into the secondary cache.
+			pending []*fileBacking
+			// warming is set to true when warming is running.
+			warming bool
+			// metrics holds the cumulative counts reported in
+			// Metrics.SecondaryCacheWarm.
+			metrics struct {
+				Tables int64
+				Bytes  int64
+			}
+		}
 	}
 
 	// Normally equal to time.Now() but may be overridden in tests.
@@ -539,6 +716,18 @@ func (d *DB) getInternal(key []byte, b *Batch, s *Snapshot) ([]byte, io.Closer,
 		panic(err)
 	}
 
+	// Reads through batches and snapshots bypass the row cache, which only
+	// holds the latest values of keys.
+	var rowCacheGen uint64
+	useRowCache := d.rowCache != nil && b == nil && s == nil
+	if useRowCache {
+		value, gen, ok := d.rowCache.get(key)
+		if ok {
+			return value, rowCacheCloser{}, nil
+		}
+		rowCacheGen = gen
+	}
+
 	// Grab and reference the current readState. This prevents the underlying
 	// files in the associated version from being deleted if there is a current
 	// compaction. The readState is unref'd by Iterator.Close().
@@ -599,7 +788,14 @@ func (d *DB) getInternal(key []byte, b *Batch, s *Snapshot) ([]byte, io.Closer,
 		}
 		return nil, nil, ErrNotFound
 	}
-	return i.Value(), i, nil
+	value, err := i.ValueAndErr()
+	if err != nil {
+		return nil, nil, errors.CombineErrors(err, i.Close())
+	}
+	if useRowCache {
+		d.rowCache.add(key, value, rowCacheGen)
+	}
+	return value, i, nil
 }
 
 // Set sets the value for the given key. It overwrites any previous value
@@ -780,7 +976,7 @@ func (d *DB) RangeKeyDelete(start, end []byte, opts *WriteOptions) error {
 //
 // It is safe to modify the contents of the arguments after Apply returns.
 func (d *DB) Apply(batch *Batch, opts *WriteOptions) error {
-	return d.applyInternal(batch, opts, false)
+	return d.applyInternal(batch, opts, false, false)
 }
 
 // ApplyNoSyncWait must only be used when opts.Sync is true and the caller
@@ -799,19 +995,243 @@ func (d *DB) ApplyNoSyncWait(batch *Batch, opts *WriteOptions) error {
 	if !opts.Sync {
 		return errors.Errorf("cannot request asynchonous apply when WriteOptions.Sync is false")
 	}
-	return d.applyInternal(batch, opts, true)
+	return d.applyInternal(batch, opts, true, false)
+}
+
+// ApplyBatches applies the operations contained in the provided batches to the
+// DB atomically: the batches are assigned consecutive sequence numbers in the
+// order provided, written to the WAL as a single record with at most a single
+// sync, and become visible at the same time. After a crash, either all or none
+// of the batches are recovered. On success, each batch is marked as applied
+// and its sequence number is set as if it had been committed individually.
+//
+// At most one of the batches may carry an idempotency token, in which case
+// the token applies to all of the batches. It is safe to modify the contents
+// of the arguments after ApplyBatches returns.
+func (d *DB) ApplyBatches(batches []*Batch, opts *WriteOptions) error {
+	var size int
+	var token []byte
+	for _, b := range batches {
+		if b.applied.Load() {
+			panic("pebble: batch already applied")
+		}
+		if b.db != nil && b.db != d {
+			panic(fmt.Sprintf("pebble: batch db mismatch: %p != %p", b.db, d))
+		}
+		if b.idempotencyToken != nil {
+			if token != nil {
+				return errors.New("pebble: multiple batches have an idempotency token")
+			}
+			token = b.idempotencyToken
+		}
+		size += b.Len()
+	}
+
+	combined := newBatch(d)
+	defer combined.Close()
+	combined.init(size)
+	for _, b := range batches {
+		if err := combined.Apply(b, nil); err != nil {
+			return err
+		}
+		if combined.minimumFormatMajorVersion < b.minimumFormatMajorVersion {
+			combined.minimumFormatMajorVersion = b.minimumFormatMajorVersion
+		}
+	}
+	if token != nil {
+		combined.idempotencyToken = findIdempotencyToken(combined.data)
+	}
+	if err := d.applyInternal(combined, opts, false, false); err != nil {
+		return err
+	}
+
+	// The combined batch is not applied if its idempotency token was seen
+	// before, in which case the batches' sequence numbers are left unset.
+	applied := combined.applied.Load()
+	seqNum := combined.SeqNum()
+	for _, b := range batches {
+		if applied && !b.Empty() {
+			b.setSeqNum(seqNum)
+		}
+		seqNum += uint64(b.Count())
+		b.applied.Store(true)
+	}
+	return nil
+}
+
+// ApplyAsync applies the operations contained in the batch to the DB without
+// waiting for the batch to become durable. ApplyAsync usually returns once the
+// mutations are applied to the memtable and visible, and fn is invoked once
+// the batch is durable as determined by opts (immediately after the batch is
+// visible if opts.Sync is false). If the WAL sync fails, fn is invoked with
+// the error.
+//
+// If the write would have to wait to be admitted (see
+// Options.Experimental.WriteAdmission), for a write stall to end or for the
+// un-flushed WAL data to shrink (see Options.MaxUnflushedWALBytes),
+// ApplyAsync returns without applying the batch. The batch is then applied
+// on the goroutine invoking the callbacks, and fn is invoked with the error
+// of applying it, or once it is durable. The batches of later calls to
+// ApplyAsync are applied after it, so that their callbacks remain ordered.
+// As with other writes, a write that triggers a write stall while in the
+// commit pipeline still waits for the stall to end.
+//
+// Callbacks are invoked sequentially, in commit order, on a single goroutine
+// shared by all asynchronous commits to the DB, so fn must not block. fn may
+// call ApplyAsync. The batch must not be closed or reused until fn has been
+// invoked. If ApplyAsync returns an error, fn is never invoked. ApplyAsync
+// returns ErrClosed once the DB has begun closing; the callbacks of batches
+// applied before then are invoked before Close returns, and the callbacks of
+// batches not yet applied are invoked with ErrClosed.
+//
+// It is safe to modify the contents of opts after ApplyAsync returns.
+func (d *DB) ApplyAsync(batch *Batch, opts *WriteOptions, fn func(err error)) error {
+	a := &d.asyncCommits
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return ErrClosed
+	}
+	sync := opts.GetSync()
+	// Deferred batches are applied with a copy of opts.
+	var deferredOpts WriteOptions
+	if opts != nil {
+		deferredOpts = *opts
+	}
+	if a.deferred > 0 {
+		d.queueAsyncCommitLocked(asyncCommit{batch: batch, opts: deferredOpts, sync: sync, deferred: true, fn: fn})
+		a.mu.Unlock()
+		return nil
+	}
+	a.pending++
+	a.mu.Unlock()
+
+	err := d.applyInternal(batch, opts, sync, true /* noWait */)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pending--
+	switch {
+	case err == nil:
+		d.queueAsyncCommitLocked(asyncCommit{batch: batch, sync: sync, fn: fn})
+	case errors.Is(err, errWriteWouldWait):
+		d.queueAsyncCommitLocked(asyncCommit{batch: batch, opts: deferredOpts, sync: sync, deferred: true, fn: fn})
+		err = nil
+	}
+	a.cond.Broadcast()
+	return err
+}
+
+// closeAsyncCommits prevents further calls to ApplyAsync and waits for the
+// in-progress calls to queue their batches. It returns a channel that is
+// closed once the callbacks of all queued batches have been invoked, or nil if
+// there are no callbacks to wait for.
+func (d *DB) closeAsyncCommits() chan struct{} {
+	a := &d.asyncCommits
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.closed = true
+	for a.pending > 0 {
+		a.cond.Wait()
+	}
+	// Wake the callback goroutine so it exits once the queue is empty.
+	a.cond.Broadcast()
+	return a.done
+}
+
+// asyncCommit is a batch committed through ApplyAsync whose callback has not
+// yet been invoked.
+type asyncCommit struct {
+	batch *Batch
+	opts  WriteOptions
+	sync  bool
+	// deferred is set if the batch has yet to be applied, because applying it
+	// would have waited.
+	deferred bool
+	fn       func(err error)
+}
+
+// queueAsyncCommitLocked queues the batch for its callback to be invoked,
+// starting the goroutine invoking the callbacks if necessary. Requires
+// d.asyncCommits.mu to be held.
+func (d *DB) queueAsyncCommitLocked(c asyncCommit) {
+	a := &d.asyncCommits
+	if a.done == nil {
+		a.done = make(chan struct{})
+		go d.asyncCommitLoop()
+	}
+	if c.deferred {
+		a.deferred++
+	}
+	a.queue = append(a.queue, c)
+	a.cond.Broadcast()
 }
 
+func (d *DB) asyncCommitLoop() {
+	a := &d.asyncCommits
+	defer close(a.done)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for {
+		for len(a.queue) == 0 && !(a.closed && a.pending == 0) {
+			a.cond.Wait()
+		}
+		if len(a.queue) == 0 {
+			return
+		}
+		c := a.queue[0]
+		a.queue[0] = asyncCommit{}
+		a.queue = a.queue[1:]
+
+		if c.deferred {
+			// Apply the batch, waiting for it to become durable. Once the DB
+			// has begun closing, batches can no longer be applied.
+			err := error(ErrClosed)
+			if !a.closed {
+				a.pending++
+				a.mu.Unlock()
+				err = d.applyInternal(c.batch, &c.opts, false, false)
+				a.mu.Lock()
+				a.pending--
+			}
+			a.deferred--
+			a.cond.Broadcast()
+			a.mu.Unlock()
+			c.fn(err)
+			a.mu.Lock()
+			continue
+		}
+
+		a.mu.Unlock()
+		var err error
+		if c.sync {
+			// WAL syncs complete in commit order, so waiting for each batch in
+			// turn does not delay the callbacks of later batches.
+			err = c.batch.SyncWait()
+		}
+		c.fn(err)
+		a.mu.Lock()
+	}
+}
+
+// applyInternal applies the batch. If noWait is set, applyInternal returns
+// errWriteWouldWait instead of waiting for the write to be admitted, for an
+// in-progress write stall to end or for the un-flushed WAL data to shrink, in
+// which case the batch is left unapplied and may be applied again.
+//
 // REQUIRES: noSyncWait => opts.Sync
-func (d *DB) applyInternal(batch *Batch, opts *WriteOptions, noSyncWait bool) error {
+func (d *DB) applyInternal(batch *Batch, opts *WriteOptions, noSyncWait, noWait bool) error {
 	if err := d.closed.Load(); err != nil {
 		panic(err)
 	}
 	if batch.applied.Load() {
 		panic("pebble: batch already applied")
 	}
-	if d.opts.ReadOnly {
-		return ErrReadOnly
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	if err := d.checkDiskSpace(); err != nil {
+		return err
 	}
 	if batch.db != nil && batch.db != d {
 		panic(fmt.Sprintf("pebble: batch db mismatch: %p != %p", batch.db, d))
@@ -821,6 +1241,12 @@ func (d *DB) applyInternal(batch *Batch, opts *WriteOptions, noSyncWait bool) er
 	if sync && d.opts.DisableWAL {
 		return errors.New("pebble: WAL disabled")
 	}
+	if opts.GetDisableWAL() {
+		if sync {
+			return errors.New("pebble: cannot sync a batch that skips the WAL")
+		}
+		batch.noWAL = true
+	}
 
 	if batch.minimumFormatMajorVersion != FormatMostCompatible {
 		if fmv := d.FormatMajorVersion(); fmv < batch.minimumFormatMajorVersion {
@@ -838,17 +1264,90 @@ func (d *DB) applyInternal(batch *Batch, opts *WriteOptions, noSyncWait bool) er
 		// TODO(jackson): Assert that all range key operands are suffixless.
 	}
 
+	admissionWait, err := d.admitWrite(opts.GetPriority(), noWait)
+	if err != nil {
+		return err
+	}
+	if noWait {
+		d.mu.Lock()
+		stalled := d.mu.writeStall.active
+		d.mu.Unlock()
+		if stalled {
+			return errWriteWouldWait
+		}
+	} else if d.opts.MaxWriteStallDuration > 0 {
+		if err := d.waitForWriteStall(); err != nil {
+			return err
+		}
+	}
+	if !d.opts.DisableWAL && !batch.noWAL && d.opts.MaxUnflushedWALBytes > 0 {
+		if err := d.applyWALQuota(batch, noWait); err != nil {
+			return err
+		}
+	}
+
+	if batch.idempotencyToken != nil {
+		tokens := &d.mu.idempotencyTokens
+		d.mu.Lock()
+		_, applied := tokens.m[string(batch.idempotencyToken)]
+		if applied {
+			// A batch with the same token has already been applied. If it is
+			// still being committed, wait for it so that the batch's writes
+			// are visible when we return.
+			for tokens.m[string(batch.idempotencyToken)] == InternalKeySeqNumMax {
+				tokens.cond.Wait()
+			}
+		} else {
+			if tokens.m == nil {
+				tokens.m = make(map[string]uint64)
+			}
+			tokens.m[string(batch.idempotencyToken)] = InternalKeySeqNumMax
+		}
+		d.mu.Unlock()
+		if applied {
+			if sync {
+				// The earlier batch may have been committed without syncing
+				// the WAL. Sync the WAL so that it is durable before we return.
+				return d.LogData(nil, Sync)
+			}
+			return nil
+		}
+	}
+
 	if batch.db == nil {
 		batch.refreshMemTableSize()
 	}
 	if int(batch.memTableSize) >= d.largeBatchThreshold {
 		batch.flushable = newFlushableBatch(batch, d.opts.Comparer)
 	}
-	if err := d.commit.Commit(batch, sync, noSyncWait); err != nil {
+	lane := commitLaneDefault
+	switch {
+	case opts.GetLatencySensitive():
+		lane = commitLaneLatencySensitive
+	case opts.GetPriority() == WritePriorityBulk:
+		lane = commitLaneBulk
+	}
+	if d.rowCache != nil {
+		d.rowCache.beginWrite(batch)
+	}
+	if err := d.commit.Commit(batch, sync, noSyncWait, lane); err != nil {
 		// There isn't much we can do on an error here. The commit pipeline will be
 		// horked at this point.
 		d.opts.Logger.Fatalf("pebble: fatal commit error: %v", err)
 	}
+	if d.rowCache != nil {
+		d.rowCache.endWrite(batch)
+	}
+	d.subscriptions.notify()
+	batch.commitStats.AdmissionWaitDuration = admissionWait
+	batch.commitStats.TotalDuration += admissionWait
+	batch.commitStats.TotalDuration += batch.commitStats.WALQuotaWaitDuration
+	if batch.idempotencyToken != nil {
+		d.mu.Lock()
+		d.mu.idempotencyTokens.m[string(batch.idempotencyToken)] = batch.SeqNum()
+		d.mu.idempotencyTokens.cond.Broadcast()
+		d.mu.Unlock()
+	}
 	// If this is a large batch, we need to clear the batch contents as the
 	// flushable batch may still be present in the flushables queue.
 	//
@@ -857,12 +1356,16 @@ func (d *DB) applyInternal(batch *Batch, opts *WriteOptions, noSyncWait bool) er
 	// an sstable. For a 100 MB batch, this might actually be faster. For a 1
 	// GB batch this is almost certainly faster.
 	if batch.flushable != nil {
+		if batch.arena != nil {
+			batch.arena.retain(batch)
+		}
 		batch.data = nil
 	}
 	return nil
 }
 
 func (d *DB) commitApply(b *Batch, mem *memTable) error {
+	d.subscriptions.add(b)
 	if b.flushable != nil {
 		// This is a large batch which was already added to the immutable queue.
 		return nil
@@ -901,6 +1404,7 @@ func (d *DB) commitApply(b *Batch, mem *memTable) error {
 func (d *DB) commitWrite(b *Batch, syncWG *sync.WaitGroup, syncErr *error) (*memTable, error) {
 	var size int64
 	repr := b.Repr()
+	disableWAL := d.opts.DisableWAL || b.noWAL
 
 	if b.flushable != nil {
 		// We have a large batch. Such batches are special in that they don't get
@@ -913,9 +1417,9 @@ func (d *DB) commitWrite(b *Batch, syncWG *sync.WaitGroup, syncErr *error) (*mem
 		// Set the sequence number since it was not set to the correct value earlier
 		// (see comment in newFlushableBatch()).
 		b.flushable.setSeqNum(b.SeqNum())
-		if !d.opts.DisableWAL {
+		if !disableWAL {
 			var err error
-			size, err = d.mu.log.SyncRecord(repr, syncWG, syncErr)
+			size, err = d.writeWALRecord(repr, syncWG, syncErr)
 			if err != nil {
 				panic(err)
 			}
@@ -933,7 +1437,7 @@ func (d *DB) commitWrite(b *Batch, syncWG *sync.WaitGroup, syncErr *error) (*mem
 		err = d.makeRoomForWrite(b)
 	}
 
-	if err == nil && !d.opts.DisableWAL {
+	if err == nil && !disableWAL {
 		d.mu.log.bytesIn += uint64(len(repr))
 	}
 
@@ -948,12 +1452,12 @@ func (d *DB) commitWrite(b *Batch, syncWG *sync.WaitGroup, syncErr *error) (*mem
 		return nil, err
 	}
 
-	if d.opts.DisableWAL {
+	if disableWAL {
 		return mem, nil
 	}
 
 	if b.flushable == nil {
-		size, err = d.mu.log.SyncRecord(repr, syncWG, syncErr)
+		size, err = d.writeWALRecord(repr, syncWG, syncErr)
 		if err != nil {
 			panic(err)
 		}
@@ -963,6 +1467,20 @@ func (d *DB) commitWrite(b *Batch, syncWG *sync.WaitGroup, syncErr *error) (*mem
 	return mem, err
 }
 
+// appendWALRecord writes the record to the WAL, encrypting it if the WAL is
+// encrypted. Requires commitPipeline.mu to be held.
+func (d *DB) appendWALRecord(
+	rec []byte, syncWG *sync.WaitGroup, syncErr *error,
+) (int64, error) {
+	if d.mu.log.cipher != nil {
+		var err error
+		if rec, err = d.mu.log.cipher.seal(rec); err != nil {
+			return 0, err
+		}
+	}
+	return d.mu.log.SyncRecord(rec, syncWG, syncErr)
+}
+
 type iterAlloc struct {
 	dbi                 Iterator
 	keyBuf              []byte
@@ -1331,6 +1849,13 @@ func (i *Iterator) constructPointIter(
 	if i.opts.RangeKeyMasking.Filter != nil {
 		internalOpts.boundLimitedFilter = &i.rangeKeyMasking
 	}
+	if i.opts.SkipCacheAdmission {
+		if i.bufferPool == nil {
+			i.bufferPool = new(sstable.BufferPool)
+			i.bufferPool.Init(2)
+		}
+		internalOpts.bufferPool = i.bufferPool
+	}
 
 	// Merging levels and levels from iterAlloc.
 	mlevels := buf.mlevels[:0]
@@ -1397,7 +1922,7 @@ func (i *Iterator) constructPointIter(
 
 	// Next are the memtables.
 	for j := len(memtables) - 1; j >= 0; j-- {
-		mem := memtables[j]
+		mem := flushableView(i.comparer.Compare, i.readState.current, memtables[j])
 		mlevels = append(mlevels, mergingIterLevel{
 			iter:         mem.newIter(&i.opts),
 			rangeDelIter: mem.newRangeDelIter(&i.opts),
@@ -1532,6 +2057,10 @@ func (d *DB) NewEventuallyFileOnlySnapshot(keyRanges []KeyRange) *EventuallyFile
 // or to call Close concurrently with any other DB method. It is not valid
 // to call any of a DB's methods after the DB has been closed.
 func (d *DB) Close() error {
+	// Stop accepting asynchronous commits before the DB is marked closed, so
+	// that ApplyAsync returns ErrClosed rather than racing with Close.
+	asyncCommitsDone := d.closeAsyncCommits()
+
 	// Lock the commit pipeline for the duration of Close. This prevents a race
 	// with makeRoomForWrite. Rotating the WAL in makeRoomForWrite requires
 	// dropping d.mu several times for I/O. If Close only holds d.mu, an
@@ -1559,8 +2088,13 @@ func (d *DB) Close() error {
 
 	d.closed.Store(errors.WithStack(ErrClosed))
 	close(d.closedCh)
+	// Wake writers delayed by admission control.
+	d.mu.compact.cond.Broadcast()
 
 	defer d.opts.Cache.Unref()
+	if d.opts.CacheQuota > 0 {
+		defer d.opts.Cache.SetQuota(d.cacheID, 0)
+	}
 
 	for d.mu.compact.compactingCount > 0 || d.mu.compact.flushing {
 		d.mu.compact.cond.Wait()
@@ -1568,9 +2102,15 @@ func (d *DB) Close() error {
 	for d.mu.tableStats.loading {
 		d.mu.tableStats.cond.Wait()
 	}
+	for d.mu.mem.buildingFilters > 0 {
+		d.mu.compact.cond.Wait()
+	}
 	for d.mu.tableValidation.validating {
 		d.mu.tableValidation.cond.Wait()
 	}
+	for d.mu.tableWarming.warming {
+		d.mu.tableWarming.cond.Wait()
+	}
 
 	var err error
 	if n := len(d.mu.compact.inProgress); n > 0 {
@@ -1578,12 +2118,24 @@ func (d *DB) Close() error {
 	}
 	err = firstError(err, d.mu.formatVers.marker.Close())
 	err = firstError(err, d.tableCache.close())
+	err = firstError(err, d.blobFiles.close())
+	d.memoryShrink.mu.Lock()
+	d.memoryRelease(MemoryKindBlockCache, d.memoryShrink.blockCacheSize-d.memoryShrink.blockCacheBytes)
+	d.memoryShrink.mu.Unlock()
+	d.releaseMemoryShrink()
 	if !d.opts.ReadOnly {
 		err = firstError(err, d.mu.log.Close())
 	} else if d.mu.log.LogWriter != nil {
 		panic("pebble: log-writer should be nil in read-only mode")
 	}
-	err = firstError(err, d.fileLock.Close())
+	// Closing the WAL completes any outstanding syncs. Wait for the callbacks
+	// of asynchronous commits to be invoked.
+	if asyncCommitsDone != nil {
+		<-asyncCommitsDone
+	}
+	if d.fileLock != nil {
+		err = firstError(err, d.fileLock.Close())
+	}
 
 	// Note that versionSet.close() only closes the MANIFEST. The versions list
 	// is still valid for the checks below.
@@ -1669,13 +2221,74 @@ func (d *DB) Close() error {
 	return err
 }
 
+// ManualCompactionPriority is the priority of a manual compaction relative to
+// automatic compactions.
+type ManualCompactionPriority int8
+
+const (
+	// ManualCompactionPriorityHigh schedules manual compactions ahead of
+	// automatic compactions. This is the default.
+	ManualCompactionPriorityHigh ManualCompactionPriority = iota
+	// ManualCompactionPriorityLow schedules manual compactions only once no
+	// automatic compaction is ready to be scheduled, so that they do not delay
+	// compactions that keep the LSM healthy.
+	ManualCompactionPriorityLow
+)
+
+// String implements fmt.Stringer.
+func (p ManualCompactionPriority) String() string {
+	switch p {
+	case ManualCompactionPriorityHigh:
+		return "high"
+	case ManualCompactionPriorityLow:
+		return "low"
+	default:
+		return "unknown"
+	}
+}
+
+// ManualCompactionProgress describes the progress of a call to
+// CompactWithOptions.
+type ManualCompactionProgress struct {
+	// Level is the level most recently compacted.
+	Level int
+	// FilesCompleted and BytesCompleted are the number and the total size of
+	// the input sstables of the compactions that have completed so far.
+	FilesCompleted int
+	BytesCompleted uint64
+}
+
+// CompactOptions holds the optional parameters for CompactWithOptions.
+type CompactOptions struct {
+	// Parallelize splits the compaction of each level into compactions of
+	// non-overlapping key ranges that may run concurrently.
+	Parallelize bool
+	// Priority is the priority of the compaction relative to automatic
+	// compactions.
+	Priority ManualCompactionPriority
+	// Progress, if set, is called after each of the compactions that make up
+	// the manual compaction completes. It is called from the goroutine that
+	// called CompactWithOptions.
+	Progress func(ManualCompactionProgress)
+}
+
 // Compact the specified range of keys in the database.
 func (d *DB) Compact(start, end []byte, parallelize bool) error {
+	return d.CompactWithOptions(context.Background(), start, end, CompactOptions{Parallelize: parallelize})
+}
+
+// CompactWithOptions compacts the specified range of keys in the database,
+// like Compact. If ctx is canceled, queued compactions are dropped, running
+// compactions are canceled once they finish their current output sstable, and
+// ctx.Err() is returned.
+func (d *DB) CompactWithOptions(
+	ctx context.Context, start, end []byte, opts CompactOptions,
+) error {
 	if err := d.closed.Load(); err != nil {
 		panic(err)
 	}
-	if d.opts.ReadOnly {
-		return ErrReadOnly
+	if err := d.checkWritable(); err != nil {
+		return err
 	}
 	if d.cmp(start, end) >= 0 {
 		return errors.Errorf("Compact start %s is not less than end %s",
@@ -1702,48 +2315,24 @@ func (d *DB) Compact(start, end []byte, parallelize bool) error {
 	}
 	// Determine if any memtable overlaps with the compaction range. We wait for
 	// any such overlap to flush (initiating a flush if necessary).
-	mem, err := func() (*flushableEntry, error) {
-		// Check to see if any files overlap with any of the memtables. The queue
-		// is ordered from oldest to newest with the mutable memtable being the
-		// last element in the slice. We want to wait for the newest table that
-		// overlaps.
-		for i := len(d.mu.mem.queue) - 1; i >= 0; i-- {
-			mem := d.mu.mem.queue[i]
-			if ingestMemtableOverlaps(d.cmp, mem, keyRanges) {
-				var err error
-				if mem.flushable == d.mu.mem.mutable {
-					// We have to hold both commitPipeline.mu and DB.mu when calling
-					// makeRoomForWrite(). Lock order requirements elsewhere force us to
-					// unlock DB.mu in order to grab commitPipeline.mu first.
-					d.mu.Unlock()
-					d.commit.mu.Lock()
-					d.mu.Lock()
-					defer d.commit.mu.Unlock()
-					if mem.flushable == d.mu.mem.mutable {
-						// Only flush if the active memtable is unchanged.
-						err = d.makeRoomForWrite(nil)
-					}
-				}
-				mem.flushForced = true
-				d.maybeScheduleFlush()
-				return mem, err
-			}
-		}
-		return nil, nil
-	}()
-
+	mem, err := d.flushOverlappingMemtablesLocked(keyRanges)
 	d.mu.Unlock()
 
 	if err != nil {
 		return err
 	}
 	if mem != nil {
-		<-mem.flushed
+		select {
+		case <-mem.flushed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 
+	var progress ManualCompactionProgress
 	for level := 0; level < maxLevelWithFiles; {
 		if err := d.manualCompact(
-			iStart.UserKey, iEnd.UserKey, level, parallelize); err != nil {
+			ctx, iStart.UserKey, iEnd.UserKey, level, &opts, &progress); err != nil {
 			return err
 		}
 		level++
@@ -1756,7 +2345,51 @@ func (d *DB) Compact(start, end []byte, parallelize bool) error {
 	return nil
 }
 
-func (d *DB) manualCompact(start, end []byte, level int, parallelize bool) error {
+// flushOverlappingMemtablesLocked forces a flush of the newest flushable that
+// overlaps any of keyRanges, rotating the mutable memtable if it is that
+// flushable. Flushes proceed in order, so all older flushables are flushed as
+// well. It returns the flushable to wait on, or nil if no flushable overlaps
+// keyRanges. DB.mu must be held when calling this method; it is temporarily
+// released if the mutable memtable needs to be rotated.
+func (d *DB) flushOverlappingMemtablesLocked(
+	keyRanges []internalKeyRange,
+) (*flushableEntry, error) {
+	// Check to see if any files overlap with any of the memtables. The queue
+	// is ordered from oldest to newest with the mutable memtable being the
+	// last element in the slice. We want to wait for the newest table that
+	// overlaps.
+	for i := len(d.mu.mem.queue) - 1; i >= 0; i-- {
+		mem := d.mu.mem.queue[i]
+		if ingestMemtableOverlaps(d.cmp, mem, keyRanges) {
+			var err error
+			if mem.flushable == d.mu.mem.mutable {
+				// We have to hold both commitPipeline.mu and DB.mu when calling
+				// makeRoomForWrite(). Lock order requirements elsewhere force us to
+				// unlock DB.mu in order to grab commitPipeline.mu first.
+				d.mu.Unlock()
+				d.commit.mu.Lock()
+				d.mu.Lock()
+				defer d.commit.mu.Unlock()
+				if mem.flushable == d.mu.mem.mutable {
+					// Only flush if the active memtable is unchanged.
+					err = d.makeRoomForWrite(nil)
+				}
+			}
+			mem.flushForced = true
+			d.maybeScheduleFlush()
+			return mem, err
+		}
+	}
+	return nil, nil
+}
+
+func (d *DB) manualCompact(
+	ctx context.Context,
+	start, end []byte,
+	level int,
+	opts *CompactOptions,
+	progress *ManualCompactionProgress,
+) error {
 	d.mu.Lock()
 	curr := d.mu.versions.currentVersion()
 	files := curr.Overlaps(level, d.cmp, start, end, false)
@@ -1766,7 +2399,7 @@ func (d *DB) manualCompact(start, end []byte, level int, parallelize bool) error
 	}
 
 	var compactions []*manualCompaction
-	if parallelize {
+	if opts.Parallelize {
 		compactions = append(compactions, d.splitManualCompaction(start, end, level)...)
 	} else {
 		compactions = append(compactions, &manualCompaction{
@@ -1776,6 +2409,9 @@ func (d *DB) manualCompact(start, end []byte, level int, parallelize bool) error
 			end:   end,
 		})
 	}
+	for _, compaction := range compactions {
+		compaction.priority = opts.Priority
+	}
 	d.mu.compact.manual = append(d.mu.compact.manual, compactions...)
 	d.maybeScheduleCompaction()
 	d.mu.Unlock()
@@ -1788,15 +2424,134 @@ func (d *DB) manualCompact(start, end []byte, level int, parallelize bool) error
 	// necessary to read from each channel, and so we can exit early in the event
 	// of an error.
 	for _, compaction := range compactions {
-		if err := <-compaction.done; err != nil {
-			return err
+		select {
+		case err := <-compaction.done:
+			if err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			d.cancelManualCompactions(compactions)
+			return ctx.Err()
+		}
+		// The compaction is nil if there was nothing to compact. Its inputs
+		// are immutable once it has been scheduled.
+		if c := compaction.c; c != nil {
+			progress.Level = level
+			for _, cl := range c.inputs {
+				progress.FilesCompleted += cl.files.Len()
+				progress.BytesCompleted += cl.files.SizeSum()
+			}
+			if opts.Progress != nil {
+				opts.Progress(*progress)
+			}
+		}
+	}
+	return nil
+}
+
+// cancelManualCompactions removes the provided manual compactions from the
+// queue of manual compactions, and cancels those that are running.
+func (d *DB) cancelManualCompactions(compactions []*manualCompaction) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	canceled := make(map[*manualCompaction]struct{}, len(compactions))
+	for _, compaction := range compactions {
+		canceled[compaction] = struct{}{}
+		if compaction.c != nil {
+			compaction.c.cancel.Store(true)
+		}
+	}
+	queue := d.mu.compact.manual[:0]
+	for _, m := range d.mu.compact.manual {
+		if _, ok := canceled[m]; !ok {
+			queue = append(queue, m)
 		}
 	}
+	d.mu.compact.manual = queue
+}
+
+// PauseCompactions stops the scheduling of automatic compactions, and waits
+// for in-progress compactions to complete. Flushes continue to run, and
+// compactions requested through Compact are still performed. While
+// compactions are paused, compaction debt accumulates (see
+// Metrics.Compact.EstimatedDebt) and L0 grows, which eventually stalls writes
+// once L0StopWritesThreshold is reached. Calling PauseCompactions while
+// compactions are already paused has no effect.
+func (d *DB) PauseCompactions() error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.mu.compact.paused {
+		d.mu.compact.paused = true
+		d.mu.compact.pausedAt = d.timeNow()
+	}
+	for d.mu.compact.compactingCount > 0 {
+		d.mu.compact.cond.Wait()
+	}
+	return nil
+}
+
+// ResumeCompactions resumes the scheduling of automatic compactions after a
+// call to PauseCompactions.
+func (d *DB) ResumeCompactions() {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.mu.compact.paused {
+		return
+	}
+	d.mu.compact.paused = false
+	d.maybeScheduleCompaction()
+}
+
+// maxHotRanges is the maximum number of key ranges suggested by
+// DB.SuggestCompactRange that are retained. Once exceeded, the oldest ranges
+// are forgotten.
+const maxHotRanges = 16
+
+// SuggestCompactRange marks the key range [start, end) as hot. Automatic
+// compactions prefer to compact sstables overlapping hot key ranges, and
+// when no other compaction is needed, compact hot key ranges until reads
+// within them consult a single sstable. Unlike Compact, SuggestCompactRange
+// does not wait for any compaction to be performed.
+func (d *DB) SuggestCompactRange(start, end []byte) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if d.cmp(start, end) >= 0 {
+		return errors.Errorf("SuggestCompactRange start %s is not less than end %s",
+			d.opts.Comparer.FormatKey(start), d.opts.Comparer.FormatKey(end))
+	}
+	kr := KeyRange{
+		Start: append([]byte(nil), start...),
+		End:   append([]byte(nil), end...),
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if n := len(d.mu.compact.hotRanges); n >= maxHotRanges {
+		d.mu.compact.hotRanges = append(d.mu.compact.hotRanges[:0], d.mu.compact.hotRanges[n-maxHotRanges+1:]...)
+	}
+	d.mu.compact.hotRanges = append(d.mu.compact.hotRanges, kr)
+	d.maybeScheduleCompaction()
 	return nil
 }
 
 // splitManualCompaction splits a manual compaction over [start,end] on level
-// such that the resulting compactions have no key overlap.
+// such that the resulting compactions have no key overlap. Compactions of
+// levels other than L0 are further split into subcompactions of roughly
+// equal size, so that a manual compaction of a large key range is spread
+// across up to MaxConcurrentCompactions concurrent compactions.
 func (d *DB) splitManualCompaction(
 	start, end []byte, level int,
 ) (splitCompactions []*manualCompaction) {
@@ -1807,6 +2562,22 @@ func (d *DB) splitManualCompaction(
 		endLevel = baseLevel
 	}
 	keyRanges := calculateInuseKeyRanges(curr, d.cmp, level, endLevel, start, end)
+	if level > 0 {
+		// Each subcompaction targets an equal share of the level's bytes
+		// within the compacted key range, but no less than the output
+		// level's target file size.
+		files := curr.Overlaps(level, d.cmp, start, end, false)
+		targetBytes := files.SizeSum() / uint64(d.opts.MaxConcurrentCompactions())
+		if minBytes := uint64(d.opts.Level(endLevel).TargetFileSize); targetBytes < minBytes {
+			targetBytes = minBytes
+		}
+		var subRanges []manifest.UserKeyRange
+		for _, keyRange := range keyRanges {
+			subRanges = append(subRanges,
+				splitManualCompactionRange(curr, d.cmp, level, endLevel, keyRange, targetBytes)...)
+		}
+		keyRanges = subRanges
+	}
 	for _, keyRange := range keyRanges {
 		splitCompactions = append(splitCompactions, &manualCompaction{
 			level: level,
@@ -1819,6 +2590,50 @@ func (d *DB) splitManualCompaction(
 	return splitCompactions
 }
 
+// splitManualCompactionRange splits the key range kr of a manual compaction
+// from level into outputLevel into subranges whose level files total at
+// least targetBytes. Subranges are only split between adjacent level files
+// that do not share a user key and are not both overlapped by the same
+// outputLevel file, so that the compactions of the subranges have
+// non-overlapping inputs and outputs.
+func splitManualCompactionRange(
+	v *version, cmp Compare, level, outputLevel int, kr manifest.UserKeyRange, targetBytes uint64,
+) []manifest.UserKeyRange {
+	var ranges []manifest.UserKeyRange
+	rangeStart := kr.Start
+	var rangeBytes uint64
+	files := v.Overlaps(level, cmp, kr.Start, kr.End, false)
+	iter := files.Iter()
+	for f := iter.First(); f != nil; {
+		rangeBytes += f.Size
+		next := iter.Next()
+		if next == nil {
+			break
+		}
+		if rangeBytes >= targetBytes && cmp(f.Largest.UserKey, next.Smallest.UserKey) < 0 &&
+			!spansGap(v, cmp, outputLevel, f.Largest.UserKey, next.Smallest.UserKey) {
+			ranges = append(ranges, manifest.UserKeyRange{Start: rangeStart, End: f.Largest.UserKey})
+			rangeStart = next.Smallest.UserKey
+			rangeBytes = 0
+		}
+		f = next
+	}
+	return append(ranges, manifest.UserKeyRange{Start: rangeStart, End: kr.End})
+}
+
+// spansGap returns true if a file in level contains both the user keys before
+// and after, which must satisfy before < after.
+func spansGap(v *version, cmp Compare, level int, before, after []byte) bool {
+	files := v.Overlaps(level, cmp, before, after, false)
+	iter := files.Iter()
+	for f := iter.First(); f != nil; f = iter.Next() {
+		if cmp(f.Smallest.UserKey, before) <= 0 && cmp(f.Largest.UserKey, after) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
 // DownloadSpan is a key range passed to the Download method.
 type DownloadSpan struct {
 	StartKey []byte
@@ -1853,6 +2668,121 @@ func (d *DB) Flush() error {
 	return nil
 }
 
+// FlushRange flushes the memtable data within the range [start, end) to
+// stable storage. The keys, range deletions and range keys within the range
+// are flushed out of the memtables holding them, rotating the mutable
+// memtable if it holds any, while the data outside of the range is left in
+// the memtables. FlushRange returns once the flush has completed, or
+// immediately if no memtable overlaps the range.
+//
+// If the format major version is older than ExperimentalFormatFlushedSpans,
+// or if an ingestion waiting to be flushed precedes the memtables overlapping
+// the range, these memtables are instead flushed in their entirety, along
+// with the older memtables.
+func (d *DB) FlushRange(start, end []byte) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	if d.cmp(start, end) >= 0 {
+		return errors.Errorf("FlushRange start %s is not less than end %s",
+			d.opts.Comparer.FormatKey(start), d.opts.Comparer.FormatKey(end))
+	}
+
+	keyRanges := []internalKeyRange{{
+		smallest: base.MakeInternalKey(start, InternalKeySeqNumMax, InternalKeyKindMax),
+		largest:  base.MakeExclusiveSentinelKey(InternalKeyKindRangeDelete, end),
+	}}
+	d.commit.mu.Lock()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	mem := d.newestOverlappingMemtableLocked(keyRanges)
+	if mem == nil {
+		d.commit.mu.Unlock()
+		return nil
+	}
+	splittable := d.FormatMajorVersion() >= ExperimentalFormatFlushedSpans
+	for _, f := range d.mu.mem.queue {
+		if _, ok := f.flushable.(*ingestedFlushable); ok {
+			// The ingestion must be flushed after the data preceding it.
+			splittable = false
+		}
+		if f == mem {
+			break
+		}
+	}
+	if !splittable {
+		d.commit.mu.Unlock()
+		mem, err := d.flushOverlappingMemtablesLocked(keyRanges)
+		if err != nil || mem == nil {
+			return err
+		}
+		d.mu.Unlock()
+		<-mem.flushed
+		d.mu.Lock()
+		return nil
+	}
+	if mem.flushable == d.mu.mem.mutable {
+		// Only the keys within the range are flushed out of the rotated
+		// memtable, so it isn't forced to flush.
+		if err := d.makeRoomForWriteImpl(nil, false /* forceFlush */); err != nil {
+			d.commit.mu.Unlock()
+			return err
+		}
+	}
+	d.commit.mu.Unlock()
+	return d.flushRangeLocked(mem, start, end)
+}
+
+// newestOverlappingMemtableLocked returns the newest flushable that overlaps
+// any of keyRanges, or nil if none does. DB.mu must be held when calling this.
+func (d *DB) newestOverlappingMemtableLocked(keyRanges []internalKeyRange) *flushableEntry {
+	for i := len(d.mu.mem.queue) - 1; i >= 0; i-- {
+		if mem := d.mu.mem.queue[i]; ingestMemtableOverlaps(d.cmp, mem, keyRanges) {
+			return mem
+		}
+	}
+	return nil
+}
+
+// FlushWithInfo flushes the memtable to stable storage, like Flush, and
+// returns the FlushInfo of each of the flushes that flushed the data written
+// before the call, from oldest to newest. The Output and OutputProperties of
+// the returned FlushInfos identify exactly the sstables that received that
+// data when they were flushed; these sstables may since have been compacted.
+// A FlushInfo may also describe data written concurrently with the call.
+func (d *DB) FlushWithInfo() ([]FlushInfo, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if err := d.checkWritable(); err != nil {
+		return nil, err
+	}
+
+	d.commit.mu.Lock()
+	d.mu.Lock()
+	// Every flushable in the queue holds data written before the call, and
+	// is flushed no later than the mutable memtable.
+	queue := append(flushableList(nil), d.mu.mem.queue...)
+	err := d.makeRoomForWrite(nil)
+	d.mu.Unlock()
+	d.commit.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	var infos []FlushInfo
+	for _, mem := range queue {
+		<-mem.flushed
+		// Consecutive flushables flushed by the same flush share a FlushInfo.
+		if n := len(infos); n == 0 || infos[n-1].JobID != mem.flushInfo.JobID {
+			infos = append(infos, *mem.flushInfo)
+		}
+	}
+	return infos, nil
+}
+
 // AsyncFlush asynchronously flushes the memtable to stable storage.
 //
 // If no error is returned, the caller can receive from the returned channel in
@@ -1861,8 +2791,8 @@ func (d *DB) AsyncFlush() (<-chan struct{}, error) {
 	if err := d.closed.Load(); err != nil {
 		panic(err)
 	}
-	if d.opts.ReadOnly {
-		return nil, ErrReadOnly
+	if err := d.checkWritable(); err != nil {
+		return nil, err
 	}
 
 	d.commit.mu.Lock()
@@ -1881,6 +2811,7 @@ func (d *DB) AsyncFlush() (<-chan struct{}, error) {
 func (d *DB) Metrics() *Metrics {
 	metrics := &Metrics{}
 	recycledLogsCount, recycledLogSize := d.logRecycler.stats()
+	secondaryCache := d.objProvider.Metrics()
 
 	d.mu.Lock()
 	vers := d.mu.versions.currentVersion()
@@ -1889,6 +2820,10 @@ func (d *DB) Metrics() *Metrics {
 	metrics.Compact.InProgressBytes = d.mu.versions.atomicInProgressBytes.Load()
 	metrics.Compact.NumInProgress = int64(d.mu.compact.compactingCount)
 	metrics.Compact.MarkedFiles = vers.Stats.MarkedForCompaction
+	if d.mu.compact.paused {
+		metrics.Compact.Paused = true
+		metrics.Compact.PausedDuration = d.timeNow().Sub(d.mu.compact.pausedAt)
+	}
 	metrics.Compact.Duration = d.mu.compact.duration
 	for c := range d.mu.compact.inProgress {
 		if c.kind != compactionKindFlush {
@@ -1911,6 +2846,7 @@ func (d *DB) Metrics() *Metrics {
 	metrics.WAL.ObsoleteFiles = int64(recycledLogsCount)
 	metrics.WAL.ObsoletePhysicalSize = recycledLogSize
 	metrics.WAL.Size = d.logSize.Load()
+	metrics.WAL.QuotaSize = uint64(d.opts.MaxUnflushedWALBytes)
 	// The current WAL size (d.atomic.logSize) is the current logical size,
 	// which may be less than the WAL's physical size if it was recycled.
 	// The file sizes in d.mu.log.queue are updated to the physical size
@@ -1939,6 +2875,12 @@ func (d *DB) Metrics() *Metrics {
 	for _, size := range d.mu.versions.zombieTables {
 		metrics.Table.ZombieSize += size
 	}
+	for _, s := range d.mu.versions.blobFiles {
+		metrics.BlobFiles.Count++
+		metrics.BlobFiles.Size += s.meta.Size
+		metrics.BlobFiles.ValueSize += s.meta.ValueSize
+		metrics.BlobFiles.LiveValueSize += s.liveValueSize
+	}
 	metrics.private.optionsFileSize = d.optionsFileSize
 
 	// TODO(jackson): Consider making these metrics optional.
@@ -1950,6 +2892,18 @@ func (d *DB) Metrics() *Metrics {
 	d.mu.versions.logUnlock()
 
 	metrics.LogWriter.FsyncLatency = d.mu.log.metrics.fsyncLatency
+	metrics.Commit.QueueDepth = len(d.commit.commitQueueSem)
+	metrics.Commit.SyncLatency = d.mu.log.metrics.fsyncLatency
+	if m := d.commit.metrics; m != nil {
+		metrics.Commit.EnqueueLatency = m.enqueueLatency
+		metrics.Commit.WALWriteLatency = m.walWriteLatency
+		metrics.Commit.MemTableApplyLatency = m.applyLatency
+		metrics.Commit.PublishLatency = m.publishLatency
+		metrics.Commit.CommitWaitLatency = m.commitWaitLatency
+	}
+	if d.diskOpTracer != nil {
+		metrics.DiskOps.Latency = d.diskOpTracer.latency
+	}
 	if err := metrics.LogWriter.Merge(&d.mu.log.metrics.LogWriterMetrics); err != nil {
 		d.opts.Logger.Infof("metrics error: %s", err)
 	}
@@ -1959,17 +2913,58 @@ func (d *DB) Metrics() *Metrics {
 	}
 	for i := 0; i < numLevels; i++ {
 		metrics.Levels[i].Additional.ValueBlocksSize = valueBlocksSizeForLevel(vers, i)
+		metrics.Levels[i].Additional.SecondaryCache = secondaryCacheMetricsForLevel(vers, i, secondaryCache.Files)
 	}
+	metrics.SecondaryCache = secondaryCache
+	metrics.SecondaryCacheWarm = d.mu.tableWarming.metrics
 
 	d.mu.Unlock()
 
 	metrics.BlockCache = d.opts.Cache.Metrics()
+	metrics.BlockCacheDB = d.opts.Cache.IDMetrics(d.cacheID)
 	metrics.TableCache, metrics.Filter = d.tableCache.metrics()
+	blockCacheByLevel := d.tableCache.blockCacheMetrics()
+	for i := range blockCacheByLevel {
+		if i < numLevels {
+			metrics.Levels[i].Additional.BlockCache = blockCacheByLevel[i]
+		}
+		metrics.BlockCacheByKind.Add(&blockCacheByLevel[i])
+	}
+	if d.rowCache != nil {
+		metrics.RowCache = d.rowCache.metrics()
+	}
 	metrics.TableIters = int64(d.tableCache.iterCount())
 	metrics.Uptime = d.timeNow().Sub(d.openedAt)
 	return metrics
 }
 
+// secondaryCacheMetricsForLevel sums the secondary cache metrics of the
+// sstables in the level of the version. Virtual sstables that share a backing
+// count its metrics once.
+func secondaryCacheMetricsForLevel(
+	v *version, level int, files map[base.DiskFileNum]SecondaryCacheFileMetrics,
+) SecondaryCacheFileMetrics {
+	var m SecondaryCacheFileMetrics
+	if len(files) == 0 {
+		return m
+	}
+	seen := make(map[base.DiskFileNum]struct{})
+	iter := v.Levels[level].Iter()
+	for f := iter.First(); f != nil; f = iter.Next() {
+		fileNum := f.FileBacking.DiskFileNum
+		if _, ok := seen[fileNum]; ok {
+			continue
+		}
+		seen[fileNum] = struct{}{}
+		if fm, ok := files[fileNum]; ok {
+			m.Hits += fm.Hits
+			m.Misses += fm.Misses
+			m.Evictions += fm.Evictions
+		}
+	}
+	return m
+}
+
 // sstablesOptions hold the optional parameters to retrieve TableInfo for all sstables.
 type sstablesOptions struct {
 	// set to true will return the sstable properties in TableInfo
@@ -2158,6 +3153,104 @@ func (d *DB) SSTables(opts ...SSTablesOption) ([][]SSTableInfo, error) {
 	return destLevels, nil
 }
 
+// AggregateTableProperties aggregates the user properties of the sstables
+// overlapping the key range [start, end) collected by the configured
+// Options.TablePropertyCollectors that implement
+// AggregatingTablePropertyCollector. If start and end are both nil, the
+// properties of all sstables are aggregated.
+//
+// The properties of an sstable are collected when it is written, so the
+// entire properties of sstables partially overlapping the key range are
+// aggregated, and virtual sstables sharing a backing sstable contribute its
+// properties only once. Keys not yet flushed from the memtables aren't
+// included. As with SSTables, the result may be out of date due to concurrent
+// flushes and compactions.
+func (d *DB) AggregateTableProperties(start, end []byte) (map[string]string, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if (start == nil) != (end == nil) {
+		return nil, errors.New("pebble: start and end must both be set or both be nil")
+	}
+	if start != nil && d.opts.Comparer.Compare(start, end) > 0 {
+		return nil, errors.New("invalid key-range specified (start > end)")
+	}
+
+	var collectors []AggregatingTablePropertyCollector
+	for _, fn := range d.opts.TablePropertyCollectors {
+		if c, ok := fn().(AggregatingTablePropertyCollector); ok {
+			collectors = append(collectors, c)
+		}
+	}
+	aggregate := make(map[string]string)
+	if len(collectors) == 0 {
+		return aggregate, nil
+	}
+
+	// Grab and reference the current readState.
+	readState := d.loadReadState()
+	defer readState.unref()
+
+	seen := make(map[base.DiskFileNum]struct{})
+	for level := range readState.current.Levels {
+		files := readState.current.Levels[level].Slice()
+		if start != nil {
+			files = readState.current.Overlaps(level, d.cmp, start, end, true /* exclusiveEnd */)
+		}
+		iter := files.Iter()
+		for m := iter.First(); m != nil; m = iter.Next() {
+			if _, ok := seen[m.FileBacking.DiskFileNum]; ok {
+				continue
+			}
+			seen[m.FileBacking.DiskFileNum] = struct{}{}
+			p, err := d.tableCache.getTableProperties(m)
+			if err != nil {
+				return nil, err
+			}
+			for _, c := range collectors {
+				if err := c.Aggregate(aggregate, p.UserProperties); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return aggregate, nil
+}
+
+// CacheResidency returns the residency of the index and filter blocks of the
+// sstables of each level in the block cache, so that reads dominated by index
+// or filter block cache misses can be diagnosed. It opens the sstables that
+// aren't in the table cache, but it doesn't read any other blocks or affect
+// the block cache. The backing sstable of virtual sstables is counted once per
+// level.
+func (d *DB) CacheResidency() ([numLevels]CacheResidency, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	var levels [numLevels]CacheResidency
+
+	// Grab and reference the current readState.
+	readState := d.loadReadState()
+	defer readState.unref()
+
+	for level := range readState.current.Levels {
+		seen := make(map[base.DiskFileNum]struct{})
+		iter := readState.current.Levels[level].Iter()
+		for m := iter.First(); m != nil; m = iter.Next() {
+			if _, ok := seen[m.FileBacking.DiskFileNum]; ok {
+				continue
+			}
+			seen[m.FileBacking.DiskFileNum] = struct{}{}
+			res, err := d.tableCache.cacheResidency(m)
+			if err != nil {
+				return levels, err
+			}
+			levels[level].Add(res)
+		}
+	}
+	return levels, nil
+}
+
 // EstimateDiskUsage returns the estimated filesystem space used in bytes for
 // storing the range `[start, end]`. The estimation is computed as follows:
 //
@@ -2269,12 +3362,108 @@ func (d *DB) walPreallocateSize() int {
 	// TODO(peter): 110% of the memtable size is quite hefty for a block
 	// size. This logic is taken from GetWalPreallocateBlockSize in
 	// RocksDB. Could a smaller preallocation block size be used?
+	if d.opts.WALPreallocateSize < 0 {
+		return 0
+	} else if d.opts.WALPreallocateSize > 0 {
+		return d.opts.WALPreallocateSize
+	}
 	size := d.opts.MemTableSize
 	size = (size / 10) + size
 	return size
 }
 
+// The thresholds on the time taken to fill a memtable that cause adaptive
+// memtable sizing to grow or shrink the memtable size.
+const (
+	memTableAdaptiveGrowInterval   = time.Second
+	memTableAdaptiveShrinkInterval = time.Minute
+)
+
+// adaptMemTableSizeLocked adjusts the size of the next memtable when adaptive
+// memtable sizing is enabled by Options.Experimental.MaxMemTableSize. It is
+// called when the mutable memtable is rotated because it is full. The size
+// doubles, up to MaxMemTableSize, if the memtable filled up in less than
+// memTableAdaptiveGrowInterval or if earlier memtables are still waiting to
+// be flushed: larger memtables absorb write bursts with fewer, larger L0
+// files. The size halves, down to MemTableSize, if the memtable took more than
+// memTableAdaptiveShrinkInterval to fill up, returning memory once the write
+// rate subsides. DB.mu must be held.
+func (d *DB) adaptMemTableSizeLocked() {
+	maxSize := d.opts.Experimental.MaxMemTableSize
+	if maxSize <= d.opts.MemTableSize {
+		return
+	}
+	size := d.mu.mem.adaptiveSize
+	if size == 0 {
+		size = d.opts.MemTableSize
+	}
+	elapsed := d.timeNow().Sub(d.mu.mem.createdAt)
+	// The queue contains the mutable memtable and the flushables waiting to
+	// be flushed.
+	backlog := len(d.mu.mem.queue) > 1
+	switch {
+	case elapsed < memTableAdaptiveGrowInterval || backlog:
+		size *= 2
+		if size > maxSize {
+			size = maxSize
+		}
+	case elapsed > memTableAdaptiveShrinkInterval:
+		size /= 2
+		if size < d.opts.MemTableSize {
+			size = d.opts.MemTableSize
+		}
+	}
+	d.mu.mem.adaptiveSize = size
+	if d.mu.mem.nextSize >= d.opts.MemTableSize {
+		// The memtable size has finished ramping up from initialMemTableSize.
+		d.mu.mem.nextSize = size
+	}
+}
+
+// memTableStopWritesSizeLocked returns the memtable size used to compute the
+// memtable size at which writes are stalled (see
+// Options.MemTableStopWritesThreshold). With adaptive memtable sizing, it is
+// the current adaptive memtable size, so that stalls are triggered by the
+// same number of queued memtables as without it. DB.mu must be held.
+func (d *DB) memTableStopWritesSizeLocked() int {
+	if d.mu.mem.adaptiveSize > d.opts.MemTableSize {
+		return d.mu.mem.adaptiveSize
+	}
+	return d.opts.MemTableSize
+}
+
+// maybeBuildMemTableFiltersLocked starts building the bloom filters of the
+// immutable memtables in the queue once all of the batches applied to them
+// have been applied, if Options.Experimental.ImmutableMemTableFilterBitsPerKey
+// is set. DB.mu must be held.
+func (d *DB) maybeBuildMemTableFiltersLocked() {
+	if d.opts.Experimental.ImmutableMemTableFilterBitsPerKey <= 0 || d.closed.Load() != nil ||
+		len(d.mu.mem.queue) <= 1 {
+		return
+	}
+	for _, entry := range d.mu.mem.queue[:len(d.mu.mem.queue)-1] {
+		mem, ok := entry.flushable.(*memTable)
+		if !ok || mem.filterScheduled || !mem.readyForFlush() {
+			continue
+		}
+		mem.filterScheduled = true
+		// The reader reference prevents the memtable from being freed by a
+		// flush while the filter is built.
+		entry.readerRef()
+		d.mu.mem.buildingFilters++
+		go func(entry *flushableEntry, mem *memTable) {
+			mem.buildFilter()
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			entry.readerUnrefLocked(true)
+			d.mu.mem.buildingFilters--
+			d.mu.compact.cond.Broadcast()
+		}(entry, mem)
+	}
+}
+
 func (d *DB) newMemTable(logNum FileNum, logSeqNum uint64) (*memTable, *flushableEntry) {
+	d.mu.mem.createdAt = d.timeNow()
 	size := d.mu.mem.nextSize
 	if d.mu.mem.nextSize < d.opts.MemTableSize {
 		d.mu.mem.nextSize *= 2
@@ -2312,6 +3501,7 @@ func (d *DB) newMemTable(logNum FileNum, logSeqNum uint64) (*memTable, *flushabl
 		memtblOpts.releaseAccountingReservation = d.opts.Cache.Reserve(size)
 		d.memTableCount.Add(1)
 		d.memTableReserved.Add(int64(size))
+		d.memoryReserve(MemoryKindMemTable, int64(size))
 
 		// Note: this is a no-op if invariants are disabled or race is enabled.
 		invariants.SetFinalizer(mem, checkMemTable)
@@ -2342,6 +3532,7 @@ func (d *DB) newMemTable(logNum FileNum, logSeqNum uint64) (*memTable, *flushabl
 func (d *DB) freeMemTable(m *memTable) {
 	d.memTableCount.Add(-1)
 	d.memTableReserved.Add(-int64(len(m.arenaBuf)))
+	d.memoryRelease(MemoryKindMemTable, int64(len(m.arenaBuf)))
 	m.free()
 }
 
@@ -2368,6 +3559,13 @@ func (d *DB) newFlushableEntry(f flushable, logNum FileNum, logSeqNum uint64) *f
 // Both DB.mu and commitPipeline.mu must be held by the caller. Note that DB.mu
 // may be released and reacquired.
 func (d *DB) makeRoomForWrite(b *Batch) error {
+	return d.makeRoomForWriteImpl(b, b == nil /* forceFlush */)
+}
+
+// makeRoomForWriteImpl implements makeRoomForWrite. When a nil Batch is
+// provided, forceFlush determines whether the rotated memtable is forced to
+// flush.
+func (d *DB) makeRoomForWriteImpl(b *Batch, forceFlush bool) error {
 	if b != nil && b.ingestedSSTBatch {
 		panic("pebble: invalid function call")
 	}
@@ -2379,13 +3577,13 @@ func (d *DB) makeRoomForWrite(b *Batch) error {
 			err := d.mu.mem.mutable.prepare(b)
 			if err != arenaskl.ErrArenaFull {
 				if stalled {
-					d.opts.EventListener.WriteStallEnd()
+					d.writeStallEndLocked()
 				}
 				return err
 			}
 		} else if !force {
 			if stalled {
-				d.opts.EventListener.WriteStallEnd()
+				d.writeStallEndLocked()
 			}
 			return nil
 		}
@@ -2395,14 +3593,16 @@ func (d *DB) makeRoomForWrite(b *Batch) error {
 			for i := range d.mu.mem.queue {
 				size += d.mu.mem.queue[i].totalBytes()
 			}
-			if size >= uint64(d.opts.MemTableStopWritesThreshold)*uint64(d.opts.MemTableSize) {
+			if size >= uint64(d.opts.MemTableStopWritesThreshold)*uint64(d.memTableStopWritesSizeLocked()) {
 				// We have filled up the current memtable, but already queued memtables
 				// are still flushing, so we wait.
 				if !stalled {
 					stalled = true
-					d.opts.EventListener.WriteStallBegin(WriteStallBeginInfo{
-						Reason: "memtable count limit reached",
-					})
+					cause := WriteStallMemTableCount
+					if d.mu.compact.flushDiskFull {
+						cause = WriteStallDiskFull
+					}
+					d.writeStallBeginLocked(cause)
 				}
 				now := time.Now()
 				d.mu.compact.cond.Wait()
@@ -2417,9 +3617,7 @@ func (d *DB) makeRoomForWrite(b *Batch) error {
 			// There are too many level-0 files, so we wait.
 			if !stalled {
 				stalled = true
-				d.opts.EventListener.WriteStallBegin(WriteStallBeginInfo{
-					Reason: "L0 file count limit exceeded",
-				})
+				d.writeStallBeginLocked(WriteStallL0FileCount)
 			}
 			now := time.Now()
 			d.mu.compact.cond.Wait()
@@ -2442,7 +3640,7 @@ func (d *DB) makeRoomForWrite(b *Batch) error {
 		immMem := d.mu.mem.mutable
 		imm := d.mu.mem.queue[len(d.mu.mem.queue)-1]
 		imm.logSize = prevLogSize
-		imm.flushForced = imm.flushForced || (b == nil)
+		imm.flushForced = imm.flushForced || forceFlush
 
 		// If we are manually flushing and we used less than half of the bytes in
 		// the memtable, don't increase the size for the next memtable. This
@@ -2452,6 +3650,11 @@ func (d *DB) makeRoomForWrite(b *Batch) error {
 			d.mu.mem.nextSize = int(immMem.totalBytes())
 		}
 
+		if b != nil && b.flushable == nil {
+			// The memtable is being rotated because it is full.
+			d.adaptMemTableSizeLocked()
+		}
+
 		if b != nil && b.flushable != nil {
 			// The batch is too large to fit in the memtable so add it directly to
 			// the immutable queue. The flushable batch is associated with the same
@@ -2485,6 +3688,64 @@ func (d *DB) makeRoomForWrite(b *Batch) error {
 	}
 }
 
+// writeStallBeginLocked records the beginning of a write stall with the
+// provided cause.
+//
+// d.mu must be held when calling this.
+func (d *DB) writeStallBeginLocked(cause WriteStallCause) {
+	d.mu.writeStall.active = true
+	d.mu.writeStall.cause = cause
+	d.opts.EventListener.WriteStallBegin(WriteStallBeginInfo{
+		Reason: cause.String(),
+		Cause:  cause,
+	})
+}
+
+// writeStallEndLocked records the end of a write stall, releasing the writes
+// waiting in waitForWriteStall.
+//
+// d.mu must be held when calling this.
+func (d *DB) writeStallEndLocked() {
+	d.mu.writeStall.active = false
+	d.mu.compact.cond.Broadcast()
+	d.opts.EventListener.WriteStallEnd()
+}
+
+// waitForWriteStall waits for an in-progress write stall to end before a write
+// enters the commit pipeline. If the stall lasts longer than
+// Options.MaxWriteStallDuration, waitForWriteStall returns an error wrapping
+// ErrWriteStallTimeout. If the DB enters degraded mode during the stall, it
+// returns an error wrapping ErrDegraded.
+func (d *DB) waitForWriteStall() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.mu.writeStall.active {
+		return nil
+	}
+	deadline := time.Now().Add(d.opts.MaxWriteStallDuration)
+	// Wake this writer at the deadline, as nothing else may signal the
+	// condition variable while the stall persists.
+	timer := time.AfterFunc(d.opts.MaxWriteStallDuration, func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.mu.compact.cond.Broadcast()
+	})
+	defer timer.Stop()
+	for d.mu.writeStall.active {
+		if err := d.closed.Load(); err != nil {
+			return err.(error)
+		}
+		if err := d.degradedErr(); err != nil {
+			return err
+		}
+		if !time.Now().Before(deadline) {
+			return errors.Wrapf(ErrWriteStallTimeout, "%s", d.mu.writeStall.cause)
+		}
+		d.mu.compact.cond.Wait()
+	}
+	return nil
+}
+
 // Both DB.mu and commitPipeline.mu must be held by the caller.
 func (d *DB) rotateMemtable(newLogNum FileNum, logSeqNum uint64, prev *memTable) {
 	// Create a new memtable, scheduling the previous one for flushing. We do
@@ -2617,6 +3878,13 @@ func (d *DB) recycleWAL() (newLogNum FileNum, prevLogSize uint64) {
 	d.mu.Lock()
 
 	d.mu.versions.metrics.WAL.Files++
+	if err == nil {
+		if recycleOK {
+			d.mu.versions.metrics.WAL.FilesReused++
+		} else {
+			d.mu.versions.metrics.WAL.FilesCreated++
+		}
+	}
 
 	if err != nil {
 		// TODO(peter): avoid chewing through file numbers in a tight loop if there
@@ -2631,8 +3899,13 @@ func (d *DB) recycleWAL() (newLogNum FileNum, prevLogSize uint64) {
 	d.mu.log.LogWriter = record.NewLogWriter(newLogFile, newLogNum, record.LogWriterConfig{
 		WALFsyncLatency:    d.mu.log.metrics.fsyncLatency,
 		WALMinSyncInterval: d.opts.WALMinSyncInterval,
+		WALSyncDeadline:    d.opts.WALSyncDeadline,
 		QueueSemChan:       d.commit.logSyncQSem,
 	})
+	if d.mu.log.cipher, err = d.startWALEncryption(d.mu.log.LogWriter); err != nil {
+		panic(err)
+	}
+	d.mu.log.commitTime = 0
 	if d.mu.log.registerLogWriterForTesting != nil {
 		d.mu.log.registerLogWriterForTesting(d.mu.log.LogWriter)
 	}
@@ -2651,6 +3924,22 @@ func (d *DB) getEarliestUnflushedSeqNumLocked() uint64 {
 	return seqNum
 }
 
+// pruneIdempotencyTokensLocked removes the idempotency tokens of batches that
+// have been flushed.
+//
+// d.mu must be held when calling this.
+func (d *DB) pruneIdempotencyTokensLocked() {
+	if len(d.mu.idempotencyTokens.m) == 0 {
+		return
+	}
+	earliestUnflushedSeqNum := d.getEarliestUnflushedSeqNumLocked()
+	for token, seqNum := range d.mu.idempotencyTokens.m {
+		if seqNum < earliestUnflushedSeqNum {
+			delete(d.mu.idempotencyTokens.m, token)
+		}
+	}
+}
+
 func (d *DB) getInProgressCompactionInfoLocked(finishing *compaction) (rv []compactionInfo) {
 	for c := range d.mu.compact.inProgress {
 		if len(c.flushing) == 0 && (finishing == nil || c != finishing) {
@@ -2719,6 +4008,23 @@ func (d *DB) SetCreatorID(creatorID uint64) error {
 	return d.objProvider.SetCreatorID(objstorage.CreatorID(creatorID))
 }
 
+// SetBlockCacheSize sets the size of the DB's block cache, evicting blocks if
+// the cache is shrunk. It's equivalent to Options.Cache.SetMaxSize, so it
+// resizes the cache for all of the DBs that share it, but the change of size
+// is also reported to Options.MemoryMonitor. The block cache capacity released
+// by ShrinkMemory is restored if it exceeds the new size.
+func (d *DB) SetBlockCacheSize(size int64) {
+	d.resizeBlockCache(size)
+}
+
+// SetTableCacheSize sets the number of sstables the DB's table cache may hold
+// open, closing sstables if the cache is shrunk. It's equivalent to
+// TableCache.SetSize, so it resizes the cache for all of the DBs that share
+// it.
+func (d *DB) SetTableCacheSize(size int) {
+	d.tableCache.tableCache.SetSize(size)
+}
+
 // KeyStatistics keeps track of the number of keys that have been pinned by a
 // snapshot as well as counts of the different key kinds in the lsm.
 type KeyStatistics struct {
@@ -2831,6 +4137,13 @@ func (d *DB) checkVirtualBounds(m *fileMetadata) {
 	if !invariants.Enabled {
 		return
 	}
+	// The bounds of a virtual sstable backed by an external object may be
+	// derived from the bounds the object was ingested with, which needn't be
+	// tight.
+	tight := true
+	if objMeta, err := d.objProvider.Lookup(fileTypeTable, m.FileBacking.DiskFileNum); err == nil && objMeta.IsExternal() {
+		tight = false
+	}
 
 	if m.HasPointKeys {
 		pointIter, rangeDelIter, err := d.newIters(context.TODO(), m, nil, internalIterOpts{})
@@ -2850,7 +4163,7 @@ func (d *DB) checkVirtualBounds(m *fileMetadata) {
 		}
 
 		// Check that the lower bound is tight.
-		if (rangeDel == nil || d.cmp(rangeDel.SmallestKey().UserKey, m.SmallestPointKey.UserKey) != 0) &&
+		if tight && (rangeDel == nil || d.cmp(rangeDel.SmallestKey().UserKey, m.SmallestPointKey.UserKey) != 0) &&
 			(pointKey == nil || d.cmp(pointKey.UserKey, m.SmallestPointKey.UserKey) != 0) {
 			panic(errors.Newf("pebble: virtual sstable %s lower point key bound is not tight", m.FileNum))
 		}
@@ -2862,7 +4175,7 @@ func (d *DB) checkVirtualBounds(m *fileMetadata) {
 		}
 
 		// Check that the upper bound is tight.
-		if (rangeDel == nil || d.cmp(rangeDel.LargestKey().UserKey, m.LargestPointKey.UserKey) != 0) &&
+		if tight && (rangeDel == nil || d.cmp(rangeDel.LargestKey().UserKey, m.LargestPointKey.UserKey) != 0) &&
 			(pointKey == nil || d.cmp(pointKey.UserKey, m.LargestPointKey.UserKey) != 0) {
 			panic(errors.Newf("pebble: virtual sstable %s upper point key bound is not tight", m.FileNum))
 		}
@@ -2899,12 +4212,12 @@ func (d *DB) checkVirtualBounds(m *fileMetadata) {
 	}
 
 	// Check that the lower bound is tight.
-	if d.cmp(rangeKeyIter.First().SmallestKey().UserKey, m.SmallestRangeKey.UserKey) != 0 {
+	if tight && d.cmp(rangeKeyIter.First().SmallestKey().UserKey, m.SmallestRangeKey.UserKey) != 0 {
 		panic(errors.Newf("pebble: virtual sstable %s lower range key bound is not tight", m.FileNum))
 	}
 
 	// Check that upper bound is tight.
-	if d.cmp(rangeKeyIter.Last().LargestKey().UserKey, m.LargestRangeKey.UserKey) != 0 {
+	if tight && d.cmp(rangeKeyIter.Last().LargestKey().UserKey, m.LargestRangeKey.UserKey) != 0 {
 		panic(errors.Newf("pebble: virtual sstable %s upper range key bound is not tight", m.FileNum))
 	}
 
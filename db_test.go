@@ -14,14 +14,17 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/bloom"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/cache"
 	"github.com/cockroachdb/pebble/internal/invariants"
 	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
+	"github.com/cockroachdb/pebble/record"
 	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
@@ -589,6 +592,400 @@ func TestLogData(t *testing.T) {
 	// For now, LogData proceeding ahead without a panic is good enough.
 }
 
+func TestWriteOptionsDisableWAL(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{FS: mem})
+	require.NoError(t, err)
+
+	require.Error(t, d.Set([]byte("a"), []byte("a"), &WriteOptions{Sync: true, DisableWAL: true}))
+	require.NoError(t, d.Set([]byte("a"), []byte("a"), NoWAL))
+	require.NoError(t, d.Set([]byte("b"), []byte("b"), NoSync))
+	require.NoError(t, d.Set([]byte("c"), []byte("c"), Sync))
+	verifyGet(t, d, []byte("a"), []byte("a"))
+	verifyGet(t, d, []byte("b"), []byte("b"))
+	verifyGet(t, d, []byte("c"), []byte("c"))
+
+	// Closing the DB does not flush the memtable, so only the batches written
+	// to the WAL are recovered on reopen.
+	require.NoError(t, d.Close())
+	d, err = Open("", &Options{FS: mem})
+	require.NoError(t, err)
+	verifyGetNotFound(t, d, []byte("a"))
+	verifyGet(t, d, []byte("b"), []byte("b"))
+	verifyGet(t, d, []byte("c"), []byte("c"))
+
+	// A batch skipping the WAL is durable once flushed.
+	require.NoError(t, d.Set([]byte("a"), []byte("a"), NoWAL))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Close())
+	d, err = Open("", &Options{FS: mem})
+	require.NoError(t, err)
+	verifyGet(t, d, []byte("a"), []byte("a"))
+	require.NoError(t, d.Close())
+}
+
+func TestBatchIdempotencyToken(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{FS: mem})
+	require.NoError(t, err)
+
+	// Merge operands are concatenated, so applying a batch twice is visible.
+	apply := func(token string) {
+		b := d.NewBatch()
+		require.NoError(t, b.Merge([]byte("k"), []byte("x"), nil))
+		if token != "" {
+			require.NoError(t, b.SetIdempotencyToken([]byte(token)))
+			require.Error(t, b.SetIdempotencyToken([]byte(token)))
+			require.Equal(t, token, string(b.IdempotencyToken()))
+		}
+		require.NoError(t, d.Apply(b, Sync))
+		require.NoError(t, b.Close())
+	}
+
+	apply("t1")
+	apply("t1")
+	verifyGet(t, d, []byte("k"), []byte("x"))
+	apply("t2")
+	apply("")
+	verifyGet(t, d, []byte("k"), []byte("xxx"))
+
+	// Tokens are recovered from the WAL when the DB is reopened.
+	require.NoError(t, d.Close())
+	d, err = Open("", &Options{FS: mem})
+	require.NoError(t, err)
+	apply("t1")
+	apply("t2")
+	verifyGet(t, d, []byte("k"), []byte("xxx"))
+
+	// Tokens are forgotten once their batches have been flushed.
+	require.NoError(t, d.Flush())
+	apply("t1")
+	verifyGet(t, d, []byte("k"), []byte("xxxx"))
+	apply("t1")
+	verifyGet(t, d, []byte("k"), []byte("xxxx"))
+
+	// Tokens survive the batch encoding.
+	b := d.NewBatch()
+	require.NoError(t, b.Merge([]byte("k"), []byte("x"), nil))
+	require.NoError(t, b.SetIdempotencyToken([]byte("t1")))
+	enc, err := b.Encode(nil)
+	require.NoError(t, err)
+	var decoded Batch
+	require.NoError(t, decoded.Decode(enc))
+	require.Equal(t, "t1", string(decoded.IdempotencyToken()))
+	require.NoError(t, d.Apply(&decoded, nil))
+	verifyGet(t, d, []byte("k"), []byte("xxxx"))
+	require.NoError(t, d.Close())
+}
+
+func TestBatchIdempotencyTokenConcurrent(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Hold the original batch in the commit pipeline after its token has been
+	// registered by blocking its WAL write on the commit pipeline mutex.
+	d.commit.mu.Lock()
+	original := d.NewBatch()
+	require.NoError(t, original.Merge([]byte("k"), []byte("x"), nil))
+	require.NoError(t, original.SetIdempotencyToken([]byte("t")))
+	originalDone := make(chan error, 1)
+	go func() { originalDone <- d.Apply(original, Sync) }()
+	require.Eventually(t, func() bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		_, ok := d.mu.idempotencyTokens.m["t"]
+		return ok
+	}, 10*time.Second, time.Millisecond)
+
+	// The duplicate does not return until the original has committed.
+	dup := d.NewBatch()
+	require.NoError(t, dup.Merge([]byte("k"), []byte("x"), nil))
+	require.NoError(t, dup.SetIdempotencyToken([]byte("t")))
+	dupDone := make(chan error, 1)
+	go func() { dupDone <- d.Apply(dup, Sync) }()
+	select {
+	case err := <-dupDone:
+		t.Fatalf("duplicate returned before the original committed: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	d.commit.mu.Unlock()
+	require.NoError(t, <-dupDone)
+	verifyGet(t, d, []byte("k"), []byte("x"))
+	require.NoError(t, <-originalDone)
+	verifyGet(t, d, []byte("k"), []byte("x"))
+	require.NoError(t, original.Close())
+	require.NoError(t, dup.Close())
+}
+
+func TestApplyBatches(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{FS: mem})
+	require.NoError(t, err)
+
+	b1 := d.NewBatch()
+	require.NoError(t, b1.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, b1.Set([]byte("b"), []byte("2"), nil))
+	b2 := d.NewIndexedBatch()
+	require.NoError(t, b2.DeleteRange([]byte("b"), []byte("c"), nil))
+	b3 := d.NewBatch()
+	require.NoError(t, b3.Set([]byte("c"), []byte("3"), nil))
+	require.NoError(t, b3.SetIdempotencyToken([]byte("token")))
+
+	before := d.mu.versions.visibleSeqNum.Load()
+	require.NoError(t, d.ApplyBatches([]*Batch{b1, b2, b3}, Sync))
+	require.Equal(t, before, b1.SeqNum())
+	require.Equal(t, before+2, b2.SeqNum())
+	require.Equal(t, before+3, b3.SeqNum())
+	require.Equal(t, before+4, d.mu.versions.visibleSeqNum.Load())
+	require.Panics(t, func() { _ = d.ApplyBatches([]*Batch{b1}, nil) })
+	for _, b := range []*Batch{b1, b2, b3} {
+		require.NoError(t, b.Close())
+	}
+
+	verifyGet(t, d, []byte("a"), []byte("1"))
+	verifyGetNotFound(t, d, []byte("b"))
+	verifyGet(t, d, []byte("c"), []byte("3"))
+
+	// At most one batch may carry an idempotency token.
+	b1, b2 = d.NewBatch(), d.NewBatch()
+	require.NoError(t, b1.SetIdempotencyToken([]byte("x")))
+	require.NoError(t, b2.SetIdempotencyToken([]byte("y")))
+	require.Error(t, d.ApplyBatches([]*Batch{b1, b2}, nil))
+	require.NoError(t, b1.Close())
+	require.NoError(t, b2.Close())
+
+	// The batches are recovered together from the WAL.
+	require.NoError(t, d.Close())
+	d, err = Open("", &Options{FS: mem})
+	require.NoError(t, err)
+	verifyGet(t, d, []byte("a"), []byte("1"))
+	verifyGetNotFound(t, d, []byte("b"))
+	verifyGet(t, d, []byte("c"), []byte("3"))
+	require.NoError(t, d.Close())
+}
+
+// blockSSTCreateFS blocks the creation of sstables until unblock is closed.
+type blockSSTCreateFS struct {
+	vfs.FS
+	unblock chan struct{}
+}
+
+func (fs blockSSTCreateFS) Create(name string) (vfs.File, error) {
+	if strings.HasSuffix(name, ".sst") {
+		<-fs.unblock
+	}
+	return fs.FS.Create(name)
+}
+
+func TestMaxWriteStallDuration(t *testing.T) {
+	const maxStall = 10 * time.Millisecond
+	stalls := make(chan WriteStallBeginInfo, 1)
+	fs := blockSSTCreateFS{FS: vfs.NewMem(), unblock: make(chan struct{})}
+	d, err := Open("", &Options{
+		FS:                          fs,
+		MemTableSize:                1 << 20,
+		MemTableStopWritesThreshold: 2,
+		MaxWriteStallDuration:       maxStall,
+		EventListener: &EventListener{
+			WriteStallBegin: func(info WriteStallBeginInfo) {
+				select {
+				case stalls <- info:
+				default:
+				}
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	// Fill memtables while flushes are blocked until a write stalls. The
+	// write that triggers the stall is already in the commit pipeline and is
+	// not bounded by MaxWriteStallDuration.
+	var stalled atomic.Bool
+	done := make(chan error, 1)
+	go func() {
+		value := make([]byte, 64<<10)
+		for i := 0; ; i++ {
+			if err := d.Set([]byte(fmt.Sprintf("k%06d", i)), value, nil); err != nil || stalled.Load() {
+				done <- err
+				return
+			}
+		}
+	}()
+	info := <-stalls
+	require.Equal(t, WriteStallMemTableCount, info.Cause)
+	require.Equal(t, info.Cause.String(), info.Reason)
+	stalled.Store(true)
+
+	// Writes arriving during the stall time out.
+	err = d.Set([]byte("a"), nil, nil)
+	require.True(t, errors.Is(err, ErrWriteStallTimeout), "%v", err)
+
+	// The stalled write is still waiting well past MaxWriteStallDuration, and
+	// completes once flushes resume.
+	select {
+	case err := <-done:
+		t.Fatalf("stalled write returned: %v", err)
+	case <-time.After(5 * maxStall):
+	}
+	close(fs.unblock)
+	require.NoError(t, <-done)
+	require.NoError(t, d.Set([]byte("a"), nil, nil))
+	require.NoError(t, d.Close())
+}
+
+func TestApplyAsync(t *testing.T) {
+	d, err := Open("", testingRandomized(t, &Options{
+		FS: vfs.NewMem(),
+	}))
+	require.NoError(t, err)
+
+	const n = 100
+	var mu sync.Mutex
+	var order []int
+	var errs []error
+	done := make(chan struct{})
+	batches := make([]*Batch, n)
+	for i := 0; i < n; i++ {
+		i := i
+		b := d.NewBatch()
+		require.NoError(t, b.Set([]byte(fmt.Sprintf("k%03d", i)), nil, nil))
+		opts := Sync
+		if i%3 == 0 {
+			opts = NoSync
+		}
+		batches[i] = b
+		require.NoError(t, d.ApplyAsync(b, opts, func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			errs = append(errs, err)
+			order = append(order, i)
+			if len(order) == n {
+				close(done)
+			}
+		}))
+		// The batch is visible as soon as ApplyAsync returns.
+		verifyGet(t, d, []byte(fmt.Sprintf("k%03d", i)), nil)
+	}
+	<-done
+	for i := range order {
+		require.NoError(t, errs[i])
+		require.Equal(t, i, order[i])
+		require.NoError(t, batches[i].Close())
+	}
+
+	// Callbacks may call ApplyAsync, even once more batches are queued than
+	// there are slots in the WAL sync queue.
+	const nested = record.SyncConcurrency + 100
+	var nestedErr error
+	var nestedCount atomic.Int32
+	nestedDone := make(chan struct{})
+	b := d.NewBatch()
+	require.NoError(t, b.Set([]byte("outer"), nil, nil))
+	require.NoError(t, d.ApplyAsync(b, NoSync, func(err error) {
+		for i := 0; i < nested && err == nil; i++ {
+			nb := d.NewBatch()
+			err = nb.Set([]byte(fmt.Sprintf("nested%05d", i)), nil, nil)
+			if err == nil {
+				err = d.ApplyAsync(nb, NoSync, func(error) {
+					if nestedCount.Add(1) == nested {
+						close(nestedDone)
+					}
+				})
+			}
+		}
+		nestedErr = err
+	}))
+	<-nestedDone
+	require.NoError(t, nestedErr)
+	verifyGet(t, d, []byte(fmt.Sprintf("nested%05d", nested-1)), nil)
+
+	// Callbacks that are still outstanding when the DB is closed are invoked
+	// before Close returns.
+	var called bool
+	b = d.NewBatch()
+	require.NoError(t, b.Set([]byte("foo"), nil, nil))
+	require.NoError(t, d.ApplyAsync(b, Sync, func(err error) { called = true }))
+	require.NoError(t, d.Close())
+	require.True(t, called)
+
+	// ApplyAsync returns ErrClosed once the DB is closed.
+	b = newBatch(nil)
+	require.NoError(t, b.Set([]byte("bar"), nil, nil))
+	require.ErrorIs(t, d.ApplyAsync(b, Sync, func(error) {
+		t.Error("unexpected callback")
+	}), ErrClosed)
+}
+
+func TestApplyAsyncWriteStall(t *testing.T) {
+	stalls := make(chan WriteStallBeginInfo, 1)
+	fs := blockSSTCreateFS{FS: vfs.NewMem(), unblock: make(chan struct{})}
+	d, err := Open("", &Options{
+		FS:                          fs,
+		MemTableSize:                1 << 20,
+		MemTableStopWritesThreshold: 2,
+		EventListener: &EventListener{
+			WriteStallBegin: func(info WriteStallBeginInfo) {
+				select {
+				case stalls <- info:
+				default:
+				}
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	// Fill memtables while flushes are blocked until a write stalls.
+	var stalled atomic.Bool
+	done := make(chan error, 1)
+	go func() {
+		value := make([]byte, 64<<10)
+		for i := 0; ; i++ {
+			if err := d.Set([]byte(fmt.Sprintf("k%06d", i)), value, nil); err != nil || stalled.Load() {
+				done <- err
+				return
+			}
+		}
+	}()
+	<-stalls
+	stalled.Store(true)
+
+	// ApplyAsync returns during the stall without applying the batches, which
+	// are applied once the stall ends.
+	var mu sync.Mutex
+	var order []int
+	var errs []error
+	callbacks := make(chan struct{}, 2)
+	for i := 0; i < 2; i++ {
+		i := i
+		b := d.NewBatch()
+		require.NoError(t, b.Set([]byte(fmt.Sprintf("a%d", i)), nil, nil))
+		require.NoError(t, d.ApplyAsync(b, Sync, func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			order = append(order, i)
+			errs = append(errs, err)
+			callbacks <- struct{}{}
+		}))
+	}
+	select {
+	case <-callbacks:
+		t.Fatal("callback invoked during the write stall")
+	case <-time.After(10 * time.Millisecond):
+	}
+	verifyGetNotFound(t, d, []byte("a0"))
+
+	close(fs.unblock)
+	require.NoError(t, <-done)
+	<-callbacks
+	<-callbacks
+	require.Equal(t, []int{0, 1}, order)
+	require.Equal(t, []error{nil, nil}, errs)
+	verifyGet(t, d, []byte("a0"), nil)
+	verifyGet(t, d, []byte("a1"), nil)
+	require.NoError(t, d.Close())
+}
+
 func TestSingleDeleteGet(t *testing.T) {
 	d, err := Open("", testingRandomized(t, &Options{
 		FS: vfs.NewMem(),
@@ -859,6 +1256,59 @@ func TestMemTableReservation(t *testing.T) {
 	require.NoError(t, d.Close())
 }
 
+func TestAdaptiveMemTableSize(t *testing.T) {
+	opts := &Options{
+		FS:                          vfs.NewMem(),
+		MemTableSize:                initialMemTableSize,
+		DisableAutomaticCompactions: true,
+	}
+	opts.Experimental.MaxMemTableSize = 4 * initialMemTableSize
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+	var offset atomic.Int64
+	d.timeNow = func() time.Time { return time.Now().Add(time.Duration(offset.Load())) }
+
+	// fill waits for queued memtables to flush, and then writes to the DB
+	// until the mutable memtable is rotated, advancing the clock by elapsed
+	// before the rotation. It returns the size of the new mutable memtable.
+	var i int
+	value := make([]byte, 1<<10)
+	fill := func(elapsed time.Duration) int {
+		d.mu.Lock()
+		for len(d.mu.mem.queue) > 1 {
+			d.mu.compact.cond.Wait()
+		}
+		mem := d.mu.mem.mutable
+		d.mu.Unlock()
+		offset.Add(int64(elapsed))
+		for {
+			i++
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("key%06d", i)), value, nil))
+			d.mu.Lock()
+			next := d.mu.mem.mutable
+			d.mu.Unlock()
+			if next != mem {
+				return len(next.arenaBuf)
+			}
+		}
+	}
+
+	// Memtables filling up quickly grow the memtable size up to the maximum.
+	require.Equal(t, 2*initialMemTableSize, fill(0))
+	require.Equal(t, 4*initialMemTableSize, fill(0))
+	require.Equal(t, 4*initialMemTableSize, fill(0))
+	require.NoError(t, d.Flush())
+
+	// Memtables filling up slowly shrink the memtable size down to
+	// MemTableSize.
+	require.Equal(t, 2*initialMemTableSize, fill(2*memTableAdaptiveShrinkInterval))
+	require.Equal(t, initialMemTableSize, fill(2*memTableAdaptiveShrinkInterval))
+	require.Equal(t, initialMemTableSize, fill(2*memTableAdaptiveShrinkInterval))
+}
+
 func TestMemTableReservationLeak(t *testing.T) {
 	d, err := Open("", &Options{FS: vfs.NewMem()})
 	require.NoError(t, err)
@@ -918,6 +1368,90 @@ func TestCacheEvict(t *testing.T) {
 	}
 }
 
+func TestCacheQuota(t *testing.T) {
+	cache := NewCache(10 << 20)
+	defer cache.Unref()
+
+	const quota = 64 << 10
+	open := func(quota int64) *DB {
+		d, err := Open("", &Options{
+			Cache:      cache,
+			CacheQuota: quota,
+			FS:         vfs.NewMem(),
+		})
+		require.NoError(t, err)
+		for i := 0; i < 10000; i++ {
+			key := []byte(fmt.Sprintf("%05d", i))
+			require.NoError(t, d.Set(key, bytes.Repeat(key, 20), nil))
+		}
+		require.NoError(t, d.Flush())
+		return d
+	}
+	scan := func(d *DB) {
+		iter, _ := d.NewIter(nil)
+		for iter.First(); iter.Valid(); iter.Next() {
+		}
+		require.NoError(t, iter.Close())
+	}
+	d1, d2 := open(0), open(quota)
+
+	// Both DBs read more than the quota, but only the DB without a quota may
+	// exceed it.
+	scan(d1)
+	scan(d2)
+	m1, m2 := d1.Metrics(), d2.Metrics()
+	require.Greater(t, m1.BlockCacheDB.Size, int64(quota))
+	// The quota is rounded up to a multiple of the number of cache shards.
+	require.LessOrEqual(t, m2.BlockCacheDB.Size, int64(quota+1<<10))
+	require.Greater(t, m2.BlockCacheDB.Misses, int64(0))
+	require.Equal(t, m1.BlockCache.Size, m1.BlockCacheDB.Size+m2.BlockCacheDB.Size)
+
+	// The first DB's blocks remain cached.
+	scan(d1)
+	m1 = d1.Metrics()
+	require.Greater(t, m1.BlockCacheDB.Hits, int64(0))
+
+	require.NoError(t, d1.Close())
+	require.NoError(t, d2.Close())
+}
+
+func TestCacheHighPriority(t *testing.T) {
+	cache := NewCache(256 << 10)
+	defer cache.Unref()
+	cache.SetHighPriorityRatio(0.25)
+
+	d, err := Open("", &Options{
+		Cache: cache,
+		FS:    vfs.NewMem(),
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	for i := 0; i < 10000; i++ {
+		key := []byte(fmt.Sprintf("%05d", i))
+		require.NoError(t, d.Set(key, bytes.Repeat(key, 20), nil))
+	}
+	require.NoError(t, d.Flush())
+	scan := func() {
+		iter, _ := d.NewIter(nil)
+		for iter.First(); iter.Valid(); iter.Next() {
+		}
+		require.NoError(t, iter.Close())
+	}
+
+	// Scans of more data blocks than fit in the cache don't evict the index
+	// block, which is in the high-priority region.
+	scan()
+	m := d.Metrics()
+	require.Greater(t, m.BlockCache.HighPriority.Count, int64(0))
+	require.LessOrEqual(t, m.BlockCache.HighPriority.Size, m.BlockCache.HighPriority.Capacity)
+	indexMisses := m.BlockCacheByKind[sstable.BlockKindIndex].Misses
+	dataMisses := m.BlockCacheByKind[sstable.BlockKindData].Misses
+	scan()
+	m = d.Metrics()
+	require.Equal(t, indexMisses, m.BlockCacheByKind[sstable.BlockKindIndex].Misses)
+	require.Greater(t, m.BlockCacheByKind[sstable.BlockKindData].Misses, dataMisses)
+}
+
 func TestFlushEmpty(t *testing.T) {
 	d, err := Open("", testingRandomized(t, &Options{
 		FS: vfs.NewMem(),
@@ -929,6 +1463,141 @@ func TestFlushEmpty(t *testing.T) {
 	require.NoError(t, d.Close())
 }
 
+func TestBlockChecksumMixed(t *testing.T) {
+	// Write sstables with each block checksum algorithm, reopening the DB with
+	// a different algorithm each time, and ensure all of them remain readable.
+	mem := vfs.NewMem()
+	checksums := []ChecksumType{ChecksumTypeCRC32c, ChecksumTypeXXH3, ChecksumTypeXXHash64}
+	for i, checksum := range checksums {
+		d, err := Open("", &Options{
+			FS:                 mem,
+			BlockChecksum:      checksum,
+			FormatMajorVersion: ExperimentalFormatBlockExtensions,
+		})
+		require.NoError(t, err)
+		key := []byte(fmt.Sprintf("%s-key", checksum))
+		require.NoError(t, d.Set(key, []byte(checksum.String()), nil))
+		require.NoError(t, d.Flush())
+		for _, prev := range checksums[:i+1] {
+			verifyGet(t, d, []byte(fmt.Sprintf("%s-key", prev)), []byte(prev.String()))
+		}
+		require.NoError(t, d.Close())
+	}
+}
+
+// testTableKeyManager wraps data keys by XORing them with a fixed key
+// encryption key.
+type testTableKeyManager struct{}
+
+func (testTableKeyManager) WrapKey(dataKey []byte) ([]byte, error) {
+	wrapped := append([]byte(nil), dataKey...)
+	for i := range wrapped {
+		wrapped[i] ^= 0x5a
+	}
+	return wrapped, nil
+}
+
+func (m testTableKeyManager) UnwrapKey(wrapped []byte) ([]byte, error) {
+	return m.WrapKey(wrapped)
+}
+
+func TestTableEncryption(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{FS: mem})
+	require.NoError(t, err)
+	require.NoError(t, d.Set([]byte("plain"), []byte("plain-value"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Close())
+
+	// Encryption requires ExperimentalFormatBlockExtensions.
+	opts := &Options{FS: mem, TableKeyManager: testTableKeyManager{}}
+	opts.private.disableTableStats = true
+	_, err = Open("", opts)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "TableKeyManager requires FormatMajorVersion")
+
+	// Sstables written before encryption is enabled remain readable.
+	opts.FormatMajorVersion = ExperimentalFormatBlockExtensions
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	require.NoError(t, d.Set([]byte("encrypted"), []byte("secret-value"), nil))
+	require.NoError(t, d.Flush())
+	verifyGet(t, d, []byte("plain"), []byte("plain-value"))
+	verifyGet(t, d, []byte("encrypted"), []byte("secret-value"))
+	require.NoError(t, d.Close())
+
+	ls, err := mem.List("")
+	require.NoError(t, err)
+	var tables int
+	for _, name := range ls {
+		if ft, _, ok := base.ParseFilename(mem, name); !ok || ft != fileTypeTable {
+			continue
+		}
+		tables++
+		f, err := mem.Open(name)
+		require.NoError(t, err)
+		data, err := io.ReadAll(f)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		require.False(t, bytes.Contains(data, []byte("secret-value")))
+	}
+	require.Equal(t, 2, tables)
+
+	// The encrypted sstable can't be read without the key manager.
+	opts = &Options{FS: mem}
+	opts.private.disableTableStats = true
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	verifyGet(t, d, []byte("plain"), []byte("plain-value"))
+	_, _, err = d.Get([]byte("encrypted"))
+	require.Error(t, err)
+	require.NoError(t, d.Close())
+}
+
+func TestTableEncryptionSecondaryCache(t *testing.T) {
+	mem := vfs.NewMem()
+	secondaryFS := vfs.NewMem()
+	c, err := NewCacheWithSecondary(256<<10, secondaryFS, "secondary", 64<<20)
+	require.NoError(t, err)
+	defer c.Unref()
+
+	// The memtable's memory is reserved in the cache, so it's kept small to
+	// leave room for blocks.
+	opts := &Options{
+		FS:                 mem,
+		Cache:              c,
+		MemTableSize:       64 << 10,
+		FormatMajorVersion: ExperimentalFormatBlockExtensions,
+		TableKeyManager:    testTableKeyManager{},
+	}
+	opts.private.disableTableStats = true
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	const n = 20000
+	for i := 0; i < n; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("key%05d", i)), []byte(fmt.Sprintf("secret-value%05d", i)), nil))
+	}
+	require.NoError(t, d.Flush())
+
+	// Reading the tables evicts many of their blocks from the in-memory
+	// cache, but the decrypted blocks are never written to the secondary
+	// cache. Only blocks that aren't encrypted in the tables either, such as
+	// the metaindex blocks, may be.
+	for j := 0; j < 2; j++ {
+		for i := 0; i < n; i++ {
+			verifyGet(t, d, []byte(fmt.Sprintf("key%05d", i)), []byte(fmt.Sprintf("secret-value%05d", i)))
+		}
+	}
+	require.NoError(t, d.Close())
+
+	f, err := secondaryFS.Open("secondary")
+	require.NoError(t, err)
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.False(t, bytes.Contains(data, []byte("secret-value")))
+}
+
 func TestRollManifest(t *testing.T) {
 	toPreserve := rand.Int31n(5) + 1
 	opts := &Options{
@@ -1390,6 +2059,132 @@ func TestSSTables(t *testing.T) {
 	}
 }
 
+// rowCountCollector counts the point keys of each table, aggregating the
+// counts by summing them.
+type rowCountCollector struct {
+	count int
+}
+
+var _ AggregatingTablePropertyCollector = (*rowCountCollector)(nil)
+
+func (c *rowCountCollector) Add(key InternalKey, value []byte) error {
+	c.count++
+	return nil
+}
+
+func (c *rowCountCollector) Finish(userProps map[string]string) error {
+	userProps["test.row-count"] = strconv.Itoa(c.count)
+	return nil
+}
+
+func (c *rowCountCollector) Name() string {
+	return "rowCountCollector"
+}
+
+func (c *rowCountCollector) Aggregate(aggregate map[string]string, props map[string]string) error {
+	n, err := strconv.Atoi(props["test.row-count"])
+	if err != nil {
+		return err
+	}
+	total, _ := strconv.Atoi(aggregate["test.row-count"])
+	aggregate["test.row-count"] = strconv.Itoa(total + n)
+	return nil
+}
+
+func TestAggregateTableProperties(t *testing.T) {
+	d, err := Open("", &Options{
+		FS: vfs.NewMem(),
+		TablePropertyCollectors: []func() TablePropertyCollector{
+			func() TablePropertyCollector { return &rowCountCollector{} },
+		},
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	// Create two sstables, [a, c] and [d, g].
+	require.NoError(t, d.Set([]byte("a"), nil, nil))
+	require.NoError(t, d.Set([]byte("b"), nil, nil))
+	require.NoError(t, d.Set([]byte("c"), nil, nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Set([]byte("d"), nil, nil))
+	require.NoError(t, d.Set([]byte("g"), nil, nil))
+	require.NoError(t, d.Flush())
+	// Unflushed keys aren't included.
+	require.NoError(t, d.Set([]byte("h"), nil, nil))
+
+	for _, tc := range []struct {
+		start, end string
+		want       string
+	}{
+		{"", "", "5"},
+		{"a", "z", "5"},
+		{"a", "b", "3"},
+		{"c", "e", "5"},
+		{"e", "z", "2"},
+		{"h", "z", ""},
+	} {
+		var start, end []byte
+		if tc.start != "" {
+			start, end = []byte(tc.start), []byte(tc.end)
+		}
+		props, err := d.AggregateTableProperties(start, end)
+		require.NoError(t, err)
+		require.Equal(t, tc.want, props["test.row-count"], "[%s, %s)", tc.start, tc.end)
+	}
+
+	// The properties of each sstable are returned by SSTables.
+	tableInfos, err := d.SSTables(WithProperties())
+	require.NoError(t, err)
+	var counts []string
+	for _, levelTables := range tableInfos {
+		for _, info := range levelTables {
+			counts = append(counts, info.Properties.UserProperties["test.row-count"])
+		}
+	}
+	sort.Strings(counts)
+	require.Equal(t, []string{"2", "3"}, counts)
+
+	_, err = d.AggregateTableProperties([]byte("a"), nil)
+	require.Error(t, err)
+	_, err = d.AggregateTableProperties([]byte("z"), []byte("a"))
+	require.Error(t, err)
+}
+
+func TestCacheResidency(t *testing.T) {
+	opts := &Options{
+		FS:                     vfs.NewMem(),
+		PinTopLevelIndexBlocks: true,
+		Levels:                 []LevelOptions{{BlockSize: 64, IndexBlockSize: 64, FilterPolicy: bloom.FilterPolicy(10)}},
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("key%03d", i)), nil, nil))
+	}
+	require.NoError(t, d.Flush())
+
+	levels, err := d.CacheResidency()
+	require.NoError(t, err)
+	for level := range levels {
+		if level > 0 {
+			require.Equal(t, CacheResidency{}, levels[level])
+			continue
+		}
+		res := levels[level]
+		require.Greater(t, res.IndexBytes, uint64(0))
+		require.Greater(t, res.FilterBytes, uint64(0))
+		// The top-level index of the flushed sstable is pinned.
+		require.Greater(t, res.IndexBytesCached, uint64(0))
+		require.Less(t, res.IndexBytesCached, res.IndexBytes)
+	}
+}
+
 type testTracer struct {
 	enabledOnlyForNonBackgroundContext bool
 	buf                                strings.Builder
@@ -0,0 +1,283 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/cockroachdb/redact"
+)
+
+// DegradedModeOptions configure the detection of a failing disk. The DB
+// observes the write-oriented operations it performs on its filesystem (see
+// vfs.OnDiskOp), and counts the IO errors and slow operations of each
+// directory. Once the count of either within Window reaches its threshold, the
+// DB enters a read-only degraded mode, instead of continuing to write to (and
+// eventually wedging writers on) a dying disk:
+//
+//   - Writes, ingestions, flushes and manual compactions fail fast with an
+//     error wrapping ErrDegraded. Writes waiting for a write stall to end (see
+//     Options.MaxWriteStallDuration) are released with that error. Writes
+//     that already entered the commit pipeline are not failed.
+//   - No further flushes or automatic compactions are scheduled.
+//   - Reads continue to be served from the memtables, the block cache and the
+//     sstables that remain readable.
+//
+// EventListener.DiskDegraded is invoked when the DB enters degraded mode. The
+// DB remains in degraded mode until it is closed.
+type DegradedModeOptions struct {
+	// ErrorThreshold is the number of IO errors observed on a directory within
+	// Window upon which the DB enters degraded mode. Errors signifying a
+	// missing or existing file, or a full disk, are not counted. If zero, IO
+	// errors don't cause the DB to enter degraded mode.
+	ErrorThreshold int
+	// SlowOpThreshold is the duration above which an operation is considered
+	// slow.
+	SlowOpThreshold time.Duration
+	// SlowOpCount is the number of slow operations observed on a directory
+	// within Window upon which the DB enters degraded mode. If zero (or if
+	// SlowOpThreshold is zero), slow operations don't cause the DB to enter
+	// degraded mode.
+	SlowOpCount int
+	// Window is the duration within which errors and slow operations are
+	// counted. If zero, it defaults to one minute.
+	Window time.Duration
+}
+
+func (o DegradedModeOptions) enabled() bool {
+	return o.ErrorThreshold > 0 || (o.SlowOpCount > 0 && o.SlowOpThreshold > 0)
+}
+
+// DegradedReason is the reason the DB entered degraded mode.
+type DegradedReason int8
+
+const (
+	// DegradedReasonErrors indicates that the DB entered degraded mode because
+	// of IO errors.
+	DegradedReasonErrors DegradedReason = iota
+	// DegradedReasonSlowOps indicates that the DB entered degraded mode
+	// because of slow operations.
+	DegradedReasonSlowOps
+)
+
+// String implements fmt.Stringer.
+func (r DegradedReason) String() string {
+	switch r {
+	case DegradedReasonErrors:
+		return "io-errors"
+	case DegradedReasonSlowOps:
+		return "slow-ops"
+	}
+	return "unknown"
+}
+
+// DiskDegradedInfo contains the info for the event of a DB entering degraded
+// mode.
+type DiskDegradedInfo struct {
+	// Path is the directory on which the errors or slow operations were
+	// observed.
+	Path string
+	// Reason is the reason the DB entered degraded mode.
+	Reason DegradedReason
+	// Count is the number of errors or slow operations observed on Path
+	// within Window.
+	Count int
+	// Window is the duration within which Count was observed.
+	Window time.Duration
+	// Op is the operation upon which the DB entered degraded mode.
+	Op vfs.DiskOpInfo
+}
+
+func (i DiskDegradedInfo) String() string {
+	return redact.StringWithoutMarkers(i)
+}
+
+// SafeFormat implements redact.SafeFormatter.
+func (i DiskDegradedInfo) SafeFormat(w redact.SafePrinter, _ rune) {
+	w.Printf("disk degraded (%s): %d in %s on %s; last: %s",
+		redact.Safe(i.Reason.String()), redact.Safe(i.Count), redact.Safe(i.Window.String()),
+		i.Path, i.Op)
+}
+
+// diskHealthMonitor counts the IO errors and slow operations observed on each
+// directory, and enters degraded mode once either reaches its threshold. See
+// DegradedModeOptions.
+type diskHealthMonitor struct {
+	opts     DegradedModeOptions
+	fs       vfs.FS
+	listener *EventListener
+	timeNow  func() time.Time
+
+	// degraded is set once the monitor entered degraded mode.
+	degraded atomic.Pointer[DiskDegradedInfo]
+
+	mu struct {
+		sync.Mutex
+		paths map[string]*diskPathHealth
+		// onDegraded, if set, is invoked once the monitor enters degraded
+		// mode.
+		onDegraded func()
+	}
+}
+
+type diskPathHealth struct {
+	errors, slowOps eventWindow
+}
+
+// eventWindow holds the times of the most recent events, up to a threshold
+// number of them.
+type eventWindow struct {
+	times []time.Time
+	next  int
+}
+
+// add records an event, and returns true if the last n events (including this
+// one) occurred within window.
+func (w *eventWindow) add(now time.Time, n int, window time.Duration) bool {
+	if w.times == nil {
+		w.times = make([]time.Time, n)
+	}
+	w.times[w.next] = now
+	w.next = (w.next + 1) % n
+	oldest := w.times[w.next]
+	return !oldest.IsZero() && now.Sub(oldest) <= window
+}
+
+func newDiskHealthMonitor(opts *Options) *diskHealthMonitor {
+	m := &diskHealthMonitor{
+		opts:     opts.Experimental.DegradedMode,
+		fs:       opts.FS,
+		listener: opts.EventListener,
+		timeNow:  time.Now,
+	}
+	if m.opts.Window <= 0 {
+		m.opts.Window = time.Minute
+	}
+	m.mu.paths = make(map[string]*diskPathHealth)
+	return m
+}
+
+// setOnDegraded sets the function invoked once the monitor enters degraded
+// mode. It is invoked immediately if the monitor already did.
+func (m *diskHealthMonitor) setOnDegraded(fn func()) {
+	m.mu.Lock()
+	m.mu.onDegraded = fn
+	m.mu.Unlock()
+	if m.degraded.Load() != nil {
+		fn()
+	}
+}
+
+// onDiskOp is the callback of the vfs.OnDiskOp filesystem wrapping the
+// filesystem of the DB.
+func (m *diskHealthMonitor) onDiskOp(info vfs.DiskOpInfo) {
+	if m.degraded.Load() != nil {
+		return
+	}
+	isErr := m.opts.ErrorThreshold > 0 && info.Err != nil && isDiskHealthError(info.Err)
+	isSlow := m.opts.SlowOpCount > 0 && m.opts.SlowOpThreshold > 0 &&
+		info.Duration >= m.opts.SlowOpThreshold
+	if !isErr && !isSlow {
+		return
+	}
+
+	now := m.timeNow()
+	dir := m.fs.PathDir(info.Path)
+	m.mu.Lock()
+	p := m.mu.paths[dir]
+	if p == nil {
+		p = &diskPathHealth{}
+		m.mu.paths[dir] = p
+	}
+	degraded := DiskDegradedInfo{Path: dir, Window: m.opts.Window, Op: info}
+	var tripped bool
+	if isErr && p.errors.add(now, m.opts.ErrorThreshold, m.opts.Window) {
+		tripped = true
+		degraded.Reason = DegradedReasonErrors
+		degraded.Count = m.opts.ErrorThreshold
+	}
+	if isSlow && p.slowOps.add(now, m.opts.SlowOpCount, m.opts.Window) && !tripped {
+		tripped = true
+		degraded.Reason = DegradedReasonSlowOps
+		degraded.Count = m.opts.SlowOpCount
+	}
+	if !tripped || !m.degraded.CompareAndSwap(nil, &degraded) {
+		m.mu.Unlock()
+		return
+	}
+	onDegraded := m.mu.onDegraded
+	m.mu.Unlock()
+
+	m.listener.DiskDegraded(degraded)
+	if onDegraded != nil {
+		onDegraded()
+	}
+}
+
+// isDiskHealthError returns true if the error returned by a filesystem
+// operation may signify a failing disk.
+func isDiskHealthError(err error) bool {
+	if oserror.IsNotExist(err) || oserror.IsExist(err) {
+		return false
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) && errno == syscall.ENOSPC {
+		// A full disk is not a failing disk.
+		return false
+	}
+	return true
+}
+
+// onDiskDegraded is invoked once the DB enters degraded mode. It may be
+// invoked while DB.mu is held, by the goroutine performing the operation upon
+// which the DB entered degraded mode.
+func (d *DB) onDiskDegraded() {
+	// Release the writes waiting for a write stall to end.
+	go func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.mu.compact.cond.Broadcast()
+	}()
+}
+
+// Degraded returns true if the DB entered degraded mode upon detecting a
+// failing disk, along with the info of the event. See
+// Options.Experimental.DegradedMode.
+func (d *DB) Degraded() (DiskDegradedInfo, bool) {
+	if d.diskHealth == nil {
+		return DiskDegradedInfo{}, false
+	}
+	if info := d.diskHealth.degraded.Load(); info != nil {
+		return *info, true
+	}
+	return DiskDegradedInfo{}, false
+}
+
+// degradedErr returns an error wrapping ErrDegraded if the DB entered degraded
+// mode.
+func (d *DB) degradedErr() error {
+	if d.diskHealth == nil {
+		return nil
+	}
+	if info := d.diskHealth.degraded.Load(); info != nil {
+		return errors.Wrapf(ErrDegraded, "%s", info)
+	}
+	return nil
+}
+
+// checkWritable returns ErrReadOnly if the DB is read-only, or an error
+// wrapping ErrDegraded if it entered degraded mode.
+func (d *DB) checkWritable() error {
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	return d.degradedErr()
+}
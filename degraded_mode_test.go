@@ -0,0 +1,162 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/cockroachdb/pebble/vfs/errorfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDegradedMode(t *testing.T) {
+	// Fail the creation of sstables once failSST is set, so that flushes fail
+	// persistently.
+	var failSST atomic.Bool
+	fs := errorfs.Wrap(vfs.NewMem(), errorfs.InjectorFunc(func(op errorfs.Op, path string) error {
+		if failSST.Load() && op == errorfs.OpCreate && strings.HasSuffix(path, ".sst") {
+			return errorfs.ErrInjected
+		}
+		return nil
+	}))
+	degradedCh := make(chan DiskDegradedInfo, 1)
+	opts := &Options{
+		FS:     fs,
+		Logger: testLogger{t: t},
+		EventListener: &EventListener{
+			DiskDegraded: func(info DiskDegradedInfo) { degradedCh <- info },
+		},
+		MaxWriteStallDuration: time.Minute,
+	}
+	opts.Experimental.DegradedMode = DegradedModeOptions{ErrorThreshold: 3}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Set([]byte("b"), []byte("2"), nil))
+	_, ok := d.Degraded()
+	require.False(t, ok)
+
+	// The flush fails repeatedly, until the DB enters degraded mode.
+	failSST.Store(true)
+	_, err = d.AsyncFlush()
+	require.NoError(t, err)
+	info := <-degradedCh
+	require.Equal(t, DegradedReasonErrors, info.Reason)
+	require.Equal(t, 3, info.Count)
+	require.Equal(t, vfs.OpTypeCreate, info.Op.OpType)
+	require.True(t, errors.Is(info.Op.Err, errorfs.ErrInjected))
+	got, ok := d.Degraded()
+	require.True(t, ok)
+	require.Equal(t, info, got)
+
+	// Writes fail fast.
+	err = d.Set([]byte("c"), []byte("3"), nil)
+	require.True(t, errors.Is(err, ErrDegraded))
+	require.True(t, errors.Is(err, ErrReadOnly))
+	_, err = d.AsyncFlush()
+	require.True(t, errors.Is(err, ErrDegraded))
+	require.True(t, errors.Is(d.Compact([]byte("a"), []byte("z"), false), ErrDegraded))
+	err = d.Ingest([]string{"ext"})
+	require.True(t, errors.Is(err, ErrDegraded))
+
+	// Reads are still served, from the sstable and the unflushed memtable.
+	for k, v := range map[string]string{"a": "1", "b": "2"} {
+		val, closer, err := d.Get([]byte(k))
+		require.NoError(t, err)
+		require.Equal(t, v, string(val))
+		require.NoError(t, closer.Close())
+	}
+	_, _, err = d.Get([]byte("c"))
+	require.Equal(t, ErrNotFound, err)
+
+	// Writes waiting for a write stall to end are released.
+	d.mu.Lock()
+	d.mu.writeStall.active = true
+	d.mu.Unlock()
+	require.True(t, errors.Is(d.waitForWriteStall(), ErrDegraded))
+	d.mu.Lock()
+	d.mu.writeStall.active = false
+	d.mu.Unlock()
+
+	require.NoError(t, d.Close())
+}
+
+func TestDiskHealthMonitor(t *testing.T) {
+	var events []DiskDegradedInfo
+	opts := &Options{
+		FS: vfs.NewMem(),
+		EventListener: &EventListener{
+			DiskDegraded: func(info DiskDegradedInfo) { events = append(events, info) },
+		},
+	}
+	opts.Experimental.DegradedMode = DegradedModeOptions{
+		ErrorThreshold:  2,
+		SlowOpThreshold: time.Second,
+		SlowOpCount:     3,
+		Window:          10 * time.Second,
+	}
+	m := newDiskHealthMonitor(opts)
+	now := time.Unix(0, 0)
+	m.timeNow = func() time.Time { return now }
+	var onDegraded int
+	m.setOnDegraded(func() { onDegraded++ })
+
+	slow := func(path string) {
+		m.onDiskOp(vfs.DiskOpInfo{Path: path, OpType: vfs.OpTypeSync, Duration: 2 * time.Second})
+	}
+	fail := func(path string, err error) {
+		m.onDiskOp(vfs.DiskOpInfo{Path: path, OpType: vfs.OpTypeWrite, Err: err})
+	}
+
+	// Errors that don't signify a failing disk, and fast operations, aren't
+	// counted.
+	for i := 0; i < 10; i++ {
+		fail("wal/000001.log", errors.WithStack(oserror.ErrNotExist))
+		fail("wal/000001.log", oserror.ErrExist)
+		fail("data/000002.sst", errors.Wrap(syscall.ENOSPC, "write"))
+		m.onDiskOp(vfs.DiskOpInfo{Path: "wal/000001.log", OpType: vfs.OpTypeSync, Duration: time.Millisecond})
+		now = now.Add(time.Minute)
+	}
+	require.Empty(t, events)
+
+	// Slow operations are counted per directory, within the window.
+	slow("wal/000001.log")
+	now = now.Add(2 * time.Second)
+	slow("data/000002.sst")
+	now = now.Add(3 * time.Second)
+	slow("wal/000001.log")
+	slow("data/000002.sst")
+	now = now.Add(6 * time.Second)
+	slow("wal/000001.log")
+	require.Empty(t, events)
+	slow("data/000002.sst")
+	require.Len(t, events, 1)
+	require.Equal(t, DiskDegradedInfo{
+		Path:   "data",
+		Reason: DegradedReasonSlowOps,
+		Count:  3,
+		Window: 10 * time.Second,
+		Op:     vfs.DiskOpInfo{Path: "data/000002.sst", OpType: vfs.OpTypeSync, Duration: 2 * time.Second},
+	}, events[0])
+	require.Equal(t, 1, onDegraded)
+
+	// Once degraded, further operations are ignored.
+	slow("data/000002.sst")
+	fail("data/000002.sst", errorfs.ErrInjected)
+	fail("data/000002.sst", errorfs.ErrInjected)
+	require.Len(t, events, 1)
+	require.Equal(t, 1, onDegraded)
+	require.Equal(t, "disk degraded (slow-ops): 3 in 10s on data; last: disk sync of file 000002.sst (2s)",
+		events[0].String())
+}
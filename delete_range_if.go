@@ -0,0 +1,109 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+
+	"github.com/cockroachdb/errors"
+)
+
+// DeletePredicate is a named predicate over point key-value pairs. Predicates
+// are registered through Options.Experimental.DeletePredicates and referenced
+// by name from DB.DeleteRangeIf.
+type DeletePredicate struct {
+	// Name is the name used to reference the predicate from DeleteRangeIf.
+	Name string
+	// Match returns true if the key-value pair should be deleted. Match must
+	// be deterministic and safe for concurrent use, as it is invoked from
+	// flushes and compactions running in background goroutines. The key and
+	// value must not be retained or modified.
+	Match func(key, value []byte) bool
+}
+
+// predicateDeletion is an in-progress DeleteRangeIf operation. All point keys
+// within [start, end) with a sequence number less than seqNum whose key-value
+// pair satisfies match are deleted by compactions that observe the
+// predicateDeletion.
+type predicateDeletion struct {
+	start, end []byte
+	seqNum     uint64
+	match      func(key, value []byte) bool
+}
+
+// covers returns true if the point key k is deleted by the predicate deletion
+// when its key-value pair satisfies match. nextSnapshot is the smallest open
+// snapshot sequence number that is greater than k's sequence number. A key is
+// only deleted if no open snapshot can observe both the key and the deletion.
+func (p *predicateDeletion) covers(cmp Compare, k *InternalKey, nextSnapshot uint64) bool {
+	if k.SeqNum() >= p.seqNum || nextSnapshot <= p.seqNum {
+		return false
+	}
+	return cmp(k.UserKey, p.start) >= 0 && cmp(k.UserKey, p.end) < 0
+}
+
+// DeleteRangeIf deletes the point keys within [start, end) whose key-value
+// pair satisfies the registered DeletePredicate with the provided name. Only
+// keys committed before DeleteRangeIf is called are considered, and only SET
+// records are subject to the predicate: MERGE operands and keys already
+// deleted are unaffected.
+//
+// DeleteRangeIf is implemented by compacting the key range with the predicate
+// applied, so it does not require reading and tombstoning each key
+// individually. Keys that are visible to an open snapshot taken before the
+// call are not deleted. The operation is not persisted: if the DB is closed
+// or crashes before DeleteRangeIf returns, some of the matching keys may
+// remain and the call should be retried.
+func (d *DB) DeleteRangeIf(start, end []byte, predicate string) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	if d.cmp(start, end) >= 0 {
+		return errors.Errorf("DeleteRangeIf start %s is not less than end %s",
+			d.opts.Comparer.FormatKey(start), d.opts.Comparer.FormatKey(end))
+	}
+	var match func(key, value []byte) bool
+	for _, p := range d.opts.Experimental.DeletePredicates {
+		if p.Name == predicate {
+			match = p.Match
+			break
+		}
+	}
+	if match == nil {
+		return errors.Errorf("pebble: unknown delete predicate %q", errors.Safe(predicate))
+	}
+
+	pd := &predicateDeletion{
+		start:  append([]byte(nil), start...),
+		end:    append([]byte(nil), end...),
+		seqNum: d.mu.versions.visibleSeqNum.Load(),
+		match:  match,
+	}
+	d.mu.Lock()
+	d.mu.compact.predicateDeletions = append(d.mu.compact.predicateDeletions, pd)
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		dels := d.mu.compact.predicateDeletions
+		for i := range dels {
+			if dels[i] == pd {
+				d.mu.compact.predicateDeletions = append(dels[:i:i], dels[i+1:]...)
+				break
+			}
+		}
+	}()
+
+	if err := d.Compact(start, end, false /* parallelize */); err != nil {
+		return err
+	}
+	// Compact does not rewrite files that exist only in the bottommost level,
+	// so explicitly rewrite the bottommost files overlapping the range.
+	return d.manualCompact(context.Background(), start, end, numLevels-1,
+		&CompactOptions{}, &ManualCompactionProgress{})
+}
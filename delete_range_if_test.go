@@ -0,0 +1,137 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteRangeIf(t *testing.T) {
+	opts := &Options{FS: vfs.NewMem()}
+	opts.Experimental.DeletePredicates = []*DeletePredicate{{
+		Name: "drop",
+		Match: func(key, value []byte) bool {
+			return bytes.Equal(value, []byte("drop"))
+		},
+	}}
+	d, err := Open("", testingRandomized(t, opts))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	// Write keys spread across the bottommost level, L0 and the memtable.
+	for i := 0; i < 30; i++ {
+		val := "keep"
+		if i%2 == 0 {
+			val = "drop"
+		}
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("k%02d", i)), []byte(val), nil))
+		switch i {
+		case 9:
+			require.NoError(t, d.Compact([]byte("k00"), []byte("k10"), false))
+		case 19:
+			require.NoError(t, d.Flush())
+		}
+	}
+
+	require.Error(t, d.DeleteRangeIf([]byte("k00"), []byte("k30"), "unknown"))
+	require.NoError(t, d.DeleteRangeIf([]byte("k00"), []byte("k28"), "drop"))
+
+	for i := 0; i < 30; i++ {
+		key := []byte(fmt.Sprintf("k%02d", i))
+		if i%2 == 0 && i < 28 {
+			verifyGetNotFound(t, d, key)
+		} else if i%2 == 0 {
+			verifyGet(t, d, key, []byte("drop"))
+		} else {
+			verifyGet(t, d, key, []byte("keep"))
+		}
+	}
+
+	// Keys written after the DeleteRangeIf are not deleted by it, even if they
+	// are later compacted.
+	require.NoError(t, d.Set([]byte("k00"), []byte("drop"), nil))
+	require.NoError(t, d.Compact([]byte("k00"), []byte("k30"), false))
+	verifyGet(t, d, []byte("k00"), []byte("drop"))
+}
+
+func TestDeleteRangeIfSnapshot(t *testing.T) {
+	opts := &Options{FS: vfs.NewMem()}
+	opts.Experimental.DeletePredicates = []*DeletePredicate{{
+		Name: "drop",
+		Match: func(key, value []byte) bool {
+			return bytes.Equal(value, []byte("drop"))
+		},
+	}}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	require.NoError(t, d.Set([]byte("a"), []byte("drop"), nil))
+	require.NoError(t, d.Set([]byte("b"), []byte("drop"), nil))
+	snap := d.NewSnapshot()
+	require.NoError(t, d.DeleteRangeIf([]byte("a"), []byte("z"), "drop"))
+
+	// The snapshot predates the DeleteRangeIf and pins both keys.
+	for _, k := range []string{"a", "b"} {
+		v, closer, err := snap.Get([]byte(k))
+		require.NoError(t, err)
+		require.Equal(t, "drop", string(v))
+		require.NoError(t, closer.Close())
+	}
+	require.NoError(t, snap.Close())
+
+	// Once the snapshot is closed, a subsequent DeleteRangeIf deletes the keys.
+	require.NoError(t, d.DeleteRangeIf([]byte("a"), []byte("z"), "drop"))
+	verifyGetNotFound(t, d, []byte("a"))
+	verifyGetNotFound(t, d, []byte("b"))
+}
+
+// TestDeleteRangeIfBaseLevel verifies that keys are deleted when a manual
+// compaction would otherwise move a file into a base level above the
+// bottommost level without rewriting it.
+func TestDeleteRangeIfBaseLevel(t *testing.T) {
+	opts := &Options{
+		FS:                          vfs.NewMem(),
+		LBaseMaxBytes:               1,
+		DisableAutomaticCompactions: true,
+	}
+	opts.Experimental.DeletePredicates = []*DeletePredicate{{
+		Name: "drop",
+		Match: func(key, value []byte) bool {
+			return bytes.Equal(value, []byte("drop"))
+		},
+	}}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("z%02d", i)), []byte("keep"), nil))
+	}
+	require.NoError(t, d.Compact([]byte("z"), []byte("zz"), false))
+	require.NoError(t, d.Set([]byte("a"), []byte("drop"), nil))
+	require.NoError(t, d.Flush())
+	d.mu.Lock()
+	baseLevel := d.mu.versions.picker.getBaseLevel()
+	d.mu.Unlock()
+	require.Less(t, baseLevel, numLevels-1)
+
+	moves := d.Metrics().Compact.MoveCount
+	require.NoError(t, d.DeleteRangeIf([]byte("a"), []byte("b"), "drop"))
+	verifyGetNotFound(t, d, []byte("a"))
+	verifyGet(t, d, []byte("z00"), []byte("keep"))
+	require.Equal(t, moves, d.Metrics().Compact.MoveCount)
+}
@@ -0,0 +1,108 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"time"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DiskOpInfo contains the info for a filesystem operation that exceeded
+// Options.Experimental.DiskOpTraceThreshold.
+type DiskOpInfo = vfs.DiskOpInfo
+
+// DiskOpKind classifies the filesystem operations whose latencies are recorded
+// in Metrics.DiskOps.
+type DiskOpKind int8
+
+const (
+	// DiskOpRead is the kind of the reads of files.
+	DiskOpRead DiskOpKind = iota
+	// DiskOpWrite is the kind of the writes and preallocations of files.
+	DiskOpWrite
+	// DiskOpSync is the kind of the syncs of files and directories.
+	DiskOpSync
+	// DiskOpCreate is the kind of the creations, links and renames of files,
+	// and of the creations of directories.
+	DiskOpCreate
+	// DiskOpRemove is the kind of the removals of files and directories.
+	DiskOpRemove
+	// NumDiskOpKinds is the number of kinds of filesystem operations.
+	NumDiskOpKinds
+)
+
+// String implements fmt.Stringer.
+func (k DiskOpKind) String() string {
+	switch k {
+	case DiskOpRead:
+		return "read"
+	case DiskOpWrite:
+		return "write"
+	case DiskOpSync:
+		return "sync"
+	case DiskOpCreate:
+		return "create"
+	case DiskOpRemove:
+		return "remove"
+	}
+	return "unknown"
+}
+
+func diskOpKind(opType vfs.OpType) DiskOpKind {
+	switch opType {
+	case vfs.OpTypeRead:
+		return DiskOpRead
+	case vfs.OpTypeWrite, vfs.OpTypePreallocate:
+		return DiskOpWrite
+	case vfs.OpTypeSync, vfs.OpTypeSyncData, vfs.OpTypeSyncTo:
+		return DiskOpSync
+	case vfs.OpTypeRemove, vfs.OpTypeRemoveAll:
+		return DiskOpRemove
+	}
+	return DiskOpCreate
+}
+
+// diskOpTracer observes the filesystem operations of the DB, recording their
+// latencies (see Options.Experimental.EnableDiskOpMetrics) and invoking
+// EventListener.DiskOpTrace for those that exceed
+// Options.Experimental.DiskOpTraceThreshold.
+type diskOpTracer struct {
+	// latency holds the histograms of the latencies of each kind of
+	// operation, recorded in nanoseconds, or nils if they're not recorded.
+	latency   [NumDiskOpKinds]prometheus.Histogram
+	threshold time.Duration
+	trace     func(DiskOpInfo)
+}
+
+// newDiskOpTracer returns a tracer configured by the options, or nil if
+// neither the metrics nor the tracing of filesystem operations are enabled.
+func newDiskOpTracer(opts *Options) *diskOpTracer {
+	if !opts.Experimental.EnableDiskOpMetrics && opts.Experimental.DiskOpTraceThreshold <= 0 {
+		return nil
+	}
+	t := &diskOpTracer{
+		threshold: opts.Experimental.DiskOpTraceThreshold,
+		trace:     opts.EventListener.DiskOpTrace,
+	}
+	if opts.Experimental.EnableDiskOpMetrics {
+		for i := range t.latency {
+			t.latency[i] = prometheus.NewHistogram(prometheus.HistogramOpts{Buckets: DiskOpLatencyBuckets})
+		}
+	}
+	return t
+}
+
+// onDiskOp is the callback of the vfs.TraceDiskOps filesystem wrapping the
+// filesystem of the DB.
+func (t *diskOpTracer) onDiskOp(info vfs.DiskOpInfo) {
+	if h := t.latency[diskOpKind(info.OpType)]; h != nil {
+		h.Observe(float64(info.Duration))
+	}
+	if t.threshold > 0 && info.Duration >= t.threshold {
+		t.trace(info)
+	}
+}
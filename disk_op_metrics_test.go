@@ -0,0 +1,74 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/cockroachdb/pebble/vfs/errorfs"
+	prometheusgo "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskOpMetrics(t *testing.T) {
+	// Syncs of sstables are slow.
+	sst, err := errorfs.PathMatches("*.sst")
+	require.NoError(t, err)
+	fs := errorfs.Wrap(vfs.NewMem(), errorfs.If(
+		errorfs.And(errorfs.OnOps(errorfs.OpFileSync), sst), errorfs.WithLatency(20*time.Millisecond)))
+
+	var mu sync.Mutex
+	var traced []DiskOpInfo
+	opts := &Options{
+		FS:     fs,
+		Logger: testLogger{t: t},
+		EventListener: &EventListener{
+			DiskOpTrace: func(info DiskOpInfo) {
+				mu.Lock()
+				defer mu.Unlock()
+				traced = append(traced, info)
+			},
+		},
+	}
+	opts.Experimental.EnableDiskOpMetrics = true
+	opts.Experimental.DiskOpTraceThreshold = 10 * time.Millisecond
+	opts.DisableAutomaticCompactions = true
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Flush())
+	// Read the sstable.
+	val, closer, err := d.Get([]byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, "1", string(val))
+	require.NoError(t, closer.Close())
+
+	count := func(k DiskOpKind) uint64 {
+		var m prometheusgo.Metric
+		require.NoError(t, d.Metrics().DiskOps.Latency[k].Write(&m))
+		return m.GetHistogram().GetSampleCount()
+	}
+	for k := DiskOpKind(0); k < NumDiskOpKinds; k++ {
+		if k == DiskOpRemove {
+			continue
+		}
+		require.NotZero(t, count(k), "%s", k)
+	}
+
+	// Only the sync of the sstable is traced.
+	mu.Lock()
+	defer mu.Unlock()
+	require.NotEmpty(t, traced)
+	for _, info := range traced {
+		require.Equal(t, DiskOpSync, diskOpKind(info.OpType))
+		require.GreaterOrEqual(t, info.Duration, 20*time.Millisecond)
+		require.Contains(t, info.String(), ".sst")
+	}
+}
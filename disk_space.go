@@ -0,0 +1,158 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/humanize"
+	"github.com/cockroachdb/redact"
+)
+
+// diskSpaceCheckInterval is the interval at which the free disk space is
+// refreshed when Options.DiskSpaceSoftLimit or Options.DiskSpaceHardLimit is
+// set. The free disk space is also refreshed whenever a flush or compaction
+// completes, and whenever an obsolete file is deleted.
+const diskSpaceCheckInterval = time.Second
+
+// DiskSpaceState is the state of the free disk space relative to
+// Options.DiskSpaceSoftLimit and Options.DiskSpaceHardLimit.
+type DiskSpaceState int8
+
+const (
+	// DiskSpaceNormal indicates that the free disk space is above the soft
+	// limit.
+	DiskSpaceNormal DiskSpaceState = iota
+	// DiskSpaceLow indicates that the free disk space is below the soft limit:
+	// low priority compactions are paused.
+	DiskSpaceLow
+	// DiskSpaceCritical indicates that the free disk space is below the hard
+	// limit: writes fail with an error wrapping ErrDiskFull.
+	DiskSpaceCritical
+)
+
+// String implements fmt.Stringer.
+func (s DiskSpaceState) String() string {
+	switch s {
+	case DiskSpaceNormal:
+		return "normal"
+	case DiskSpaceLow:
+		return "low"
+	case DiskSpaceCritical:
+		return "critical"
+	}
+	return "unknown"
+}
+
+// DiskSpaceInfo contains the info for a change of the state of the free disk
+// space.
+type DiskSpaceInfo struct {
+	// Path is the directory of the DB.
+	Path string
+	// AvailBytes is the free disk space.
+	AvailBytes uint64
+	// SoftLimit and HardLimit are the limits configured by
+	// Options.DiskSpaceSoftLimit and Options.DiskSpaceHardLimit.
+	SoftLimit, HardLimit uint64
+	// State is the new state of the free disk space.
+	State DiskSpaceState
+	// Prev is the previous state of the free disk space.
+	Prev DiskSpaceState
+}
+
+func (i DiskSpaceInfo) String() string {
+	return redact.StringWithoutMarkers(i)
+}
+
+// SafeFormat implements redact.SafeFormatter.
+func (i DiskSpaceInfo) SafeFormat(w redact.SafePrinter, _ rune) {
+	w.Printf("disk space %s -> %s: %s available on %s (soft limit %s, hard limit %s)",
+		redact.Safe(i.Prev.String()), redact.Safe(i.State.String()),
+		humanize.Bytes.Uint64(i.AvailBytes), i.Path,
+		humanize.Bytes.Uint64(i.SoftLimit), humanize.Bytes.Uint64(i.HardLimit))
+}
+
+// diskSpaceLimitsEnabled returns true if Options.DiskSpaceSoftLimit or
+// Options.DiskSpaceHardLimit is set.
+func (o *Options) diskSpaceLimitsEnabled() bool {
+	return o.DiskSpaceSoftLimit > 0 || o.DiskSpaceHardLimit > 0
+}
+
+// diskSpaceStateFor returns the state of the provided free disk space.
+func (o *Options) diskSpaceStateFor(availBytes uint64) DiskSpaceState {
+	switch {
+	case o.DiskSpaceHardLimit > 0 && availBytes < o.DiskSpaceHardLimit:
+		return DiskSpaceCritical
+	case o.DiskSpaceSoftLimit > 0 && availBytes < o.DiskSpaceSoftLimit:
+		return DiskSpaceLow
+	}
+	return DiskSpaceNormal
+}
+
+// updateDiskSpaceState updates the state of the free disk space after it was
+// refreshed, invoking EventListener.DiskSpace if it changed. When the free
+// disk space rises above the soft limit, the compactions paused below it are
+// scheduled.
+func (d *DB) updateDiskSpaceState(availBytes uint64) {
+	if !d.opts.diskSpaceLimitsEnabled() || d.opts.ReadOnly {
+		return
+	}
+	state := d.opts.diskSpaceStateFor(availBytes)
+	prev := DiskSpaceState(d.diskSpaceState.Swap(int32(state)))
+	if state == prev {
+		return
+	}
+	d.opts.EventListener.DiskSpace(DiskSpaceInfo{
+		Path:       d.dirname,
+		AvailBytes: availBytes,
+		SoftLimit:  d.opts.DiskSpaceSoftLimit,
+		HardLimit:  d.opts.DiskSpaceHardLimit,
+		State:      state,
+		Prev:       prev,
+	})
+	if state == DiskSpaceNormal {
+		// Schedule the compactions that were paused. The caller may hold d.mu.
+		go func() {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			d.maybeScheduleCompaction()
+		}()
+	}
+}
+
+// diskSpaceLow returns true if the free disk space is below
+// Options.DiskSpaceSoftLimit, in which case low priority compactions are
+// paused.
+func (d *DB) diskSpaceLow() bool {
+	return DiskSpaceState(d.diskSpaceState.Load()) != DiskSpaceNormal
+}
+
+// checkDiskSpace returns an error wrapping ErrDiskFull if the free disk space
+// is below Options.DiskSpaceHardLimit.
+func (d *DB) checkDiskSpace() error {
+	if DiskSpaceState(d.diskSpaceState.Load()) != DiskSpaceCritical {
+		return nil
+	}
+	return errors.Wrapf(ErrDiskFull, "%s available, below the hard limit of %s",
+		humanize.Bytes.Uint64(d.diskAvailBytes.Load()),
+		humanize.Bytes.Uint64(d.opts.DiskSpaceHardLimit))
+}
+
+// diskSpaceCheckLoop refreshes the free disk space at diskSpaceCheckInterval,
+// so that the limits are enforced while no flush, compaction or file deletion
+// refreshes it. It exits when the DB is closed.
+func (d *DB) diskSpaceCheckLoop() {
+	ticker := time.NewTicker(diskSpaceCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.closedCh:
+			return
+		case <-ticker.C:
+			d.calculateDiskAvailableBytes()
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+// diskUsageFS is a vfs.FS reporting a configurable amount of free disk space.
+type diskUsageFS struct {
+	vfs.FS
+	availBytes atomic.Uint64
+}
+
+func (fs *diskUsageFS) GetDiskUsage(path string) (vfs.DiskUsage, error) {
+	avail := fs.availBytes.Load()
+	return vfs.DiskUsage{AvailBytes: avail, TotalBytes: 1 << 30, UsedBytes: 1<<30 - avail}, nil
+}
+
+func TestDiskSpaceLimits(t *testing.T) {
+	fs := &diskUsageFS{FS: vfs.NewMem()}
+	fs.availBytes.Store(1000)
+	var mu sync.Mutex
+	var events []DiskSpaceInfo
+	opts := &Options{
+		FS:     fs,
+		Logger: testLogger{t: t},
+		EventListener: &EventListener{
+			DiskSpace: func(info DiskSpaceInfo) {
+				mu.Lock()
+				defer mu.Unlock()
+				events = append(events, info)
+			},
+		},
+		DiskSpaceSoftLimit: 100,
+		DiskSpaceHardLimit: 50,
+	}
+	d, err := Open("db", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	setAvail := func(avail uint64) {
+		fs.availBytes.Store(avail)
+		d.calculateDiskAvailableBytes()
+	}
+	lastEvent := func() DiskSpaceInfo {
+		mu.Lock()
+		defer mu.Unlock()
+		require.NotEmpty(t, events)
+		return events[len(events)-1]
+	}
+
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.False(t, d.diskSpaceLow())
+
+	// Below the soft limit, writes are accepted but low priority compactions
+	// are paused.
+	setAvail(75)
+	require.Equal(t, DiskSpaceInfo{
+		Path: "db", AvailBytes: 75, SoftLimit: 100, HardLimit: 50,
+		State: DiskSpaceLow, Prev: DiskSpaceNormal,
+	}, lastEvent())
+	require.Equal(t, "disk space normal -> low: 75B available on db (soft limit 100B, hard limit 50B)",
+		lastEvent().String())
+	require.True(t, d.diskSpaceLow())
+	require.NoError(t, d.Set([]byte("b"), []byte("2"), nil))
+	d.mu.Lock()
+	pc := d.mu.versions.picker.pickReadTriggeredCompaction(compactionEnv{
+		readCompactionEnv: readCompactionEnv{readCompactions: &d.mu.compact.readCompactions},
+		diskSpaceLow:      true,
+	})
+	d.mu.Unlock()
+	require.Nil(t, pc)
+
+	// Below the hard limit, writes fail fast while reads are still served.
+	setAvail(10)
+	require.Equal(t, DiskSpaceCritical, lastEvent().State)
+	err = d.Set([]byte("c"), []byte("3"), nil)
+	require.True(t, errors.Is(err, ErrDiskFull))
+	val, closer, err := d.Get([]byte("b"))
+	require.NoError(t, err)
+	require.Equal(t, "2", string(val))
+	require.NoError(t, closer.Close())
+
+	// Refreshing the free disk space without a change of state doesn't invoke
+	// the event listener again.
+	setAvail(20)
+	mu.Lock()
+	require.Len(t, events, 2)
+	mu.Unlock()
+
+	// Once space is freed, writes are accepted again.
+	setAvail(1000)
+	require.Equal(t, DiskSpaceInfo{
+		Path: "db", AvailBytes: 1000, SoftLimit: 100, HardLimit: 50,
+		State: DiskSpaceNormal, Prev: DiskSpaceCritical,
+	}, lastEvent())
+	require.False(t, d.diskSpaceLow())
+	require.NoError(t, d.Set([]byte("c"), []byte("3"), nil))
+}
+
+func TestDiskSpaceLimitsValidate(t *testing.T) {
+	opts := &Options{DiskSpaceSoftLimit: 100, DiskSpaceHardLimit: 200}
+	opts.EnsureDefaults()
+	err := opts.Validate()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "DiskSpaceHardLimit (200B) must be <= DiskSpaceSoftLimit (100B)")
+
+	// A hard limit alone is valid.
+	opts.DiskSpaceSoftLimit = 0
+	require.NoError(t, opts.Validate())
+}
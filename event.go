@@ -13,6 +13,7 @@ import (
 	"github.com/cockroachdb/pebble/internal/humanize"
 	"github.com/cockroachdb/pebble/internal/invariants"
 	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/cockroachdb/redact"
 )
@@ -135,6 +136,10 @@ type FlushInfo struct {
 	// Output contains the ouptut table generated by the flush. The output info
 	// is empty for the flush begin event.
 	Output []TableInfo
+	// OutputProperties contains the properties of each table in Output, in the
+	// same order. The properties of tables added by an ingestion (see Ingest)
+	// are nil.
+	OutputProperties []*sstable.Properties
 	// Duration is the time spent flushing. This duration includes writing and
 	// syncing all of the flushed keys to sstables.
 	Duration time.Duration
@@ -378,6 +383,44 @@ func (i TableValidatedInfo) SafeFormat(w redact.SafePrinter, _ rune) {
 	w.Printf("[JOB %d] validated table: %s", redact.Safe(i.JobID), i.Meta)
 }
 
+// WALCorruptionInfo contains info about corruption encountered within a WAL
+// file while it is replayed by Open, followed by valid batches. The batches
+// between the corruption and the valid batches are lost.
+type WALCorruptionInfo struct {
+	// JobID is the ID of the job replaying the WAL.
+	JobID int
+	Path  string
+	// The file number of the WAL.
+	FileNum FileNum
+	// Offset is the offset within the WAL file of the corrupt record.
+	Offset int64
+	// LostSeqNums is the range of sequence numbers of the lost batches, from
+	// the sequence number following the last batch preceding the corruption to
+	// the sequence number of the first valid batch following it, exclusive.
+	LostSeqNums [2]uint64
+	// Skipped is true if replay skipped the corruption, continuing with the
+	// valid batches following it (see WALRecoverySkipAndReport). Otherwise the
+	// valid batches following the corruption were discarded as well, or Open
+	// failed.
+	Skipped bool
+	Err     error
+}
+
+func (i WALCorruptionInfo) String() string {
+	return redact.StringWithoutMarkers(i)
+}
+
+// SafeFormat implements redact.SafeFormatter.
+func (i WALCorruptionInfo) SafeFormat(w redact.SafePrinter, _ rune) {
+	action := "discarded"
+	if i.Skipped {
+		action = "skipped"
+	}
+	w.Printf("[JOB %d] WAL %s corrupt at offset %d: %s; lost seqnums [%d,%d), %s the following batches",
+		redact.Safe(i.JobID), redact.Safe(i.FileNum), redact.Safe(i.Offset), i.Err,
+		redact.Safe(i.LostSeqNums[0]), redact.Safe(i.LostSeqNums[1]), redact.Safe(action))
+}
+
 // WALCreateInfo contains info about a WAL creation event.
 type WALCreateInfo struct {
 	// JobID is the ID of the job the caused the WAL to be created.
@@ -433,9 +476,51 @@ func (i WALDeleteInfo) SafeFormat(w redact.SafePrinter, _ rune) {
 	w.Printf("[JOB %d] WAL deleted %s", redact.Safe(i.JobID), redact.Safe(i.FileNum))
 }
 
+// WriteStallCause is the cause of a write stall.
+type WriteStallCause int8
+
+const (
+	// WriteStallMemTableCount indicates that writes are stalled because the
+	// queued memtables exceed Options.MemTableStopWritesThreshold.
+	WriteStallMemTableCount WriteStallCause = iota
+	// WriteStallL0FileCount indicates that writes are stalled because L0 read
+	// amplification exceeds Options.L0StopWritesThreshold.
+	WriteStallL0FileCount
+	// WriteStallDiskFull indicates that writes are stalled because memtables
+	// cannot be flushed as the disk is full.
+	WriteStallDiskFull
+	// WriteStallWALQuota indicates that writes are stalled because the WAL
+	// data that has not been flushed exceeds Options.MaxUnflushedWALBytes.
+	WriteStallWALQuota
+)
+
+// String implements fmt.Stringer.
+func (c WriteStallCause) String() string {
+	switch c {
+	case WriteStallMemTableCount:
+		return "memtable count limit reached"
+	case WriteStallL0FileCount:
+		return "L0 file count limit exceeded"
+	case WriteStallDiskFull:
+		return "disk full"
+	case WriteStallWALQuota:
+		return "un-flushed WAL size limit reached"
+	default:
+		return "unknown"
+	}
+}
+
+// SafeFormat implements redact.SafeFormatter.
+func (c WriteStallCause) SafeFormat(w redact.SafePrinter, _ rune) {
+	w.Print(redact.SafeString(c.String()))
+}
+
 // WriteStallBeginInfo contains the info for a write stall begin event.
 type WriteStallBeginInfo struct {
+	// Reason is a description of the cause of the stall.
 	Reason string
+	// Cause is the cause of the stall.
+	Cause WriteStallCause
 }
 
 func (i WriteStallBeginInfo) String() string {
@@ -475,6 +560,27 @@ type EventListener struct {
 	// working.
 	DiskSlow func(DiskSlowInfo)
 
+	// DiskDegraded is invoked when the DB enters a read-only degraded mode
+	// upon detecting a failing disk (see Options.Experimental.DegradedMode).
+	// DiskDegraded is called by the goroutine performing the filesystem
+	// operation upon which the DB entered degraded mode, which may hold
+	// internal locks of the DB: the callee MUST return without doing any IO,
+	// or calling back into the DB.
+	DiskDegraded func(DiskDegradedInfo)
+
+	// DiskSpace is invoked when the free disk space crosses
+	// Options.DiskSpaceSoftLimit or Options.DiskSpaceHardLimit, in either
+	// direction. DiskSpace may be called while internal locks of the DB are
+	// held: the callee MUST return without calling back into the DB.
+	DiskSpace func(DiskSpaceInfo)
+
+	// DiskOpTrace is invoked after a filesystem operation of the DB took at
+	// least Options.Experimental.DiskOpTraceThreshold. DiskOpTrace is called by
+	// the goroutine performing the operation, which may hold internal locks of
+	// the DB: the callee MUST return without doing any IO, or calling back into
+	// the DB.
+	DiskOpTrace func(DiskOpInfo)
+
 	// FlushBegin is invoked after the inputs to a flush have been determined,
 	// but before the flush has produced any output.
 	FlushBegin func(FlushInfo)
@@ -510,6 +616,10 @@ type EventListener struct {
 	// TableValidated is invoked after validation runs on an sstable.
 	TableValidated func(TableValidatedInfo)
 
+	// WALCorruption is invoked when the replay of a WAL by Open encounters
+	// corruption followed by valid batches. See Options.WALRecoveryMode.
+	WALCorruption func(WALCorruptionInfo)
+
 	// WALCreated is invoked after a WAL has been created.
 	WALCreated func(WALCreateInfo)
 
@@ -546,6 +656,33 @@ func (l *EventListener) EnsureDefaults(logger Logger) {
 	if l.DiskSlow == nil {
 		l.DiskSlow = func(info DiskSlowInfo) {}
 	}
+	if l.DiskDegraded == nil {
+		if logger != nil {
+			l.DiskDegraded = func(info DiskDegradedInfo) {
+				logger.Infof("%s", info)
+			}
+		} else {
+			l.DiskDegraded = func(info DiskDegradedInfo) {}
+		}
+	}
+	if l.DiskOpTrace == nil {
+		if logger != nil {
+			l.DiskOpTrace = func(info DiskOpInfo) {
+				logger.Infof("%s", info)
+			}
+		} else {
+			l.DiskOpTrace = func(info DiskOpInfo) {}
+		}
+	}
+	if l.DiskSpace == nil {
+		if logger != nil {
+			l.DiskSpace = func(info DiskSpaceInfo) {
+				logger.Infof("%s", info)
+			}
+		} else {
+			l.DiskSpace = func(info DiskSpaceInfo) {}
+		}
+	}
 	if l.FlushBegin == nil {
 		l.FlushBegin = func(info FlushInfo) {}
 	}
@@ -576,6 +713,9 @@ func (l *EventListener) EnsureDefaults(logger Logger) {
 	if l.TableValidated == nil {
 		l.TableValidated = func(validated TableValidatedInfo) {}
 	}
+	if l.WALCorruption == nil {
+		l.WALCorruption = func(info WALCorruptionInfo) {}
+	}
 	if l.WALCreated == nil {
 		l.WALCreated = func(info WALCreateInfo) {}
 	}
@@ -610,6 +750,15 @@ func MakeLoggingEventListener(logger Logger) EventListener {
 		DiskSlow: func(info DiskSlowInfo) {
 			logger.Infof("%s", info)
 		},
+		DiskDegraded: func(info DiskDegradedInfo) {
+			logger.Infof("%s", info)
+		},
+		DiskSpace: func(info DiskSpaceInfo) {
+			logger.Infof("%s", info)
+		},
+		DiskOpTrace: func(info DiskOpInfo) {
+			logger.Infof("%s", info)
+		},
 		FlushBegin: func(info FlushInfo) {
 			logger.Infof("%s", info)
 		},
@@ -640,6 +789,9 @@ func MakeLoggingEventListener(logger Logger) EventListener {
 		TableValidated: func(info TableValidatedInfo) {
 			logger.Infof("%s", info)
 		},
+		WALCorruption: func(info WALCorruptionInfo) {
+			logger.Infof("%s", info)
+		},
 		WALCreated: func(info WALCreateInfo) {
 			logger.Infof("%s", info)
 		},
@@ -676,6 +828,18 @@ func TeeEventListener(a, b EventListener) EventListener {
 			a.DiskSlow(info)
 			b.DiskSlow(info)
 		},
+		DiskDegraded: func(info DiskDegradedInfo) {
+			a.DiskDegraded(info)
+			b.DiskDegraded(info)
+		},
+		DiskSpace: func(info DiskSpaceInfo) {
+			a.DiskSpace(info)
+			b.DiskSpace(info)
+		},
+		DiskOpTrace: func(info DiskOpInfo) {
+			a.DiskOpTrace(info)
+			b.DiskOpTrace(info)
+		},
 		FlushBegin: func(info FlushInfo) {
 			a.FlushBegin(info)
 			b.FlushBegin(info)
@@ -716,6 +880,10 @@ func TeeEventListener(a, b EventListener) EventListener {
 			a.TableValidated(info)
 			b.TableValidated(info)
 		},
+		WALCorruption: func(info WALCorruptionInfo) {
+			a.WALCorruption(info)
+			b.WALCorruption(info)
+		},
 		WALCreated: func(info WALCreateInfo) {
 			a.WALCreated(info)
 			b.WALCreated(info)
@@ -0,0 +1,346 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/internal/rate"
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// exportChunkSize is the number of bytes exported between checks of the
+// context and waits on the rate limiter of an export.
+const exportChunkSize = 64 << 10 /* 64KB */
+
+// ExportOptions configures an export of a key range to sstables (see
+// DB.Export).
+type ExportOptions struct {
+	// FS and Dir are the filesystem and the directory the sstables are
+	// written to, which is created if it doesn't exist. The sstables are
+	// named export-000001.sst, export-000002.sst and so on, and existing files
+	// with these names are overwritten. FS defaults to the FS of the DB.
+	FS  vfs.FS
+	Dir string
+
+	// TargetFileSize is the size above which the sstable being written is
+	// finished and a new one is started. The versions of a key (the keys with
+	// the same prefix, see Comparer.Split) are never split across sstables.
+	// The default is the TargetFileSize of the bottommost level.
+	TargetFileSize int64
+
+	// TableFormat is the format of the sstables. The default is the newest
+	// format supported by the format major version of the DB; an older format
+	// may be required by the DB that ingests the sstables. Regardless of the
+	// format, the sstables don't use the block extensions of
+	// ExperimentalFormatBlockExtensions, nor the prefix extractor, the TTL or
+	// the table key manager of the DB.
+	TableFormat sstable.TableFormat
+
+	// BytesPerSec limits the rate at which the keys and values are exported,
+	// if positive. The default is to export them as fast as possible.
+	BytesPerSec int64
+
+	// IncludeHistory exports the older versions of the keys along with the
+	// newest ones. The versions of a key are the keys with the same prefix
+	// (see Comparer.Split), and the first of them in key order is the newest;
+	// by default, only the newest version of each key is exported. If
+	// HistorySuffix is set, only the older versions that sort before or at the
+	// key with the same prefix and the suffix HistorySuffix are exported,
+	// which, with suffixes holding timestamps in descending order, is the
+	// window of the versions that are at least as recent as HistorySuffix.
+	//
+	// With a Comparer that doesn't split keys into prefixes and suffixes, each
+	// key is its own newest version, and all of the keys are exported.
+	IncludeHistory bool
+	HistorySuffix  []byte
+
+	// IncludeRangeKeys exports the range keys overlapping the key range, with
+	// their bounds truncated to the key range and to the sstables they're
+	// written to. The history options don't apply to range keys.
+	IncludeRangeKeys bool
+}
+
+// ExportedTable is an sstable written by DB.Export.
+type ExportedTable struct {
+	Path string
+	Size int64
+	// Keys is the number of point keys in the sstable.
+	Keys int
+}
+
+// Export writes the live data of the DB in the key range [start, end) into
+// sstables suitable for ingestion into another DB (see DB.Ingest), and
+// returns them, in key order. The data is read from a consistent view of the
+// DB: point keys are exported as SET keys with their current values (merge
+// operands are merged), and deleted keys aren't exported. See ExportOptions
+// for the versions of the keys and the range keys that are exported.
+//
+// If the export fails, or ctx is done before it completes, the sstables
+// written so far are removed.
+func (d *DB) Export(
+	ctx context.Context, start, end []byte, opts ExportOptions,
+) (_ []ExportedTable, err error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if start != nil && end != nil && d.cmp(start, end) >= 0 {
+		return nil, errors.Errorf("pebble: invalid export range [%s, %s)",
+			d.opts.Comparer.FormatKey(start), d.opts.Comparer.FormatKey(end))
+	}
+	e := &exporter{d: d, ctx: ctx, opts: opts}
+	if e.opts.FS == nil {
+		e.opts.FS = d.opts.FS
+	}
+	if e.opts.TargetFileSize <= 0 {
+		e.opts.TargetFileSize = d.opts.Level(numLevels - 1).TargetFileSize
+	}
+	if e.opts.TableFormat == sstable.TableFormatUnspecified {
+		e.opts.TableFormat = d.FormatMajorVersion().MaxTableFormat()
+	}
+	e.writerOpts = d.opts.MakeWriterOptions(numLevels-1, e.opts.TableFormat)
+	// The sstables are read by other DBs, which may be at older format major
+	// versions, run older versions of Pebble, or not share the key manager,
+	// prefix extractor or TTL of this DB. They're written without the block
+	// extensions (XXH3 checksums, columnar data blocks and encryption) and
+	// without the options specific to this DB.
+	e.writerOpts.Checksum = sstable.ChecksumTypeCRC32c
+	e.writerOpts.ColumnarSchema = nil
+	e.writerOpts.KeyManager = nil
+	e.writerOpts.PrefixExtractor = nil
+	e.writerOpts.BlockPropertyCollectors = d.opts.BlockPropertyCollectors
+	if e.opts.BytesPerSec > 0 {
+		e.limiter = rate.NewLimiter(float64(e.opts.BytesPerSec), float64(exportChunkSize))
+	}
+	if err := e.opts.FS.MkdirAll(e.opts.Dir, 0755); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			err = firstError(err, e.abort())
+		}
+	}()
+	if err := e.export(start, end); err != nil {
+		return nil, err
+	}
+	return e.tables, nil
+}
+
+// exporter writes the sstables of an export.
+type exporter struct {
+	d          *DB
+	ctx        context.Context
+	opts       ExportOptions
+	writerOpts sstable.WriterOptions
+	limiter    *rate.Limiter
+	tables     []ExportedTable
+	// w is the writer of the sstable being written, which is the last of
+	// tables.
+	w *sstable.Writer
+	// rangeKey is the range key overlapping the current key, which is
+	// written once it ends or the sstable is finished.
+	rangeKey *exportRangeKey
+	// pending is the number of bytes exported since the last check of the
+	// context and wait on the rate limiter.
+	pending int
+}
+
+type exportRangeKey struct {
+	start, end []byte
+	keys       []RangeKeyData
+}
+
+func (e *exporter) export(start, end []byte) error {
+	d := e.d
+	iterOpts := &IterOptions{LowerBound: start, UpperBound: end}
+	if e.opts.IncludeRangeKeys {
+		iterOpts.KeyTypes = IterKeyTypePointsAndRanges
+	}
+	iter, err := d.NewIterWithContext(e.ctx, iterOpts)
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	var prefix, historyKey []byte
+	var havePrefix bool
+	split := d.opts.Comparer.Split
+	for valid := iter.First(); valid; valid = iter.Next() {
+		key := iter.Key()
+		hasPoint, hasRange := iter.HasPointAndRange()
+		if iter.RangeKeyChanged() {
+			if err := e.finishRangeKey(nil); err != nil {
+				return err
+			}
+		}
+		if hasPoint {
+			n := len(key)
+			if split != nil {
+				n = split(key)
+			}
+			newest := !havePrefix || !d.equal(key[:n], prefix)
+			if newest && e.w != nil && int64(e.w.EstimatedSize()) >= e.opts.TargetFileSize {
+				// Start a new sstable, at a key that isn't a version of the
+				// keys of the previous one.
+				if err := e.finishTable(key); err != nil {
+					return err
+				}
+			}
+			if newest {
+				prefix = append(prefix[:0], key[:n]...)
+				havePrefix = true
+				if e.opts.HistorySuffix != nil {
+					historyKey = append(append(historyKey[:0], prefix...), e.opts.HistorySuffix...)
+				}
+			}
+			hasPoint = newest || (e.opts.IncludeHistory &&
+				(historyKey == nil || d.cmp(key, historyKey) <= 0))
+		}
+		if iter.RangeKeyChanged() && hasRange {
+			rangeStart, rangeEnd := iter.RangeBounds()
+			rk := &exportRangeKey{
+				start: append([]byte(nil), rangeStart...),
+				end:   append([]byte(nil), rangeEnd...),
+			}
+			for _, k := range iter.RangeKeys() {
+				rk.keys = append(rk.keys, RangeKeyData{
+					Suffix: append([]byte(nil), k.Suffix...),
+					Value:  append([]byte(nil), k.Value...),
+				})
+			}
+			e.rangeKey = rk
+		}
+		if !hasPoint && e.rangeKey == nil {
+			continue
+		}
+		if e.w == nil {
+			if err := e.newTable(); err != nil {
+				return err
+			}
+		}
+		if !hasPoint {
+			continue
+		}
+		value, err := iter.ValueAndErr()
+		if err != nil {
+			return err
+		}
+		if err := e.w.Set(key, value); err != nil {
+			return err
+		}
+		e.tables[len(e.tables)-1].Keys++
+		if err := e.pace(len(key) + len(value)); err != nil {
+			return err
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	if err := e.finishRangeKey(nil); err != nil {
+		return err
+	}
+	if e.w != nil {
+		return e.finishTable(nil)
+	}
+	return nil
+}
+
+// pace accounts for n exported bytes, checking the context and waiting on the
+// rate limiter once enough bytes are exported.
+func (e *exporter) pace(n int) error {
+	e.pending += n
+	if e.pending < exportChunkSize {
+		return nil
+	}
+	if e.limiter != nil {
+		e.limiter.Wait(float64(e.pending))
+	}
+	e.pending = 0
+	return e.ctx.Err()
+}
+
+func (e *exporter) newTable() error {
+	if err := e.ctx.Err(); err != nil {
+		return err
+	}
+	path := e.opts.FS.PathJoin(e.opts.Dir, fmt.Sprintf("export-%06d.sst", len(e.tables)+1))
+	f, err := e.opts.FS.Create(path)
+	if err != nil {
+		return err
+	}
+	e.tables = append(e.tables, ExportedTable{Path: path})
+	e.w = sstable.NewWriter(objstorageprovider.NewFileWritable(f), e.writerOpts)
+	return nil
+}
+
+// finishRangeKey writes the current range key, truncated at end if it's set.
+// The rest of the range key remains current.
+func (e *exporter) finishRangeKey(end []byte) error {
+	rk := e.rangeKey
+	if rk == nil {
+		return nil
+	}
+	rkEnd := rk.end
+	if end != nil && e.d.cmp(end, rkEnd) < 0 {
+		rkEnd = end
+	}
+	if e.d.cmp(rk.start, rkEnd) < 0 {
+		for _, k := range rk.keys {
+			if err := e.w.RangeKeySet(rk.start, rkEnd, k.Suffix, k.Value); err != nil {
+				return err
+			}
+		}
+	}
+	if end == nil || e.d.cmp(end, rk.end) >= 0 {
+		e.rangeKey = nil
+	} else {
+		rk.start = append(rk.start[:0], end...)
+	}
+	return nil
+}
+
+// finishTable finishes the current sstable. If next is set, it's the first
+// key of the next sstable, at which the current range key is truncated.
+func (e *exporter) finishTable(next []byte) error {
+	if next != nil {
+		if err := e.finishRangeKey(next); err != nil {
+			return err
+		}
+	}
+	w := e.w
+	e.w = nil
+	if err := w.Close(); err != nil {
+		return err
+	}
+	t := &e.tables[len(e.tables)-1]
+	meta, err := w.Metadata()
+	if err != nil {
+		return err
+	}
+	t.Size = int64(meta.Size)
+	return nil
+}
+
+// abort removes the sstables of a failed export.
+func (e *exporter) abort() error {
+	if e.w != nil {
+		// The sstable is being removed, so an error closing it is irrelevant.
+		_ = e.w.Close()
+		e.w = nil
+	}
+	var err error
+	for _, t := range e.tables {
+		if rmErr := e.opts.FS.Remove(t.Path); rmErr != nil && !oserror.IsNotExist(rmErr) {
+			err = firstError(err, rmErr)
+		}
+	}
+	e.tables = nil
+	return err
+}
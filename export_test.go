@@ -0,0 +1,189 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExport(t *testing.T) {
+	fs := vfs.NewMem()
+	newOpts := func() *Options {
+		return &Options{
+			FS:                 fs,
+			Comparer:           testkeys.Comparer,
+			FormatMajorVersion: internalFormatNewest,
+		}
+	}
+	d, err := Open("src", newOpts())
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	for i := 0; i < 20; i++ {
+		for ts := 1; ts <= 5; ts++ {
+			key := fmt.Sprintf("k%02d@%d", i, ts)
+			require.NoError(t, d.Set([]byte(key), []byte(key), nil))
+		}
+	}
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Delete([]byte("k03@5"), nil))
+	require.NoError(t, d.RangeKeySet([]byte("k05"), []byte("k15"), []byte("@7"), []byte("r1"), nil))
+	require.NoError(t, d.RangeKeySet([]byte("k10"), []byte("k12"), []byte("@8"), []byte("r2"), nil))
+
+	// contents returns the point keys and range keys of a DB in [k02, k18).
+	contents := func(d *DB) string {
+		iter, _ := d.NewIter(&IterOptions{
+			LowerBound: []byte("k02"),
+			UpperBound: []byte("k18"),
+			KeyTypes:   IterKeyTypePointsAndRanges,
+		})
+		var b strings.Builder
+		for valid := iter.First(); valid; valid = iter.Next() {
+			if hasPoint, _ := iter.HasPointAndRange(); hasPoint {
+				fmt.Fprintf(&b, "%s ", iter.Key())
+			}
+			if iter.RangeKeyChanged() {
+				start, end := iter.RangeBounds()
+				fmt.Fprintf(&b, "[%s-%s)%v ", start, end, iter.RangeKeys())
+			}
+		}
+		require.NoError(t, iter.Close())
+		return b.String()
+	}
+	// exportAndIngest exports [k02, k18) from d and ingests the exported
+	// sstables into a new DB, whose contents it returns.
+	n := 0
+	exportAndIngest := func(opts ExportOptions) ([]ExportedTable, string) {
+		n++
+		opts.Dir = fmt.Sprintf("export%d", n)
+		tables, err := d.Export(context.Background(), []byte("k02"), []byte("k18"), opts)
+		require.NoError(t, err)
+		dst, err := Open(fmt.Sprintf("dst%d", n), newOpts())
+		require.NoError(t, err)
+		defer func() { require.NoError(t, dst.Close()) }()
+		var paths []string
+		for _, t := range tables {
+			paths = append(paths, t.Path)
+		}
+		require.NoError(t, dst.Ingest(paths))
+		return tables, contents(dst)
+	}
+
+	// By default, only the newest versions are exported, into a single
+	// sstable.
+	tables, got := exportAndIngest(ExportOptions{})
+	require.Len(t, tables, 1)
+	require.Equal(t, 16, tables[0].Keys)
+	require.True(t, strings.HasPrefix(got, "k02@5 k03@4 k04@5 "), got)
+
+	// All the versions, split across sstables without splitting the versions
+	// of a key.
+	tables, got = exportAndIngest(ExportOptions{IncludeHistory: true, TargetFileSize: 1})
+	require.Len(t, tables, 16)
+	for _, tbl := range tables {
+		require.Contains(t, []int{4, 5}, tbl.Keys)
+		require.Greater(t, tbl.Size, int64(0))
+	}
+	var want strings.Builder
+	iter, _ := d.NewIter(&IterOptions{LowerBound: []byte("k02"), UpperBound: []byte("k18")})
+	for valid := iter.First(); valid; valid = iter.Next() {
+		fmt.Fprintf(&want, "%s ", iter.Key())
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, want.String(), got)
+
+	// A window of versions.
+	tables, got = exportAndIngest(ExportOptions{IncludeHistory: true, HistorySuffix: []byte("@4")})
+	require.Equal(t, 16*2-1, tables[0].Keys)
+	require.True(t, strings.HasPrefix(got, "k02@5 k02@4 k03@4 k04@5 k04@4 "), got)
+
+	// Range keys, split across sstables.
+	_, got = exportAndIngest(ExportOptions{IncludeHistory: true, IncludeRangeKeys: true, TargetFileSize: 1})
+	require.Equal(t, contents(d), got)
+
+	// A canceled export leaves no sstables behind.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = d.Export(ctx, nil, nil, ExportOptions{Dir: "canceled"})
+	require.ErrorIs(t, err, context.Canceled)
+	ls, err := fs.List("canceled")
+	require.NoError(t, err)
+	require.Empty(t, ls)
+
+	_, err = d.Export(context.Background(), []byte("b"), []byte("a"), ExportOptions{Dir: "invalid"})
+	require.Error(t, err)
+}
+
+// TestExportOlderFormatMajorVersion verifies that the sstables exported by a
+// DB using block extensions can be ingested by a DB at an older format major
+// version.
+func TestExportOlderFormatMajorVersion(t *testing.T) {
+	fs := vfs.NewMem()
+	srcOpts := &Options{
+		FS:                 fs,
+		FormatMajorVersion: internalFormatNewest,
+		BlockChecksum:      ChecksumTypeXXH3,
+		PrefixExtractor: &PrefixExtractor{
+			Name: "test-slash",
+			Split: func(k []byte) int {
+				if i := bytes.IndexByte(k, '/'); i >= 0 {
+					return i + 1
+				}
+				return len(k)
+			},
+		},
+		TTL: TTLOptions{
+			TimestampExtractor: ttlTestExtractor,
+			TTL:                time.Hour,
+		},
+	}
+	srcOpts.Experimental.ColumnarSchema = &ColumnarSchema{
+		Name:    "test",
+		Columns: []sstable.ColumnSpec{{Width: 2, Encoding: sstable.ColumnEncodingDictionary}},
+	}
+	src, err := Open("src", srcOpts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, src.Close()) }()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, src.Set([]byte(fmt.Sprintf("k%02d", i)), []byte("ab"), nil))
+	}
+	require.NoError(t, src.Flush())
+
+	const fmv = FormatPrePebblev1MarkedCompacted
+	tables, err := src.Export(context.Background(), nil, nil, ExportOptions{
+		Dir:         "export",
+		TableFormat: fmv.MaxTableFormat(),
+	})
+	require.NoError(t, err)
+	require.Len(t, tables, 1)
+
+	f, err := fs.Open(tables[0].Path)
+	require.NoError(t, err)
+	readable, err := sstable.NewSimpleReadable(f)
+	require.NoError(t, err)
+	r, err := sstable.NewReader(readable, sstable.ReaderOptions{})
+	require.NoError(t, err)
+	require.Equal(t, sstable.ChecksumTypeCRC32c, r.ChecksumType())
+	require.False(t, r.Properties.ColumnarDataBlocks)
+	require.NotEqual(t, srcOpts.PrefixExtractor.Name, r.Properties.PrefixExtractorName)
+	require.NotContains(t, r.Properties.UserProperties, ttlTimestampPropertyName)
+	require.NoError(t, r.Close())
+
+	dst, err := Open("dst", &Options{FS: fs, FormatMajorVersion: fmv})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, dst.Close()) }()
+	require.NoError(t, dst.Ingest([]string{tables[0].Path}))
+	verifyGet(t, dst, []byte("k05"), []byte("ab"))
+}
@@ -217,7 +217,8 @@ func createExternalPointIter(ctx context.Context, it *Iterator) (internalIterato
 			pointIter, err = r.NewIterWithBlockPropertyFiltersAndContextEtc(
 				ctx, it.opts.LowerBound, it.opts.UpperBound, nil, /* BlockPropertiesFilterer */
 				false /* hideObsoletePoints */, false, /* useFilterBlock */
-				&it.stats.InternalStats, sstable.TrivialReaderProvider{Reader: r})
+				&it.stats.InternalStats, nil /* cacheStats */, sstable.TrivialReaderProvider{Reader: r},
+				nil /* bufferPool */)
 			if err != nil {
 				return nil, err
 			}
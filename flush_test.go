@@ -6,6 +6,8 @@ package pebble
 
 import (
 	"fmt"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -115,3 +117,165 @@ func TestFlushEmptyKey(t *testing.T) {
 	require.NoError(t, closer.Close())
 	require.NoError(t, d.Close())
 }
+
+func TestFlushRange(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+	l0Files := func() int64 {
+		return d.Metrics().Levels[0].NumFiles
+	}
+
+	require.Error(t, d.FlushRange([]byte("b"), []byte("a")))
+
+	// The memtable does not overlap the range, and is not flushed.
+	require.NoError(t, d.Set([]byte("b"), nil, nil))
+	require.NoError(t, d.FlushRange([]byte("m"), []byte("z")))
+	require.NoError(t, d.FlushRange([]byte("a"), []byte("b")))
+	require.Equal(t, int64(0), l0Files())
+
+	// The memtable overlaps the range, and is flushed.
+	require.NoError(t, d.FlushRange([]byte("a"), []byte("c")))
+	require.Equal(t, int64(1), l0Files())
+
+	// Range deletions overlapping the range cause a flush.
+	require.NoError(t, d.DeleteRange([]byte("c"), []byte("e"), nil))
+	require.NoError(t, d.FlushRange([]byte("a"), []byte("c")))
+	require.Equal(t, int64(1), l0Files())
+	require.NoError(t, d.FlushRange([]byte("d"), []byte("f")))
+	require.Equal(t, int64(2), l0Files())
+}
+
+func TestFlushRangeKeepsKeysOutsideRange(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{FS: mem, FormatMajorVersion: internalFormatNewest}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Set([]byte("c"), []byte("1"), nil))
+	require.NoError(t, d.Set([]byte("x"), []byte("1"), nil))
+	require.NoError(t, d.DeleteRange([]byte("b"), []byte("k"), nil))
+	require.NoError(t, d.Set([]byte("d"), []byte("1"), nil))
+	require.NoError(t, d.Merge([]byte("d"), []byte("2"), nil))
+	require.NoError(t, d.Set([]byte("j"), []byte("1"), nil))
+
+	require.NoError(t, d.FlushRange([]byte("c"), []byte("e")))
+
+	// Only the keys within the range were flushed.
+	tables, err := d.SSTables()
+	require.NoError(t, err)
+	require.Len(t, tables[0], 1)
+	require.Equal(t, "c", string(tables[0][0].Smallest.UserKey))
+	require.Equal(t, "e", string(tables[0][0].Largest.UserKey))
+
+	// The keys outside of the range are still in the memtables.
+	memtableKeys := func() string {
+		rs := d.loadReadState()
+		defer rs.unref()
+		var keys []string
+		for _, m := range rs.memtables {
+			iter := flushableView(d.cmp, rs.current, m).newIter(nil)
+			for k, _ := iter.First(); k != nil; k, _ = iter.Next() {
+				keys = append(keys, string(k.UserKey))
+			}
+			require.NoError(t, iter.Close())
+		}
+		return strings.Join(keys, " ")
+	}
+	require.Equal(t, "a j x", memtableKeys())
+
+	check := func() {
+		t.Helper()
+		for _, kv := range []struct{ key, value string }{
+			{"a", "1"}, {"c", ""}, {"d", "12"}, {"j", "1"}, {"x", "1"},
+		} {
+			v, closer, err := d.Get([]byte(kv.key))
+			if kv.value == "" {
+				require.ErrorIs(t, err, ErrNotFound, kv.key)
+				continue
+			}
+			require.NoError(t, err)
+			require.Equal(t, kv.value, string(v), kv.key)
+			require.NoError(t, closer.Close())
+		}
+	}
+	check()
+
+	// The keys remaining in the memtables are flushed without the keys already
+	// flushed, which would otherwise be merged twice.
+	require.NoError(t, d.Flush())
+	require.Equal(t, "", memtableKeys())
+	check()
+
+	require.NoError(t, d.Close())
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	check()
+	require.NoError(t, d.Close())
+
+	// The keys flushed out of memtables which weren't flushed aren't replayed
+	// from the WAL on reopen.
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	require.NoError(t, d.Set([]byte("d"), []byte("1"), nil))
+	require.NoError(t, d.Merge([]byte("d"), []byte("2"), nil))
+	require.NoError(t, d.Set([]byte("y"), []byte("1"), nil))
+	require.NoError(t, d.FlushRange([]byte("c"), []byte("e")))
+	require.Equal(t, "y", memtableKeys())
+	require.NoError(t, d.Close())
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	check()
+	v, closer, err := d.Get([]byte("y"))
+	require.NoError(t, err)
+	require.Equal(t, "1", string(v))
+	require.NoError(t, closer.Close())
+	require.NoError(t, d.Close())
+}
+
+func TestFlushWithInfo(t *testing.T) {
+	var mu sync.Mutex
+	var listenerInfos []FlushInfo
+	d, err := Open("", &Options{
+		FS: vfs.NewMem(),
+		EventListener: &EventListener{
+			FlushEnd: func(info FlushInfo) {
+				mu.Lock()
+				defer mu.Unlock()
+				listenerInfos = append(listenerInfos, info)
+			},
+		},
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Set([]byte("b"), []byte("2"), nil))
+	infos, err := d.FlushWithInfo()
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	info := infos[0]
+	require.Len(t, info.Output, 1)
+	require.Len(t, info.OutputProperties, 1)
+	require.Equal(t, "a", string(info.Output[0].Smallest.UserKey))
+	require.Equal(t, "b", string(info.Output[0].Largest.UserKey))
+	require.Equal(t, uint64(2), info.OutputProperties[0].NumEntries)
+
+	// The flushed table is the one in the LSM.
+	tables, err := d.SSTables()
+	require.NoError(t, err)
+	require.Len(t, tables[0], 1)
+	require.Equal(t, info.Output[0].FileNum, tables[0][0].FileNum)
+
+	// The event listener observes the same flush.
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, listenerInfos, 1)
+	require.Equal(t, info.JobID, listenerInfos[0].JobID)
+	require.Equal(t, info.OutputProperties, listenerInfos[0].OutputProperties)
+}
@@ -37,6 +37,9 @@ type flushableEntry struct {
 	flushable
 	// Channel which is closed when the flushable has been flushed.
 	flushed chan struct{}
+	// flushInfo describes the flush that flushed the flushable. It is set
+	// before flushed is closed, and is immutable afterwards.
+	flushInfo *FlushInfo
 	// flushForced indicates whether a flush was forced on this memtable (either
 	// manual, or due to ingestion). Protected by DB.mu.
 	flushForced bool
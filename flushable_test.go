@@ -58,7 +58,7 @@ func TestIngestedSSTFlushableAPI(t *testing.T) {
 
 		// We can reuse the ingestLoad function for this test even if we're
 		// not actually ingesting a file.
-		lr, err := ingestLoad(d.opts, d.FormatMajorVersion(), paths, nil, nil, d.cacheID, pendingOutputs, d.objProvider, jobID)
+		lr, err := ingestLoad(d.opts, d.FormatMajorVersion(), paths, nil, nil, nil, d.cacheID, pendingOutputs, d.objProvider, jobID, IngestOptions{})
 		meta := lr.localMeta
 		if err != nil {
 			panic(err)
@@ -0,0 +1,260 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/keyspan"
+)
+
+// hiddenRange is a range [start, end) of user keys hidden from a flushable. A
+// nil start or end leaves the range unbounded.
+type hiddenRange struct {
+	start, end []byte
+}
+
+// flushableView returns the flushable of mem as read through the version v:
+// the keys of the spans flushed out of mem by DB.FlushRange (see
+// manifest.FlushedSpan) are hidden, as they're read from the sstables of v
+// instead.
+//
+// A flushable either holds no key below the sequence number of a flushed span
+// or only keys below it: a memtable is rotated before its keys are flushed
+// out, and a memtable replayed from the WAL holds the batches of a single WAL
+// file. Since the logSeqNum of a flushable is no greater than any of its
+// keys, it tells which spans were flushed out of it.
+func flushableView(cmp Compare, v *version, mem *flushableEntry) flushable {
+	var hidden []hiddenRange
+	for _, s := range v.FlushedSpans {
+		if mem.logSeqNum < s.SeqNum {
+			hidden = append(hidden, hiddenRange{start: s.Start, end: s.End})
+		}
+	}
+	if len(hidden) == 0 {
+		return mem.flushable
+	}
+	return newFlushedSpanView(cmp, mem.flushable, hidden)
+}
+
+// flushRangeView returns the flushable of mem restricted to the keys within
+// [start, end).
+func flushRangeView(cmp Compare, mem *flushableEntry, start, end []byte) *flushableEntry {
+	return &flushableEntry{
+		flushable: newFlushedSpanView(cmp, mem.flushable, []hiddenRange{
+			{end: start},
+			{start: end},
+		}),
+		logNum:    mem.logNum,
+		logSeqNum: mem.logSeqNum,
+	}
+}
+
+// flushedSpanView is a flushable with ranges of keys hidden.
+type flushedSpanView struct {
+	flushable
+	cmp Compare
+	// hidden is sorted and its ranges don't overlap.
+	hidden []hiddenRange
+}
+
+var _ flushable = (*flushedSpanView)(nil)
+
+func newFlushedSpanView(cmp Compare, f flushable, hidden []hiddenRange) *flushedSpanView {
+	sort.Slice(hidden, func(i, j int) bool {
+		if hidden[i].start == nil || hidden[j].start == nil {
+			return hidden[i].start == nil && hidden[j].start != nil
+		}
+		return cmp(hidden[i].start, hidden[j].start) < 0
+	})
+	merged := hidden[:1]
+	for _, r := range hidden[1:] {
+		last := &merged[len(merged)-1]
+		if last.end == nil {
+			break
+		}
+		if r.start != nil && cmp(r.start, last.end) > 0 {
+			merged = append(merged, r)
+			continue
+		}
+		if r.end == nil || cmp(r.end, last.end) > 0 {
+			last.end = r.end
+		}
+	}
+	return &flushedSpanView{flushable: f, cmp: cmp, hidden: merged}
+}
+
+// find returns the hidden range containing key, if any.
+func (f *flushedSpanView) find(key []byte) (hiddenRange, bool) {
+	i := sort.Search(len(f.hidden), func(i int) bool {
+		return f.hidden[i].end == nil || f.cmp(f.hidden[i].end, key) > 0
+	})
+	if i < len(f.hidden) && (f.hidden[i].start == nil || f.cmp(f.hidden[i].start, key) <= 0) {
+		return f.hidden[i], true
+	}
+	return hiddenRange{}, false
+}
+
+func (f *flushedSpanView) newIter(o *IterOptions) internalIterator {
+	return &flushedSpanIter{iter: f.flushable.newIter(o), view: f, canSeek: true}
+}
+
+func (f *flushedSpanView) newFlushIter(o *IterOptions, bytesFlushed *uint64) internalIterator {
+	return &flushedSpanIter{iter: f.flushable.newFlushIter(o, bytesFlushed), view: f}
+}
+
+func (f *flushedSpanView) newRangeDelIter(o *IterOptions) keyspan.FragmentIterator {
+	return f.hideSpans(f.flushable.newRangeDelIter(o))
+}
+
+func (f *flushedSpanView) newRangeKeyIter(o *IterOptions) keyspan.FragmentIterator {
+	return f.hideSpans(f.flushable.newRangeKeyIter(o))
+}
+
+// hideSpans returns an iterator over the fragments of iter with the hidden
+// ranges cut out of them, or nil if no fragment remains. It closes iter.
+func (f *flushedSpanView) hideSpans(iter keyspan.FragmentIterator) keyspan.FragmentIterator {
+	if iter == nil {
+		return nil
+	}
+	var spans []keyspan.Span
+	emit := func(s *keyspan.Span, start, end []byte) {
+		c := s.ShallowClone()
+		c.Start, c.End = start, end
+		spans = append(spans, c)
+	}
+	for s := iter.First(); s != nil; s = iter.Next() {
+		start, done := s.Start, false
+		for _, r := range f.hidden {
+			if r.end != nil && f.cmp(r.end, start) <= 0 {
+				continue
+			}
+			if r.start != nil && f.cmp(r.start, s.End) >= 0 {
+				break
+			}
+			if r.start != nil && f.cmp(r.start, start) > 0 {
+				emit(s, start, r.start)
+			}
+			if r.end == nil || f.cmp(r.end, s.End) >= 0 {
+				done = true
+				break
+			}
+			start = r.end
+		}
+		if !done {
+			emit(s, start, s.End)
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return newErrorKeyspanIter(err)
+	}
+	if len(spans) == 0 {
+		return nil
+	}
+	return keyspan.NewIter(f.cmp, spans)
+}
+
+// flushedSpanIter wraps the point iterator of a flushable, skipping the keys
+// within the ranges hidden by a flushedSpanView.
+type flushedSpanIter struct {
+	iter internalIterator
+	view *flushedSpanView
+	// canSeek is set if iter supports seeks and reverse iteration, which skip
+	// the hidden ranges at once. Otherwise, iter is a flush iterator which
+	// only supports forward iteration.
+	canSeek bool
+}
+
+var _ internalIterator = (*flushedSpanIter)(nil)
+
+func (i *flushedSpanIter) skipForward(
+	key *InternalKey, value base.LazyValue,
+) (*InternalKey, base.LazyValue) {
+	for key != nil {
+		r, ok := i.view.find(key.UserKey)
+		if !ok {
+			return key, value
+		}
+		switch {
+		case !i.canSeek:
+			key, value = i.iter.Next()
+		case r.end == nil:
+			return nil, base.LazyValue{}
+		default:
+			key, value = i.iter.SeekGE(r.end, base.SeekGEFlagsNone)
+		}
+	}
+	return key, value
+}
+
+func (i *flushedSpanIter) skipBackward(
+	key *InternalKey, value base.LazyValue,
+) (*InternalKey, base.LazyValue) {
+	for key != nil {
+		r, ok := i.view.find(key.UserKey)
+		if !ok {
+			return key, value
+		}
+		if r.start == nil {
+			return nil, base.LazyValue{}
+		}
+		key, value = i.iter.SeekLT(r.start, base.SeekLTFlagsNone)
+	}
+	return key, value
+}
+
+func (i *flushedSpanIter) SeekGE(key []byte, flags base.SeekGEFlags) (*InternalKey, base.LazyValue) {
+	// The wrapped iterator may be positioned past the keys returned, so it
+	// can't seek using next.
+	return i.skipForward(i.iter.SeekGE(key, flags.DisableTrySeekUsingNext()))
+}
+
+func (i *flushedSpanIter) SeekPrefixGE(
+	prefix, key []byte, flags base.SeekGEFlags,
+) (*InternalKey, base.LazyValue) {
+	return i.skipForward(i.iter.SeekPrefixGE(prefix, key, flags.DisableTrySeekUsingNext()))
+}
+
+func (i *flushedSpanIter) SeekLT(key []byte, flags base.SeekLTFlags) (*InternalKey, base.LazyValue) {
+	return i.skipBackward(i.iter.SeekLT(key, flags))
+}
+
+func (i *flushedSpanIter) First() (*InternalKey, base.LazyValue) {
+	return i.skipForward(i.iter.First())
+}
+
+func (i *flushedSpanIter) Last() (*InternalKey, base.LazyValue) {
+	return i.skipBackward(i.iter.Last())
+}
+
+func (i *flushedSpanIter) Next() (*InternalKey, base.LazyValue) {
+	return i.skipForward(i.iter.Next())
+}
+
+func (i *flushedSpanIter) NextPrefix(succKey []byte) (*InternalKey, base.LazyValue) {
+	return i.skipForward(i.iter.NextPrefix(succKey))
+}
+
+func (i *flushedSpanIter) Prev() (*InternalKey, base.LazyValue) {
+	return i.skipBackward(i.iter.Prev())
+}
+
+func (i *flushedSpanIter) Error() error {
+	return i.iter.Error()
+}
+
+func (i *flushedSpanIter) Close() error {
+	return i.iter.Close()
+}
+
+func (i *flushedSpanIter) SetBounds(lower, upper []byte) {
+	i.iter.SetBounds(lower, upper)
+}
+
+func (i *flushedSpanIter) String() string {
+	return fmt.Sprintf("flushed-span(%s)", i.iter)
+}
@@ -166,6 +166,44 @@ const (
 	// a format major version.
 	ExperimentalFormatVirtualSSTables
 
+	// ExperimentalFormatBlobFiles is a format major version that adds support
+	// for separating large values into blob files, which are referenced from
+	// sstables by blob handles (see Options.Experimental.ValueSeparation). Blob
+	// files are tracked through new, backward-incompatible records and fields
+	// in the Manifest, and therefore require a format major version.
+	ExperimentalFormatBlobFiles
+
+	// ExperimentalFormatPrefixReplacement is a format major version that adds
+	// support for ingesting external sstables whose keys are exposed with a
+	// rewritten prefix (see ExternalFile.SyntheticPrefix). The prefix
+	// replacement of a virtual sstable is persisted through a new,
+	// backward-incompatible field in the Manifest, and therefore requires a
+	// format major version.
+	ExperimentalFormatPrefixReplacement
+
+	// ExperimentalFormatZstdDictionaries is a format major version that adds
+	// support for sstables whose data blocks are compressed with a zstd
+	// dictionary stored in the sstable (see LevelOptions.ZstdDictionarySize).
+	// Such sstables cannot be read by versions of Pebble predating dictionary
+	// compression, and therefore require a format major version.
+	ExperimentalFormatZstdDictionaries
+
+	// ExperimentalFormatBlockExtensions is a format major version that adds
+	// support for sstables whose blocks are checksummed with XXH3 (see
+	// Options.BlockChecksum), whose data blocks use the columnar layout (see
+	// Options.Experimental.ColumnarSchema), or whose blocks are encrypted (see
+	// Options.TableKeyManager). Such sstables cannot be read by versions of
+	// Pebble predating these extensions, and therefore require a format major
+	// version.
+	ExperimentalFormatBlockExtensions
+
+	// ExperimentalFormatFlushedSpans is a format major version that adds
+	// support for flushing the keys of a span out of the memtables while
+	// leaving their other keys in memory (see DB.FlushRange). The flushed
+	// spans are persisted through a new, backward-incompatible record in the
+	// Manifest, and therefore require a format major version.
+	ExperimentalFormatFlushedSpans
+
 	// internalFormatNewest holds the newest format major version, including
 	// experimental ones excluded from the exported FormatNewest constant until
 	// they've stabilized. Used in tests.
@@ -191,7 +229,10 @@ func (v FormatMajorVersion) MaxTableFormat() sstable.TableFormat {
 		return sstable.TableFormatPebblev2
 	case FormatSSTableValueBlocks, FormatFlushableIngest, FormatPrePebblev1MarkedCompacted:
 		return sstable.TableFormatPebblev3
-	case ExperimentalFormatDeleteSizedAndObsolete, ExperimentalFormatVirtualSSTables:
+	case ExperimentalFormatDeleteSizedAndObsolete, ExperimentalFormatVirtualSSTables,
+		ExperimentalFormatBlobFiles, ExperimentalFormatPrefixReplacement,
+		ExperimentalFormatZstdDictionaries, ExperimentalFormatBlockExtensions,
+		ExperimentalFormatFlushedSpans:
 		return sstable.TableFormatPebblev4
 	default:
 		panic(fmt.Sprintf("pebble: unsupported format major version: %s", v))
@@ -210,7 +251,10 @@ func (v FormatMajorVersion) MinTableFormat() sstable.TableFormat {
 	case FormatMinTableFormatPebblev1, FormatPrePebblev1Marked,
 		FormatUnusedPrePebblev1MarkedCompacted, FormatSSTableValueBlocks,
 		FormatFlushableIngest, FormatPrePebblev1MarkedCompacted,
-		ExperimentalFormatDeleteSizedAndObsolete, ExperimentalFormatVirtualSSTables:
+		ExperimentalFormatDeleteSizedAndObsolete, ExperimentalFormatVirtualSSTables,
+		ExperimentalFormatBlobFiles, ExperimentalFormatPrefixReplacement,
+		ExperimentalFormatZstdDictionaries, ExperimentalFormatBlockExtensions,
+		ExperimentalFormatFlushedSpans:
 		return sstable.TableFormatPebblev1
 	default:
 		panic(fmt.Sprintf("pebble: unsupported format major version: %s", v))
@@ -349,6 +393,21 @@ var formatMajorVersionMigrations = map[FormatMajorVersion]func(*DB) error{
 	ExperimentalFormatVirtualSSTables: func(d *DB) error {
 		return d.finalizeFormatVersUpgrade(ExperimentalFormatVirtualSSTables)
 	},
+	ExperimentalFormatBlobFiles: func(d *DB) error {
+		return d.finalizeFormatVersUpgrade(ExperimentalFormatBlobFiles)
+	},
+	ExperimentalFormatPrefixReplacement: func(d *DB) error {
+		return d.finalizeFormatVersUpgrade(ExperimentalFormatPrefixReplacement)
+	},
+	ExperimentalFormatZstdDictionaries: func(d *DB) error {
+		return d.finalizeFormatVersUpgrade(ExperimentalFormatZstdDictionaries)
+	},
+	ExperimentalFormatBlockExtensions: func(d *DB) error {
+		return d.finalizeFormatVersUpgrade(ExperimentalFormatBlockExtensions)
+	},
+	ExperimentalFormatFlushedSpans: func(d *DB) error {
+		return d.finalizeFormatVersUpgrade(ExperimentalFormatFlushedSpans)
+	},
 }
 
 const formatVersionMarkerName = `format-version`
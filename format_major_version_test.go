@@ -66,6 +66,16 @@ func TestRatchetFormat(t *testing.T) {
 	require.Equal(t, ExperimentalFormatDeleteSizedAndObsolete, d.FormatMajorVersion())
 	require.NoError(t, d.RatchetFormatMajorVersion(ExperimentalFormatVirtualSSTables))
 	require.Equal(t, ExperimentalFormatVirtualSSTables, d.FormatMajorVersion())
+	require.NoError(t, d.RatchetFormatMajorVersion(ExperimentalFormatBlobFiles))
+	require.Equal(t, ExperimentalFormatBlobFiles, d.FormatMajorVersion())
+	require.NoError(t, d.RatchetFormatMajorVersion(ExperimentalFormatPrefixReplacement))
+	require.Equal(t, ExperimentalFormatPrefixReplacement, d.FormatMajorVersion())
+	require.NoError(t, d.RatchetFormatMajorVersion(ExperimentalFormatZstdDictionaries))
+	require.Equal(t, ExperimentalFormatZstdDictionaries, d.FormatMajorVersion())
+	require.NoError(t, d.RatchetFormatMajorVersion(ExperimentalFormatBlockExtensions))
+	require.Equal(t, ExperimentalFormatBlockExtensions, d.FormatMajorVersion())
+	require.NoError(t, d.RatchetFormatMajorVersion(ExperimentalFormatFlushedSpans))
+	require.Equal(t, ExperimentalFormatFlushedSpans, d.FormatMajorVersion())
 
 	require.NoError(t, d.Close())
 
@@ -231,6 +241,11 @@ func TestFormatMajorVersions_TableFormat(t *testing.T) {
 		FormatPrePebblev1MarkedCompacted:         {sstable.TableFormatPebblev1, sstable.TableFormatPebblev3},
 		ExperimentalFormatDeleteSizedAndObsolete: {sstable.TableFormatPebblev1, sstable.TableFormatPebblev4},
 		ExperimentalFormatVirtualSSTables:        {sstable.TableFormatPebblev1, sstable.TableFormatPebblev4},
+		ExperimentalFormatBlobFiles:              {sstable.TableFormatPebblev1, sstable.TableFormatPebblev4},
+		ExperimentalFormatPrefixReplacement:      {sstable.TableFormatPebblev1, sstable.TableFormatPebblev4},
+		ExperimentalFormatZstdDictionaries:       {sstable.TableFormatPebblev1, sstable.TableFormatPebblev4},
+		ExperimentalFormatBlockExtensions:        {sstable.TableFormatPebblev1, sstable.TableFormatPebblev4},
+		ExperimentalFormatFlushedSpans:           {sstable.TableFormatPebblev1, sstable.TableFormatPebblev4},
 	}
 
 	// Valid versions.
@@ -146,9 +146,16 @@ func (g *getIter) Next() (*InternalKey, base.LazyValue) {
 		// Create iterators from memtables from newest to oldest.
 		if n := len(g.mem); n > 0 {
 			m := g.mem[n-1]
-			g.iter = m.newIter(nil)
-			g.rangeDelIter = m.newRangeDelIter(nil)
+			f := flushableView(g.cmp, g.version, m)
+			g.iter = f.newIter(nil)
+			g.rangeDelIter = f.newRangeDelIter(nil)
 			g.mem = g.mem[:n-1]
+			if mem, ok := m.flushable.(*memTable); ok && !mem.mayContainPrefixOf(g.key) {
+				// The memtable's prefix index shows that it does not contain
+				// the key. Its range deletions are still consulted.
+				g.iterKey, g.iterValue = nil, base.LazyValue{}
+				continue
+			}
 			g.iterKey, g.iterValue = g.iter.SeekGE(g.key, base.SeekGEFlagsNone)
 			continue
 		}
@@ -5,6 +5,7 @@
 package pebble
 
 import (
+	"bytes"
 	"context"
 	"sort"
 	"time"
@@ -88,6 +89,99 @@ func ingestValidateKey(opts *Options, key *InternalKey) error {
 	return nil
 }
 
+// ingestVerify verifies the contents of an external sstable as requested by
+// ingestOpts. See IngestOptions.
+func ingestVerify(opts *Options, r *sstable.Reader, ingestOpts IngestOptions) error {
+	if ingestOpts.VerifyChecksums {
+		if err := r.ValidateBlockChecksums(); err != nil {
+			return err
+		}
+	}
+	if !ingestOpts.VerifyContents {
+		return nil
+	}
+	cmp, formatKey := opts.Comparer.Compare, opts.Comparer.FormatKey
+
+	// Read every point key and value, verifying that the keys are ordered and
+	// that they match the counts of the table's properties.
+	iter, err := r.NewIter(nil /* lower */, nil /* upper */)
+	if err != nil {
+		return err
+	}
+	var prev InternalKey
+	var buf []byte
+	var numEntries, numDeletions, numMergeOperands uint64
+	for key, lv := iter.First(); key != nil; key, lv = iter.Next() {
+		if err := ingestValidateKey(opts, key); err != nil {
+			_ = iter.Close()
+			return err
+		}
+		if numEntries > 0 && base.InternalCompare(cmp, prev, *key) >= 0 {
+			_ = iter.Close()
+			return base.CorruptionErrorf("pebble: external sstable has out of order keys %s and %s",
+				prev.Pretty(formatKey), key.Pretty(formatKey))
+		}
+		prev.CopyFrom(*key)
+		if buf, _, err = lv.Value(buf[:0]); err != nil {
+			_ = iter.Close()
+			return err
+		}
+		numEntries++
+		switch key.Kind() {
+		case InternalKeyKindDelete, InternalKeyKindSingleDelete, InternalKeyKindDeleteSized:
+			numDeletions++
+		case InternalKeyKindMerge:
+			numMergeOperands++
+		}
+	}
+	if err := firstError(iter.Error(), iter.Close()); err != nil {
+		return err
+	}
+	p := &r.Properties
+	if numEntries != p.NumEntries-p.NumRangeDeletions ||
+		numDeletions != p.NumPointDeletions() || numMergeOperands != p.NumMergeOperands {
+		return base.CorruptionErrorf(
+			"pebble: external sstable has %d point keys, %d deletions and %d merge operands, "+
+				"but its properties record %d, %d and %d",
+			numEntries, numDeletions, numMergeOperands,
+			p.NumEntries-p.NumRangeDeletions, p.NumPointDeletions(), p.NumMergeOperands)
+	}
+
+	// Verify that the range deletions and range keys are ordered,
+	// non-overlapping fragments.
+	for _, newIter := range []func() (keyspan.FragmentIterator, error){
+		r.NewRawRangeDelIter, r.NewRawRangeKeyIter,
+	} {
+		iter, err := newIter()
+		if err != nil {
+			return err
+		}
+		if iter == nil {
+			continue
+		}
+		var prevEnd []byte
+		for s := iter.First(); s != nil; s = iter.Next() {
+			if cmp(s.Start, s.End) >= 0 || (prevEnd != nil && cmp(prevEnd, s.Start) > 0) {
+				_ = iter.Close()
+				return base.CorruptionErrorf("pebble: external sstable has out of order span %s",
+					s.Pretty(formatKey))
+			}
+			for i := range s.Keys {
+				if s.Keys[i].SeqNum() != 0 {
+					_ = iter.Close()
+					return base.CorruptionErrorf("pebble: external sstable has non-zero seqnum: %s",
+						s.Pretty(formatKey))
+				}
+			}
+			prevEnd = append(prevEnd[:0], s.End...)
+		}
+		if err := firstError(iter.Error(), iter.Close()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // ingestSynthesizeShared constructs a fileMetadata for one shared sstable owned
 // or shared by another node.
 func ingestSynthesizeShared(
@@ -141,12 +235,32 @@ func ingestLoad1External(
 	if !e.HasRangeKey && !e.HasPointKey {
 		return nil, errors.New("pebble: cannot ingest external file with no point or range keys")
 	}
+	var prefixReplacement *manifest.PrefixReplacement
+	if e.ContentPrefix != nil || e.SyntheticPrefix != nil {
+		prefixReplacement = &manifest.PrefixReplacement{
+			ContentPrefix:   append([]byte(nil), e.ContentPrefix...),
+			SyntheticPrefix: append([]byte(nil), e.SyntheticPrefix...),
+		}
+		if err := prefixReplacement.Validate(); err != nil {
+			return nil, err
+		}
+		// The exclusive upper bound may be the end of the keys starting with
+		// the synthetic prefix, which, unlike the keys beyond it, is unchanged
+		// by a round trip through the keyspace of the sstable.
+		largest := prefixReplacement.ReplaceResult(nil, prefixReplacement.ReplaceArg(nil, e.LargestUserKey))
+		if !bytes.HasPrefix(e.SmallestUserKey, e.SyntheticPrefix) || !bytes.Equal(largest, e.LargestUserKey) {
+			return nil, errors.Errorf("pebble: external file bounds [%s, %s) aren't within the synthetic prefix %s",
+				opts.Comparer.FormatKey(e.SmallestUserKey), opts.Comparer.FormatKey(e.LargestUserKey),
+				opts.Comparer.FormatKey(e.SyntheticPrefix))
+		}
+	}
 	// Don't load table stats. Doing a round trip to shared storage, one SST
 	// at a time is not worth it as it slows down ingestion.
 	meta := &fileMetadata{}
 	meta.FileNum = fileNum.FileNum()
 	meta.CreationTime = time.Now().Unix()
 	meta.Virtual = true
+	meta.PrefixReplacement = prefixReplacement
 	meta.Size = e.Size
 	meta.InitProviderBacking(fileNum)
 
@@ -200,13 +314,16 @@ func ingestLoad1External(
 }
 
 // ingestLoad1 creates the FileMetadata for one file. This file will be owned
-// by this store.
+// by this store. If span is valid, the returned FileMetadata describes a
+// virtual sstable containing only the keys of the file within span.
 func ingestLoad1(
 	opts *Options,
 	fmv FormatMajorVersion,
 	readable objstorage.Readable,
 	cacheID uint64,
 	fileNum base.DiskFileNum,
+	span KeyRange,
+	ingestOpts IngestOptions,
 ) (*fileMetadata, error) {
 	cacheOpts := private.SSTableCacheOpts(cacheID, fileNum).(sstable.ReaderOption)
 	r, err := sstable.NewReader(readable, opts.MakeReaderOptions(), cacheOpts)
@@ -215,11 +332,18 @@ func ingestLoad1(
 	}
 	defer r.Close()
 
+	if err := ingestVerify(opts, r, ingestOpts); err != nil {
+		return nil, err
+	}
+
 	// Avoid ingesting tables with format versions this DB doesn't support.
 	tf, err := r.TableFormat()
 	if err != nil {
 		return nil, err
 	}
+	// Tables written by RocksDB (e.g. by its SstFileWriter) are at
+	// TableFormatRocksDBv2, and so may only be ingested by DBs whose format
+	// major version precedes FormatMinTableFormatPebblev1.
 	if tf < fmv.MinTableFormat() || tf > fmv.MaxTableFormat() {
 		return nil, errors.Newf(
 			"pebble: table format %s is not within range supported at DB format major version %d, (%s,%s)",
@@ -227,31 +351,87 @@ func ingestLoad1(
 		)
 	}
 
+	// Avoid ingesting tables that use block extensions this DB's format major
+	// version doesn't support, which older versions of Pebble can't read.
+	if fmv < ExperimentalFormatZstdDictionaries && r.HasZstdDictionary() {
+		return nil, errors.Newf(
+			"pebble: table compressed with a zstd dictionary requires format major version %d, DB is at %d",
+			ExperimentalFormatZstdDictionaries, fmv)
+	}
+	if fmv < ExperimentalFormatBlockExtensions {
+		var ext string
+		switch {
+		case r.ChecksumType() == ChecksumTypeXXH3:
+			ext = "checksummed with XXH3"
+		case r.Properties.ColumnarDataBlocks:
+			ext = "with columnar data blocks"
+		case r.IsEncrypted():
+			ext = "with encrypted blocks"
+		}
+		if ext != "" {
+			return nil, errors.Newf("pebble: table %s requires format major version %d, DB is at %d",
+				ext, ExperimentalFormatBlockExtensions, fmv)
+		}
+	}
+
+	// The values stored in the blob files of another DB can't be read.
+	if r.Properties.NumValuesInBlobFiles > 0 {
+		return nil, errors.Newf("pebble: cannot ingest sstable with values in blob files")
+	}
+
 	meta := &fileMetadata{}
 	meta.FileNum = fileNum.FileNum()
 	meta.Size = uint64(readable.Size())
 	meta.CreationTime = time.Now().Unix()
-	meta.InitPhysicalBacking()
+	if span.Valid() {
+		// Only a slice of the file is being ingested. The file is linked into
+		// the DB in its entirety and backs a virtual sstable constrained to
+		// the keys within span.
+		size, err := r.EstimateDiskUsage(span.Start, span.End)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			// Disallow 0 file sizes.
+			size = 1
+		}
+		meta.Virtual = true
+		meta.Size = size
+		meta.InitProviderBacking(fileNum)
+		meta.FileBacking.Size = uint64(readable.Size())
+	} else {
+		meta.InitPhysicalBacking()
 
-	// Avoid loading into the table cache for collecting stats if we
-	// don't need to. If there are no range deletions, we have all the
-	// information to compute the stats here.
-	//
-	// This is helpful in tests for avoiding awkwardness around deletion of
-	// ingested files from MemFS. MemFS implements the Windows semantics of
-	// disallowing removal of an open file. Under MemFS, if we don't populate
-	// meta.Stats here, the file will be loaded into the table cache for
-	// calculating stats before we can remove the original link.
-	maybeSetStatsFromProperties(meta.PhysicalMeta(), &r.Properties)
+		// Avoid loading into the table cache for collecting stats if we
+		// don't need to. If there are no range deletions, we have all the
+		// information to compute the stats here.
+		//
+		// This is helpful in tests for avoiding awkwardness around deletion of
+		// ingested files from MemFS. MemFS implements the Windows semantics of
+		// disallowing removal of an open file. Under MemFS, if we don't populate
+		// meta.Stats here, the file will be loaded into the table cache for
+		// calculating stats before we can remove the original link.
+		maybeSetStatsFromProperties(meta.PhysicalMeta(), &r.Properties)
+	}
 
 	{
-		iter, err := r.NewIter(nil /* lower */, nil /* upper */)
+		iter, err := r.NewIter(span.Start, span.End)
 		if err != nil {
 			return nil, err
 		}
 		defer iter.Close()
+		// Iterators with bounds must be positioned by seeking.
+		first, last := iter.First, iter.Last
+		if span.Valid() {
+			first = func() (*InternalKey, base.LazyValue) {
+				return iter.SeekGE(span.Start, base.SeekGEFlagsNone)
+			}
+			last = func() (*InternalKey, base.LazyValue) {
+				return iter.SeekLT(span.End, base.SeekLTFlagsNone)
+			}
+		}
 		var smallest InternalKey
-		if key, _ := iter.First(); key != nil {
+		if key, _ := first(); key != nil {
 			if err := ingestValidateKey(opts, key); err != nil {
 				return nil, err
 			}
@@ -260,7 +440,7 @@ func ingestLoad1(
 		if err := iter.Error(); err != nil {
 			return nil, err
 		}
-		if key, _ := iter.Last(); key != nil {
+		if key, _ := last(); key != nil {
 			if err := ingestValidateKey(opts, key); err != nil {
 				return nil, err
 			}
@@ -277,6 +457,10 @@ func ingestLoad1(
 	}
 	if iter != nil {
 		defer iter.Close()
+		if span.Valid() {
+			iter = keyspan.Truncate(opts.Comparer.Compare, iter, span.Start, span.End,
+				nil, nil, false /* panicOnUpperTruncate */)
+		}
 		var smallest InternalKey
 		if s := iter.First(); s != nil {
 			key := s.SmallestKey()
@@ -306,6 +490,10 @@ func ingestLoad1(
 		}
 		if iter != nil {
 			defer iter.Close()
+			if span.Valid() {
+				iter = keyspan.Truncate(opts.Comparer.Compare, iter, span.Start, span.End,
+					nil, nil, false /* panicOnUpperTruncate */)
+			}
 			var smallest InternalKey
 			if s := iter.First(); s != nil {
 				key := s.SmallestKey()
@@ -357,12 +545,14 @@ func ingestLoad(
 	opts *Options,
 	fmv FormatMajorVersion,
 	paths []string,
+	spans []KeyRange,
 	shared []SharedSSTMeta,
 	external []ExternalFile,
 	cacheID uint64,
 	pending []base.DiskFileNum,
 	objProvider objstorage.Provider,
 	jobID int,
+	ingestOpts IngestOptions,
 ) (ingestLoadResult, error) {
 	meta := make([]*fileMetadata, 0, len(paths))
 	newPaths := make([]string, 0, len(paths))
@@ -376,7 +566,11 @@ func ingestLoad(
 		if err != nil {
 			return ingestLoadResult{}, err
 		}
-		m, err := ingestLoad1(opts, fmv, readable, cacheID, pending[i])
+		var span KeyRange
+		if spans != nil {
+			span = spans[i]
+		}
+		m, err := ingestLoad1(opts, fmv, readable, cacheID, pending[i], span, ingestOpts)
 		if err != nil {
 			return ingestLoadResult{}, err
 		}
@@ -534,7 +728,7 @@ func ingestLink(
 	for i := range lr.localPaths {
 		objMeta, err := objProvider.LinkOrCopyFromLocal(
 			context.TODO(), opts.FS, lr.localPaths[i], fileTypeTable, lr.localMeta[i].FileBacking.DiskFileNum,
-			objstorage.CreateOptions{PreferSharedStorage: true},
+			objstorage.CreateOptions{PreferSharedStorage: opts.Experimental.CreateOnShared},
 		)
 		if err != nil {
 			if err2 := ingestCleanup(objProvider, lr.localMeta[:i]); err2 != nil {
@@ -1007,10 +1201,10 @@ func (d *DB) Ingest(paths []string) error {
 	if err := d.closed.Load(); err != nil {
 		panic(err)
 	}
-	if d.opts.ReadOnly {
-		return ErrReadOnly
+	if err := d.checkWritable(); err != nil {
+		return err
 	}
-	_, err := d.ingest(paths, ingestTargetLevel, nil /* shared */, KeyRange{}, nil /* external */)
+	_, err := d.ingest(paths, nil /* spans */, ingestTargetLevel, nil /* shared */, KeyRange{}, nil /* external */, IngestOptions{})
 	return err
 }
 
@@ -1030,6 +1224,25 @@ type IngestOperationStats struct {
 	MemtableOverlappingFiles int
 }
 
+// IngestOptions configures the verification of the sstables being ingested by
+// DB.IngestWithOptions. By default, ingestion only reads the metadata and the
+// bounds of the sstables, trusting the rest of their contents.
+type IngestOptions struct {
+	// VerifyChecksums validates the checksums of every block of the sstables
+	// before they're linked into the DB, so that sstables corrupted in transit
+	// are rejected rather than failing later reads and compactions.
+	VerifyChecksums bool
+	// VerifyContents reads every key and value of the sstables, verifying that
+	// the point keys are ordered, that the range deletions and range keys are
+	// ordered, non-overlapping fragments, that none of the keys have sequence
+	// numbers, and that the point key counts match the sstables' properties.
+	VerifyContents bool
+
+	// disableIngestAsBatch prevents small sstables from being ingested as a
+	// batch (see Options.Experimental.IngestAsBatchMaxSize).
+	disableIngestAsBatch bool
+}
+
 // ExternalFile are external sstables that can be referenced through
 // objprovider and ingested as remote files that will not be refcounted or
 // cleaned up. For use with online restore. Note that the underlying sstable
@@ -1054,6 +1267,20 @@ type ExternalFile struct {
 	// or range keys. If both structs are false, an error is returned during
 	// ingestion.
 	HasPointKey, HasRangeKey bool
+	// ContentPrefix and SyntheticPrefix, if set, rewrite the prefix of the keys
+	// of the sstable: its keys within the bounds start with ContentPrefix, and
+	// they're ingested as if they started with SyntheticPrefix instead (for
+	// example, to move the data of a tenant to another tenant ID without
+	// rewriting the sstable). The sstable is only rewritten with the new
+	// prefix when it's compacted. SmallestUserKey and LargestUserKey are in the
+	// rewritten keyspace, and must be within the keys starting with
+	// SyntheticPrefix.
+	//
+	// The Comparer must order the keys starting with a common prefix by the
+	// bytes that follow it, and split them into prefixes and suffixes (see
+	// Comparer.Split) regardless of it. Prefix replacement requires
+	// ExperimentalFormatPrefixReplacement.
+	ContentPrefix, SyntheticPrefix []byte
 }
 
 // IngestWithStats does the same as Ingest, and additionally returns
@@ -1062,10 +1289,24 @@ func (d *DB) IngestWithStats(paths []string) (IngestOperationStats, error) {
 	if err := d.closed.Load(); err != nil {
 		panic(err)
 	}
-	if d.opts.ReadOnly {
-		return IngestOperationStats{}, ErrReadOnly
+	if err := d.checkWritable(); err != nil {
+		return IngestOperationStats{}, err
 	}
-	return d.ingest(paths, ingestTargetLevel, nil /* shared */, KeyRange{}, nil /* external */)
+	return d.ingest(paths, nil /* spans */, ingestTargetLevel, nil /* shared */, KeyRange{}, nil /* external */, IngestOptions{})
+}
+
+// IngestWithOptions does the same as IngestWithStats, and additionally
+// verifies the contents of the sstables before ingesting them as configured by
+// opts. If the verification of any of the sstables fails, none of them are
+// ingested and a corruption error is returned.
+func (d *DB) IngestWithOptions(paths []string, opts IngestOptions) (IngestOperationStats, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if err := d.checkWritable(); err != nil {
+		return IngestOperationStats{}, err
+	}
+	return d.ingest(paths, nil /* spans */, ingestTargetLevel, nil /* shared */, KeyRange{}, nil /* external */, opts)
 }
 
 // IngestExternalFiles does the same as IngestWithStats, and additionally
@@ -1077,13 +1318,53 @@ func (d *DB) IngestExternalFiles(external []ExternalFile) (IngestOperationStats,
 		panic(err)
 	}
 
-	if d.opts.ReadOnly {
-		return IngestOperationStats{}, ErrReadOnly
+	if err := d.checkWritable(); err != nil {
+		return IngestOperationStats{}, err
 	}
 	if d.opts.Experimental.RemoteStorage == nil {
 		return IngestOperationStats{}, errors.New("pebble: cannot ingest external files without shared storage configured")
 	}
-	return d.ingest(nil, ingestTargetLevel, nil /* shared */, KeyRange{}, external)
+	return d.ingest(nil, nil /* spans */, ingestTargetLevel, nil /* shared */, KeyRange{}, external, IngestOptions{})
+}
+
+// IngestSlice describes a slice of a local sstable: the keys of the sstable at
+// Path that fall within the user key span [Start, End).
+type IngestSlice struct {
+	Path       string
+	Start, End []byte
+}
+
+// IngestSlices does the same as IngestWithStats, but ingests only the keys of
+// each sstable that fall within the corresponding slice. Each sstable is
+// linked into the DB in its entirety and backs a virtual sstable constrained
+// to the slice, so that a producer can build one large sstable and disjoint
+// slices of it can be ingested without rewriting it. Unlike the other forms of
+// ingestion, the sstables are not removed once ingested. Multiple slices of
+// the same sstable may be ingested, either in one call or in separate calls,
+// as long as the slices ingested together do not overlap. Range deletions and
+// range keys that straddle the bounds of a slice are truncated to it. Slices
+// that do not contain any keys are elided.
+//
+// Requires a format major version of at least
+// ExperimentalFormatVirtualSSTables.
+func (d *DB) IngestSlices(slices []IngestSlice) (IngestOperationStats, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if err := d.checkWritable(); err != nil {
+		return IngestOperationStats{}, err
+	}
+	paths := make([]string, len(slices))
+	spans := make([]KeyRange, len(slices))
+	for i := range slices {
+		if slices[i].Start == nil || slices[i].End == nil || d.cmp(slices[i].Start, slices[i].End) >= 0 {
+			return IngestOperationStats{}, errors.Errorf("pebble: invalid slice [%s, %s) of %s",
+				d.opts.Comparer.FormatKey(slices[i].Start), d.opts.Comparer.FormatKey(slices[i].End), slices[i].Path)
+		}
+		paths[i] = slices[i].Path
+		spans[i] = KeyRange{Start: slices[i].Start, End: slices[i].End}
+	}
+	return d.ingest(paths, spans, ingestTargetLevel, nil /* shared */, KeyRange{}, nil /* external */, IngestOptions{})
 }
 
 // IngestAndExcise does the same as IngestWithStats, and additionally accepts a
@@ -1102,10 +1383,36 @@ func (d *DB) IngestAndExcise(
 	if err := d.closed.Load(); err != nil {
 		panic(err)
 	}
-	if d.opts.ReadOnly {
-		return IngestOperationStats{}, ErrReadOnly
+	if err := d.checkWritable(); err != nil {
+		return IngestOperationStats{}, err
 	}
-	return d.ingest(paths, ingestTargetLevel, shared, exciseSpan, nil /* external */)
+	return d.ingest(paths, nil /* spans */, ingestTargetLevel, shared, exciseSpan, nil /* external */, IngestOptions{})
+}
+
+// Excise atomically deletes all of the keys in the span [Start, End), as
+// IngestAndExcise does for its exciseSpan, without ingesting any sstables.
+// Sstables within the span are removed, and sstables that straddle its bounds
+// are replaced by virtual sstables that exclude it, so the cost of Excise is
+// independent of the amount of data deleted. Unlike a DeleteRange, the keys
+// are removed from the views of open snapshots too; only iterators that are
+// already open continue to observe them. If the memtables contain keys in the
+// span, Excise waits for them to be flushed.
+//
+// Excise requires a FormatMajorVersion of at least
+// ExperimentalFormatVirtualSSTables.
+func (d *DB) Excise(span KeyRange) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	if !span.Valid() || d.cmp(span.Start, span.End) >= 0 {
+		return errors.Errorf("pebble: invalid excise span [%s, %s)",
+			d.opts.Comparer.FormatKey(span.Start), d.opts.Comparer.FormatKey(span.End))
+	}
+	_, err := d.ingest(nil /* paths */, nil /* spans */, ingestTargetLevel, nil /* shared */, span, nil /* external */, IngestOptions{})
+	return err
 }
 
 // Both DB.mu and commitPipeline.mu must be held while this is called.
@@ -1218,16 +1525,30 @@ func (d *DB) handleIngestAsFlushable(meta []*fileMetadata, seqNum uint64) error
 // See comment at Ingest() for details on how this works.
 func (d *DB) ingest(
 	paths []string,
+	spans []KeyRange,
 	targetLevelFunc ingestTargetLevelFunc,
 	shared []SharedSSTMeta,
 	exciseSpan KeyRange,
 	external []ExternalFile,
+	ingestOpts IngestOptions,
 ) (IngestOperationStats, error) {
 	if len(shared) > 0 && d.opts.Experimental.RemoteStorage == nil {
 		panic("cannot ingest shared sstables with nil SharedStorage")
 	}
-	if (exciseSpan.Valid() || len(shared) > 0 || len(external) > 0) && d.FormatMajorVersion() < ExperimentalFormatVirtualSSTables {
-		return IngestOperationStats{}, errors.New("pebble: format major version too old for excise, shared or external sstable ingestion")
+	if (exciseSpan.Valid() || len(shared) > 0 || len(external) > 0 || len(spans) > 0) && d.FormatMajorVersion() < ExperimentalFormatVirtualSSTables {
+		return IngestOperationStats{}, errors.New("pebble: format major version too old for excise, shared, external or sliced sstable ingestion")
+	}
+	if d.FormatMajorVersion() < ExperimentalFormatPrefixReplacement {
+		for i := range external {
+			if external[i].ContentPrefix != nil || external[i].SyntheticPrefix != nil {
+				return IngestOperationStats{}, errors.New("pebble: format major version too old for external sstable ingestion with prefix replacement")
+			}
+		}
+	}
+	if d.rowCache != nil {
+		// The ingested sstables (and the excise span) may contain any key.
+		d.rowCache.beginRange()
+		defer d.rowCache.endRange()
 	}
 	// Allocate file numbers for all of the files being ingested and mark them as
 	// pending in order to prevent them from being deleted. Note that this causes
@@ -1246,13 +1567,14 @@ func (d *DB) ingest(
 
 	// Load the metadata for all the files being ingested. This step detects
 	// and elides empty sstables.
-	loadResult, err := ingestLoad(d.opts, d.FormatMajorVersion(), paths, shared, external, d.cacheID, pendingOutputs, d.objProvider, jobID)
+	loadResult, err := ingestLoad(d.opts, d.FormatMajorVersion(), paths, spans, shared, external, d.cacheID, pendingOutputs, d.objProvider, jobID, ingestOpts)
 	if err != nil {
 		return IngestOperationStats{}, err
 	}
 
-	if loadResult.fileCount == 0 {
-		// All of the sstables to be ingested were empty. Nothing to do.
+	if loadResult.fileCount == 0 && !exciseSpan.Valid() {
+		// All of the sstables to be ingested were empty, and there is no span
+		// to excise. Nothing to do.
 		return IngestOperationStats{}, nil
 	}
 
@@ -1261,6 +1583,10 @@ func (d *DB) ingest(
 		return IngestOperationStats{}, err
 	}
 
+	if !exciseSpan.Valid() && !ingestOpts.disableIngestAsBatch && d.shouldIngestAsBatch(loadResult) {
+		return d.ingestAsBatch(loadResult)
+	}
+
 	// Hard link the sstables into the DB directory. Since the sstables aren't
 	// referenced by a version, they won't be used. If the hard linking fails
 	// (e.g. because the files reside on a different filesystem), ingestLink will
@@ -1277,6 +1603,16 @@ func (d *DB) ingest(
 		return IngestOperationStats{}, err
 	}
 
+	// Record the ingestion in the WAL archive for replication, as it bypasses
+	// the WAL.
+	replEvent, err := d.linkReplicationTables(loadResult, spans, pendingOutputs, exciseSpan)
+	if err != nil {
+		if err2 := ingestCleanup(d.objProvider, loadResult.localMeta); err2 != nil {
+			d.opts.Logger.Infof("ingest cleanup failed: %v", err2)
+		}
+		return IngestOperationStats{}, err
+	}
+
 	// metaFlushableOverlaps is a slice parallel to meta indicating which of the
 	// ingested sstables overlap some table in the flushable queue. It's used to
 	// approximate ingest-into-L0 stats when using flushable ingests.
@@ -1290,6 +1626,11 @@ func (d *DB) ingest(
 
 		d.mu.Lock()
 		defer d.mu.Unlock()
+		if replEvent != nil {
+			// Register the event once the WAL is rotated below, if it is, so
+			// that the event's WAL file precedes those of the later batches.
+			defer d.beginReplicationEventLocked(replEvent, seqNum)
+		}
 
 		// Check to see if any files overlap with any of the memtables. The queue
 		// is ordered from oldest to newest with the mutable memtable being the
@@ -1371,7 +1712,7 @@ func (d *DB) ingest(
 		// The ingestion overlaps with some entry in the flushable queue.
 		if d.FormatMajorVersion() < FormatFlushableIngest ||
 			d.opts.Experimental.DisableIngestAsFlushable() ||
-			len(shared) > 0 || exciseSpan.Valid() || len(external) > 0 ||
+			len(shared) > 0 || exciseSpan.Valid() || len(external) > 0 || len(spans) > 0 ||
 			(len(d.mu.mem.queue) > d.opts.MemTableStopWritesThreshold-1) {
 			// We're not able to ingest as a flushable,
 			// so we must synchronously flush.
@@ -1450,17 +1791,29 @@ func (d *DB) ingest(
 	// the commit mutex which would prevent unrelated batches from writing their
 	// changes to the WAL and memtable. This will cause a bigger commit hiccup
 	// during ingestion.
+	//
+	// An excise that ingests no sstables still allocates a sequence number,
+	// ordering it after the writes that precede it.
+	seqNumCount := loadResult.fileCount
+	if seqNumCount == 0 {
+		seqNumCount = 1
+	}
 	d.commit.ingestSem <- struct{}{}
-	d.commit.AllocateSeqNum(loadResult.fileCount, prepare, apply)
+	d.commit.AllocateSeqNum(seqNumCount, prepare, apply)
 	<-d.commit.ingestSem
+	if replEvent != nil {
+		d.finishReplicationEvent(replEvent, err)
+	}
 
 	if err != nil {
 		if err2 := ingestCleanup(d.objProvider, loadResult.localMeta); err2 != nil {
 			d.opts.Logger.Infof("ingest cleanup failed: %v", err2)
 		}
-	} else {
+	} else if len(spans) == 0 {
 		// Since we either created a hard link to the ingesting files, or copied
-		// them over, it is safe to remove the originals paths.
+		// them over, it is safe to remove the originals paths. The sstables
+		// slices were ingested from are left in place, as other slices of them
+		// may still be ingested.
 		for _, path := range loadResult.localPaths {
 			if err2 := d.opts.FS.Remove(path); err2 != nil {
 				d.opts.Logger.Infof("ingest failed to remove original file: %s", err2)
@@ -1472,6 +1825,11 @@ func (d *DB) ingest(
 		for _, sharedMeta := range loadResult.sharedMeta {
 			d.checkVirtualBounds(sharedMeta)
 		}
+		for _, localMeta := range loadResult.localMeta {
+			if localMeta.Virtual {
+				d.checkVirtualBounds(localMeta)
+			}
+		}
 	}
 
 	info := TableIngestInfo{
@@ -1483,7 +1841,7 @@ func (d *DB) ingest(
 		info.GlobalSeqNum = loadResult.localMeta[0].SmallestSeqNum
 	} else if len(loadResult.sharedMeta) > 0 {
 		info.GlobalSeqNum = loadResult.sharedMeta[0].SmallestSeqNum
-	} else {
+	} else if len(loadResult.externalMeta) > 0 {
 		info.GlobalSeqNum = loadResult.externalMeta[0].SmallestSeqNum
 	}
 	var stats IngestOperationStats
@@ -1533,6 +1891,132 @@ func (d *DB) ingest(
 	return stats, err
 }
 
+// shouldIngestAsBatch returns true if the sstables of an ingestion should be
+// applied to the memtable as a batch, rather than ingested. See
+// Options.Experimental.IngestAsBatchMaxSize.
+func (d *DB) shouldIngestAsBatch(loadResult ingestLoadResult) bool {
+	maxSize := d.opts.Experimental.IngestAsBatchMaxSize
+	if maxSize <= 0 || len(loadResult.sharedMeta) > 0 || len(loadResult.externalMeta) > 0 {
+		return false
+	}
+	var size uint64
+	keyRanges := make([]internalKeyRange, 0, len(loadResult.localMeta))
+	for _, m := range loadResult.localMeta {
+		if m.HasRangeKeys || m.Virtual {
+			return false
+		}
+		size += m.Size
+		keyRanges = append(keyRanges, internalKeyRange{smallest: m.Smallest, largest: m.Largest})
+	}
+	if size > uint64(maxSize) {
+		return false
+	}
+
+	// Tables that do not overlap the memtables are ingested without a flush,
+	// and usually into a level below L0.
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, m := range d.mu.mem.queue {
+		if ingestMemtableOverlaps(d.cmp, m, keyRanges) {
+			return true
+		}
+	}
+	return false
+}
+
+// ingestAsBatch applies the contents of the sstables of an ingestion to the
+// memtable as a batch, and removes the sstables.
+func (d *DB) ingestAsBatch(loadResult ingestLoadResult) (IngestOperationStats, error) {
+	// The keys of an ingested sstable share a sequence number, so the range
+	// deletions of an ingestion do not delete its point keys. The range
+	// deletions are added to the batch before the point keys, which receive
+	// higher sequence numbers, to preserve that.
+	b := d.NewBatch()
+	defer b.Close()
+	points := d.NewBatch()
+	defer points.Close()
+
+	var stats IngestOperationStats
+	for i, m := range loadResult.localMeta {
+		if err := d.ingestReadIntoBatch(m, loadResult.localPaths[i], b, points); err != nil {
+			return IngestOperationStats{}, err
+		}
+		stats.Bytes += m.Size
+		stats.MemtableOverlappingFiles++
+	}
+	if err := b.Apply(points, nil); err != nil {
+		return IngestOperationStats{}, err
+	}
+	// With the WAL disabled, the keys are durable once the memtable is
+	// flushed, like those of any other write.
+	writeOpts := Sync
+	if d.opts.DisableWAL {
+		writeOpts = NoSync
+	}
+	if err := d.Apply(b, writeOpts); err != nil {
+		return IngestOperationStats{}, err
+	}
+	for _, path := range loadResult.localPaths {
+		if err := d.opts.FS.Remove(path); err != nil {
+			d.opts.Logger.Infof("ingest failed to remove original file: %s", err)
+		}
+	}
+	return stats, nil
+}
+
+// ingestReadIntoBatch adds the range deletions of the ingested sstable m,
+// stored at path, to rangeDels, and its point keys to points.
+func (d *DB) ingestReadIntoBatch(
+	m *fileMetadata, path string, rangeDels, points *Batch,
+) error {
+	f, err := d.opts.FS.Open(path)
+	if err != nil {
+		return err
+	}
+	readable, err := sstable.NewSimpleReadable(f)
+	if err != nil {
+		return err
+	}
+	cacheOpts := private.SSTableCacheOpts(d.cacheID, m.FileBacking.DiskFileNum).(sstable.ReaderOption)
+	r, err := sstable.NewReader(readable, d.opts.MakeReaderOptions(), cacheOpts)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	rangeDelIter, err := r.NewRawRangeDelIter()
+	if err != nil {
+		return err
+	}
+	if rangeDelIter != nil {
+		for s := rangeDelIter.First(); s != nil; s = rangeDelIter.Next() {
+			if err := rangeDels.DeleteRange(s.Start, s.End, nil); err != nil {
+				rangeDelIter.Close()
+				return err
+			}
+		}
+		if err := rangeDelIter.Close(); err != nil {
+			return err
+		}
+	}
+
+	iter, err := r.NewIter(nil /* lower */, nil /* upper */)
+	if err != nil {
+		return err
+	}
+	for k, lv := iter.First(); k != nil; k, lv = iter.Next() {
+		v, _, err := lv.Value(nil)
+		if err == nil {
+			err = points.AddInternalKey(k, v, nil)
+		}
+		if err != nil {
+			iter.Close()
+			return err
+		}
+	}
+	return iter.Close()
+}
+
 // excise updates ve to include a replacement of the file m with new virtual
 // sstables that exclude exciseSpan, returning a slice of newly-created files if
 // any. If the entirety of m is deleted by exciseSpan, no new sstables are added
@@ -1583,9 +2067,10 @@ func (d *DB) excise(
 	// https://github.com/cockroachdb/pebble/issues/2112 .
 	if d.cmp(m.Smallest.UserKey, exciseSpan.Start) < 0 {
 		leftFile := &fileMetadata{
-			Virtual:     true,
-			FileBacking: m.FileBacking,
-			FileNum:     d.mu.versions.getNextFileNum(),
+			Virtual:           true,
+			FileBacking:       m.FileBacking,
+			FileNum:           d.mu.versions.getNextFileNum(),
+			PrefixReplacement: m.PrefixReplacement,
 			// Note that these are loose bounds for smallest/largest seqnums, but they're
 			// sufficient for maintaining correctness.
 			SmallestSeqNum: m.SmallestSeqNum,
@@ -1670,6 +2155,7 @@ func (d *DB) excise(
 				return nil, err
 			}
 			leftFile.ValidateVirtual(m)
+			leftFile.BlobReferences = manifest.ScaleBlobReferences(m.BlobReferences, leftFile.Size, m.Size)
 			d.checkVirtualBounds(leftFile)
 			ve.NewFiles = append(ve.NewFiles, newFileEntry{Level: level, Meta: leftFile})
 			ve.CreatedBackingTables = append(ve.CreatedBackingTables, leftFile.FileBacking)
@@ -1687,9 +2173,10 @@ func (d *DB) excise(
 	// See comment before the definition of leftFile for the motivation behind
 	// calculating tight user-key bounds.
 	rightFile := &fileMetadata{
-		Virtual:     true,
-		FileBacking: m.FileBacking,
-		FileNum:     d.mu.versions.getNextFileNum(),
+		Virtual:           true,
+		FileBacking:       m.FileBacking,
+		FileNum:           d.mu.versions.getNextFileNum(),
+		PrefixReplacement: m.PrefixReplacement,
 		// Note that these are loose bounds for smallest/largest seqnums, but they're
 		// sufficient for maintaining correctness.
 		SmallestSeqNum: m.SmallestSeqNum,
@@ -1779,6 +2266,7 @@ func (d *DB) excise(
 			rightFile.Size = 1
 		}
 		rightFile.ValidateVirtual(m)
+		rightFile.BlobReferences = manifest.ScaleBlobReferences(m.BlobReferences, rightFile.Size, m.Size)
 		d.checkVirtualBounds(rightFile)
 		ve.NewFiles = append(ve.NewFiles, newFileEntry{Level: level, Meta: rightFile})
 		if !backingTableCreated {
@@ -1875,7 +2363,9 @@ func (d *DB) ingestApply(
 			}
 			ve.CreatedBackingTables = append(ve.CreatedBackingTables, m.FileBacking)
 		} else {
-			if externalFile {
+			if externalFile || m.Virtual {
+				// External files and slices of local files are virtual sstables
+				// over a newly created backing.
 				ve.CreatedBackingTables = append(ve.CreatedBackingTables, m.FileBacking)
 			}
 			if exciseSpan.Valid() && exciseSpan.Contains(d.cmp, m.Smallest) && exciseSpan.Contains(d.cmp, m.Largest) {
@@ -2005,6 +2495,7 @@ func (d *DB) ingestApply(
 	// so check to see if one is necessary and schedule it.
 	d.maybeScheduleCompaction()
 	d.maybeValidateSSTablesLocked(ve.NewFiles)
+	d.maybeWarmSSTablesLocked(ve.NewFiles)
 	return ve, nil
 }
 
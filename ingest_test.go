@@ -126,7 +126,7 @@ func TestIngestLoad(t *testing.T) {
 				Comparer: DefaultComparer,
 				FS:       mem,
 			}).WithFSDefaults()
-			lr, err := ingestLoad(opts, dbVersion, []string{"ext"}, nil, nil, 0, []base.DiskFileNum{base.FileNum(1).DiskFileNum()}, nil, 0)
+			lr, err := ingestLoad(opts, dbVersion, []string{"ext"}, nil, nil, nil, 0, []base.DiskFileNum{base.FileNum(1).DiskFileNum()}, nil, 0, IngestOptions{})
 			if err != nil {
 				return err.Error()
 			}
@@ -213,7 +213,7 @@ func TestIngestLoadRand(t *testing.T) {
 		Comparer: DefaultComparer,
 		FS:       mem,
 	}).WithFSDefaults()
-	lr, err := ingestLoad(opts, version, paths, nil, nil, 0, pending, nil, 0)
+	lr, err := ingestLoad(opts, version, paths, nil, nil, nil, 0, pending, nil, 0, IngestOptions{})
 	require.NoError(t, err)
 
 	for _, m := range lr.localMeta {
@@ -234,11 +234,87 @@ func TestIngestLoadInvalid(t *testing.T) {
 		Comparer: DefaultComparer,
 		FS:       mem,
 	}).WithFSDefaults()
-	if _, err := ingestLoad(opts, internalFormatNewest, []string{"invalid"}, nil, nil, 0, []base.DiskFileNum{base.FileNum(1).DiskFileNum()}, nil, 0); err == nil {
+	if _, err := ingestLoad(opts, internalFormatNewest, []string{"invalid"}, nil, nil, nil, 0, []base.DiskFileNum{base.FileNum(1).DiskFileNum()}, nil, 0, IngestOptions{}); err == nil {
 		t.Fatalf("expected error, but found success")
 	}
 }
 
+func TestIngestVerify(t *testing.T) {
+	mem := vfs.NewMem()
+	f, err := mem.Create("ext")
+	require.NoError(t, err)
+	w := sstable.NewWriter(objstorageprovider.NewFileWritable(f), sstable.WriterOptions{
+		BlockSize:   64,
+		TableFormat: internalFormatNewest.MaxTableFormat(),
+	})
+	for i := 0; i < 100; i++ {
+		require.NoError(t, w.Set([]byte(fmt.Sprintf("key%03d", i)), []byte("value")))
+	}
+	require.NoError(t, w.DeleteRange([]byte("key010"), []byte("key020")))
+	require.NoError(t, w.RangeKeySet([]byte("key030"), []byte("key040"), nil, []byte("value")))
+	require.NoError(t, w.Close())
+
+	readExt := func(name string) *sstable.Reader {
+		f, err := mem.Open(name)
+		require.NoError(t, err)
+		readable, err := sstable.NewSimpleReadable(f)
+		require.NoError(t, err)
+		r, err := sstable.NewReader(readable, sstable.ReaderOptions{})
+		require.NoError(t, err)
+		return r
+	}
+
+	// Corrupt a data block in the middle of a copy of the sstable, which isn't
+	// read by ingestion unless its checksums are verified.
+	r := readExt("ext")
+	layout, err := r.Layout()
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Greater(t, len(layout.Data), 2)
+	require.NoError(t, vfs.Copy(mem, "ext", "corrupt"))
+	{
+		f, err := mem.OpenReadWrite("corrupt")
+		require.NoError(t, err)
+		off := int64(layout.Data[len(layout.Data)/2].Offset)
+		b := make([]byte, 1)
+		_, err = f.ReadAt(b, off)
+		require.NoError(t, err)
+		b[0] ^= 0xff
+		_, err = f.WriteAt(b, off)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	// The properties of a table must match its contents.
+	opts := (&Options{
+		Comparer:           DefaultComparer,
+		FS:                 mem,
+		FormatMajorVersion: internalFormatNewest,
+	}).WithFSDefaults()
+	r = readExt("ext")
+	require.NoError(t, ingestVerify(opts, r, IngestOptions{VerifyChecksums: true, VerifyContents: true}))
+	r.Properties.NumMergeOperands++
+	err = ingestVerify(opts, r, IngestOptions{VerifyContents: true})
+	require.True(t, errors.Is(err, base.ErrCorruption), "%v", err)
+	require.NoError(t, r.Close())
+
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	_, err = d.IngestWithOptions([]string{"corrupt"}, IngestOptions{VerifyChecksums: true})
+	require.True(t, errors.Is(err, base.ErrCorruption), "%v", err)
+	_, err = d.IngestWithOptions([]string{"corrupt"}, IngestOptions{VerifyContents: true})
+	require.True(t, errors.Is(err, base.ErrCorruption), "%v", err)
+
+	_, err = d.IngestWithOptions([]string{"ext"}, IngestOptions{VerifyChecksums: true, VerifyContents: true})
+	require.NoError(t, err)
+	v, closer, err := d.Get([]byte("key099"))
+	require.NoError(t, err)
+	require.Equal(t, "value", string(v))
+	require.NoError(t, closer.Close())
+}
+
 func TestIngestSortAndVerify(t *testing.T) {
 	comparers := map[string]Compare{
 		"default": DefaultComparer.Compare,
@@ -1166,6 +1242,50 @@ func TestSimpleIngestShared(t *testing.T) {
 	// of d and e have been updated.
 }
 
+func TestExciseWithoutIngest(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                 vfs.NewMem(),
+		FormatMajorVersion: ExperimentalFormatVirtualSSTables,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+	}
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("a"), []byte("f"), false))
+	// Keys in the memtable are flushed before they are excised.
+	require.NoError(t, d.Set([]byte("c2"), []byte("c2"), nil))
+	snap := d.NewSnapshot()
+	defer func() { require.NoError(t, snap.Close()) }()
+
+	require.NoError(t, d.Excise(KeyRange{Start: []byte("b"), End: []byte("d")}))
+	for _, r := range []Reader{d, snap} {
+		verifyGet(t, r, []byte("a"), []byte("a"))
+		verifyGetNotFound(t, r, []byte("b"))
+		verifyGetNotFound(t, r, []byte("c"))
+		verifyGetNotFound(t, r, []byte("c2"))
+		verifyGet(t, r, []byte("d"), []byte("d"))
+		verifyGet(t, r, []byte("e"), []byte("e"))
+	}
+	// The straddling sstable was replaced by virtual sstables.
+	var virtual int
+	d.mu.Lock()
+	iter := d.mu.versions.currentVersion().Levels[numLevels-1].Iter()
+	for f := iter.First(); f != nil; f = iter.Next() {
+		if f.Virtual {
+			virtual++
+		}
+	}
+	d.mu.Unlock()
+	require.Equal(t, 2, virtual)
+
+	// An empty span is rejected.
+	require.Error(t, d.Excise(KeyRange{Start: []byte("d"), End: []byte("d")}))
+	require.Error(t, d.Excise(KeyRange{Start: []byte("d")}))
+}
+
 type blockedCompaction struct {
 	startBlock, unblock chan struct{}
 }
@@ -1580,6 +1700,208 @@ func TestConcurrentExcise(t *testing.T) {
 	})
 }
 
+// TestIngestExternalRemoteWritable tests ingesting an sstable written directly
+// to remote storage.
+func TestIngestExternalRemoteWritable(t *testing.T) {
+	remoteStorage := remote.NewInMem()
+	opts := &Options{
+		FS:                 vfs.NewMem(),
+		FormatMajorVersion: ExperimentalFormatVirtualSSTables,
+	}
+	opts.Experimental.RemoteStorage = remote.MakeSimpleFactory(map[remote.Locator]remote.Storage{
+		"external-locator": remoteStorage,
+	})
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	require.NoError(t, d.SetCreatorID(1))
+
+	writable, err := objstorageprovider.CreateRemoteWritable(
+		remoteStorage, "bulk/000001.sst", objstorageprovider.RemoteUploadOptions{PartSize: 4 << 10})
+	require.NoError(t, err)
+	w := sstable.NewWriter(writable, d.opts.MakeWriterOptions(0, d.FormatMajorVersion().MaxTableFormat()))
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, w.Set([]byte(fmt.Sprintf("key%04d", i)), []byte(fmt.Sprintf("value%d", i))))
+	}
+	require.NoError(t, w.Close())
+	meta, err := w.Metadata()
+	require.NoError(t, err)
+
+	_, err = d.IngestExternalFiles([]ExternalFile{{
+		Locator:         "external-locator",
+		ObjName:         "bulk/000001.sst",
+		Size:            meta.Size,
+		SmallestUserKey: meta.SmallestPoint.UserKey,
+		LargestUserKey:  append(append([]byte(nil), meta.LargestPoint.UserKey...), 0),
+		HasPointKey:     true,
+	}})
+	require.NoError(t, err)
+
+	v, closer, err := d.Get([]byte("key0500"))
+	require.NoError(t, err)
+	require.Equal(t, "value500", string(v))
+	require.NoError(t, closer.Close())
+}
+
+// TestIngestExternalPrefixReplacement tests ingesting an external sstable with
+// the prefix of its keys rewritten.
+func TestIngestExternalPrefixReplacement(t *testing.T) {
+	fs := vfs.NewMem()
+	remoteStorage := remote.NewInMem()
+	newOpts := func() *Options {
+		opts := &Options{
+			FS:                 fs,
+			Comparer:           testkeys.Comparer,
+			FormatMajorVersion: ExperimentalFormatPrefixReplacement - 1,
+			DebugCheck:         DebugCheckLevels,
+		}
+		opts.Experimental.RemoteStorage = remote.MakeSimpleFactory(map[remote.Locator]remote.Storage{
+			"external-locator": remoteStorage,
+		})
+		opts.DisableAutomaticCompactions = true
+		return opts
+	}
+	d, err := Open("", newOpts())
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	require.NoError(t, d.SetCreatorID(1))
+
+	// The sstable holds the keys of the tenants t0, t1 and t2, of which the
+	// keys of t1 are ingested as the keys of t5.
+	writable, err := objstorageprovider.CreateRemoteWritable(
+		remoteStorage, "bulk/000001.sst", objstorageprovider.RemoteUploadOptions{PartSize: 4 << 10})
+	require.NoError(t, err)
+	w := sstable.NewWriter(writable, d.opts.MakeWriterOptions(0, d.FormatMajorVersion().MaxTableFormat()))
+	require.NoError(t, w.Set([]byte("t0/k000"), []byte("t0")))
+	require.NoError(t, w.DeleteRange([]byte("t1/k030"), []byte("t1/k040")))
+	require.NoError(t, w.RangeKeySet([]byte("t1/k070"), []byte("t1/k080"), nil, []byte("t1")))
+	for i := 0; i <= 100; i += 10 {
+		require.NoError(t, w.Set([]byte(fmt.Sprintf("t1/k%03d", i)), []byte(fmt.Sprintf("t1-%d", i))))
+	}
+	require.NoError(t, w.Set([]byte("t2/k000"), []byte("t2")))
+	require.NoError(t, w.Close())
+	meta, err := w.Metadata()
+	require.NoError(t, err)
+
+	external := ExternalFile{
+		Locator:         "external-locator",
+		ObjName:         "bulk/000001.sst",
+		Size:            meta.Size,
+		SmallestUserKey: []byte("t5/"),
+		LargestUserKey:  []byte("t50"),
+		HasPointKey:     true,
+		HasRangeKey:     true,
+		ContentPrefix:   []byte("t1/"),
+		SyntheticPrefix: []byte("t5/"),
+	}
+	// Prefix replacement requires ExperimentalFormatPrefixReplacement.
+	_, err = d.IngestExternalFiles([]ExternalFile{external})
+	require.Error(t, err)
+	require.NoError(t, d.RatchetFormatMajorVersion(ExperimentalFormatPrefixReplacement))
+
+	// The bounds must be within the synthetic prefix.
+	invalid := external
+	invalid.SmallestUserKey = []byte("t1/")
+	_, err = d.IngestExternalFiles([]ExternalFile{invalid})
+	require.Error(t, err)
+	invalid = external
+	invalid.ContentPrefix = nil
+	_, err = d.IngestExternalFiles([]ExternalFile{invalid})
+	require.Error(t, err)
+
+	// A key of t5 written before the ingestion is deleted by its range
+	// deletion.
+	require.NoError(t, d.Set([]byte("t5/k035"), []byte("existing"), nil))
+	require.NoError(t, d.Flush())
+	_, err = d.IngestExternalFiles([]ExternalFile{external})
+	require.NoError(t, err)
+
+	contents := func() string {
+		iter, _ := d.NewIter(&IterOptions{KeyTypes: IterKeyTypePointsAndRanges})
+		defer iter.Close()
+		var b strings.Builder
+		for valid := iter.First(); valid; valid = iter.Next() {
+			hasPoint, hasRange := iter.HasPointAndRange()
+			if hasRange && iter.RangeKeyChanged() {
+				start, end := iter.RangeBounds()
+				fmt.Fprintf(&b, "[%s-%s)=%s ", start, end, iter.RangeKeys()[0].Value)
+			}
+			if hasPoint {
+				fmt.Fprintf(&b, "%s=%s ", iter.Key(), iter.Value())
+			}
+		}
+		var reverse []string
+		for valid := iter.Last(); valid; valid = iter.Prev() {
+			if hasPoint, _ := iter.HasPointAndRange(); hasPoint {
+				reverse = append(reverse, string(iter.Key()))
+			}
+		}
+		fmt.Fprintf(&b, "reverse:%v", reverse)
+		return b.String()
+	}
+	// The range deletion of the ingested sstable doesn't delete its point keys,
+	// which share its sequence number.
+	const want = "t5/k000=t1-0 t5/k010=t1-10 t5/k020=t1-20 t5/k030=t1-30 t5/k040=t1-40 " +
+		"t5/k050=t1-50 t5/k060=t1-60 [t5/k070-t5/k080)=t1 t5/k070=t1-70 t5/k080=t1-80 " +
+		"t5/k090=t1-90 t5/k100=t1-100 reverse:[t5/k100 t5/k090 t5/k080 t5/k070 t5/k060 " +
+		"t5/k050 t5/k040 t5/k030 t5/k020 t5/k010 t5/k000]"
+	require.Equal(t, want, contents())
+
+	get := func(key string) string {
+		v, closer, err := d.Get([]byte(key))
+		if errors.Is(err, ErrNotFound) {
+			return "not found"
+		}
+		require.NoError(t, err)
+		defer closer.Close()
+		return string(v)
+	}
+	require.Equal(t, "t1-50", get("t5/k050"))
+	require.Equal(t, "not found", get("t5/k035"))
+	require.Equal(t, "not found", get("t1/k050"))
+	require.Equal(t, "not found", get("t0/k000"))
+
+	// Seeks and bounds are translated.
+	iter, _ := d.NewIter(&IterOptions{LowerBound: []byte("t5/k025"), UpperBound: []byte("t5/k065")})
+	require.True(t, iter.First())
+	require.Equal(t, "t5/k030", string(iter.Key()))
+	require.True(t, iter.Last())
+	require.Equal(t, "t5/k060", string(iter.Key()))
+	require.True(t, iter.SeekGE([]byte("t5/k051")))
+	require.Equal(t, "t5/k060", string(iter.Key()))
+	require.True(t, iter.SeekLT([]byte("t5/k040")))
+	require.Equal(t, "t5/k030", string(iter.Key()))
+	iter.SetBounds(nil, []byte("t5/k015"))
+	require.True(t, iter.Last())
+	require.Equal(t, "t5/k010", string(iter.Key()))
+	require.True(t, iter.SeekPrefixGE([]byte("t5/k000")))
+	require.Equal(t, "t5/k000", string(iter.Key()))
+	require.False(t, iter.SeekPrefixGE([]byte("t5/k005")))
+	require.NoError(t, iter.Close())
+
+	// The prefix replacement is persisted in the MANIFEST.
+	require.NoError(t, d.Close())
+	d, err = Open("", newOpts())
+	require.NoError(t, err)
+	require.Equal(t, want, contents())
+
+	// Excising part of the sstable preserves the prefix replacement.
+	require.NoError(t, d.Excise(KeyRange{Start: []byte("t5/k085"), End: []byte("t5/k095")}))
+	want2 := strings.Replace(strings.Replace(want, "t5/k090=t1-90 ", "", 1), "t5/k090 ", "", 1)
+	require.Equal(t, want2, contents())
+
+	// A compaction rewrites the keys with the synthetic prefix.
+	require.NoError(t, d.Compact([]byte("t5/"), []byte("t50"), false))
+	tables, err := d.SSTables()
+	require.NoError(t, err)
+	for _, level := range tables {
+		for _, tbl := range level {
+			require.False(t, tbl.Virtual)
+		}
+	}
+	require.Equal(t, want2, contents())
+}
+
 func TestIngestExternal(t *testing.T) {
 	var mem vfs.FS
 	var d *DB
@@ -1706,6 +2028,108 @@ func TestIngestExternal(t *testing.T) {
 	})
 }
 
+func TestIngestSlices(t *testing.T) {
+	mem := vfs.NewMem()
+	f, err := mem.Create("ext")
+	require.NoError(t, err)
+	w := sstable.NewWriter(objstorageprovider.NewFileWritable(f), sstable.WriterOptions{
+		BlockSize:   64,
+		TableFormat: internalFormatNewest.MaxTableFormat(),
+	})
+	for i := 0; i < 100; i++ {
+		require.NoError(t, w.Set([]byte(fmt.Sprintf("key%03d", i)), []byte("value")))
+	}
+	require.NoError(t, w.DeleteRange([]byte("key010"), []byte("key030")))
+	require.NoError(t, w.RangeKeySet([]byte("key040"), []byte("key060"), nil, []byte("value")))
+	require.NoError(t, w.Close())
+
+	opts := (&Options{
+		FS:                 mem,
+		FormatMajorVersion: internalFormatNewest,
+	}).WithFSDefaults()
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	require.NoError(t, d.Set([]byte("key015"), []byte("existing"), nil))
+	require.NoError(t, d.Set([]byte("key025"), []byte("existing"), nil))
+
+	_, err = d.IngestSlices([]IngestSlice{{Path: "ext", Start: []byte("key020"), End: []byte("key010")}})
+	require.Error(t, err)
+
+	// Ingest two disjoint slices that split the range deletion and the range
+	// key, and a slice containing no keys which is elided.
+	_, err = d.IngestSlices([]IngestSlice{
+		{Path: "ext", Start: []byte("key005"), End: []byte("key020")},
+		{Path: "ext", Start: []byte("key050"), End: []byte("key055")},
+		{Path: "ext", Start: []byte("zzz"), End: []byte("zzzz")},
+	})
+	require.NoError(t, err)
+	// The sstable isn't removed, so other slices of it can be ingested later.
+	_, err = d.IngestSlices([]IngestSlice{{Path: "ext", Start: []byte("key090"), End: []byte("key095")}})
+	require.NoError(t, err)
+
+	check := func(d *DB) {
+		var got []string
+		iter, _ := d.NewIter(&IterOptions{KeyTypes: IterKeyTypePointsAndRanges})
+		for valid := iter.First(); valid; valid = iter.Next() {
+			k := string(iter.Key())
+			hasPoint, hasRange := iter.HasPointAndRange()
+			if hasPoint {
+				k += "=" + string(iter.Value())
+			}
+			if hasRange && iter.RangeKeyChanged() {
+				start, end := iter.RangeBounds()
+				k += fmt.Sprintf(" [%s-%s)", start, end)
+			}
+			got = append(got, k)
+		}
+		require.NoError(t, iter.Close())
+		// The range deletion within the first slice doesn't delete the points
+		// ingested with it, and the existing key025 isn't deleted by the
+		// portion of the range deletion outside the slice.
+		var want []string
+		for i := 5; i < 20; i++ {
+			want = append(want, fmt.Sprintf("key%03d=value", i))
+		}
+		want = append(want, "key025=existing", "key050=value [key050-key055)")
+		for i := 51; i < 55; i++ {
+			want = append(want, fmt.Sprintf("key%03d=value", i))
+		}
+		for i := 90; i < 95; i++ {
+			want = append(want, fmt.Sprintf("key%03d=value", i))
+		}
+		require.Equal(t, want, got)
+	}
+	check(d)
+
+	var virtual int
+	for _, level := range d.mu.versions.currentVersion().Levels {
+		iter := level.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if f.Virtual {
+				virtual++
+			}
+		}
+	}
+	require.Equal(t, 3, virtual)
+
+	// The slices survive compactions and reopening the DB.
+	require.NoError(t, d.Compact([]byte("key000"), []byte("key100"), false))
+	check(d)
+	require.NoError(t, d.Close())
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	check(d)
+	require.NoError(t, d.Close())
+
+	// Slices require virtual sstables.
+	opts.FormatMajorVersion = ExperimentalFormatVirtualSSTables - 1
+	d, err = Open("old", opts)
+	require.NoError(t, err)
+	_, err = d.IngestSlices([]IngestSlice{{Path: "ext", Start: []byte("key000"), End: []byte("key010")}})
+	require.Error(t, err)
+	require.NoError(t, d.Close())
+}
+
 func TestIngestMemtableOverlaps(t *testing.T) {
 	comparers := []Comparer{
 		{Name: "default", Compare: DefaultComparer.Compare, FormatKey: DefaultComparer.FormatKey},
@@ -2470,6 +2894,145 @@ func TestIngestStats(t *testing.T) {
 	require.NoError(t, d.Close())
 }
 
+func TestIngestAsBatch(t *testing.T) {
+	for _, disableWAL := range []bool{false, true} {
+		t.Run(fmt.Sprintf("disable-wal=%t", disableWAL), func(t *testing.T) {
+			mem := vfs.NewMem()
+			opts := &Options{FS: mem, DisableWAL: disableWAL}
+			opts.Experimental.IngestAsBatchMaxSize = 1 << 20
+			d, err := Open("", opts)
+			require.NoError(t, err)
+			defer func() {
+				require.NoError(t, d.Close())
+			}()
+
+			require.NoError(t, d.Set([]byte("a"), []byte("old"), NoSync))
+			require.NoError(t, d.Set([]byte("b"), []byte("old"), NoSync))
+
+			write := func(path string, rangeDel [2]string, keys ...string) {
+				t.Helper()
+				f, err := mem.Create(path)
+				require.NoError(t, err)
+				w := sstable.NewWriter(objstorageprovider.NewFileWritable(f), sstable.WriterOptions{})
+				if rangeDel[0] != "" {
+					require.NoError(t, w.DeleteRange([]byte(rangeDel[0]), []byte(rangeDel[1])))
+				}
+				for _, k := range keys {
+					require.NoError(t, w.Set([]byte(k), []byte("new")))
+				}
+				require.NoError(t, w.Close())
+			}
+			get := func(key string) string {
+				t.Helper()
+				v, closer, err := d.Get([]byte(key))
+				if errors.Is(err, ErrNotFound) {
+					return "not found"
+				}
+				require.NoError(t, err)
+				defer closer.Close()
+				return string(v)
+			}
+
+			// The sstable overlaps the memtable, and is applied to it. Its range
+			// deletion deletes the existing key "b", but not the ingested key "c".
+			write("ext", [2]string{"b", "d"}, "a", "c")
+			stats, err := d.IngestWithStats([]string{"ext"})
+			require.NoError(t, err)
+			require.Equal(t, 1, stats.MemtableOverlappingFiles)
+			require.EqualValues(t, 0, stats.ApproxIngestedIntoL0Bytes)
+			require.Less(t, uint64(0), stats.Bytes)
+			require.Equal(t, "new", get("a"))
+			require.Equal(t, "not found", get("b"))
+			require.Equal(t, "new", get("c"))
+			_, err = mem.Stat("ext")
+			require.True(t, oserror.IsNotExist(err))
+
+			m := d.Metrics()
+			require.EqualValues(t, 0, m.Flush.Count)
+			for l := range m.Levels {
+				require.EqualValues(t, 0, m.Levels[l].NumFiles)
+			}
+
+			// The sstable does not overlap the memtable, and is ingested.
+			write("ext", [2]string{}, "x")
+			require.NoError(t, d.Ingest([]string{"ext"}))
+			require.Equal(t, "new", get("x"))
+			require.EqualValues(t, 1, d.Metrics().Levels[numLevels-1].NumFiles)
+		})
+	}
+}
+
+// TestIngestBlockExtensions verifies that tables using block extensions are
+// only ingested by DBs at a format major version that supports them.
+func TestIngestBlockExtensions(t *testing.T) {
+	testCases := []struct {
+		name       string
+		fmv        FormatMajorVersion
+		writerOpts sstable.WriterOptions
+	}{
+		{
+			name:       "xxh3",
+			fmv:        ExperimentalFormatBlockExtensions,
+			writerOpts: sstable.WriterOptions{Checksum: ChecksumTypeXXH3},
+		},
+		{
+			name: "columnar",
+			fmv:  ExperimentalFormatBlockExtensions,
+			writerOpts: sstable.WriterOptions{ColumnarSchema: &ColumnarSchema{
+				Name:    "test",
+				Columns: []sstable.ColumnSpec{{Width: 2, Encoding: sstable.ColumnEncodingDictionary}},
+			}},
+		},
+		{
+			name:       "encrypted",
+			fmv:        ExperimentalFormatBlockExtensions,
+			writerOpts: sstable.WriterOptions{KeyManager: testTableKeyManager{}},
+		},
+		{
+			name: "zstd-dictionary",
+			fmv:  ExperimentalFormatZstdDictionaries,
+			writerOpts: sstable.WriterOptions{
+				Compression:    ZstdCompression,
+				ZstdDictionary: bytes.Repeat([]byte("value"), 100),
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mem := vfs.NewMem()
+			write := func() {
+				f, err := mem.Create("ext")
+				require.NoError(t, err)
+				writerOpts := tc.writerOpts
+				writerOpts.TableFormat = sstable.TableFormatPebblev4
+				w := sstable.NewWriter(objstorageprovider.NewFileWritable(f), writerOpts)
+				require.NoError(t, w.Set([]byte("a"), []byte("va")))
+				require.NoError(t, w.Close())
+			}
+			open := func(fmv FormatMajorVersion) *DB {
+				opts := &Options{FS: mem, FormatMajorVersion: fmv}
+				if fmv >= ExperimentalFormatBlockExtensions {
+					opts.TableKeyManager = testTableKeyManager{}
+				}
+				d, err := Open(fmt.Sprintf("db%d", fmv), opts)
+				require.NoError(t, err)
+				return d
+			}
+
+			d := open(tc.fmv - 1)
+			write()
+			require.Error(t, d.Ingest([]string{"ext"}))
+			require.NoError(t, d.Close())
+
+			d = open(tc.fmv)
+			write()
+			require.NoError(t, d.Ingest([]string{"ext"}))
+			verifyGet(t, d, []byte("a"), []byte("va"))
+			require.NoError(t, d.Close())
+		})
+	}
+}
+
 func TestIngestFlushQueuedLargeBatch(t *testing.T) {
 	// Verify that ingestion forces a flush of a queued large batch.
 
@@ -58,6 +58,7 @@ const (
 	FileTypeOptions
 	FileTypeOldTemp
 	FileTypeTemp
+	FileTypeBlob
 )
 
 // MakeFilename builds a filename from components.
@@ -79,6 +80,8 @@ func MakeFilename(fileType FileType, dfn DiskFileNum) string {
 		return fmt.Sprintf("CURRENT.%s.dbtmp", dfn)
 	case FileTypeTemp:
 		return fmt.Sprintf("temporary.%s.dbtmp", dfn)
+	case FileTypeBlob:
+		return fmt.Sprintf("%s.blob", dfn)
 	}
 	panic("unreachable")
 }
@@ -136,6 +139,8 @@ func ParseFilename(fs vfs.FS, filename string) (fileType FileType, dfn DiskFileN
 			return FileTypeTable, dfn, true
 		case "log":
 			return FileTypeLog, dfn, true
+		case "blob":
+			return FileTypeBlob, dfn, true
 		}
 	}
 	return 0, dfn, false
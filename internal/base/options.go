@@ -64,6 +64,32 @@ type FilterPolicy interface {
 	NewWriter(ftype FilterType) FilterWriter
 }
 
+// PrefixExtractor extracts the prefixes of keys that are added to and looked
+// up in table filters, in place of Comparer.Split. It allows building filters
+// over application-defined prefixes that are shorter than the prefixes
+// returned by Comparer.Split, such as the tenant or table of a key whose
+// Comparer.Split prefix strips an MVCC timestamp.
+//
+// The name is written to sstables that use the extractor. The filters of an
+// sstable written with a different extractor, or with Comparer.Split, are
+// ignored.
+type PrefixExtractor struct {
+	// Name names the prefix extractor.
+	Name string
+
+	// Split returns the length of the prefix of the key that is added to
+	// filters. The prefix must be a byte prefix of the prefix returned by
+	// Comparer.Split, and keys with the same Comparer.Split prefix must have the
+	// same extracted prefix:
+	//
+	//	Split(a) <= Comparer.Split(a)
+	//	Split(a[:Comparer.Split(a)]) == Split(a)
+	//
+	// Additionally, keys with the same extracted prefix must be contiguous in
+	// the ordering of the Comparer.
+	Split Split
+}
+
 // BlockPropertyFilter is used in an Iterator to filter sstables and blocks
 // within the sstable. It should not maintain any per-sstable state, and must
 // be thread-safe.
@@ -0,0 +1,140 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+// Package blob implements blob files, which hold the values that are
+// separated from the sstables referencing them (see
+// docs/RFCS/20231016_blob_files.md).
+//
+// A blob file is a sequence of blocks of concatenated values, followed by an
+// index block and a fixed-size footer:
+//
+//	+---------+---------+-----+-------------+--------+
+//	| block 0 | block 1 | ... | index block | footer |
+//	+---------+---------+-----+-------------+--------+
+//
+// Every block, including the index block, is followed by a 5 byte trailer
+// holding its compression type and the checksum of the block and the
+// compression type, like sstable blocks. The index block holds, for each
+// block, its offset and its length (without the trailer), as fixed-width
+// little-endian integers, so the handle of block N is found at offset
+// N*indexEntryLen. The footer holds the handle of the index block, the format
+// version, a checksum of these fields, and a magic number.
+//
+// A value is addressed by a Handle: the number of the blob file, the number
+// of the block holding the value, and the offset of the value within the
+// uncompressed block. The length of the value is stored by the referencing
+// sstable alongside the handle.
+package blob
+
+import (
+	"encoding/binary"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/crc"
+)
+
+// Compression is the compression algorithm used for the blocks of a blob
+// file.
+type Compression uint8
+
+// The available compression algorithms.
+const (
+	NoCompression Compression = iota
+	SnappyCompression
+)
+
+const (
+	blockTrailerLen = 5
+	indexEntryLen   = 12
+	footerLen       = 32
+	formatVersion   = 1
+	// magic is the last 8 bytes of every blob file.
+	magic = "\xb1\x0b\xf1\x1e\xa5\xe9\x7a\x1c"
+	// defaultBlockSize is the default target size of uncompressed blocks.
+	defaultBlockSize = 64 << 10
+)
+
+// Handle locates a value within the blob files of a DB.
+type Handle struct {
+	FileNum       base.DiskFileNum
+	BlockNum      uint32
+	OffsetInBlock uint32
+}
+
+// MaxHandleLen is the maximum length of an encoded Handle.
+const MaxHandleLen = binary.MaxVarintLen64 + 2*binary.MaxVarintLen32
+
+// Encode appends the encoding of h to dst.
+func (h Handle) Encode(dst []byte) []byte {
+	dst = binary.AppendUvarint(dst, uint64(h.FileNum.FileNum()))
+	dst = binary.AppendUvarint(dst, uint64(h.BlockNum))
+	return binary.AppendUvarint(dst, uint64(h.OffsetInBlock))
+}
+
+// DecodeHandle decodes a Handle encoded by Handle.Encode.
+func DecodeHandle(src []byte) (Handle, error) {
+	fileNum, n := binary.Uvarint(src)
+	if n <= 0 {
+		return Handle{}, errCorruptHandle
+	}
+	src = src[n:]
+	blockNum, n := binary.Uvarint(src)
+	if n <= 0 || blockNum > 1<<32-1 {
+		return Handle{}, errCorruptHandle
+	}
+	src = src[n:]
+	offset, n := binary.Uvarint(src)
+	if n <= 0 || n != len(src) || offset > 1<<32-1 {
+		return Handle{}, errCorruptHandle
+	}
+	return Handle{
+		FileNum:       base.FileNum(fileNum).DiskFileNum(),
+		BlockNum:      uint32(blockNum),
+		OffsetInBlock: uint32(offset),
+	}, nil
+}
+
+// DecodeHandleFileNum returns the number of the blob file referenced by an
+// encoded Handle, without decoding the rest of the handle.
+func DecodeHandleFileNum(src []byte) (base.DiskFileNum, error) {
+	fileNum, n := binary.Uvarint(src)
+	if n <= 0 {
+		return base.DiskFileNum{}, errCorruptHandle
+	}
+	return base.FileNum(fileNum).DiskFileNum(), nil
+}
+
+var errCorruptHandle = base.CorruptionErrorf("pebble: corrupt blob handle")
+
+// blockHandle is the location of a block within a blob file.
+type blockHandle struct {
+	offset uint64
+	length uint64
+}
+
+func encodeFooter(dst []byte, index blockHandle) []byte {
+	start := len(dst)
+	dst = binary.LittleEndian.AppendUint64(dst, index.offset)
+	dst = binary.LittleEndian.AppendUint64(dst, index.length)
+	dst = binary.LittleEndian.AppendUint32(dst, formatVersion)
+	dst = binary.LittleEndian.AppendUint32(dst, crc.New(dst[start:]).Value())
+	return append(dst, magic...)
+}
+
+func decodeFooter(b []byte) (blockHandle, error) {
+	if len(b) != footerLen || string(b[footerLen-len(magic):]) != magic {
+		return blockHandle{}, base.CorruptionErrorf("pebble: invalid blob file (bad magic number)")
+	}
+	if binary.LittleEndian.Uint32(b[20:]) != crc.New(b[:20]).Value() {
+		return blockHandle{}, base.CorruptionErrorf("pebble: invalid blob file (footer checksum mismatch)")
+	}
+	if v := binary.LittleEndian.Uint32(b[16:]); v != formatVersion {
+		return blockHandle{}, errors.Errorf("pebble: unsupported blob file format version %d", errors.Safe(v))
+	}
+	return blockHandle{
+		offset: binary.LittleEndian.Uint64(b),
+		length: binary.LittleEndian.Uint64(b[8:]),
+	}, nil
+}
@@ -0,0 +1,179 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package blob
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/cache"
+	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleEncoding(t *testing.T) {
+	for _, h := range []Handle{
+		{},
+		{FileNum: base.FileNum(7).DiskFileNum(), BlockNum: 3, OffsetInBlock: 100},
+		{FileNum: base.FileNum(1 << 40).DiskFileNum(), BlockNum: 1<<32 - 1, OffsetInBlock: 1<<32 - 1},
+	} {
+		b := h.Encode(nil)
+		require.LessOrEqual(t, len(b), MaxHandleLen)
+		decoded, err := DecodeHandle(b)
+		require.NoError(t, err)
+		require.Equal(t, h, decoded)
+		fileNum, err := DecodeHandleFileNum(b)
+		require.NoError(t, err)
+		require.Equal(t, h.FileNum, fileNum)
+
+		_, err = DecodeHandle(b[:len(b)-1])
+		require.Error(t, err)
+		_, err = DecodeHandle(append(b, 0))
+		require.Error(t, err)
+	}
+}
+
+func TestWriterReader(t *testing.T) {
+	for _, compression := range []Compression{NoCompression, SnappyCompression} {
+		t.Run(fmt.Sprintf("compression=%d", compression), func(t *testing.T) {
+			provider, err := objstorageprovider.Open(objstorageprovider.DefaultSettings(vfs.NewMem(), ""))
+			require.NoError(t, err)
+			defer provider.Close()
+			fileNum := base.FileNum(5).DiskFileNum()
+
+			rng := rand.New(rand.NewSource(1))
+			var values [][]byte
+			var handles []Handle
+			writable, _, err := provider.Create(context.Background(), base.FileTypeBlob, fileNum, objstorage.CreateOptions{})
+			require.NoError(t, err)
+			w := NewWriter(writable, fileNum, WriterOptions{BlockSize: 4 << 10, Compression: compression})
+			var valueSize uint64
+			for i := 0; i < 200; i++ {
+				// Mix compressible and incompressible values, including values
+				// larger than the block size and empty values.
+				v := make([]byte, rng.Intn(10<<10))
+				if i%2 == 0 {
+					rng.Read(v)
+				} else {
+					for j := range v {
+						v[j] = byte(i)
+					}
+				}
+				h, err := w.Add(v)
+				require.NoError(t, err)
+				require.Equal(t, fileNum, h.FileNum)
+				values = append(values, v)
+				handles = append(handles, h)
+				valueSize += uint64(len(v))
+			}
+			estimate := w.EstimatedSize()
+			stats, err := w.Close()
+			require.NoError(t, err)
+			require.Equal(t, valueSize, stats.ValueSize)
+			require.Equal(t, uint64(len(values)), stats.NumValues)
+			if compression == NoCompression {
+				require.Equal(t, estimate, stats.Size)
+			}
+
+			readable, err := provider.OpenForReading(context.Background(), base.FileTypeBlob, fileNum, objstorage.OpenOptions{})
+			require.NoError(t, err)
+			require.Equal(t, int64(stats.Size), readable.Size())
+			c := cache.New(1 << 20)
+			defer c.Unref()
+			r, err := NewReader(context.Background(), readable, fileNum, ReaderOptions{Cache: c})
+			require.NoError(t, err)
+			require.Greater(t, r.NumBlocks(), 1)
+			// Read the values twice, so that the second pass reads the blocks
+			// from the cache.
+			for pass := 0; pass < 2; pass++ {
+				for _, i := range rng.Perm(len(values)) {
+					v, err := r.ReadValue(context.Background(), handles[i], len(values[i]), []byte("prefix"))
+					require.NoError(t, err)
+					require.True(t, bytes.Equal(v, append([]byte("prefix"), values[i]...)))
+				}
+			}
+
+			h := handles[len(handles)-1]
+			_, err = r.ReadValue(context.Background(), h, len(values[len(values)-1])+1<<20, nil)
+			require.Error(t, err)
+			h.BlockNum = uint32(r.NumBlocks())
+			_, err = r.ReadValue(context.Background(), h, 0, nil)
+			require.Error(t, err)
+			require.NoError(t, r.Close())
+		})
+	}
+}
+
+func TestReaderCorruption(t *testing.T) {
+	mem := vfs.NewMem()
+	provider, err := objstorageprovider.Open(objstorageprovider.DefaultSettings(mem, ""))
+	require.NoError(t, err)
+	defer provider.Close()
+	fileNum := base.FileNum(1).DiskFileNum()
+	writable, _, err := provider.Create(context.Background(), base.FileTypeBlob, fileNum, objstorage.CreateOptions{})
+	require.NoError(t, err)
+	w := NewWriter(writable, fileNum, WriterOptions{})
+	h, err := w.Add([]byte("hello world"))
+	require.NoError(t, err)
+	// An empty value is readable even if it's the only value of its block.
+	empty, err := w.Add(nil)
+	require.NoError(t, err)
+	_, err = w.Close()
+	require.NoError(t, err)
+
+	corrupt := func(offset int64) {
+		f, err := mem.OpenReadWrite(base.MakeFilename(base.FileTypeBlob, fileNum))
+		require.NoError(t, err)
+		var b [1]byte
+		_, err = f.ReadAt(b[:], offset)
+		require.NoError(t, err)
+		b[0] ^= 0xff
+		_, err = f.WriteAt(b[:], offset)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+	open := func() (*Reader, error) {
+		readable, err := provider.OpenForReading(context.Background(), base.FileTypeBlob, fileNum, objstorage.OpenOptions{})
+		require.NoError(t, err)
+		return NewReader(context.Background(), readable, fileNum, ReaderOptions{})
+	}
+
+	// A corrupt value block is detected when the value is read.
+	corrupt(0)
+	r, err := open()
+	require.NoError(t, err)
+	_, err = r.ReadValue(context.Background(), h, len("hello world"), nil)
+	require.True(t, errors.Is(err, base.ErrCorruption), "%v", err)
+	require.NoError(t, r.Close())
+	corrupt(0)
+
+	// A corrupt footer is detected when the file is opened.
+	readable, err := provider.OpenForReading(context.Background(), base.FileTypeBlob, fileNum, objstorage.OpenOptions{})
+	require.NoError(t, err)
+	size := readable.Size()
+	require.NoError(t, readable.Close())
+	for _, offset := range []int64{size - 1, size - footerLen} {
+		corrupt(offset)
+		_, err = open()
+		require.True(t, errors.Is(err, base.ErrCorruption), "%v", err)
+		corrupt(offset)
+	}
+	r, err = open()
+	require.NoError(t, err)
+	v, err := r.ReadValue(context.Background(), h, len("hello world"), nil)
+	require.NoError(t, err)
+	require.Equal(t, "hello world", string(v))
+	v, err = r.ReadValue(context.Background(), empty, 0, nil)
+	require.NoError(t, err)
+	require.Len(t, v, 0)
+	require.NoError(t, r.Close())
+}
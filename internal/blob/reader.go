@@ -0,0 +1,156 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package blob
+
+import (
+	"context"
+	"encoding/binary"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/cache"
+	"github.com/cockroachdb/pebble/internal/crc"
+	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/golang/snappy"
+)
+
+// ReaderOptions holds the parameters used to read a blob file.
+type ReaderOptions struct {
+	// Cache is the block cache through which blocks are read. The blocks of
+	// the file are cached under CacheID and the file number of the file.
+	Cache   *cache.Cache
+	CacheID uint64
+}
+
+// Reader reads the values of a blob file. It is safe for concurrent use.
+type Reader struct {
+	readable objstorage.Readable
+	fileNum  base.DiskFileNum
+	opts     ReaderOptions
+	// index holds the index block, which is small enough to be kept in
+	// memory for as long as the file is open.
+	index []byte
+}
+
+// NewReader returns a Reader for the blob file with the given number. The
+// Reader takes ownership of readable, and closes it when it's closed,
+// including if NewReader returns an error.
+func NewReader(
+	ctx context.Context, readable objstorage.Readable, fileNum base.DiskFileNum, opts ReaderOptions,
+) (*Reader, error) {
+	r := &Reader{readable: readable, fileNum: fileNum, opts: opts}
+	if r.opts.Cache == nil {
+		r.opts.Cache = cache.New(0)
+	} else {
+		r.opts.Cache.Ref()
+	}
+	if r.opts.CacheID == 0 {
+		r.opts.CacheID = r.opts.Cache.NewID()
+	}
+	if err := r.init(ctx); err != nil {
+		_ = r.Close()
+		return nil, errors.Wrapf(err, "pebble: blob file %s", fileNum)
+	}
+	return r, nil
+}
+
+func (r *Reader) init(ctx context.Context) error {
+	size := r.readable.Size()
+	if size < footerLen {
+		return base.CorruptionErrorf("pebble: invalid blob file (file size is too small)")
+	}
+	var footer [footerLen]byte
+	if err := r.readable.ReadAt(ctx, footer[:], size-footerLen); err != nil {
+		return err
+	}
+	bh, err := decodeFooter(footer[:])
+	if err != nil {
+		return err
+	}
+	if bh.offset+bh.length+blockTrailerLen+footerLen != uint64(size) || bh.length%indexEntryLen != 0 {
+		return base.CorruptionErrorf("pebble: invalid blob file (bad index block handle)")
+	}
+	r.index, err = r.readBlock(ctx, bh)
+	return err
+}
+
+// Close closes the Reader.
+func (r *Reader) Close() error {
+	r.opts.Cache.Unref()
+	err := r.readable.Close()
+	r.readable = nil
+	return err
+}
+
+// NumBlocks returns the number of value blocks of the file.
+func (r *Reader) NumBlocks() int {
+	return len(r.index) / indexEntryLen
+}
+
+// ReadValue reads the value of length valueLen with the given handle,
+// appending it to buf.
+func (r *Reader) ReadValue(
+	ctx context.Context, h Handle, valueLen int, buf []byte,
+) ([]byte, error) {
+	if h.FileNum != r.fileNum {
+		return nil, errors.AssertionFailedf("pebble: blob handle for file %s used with file %s", h.FileNum, r.fileNum)
+	}
+	if int(h.BlockNum) >= r.NumBlocks() {
+		return nil, base.CorruptionErrorf("pebble: blob handle block %d out of range in blob file %s",
+			errors.Safe(h.BlockNum), r.fileNum)
+	}
+	e := r.index[h.BlockNum*indexEntryLen:]
+	bh := blockHandle{
+		offset: binary.LittleEndian.Uint64(e),
+		length: uint64(binary.LittleEndian.Uint32(e[8:])),
+	}
+	ch := r.opts.Cache.Get(r.opts.CacheID, r.fileNum, bh.offset)
+	block := ch.Get()
+	if block == nil {
+		b, err := r.readBlock(ctx, bh)
+		if err != nil {
+			return nil, err
+		}
+		v := cache.Alloc(len(b))
+		copy(v.Buf(), b)
+		ch = r.opts.Cache.Set(r.opts.CacheID, r.fileNum, bh.offset, v)
+		block = ch.Get()
+	}
+	defer ch.Release()
+	if uint64(h.OffsetInBlock)+uint64(valueLen) > uint64(len(block)) {
+		return nil, base.CorruptionErrorf("pebble: blob handle offset %d and length %d out of range in blob file %s",
+			errors.Safe(h.OffsetInBlock), errors.Safe(valueLen), r.fileNum)
+	}
+	return append(buf, block[h.OffsetInBlock:h.OffsetInBlock+uint32(valueLen)]...), nil
+}
+
+// readBlock reads, verifies and decompresses the block with the given
+// handle.
+func (r *Reader) readBlock(ctx context.Context, bh blockHandle) ([]byte, error) {
+	b := make([]byte, bh.length+blockTrailerLen)
+	if err := r.readable.ReadAt(ctx, b, int64(bh.offset)); err != nil {
+		return nil, err
+	}
+	trailer := b[bh.length:]
+	b = b[:bh.length]
+	if expected, computed := binary.LittleEndian.Uint32(trailer[1:]),
+		crc.New(b).Update(trailer[:1]).Value(); expected != computed {
+		return nil, base.CorruptionErrorf("pebble: checksum mismatch at %d/%d in blob file %s: expected %x, computed %x",
+			errors.Safe(bh.offset), errors.Safe(bh.length), r.fileNum, errors.Safe(expected), errors.Safe(computed))
+	}
+	switch Compression(trailer[0]) {
+	case NoCompression:
+		return b, nil
+	case SnappyCompression:
+		decoded, err := snappy.Decode(nil, b)
+		if err != nil {
+			return nil, base.MarkCorruptionError(err)
+		}
+		return decoded, nil
+	default:
+		return nil, base.CorruptionErrorf("pebble: unknown compression type %d in blob file %s",
+			errors.Safe(trailer[0]), r.fileNum)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package blob
+
+import (
+	"encoding/binary"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/crc"
+	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/golang/snappy"
+)
+
+// WriterOptions holds the parameters used to write a blob file.
+type WriterOptions struct {
+	// BlockSize is the target uncompressed size of the blocks of the file. A
+	// value larger than BlockSize is stored in a block of its own. The
+	// default is 64 KB.
+	BlockSize int
+	// Compression is the compression algorithm used for the blocks. Blocks
+	// that don't shrink by at least 12.5% are stored uncompressed.
+	Compression Compression
+}
+
+// WriterStats describes the blob file written by a Writer.
+type WriterStats struct {
+	// Size is the size of the file, in bytes.
+	Size uint64
+	// ValueSize is the sum of the lengths of the values in the file.
+	ValueSize uint64
+	// NumValues is the number of values in the file.
+	NumValues uint64
+}
+
+// Writer writes a blob file. Values are added with Add, which returns the
+// Handle of the value, and the file is completed with Close.
+type Writer struct {
+	w       objstorage.Writable
+	fileNum base.DiskFileNum
+	opts    WriterOptions
+	block   []byte
+	// blockValues is the number of values in block, which may all be empty.
+	blockValues int
+	buf         []byte
+	index       []byte
+	numBlocks   uint32
+	stats       WriterStats
+	err         error
+}
+
+// NewWriter returns a Writer writing the blob file with the given number to
+// w.
+func NewWriter(w objstorage.Writable, fileNum base.DiskFileNum, opts WriterOptions) *Writer {
+	if opts.BlockSize <= 0 {
+		opts.BlockSize = defaultBlockSize
+	}
+	return &Writer{w: w, fileNum: fileNum, opts: opts}
+}
+
+// Add adds a value to the file, returning its handle.
+func (w *Writer) Add(value []byte) (Handle, error) {
+	if w.err != nil {
+		return Handle{}, w.err
+	}
+	if w.blockValues > 0 && len(w.block)+len(value) > w.opts.BlockSize {
+		if w.err = w.flushBlock(); w.err != nil {
+			return Handle{}, w.err
+		}
+	}
+	h := Handle{
+		FileNum:       w.fileNum,
+		BlockNum:      w.numBlocks,
+		OffsetInBlock: uint32(len(w.block)),
+	}
+	w.block = append(w.block, value...)
+	w.blockValues++
+	w.stats.ValueSize += uint64(len(value))
+	w.stats.NumValues++
+	return h, nil
+}
+
+// EstimatedSize returns the approximate size of the file, were it closed
+// now.
+func (w *Writer) EstimatedSize() uint64 {
+	size := w.stats.Size + uint64(len(w.index)) + blockTrailerLen + footerLen
+	if w.blockValues > 0 {
+		size += uint64(len(w.block)) + blockTrailerLen + indexEntryLen
+	}
+	return size
+}
+
+// Close completes the file, returning its stats. The file isn't durable
+// until the objstorage provider is synced.
+func (w *Writer) Close() (WriterStats, error) {
+	if w.err != nil {
+		w.w.Abort()
+		return WriterStats{}, w.err
+	}
+	if w.blockValues > 0 {
+		if w.err = w.flushBlock(); w.err != nil {
+			w.w.Abort()
+			return WriterStats{}, w.err
+		}
+	}
+	index := blockHandle{offset: w.stats.Size, length: uint64(len(w.index))}
+	if w.err = w.writeBlock(w.index, NoCompression); w.err != nil {
+		w.w.Abort()
+		return WriterStats{}, w.err
+	}
+	footer := encodeFooter(w.buf[:0], index)
+	if w.err = w.w.Write(footer); w.err != nil {
+		w.w.Abort()
+		return WriterStats{}, w.err
+	}
+	w.stats.Size += uint64(len(footer))
+	if w.err = w.w.Finish(); w.err != nil {
+		return WriterStats{}, w.err
+	}
+	w.err = errors.New("pebble: blob writer is closed")
+	return w.stats, nil
+}
+
+// Abort gives up on writing the file.
+func (w *Writer) Abort() {
+	if w.err == nil {
+		w.err = errors.New("pebble: blob writer is aborted")
+	}
+	w.w.Abort()
+}
+
+func (w *Writer) flushBlock() error {
+	w.index = binary.LittleEndian.AppendUint64(w.index, w.stats.Size)
+	compression := w.opts.Compression
+	b := w.block
+	if compression == SnappyCompression {
+		compressed := snappy.Encode(w.buf[:cap(w.buf)], b)
+		if len(compressed) < len(b)-len(b)/8 {
+			b = compressed
+		} else {
+			compression = NoCompression
+		}
+		w.buf = compressed[:0]
+	}
+	w.index = binary.LittleEndian.AppendUint32(w.index, uint32(len(b)))
+	if err := w.writeBlock(b, compression); err != nil {
+		return err
+	}
+	w.block = w.block[:0]
+	w.blockValues = 0
+	w.numBlocks++
+	return nil
+}
+
+// writeBlock writes a block and its trailer.
+func (w *Writer) writeBlock(b []byte, compression Compression) error {
+	var trailer [blockTrailerLen]byte
+	trailer[0] = byte(compression)
+	checksum := crc.New(b).Update(trailer[:1]).Value()
+	binary.LittleEndian.PutUint32(trailer[1:], checksum)
+	// NB: Write may modify the slice it's passed, so the trailer is written
+	// separately from the block.
+	if err := w.w.Write(b); err != nil {
+		return err
+	}
+	if err := w.w.Write(trailer[:]); err != nil {
+		return err
+	}
+	w.stats.Size += uint64(len(b)) + blockTrailerLen
+	return nil
+}
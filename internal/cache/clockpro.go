@@ -28,6 +28,7 @@ import (
 
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/invariants"
+	"github.com/cockroachdb/pebble/vfs"
 )
 
 type fileKey struct {
@@ -74,6 +75,16 @@ func (h Handle) Release() {
 	h.value.release()
 }
 
+// Acquire acquires an additional reference to the cache entry, returning a
+// handle that must be released separately. The value remains valid while any
+// of its handles are held, even if it's evicted from the cache.
+func (h Handle) Acquire() Handle {
+	if h.value != nil {
+		h.value.acquire()
+	}
+	return h
+}
+
 type shard struct {
 	hits   atomic.Int64
 	misses atomic.Int64
@@ -85,6 +96,7 @@ type shard struct {
 	coldTarget   int64
 	blocks       robinHoodMap // fileNum+offset -> block
 	files        robinHoodMap // fileNum -> list of blocks
+	ids          map[uint64]*shardID
 
 	// The blocks and files maps store values in manually managed memory that is
 	// invisible to the Go GC. This is fine for Value and entry objects that are
@@ -93,10 +105,33 @@ type shard struct {
 	// contain a reference to every entry.
 	entries map[*entry]struct{}
 
+	policy Policy
+
+	// The clock hands of the ClockPro policy. They point into a single ring
+	// of all of the entries.
 	handHot  *entry
 	handCold *entry
 	handTest *entry
 
+	// The oldest entries of the queues of the S3FIFO policy. See s3Queue.
+	s3 struct {
+		small *entry
+		main  *entry
+		ghost *entry
+	}
+
+	// highPri holds the state of the high-priority region of the shard. See
+	// Cache.SetHighPriorityRatio and highPriPush.
+	highPri struct {
+		hand *entry
+		// ratio is the portion of maxSize reserved for the region, and
+		// capacity is the resulting number of bytes.
+		ratio    float64
+		capacity int64
+		size     int64
+		count    int64
+	}
+
 	sizeHot  int64
 	sizeCold int64
 	sizeTest int64
@@ -109,6 +144,36 @@ type shard struct {
 	countHot  int64
 	countCold int64
 	countTest int64
+
+	// secondary is the secondary tier of the cache, if any. Values evicted
+	// while the mutex is held are accumulated in demoted, and written to the
+	// secondary cache once it's released.
+	secondary *secondaryCache
+	demoted   []demotedValue
+}
+
+// demotedValue is a value evicted from a shard which is to be written to the
+// secondary cache, if any.
+type demotedValue struct {
+	key   key
+	value *Value
+}
+
+// shardID tracks the blocks of a single ID within a shard. It exists while the
+// ID has blocks in the shard or a quota.
+type shardID struct {
+	// blocks is the oldest of the ID's entries, which are linked in the order
+	// they were added through entry.idLink.
+	blocks *entry
+	// entries is the number of the ID's entries, including test entries.
+	entries int64
+	// size and count are the total size and number of the ID's entries that
+	// hold values.
+	size  int64
+	count int64
+	// quota is the shard's portion of the ID's quota, or 0 if the ID has no
+	// quota. See Cache.SetQuota.
+	quota int64
 }
 
 func (c *shard) Get(id uint64, fileNum base.DiskFileNum, offset uint64) Handle {
@@ -129,26 +194,51 @@ func (c *shard) Get(id uint64, fileNum base.DiskFileNum, offset uint64) Handle {
 	return Handle{value: value}
 }
 
-func (c *shard) Set(id uint64, fileNum base.DiskFileNum, offset uint64, value *Value) Handle {
+func (c *shard) Peek(id uint64, fileNum base.DiskFileNum, offset uint64) Handle {
+	c.mu.RLock()
+	var value *Value
+	if e := c.blocks.Get(key{fileKey{id, fileNum}, offset}); e != nil {
+		value = e.acquireValue()
+	}
+	c.mu.RUnlock()
+	return Handle{value: value}
+}
+
+func (c *shard) Set(
+	id uint64, fileNum base.DiskFileNum, offset uint64, value *Value, pri Priority,
+) Handle {
 	if n := value.refs(); n != 1 {
 		panic(fmt.Sprintf("pebble: Value has already been added to the cache: refs=%d", n))
 	}
 
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlockAndDemote()
 
 	k := key{fileKey{id, fileNum}, offset}
 	e := c.blocks.Get(k)
+	// High-priority values larger than the high-priority region are added to
+	// the main region.
+	highPri := pri == HighPriority && int64(len(value.buf)) <= c.highPri.capacity
 
 	switch {
 	case e == nil:
 		// no cache entry? add it
 		e = newEntry(c, k, int64(len(value.buf)))
+		if highPri {
+			e.ptype = etHighPri
+		}
 		e.setValue(value)
 		if c.metaAdd(k, e) {
-			value.ref.trace("add-cold")
-			c.sizeCold += e.size
-			c.countCold++
+			if highPri {
+				value.ref.trace("add-high-priority")
+				c.highPri.size += e.size
+				c.highPri.count++
+			} else {
+				value.ref.trace("add-cold")
+				c.sizeCold += e.size
+				c.countCold++
+			}
+			c.accountLocked(e, e.size, 1)
 		} else {
 			value.ref.trace("skip-cold")
 			e.free()
@@ -161,10 +251,16 @@ func (c *shard) Set(id uint64, fileNum base.DiskFileNum, offset uint64, value *V
 		e.referenced.Store(true)
 		delta := int64(len(value.buf)) - e.size
 		e.size = int64(len(value.buf))
-		if e.ptype == etHot {
+		c.accountLocked(e, delta, 0)
+		switch e.ptype {
+		case etHot:
 			value.ref.trace("add-hot")
 			c.sizeHot += delta
-		} else {
+		case etHighPri:
+			value.ref.trace("add-high-priority")
+			c.highPri.size += delta
+			c.evictHighPri(0)
+		default:
 			value.ref.trace("add-cold")
 			c.sizeCold += delta
 		}
@@ -178,24 +274,35 @@ func (c *shard) Set(id uint64, fileNum base.DiskFileNum, offset uint64, value *V
 		c.metaCheck(e)
 
 		e.size = int64(len(value.buf))
-		c.coldTarget += e.size
-		if c.coldTarget > c.targetSize() {
-			c.coldTarget = c.targetSize()
-		}
-
 		e.referenced.Store(false)
 		e.setValue(value)
-		e.ptype = etHot
+		if highPri {
+			e.ptype = etHighPri
+		} else {
+			c.coldTarget += e.size
+			if c.coldTarget > c.targetSize() {
+				c.coldTarget = c.targetSize()
+			}
+			e.ptype = etHot
+		}
 		if c.metaAdd(k, e) {
-			value.ref.trace("add-hot")
-			c.sizeHot += e.size
-			c.countHot++
+			if highPri {
+				value.ref.trace("add-high-priority")
+				c.highPri.size += e.size
+				c.highPri.count++
+			} else {
+				value.ref.trace("add-hot")
+				c.sizeHot += e.size
+				c.countHot++
+			}
+			c.accountLocked(e, e.size, 1)
 		} else {
 			value.ref.trace("skip-hot")
 			e.free()
 			e = nil
 		}
 	}
+	c.enforceQuotaLocked(id)
 
 	c.checkConsistency()
 
@@ -204,6 +311,81 @@ func (c *shard) Set(id uint64, fileNum base.DiskFileNum, offset uint64, value *V
 	return Handle{value: value}
 }
 
+// unlockAndDemote releases the mutex, and then queues the values that were
+// evicted while it was held to be written to the secondary cache, if any.
+// Values marked with SetNoSecondary are released instead.
+func (c *shard) unlockAndDemote() {
+	demoted := c.demoted
+	c.demoted = nil
+	c.mu.Unlock()
+	for _, d := range demoted {
+		if c.secondary != nil && !d.value.noSecondary {
+			c.secondary.enqueue(d.key, d.value)
+			continue
+		}
+		d.value.release()
+	}
+}
+
+// accountLocked adjusts the size and count of the blocks holding values of the
+// entry's ID.
+func (c *shard) accountLocked(e *entry, size, count int64) {
+	s := c.ids[e.key.id]
+	s.size += size
+	s.count += count
+}
+
+// enforceQuotaLocked evicts blocks of the specified ID until their size no
+// longer exceeds the ID's quota. The ID's blocks are swept from oldest to
+// newest, and blocks that have been referenced since they were last swept are
+// given a second chance, as in CLOCK. Blocks of other IDs are unaffected, so
+// that an ID that exceeds its quota doesn't evict the working sets of others.
+func (c *shard) enforceQuotaLocked(id uint64) {
+	s := c.ids[id]
+	if s == nil || s.quota == 0 {
+		return
+	}
+	// Two sweeps over the ID's entries suffice, as the first clears all of
+	// the referenced bits.
+	for n := 2 * s.entries; s.size > s.quota && n > 0; n-- {
+		e := s.blocks
+		s.blocks = e.idLink.next
+		if e.peekValue() == nil {
+			// Test entries don't hold values.
+			continue
+		}
+		if e.referenced.Load() {
+			e.referenced.Store(false)
+			continue
+		}
+		k := e.key
+		if v := c.metaEvict(e); v != nil {
+			c.demoted = append(c.demoted, demotedValue{key: k, value: v})
+		}
+	}
+}
+
+// SetQuota sets the shard's portion of the quota of the specified ID.
+func (c *shard) SetQuota(id uint64, quota int64) {
+	c.mu.Lock()
+	defer c.unlockAndDemote()
+	s := c.ids[id]
+	if s == nil {
+		if quota == 0 {
+			return
+		}
+		s = &shardID{}
+		c.ids[id] = s
+	}
+	s.quota = quota
+	if quota == 0 && s.blocks == nil {
+		delete(c.ids, id)
+		return
+	}
+	c.enforceQuotaLocked(id)
+	c.checkConsistency()
+}
+
 func (c *shard) checkConsistency() {
 	// See the comment above the count{Hot,Cold,Test} fields.
 	switch {
@@ -216,6 +398,9 @@ func (c *shard) checkConsistency() {
 		panic(fmt.Sprintf("pebble: mismatch %d cold size, %d cold count", c.sizeCold, c.countCold))
 	case c.sizeTest > 0 && c.countTest == 0:
 		panic(fmt.Sprintf("pebble: mismatch %d test size, %d test count", c.sizeTest, c.countTest))
+	case c.highPri.size < 0 || c.highPri.count < 0:
+		panic(fmt.Sprintf("pebble: unexpected negative: %d (%d bytes) high-priority",
+			c.highPri.count, c.highPri.size))
 	}
 }
 
@@ -308,10 +493,16 @@ func (c *shard) Free() {
 
 	// NB: we use metaDel rather than metaEvict in order to avoid the expensive
 	// metaCheck call when the "invariants" build tag is specified.
-	for c.handHot != nil {
-		e := c.handHot
-		c.metaDel(c.handHot).release()
-		e.free()
+	for _, e := range c.rings() {
+		for e != nil {
+			next := e.next()
+			if next == e {
+				next = nil
+			}
+			c.metaDel(e).release()
+			e.free()
+			e = next
+		}
 	}
 
 	c.blocks.free()
@@ -320,7 +511,7 @@ func (c *shard) Free() {
 
 func (c *shard) Reserve(n int) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlockAndDemote()
 	c.reservedSize += int64(n)
 
 	// Changing c.reservedSize will either increase or decrease
@@ -336,16 +527,30 @@ func (c *shard) Reserve(n int) {
 	c.checkConsistency()
 }
 
+// SetMaxSize sets the maximum size of the shard, evicting entries if the shard
+// is larger than the new size.
+func (c *shard) SetMaxSize(size int64) {
+	c.mu.Lock()
+	defer c.unlockAndDemote()
+	c.maxSize = size
+	// updateHighPriCapacityLocked keeps coldTarget within [0, targetSize] and
+	// evicts entries from both regions.
+	c.updateHighPriCapacityLocked()
+	c.checkConsistency()
+}
+
 // Size returns the current space used by the cache.
 func (c *shard) Size() int64 {
 	c.mu.RLock()
-	size := c.sizeHot + c.sizeCold
+	size := c.sizeHot + c.sizeCold + c.highPri.size
 	c.mu.RUnlock()
 	return size
 }
 
+// targetSize returns the target size of the main region of the shard, which
+// excludes the reserved size and the capacity of the high-priority region.
 func (c *shard) targetSize() int64 {
-	target := c.maxSize - c.reservedSize
+	target := c.maxSize - c.reservedSize - c.highPri.capacity
 	// Always return a positive integer for targetSize. This is so that we don't
 	// end up in an infinite loop in evict(), in cases where reservedSize is
 	// greater than or equal to maxSize.
@@ -358,10 +563,16 @@ func (c *shard) targetSize() int64 {
 // Add the entry to the cache, returning true if the entry was added and false
 // if it would not fit in the cache.
 func (c *shard) metaAdd(key key, e *entry) bool {
-	c.evict()
-	if e.size > c.targetSize() {
-		// The entry is larger than the target cache size.
-		return false
+	if e.ptype == etHighPri {
+		// The caller ensures that high-priority entries fit in the
+		// high-priority region.
+		c.evictHighPri(e.size)
+	} else {
+		c.evict()
+		if e.size > c.targetSize() {
+			// The entry is larger than the target cache size.
+			return false
+		}
 	}
 
 	c.blocks.Put(key, e)
@@ -371,17 +582,23 @@ func (c *shard) metaAdd(key key, e *entry) bool {
 		c.entries[e] = struct{}{}
 	}
 
-	if c.handHot == nil {
-		// first element
-		c.handHot = e
-		c.handCold = e
-		c.handTest = e
+	if e.ptype == etHighPri {
+		c.highPriPush(e)
+	} else if c.policy == S3FIFO {
+		c.s3Push(e)
 	} else {
-		c.handHot.link(e)
-	}
+		if c.handHot == nil {
+			// first element
+			c.handHot = e
+			c.handCold = e
+			c.handTest = e
+		} else {
+			c.handHot.link(e)
+		}
 
-	if c.handCold == c.handHot {
-		c.handCold = c.handCold.prev()
+		if c.handCold == c.handHot {
+			c.handCold = c.handCold.prev()
+		}
 	}
 
 	fkey := key.file()
@@ -390,6 +607,18 @@ func (c *shard) metaAdd(key key, e *entry) bool {
 	} else {
 		fileBlocks.linkFile(e)
 	}
+
+	s := c.ids[key.id]
+	if s == nil {
+		s = &shardID{}
+		c.ids[key.id] = s
+	}
+	if s.blocks == nil {
+		s.blocks = e
+	} else {
+		s.blocks.linkID(e)
+	}
+	s.entries++
 	return true
 }
 
@@ -411,21 +640,27 @@ func (c *shard) metaDel(e *entry) (deletedValue *Value) {
 		delete(c.entries, e)
 	}
 
-	if e == c.handHot {
-		c.handHot = c.handHot.prev()
-	}
-	if e == c.handCold {
-		c.handCold = c.handCold.prev()
-	}
-	if e == c.handTest {
-		c.handTest = c.handTest.prev()
-	}
+	if e.ptype == etHighPri {
+		c.highPriUnlink(e)
+	} else if c.policy == S3FIFO {
+		c.s3Unlink(e)
+	} else {
+		if e == c.handHot {
+			c.handHot = c.handHot.prev()
+		}
+		if e == c.handCold {
+			c.handCold = c.handCold.prev()
+		}
+		if e == c.handTest {
+			c.handTest = c.handTest.prev()
+		}
 
-	if e.unlink() == e {
-		// This was the last entry in the cache.
-		c.handHot = nil
-		c.handCold = nil
-		c.handTest = nil
+		if e.unlink() == e {
+			// This was the last entry in the cache.
+			c.handHot = nil
+			c.handCold = nil
+			c.handTest = nil
+		}
 	}
 
 	fkey := e.key.file()
@@ -434,6 +669,17 @@ func (c *shard) metaDel(e *entry) (deletedValue *Value) {
 	} else {
 		c.files.Put(fkey, next)
 	}
+
+	s := c.ids[e.key.id]
+	s.entries--
+	if next := e.unlinkID(); e == next {
+		s.blocks = nil
+		if s.quota == 0 {
+			delete(c.ids, e.key.id)
+		}
+	} else if s.blocks == e {
+		s.blocks = next
+	}
 	return deletedValue
 }
 
@@ -456,31 +702,42 @@ func (c *shard) metaCheck(e *entry) {
 			os.Exit(1)
 		}
 		// NB: c.hand{Hot,Cold,Test} are pointers into a single linked list. We
-		// only have to traverse one of them to check all of them.
-		var countHot, countCold, countTest int64
-		var sizeHot, sizeCold, sizeTest int64
-		for t := c.handHot.next(); t != nil; t = t.next() {
-			// Recompute count{Hot,Cold,Test} and size{Hot,Cold,Test}.
-			switch t.ptype {
-			case etHot:
-				countHot++
-				sizeHot += t.size
-			case etCold:
-				countCold++
-				sizeCold += t.size
-			case etTest:
-				countTest++
-				sizeTest += t.size
-			}
-			if e == t {
-				fmt.Fprintf(os.Stderr, "%p: %s unexpectedly found in blocks list\n%s",
-					e, e.key, debug.Stack())
-				os.Exit(1)
-			}
-			if t == c.handHot {
-				break
+		// only have to traverse one of them to check all of them. The queues of
+		// the S3FIFO policy are separate lists.
+		var countHot, countCold, countTest, countHighPri int64
+		var sizeHot, sizeCold, sizeTest, sizeHighPri int64
+		for _, head := range c.rings() {
+			for t := head.next(); t != nil; t = t.next() {
+				// Recompute count{Hot,Cold,Test} and size{Hot,Cold,Test}.
+				switch t.ptype {
+				case etHot:
+					countHot++
+					sizeHot += t.size
+				case etCold:
+					countCold++
+					sizeCold += t.size
+				case etTest:
+					countTest++
+					sizeTest += t.size
+				case etHighPri:
+					countHighPri++
+					sizeHighPri += t.size
+				}
+				if e == t {
+					fmt.Fprintf(os.Stderr, "%p: %s unexpectedly found in blocks list\n%s",
+						e, e.key, debug.Stack())
+					os.Exit(1)
+				}
+				if t == head {
+					break
+				}
 			}
 		}
+		if countHighPri != c.highPri.count || sizeHighPri != c.highPri.size {
+			fmt.Fprintf(os.Stderr, "divergence of high-priority statistics: cache's %d, %d, recalculated %d, %d\n%s",
+				c.highPri.count, c.highPri.size, countHighPri, sizeHighPri, debug.Stack())
+			os.Exit(1)
+		}
 		if countHot != c.countHot || countCold != c.countCold || countTest != c.countTest ||
 			sizeHot != c.sizeHot || sizeCold != c.sizeCold || sizeTest != c.sizeTest {
 			fmt.Fprintf(os.Stderr, `divergence of Hot,Cold,Test statistics
@@ -499,12 +756,18 @@ func (c *shard) metaEvict(e *entry) (evictedValue *Value) {
 	case etHot:
 		c.sizeHot -= e.size
 		c.countHot--
+		c.accountLocked(e, -e.size, -1)
 	case etCold:
 		c.sizeCold -= e.size
 		c.countCold--
+		c.accountLocked(e, -e.size, -1)
 	case etTest:
 		c.sizeTest -= e.size
 		c.countTest--
+	case etHighPri:
+		c.highPri.size -= e.size
+		c.highPri.count--
+		c.accountLocked(e, -e.size, -1)
 	}
 	evictedValue = c.metaDel(e)
 	c.metaCheck(e)
@@ -512,7 +775,20 @@ func (c *shard) metaEvict(e *entry) (evictedValue *Value) {
 	return evictedValue
 }
 
+// rings returns an entry of each of the rings of entries of the shard's
+// policy and of its high-priority region.
+func (c *shard) rings() []*entry {
+	if c.policy == S3FIFO {
+		return []*entry{c.s3.small, c.s3.main, c.s3.ghost, c.highPri.hand}
+	}
+	return []*entry{c.handHot, c.highPri.hand}
+}
+
 func (c *shard) evict() {
+	if c.policy == S3FIFO {
+		c.s3Evict()
+		return
+	}
 	for c.targetSize() <= c.sizeHot+c.sizeCold && c.handCold != nil {
 		c.runHandCold(c.countCold, c.sizeCold)
 	}
@@ -539,10 +815,16 @@ func (c *shard) runHandCold(countColdDebug, sizeColdDebug int64) {
 			c.sizeHot += e.size
 			c.countHot++
 		} else {
+			if c.secondary != nil {
+				if v := e.acquireValue(); v != nil {
+					c.demoted = append(c.demoted, demotedValue{key: e.key, value: v})
+				}
+			}
 			e.setValue(nil)
 			e.ptype = etTest
 			c.sizeCold -= e.size
 			c.countCold--
+			c.accountLocked(e, -e.size, -1)
 			c.sizeTest += e.size
 			c.countTest++
 			for c.targetSize() < c.sizeTest && c.handTest != nil {
@@ -622,6 +904,26 @@ type Metrics struct {
 	Hits int64
 	// The number of cache misses.
 	Misses int64
+	// Secondary holds the metrics for the secondary tier of the cache, if
+	// any.
+	Secondary SecondaryMetrics
+	// HighPriority holds the metrics for the high-priority region of the
+	// cache, if any. Its blocks are included in Size and Count.
+	HighPriority HighPriorityMetrics
+}
+
+// SecondaryMetrics holds metrics for the secondary tier of the cache.
+type SecondaryMetrics struct {
+	// The number of bytes of blocks stored in the secondary cache.
+	Size int64
+	// The count of blocks stored in the secondary cache.
+	Count int64
+	// The number of misses in the in-memory cache that were found in the
+	// secondary cache.
+	Hits int64
+	// The number of misses in the in-memory cache that weren't found in the
+	// secondary cache either.
+	Misses int64
 }
 
 // Cache implements Pebble's sharded block cache. The Clock-PRO algorithm is
@@ -629,7 +931,8 @@ type Metrics struct {
 // (http://static.usenix.org/event/usenix05/tech/general/full_papers/jiang/jiang_html/html.html). In
 // order to provide better concurrency, 4 x NumCPUs shards are created, with
 // each shard being given 1/n of the target cache size. The Clock-PRO algorithm
-// is run independently on each shard.
+// is run independently on each shard. Alternatively, NewWithPolicy creates a
+// cache that uses another replacement policy (see Policy).
 //
 // Blocks are keyed by an (id, fileNum, offset) triple. The ID is a namespace
 // for file numbers and allows a single Cache to be shared between multiple
@@ -662,9 +965,21 @@ type Metrics struct {
 // "tracing" produces a significant slowdown, while "invariants" does not.
 type Cache struct {
 	refs    atomic.Int64
-	maxSize int64
+	maxSize atomic.Int64
 	idAlloc atomic.Uint64
-	shards  []shard
+	// resizeMu serializes calls to SetMaxSize.
+	resizeMu sync.Mutex
+	shards   []shard
+	// secondary is the secondary tier of the cache, if any. See
+	// NewWithSecondary.
+	secondary *secondaryCache
+
+	// idCounters holds the hit and miss counters of each ID that has been
+	// looked up. The map is copied on write, since new IDs are rare.
+	idCounters struct {
+		sync.Mutex
+		m atomic.Pointer[map[uint64]*idCounters]
+	}
 
 	// Traces recorded by Cache.trace. Used for debugging.
 	tr struct {
@@ -713,11 +1028,44 @@ func New(size int64) *Cache {
 	return newShards(size, m)
 }
 
+// NewWithPolicy creates a new cache like New, which uses the specified
+// replacement policy.
+func NewWithPolicy(size int64, policy Policy) *Cache {
+	c := New(size)
+	for i := range c.shards {
+		c.shards[i].policy = policy
+	}
+	return c
+}
+
+// NewWithSecondary creates a new cache like New, with a secondary tier of
+// secondarySize bytes stored in the file at path on fs, which is typically on
+// local NVMe. Blocks evicted from the in-memory cache are written to the
+// secondary cache, and blocks missing from the in-memory cache are read from
+// the secondary cache, if present, and re-admitted to the in-memory cache.
+// This improves read latency for working sets larger than the in-memory cache,
+// particularly when sstables reside on remote storage.
+//
+// The file is created, overwriting any existing file, and is removed when the
+// cache's last reference is released. Its contents don't survive restarts.
+func NewWithSecondary(size int64, fs vfs.FS, path string, secondarySize int64) (*Cache, error) {
+	s, err := newSecondaryCache(fs, path, secondarySize)
+	if err != nil {
+		return nil, err
+	}
+	c := New(size)
+	c.secondary = s
+	for i := range c.shards {
+		c.shards[i].secondary = s
+	}
+	return c, nil
+}
+
 func newShards(size int64, shards int) *Cache {
 	c := &Cache{
-		maxSize: size,
-		shards:  make([]shard, shards),
+		shards: make([]shard, shards),
 	}
+	c.maxSize.Store(size)
 	c.refs.Store(1)
 	c.idAlloc.Store(1)
 	c.trace("alloc", c.refs.Load())
@@ -729,6 +1077,7 @@ func newShards(size int64, shards int) *Cache {
 		if entriesGoAllocated {
 			c.shards[i].entries = make(map[*entry]struct{})
 		}
+		c.shards[i].ids = make(map[uint64]*shardID)
 		c.shards[i].blocks.init(16)
 		c.shards[i].files.init(16)
 	}
@@ -801,13 +1150,109 @@ func (c *Cache) Unref() {
 		for i := range c.shards {
 			c.shards[i].Free()
 		}
+		if c.secondary != nil {
+			_ = c.secondary.close()
+		}
 	}
 }
 
 // Get retrieves the cache value for the specified file and offset, returning
-// nil if no value is present.
+// nil if no value is present. If the value isn't present in memory but is
+// present in the secondary cache, it's re-admitted to the cache.
 func (c *Cache) Get(id uint64, fileNum base.DiskFileNum, offset uint64) Handle {
-	return c.getShard(id, fileNum, offset).Get(id, fileNum, offset)
+	s := c.getShard(id, fileNum, offset)
+	h := s.Get(id, fileNum, offset)
+	if h.value == nil && c.secondary != nil {
+		if v := c.secondary.get(key{fileKey{id, fileNum}, offset}); v != nil {
+			h = s.Set(id, fileNum, offset, v, LowPriority)
+		}
+	}
+	if counters := c.getIDCounters(id); h.value != nil {
+		counters.hits.Add(1)
+	} else {
+		counters.misses.Add(1)
+	}
+	return h
+}
+
+// idCounters holds the hit and miss counts of the blocks of an ID.
+type idCounters struct {
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+func (c *Cache) getIDCounters(id uint64) *idCounters {
+	if m := c.idCounters.m.Load(); m != nil {
+		if counters := (*m)[id]; counters != nil {
+			return counters
+		}
+	}
+	c.idCounters.Lock()
+	defer c.idCounters.Unlock()
+	var m map[uint64]*idCounters
+	if old := c.idCounters.m.Load(); old != nil {
+		if counters := (*old)[id]; counters != nil {
+			return counters
+		}
+		m = make(map[uint64]*idCounters, len(*old)+1)
+		for id, counters := range *old {
+			m[id] = counters
+		}
+	} else {
+		m = make(map[uint64]*idCounters)
+	}
+	counters := &idCounters{}
+	m[id] = counters
+	c.idCounters.m.Store(&m)
+	return counters
+}
+
+// SetQuota limits the total size of the blocks of the specified ID in the
+// cache to quota bytes, or removes the ID's quota if quota is 0. When a cache
+// is shared by many DBs, a quota on each DB's ID prevents a DB whose working
+// set is larger than its quota from evicting the blocks of the others: once
+// an ID exceeds its quota, adding its blocks evicts its own least recently
+// used blocks. As with the cache's size, the quota is divided evenly among the
+// cache's shards.
+func (c *Cache) SetQuota(id uint64, quota int64) {
+	if id == 0 {
+		panic("pebble: 0 cache ID is invalid")
+	}
+	var shardQuota int64
+	if quota > 0 {
+		// Round up the per-shard quota so that a quota never disables
+		// caching entirely.
+		shardQuota = (quota + int64(len(c.shards)) - 1) / int64(len(c.shards))
+	}
+	for i := range c.shards {
+		c.shards[i].SetQuota(id, shardQuota)
+	}
+}
+
+// IDMetrics returns the metrics of the blocks of the specified ID in the
+// cache.
+func (c *Cache) IDMetrics(id uint64) Metrics {
+	var m Metrics
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.RLock()
+		if sid := s.ids[id]; sid != nil {
+			m.Count += sid.count
+			m.Size += sid.size
+		}
+		s.mu.RUnlock()
+	}
+	counters := c.getIDCounters(id)
+	m.Hits = counters.hits.Load()
+	m.Misses = counters.misses.Load()
+	return m
+}
+
+// Peek is like Get, but it neither counts as a hit or miss nor marks the value
+// as referenced, so it doesn't affect the cache's metrics or evictions. It's
+// used to inspect the contents of the cache.
+func (c *Cache) Peek(id uint64, fileNum base.DiskFileNum, offset uint64) Handle {
+	return c.getShard(id, fileNum, offset).Peek(id, fileNum, offset)
 }
 
 // Set sets the cache value for the specified file and offset, overwriting an
@@ -815,12 +1260,42 @@ func (c *Cache) Get(id uint64, fileNum base.DiskFileNum, offset uint64) Handle {
 // retrieval of the cached value than Get (lock-free and avoidance of the map
 // lookup). The value must have been allocated by Cache.Alloc.
 func (c *Cache) Set(id uint64, fileNum base.DiskFileNum, offset uint64, value *Value) Handle {
-	return c.getShard(id, fileNum, offset).Set(id, fileNum, offset, value)
+	return c.getShard(id, fileNum, offset).Set(id, fileNum, offset, value, LowPriority)
+}
+
+// SetWithPriority is like Set, but adds the value with the specified
+// priority. See SetHighPriorityRatio.
+func (c *Cache) SetWithPriority(
+	id uint64, fileNum base.DiskFileNum, offset uint64, value *Value, pri Priority,
+) Handle {
+	return c.getShard(id, fileNum, offset).Set(id, fileNum, offset, value, pri)
+}
+
+// SetHighPriorityRatio reserves the specified portion of the cache, between 0
+// and 1, for blocks added with HighPriority, such as index and filter blocks.
+// High-priority blocks are never evicted by blocks added with LowPriority, so
+// that scans of data blocks can't evict the index and filter blocks needed by
+// every read. Once the reserved region is full, high-priority blocks are
+// evicted to make room for each other as in CLOCK. High-priority blocks larger
+// than the region are added with low priority. The reserved region isn't
+// available to low-priority blocks even when it isn't full; the HighPriority
+// metrics show its utilization. A ratio of 0, the default, disables the
+// region.
+func (c *Cache) SetHighPriorityRatio(ratio float64) {
+	if ratio < 0 || ratio > 1 {
+		panic(fmt.Sprintf("pebble: invalid high-priority ratio: %f", ratio))
+	}
+	for i := range c.shards {
+		c.shards[i].SetHighPriorityRatio(ratio)
+	}
 }
 
 // Delete deletes the cached value for the specified file and offset.
 func (c *Cache) Delete(id uint64, fileNum base.DiskFileNum, offset uint64) {
 	c.getShard(id, fileNum, offset).Delete(id, fileNum, offset)
+	if c.secondary != nil {
+		c.secondary.delete(key{fileKey{id, fileNum}, offset})
+	}
 }
 
 // EvictFile evicts all of the cache values for the specified file.
@@ -831,11 +1306,28 @@ func (c *Cache) EvictFile(id uint64, fileNum base.DiskFileNum) {
 	for i := range c.shards {
 		c.shards[i].EvictFile(id, fileNum)
 	}
+	if c.secondary != nil {
+		c.secondary.evictFile(fileKey{id, fileNum})
+	}
 }
 
 // MaxSize returns the max size of the cache.
 func (c *Cache) MaxSize() int64 {
-	return c.maxSize
+	return c.maxSize.Load()
+}
+
+// SetMaxSize sets the max size of the cache, which may be used to rebalance
+// memory between the cache and other uses while the cache is in use. If the
+// cache is larger than the new size, blocks are evicted until it fits. The
+// shards of the cache are resized one at a time, so that a shrinking cache
+// only blocks operations on the shard that is evicting blocks.
+func (c *Cache) SetMaxSize(size int64) {
+	c.resizeMu.Lock()
+	defer c.resizeMu.Unlock()
+	c.maxSize.Store(size)
+	for i := range c.shards {
+		c.shards[i].SetMaxSize(size / int64(len(c.shards)))
+	}
 }
 
 // Size returns the current space used by the cache.
@@ -894,11 +1386,17 @@ func (c *Cache) Metrics() Metrics {
 		s := &c.shards[i]
 		s.mu.RLock()
 		m.Count += int64(s.blocks.Count())
-		m.Size += s.sizeHot + s.sizeCold
+		m.Size += s.sizeHot + s.sizeCold + s.highPri.size
+		m.HighPriority.Capacity += s.highPri.capacity
+		m.HighPriority.Size += s.highPri.size
+		m.HighPriority.Count += s.highPri.count
 		s.mu.RUnlock()
 		m.Hits += s.hits.Load()
 		m.Misses += s.misses.Load()
 	}
+	if c.secondary != nil {
+		m.Secondary = c.secondary.metrics()
+	}
 	return m
 }
 
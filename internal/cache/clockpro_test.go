@@ -96,6 +96,28 @@ func TestCacheDelete(t *testing.T) {
 	}
 }
 
+func TestCachePeekAndAcquire(t *testing.T) {
+	cache := newShards(100, 1)
+	defer cache.Unref()
+
+	cache.Set(1, base.FileNum(0).DiskFileNum(), 0, testValue(cache, "a", 5)).Release()
+	// Peeking doesn't count as a hit or miss.
+	h := cache.Peek(1, base.FileNum(0).DiskFileNum(), 0)
+	require.Equal(t, "a", string(h.Get()[:1]))
+	require.Nil(t, cache.Peek(1, base.FileNum(1).DiskFileNum(), 0).Get())
+	m := cache.Metrics()
+	require.Equal(t, int64(0), m.Hits)
+	require.Equal(t, int64(0), m.Misses)
+
+	// An acquired handle remains valid after its value is evicted.
+	h2 := h.Acquire()
+	h.Release()
+	cache.EvictFile(1, base.FileNum(0).DiskFileNum())
+	require.Equal(t, int64(0), cache.Size())
+	require.Equal(t, "a", string(h2.Get()[:1]))
+	h2.Release()
+}
+
 func TestEvictFile(t *testing.T) {
 	cache := newShards(100, 1)
 	defer cache.Unref()
@@ -157,6 +179,56 @@ func TestMultipleDBs(t *testing.T) {
 	}
 }
 
+func TestQuota(t *testing.T) {
+	cache := newShards(100, 1)
+	defer cache.Unref()
+
+	// ID 1's working set fits in the cache.
+	for i := 0; i < 5; i++ {
+		cache.Set(1, base.FileNum(i).DiskFileNum(), 0, testValue(cache, "a", 5)).Release()
+	}
+	// ID 2 scans through far more blocks than fit in the cache, but is limited
+	// to its quota, so it doesn't evict ID 1's blocks.
+	cache.SetQuota(2, 20)
+	for i := 0; i < 100; i++ {
+		cache.Set(2, base.FileNum(i).DiskFileNum(), 0, testValue(cache, "b", 5)).Release()
+		require.LessOrEqual(t, cache.IDMetrics(2).Size, int64(20))
+	}
+	for i := 0; i < 5; i++ {
+		h := cache.Get(1, base.FileNum(i).DiskFileNum(), 0)
+		require.Equal(t, "aaaaa", string(h.Get()))
+		h.Release()
+	}
+	m1, m2 := cache.IDMetrics(1), cache.IDMetrics(2)
+	require.Equal(t, Metrics{Size: 25, Count: 5, Hits: 5}, m1)
+	require.Equal(t, int64(20), m2.Size)
+	require.Equal(t, int64(4), m2.Count)
+
+	// The most recent blocks of ID 2 are retained, and referenced blocks are
+	// given a second chance.
+	h := cache.Get(2, base.FileNum(96).DiskFileNum(), 0)
+	require.Equal(t, "bbbbb", string(h.Get()))
+	h.Release()
+	cache.Set(2, base.FileNum(100).DiskFileNum(), 0, testValue(cache, "b", 5)).Release()
+	h = cache.Get(2, base.FileNum(96).DiskFileNum(), 0)
+	require.Equal(t, "bbbbb", string(h.Get()))
+	h.Release()
+	require.Nil(t, cache.Get(2, base.FileNum(97).DiskFileNum(), 0).Get())
+	m2 = cache.IDMetrics(2)
+	require.Equal(t, int64(2), m2.Hits)
+	require.Equal(t, int64(1), m2.Misses)
+
+	// Lowering the quota evicts blocks immediately, and removing it allows the
+	// ID to use the rest of the cache.
+	cache.SetQuota(2, 10)
+	require.Equal(t, int64(10), cache.IDMetrics(2).Size)
+	cache.SetQuota(2, 0)
+	for i := 0; i < 10; i++ {
+		cache.Set(2, base.FileNum(200+i).DiskFileNum(), 0, testValue(cache, "b", 5)).Release()
+	}
+	require.Greater(t, cache.IDMetrics(2).Size, int64(20))
+}
+
 func TestZeroSize(t *testing.T) {
 	cache := newShards(0, 1)
 	defer cache.Unref()
@@ -185,6 +257,41 @@ func TestReserve(t *testing.T) {
 	require.EqualValues(t, 4, cache.Size())
 }
 
+func TestSetMaxSize(t *testing.T) {
+	for _, policy := range []Policy{ClockPro, S3FIFO} {
+		t.Run(policy.String(), func(t *testing.T) {
+			cache := newShards(100, 2)
+			defer cache.Unref()
+			for i := range cache.shards {
+				cache.shards[i].policy = policy
+			}
+
+			for i := 0; i < 200; i++ {
+				cache.Set(1, base.FileNum(i).DiskFileNum(), 0, testValue(cache, "a", 1)).Release()
+			}
+			require.EqualValues(t, 100, cache.MaxSize())
+			require.LessOrEqual(t, cache.Size(), int64(100))
+			require.Greater(t, cache.Size(), int64(50))
+
+			// Shrinking the cache evicts blocks until it fits.
+			cache.SetMaxSize(20)
+			require.EqualValues(t, 20, cache.MaxSize())
+			require.LessOrEqual(t, cache.Size(), int64(20))
+			for i := 0; i < 200; i++ {
+				cache.Set(1, base.FileNum(i).DiskFileNum(), 0, testValue(cache, "a", 1)).Release()
+			}
+			require.LessOrEqual(t, cache.Size(), int64(20))
+
+			// Growing the cache allows it to hold more blocks again.
+			cache.SetMaxSize(100)
+			for i := 0; i < 200; i++ {
+				cache.Set(1, base.FileNum(i).DiskFileNum(), 0, testValue(cache, "a", 1)).Release()
+			}
+			require.Greater(t, cache.Size(), int64(50))
+		})
+	}
+}
+
 func TestReserveDoubleRelease(t *testing.T) {
 	cache := newShards(100, 1)
 	defer cache.Unref()
@@ -12,6 +12,8 @@ const (
 	etTest entryType = iota
 	etCold
 	etHot
+	// etHighPri entries are in the high-priority region of the shard.
+	etHighPri
 )
 
 func (p entryType) String() string {
@@ -22,6 +24,8 @@ func (p entryType) String() string {
 		return "cold"
 	case etHot:
 		return "hot"
+	case etHighPri:
+		return "high-priority"
 	}
 	return "unknown"
 }
@@ -54,6 +58,10 @@ type entry struct {
 		next *entry
 		prev *entry
 	}
+	idLink struct {
+		next *entry
+		prev *entry
+	}
 	size  int64
 	ptype entryType
 	// referenced is atomically set to indicate that this entry has been accessed
@@ -77,6 +85,8 @@ func newEntry(s *shard, key key, size int64) *entry {
 	e.blockLink.prev = e
 	e.fileLink.next = e
 	e.fileLink.prev = e
+	e.idLink.next = e
+	e.idLink.prev = e
 	e.ref.init(1)
 	return e
 }
@@ -133,6 +143,22 @@ func (e *entry) unlinkFile() *entry {
 	return next
 }
 
+func (e *entry) linkID(s *entry) {
+	s.idLink.prev = e.idLink.prev
+	s.idLink.prev.idLink.next = s
+	s.idLink.next = e
+	s.idLink.next.idLink.prev = s
+}
+
+func (e *entry) unlinkID() *entry {
+	next := e.idLink.next
+	e.idLink.prev.idLink.next = e.idLink.next
+	e.idLink.next.idLink.prev = e.idLink.prev
+	e.idLink.prev = e
+	e.idLink.next = e
+	return next
+}
+
 func (e *entry) setValue(v *Value) {
 	if v != nil {
 		v.acquire()
@@ -0,0 +1,110 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package cache
+
+import "fmt"
+
+// Priority is the priority with which a block is added to the cache. See
+// Cache.SetHighPriorityRatio.
+type Priority int8
+
+const (
+	// LowPriority blocks are cached in the main region of the cache, and are
+	// evicted by its replacement policy.
+	LowPriority Priority = iota
+	// HighPriority blocks are cached in the high-priority region of the cache,
+	// if it has one, where they're only evicted to make room for other
+	// high-priority blocks. Pebble adds index and filter blocks with high
+	// priority.
+	HighPriority
+)
+
+func (p Priority) String() string {
+	switch p {
+	case LowPriority:
+		return "low"
+	case HighPriority:
+		return "high"
+	default:
+		return fmt.Sprintf("Priority(%d)", int8(p))
+	}
+}
+
+// HighPriorityMetrics holds metrics for the high-priority region of the cache.
+type HighPriorityMetrics struct {
+	// The number of bytes reserved for high-priority blocks.
+	Capacity int64
+	// The number of bytes in use by high-priority blocks.
+	Size int64
+	// The count of high-priority blocks.
+	Count int64
+}
+
+// The entries of the high-priority region have the etHighPri type, and are
+// linked through entry.blockLink into a ring separate from the entries of the
+// shard's replacement policy. The region is managed with CLOCK: highPri.hand
+// points at the oldest entry, and entries that have been referenced since the
+// hand last swept them are given a second chance.
+
+// highPriPush adds the entry to the tail of the high-priority ring.
+func (c *shard) highPriPush(e *entry) {
+	if c.highPri.hand == nil {
+		c.highPri.hand = e
+	} else {
+		c.highPri.hand.link(e)
+	}
+}
+
+// highPriUnlink removes the entry from the high-priority ring.
+func (c *shard) highPriUnlink(e *entry) {
+	if next := e.unlink(); next == e {
+		c.highPri.hand = nil
+	} else if c.highPri.hand == e {
+		c.highPri.hand = next
+	}
+}
+
+// evictHighPri evicts entries of the high-priority region until n more bytes
+// fit within its capacity.
+func (c *shard) evictHighPri(n int64) {
+	for c.highPri.size+n > c.highPri.capacity && c.highPri.hand != nil {
+		e := c.highPri.hand
+		if e.referenced.Load() {
+			e.referenced.Store(false)
+			c.highPri.hand = e.next()
+			continue
+		}
+		k := e.key
+		if v := c.metaEvict(e); v != nil {
+			c.demoted = append(c.demoted, demotedValue{key: k, value: v})
+		}
+	}
+}
+
+// updateHighPriCapacityLocked recomputes the capacity of the high-priority
+// region after the shard's size or the ratio changed, evicting entries from
+// both regions as necessary.
+func (c *shard) updateHighPriCapacityLocked() {
+	c.highPri.capacity = int64(float64(c.maxSize) * c.highPri.ratio)
+	c.evictHighPri(0)
+
+	// The capacity of the high-priority region is excluded from targetSize.
+	// As in Reserve, keep coldTarget within [0, targetSize].
+	targetSize := c.targetSize()
+	if c.coldTarget > targetSize {
+		c.coldTarget = targetSize
+	}
+	c.evict()
+}
+
+// SetHighPriorityRatio sets the portion of the shard reserved for
+// high-priority entries.
+func (c *shard) SetHighPriorityRatio(ratio float64) {
+	c.mu.Lock()
+	defer c.unlockAndDemote()
+	c.highPri.ratio = ratio
+	c.updateHighPriCapacityLocked()
+	c.checkConsistency()
+}
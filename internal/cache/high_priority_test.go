@@ -0,0 +1,114 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/rand"
+)
+
+func TestHighPriority(t *testing.T) {
+	for _, policy := range []Policy{ClockPro, S3FIFO} {
+		t.Run(policy.String(), func(t *testing.T) {
+			cache := newShards(100, 1)
+			defer cache.Unref()
+			cache.shards[0].policy = policy
+			cache.SetHighPriorityRatio(0.5)
+
+			get := func(fileNum int) bool {
+				h := cache.Get(1, base.FileNum(fileNum).DiskFileNum(), 0)
+				defer h.Release()
+				return h.Get() != nil
+			}
+			set := func(fileNum int, size int, pri Priority) {
+				v := testValue(cache, "a", size)
+				cache.SetWithPriority(1, base.FileNum(fileNum).DiskFileNum(), 0, v, pri).Release()
+			}
+
+			// Low-priority blocks never evict high-priority blocks.
+			for i := 0; i < 10; i++ {
+				set(i, 5, HighPriority)
+			}
+			for i := 100; i < 1000; i++ {
+				set(i, 1, LowPriority)
+			}
+			for i := 0; i < 10; i++ {
+				require.True(t, get(i), "block %d", i)
+			}
+			m := cache.Metrics()
+			require.Equal(t, HighPriorityMetrics{Capacity: 50, Size: 50, Count: 10}, m.HighPriority)
+			require.LessOrEqual(t, m.Size, int64(100))
+
+			// Once the region is full, high-priority blocks evict the
+			// high-priority blocks that weren't referenced since they were last
+			// swept. All of the blocks were referenced above, so block 0 is
+			// evicted after the first sweep.
+			set(10, 5, HighPriority)
+			require.False(t, get(0))
+			require.True(t, get(10))
+			require.Equal(t, int64(50), cache.Metrics().HighPriority.Size)
+
+			// High-priority blocks larger than the region are added with low
+			// priority.
+			set(11, 60, HighPriority)
+			require.Equal(t, int64(10), cache.Metrics().HighPriority.Count)
+
+			// Disabling the region evicts its blocks.
+			cache.SetHighPriorityRatio(0)
+			require.Equal(t, HighPriorityMetrics{}, cache.Metrics().HighPriority)
+			require.False(t, get(10))
+		})
+	}
+}
+
+func TestHighPriorityRandomized(t *testing.T) {
+	seed := uint64(time.Now().UnixNano())
+	t.Logf("seed: %d", seed)
+	rng := rand.New(rand.NewSource(seed))
+
+	for _, policy := range []Policy{ClockPro, S3FIFO} {
+		t.Run(policy.String(), func(t *testing.T) {
+			cache := newShards(1000, 2)
+			defer cache.Unref()
+			for i := range cache.shards {
+				cache.shards[i].policy = policy
+			}
+			cache.SetHighPriorityRatio(0.3)
+			cache.SetQuota(2, 300)
+
+			for i := 0; i < 20000; i++ {
+				id := uint64(1 + rng.Intn(2))
+				fileNum := base.FileNum(rng.Intn(50)).DiskFileNum()
+				offset := uint64(rng.Intn(20))
+				switch n := rng.Intn(100); {
+				case n < 50:
+					h := cache.Get(id, fileNum, offset)
+					if v := h.Get(); v != nil {
+						require.Equal(t, byte(offset), v[0])
+					}
+					h.Release()
+				case n < 90:
+					v := testValue(cache, string([]byte{byte(offset)}), 1+rng.Intn(30))
+					pri := Priority(rng.Intn(2))
+					cache.SetWithPriority(id, fileNum, offset, v, pri).Release()
+				case n < 98:
+					cache.Delete(id, fileNum, offset)
+				case n < 99:
+					cache.EvictFile(id, fileNum)
+				default:
+					cache.SetHighPriorityRatio(float64(rng.Intn(5)) / 10)
+				}
+				m := cache.Metrics()
+				require.LessOrEqual(t, m.HighPriority.Size, m.HighPriority.Capacity)
+				require.LessOrEqual(t, m.Size, int64(1000+2*30))
+				require.LessOrEqual(t, cache.IDMetrics(2).Size, int64(300))
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package cache
+
+import "fmt"
+
+// Policy is a replacement policy of the cache, which decides which blocks are
+// evicted when the cache is full.
+type Policy int8
+
+const (
+	// ClockPro is the CLOCK-Pro replacement policy, which is the default. It
+	// adapts the sizes of its hot and cold sets to the workload, and is
+	// resistant to scans of blocks that are accessed once.
+	ClockPro Policy = iota
+	// S3FIFO is the S3-FIFO replacement policy
+	// (https://dl.acm.org/doi/10.1145/3600006.3613147). New blocks are admitted
+	// to a small FIFO queue holding 10% of the cache, and are promoted to the
+	// main queue only if they're accessed again before they're evicted from
+	// it. The keys of blocks evicted from the small queue are remembered in a
+	// ghost queue, and blocks found in it are admitted directly to the main
+	// queue. Blocks in the main queue are evicted as in CLOCK. Blocks read once
+	// by large scans are quickly evicted from the small queue, so S3-FIFO
+	// protects the working set of point lookups from scans.
+	S3FIFO
+)
+
+func (p Policy) String() string {
+	switch p {
+	case ClockPro:
+		return "clockpro"
+	case S3FIFO:
+		return "s3fifo"
+	default:
+		return fmt.Sprintf("Policy(%d)", int8(p))
+	}
+}
+
+// s3FIFOSmallRatio is the portion of a shard's target size reserved for the
+// small queue of the S3-FIFO policy.
+const s3FIFOSmallRatio = 10
+
+// The S3-FIFO policy reuses the entry types and the size and count fields of
+// the CLOCK-Pro policy: entries in the small queue are etCold, entries in the
+// main queue are etHot, and entries in the ghost queue are etTest. Each queue
+// is a ring linked through entry.blockLink, and s3Queue returns the oldest
+// entry of the queue of the given type.
+func (c *shard) s3Queue(ptype entryType) **entry {
+	switch ptype {
+	case etCold:
+		return &c.s3.small
+	case etHot:
+		return &c.s3.main
+	default:
+		return &c.s3.ghost
+	}
+}
+
+// s3Push adds the entry to the tail of the queue of its type.
+func (c *shard) s3Push(e *entry) {
+	q := c.s3Queue(e.ptype)
+	if *q == nil {
+		*q = e
+	} else {
+		(*q).link(e)
+	}
+}
+
+// s3Unlink removes the entry from the queue of its type.
+func (c *shard) s3Unlink(e *entry) {
+	q := c.s3Queue(e.ptype)
+	if next := e.unlink(); next == e {
+		*q = nil
+	} else if *q == e {
+		*q = next
+	}
+}
+
+// s3Evict evicts entries until the size of the shard is below its target.
+func (c *shard) s3Evict() {
+	for c.targetSize() <= c.sizeHot+c.sizeCold && (c.s3.small != nil || c.s3.main != nil) {
+		smallTarget := c.targetSize() / s3FIFOSmallRatio
+		if c.s3.small != nil && (c.sizeCold >= smallTarget || c.s3.main == nil) {
+			c.s3EvictSmall()
+		} else {
+			c.s3EvictMain()
+		}
+	}
+}
+
+// s3EvictSmall removes the oldest entry of the small queue, moving it to the
+// main queue if it has been referenced, and to the ghost queue otherwise.
+func (c *shard) s3EvictSmall() {
+	e := c.s3.small
+	c.s3Unlink(e)
+	c.sizeCold -= e.size
+	c.countCold--
+	if e.referenced.Load() {
+		e.referenced.Store(false)
+		e.ptype = etHot
+		c.sizeHot += e.size
+		c.countHot++
+		c.s3Push(e)
+		return
+	}
+
+	if v := e.acquireValue(); v != nil {
+		c.demoted = append(c.demoted, demotedValue{key: e.key, value: v})
+	}
+	e.setValue(nil)
+	c.accountLocked(e, -e.size, -1)
+	e.ptype = etTest
+	c.sizeTest += e.size
+	c.countTest++
+	c.s3Push(e)
+
+	// The ghost queue remembers as many bytes of blocks as the main queue may
+	// hold.
+	mainTarget := c.targetSize() - c.targetSize()/s3FIFOSmallRatio
+	for c.sizeTest > mainTarget && c.s3.ghost != nil {
+		g := c.s3.ghost
+		c.sizeTest -= g.size
+		c.countTest--
+		c.metaDel(g).release()
+		c.metaCheck(g)
+		g.free()
+	}
+}
+
+// s3EvictMain evicts the oldest entry of the main queue, unless it has been
+// referenced, in which case it's moved to the tail of the queue.
+func (c *shard) s3EvictMain() {
+	e := c.s3.main
+	if e.referenced.Load() {
+		e.referenced.Store(false)
+		c.s3.main = e.next()
+		return
+	}
+	k := e.key
+	if v := c.metaEvict(e); v != nil {
+		c.demoted = append(c.demoted, demotedValue{key: k, value: v})
+	}
+}
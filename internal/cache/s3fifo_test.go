@@ -0,0 +1,96 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/rand"
+)
+
+func newS3FIFOShards(size int64, shards int) *Cache {
+	c := newShards(size, shards)
+	for i := range c.shards {
+		c.shards[i].policy = S3FIFO
+	}
+	return c
+}
+
+func TestS3FIFO(t *testing.T) {
+	cache := newS3FIFOShards(100, 1)
+	defer cache.Unref()
+
+	get := func(fileNum int) bool {
+		h := cache.Get(1, base.FileNum(fileNum).DiskFileNum(), 0)
+		defer h.Release()
+		return h.Get() != nil
+	}
+	set := func(fileNum int) {
+		cache.Set(1, base.FileNum(fileNum).DiskFileNum(), 0, testValue(cache, "a", 1)).Release()
+	}
+
+	// Blocks accessed again while in the small queue are promoted to the main
+	// queue.
+	for i := 0; i < 50; i++ {
+		set(i)
+		require.True(t, get(i))
+	}
+	// A scan of blocks that are accessed once only cycles through the small
+	// queue, and doesn't evict the blocks in the main queue.
+	for i := 1000; i < 2000; i++ {
+		set(i)
+	}
+	for i := 0; i < 50; i++ {
+		require.True(t, get(i), "block %d", i)
+	}
+	require.LessOrEqual(t, cache.Size(), int64(100))
+
+	// A block recently evicted from the small queue is remembered by the ghost
+	// queue, and is admitted directly to the main queue when it's added again.
+	require.False(t, get(1920))
+	set(1920)
+	for i := 2000; i < 3000; i++ {
+		set(i)
+	}
+	require.True(t, get(1920))
+}
+
+func TestS3FIFORandomized(t *testing.T) {
+	seed := uint64(time.Now().UnixNano())
+	t.Logf("seed: %d", seed)
+	rng := rand.New(rand.NewSource(seed))
+
+	cache := newS3FIFOShards(1000, 2)
+	defer cache.Unref()
+	cache.SetQuota(2, 300)
+
+	for i := 0; i < 20000; i++ {
+		id := uint64(1 + rng.Intn(2))
+		fileNum := base.FileNum(rng.Intn(50)).DiskFileNum()
+		offset := uint64(rng.Intn(20))
+		switch n := rng.Intn(100); {
+		case n < 50:
+			h := cache.Get(id, fileNum, offset)
+			if v := h.Get(); v != nil {
+				require.Equal(t, byte(offset), v[0])
+			}
+			h.Release()
+		case n < 90:
+			v := testValue(cache, string([]byte{byte(offset)}), 1+rng.Intn(30))
+			cache.Set(id, fileNum, offset, v).Release()
+		case n < 98:
+			cache.Delete(id, fileNum, offset)
+		default:
+			cache.EvictFile(id, fileNum)
+		}
+		// As with CLOCK-Pro, a shard may exceed its target size by the size of
+		// the block added last.
+		require.LessOrEqual(t, cache.Size(), int64(1000+2*30))
+		require.LessOrEqual(t, cache.IDMetrics(2).Size, int64(300))
+	}
+}
@@ -0,0 +1,329 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/internal/crc"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// secondaryBlock describes a block stored in the secondary cache.
+type secondaryBlock struct {
+	// offset is the offset of the block within the secondary cache's file.
+	offset   int64
+	length   int32
+	checksum uint32
+	// gen is the generation of the slot the block occupies. Each slot is
+	// assigned a new generation when it's allocated, so a reader can detect
+	// that the slot was reallocated, and possibly overwritten, while it was
+	// reading it.
+	gen uint64
+	// written is set once the block has been written to the slot.
+	written bool
+}
+
+// secondaryWriteQueueLen is the maximum number of blocks waiting to be written
+// to the secondary cache. Blocks demoted while the queue is full are dropped.
+const secondaryWriteQueueLen = 256
+
+// secondaryCache is a bounded, file-backed tier of the block cache, intended
+// to be placed on local NVMe. Blocks evicted from the in-memory cache are
+// written to it, and a block missing from the in-memory cache that is found in
+// the secondary cache is read from it and re-admitted to the in-memory cache.
+//
+// The file is used as a ring buffer: blocks are appended at the head, wrapping
+// around to the start of the file when they don't fit before its end, and the
+// oldest blocks are overwritten to make room for new ones. Blocks are written
+// by a background goroutine, so that evictions from the in-memory cache don't
+// wait on the file, and the mutex is never held across file I/O. A block
+// whose slot was reallocated while it was being read, or whose checksum
+// doesn't match, is treated as a miss. The contents of the secondary cache
+// don't survive the cache being closed.
+type secondaryCache struct {
+	fs   vfs.FS
+	path string
+	file vfs.File
+	size int64
+
+	hits   atomic.Int64
+	misses atomic.Int64
+
+	// pending holds the blocks waiting to be written by the writer goroutine.
+	pending chan demotedValue
+	// inflight counts the blocks that have been queued but not yet written.
+	inflight sync.WaitGroup
+	done     chan struct{}
+	stopped  sync.WaitGroup
+
+	mu struct {
+		sync.Mutex
+		// head is the offset in the file at which the next block is written.
+		head int64
+		// gen is the generation assigned to the most recently allocated slot.
+		gen uint64
+		// files maps each file to the offsets of its blocks in the secondary
+		// cache.
+		files map[fileKey]map[uint64]secondaryBlock
+		// queue holds the keys of the blocks in the order they were written,
+		// which is also the order in which they're overwritten. It may contain
+		// keys of blocks that have since been evicted.
+		queue []secondaryQueueEntry
+		// count and bytes are the number and total size of the blocks.
+		count int64
+		bytes int64
+	}
+}
+
+type secondaryQueueEntry struct {
+	key    key
+	offset int64
+	gen    uint64
+}
+
+func newSecondaryCache(fs vfs.FS, path string, size int64) (*secondaryCache, error) {
+	// Any previous contents of the file are discarded.
+	if err := fs.Remove(path); err != nil && !oserror.IsNotExist(err) {
+		return nil, err
+	}
+	file, err := fs.OpenReadWrite(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Preallocate(0, size); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	s := &secondaryCache{
+		fs:      fs,
+		path:    path,
+		file:    file,
+		size:    size,
+		pending: make(chan demotedValue, secondaryWriteQueueLen),
+		done:    make(chan struct{}),
+	}
+	s.mu.files = make(map[fileKey]map[uint64]secondaryBlock)
+	s.stopped.Add(1)
+	go s.writeLoop()
+	return s, nil
+}
+
+// enqueue queues the value to be written to the secondary cache by the writer
+// goroutine, taking ownership of the caller's reference to it. The value is
+// dropped if the queue is full.
+func (s *secondaryCache) enqueue(k key, v *Value) {
+	s.inflight.Add(1)
+	select {
+	case s.pending <- demotedValue{key: k, value: v}:
+	default:
+		s.inflight.Done()
+		v.release()
+	}
+}
+
+func (s *secondaryCache) writeLoop() {
+	defer s.stopped.Done()
+	for {
+		select {
+		case d := <-s.pending:
+			s.set(d.key, d.value.buf)
+			d.value.release()
+			s.inflight.Done()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// flush waits for the queued blocks to be written.
+func (s *secondaryCache) flush() {
+	s.inflight.Wait()
+}
+
+// get reads the block with the specified key, returning nil if it isn't
+// present in the secondary cache. The returned value has been allocated by
+// Alloc and must be added to the cache or freed.
+func (s *secondaryCache) get(k key) *Value {
+	s.mu.Lock()
+	b, ok := s.mu.files[k.fileKey][k.offset]
+	s.mu.Unlock()
+	if !ok || !b.written {
+		s.misses.Add(1)
+		return nil
+	}
+	v := Alloc(int(b.length))
+	if _, err := s.file.ReadAt(v.buf, b.offset); err != nil || !s.validGen(k, b.gen) ||
+		crc.New(v.buf).Value() != b.checksum {
+		Free(v)
+		s.misses.Add(1)
+		return nil
+	}
+	s.hits.Add(1)
+	return v
+}
+
+// validGen returns true if the block with the specified key still occupies
+// the slot of generation gen. Slots are only reallocated after the blocks
+// occupying them are removed, and generations are never reused, so a block
+// read from a slot whose generation is unchanged wasn't overwritten during the
+// read.
+func (s *secondaryCache) validGen(k key, gen uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.mu.files[k.fileKey][k.offset]
+	return ok && b.gen == gen
+}
+
+// set writes the block with the specified key to the secondary cache,
+// overwriting the oldest blocks if there isn't enough room for it. Blocks that
+// are already present or are larger than the secondary cache are ignored. set
+// is called by the writer goroutine, or directly by tests.
+func (s *secondaryCache) set(k key, buf []byte) {
+	n := int64(len(buf))
+	if n == 0 || n > s.size {
+		return
+	}
+	offset, gen, ok := s.allocate(k, n)
+	if !ok {
+		return
+	}
+	if _, err := s.file.WriteAt(buf, offset); err != nil {
+		// The secondary cache is best effort.
+		s.delete(k)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// The block may have been evicted, and its slot reallocated, while it was
+	// being written.
+	if blocks := s.mu.files[k.fileKey]; blocks != nil {
+		if b, ok := blocks[k.offset]; ok && b.gen == gen {
+			b.checksum = crc.New(buf).Value()
+			b.written = true
+			blocks[k.offset] = b
+		}
+	}
+}
+
+// allocate allocates a slot of n bytes for the block with the specified key,
+// removing the blocks occupying it, and returns its offset and generation. It
+// returns false if the block is already present.
+func (s *secondaryCache) allocate(k key, n int64) (offset int64, gen uint64, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.mu.files[k.fileKey][k.offset]; ok {
+		return 0, 0, false
+	}
+
+	if s.mu.head+n > s.size {
+		// Wrap around to the start of the file, discarding the blocks between
+		// the head and the end of the file.
+		for len(s.mu.queue) > 0 && s.mu.queue[0].offset >= s.mu.head {
+			s.popLocked()
+		}
+		s.mu.head = 0
+	}
+	for len(s.mu.queue) > 0 && s.mu.queue[0].offset >= s.mu.head && s.mu.queue[0].offset < s.mu.head+n {
+		s.popLocked()
+	}
+
+	s.mu.gen++
+	blocks := s.mu.files[k.fileKey]
+	if blocks == nil {
+		blocks = make(map[uint64]secondaryBlock)
+		s.mu.files[k.fileKey] = blocks
+	}
+	blocks[k.offset] = secondaryBlock{
+		offset: s.mu.head,
+		length: int32(n),
+		gen:    s.mu.gen,
+	}
+	s.mu.queue = append(s.mu.queue, secondaryQueueEntry{key: k, offset: s.mu.head, gen: s.mu.gen})
+	offset = s.mu.head
+	s.mu.head += n
+	s.mu.count++
+	s.mu.bytes += n
+	return offset, s.mu.gen, true
+}
+
+// popLocked removes the oldest block written to the secondary cache, if it
+// hasn't been evicted already.
+func (s *secondaryCache) popLocked() {
+	q := s.mu.queue[0]
+	s.mu.queue[0] = secondaryQueueEntry{}
+	s.mu.queue = s.mu.queue[1:]
+	if blocks := s.mu.files[q.key.fileKey]; blocks != nil {
+		if b, ok := blocks[q.key.offset]; ok && b.gen == q.gen {
+			s.deleteLocked(q.key, blocks)
+		}
+	}
+}
+
+func (s *secondaryCache) deleteLocked(k key, blocks map[uint64]secondaryBlock) {
+	s.mu.count--
+	s.mu.bytes -= int64(blocks[k.offset].length)
+	delete(blocks, k.offset)
+	if len(blocks) == 0 {
+		delete(s.mu.files, k.fileKey)
+	}
+}
+
+// delete evicts the block with the specified key.
+func (s *secondaryCache) delete(k key) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if blocks := s.mu.files[k.fileKey]; blocks != nil {
+		if _, ok := blocks[k.offset]; ok {
+			s.deleteLocked(k, blocks)
+		}
+	}
+}
+
+// evictFile evicts all of the blocks of the specified file. The space they
+// occupy is reclaimed as the ring buffer wraps around.
+func (s *secondaryCache) evictFile(fk fileKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, b := range s.mu.files[fk] {
+		s.mu.count--
+		s.mu.bytes -= int64(b.length)
+	}
+	delete(s.mu.files, fk)
+}
+
+func (s *secondaryCache) metrics() SecondaryMetrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SecondaryMetrics{
+		Size:   s.mu.bytes,
+		Count:  s.mu.count,
+		Hits:   s.hits.Load(),
+		Misses: s.misses.Load(),
+	}
+}
+
+// close stops the writer goroutine, and closes and removes the secondary
+// cache's file. Blocks that haven't been written are dropped.
+func (s *secondaryCache) close() error {
+	close(s.done)
+	s.stopped.Wait()
+	for drained := false; !drained; {
+		select {
+		case d := <-s.pending:
+			d.value.release()
+			s.inflight.Done()
+		default:
+			drained = true
+		}
+	}
+	err := s.file.Close()
+	if err2 := s.fs.Remove(s.path); err == nil {
+		err = err2
+	}
+	return err
+}
@@ -0,0 +1,175 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecondaryCacheRingBuffer(t *testing.T) {
+	fs := vfs.NewMem()
+	s, err := newSecondaryCache(fs, "secondary", 30)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, s.close()) }()
+
+	k := func(fileNum, offset uint64) key {
+		return key{fileKey{1, base.FileNum(fileNum).DiskFileNum()}, offset}
+	}
+	get := func(k key) string {
+		v := s.get(k)
+		if v == nil {
+			return ""
+		}
+		defer Free(v)
+		return string(v.Buf())
+	}
+	val := func(c string) []byte { return bytes.Repeat([]byte(c), 10) }
+
+	s.set(k(1, 0), val("a"))
+	s.set(k(1, 10), val("b"))
+	s.set(k(2, 0), val("c"))
+	require.Equal(t, string(val("a")), get(k(1, 0)))
+	require.Equal(t, string(val("c")), get(k(2, 0)))
+	require.Equal(t, SecondaryMetrics{Size: 30, Count: 3, Hits: 2}, s.metrics())
+
+	// Blocks that are present or too large are ignored.
+	s.set(k(1, 0), val("x"))
+	s.set(k(3, 0), bytes.Repeat([]byte("x"), 31))
+	require.Equal(t, string(val("a")), get(k(1, 0)))
+	require.Equal(t, "", get(k(3, 0)))
+
+	// Writing more blocks wraps around and overwrites the oldest blocks.
+	s.set(k(3, 0), val("d"))
+	s.set(k(3, 10), val("e"))
+	require.Equal(t, "", get(k(1, 0)))
+	require.Equal(t, "", get(k(1, 10)))
+	require.Equal(t, string(val("c")), get(k(2, 0)))
+	require.Equal(t, string(val("d")), get(k(3, 0)))
+	require.Equal(t, string(val("e")), get(k(3, 10)))
+
+	// A block that doesn't fit before the end of the file wraps around,
+	// discarding the blocks after the head.
+	f := bytes.Repeat([]byte("f"), 15)
+	s.set(k(4, 0), f)
+	require.Equal(t, "", get(k(2, 0)))
+	require.Equal(t, "", get(k(3, 0)))
+	require.Equal(t, "", get(k(3, 10)))
+	require.Equal(t, string(f), get(k(4, 0)))
+
+	s.set(k(3, 0), val("d"))
+	s.evictFile(k(3, 0).fileKey)
+	s.delete(k(4, 0))
+	require.Equal(t, "", get(k(3, 0)))
+	require.Equal(t, "", get(k(4, 0)))
+	m := s.metrics()
+	require.Equal(t, int64(0), m.Size)
+	require.Equal(t, int64(0), m.Count)
+}
+
+func TestCacheWithSecondary(t *testing.T) {
+	fs := vfs.NewMem()
+	c, err := NewWithSecondary(100, fs, "secondary", 1<<20)
+	require.NoError(t, err)
+
+	// Write many more blocks than fit in memory. The blocks evicted from
+	// memory are written to the secondary cache.
+	const n = 100
+	value := func(i int) string { return fmt.Sprintf("block%05d", i) }
+	for i := 0; i < n; i++ {
+		v := Alloc(len(value(i)))
+		copy(v.Buf(), value(i))
+		c.Set(1, base.FileNum(i).DiskFileNum(), 0, v).Release()
+	}
+	c.secondary.flush()
+	m := c.Metrics()
+	require.Greater(t, m.Secondary.Count, int64(0))
+
+	// All of the blocks can be retrieved, either from memory or by
+	// re-admitting them from the secondary cache.
+	for i := 0; i < n; i++ {
+		h := c.Get(1, base.FileNum(i).DiskFileNum(), 0)
+		require.Equal(t, value(i), string(h.Get()))
+		h.Release()
+		c.secondary.flush()
+	}
+	m = c.Metrics()
+	require.Greater(t, m.Secondary.Hits, int64(0))
+	require.Equal(t, int64(0), m.Secondary.Misses)
+
+	// Evicting a file evicts its blocks from the secondary cache too.
+	c.secondary.flush()
+	for i := 0; i < n; i++ {
+		c.EvictFile(1, base.FileNum(i).DiskFileNum())
+	}
+	for i := 0; i < n; i++ {
+		require.Nil(t, c.Get(1, base.FileNum(i).DiskFileNum(), 0).Get())
+	}
+	m = c.Metrics()
+	require.Equal(t, int64(0), m.Secondary.Count)
+	require.Equal(t, int64(n), m.Secondary.Misses)
+
+	// The secondary cache's file is removed when the cache is released.
+	_, err = fs.Stat("secondary")
+	require.NoError(t, err)
+	c.Unref()
+	_, err = fs.Stat("secondary")
+	require.True(t, oserror.IsNotExist(err))
+}
+
+func TestSecondaryCacheGenerations(t *testing.T) {
+	fs := vfs.NewMem()
+	s, err := newSecondaryCache(fs, "secondary", 20)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, s.close()) }()
+
+	k1 := key{fileKey{1, base.FileNum(1).DiskFileNum()}, 0}
+	k2 := key{fileKey{1, base.FileNum(2).DiskFileNum()}, 0}
+	val := func(c string) []byte { return bytes.Repeat([]byte(c), 10) }
+
+	// A slot that has been allocated but not yet written is a miss.
+	_, gen, ok := s.allocate(k1, 10)
+	require.True(t, ok)
+	require.Nil(t, s.get(k1))
+
+	// Deleting the block and writing another one with the same key and
+	// contents to the same slot assigns it a new generation, so a read that
+	// started before the slot was reallocated is detected even though the
+	// contents, and so the checksum, are the same.
+	s.delete(k1)
+	s.set(k2, val("a"))
+	s.set(k1, val("a"))
+	require.False(t, s.validGen(k1, gen))
+	v := s.get(k1)
+	require.Equal(t, string(val("a")), string(v.Buf()))
+	Free(v)
+}
+
+func TestCacheWithSecondaryNoSecondary(t *testing.T) {
+	fs := vfs.NewMem()
+	c, err := NewWithSecondary(100, fs, "secondary", 1<<20)
+	require.NoError(t, err)
+	defer c.Unref()
+
+	// Values marked with SetNoSecondary are never written to the secondary
+	// cache when they're evicted from memory.
+	const n = 100
+	for i := 0; i < n; i++ {
+		v := Alloc(10)
+		copy(v.Buf(), fmt.Sprintf("block%05d", i))
+		v.SetNoSecondary()
+		c.Set(1, base.FileNum(i).DiskFileNum(), 0, v).Release()
+	}
+	c.secondary.flush()
+	m := c.Metrics()
+	require.Equal(t, int64(0), m.Secondary.Count)
+	require.Equal(t, int64(0), m.Secondary.Size)
+}
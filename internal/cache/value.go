@@ -10,6 +10,9 @@ type Value struct {
 	// Reference count for the value. The value is freed when the reference count
 	// drops to zero.
 	ref refcnt
+	// noSecondary is set if the value must not be written to the secondary
+	// tier of the cache. See SetNoSecondary.
+	noSecondary bool
 }
 
 // Buf returns the buffer associated with the value. The contents of the buffer
@@ -23,6 +26,15 @@ func (v *Value) Buf() []byte {
 	return v.buf
 }
 
+// SetNoSecondary prevents the value from being written to the secondary tier
+// of the cache, if any, when it's evicted from memory. It's used for values
+// that must not be stored on disk unprotected, such as blocks decrypted from
+// encrypted sstables. Like the buffer, it should not be changed once the value
+// has been added to the cache.
+func (v *Value) SetNoSecondary() {
+	v.noSecondary = true
+}
+
 // Truncate the buffer to the specified length. The buffer length should not be
 // changed once the value has been added to the cache as there may be
 // concurrent readers of the Value. Instead, a new Value should be created and
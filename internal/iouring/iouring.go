@@ -0,0 +1,40 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+// Package iouring implements file reads on top of Linux's io_uring interface,
+// which allows submitting many reads with a single system call and waiting for
+// their completion asynchronously. On other platforms (and on Linux kernels
+// older than 5.6, or where io_uring is disabled), NewRing returns
+// ErrNotSupported.
+package iouring
+
+import "github.com/cockroachdb/errors"
+
+// ErrNotSupported is returned by NewRing when io_uring isn't supported.
+var ErrNotSupported = errors.New("io_uring not supported")
+
+// Read is a read of a file submitted to a Ring.
+type Read struct {
+	// Fd is the file descriptor of the file.
+	Fd uintptr
+	// Buf is the buffer the data is read into. It must not be accessed until the
+	// read completes.
+	Buf []byte
+	// Offset is the offset in the file of the first byte read.
+	Offset int64
+
+	// N is the number of bytes read, set when the read completes. N is only
+	// smaller than len(Buf) if Err is set; Err is io.EOF if the end of the file
+	// was reached.
+	N   int
+	Err error
+
+	id   uint64
+	done bool
+}
+
+// Done returns true if the read completed.
+func (rd *Read) Done() bool {
+	return rd.done
+}
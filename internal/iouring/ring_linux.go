@@ -0,0 +1,353 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package iouring
+
+import (
+	"io"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"github.com/cockroachdb/errors"
+	"golang.org/x/sys/unix"
+)
+
+// The layouts of the kernel structures of the io_uring interface (see
+// include/uapi/linux/io_uring.h).
+
+type sqRingOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type cqRingOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type params struct {
+	sqEntries, cqEntries, flags, sqThreadCPU, sqThreadIdle, features, wqFd uint32
+	resv                                                                   [3]uint32
+	sqOff                                                                  sqRingOffsets
+	cqOff                                                                  cqRingOffsets
+}
+
+type sqe struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	rwFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFdIn  int32
+	addr3       uint64
+	_           uint64
+}
+
+type cqe struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+const (
+	opRead             = 22 // IORING_OP_READ, since Linux 5.6.
+	enterGetEvents     = 1 << 0
+	featSingleMmap     = 1 << 0
+	offSQRing          = 0
+	offCQRing          = 0x8000000
+	offSQEs            = 0x10000000
+	sqeSize            = unsafe.Sizeof(sqe{})
+	cqeSize            = unsafe.Sizeof(cqe{})
+	maxEntries         = 4096
+	defaultRingEntries = 64
+)
+
+// Ring is an io_uring instance used to submit reads. A Ring is not safe for
+// concurrent use.
+type Ring struct {
+	fd int
+
+	sqRing, cqRing, sqes []byte
+
+	// The heads and tails of the queues are shared with the kernel, and are
+	// accessed atomically.
+	sq struct {
+		head, tail *atomic.Uint32
+		mask       *uint32
+		array      []uint32
+		entries    uint32
+	}
+	cq struct {
+		head, tail *atomic.Uint32
+		mask       *uint32
+		cqes       []cqe
+	}
+	sqeSlice []sqe
+
+	// pending holds the submitted reads, by ID. It keeps their buffers alive
+	// while the kernel references them.
+	pending map[uint64]*Read
+	nextID  uint64
+}
+
+// NewRing returns a Ring with room for the given number of reads in flight;
+// if entries is zero, a default of 64 is used.
+func NewRing(entries int) (*Ring, error) {
+	if entries <= 0 {
+		entries = defaultRingEntries
+	}
+	if entries > maxEntries {
+		entries = maxEntries
+	}
+	var p params
+	fd, _, errno := unix.Syscall(unix.SYS_IO_URING_SETUP, uintptr(entries), uintptr(unsafe.Pointer(&p)), 0)
+	if errno != 0 {
+		if errno == unix.ENOSYS || errno == unix.EPERM {
+			return nil, ErrNotSupported
+		}
+		return nil, errors.Wrap(errno, "io_uring_setup")
+	}
+	r := &Ring{fd: int(fd), pending: make(map[uint64]*Read)}
+	if err := r.mmap(&p); err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	if !r.probe() {
+		_ = r.Close()
+		return nil, ErrNotSupported
+	}
+	return r, nil
+}
+
+func (r *Ring) mmap(p *params) error {
+	sqSize := int(p.sqOff.array) + int(p.sqEntries)*4
+	cqSize := int(p.cqOff.cqes) + int(p.cqEntries)*int(cqeSize)
+	if p.features&featSingleMmap != 0 && cqSize > sqSize {
+		sqSize = cqSize
+	}
+	var err error
+	const prot, flags = unix.PROT_READ | unix.PROT_WRITE, unix.MAP_SHARED | unix.MAP_POPULATE
+	if r.sqRing, err = unix.Mmap(r.fd, offSQRing, sqSize, prot, flags); err != nil {
+		return errors.Wrap(err, "io_uring mmap")
+	}
+	if p.features&featSingleMmap != 0 {
+		r.cqRing = r.sqRing
+	} else if r.cqRing, err = unix.Mmap(r.fd, offCQRing, cqSize, prot, flags); err != nil {
+		return errors.Wrap(err, "io_uring mmap")
+	}
+	if r.sqes, err = unix.Mmap(r.fd, offSQEs, int(p.sqEntries)*int(sqeSize), prot, flags); err != nil {
+		return errors.Wrap(err, "io_uring mmap")
+	}
+
+	u32 := func(b []byte, off uint32) *uint32 {
+		return (*uint32)(unsafe.Pointer(&b[off]))
+	}
+	atomicU32 := func(b []byte, off uint32) *atomic.Uint32 {
+		return (*atomic.Uint32)(unsafe.Pointer(&b[off]))
+	}
+	r.sq.head = atomicU32(r.sqRing, p.sqOff.head)
+	r.sq.tail = atomicU32(r.sqRing, p.sqOff.tail)
+	r.sq.mask = u32(r.sqRing, p.sqOff.ringMask)
+	r.sq.entries = p.sqEntries
+	r.sq.array = unsafe.Slice(u32(r.sqRing, p.sqOff.array), p.sqEntries)
+	r.cq.head = atomicU32(r.cqRing, p.cqOff.head)
+	r.cq.tail = atomicU32(r.cqRing, p.cqOff.tail)
+	r.cq.mask = u32(r.cqRing, p.cqOff.ringMask)
+	r.cq.cqes = unsafe.Slice((*cqe)(unsafe.Pointer(&r.cqRing[p.cqOff.cqes])), p.cqEntries)
+	r.sqeSlice = unsafe.Slice((*sqe)(unsafe.Pointer(&r.sqes[0])), p.sqEntries)
+	return nil
+}
+
+// probe checks that the kernel supports IORING_OP_READ, by reading from an
+// invalid file descriptor: kernels that don't know the opcode fail the read
+// with EINVAL rather than EBADF.
+func (r *Ring) probe() bool {
+	rd := Read{Fd: ^uintptr(0) >> 1, Buf: make([]byte, 1)}
+	if err := r.ReadBatch([]*Read{&rd}); err != nil {
+		return false
+	}
+	return errors.Is(rd.Err, unix.EBADF)
+}
+
+// Close releases the ring. It must not be called while reads are in flight.
+func (r *Ring) Close() error {
+	if len(r.pending) > 0 {
+		panic("iouring: Close with reads in flight")
+	}
+	if r.sqes != nil {
+		_ = unix.Munmap(r.sqes)
+	}
+	if r.cqRing != nil && &r.cqRing[0] != &r.sqRing[0] {
+		_ = unix.Munmap(r.cqRing)
+	}
+	if r.sqRing != nil {
+		_ = unix.Munmap(r.sqRing)
+	}
+	r.sqes, r.cqRing, r.sqRing = nil, nil, nil
+	return unix.Close(r.fd)
+}
+
+// InFlight returns the number of reads in flight.
+func (r *Ring) InFlight() int {
+	return len(r.pending)
+}
+
+// Capacity returns the maximum number of reads in flight.
+func (r *Ring) Capacity() int {
+	return int(r.sq.entries)
+}
+
+// Start submits the reads without waiting for them to complete. The number of
+// reads in flight must remain below the Capacity of the ring.
+func (r *Ring) Start(reads ...*Read) error {
+	if len(r.pending)+len(reads) > r.Capacity() {
+		return errors.AssertionFailedf("iouring: %d reads exceed the capacity of the ring", len(r.pending)+len(reads))
+	}
+	for _, rd := range reads {
+		rd.N, rd.Err, rd.done = 0, nil, false
+		if len(rd.Buf) == 0 {
+			rd.done = true
+			continue
+		}
+		r.queue(rd)
+	}
+	return r.enter(0)
+}
+
+// queue adds the read (or what remains of it, if it was cut short) to the
+// submission queue.
+func (r *Ring) queue(rd *Read) {
+	r.nextID++
+	rd.id = r.nextID
+	r.pending[rd.id] = rd
+
+	tail := r.sq.tail.Load()
+	idx := tail & *r.sq.mask
+	buf := rd.Buf[rd.N:]
+	r.sqeSlice[idx] = sqe{
+		opcode:   opRead,
+		fd:       int32(rd.Fd),
+		off:      uint64(rd.Offset) + uint64(rd.N),
+		addr:     uint64(uintptr(unsafe.Pointer(&buf[0]))),
+		len:      uint32(len(buf)),
+		userData: rd.id,
+	}
+	r.sq.array[idx] = idx
+	r.sq.tail.Store(tail + 1)
+}
+
+// enter submits the queued reads, and waits until at least minComplete
+// completions are available.
+func (r *Ring) enter(minComplete uint32) error {
+	for {
+		toSubmit := r.sq.tail.Load() - r.sq.head.Load()
+		if toSubmit == 0 && minComplete == 0 {
+			return nil
+		}
+		var flags uintptr
+		if minComplete > 0 {
+			flags = enterGetEvents
+		}
+		_, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(r.fd), uintptr(toSubmit),
+			uintptr(minComplete), flags, 0, 0)
+		switch errno {
+		case 0:
+			if r.sq.tail.Load() == r.sq.head.Load() {
+				return nil
+			}
+		case unix.EINTR, unix.EAGAIN, unix.EBUSY:
+		default:
+			return errors.Wrap(errno, "io_uring_enter")
+		}
+		if minComplete > 0 && r.reap() > 0 {
+			minComplete = 0
+		}
+	}
+}
+
+// reap processes the available completions, returning their number. Reads
+// that were cut short are queued again for their remainder.
+func (r *Ring) reap() int {
+	n := 0
+	for {
+		head := r.cq.head.Load()
+		if head == r.cq.tail.Load() {
+			return n
+		}
+		c := r.cq.cqes[head&*r.cq.mask]
+		r.cq.head.Store(head + 1)
+		n++
+
+		rd, ok := r.pending[c.userData]
+		if !ok {
+			continue
+		}
+		delete(r.pending, c.userData)
+		switch {
+		case c.res < 0:
+			if errno := syscall.Errno(-c.res); errno == unix.EINTR || errno == unix.EAGAIN {
+				r.queue(rd)
+				continue
+			} else {
+				rd.Err = errno
+			}
+		case c.res == 0:
+			rd.Err = io.EOF
+		default:
+			rd.N += int(c.res)
+			if rd.N < len(rd.Buf) {
+				r.queue(rd)
+				continue
+			}
+		}
+		rd.done = true
+	}
+}
+
+// Wait waits for the read, which must have been started, to complete.
+func (r *Ring) Wait(rd *Read) error {
+	if _, ok := r.pending[rd.id]; !ok && !rd.done {
+		return errors.AssertionFailedf("iouring: Wait for a read that wasn't started")
+	}
+	for !rd.done {
+		if err := r.enter(1); err != nil {
+			return err
+		}
+		r.reap()
+	}
+	return nil
+}
+
+// ReadBatch performs the reads, submitting as many at a time as the ring
+// allows, and waits for all of them to complete. The error returned is a
+// failure of the ring; the outcome of each read is set in the read.
+func (r *Ring) ReadBatch(reads []*Read) error {
+	for len(reads) > 0 {
+		n := r.Capacity() - len(r.pending)
+		if n > len(reads) {
+			n = len(reads)
+		}
+		batch := reads[:n]
+		reads = reads[n:]
+		if err := r.Start(batch...); err != nil {
+			return err
+		}
+		for _, rd := range batch {
+			if err := r.Wait(rd); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
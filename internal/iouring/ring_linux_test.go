@@ -0,0 +1,85 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package iouring
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func newTestRing(t *testing.T, entries int) *Ring {
+	r, err := NewRing(entries)
+	if err == ErrNotSupported {
+		t.Skip("io_uring not supported")
+	}
+	require.NoError(t, err)
+	return r
+}
+
+func TestRing(t *testing.T) {
+	r := newTestRing(t, 8)
+	defer func() { require.NoError(t, r.Close()) }()
+	require.Equal(t, 8, r.Capacity())
+
+	rng := rand.New(rand.NewSource(1))
+	data := make([]byte, 1<<20)
+	rng.Read(data)
+	path := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(path, data, 0644))
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	// A batch larger than the ring is submitted in several rounds.
+	reads := make([]*Read, 100)
+	for i := range reads {
+		off := rng.Intn(len(data))
+		reads[i] = &Read{Fd: f.Fd(), Buf: make([]byte, rng.Intn(64<<10)), Offset: int64(off)}
+	}
+	require.NoError(t, r.ReadBatch(reads))
+	require.Zero(t, r.InFlight())
+	for _, rd := range reads {
+		require.True(t, rd.Done())
+		end := int(rd.Offset) + len(rd.Buf)
+		if end > len(data) {
+			require.Equal(t, io.EOF, rd.Err)
+			end = len(data)
+		} else {
+			require.NoError(t, rd.Err)
+		}
+		require.Equal(t, end-int(rd.Offset), rd.N)
+		require.True(t, bytes.Equal(data[rd.Offset:end], rd.Buf[:rd.N]))
+	}
+
+	// Asynchronous reads.
+	a := &Read{Fd: f.Fd(), Buf: make([]byte, 1000), Offset: 10}
+	b := &Read{Fd: f.Fd(), Buf: make([]byte, 1000), Offset: 5000}
+	require.NoError(t, r.Start(a, b))
+	require.NoError(t, r.Wait(b))
+	require.NoError(t, r.Wait(a))
+	require.True(t, bytes.Equal(data[10:1010], a.Buf))
+	require.True(t, bytes.Equal(data[5000:6000], b.Buf))
+
+	// Errors.
+	bad := &Read{Fd: ^uintptr(0) >> 1, Buf: make([]byte, 10)}
+	require.NoError(t, r.ReadBatch([]*Read{bad}))
+	require.ErrorIs(t, bad.Err, unix.EBADF)
+	require.Error(t, r.Wait(&Read{Buf: make([]byte, 1)}))
+	tooMany := make([]*Read, 9)
+	for i := range tooMany {
+		tooMany[i] = &Read{Fd: f.Fd(), Buf: make([]byte, 1)}
+	}
+	require.Error(t, r.Start(tooMany...))
+}
@@ -0,0 +1,35 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package iouring
+
+// Ring is an io_uring instance used to submit reads. It's only supported on
+// Linux.
+type Ring struct{}
+
+// NewRing returns ErrNotSupported.
+func NewRing(entries int) (*Ring, error) {
+	return nil, ErrNotSupported
+}
+
+// Close releases the ring.
+func (r *Ring) Close() error { return nil }
+
+// InFlight returns the number of reads in flight.
+func (r *Ring) InFlight() int { return 0 }
+
+// Capacity returns the maximum number of reads in flight.
+func (r *Ring) Capacity() int { return 0 }
+
+// Start submits the reads without waiting for them to complete.
+func (r *Ring) Start(reads ...*Read) error { return ErrNotSupported }
+
+// Wait waits for the read to complete.
+func (r *Ring) Wait(rd *Read) error { return ErrNotSupported }
+
+// ReadBatch performs the reads and waits for all of them to complete.
+func (r *Ring) ReadBatch(reads []*Read) error { return ErrNotSupported }
@@ -0,0 +1,55 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package manifest
+
+import (
+	"fmt"
+
+	"github.com/cockroachdb/pebble/internal/base"
+)
+
+// BlobFileMetadata describes a blob file, which holds values that were
+// separated from the sstables referencing them (see internal/blob).
+type BlobFileMetadata struct {
+	// FileNum is the number of the blob file.
+	FileNum base.DiskFileNum
+	// Size is the size of the blob file, in bytes.
+	Size uint64
+	// ValueSize is the sum of the lengths of the values in the blob file.
+	ValueSize uint64
+}
+
+func (m BlobFileMetadata) String() string {
+	return fmt.Sprintf("%s size:%d value-size:%d", m.FileNum, m.Size, m.ValueSize)
+}
+
+// BlobReference records that an sstable references values in a blob file.
+type BlobReference struct {
+	// FileNum is the number of the blob file.
+	FileNum base.DiskFileNum
+	// ValueSize is the sum of the lengths of the values of the blob file
+	// referenced by the sstable. For a virtual sstable, it's an estimate
+	// derived from the references of its backing sstable.
+	ValueSize uint64
+}
+
+// ScaleBlobReferences returns the references of a virtual sstable of size
+// virtualSize backed by a sstable of size backingSize, which holds refs. The
+// values referenced by the virtual sstable are assumed to be proportional to
+// its size, but every blob file referenced by the backing sstable remains
+// referenced.
+func ScaleBlobReferences(refs []BlobReference, virtualSize, backingSize uint64) []BlobReference {
+	if len(refs) == 0 {
+		return nil
+	}
+	scaled := make([]BlobReference, len(refs))
+	for i, ref := range refs {
+		scaled[i] = ref
+		if backingSize > 0 && virtualSize < backingSize {
+			scaled[i].ValueSize = uint64(float64(ref.ValueSize) * float64(virtualSize) / float64(backingSize))
+		}
+	}
+	return scaled
+}
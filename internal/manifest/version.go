@@ -48,6 +48,8 @@ type TableStats struct {
 	NumEntries uint64
 	// The number of point and range deletion entries in the table.
 	NumDeletions uint64
+	// The number of range deletion entries in the table.
+	NumRangeDeletions uint64
 	// NumRangeKeySets is the total number of range key sets in the table.
 	NumRangeKeySets uint64
 	// Estimate of the total disk space that may be dropped by this table's
@@ -68,6 +70,11 @@ type TableStats struct {
 	RangeDeletionsBytesEstimate uint64
 	// Total size of value blocks and value index block.
 	ValueBlocksSize uint64
+	// TimestampUpperBound is the exclusive upper bound, in seconds since the
+	// Unix epoch, of the timestamps of the table's point keys extracted by
+	// Options.TTL. It is zero if the table has no timestamped keys or was
+	// written without TTLs configured.
+	TimestampUpperBound uint64
 }
 
 // boundType represents the type of key (point or range) present as the smallest
@@ -155,6 +162,13 @@ type FileMetadata struct {
 	// that returns a user key (eg. Next, Prev, SeekGE, SeekLT, etc).
 	AllowedSeeks atomic.Int64
 
+	// LastReadTime is the time, in seconds since the epoch, at which the
+	// table was last read, and ReadCount is the number of times it has been
+	// read, since its metadata was loaded. They are only maintained if the DB
+	// tiers sstables based on their reads, and are not persisted.
+	LastReadTime atomic.Int64
+	ReadCount    atomic.Int64
+
 	// statsValid indicates if stats have been loaded for the table. The
 	// TableStats structure is populated only if valid is true.
 	statsValid atomic.Bool
@@ -274,6 +288,83 @@ type FileMetadata struct {
 	boundTypeSmallest, boundTypeLargest boundType
 	// Virtual is true if the FileMetadata belongs to a virtual sstable.
 	Virtual bool
+	// PrefixReplacement is set if the keys of the virtual sstable are exposed
+	// with a different prefix than the one they're stored with in the backing
+	// sstable. It may only be set on virtual sstables.
+	PrefixReplacement *PrefixReplacement
+	// BlobReferences holds the blob files in which values of the sstable
+	// are stored, if any. The sstable stores blob handles in place of these
+	// values.
+	BlobReferences []BlobReference
+}
+
+// PrefixReplacement describes the rewriting of the prefix of the keys of a
+// virtual sstable: the keys of the backing sstable within the bounds of the
+// virtual sstable start with ContentPrefix, and they're exposed as if they
+// started with SyntheticPrefix instead. The bounds of the virtual sstable (and
+// all the keys passed to and returned by its iterators) are in the rewritten
+// keyspace.
+//
+// Replacing the prefix must not change the order of the keys, nor how they're
+// split into prefixes and suffixes (see Comparer.Split), which holds if the
+// Comparer orders the keys starting with a common prefix by the bytes that
+// follow it and splits them regardless of it.
+type PrefixReplacement struct {
+	ContentPrefix   []byte
+	SyntheticPrefix []byte
+}
+
+// ReplaceArg translates a key of the rewritten keyspace, such as a seek key or
+// a bound, into the keyspace of the backing sstable, appending it to dst. A
+// key which sorts before (after) all the keys starting with SyntheticPrefix is
+// translated into a key which sorts before (after) all the keys starting with
+// ContentPrefix, so that the translation preserves the order of the keys.
+func (p *PrefixReplacement) ReplaceArg(dst, key []byte) []byte {
+	return replacePrefix(dst, key, p.SyntheticPrefix, p.ContentPrefix)
+}
+
+// ReplaceResult translates a key of the backing sstable into the rewritten
+// keyspace, appending it to dst. It's the inverse of ReplaceArg.
+func (p *PrefixReplacement) ReplaceResult(dst, key []byte) []byte {
+	return replacePrefix(dst, key, p.ContentPrefix, p.SyntheticPrefix)
+}
+
+// Validate returns an error if the prefixes can't be used to rewrite keys.
+func (p *PrefixReplacement) Validate() error {
+	if len(p.ContentPrefix) == 0 || len(p.SyntheticPrefix) == 0 {
+		return errors.New("pebble: prefix replacement with an empty prefix")
+	}
+	if prefixEnd(nil, p.ContentPrefix) == nil || prefixEnd(nil, p.SyntheticPrefix) == nil {
+		return errors.New("pebble: prefix replacement with a prefix without a successor")
+	}
+	return nil
+}
+
+func (p *PrefixReplacement) String() string {
+	return fmt.Sprintf("%q->%q", p.ContentPrefix, p.SyntheticPrefix)
+}
+
+func replacePrefix(dst, key, from, to []byte) []byte {
+	switch {
+	case bytes.HasPrefix(key, from):
+		return append(append(dst, to...), key[len(from):]...)
+	case bytes.Compare(key, from) < 0:
+		return append(dst, to...)
+	default:
+		return prefixEnd(dst, to)
+	}
+}
+
+// prefixEnd appends to dst the smallest key which sorts after all the keys
+// starting with prefix, returning nil if there is none.
+func prefixEnd(dst, prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			dst = append(dst, prefix[:i]...)
+			return append(dst, prefix[i]+1)
+		}
+	}
+	return nil
 }
 
 // PhysicalFileMeta is used by functions which want a guarantee that their input
@@ -1089,6 +1180,11 @@ type Version struct {
 	// duplication should be minimal, as range keys are expected to be rare.
 	RangeKeyLevels [NumLevels]LevelMetadata
 
+	// FlushedSpans holds the spans flushed out of memtables that are still
+	// needed to read them (see FlushedSpan). Like Stats, it is maintained from
+	// version to version rather than derived from the version edits applied.
+	FlushedSpans []FlushedSpan
+
 	// The callback to invoke when the last reference to a version is
 	// removed. Will be called with list.mu held.
 	Deleted func(obsolete []*FileBacking)
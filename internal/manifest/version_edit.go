@@ -54,6 +54,8 @@ const (
 	tagNewFile5            = 104 // Range keys.
 	tagCreatedBackingTable = 105
 	tagRemovedBackingTable = 106
+	tagNewBlobFile         = 107
+	tagFlushedSpan         = 108
 
 	// The custom tags sub-format used by tagNewFile4 and above.
 	customTagTerminate         = 1
@@ -62,6 +64,10 @@ const (
 	customTagPathID            = 65
 	customTagNonSafeIgnoreMask = 1 << 6
 	customTagVirtual           = 66
+	customTagBlobReferences    = 67
+	// customTagPrefixReplacement is not safe to ignore, and is only written by
+	// DBs at ExperimentalFormatPrefixReplacement or above.
+	customTagPrefixReplacement = 68
 )
 
 // DeletedFileEntry holds the state for a file deletion from a level. The file
@@ -81,6 +87,23 @@ type NewFileEntry struct {
 	BackingFileNum base.DiskFileNum
 }
 
+// FlushedSpan records that the keys of the span [Start, End) with sequence
+// numbers less than SeqNum were flushed out of the memtables holding them,
+// while the keys of these memtables outside of the span were not. Readers
+// hide the keys of the span in memtables holding keys less than SeqNum, which
+// are instead read from the flushed sstables. A FlushedSpan is no longer
+// needed once the WAL LogNum, the WAL of the newest memtable flushed, is
+// flushed.
+type FlushedSpan struct {
+	Start, End []byte
+	SeqNum     uint64
+	LogNum     base.FileNum
+}
+
+func (s FlushedSpan) String() string {
+	return fmt.Sprintf("[%q, %q) seqnum<%d log:%s", s.Start, s.End, s.SeqNum, s.LogNum)
+}
+
 // VersionEdit holds the state for an edit to a Version along with other
 // on-disk state (log numbers, next file number, and the last sequence number).
 type VersionEdit struct {
@@ -145,6 +168,15 @@ type VersionEdit struct {
 	// and RemovedBackingTables. A file must be present in RemovedBackingTables
 	// in exactly one version edit.
 	RemovedBackingTables []base.DiskFileNum
+	// NewBlobFiles holds the blob files created by the edit. A blob file is
+	// added in the same version edit as the first sstables referencing it
+	// (see FileMetadata.BlobReferences). Blob files aren't removed
+	// explicitly: a blob file is obsolete once no sstable references it.
+	NewBlobFiles []BlobFileMetadata
+	// FlushedSpans holds the spans flushed out of the memtables by the edit.
+	// The spans of the preceding edits remain in effect until they're no
+	// longer needed (see FlushedSpan).
+	FlushedSpans []FlushedSpan
 }
 
 // Decode decodes an edit from the specified reader.
@@ -226,6 +258,47 @@ func (v *VersionEdit) Decode(r io.Reader) error {
 				Size:        size,
 			}
 			v.CreatedBackingTables = append(v.CreatedBackingTables, fileBacking)
+		case tagNewBlobFile:
+			fileNum, err := d.readUvarint()
+			if err != nil {
+				return err
+			}
+			size, err := d.readUvarint()
+			if err != nil {
+				return err
+			}
+			valueSize, err := d.readUvarint()
+			if err != nil {
+				return err
+			}
+			v.NewBlobFiles = append(v.NewBlobFiles, BlobFileMetadata{
+				FileNum:   base.FileNum(fileNum).DiskFileNum(),
+				Size:      size,
+				ValueSize: valueSize,
+			})
+		case tagFlushedSpan:
+			start, err := d.readBytes()
+			if err != nil {
+				return err
+			}
+			end, err := d.readBytes()
+			if err != nil {
+				return err
+			}
+			seqNum, err := d.readUvarint()
+			if err != nil {
+				return err
+			}
+			logNum, err := d.readFileNum()
+			if err != nil {
+				return err
+			}
+			v.FlushedSpans = append(v.FlushedSpans, FlushedSpan{
+				Start:  start,
+				End:    end,
+				SeqNum: seqNum,
+				LogNum: logNum,
+			})
 		case tagDeletedFile:
 			level, err := d.readLevel()
 			if err != nil {
@@ -331,6 +404,8 @@ func (v *VersionEdit) Decode(r io.Reader) error {
 			}
 			var markedForCompaction bool
 			var creationTime uint64
+			var blobReferences []BlobReference
+			var prefixReplacement *PrefixReplacement
 			virtualState := struct {
 				virtual        bool
 				backingFileNum uint64
@@ -374,6 +449,35 @@ func (v *VersionEdit) Decode(r io.Reader) error {
 					case customTagPathID:
 						return base.CorruptionErrorf("new-file4: path-id field not supported")
 
+					case customTagBlobReferences:
+						for len(field) > 0 {
+							fileNum, n := binary.Uvarint(field)
+							if n <= 0 {
+								return base.CorruptionErrorf("new-file4: invalid blob references")
+							}
+							field = field[n:]
+							valueSize, n := binary.Uvarint(field)
+							if n <= 0 {
+								return base.CorruptionErrorf("new-file4: invalid blob references")
+							}
+							field = field[n:]
+							blobReferences = append(blobReferences, BlobReference{
+								FileNum:   base.FileNum(fileNum).DiskFileNum(),
+								ValueSize: valueSize,
+							})
+						}
+
+					case customTagPrefixReplacement:
+						n, k := binary.Uvarint(field)
+						if k <= 0 || n > uint64(len(field)-k) {
+							return base.CorruptionErrorf("new-file4: invalid prefix replacement")
+						}
+						field = field[k:]
+						prefixReplacement = &PrefixReplacement{
+							ContentPrefix:   field[:n],
+							SyntheticPrefix: field[n:],
+						}
+
 					default:
 						if (customTag & customTagNonSafeIgnoreMask) != 0 {
 							return base.CorruptionErrorf("new-file4: custom field not supported: %d", customTag)
@@ -389,6 +493,8 @@ func (v *VersionEdit) Decode(r io.Reader) error {
 				LargestSeqNum:       largestSeqNum,
 				MarkedForCompaction: markedForCompaction,
 				Virtual:             virtualState.virtual,
+				BlobReferences:      blobReferences,
+				PrefixReplacement:   prefixReplacement,
 			}
 			if tag != tagNewFile5 { // no range keys present
 				m.SmallestPointKey = base.DecodeInternalKey(smallestPointKey)
@@ -493,6 +599,12 @@ func (v *VersionEdit) string(verbose bool, fmtKey base.FormatKey) string {
 		}
 		fmt.Fprintln(&buf)
 	}
+	for _, bf := range v.NewBlobFiles {
+		fmt.Fprintf(&buf, "  added-blob:    %s\n", bf)
+	}
+	for _, fs := range v.FlushedSpans {
+		fmt.Fprintf(&buf, "  flushed-span:  %s\n", fs)
+	}
 	return buf.String()
 }
 
@@ -535,6 +647,19 @@ func (v *VersionEdit) Encode(w io.Writer) error {
 		e.writeUvarint(uint64(fileBacking.DiskFileNum.FileNum()))
 		e.writeUvarint(fileBacking.Size)
 	}
+	for _, bf := range v.NewBlobFiles {
+		e.writeUvarint(tagNewBlobFile)
+		e.writeUvarint(uint64(bf.FileNum.FileNum()))
+		e.writeUvarint(bf.Size)
+		e.writeUvarint(bf.ValueSize)
+	}
+	for _, fs := range v.FlushedSpans {
+		e.writeUvarint(tagFlushedSpan)
+		e.writeBytes(fs.Start)
+		e.writeBytes(fs.End)
+		e.writeUvarint(fs.SeqNum)
+		e.writeUvarint(uint64(fs.LogNum))
+	}
 	// RocksDB requires LastSeqNum to be encoded for the first MANIFEST entry,
 	// even though its value is zero. We detect this by encoding LastSeqNum when
 	// ComparerName is set.
@@ -548,7 +673,8 @@ func (v *VersionEdit) Encode(w io.Writer) error {
 		e.writeUvarint(uint64(x.FileNum))
 	}
 	for _, x := range v.NewFiles {
-		customFields := x.Meta.MarkedForCompaction || x.Meta.CreationTime != 0 || x.Meta.Virtual
+		customFields := x.Meta.MarkedForCompaction || x.Meta.CreationTime != 0 || x.Meta.Virtual ||
+			len(x.Meta.BlobReferences) > 0 || x.Meta.PrefixReplacement != nil
 		var tag uint64
 		switch {
 		case x.Meta.HasRangeKeys:
@@ -605,6 +731,21 @@ func (v *VersionEdit) Encode(w io.Writer) error {
 				e.writeUvarint(customTagVirtual)
 				e.writeUvarint(uint64(x.Meta.FileBacking.DiskFileNum.FileNum()))
 			}
+			if len(x.Meta.BlobReferences) > 0 {
+				e.writeUvarint(customTagBlobReferences)
+				var buf []byte
+				for _, ref := range x.Meta.BlobReferences {
+					buf = binary.AppendUvarint(buf, uint64(ref.FileNum.FileNum()))
+					buf = binary.AppendUvarint(buf, ref.ValueSize)
+				}
+				e.writeBytes(buf)
+			}
+			if p := x.Meta.PrefixReplacement; p != nil {
+				e.writeUvarint(customTagPrefixReplacement)
+				buf := binary.AppendUvarint(nil, uint64(len(p.ContentPrefix)))
+				buf = append(append(buf, p.ContentPrefix...), p.SyntheticPrefix...)
+				e.writeBytes(buf)
+			}
 			e.writeUvarint(customTagTerminate)
 		}
 	}
@@ -716,6 +857,10 @@ type BulkVersionEdit struct {
 	AddedFileBacking   map[base.DiskFileNum]*FileBacking
 	RemovedFileBacking []base.DiskFileNum
 
+	// AddedBlobFiles holds the blob files added by the accumulated version
+	// edits, which may no longer be referenced by any sstable.
+	AddedBlobFiles map[base.DiskFileNum]BlobFileMetadata
+
 	// AddedByFileNum maps file number to file metadata for all added files
 	// from accumulated version edits. AddedByFileNum is only populated if set
 	// to non-nil by a caller. It must be set to non-nil when replaying
@@ -785,6 +930,12 @@ func (b *BulkVersionEdit) Accumulate(ve *VersionEdit) error {
 	for _, fb := range ve.CreatedBackingTables {
 		b.AddedFileBacking[fb.DiskFileNum] = fb
 	}
+	if len(ve.NewBlobFiles) > 0 && b.AddedBlobFiles == nil {
+		b.AddedBlobFiles = make(map[base.DiskFileNum]BlobFileMetadata)
+	}
+	for _, bf := range ve.NewBlobFiles {
+		b.AddedBlobFiles[bf.FileNum] = bf
+	}
 
 	for _, nf := range ve.NewFiles {
 		// A new file should not have been deleted in this or a preceding
@@ -69,6 +69,10 @@ func TestVERoundTripAndAccumulate(t *testing.T) {
 		LargestSeqNum:  11,
 		Virtual:        true,
 		FileBacking:    m1.FileBacking,
+		PrefixReplacement: &PrefixReplacement{
+			ContentPrefix:   []byte("x"),
+			SyntheticPrefix: []byte("a"),
+		},
 	}).ExtendPointKeyBounds(
 		cmp,
 		base.MakeInternalKey([]byte("a"), 0, base.InternalKeyKindSet),
@@ -198,6 +202,38 @@ func TestVersionEditRoundTrip(t *testing.T) {
 	)
 	m6.InitPhysicalBacking()
 
+	m7 := (&FileMetadata{
+		FileNum:        812,
+		Size:           8120,
+		SmallestSeqNum: 12,
+		LargestSeqNum:  14,
+		BlobReferences: []BlobReference{
+			{FileNum: base.FileNum(813).DiskFileNum(), ValueSize: 1 << 20},
+			{FileNum: base.FileNum(700).DiskFileNum(), ValueSize: 5},
+		},
+	}).ExtendPointKeyBounds(
+		cmp,
+		base.MakeInternalKey([]byte("b"), 13, base.InternalKeyKindSet),
+		base.MakeInternalKey([]byte("c"), 12, base.InternalKeyKindSet),
+	)
+	m7.InitPhysicalBacking()
+
+	m8 := (&FileMetadata{
+		FileNum:        814,
+		Size:           8140,
+		SmallestSeqNum: 15,
+		LargestSeqNum:  15,
+		PrefixReplacement: &PrefixReplacement{
+			ContentPrefix:   []byte("x"),
+			SyntheticPrefix: []byte("a"),
+		},
+	}).ExtendPointKeyBounds(
+		cmp,
+		base.MakeInternalKey([]byte("ab"), 15, base.InternalKeyKindSet),
+		base.MakeInternalKey([]byte("ac"), 15, base.InternalKeyKindSet),
+	)
+	m8.InitPhysicalBacking()
+
 	testCases := []VersionEdit{
 		// An empty version edit.
 		{},
@@ -239,6 +275,21 @@ func TestVersionEditRoundTrip(t *testing.T) {
 					Level: 6,
 					Meta:  m4,
 				},
+				{
+					Level: 1,
+					Meta:  m7,
+				},
+				{
+					Level: 2,
+					Meta:  m8,
+				},
+			},
+			NewBlobFiles: []BlobFileMetadata{
+				{FileNum: base.FileNum(813).DiskFileNum(), Size: 1<<20 + 100, ValueSize: 1 << 20},
+			},
+			FlushedSpans: []FlushedSpan{
+				{Start: []byte("b"), End: []byte("d"), SeqNum: 1003, LogNum: 801},
+				{Start: []byte("c"), End: []byte("k"), SeqNum: 1011, LogNum: 803},
 			},
 		},
 	}
@@ -27,3 +27,20 @@ var SSTableWriterDisableKeyOrderChecks func(interface{})
 // sstable writers are not permitted to edit. It's an untyped interface{} to
 // avoid a cyclic dependency.
 var SSTableInternalProperties interface{}
+
+// SSTableValueBlockPropertyCollector is a
+// func(sstable.BlockPropertyCollector) sstable.BlockPropertyCollector function
+// that wraps a block property collector so that sstable.Writer passes it the
+// values of SETs, rather than nil, in tables formats that store values outside
+// of data blocks. The values of SETs stored in blob files are only passed if
+// they're added through SSTableWriterAddBlobHandle. It's an untyped
+// interface{} to avoid a cyclic dependency.
+var SSTableValueBlockPropertyCollector interface{}
+
+// SSTableWriterAddBlobHandle is a
+// func(w *sstable.Writer, key base.InternalKey, handle []byte, attr base.AttributeAndLen, value []byte, forceObsolete bool) error
+// function that adds a SET whose value is stored in a blob file, like
+// sstable.Writer.AddBlobHandle, and passes the value to the block property
+// collectors wrapped by SSTableValueBlockPropertyCollector. It's an untyped
+// interface{} to avoid a cyclic dependency.
+var SSTableWriterAddBlobHandle interface{}
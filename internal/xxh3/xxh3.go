@@ -0,0 +1,227 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+// Package xxh3 implements the 64-bit variant of the XXH3 hash function with
+// the default secret and a zero seed, as specified by
+// https://github.com/Cyan4973/xxHash/blob/dev/doc/xxhash_spec.md.
+//
+// The implementation is a portable scalar one. XXH3 is used for sstable block
+// checksums, where it's considerably cheaper than CRC-32C on platforms
+// without a hardware CRC instruction.
+package xxh3 // import "github.com/cockroachdb/pebble/internal/xxh3"
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+const (
+	prime32_1 = 0x9E3779B1
+	prime32_2 = 0x85EBCA77
+	prime32_3 = 0xC2B2AE3D
+
+	prime64_1 = 0x9E3779B185EBCA87
+	prime64_2 = 0xC2B2AE3D27D4EB4F
+	prime64_3 = 0x165667B19E3779F9
+	prime64_4 = 0x85EBCA77C2B2AE63
+	prime64_5 = 0x27D4EB2F165667C5
+
+	stripeLen          = 64
+	secretConsumeRate  = 8
+	accNB              = stripeLen / 8
+	secretSizeMin      = 136
+	secretMergeAccsOff = 11
+	secretLastAccOff   = 7
+	midSizeStartOff    = 3
+	midSizeLastOff     = 17
+	midSizeMax         = 240
+)
+
+// secret is the default XXH3 secret.
+var secret = [192]byte{
+	0xb8, 0xfe, 0x6c, 0x39, 0x23, 0xa4, 0x4b, 0xbe, 0x7c, 0x01, 0x81, 0x2c, 0xf7, 0x21, 0xad, 0x1c,
+	0xde, 0xd4, 0x6d, 0xe9, 0x83, 0x90, 0x97, 0xdb, 0x72, 0x40, 0xa4, 0xa4, 0xb7, 0xb3, 0x67, 0x1f,
+	0xcb, 0x79, 0xe6, 0x4e, 0xcc, 0xc0, 0xe5, 0x78, 0x82, 0x5a, 0xd0, 0x7d, 0xcc, 0xff, 0x72, 0x21,
+	0xb8, 0x08, 0x46, 0x74, 0xf7, 0x43, 0x24, 0x8e, 0xe0, 0x35, 0x90, 0xe6, 0x81, 0x3a, 0x26, 0x4c,
+	0x3c, 0x28, 0x52, 0xbb, 0x91, 0xc3, 0x00, 0xcb, 0x88, 0xd0, 0x65, 0x8b, 0x1b, 0x53, 0x2e, 0xa3,
+	0x71, 0x64, 0x48, 0x97, 0xa2, 0x0d, 0xf9, 0x4e, 0x38, 0x19, 0xef, 0x46, 0xa9, 0xde, 0xac, 0xd8,
+	0xa8, 0xfa, 0x76, 0x3f, 0xe3, 0x9c, 0x34, 0x3f, 0xf9, 0xdc, 0xbb, 0xc7, 0xc7, 0x0b, 0x4f, 0x1d,
+	0x8a, 0x51, 0xe0, 0x4b, 0xcd, 0xb4, 0x59, 0x31, 0xc8, 0x9f, 0x7e, 0xc9, 0xd9, 0x78, 0x73, 0x64,
+	0xea, 0xc5, 0xac, 0x83, 0x34, 0xd3, 0xeb, 0xc3, 0xc5, 0x81, 0xa0, 0xff, 0xfa, 0x13, 0x63, 0xeb,
+	0x17, 0x0d, 0xdd, 0x51, 0xb7, 0xf0, 0xda, 0x49, 0xd3, 0x16, 0x55, 0x26, 0x29, 0xd4, 0x68, 0x9e,
+	0x2b, 0x16, 0xbe, 0x58, 0x7d, 0x47, 0xa1, 0xfc, 0x8f, 0xf8, 0xb8, 0xd1, 0x7a, 0xd0, 0x31, 0xce,
+	0x45, 0xcb, 0x3a, 0x8f, 0x95, 0x16, 0x04, 0x28, 0xaf, 0xd7, 0xfb, 0xca, 0xbb, 0x4b, 0x40, 0x7e,
+}
+
+// Hash64 returns the 64-bit XXH3 hash of b.
+func Hash64(b []byte) uint64 {
+	n := len(b)
+	switch {
+	case n <= 16:
+		return hashLen0To16(b)
+	case n <= 128:
+		return hashLen17To128(b)
+	case n <= midSizeMax:
+		return hashLen129To240(b)
+	default:
+		return hashLong(b)
+	}
+}
+
+func read32(b []byte, i int) uint64 {
+	return uint64(binary.LittleEndian.Uint32(b[i:]))
+}
+
+func read64(b []byte, i int) uint64 {
+	return binary.LittleEndian.Uint64(b[i:])
+}
+
+func mul128Fold64(x, y uint64) uint64 {
+	hi, lo := bits.Mul64(x, y)
+	return hi ^ lo
+}
+
+func xxh64Avalanche(h uint64) uint64 {
+	h ^= h >> 33
+	h *= prime64_2
+	h ^= h >> 29
+	h *= prime64_3
+	h ^= h >> 32
+	return h
+}
+
+func avalanche(h uint64) uint64 {
+	h ^= h >> 37
+	h *= 0x165667919E3779F9
+	h ^= h >> 32
+	return h
+}
+
+func rrmxmx(h, n uint64) uint64 {
+	h ^= bits.RotateLeft64(h, 49) ^ bits.RotateLeft64(h, 24)
+	h *= 0x9FB21C651E98DF25
+	h ^= (h >> 35) + n
+	h *= 0x9FB21C651E98DF25
+	h ^= h >> 28
+	return h
+}
+
+func mix16B(b []byte, i int, secretOff int) uint64 {
+	return mul128Fold64(
+		read64(b, i)^read64(secret[:], secretOff),
+		read64(b, i+8)^read64(secret[:], secretOff+8))
+}
+
+func hashLen0To16(b []byte) uint64 {
+	n := len(b)
+	switch {
+	case n > 8:
+		lo := read64(b, 0) ^ (read64(secret[:], 24) ^ read64(secret[:], 32))
+		hi := read64(b, n-8) ^ (read64(secret[:], 40) ^ read64(secret[:], 48))
+		acc := uint64(n) + bits.ReverseBytes64(lo) + hi + mul128Fold64(lo, hi)
+		return avalanche(acc)
+	case n >= 4:
+		in1 := read32(b, 0)
+		in2 := read32(b, n-4)
+		keyed := (in2 + in1<<32) ^ (read64(secret[:], 8) ^ read64(secret[:], 16))
+		return rrmxmx(keyed, uint64(n))
+	case n > 0:
+		c1 := uint64(b[0])
+		c2 := uint64(b[n>>1])
+		c3 := uint64(b[n-1])
+		combined := c1<<16 | c2<<24 | c3 | uint64(n)<<8
+		return xxh64Avalanche(combined ^ (read32(secret[:], 0) ^ read32(secret[:], 4)))
+	default:
+		return xxh64Avalanche(read64(secret[:], 56) ^ read64(secret[:], 64))
+	}
+}
+
+func hashLen17To128(b []byte) uint64 {
+	n := len(b)
+	acc := uint64(n) * prime64_1
+	if n > 32 {
+		if n > 64 {
+			if n > 96 {
+				acc += mix16B(b, 48, 96)
+				acc += mix16B(b, n-64, 112)
+			}
+			acc += mix16B(b, 32, 64)
+			acc += mix16B(b, n-48, 80)
+		}
+		acc += mix16B(b, 16, 32)
+		acc += mix16B(b, n-32, 48)
+	}
+	acc += mix16B(b, 0, 0)
+	acc += mix16B(b, n-16, 16)
+	return avalanche(acc)
+}
+
+func hashLen129To240(b []byte) uint64 {
+	n := len(b)
+	acc := uint64(n) * prime64_1
+	rounds := n / 16
+	for i := 0; i < 8; i++ {
+		acc += mix16B(b, 16*i, 16*i)
+	}
+	acc = avalanche(acc)
+	for i := 8; i < rounds; i++ {
+		acc += mix16B(b, 16*i, 16*(i-8)+midSizeStartOff)
+	}
+	acc += mix16B(b, n-16, secretSizeMin-midSizeLastOff)
+	return avalanche(acc)
+}
+
+func accumulate512(acc *[accNB]uint64, b []byte, i int, secretOff int) {
+	for j := 0; j < accNB; j++ {
+		v := read64(b, i+8*j)
+		k := v ^ read64(secret[:], secretOff+8*j)
+		acc[j^1] += v
+		acc[j] += (k & 0xFFFFFFFF) * (k >> 32)
+	}
+}
+
+func scramble(acc *[accNB]uint64) {
+	const off = len(secret) - stripeLen
+	for j := 0; j < accNB; j++ {
+		a := acc[j]
+		a ^= a >> 47
+		a ^= read64(secret[:], off+8*j)
+		a *= prime32_1
+		acc[j] = a
+	}
+}
+
+func hashLong(b []byte) uint64 {
+	const stripesPerBlock = (len(secret) - stripeLen) / secretConsumeRate
+	const blockLen = stripeLen * stripesPerBlock
+
+	acc := [accNB]uint64{
+		prime32_3, prime64_1, prime64_2, prime64_3,
+		prime64_4, prime32_2, prime64_5, prime32_1,
+	}
+	n := len(b)
+	blocks := (n - 1) / blockLen
+	for i := 0; i < blocks; i++ {
+		for s := 0; s < stripesPerBlock; s++ {
+			accumulate512(&acc, b, i*blockLen+s*stripeLen, s*secretConsumeRate)
+		}
+		scramble(&acc)
+	}
+	// The last partial block.
+	stripes := ((n - 1) - blockLen*blocks) / stripeLen
+	for s := 0; s < stripes; s++ {
+		accumulate512(&acc, b, blocks*blockLen+s*stripeLen, s*secretConsumeRate)
+	}
+	// The last stripe.
+	accumulate512(&acc, b, n-stripeLen, len(secret)-stripeLen-secretLastAccOff)
+
+	// Merge the accumulators.
+	result := uint64(n) * prime64_1
+	for j := 0; j < accNB/2; j++ {
+		result += mul128Fold64(
+			acc[2*j]^read64(secret[:], secretMergeAccsOff+16*j),
+			acc[2*j+1]^read64(secret[:], secretMergeAccsOff+16*j+8))
+	}
+	return avalanche(result)
+}
@@ -0,0 +1,54 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package xxh3
+
+import "testing"
+
+func TestHash64(t *testing.T) {
+	// The expected values are produced by the reference C implementation
+	// (XXH3_64bits) over inputs where the i'th byte is (i+1)%251. The lengths
+	// exercise each of the size classes and their boundaries.
+	testCases := []struct {
+		n    int
+		want uint64
+	}{
+		{0, 0x2d06800538d394c2},
+		{1, 0xe12ef9d2eb86ceeb},
+		{2, 0x08130b77ddef5807},
+		{3, 0xebce9b7632ae733b},
+		{4, 0x988b7b9033ac4622},
+		{8, 0x16f217ea16232297},
+		{9, 0x17d143e7f447850a},
+		{16, 0xeb5aeb9a32450f6a},
+		{17, 0x6d458e1fff494078},
+		{64, 0xc82013245d8f2587},
+		{96, 0xd384b43b482e2615},
+		{128, 0xce22cae9106851df},
+		{129, 0x7d4fc663f5958d40},
+		{240, 0xa5a910b2d7e065b0},
+		{241, 0xb6515f490cdd4ce5},
+		{1024, 0x546f61a5b0b850c1},
+		{1025, 0xa58696e72de6df58},
+		{2500, 0x5cc245daff08e088},
+		{4095, 0x268198759d7bdf74},
+	}
+	buf := make([]byte, 4096)
+	for i := range buf {
+		buf[i] = byte((i + 1) % 251)
+	}
+	for _, tc := range testCases {
+		if got := Hash64(buf[:tc.n]); got != tc.want {
+			t.Errorf("Hash64(len=%d) = %016x, want %016x", tc.n, got, tc.want)
+		}
+	}
+}
+
+func BenchmarkHash64(b *testing.B) {
+	buf := make([]byte, 32<<10)
+	b.SetBytes(int64(len(buf)))
+	for i := 0; i < b.N; i++ {
+		_ = Hash64(buf)
+	}
+}
@@ -0,0 +1,485 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/rate"
+	"github.com/cockroachdb/pebble/objstorage"
+)
+
+// IOClass classifies the sstable IO performed by a DB for the purpose of
+// scheduling disk bandwidth. See IOScheduler.
+type IOClass int8
+
+const (
+	// IOClassForegroundRead is the class of sstable reads by iterators and
+	// Gets. Reads of index, filter and other metadata blocks by compactions
+	// are also in this class.
+	IOClassForegroundRead IOClass = iota
+	// IOClassCompactionRead is the class of sstable data block reads by
+	// compactions.
+	IOClassCompactionRead
+	// IOClassFlushWrite is the class of sstable writes by flushes.
+	IOClassFlushWrite
+	// IOClassCompactionWrite is the class of sstable writes by compactions.
+	IOClassCompactionWrite
+	// NumIOClasses is the number of IO classes.
+	NumIOClasses
+)
+
+// String implements fmt.Stringer.
+func (c IOClass) String() string {
+	switch c {
+	case IOClassForegroundRead:
+		return "foreground-read"
+	case IOClassCompactionRead:
+		return "compaction-read"
+	case IOClassFlushWrite:
+		return "flush-write"
+	case IOClassCompactionWrite:
+		return "compaction-write"
+	}
+	return "unknown"
+}
+
+// IOScheduler grants disk bandwidth to the sstable reads and writes of a DB.
+// Each read and write acquires bandwidth for its bytes before it is performed.
+// See Options.IOScheduler.
+type IOScheduler interface {
+	// Acquire blocks until n bytes of IO of the given class may be performed.
+	// Acquire is called concurrently, from the goroutines performing the IO.
+	Acquire(class IOClass, n int)
+}
+
+// IOLatencyObserver may be implemented by an IOScheduler to observe the
+// latency of the sstable reads it granted bandwidth to, so that it may adapt
+// its schedule to the latency experienced by foreground reads.
+type IOLatencyObserver interface {
+	// ObserveLatency is called after a read of n bytes of the given class
+	// completes, with the time the read took once granted bandwidth.
+	// ObserveLatency is called concurrently, from the goroutines performing
+	// the reads.
+	ObserveLatency(class IOClass, n int, latency time.Duration)
+}
+
+// ioSchedulerBurst is the number of bytes an IO class may transfer in a burst
+// before being throttled by a BandwidthScheduler.
+const ioSchedulerBurst = 1 << 20 // 1 MB
+
+// BandwidthScheduler is an IOScheduler that limits the total bandwidth of all
+// IO classes, and reserves a minimum share of that bandwidth for each IO
+// class. The IO of the other classes is throttled so that it never uses a
+// class's reserved share, even when the class is idle. For example, reserving
+// 0.3 for IOClassForegroundRead with a bandwidth of 100 MB/s limits flushes
+// and compactions to 70 MB/s combined, leaving reads at least 30 MB/s.
+type BandwidthScheduler struct {
+	// bytesPerSec is the current bandwidth, or 0 if IO is not limited.
+	bytesPerSec atomic.Int64
+	reserved    [NumIOClasses]float64
+	// total limits the IO of all classes to bytesPerSec.
+	total *rate.Limiter
+	// others limits, for each class c, the IO of all classes other than c to
+	// the bandwidth not reserved for c.
+	others [NumIOClasses]*rate.Limiter
+}
+
+var _ IOScheduler = (*BandwidthScheduler)(nil)
+
+// NewBandwidthScheduler returns a BandwidthScheduler that limits IO to
+// bytesPerSec, and reserves the fraction reserved[c] of the bandwidth for
+// each IO class c. The reserved fractions must be in [0,1) and sum to at most
+// 1. A bytesPerSec of 0 does not limit IO.
+func NewBandwidthScheduler(
+	bytesPerSec int64, reserved [NumIOClasses]float64,
+) (*BandwidthScheduler, error) {
+	if bytesPerSec < 0 {
+		return nil, errors.Errorf("pebble: negative IO bandwidth %d", bytesPerSec)
+	}
+	var sum float64
+	for c, r := range reserved {
+		if r < 0 || r >= 1 {
+			return nil, errors.Errorf("pebble: reserved share %.2f of %s not in [0,1)", r, IOClass(c))
+		}
+		sum += r
+	}
+	if sum > 1 {
+		return nil, errors.Errorf("pebble: reserved shares sum to %.2f, more than 1", sum)
+	}
+	s := &BandwidthScheduler{reserved: reserved}
+	s.total = rate.NewLimiter(float64(bytesPerSec), ioSchedulerBurst)
+	for c := range s.others {
+		s.others[c] = rate.NewLimiter(float64(bytesPerSec)*(1-reserved[c]), ioSchedulerBurst)
+	}
+	s.bytesPerSec.Store(bytesPerSec)
+	return s, nil
+}
+
+// Acquire implements IOScheduler.
+func (s *BandwidthScheduler) Acquire(class IOClass, n int) {
+	if s.bytesPerSec.Load() == 0 {
+		return
+	}
+	for c, l := range s.others {
+		if IOClass(c) != class {
+			l.Wait(float64(n))
+		}
+	}
+	s.total.Wait(float64(n))
+}
+
+// SetBandwidth sets the total bandwidth, in bytes per second. A value of 0
+// removes the limit. The reserved shares are unchanged.
+func (s *BandwidthScheduler) SetBandwidth(bytesPerSec int64) {
+	if bytesPerSec < 0 {
+		bytesPerSec = 0
+	}
+	if bytesPerSec > 0 {
+		s.total.SetRate(float64(bytesPerSec))
+		for c, l := range s.others {
+			l.SetRate(float64(bytesPerSec) * (1 - s.reserved[c]))
+		}
+	}
+	s.bytesPerSec.Store(bytesPerSec)
+}
+
+// Bandwidth returns the current total bandwidth, in bytes per second, or 0 if
+// IO is not limited.
+func (s *BandwidthScheduler) Bandwidth() int64 {
+	return s.bytesPerSec.Load()
+}
+
+// FairIOSchedulerOptions configure a FairIOScheduler.
+type FairIOSchedulerOptions struct {
+	// Bandwidth is the total bandwidth, in bytes per second, divided between
+	// the IO classes. A value of 0 does not limit IO, except while foreground
+	// reads are degraded (see ReadLatencyTarget).
+	Bandwidth int64
+	// Weights are the relative weights of the IO classes. The bandwidth is
+	// divided between the classes that performed IO recently in proportion to
+	// their weights, so that the share of an idle class is used by the
+	// others. A class with a zero weight is never throttled, and does not take
+	// a share of the bandwidth.
+	Weights [NumIOClasses]float64
+	// ReadLatencyTarget, if set, is the moving average latency of foreground
+	// reads above which they are considered degraded. While they are, the
+	// background classes (all classes but IOClassForegroundRead) are starved:
+	// they share StarvedBandwidth in proportion to their weights, regardless
+	// of Bandwidth. Foreground read latency is only observed for reads of
+	// sstables opened through a DB configured with the scheduler as
+	// Options.IOScheduler.
+	ReadLatencyTarget time.Duration
+	// StarvedBandwidth is the bandwidth, in bytes per second, shared by the
+	// background classes while foreground reads are degraded. If zero, it
+	// defaults to 1 MB/s, which allows flushes to make slow progress.
+	StarvedBandwidth int64
+}
+
+func (o *FairIOSchedulerOptions) validate() error {
+	if o.Bandwidth < 0 {
+		return errors.Errorf("pebble: negative IO bandwidth %d", o.Bandwidth)
+	}
+	if o.StarvedBandwidth < 0 {
+		return errors.Errorf("pebble: negative starved IO bandwidth %d", o.StarvedBandwidth)
+	}
+	if o.ReadLatencyTarget < 0 {
+		return errors.Errorf("pebble: negative read latency target %s", o.ReadLatencyTarget)
+	}
+	for c, w := range o.Weights {
+		if w < 0 {
+			return errors.Errorf("pebble: negative weight %.2f of %s", w, IOClass(c))
+		}
+	}
+	return nil
+}
+
+const (
+	// fairIOActiveWindow is the duration after its last IO during which an IO
+	// class takes a share of the bandwidth of a FairIOScheduler. A class with
+	// IO waiting for bandwidth is always active.
+	fairIOActiveWindow = 100 * time.Millisecond
+	// fairIOLatencyWindow is the duration after the last foreground read
+	// during which its latency is considered to be current. Once it lapses,
+	// the background classes are no longer starved.
+	fairIOLatencyWindow = time.Second
+	// fairIOLatencyAlpha is the smoothing factor of the moving average of
+	// foreground read latency.
+	fairIOLatencyAlpha = 0.1
+	// fairIODefaultStarvedBandwidth is the default value of
+	// FairIOSchedulerOptions.StarvedBandwidth.
+	fairIODefaultStarvedBandwidth = 1 << 20 // 1 MB/s
+)
+
+// FairIOScheduler is an IOScheduler that divides a total bandwidth between
+// the IO classes by weights, and starves the background classes while the
+// latency of foreground reads is degraded. Its options may be changed at any
+// time with SetOptions.
+type FairIOScheduler struct {
+	timeNow func() time.Time
+
+	// limiters throttle the IO of each class to its share of the bandwidth.
+	limiters [NumIOClasses]*rate.Limiter
+
+	mu struct {
+		sync.Mutex
+		opts FairIOSchedulerOptions
+		// lastIO is the time of the most recent IO of each class, and waiting
+		// the number of IOs of each class waiting for bandwidth.
+		lastIO  [NumIOClasses]time.Time
+		waiting [NumIOClasses]int
+		// readLatency is the moving average of foreground read latency, and
+		// lastRead the time at which the latest read was observed.
+		readLatency float64
+		lastRead    time.Time
+		// rates is the current rate of each class's limiter, or 0 if the class
+		// is not throttled.
+		rates [NumIOClasses]float64
+	}
+}
+
+var _ IOScheduler = (*FairIOScheduler)(nil)
+var _ IOLatencyObserver = (*FairIOScheduler)(nil)
+
+// NewFairIOScheduler returns a FairIOScheduler configured by the provided
+// options.
+func NewFairIOScheduler(opts FairIOSchedulerOptions) (*FairIOScheduler, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	s := &FairIOScheduler{timeNow: time.Now}
+	for c := range s.limiters {
+		s.limiters[c] = rate.NewLimiter(0, ioSchedulerBurst)
+	}
+	s.mu.opts = opts
+	return s, nil
+}
+
+// Acquire implements IOScheduler.
+func (s *FairIOScheduler) Acquire(class IOClass, n int) {
+	s.mu.Lock()
+	s.mu.lastIO[class] = s.timeNow()
+	s.updateRatesLocked()
+	throttled := s.mu.rates[class] > 0
+	if throttled {
+		s.mu.waiting[class]++
+	}
+	s.mu.Unlock()
+	if throttled {
+		s.limiters[class].Wait(float64(n))
+		s.mu.Lock()
+		s.mu.waiting[class]--
+		s.mu.lastIO[class] = s.timeNow()
+		s.mu.Unlock()
+	}
+}
+
+// ObserveLatency implements IOLatencyObserver.
+func (s *FairIOScheduler) ObserveLatency(class IOClass, n int, latency time.Duration) {
+	if class != IOClassForegroundRead {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.timeNow()
+	if now.Sub(s.mu.lastRead) > fairIOLatencyWindow {
+		// The average is stale: restart it from this read.
+		s.mu.readLatency = float64(latency)
+	} else {
+		s.mu.readLatency += fairIOLatencyAlpha * (float64(latency) - s.mu.readLatency)
+	}
+	s.mu.lastRead = now
+}
+
+// starvedLocked returns true if the background classes are starved because
+// foreground reads are degraded.
+//
+// s.mu must be held when calling this.
+func (s *FairIOScheduler) starvedLocked(now time.Time) bool {
+	target := s.mu.opts.ReadLatencyTarget
+	return target > 0 && now.Sub(s.mu.lastRead) <= fairIOLatencyWindow &&
+		s.mu.readLatency > float64(target)
+}
+
+// updateRatesLocked divides the bandwidth between the active classes, and
+// updates the rates of the limiters that changed.
+//
+// s.mu must be held when calling this.
+func (s *FairIOScheduler) updateRatesLocked() {
+	now := s.timeNow()
+	opts := &s.mu.opts
+	starved := s.starvedLocked(now)
+	starvedBandwidth := opts.StarvedBandwidth
+	if starvedBandwidth == 0 {
+		starvedBandwidth = fairIODefaultStarvedBandwidth
+	}
+
+	var active [NumIOClasses]bool
+	var weightSum, backgroundWeightSum float64
+	for c, w := range opts.Weights {
+		if w == 0 || (s.mu.waiting[c] == 0 && now.Sub(s.mu.lastIO[c]) > fairIOActiveWindow) {
+			continue
+		}
+		active[c] = true
+		weightSum += w
+		if IOClass(c) != IOClassForegroundRead {
+			backgroundWeightSum += w
+		}
+	}
+	for c, w := range opts.Weights {
+		// An inactive class is granted the share it would have once active,
+		// which is recomputed on its next IO.
+		sum, backgroundSum := weightSum, backgroundWeightSum
+		if !active[c] {
+			sum += w
+			backgroundSum += w
+		}
+		var r float64
+		switch {
+		case w == 0:
+		case starved && IOClass(c) != IOClassForegroundRead:
+			r = float64(starvedBandwidth) * w / backgroundSum
+		case opts.Bandwidth > 0:
+			r = float64(opts.Bandwidth) * w / sum
+		}
+		if r != s.mu.rates[c] {
+			if r > 0 {
+				s.limiters[c].SetRate(r)
+			}
+			s.mu.rates[c] = r
+		}
+	}
+}
+
+// SetOptions replaces the options of the scheduler. The new options apply to
+// subsequent IO, and to the IO of classes that remain throttled.
+func (s *FairIOScheduler) SetOptions(opts FairIOSchedulerOptions) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.opts = opts
+	s.updateRatesLocked()
+	return nil
+}
+
+// Options returns the current options of the scheduler.
+func (s *FairIOScheduler) Options() FairIOSchedulerOptions {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mu.opts
+}
+
+// SetBandwidth sets the total bandwidth, in bytes per second. A value of 0
+// removes the limit. The other options are unchanged.
+func (s *FairIOScheduler) SetBandwidth(bytesPerSec int64) {
+	if bytesPerSec < 0 {
+		bytesPerSec = 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.opts.Bandwidth = bytesPerSec
+	s.updateRatesLocked()
+}
+
+// Bandwidth returns the current total bandwidth, in bytes per second, or 0 if
+// IO is not limited.
+func (s *FairIOScheduler) Bandwidth() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mu.opts.Bandwidth
+}
+
+// Starved returns true if the background classes are currently starved
+// because the latency of foreground reads exceeds
+// FairIOSchedulerOptions.ReadLatencyTarget, along with that latency.
+func (s *FairIOScheduler) Starved() (bool, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.starvedLocked(s.timeNow()), time.Duration(s.mu.readLatency)
+}
+
+// ioScheduledWritable is an objstorage.Writable wrapper that acquires
+// bandwidth from an IOScheduler for its writes.
+type ioScheduledWritable struct {
+	objstorage.Writable
+
+	scheduler IOScheduler
+	class     IOClass
+}
+
+// Write is part of the objstorage.Writable interface.
+func (w *ioScheduledWritable) Write(p []byte) error {
+	w.scheduler.Acquire(w.class, len(p))
+	return w.Writable.Write(p)
+}
+
+// ioScheduledReadable is an objstorage.Readable wrapper that acquires
+// bandwidth from an IOScheduler for its reads. Reads through read handles set
+// up for compactions are in IOClassCompactionRead, and all other reads are
+// in IOClassForegroundRead.
+type ioScheduledReadable struct {
+	objstorage.Readable
+
+	scheduler IOScheduler
+}
+
+// ReadAt is part of the objstorage.Readable interface.
+func (r *ioScheduledReadable) ReadAt(ctx context.Context, p []byte, off int64) error {
+	return scheduledRead(r.scheduler, IOClassForegroundRead, p, func() error {
+		return r.Readable.ReadAt(ctx, p, off)
+	})
+}
+
+// NewReadHandle is part of the objstorage.Readable interface.
+func (r *ioScheduledReadable) NewReadHandle(ctx context.Context) objstorage.ReadHandle {
+	return &ioScheduledReadHandle{
+		ReadHandle: r.Readable.NewReadHandle(ctx),
+		scheduler:  r.scheduler,
+		class:      IOClassForegroundRead,
+	}
+}
+
+// ioScheduledReadHandle is the objstorage.ReadHandle of an
+// ioScheduledReadable.
+type ioScheduledReadHandle struct {
+	objstorage.ReadHandle
+
+	scheduler IOScheduler
+	class     IOClass
+}
+
+// ReadAt is part of the objstorage.ReadHandle interface.
+func (h *ioScheduledReadHandle) ReadAt(ctx context.Context, p []byte, off int64) error {
+	return scheduledRead(h.scheduler, h.class, p, func() error {
+		return h.ReadHandle.ReadAt(ctx, p, off)
+	})
+}
+
+// SetupForCompaction is part of the objstorage.ReadHandle interface.
+func (h *ioScheduledReadHandle) SetupForCompaction() {
+	h.class = IOClassCompactionRead
+	h.ReadHandle.SetupForCompaction()
+}
+
+// scheduledRead acquires bandwidth for the read of p from the scheduler, and
+// performs the read. If the scheduler is an IOLatencyObserver, it observes
+// the latency of the read.
+func scheduledRead(scheduler IOScheduler, class IOClass, p []byte, read func() error) error {
+	scheduler.Acquire(class, len(p))
+	observer, ok := scheduler.(IOLatencyObserver)
+	if !ok {
+		return read()
+	}
+	start := time.Now()
+	err := read()
+	observer.ObserveLatency(class, len(p), time.Since(start))
+	return err
+}
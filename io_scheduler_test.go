@@ -0,0 +1,303 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/rate"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBandwidthScheduler(t *testing.T) {
+	_, err := NewBandwidthScheduler(-1, [NumIOClasses]float64{})
+	require.Error(t, err)
+	_, err = NewBandwidthScheduler(1, [NumIOClasses]float64{IOClassForegroundRead: 1})
+	require.Error(t, err)
+	_, err = NewBandwidthScheduler(1, [NumIOClasses]float64{0.5, 0.5, 0.5})
+	require.Error(t, err)
+
+	const bytesPerSec = 1 << 20
+	reserved := [NumIOClasses]float64{IOClassForegroundRead: 0.5}
+	newScheduler := func() (*BandwidthScheduler, func() time.Duration) {
+		s, err := NewBandwidthScheduler(bytesPerSec, reserved)
+		require.NoError(t, err)
+
+		// Replace the limiters' clocks with one that advances only when a
+		// limiter sleeps.
+		var mu sync.Mutex
+		var now time.Time
+		var slept time.Duration
+		newLimiter := func(r float64) *rate.Limiter {
+			return rate.NewLimiterWithCustomTime(r, ioSchedulerBurst,
+				func() time.Time {
+					mu.Lock()
+					defer mu.Unlock()
+					return now
+				},
+				func(d time.Duration) {
+					mu.Lock()
+					defer mu.Unlock()
+					now = now.Add(d)
+					slept += d
+				})
+		}
+		s.total = newLimiter(bytesPerSec)
+		for c := range s.others {
+			s.others[c] = newLimiter(bytesPerSec * (1 - reserved[c]))
+		}
+		return s, func() time.Duration {
+			mu.Lock()
+			defer mu.Unlock()
+			return slept
+		}
+	}
+
+	const n = 10*bytesPerSec + ioSchedulerBurst
+	// Compaction writes are limited to the bandwidth not reserved for reads.
+	s, slept := newScheduler()
+	for i := 0; i < n/1024; i++ {
+		s.Acquire(IOClassCompactionWrite, 1024)
+	}
+	require.InDelta(t, float64(20*time.Second), float64(slept()), float64(time.Second))
+
+	// Reads may use the entire bandwidth.
+	s, slept = newScheduler()
+	for i := 0; i < n/1024; i++ {
+		s.Acquire(IOClassForegroundRead, 1024)
+	}
+	require.InDelta(t, float64(10*time.Second), float64(slept()), float64(time.Second))
+
+	// Removing the limit stops throttling.
+	s.SetBandwidth(0)
+	require.Zero(t, s.Bandwidth())
+	before := slept()
+	for i := 0; i < n/1024; i++ {
+		s.Acquire(IOClassCompactionWrite, 1024)
+	}
+	require.Equal(t, before, slept())
+}
+
+// recordingIOScheduler is an IOScheduler that records the bytes acquired by
+// each IO class.
+type recordingIOScheduler struct {
+	mu    sync.Mutex
+	bytes [NumIOClasses]int
+}
+
+func (s *recordingIOScheduler) Acquire(class IOClass, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bytes[class] += n
+}
+
+func TestIOSchedulerClasses(t *testing.T) {
+	s := &recordingIOScheduler{}
+	d, err := Open("", &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+		IOScheduler:                 s,
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 100; j++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("key%03d", j)), []byte("val"), nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+	_, closer, err := d.Get([]byte("key050"))
+	require.NoError(t, err)
+	require.NoError(t, closer.Close())
+	require.NoError(t, d.Compact([]byte("key"), []byte("kez"), false))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := IOClass(0); c < NumIOClasses; c++ {
+		require.Positive(t, s.bytes[c], "%s", c)
+	}
+}
+
+// useFakeClock replaces the clock of the scheduler and its limiters with one
+// that advances only when a limiter sleeps, and returns a function reporting
+// the total time slept.
+func useFakeClock(s *FairIOScheduler) func() time.Duration {
+	var mu sync.Mutex
+	var now time.Time
+	var slept time.Duration
+	nowFn := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.timeNow = nowFn
+	for c := range s.limiters {
+		s.limiters[c] = rate.NewLimiterWithCustomTime(0, ioSchedulerBurst, nowFn,
+			func(d time.Duration) {
+				mu.Lock()
+				defer mu.Unlock()
+				now = now.Add(d)
+				slept += d
+			})
+		s.mu.rates[c] = 0
+	}
+	return func() time.Duration {
+		mu.Lock()
+		defer mu.Unlock()
+		return slept
+	}
+}
+
+func TestFairIOScheduler(t *testing.T) {
+	_, err := NewFairIOScheduler(FairIOSchedulerOptions{Bandwidth: -1})
+	require.Error(t, err)
+	_, err = NewFairIOScheduler(FairIOSchedulerOptions{
+		Weights: [NumIOClasses]float64{IOClassFlushWrite: -1},
+	})
+	require.Error(t, err)
+
+	const mb = 1 << 20
+	s, err := NewFairIOScheduler(FairIOSchedulerOptions{
+		Bandwidth: 8 * mb,
+		Weights: [NumIOClasses]float64{
+			IOClassForegroundRead:  4,
+			IOClassFlushWrite:      3,
+			IOClassCompactionWrite: 1,
+		},
+		ReadLatencyTarget: 10 * time.Millisecond,
+		StarvedBandwidth:  mb,
+	})
+	require.NoError(t, err)
+	now := time.Unix(0, 0)
+	s.timeNow = func() time.Time { return now }
+	rates := func() [NumIOClasses]float64 {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.mu.rates
+	}
+
+	// The bandwidth is divided between the active classes by weights.
+	s.Acquire(IOClassForegroundRead, 1)
+	s.Acquire(IOClassFlushWrite, 1)
+	s.Acquire(IOClassCompactionWrite, 1)
+	s.Acquire(IOClassCompactionRead, 1)
+	require.Equal(t, [NumIOClasses]float64{
+		IOClassForegroundRead:  4 * mb,
+		IOClassFlushWrite:      3 * mb,
+		IOClassCompactionWrite: 1 * mb,
+	}, rates())
+
+	// The share of an idle class is used by the others.
+	now = now.Add(time.Second)
+	s.Acquire(IOClassFlushWrite, 1)
+	s.Acquire(IOClassCompactionWrite, 1)
+	require.Equal(t, 6*mb, int(rates()[IOClassFlushWrite]))
+	require.Equal(t, 2*mb, int(rates()[IOClassCompactionWrite]))
+
+	// Degraded foreground reads starve the background classes.
+	s.ObserveLatency(IOClassForegroundRead, 1, 5*time.Millisecond)
+	starved, _ := s.Starved()
+	require.False(t, starved)
+	for i := 0; i < 20; i++ {
+		s.ObserveLatency(IOClassForegroundRead, 1, 50*time.Millisecond)
+	}
+	// Latency of other classes is ignored.
+	s.ObserveLatency(IOClassCompactionRead, 1, time.Hour)
+	starved, latency := s.Starved()
+	require.True(t, starved)
+	require.Greater(t, latency, 10*time.Millisecond)
+	require.Less(t, latency, 50*time.Millisecond)
+	s.Acquire(IOClassForegroundRead, 1)
+	s.Acquire(IOClassFlushWrite, 1)
+	s.Acquire(IOClassCompactionWrite, 1)
+	require.Equal(t, [NumIOClasses]float64{
+		IOClassForegroundRead:  4 * mb,
+		IOClassFlushWrite:      0.75 * mb,
+		IOClassCompactionWrite: 0.25 * mb,
+	}, rates())
+
+	// Once no foreground reads are observed, the latency is no longer current
+	// and the background classes are no longer starved.
+	now = now.Add(2 * time.Second)
+	starved, _ = s.Starved()
+	require.False(t, starved)
+	s.Acquire(IOClassFlushWrite, 1)
+	require.Equal(t, 8*mb, int(rates()[IOClassFlushWrite]))
+
+	// Options may be changed at runtime.
+	opts := s.Options()
+	opts.Weights[IOClassCompactionWrite] = 3
+	require.NoError(t, s.SetOptions(opts))
+	s.Acquire(IOClassCompactionWrite, 1)
+	require.Equal(t, 4*mb, int(rates()[IOClassFlushWrite]))
+	require.Equal(t, 4*mb, int(rates()[IOClassCompactionWrite]))
+	opts.StarvedBandwidth = -1
+	require.Error(t, s.SetOptions(opts))
+
+	// Removing the limit stops throttling.
+	s.SetBandwidth(0)
+	require.Zero(t, s.Bandwidth())
+	require.Equal(t, [NumIOClasses]float64{}, rates())
+}
+
+func TestFairIOSchedulerThrottles(t *testing.T) {
+	const bytesPerSec = 1 << 20
+	s, err := NewFairIOScheduler(FairIOSchedulerOptions{
+		Bandwidth: bytesPerSec,
+		Weights:   [NumIOClasses]float64{IOClassFlushWrite: 1, IOClassCompactionWrite: 1},
+	})
+	require.NoError(t, err)
+	slept := useFakeClock(s)
+
+	// Two active classes of equal weights each get half the bandwidth.
+	const n = 5*bytesPerSec + ioSchedulerBurst
+	for i := 0; i < n/1024; i++ {
+		s.Acquire(IOClassFlushWrite, 1024)
+		s.Acquire(IOClassCompactionWrite, 1024)
+	}
+	require.InDelta(t, float64(10*time.Second), float64(slept()), float64(time.Second))
+
+	// Classes with a zero weight are not throttled.
+	before := slept()
+	for i := 0; i < n/1024; i++ {
+		s.Acquire(IOClassForegroundRead, 1024)
+	}
+	require.Equal(t, before, slept())
+}
+
+func TestFairIOSchedulerObservesReadLatency(t *testing.T) {
+	s, err := NewFairIOScheduler(FairIOSchedulerOptions{ReadLatencyTarget: time.Nanosecond})
+	require.NoError(t, err)
+	d, err := Open("", &Options{
+		FS:          vfs.NewMem(),
+		IOScheduler: s,
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Flush())
+	starved, _ := s.Starved()
+	require.False(t, starved)
+
+	// Reading the sstable observes the latency of the foreground reads.
+	_, closer, err := d.Get([]byte("a"))
+	require.NoError(t, err)
+	require.NoError(t, closer.Close())
+	starved, latency := s.Starved()
+	require.True(t, starved)
+	require.Positive(t, latency)
+}
@@ -312,6 +312,9 @@ type Iterator struct {
 	// they won't be used, so that Close() doesn't need to default to closing
 	// point iterators twice.
 	closePointIterOnce bool
+	// bufferPool holds the buffers for blocks read by the point iterator when
+	// IterOptions.SkipCacheAdmission is set, in lieu of the block cache.
+	bufferPool *sstable.BufferPool
 	// Used in some tests to disable the random disabling of seek optimizations.
 	forceEnableSeekOpt bool
 	// Set to true if NextPrefix is not currently permitted. Defaults to false
@@ -778,6 +781,10 @@ func (i *Iterator) maybeSampleRead() {
 }
 
 func (i *Iterator) sampleRead() {
+	minOverlappingLevels := i.readState.db.opts.Experimental.ReadCompactionOverlappingLevels
+	if minOverlappingLevels < 2 {
+		minOverlappingLevels = 2
+	}
 	var topFile *manifest.FileMetadata
 	topLevel, numOverlappingLevels := numLevels, 0
 	if mi, ok := i.iter.(*mergingIter); ok {
@@ -802,12 +809,14 @@ func (i *Iterator) sampleRead() {
 					// https://github.com/cockroachdb/pebble/pull/1041#issuecomment-763226492
 					if containsKey {
 						numOverlappingLevels++
-						if numOverlappingLevels >= 2 {
-							// Terminate the loop early if at least 2 overlapping levels are found.
+						if numOverlappingLevels == 1 {
+							topLevel = l
+							topFile = f
+						}
+						if numOverlappingLevels >= minOverlappingLevels {
+							// Terminate the loop early if enough overlapping levels are found.
 							return true
 						}
-						topLevel = l
-						topFile = f
 					}
 				}
 				return false
@@ -817,7 +826,7 @@ func (i *Iterator) sampleRead() {
 	if topFile == nil || topLevel >= numLevels {
 		return
 	}
-	if numOverlappingLevels >= 2 {
+	if numOverlappingLevels >= minOverlappingLevels {
 		allowedSeeks := topFile.AllowedSeeks.Add(-1)
 		if allowedSeeks == 0 {
 
@@ -2202,6 +2211,12 @@ func (i *Iterator) Close() error {
 			i.err = firstError(i.err, i.rangeKey.rangeKeyIter.Close())
 		}
 	}
+	if i.bufferPool != nil {
+		// All of the point iterator's buffers have been returned to the pool
+		// now that it's closed.
+		i.bufferPool.Release()
+		i.bufferPool = nil
+	}
 	err := i.err
 
 	if i.readState != nil {
@@ -2513,6 +2528,14 @@ func (i *Iterator) SetOptions(o *IterOptions) {
 		initialized: i.rangeKey != nil || !i.opts.rangeKeys(),
 	}
 
+	// The point iterator's levels are configured with the iterator's buffer
+	// pool when it's constructed, so it must be reconstructed if the cache
+	// admission policy changed.
+	if i.pointIter != nil && o.SkipCacheAdmission != i.opts.SkipCacheAdmission {
+		i.err = firstError(i.err, i.pointIter.Close())
+		i.pointIter = nil
+	}
+
 	boundsEqual := ((i.opts.LowerBound == nil) == (o.LowerBound == nil)) &&
 		((i.opts.UpperBound == nil) == (o.UpperBound == nil)) &&
 		i.equal(i.opts.LowerBound, o.LowerBound) &&
@@ -622,6 +622,9 @@ func TestReadSampling(t *testing.T) {
 				func() TablePropertyCollector {
 					return &minSeqNumPropertyCollector{}
 				})
+			if td.HasArg("overlapping-levels") {
+				td.ScanArgs(t, "overlapping-levels", &opts.Experimental.ReadCompactionOverlappingLevels)
+			}
 
 			var err error
 			if d, err = runDBDefineCmd(td, opts); err != nil {
@@ -1491,6 +1494,69 @@ func TestIteratorGuaranteedDurable(t *testing.T) {
 	})
 }
 
+func TestIteratorSkipCacheAdmission(t *testing.T) {
+	mem := vfs.NewMem()
+	cache := NewCache(128 << 20)
+	defer cache.Unref()
+	opts := &Options{FS: mem, Cache: cache}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	const n = 1000
+	for i := 0; i < n; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("key%05d", i)), bytes.Repeat([]byte("v"), 100), nil))
+	}
+	require.NoError(t, d.Flush())
+	d.mu.Lock()
+	d.waitTableStats()
+	d.mu.Unlock()
+	// Load the table's index block into the cache.
+	_, closer, err := d.Get([]byte("key00000"))
+	require.NoError(t, err)
+	require.NoError(t, closer.Close())
+
+	scan := func(o *IterOptions) {
+		iter, _ := d.NewIter(o)
+		count := 0
+		for valid := iter.First(); valid; valid = iter.Next() {
+			count++
+		}
+		require.Equal(t, n, count)
+		require.NoError(t, iter.Close())
+	}
+
+	// A scan that skips cache admission doesn't add blocks to the cache.
+	before := cache.Metrics()
+	scan(&IterOptions{SkipCacheAdmission: true})
+	after := cache.Metrics()
+	require.Equal(t, before.Count, after.Count)
+	require.Equal(t, before.Size, after.Size)
+
+	// A regular scan does.
+	scan(nil)
+	after = cache.Metrics()
+	require.Greater(t, after.Count, before.Count)
+
+	// A scan that skips cache admission still uses blocks already present in
+	// the cache.
+	before = after
+	scan(&IterOptions{SkipCacheAdmission: true})
+	after = cache.Metrics()
+	require.Equal(t, before.Count, after.Count)
+	require.Greater(t, after.Hits, before.Hits)
+
+	// Changing the option through SetOptions reconstructs the iterator.
+	iter, _ := d.NewIter(nil)
+	require.True(t, iter.First())
+	iter.SetOptions(&IterOptions{SkipCacheAdmission: true})
+	require.True(t, iter.Last())
+	require.Equal(t, []byte(fmt.Sprintf("key%05d", n-1)), iter.Key())
+	require.NoError(t, iter.Close())
+}
+
 func TestIteratorBoundsLifetimes(t *testing.T) {
 	rng := rand.New(rand.NewSource(uint64(time.Now().UnixNano())))
 	d := newPointTestkeysDatabase(t, testkeys.Alpha(2))
@@ -1747,6 +1813,7 @@ func testSetOptionsEquivalence(t *testing.T, seed uint64) {
 		if o.KeyTypes == IterKeyTypePointsAndRanges && rng.Intn(2) == 1 {
 			o.RangeKeyMasking.Suffix = testkeys.Suffix(rng.Intn(ks.Count()))
 		}
+		o.SkipCacheAdmission = rng.Intn(2) == 1
 	}
 
 	var longLivedIter, newIter *Iterator
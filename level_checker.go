@@ -361,7 +361,7 @@ func checkRangeTombstones(c *checkConfig) error {
 
 	memtables := c.readState.memtables
 	for i := len(memtables) - 1; i >= 0; i-- {
-		iter := memtables[i].newRangeDelIter(nil)
+		iter := flushableView(c.cmp, c.readState.current, memtables[i]).newRangeDelIter(nil)
 		if iter == nil {
 			continue
 		}
@@ -607,7 +607,7 @@ func checkLevelsInternal(c *checkConfig) (err error) {
 
 	memtables := c.readState.memtables
 	for i := len(memtables) - 1; i >= 0; i-- {
-		mem := memtables[i]
+		mem := flushableView(c.cmp, c.readState.current, memtables[i])
 		mlevels = append(mlevels, simpleMergingIterLevel{
 			iter:         mem.newIter(nil),
 			rangeDelIter: mem.newRangeDelIter(nil),
@@ -164,8 +164,8 @@ func (lt *levelIterTest) newIters(
 ) (internalIterator, keyspan.FragmentIterator, error) {
 	lt.itersCreated++
 	iter, err := lt.readers[file.FileNum].NewIterWithBlockPropertyFiltersAndContextEtc(
-		ctx, opts.LowerBound, opts.UpperBound, nil, false, true, iio.stats,
-		sstable.TrivialReaderProvider{Reader: lt.readers[file.FileNum]})
+		ctx, opts.LowerBound, opts.UpperBound, nil, false, true, iio.stats, nil, /* cacheStats */
+		sstable.TrivialReaderProvider{Reader: lt.readers[file.FileNum]}, nil /* bufferPool */)
 	if err != nil {
 		return nil, nil, err
 	}
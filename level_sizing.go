@@ -0,0 +1,102 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import "github.com/cockroachdb/errors"
+
+// LevelSizing configures the shape of the LSM: the maximum sizes of the
+// levels, and the sizes of the sstables written into them. See
+// DB.SetLevelSizing.
+type LevelSizing struct {
+	// LBaseMaxBytes is the maximum number of bytes for the base level. See
+	// Options.LBaseMaxBytes.
+	LBaseMaxBytes int64
+	// LevelMultiplier is the ratio of the maximum sizes of adjacent levels.
+	// See Options.Experimental.LevelMultiplier.
+	LevelMultiplier int
+	// TargetFileSizes holds the target sstable size of each level, starting
+	// with L0. Levels beyond the end of the slice use twice the target size
+	// of the level above them. See LevelOptions.TargetFileSize.
+	TargetFileSizes []int64
+}
+
+// LevelSizing returns the current sizing of the levels of the LSM. Its
+// TargetFileSizes holds an entry for every level.
+func (d *DB) LevelSizing() LevelSizing {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := LevelSizing{
+		LBaseMaxBytes:   d.opts.LBaseMaxBytes,
+		LevelMultiplier: d.opts.Experimental.LevelMultiplier,
+		TargetFileSizes: make([]int64, numLevels),
+	}
+	for level := range s.TargetFileSizes {
+		s.TargetFileSizes[level] = d.opts.Level(level).TargetFileSize
+	}
+	return s
+}
+
+// SetLevelSizing changes the sizing of the levels of the LSM while the DB is
+// open. Fields of s that are zero are left unchanged. The new level sizes
+// are used to score levels for compaction immediately, and the LSM is
+// reshaped over time by compactions scheduled as usual, subject to
+// MaxConcurrentCompactions. The new target file sizes apply to flushes and
+// compactions that start after the call; existing sstables are not rewritten
+// to match them. The sizing is not persisted to the OPTIONS file, and is
+// reset to the configured Options when the DB is reopened.
+func (d *DB) SetLevelSizing(s LevelSizing) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if s.LBaseMaxBytes < 0 {
+		return errors.Errorf("pebble: negative LBaseMaxBytes %d", s.LBaseMaxBytes)
+	}
+	if s.LevelMultiplier < 0 {
+		return errors.Errorf("pebble: negative LevelMultiplier %d", s.LevelMultiplier)
+	}
+	if len(s.TargetFileSizes) > numLevels {
+		return errors.Errorf("pebble: %d target file sizes for %d levels", len(s.TargetFileSizes), numLevels)
+	}
+	for level, size := range s.TargetFileSizes {
+		if size < 0 {
+			return errors.Errorf("pebble: negative target file size %d for L%d", size, level)
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if s.LBaseMaxBytes > 0 {
+		d.opts.LBaseMaxBytes = s.LBaseMaxBytes
+	}
+	if s.LevelMultiplier > 0 {
+		d.opts.Experimental.LevelMultiplier = s.LevelMultiplier
+	}
+	if len(s.TargetFileSizes) > 0 {
+		// The level options are replaced rather than modified in place, as
+		// they may be shared with the Options passed to Open.
+		levels := make([]LevelOptions, len(d.opts.Levels))
+		copy(levels, d.opts.Levels)
+		for level, size := range s.TargetFileSizes {
+			if level >= len(levels) {
+				levels = append(levels, d.opts.Level(level))
+			}
+			if size > 0 {
+				levels[level].TargetFileSize = size
+			}
+		}
+		d.opts.Levels = levels
+	}
+
+	// Rescore the levels with the new sizing. Pickers created for versions
+	// installed later observe the new sizing through d.opts.
+	vs := d.mu.versions
+	vs.picker = newCompactionPicker(vs.currentVersion(), vs.opts,
+		d.getInProgressCompactionInfoLocked(nil), vs.metrics.levelSizes(), vs.diskAvailBytes)
+	if !vs.dynamicBaseLevel {
+		vs.picker.forceBaseLevel1()
+	}
+	d.maybeScheduleCompaction()
+	return nil
+}
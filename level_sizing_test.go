@@ -0,0 +1,75 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetLevelSizing(t *testing.T) {
+	opts := &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+		Levels:                      []LevelOptions{{TargetFileSize: 2 << 20}},
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	s := d.LevelSizing()
+	require.Equal(t, int64(64<<20), s.LBaseMaxBytes)
+	require.Equal(t, defaultLevelMultiplier, s.LevelMultiplier)
+	require.Len(t, s.TargetFileSizes, numLevels)
+	require.Equal(t, int64(2<<20), s.TargetFileSizes[0])
+	require.Equal(t, int64(4<<20), s.TargetFileSizes[1])
+
+	require.Error(t, d.SetLevelSizing(LevelSizing{LBaseMaxBytes: -1}))
+	require.Error(t, d.SetLevelSizing(LevelSizing{LevelMultiplier: -1}))
+	require.Error(t, d.SetLevelSizing(LevelSizing{TargetFileSizes: make([]int64, numLevels+1)}))
+	require.Error(t, d.SetLevelSizing(LevelSizing{TargetFileSizes: []int64{-1}}))
+
+	// Compactions use the new target file sizes.
+	targetFileSizes := make([]int64, numLevels)
+	for i := range targetFileSizes {
+		targetFileSizes[i] = 16 << 10
+	}
+	require.NoError(t, d.SetLevelSizing(LevelSizing{TargetFileSizes: targetFileSizes}))
+	rng := rand.New(rand.NewSource(1))
+	value := make([]byte, 1<<10)
+	for i := 0; i < 100; i++ {
+		rng.Read(value)
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("key%03d", i)), value, nil))
+	}
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("key"), []byte("kez"), false))
+	require.Greater(t, d.Metrics().Levels[numLevels-1].NumFiles, int64(1))
+
+	require.NoError(t, d.SetLevelSizing(LevelSizing{
+		LBaseMaxBytes:   1 << 20,
+		TargetFileSizes: []int64{0, 0, 16 << 10},
+	}))
+	s = d.LevelSizing()
+	require.Equal(t, int64(1<<20), s.LBaseMaxBytes)
+	require.Equal(t, defaultLevelMultiplier, s.LevelMultiplier)
+	require.Equal(t, int64(16<<10), s.TargetFileSizes[0])
+	require.Equal(t, int64(16<<10), s.TargetFileSizes[1])
+	require.Equal(t, int64(16<<10), s.TargetFileSizes[2])
+	// The Options passed to Open are not modified.
+	require.Len(t, opts.Levels, 1)
+
+	// The picker is rescored with the new sizing.
+	d.mu.Lock()
+	p := d.mu.versions.picker.(*compactionPickerByScore)
+	baseLevelMaxBytes := p.levelMaxBytes[p.baseLevel]
+	d.mu.Unlock()
+	require.Equal(t, int64(1<<20), baseLevelMaxBytes)
+}
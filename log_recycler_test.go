@@ -133,3 +133,46 @@ func TestRecycleLogs(t *testing.T) {
 	}
 	require.NoError(t, d.Close())
 }
+
+func TestRecycleLogsOptions(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		opts      Options
+		wantLimit int
+	}{
+		{name: "default", wantLimit: 3},
+		{name: "limit", opts: Options{WALRecycleLimit: 1}, wantLimit: 1},
+		{name: "disabled", opts: Options{DisableWALRecycling: true}, wantLimit: 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := tc.opts
+			opts.FS = vfs.NewMem()
+			d, err := Open("", &opts)
+			require.NoError(t, err)
+			require.Equal(t, tc.wantLimit, d.logRecycler.limit)
+			for i := 0; i < 5; i++ {
+				require.NoError(t, d.Set([]byte("a"), nil, nil))
+				require.NoError(t, d.Flush())
+			}
+			m := d.Metrics()
+			require.EqualValues(t, len(d.logRecycler.logNums()), m.WAL.ObsoleteFiles)
+			require.EqualValues(t, 6, m.WAL.FilesCreated+m.WAL.FilesReused)
+			if opts.DisableWALRecycling {
+				require.Zero(t, m.WAL.ObsoleteFiles)
+				require.Zero(t, m.WAL.FilesReused)
+			} else {
+				require.Positive(t, m.WAL.FilesReused)
+			}
+			require.NoError(t, d.Close())
+		})
+	}
+}
+
+func TestWALPreallocateSize(t *testing.T) {
+	d := &DB{opts: &Options{MemTableSize: 1000}}
+	require.Equal(t, 1100, d.walPreallocateSize())
+	d.opts.WALPreallocateSize = 4096
+	require.Equal(t, 4096, d.walPreallocateSize())
+	d.opts.WALPreallocateSize = -1
+	require.Equal(t, 0, d.walPreallocateSize())
+}
@@ -12,6 +12,7 @@ import (
 	"sync/atomic"
 
 	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/bloom"
 	"github.com/cockroachdb/pebble/internal/arenaskl"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/keyspan"
@@ -59,13 +60,15 @@ var memTableEmptySize = func() uint32 {
 // operations. Applying a batch is an O(n logm) operation where N is the number
 // of records in the batch and M is the number of records in the memtable. The
 // commitPipeline serializes batch preparation, and allows batch application to
-// proceed concurrently.
+// proceed concurrently. The entries of a single large batch may also be
+// inserted in parallel (see Options.Experimental.MemTableApplyConcurrency).
 //
 // It is safe to call get, apply, newIter, and newRangeDelIter concurrently.
 type memTable struct {
 	cmp         Compare
 	formatKey   base.FormatKey
 	equal       Equal
+	split       Split
 	arenaBuf    []byte
 	skl         arenaskl.Skiplist
 	rangeDelSkl arenaskl.Skiplist
@@ -87,6 +90,24 @@ type memTable struct {
 	// guaranteed to be less than or equal to any seqnum stored in the memtable.
 	logSeqNum                    uint64
 	releaseAccountingReservation func()
+	// applyConcurrency is the maximum number of goroutines inserting the
+	// entries of a single batch. See Options.Experimental.MemTableApplyConcurrency.
+	applyConcurrency int
+	// prefixIndex is the hash index of the prefixes of the point keys in the
+	// memtable. It is nil unless the memtable's kind is
+	// MemTablePrefixHashSkiplist.
+	prefixIndex *memTablePrefixIndex
+	// filterBitsPerKey is the number of bits per key of the bloom filter built
+	// over the prefixes of the point keys once the memtable is immutable, or 0
+	// if no filter is built. See
+	// Options.Experimental.ImmutableMemTableFilterBitsPerKey.
+	filterBitsPerKey int
+	// filterScheduled is set once the filter build has been scheduled.
+	// Protected by DB.mu.
+	filterScheduled bool
+	// filter is the bloom filter built over the prefixes of the point keys,
+	// or nil if it has not been built.
+	filter atomic.Pointer[[]byte]
 }
 
 func (m *memTable) free() {
@@ -133,9 +154,12 @@ func (m *memTable) init(opts memTableOptions) {
 		cmp:                          opts.Comparer.Compare,
 		formatKey:                    opts.Comparer.FormatKey,
 		equal:                        opts.Comparer.Equal,
+		split:                        opts.Comparer.Split,
 		arenaBuf:                     opts.arenaBuf,
 		logSeqNum:                    opts.logSeqNum,
 		releaseAccountingReservation: opts.releaseAccountingReservation,
+		applyConcurrency:             opts.Experimental.MemTableApplyConcurrency,
+		filterBitsPerKey:             opts.Experimental.ImmutableMemTableFilterBitsPerKey,
 	}
 	m.writerRefs.Store(1)
 	m.tombstones = keySpanCache{
@@ -154,6 +178,9 @@ func (m *memTable) init(opts memTableOptions) {
 	if m.arenaBuf == nil {
 		m.arenaBuf = make([]byte, opts.size)
 	}
+	if opts.Experimental.MemTableKind == MemTablePrefixHashSkiplist && m.split != nil {
+		m.prefixIndex = newMemTablePrefixIndex(opts.size)
+	}
 
 	arena := arenaskl.NewArena(m.arenaBuf)
 	m.skl.Reset(arena, m.cmp)
@@ -199,21 +226,58 @@ func (m *memTable) prepare(batch *Batch) error {
 	return nil
 }
 
+// memTableParallelApplyMinCount is the minimum number of entries inserted by
+// each goroutine when a batch is applied to a memtable in parallel. Smaller
+// batches are not worth the cost of starting goroutines.
+const memTableParallelApplyMinCount = 1024
+
 func (m *memTable) apply(batch *Batch, seqNum uint64) error {
 	if seqNum < m.logSeqNum {
 		return base.CorruptionErrorf("pebble: batch seqnum %d is less than memtable creation seqnum %d",
 			errors.Safe(seqNum), errors.Safe(m.logSeqNum))
 	}
 
-	var ins arenaskl.Inserter
+	var endSeqNum uint64
 	var tombstoneCount, rangeKeyCount uint32
-	startSeqNum := seqNum
-	for r := batch.Reader(); ; seqNum++ {
+	var err error
+	n := int(batch.Count() / memTableParallelApplyMinCount)
+	if n > m.applyConcurrency {
+		n = m.applyConcurrency
+	}
+	if n > 1 {
+		endSeqNum, tombstoneCount, rangeKeyCount, err = m.applyParallel(batch.Reader(), seqNum, int(batch.Count()), n)
+	} else {
+		endSeqNum, tombstoneCount, rangeKeyCount, err = m.applyEntries(batch.Reader(), seqNum)
+	}
+	if err != nil {
+		return err
+	}
+	if endSeqNum != seqNum+uint64(batch.Count()) {
+		return base.CorruptionErrorf("pebble: inconsistent batch count: %d vs %d",
+			errors.Safe(endSeqNum), errors.Safe(seqNum+uint64(batch.Count())))
+	}
+	if tombstoneCount != 0 {
+		m.tombstones.invalidate(tombstoneCount)
+	}
+	if rangeKeyCount != 0 {
+		m.rangeKeys.invalidate(rangeKeyCount)
+	}
+	return nil
+}
+
+// applyEntries inserts the entries read from r into the memtable, assigning
+// sequence numbers starting at seqNum. It returns the sequence number
+// following the last entry, and the number of range deletions and range keys
+// inserted.
+func (m *memTable) applyEntries(
+	r BatchReader, seqNum uint64,
+) (endSeqNum uint64, tombstoneCount, rangeKeyCount uint32, err error) {
+	var ins arenaskl.Inserter
+	for ; ; seqNum++ {
 		kind, ukey, value, ok := r.Next()
 		if !ok {
 			break
 		}
-		var err error
 		ikey := base.MakeInternalKey(ukey, seqNum, kind)
 		switch kind {
 		case InternalKeyKindRangeDelete:
@@ -229,30 +293,143 @@ func (m *memTable) apply(batch *Batch, seqNum uint64) error {
 		case InternalKeyKindIngestSST:
 			panic("pebble: cannot apply ingested sstable key kind to memtable")
 		default:
+			if m.prefixIndex != nil {
+				m.prefixIndex.add(m.prefix(ukey))
+			}
 			err = ins.Add(&m.skl, ikey, value)
 		}
 		if err != nil {
-			return err
+			return 0, 0, 0, err
 		}
 	}
-	if seqNum != startSeqNum+uint64(batch.Count()) {
-		return base.CorruptionErrorf("pebble: inconsistent batch count: %d vs %d",
-			errors.Safe(seqNum), errors.Safe(startSeqNum+uint64(batch.Count())))
+	return seqNum, tombstoneCount, rangeKeyCount, nil
+}
+
+// applyParallel splits the count entries read from r into n chunks of roughly
+// equal counts, and inserts the chunks into the memtable concurrently. The
+// skiplists support concurrent insertion, and the entries of a batch have
+// distinct sequence numbers, so the chunks may be inserted in any order. The
+// batch's entries only become visible once its sequence number is published,
+// after all of the chunks have been inserted.
+func (m *memTable) applyParallel(
+	r BatchReader, seqNum uint64, count, n int,
+) (endSeqNum uint64, tombstoneCount, rangeKeyCount uint32, err error) {
+	type chunk struct {
+		r      BatchReader
+		seqNum uint64
 	}
-	if tombstoneCount != 0 {
-		m.tombstones.invalidate(tombstoneCount)
+	perChunk := (count + n - 1) / n
+
+	chunks := make([]chunk, 0, n)
+	for len(r) > 0 && len(chunks) < n-1 {
+		c := chunk{r: r, seqNum: seqNum}
+		for i := 0; i < perChunk; {
+			kind, _, _, ok := r.Next()
+			if !ok {
+				// The batch is corrupt. Leave the remainder to the last chunk,
+				// whose insertion stops at the corruption.
+				break
+			}
+			if kind != InternalKeyKindLogData {
+				i++
+				seqNum++
+			}
+		}
+		c.r = c.r[:len(c.r)-len(r)]
+		chunks = append(chunks, c)
 	}
-	if rangeKeyCount != 0 {
-		m.rangeKeys.invalidate(rangeKeyCount)
+	chunks = append(chunks, chunk{r: r, seqNum: seqNum})
+
+	type result struct {
+		endSeqNum                     uint64
+		tombstoneCount, rangeKeyCount uint32
+		err                           error
 	}
-	return nil
+	results := make([]result, len(chunks))
+	var wg sync.WaitGroup
+	wg.Add(len(chunks) - 1)
+	for i := 1; i < len(chunks); i++ {
+		go func(i int) {
+			defer wg.Done()
+			res := &results[i]
+			res.endSeqNum, res.tombstoneCount, res.rangeKeyCount, res.err =
+				m.applyEntries(chunks[i].r, chunks[i].seqNum)
+		}(i)
+	}
+	res := &results[0]
+	res.endSeqNum, res.tombstoneCount, res.rangeKeyCount, res.err =
+		m.applyEntries(chunks[0].r, chunks[0].seqNum)
+	wg.Wait()
+
+	for i := range results {
+		if results[i].err != nil {
+			return 0, 0, 0, results[i].err
+		}
+		tombstoneCount += results[i].tombstoneCount
+		rangeKeyCount += results[i].rangeKeyCount
+	}
+	return results[len(results)-1].endSeqNum, tombstoneCount, rangeKeyCount, nil
 }
 
 // newIter returns an iterator that is unpositioned (Iterator.Valid() will
 // return false). The iterator can be positioned via a call to SeekGE,
 // SeekLT, First or Last.
 func (m *memTable) newIter(o *IterOptions) internalIterator {
-	return m.skl.NewIter(o.GetLowerBound(), o.GetUpperBound())
+	iter := m.skl.NewIter(o.GetLowerBound(), o.GetUpperBound())
+	if m.prefixIndex != nil || m.filterBitsPerKey > 0 {
+		return &memTablePrefixIter{Iterator: iter, mem: m}
+	}
+	return iter
+}
+
+// mayContainPrefix returns false if the memtable contains no point keys with
+// the given prefix, as determined by its prefix index and filter. It returns
+// true if the memtable has neither.
+func (m *memTable) mayContainPrefix(prefix []byte) bool {
+	if f := m.filter.Load(); f != nil &&
+		!bloom.FilterPolicy(m.filterBitsPerKey).MayContain(base.TableFilter, *f, prefix) {
+		return false
+	}
+	return m.prefixIndex == nil || m.prefixIndex.mayContain(prefix)
+}
+
+// mayContainPrefixOf returns false if the memtable contains no point keys
+// with the same prefix as the given user key. See mayContainPrefix.
+func (m *memTable) mayContainPrefixOf(userKey []byte) bool {
+	if m.prefixIndex == nil && m.filterBitsPerKey == 0 {
+		return true
+	}
+	return m.mayContainPrefix(m.prefix(userKey))
+}
+
+// prefix returns the prefix of the user key, as defined by Comparer.Split, or
+// the entire key if the Comparer does not define Split.
+func (m *memTable) prefix(userKey []byte) []byte {
+	if m.split == nil {
+		return userKey
+	}
+	return userKey[:m.split(userKey)]
+}
+
+// buildFilter builds the bloom filter over the prefixes of the point keys of
+// the memtable. It must only be called once the memtable is immutable and all
+// of the batches applied to it have been applied (see readyForFlush), and the
+// caller must hold a reader reference on the memtable.
+func (m *memTable) buildFilter() {
+	w := bloom.FilterPolicy(m.filterBitsPerKey).NewWriter(base.TableFilter)
+	iter := m.skl.NewIter(nil, nil)
+	var prev []byte
+	for k, _ := iter.First(); k != nil; k, _ = iter.Next() {
+		// The keys are sorted, so keys sharing a prefix are adjacent.
+		prefix := m.prefix(k.UserKey)
+		if prev == nil || !m.equal(prev, prefix) {
+			w.AddKey(prefix)
+			prev = prefix
+		}
+	}
+	_ = iter.Close()
+	filter := w.Finish(nil)
+	m.filter.Store(&filter)
 }
 
 func (m *memTable) newFlushIter(o *IterOptions, bytesFlushed *uint64) internalIterator {
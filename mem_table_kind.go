@@ -0,0 +1,181 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sync/atomic"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/cockroachdb/pebble/internal/arenaskl"
+	"github.com/cockroachdb/pebble/internal/base"
+)
+
+// MemTableKind selects the data structure used to index the point keys of
+// memtables. See Options.Experimental.MemTableKind.
+type MemTableKind int8
+
+const (
+	// MemTableSkiplist indexes point keys with a concurrent skiplist. It is
+	// the default.
+	MemTableSkiplist MemTableKind = iota
+	// MemTablePrefixHashSkiplist indexes point keys with a concurrent
+	// skiplist, and additionally maintains a hash index of the key prefixes
+	// (as defined by Comparer.Split) inserted into the memtable. Point
+	// lookups and prefix seeks (see Iterator.SeekPrefixGE) skip the skiplist
+	// of a memtable that does not contain the prefix. The hash index is
+	// probabilistic: it never omits a prefix that is present, but may report
+	// prefixes that are absent. It uses 1 byte of memory for every 64 bytes
+	// of MemTableSize, which is not charged against the memtable's arena. The
+	// index is unused if the Comparer does not define Split.
+	MemTablePrefixHashSkiplist
+)
+
+// String implements fmt.Stringer.
+func (k MemTableKind) String() string {
+	switch k {
+	case MemTableSkiplist:
+		return "skiplist"
+	case MemTablePrefixHashSkiplist:
+		return "prefix-hash-skiplist"
+	}
+	return "unknown"
+}
+
+// memTablePrefixIndexBytesPerBit is the number of bytes of memtable capacity
+// per bit of a memTablePrefixIndex. The smallest memtable entries consume
+// roughly 50 bytes of the arena, so a full memtable has at least 6 bits per
+// entry.
+const memTablePrefixIndexBytesPerBit = 8
+
+// memTablePrefixIndex is the hash index of the key prefixes of a memtable
+// with kind MemTablePrefixHashSkiplist. It is a bloom filter that supports
+// concurrent insertion: each prefix sets two bits, which are set with atomic
+// operations, so that prefixes may be added by concurrent applications of
+// batches while the index is read.
+type memTablePrefixIndex struct {
+	words []atomic.Uint64
+}
+
+func newMemTablePrefixIndex(memTableSize int) *memTablePrefixIndex {
+	n := memTableSize / memTablePrefixIndexBytesPerBit / 64
+	if n < 1 {
+		n = 1
+	}
+	return &memTablePrefixIndex{words: make([]atomic.Uint64, n)}
+}
+
+func (x *memTablePrefixIndex) bits(prefix []byte) (uint64, uint64) {
+	h := xxhash.Sum64(prefix)
+	n := uint64(len(x.words)) * 64
+	return (h & 0xffffffff) % n, (h >> 32) % n
+}
+
+// add adds the prefix to the index.
+func (x *memTablePrefixIndex) add(prefix []byte) {
+	b1, b2 := x.bits(prefix)
+	for _, b := range [2]uint64{b1, b2} {
+		w, mask := &x.words[b/64], uint64(1)<<(b%64)
+		for {
+			v := w.Load()
+			if v&mask != 0 || w.CompareAndSwap(v, v|mask) {
+				break
+			}
+		}
+	}
+}
+
+// mayContain returns false if the prefix was never added to the index.
+func (x *memTablePrefixIndex) mayContain(prefix []byte) bool {
+	b1, b2 := x.bits(prefix)
+	return x.words[b1/64].Load()&(uint64(1)<<(b1%64)) != 0 &&
+		x.words[b2/64].Load()&(uint64(1)<<(b2%64)) != 0
+}
+
+// memTablePrefixIter wraps the skiplist iterator of a memtable with a prefix
+// index or filter, and skips the skiplist seek of a SeekPrefixGE for a prefix
+// that is absent from the memtable.
+type memTablePrefixIter struct {
+	*arenaskl.Iterator
+	mem *memTable
+	// exhausted is set when SeekPrefixGE was answered by the prefix index or
+	// filter, without positioning the skiplist iterator.
+	exhausted bool
+}
+
+var _ internalIterator = (*memTablePrefixIter)(nil)
+
+// SeekGE implements internalIterator.SeekGE, as documented in the pebble
+// package.
+func (i *memTablePrefixIter) SeekGE(
+	key []byte, flags base.SeekGEFlags,
+) (*base.InternalKey, base.LazyValue) {
+	i.exhausted = false
+	return i.Iterator.SeekGE(key, flags)
+}
+
+// SeekPrefixGE implements internalIterator.SeekPrefixGE, as documented in the
+// pebble package.
+func (i *memTablePrefixIter) SeekPrefixGE(
+	prefix, key []byte, flags base.SeekGEFlags,
+) (*base.InternalKey, base.LazyValue) {
+	if !i.mem.mayContainPrefix(prefix) {
+		// The skiplist iterator is left at its previous position. That is
+		// safe for a subsequent seek with TrySeekUsingNext, which requires
+		// only that the iterator is positioned at or before the sought key.
+		i.exhausted = true
+		return nil, base.LazyValue{}
+	}
+	i.exhausted = false
+	return i.Iterator.SeekPrefixGE(prefix, key, flags)
+}
+
+// SeekLT implements internalIterator.SeekLT, as documented in the pebble
+// package.
+func (i *memTablePrefixIter) SeekLT(
+	key []byte, flags base.SeekLTFlags,
+) (*base.InternalKey, base.LazyValue) {
+	i.exhausted = false
+	return i.Iterator.SeekLT(key, flags)
+}
+
+// First implements internalIterator.First, as documented in the pebble
+// package.
+func (i *memTablePrefixIter) First() (*base.InternalKey, base.LazyValue) {
+	i.exhausted = false
+	return i.Iterator.First()
+}
+
+// Last implements internalIterator.Last, as documented in the pebble package.
+func (i *memTablePrefixIter) Last() (*base.InternalKey, base.LazyValue) {
+	i.exhausted = false
+	return i.Iterator.Last()
+}
+
+// Next implements internalIterator.Next, as documented in the pebble package.
+func (i *memTablePrefixIter) Next() (*base.InternalKey, base.LazyValue) {
+	if i.exhausted {
+		return nil, base.LazyValue{}
+	}
+	return i.Iterator.Next()
+}
+
+// NextPrefix implements internalIterator.NextPrefix, as documented in the
+// pebble package.
+func (i *memTablePrefixIter) NextPrefix(succKey []byte) (*base.InternalKey, base.LazyValue) {
+	if i.exhausted {
+		return nil, base.LazyValue{}
+	}
+	return i.Iterator.NextPrefix(succKey)
+}
+
+// Prev implements internalIterator.Prev, as documented in the pebble package.
+func (i *memTablePrefixIter) Prev() (*base.InternalKey, base.LazyValue) {
+	if i.exhausted {
+		// Stepping back from an iterator exhausted in the forward direction
+		// positions it at the last key.
+		return i.Last()
+	}
+	return i.Iterator.Prev()
+}
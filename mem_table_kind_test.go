@@ -0,0 +1,133 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemTablePrefixIndex(t *testing.T) {
+	x := newMemTablePrefixIndex(1 << 20)
+	for i := 0; i < 10000; i++ {
+		x.add([]byte(fmt.Sprintf("present%05d", i)))
+	}
+	var falsePositives int
+	for i := 0; i < 10000; i++ {
+		require.True(t, x.mayContain([]byte(fmt.Sprintf("present%05d", i))))
+		if x.mayContain([]byte(fmt.Sprintf("absent%05d", i))) {
+			falsePositives++
+		}
+	}
+	require.Less(t, falsePositives, 300)
+}
+
+func TestMemTableKind(t *testing.T) {
+	// Write the same keys into a DB with each memtable kind, and verify that
+	// point lookups and prefix iteration observe the same keys.
+	read := func(kind MemTableKind) string {
+		opts := &Options{FS: vfs.NewMem(), Comparer: testkeys.Comparer}
+		opts.Experimental.MemTableKind = kind
+		d, err := Open("", opts)
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, d.Close())
+		}()
+
+		for i := 0; i < 100; i += 2 {
+			key := testkeys.KeyAt(testkeys.Alpha(2), i, i%3)
+			require.NoError(t, d.Set(key, key, nil))
+		}
+		require.NoError(t, d.DeleteRange([]byte("b"), []byte("c"), nil))
+
+		var buf []byte
+		for i := 0; i < 100; i++ {
+			key := testkeys.KeyAt(testkeys.Alpha(2), i, i%3)
+			v, closer, err := d.Get(key)
+			if errors.Is(err, ErrNotFound) {
+				buf = append(buf, fmt.Sprintf("%s: not found\n", key)...)
+				continue
+			}
+			require.NoError(t, err)
+			buf = append(buf, fmt.Sprintf("%s: %s\n", key, v)...)
+			require.NoError(t, closer.Close())
+		}
+
+		iter, err := d.NewIter(nil)
+		require.NoError(t, err)
+		for i := 0; i < 100; i++ {
+			prefix := testkeys.Key(testkeys.Alpha(2), i)
+			for valid := iter.SeekPrefixGE(prefix); valid; valid = iter.Next() {
+				buf = append(buf, fmt.Sprintf("%s\n", iter.Key())...)
+			}
+		}
+		require.NoError(t, iter.Close())
+		return string(buf)
+	}
+	require.Equal(t, read(MemTableSkiplist), read(MemTablePrefixHashSkiplist))
+}
+
+func TestImmutableMemTableFilter(t *testing.T) {
+	opts := &Options{FS: vfs.NewMem(), Comparer: testkeys.Comparer}
+	opts.Experimental.ImmutableMemTableFilterBitsPerKey = 10
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	// Prevent flushes, so that the memtable remains queued once it is
+	// immutable.
+	d.mu.Lock()
+	d.mu.compact.flushing = true
+	d.mu.Unlock()
+	for i := 0; i < 100; i++ {
+		key := testkeys.KeyAt(testkeys.Alpha(2), i, 1)
+		require.NoError(t, d.Set(key, key, nil))
+	}
+	d.mu.Lock()
+	mem := d.mu.mem.mutable
+	d.mu.Unlock()
+	require.Nil(t, mem.filter.Load())
+	_, err = d.AsyncFlush()
+	require.NoError(t, err)
+
+	// Wait for the filter to be built.
+	d.mu.Lock()
+	for d.mu.mem.buildingFilters > 0 {
+		d.mu.compact.cond.Wait()
+	}
+	d.mu.Unlock()
+	require.NotNil(t, mem.filter.Load())
+
+	var falsePositives int
+	for i := 0; i < 100; i++ {
+		require.True(t, mem.mayContainPrefixOf(testkeys.KeyAt(testkeys.Alpha(2), i, 2)))
+		key := testkeys.KeyAt(testkeys.Alpha(2), 100+i, 1)
+		if mem.mayContainPrefixOf(key) {
+			falsePositives++
+		}
+		_, _, err := d.Get(key)
+		require.ErrorIs(t, err, ErrNotFound)
+
+		key = testkeys.KeyAt(testkeys.Alpha(2), i, 1)
+		v, closer, err := d.Get(key)
+		require.NoError(t, err)
+		require.Equal(t, key, v)
+		require.NoError(t, closer.Close())
+	}
+	require.Less(t, falsePositives, 10)
+
+	d.mu.Lock()
+	d.mu.compact.flushing = false
+	d.maybeScheduleFlush()
+	d.mu.Unlock()
+	require.NoError(t, d.Flush())
+}
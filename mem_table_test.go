@@ -384,6 +384,49 @@ func TestMemTableConcurrentDeleteRange(t *testing.T) {
 	}
 }
 
+func TestMemTableParallelApply(t *testing.T) {
+	// Apply the same large batch to a memtable serially and in parallel, and
+	// verify that both memtables contain the same entries.
+	b := newBatch(nil)
+	for i := 0; i < 10*memTableParallelApplyMinCount; i++ {
+		key := []byte(fmt.Sprintf("%05d", i))
+		switch i % 100 {
+		case 0:
+			require.NoError(t, b.DeleteRange(key, []byte(fmt.Sprintf("%05d", i+10)), nil))
+		case 1:
+			require.NoError(t, b.LogData(key, nil))
+		default:
+			require.NoError(t, b.Set(key, key, nil))
+		}
+	}
+	defer b.release()
+
+	const seqNum = 100
+	contents := func(concurrency int) string {
+		opts := &Options{MemTableSize: 64 << 20}
+		opts.Experimental.MemTableApplyConcurrency = concurrency
+		m := newMemTable(memTableOptions{Options: opts})
+		require.NoError(t, m.apply(b, seqNum))
+
+		var buf strings.Builder
+		it := m.newIter(nil)
+		for k, v := it.First(); k != nil; k, v = it.Next() {
+			fmt.Fprintf(&buf, "%s:%s\n", k, v.InPlaceValue())
+		}
+		require.NoError(t, it.Close())
+		rangeDelIter := m.newRangeDelIter(nil)
+		for s := rangeDelIter.First(); s != nil; s = rangeDelIter.Next() {
+			fmt.Fprintf(&buf, "%s\n", s)
+		}
+		require.NoError(t, rangeDelIter.Close())
+		return buf.String()
+	}
+	serial := contents(1)
+	require.Equal(t, serial, contents(4))
+	require.Equal(t, serial, contents(100))
+	require.Contains(t, serial, fmt.Sprintf("#%d,%d:", seqNum+int(b.Count())-1, InternalKeyKindSet))
+}
+
 func TestMemTableReserved(t *testing.T) {
 	m := newMemTable(memTableOptions{size: 5000})
 	// Increase to 2 references.
@@ -0,0 +1,231 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sync"
+	"unsafe"
+
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// MemoryKind classifies the memory used by a DB for the purpose of reporting
+// it to a MemoryMonitor.
+type MemoryKind int8
+
+const (
+	// MemoryKindMemTable is the memory of the memtables, including memtables
+	// queued for flushing, flushed memtables still referenced by iterators,
+	// and an obsolete memtable kept for reuse.
+	MemoryKindMemTable MemoryKind = iota
+	// MemoryKindBlockCache is the capacity of the block cache.
+	MemoryKindBlockCache
+	// MemoryKindTableCache is the (estimated) memory of the sstable readers
+	// held open by the table cache for the DB.
+	MemoryKindTableCache
+	// NumMemoryKinds is the number of memory kinds.
+	NumMemoryKinds
+)
+
+// String implements fmt.Stringer.
+func (k MemoryKind) String() string {
+	switch k {
+	case MemoryKindMemTable:
+		return "memtable"
+	case MemoryKindBlockCache:
+		return "block-cache"
+	case MemoryKindTableCache:
+		return "table-cache"
+	}
+	return "unknown"
+}
+
+// MemoryMonitor is notified of the memory reserved and released by a DB, so
+// that an embedder may account for it against a process-wide memory budget.
+// An embedder over budget may ask the DB to release memory with
+// DB.ShrinkMemory. See Options.MemoryMonitor.
+//
+// The methods of a MemoryMonitor are called concurrently, possibly while the
+// DB holds internal locks, and must not call back into the DB.
+type MemoryMonitor interface {
+	// Reserve reports that the DB reserved n bytes of memory of the given
+	// kind.
+	Reserve(kind MemoryKind, n int64)
+	// Release reports that the DB released n bytes of memory of the given
+	// kind, previously reported by Reserve.
+	Release(kind MemoryKind, n int64)
+}
+
+// tableCacheReaderSize is the estimated memory used by an sstable reader held
+// open by the table cache. The blocks loaded by the reader are accounted for
+// by the block cache.
+const tableCacheReaderSize = int64(unsafe.Sizeof(sstable.Reader{}))
+
+// memoryShrink tracks the block cache capacity released by DB.ShrinkMemory.
+type memoryShrink struct {
+	mu sync.Mutex
+	// blockCacheSize is the block cache capacity reserved with the
+	// MemoryMonitor by Open, as resized by DB.SetBlockCacheSize. The capacity
+	// released by ShrinkMemory is included.
+	blockCacheSize int64
+	// blockCache holds the reservations of block cache capacity made by
+	// ShrinkMemory, in the order they were made.
+	blockCache []blockCacheShrink
+	// blockCacheBytes is the sum of the sizes of the reservations in
+	// blockCache.
+	blockCacheBytes int64
+}
+
+type blockCacheShrink struct {
+	n       int64
+	release func()
+}
+
+func (d *DB) memoryReserve(kind MemoryKind, n int64) {
+	if d.opts.MemoryMonitor != nil && n != 0 {
+		d.opts.MemoryMonitor.Reserve(kind, n)
+	}
+}
+
+func (d *DB) memoryRelease(kind MemoryKind, n int64) {
+	if d.opts.MemoryMonitor != nil && n != 0 {
+		d.opts.MemoryMonitor.Release(kind, n)
+	}
+}
+
+// ShrinkMemory asks the DB to release n bytes of memory of the given kind,
+// and returns the number of bytes released, which are also reported to the
+// MemoryMonitor. Fewer than n bytes may be released:
+//
+//   - MemoryKindMemTable releases the obsolete memtable kept for reuse, if
+//     any. If that is not enough, the mutable memtable is scheduled to be
+//     flushed, and the memory of the flushed memtables is released (and
+//     reported) once they are flushed and no longer referenced by iterators.
+//   - MemoryKindBlockCache reduces the capacity of the block cache by n
+//     bytes, evicting cached blocks, until the capacity is restored by
+//     DB.GrowMemory or the DB is closed. When the block cache is shared by
+//     several DBs, the capacity of the cache is reduced for all of them.
+//   - MemoryKindTableCache closes sstable readers of the DB held open by the
+//     table cache. Readers in use by iterators are closed once the iterators
+//     are closed.
+func (d *DB) ShrinkMemory(kind MemoryKind, n int64) (int64, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if n <= 0 {
+		return 0, nil
+	}
+	switch kind {
+	case MemoryKindMemTable:
+		var released int64
+		if mem := d.memTableRecycle.Swap(nil); mem != nil {
+			released = int64(len(mem.arenaBuf))
+			d.freeMemTable(mem)
+		}
+		if released < n && !d.opts.ReadOnly {
+			d.mu.Lock()
+			empty := d.mu.mem.mutable.empty()
+			d.mu.Unlock()
+			if !empty {
+				if _, err := d.AsyncFlush(); err != nil {
+					return released, err
+				}
+			}
+		}
+		return released, nil
+
+	case MemoryKindBlockCache:
+		d.memoryShrink.mu.Lock()
+		defer d.memoryShrink.mu.Unlock()
+		if avail := d.opts.Cache.MaxSize() - d.memoryShrink.blockCacheBytes; n > avail {
+			n = avail
+		}
+		if n <= 0 {
+			return 0, nil
+		}
+		d.memoryShrink.blockCache = append(d.memoryShrink.blockCache, blockCacheShrink{
+			n:       n,
+			release: d.opts.Cache.Reserve(int(n)),
+		})
+		d.memoryShrink.blockCacheBytes += n
+		d.memoryRelease(MemoryKindBlockCache, n)
+		return n, nil
+
+	case MemoryKindTableCache:
+		released := d.tableCache.shrink(n)
+		return released, nil
+	}
+	return 0, nil
+}
+
+// GrowMemory restores up to n bytes of block cache capacity released by
+// ShrinkMemory, and returns the number of bytes restored, which are also
+// reported to the MemoryMonitor. The memory of the other kinds grows again as
+// the DB is used, without a call to GrowMemory, and GrowMemory returns 0 for
+// them.
+func (d *DB) GrowMemory(kind MemoryKind, n int64) int64 {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if kind != MemoryKindBlockCache || n <= 0 {
+		return 0
+	}
+	d.memoryShrink.mu.Lock()
+	defer d.memoryShrink.mu.Unlock()
+	var restored int64
+	for restored < n && len(d.memoryShrink.blockCache) > 0 {
+		last := &d.memoryShrink.blockCache[len(d.memoryShrink.blockCache)-1]
+		last.release()
+		d.memoryShrink.blockCache = d.memoryShrink.blockCache[:len(d.memoryShrink.blockCache)-1]
+		d.memoryShrink.blockCacheBytes -= last.n
+		restored += last.n
+		if restored > n {
+			// Keep the part of the reservation that was not asked for.
+			rest := restored - n
+			d.memoryShrink.blockCache = append(d.memoryShrink.blockCache, blockCacheShrink{
+				n:       rest,
+				release: d.opts.Cache.Reserve(int(rest)),
+			})
+			d.memoryShrink.blockCacheBytes += rest
+			restored = n
+		}
+	}
+	d.memoryReserve(MemoryKindBlockCache, restored)
+	return restored
+}
+
+// releaseMemoryShrink restores the block cache capacity released by
+// ShrinkMemory. It is called when the DB is closed.
+func (d *DB) releaseMemoryShrink() {
+	d.memoryShrink.mu.Lock()
+	defer d.memoryShrink.mu.Unlock()
+	for _, s := range d.memoryShrink.blockCache {
+		s.release()
+	}
+	d.memoryShrink.blockCache = nil
+	d.memoryShrink.blockCacheBytes = 0
+}
+
+// resizeBlockCache sets the capacity of the block cache, and reports the
+// change of capacity to the MemoryMonitor. The reservations made by
+// ShrinkMemory are restored while they exceed the new capacity.
+func (d *DB) resizeBlockCache(size int64) {
+	d.memoryShrink.mu.Lock()
+	defer d.memoryShrink.mu.Unlock()
+	prev := d.memoryShrink.blockCacheSize - d.memoryShrink.blockCacheBytes
+	d.opts.Cache.SetMaxSize(size)
+	for d.memoryShrink.blockCacheBytes > size {
+		last := d.memoryShrink.blockCache[len(d.memoryShrink.blockCache)-1]
+		last.release()
+		d.memoryShrink.blockCache = d.memoryShrink.blockCache[:len(d.memoryShrink.blockCache)-1]
+		d.memoryShrink.blockCacheBytes -= last.n
+	}
+	d.memoryShrink.blockCacheSize = size
+	if cur := size - d.memoryShrink.blockCacheBytes; cur > prev {
+		d.memoryReserve(MemoryKindBlockCache, cur-prev)
+	} else {
+		d.memoryRelease(MemoryKindBlockCache, prev-cur)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+type testMemoryMonitor struct {
+	reserved [NumMemoryKinds]atomic.Int64
+}
+
+func (m *testMemoryMonitor) Reserve(kind MemoryKind, n int64) {
+	m.reserved[kind].Add(n)
+}
+
+func (m *testMemoryMonitor) Release(kind MemoryKind, n int64) {
+	if m.reserved[kind].Add(-n) < 0 {
+		panic("released more memory than reserved")
+	}
+}
+
+func TestMemoryMonitor(t *testing.T) {
+	monitor := &testMemoryMonitor{}
+	cache := NewCache(1 << 20)
+	defer cache.Unref()
+	d, err := Open("", &Options{
+		FS:            vfs.NewMem(),
+		Cache:         cache,
+		MemoryMonitor: monitor,
+	})
+	require.NoError(t, err)
+
+	require.EqualValues(t, 1<<20, monitor.reserved[MemoryKindBlockCache].Load())
+	require.Equal(t, d.memTableReserved.Load(), monitor.reserved[MemoryKindMemTable].Load())
+	require.EqualValues(t, 0, monitor.reserved[MemoryKindTableCache].Load())
+
+	// Flushing creates an sstable, which is opened in the table cache by the
+	// read.
+	require.NoError(t, d.Set([]byte("a"), []byte("a"), nil))
+	require.NoError(t, d.Flush())
+	_, closer, err := d.Get([]byte("a"))
+	require.NoError(t, err)
+	require.NoError(t, closer.Close())
+	require.EqualValues(t, tableCacheReaderSize, monitor.reserved[MemoryKindTableCache].Load())
+	require.Equal(t, d.memTableReserved.Load(), monitor.reserved[MemoryKindMemTable].Load())
+
+	released, err := d.ShrinkMemory(MemoryKindTableCache, 1)
+	require.NoError(t, err)
+	require.EqualValues(t, tableCacheReaderSize, released)
+	// Readers are closed in the background.
+	require.Eventually(t, func() bool {
+		return monitor.reserved[MemoryKindTableCache].Load() == 0
+	}, 10*time.Second, time.Millisecond)
+
+	// The flushed memtable is kept for reuse, and is released by a shrink.
+	require.NotNil(t, d.memTableRecycle.Load())
+	released, err = d.ShrinkMemory(MemoryKindMemTable, 1)
+	require.NoError(t, err)
+	require.Less(t, int64(0), released)
+	require.Nil(t, d.memTableRecycle.Load())
+	require.Equal(t, d.memTableReserved.Load(), monitor.reserved[MemoryKindMemTable].Load())
+
+	// Block cache shrinks are bounded by the capacity of the cache, and are
+	// undone by GrowMemory.
+	released, err = d.ShrinkMemory(MemoryKindBlockCache, 256<<10)
+	require.NoError(t, err)
+	require.EqualValues(t, 256<<10, released)
+	released, err = d.ShrinkMemory(MemoryKindBlockCache, 1<<20)
+	require.NoError(t, err)
+	require.EqualValues(t, 768<<10, released)
+	require.EqualValues(t, 0, monitor.reserved[MemoryKindBlockCache].Load())
+	require.EqualValues(t, 512<<10, d.GrowMemory(MemoryKindBlockCache, 512<<10))
+	require.EqualValues(t, 512<<10, monitor.reserved[MemoryKindBlockCache].Load())
+	require.EqualValues(t, 512<<10, d.GrowMemory(MemoryKindBlockCache, 1<<20))
+	require.EqualValues(t, 1<<20, monitor.reserved[MemoryKindBlockCache].Load())
+
+	require.NoError(t, d.Close())
+	for kind := MemoryKind(0); kind < NumMemoryKinds; kind++ {
+		require.EqualValues(t, 0, monitor.reserved[kind].Load(), "%s", kind)
+	}
+}
+
+func TestMemoryMonitorSetBlockCacheSize(t *testing.T) {
+	monitor := &testMemoryMonitor{}
+	cache := NewCache(8 << 20)
+	defer cache.Unref()
+	d, err := Open("", &Options{
+		FS:            vfs.NewMem(),
+		Cache:         cache,
+		MemoryMonitor: monitor,
+	})
+	require.NoError(t, err)
+	require.EqualValues(t, 8<<20, monitor.reserved[MemoryKindBlockCache].Load())
+
+	d.SetBlockCacheSize(16 << 20)
+	require.EqualValues(t, 16<<20, monitor.reserved[MemoryKindBlockCache].Load())
+
+	released, err := d.ShrinkMemory(MemoryKindBlockCache, 4<<20)
+	require.NoError(t, err)
+	require.EqualValues(t, 4<<20, released)
+	require.EqualValues(t, 12<<20, monitor.reserved[MemoryKindBlockCache].Load())
+
+	// Shrinking the cache below the capacity released by ShrinkMemory restores
+	// that capacity.
+	d.SetBlockCacheSize(2 << 20)
+	require.EqualValues(t, 2<<20, monitor.reserved[MemoryKindBlockCache].Load())
+	require.EqualValues(t, 0, d.GrowMemory(MemoryKindBlockCache, 4<<20))
+
+	require.NoError(t, d.Close())
+	require.EqualValues(t, 0, monitor.reserved[MemoryKindBlockCache].Load())
+}
@@ -40,7 +40,7 @@ const (
 	// metamorphic tests should use. This may be greater than
 	// pebble.FormatNewest when some format major versions are marked as
 	// experimental.
-	newestFormatMajorVersionTODO = pebble.ExperimentalFormatVirtualSSTables
+	newestFormatMajorVersionTODO = pebble.ExperimentalFormatFlushedSpans
 )
 
 func parseOptions(
@@ -509,6 +509,9 @@ func randomOptions(
 			testOpts.secondaryCacheEnabled = true
 			// TODO(josh): Randomize various secondary cache settings.
 			testOpts.Opts.Experimental.SecondaryCacheSizeBytes = 1024 * 1024 * 32 // 32 MBs
+			if rng.Intn(2) == 0 {
+				testOpts.Opts.Experimental.SecondaryCacheWriteMode = pebble.SecondaryCacheWriteThrough
+			}
 		}
 	}
 	return testOpts
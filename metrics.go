@@ -12,6 +12,7 @@ import (
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/cache"
 	"github.com/cockroachdb/pebble/internal/humanize"
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/sharedcache"
 	"github.com/cockroachdb/pebble/record"
 	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/redact"
@@ -24,6 +25,17 @@ type CacheMetrics = cache.Metrics
 // FilterMetrics holds metrics for the filter policy
 type FilterMetrics = sstable.FilterMetrics
 
+// BlockCacheMetricsByKind holds the block cache lookups of sstable reads,
+// indexed by sstable.BlockKind.
+type BlockCacheMetricsByKind = sstable.CacheMetricsByKind
+
+// SecondaryCacheMetrics holds metrics for the secondary cache of remote
+// sstables.
+type SecondaryCacheMetrics = sharedcache.Metrics
+
+// SecondaryCacheFileMetrics holds the secondary cache metrics of sstables.
+type SecondaryCacheFileMetrics = sharedcache.FileMetrics
+
 // ThroughputMetric is a cumulative throughput metric. See the detailed
 // comment in base.
 type ThroughputMetric = base.ThroughputMetric
@@ -82,6 +94,15 @@ type LevelMetrics struct {
 		// LevelMetrics.format, but are available to sophisticated clients.
 		BytesWrittenDataBlocks  uint64
 		BytesWrittenValueBlocks uint64
+		// BlockCache holds the cumulative block cache lookups of the blocks
+		// read by iterators over the level, by kind of block. Not printed by
+		// LevelMetrics.format.
+		BlockCache BlockCacheMetricsByKind
+		// SecondaryCache holds the secondary cache reads and evictions of the
+		// remote sstables currently in the level (see
+		// Options.Experimental.SecondaryCacheSizeBytes). Not printed by
+		// LevelMetrics.format.
+		SecondaryCache SecondaryCacheFileMetrics
 	}
 }
 
@@ -102,6 +123,10 @@ func (m *LevelMetrics) Add(u *LevelMetrics) {
 	m.Additional.BytesWrittenDataBlocks += u.Additional.BytesWrittenDataBlocks
 	m.Additional.BytesWrittenValueBlocks += u.Additional.BytesWrittenValueBlocks
 	m.Additional.ValueBlocksSize += u.Additional.ValueBlocksSize
+	m.Additional.BlockCache.Add(&u.Additional.BlockCache)
+	m.Additional.SecondaryCache.Hits += u.Additional.SecondaryCache.Hits
+	m.Additional.SecondaryCache.Misses += u.Additional.SecondaryCache.Misses
+	m.Additional.SecondaryCache.Evictions += u.Additional.SecondaryCache.Evictions
 }
 
 // WriteAmp computes the write amplification for compactions at this
@@ -121,6 +146,14 @@ func (m *LevelMetrics) WriteAmp() float64 {
 // metrics reflect those operations.
 type Metrics struct {
 	BlockCache CacheMetrics
+	// BlockCacheDB holds the metrics of the DB's blocks in the block cache,
+	// which may be shared with other DBs. See Options.CacheQuota.
+	BlockCacheDB CacheMetrics
+	// BlockCacheByKind holds the cumulative block cache lookups of the DB's
+	// sstable reads by kind of block. It includes the lookups of reads not made
+	// by iterators over a level, such as those made when opening sstables and
+	// by compactions, which aren't included in LevelMetrics.
+	BlockCacheByKind BlockCacheMetricsByKind
 
 	Compact struct {
 		// The total number of compactions, and per-compaction type counts.
@@ -131,6 +164,20 @@ type Metrics struct {
 		MoveCount        int64
 		ReadCount        int64
 		RewriteCount     int64
+		TTLCount         int64
+		PeriodicCount    int64
+		// TombstoneDensityCount is the number of compactions of sstables
+		// whose ratio of tombstones exceeded
+		// Options.TombstoneDensityCompactionThreshold.
+		TombstoneDensityCount int64
+		// TieringCount is the number of compactions that migrated sstables
+		// between local and remote storage under
+		// Options.Experimental.Tiering.
+		TieringCount int64
+		// BlobRewriteCount is the number of compactions that rewrote sstables
+		// to garbage collect blob files under
+		// Options.Experimental.ValueSeparation.
+		BlobRewriteCount int64
 		MultiLevelCount  int64
 		// An estimate of the number of bytes that need to be compacted for the LSM
 		// to reach a stable state.
@@ -148,6 +195,11 @@ type Metrics struct {
 		// Duration records the cumulative duration of all compactions since the
 		// database was opened.
 		Duration time.Duration
+		// Paused is true if automatic compactions are paused by
+		// DB.PauseCompactions, in which case PausedDuration is the time elapsed
+		// since they were paused.
+		Paused         bool
+		PausedDuration time.Duration
 	}
 
 	Ingest struct {
@@ -232,8 +284,40 @@ type Metrics struct {
 		ZombieCount int64
 	}
 
+	// BlobFiles holds the metrics of the blob files holding the values
+	// separated under Options.Experimental.ValueSeparation.
+	BlobFiles struct {
+		// The count of blob files referenced by the sstables in use.
+		Count int64
+		// The size of the blob files, in bytes.
+		Size uint64
+		// The sum of the lengths of the values in the blob files.
+		ValueSize uint64
+		// The sum of the lengths of the values in the blob files that are
+		// referenced by the current version. The difference with ValueSize is
+		// reclaimed by the garbage collection of blob files.
+		LiveValueSize uint64
+	}
+
 	TableCache CacheMetrics
 
+	// RowCache holds the metrics of the cache of values returned by Get. It's
+	// zero unless Options.RowCacheSize is positive.
+	RowCache CacheMetrics
+
+	// SecondaryCache holds the metrics of the secondary cache of remote
+	// sstables, including those of each sstable read through it. It's zero
+	// unless Options.Experimental.SecondaryCacheSizeBytes is positive.
+	SecondaryCache SecondaryCacheMetrics
+
+	// SecondaryCacheWarm holds the cumulative number of remote sstables, and
+	// of their bytes, read into the secondary cache by
+	// DB.WarmSecondaryCache and Options.Experimental.WarmSecondaryCacheOnIngest.
+	SecondaryCacheWarm struct {
+		Tables int64
+		Bytes  int64
+	}
+
 	// Count of the number of open sstable iterators.
 	TableIters int64
 	// Uptime is the total time since this DB was opened.
@@ -244,6 +328,11 @@ type Metrics struct {
 		Files int64
 		// Number of obsolete WAL files.
 		ObsoleteFiles int64
+		// Number of WAL files created by reusing a recycled WAL file, and
+		// created as new files, since the DB was opened. Their ratio shows how
+		// often WAL recycling avoids creating a new file.
+		FilesReused  int64
+		FilesCreated int64
 		// Physical size of the obsolete WAL files.
 		ObsoletePhysicalSize uint64
 		// Size of the live data in the WAL files. Note that with WAL file
@@ -256,6 +345,17 @@ type Metrics struct {
 		BytesIn uint64
 		// Number of bytes written to the WAL.
 		BytesWritten uint64
+		// The cap on the WAL data that has not been flushed yet
+		// (Options.MaxUnflushedWALBytes), or 0 if there is no cap. Size is the
+		// WAL data counted against it.
+		QuotaSize uint64
+		// Number of writes delayed, and their total delay, because the
+		// un-flushed WAL data approached QuotaSize.
+		QuotaDelayCount    int64
+		QuotaDelayDuration time.Duration
+		// Number of write stalls because the un-flushed WAL data reached
+		// QuotaSize.
+		QuotaStallCount int64
 	}
 
 	LogWriter struct {
@@ -263,6 +363,47 @@ type Metrics struct {
 		record.LogWriterMetrics
 	}
 
+	// Commit holds metrics for the commit pipeline. The latency histograms
+	// record durations in nanoseconds and are cumulative over the lifetime of
+	// the DB. The histograms of the pipeline stages are nil unless
+	// Options.Experimental.EnableCommitMetrics is set.
+	Commit struct {
+		// QueueDepth is the number of batches in the commit pipeline at the time
+		// the metrics were collected.
+		QueueDepth int
+		// SyncLatency is the latency of WAL syncs, which synced commits wait
+		// for. It is the same histogram as LogWriter.FsyncLatency, and is
+		// recorded regardless of Options.Experimental.EnableCommitMetrics.
+		SyncLatency prometheus.Histogram
+		// EnqueueLatency is the time a commit waits to enter the commit pipeline
+		// and acquire the right to write to the WAL.
+		EnqueueLatency prometheus.Histogram
+		// WALWriteLatency is the time spent writing a batch to the WAL, including
+		// any write stall or WAL rotation. It does not include the WAL sync.
+		WALWriteLatency prometheus.Histogram
+		// MemTableApplyLatency is the time spent applying a batch to the
+		// memtable.
+		MemTableApplyLatency prometheus.Histogram
+		// PublishLatency is the time spent publishing the sequence numbers of a
+		// batch and of any applied batches ahead of it.
+		PublishLatency prometheus.Histogram
+		// CommitWaitLatency is the time a commit waits for its batch to be
+		// published by another goroutine and, for synced commits, for the WAL
+		// sync. For synced commits it is dominated by the WAL sync, whose latency
+		// is available in SyncLatency.
+		CommitWaitLatency prometheus.Histogram
+	}
+
+	// DiskOps holds the histograms of the latencies of the filesystem
+	// operations of the DB, by kind of operation, recorded in nanoseconds and
+	// cumulative over the lifetime of the DB. They're nil unless
+	// Options.Experimental.EnableDiskOpMetrics is set. Comparing them with the
+	// latencies of the operations of the DB tells whether the disk or the DB is
+	// at fault for slow operations.
+	DiskOps struct {
+		Latency [NumDiskOpKinds]prometheus.Histogram
+	}
+
 	private struct {
 		optionsFileSize  uint64
 		manifestFileSize uint64
@@ -276,6 +417,16 @@ var (
 		prometheus.LinearBuckets(0.0, float64(time.Microsecond*100), 50),
 		prometheus.ExponentialBucketsRange(float64(time.Millisecond*5), float64(10*time.Second), 50)...,
 	)
+
+	// CommitLatencyBuckets are prometheus histogram buckets suitable for a
+	// histogram that records latencies for the stages of a commit.
+	CommitLatencyBuckets = prometheus.ExponentialBucketsRange(
+		float64(time.Microsecond), float64(10*time.Second), 80)
+
+	// DiskOpLatencyBuckets are prometheus histogram buckets suitable for a
+	// histogram that records latencies of filesystem operations.
+	DiskOpLatencyBuckets = prometheus.ExponentialBucketsRange(
+		float64(time.Microsecond), float64(10*time.Second), 80)
 )
 
 // DiskSpaceUsage returns the total disk space used by the database in bytes,
@@ -348,7 +499,7 @@ func (m *Metrics) Total() LevelMetrics {
 //	WAL: 22 files (24B)  in: 25B  written: 26B (4% overhead)
 //	Flushes: 8
 //	Compactions: 5  estimated debt: 6B  in progress: 2 (7B)
-//	default: 27  delete: 28  elision: 29  move: 30  read: 31  rewrite: 32  multi-level: 33
+//	default: 27  delete: 28  elision: 29  move: 30  read: 31  rewrite: 32  ttl: 37  periodic: 38  tombstone: 39  tiering: 40  multi-level: 33
 //	MemTables: 12 (11B)  zombie: 14 (13B)
 //	Zombie tables: 16 (15B)
 //	Block cache: 2 entries (1B)  hit rate: 42.9%
@@ -459,13 +610,17 @@ func (m *Metrics) SafeFormat(w redact.SafePrinter, _ rune) {
 		redact.Safe(m.Compact.NumInProgress),
 		humanize.Bytes.Int64(m.Compact.InProgressBytes))
 
-	w.Printf("             default: %d  delete: %d  elision: %d  move: %d  read: %d  rewrite: %d  multi-level: %d\n",
+	w.Printf("             default: %d  delete: %d  elision: %d  move: %d  read: %d  rewrite: %d  ttl: %d  periodic: %d  tombstone: %d  tiering: %d  multi-level: %d\n",
 		redact.Safe(m.Compact.DefaultCount),
 		redact.Safe(m.Compact.DeleteOnlyCount),
 		redact.Safe(m.Compact.ElisionOnlyCount),
 		redact.Safe(m.Compact.MoveCount),
 		redact.Safe(m.Compact.ReadCount),
 		redact.Safe(m.Compact.RewriteCount),
+		redact.Safe(m.Compact.TTLCount),
+		redact.Safe(m.Compact.PeriodicCount),
+		redact.Safe(m.Compact.TombstoneDensityCount),
+		redact.Safe(m.Compact.TieringCount),
 		redact.Safe(m.Compact.MultiLevelCount))
 
 	w.Printf("MemTables: %d (%s)  zombie: %d (%s)\n",
@@ -478,6 +633,15 @@ func (m *Metrics) SafeFormat(w redact.SafePrinter, _ rune) {
 		redact.Safe(m.Table.ZombieCount),
 		humanize.Bytes.Uint64(m.Table.ZombieSize))
 
+	if m.BlobFiles.Count > 0 || m.Compact.BlobRewriteCount > 0 {
+		w.Printf("Blob files: %d (%s)  values: %s  live: %s  rewrites: %d\n",
+			redact.Safe(m.BlobFiles.Count),
+			humanize.Bytes.Uint64(m.BlobFiles.Size),
+			humanize.Bytes.Uint64(m.BlobFiles.ValueSize),
+			humanize.Bytes.Uint64(m.BlobFiles.LiveValueSize),
+			redact.Safe(m.Compact.BlobRewriteCount))
+	}
+
 	formatCacheMetrics := func(m *CacheMetrics, name redact.SafeString) {
 		w.Printf("%s: %s entries (%s)  hit rate: %.1f%%\n",
 			name,
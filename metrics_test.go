@@ -11,8 +11,11 @@ import (
 	"testing"
 
 	"github.com/cockroachdb/datadriven"
+	"github.com/cockroachdb/pebble/bloom"
 	"github.com/cockroachdb/pebble/internal/humanize"
 	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/objstorage/remote"
+	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/cockroachdb/redact"
 	"github.com/stretchr/testify/require"
@@ -31,6 +34,10 @@ func exampleMetrics() Metrics {
 	m.Compact.MoveCount = 30
 	m.Compact.ReadCount = 31
 	m.Compact.RewriteCount = 32
+	m.Compact.TTLCount = 37
+	m.Compact.PeriodicCount = 38
+	m.Compact.TombstoneDensityCount = 39
+	m.Compact.TieringCount = 40
 	m.Compact.MultiLevelCount = 33
 	m.Compact.EstimatedDebt = 6
 	m.Compact.InProgressBytes = 7
@@ -272,3 +279,96 @@ func TestMetricsWAmpDisableWAL(t *testing.T) {
 	require.Greater(t, tot.WriteAmp(), 1.0)
 	require.NoError(t, d.Close())
 }
+
+func TestMetricsBlockCacheByKind(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:       vfs.NewMem(),
+		Comparer: testkeys.Comparer,
+		Levels:   []LevelOptions{{FilterPolicy: bloom.FilterPolicy(10)}},
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Set([]byte("b"), []byte("2"), nil))
+	require.NoError(t, d.Flush())
+
+	seekPrefix := func(key string) {
+		iter, _ := d.NewIter(nil)
+		require.True(t, iter.SeekPrefixGE([]byte(key)))
+		require.NoError(t, iter.Close())
+	}
+
+	// The first seek misses the filter, index and data blocks of the L0
+	// table, and the second finds them in the block cache.
+	seekPrefix("a")
+	seekPrefix("b")
+	m := d.Metrics()
+	l0 := m.Levels[0].Additional.BlockCache
+	for _, kind := range []sstable.BlockKind{sstable.BlockKindData, sstable.BlockKindFilter} {
+		require.Equal(t, int64(1), l0[kind].Misses, "%s", kind)
+		require.Equal(t, int64(1), l0[kind].Hits, "%s", kind)
+		require.Greater(t, l0[kind].HitBytes, int64(0), "%s", kind)
+		require.Equal(t, l0[kind].HitBytes, l0[kind].MissBytes, "%s", kind)
+	}
+	require.Greater(t, l0[sstable.BlockKindIndex].Hits+l0[sstable.BlockKindIndex].Misses, int64(0))
+	require.Zero(t, m.Levels[6].Additional.BlockCache.Total())
+
+	// The lookups of the level are included in the DB's totals, along with
+	// those made when the table was opened.
+	for kind := range l0 {
+		require.GreaterOrEqual(t, m.BlockCacheByKind[kind].Hits, l0[kind].Hits)
+		require.GreaterOrEqual(t, m.BlockCacheByKind[kind].Misses, l0[kind].Misses)
+	}
+	require.Greater(t, m.BlockCacheByKind[sstable.BlockKindOther].Misses, int64(0))
+}
+
+func TestMetricsSecondaryCache(t *testing.T) {
+	for _, mode := range []SecondaryCacheWriteMode{SecondaryCacheWriteAround, SecondaryCacheWriteThrough} {
+		t.Run(mode.String(), func(t *testing.T) {
+			opts := &Options{FS: vfs.NewMem()}
+			opts.Experimental.RemoteStorage = remote.MakeSimpleFactory(map[remote.Locator]remote.Storage{
+				"": remote.NewInMem(),
+			})
+			opts.Experimental.CreateOnShared = true
+			opts.Experimental.SecondaryCacheSizeBytes = 32 << 20
+			opts.Experimental.SecondaryCacheWriteMode = mode
+			opts.DisableAutomaticCompactions = true
+			opts.EnsureDefaults()
+
+			// The write mode round trips through the OPTIONS file.
+			var parsed Options
+			require.NoError(t, parsed.Parse(opts.String(), nil))
+			require.Equal(t, mode, parsed.Experimental.SecondaryCacheWriteMode)
+
+			d, err := Open("", opts)
+			require.NoError(t, err)
+			defer func() { require.NoError(t, d.Close()) }()
+			require.NoError(t, d.SetCreatorID(1))
+
+			for i := 0; i < 1000; i++ {
+				require.NoError(t, d.Set([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100), nil))
+			}
+			require.NoError(t, d.Flush())
+			verifyGet(t, d, []byte("0000"), make([]byte, 100))
+			verifyGet(t, d, []byte("0999"), make([]byte, 100))
+
+			m := d.Metrics()
+			require.Equal(t, int64(32<<20), m.SecondaryCache.Size)
+			if mode == SecondaryCacheWriteThrough {
+				// The flushed table was added to the cache as it was written.
+				require.Equal(t, m.Levels[0].Size, m.SecondaryCache.WriteThroughBytes)
+			} else {
+				require.Zero(t, m.SecondaryCache.WriteThroughBytes)
+				require.Greater(t, m.SecondaryCache.Misses, int64(0))
+			}
+			// All the reads were of the L0 table.
+			l0 := m.Levels[0].Additional.SecondaryCache
+			require.Greater(t, l0.Hits+l0.Misses, int64(0))
+			require.Equal(t, m.SecondaryCache.Hits, l0.Hits)
+			require.Equal(t, m.SecondaryCache.Misses, l0.Misses)
+			require.Zero(t, m.Levels[6].Additional.SecondaryCache)
+			require.Len(t, m.SecondaryCache.Files, 1)
+		})
+	}
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/sharedcache"
 	"github.com/cockroachdb/pebble/objstorage/remote"
 	"github.com/cockroachdb/pebble/vfs"
 )
@@ -63,6 +64,52 @@ type ReadHandle interface {
 	RecordCacheHit(ctx context.Context, offset, size int64)
 }
 
+// ReadRequest is one of the reads performed by ReadBatch.
+type ReadRequest struct {
+	// P is the buffer read into; len(P) bytes are read.
+	P      []byte
+	Offset int64
+}
+
+// BatchReader is implemented by the Readables and ReadHandles that can
+// perform a batch of reads more efficiently than one read at a time, e.g. by
+// submitting them to the OS together.
+type BatchReader interface {
+	// ReadAtBatch performs the reads, as if by calling ReadAt for each of
+	// them, returning the first error encountered.
+	ReadAtBatch(ctx context.Context, reqs []ReadRequest) error
+	// BatchesReads returns true if ReadAtBatch performs the reads of a batch
+	// concurrently, rather than one at a time.
+	BatchesReads() bool
+}
+
+// BatchesReads returns true if r, which is a Readable or a ReadHandle,
+// performs the reads of a ReadBatch concurrently.
+func BatchesReads(r interface{}) bool {
+	br, ok := r.(BatchReader)
+	return ok && br.BatchesReads()
+}
+
+// ReadBatch performs the reads with r, which is a Readable or a ReadHandle,
+// as a batch if it implements BatchReader and one read at a time otherwise.
+func ReadBatch(
+	ctx context.Context,
+	r interface {
+		ReadAt(ctx context.Context, p []byte, off int64) error
+	},
+	reqs []ReadRequest,
+) error {
+	if br, ok := r.(BatchReader); ok {
+		return br.ReadAtBatch(ctx, reqs)
+	}
+	for _, req := range reqs {
+		if err := r.ReadAt(ctx, req.P, req.Offset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Writable is the handle for an object that is open for writing.
 // Either Finish or Abort must be called.
 type Writable interface {
@@ -89,6 +136,11 @@ type ObjectMetadata struct {
 	DiskFileNum base.DiskFileNum
 	FileType    base.FileType
 
+	// LocalDir is the directory of a local object created in one of the extra
+	// directories of the provider (see CreateOptions.LocalDir). It is empty for
+	// objects in the main directory, and for remote objects.
+	LocalDir string
+
 	// The fields below are only set if the object is on remote storage.
 	Remote struct {
 		// CreatorID identifies the DB instance that originally created the object.
@@ -139,7 +191,8 @@ func (meta *ObjectMetadata) AssertValid() {
 			panic(errors.AssertionFailedf("meta.Remote not empty: %#v", meta.Remote))
 		}
 	} else {
-		if meta.Remote.CustomObjectName != "" {
+		// External objects aren't created by a Pebble instance.
+		if meta.Remote.CustomObjectName == "" {
 			if meta.Remote.CreatorID == 0 {
 				panic(errors.AssertionFailedf("CreatorID not set"))
 			}
@@ -195,6 +248,11 @@ type CreateOptions struct {
 	// SharedCleanupMethod is used for the object when it is created on shared storage.
 	// The default (zero) value is SharedRefTracking.
 	SharedCleanupMethod SharedCleanupMethod
+
+	// LocalDir, if set, is the directory in which the object is created if it
+	// is created locally, instead of the main directory of the provider. It
+	// must be one of the provider's extra directories.
+	LocalDir string
 }
 
 // Provider is a singleton object used to access and manage objects.
@@ -261,6 +319,12 @@ type Provider interface {
 	// List returns the objects currently known to the provider. Does not perform any I/O.
 	List() []ObjectMetadata
 
+	// Rescan lists the local directories of the provider, and adds the objects
+	// created in them by another process since they were last listed (such as
+	// the sstables written by the primary instance of a DB opened as a
+	// secondary). The objects removed by the other process remain known.
+	Rescan() error
+
 	// SetCreatorID sets the CreatorID which is needed in order to use shared
 	// objects. Remote object usage is disabled until this method is called the
 	// first time. Once set, the Creator ID is persisted and cannot change.
@@ -287,6 +351,10 @@ type Provider interface {
 	// IsNotExistError indicates whether the error is known to report that a file or
 	// directory does not exist.
 	IsNotExistError(err error) bool
+
+	// Metrics returns the metrics of the on-disk cache of remote objects. It
+	// returns the zero value if there is no such cache.
+	Metrics() sharedcache.Metrics
 }
 
 // RemoteObjectBacking encodes the metadata necessary to incorporate a shared
@@ -8,6 +8,7 @@ import (
 	"context"
 	"io"
 	"os"
+	"runtime"
 	"sort"
 	"sync"
 
@@ -18,6 +19,7 @@ import (
 	"github.com/cockroachdb/pebble/objstorage"
 	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/objiotracing"
 	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/remoteobjcat"
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/sharedcache"
 	"github.com/cockroachdb/pebble/objstorage/remote"
 	"github.com/cockroachdb/pebble/vfs"
 )
@@ -26,7 +28,12 @@ import (
 type provider struct {
 	st Settings
 
-	fsDir vfs.File
+	// fsDirs are the open main directory (first) and extra directories.
+	fsDirs []vfs.File
+
+	// rings is the pool of io_uring rings used by local objects, if
+	// Settings.IOUringReads is set and io_uring is supported.
+	rings *ringPool
 
 	tracer *objiotracing.Tracer
 
@@ -67,6 +74,11 @@ type Settings struct {
 	FS        vfs.FS
 	FSDirName string
 
+	// FSExtraDirNames are additional directories of FS holding local objects.
+	// Objects are created in FSDirName, unless objstorage.CreateOptions.LocalDir
+	// designates one of FSExtraDirNames. The directories must exist.
+	FSExtraDirNames []string
+
 	// FSDirInitialListing is a listing of FSDirName at the time of calling Open.
 	//
 	// This is an optional optimization to avoid double listing on Open when the
@@ -91,6 +103,27 @@ type Settings struct {
 	// out a large chunk of dirty filesystem buffers.
 	BytesPerSync int
 
+	// DirectIO configures the use of direct I/O (O_DIRECT) for local objects,
+	// which bypasses the OS page cache. When the file system doesn't support
+	// direct I/O, buffered I/O is used instead.
+	DirectIO struct {
+		// Reads enables direct I/O for reads of local objects.
+		Reads bool
+		// Writes enables direct I/O for writes of new local objects.
+		Writes bool
+	}
+
+	// IOUringReads makes reads of local objects use io_uring (on Linux, where
+	// it's supported), including batches of reads (see objstorage.ReadBatch)
+	// and read-ahead, instead of pread and OS read-ahead.
+	IOUringReads bool
+
+	// MmapReads makes reads of local objects (other than those read with
+	// direct I/O) copy from read-only memory mappings of the files, where mmap
+	// is supported, instead of using pread. An I/O error while reading a
+	// mapping crashes the process.
+	MmapReads bool
+
 	// Fields here are set only if the provider is to support remote objects
 	// (experimental).
 	Remote struct {
@@ -109,6 +142,12 @@ type Settings struct {
 		// CacheBlockSize is the block size of the cache; if 0, the default of 32KB is used.
 		CacheBlockSize int
 
+		// CacheWriteThrough, if true, adds the data of objects created on
+		// remote storage to the cache as they are written (write-through).
+		// Otherwise the cache is only populated by reads that miss the cache
+		// (write-around).
+		CacheWriteThrough bool
+
 		// ShardingBlockSize is the size of a shard block. The cache is split into contiguous
 		// ShardingBlockSize units. The units are distributed across multiple independent shards
 		// of the cache, via a hash(offset) modulo num shards operation. The cache replacement
@@ -147,20 +186,25 @@ func Open(settings Settings) (objstorage.Provider, error) {
 }
 
 func open(settings Settings) (p *provider, _ error) {
-	fsDir, err := settings.FS.OpenDir(settings.FSDirName)
-	if err != nil {
-		return nil, err
-	}
-
+	var fsDirs []vfs.File
 	defer func() {
 		if p == nil {
-			fsDir.Close()
+			for _, dir := range fsDirs {
+				dir.Close()
+			}
 		}
 	}()
+	for _, name := range append([]string{settings.FSDirName}, settings.FSExtraDirNames...) {
+		dir, err := settings.FS.OpenDir(name)
+		if err != nil {
+			return nil, err
+		}
+		fsDirs = append(fsDirs, dir)
+	}
 
 	p = &provider{
-		st:    settings,
-		fsDir: fsDir,
+		st:     settings,
+		fsDirs: fsDirs,
 	}
 	p.mu.knownObjects = make(map[base.DiskFileNum]objstorage.ObjectMetadata)
 	p.mu.protectedObjects = make(map[base.DiskFileNum]int)
@@ -169,6 +213,12 @@ func open(settings Settings) (p *provider, _ error) {
 		p.tracer = objiotracing.Open(settings.FS, settings.FSDirName)
 	}
 
+	if settings.IOUringReads {
+		if p.rings = newRingPool(2 * runtime.GOMAXPROCS(0)); p.rings == nil {
+			settings.Logger.Infof("io_uring is not supported; local objects are read with pread")
+		}
+	}
+
 	// Add local FS objects.
 	if err := p.vfsInit(); err != nil {
 		return nil, err
@@ -185,10 +235,14 @@ func open(settings Settings) (p *provider, _ error) {
 // Close is part of the objstorage.Provider interface.
 func (p *provider) Close() error {
 	err := p.sharedClose()
-	if p.fsDir != nil {
-		err = firstError(err, p.fsDir.Close())
-		p.fsDir = nil
+	if p.rings != nil {
+		p.rings.close()
+		p.rings = nil
+	}
+	for _, dir := range p.fsDirs {
+		err = firstError(err, dir.Close())
 	}
+	p.fsDirs = nil
 	if objiotracing.Enabled {
 		if p.tracer != nil {
 			p.tracer.Close()
@@ -215,7 +269,7 @@ func (p *provider) OpenForReading(
 
 	var r objstorage.Readable
 	if !meta.IsRemote() {
-		r, err = p.vfsOpenForReading(ctx, fileType, fileNum, opts)
+		r, err = p.vfsOpenForReading(ctx, meta, opts)
 	} else {
 		r, err = p.remoteOpenForReading(ctx, meta, opts)
 		if err != nil && p.isNotExistError(meta, err) {
@@ -245,7 +299,7 @@ func (p *provider) Create(
 	if opts.PreferSharedStorage && p.st.Remote.CreateOnShared {
 		w, meta, err = p.sharedCreate(ctx, fileType, fileNum, p.st.Remote.CreateOnSharedLocator, opts)
 	} else {
-		w, meta, err = p.vfsCreate(ctx, fileType, fileNum)
+		w, meta, err = p.vfsCreate(ctx, fileType, fileNum, opts.LocalDir)
 	}
 	if err != nil {
 		err = errors.Wrapf(err, "creating object %s", errors.Safe(fileNum))
@@ -272,7 +326,7 @@ func (p *provider) Remove(fileType base.FileType, fileNum base.DiskFileNum) erro
 	}
 
 	if !meta.IsRemote() {
-		err = p.vfsRemove(fileType, fileNum)
+		err = p.vfsRemove(meta)
 	} else {
 		// TODO(radu): implement remote object removal (i.e. deref).
 		err = p.sharedUnref(meta)
@@ -289,6 +343,9 @@ func (p *provider) Remove(fileType base.FileType, fileNum base.DiskFileNum) erro
 	}
 
 	p.removeMetadata(fileNum)
+	if meta.IsRemote() && p.remote.cache != nil {
+		p.remote.cache.RemoveFile(fileNum)
+	}
 	return err
 }
 
@@ -299,6 +356,14 @@ func (p *provider) isNotExistError(meta objstorage.ObjectMetadata, err error) bo
 	return oserror.IsNotExist(err)
 }
 
+// Metrics is part of the objstorage.Provider interface.
+func (p *provider) Metrics() sharedcache.Metrics {
+	if p.remote.cache != nil {
+		return p.remote.cache.Metrics()
+	}
+	return sharedcache.Metrics{}
+}
+
 // IsNotExistError is part of the objstorage.Provider interface.
 func (p *provider) IsNotExistError(err error) bool {
 	// We use errors.Mark(err, os.ErrNotExist) for not-exist errors coming from
@@ -339,15 +404,18 @@ func (p *provider) LinkOrCopyFromLocal(
 			NoSyncOnClose: p.st.NoSyncOnClose,
 			BytesPerSync:  p.st.BytesPerSync,
 		})
-		dstPath := p.vfsPath(dstFileType, dstFileNum)
-		if err := vfs.LinkOrCopy(fs, srcFilePath, dstPath); err != nil {
+		if err := p.checkLocalDir(opts.LocalDir); err != nil {
 			return objstorage.ObjectMetadata{}, err
 		}
-
 		meta := objstorage.ObjectMetadata{
 			DiskFileNum: dstFileNum,
 			FileType:    dstFileType,
+			LocalDir:    opts.LocalDir,
+		}
+		if err := vfs.LinkOrCopy(fs, srcFilePath, p.vfsPath(meta)); err != nil {
+			return objstorage.ObjectMetadata{}, err
 		}
+
 		p.addMetadata(meta)
 		return meta, nil
 	}
@@ -412,7 +480,7 @@ func (p *provider) Lookup(
 // Path is part of the objstorage.Provider interface.
 func (p *provider) Path(meta objstorage.ObjectMetadata) string {
 	if !meta.IsRemote() {
-		return p.vfsPath(meta.FileType, meta.DiskFileNum)
+		return p.vfsPath(meta)
 	}
 	return p.remotePath(meta)
 }
@@ -420,7 +488,7 @@ func (p *provider) Path(meta objstorage.ObjectMetadata) string {
 // Size returns the size of the object.
 func (p *provider) Size(meta objstorage.ObjectMetadata) (int64, error) {
 	if !meta.IsRemote() {
-		return p.vfsSize(meta.FileType, meta.DiskFileNum)
+		return p.vfsSize(meta)
 	}
 	return p.remoteSize(meta)
 }
@@ -439,6 +507,11 @@ func (p *provider) List() []objstorage.ObjectMetadata {
 	return res
 }
 
+// Rescan is part of the objstorage.Provider interface.
+func (p *provider) Rescan() error {
+	return p.vfsRescan()
+}
+
 func (p *provider) addMetadata(meta objstorage.ObjectMetadata) {
 	if invariants.Enabled {
 		meta.AssertValid()
@@ -448,12 +521,13 @@ func (p *provider) addMetadata(meta objstorage.ObjectMetadata) {
 	p.mu.knownObjects[meta.DiskFileNum] = meta
 	if meta.IsRemote() {
 		p.mu.remote.catalogBatch.AddObject(remoteobjcat.RemoteObjectMetadata{
-			FileNum:        meta.DiskFileNum,
-			FileType:       meta.FileType,
-			CreatorID:      meta.Remote.CreatorID,
-			CreatorFileNum: meta.Remote.CreatorFileNum,
-			Locator:        meta.Remote.Locator,
-			CleanupMethod:  meta.Remote.CleanupMethod,
+			FileNum:          meta.DiskFileNum,
+			FileType:         meta.FileType,
+			CreatorID:        meta.Remote.CreatorID,
+			CreatorFileNum:   meta.Remote.CreatorFileNum,
+			Locator:          meta.Remote.Locator,
+			CustomObjectName: meta.Remote.CustomObjectName,
+			CleanupMethod:    meta.Remote.CleanupMethod,
 		})
 	} else {
 		p.mu.localObjectsChanged = true
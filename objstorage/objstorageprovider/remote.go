@@ -228,6 +228,19 @@ func (p *provider) sharedCreateRef(meta objstorage.ObjectMetadata) error {
 	return nil
 }
 
+// sharedDeleteRef deletes the reference marker object of this provider,
+// tolerating a marker that does not exist.
+func (p *provider) sharedDeleteRef(meta objstorage.ObjectMetadata) error {
+	if meta.Remote.CleanupMethod != objstorage.SharedRefTracking {
+		return nil
+	}
+	refName := p.sharedObjectRefName(meta)
+	if err := meta.Remote.Storage.Delete(refName); err != nil && !meta.Remote.Storage.IsNotExistError(err) {
+		return errors.Wrapf(err, "deleting marker object %q", refName)
+	}
+	return nil
+}
+
 func (p *provider) sharedCreate(
 	_ context.Context,
 	fileType base.FileType,
@@ -252,16 +265,26 @@ func (p *provider) sharedCreate(
 	meta.Remote.Locator = locator
 	meta.Remote.Storage = storage
 
+	// Create the marker object before the object, so that the object is never
+	// unreferenced on shared storage (see DeleteUnreferencedSharedObjects).
+	if err := p.sharedCreateRef(meta); err != nil {
+		return nil, objstorage.ObjectMetadata{}, err
+	}
 	objName := remoteObjectName(meta)
 	writer, err := storage.CreateObject(objName)
 	if err != nil {
+		_ = p.sharedDeleteRef(meta)
 		return nil, objstorage.ObjectMetadata{}, errors.Wrapf(err, "creating object %q", objName)
 	}
-	return &sharedWritable{
+	w := &sharedWritable{
 		p:             p,
 		meta:          meta,
 		storageWriter: writer,
-	}, meta, nil
+	}
+	if p.remote.cache != nil && p.st.Remote.CacheWriteThrough {
+		w.cache = p.remote.cache
+	}
+	return w, meta, nil
 }
 
 func (p *provider) remoteOpenForReading(
@@ -322,11 +345,13 @@ func (p *provider) sharedUnref(meta objstorage.ObjectMetadata) error {
 		return nil
 	}
 
-	refName := p.sharedObjectRefName(meta)
-	// Tolerate a not-exists error.
-	if err := meta.Remote.Storage.Delete(refName); err != nil && !meta.Remote.Storage.IsNotExistError(err) {
+	if err := p.sharedDeleteRef(meta); err != nil {
 		return err
 	}
+	// The object is deleted only if no references remain after ours is
+	// deleted. A provider attaching the object creates its reference before
+	// checking that the reference of the provider it got the object from
+	// exists, so either we see its reference here, or it fails to attach.
 	otherRefs, err := meta.Remote.Storage.List(sharedObjectRefPrefix(meta), "" /* delimiter */)
 	if err != nil {
 		return err
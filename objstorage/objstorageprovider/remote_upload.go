@@ -0,0 +1,117 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package objstorageprovider
+
+import (
+	"io"
+
+	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/objstorage/remote"
+)
+
+// defaultRemoteUploadPartSize is the default RemoteUploadOptions.PartSize.
+const defaultRemoteUploadPartSize = 8 << 20
+
+// RemoteUploadOptions configures a Writable created by CreateRemoteWritable.
+type RemoteUploadOptions struct {
+	// PartSize is the size of the writes to the object: the data written to the
+	// Writable is buffered until PartSize bytes are available, and the last
+	// write of the object may be smaller. Remote storage implementations
+	// typically upload each write of an object as a part of a multipart
+	// upload, whose parts have a minimum size (5MB for S3), and upload larger
+	// parts more efficiently.
+	//
+	// The default value is 8MB.
+	PartSize int
+}
+
+// CreateRemoteWritable creates the object objName in the remote storage, and
+// returns a Writable that streams the data written to it to the object in
+// parts. It allows writing an sstable directly to remote storage, for example
+// to build external files ingested with DB.IngestExternalFiles without writing
+// them to local disk first. Aborting the Writable deletes the object.
+func CreateRemoteWritable(
+	storage remote.Storage, objName string, opts RemoteUploadOptions,
+) (objstorage.Writable, error) {
+	if opts.PartSize <= 0 {
+		opts.PartSize = defaultRemoteUploadPartSize
+	}
+	w, err := storage.CreateObject(objName)
+	if err != nil {
+		return nil, err
+	}
+	return &remoteUploadWritable{
+		storage:       storage,
+		objName:       objName,
+		storageWriter: w,
+		partSize:      opts.PartSize,
+	}, nil
+}
+
+// remoteUploadWritable is a Writable that buffers writes into parts written to
+// the WriteCloser returned by remote.Storage.CreateObject.
+type remoteUploadWritable struct {
+	storage       remote.Storage
+	objName       string
+	storageWriter io.WriteCloser
+	partSize      int
+	buf           []byte
+}
+
+var _ objstorage.Writable = (*remoteUploadWritable)(nil)
+
+// Write is part of the Writable interface.
+func (w *remoteUploadWritable) Write(p []byte) error {
+	for len(p) > 0 {
+		if w.buf == nil {
+			w.buf = make([]byte, 0, w.partSize)
+		}
+		n := w.partSize - len(w.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+		if len(w.buf) == w.partSize {
+			if err := w.writePart(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *remoteUploadWritable) writePart() error {
+	_, err := w.storageWriter.Write(w.buf)
+	w.buf = w.buf[:0]
+	return err
+}
+
+// Finish is part of the Writable interface.
+func (w *remoteUploadWritable) Finish() error {
+	if len(w.buf) > 0 {
+		if err := w.writePart(); err != nil {
+			w.Abort()
+			return err
+		}
+	}
+	err := w.storageWriter.Close()
+	w.storageWriter = nil
+	if err != nil {
+		w.Abort()
+		return err
+	}
+	return nil
+}
+
+// Abort is part of the Writable interface.
+func (w *remoteUploadWritable) Abort() {
+	if w.storageWriter != nil {
+		_ = w.storageWriter.Close()
+		w.storageWriter = nil
+	}
+	w.buf = nil
+	_ = w.storage.Delete(w.objName)
+}
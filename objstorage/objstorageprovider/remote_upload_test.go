@@ -0,0 +1,68 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package objstorageprovider
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/cockroachdb/pebble/objstorage/remote"
+	"github.com/stretchr/testify/require"
+)
+
+// partRecordingStorage records the sizes of the writes to its objects.
+type partRecordingStorage struct {
+	remote.Storage
+	parts []int
+}
+
+func (s *partRecordingStorage) CreateObject(objName string) (io.WriteCloser, error) {
+	w, err := s.Storage.CreateObject(objName)
+	return &partRecordingWriter{WriteCloser: w, s: s}, err
+}
+
+type partRecordingWriter struct {
+	io.WriteCloser
+	s *partRecordingStorage
+}
+
+func (w *partRecordingWriter) Write(p []byte) (int, error) {
+	w.s.parts = append(w.s.parts, len(p))
+	return w.WriteCloser.Write(p)
+}
+
+func TestRemoteUploadWritable(t *testing.T) {
+	storage := &partRecordingStorage{Storage: remote.NewInMem()}
+	w, err := CreateRemoteWritable(storage, "obj", RemoteUploadOptions{PartSize: 100})
+	require.NoError(t, err)
+	data := bytes.Repeat([]byte("0123456789"), 25)
+	for i := 0; i < len(data); i += 30 {
+		end := i + 30
+		if end > len(data) {
+			end = len(data)
+		}
+		require.NoError(t, w.Write(data[i:end]))
+	}
+	require.NoError(t, w.Finish())
+	require.Equal(t, []int{100, 100, 50}, storage.parts)
+
+	r, size, err := storage.ReadObject(context.Background(), "obj")
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), size)
+	got := make([]byte, size)
+	require.NoError(t, r.ReadAt(context.Background(), got, 0))
+	require.Equal(t, data, got)
+	require.NoError(t, r.Close())
+
+	// Aborting deletes the object.
+	w, err = CreateRemoteWritable(storage, "aborted", RemoteUploadOptions{PartSize: 100})
+	require.NoError(t, err)
+	require.NoError(t, w.Write(data))
+	w.Abort()
+	_, err = storage.Size("aborted")
+	require.True(t, storage.IsNotExistError(err))
+}
@@ -0,0 +1,263 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package objstorageprovider
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/objstorage/remote"
+)
+
+// Shared objects are referenced by the providers that use them through
+// reference marker objects (see sharedObjectRefName), and the providers follow
+// a protocol that keeps an object from being deleted while it is referenced:
+//
+//   - A provider creates its reference to an object it creates before the
+//     object itself, so an object is never unreferenced while it is in use.
+//
+//   - A provider that attaches an object, or to which a reference is
+//     transferred, creates its reference and then checks that the reference it
+//     got the object through still exists, failing (and removing its
+//     reference) otherwise.
+//
+//   - A provider that removes an object deletes its reference, then lists the
+//     references of the object and deletes the object if there are none.
+//
+// If a provider removing an object and another attaching it race, either the
+// attaching provider's reference is created before the removing provider lists
+// the references, in which case the object is not deleted, or it is created
+// after the removing provider's reference is deleted, in which case the check
+// fails and the attach is rejected. Deletions tolerate objects that no longer
+// exist, so that concurrent deletions of the same object by several providers
+// (or by DeleteUnreferencedSharedObjects) are harmless.
+
+// SharedObject describes an object on shared storage created by a provider
+// with ref tracking enabled, along with the references to it.
+type SharedObject struct {
+	// Name is the name of the object on shared storage.
+	Name string
+	// CreatorID and CreatorFileNum identify the provider that created the
+	// object, and the object in that provider.
+	CreatorID      objstorage.CreatorID
+	CreatorFileNum base.DiskFileNum
+	// Refs are the references to the object, sorted by creator ID and file
+	// number.
+	Refs []SharedObjectRef
+	// Missing is set if references to the object exist but the object doesn't.
+	// This happens transiently while the object is created, or if the object
+	// was deleted in a way that doesn't follow the ref tracking protocol.
+	Missing bool
+}
+
+// SharedObjectRef identifies a reference to a shared object: the provider
+// holding it, and the file number of the object in that provider.
+type SharedObjectRef struct {
+	CreatorID objstorage.CreatorID
+	FileNum   base.DiskFileNum
+}
+
+// meta returns the metadata that the object names are derived from.
+func (o *SharedObject) meta() objstorage.ObjectMetadata {
+	meta := objstorage.ObjectMetadata{FileType: base.FileTypeTable}
+	meta.Remote.CreatorID = o.CreatorID
+	meta.Remote.CreatorFileNum = o.CreatorFileNum
+	meta.Remote.CleanupMethod = objstorage.SharedRefTracking
+	return meta
+}
+
+// ListSharedObjects lists the shared sstables and their references in the
+// given storage. Objects whose names weren't generated by a provider, such as
+// external files, are not listed.
+//
+// The listing is not atomic: references created or deleted concurrently may
+// or may not be reflected.
+func ListSharedObjects(storage remote.Storage) ([]SharedObject, error) {
+	names, err := storage.List("" /* prefix */, "" /* delimiter */)
+	if err != nil {
+		return nil, err
+	}
+	objs := make(map[string]*SharedObject)
+	getObj := func(name string) *SharedObject {
+		if o, ok := objs[name]; ok {
+			return o
+		}
+		creatorID, creatorFileNum, ok := parseSharedObjectName(name)
+		if !ok {
+			return nil
+		}
+		o := &SharedObject{
+			Name:           name,
+			CreatorID:      creatorID,
+			CreatorFileNum: creatorFileNum,
+			Missing:        true,
+		}
+		objs[name] = o
+		return o
+	}
+	for _, name := range names {
+		if i := strings.Index(name, ".sst.ref."); i >= 0 {
+			ref, ok := parseSharedObjectRef(name[i+len(".sst.ref."):])
+			if o := getObj(name[:i+len(".sst")]); ok && o != nil {
+				o.Refs = append(o.Refs, ref)
+			}
+			continue
+		}
+		if o := getObj(name); o != nil {
+			o.Missing = false
+		}
+	}
+	res := make([]SharedObject, 0, len(objs))
+	for _, o := range objs {
+		sort.Slice(o.Refs, func(i, j int) bool {
+			a, b := o.Refs[i], o.Refs[j]
+			return a.CreatorID < b.CreatorID || (a.CreatorID == b.CreatorID && a.FileNum.FileNum() < b.FileNum.FileNum())
+		})
+		res = append(res, *o)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res, nil
+}
+
+// TransferSharedObjectRef transfers the reference to a shared object held by
+// one provider to another: the reference of the latter is created, and the
+// reference of the former is deleted. It is used to hand the ownership of an
+// object from a provider (which must stop using the object, without removing
+// it) to another provider, which can then attach the object with the new
+// reference.
+//
+// It fails, without creating the new reference, if the reference being
+// transferred does not exist (for example, because the object was removed
+// from the provider holding it concurrently).
+func TransferSharedObjectRef(
+	storage remote.Storage, obj SharedObject, from, to SharedObjectRef,
+) error {
+	if from == to {
+		return errors.Errorf("transferring reference %s to itself", from)
+	}
+	meta := obj.meta()
+	toName := sharedObjectRefName(meta, to.CreatorID, to.FileNum)
+	writer, err := storage.CreateObject(toName)
+	if err == nil {
+		// The object is empty, just close the writer.
+		err = writer.Close()
+	}
+	if err != nil {
+		return errors.Wrapf(err, "creating marker object %q", toName)
+	}
+	// Check the reference being transferred after creating the new one; see
+	// the protocol above.
+	fromName := sharedObjectRefName(meta, from.CreatorID, from.FileNum)
+	if _, err := storage.Size(fromName); err != nil {
+		// Delete the new reference. If the provider that deleted the reference
+		// being transferred saw the new one, the object is left unreferenced,
+		// to be deleted by DeleteUnreferencedSharedObjects.
+		_ = storage.Delete(toName)
+		if storage.IsNotExistError(err) {
+			return errors.Errorf("marker object %q does not exist", fromName)
+		}
+		return errors.Wrapf(err, "checking marker object %q", fromName)
+	}
+	if err := storage.Delete(fromName); err != nil && !storage.IsNotExistError(err) {
+		return errors.Wrapf(err, "deleting marker object %q", fromName)
+	}
+	return nil
+}
+
+// DeleteUnreferencedSharedObjects deletes the shared sstables in the given
+// storage that have no references, returning their names. These are objects
+// leaked by providers that failed or crashed while removing them.
+//
+// The references of each object are listed again right before it is deleted.
+// Objects are only unreferenced once all the providers using them have
+// removed them, and no provider can reference them afterwards (attaching
+// fails once the reference attached through is deleted), so objects that are
+// unreferenced when listed cannot be in use. It is safe to run concurrently
+// with providers using the storage, and with other calls to
+// DeleteUnreferencedSharedObjects.
+func DeleteUnreferencedSharedObjects(storage remote.Storage) ([]string, error) {
+	objs, err := ListSharedObjects(storage)
+	if err != nil {
+		return nil, err
+	}
+	var deleted []string
+	for i := range objs {
+		o := &objs[i]
+		if o.Missing || len(o.Refs) > 0 {
+			continue
+		}
+		refs, err := storage.List(sharedObjectRefPrefix(o.meta()), "" /* delimiter */)
+		if err != nil {
+			return deleted, err
+		}
+		if len(refs) > 0 {
+			continue
+		}
+		if err := storage.Delete(o.Name); err != nil {
+			if storage.IsNotExistError(err) {
+				// The object was deleted concurrently.
+				continue
+			}
+			return deleted, errors.Wrapf(err, "deleting object %q", o.Name)
+		}
+		deleted = append(deleted, o.Name)
+	}
+	return deleted, nil
+}
+
+// parseSharedObjectName parses the name of a shared sstable, as generated by
+// remoteObjectName.
+func parseSharedObjectName(
+	name string,
+) (creatorID objstorage.CreatorID, creatorFileNum base.DiskFileNum, ok bool) {
+	if !strings.HasSuffix(name, ".sst") {
+		return 0, base.DiskFileNum{}, false
+	}
+	parts := strings.Split(strings.TrimSuffix(name, ".sst"), "-")
+	if len(parts) != 3 || len(parts[0]) != 4 {
+		return 0, base.DiskFileNum{}, false
+	}
+	hash, err1 := strconv.ParseUint(parts[0], 16, 16)
+	id, err2 := strconv.ParseUint(parts[1], 10, 64)
+	fileNum, err3 := strconv.ParseUint(parts[2], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, base.DiskFileNum{}, false
+	}
+	creatorID = objstorage.CreatorID(id)
+	creatorFileNum = base.FileNum(fileNum).DiskFileNum()
+	// Check the hash, to skip names that only look like generated ones.
+	o := SharedObject{CreatorID: creatorID, CreatorFileNum: creatorFileNum}
+	if uint16(hash) != objHash(o.meta()) || remoteObjectName(o.meta()) != name {
+		return 0, base.DiskFileNum{}, false
+	}
+	return creatorID, creatorFileNum, true
+}
+
+// parseSharedObjectRef parses the "<ref-creator-id>.<ref-file-num>" suffix of
+// the name of a reference marker object.
+func parseSharedObjectRef(s string) (SharedObjectRef, bool) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 2 {
+		return SharedObjectRef{}, false
+	}
+	id, err1 := strconv.ParseUint(parts[0], 10, 64)
+	fileNum, err2 := strconv.ParseUint(parts[1], 10, 64)
+	if err1 != nil || err2 != nil {
+		return SharedObjectRef{}, false
+	}
+	return SharedObjectRef{
+		CreatorID: objstorage.CreatorID(id),
+		FileNum:   base.FileNum(fileNum).DiskFileNum(),
+	}, true
+}
+
+// String implements fmt.Stringer.
+func (r SharedObjectRef) String() string {
+	return r.CreatorID.String() + "." + r.FileNum.String()
+}
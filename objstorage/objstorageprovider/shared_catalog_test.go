@@ -0,0 +1,122 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package objstorageprovider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/objstorage/remote"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSharedCatalog(t *testing.T) {
+	ctx := context.Background()
+	storage := remote.NewInMem()
+	sharedFactory := remote.MakeSimpleFactory(map[remote.Locator]remote.Storage{
+		"": storage,
+	})
+	open := func(creatorID objstorage.CreatorID) objstorage.Provider {
+		st := DefaultSettings(vfs.NewMem(), "")
+		st.Remote.StorageFactory = sharedFactory
+		st.Remote.CreateOnShared = true
+		p, err := Open(st)
+		require.NoError(t, err)
+		require.NoError(t, p.SetCreatorID(creatorID))
+		return p
+	}
+	p1 := open(1)
+	defer p1.Close()
+	p2 := open(2)
+	defer p2.Close()
+
+	fileNum := func(n uint64) base.DiskFileNum { return base.FileNum(n).DiskFileNum() }
+	create := func(n uint64) objstorage.Writable {
+		w, _, err := p1.Create(ctx, base.FileTypeTable, fileNum(n), objstorage.CreateOptions{
+			PreferSharedStorage: true,
+			SharedCleanupMethod: objstorage.SharedRefTracking,
+		})
+		require.NoError(t, err)
+		require.NoError(t, w.Write(make([]byte, 100)))
+		return w
+	}
+	list := func() []SharedObject {
+		objs, err := ListSharedObjects(storage)
+		require.NoError(t, err)
+		return objs
+	}
+	refs := func(refs ...SharedObjectRef) []SharedObjectRef { return refs }
+
+	// Objects not created by a provider are not listed.
+	ext, err := storage.CreateObject("external.sst")
+	require.NoError(t, err)
+	require.NoError(t, ext.Close())
+
+	// An aborted object is deleted along with its reference.
+	create(1).Abort()
+	require.Empty(t, list())
+
+	// The reference of an object is created along with it.
+	w := create(2)
+	objs := list()
+	require.Len(t, objs, 1)
+	require.True(t, objs[0].Missing)
+	require.NoError(t, w.Finish())
+	objs = list()
+	require.Len(t, objs, 1)
+	obj := objs[0]
+	require.False(t, obj.Missing)
+	require.Equal(t, objstorage.CreatorID(1), obj.CreatorID)
+	require.Equal(t, fileNum(2), obj.CreatorFileNum)
+	require.Equal(t, refs(SharedObjectRef{1, fileNum(2)}), obj.Refs)
+
+	// Attaching the object adds a reference.
+	meta, err := p1.Lookup(base.FileTypeTable, fileNum(2))
+	require.NoError(t, err)
+	h, err := p1.RemoteObjectBacking(&meta)
+	require.NoError(t, err)
+	backing, err := h.Get()
+	require.NoError(t, err)
+	h.Close()
+	_, err = p2.AttachRemoteObjects([]objstorage.RemoteObjectToAttach{{
+		FileNum:  fileNum(5),
+		FileType: base.FileTypeTable,
+		Backing:  backing,
+	}})
+	require.NoError(t, err)
+	require.Equal(t, refs(SharedObjectRef{1, fileNum(2)}, SharedObjectRef{2, fileNum(5)}), list()[0].Refs)
+
+	// Transfer the reference of the second provider to a third one.
+	require.NoError(t, TransferSharedObjectRef(storage, obj, SharedObjectRef{2, fileNum(5)}, SharedObjectRef{3, fileNum(7)}))
+	require.Equal(t, refs(SharedObjectRef{1, fileNum(2)}, SharedObjectRef{3, fileNum(7)}), list()[0].Refs)
+
+	// Transferring a reference that doesn't exist fails, without adding a
+	// reference.
+	require.Error(t, TransferSharedObjectRef(storage, obj, SharedObjectRef{2, fileNum(5)}, SharedObjectRef{4, fileNum(1)}))
+	require.Equal(t, refs(SharedObjectRef{1, fileNum(2)}, SharedObjectRef{3, fileNum(7)}), list()[0].Refs)
+
+	// Removing the object from the creator leaves it in place for the third
+	// provider.
+	require.NoError(t, p1.Remove(base.FileTypeTable, fileNum(2)))
+	require.Equal(t, refs(SharedObjectRef{3, fileNum(7)}), list()[0].Refs)
+	deleted, err := DeleteUnreferencedSharedObjects(storage)
+	require.NoError(t, err)
+	require.Empty(t, deleted)
+
+	// The object is deleted once the last reference is gone.
+	require.NoError(t, storage.Delete(obj.Name+".ref.3.000007"))
+	deleted, err = DeleteUnreferencedSharedObjects(storage)
+	require.NoError(t, err)
+	require.Equal(t, []string{obj.Name}, deleted)
+	require.Empty(t, list())
+	deleted, err = DeleteUnreferencedSharedObjects(storage)
+	require.NoError(t, err)
+	require.Empty(t, deleted)
+	_, err = storage.Size("external.sst")
+	require.NoError(t, err)
+}
@@ -8,6 +8,7 @@ import (
 	"io"
 
 	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider/sharedcache"
 )
 
 // NewRemoteWritable creates an objstorage.Writable out of an io.WriteCloser.
@@ -23,14 +24,45 @@ type sharedWritable struct {
 	p             *provider
 	meta          objstorage.ObjectMetadata
 	storageWriter io.WriteCloser
+
+	// cache is set if the data of the object is added to the cache as it is
+	// written (see Settings.Remote.CacheWriteThrough). The data not yet added
+	// is buffered in cacheBuf, starting at cacheOffset.
+	cache       *sharedcache.Cache
+	cacheBuf    []byte
+	cacheOffset int64
 }
 
+// writeThroughChunkBlocks is the number of cache blocks of data that are
+// added to the cache at a time.
+const writeThroughChunkBlocks = 32
+
 var _ objstorage.Writable = (*sharedWritable)(nil)
 
 // Write is part of the Writable interface.
 func (w *sharedWritable) Write(p []byte) error {
-	_, err := w.storageWriter.Write(p)
-	return err
+	if _, err := w.storageWriter.Write(p); err != nil {
+		return err
+	}
+	if w.cache != nil {
+		w.cacheBuf = append(w.cacheBuf, p...)
+		if chunkSize := writeThroughChunkBlocks * w.cache.BlockSize(); len(w.cacheBuf) >= chunkSize {
+			w.flushToCache(len(w.cacheBuf) / w.cache.BlockSize() * w.cache.BlockSize())
+		}
+	}
+	return nil
+}
+
+// flushToCache adds the first n bytes of cacheBuf to the cache.
+func (w *sharedWritable) flushToCache(n int) {
+	if n == 0 {
+		return
+	}
+	w.cache.Write(w.meta.DiskFileNum, w.cacheBuf[:n:n], w.cacheOffset)
+	w.cacheOffset += int64(n)
+	// The cache holds on to the flushed data, so copy the rest into a new
+	// buffer.
+	w.cacheBuf = append([]byte(nil), w.cacheBuf[n:]...)
 }
 
 // Finish is part of the Writable interface.
@@ -41,13 +73,10 @@ func (w *sharedWritable) Finish() error {
 		w.Abort()
 		return err
 	}
-
-	// Create the marker object.
-	if w.p != nil {
-		if err := w.p.sharedCreateRef(w.meta); err != nil {
-			w.Abort()
-			return err
-		}
+	// The marker object was created along with the object, in sharedCreate.
+	if w.cache != nil {
+		w.flushToCache(len(w.cacheBuf))
+		w.cacheBuf = nil
 	}
 	return nil
 }
@@ -59,7 +88,12 @@ func (w *sharedWritable) Abort() {
 		w.storageWriter = nil
 	}
 	if w.p != nil {
+		// Delete the object, if it was created, before the marker object, so
+		// that the object is never unreferenced.
+		objName := remoteObjectName(w.meta)
+		if err := w.meta.Remote.Storage.Delete(objName); err == nil || w.meta.Remote.Storage.IsNotExistError(err) {
+			_ = w.p.sharedDeleteRef(w.meta)
+		}
 		w.p.removeMetadata(w.meta.DiskFileNum)
 	}
-	// TODO(radu): delete the object if it was created.
 }
@@ -33,9 +33,86 @@ type Cache struct {
 	// is just for testing.
 	misses atomic.Int32
 
+	// files holds the per-object metrics.
+	files fileMetricsMap
+	// writeThroughBytes is the number of bytes added to the cache by Write.
+	writeThroughBytes atomic.Int64
+
 	writeWorkers writeWorkers
 }
 
+// Metrics holds metrics for the cache.
+type Metrics struct {
+	// Size is the size of the cache in bytes.
+	Size int64
+	// Hits and Misses are the cumulative number of reads served entirely by the
+	// cache, and of reads that read at least part of the data from remote
+	// storage.
+	Hits, Misses int64
+	// Evictions is the cumulative number of blocks evicted from the cache to
+	// make room for other data.
+	Evictions int64
+	// WriteThroughBytes is the cumulative number of bytes added to the cache as
+	// objects were written, see Cache.Write.
+	WriteThroughBytes int64
+	// Files holds the metrics of the objects that were read through or written
+	// to the cache and not yet removed with Cache.RemoveFile.
+	Files map[base.DiskFileNum]FileMetrics
+}
+
+// FileMetrics holds the metrics of the cache for an object.
+type FileMetrics struct {
+	// Hits and Misses are the number of reads of the object served entirely
+	// by the cache, and of reads that read at least part of the data from
+	// remote storage.
+	Hits, Misses int64
+	// Evictions is the number of blocks of the object evicted from the cache.
+	Evictions int64
+}
+
+// HitRate returns the fraction of the reads served entirely by the cache, or
+// 0 if there were no reads.
+func (m FileMetrics) HitRate() float64 {
+	if m.Hits+m.Misses == 0 {
+		return 0
+	}
+	return float64(m.Hits) / float64(m.Hits+m.Misses)
+}
+
+// fileMetricsMap holds the metrics of each object.
+type fileMetricsMap struct {
+	mu sync.Mutex
+	m  map[base.DiskFileNum]*fileMetrics
+}
+
+type fileMetrics struct {
+	hits, misses, evictions atomic.Int64
+}
+
+// recordEviction records the eviction of a block of an object. Unlike reads,
+// it doesn't add metrics for objects that were removed.
+func (f *fileMetricsMap) recordEviction(fileNum base.DiskFileNum) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if m, ok := f.m[fileNum]; ok {
+		m.evictions.Add(1)
+	}
+}
+
+func (f *fileMetricsMap) get(fileNum base.DiskFileNum) *fileMetrics {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	m, ok := f.m[fileNum]
+	if !ok {
+		if f.m == nil {
+			f.m = make(map[base.DiskFileNum]*fileMetrics)
+		}
+		m = &fileMetrics{}
+		f.m[fileNum] = m
+	}
+	return m
+}
+
 const (
 	// writeWorkersPerShard is used to establish the number of worker goroutines
 	// that perform writes to the cache.
@@ -77,6 +154,7 @@ func Open(
 		if err := sc.shards[i].init(fs, fsDir, i, blocksPerShard, blockSize, shardingBlockSize); err != nil {
 			return nil, err
 		}
+		sc.shards[i].files = &sc.files
 	}
 	sc.writeWorkers.Start(sc, numShards*writeWorkersPerShard)
 	return sc, nil
@@ -130,6 +208,7 @@ func (c *Cache) ReadAt(
 		}
 		if n == len(p) {
 			// Everything was in cache!
+			c.files.get(fileNum).hits.Add(1)
 			return nil
 		}
 
@@ -146,6 +225,7 @@ func (c *Cache) ReadAt(
 	}
 
 	c.misses.Add(1)
+	c.files.get(fileNum).misses.Add(1)
 
 	if flags.ReadOnly {
 		return objReader.ReadAt(ctx, p, ofs)
@@ -177,6 +257,67 @@ func (c *Cache) ReadAt(
 	return nil
 }
 
+// Write adds data of an object that is being written to remote storage to the
+// cache, so that reads of the object don't have to go to remote storage. ofs
+// must be a multiple of the block size, and so must len(p) unless p extends
+// to the end of the object. The data is written to the cache asynchronously,
+// and p must not be modified afterwards. Write blocks if too many writes are
+// queued.
+func (c *Cache) Write(fileNum base.DiskFileNum, p []byte, ofs int64) {
+	if invariants.Enabled && c.bm.Remainder(ofs) != 0 {
+		panic(fmt.Sprintf("write with ofs not a multiple of block size: %v", ofs))
+	}
+	c.writeThroughBytes.Add(int64(len(p)))
+	// Track the object, so that the evictions of its blocks are recorded.
+	c.files.get(fileNum)
+	if rem := c.bm.Remainder(int64(len(p))); rem != 0 {
+		// Pad the last block, as ReadAt does for reads that extend to the end
+		// of the object.
+		padded := make([]byte, c.bm.RoundUp(int64(len(p))))
+		copy(padded, p)
+		p = padded
+	}
+	c.writeWorkers.QueueWrite(fileNum, p, ofs)
+}
+
+// BlockSize returns the block size of the cache.
+func (c *Cache) BlockSize() int {
+	return c.bm.BlockSize()
+}
+
+// RemoveFile forgets the metrics of an object that was removed. The object's
+// data remains in the cache until it is evicted.
+func (c *Cache) RemoveFile(fileNum base.DiskFileNum) {
+	c.files.mu.Lock()
+	defer c.files.mu.Unlock()
+	delete(c.files.m, fileNum)
+}
+
+// Metrics returns the metrics of the cache.
+func (c *Cache) Metrics() Metrics {
+	m := Metrics{
+		Size:              int64(len(c.shards)) * c.shards[0].sizeInBlocks * int64(c.bm.BlockSize()),
+		WriteThroughBytes: c.writeThroughBytes.Load(),
+		Files:             make(map[base.DiskFileNum]FileMetrics),
+	}
+	c.files.mu.Lock()
+	defer c.files.mu.Unlock()
+	for fileNum, f := range c.files.m {
+		fm := FileMetrics{
+			Hits:      f.hits.Load(),
+			Misses:    f.misses.Load(),
+			Evictions: f.evictions.Load(),
+		}
+		m.Files[fileNum] = fm
+		m.Hits += fm.Hits
+		m.Misses += fm.Misses
+	}
+	for i := range c.shards {
+		m.Evictions += c.shards[i].evictions.Load()
+	}
+	return m
+}
+
 // get attempts to read the requested data from the cache, if it is already
 // there.
 //
@@ -261,7 +402,11 @@ type shard struct {
 	sizeInBlocks      int64
 	bm                blockMath
 	shardingBlockSize int64
-	mu                struct {
+	// files holds the per-object metrics of the cache, to which evictions are
+	// recorded.
+	files     *fileMetricsMap
+	evictions atomic.Int64
+	mu        struct {
 		sync.Mutex
 		// TODO(josh): None of these datastructures are space-efficient.
 		// Focusing on correctness to start.
@@ -551,7 +696,12 @@ func (s *shard) set(fileNum base.DiskFileNum, p []byte, ofs int64) error {
 				}
 			}
 			s.lruUnlink(cacheBlockIdx)
-			delete(s.mu.where, s.mu.blocks[cacheBlockIdx].logical)
+			evicted := s.mu.blocks[cacheBlockIdx].logical
+			delete(s.mu.where, evicted)
+			s.evictions.Add(1)
+			if s.files != nil {
+				s.files.recordEviction(evicted.filenum)
+			}
 		} else {
 			cacheBlockIdx = s.freePop()
 		}
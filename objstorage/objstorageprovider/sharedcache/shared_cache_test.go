@@ -262,3 +262,70 @@ func tryParseBytesArg(t testing.TB, d *datadriven.TestData, argName string) (val
 
 	return res * factor, true
 }
+
+func TestSharedCacheMetrics(t *testing.T) {
+	ctx := context.Background()
+	fs := vfs.NewMem()
+	provider, err := objstorageprovider.Open(objstorageprovider.DefaultSettings(fs, ""))
+	require.NoError(t, err)
+	defer provider.Close()
+
+	// The cache has a single shard of 4 blocks.
+	const blockSize = 1024
+	cache, err := sharedcache.Open(fs, base.DefaultLogger, "", blockSize, 4*blockSize, 4*blockSize, 1)
+	require.NoError(t, err)
+	defer cache.Close()
+
+	fileNum := func(n uint64) base.DiskFileNum { return base.FileNum(n).DiskFileNum() }
+	createObj := func(n uint64, size int) []byte {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(i)
+		}
+		w, _, err := provider.Create(ctx, base.FileTypeTable, fileNum(n), objstorage.CreateOptions{})
+		require.NoError(t, err)
+		require.NoError(t, w.Write(append([]byte(nil), data...)))
+		require.NoError(t, w.Finish())
+		return data
+	}
+	read := func(n uint64, data []byte, ofs, size int) {
+		r, err := provider.OpenForReading(ctx, base.FileTypeTable, fileNum(n), objstorage.OpenOptions{})
+		require.NoError(t, err)
+		defer r.Close()
+		got := make([]byte, size)
+		require.NoError(t, cache.ReadAt(ctx, fileNum(n), got, int64(ofs), r, r.Size(), sharedcache.ReadFlags{}))
+		require.Equal(t, data[ofs:ofs+size], got)
+		cache.WaitForWritesToComplete()
+	}
+
+	// Objects written through the cache are read from the cache, including
+	// their last, partial, block.
+	data1 := createObj(1, 4*blockSize)
+	cache.Write(fileNum(1), data1[:2*blockSize], 0)
+	cache.Write(fileNum(1), data1[2*blockSize:3*blockSize+500], 2*blockSize)
+	cache.WaitForWritesToComplete()
+	read(1, data1, 0, 2*blockSize)
+	read(1, data1, 3*blockSize, 500)
+	m := cache.Metrics()
+	require.Equal(t, int64(4*blockSize), m.Size)
+	require.Equal(t, int64(3*blockSize+500), m.WriteThroughBytes)
+	require.Equal(t, sharedcache.FileMetrics{Hits: 2}, m.Files[fileNum(1)])
+	require.Equal(t, 1.0, m.Files[fileNum(1)].HitRate())
+
+	// Reading another object misses the cache, and evicts the least recently
+	// used blocks of the first object.
+	data2 := createObj(2, 2*blockSize)
+	read(2, data2, 0, 2*blockSize)
+	read(2, data2, blockSize, blockSize)
+	m = cache.Metrics()
+	require.Equal(t, sharedcache.FileMetrics{Hits: 2, Evictions: 2}, m.Files[fileNum(1)])
+	require.Equal(t, sharedcache.FileMetrics{Hits: 1, Misses: 1}, m.Files[fileNum(2)])
+	require.Equal(t, int64(3), m.Hits)
+	require.Equal(t, int64(1), m.Misses)
+	require.Equal(t, int64(2), m.Evictions)
+
+	// The metrics of removed objects are forgotten.
+	cache.RemoveFile(fileNum(1))
+	_, ok := cache.Metrics().Files[fileNum(1)]
+	require.False(t, ok)
+}
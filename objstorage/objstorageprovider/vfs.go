@@ -13,17 +13,36 @@ import (
 	"github.com/cockroachdb/pebble/vfs"
 )
 
-func (p *provider) vfsPath(fileType base.FileType, fileNum base.DiskFileNum) string {
-	return base.MakeFilepath(p.st.FS, p.st.FSDirName, fileType, fileNum)
+// vfsDir returns the directory of a local object.
+func (p *provider) vfsDir(meta objstorage.ObjectMetadata) string {
+	if meta.LocalDir != "" {
+		return meta.LocalDir
+	}
+	return p.st.FSDirName
+}
+
+func (p *provider) vfsPath(meta objstorage.ObjectMetadata) string {
+	return base.MakeFilepath(p.st.FS, p.vfsDir(meta), meta.FileType, meta.DiskFileNum)
+}
+
+// checkLocalDir returns an error if dir is not a valid
+// objstorage.CreateOptions.LocalDir.
+func (p *provider) checkLocalDir(dir string) error {
+	if dir == "" {
+		return nil
+	}
+	for _, d := range p.st.FSExtraDirNames {
+		if d == dir {
+			return nil
+		}
+	}
+	return errors.AssertionFailedf("pebble: %q is not a local object directory", dir)
 }
 
 func (p *provider) vfsOpenForReading(
-	ctx context.Context,
-	fileType base.FileType,
-	fileNum base.DiskFileNum,
-	opts objstorage.OpenOptions,
+	ctx context.Context, meta objstorage.ObjectMetadata, opts objstorage.OpenOptions,
 ) (objstorage.Readable, error) {
-	filename := p.vfsPath(fileType, fileNum)
+	filename := p.vfsPath(meta)
 	file, err := p.st.FS.Open(filename, vfs.RandomReadsOption)
 	if err != nil {
 		if opts.MustExist {
@@ -31,33 +50,55 @@ func (p *provider) vfsOpenForReading(
 		}
 		return nil, err
 	}
-	return newFileReadable(file, p.st.FS, filename)
+	if p.st.DirectIO.Reads && vfs.SetDirectIO(file, true) == nil {
+		return newDirectIOReadable(file)
+	}
+	if p.st.MmapReads {
+		if r, err := newMmapReadable(file); err == nil {
+			return r, nil
+		}
+	}
+	r, err := newFileReadable(file, p.st.FS, filename)
+	if err != nil {
+		return nil, err
+	}
+	r.rings = p.rings
+	return r, nil
 }
 
 func (p *provider) vfsCreate(
-	_ context.Context, fileType base.FileType, fileNum base.DiskFileNum,
+	_ context.Context, fileType base.FileType, fileNum base.DiskFileNum, dir string,
 ) (objstorage.Writable, objstorage.ObjectMetadata, error) {
-	filename := p.vfsPath(fileType, fileNum)
+	if err := p.checkLocalDir(dir); err != nil {
+		return nil, objstorage.ObjectMetadata{}, err
+	}
+	meta := objstorage.ObjectMetadata{
+		DiskFileNum: fileNum,
+		FileType:    fileType,
+		LocalDir:    dir,
+	}
+	filename := p.vfsPath(meta)
 	file, err := p.st.FS.Create(filename)
 	if err != nil {
 		return nil, objstorage.ObjectMetadata{}, err
 	}
+	directIO := p.st.DirectIO.Writes && vfs.SetDirectIO(file, true) == nil
 	file = vfs.NewSyncingFile(file, vfs.SyncingFileOptions{
 		NoSyncOnClose: p.st.NoSyncOnClose,
 		BytesPerSync:  p.st.BytesPerSync,
 	})
-	meta := objstorage.ObjectMetadata{
-		DiskFileNum: fileNum,
-		FileType:    fileType,
+	if directIO {
+		return newDirectIOWritable(file), meta, nil
 	}
 	return newFileBufferedWritable(file), meta, nil
 }
 
-func (p *provider) vfsRemove(fileType base.FileType, fileNum base.DiskFileNum) error {
-	return p.st.FSCleaner.Clean(p.st.FS, fileType, p.vfsPath(fileType, fileNum))
+func (p *provider) vfsRemove(meta objstorage.ObjectMetadata) error {
+	return p.st.FSCleaner.Clean(p.st.FS, meta.FileType, p.vfsPath(meta))
 }
 
-// vfsInit finds any local FS objects.
+// vfsInit finds any local FS objects, in the main directory and in the extra
+// directories.
 func (p *provider) vfsInit() error {
 	listing := p.st.FSDirInitialListing
 	if listing == nil {
@@ -67,17 +108,54 @@ func (p *provider) vfsInit() error {
 			return errors.Wrapf(err, "pebble: could not list store directory")
 		}
 	}
+	p.vfsAddListing("", listing)
 
+	for _, dir := range p.st.FSExtraDirNames {
+		listing, err := p.st.FS.List(dir)
+		if err != nil {
+			return errors.Wrapf(err, "pebble: could not list directory %q", dir)
+		}
+		p.vfsAddListing(dir, listing)
+	}
+	return nil
+}
+
+// vfsAddListing adds the objects in the listing of a local directory (with dir
+// empty for the main directory).
+func (p *provider) vfsAddListing(dir string, listing []string) {
 	for _, filename := range listing {
 		fileType, fileNum, ok := base.ParseFilename(p.st.FS, filename)
-		if ok && fileType == base.FileTypeTable {
+		if ok && (fileType == base.FileTypeTable || fileType == base.FileTypeBlob) {
 			o := objstorage.ObjectMetadata{
 				FileType:    fileType,
 				DiskFileNum: fileNum,
+				LocalDir:    dir,
 			}
 			p.mu.knownObjects[o.DiskFileNum] = o
 		}
 	}
+}
+
+// vfsRescan adds the local FS objects that were created in the main directory
+// and in the extra directories since they were listed.
+func (p *provider) vfsRescan() error {
+	listing, err := p.st.FS.List(p.st.FSDirName)
+	if err != nil {
+		return errors.Wrapf(err, "pebble: could not list store directory")
+	}
+	p.mu.Lock()
+	p.vfsAddListing("", listing)
+	p.mu.Unlock()
+
+	for _, dir := range p.st.FSExtraDirNames {
+		listing, err := p.st.FS.List(dir)
+		if err != nil {
+			return errors.Wrapf(err, "pebble: could not list directory %q", dir)
+		}
+		p.mu.Lock()
+		p.vfsAddListing(dir, listing)
+		p.mu.Unlock()
+	}
 	return nil
 }
 
@@ -90,17 +168,19 @@ func (p *provider) vfsSync() error {
 	if !shouldSync {
 		return nil
 	}
-	if err := p.fsDir.Sync(); err != nil {
-		p.mu.Lock()
-		defer p.mu.Unlock()
-		p.mu.localObjectsChanged = true
-		return err
+	for _, dir := range p.fsDirs {
+		if err := dir.Sync(); err != nil {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			p.mu.localObjectsChanged = true
+			return err
+		}
 	}
 	return nil
 }
 
-func (p *provider) vfsSize(fileType base.FileType, fileNum base.DiskFileNum) (int64, error) {
-	filename := p.vfsPath(fileType, fileNum)
+func (p *provider) vfsSize(meta objstorage.ObjectMetadata) (int64, error) {
+	filename := p.vfsPath(meta)
 	stat, err := p.st.FS.Stat(filename)
 	if err != nil {
 		return 0, err
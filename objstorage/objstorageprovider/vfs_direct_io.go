@@ -0,0 +1,243 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package objstorageprovider
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+const (
+	// directIOAlign is the alignment of direct I/O reads and writes.
+	directIOAlign = vfs.DirectIOAlignment
+	// directIOReadBufSize is the size of the pooled buffers used for direct
+	// I/O reads. Larger reads allocate their buffer.
+	directIOReadBufSize = 64 << 10
+	// directIOReadaheadSize is the size of the reads of a ReadHandle set up
+	// for compaction, which don't benefit from OS readahead.
+	directIOReadaheadSize = fileMaxReadaheadSize
+	// directIOWriteBufSize is the size of the writes of a directIOWritable.
+	directIOWriteBufSize = 512 << 10
+)
+
+var directIOReadBufPool = sync.Pool{
+	New: func() interface{} {
+		buf := vfs.AlignedBuffer(directIOReadBufSize)
+		return &buf
+	},
+}
+
+func alignDown(n int64) int64 {
+	return n &^ (directIOAlign - 1)
+}
+
+func alignUp(n int64) int64 {
+	return alignDown(n + directIOAlign - 1)
+}
+
+// directIOReadable implements objstorage.Readable on top of a vfs.File with
+// direct I/O enabled. Reads are widened to aligned offsets and lengths, and
+// are read into aligned buffers before being copied out.
+type directIOReadable struct {
+	file vfs.File
+	size int64
+}
+
+var _ objstorage.Readable = (*directIOReadable)(nil)
+
+func newDirectIOReadable(file vfs.File) (*directIOReadable, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &directIOReadable{file: file, size: info.Size()}, nil
+}
+
+// readAligned reads the aligned range of the file that starts at the aligned
+// offset off into buf, whose length is a multiple of the alignment, returning
+// the number of bytes read (which is smaller than len(buf) at the end of the
+// file).
+func (r *directIOReadable) readAligned(buf []byte, off int64) (int, error) {
+	n, err := r.file.ReadAt(buf, off)
+	if err == io.EOF && off+int64(n) == r.size {
+		err = nil
+	}
+	return n, err
+}
+
+// ReadAt is part of the objstorage.Readable interface.
+func (r *directIOReadable) ReadAt(_ context.Context, p []byte, off int64) error {
+	if off+int64(len(p)) > r.size {
+		return io.EOF
+	}
+	start := alignDown(off)
+	end := alignUp(off + int64(len(p)))
+	var buf []byte
+	if end-start <= directIOReadBufSize {
+		bufp := directIOReadBufPool.Get().(*[]byte)
+		defer directIOReadBufPool.Put(bufp)
+		buf = (*bufp)[:end-start]
+	} else {
+		buf = vfs.AlignedBuffer(int(end - start))
+	}
+	n, err := r.readAligned(buf, start)
+	if err != nil {
+		return err
+	}
+	if int64(n) < off+int64(len(p))-start {
+		return io.ErrUnexpectedEOF
+	}
+	copy(p, buf[off-start:])
+	return nil
+}
+
+// Close is part of the objstorage.Readable interface.
+func (r *directIOReadable) Close() error {
+	defer func() { r.file = nil }()
+	return r.file.Close()
+}
+
+// Size is part of the objstorage.Readable interface.
+func (r *directIOReadable) Size() int64 {
+	return r.size
+}
+
+// NewReadHandle is part of the objstorage.Readable interface.
+func (r *directIOReadable) NewReadHandle(_ context.Context) objstorage.ReadHandle {
+	return &directIOReadHandle{r: r}
+}
+
+// directIOReadHandle is the ReadHandle of a directIOReadable. Reads bypass the
+// OS page cache and its readahead, so once set up for compaction the handle
+// does its own readahead: reads are served from a buffer holding the
+// directIOReadaheadSize bytes of the file following the first read that missed
+// it.
+type directIOReadHandle struct {
+	r *directIOReadable
+	// buf is allocated by SetupForCompaction, and holds bufLen bytes of the
+	// file at bufOffset.
+	buf       []byte
+	bufOffset int64
+	bufLen    int
+}
+
+var _ objstorage.ReadHandle = (*directIOReadHandle)(nil)
+
+// ReadAt is part of the objstorage.ReadHandle interface.
+func (rh *directIOReadHandle) ReadAt(ctx context.Context, p []byte, off int64) error {
+	if rh.buf == nil || len(p) > len(rh.buf)-directIOAlign {
+		return rh.r.ReadAt(ctx, p, off)
+	}
+	end := off + int64(len(p))
+	if off < rh.bufOffset || end > rh.bufOffset+int64(rh.bufLen) {
+		if end > rh.r.size {
+			return io.EOF
+		}
+		rh.bufOffset = alignDown(off)
+		n, err := rh.r.readAligned(rh.buf, rh.bufOffset)
+		rh.bufLen = n
+		if err != nil {
+			rh.bufLen = 0
+			return err
+		}
+		if end > rh.bufOffset+int64(n) {
+			return errors.WithStack(io.ErrUnexpectedEOF)
+		}
+	}
+	copy(p, rh.buf[off-rh.bufOffset:])
+	return nil
+}
+
+// SetupForCompaction is part of the objstorage.ReadHandle interface.
+func (rh *directIOReadHandle) SetupForCompaction() {
+	if rh.buf == nil {
+		rh.buf = vfs.AlignedBuffer(directIOReadaheadSize)
+	}
+}
+
+// RecordCacheHit is part of the objstorage.ReadHandle interface.
+func (rh *directIOReadHandle) RecordCacheHit(_ context.Context, offset, size int64) {}
+
+// Close is part of the objstorage.ReadHandle interface.
+func (rh *directIOReadHandle) Close() error {
+	*rh = directIOReadHandle{}
+	return nil
+}
+
+// directIOWritable implements objstorage.Writable on top of a vfs.File with
+// direct I/O enabled. Writes are buffered into an aligned buffer that is
+// written out whenever it fills up. Finish writes the aligned part of the
+// remaining data with direct I/O, and the unaligned tail of the file with
+// direct I/O disabled.
+type directIOWritable struct {
+	file vfs.File
+	buf  []byte
+}
+
+var _ objstorage.Writable = (*directIOWritable)(nil)
+
+func newDirectIOWritable(file vfs.File) *directIOWritable {
+	return &directIOWritable{
+		file: file,
+		buf:  vfs.AlignedBuffer(directIOWriteBufSize)[:0],
+	}
+}
+
+// Write is part of the objstorage.Writable interface.
+func (w *directIOWritable) Write(p []byte) error {
+	for len(p) > 0 {
+		n := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		if len(w.buf) == cap(w.buf) {
+			if _, err := w.file.Write(w.buf); err != nil {
+				return err
+			}
+			w.buf = w.buf[:0]
+		}
+	}
+	return nil
+}
+
+// Finish is part of the objstorage.Writable interface.
+func (w *directIOWritable) Finish() error {
+	err := w.flushTail()
+	if err == nil {
+		err = w.file.Sync()
+	}
+	err = firstError(err, w.file.Close())
+	w.buf = nil
+	w.file = nil
+	return err
+}
+
+func (w *directIOWritable) flushTail() error {
+	n := int(alignDown(int64(len(w.buf))))
+	if n > 0 {
+		if _, err := w.file.Write(w.buf[:n]); err != nil {
+			return err
+		}
+	}
+	if n == len(w.buf) {
+		return nil
+	}
+	if err := vfs.SetDirectIO(w.file, false); err != nil {
+		return err
+	}
+	_, err := w.file.Write(w.buf[n:])
+	return err
+}
+
+// Abort is part of the objstorage.Writable interface.
+func (w *directIOWritable) Abort() {
+	_ = w.file.Close()
+	w.buf = nil
+	w.file = nil
+}
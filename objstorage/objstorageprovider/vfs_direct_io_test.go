@@ -0,0 +1,96 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package objstorageprovider
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirectIO(t *testing.T) {
+	dir := t.TempDir()
+	f, err := vfs.Default.Create(filepath.Join(dir, "probe"))
+	require.NoError(t, err)
+	supported := vfs.SetDirectIO(f, true) == nil
+	require.NoError(t, f.Close())
+
+	for _, fs := range []vfs.FS{vfs.Default, vfs.NewMem()} {
+		fsDir := dir
+		if fs != vfs.Default {
+			fsDir = ""
+		}
+		settings := DefaultSettings(fs, fsDir)
+		settings.DirectIO.Reads = true
+		settings.DirectIO.Writes = true
+		provider, err := Open(settings)
+		require.NoError(t, err)
+		direct := supported && fs == vfs.Default
+
+		rng := rand.New(rand.NewSource(1))
+		ctx := context.Background()
+		for i, size := range []int{0, 100, directIOAlign, 3*directIOWriteBufSize + 12345} {
+			fileNum := base.FileNum(i + 1).DiskFileNum()
+			w, _, err := provider.Create(ctx, base.FileTypeTable, fileNum, objstorage.CreateOptions{})
+			require.NoError(t, err)
+			_, ok := w.(*directIOWritable)
+			require.Equal(t, direct, ok)
+			data := make([]byte, size)
+			rng.Read(data)
+			for p := data; len(p) > 0; {
+				n := 1 + rng.Intn(10000)
+				if n > len(p) {
+					n = len(p)
+				}
+				// Write is allowed to modify the slice passed in.
+				require.NoError(t, w.Write(append([]byte(nil), p[:n]...)))
+				p = p[n:]
+			}
+			require.NoError(t, w.Finish())
+
+			r, err := provider.OpenForReading(ctx, base.FileTypeTable, fileNum, objstorage.OpenOptions{})
+			require.NoError(t, err)
+			_, ok = r.(*directIOReadable)
+			require.Equal(t, direct, ok)
+			require.Equal(t, int64(size), r.Size())
+			if size == 0 {
+				require.NoError(t, r.Close())
+				continue
+			}
+			// Random reads.
+			for j := 0; j < 100; j++ {
+				off := rng.Intn(size)
+				p := make([]byte, 1+rng.Intn(size-off))
+				require.NoError(t, r.ReadAt(ctx, p, int64(off)))
+				require.True(t, bytes.Equal(data[off:off+len(p)], p))
+			}
+			require.Equal(t, io.EOF, r.ReadAt(ctx, make([]byte, 2), int64(size-1)))
+
+			// Sequential reads of a handle set up for compaction.
+			rh := r.NewReadHandle(ctx)
+			rh.SetupForCompaction()
+			for off := 0; off < size; {
+				p := make([]byte, 1+rng.Intn(8000))
+				if off+len(p) > size {
+					p = p[:size-off]
+				}
+				require.NoError(t, rh.ReadAt(ctx, p, int64(off)))
+				require.True(t, bytes.Equal(data[off:off+len(p)], p))
+				off += len(p)
+			}
+			require.NoError(t, rh.Close())
+			require.NoError(t, r.Close())
+		}
+		require.NoError(t, provider.Close())
+	}
+}
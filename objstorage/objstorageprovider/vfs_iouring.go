@@ -0,0 +1,235 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package objstorageprovider
+
+import (
+	"context"
+	"io"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/iouring"
+	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// ringPool is a pool of io_uring rings used to read local objects. Rings are
+// not safe for concurrent use, so each batch of reads (and each ReadHandle
+// doing io_uring readahead) uses a ring of its own, taken from the pool.
+type ringPool struct {
+	rings chan *iouring.Ring
+}
+
+// newRingPool returns a pool of rings, or nil if io_uring isn't supported.
+func newRingPool(size int) *ringPool {
+	r, err := iouring.NewRing(0)
+	if err != nil {
+		return nil
+	}
+	p := &ringPool{rings: make(chan *iouring.Ring, size)}
+	p.rings <- r
+	return p
+}
+
+// get returns a ring, or nil if a new ring couldn't be created.
+func (p *ringPool) get() *iouring.Ring {
+	select {
+	case r := <-p.rings:
+		return r
+	default:
+	}
+	r, err := iouring.NewRing(0)
+	if err != nil {
+		return nil
+	}
+	return r
+}
+
+func (p *ringPool) put(r *iouring.Ring) {
+	select {
+	case p.rings <- r:
+	default:
+		_ = r.Close()
+	}
+}
+
+func (p *ringPool) close() {
+	for {
+		select {
+		case r := <-p.rings:
+			_ = r.Close()
+		default:
+			return
+		}
+	}
+}
+
+// readAt reads into p from the file at off, with io_uring if reads use
+// io_uring and with pread otherwise.
+func (r *fileReadable) readAt(p []byte, off int64) (int, error) {
+	var ring *iouring.Ring
+	fd := r.file.Fd()
+	if r.rings != nil && fd != vfs.InvalidFd && len(p) > 0 {
+		ring = r.rings.get()
+	}
+	if ring == nil {
+		return r.file.ReadAt(p, off)
+	}
+	defer r.rings.put(ring)
+	rd := iouring.Read{Fd: fd, Buf: p, Offset: off}
+	if err := ring.ReadBatch([]*iouring.Read{&rd}); err != nil {
+		return 0, err
+	}
+	if rd.Err != nil {
+		return rd.N, errors.WithStack(rd.Err)
+	}
+	return rd.N, nil
+}
+
+// BatchesReads is part of the objstorage.BatchReader interface.
+func (r *fileReadable) BatchesReads() bool {
+	return r.rings != nil && r.file.Fd() != vfs.InvalidFd
+}
+
+// ReadAtBatch is part of the objstorage.BatchReader interface. With io_uring,
+// the reads are submitted together, and are performed concurrently by the OS.
+func (r *fileReadable) ReadAtBatch(ctx context.Context, reqs []objstorage.ReadRequest) error {
+	var ring *iouring.Ring
+	fd := r.file.Fd()
+	if r.BatchesReads() && len(reqs) > 1 {
+		ring = r.rings.get()
+	}
+	if ring == nil {
+		for _, req := range reqs {
+			if err := r.ReadAt(ctx, req.P, req.Offset); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	defer r.rings.put(ring)
+
+	reads := make([]iouring.Read, len(reqs))
+	readPtrs := make([]*iouring.Read, len(reqs))
+	for i, req := range reqs {
+		reads[i] = iouring.Read{Fd: fd, Buf: req.P, Offset: req.Offset}
+		readPtrs[i] = &reads[i]
+	}
+	if err := ring.ReadBatch(readPtrs); err != nil {
+		return err
+	}
+	for i := range reads {
+		if reads[i].Err != nil {
+			return errors.WithStack(reads[i].Err)
+		}
+	}
+	return nil
+}
+
+// BatchesReads is part of the objstorage.BatchReader interface.
+func (rh *vfsReadHandle) BatchesReads() bool {
+	return rh.r.BatchesReads()
+}
+
+// ReadAtBatch is part of the objstorage.BatchReader interface.
+func (rh *vfsReadHandle) ReadAtBatch(ctx context.Context, reqs []objstorage.ReadRequest) error {
+	return rh.r.ReadAtBatch(ctx, reqs)
+}
+
+// ioUringReadahead performs the readahead of a vfsReadHandle with io_uring,
+// instead of relying on OS readahead. It reads the file in windows of
+// fileMaxReadaheadSize bytes: the reads of the handle are served from the
+// current window while the next window is read asynchronously.
+type ioUringReadahead struct {
+	rings *ringPool
+	ring  *iouring.Ring
+	file  vfs.File
+	size  int64
+	// cur holds the window the last read was served from, and next the
+	// window being read if started is set. Their buffers have a capacity of
+	// fileMaxReadaheadSize bytes, and are shorter at the end of the file.
+	cur, next iouring.Read
+	started   bool
+}
+
+func newIOUringReadahead(r *fileReadable) *ioUringReadahead {
+	if r.rings == nil || r.file.Fd() == vfs.InvalidFd {
+		return nil
+	}
+	ring := r.rings.get()
+	if ring == nil {
+		return nil
+	}
+	return &ioUringReadahead{
+		rings: r.rings,
+		ring:  ring,
+		file:  r.file,
+		size:  r.size,
+		cur:   iouring.Read{Fd: r.file.Fd(), Buf: make([]byte, fileMaxReadaheadSize)},
+		next:  iouring.Read{Fd: r.file.Fd(), Buf: make([]byte, fileMaxReadaheadSize)},
+	}
+}
+
+// covers returns true if the window holds [off, off+n).
+func covers(w *iouring.Read, off int64, n int) bool {
+	return off >= w.Offset && off+int64(n) <= w.Offset+int64(w.N)
+}
+
+func (ra *ioUringReadahead) readAt(p []byte, off int64) error {
+	if !covers(&ra.cur, off, len(p)) && ra.started {
+		if err := ra.ring.Wait(&ra.next); err != nil {
+			return err
+		}
+		ra.started = false
+		if ra.next.Err == nil || ra.next.Err == io.EOF {
+			ra.cur, ra.next = ra.next, ra.cur
+		}
+	}
+	if !covers(&ra.cur, off, len(p)) {
+		buf := ra.cur.Buf[:cap(ra.cur.Buf)]
+		if len(p) > len(buf) || off+int64(len(p)) > ra.size {
+			// The read doesn't fit in a window (or is past the end of the file).
+			return ra.pread(p, off)
+		}
+		// The reads aren't sequential: restart the readahead at off.
+		if n := ra.size - off; n < int64(len(buf)) {
+			buf = buf[:n]
+		}
+		ra.cur = iouring.Read{Fd: ra.cur.Fd, Buf: buf, Offset: off}
+		if err := ra.pread(buf, off); err != nil {
+			return err
+		}
+		ra.cur.N = len(buf)
+	}
+	copy(p, ra.cur.Buf[off-ra.cur.Offset:])
+
+	// Start reading the next window.
+	if end := ra.cur.Offset + int64(ra.cur.N); !ra.started && end < ra.size {
+		buf := ra.next.Buf[:cap(ra.next.Buf)]
+		if n := ra.size - end; n < int64(len(buf)) {
+			buf = buf[:n]
+		}
+		ra.next.Buf = buf
+		ra.next.Offset = end
+		ra.started = ra.ring.Start(&ra.next) == nil
+	}
+	return nil
+}
+
+func (ra *ioUringReadahead) pread(p []byte, off int64) error {
+	n, err := ra.file.ReadAt(p, off)
+	if err == io.EOF && n == len(p) {
+		err = nil
+	}
+	return err
+}
+
+func (ra *ioUringReadahead) close() {
+	if ra.started {
+		_ = ra.ring.Wait(&ra.next)
+		ra.started = false
+	}
+	ra.rings.put(ra.ring)
+	*ra = ioUringReadahead{}
+}
@@ -0,0 +1,85 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package objstorageprovider
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIOUringReads(t *testing.T) {
+	for _, fs := range []vfs.FS{vfs.Default, vfs.NewMem()} {
+		dir := ""
+		if fs == vfs.Default {
+			dir = t.TempDir()
+		}
+		settings := DefaultSettings(fs, dir)
+		settings.IOUringReads = true
+		p, err := open(settings)
+		require.NoError(t, err)
+		supported := p.rings != nil
+
+		ctx := context.Background()
+		rng := rand.New(rand.NewSource(1))
+		data := make([]byte, 5*fileMaxReadaheadSize+1234)
+		rng.Read(data)
+		w, _, err := p.Create(ctx, base.FileTypeTable, base.FileNum(1).DiskFileNum(), objstorage.CreateOptions{})
+		require.NoError(t, err)
+		require.NoError(t, w.Write(append([]byte(nil), data...)))
+		require.NoError(t, w.Finish())
+
+		r, err := p.OpenForReading(ctx, base.FileTypeTable, base.FileNum(1).DiskFileNum(), objstorage.OpenOptions{})
+		require.NoError(t, err)
+		if fs == vfs.Default && !supported {
+			t.Log("io_uring not supported")
+		}
+
+		// Batches of reads.
+		reqs := make([]objstorage.ReadRequest, 100)
+		for i := range reqs {
+			off := rng.Intn(len(data))
+			reqs[i] = objstorage.ReadRequest{P: make([]byte, 1+rng.Intn(len(data)-off)), Offset: int64(off)}
+		}
+		require.NoError(t, objstorage.ReadBatch(ctx, r, reqs))
+		for _, req := range reqs {
+			require.True(t, bytes.Equal(data[req.Offset:req.Offset+int64(len(req.P))], req.P))
+		}
+		reqs[50].Offset = int64(len(data))
+		require.Error(t, objstorage.ReadBatch(ctx, r, reqs))
+
+		// Sequential reads of a handle set up for compaction, with occasional
+		// jumps.
+		rh := r.NewReadHandle(ctx)
+		rh.SetupForCompaction()
+		require.True(t, TestingCheckMaxReadahead(rh))
+		require.Equal(t, fs == vfs.Default && supported, rh.(*vfsReadHandle).ioUring != nil)
+		for off := 0; off < len(data); {
+			if rng.Intn(50) == 0 {
+				off = rng.Intn(len(data))
+			}
+			p := make([]byte, 1+rng.Intn(20000))
+			if rng.Intn(100) == 0 {
+				p = make([]byte, 2*fileMaxReadaheadSize)
+			}
+			if off+len(p) > len(data) {
+				p = p[:len(data)-off]
+			}
+			require.NoError(t, rh.ReadAt(ctx, p, int64(off)))
+			require.True(t, bytes.Equal(data[off:off+len(p)], p))
+			off += len(p)
+		}
+		require.Error(t, rh.ReadAt(ctx, make([]byte, 10), int64(len(data)-5)))
+		require.NoError(t, rh.Close())
+		require.NoError(t, r.Close())
+		require.NoError(t, p.Close())
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package objstorageprovider
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+var pageSize = int64(os.Getpagesize())
+
+// mmapReadable implements objstorage.Readable on top of a read-only memory
+// mapping of a file. Reads copy from the mapping, without a syscall unless they
+// fault in pages that aren't resident.
+//
+// The OS is advised that the mapping is accessed randomly, so that point reads
+// don't trigger OS readahead; the ReadHandles instead advise the OS to read
+// ahead the ranges their readahead state (or compaction) calls for.
+type mmapReadable struct {
+	data []byte
+}
+
+var _ objstorage.Readable = (*mmapReadable)(nil)
+
+// newMmapReadable maps the file, which it closes on success.
+func newMmapReadable(file vfs.File) (*mmapReadable, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	data, err := vfs.Mmap(file, int(info.Size()))
+	if err != nil {
+		return nil, err
+	}
+	_ = vfs.Madvise(data, vfs.MmapAdviceRandom)
+	if err := file.Close(); err != nil {
+		_ = vfs.Munmap(data)
+		return nil, err
+	}
+	return &mmapReadable{data: data}, nil
+}
+
+// ReadAt is part of the objstorage.Readable interface.
+func (r *mmapReadable) ReadAt(_ context.Context, p []byte, off int64) error {
+	if off < 0 || off+int64(len(p)) > int64(len(r.data)) {
+		return io.EOF
+	}
+	copy(p, r.data[off:])
+	return nil
+}
+
+// Close is part of the objstorage.Readable interface.
+func (r *mmapReadable) Close() error {
+	defer func() { r.data = nil }()
+	return vfs.Munmap(r.data)
+}
+
+// Size is part of the objstorage.Readable interface.
+func (r *mmapReadable) Size() int64 {
+	return int64(len(r.data))
+}
+
+// NewReadHandle is part of the objstorage.Readable interface.
+func (r *mmapReadable) NewReadHandle(_ context.Context) objstorage.ReadHandle {
+	return &mmapReadHandle{
+		r:  r,
+		rs: makeReadaheadState(fileMaxReadaheadSize),
+	}
+}
+
+// willNeed advises the OS to read ahead n bytes of the file at offset off.
+func (r *mmapReadable) willNeed(off, n int64) {
+	start := off &^ (pageSize - 1)
+	end := off + n
+	if end > int64(len(r.data)) {
+		end = int64(len(r.data))
+	}
+	if start < end {
+		_ = vfs.Madvise(r.data[start:end], vfs.MmapAdviceWillNeed)
+	}
+}
+
+// mmapReadHandle is the ReadHandle of a mmapReadable. It advises the OS to
+// read ahead the ranges that the readahead state calls for, like
+// vfsReadHandle prefetches them. Once set up for compaction, it keeps
+// fileMaxReadaheadSize bytes ahead of its reads advised.
+type mmapReadHandle struct {
+	r  *mmapReadable
+	rs readaheadState
+
+	// sequential is set by SetupForCompaction. adviseEnd is then the offset up
+	// to which the OS has been advised to read ahead.
+	sequential bool
+	adviseEnd  int64
+}
+
+var _ objstorage.ReadHandle = (*mmapReadHandle)(nil)
+
+// ReadAt is part of the objstorage.ReadHandle interface.
+func (rh *mmapReadHandle) ReadAt(ctx context.Context, p []byte, off int64) error {
+	end := off + int64(len(p))
+	if rh.sequential {
+		// Advise the next window once half of the current one has been read.
+		if end > rh.adviseEnd-fileMaxReadaheadSize/2 {
+			start := rh.adviseEnd
+			if start < off || start > end+fileMaxReadaheadSize {
+				start = off
+			}
+			rh.adviseEnd = end + fileMaxReadaheadSize
+			rh.r.willNeed(start, rh.adviseEnd-start)
+		}
+	} else if readaheadSize := rh.rs.maybeReadahead(off, int64(len(p))); readaheadSize > 0 {
+		rh.r.willNeed(off, readaheadSize)
+	}
+	return rh.r.ReadAt(ctx, p, off)
+}
+
+// SetupForCompaction is part of the objstorage.ReadHandle interface.
+func (rh *mmapReadHandle) SetupForCompaction() {
+	rh.sequential = true
+}
+
+// RecordCacheHit is part of the objstorage.ReadHandle interface.
+func (rh *mmapReadHandle) RecordCacheHit(_ context.Context, offset, size int64) {
+	if !rh.sequential {
+		rh.rs.recordCacheHit(offset, size)
+	}
+}
+
+// Close is part of the objstorage.ReadHandle interface.
+func (rh *mmapReadHandle) Close() error {
+	*rh = mmapReadHandle{}
+	return nil
+}
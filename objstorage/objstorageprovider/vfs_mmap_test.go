@@ -0,0 +1,99 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package objstorageprovider
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMmapReads(t *testing.T) {
+	dir := t.TempDir()
+	f, err := vfs.Default.Create(filepath.Join(dir, "probe"))
+	require.NoError(t, err)
+	_, err = f.Write([]byte("x"))
+	require.NoError(t, err)
+	b, err := vfs.Mmap(f, 1)
+	supported := err == nil
+	if supported {
+		require.NoError(t, vfs.Munmap(b))
+	}
+	require.NoError(t, f.Close())
+
+	for _, fs := range []vfs.FS{vfs.Default, vfs.NewMem()} {
+		fsDir := dir
+		if fs != vfs.Default {
+			fsDir = ""
+		}
+		settings := DefaultSettings(fs, fsDir)
+		settings.MmapReads = true
+		provider, err := Open(settings)
+		require.NoError(t, err)
+
+		rng := rand.New(rand.NewSource(1))
+		ctx := context.Background()
+		for i, size := range []int{0, 100, 4096, 3*fileMaxReadaheadSize + 12345} {
+			fileNum := base.FileNum(i + 1).DiskFileNum()
+			w, _, err := provider.Create(ctx, base.FileTypeTable, fileNum, objstorage.CreateOptions{})
+			require.NoError(t, err)
+			data := make([]byte, size)
+			rng.Read(data)
+			require.NoError(t, w.Write(append([]byte(nil), data...)))
+			require.NoError(t, w.Finish())
+
+			r, err := provider.OpenForReading(ctx, base.FileTypeTable, fileNum, objstorage.OpenOptions{})
+			require.NoError(t, err)
+			// Empty files can't be mapped, and are read with pread.
+			_, ok := r.(*mmapReadable)
+			require.Equal(t, supported && fs == vfs.Default && size > 0, ok)
+			require.Equal(t, int64(size), r.Size())
+			if size == 0 {
+				require.NoError(t, r.Close())
+				continue
+			}
+			// Random reads.
+			for j := 0; j < 100; j++ {
+				off := rng.Intn(size)
+				p := make([]byte, 1+rng.Intn(size-off))
+				require.NoError(t, r.ReadAt(ctx, p, int64(off)))
+				require.True(t, bytes.Equal(data[off:off+len(p)], p))
+			}
+			require.Equal(t, io.EOF, r.ReadAt(ctx, make([]byte, 2), int64(size-1)))
+
+			// Sequential reads of a handle, before and after it's set up for
+			// compaction.
+			for _, compaction := range []bool{false, true} {
+				rh := r.NewReadHandle(ctx)
+				if compaction {
+					rh.SetupForCompaction()
+				}
+				for off := 0; off < size; {
+					p := make([]byte, 1+rng.Intn(8000))
+					if off+len(p) > size {
+						p = p[:size-off]
+					}
+					require.NoError(t, rh.ReadAt(ctx, p, int64(off)))
+					require.True(t, bytes.Equal(data[off:off+len(p)], p))
+					off += len(p)
+				}
+				if ok {
+					require.Equal(t, compaction, TestingCheckMaxReadahead(rh))
+				}
+				require.NoError(t, rh.Close())
+			}
+			require.NoError(t, r.Close())
+		}
+		require.NoError(t, provider.Close())
+	}
+}
@@ -29,6 +29,9 @@ type fileReadable struct {
 	// sequential reads option (see vfsReadHandle).
 	filename string
 	fs       vfs.FS
+
+	// rings is set if reads use io_uring (see Settings.IOUringReads).
+	rings *ringPool
 }
 
 var _ objstorage.Readable = (*fileReadable)(nil)
@@ -55,7 +58,7 @@ func newFileReadable(file vfs.File, fs vfs.FS, filename string) (*fileReadable,
 
 // ReadAt is part of the objstorage.Readable interface.
 func (r *fileReadable) ReadAt(_ context.Context, p []byte, off int64) error {
-	n, err := r.file.ReadAt(p, off)
+	n, err := r.readAt(p, off)
 	if invariants.Enabled && err == nil && n != len(p) {
 		panic("short read")
 	}
@@ -90,6 +93,9 @@ type vfsReadHandle struct {
 	// OS-level readahead. Once this is non-nil, the other variables in
 	// readaheadState don't matter much as we defer to OS-level readahead.
 	sequentialFile vfs.File
+
+	// ioUring is used instead of sequentialFile when reads use io_uring.
+	ioUring *ioUringReadahead
 }
 
 var _ objstorage.ReadHandle = (*vfsReadHandle)(nil)
@@ -114,6 +120,9 @@ func (rh *vfsReadHandle) Close() error {
 	if rh.sequentialFile != nil {
 		err = rh.sequentialFile.Close()
 	}
+	if rh.ioUring != nil {
+		rh.ioUring.close()
+	}
 	*rh = vfsReadHandle{}
 	readHandlePool.Put(rh)
 	return err
@@ -121,6 +130,10 @@ func (rh *vfsReadHandle) Close() error {
 
 // ReadAt is part of the objstorage.ReadHandle interface.
 func (rh *vfsReadHandle) ReadAt(_ context.Context, p []byte, offset int64) error {
+	if rh.ioUring != nil {
+		// Use io_uring read-ahead.
+		return rh.ioUring.readAt(p, offset)
+	}
 	var n int
 	var err error
 	if rh.sequentialFile != nil {
@@ -136,7 +149,7 @@ func (rh *vfsReadHandle) ReadAt(_ context.Context, p []byte, offset int64) error
 				_ = rh.r.file.Prefetch(offset, readaheadSize)
 			}
 		}
-		n, err = rh.r.file.ReadAt(p, offset)
+		n, err = rh.r.readAt(p, offset)
 	}
 	if invariants.Enabled && err == nil && n != len(p) {
 		panic("short read")
@@ -149,8 +162,13 @@ func (rh *vfsReadHandle) SetupForCompaction() {
 	rh.switchToOSReadahead()
 }
 
+// switchToOSReadahead switches to OS-level read-ahead, or to io_uring
+// read-ahead if reads use io_uring.
 func (rh *vfsReadHandle) switchToOSReadahead() {
-	if rh.sequentialFile != nil {
+	if rh.sequentialFile != nil || rh.ioUring != nil {
+		return
+	}
+	if rh.ioUring = newIOUringReadahead(rh.r); rh.ioUring != nil {
 		return
 	}
 
@@ -164,8 +182,8 @@ func (rh *vfsReadHandle) switchToOSReadahead() {
 
 // RecordCacheHit is part of the objstorage.ReadHandle interface.
 func (rh *vfsReadHandle) RecordCacheHit(_ context.Context, offset, size int64) {
-	if rh.sequentialFile != nil {
-		// Using OS-level readahead, so do nothing.
+	if rh.sequentialFile != nil || rh.ioUring != nil {
+		// Using OS-level or io_uring readahead, so do nothing.
 		return
 	}
 	rh.rs.recordCacheHit(offset, size)
@@ -176,9 +194,11 @@ func (rh *vfsReadHandle) RecordCacheHit(_ context.Context, offset, size int64) {
 func TestingCheckMaxReadahead(rh objstorage.ReadHandle) bool {
 	switch rh := rh.(type) {
 	case *vfsReadHandle:
-		return rh.sequentialFile != nil
+		return rh.sequentialFile != nil || rh.ioUring != nil
 	case *PreallocatedReadHandle:
-		return rh.sequentialFile != nil
+		return rh.sequentialFile != nil || rh.ioUring != nil
+	case *mmapReadHandle:
+		return rh.sequential
 	default:
 		panic("unknown ReadHandle type")
 	}
@@ -196,6 +216,9 @@ func (rh *PreallocatedReadHandle) Close() error {
 	if rh.sequentialFile != nil {
 		err = rh.sequentialFile.Close()
 	}
+	if rh.ioUring != nil {
+		rh.ioUring.close()
+	}
 	rh.vfsReadHandle = vfsReadHandle{}
 	return err
 }
@@ -0,0 +1,61 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package s3
+
+import (
+	"context"
+	"os"
+
+	"github.com/cockroachdb/errors"
+)
+
+// Credentials are the keys with which requests are signed. For GCS, these are
+// the access ID and secret of an HMAC key.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is set for temporary credentials.
+	SessionToken string
+}
+
+// CredentialsProvider returns the credentials with which to sign a request.
+// It's called for every request (including retries), so that implementations
+// can rotate temporary credentials; implementations should cache credentials
+// as appropriate and must be safe for concurrent use.
+type CredentialsProvider interface {
+	Credentials(ctx context.Context) (Credentials, error)
+}
+
+// StaticCredentials is a CredentialsProvider that always returns the same
+// credentials.
+type StaticCredentials Credentials
+
+var _ CredentialsProvider = StaticCredentials{}
+
+// Credentials is part of the CredentialsProvider interface.
+func (c StaticCredentials) Credentials(context.Context) (Credentials, error) {
+	return Credentials(c), nil
+}
+
+// EnvCredentials returns a CredentialsProvider that reads the credentials from
+// the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+// environment variables.
+func EnvCredentials() CredentialsProvider {
+	return envCredentials{}
+}
+
+type envCredentials struct{}
+
+func (envCredentials) Credentials(context.Context) (Credentials, error) {
+	c := Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return Credentials{}, errors.New("s3: AWS_ACCESS_KEY_ID or AWS_SECRET_ACCESS_KEY is not set")
+	}
+	return c, nil
+}
@@ -0,0 +1,472 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+// Package s3 implements remote.Storage on top of the S3 API, which is also
+// served by GCS (through its XML API, with HMAC keys) and by most
+// S3-compatible object stores. It only depends on the standard library: requests
+// are signed with AWS Signature Version 4 and sent with an http.Client.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/objstorage/remote"
+)
+
+// GCSEndpoint is the endpoint of the GCS XML API. GCS is used by setting
+// Config.Endpoint to GCSEndpoint, Config.Region to "auto" and
+// Config.Credentials to the access ID and secret of an HMAC key.
+const GCSEndpoint = "https://storage.googleapis.com"
+
+// Config configures a Storage.
+type Config struct {
+	// Bucket is the name of the bucket.
+	Bucket string
+	// Prefix is prepended to the names of all objects.
+	Prefix string
+	// Region is the region of the bucket, used to sign requests.
+	Region string
+	// Endpoint is the URL of the service, e.g. "https://s3.us-east-1.amazonaws.com".
+	// If empty, the AWS endpoint of Region is used.
+	Endpoint string
+	// PathStyle addresses the bucket in the path of the URL
+	// (https://endpoint/bucket/object) instead of in the host name
+	// (https://bucket.endpoint/object). Some S3-compatible stores require it.
+	PathStyle bool
+	// Credentials provides the credentials requests are signed with. If nil,
+	// requests are not signed, which only allows access to public buckets.
+	Credentials CredentialsProvider
+	// HTTPClient is the client requests are sent with; http.DefaultClient if
+	// nil.
+	HTTPClient *http.Client
+
+	// PartSize is the size of the parts of multipart uploads. Objects smaller
+	// than PartSize are uploaded with a single request. S3 requires parts other
+	// than the last to be at least 5MB.
+	//
+	// The default value is 8MB.
+	PartSize int
+	// UploadConcurrency is the maximum number of parts of an object uploaded in
+	// parallel. Each part in flight holds a buffer of PartSize bytes.
+	//
+	// The default value is 4.
+	UploadConcurrency int
+
+	// MaxRetries is the number of times a request that failed with a transient
+	// error (a network error, a throttling response or a server error) is
+	// retried. Retries are delayed with exponential backoff, with jitter,
+	// starting at RetryBaseDelay and capped at RetryMaxDelay.
+	//
+	// The defaults are 8 retries, a base delay of 50ms and a max delay of 5s.
+	MaxRetries     int
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+
+	// now is used to sign requests (for testing).
+	now func() time.Time
+}
+
+// EnsureDefaults ensures that the default values for all of the options have
+// been initialized. It is valid to call EnsureDefaults on a nil receiver. A
+// non-nil result will always be returned.
+func (c *Config) EnsureDefaults() *Config {
+	if c == nil {
+		c = &Config{}
+	}
+	if c.Endpoint == "" {
+		c.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", c.Region)
+	}
+	if c.HTTPClient == nil {
+		c.HTTPClient = http.DefaultClient
+	}
+	if c.PartSize <= 0 {
+		c.PartSize = 8 << 20
+	}
+	if c.UploadConcurrency <= 0 {
+		c.UploadConcurrency = 4
+	}
+	if c.MaxRetries == 0 {
+		c.MaxRetries = 8
+	}
+	if c.RetryBaseDelay <= 0 {
+		c.RetryBaseDelay = 50 * time.Millisecond
+	}
+	if c.RetryMaxDelay <= 0 {
+		c.RetryMaxDelay = 5 * time.Second
+	}
+	if c.now == nil {
+		c.now = time.Now
+	}
+	return c
+}
+
+// Storage is a remote.Storage implementation backed by a bucket of an
+// S3-compatible object store.
+type Storage struct {
+	cfg      Config
+	endpoint *url.URL
+}
+
+var _ remote.Storage = (*Storage)(nil)
+
+// New returns a Storage for the bucket configured in cfg.
+func New(cfg Config) (*Storage, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("s3: bucket is not set")
+	}
+	if cfg.Region == "" {
+		return nil, errors.New("s3: region is not set")
+	}
+	cfg.EnsureDefaults()
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, errors.Wrapf(err, "s3: invalid endpoint %q", cfg.Endpoint)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, errors.Errorf("s3: invalid endpoint %q", cfg.Endpoint)
+	}
+	return &Storage{cfg: cfg, endpoint: u}, nil
+}
+
+// MakeFactory returns a remote.StorageFactory that creates a Storage for each
+// of the given locators. The locators (which must not contain secrets) are
+// typically the names of the buckets, while the configs hold the credentials.
+func MakeFactory(configs map[remote.Locator]Config) remote.StorageFactory {
+	return factory(configs)
+}
+
+type factory map[remote.Locator]Config
+
+// CreateStorage is part of the remote.StorageFactory interface.
+func (f factory) CreateStorage(locator remote.Locator) (remote.Storage, error) {
+	cfg, ok := f[locator]
+	if !ok {
+		return nil, errors.Errorf("unknown locator '%s'", locator)
+	}
+	return New(cfg)
+}
+
+// Close is part of the remote.Storage interface.
+func (s *Storage) Close() error {
+	return nil
+}
+
+// Error is the error returned for a request the service responded to with an
+// error status.
+type Error struct {
+	Op         string
+	Key        string
+	StatusCode int
+	// Code and Message are from the XML body of the response, if any.
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("s3: %s %q: status %d", e.Op, e.Key, e.StatusCode)
+	if e.Code != "" {
+		msg += ": " + e.Code
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// IsNotExistError is part of the remote.Storage interface.
+func (s *Storage) IsNotExistError(err error) bool {
+	var e *Error
+	if !errors.As(err, &e) {
+		return false
+	}
+	return e.StatusCode == http.StatusNotFound || e.Code == "NoSuchKey"
+}
+
+// ReadObject is part of the remote.Storage interface.
+func (s *Storage) ReadObject(
+	ctx context.Context, objName string,
+) (_ remote.ObjectReader, objSize int64, _ error) {
+	size, err := s.head(ctx, objName)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &objectReader{s: s, objName: objName, size: size}, size, nil
+}
+
+// Size is part of the remote.Storage interface.
+func (s *Storage) Size(objName string) (int64, error) {
+	return s.head(context.Background(), objName)
+}
+
+func (s *Storage) head(ctx context.Context, objName string) (int64, error) {
+	var size int64
+	err := s.do(ctx, request{op: "head", method: http.MethodHead, key: objName},
+		func(resp *http.Response) error {
+			size = resp.ContentLength
+			if size < 0 {
+				return errors.Errorf("s3: head %q: missing content length", objName)
+			}
+			return nil
+		})
+	return size, err
+}
+
+// objectReader performs ranged reads of an object.
+type objectReader struct {
+	s       *Storage
+	objName string
+	size    int64
+}
+
+var _ remote.ObjectReader = (*objectReader)(nil)
+
+// ReadAt is part of the remote.ObjectReader interface.
+func (r *objectReader) ReadAt(ctx context.Context, p []byte, offset int64) error {
+	if len(p) == 0 {
+		return nil
+	}
+	if offset < 0 || offset+int64(len(p)) > r.size {
+		return io.EOF
+	}
+	h := http.Header{}
+	h.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+int64(len(p))-1))
+	return r.s.do(ctx, request{op: "read", method: http.MethodGet, key: r.objName, header: h},
+		func(resp *http.Response) error {
+			if resp.StatusCode != http.StatusPartialContent && !(offset == 0 && int64(len(p)) == r.size) {
+				return errors.Errorf("s3: read %q: range not satisfied (status %d)", r.objName, resp.StatusCode)
+			}
+			// A body cut short is retried (see isRetryable).
+			_, err := io.ReadFull(resp.Body, p)
+			return err
+		})
+}
+
+// Close is part of the remote.ObjectReader interface.
+func (r *objectReader) Close() error {
+	r.s = nil
+	return nil
+}
+
+// CreateObject is part of the remote.Storage interface. The object is
+// uploaded with a single request when it's smaller than Config.PartSize, and
+// with a multipart upload of parts uploaded in parallel otherwise. The object
+// only becomes visible once Close returns successfully.
+func (s *Storage) CreateObject(objName string) (io.WriteCloser, error) {
+	return newObjectWriter(s, objName), nil
+}
+
+// Delete is part of the remote.Storage interface.
+func (s *Storage) Delete(objName string) error {
+	return s.do(context.Background(), request{op: "delete", method: http.MethodDelete, key: objName}, nil)
+}
+
+type listBucketResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []struct {
+		Key string
+	}
+	CommonPrefixes []struct {
+		Prefix string
+	}
+}
+
+// List is part of the remote.Storage interface. As with the S3 API, a group
+// of names sharing a prefix up to the delimiter is listed as that prefix,
+// without the delimiter.
+func (s *Storage) List(prefix, delimiter string) ([]string, error) {
+	fullPrefix := s.cfg.Prefix + prefix
+	var res []string
+	var token string
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {fullPrefix}}
+		if delimiter != "" {
+			q.Set("delimiter", delimiter)
+		}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		var result listBucketResult
+		err := s.do(context.Background(), request{op: "list", method: http.MethodGet, query: q, bucketOnly: true},
+			func(resp *http.Response) error {
+				result = listBucketResult{}
+				return xml.NewDecoder(resp.Body).Decode(&result)
+			})
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range result.Contents {
+			res = append(res, strings.TrimPrefix(c.Key, fullPrefix))
+		}
+		for _, p := range result.CommonPrefixes {
+			res = append(res, strings.TrimSuffix(strings.TrimPrefix(p.Prefix, fullPrefix), delimiter))
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return res, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// request describes a request to the service.
+type request struct {
+	// op and key identify the request in errors.
+	op     string
+	method string
+	// key is the name of the object, without Config.Prefix.
+	key string
+	// bucketOnly is set for requests on the bucket rather than an object.
+	bucketOnly bool
+	query      url.Values
+	header     http.Header
+	body       []byte
+}
+
+func (s *Storage) url(r *request) *url.URL {
+	u := *s.endpoint
+	p := strings.TrimSuffix(u.Path, "/")
+	if s.cfg.PathStyle {
+		p += "/" + s.cfg.Bucket
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+	}
+	if r.bucketOnly {
+		p += "/"
+	} else {
+		p += "/" + s.cfg.Prefix + r.key
+	}
+	u.Path = p
+	u.RawPath = uriEncode(p, false /* encodeSlash */)
+	u.RawQuery = canonicalQuery(r.query)
+	return &u
+}
+
+// do sends the request, retrying it on transient errors. The successful
+// response is passed to fn (if non-nil); an error fn returns is retried too if
+// it's transient, e.g. if reading the body failed.
+func (s *Storage) do(ctx context.Context, r request, fn func(*http.Response) error) error {
+	payloadHash := emptyPayloadHash
+	if r.body != nil {
+		payloadHash = hexSHA256(r.body)
+	}
+	for attempt := 0; ; attempt++ {
+		err := s.attempt(ctx, &r, payloadHash, fn)
+		if err == nil || attempt >= s.cfg.MaxRetries || !isRetryable(err) {
+			return err
+		}
+		if waitErr := s.backoff(ctx, attempt+1); waitErr != nil {
+			return errors.WithSecondaryError(waitErr, err)
+		}
+	}
+}
+
+func (s *Storage) attempt(
+	ctx context.Context, r *request, payloadHash string, fn func(*http.Response) error,
+) error {
+	var body io.Reader
+	if r.body != nil {
+		body = bytes.NewReader(r.body)
+	}
+	req, err := http.NewRequestWithContext(ctx, r.method, s.url(r).String(), body)
+	if err != nil {
+		return err
+	}
+	for k, v := range r.header {
+		req.Header[k] = v
+	}
+	if s.cfg.Credentials != nil {
+		creds, err := s.cfg.Credentials.Credentials(ctx)
+		if err != nil {
+			return errors.Wrap(err, "s3: retrieving credentials")
+		}
+		signRequest(req, payloadHash, creds, s.cfg.Region, s.cfg.now())
+	}
+	resp, err := s.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+	if resp.StatusCode/100 != 2 {
+		e := &Error{Op: r.op, Key: r.key, StatusCode: resp.StatusCode}
+		var body struct {
+			Code    string
+			Message string
+		}
+		if r.method != http.MethodHead && xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body) == nil {
+			e.Code, e.Message = body.Code, body.Message
+		}
+		return e
+	}
+	if fn == nil {
+		return nil
+	}
+	return fn(resp)
+}
+
+// backoff waits before the given retry attempt.
+func (s *Storage) backoff(ctx context.Context, attempt int) error {
+	d := s.cfg.RetryBaseDelay
+	for i := 1; i < attempt && d < s.cfg.RetryMaxDelay; i++ {
+		d *= 2
+	}
+	if d > s.cfg.RetryMaxDelay {
+		d = s.cfg.RetryMaxDelay
+	}
+	// Jitter the delay within its upper half.
+	d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isRetryable returns true if the error is transient: a network error, a
+// truncated response body, or a throttling or server error response.
+func isRetryable(err error) bool {
+	var e *Error
+	if errors.As(err, &e) {
+		switch e.StatusCode {
+		case http.StatusRequestTimeout, http.StatusTooManyRequests:
+			return true
+		}
+		return e.StatusCode >= 500 || e.Code == "SlowDown" || e.Code == "RequestTimeout"
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// partNumberParam returns the query of a request on a part of a multipart
+// upload.
+func partNumberParam(uploadID string, partNumber int) url.Values {
+	return url.Values{"partNumber": {strconv.Itoa(partNumber)}, "uploadId": {uploadID}}
+}
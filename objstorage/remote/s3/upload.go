@@ -0,0 +1,257 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package s3
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+
+	"github.com/cockroachdb/errors"
+)
+
+// objectWriter is the io.WriteCloser returned by Storage.CreateObject. It
+// buffers the written data into parts of Config.PartSize bytes. The first full
+// part starts a multipart upload, and each part is then uploaded in the
+// background, with up to Config.UploadConcurrency parts in flight; Write blocks
+// while that many parts are in flight. If the object is smaller than a part,
+// Close uploads it with a single request instead.
+type objectWriter struct {
+	s       *Storage
+	objName string
+	ctx     context.Context
+	buf     []byte
+	// uploadID is the ID of the multipart upload, once started.
+	uploadID string
+	// numParts is the number of parts handed to uploaders.
+	numParts int
+	// sem limits the number of parts in flight.
+	sem    chan struct{}
+	wg     sync.WaitGroup
+	closed bool
+
+	mu struct {
+		sync.Mutex
+		// err is the first error encountered by an uploader.
+		err   error
+		parts []completedPart
+	}
+}
+
+type completedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+var _ io.WriteCloser = (*objectWriter)(nil)
+
+func newObjectWriter(s *Storage, objName string) *objectWriter {
+	return &objectWriter{
+		s:       s,
+		objName: objName,
+		ctx:     context.Background(),
+		sem:     make(chan struct{}, s.cfg.UploadConcurrency),
+	}
+}
+
+// Write is part of the io.Writer interface. An error uploading an earlier part
+// may be returned by a later Write, and is always returned by Close.
+func (w *objectWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("s3: write to closed object writer")
+	}
+	if err := w.uploadErr(); err != nil {
+		return 0, err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		if w.buf == nil {
+			w.buf = make([]byte, 0, w.s.cfg.PartSize)
+		}
+		m := cap(w.buf) - len(w.buf)
+		if m > len(p) {
+			m = len(p)
+		}
+		w.buf = append(w.buf, p[:m]...)
+		p = p[m:]
+		if len(w.buf) == cap(w.buf) {
+			if err := w.flushPart(); err != nil {
+				return n - len(p), err
+			}
+		}
+	}
+	return n, nil
+}
+
+// flushPart hands the buffer to an uploader, starting the multipart upload if
+// necessary.
+func (w *objectWriter) flushPart() error {
+	if w.uploadID == "" {
+		if err := w.startUpload(); err != nil {
+			w.setErr(err)
+			return err
+		}
+	}
+	w.numParts++
+	partNumber, data := w.numParts, w.buf
+	w.buf = nil
+	w.sem <- struct{}{}
+	w.wg.Add(1)
+	go func() {
+		defer func() {
+			<-w.sem
+			w.wg.Done()
+		}()
+		if w.uploadErr() != nil {
+			return
+		}
+		var etag string
+		err := w.s.do(w.ctx, request{
+			op:     "upload part",
+			method: http.MethodPut,
+			key:    w.objName,
+			query:  partNumberParam(w.uploadID, partNumber),
+			body:   data,
+		}, func(resp *http.Response) error {
+			etag = resp.Header.Get("ETag")
+			return nil
+		})
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if err != nil {
+			if w.mu.err == nil {
+				w.mu.err = err
+			}
+			return
+		}
+		w.mu.parts = append(w.mu.parts, completedPart{PartNumber: partNumber, ETag: etag})
+	}()
+	return nil
+}
+
+func (w *objectWriter) startUpload() error {
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err := w.s.do(w.ctx, request{
+		op:     "create multipart upload",
+		method: http.MethodPost,
+		key:    w.objName,
+		query:  url.Values{"uploads": {""}},
+	}, func(resp *http.Response) error {
+		return xml.NewDecoder(resp.Body).Decode(&result)
+	})
+	if err != nil {
+		return err
+	}
+	if result.UploadID == "" {
+		return errors.Errorf("s3: create multipart upload %q: missing upload ID", w.objName)
+	}
+	w.uploadID = result.UploadID
+	return nil
+}
+
+func (w *objectWriter) uploadErr() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.mu.err
+}
+
+func (w *objectWriter) setErr(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.mu.err == nil {
+		w.mu.err = err
+	}
+}
+
+// Close is part of the io.Closer interface. It completes the upload, or
+// aborts it if any part failed to upload.
+func (w *objectWriter) Close() error {
+	if w.closed {
+		return errors.New("s3: object writer already closed")
+	}
+	w.closed = true
+	if w.uploadID == "" {
+		if err := w.uploadErr(); err != nil {
+			return err
+		}
+		body := w.buf
+		if body == nil {
+			body = []byte{}
+		}
+		w.buf = nil
+		return w.s.do(w.ctx, request{op: "put", method: http.MethodPut, key: w.objName, body: body}, nil)
+	}
+
+	if len(w.buf) > 0 {
+		// flushPart can't fail since the upload has started.
+		_ = w.flushPart()
+	}
+	w.wg.Wait()
+	if err := w.uploadErr(); err != nil {
+		w.abort()
+		return err
+	}
+	if err := w.complete(); err != nil {
+		w.abort()
+		return err
+	}
+	return nil
+}
+
+func (w *objectWriter) complete() error {
+	parts := w.mu.parts
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	return w.s.do(w.ctx, request{
+		op:     "complete multipart upload",
+		method: http.MethodPost,
+		key:    w.objName,
+		query:  url.Values{"uploadId": {w.uploadID}},
+		body:   body,
+	}, func(resp *http.Response) error {
+		// The request can fail after the response status was sent, in which case
+		// the body is an error rather than the result.
+		var result struct {
+			XMLName xml.Name
+			Code    string
+			Message string
+		}
+		if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return err
+		}
+		if result.XMLName.Local == "Error" {
+			return &Error{
+				Op:         "complete multipart upload",
+				Key:        w.objName,
+				StatusCode: http.StatusInternalServerError,
+				Code:       result.Code,
+				Message:    result.Message,
+			}
+		}
+		return nil
+	})
+}
+
+// abort aborts the multipart upload, freeing the parts that were uploaded.
+func (w *objectWriter) abort() {
+	_ = w.s.do(w.ctx, request{
+		op:     "abort multipart upload",
+		method: http.MethodDelete,
+		key:    w.objName,
+		query:  url.Values{"uploadId": {w.uploadID}},
+	}, nil)
+}
@@ -70,6 +70,20 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 		opts.Logger = opts.LoggerAndTracer
 	}
 
+	// Observe the operations performed on the filesystem to detect a failing
+	// disk, upon which the DB enters degraded mode.
+	var diskHealth *diskHealthMonitor
+	if opts.Experimental.DegradedMode.enabled() && !opts.ReadOnly {
+		diskHealth = newDiskHealthMonitor(opts)
+		opts.FS = vfs.OnDiskOp(opts.FS, diskHealth.onDiskOp)
+	}
+	// Time the operations performed on the filesystem, for Metrics.DiskOps
+	// and EventListener.DiskOpTrace.
+	diskOpTracer := newDiskOpTracer(opts)
+	if diskOpTracer != nil {
+		opts.FS = vfs.TraceDiskOps(opts.FS, diskOpTracer.onDiskOp)
+	}
+
 	// In all error cases, we return db = nil; this is used by various
 	// deferred cleanups.
 
@@ -99,14 +113,16 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 			return nil, err
 		}
 		fileLock = opts.Lock
-	} else {
+	} else if !opts.private.secondary {
+		// A secondary doesn't lock the database directory, which is locked
+		// by its primary.
 		fileLock, err = LockDirectory(dirname, opts.FS)
 		if err != nil {
 			return nil, err
 		}
 	}
 	defer func() {
-		if db == nil {
+		if db == nil && fileLock != nil {
 			fileLock.Close()
 		}
 	}()
@@ -164,9 +180,17 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 		fileLock:            fileLock,
 		dataDir:             dataDir,
 		walDir:              walDir,
-		logRecycler:         logRecycler{limit: opts.MemTableStopWritesThreshold + 1},
+		logRecycler:         logRecycler{limit: opts.walRecycleLimit()},
 		closed:              new(atomic.Value),
 		closedCh:            make(chan struct{}),
+		diskHealth:          diskHealth,
+		diskOpTracer:        diskOpTracer,
+	}
+	if diskHealth != nil {
+		diskHealth.setOnDegraded(d.onDiskDegraded)
+	}
+	if opts.CacheQuota > 0 {
+		opts.Cache.SetQuota(d.cacheID, opts.CacheQuota)
 	}
 	d.mu.versions = &versionSet{}
 	d.diskAvailBytes.Store(math.MaxUint64)
@@ -187,10 +211,14 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 			if d.tableCache != nil {
 				_ = d.tableCache.close()
 			}
+			if d.blobFiles != nil {
+				_ = d.blobFiles.close()
+			}
 
 			for _, mem := range d.mu.mem.queue {
 				switch t := mem.flushable.(type) {
 				case *memTable:
+					d.memoryRelease(MemoryKindMemTable, int64(len(t.arenaBuf)))
 					manual.Free(t.arenaBuf)
 					t.arenaBuf = nil
 				}
@@ -208,10 +236,12 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 	}()
 
 	d.commit = newCommitPipeline(commitEnv{
-		logSeqNum:     &d.mu.versions.logSeqNum,
-		visibleSeqNum: &d.mu.versions.visibleSeqNum,
-		apply:         d.commitApply,
-		write:         d.commitWrite,
+		logSeqNum:      &d.mu.versions.logSeqNum,
+		visibleSeqNum:  &d.mu.versions.visibleSeqNum,
+		apply:          d.commitApply,
+		write:          d.commitWrite,
+		maxConcurrency: opts.Experimental.MaxConcurrentCommits,
+		recordMetrics:  opts.Experimental.EnableCommitMetrics,
 	})
 	d.mu.nextJobID = 1
 	d.mu.mem.nextSize = opts.MemTableSize
@@ -219,6 +249,8 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 		d.mu.mem.nextSize = initialMemTableSize
 	}
 	d.mu.compact.cond.L = &d.mu.Mutex
+	d.mu.idempotencyTokens.cond.L = &d.mu.Mutex
+	d.asyncCommits.cond.L = &d.asyncCommits.mu
 	d.mu.compact.inProgress = make(map[*compaction]struct{})
 	d.mu.compact.noOngoingFlushStartTime = time.Now()
 	d.mu.snapshots.init()
@@ -232,6 +264,7 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 
 	d.timeNow = time.Now
 	d.openedAt = d.timeNow()
+	d.mu.compact.l0Trajectory.timeNow = time.Now
 
 	d.mu.Lock()
 	defer d.mu.Unlock()
@@ -267,6 +300,9 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 			}
 		}
 	}
+	if opts.private.secondary {
+		d.initSecondaryLocked()
+	}
 
 	// In read-only mode, we replay directly into the mutable memtable but never
 	// flush it. We need to delay creation of the memtable until we know the
@@ -297,18 +333,31 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 		FSCleaner:           opts.Cleaner,
 		NoSyncOnClose:       opts.NoSyncOnClose,
 		BytesPerSync:        opts.BytesPerSync,
+		FSExtraDirNames:     opts.tableDirs(dirname),
 	}
+	providerSettings.DirectIO.Reads = opts.Experimental.DirectIOReads
+	providerSettings.DirectIO.Writes = opts.Experimental.DirectIOWrites
+	providerSettings.IOUringReads = opts.Experimental.IOUringReads
+	providerSettings.MmapReads = opts.Experimental.MmapReads
 	providerSettings.Remote.StorageFactory = opts.Experimental.RemoteStorage
-	providerSettings.Remote.CreateOnShared = opts.Experimental.CreateOnShared
+	// Tiering compactions create sstables on remote storage even if no other
+	// sstables are.
+	providerSettings.Remote.CreateOnShared = opts.Experimental.CreateOnShared || opts.Experimental.Tiering.enabled()
 	providerSettings.Remote.CreateOnSharedLocator = opts.Experimental.CreateOnSharedLocator
 	providerSettings.Remote.CacheSizeBytes = opts.Experimental.SecondaryCacheSizeBytes
+	providerSettings.Remote.CacheWriteThrough = opts.Experimental.SecondaryCacheWriteMode == SecondaryCacheWriteThrough
 
 	d.objProvider, err = objstorageprovider.Open(providerSettings)
 	if err != nil {
 		return nil, err
 	}
 
-	d.cleanupManager = openCleanupManager(opts, d.objProvider, d.onObsoleteTableDelete, d.getDeletionPacerInfo)
+	d.compactionLimiter = newCompactionWriteScheduler(opts.CompactionWriteRateLimit)
+	d.ioScheduler = opts.IOScheduler
+	if d.ioScheduler == nil {
+		d.ioScheduler = d.compactionLimiter
+	}
+	d.cleanupManager = openCleanupManager(opts, d.objProvider, d.onObsoleteTableDelete, d.onObsoleteWALDelete, d.getDeletionPacerInfo)
 
 	if manifestExists {
 		curVersion := d.mu.versions.currentVersion()
@@ -319,8 +368,20 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 
 	tableCacheSize := TableCacheSize(opts.MaxOpenFiles)
 	d.tableCache = newTableCacheContainer(opts.TableCache, d.cacheID, d.objProvider, d.opts, tableCacheSize)
+	if opts.Experimental.Tiering.enabled() {
+		d.tableCache.dbOpts.recordRead = d.recordTableRead
+	}
+	d.blobFiles = newBlobFileCache(d.objProvider, opts.Cache, d.cacheID)
+	d.tableCache.dbOpts.opts.BlobValueFetcher = d.blobFiles
 	d.newIters = d.tableCache.newIters
 	d.tableNewRangeKeyIter = d.tableCache.newRangeKeyIter
+	if opts.RowCacheSize > 0 {
+		d.rowCache = newRowCache(opts.RowCacheSize)
+	}
+	d.subscriptions.maxSize = opts.SubscriptionBufferSize
+	if d.subscriptions.maxSize == 0 {
+		d.subscriptions.maxSize = defaultSubscriptionBufferSize
+	}
 
 	// Replay any newer log files than the ones named in the manifest.
 	type fileNumAndName struct {
@@ -385,19 +446,47 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 
 	var ve versionEdit
 	var toFlush flushableList
+	if target := opts.WALReplayTarget.SeqNum; target != 0 {
+		if err := d.checkWALReplayTarget(target); err != nil {
+			return nil, err
+		}
+	}
+	var replayStopped bool
+	// walCorruption is a corruption at the tail of a WAL file that isn't the
+	// most recent, which is reported once the first valid batch following it
+	// is replayed from a later WAL file (see WALRecoverySkipAndReport).
+	var walCorruption *WALCorruptionInfo
 	for i, lf := range logFiles {
+		if replayStopped {
+			// The WAL files following the WAL replay target are not replayed,
+			// and become obsolete.
+			d.mu.versions.markFileNumUsed(lf.num)
+			continue
+		}
 		lastWAL := i == len(logFiles)-1
-		flush, maxSeqNum, err := d.replayWAL(jobID, &ve, opts.FS,
-			opts.FS.PathJoin(d.walDirname, lf.name), lf.num, strictWALTail && !lastWAL)
+		if d.opts.ReadOnly && len(d.mu.versions.currentVersion().FlushedSpans) > 0 {
+			// Replay each WAL into its own memtables, for the flushed spans
+			// to apply to them (see flushableView).
+			d.mu.mem.mutable = nil
+		}
+		flush, maxSeqNum, stopped, err := d.replayWAL(jobID, &ve, opts.FS,
+			opts.FS.PathJoin(d.walDirname, lf.name), lf.num,
+			(strictWALTail || opts.WALRecoveryMode == WALRecoveryStrict) && !lastWAL, &walCorruption)
 		if err != nil {
 			return nil, err
 		}
+		replayStopped = stopped
 		toFlush = append(toFlush, flush...)
 		d.mu.versions.markFileNumUsed(lf.num)
 		if d.mu.versions.logSeqNum.Load() < maxSeqNum {
 			d.mu.versions.logSeqNum.Store(maxSeqNum)
 		}
 	}
+	if walCorruption != nil && !replayStopped {
+		// No valid batch follows the corruption, so the number of batches lost
+		// is unknown.
+		return nil, errors.Wrap(walCorruption.Err, "pebble: error when replaying WAL")
+	}
 	d.mu.versions.visibleSeqNum.Store(d.mu.versions.logSeqNum.Load())
 
 	if !d.opts.ReadOnly {
@@ -453,11 +542,16 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 
 		logWriterConfig := record.LogWriterConfig{
 			WALMinSyncInterval: d.opts.WALMinSyncInterval,
+			WALSyncDeadline:    d.opts.WALSyncDeadline,
 			WALFsyncLatency:    d.mu.log.metrics.fsyncLatency,
 			QueueSemChan:       d.commit.logSyncQSem,
 		}
 		d.mu.log.LogWriter = record.NewLogWriter(logFile, newLogNum, logWriterConfig)
+		if d.mu.log.cipher, err = d.startWALEncryption(d.mu.log.LogWriter); err != nil {
+			return nil, err
+		}
 		d.mu.versions.metrics.WAL.Files++
+		d.mu.versions.metrics.WAL.FilesCreated++
 	}
 	d.updateReadStateLocked(d.opts.DebugCheck)
 
@@ -518,6 +612,7 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 	}
 	d.mu.tableStats.cond.L = &d.mu.Mutex
 	d.mu.tableValidation.cond.L = &d.mu.Mutex
+	d.mu.tableWarming.cond.L = &d.mu.Mutex
 	if !d.opts.ReadOnly {
 		d.maybeCollectTableStatsLocked()
 	}
@@ -525,6 +620,12 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 
 	d.maybeScheduleFlush()
 	d.maybeScheduleCompaction()
+	if interval := d.opts.compactionCheckInterval(); interval > 0 && !d.opts.ReadOnly {
+		go d.compactionCheckLoop(interval)
+	}
+	if d.opts.diskSpaceLimitsEnabled() && !d.opts.ReadOnly {
+		go d.diskSpaceCheckLoop()
+	}
 
 	// Note: this is a no-op if invariants are disabled or race is enabled.
 	//
@@ -551,6 +652,8 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 		}
 	})
 
+	d.memoryShrink.blockCacheSize = d.opts.Cache.MaxSize()
+	d.memoryReserve(MemoryKindBlockCache, d.memoryShrink.blockCacheSize)
 	return d, nil
 }
 
@@ -576,6 +679,11 @@ func prepareAndOpenDirs(
 				return "", nil, nil, err
 			}
 		}
+		for _, dir := range opts.tableDirs(dirname) {
+			if err := opts.FS.MkdirAll(dir, 0755); err != nil {
+				return "", nil, nil, err
+			}
+		}
 	}
 
 	dataDir, err = opts.FS.OpenDir(dirname)
@@ -668,12 +776,24 @@ func GetVersion(dir string, fs vfs.FS) (string, error) {
 //
 // d.mu must be held when calling this, but the mutex may be dropped and
 // re-acquired during the course of this method.
+//
+// If a corrupt record extends to the end of a WAL file with a strict tail in
+// WALRecoverySkipAndReport mode, the batches lost are only known once the
+// following WAL file is replayed. The corruption is then returned in
+// *tailCorruption, which must be passed to the replay of the following WAL
+// file, which reports it.
 func (d *DB) replayWAL(
-	jobID int, ve *versionEdit, fs vfs.FS, filename string, logNum FileNum, strictWALTail bool,
-) (toFlush flushableList, maxSeqNum uint64, err error) {
+	jobID int,
+	ve *versionEdit,
+	fs vfs.FS,
+	filename string,
+	logNum FileNum,
+	strictWALTail bool,
+	tailCorruption **WALCorruptionInfo,
+) (toFlush flushableList, maxSeqNum uint64, stopped bool, err error) {
 	file, err := fs.Open(filename)
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, false, err
 	}
 	defer file.Close()
 	var (
@@ -682,11 +802,18 @@ func (d *DB) replayWAL(
 		mem             *memTable
 		entry           *flushableEntry
 		rr              = record.NewReader(file, logNum)
-		offset          int64 // byte offset in rr
+		dec             = walDecoder{km: d.opts.WALKeyManager}
+		target          = &d.opts.WALReplayTarget
+		commitTimeKnown bool // a commit time record preceded the batch
+		afterTarget     bool // the batch was committed after target.Time
+		mode            = d.opts.WALRecoveryMode
+		corruption      = *tailCorruption // corruption preceding the next batch
+		offset          int64             // byte offset in rr
 		lastFlushOffset int64
 		keysReplayed    int64 // number of keys replayed
 		batchesReplayed int64 // number of batches replayed
 	)
+	*tailCorruption = nil
 
 	if d.opts.ReadOnly {
 		// In read-only mode, we replay directly into the mutable memtable which will
@@ -756,30 +883,104 @@ func (d *DB) replayWAL(
 			// truncated and to avoid replaying subsequent WALs, but want
 			// to otherwise treat them like EOF.
 			if err == io.EOF {
+				if corruption != nil && strictWALTail && mode == WALRecoveryStrict {
+					return nil, 0, false, errors.Wrap(corruption.Err, "pebble: error when replaying WAL")
+				}
+				// A corruption at the tail of a WAL file that isn't the most
+				// recent (or carried over from such a file) is reported by the
+				// replay of the following WAL file.
+				if corruption != nil && mode == WALRecoverySkipAndReport &&
+					(strictWALTail || corruption.FileNum != logNum) {
+					*tailCorruption = corruption
+				}
 				break
-			} else if record.IsInvalidRecord(err) && !strictWALTail {
-				break
 			}
-			return nil, 0, errors.Wrap(err, "pebble: error when replaying WAL")
+			if !record.IsInvalidRecord(err) || (strictWALTail && mode == WALRecoveryTolerateCorruptTail) {
+				return nil, 0, false, errors.Wrap(err, "pebble: error when replaying WAL")
+			}
+			// Continue reading past the corruption to find out whether valid
+			// batches follow it. If none do, the corruption is the tail of the
+			// WAL file.
+			if corruption == nil {
+				corruption = &WALCorruptionInfo{
+					JobID:   jobID,
+					Path:    filename,
+					FileNum: logNum,
+					Offset:  offset,
+					Err:     err,
+				}
+				corruption.LostSeqNums[0] = maxSeqNum
+				if maxSeqNum == 0 {
+					corruption.LostSeqNums[0] = d.mu.versions.logSeqNum.Load()
+				}
+			}
+			rr.Recover()
+			buf.Reset()
+			continue
 		}
 
-		if buf.Len() < batchHeaderLen {
-			return nil, 0, base.CorruptionErrorf("pebble: corrupt log file %q (num %s)",
+		repr, err := dec.decode(buf.Bytes())
+		if err != nil {
+			return nil, 0, false, errors.Wrapf(err, "pebble: error when replaying WAL %q", filename)
+		}
+		if repr == nil {
+			// The encryption header of the WAL file.
+			buf.Reset()
+			continue
+		}
+		if millis, ok := decodeWALCommitTime(repr); ok {
+			commitTimeKnown = true
+			afterTarget = !target.Time.IsZero() && millis > target.Time.UnixMilli()
+			buf.Reset()
+			continue
+		}
+
+		if len(repr) < batchHeaderLen {
+			return nil, 0, false, base.CorruptionErrorf("pebble: corrupt log file %q (num %s)",
 				filename, errors.Safe(logNum))
 		}
 
+		if corruption != nil {
+			// Valid batches follow the corruption, and the batches between them
+			// are lost.
+			corruption.LostSeqNums[1] = binary.LittleEndian.Uint64(repr[:batchCountOffset])
+			corruption.Skipped = mode == WALRecoverySkipAndReport
+			d.opts.EventListener.WALCorruption(*corruption)
+			if mode == WALRecoveryStrict {
+				return nil, 0, false, walCorruptionError(*corruption)
+			}
+			if mode == WALRecoveryTolerateCorruptTail {
+				break
+			}
+			corruption = nil
+		}
+
 		if d.opts.ErrorIfNotPristine {
-			return nil, 0, errors.WithDetailf(ErrDBNotPristine, "location: %q", d.dirname)
+			return nil, 0, false, errors.WithDetailf(ErrDBNotPristine, "location: %q", d.dirname)
 		}
 
 		// Specify Batch.db so that Batch.SetRepr will compute Batch.memTableSize
 		// which is used below.
 		b = Batch{db: d}
-		b.SetRepr(buf.Bytes())
+		b.SetRepr(repr)
 		seqNum := b.SeqNum()
+		if afterTarget || (target.SeqNum != 0 && seqNum+uint64(b.Count()) > target.SeqNum) {
+			stopped = true
+			break
+		}
+		if !target.Time.IsZero() && !commitTimeKnown {
+			return nil, 0, false, errors.Errorf("pebble: WAL file %q does not record commit times, "+
+				"which are required by Options.WALReplayTarget.Time", filename)
+		}
 		maxSeqNum = seqNum + uint64(b.Count())
 		keysReplayed += int64(b.Count())
 		batchesReplayed++
+		if token := findIdempotencyToken(b.data); token != nil {
+			if d.mu.idempotencyTokens.m == nil {
+				d.mu.idempotencyTokens.m = make(map[string]uint64)
+			}
+			d.mu.idempotencyTokens.m[string(token)] = seqNum
+		}
 		{
 			br := b.Reader()
 			if kind, encodedFileNum, _, _ := br.Next(); kind == InternalKeyKindIngestSST {
@@ -813,29 +1014,29 @@ func (d *DB) replayWAL(
 					var readable objstorage.Readable
 					objMeta, err := d.objProvider.Lookup(fileTypeTable, n)
 					if err != nil {
-						return nil, 0, errors.Wrap(err, "pebble: error when looking up ingested SSTs")
+						return nil, 0, false, errors.Wrap(err, "pebble: error when looking up ingested SSTs")
 					}
 					if objMeta.IsRemote() {
 						readable, err = d.objProvider.OpenForReading(context.TODO(), fileTypeTable, n, objstorage.OpenOptions{MustExist: true})
 						if err != nil {
-							return nil, 0, errors.Wrap(err, "pebble: error when opening flushable ingest files")
+							return nil, 0, false, errors.Wrap(err, "pebble: error when opening flushable ingest files")
 						}
 					} else {
-						path := base.MakeFilepath(d.opts.FS, d.dirname, fileTypeTable, n)
+						path := d.objProvider.Path(objMeta)
 						f, err := d.opts.FS.Open(path)
 						if err != nil {
-							return nil, 0, err
+							return nil, 0, false, err
 						}
 
 						readable, err = sstable.NewSimpleReadable(f)
 						if err != nil {
-							return nil, 0, err
+							return nil, 0, false, err
 						}
 					}
 					// NB: ingestLoad1 will close readable.
-					meta[i], err = ingestLoad1(d.opts, d.FormatMajorVersion(), readable, d.cacheID, n)
+					meta[i], err = ingestLoad1(d.opts, d.FormatMajorVersion(), readable, d.cacheID, n, KeyRange{}, IngestOptions{})
 					if err != nil {
-						return nil, 0, errors.Wrap(err, "pebble: error when loading flushable ingest files")
+						return nil, 0, false, errors.Wrap(err, "pebble: error when loading flushable ingest files")
 					}
 				}
 
@@ -847,7 +1048,7 @@ func (d *DB) replayWAL(
 					meta, seqNum, logNum,
 				)
 				if err != nil {
-					return nil, 0, err
+					return nil, 0, false, err
 				}
 
 				if d.opts.ReadOnly {
@@ -888,14 +1089,14 @@ func (d *DB) replayWAL(
 						ve.NewFiles = append(ve.NewFiles, newFileEntry{Level: 0, Meta: file.FileMetadata})
 					}
 				}
-				return toFlush, maxSeqNum, nil
+				return toFlush, maxSeqNum, false, nil
 			}
 		}
 
 		if b.memTableSize >= uint64(d.largeBatchThreshold) {
 			flushMem()
-			// Make a copy of the data slice since it is currently owned by buf and will
-			// be reused in the next iteration.
+			// Make a copy of the data slice since it is currently owned by buf (or
+			// the WAL decoder) and will be reused in the next iteration.
 			b.data = append([]byte(nil), b.data...)
 			b.flushable = newFlushableBatch(&b, d.opts.Comparer)
 			entry := d.newFlushableEntry(b.flushable, logNum, b.SeqNum())
@@ -919,7 +1120,7 @@ func (d *DB) replayWAL(
 		} else {
 			ensureMem(seqNum)
 			if err = mem.prepare(&b); err != nil && err != arenaskl.ErrArenaFull {
-				return nil, 0, err
+				return nil, 0, false, err
 			}
 			// We loop since DB.newMemTable() slowly grows the size of allocated memtables, so the
 			// batch may not initially fit, but will eventually fit (since it is smaller than
@@ -929,11 +1130,11 @@ func (d *DB) replayWAL(
 				ensureMem(seqNum)
 				err = mem.prepare(&b)
 				if err != nil && err != arenaskl.ErrArenaFull {
-					return nil, 0, err
+					return nil, 0, false, err
 				}
 			}
 			if err = mem.apply(&b, seqNum); err != nil {
-				return nil, 0, err
+				return nil, 0, false, err
 			}
 			mem.writerUnref()
 		}
@@ -941,16 +1142,22 @@ func (d *DB) replayWAL(
 	}
 
 	d.opts.Logger.Infof("[JOB %d] WAL file %s with log number %s stopped reading at offset: %d; replayed %d keys in %d batches", jobID, filename, logNum.String(), offset, keysReplayed, batchesReplayed)
+	if stopped {
+		d.opts.Logger.Infof("[JOB %d] WAL replay target reached in WAL file %s at offset: %d; discarding the following batches", jobID, filename, offset)
+		if err := d.checkWALReplayTarget(b.SeqNum()); err != nil {
+			return nil, 0, false, err
+		}
+	}
 	flushMem()
 
 	// mem is nil here.
 	if !d.opts.ReadOnly {
 		err = updateVE()
 		if err != nil {
-			return nil, 0, err
+			return nil, 0, false, err
 		}
 	}
-	return toFlush, maxSeqNum, err
+	return toFlush, maxSeqNum, stopped, err
 }
 
 func checkOptions(opts *Options, path string) (strictWALTail bool, err error) {
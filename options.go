@@ -41,6 +41,16 @@ const (
 	ZstdCompression    = sstable.ZstdCompression
 )
 
+// ChecksumType exports the sstable.ChecksumType type.
+type ChecksumType = sstable.ChecksumType
+
+// Exported ChecksumType constants.
+const (
+	ChecksumTypeCRC32c   = sstable.ChecksumTypeCRC32c
+	ChecksumTypeXXHash64 = sstable.ChecksumTypeXXHash64
+	ChecksumTypeXXH3     = sstable.ChecksumTypeXXH3
+)
+
 // FilterType exports the base.FilterType type.
 type FilterType = base.FilterType
 
@@ -55,15 +65,31 @@ type FilterWriter = base.FilterWriter
 // FilterPolicy exports the base.FilterPolicy type.
 type FilterPolicy = base.FilterPolicy
 
+// PrefixExtractor exports the base.PrefixExtractor type.
+type PrefixExtractor = base.PrefixExtractor
+
 // TablePropertyCollector exports the sstable.TablePropertyCollector type.
 type TablePropertyCollector = sstable.TablePropertyCollector
 
+// AggregatingTablePropertyCollector exports the
+// sstable.AggregatingTablePropertyCollector type.
+type AggregatingTablePropertyCollector = sstable.AggregatingTablePropertyCollector
+
 // BlockPropertyCollector exports the sstable.BlockPropertyCollector type.
 type BlockPropertyCollector = sstable.BlockPropertyCollector
 
 // BlockPropertyFilter exports the sstable.BlockPropertyFilter type.
 type BlockPropertyFilter = base.BlockPropertyFilter
 
+// TableKeyManager exports the sstable.KeyManager type.
+type TableKeyManager = sstable.KeyManager
+
+// CacheResidency exports the sstable.CacheResidency type.
+type CacheResidency = sstable.CacheResidency
+
+// ColumnarSchema exports the sstable.ColumnarSchema type.
+type ColumnarSchema = sstable.ColumnarSchema
+
 // ShortAttributeExtractor exports the base.ShortAttributeExtractor type.
 type ShortAttributeExtractor = base.ShortAttributeExtractor
 
@@ -99,6 +125,33 @@ func (t IterKeyType) String() string {
 	}
 }
 
+// SecondaryCacheWriteMode configures how the secondary cache of remote
+// sstables is populated (see Options.Experimental.SecondaryCacheSizeBytes).
+type SecondaryCacheWriteMode int8
+
+const (
+	// SecondaryCacheWriteAround populates the secondary cache only with the
+	// data that reads missing the cache read from remote storage.
+	SecondaryCacheWriteAround SecondaryCacheWriteMode = iota
+	// SecondaryCacheWriteThrough additionally adds the sstables created on
+	// remote storage by flushes, compactions and ingestions to the secondary
+	// cache as they are written, so that their first reads don't have to wait
+	// on remote storage.
+	SecondaryCacheWriteThrough
+)
+
+// String implements fmt.Stringer.
+func (m SecondaryCacheWriteMode) String() string {
+	switch m {
+	case SecondaryCacheWriteAround:
+		return "write-around"
+	case SecondaryCacheWriteThrough:
+		return "write-through"
+	default:
+		return fmt.Sprintf("SecondaryCacheWriteMode(%d)", int8(m))
+	}
+}
+
 // IterOptions hold the optional per-query parameters for NewIter.
 //
 // Like Options, a nil *IterOptions is valid and means to use the default
@@ -176,6 +229,14 @@ type IterOptions struct {
 	// existing is not low or if we just expect a one-time Seek (where loading the
 	// data block directly is better).
 	UseL6Filters bool
+	// SkipCacheAdmission prevents data blocks read by the iterator from being
+	// added to the block cache. Blocks already present in the block cache are
+	// still used, and index blocks are still added to it. Helpful for large
+	// scans that are unlikely to revisit the data blocks they read, and that
+	// would otherwise evict the working set of point lookups from the block
+	// cache. Data blocks that aren't in the block cache are read into buffers
+	// owned by the iterator, which are released when it's closed.
+	SkipCacheAdmission bool
 
 	// Internal options.
 
@@ -352,6 +413,48 @@ type WriteOptions struct {
 	//
 	// The default value is true.
 	Sync bool
+
+	// DisableWAL skips writing the batch to the write-ahead log. The batch is
+	// applied to the memtable and only becomes durable once the memtable is
+	// flushed. DisableWAL and Sync are mutually exclusive.
+	//
+	// Batches committed with and without the WAL share a single sequence
+	// number space, and the commit order between them is preserved for
+	// readers. However, after a crash, WAL replay recovers only the batches
+	// that were written to the WAL: a batch committed with DisableWAL may be
+	// lost even though a later batch written to the WAL is recovered. Callers
+	// mixing durability levels must not rely on the recovered state being a
+	// prefix of the commit order.
+	//
+	// The default value is false. Setting DisableWAL has no effect if the
+	// WAL is already disabled through Options.DisableWAL.
+	DisableWAL bool
+
+	// Priority is the admission control priority of the write. Writes with a
+	// priority lower than WritePriorityUser are delayed once the LSM reaches
+	// the thresholds configured in Options.Experimental.WriteAdmission.
+	//
+	// The default value is WritePriorityUser.
+	Priority WritePriority
+
+	// LatencySensitive marks the write as latency-sensitive. Writes with a
+	// Priority of WritePriorityBulk are held back from the commit pipeline
+	// while a latency-sensitive write is waiting to be written to the WAL or,
+	// if it waits for the WAL to be synced, for the sync to complete. Thus
+	// latency-sensitive writes are neither queued behind large bulk batches nor
+	// made to wait for their WAL sync to persist bulk batches written after
+	// them. A bulk write is held back for at most 16 latency-sensitive writes,
+	// so a steady stream of latency-sensitive writes cannot delay it
+	// indefinitely.
+	//
+	// Bulk writes that have already entered the commit pipeline are not
+	// preempted: a latency-sensitive write still waits for a bulk batch that
+	// is being written to the WAL, and its WAL sync persists any bulk batches
+	// written before it. Isolating latency-sensitive syncs from those bulk
+	// batches would require separate WALs and is not supported.
+	//
+	// The default value is false.
+	LatencySensitive bool
 }
 
 // Sync specifies the default write options for writes which synchronize to
@@ -362,11 +465,34 @@ var Sync = &WriteOptions{Sync: true}
 // synchronize to disk.
 var NoSync = &WriteOptions{Sync: false}
 
+// NoWAL specifies the write options for writes which skip the WAL entirely.
+var NoWAL = &WriteOptions{Sync: false, DisableWAL: true}
+
 // GetSync returns the Sync value or true if the receiver is nil.
 func (o *WriteOptions) GetSync() bool {
 	return o == nil || o.Sync
 }
 
+// GetDisableWAL returns the DisableWAL value or false if the receiver is nil.
+func (o *WriteOptions) GetDisableWAL() bool {
+	return o != nil && o.DisableWAL
+}
+
+// GetPriority returns the Priority value or WritePriorityUser if the receiver
+// is nil.
+func (o *WriteOptions) GetPriority() WritePriority {
+	if o == nil {
+		return WritePriorityUser
+	}
+	return o.Priority
+}
+
+// GetLatencySensitive returns the LatencySensitive value or false if the
+// receiver is nil.
+func (o *WriteOptions) GetLatencySensitive() bool {
+	return o != nil && o.LatencySensitive
+}
+
 // LevelOptions holds the optional per-level parameters.
 type LevelOptions struct {
 	// BlockRestartInterval is the number of keys between restart points
@@ -375,6 +501,15 @@ type LevelOptions struct {
 	// The default value is 16.
 	BlockRestartInterval int
 
+	// DisableKeyDeltaEncoding stores the keys of data blocks in full rather
+	// than delta encoding them against the preceding key. Along with a short
+	// BlockRestartInterval, it suits levels serving mostly point lookups,
+	// while a longer BlockRestartInterval with delta encoding suits levels
+	// serving mostly scans.
+	//
+	// The default value is false.
+	DisableKeyDeltaEncoding bool
+
 	// BlockSize is the target uncompressed size in bytes of each table block.
 	//
 	// The default value is 4096.
@@ -392,11 +527,41 @@ type LevelOptions struct {
 	// The default value (DefaultCompression) uses snappy compression.
 	Compression Compression
 
+	// ValueBlockCompression defines the compression of the value blocks of
+	// sstables in TableFormatPebblev3 and later, which hold the older versions
+	// of keys separated from the data blocks. It allows values to be
+	// compressed with a better ratio, such as with ZstdCompression, while the
+	// data blocks read by every seek remain cheap to decompress.
+	//
+	// The default value (DefaultCompression) uses Compression.
+	ValueBlockCompression Compression
+
+	// ValueBlockSize is the target uncompressed size in bytes of each value
+	// block.
+	//
+	// The default value is the value of BlockSize.
+	ValueBlockSize int
+
+	// ZstdDictionarySize, if positive and Compression is ZstdCompression, is
+	// the size of the zstd dictionaries with which the data blocks of the
+	// sstables written to the level by flushes and compactions are
+	// compressed. The dictionary of each sstable is trained from the data
+	// blocks sampled from the sstable previously written to the level, and
+	// stored in the sstable. Dictionaries drastically improve the compression
+	// of small, similar values, but sstables compressed with a dictionary
+	// cannot be read by versions of Pebble predating dictionary compression,
+	// so dictionaries are only used once the DB's format major version is at
+	// least ExperimentalFormatZstdDictionaries.
+	//
+	// The default value is 0, which disables dictionary compression.
+	ZstdDictionarySize int
+
 	// FilterPolicy defines a filter algorithm (such as a Bloom filter) that can
 	// reduce disk reads for Get calls.
 	//
 	// One such implementation is bloom.FilterPolicy(10) from the pebble/bloom
-	// package.
+	// package. ribbon.FilterPolicy(10) from the pebble/ribbon package has the
+	// same false positive rate using ~30% less space.
 	//
 	// The default value means to use no filter.
 	FilterPolicy FilterPolicy
@@ -421,6 +586,35 @@ type LevelOptions struct {
 
 	// The target file size for the level.
 	TargetFileSize int64
+
+	// Dir is the directory in which flushes and compactions create the
+	// sstables they output to the level, so that fast media may be reserved
+	// for the latency-critical levels (typically L0 and L1), while the larger,
+	// lower levels are placed on slower media. The WAL may be placed with
+	// Options.WALDir. A file is not moved to a level with a different Dir
+	// without being rewritten. Ingested sstables are placed in the DB
+	// directory. Levels beyond the end of Options.Levels use the Dir of the
+	// last configured level.
+	//
+	// The directory is created if needed when the DB is opened. It must remain
+	// configured for as long as it holds sstables of the DB: sstables in
+	// directories that are no longer configured are not found.
+	//
+	// The default value is "", which places the sstables in the DB directory.
+	Dir string
+}
+
+// sameTableLayout returns true if sstables written with o and other use the
+// same compression (including zstd dictionaries) and block sizes.
+func (o LevelOptions) sameTableLayout(other LevelOptions) bool {
+	return o.Compression == other.Compression &&
+		o.BlockSize == other.BlockSize &&
+		o.BlockRestartInterval == other.BlockRestartInterval &&
+		o.DisableKeyDeltaEncoding == other.DisableKeyDeltaEncoding &&
+		o.IndexBlockSize == other.IndexBlockSize &&
+		o.ValueBlockCompression == other.ValueBlockCompression &&
+		o.ValueBlockSize == other.ValueBlockSize &&
+		o.ZstdDictionarySize == other.ZstdDictionarySize
 }
 
 // EnsureDefaults ensures that the default values for all of the options have
@@ -457,6 +651,17 @@ func (o *LevelOptions) EnsureDefaults() *LevelOptions {
 // apply to the DB at large; per-query options are defined by the IterOptions
 // and WriteOptions types.
 type Options struct {
+	// BlockChecksum is the checksum algorithm used for the blocks of the
+	// sstables written by the DB. Each sstable records its algorithm, so
+	// sstables written with different algorithms, including those written
+	// before the option was changed, are read alongside each other.
+	// ChecksumTypeXXH3 is considerably cheaper to compute than CRC-32C on
+	// platforms without a hardware CRC instruction, but requires a
+	// FormatMajorVersion of at least ExperimentalFormatBlockExtensions.
+	//
+	// The default value (ChecksumTypeCRC32c) uses CRC-32C.
+	BlockChecksum ChecksumType
+
 	// Sync sstables periodically in order to smooth out writes to disk. This
 	// option does not provide any persistency guarantee, but is used to avoid
 	// latency spikes if the OS automatically decides to write out a large chunk
@@ -471,6 +676,39 @@ type Options struct {
 	// The default cache size is 8 MB.
 	Cache *cache.Cache
 
+	// CacheQuota limits the total size of the DB's blocks in Cache. When Cache
+	// is shared by many DBs, a quota prevents a DB whose working set exceeds
+	// its quota from evicting the blocks of the others. Instead, once the DB's
+	// blocks exceed its quota, they're evicted to make room for its new blocks.
+	// Metrics.BlockCacheDB reports the DB's usage and hit rate.
+	//
+	// The default value is 0, which places no limit on the DB's share of the
+	// cache beyond the cache's size.
+	CacheQuota int64
+
+	// PinTopLevelIndexBlocks pins the top-level index blocks of sstables with
+	// two-level indexes while the sstables are open in the table cache, so
+	// that reads don't miss in the block cache on them. The size of the index
+	// partitions below them is controlled by LevelOptions.IndexBlockSize. The
+	// pinned blocks are held in addition to the block cache's capacity once
+	// they're evicted from it. DB.CacheResidency reports how much of the index
+	// and filter blocks of each level are resident in the block cache.
+	//
+	// The default value is false.
+	PinTopLevelIndexBlocks bool
+
+	// RowCacheSize is the capacity in bytes of a cache of the values returned
+	// by DB.Get, keyed by user key. Get consults the row cache before the
+	// memtables and sstables, which benefits workloads whose point lookups are
+	// heavily skewed towards a small set of keys, where even finding a key's
+	// value in a cached block is measurable. Writes of a key invalidate its
+	// entry, and range deletions and ingestions invalidate the entire row
+	// cache. Reads through batches and snapshots don't use the row cache. The
+	// row cache assumes that keys that compare as equal are byte-wise equal.
+	//
+	// The default value is 0, which disables the row cache.
+	RowCacheSize int64
+
 	// Cleaner cleans obsolete files.
 	//
 	// The default cleaner uses the DeleteCleaner.
@@ -540,6 +778,16 @@ type Options struct {
 		// concurrency slots as determined by the two options is chosen.
 		CompactionDebtConcurrency int
 
+		// SnapshotElisionConcurrency is the maximum number of concurrent
+		// elision-only compactions of bottommost sstables whose obsolete keys
+		// were pinned by a snapshot that has since been closed. When the
+		// earliest open snapshot advances, such sstables are queued and
+		// compacted with this budget, in addition to MaxConcurrentCompactions,
+		// to quickly reclaim the space held by long-lived snapshots. If zero,
+		// sstables are not queued and are only elided as regular compactions
+		// pick them.
+		SnapshotElisionConcurrency int
+
 		// ReadCompactionRate controls the frequency of read triggered
 		// compactions by adjusting `AllowedSeeks` in manifest.FileMetadata:
 		//
@@ -563,6 +811,14 @@ type Options struct {
 		// ```
 		ReadCompactionRate int64
 
+		// ReadCompactionOverlappingLevels is the minimum number of levels
+		// whose sstables must contain a sampled key for the read to count
+		// against the AllowedSeeks of the topmost of those sstables. Raising
+		// it restricts read triggered compactions to key ranges where reads
+		// repeatedly touch many overlapping files. Values below 2 are
+		// treated as 2, the default.
+		ReadCompactionOverlappingLevels int
+
 		// ReadSamplingMultiplier is a multiplier for the readSamplingPeriod in
 		// iterator.maybeSampleRead() to control the frequency of read sampling
 		// to trigger a read triggered compaction. A value of -1 prevents sampling
@@ -608,6 +864,39 @@ type Options struct {
 		// compaction will never get triggered.
 		MultiLevelCompactionHueristic MultiLevelHeuristic
 
+		// MaxMemTableSize enables adaptive memtable sizing when it is larger
+		// than MemTableSize. The size of each new memtable then varies between
+		// MemTableSize and MaxMemTableSize: it grows while memtables fill up
+		// quickly or while flushes are falling behind, and shrinks once
+		// memtables take a long time to fill up. The memtable size at which
+		// writes are stalled (see MemTableStopWritesThreshold) scales with the
+		// current memtable size, so memtables may use up to
+		// MemTableStopWritesThreshold*MaxMemTableSize bytes of memory.
+		MaxMemTableSize int
+
+		// MemTableKind selects the data structure used to index the point keys
+		// of memtables. The default is MemTableSkiplist.
+		MemTableKind MemTableKind
+
+		// ImmutableMemTableFilterBitsPerKey, if positive, enables building a
+		// bloom filter over the key prefixes (as defined by Comparer.Split) of
+		// each memtable once it becomes immutable, with the given number of
+		// bits per prefix. The filter is built in the background while the
+		// memtable waits to be flushed, and lets point lookups and prefix
+		// seeks that miss skip the memtable's skiplist. It is most useful when
+		// many immutable memtables are queued for flushing during write
+		// bursts. A value of 10 yields a false positive rate of about 1%.
+		ImmutableMemTableFilterBitsPerKey int
+
+		// MemTableApplyConcurrency is the maximum number of goroutines used to
+		// insert the entries of a single large batch into the memtable. Batches
+		// committed concurrently are always applied to the memtable
+		// concurrently; this option additionally splits a batch with many
+		// entries into chunks that are inserted in parallel, so that applying
+		// a large batch is not limited to a single core. Values of 1 or less
+		// apply each batch from the committing goroutine, the default.
+		MemTableApplyConcurrency int
+
 		// MaxWriterConcurrency is used to indicate the maximum number of
 		// compression workers the compression queue is allowed to use. If
 		// MaxWriterConcurrency > 0, then the Writer will use parallelism, to
@@ -660,11 +949,34 @@ type Options struct {
 		// in value blocks.
 		RequiredInPlaceValueBound UserKeyPrefixBound
 
+		// ColumnarSchema, if set, is the fixed schema of the values of the
+		// DB, with which the data blocks of sstables are stored in a
+		// columnar encoding that stores the keys and each column of the
+		// values separately, improving compression. Blocks are decoded back
+		// to the row-oriented layout when read, so this is a compression
+		// option only. See sstable.ColumnarSchema.
+		// sstables with columnar data blocks cannot be read by versions of
+		// Pebble predating them, so ColumnarSchema requires a
+		// FormatMajorVersion of at least ExperimentalFormatBlockExtensions.
+		ColumnarSchema *ColumnarSchema
+
 		// DisableIngestAsFlushable disables lazy ingestion of sstables through
 		// a WAL write and memtable rotation. Only effectual if the the format
 		// major version is at least `FormatFlushableIngest`.
 		DisableIngestAsFlushable func() bool
 
+		// IngestAsBatchMaxSize, if positive, is the total size in bytes of the
+		// sstables of an ingestion at or below which sstables that overlap the
+		// memtables are applied to the memtable as a batch, rather than ingested
+		// into L0 (or as a flushable) after a flush of the overlapping memtables.
+		// This reduces the number of L0 files created by workloads that ingest
+		// many small sstables. Ingestions containing range keys are never
+		// applied as a batch. The applied keys are written to the WAL, and
+		// receive sequence numbers in the order of the sstables' keys, with range
+		// deletions ordered before point keys so that range deletions do not
+		// delete the point keys of the same ingestion.
+		IngestAsBatchMaxSize int64
+
 		// RemoteStorage enables use of remote storage (e.g. S3) for storing
 		// sstables. Setting this option enables use of CreateOnShared option and
 		// allows ingestion of external files.
@@ -680,9 +992,147 @@ type Options struct {
 		CreateOnShared        bool
 		CreateOnSharedLocator remote.Locator
 
+		// CreateOnSharedMinLevel, if CreateOnShared is true, restricts the
+		// creation of sstables on remote storage to flush and compaction
+		// outputs written into levels at or below this level. Sstables written
+		// into higher levels are created on local disk. Setting it to 6 tiers
+		// the bottommost level onto remote storage while the frequently
+		// rewritten upper levels remain local. Sstables that would move from a
+		// local level into a remote level are rewritten instead. If zero, all
+		// sstables are created on remote storage.
+		CreateOnSharedMinLevel int
+
 		// CacheSizeBytesBytes is the size of the on-disk block cache for objects
 		// on shared storage in bytes. If it is 0, no cache is used.
 		SecondaryCacheSizeBytes int64
+
+		// SecondaryCacheWriteMode configures whether sstables created on remote
+		// storage are added to the secondary cache as they are written. The
+		// default, SecondaryCacheWriteAround, only adds data read from remote
+		// storage. Metrics.SecondaryCache and the SecondaryCache metrics of
+		// each level report the resulting hit rates.
+		SecondaryCacheWriteMode SecondaryCacheWriteMode
+
+		// WarmSecondaryCacheOnIngest, if true, reads the remote sstables of
+		// ingestions, such as the shared sstables of DB.IngestAndExcise, into
+		// the secondary cache in the background after they are ingested, as
+		// DB.WarmSecondaryCache does. It has no effect if
+		// SecondaryCacheSizeBytes is zero.
+		WarmSecondaryCacheOnIngest bool
+
+		// SecondaryCacheWarmRate, if positive, limits the rate in bytes per
+		// second at which WarmSecondaryCacheOnIngest reads sstables from remote
+		// storage.
+		SecondaryCacheWarmRate int64
+
+		// RemoteCompactor, if set, is used to run compactions whose inputs all
+		// reside on shared storage in an external worker process. The outputs
+		// are installed into this DB once the worker completes. Compactions
+		// that are not eligible for offloading, or for which the remote
+		// compactor returns an error, are run locally. Requires RemoteStorage
+		// and CreateOnShared to be set.
+		RemoteCompactor RemoteCompactor
+
+		// Tiering configures the automatic demotion of sstables in the
+		// bottommost level that go unread onto remote storage, and their
+		// promotion back onto local storage once they are read again. Requires
+		// RemoteStorage to be set. See TieringOptions.
+		Tiering TieringOptions
+
+		// ValueSeparation configures the separation of large values into blob
+		// files, which reduces the write amplification of workloads with large
+		// values. Requires ExperimentalFormatBlobFiles. Blob files aren't
+		// encrypted, so value separation cannot be combined with
+		// TableKeyManager. See ValueSeparationOptions.
+		ValueSeparation ValueSeparationOptions
+
+		// DeletePredicates is the set of predicates that may be referenced by
+		// name from DB.DeleteRangeIf.
+		DeletePredicates []*DeletePredicate
+
+		// WriteAdmission holds the write admission thresholds for each
+		// WritePriority, indexed by priority. Writes of priorities lower than
+		// WritePriorityUser are delayed once the LSM reaches their thresholds,
+		// ahead of the write stalls that delay all writes. The entry for
+		// WritePriorityUser is ignored. If L0ReadAmp is not set, it defaults to
+		// 3/4 of L0StopWritesThreshold for WritePriorityBackground and 1/2 of
+		// L0StopWritesThreshold for WritePriorityBulk, and it is never lower
+		// than L0CompactionThreshold. The thresholds of a priority are clamped
+		// to be no higher than those of the priorities above it. Delayed writes
+		// wait until flushes and compactions bring the LSM under the
+		// thresholds, which may never happen if automatic compactions are
+		// disabled, unless Options.MaxWriteStallDuration bounds the wait.
+		WriteAdmission [NumWritePriorities]WriteAdmissionThresholds
+
+		// MaxConcurrentCommits bounds the number of batches that may be in the
+		// commit pipeline concurrently, which is also the maximum number of
+		// batches whose WAL writes may be grouped into a single WAL sync. It is
+		// a count of batches, not a size in bytes: the size of a sync group is
+		// not bounded. Lowering it trades throughput under high write
+		// concurrency for lower tail latency of individual commits. If zero or
+		// greater than the capacity of the commit pipeline, it defaults to that
+		// capacity (currently 4095).
+		MaxConcurrentCommits int
+
+		// EnableCommitMetrics enables recording the latencies of the stages of
+		// the commit pipeline in Metrics.Commit. Recording them adds timing
+		// calls to every commit, which measurably slows down the commit
+		// pipeline under high write concurrency, so they are disabled by
+		// default.
+		EnableCommitMetrics bool
+
+		// EnableDiskOpMetrics enables recording the latencies of the
+		// filesystem operations of the DB (reads, writes, syncs, creations and
+		// removals of files) in Metrics.DiskOps. Recording them adds timing
+		// calls to every filesystem operation, including every read of an
+		// sstable block that misses the block cache, so they are disabled by
+		// default.
+		EnableDiskOpMetrics bool
+
+		// DiskOpTraceThreshold, if positive, makes each filesystem operation of
+		// the DB that takes at least DiskOpTraceThreshold invoke
+		// EventListener.DiskOpTrace. Unlike EventListener.DiskSlow, which is
+		// invoked while a write or sync is stuck, the trace event is emitted
+		// when the operation completes, and reads are traced too.
+		DiskOpTraceThreshold time.Duration
+
+		// DirectIOReads and DirectIOWrites enable direct I/O (O_DIRECT on
+		// Linux) for reads of local sstables and for writes of the sstables
+		// output by flushes and compactions. Direct I/O bypasses the OS page
+		// cache, avoiding caching blocks twice when the block cache is sized to
+		// the machine's memory; reads that miss the block cache always go to
+		// disk though, so it's only beneficial with a large block cache. Direct
+		// I/O is ignored where it isn't supported (e.g. by vfs.NewMem or on
+		// platforms other than Linux).
+		DirectIOReads  bool
+		DirectIOWrites bool
+
+		// IOUringReads makes reads of local sstables use io_uring on Linux:
+		// block reads are submitted to io_uring rather than performed with
+		// pread, forward scans prefetch the following data blocks into the block
+		// cache with batches of reads submitted together, and the read-ahead of
+		// compactions is performed asynchronously with io_uring instead of
+		// relying on OS read-ahead. It's ignored where io_uring isn't
+		// supported (on other platforms, on Linux kernels older than 5.6, or
+		// where io_uring is disabled).
+		IOUringReads bool
+
+		// MmapReads makes reads of local sstables copy from read-only memory
+		// mappings of the files instead of using pread, with madvise hints
+		// driven by the readahead of iterators and compactions. On fast local
+		// NVMe devices, avoiding a syscall per block read measurably reduces CPU
+		// usage. An I/O error while reading a mapped file crashes the process
+		// (SIGBUS) rather than being returned, and the mappings count against
+		// the process's virtual memory. It's ignored for sstables read with
+		// direct I/O (see DirectIOReads) and where mmap isn't supported (e.g. by
+		// vfs.NewMem or on platforms other than Linux).
+		MmapReads bool
+
+		// DegradedMode configures the detection of a failing disk, upon which
+		// the DB enters a read-only degraded mode rather than wedging writers
+		// on the disk. Detection is disabled by default, and is disabled for
+		// read-only DBs. See DegradedModeOptions.
+		DegradedMode DegradedModeOptions
 	}
 
 	// Filters is a map from filter policy name to filter policy. It is used for
@@ -766,6 +1216,14 @@ type Options struct {
 
 	// Per-level options. Options for at least one level must be specified. The
 	// options for the last level are used for all subsequent levels.
+	//
+	// Flushes write sstables using the options for L0, and compactions write
+	// sstables using the options for their output level. This allows, for
+	// example, using a cheap compression algorithm and small blocks in the
+	// upper levels, where data is rewritten frequently, and ZSTD compression
+	// and larger blocks in the lower levels, which hold most of the data. A
+	// compaction that would otherwise move an sstable to a level configured
+	// with a different compression or block size rewrites the sstable instead.
 	Levels []LevelOptions
 
 	// LoggerAndTracer will be used, if non-nil, else Logger will be used and
@@ -805,6 +1263,116 @@ type Options struct {
 	// or writes will stop whenever a MemTable is being flushed.
 	MemTableStopWritesThreshold int
 
+	// MaxWriteStallDuration bounds the time a write waits for a write stall
+	// (see EventListener.WriteStallBegin) to end. A write that arrives while a
+	// stall is in progress and is still waiting after MaxWriteStallDuration
+	// returns an error wrapping ErrWriteStallTimeout instead of blocking. It
+	// also bounds the time a write of a priority below WritePriorityUser waits
+	// to be admitted (see Options.Experimental.WriteAdmission).
+	//
+	// The bound only applies to writes that observe the stall before entering
+	// the commit pipeline. The following writes wait for the stall to end
+	// without bound: the write whose memtable rotation triggers the stall,
+	// writes already waiting in the commit pipeline when the stall begins, and
+	// writes that checked for a stall just before it began.
+	//
+	// The default value is 0, which places no bound on stall time.
+	MaxWriteStallDuration time.Duration
+
+	// DiskSpaceSoftLimit is the free disk space, in bytes, below which low
+	// priority compactions (read-triggered compactions and manual compactions
+	// of ManualCompactionPriorityLow) are paused, so that the remaining space
+	// is kept for flushes and for the compactions that reclaim space.
+	// EventListener.DiskSpace is invoked whenever the free disk space crosses
+	// this limit or DiskSpaceHardLimit.
+	//
+	// The default value is 0, which disables the soft limit.
+	DiskSpaceSoftLimit uint64
+
+	// DiskSpaceHardLimit is the free disk space, in bytes, below which writes
+	// fail fast with an error wrapping ErrDiskFull, rather than letting the
+	// OS fail a WAL write in the middle of a commit. Flushes and compactions
+	// continue to run, and writes are accepted again once compactions or
+	// file deletions free enough space. It must not exceed DiskSpaceSoftLimit, if set.
+	//
+	// The free disk space is refreshed every second, and whenever a flush or
+	// compaction completes, so writes may overshoot the limit in between.
+	//
+	// The default value is 0, which disables the hard limit.
+	DiskSpaceHardLimit uint64
+
+	// CompactionFilter is invoked for the point keys rewritten by compactions
+	// and may remove them or change their values. See CompactionFilter for
+	// the restrictions on when the filter is invoked.
+	//
+	// The default value is nil, which retains all keys.
+	CompactionFilter CompactionFilter
+
+	// CompactionPolicy, if set, is consulted before pebble's built-in
+	// heuristics when picking automatic compactions, and may replace or
+	// augment them. See CompactionPolicy.
+	//
+	// The default value is nil, which uses the built-in heuristics.
+	CompactionPolicy CompactionPolicy
+
+	// PeriodicCompactionInterval is the maximum time an sstable in L1 or below
+	// may go without being rewritten by a compaction. Older sstables are
+	// compacted into the next level, or rewritten in place in the bottommost
+	// level, so that changes that only take effect when a file is rewritten
+	// (such as new filter policies or compression, or the elision of deleted
+	// keys) eventually apply to data that is no longer written to. The age of
+	// an sstable is measured from its creation, and is not reset when a
+	// compaction moves it to another level without rewriting it. Sstables
+	// whose creation time is not recorded in the MANIFEST are never
+	// considered stale.
+	//
+	// The default value is 0, which disables periodic compactions.
+	PeriodicCompactionInterval time.Duration
+
+	// CompactionWriteRateLimit is the rate, in bytes per second, at which
+	// flushes and compactions write sstables. The limit is shared by all
+	// concurrent flushes and compactions, and may be changed while the DB is
+	// open using DB.SetCompactionWriteRateLimit. Throttling flushes slows down
+	// the rate at which memtables are freed, so a low limit may cause write
+	// stalls.
+	//
+	// The default value is 0, which does not limit the rate of writes.
+	CompactionWriteRateLimit int64
+
+	// IOScheduler, if set, grants disk bandwidth to sstable reads and writes
+	// by IO class, in place of CompactionWriteRateLimit. Flushes and
+	// compactions acquire bandwidth for the sstables they write, and
+	// iterators, Gets and compactions for the sstable blocks they read from
+	// storage. Blocks found in the block cache do not acquire bandwidth. See
+	// NewFairIOScheduler for a scheduler that divides the bandwidth between
+	// IO classes by weights and starves background IO while foreground reads
+	// are slow, and NewBandwidthScheduler for a scheduler that reserves a
+	// minimum share of the bandwidth for each IO class.
+	IOScheduler IOScheduler
+
+	// MemoryMonitor, if set, is notified of the memory reserved and released
+	// by the memtables, block cache and table cache of the DB, so that an
+	// embedder may enforce a process-wide memory budget. An embedder over
+	// budget may ask the DB to release memory with DB.ShrinkMemory.
+	MemoryMonitor MemoryMonitor
+
+	// TombstoneDensityCompactionThreshold is the fraction of an sstable's
+	// entries that must be point or range tombstones for the sstable to be
+	// compacted into the next level, so that reads over heavily-deleted key
+	// ranges stop paying the cost of skipping the tombstones. Only sstables in
+	// L1 through L5 with at least 1000 tombstones are considered. Tombstones
+	// in the bottommost level are removed by elision-only compactions instead.
+	//
+	// The default value is 0, which disables tombstone density compactions.
+	TombstoneDensityCompactionThreshold float64
+
+	// TTL configures the expiration of keys based on a timestamp extracted
+	// from each key-value pair, and compactions that promptly reclaim the
+	// space used by expired keys. See TTLOptions.
+	//
+	// The default value leaves keys to never expire.
+	TTL TTLOptions
+
 	// Merger defines the associative merge operation to use for merging values
 	// written with {Batch,DB}.Merge.
 	//
@@ -835,12 +1403,32 @@ type Options struct {
 	// to keep one older manifest.
 	NumPrevManifest int
 
+	// PrefixExtractor extracts the prefixes of keys added to and looked up in
+	// table filters, in place of Comparer.Split. Filters built over the
+	// prefixes of the extractor are consulted by SeekPrefixGE, and by SeekGE
+	// when the sought key and the iterator's upper bound have the same
+	// extracted prefix, which allows a scan of an extracted prefix to skip the
+	// sstables that do not contain it. Changing the extractor disables the
+	// filters of the sstables written with the previous extractor until they
+	// are compacted.
+	//
+	// The default value means filters are built over the prefixes returned by
+	// Comparer.Split.
+	PrefixExtractor *PrefixExtractor
+
 	// ReadOnly indicates that the DB should be opened in read-only mode. Writes
 	// to the DB will return an error, background compactions are disabled, and
 	// the flush that normally occurs after replaying the WAL at startup is
 	// disabled.
 	ReadOnly bool
 
+	// SecondaryCatchUpInterval is the interval at which a DB opened by
+	// OpenSecondary catches up with its primary (see DB.CatchUpWithPrimary).
+	// A value of 0 selects the default of 1 second, and a negative value
+	// disables the periodic catch-ups, which then only happen through calls
+	// to DB.CatchUpWithPrimary. It's ignored by the DBs opened by Open.
+	SecondaryCatchUpInterval time.Duration
+
 	// TableCache is an initialized TableCache which should be set as an
 	// option if the DB needs to be initialized with a pre-existing table cache.
 	// If TableCache is nil, then a table cache which is unique to the DB instance
@@ -849,9 +1437,26 @@ type Options struct {
 	// and pebble will panic otherwise.
 	TableCache *TableCache
 
+	// TableKeyManager, if set, encrypts the blocks of new sstables with
+	// AES-GCM, using a data key generated for each sstable that is stored in
+	// the sstable wrapped by the key manager. Unlike encryption by a vfs.FS
+	// wrapper, this protects sstables placed on shared or remote storage.
+	// Encrypted sstables can only be read with a key manager able to unwrap
+	// their data keys, while unencrypted sstables, such as those written
+	// before it was set, remain readable. Blob files are not encrypted, so
+	// TableKeyManager cannot be combined with Experimental.ValueSeparation.
+	// TableKeyManager requires a FormatMajorVersion of at least
+	// ExperimentalFormatBlockExtensions. See sstable.KeyManager. The default is
+	// to not encrypt sstables.
+	TableKeyManager TableKeyManager
+
 	// TablePropertyCollectors is a list of TablePropertyCollector creation
 	// functions. A new TablePropertyCollector is created for each sstable built
-	// and lives for the lifetime of the table.
+	// and lives for the lifetime of the table. The properties collected for
+	// each sstable are returned by DB.SSTables with the WithProperties option,
+	// and collectors implementing AggregatingTablePropertyCollector may have
+	// their properties aggregated over a key range by
+	// DB.AggregateTableProperties.
 	TablePropertyCollectors []func() TablePropertyCollector
 
 	// BlockPropertyCollectors is a list of BlockPropertyCollector creation
@@ -870,16 +1475,92 @@ type Options struct {
 	// default behaviour in RocksDB.
 	WALBytesPerSync int
 
+	// WALPreallocateSize is the number of bytes preallocated for each WAL file
+	// when it is created (e.g. using fallocate), which avoids updating the file
+	// metadata on every sync of a growing WAL file. A value of 0 selects the
+	// default of 110% of MemTableSize. A negative value disables preallocation,
+	// for filesystems on which preallocation is slow or unsupported.
+	WALPreallocateSize int
+
+	// DisableWALRecycling disables the recycling of obsolete WAL files, which
+	// are then deleted. Recycling reuses an obsolete WAL file for a new WAL,
+	// which is faster to sync than a new file on most filesystems, but
+	// retains disk space and may be slower on copy-on-write filesystems.
+	DisableWALRecycling bool
+
+	// WALRecycleLimit is the maximum number of obsolete WAL files retained for
+	// recycling. A value of 0 selects the default of
+	// MemTableStopWritesThreshold+1, which is the most WAL files that may be
+	// created before any of them becomes obsolete.
+	WALRecycleLimit int
+
+	// MaxUnflushedWALBytes caps the size of the WAL files holding data that
+	// has not been flushed yet, which otherwise grows without bound while
+	// flushes are slow or stalled. Once the un-flushed WAL bytes reach half of
+	// the cap, the memtables are flushed. Beyond three quarters of the cap,
+	// writes are delayed, increasingly as the cap is approached, and writes
+	// stall once the cap is reached (see WriteStallWALQuota) until flushes
+	// bring the un-flushed WAL bytes back below the cap, or for at most
+	// MaxWriteStallDuration, if set. A large batch written while below the
+	// cap may overshoot it.
+	//
+	// The default value is 0, which places no cap on the un-flushed WAL bytes.
+	MaxUnflushedWALBytes int64
+
 	// WALDir specifies the directory to store write-ahead logs (WALs) in. If
 	// empty (the default), WALs will be stored in the same directory as sstables
 	// (i.e. the directory passed to pebble.Open).
 	WALDir string
 
+	// WALArchive configures the archiving of obsolete WAL files into a
+	// directory or through a callback, in place of their deletion. See
+	// WALArchiveOptions. The default is to not archive WAL files.
+	WALArchive WALArchiveOptions
+
+	// SubscriptionBufferSize is the maximum size of the committed batches
+	// buffered in memory while subscriptions to the committed batches are open
+	// (see DB.Subscribe). Subscriptions that fall behind the buffer read the
+	// batches from the WAL. A value of 0 selects the default of 16MB. A
+	// negative value disables the buffering, so that subscriptions always read
+	// the batches from the WAL.
+	SubscriptionBufferSize int64
+
+	// WALKeyManager, if set, encrypts the records of the WAL with AES-GCM using
+	// the keys it provides. Each WAL file is encrypted with the current key of
+	// the key manager when the WAL file is created, so keys are rotated at WAL
+	// file boundaries. Encrypted WAL files can only be replayed with a key
+	// manager providing their keys. See WALKeyManager. The default is to not
+	// encrypt the WAL.
+	WALKeyManager WALKeyManager
+
+	// WALRecordCommitTimes records the wall-clock commit times of batches in
+	// the WAL, with millisecond granularity, which allows WALReplayTarget to
+	// stop replay at a point in time. The commit times are written as records
+	// that versions of Pebble predating this option mistake for corrupt
+	// batches, so those versions cannot replay WALs written with this option
+	// set, and it should only be set once downgrading to such a version is
+	// no longer possible.
+	WALRecordCommitTimes bool
+
+	// WALRecoveryMode controls the behavior of Open when the replay of the WAL
+	// encounters a corrupt record. See WALRecoveryMode. The default is
+	// WALRecoveryTolerateCorruptTail.
+	WALRecoveryMode WALRecoveryMode
+
+	// WALReplayTarget, if set, stops the replay of the WAL by Open at a
+	// sequence number or commit time, for point-in-time recovery. See
+	// WALReplayTarget. The default is to replay the entire WAL.
+	WALReplayTarget WALReplayTarget
+
 	// WALMinSyncInterval is the minimum duration between syncs of the WAL. If
 	// WAL syncs are requested faster than this interval, they will be
 	// artificially delayed. Introducing a small artificial delay (500us) between
 	// WAL syncs can allow more operations to arrive and reduce IO operations
-	// while having a minimal impact on throughput. This option is supplied as a
+	// while having a minimal impact on throughput. It is effectively the
+	// group commit window: synced commits arriving within the interval share a
+	// single WAL sync (see also Experimental.MaxConcurrentCommits). Writes to
+	// the WAL are always coalesced by the WAL's flush loop, which writes all of
+	// the blocks that are pending when it wakes. This option is supplied as a
 	// closure in order to allow the value to be changed dynamically. The default
 	// value is 0.
 	//
@@ -887,6 +1568,16 @@ type Options struct {
 	// changing options dynamically?
 	WALMinSyncInterval func() time.Duration
 
+	// WALSyncDeadline bounds the duration a synced commit is deferred by
+	// WALMinSyncInterval: once a synced commit has waited for WALSyncDeadline,
+	// the WAL is synced even if the min sync interval has not elapsed yet. It
+	// bounds the commit latency added by the group commit window, so that
+	// operators can choose a WALMinSyncInterval that reduces the syncs issued
+	// to IOPS-limited disks without exceeding a commit latency budget. This
+	// option is supplied as a closure in order to allow the value to be changed
+	// dynamically. The default is to not bound the deferral.
+	WALSyncDeadline func() time.Duration
+
 	// TargetByteDeletionRate is the rate (in bytes per second) at which sstable file
 	// deletions are limited to (under normal circumstances).
 	//
@@ -901,6 +1592,29 @@ type Options struct {
 	// Setting this to 0 disables deletion pacing, which is also the default.
 	TargetByteDeletionRate int
 
+	// HolePunchDeletion configures the incremental deallocation of large
+	// obsolete sstables before they are deleted. Unlinking a multi-GB file
+	// makes the filesystem free all its extents at once, which can spike the
+	// latency of concurrent reads. Instead, the sstables of at least
+	// MinFileSize bytes are deallocated by punching holes in them (see
+	// vfs.PunchHole), at most BytesPerSec bytes per second, and are unlinked
+	// once empty.
+	//
+	// Hole punching only applies to local sstables deleted with the
+	// DeleteCleaner that have no other hard link (like a checkpoint's), and
+	// only on filesystems supporting it; other sstables are unlinked directly.
+	// Hole punching is abandoned when the DB is closed.
+	//
+	// The default value of BytesPerSec is 0, which disables hole punching.
+	HolePunchDeletion struct {
+		// MinFileSize is the size, in bytes, of the smallest sstable that is
+		// deallocated by hole punching.
+		MinFileSize int64
+		// BytesPerSec is the rate, in bytes per second, at which sstables are
+		// deallocated.
+		BytesPerSec int64
+	}
+
 	// private options are only used by internal tests or are used internally
 	// for facilitating upgrade paths of unconfigurable functionality.
 	private struct {
@@ -934,6 +1648,10 @@ type Options struct {
 		// A private option to disable stats collection.
 		disableTableStats bool
 
+		// secondary is set for the DBs opened by OpenSecondary, which are
+		// read-only and tail the store of a primary DB.
+		secondary bool
+
 		// fsCloser holds a closer that should be invoked after a DB using these
 		// Options is closed. This is used to automatically stop the
 		// long-running goroutine associated with the disk-health-checking FS.
@@ -1060,6 +1778,18 @@ func (o *Options) EnsureDefaults() *Options {
 	if o.NumPrevManifest <= 0 {
 		o.NumPrevManifest = 1
 	}
+	if o.TTL.CheckInterval <= 0 {
+		o.TTL.CheckInterval = time.Minute
+	}
+	if o.Experimental.Tiering.CheckInterval <= 0 {
+		o.Experimental.Tiering.CheckInterval = time.Minute
+	}
+	if o.Experimental.ValueSeparation.TargetBlobFileSize <= 0 {
+		o.Experimental.ValueSeparation.TargetBlobFileSize = 128 << 20
+	}
+	if o.Experimental.ValueSeparation.MinLiveRatio == 0 {
+		o.Experimental.ValueSeparation.MinLiveRatio = 0.5
+	}
 
 	if o.FormatMajorVersion == FormatDefault {
 		o.FormatMajorVersion = FormatMostCompatible
@@ -1089,6 +1819,7 @@ func (o *Options) EnsureDefaults() *Options {
 	if o.Experimental.MultiLevelCompactionHueristic == nil {
 		o.Experimental.MultiLevelCompactionHueristic = NoMultiLevel{}
 	}
+	o.ensureWriteAdmissionDefaults()
 
 	o.initMaps()
 	return o
@@ -1152,6 +1883,47 @@ func (o *Options) Level(level int) LevelOptions {
 	return l
 }
 
+// tableDirs returns the directories other than dirname in which sstables are
+// created, as configured by LevelOptions.Dir.
+func (o *Options) tableDirs(dirname string) []string {
+	var dirs []string
+	seen := make(map[string]bool)
+	for level := 0; level < numLevels; level++ {
+		dir := o.tableDir(dirname, level)
+		if dir != "" && !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// tableDir returns the directory in which sstables output to the specified
+// level are created, as configured by LevelOptions.Dir. It returns "" for the
+// DB directory.
+func (o *Options) tableDir(dirname string, level int) string {
+	if dir := o.Level(level).Dir; dir != dirname {
+		return dir
+	}
+	return ""
+}
+
+// preferSharedStorage returns true if sstables written into the specified
+// level should be created on shared storage. See
+// Experimental.CreateOnSharedMinLevel.
+func (o *Options) preferSharedStorage(level int) bool {
+	return o.Experimental.CreateOnShared && level >= o.Experimental.CreateOnSharedMinLevel
+}
+
+// walRecycleLimit returns the maximum number of obsolete WAL files retained
+// for recycling. See WALRecycleLimit.
+func (o *Options) walRecycleLimit() int {
+	if o.WALRecycleLimit > 0 {
+		return o.WALRecycleLimit
+	}
+	return o.MemTableStopWritesThreshold + 1
+}
+
 // Clone creates a shallow-copy of the supplied options.
 func (o *Options) Clone() *Options {
 	n := &Options{}
@@ -1180,19 +1952,49 @@ func (o *Options) String() string {
 	fmt.Fprintf(&buf, "  pebble_version=0.1\n")
 	fmt.Fprintf(&buf, "\n")
 	fmt.Fprintf(&buf, "[Options]\n")
+	if o.BlockChecksum != 0 && o.BlockChecksum != ChecksumTypeCRC32c {
+		fmt.Fprintf(&buf, "  block_checksum=%s\n", o.BlockChecksum)
+	}
 	fmt.Fprintf(&buf, "  bytes_per_sync=%d\n", o.BytesPerSync)
+	if o.CacheQuota != 0 {
+		fmt.Fprintf(&buf, "  cache_quota=%d\n", o.CacheQuota)
+	}
 	fmt.Fprintf(&buf, "  cache_size=%d\n", cacheSize)
 	fmt.Fprintf(&buf, "  cleaner=%s\n", o.Cleaner)
 	fmt.Fprintf(&buf, "  compaction_debt_concurrency=%d\n", o.Experimental.CompactionDebtConcurrency)
+	if o.CompactionWriteRateLimit != 0 {
+		fmt.Fprintf(&buf, "  compaction_write_rate_limit=%d\n", o.CompactionWriteRateLimit)
+	}
 	fmt.Fprintf(&buf, "  comparer=%s\n", o.Comparer.Name)
+	if o.Experimental.DirectIOReads {
+		fmt.Fprintf(&buf, "  direct_io_reads=%t\n", o.Experimental.DirectIOReads)
+	}
+	if o.Experimental.DirectIOWrites {
+		fmt.Fprintf(&buf, "  direct_io_writes=%t\n", o.Experimental.DirectIOWrites)
+	}
 	fmt.Fprintf(&buf, "  disable_wal=%t\n", o.DisableWAL)
 	if o.Experimental.DisableIngestAsFlushable != nil && o.Experimental.DisableIngestAsFlushable() {
 		fmt.Fprintf(&buf, "  disable_ingest_as_flushable=%t\n", true)
 	}
+	if o.DiskSpaceHardLimit != 0 {
+		fmt.Fprintf(&buf, "  disk_space_hard_limit=%d\n", o.DiskSpaceHardLimit)
+	}
+	if o.DiskSpaceSoftLimit != 0 {
+		fmt.Fprintf(&buf, "  disk_space_soft_limit=%d\n", o.DiskSpaceSoftLimit)
+	}
 	fmt.Fprintf(&buf, "  flush_delay_delete_range=%s\n", o.FlushDelayDeleteRange)
 	fmt.Fprintf(&buf, "  flush_delay_range_key=%s\n", o.FlushDelayRangeKey)
 	fmt.Fprintf(&buf, "  flush_split_bytes=%d\n", o.FlushSplitBytes)
 	fmt.Fprintf(&buf, "  format_major_version=%d\n", o.FormatMajorVersion)
+	if o.Experimental.ImmutableMemTableFilterBitsPerKey != 0 {
+		fmt.Fprintf(&buf, "  immutable_mem_table_filter_bits_per_key=%d\n", o.Experimental.ImmutableMemTableFilterBitsPerKey)
+	}
+	if o.Experimental.IngestAsBatchMaxSize != 0 {
+		fmt.Fprintf(&buf, "  ingest_as_batch_max_size=%d\n", o.Experimental.IngestAsBatchMaxSize)
+	}
+	if o.Experimental.IOUringReads {
+		fmt.Fprintf(&buf, "  io_uring_reads=%t\n", o.Experimental.IOUringReads)
+	}
 	fmt.Fprintf(&buf, "  l0_compaction_concurrency=%d\n", o.Experimental.L0CompactionConcurrency)
 	fmt.Fprintf(&buf, "  l0_compaction_file_threshold=%d\n", o.L0CompactionFileThreshold)
 	fmt.Fprintf(&buf, "  l0_compaction_threshold=%d\n", o.L0CompactionThreshold)
@@ -1203,13 +2005,51 @@ func (o *Options) String() string {
 	}
 	fmt.Fprintf(&buf, "  max_concurrent_compactions=%d\n", o.MaxConcurrentCompactions())
 	fmt.Fprintf(&buf, "  max_manifest_file_size=%d\n", o.MaxManifestFileSize)
+	if o.Experimental.MaxMemTableSize != 0 {
+		fmt.Fprintf(&buf, "  max_mem_table_size=%d\n", o.Experimental.MaxMemTableSize)
+	}
 	fmt.Fprintf(&buf, "  max_open_files=%d\n", o.MaxOpenFiles)
+	if o.MaxWriteStallDuration != 0 {
+		fmt.Fprintf(&buf, "  max_write_stall_duration=%s\n", o.MaxWriteStallDuration)
+	}
+	if o.Experimental.MemTableApplyConcurrency != 0 {
+		fmt.Fprintf(&buf, "  mem_table_apply_concurrency=%d\n", o.Experimental.MemTableApplyConcurrency)
+	}
+	if o.Experimental.MemTableKind != MemTableSkiplist {
+		fmt.Fprintf(&buf, "  mem_table_kind=%s\n", o.Experimental.MemTableKind)
+	}
 	fmt.Fprintf(&buf, "  mem_table_size=%d\n", o.MemTableSize)
 	fmt.Fprintf(&buf, "  mem_table_stop_writes_threshold=%d\n", o.MemTableStopWritesThreshold)
 	fmt.Fprintf(&buf, "  min_deletion_rate=%d\n", o.TargetByteDeletionRate)
+	if o.HolePunchDeletion.BytesPerSec != 0 {
+		fmt.Fprintf(&buf, "  hole_punch_deletion_bytes_per_sec=%d\n", o.HolePunchDeletion.BytesPerSec)
+		fmt.Fprintf(&buf, "  hole_punch_deletion_min_file_size=%d\n", o.HolePunchDeletion.MinFileSize)
+	}
 	fmt.Fprintf(&buf, "  merger=%s\n", o.Merger.Name)
+	if o.Experimental.MmapReads {
+		fmt.Fprintf(&buf, "  mmap_reads=%t\n", o.Experimental.MmapReads)
+	}
+	if o.PeriodicCompactionInterval != 0 {
+		fmt.Fprintf(&buf, "  periodic_compaction_interval=%s\n", o.PeriodicCompactionInterval)
+	}
+	if o.PinTopLevelIndexBlocks {
+		fmt.Fprintf(&buf, "  pin_top_level_index_blocks=%t\n", o.PinTopLevelIndexBlocks)
+	}
+	if o.PrefixExtractor != nil {
+		fmt.Fprintf(&buf, "  prefix_extractor=%s\n", o.PrefixExtractor.Name)
+	}
+
+	if o.Experimental.ReadCompactionOverlappingLevels != 0 {
+		fmt.Fprintf(&buf, "  read_compaction_overlapping_levels=%d\n", o.Experimental.ReadCompactionOverlappingLevels)
+	}
 	fmt.Fprintf(&buf, "  read_compaction_rate=%d\n", o.Experimental.ReadCompactionRate)
 	fmt.Fprintf(&buf, "  read_sampling_multiplier=%d\n", o.Experimental.ReadSamplingMultiplier)
+	if o.RowCacheSize != 0 {
+		fmt.Fprintf(&buf, "  row_cache_size=%d\n", o.RowCacheSize)
+	}
+	if o.Experimental.SnapshotElisionConcurrency != 0 {
+		fmt.Fprintf(&buf, "  snapshot_elision_concurrency=%d\n", o.Experimental.SnapshotElisionConcurrency)
+	}
 	fmt.Fprintf(&buf, "  strict_wal_tail=%t\n", o.private.strictWALTail)
 	fmt.Fprintf(&buf, "  table_cache_shards=%d\n", o.Experimental.TableCacheShards)
 	fmt.Fprintf(&buf, "  table_property_collectors=[")
@@ -1222,12 +2062,52 @@ func (o *Options) String() string {
 		fmt.Fprintf(&buf, "%s", o.TablePropertyCollectors[i]().Name())
 	}
 	fmt.Fprintf(&buf, "]\n")
+	if o.TombstoneDensityCompactionThreshold != 0 {
+		fmt.Fprintf(&buf, "  tombstone_density_compaction_threshold=%s\n",
+			strconv.FormatFloat(o.TombstoneDensityCompactionThreshold, 'g', -1, 64))
+	}
 	fmt.Fprintf(&buf, "  validate_on_ingest=%t\n", o.Experimental.ValidateOnIngest)
 	fmt.Fprintf(&buf, "  wal_dir=%s\n", o.WALDir)
 	fmt.Fprintf(&buf, "  wal_bytes_per_sync=%d\n", o.WALBytesPerSync)
+	if o.WALPreallocateSize != 0 {
+		fmt.Fprintf(&buf, "  wal_preallocate_size=%d\n", o.WALPreallocateSize)
+	}
+	if o.DisableWALRecycling {
+		fmt.Fprintf(&buf, "  disable_wal_recycling=%t\n", o.DisableWALRecycling)
+	}
+	if o.WALRecycleLimit != 0 {
+		fmt.Fprintf(&buf, "  wal_recycle_limit=%d\n", o.WALRecycleLimit)
+	}
+	if o.MaxUnflushedWALBytes != 0 {
+		fmt.Fprintf(&buf, "  max_unflushed_wal_bytes=%d\n", o.MaxUnflushedWALBytes)
+	}
+	if o.WALArchive.Dir != "" {
+		fmt.Fprintf(&buf, "  wal_archive_dir=%s\n", o.WALArchive.Dir)
+	}
+	if o.WALArchive.MaxFiles != 0 {
+		fmt.Fprintf(&buf, "  wal_archive_max_files=%d\n", o.WALArchive.MaxFiles)
+	}
+	if o.WALArchive.MaxAge != 0 {
+		fmt.Fprintf(&buf, "  wal_archive_max_age=%s\n", o.WALArchive.MaxAge)
+	}
+	if o.SubscriptionBufferSize != 0 {
+		fmt.Fprintf(&buf, "  subscription_buffer_size=%d\n", o.SubscriptionBufferSize)
+	}
+	if o.WALRecoveryMode != WALRecoveryTolerateCorruptTail {
+		fmt.Fprintf(&buf, "  wal_recovery_mode=%s\n", o.WALRecoveryMode)
+	}
+	if o.WALRecordCommitTimes {
+		fmt.Fprintf(&buf, "  wal_record_commit_times=%t\n", o.WALRecordCommitTimes)
+	}
 	fmt.Fprintf(&buf, "  max_writer_concurrency=%d\n", o.Experimental.MaxWriterConcurrency)
+	if o.Experimental.MaxConcurrentCommits != 0 {
+		fmt.Fprintf(&buf, "  max_concurrent_commits=%d\n", o.Experimental.MaxConcurrentCommits)
+	}
 	fmt.Fprintf(&buf, "  force_writer_parallelism=%t\n", o.Experimental.ForceWriterParallelism)
 	fmt.Fprintf(&buf, "  secondary_cache_size_bytes=%d\n", o.Experimental.SecondaryCacheSizeBytes)
+	if o.Experimental.SecondaryCacheWriteMode != SecondaryCacheWriteAround {
+		fmt.Fprintf(&buf, "  secondary_cache_write_mode=%s\n", o.Experimental.SecondaryCacheWriteMode)
+	}
 
 	// Private options.
 	//
@@ -1250,18 +2130,50 @@ func (o *Options) String() string {
 		fmt.Fprintf(&buf, "\n")
 		fmt.Fprintf(&buf, "[Level \"%d\"]\n", i)
 		fmt.Fprintf(&buf, "  block_restart_interval=%d\n", l.BlockRestartInterval)
+		if l.DisableKeyDeltaEncoding {
+			fmt.Fprintf(&buf, "  disable_key_delta_encoding=%t\n", l.DisableKeyDeltaEncoding)
+		}
 		fmt.Fprintf(&buf, "  block_size=%d\n", l.BlockSize)
 		fmt.Fprintf(&buf, "  block_size_threshold=%d\n", l.BlockSizeThreshold)
 		fmt.Fprintf(&buf, "  compression=%s\n", l.Compression)
+		if l.ValueBlockCompression != DefaultCompression {
+			fmt.Fprintf(&buf, "  value_block_compression=%s\n", l.ValueBlockCompression)
+		}
+		if l.ValueBlockSize != 0 {
+			fmt.Fprintf(&buf, "  value_block_size=%d\n", l.ValueBlockSize)
+		}
+		if l.ZstdDictionarySize != 0 {
+			fmt.Fprintf(&buf, "  zstd_dictionary_size=%d\n", l.ZstdDictionarySize)
+		}
 		fmt.Fprintf(&buf, "  filter_policy=%s\n", filterPolicyName(l.FilterPolicy))
 		fmt.Fprintf(&buf, "  filter_type=%s\n", l.FilterType)
 		fmt.Fprintf(&buf, "  index_block_size=%d\n", l.IndexBlockSize)
 		fmt.Fprintf(&buf, "  target_file_size=%d\n", l.TargetFileSize)
+		if l.Dir != "" {
+			fmt.Fprintf(&buf, "  dir=%s\n", l.Dir)
+		}
 	}
 
 	return buf.String()
 }
 
+// parseCompression parses the name of a compression as printed by
+// Compression.String.
+func parseCompression(value string) (Compression, error) {
+	switch value {
+	case "Default":
+		return DefaultCompression, nil
+	case "NoCompression":
+		return NoCompression, nil
+	case "Snappy":
+		return SnappyCompression, nil
+	case "ZSTD":
+		return ZstdCompression, nil
+	default:
+		return DefaultCompression, errors.Errorf("pebble: unknown compression: %q", errors.Safe(value))
+	}
+}
+
 func parseOptions(s string, fn func(section, key, value string) error) error {
 	var section string
 	for _, line := range strings.Split(s, "\n") {
@@ -1313,12 +2225,13 @@ func parseOptions(s string, fn func(section, key, value string) error) error {
 // ParseHooks contains callbacks to create options fields which can have
 // user-defined implementations.
 type ParseHooks struct {
-	NewCache        func(size int64) *Cache
-	NewCleaner      func(name string) (Cleaner, error)
-	NewComparer     func(name string) (*Comparer, error)
-	NewFilterPolicy func(name string) (FilterPolicy, error)
-	NewMerger       func(name string) (*Merger, error)
-	SkipUnknown     func(name, value string) bool
+	NewCache           func(size int64) *Cache
+	NewCleaner         func(name string) (Cleaner, error)
+	NewComparer        func(name string) (*Comparer, error)
+	NewFilterPolicy    func(name string) (FilterPolicy, error)
+	NewMerger          func(name string) (*Merger, error)
+	NewPrefixExtractor func(name string) (*PrefixExtractor, error)
+	SkipUnknown        func(name, value string) bool
 }
 
 // Parse parses the options from the specified string. Note that certain
@@ -1347,8 +2260,12 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 		case section == "Options":
 			var err error
 			switch key {
+			case "block_checksum":
+				o.BlockChecksum, err = sstable.ParseChecksumType(value)
 			case "bytes_per_sync":
 				o.BytesPerSync, err = strconv.Atoi(value)
+			case "cache_quota":
+				o.CacheQuota, err = strconv.ParseInt(value, 10, 64)
 			case "cache_size":
 				var n int64
 				n, err = strconv.ParseInt(value, 10, 64)
@@ -1382,10 +2299,16 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				}
 			case "compaction_debt_concurrency":
 				o.Experimental.CompactionDebtConcurrency, err = strconv.Atoi(value)
+			case "compaction_write_rate_limit":
+				o.CompactionWriteRateLimit, err = strconv.ParseInt(value, 10, 64)
 			case "delete_range_flush_delay":
 				// NB: This is a deprecated serialization of the
 				// `flush_delay_delete_range`.
 				o.FlushDelayDeleteRange, err = time.ParseDuration(value)
+			case "direct_io_reads":
+				o.Experimental.DirectIOReads, err = strconv.ParseBool(value)
+			case "direct_io_writes":
+				o.Experimental.DirectIOWrites, err = strconv.ParseBool(value)
 			case "disable_delete_only_compactions":
 				o.private.disableDeleteOnlyCompactions, err = strconv.ParseBool(value)
 			case "disable_elision_only_compactions":
@@ -1400,6 +2323,10 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				o.private.disableLazyCombinedIteration, err = strconv.ParseBool(value)
 			case "disable_wal":
 				o.DisableWAL, err = strconv.ParseBool(value)
+			case "disk_space_hard_limit":
+				o.DiskSpaceHardLimit, err = strconv.ParseUint(value, 10, 64)
+			case "disk_space_soft_limit":
+				o.DiskSpaceSoftLimit, err = strconv.ParseUint(value, 10, 64)
 			case "flush_delay_delete_range":
 				o.FlushDelayDeleteRange, err = time.ParseDuration(value)
 			case "flush_delay_range_key":
@@ -1419,6 +2346,16 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				if err == nil {
 					o.FormatMajorVersion = FormatMajorVersion(v)
 				}
+			case "immutable_mem_table_filter_bits_per_key":
+				o.Experimental.ImmutableMemTableFilterBitsPerKey, err = strconv.Atoi(value)
+			case "ingest_as_batch_max_size":
+				o.Experimental.IngestAsBatchMaxSize, err = strconv.ParseInt(value, 10, 64)
+			case "io_uring_reads":
+				o.Experimental.IOUringReads, err = strconv.ParseBool(value)
+			case "hole_punch_deletion_bytes_per_sec":
+				o.HolePunchDeletion.BytesPerSec, err = strconv.ParseInt(value, 10, 64)
+			case "hole_punch_deletion_min_file_size":
+				o.HolePunchDeletion.MinFileSize, err = strconv.ParseInt(value, 10, 64)
 			case "l0_compaction_concurrency":
 				o.Experimental.L0CompactionConcurrency, err = strconv.Atoi(value)
 			case "l0_compaction_file_threshold":
@@ -1443,17 +2380,38 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				}
 			case "max_manifest_file_size":
 				o.MaxManifestFileSize, err = strconv.ParseInt(value, 10, 64)
+			case "max_mem_table_size":
+				o.Experimental.MaxMemTableSize, err = strconv.Atoi(value)
 			case "max_open_files":
 				o.MaxOpenFiles, err = strconv.Atoi(value)
+			case "max_write_stall_duration":
+				o.MaxWriteStallDuration, err = time.ParseDuration(value)
+			case "mem_table_apply_concurrency":
+				o.Experimental.MemTableApplyConcurrency, err = strconv.Atoi(value)
+			case "mem_table_kind":
+				switch value {
+				case "skiplist":
+					o.Experimental.MemTableKind = MemTableSkiplist
+				case "prefix-hash-skiplist":
+					o.Experimental.MemTableKind = MemTablePrefixHashSkiplist
+				default:
+					return errors.Errorf("pebble: unknown memtable kind: %q", errors.Safe(value))
+				}
 			case "mem_table_size":
 				o.MemTableSize, err = strconv.Atoi(value)
 			case "mem_table_stop_writes_threshold":
 				o.MemTableStopWritesThreshold, err = strconv.Atoi(value)
+			case "periodic_compaction_interval":
+				o.PeriodicCompactionInterval, err = time.ParseDuration(value)
+			case "pin_top_level_index_blocks":
+				o.PinTopLevelIndexBlocks, err = strconv.ParseBool(value)
 			case "min_compaction_rate":
 				// Do nothing; option existed in older versions of pebble, and
 				// may be meaningful again eventually.
 			case "min_deletion_rate":
 				o.TargetByteDeletionRate, err = strconv.Atoi(value)
+			case "mmap_reads":
+				o.Experimental.MmapReads, err = strconv.ParseBool(value)
 			case "min_flush_rate":
 				// Do nothing; option existed in older versions of pebble, and
 				// may be meaningful again eventually.
@@ -1472,10 +2430,20 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 						o.Merger, err = hooks.NewMerger(value)
 					}
 				}
+			case "prefix_extractor":
+				if hooks != nil && hooks.NewPrefixExtractor != nil {
+					o.PrefixExtractor, err = hooks.NewPrefixExtractor(value)
+				}
+			case "read_compaction_overlapping_levels":
+				o.Experimental.ReadCompactionOverlappingLevels, err = strconv.Atoi(value)
 			case "read_compaction_rate":
 				o.Experimental.ReadCompactionRate, err = strconv.ParseInt(value, 10, 64)
 			case "read_sampling_multiplier":
 				o.Experimental.ReadSamplingMultiplier, err = strconv.ParseInt(value, 10, 64)
+			case "row_cache_size":
+				o.RowCacheSize, err = strconv.ParseInt(value, 10, 64)
+			case "snapshot_elision_concurrency":
+				o.Experimental.SnapshotElisionConcurrency, err = strconv.Atoi(value)
 			case "table_cache_shards":
 				o.Experimental.TableCacheShards, err = strconv.Atoi(value)
 			case "table_format":
@@ -1487,18 +2455,51 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				}
 			case "table_property_collectors":
 				// TODO(peter): set o.TablePropertyCollectors
+			case "tombstone_density_compaction_threshold":
+				o.TombstoneDensityCompactionThreshold, err = strconv.ParseFloat(value, 64)
 			case "validate_on_ingest":
 				o.Experimental.ValidateOnIngest, err = strconv.ParseBool(value)
 			case "wal_dir":
 				o.WALDir = value
 			case "wal_bytes_per_sync":
 				o.WALBytesPerSync, err = strconv.Atoi(value)
+			case "wal_preallocate_size":
+				o.WALPreallocateSize, err = strconv.Atoi(value)
+			case "disable_wal_recycling":
+				o.DisableWALRecycling, err = strconv.ParseBool(value)
+			case "wal_recycle_limit":
+				o.WALRecycleLimit, err = strconv.Atoi(value)
+			case "max_unflushed_wal_bytes":
+				o.MaxUnflushedWALBytes, err = strconv.ParseInt(value, 10, 64)
+			case "wal_archive_dir":
+				o.WALArchive.Dir = value
+			case "wal_archive_max_files":
+				o.WALArchive.MaxFiles, err = strconv.Atoi(value)
+			case "wal_archive_max_age":
+				o.WALArchive.MaxAge, err = time.ParseDuration(value)
+			case "subscription_buffer_size":
+				o.SubscriptionBufferSize, err = strconv.ParseInt(value, 10, 64)
+			case "wal_recovery_mode":
+				o.WALRecoveryMode, err = parseWALRecoveryMode(value)
+			case "wal_record_commit_times":
+				o.WALRecordCommitTimes, err = strconv.ParseBool(value)
 			case "max_writer_concurrency":
 				o.Experimental.MaxWriterConcurrency, err = strconv.Atoi(value)
+			case "max_concurrent_commits":
+				o.Experimental.MaxConcurrentCommits, err = strconv.Atoi(value)
 			case "force_writer_parallelism":
 				o.Experimental.ForceWriterParallelism, err = strconv.ParseBool(value)
 			case "secondary_cache_size_bytes":
 				o.Experimental.SecondaryCacheSizeBytes, err = strconv.ParseInt(value, 10, 64)
+			case "secondary_cache_write_mode":
+				switch value {
+				case "write-around":
+					o.Experimental.SecondaryCacheWriteMode = SecondaryCacheWriteAround
+				case "write-through":
+					o.Experimental.SecondaryCacheWriteMode = SecondaryCacheWriteThrough
+				default:
+					err = errors.Errorf("pebble: unknown secondary cache write mode %q", value)
+				}
 			default:
 				if hooks != nil && hooks.SkipUnknown != nil && hooks.SkipUnknown(section+"."+key, value) {
 					return nil
@@ -1530,23 +2531,20 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 			switch key {
 			case "block_restart_interval":
 				l.BlockRestartInterval, err = strconv.Atoi(value)
+			case "disable_key_delta_encoding":
+				l.DisableKeyDeltaEncoding, err = strconv.ParseBool(value)
 			case "block_size":
 				l.BlockSize, err = strconv.Atoi(value)
 			case "block_size_threshold":
 				l.BlockSizeThreshold, err = strconv.Atoi(value)
 			case "compression":
-				switch value {
-				case "Default":
-					l.Compression = DefaultCompression
-				case "NoCompression":
-					l.Compression = NoCompression
-				case "Snappy":
-					l.Compression = SnappyCompression
-				case "ZSTD":
-					l.Compression = ZstdCompression
-				default:
-					return errors.Errorf("pebble: unknown compression: %q", errors.Safe(value))
-				}
+				l.Compression, err = parseCompression(value)
+			case "value_block_compression":
+				l.ValueBlockCompression, err = parseCompression(value)
+			case "value_block_size":
+				l.ValueBlockSize, err = strconv.Atoi(value)
+			case "zstd_dictionary_size":
+				l.ZstdDictionarySize, err = strconv.Atoi(value)
 			case "filter_policy":
 				if hooks != nil && hooks.NewFilterPolicy != nil {
 					l.FilterPolicy, err = hooks.NewFilterPolicy(value)
@@ -1562,6 +2560,8 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				l.IndexBlockSize, err = strconv.Atoi(value)
 			case "target_file_size":
 				l.TargetFileSize, err = strconv.ParseInt(value, 10, 64)
+			case "dir":
+				l.Dir = value
 			default:
 				if hooks != nil && hooks.SkipUnknown != nil && hooks.SkipUnknown(section+"."+key, value) {
 					return nil
@@ -1631,14 +2631,59 @@ func (o *Options) Validate() error {
 		fmt.Fprintf(&buf, "MemTableSize (%s) must be < %s\n",
 			humanize.Bytes.Uint64(uint64(o.MemTableSize)), humanize.Bytes.Uint64(maxMemTableSize))
 	}
+	if uint64(o.Experimental.MaxMemTableSize) >= maxMemTableSize {
+		fmt.Fprintf(&buf, "MaxMemTableSize (%s) must be < %s\n",
+			humanize.Bytes.Uint64(uint64(o.Experimental.MaxMemTableSize)), humanize.Bytes.Uint64(maxMemTableSize))
+	}
 	if o.MemTableStopWritesThreshold < 2 {
 		fmt.Fprintf(&buf, "MemTableStopWritesThreshold (%d) must be >= 2\n",
 			o.MemTableStopWritesThreshold)
 	}
+	if o.DiskSpaceSoftLimit > 0 && o.DiskSpaceHardLimit > o.DiskSpaceSoftLimit {
+		fmt.Fprintf(&buf, "DiskSpaceHardLimit (%s) must be <= DiskSpaceSoftLimit (%s)\n",
+			humanize.Bytes.Uint64(o.DiskSpaceHardLimit), humanize.Bytes.Uint64(o.DiskSpaceSoftLimit))
+	}
+	switch o.BlockChecksum {
+	case 0, ChecksumTypeCRC32c, ChecksumTypeXXHash64, ChecksumTypeXXH3:
+	default:
+		fmt.Fprintf(&buf, "BlockChecksum (%d) is not a supported checksum type\n", o.BlockChecksum)
+	}
 	if o.FormatMajorVersion > internalFormatNewest {
 		fmt.Fprintf(&buf, "FormatMajorVersion (%d) must be <= %d\n",
 			o.FormatMajorVersion, internalFormatNewest)
 	}
+	if o.FormatMajorVersion < ExperimentalFormatBlockExtensions {
+		if o.BlockChecksum == ChecksumTypeXXH3 {
+			fmt.Fprintf(&buf, "BlockChecksum (%s) requires FormatMajorVersion >= %d\n",
+				o.BlockChecksum, ExperimentalFormatBlockExtensions)
+		}
+		if o.Experimental.ColumnarSchema != nil {
+			fmt.Fprintf(&buf, "ColumnarSchema requires FormatMajorVersion >= %d\n",
+				ExperimentalFormatBlockExtensions)
+		}
+		if o.TableKeyManager != nil {
+			fmt.Fprintf(&buf, "TableKeyManager requires FormatMajorVersion >= %d\n",
+				ExperimentalFormatBlockExtensions)
+		}
+	}
+	if o.WALArchive.Dir != "" && o.WALArchive.Archive != nil {
+		fmt.Fprintf(&buf, "WALArchive.Dir and WALArchive.Archive are mutually exclusive\n")
+	}
+	if o.Experimental.Tiering.enabled() {
+		if o.Experimental.RemoteStorage == nil {
+			fmt.Fprintf(&buf, "Tiering requires RemoteStorage to be set\n")
+		}
+		if o.preferSharedStorage(numLevels - 1) {
+			fmt.Fprintf(&buf, "Tiering cannot be combined with CreateOnShared for the bottommost level\n")
+		}
+	}
+	if o.Experimental.ValueSeparation.MinLiveRatio >= 1 {
+		fmt.Fprintf(&buf, "ValueSeparation.MinLiveRatio (%f) must be < 1\n",
+			o.Experimental.ValueSeparation.MinLiveRatio)
+	}
+	if o.Experimental.ValueSeparation.enabled() && o.TableKeyManager != nil {
+		fmt.Fprintf(&buf, "ValueSeparation cannot be combined with TableKeyManager\n")
+	}
 	if o.TableCache != nil && o.Cache != o.TableCache.cache {
 		fmt.Fprintf(&buf, "underlying cache in the TableCache and the Cache dont match\n")
 	}
@@ -1656,6 +2701,9 @@ func (o *Options) MakeReaderOptions() sstable.ReaderOptions {
 		readerOpts.Cache = o.Cache
 		readerOpts.Comparer = o.Comparer
 		readerOpts.Filters = o.Filters
+		readerOpts.KeyManager = o.TableKeyManager
+		readerOpts.PinTopLevelIndex = o.PinTopLevelIndexBlocks
+		readerOpts.PrefixExtractor = o.PrefixExtractor
 		if o.Merger != nil {
 			readerOpts.Merge = o.Merger.Merge
 			readerOpts.MergerName = o.Merger.Name
@@ -1672,12 +2720,22 @@ func (o *Options) MakeWriterOptions(level int, format sstable.TableFormat) sstab
 	writerOpts.TableFormat = format
 	if o != nil {
 		writerOpts.Cache = o.Cache
+		writerOpts.Checksum = o.BlockChecksum
 		writerOpts.Comparer = o.Comparer
+		writerOpts.KeyManager = o.TableKeyManager
+		writerOpts.PrefixExtractor = o.PrefixExtractor
+		writerOpts.ColumnarSchema = o.Experimental.ColumnarSchema
 		if o.Merger != nil {
 			writerOpts.MergerName = o.Merger.Name
 		}
 		writerOpts.TablePropertyCollectors = o.TablePropertyCollectors
 		writerOpts.BlockPropertyCollectors = o.BlockPropertyCollectors
+		if o.TTL.enabled() {
+			ttl := &o.TTL
+			writerOpts.BlockPropertyCollectors = append(
+				writerOpts.BlockPropertyCollectors[:len(o.BlockPropertyCollectors):len(o.BlockPropertyCollectors)],
+				func() BlockPropertyCollector { return newTTLBlockPropertyCollector(ttl) })
+		}
 	}
 	if format >= sstable.TableFormatPebblev3 {
 		writerOpts.ShortAttributeExtractor = o.Experimental.ShortAttributeExtractor
@@ -1688,9 +2746,12 @@ func (o *Options) MakeWriterOptions(level int, format sstable.TableFormat) sstab
 	}
 	levelOpts := o.Level(level)
 	writerOpts.BlockRestartInterval = levelOpts.BlockRestartInterval
+	writerOpts.DisableKeyDeltaEncoding = levelOpts.DisableKeyDeltaEncoding
 	writerOpts.BlockSize = levelOpts.BlockSize
 	writerOpts.BlockSizeThreshold = levelOpts.BlockSizeThreshold
 	writerOpts.Compression = levelOpts.Compression
+	writerOpts.ValueBlockCompression = levelOpts.ValueBlockCompression
+	writerOpts.ValueBlockSize = levelOpts.ValueBlockSize
 	writerOpts.FilterPolicy = levelOpts.FilterPolicy
 	writerOpts.FilterType = levelOpts.FilterType
 	writerOpts.IndexBlockSize = levelOpts.IndexBlockSize
@@ -229,6 +229,7 @@ func TestOptionsParse(t *testing.T) {
 			opts.Levels[0].BlockSize = 1024
 			opts.Levels[1].BlockSize = 2048
 			opts.Levels[2].BlockSize = 4096
+			opts.Levels[2].Dir = "slow"
 			opts.Experimental.CompactionDebtConcurrency = 100
 			opts.FlushDelayDeleteRange = 10 * time.Second
 			opts.FlushDelayRangeKey = 11 * time.Second
@@ -240,6 +241,17 @@ func TestOptionsParse(t *testing.T) {
 			opts.Experimental.MaxWriterConcurrency = 1
 			opts.Experimental.ForceWriterParallelism = true
 			opts.Experimental.SecondaryCacheSizeBytes = 1024
+			opts.Experimental.MaxConcurrentCommits = 64
+			opts.Experimental.DirectIOReads = true
+			opts.Experimental.DirectIOWrites = true
+			opts.Experimental.IOUringReads = true
+			opts.Experimental.MmapReads = true
+			opts.MaxWriteStallDuration = 5 * time.Second
+			opts.PeriodicCompactionInterval = 30 * 24 * time.Hour
+			opts.CompactionWriteRateLimit = 64 << 20
+			opts.TombstoneDensityCompactionThreshold = 0.25
+			opts.Experimental.SnapshotElisionConcurrency = 2
+			opts.BlockChecksum = ChecksumTypeXXH3
 			opts.EnsureDefaults()
 			str := opts.String()
 
@@ -256,6 +268,59 @@ func TestOptionsParse(t *testing.T) {
 	}
 }
 
+func TestLevelDirs(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{
+		FS:     mem,
+		Logger: testLogger{t: t},
+		Levels: []LevelOptions{{Dir: "fast"}, {Dir: "fast"}, {Dir: "slow"}},
+	}
+	require.Equal(t, []string{"fast", "slow"}, opts.tableDirs("db"))
+
+	// levelDirs returns the directory of the sstables of each non-empty level.
+	levelDirs := func(d *DB) map[int]string {
+		m := make(map[int]string)
+		tables, err := d.SSTables()
+		require.NoError(t, err)
+		for level, files := range tables {
+			for _, f := range files {
+				meta, err := d.objProvider.Lookup(fileTypeTable, f.FileNum.DiskFileNum())
+				require.NoError(t, err)
+				m[level] = meta.LocalDir
+			}
+		}
+		return m
+	}
+
+	d, err := Open("db", opts)
+	require.NoError(t, err)
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Flush())
+	require.Equal(t, map[int]string{0: "fast"}, levelDirs(d))
+
+	require.NoError(t, d.Compact([]byte("a"), []byte("b"), true /* parallelize */))
+	require.Equal(t, map[int]string{numLevels - 1: "slow"}, levelDirs(d))
+	require.NoError(t, d.Set([]byte("b"), []byte("2"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Close())
+
+	ls, err := mem.List("slow")
+	require.NoError(t, err)
+	require.Len(t, ls, 1)
+
+	// The sstables are found when the DB is reopened.
+	d, err = Open("db", opts)
+	require.NoError(t, err)
+	require.Equal(t, map[int]string{0: "fast", numLevels - 1: "slow"}, levelDirs(d))
+	for k, v := range map[string]string{"a": "1", "b": "2"} {
+		val, closer, err := d.Get([]byte(k))
+		require.NoError(t, err)
+		require.Equal(t, v, string(val))
+		require.NoError(t, closer.Close())
+	}
+	require.NoError(t, d.Close())
+}
+
 func TestOptionsValidate(t *testing.T) {
 	testCases := []struct {
 		options  string
@@ -287,6 +352,20 @@ func TestOptionsValidate(t *testing.T) {
 `,
 			`MemTableStopWritesThreshold .* must be >= 2`,
 		},
+		{`
+[Options]
+  block_checksum=xxh3
+  format_major_version=20
+`,
+			``,
+		},
+		{`
+[Options]
+  block_checksum=xxh3
+  format_major_version=19
+`,
+			`BlockChecksum \(xxh3\) requires FormatMajorVersion >= 20`,
+		},
 	}
 
 	for _, c := range testCases {
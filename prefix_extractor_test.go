@@ -0,0 +1,75 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/pebble/bloom"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefixExtractor(t *testing.T) {
+	extractor := &PrefixExtractor{
+		Name: "test-slash",
+		Split: func(a []byte) int {
+			if i := bytes.IndexByte(a, '/'); i >= 0 {
+				return i + 1
+			}
+			return len(a)
+		},
+	}
+	opts := &Options{
+		FS:              vfs.NewMem(),
+		PrefixExtractor: extractor,
+	}
+	opts.Levels = []LevelOptions{{FilterPolicy: bloom.FilterPolicy(10)}}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Write two sstables whose key ranges span the tenant t2, which they do
+	// not contain.
+	for _, tenants := range [][]string{{"t1", "t5"}, {"t3", "t5"}} {
+		for _, tenant := range tenants {
+			for i := 0; i < 10; i++ {
+				require.NoError(t, d.Set([]byte(fmt.Sprintf("%s/%03d", tenant, i)), []byte("v"), nil))
+			}
+		}
+		require.NoError(t, d.Flush())
+	}
+
+	scan := func(tenant string) int {
+		iter, _ := d.NewIter(&IterOptions{
+			LowerBound: []byte(tenant + "/"),
+			UpperBound: []byte(tenant + "/\xff"),
+			// The sstables may have been moved to L6.
+			UseL6Filters: true,
+		})
+		n := 0
+		for valid := iter.SeekGE([]byte(tenant + "/")); valid; valid = iter.Next() {
+			n++
+		}
+		require.NoError(t, iter.Close())
+		return n
+	}
+	require.Equal(t, 10, scan("t3"))
+	hits := d.Metrics().Filter.Hits
+	require.Equal(t, 0, scan("t2"))
+	require.Greater(t, d.Metrics().Filter.Hits, hits)
+
+	parsed := &Options{}
+	hooks := &ParseHooks{
+		NewPrefixExtractor: func(name string) (*PrefixExtractor, error) {
+			require.Equal(t, extractor.Name, name)
+			return extractor, nil
+		},
+	}
+	require.NoError(t, parsed.Parse(opts.EnsureDefaults().String(), hooks))
+	require.Equal(t, extractor, parsed.PrefixExtractor)
+}
@@ -0,0 +1,220 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/rangekey"
+)
+
+// rangeKeyLoaderBatchSize is the size at which a RangeKeyLoader commits its
+// pending batch.
+const rangeKeyLoaderBatchSize = 1 << 20 // 1 MB
+
+// RangeKeyLoader applies a stream of RangeKeySet and RangeKeyUnset operations
+// sorted by start key to a DB. Consecutive operations are coalesced before
+// they are written: operations of the same kind over an identical span are
+// combined into a single record holding all of their suffixes, and operations
+// with the same kind, suffix and value over overlapping or abutting spans are
+// combined into a single operation over the union of the spans. The coalesced
+// operations are committed in batches of roughly 1 MB.
+//
+// The loaded range keys are not applied atomically: operations become visible
+// as their batches are committed, and a crash may leave a prefix of the
+// operations applied. A RangeKeyLoader is not safe for concurrent use.
+type RangeKeyLoader struct {
+	db    *DB
+	opts  *WriteOptions
+	batch *Batch
+	err   error
+	// lastStart is the start key of the most recent operation, used to verify
+	// that the input is sorted.
+	lastStart []byte
+	// pending is the coalesced operation that has not yet been added to the
+	// batch.
+	pending struct {
+		kind         InternalKeyKind
+		start, end   []byte
+		suffixValues []rangekey.SuffixValue
+		suffixes     [][]byte
+		// buf holds copies of the operation's keys, suffixes and values.
+		buf []byte
+	}
+	// ops and records count the operations added to the loader and the
+	// coalesced records written to batches.
+	ops, records int
+}
+
+// NewRangeKeyLoader returns a RangeKeyLoader that applies range keys to the DB
+// with the provided write options. The caller must call Finish to commit the
+// remaining operations.
+func (d *DB) NewRangeKeyLoader(opts *WriteOptions) *RangeKeyLoader {
+	l := &RangeKeyLoader{db: d, opts: opts}
+	switch {
+	case d.split == nil:
+		l.err = errNoSplit
+	case d.FormatMajorVersion() < FormatRangeKeys:
+		l.err = errors.Errorf("pebble: range keys require at least format major version %d (current: %d)",
+			FormatRangeKeys, d.FormatMajorVersion())
+	}
+	return l
+}
+
+// Set adds a RangeKeySet of [start, end) at the provided suffix and value.
+// The start key must not be less than the start key of the previous
+// operation. It is safe to modify the contents of the arguments after Set
+// returns.
+func (l *RangeKeyLoader) Set(start, end, suffix, value []byte) error {
+	return l.add(InternalKeyKindRangeKeySet, start, end, suffix, value)
+}
+
+// Unset adds a RangeKeyUnset of [start, end) at the provided suffix. The start
+// key must not be less than the start key of the previous operation. It is
+// safe to modify the contents of the arguments after Unset returns.
+func (l *RangeKeyLoader) Unset(start, end, suffix []byte) error {
+	return l.add(InternalKeyKindRangeKeyUnset, start, end, suffix, nil)
+}
+
+// Finish commits the remaining operations. The loader must not be used after
+// Finish is called.
+func (l *RangeKeyLoader) Finish() error {
+	if l.err == nil {
+		l.err = l.flushPending()
+	}
+	if l.err == nil && l.batch != nil && !l.batch.Empty() {
+		l.err = l.batch.Commit(l.opts)
+	}
+	if l.batch != nil {
+		_ = l.batch.Close()
+		l.batch = nil
+	}
+	return l.err
+}
+
+func (l *RangeKeyLoader) add(kind InternalKeyKind, start, end, suffix, value []byte) error {
+	if l.err != nil {
+		return l.err
+	}
+	cmp := l.db.cmp
+	if cmp(start, end) >= 0 {
+		return errors.Errorf("pebble: range key start %s is not less than end %s",
+			l.db.opts.Comparer.FormatKey(start), l.db.opts.Comparer.FormatKey(end))
+	}
+	if l.lastStart != nil && cmp(start, l.lastStart) < 0 {
+		return errors.Errorf("pebble: range key start %s is less than previous start %s",
+			l.db.opts.Comparer.FormatKey(start), l.db.opts.Comparer.FormatKey(l.lastStart))
+	}
+	l.lastStart = append(l.lastStart[:0], start...)
+	l.ops++
+
+	p := &l.pending
+	n := len(p.suffixValues) + len(p.suffixes)
+	if n > 0 && p.kind == kind {
+		switch {
+		case cmp(p.start, start) == 0 && cmp(p.end, end) == 0 && !l.pendingHasSuffix(suffix):
+			// An identical span: add the suffix to the pending record.
+			l.appendSuffix(kind, suffix, value)
+			return nil
+		case n == 1 && cmp(start, p.end) <= 0 && l.pendingIsSuffixValue(suffix, value):
+			// An overlapping or abutting span with the same suffix and value:
+			// extend the pending operation.
+			if cmp(end, p.end) > 0 {
+				p.end = l.copy(end)
+			}
+			return nil
+		}
+	}
+
+	if l.err = l.flushPending(); l.err != nil {
+		return l.err
+	}
+	p.kind = kind
+	p.start = l.copy(start)
+	p.end = l.copy(end)
+	l.appendSuffix(kind, suffix, value)
+	return nil
+}
+
+func (l *RangeKeyLoader) copy(b []byte) []byte {
+	n := len(l.pending.buf)
+	l.pending.buf = append(l.pending.buf, b...)
+	return l.pending.buf[n:len(l.pending.buf):len(l.pending.buf)]
+}
+
+func (l *RangeKeyLoader) appendSuffix(kind InternalKeyKind, suffix, value []byte) {
+	p := &l.pending
+	if kind == InternalKeyKindRangeKeySet {
+		p.suffixValues = append(p.suffixValues, rangekey.SuffixValue{
+			Suffix: l.copy(suffix),
+			Value:  l.copy(value),
+		})
+	} else {
+		p.suffixes = append(p.suffixes, l.copy(suffix))
+	}
+}
+
+func (l *RangeKeyLoader) pendingHasSuffix(suffix []byte) bool {
+	for _, sv := range l.pending.suffixValues {
+		if bytes.Equal(sv.Suffix, suffix) {
+			return true
+		}
+	}
+	for _, s := range l.pending.suffixes {
+		if bytes.Equal(s, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *RangeKeyLoader) pendingIsSuffixValue(suffix, value []byte) bool {
+	p := &l.pending
+	if p.kind == InternalKeyKindRangeKeySet {
+		return bytes.Equal(p.suffixValues[0].Suffix, suffix) && bytes.Equal(p.suffixValues[0].Value, value)
+	}
+	return bytes.Equal(p.suffixes[0], suffix)
+}
+
+// flushPending adds the pending operation to the batch, committing the batch
+// if it has grown large enough.
+func (l *RangeKeyLoader) flushPending() error {
+	p := &l.pending
+	if len(p.suffixValues)+len(p.suffixes) == 0 {
+		return nil
+	}
+	if l.batch == nil {
+		l.batch = l.db.NewBatch()
+	}
+	b := l.batch
+	if p.kind == InternalKeyKindRangeKeySet {
+		n := rangekey.EncodedSetValueLen(p.end, p.suffixValues)
+		op := b.rangeKeySetDeferred(len(p.start), n)
+		copy(op.Key, p.start)
+		rangekey.EncodeSetValue(op.Value, p.end, p.suffixValues)
+	} else {
+		n := rangekey.EncodedUnsetValueLen(p.end, p.suffixes)
+		op := b.rangeKeyUnsetDeferred(len(p.start), n)
+		copy(op.Key, p.start)
+		rangekey.EncodeUnsetValue(op.Value, p.end, p.suffixes)
+	}
+	l.records++
+
+	// The pending operation's buffer may be reused once it has been copied into
+	// the batch.
+	p.start, p.end = nil, nil
+	p.suffixValues = p.suffixValues[:0]
+	p.suffixes = p.suffixes[:0]
+	p.buf = p.buf[:0]
+
+	if b.Len() >= rangeKeyLoaderBatchSize {
+		if err := b.Commit(l.opts); err != nil {
+			return err
+		}
+		b.Reset()
+	}
+	return nil
+}
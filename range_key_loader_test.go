@@ -0,0 +1,100 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRangeKeyLoader(t *testing.T) {
+	open := func() *DB {
+		d, err := Open("", &Options{
+			FS:                 vfs.NewMem(),
+			Comparer:           testkeys.Comparer,
+			FormatMajorVersion: FormatNewest,
+		})
+		require.NoError(t, err)
+		return d
+	}
+	scan := func(d *DB) string {
+		iter, err := d.NewIter(&IterOptions{KeyTypes: IterKeyTypeRangesOnly})
+		require.NoError(t, err)
+		defer iter.Close()
+		var buf strings.Builder
+		for valid := iter.First(); valid; valid = iter.Next() {
+			start, end := iter.RangeBounds()
+			fmt.Fprintf(&buf, "[%s,%s):", start, end)
+			for _, k := range iter.RangeKeys() {
+				fmt.Fprintf(&buf, " %s=%s", k.Suffix, k.Value)
+			}
+			buf.WriteString("\n")
+		}
+		return buf.String()
+	}
+
+	d := open()
+	defer func() { require.NoError(t, d.Close()) }()
+	l := d.NewRangeKeyLoader(nil)
+	require.NoError(t, l.Set([]byte("a"), []byte("b"), []byte("@1"), []byte("v")))
+	require.NoError(t, l.Set([]byte("b"), []byte("c"), []byte("@1"), []byte("v")))
+	require.NoError(t, l.Set([]byte("b"), []byte("d"), []byte("@1"), []byte("v")))
+	require.NoError(t, l.Set([]byte("c"), []byte("e"), []byte("@2"), []byte("w")))
+	require.NoError(t, l.Set([]byte("c"), []byte("e"), []byte("@3"), []byte("w")))
+	require.NoError(t, l.Unset([]byte("d"), []byte("f"), []byte("@2")))
+	require.Error(t, l.Set([]byte("a"), []byte("b"), []byte("@1"), nil))
+	require.Error(t, l.Set([]byte("e"), []byte("e"), []byte("@1"), nil))
+	require.NoError(t, l.Finish())
+	require.Equal(t, 6, l.ops)
+	require.Equal(t, 3, l.records)
+	require.Equal(t, `[a,c): @1=v
+[c,d): @3=w @2=w @1=v
+[d,e): @3=w
+`, scan(d))
+
+	// Loading a large randomized stream is equivalent to writing each
+	// operation to a batch.
+	seed := time.Now().UnixNano()
+	t.Logf("seed: %d", seed)
+	rng := rand.New(rand.NewSource(seed))
+	loaded, batched := open(), open()
+	defer func() {
+		require.NoError(t, loaded.Close())
+		require.NoError(t, batched.Close())
+	}()
+	l = loaded.NewRangeKeyLoader(nil)
+	b := batched.NewBatch()
+	ks := testkeys.Alpha(3)
+	start := 0
+	for i := 0; i < 50000; i++ {
+		start += rng.Intn(2)
+		end := start + 1 + rng.Intn(3)
+		if end >= ks.Count() {
+			break
+		}
+		s, e := testkeys.Key(ks, start), testkeys.Key(ks, end)
+		suffix := testkeys.Suffix(1 + rng.Intn(3))
+		if rng.Intn(4) == 0 {
+			require.NoError(t, l.Unset(s, e, suffix))
+			require.NoError(t, b.RangeKeyUnset(s, e, suffix, nil))
+		} else {
+			value := []byte(fmt.Sprint(rng.Intn(2)))
+			require.NoError(t, l.Set(s, e, suffix, value))
+			require.NoError(t, b.RangeKeySet(s, e, suffix, value, nil))
+		}
+	}
+	require.NoError(t, l.Finish())
+	require.NoError(t, b.Commit(nil))
+	require.NoError(t, b.Close())
+	require.Less(t, l.records, l.ops)
+	require.Equal(t, scan(batched), scan(loaded))
+}
@@ -44,7 +44,8 @@ func (i *Iterator) constructRangeKeyIter() {
 			if logSeqNum := mem.logSeqNum; logSeqNum >= i.seqNum {
 				continue
 			}
-			if rki := mem.newRangeKeyIter(&i.opts); rki != nil {
+			f := flushableView(i.comparer.Compare, i.readState.current, mem)
+			if rki := f.newRangeKeyIter(&i.opts); rki != nil {
 				i.rangeKey.iterConfig.AddLevel(rki)
 			}
 		}
@@ -95,6 +95,7 @@ func (d *DB) updateReadStateLocked(checker func(*DB) error) {
 	old := d.readState.val
 	d.readState.val = s
 	d.readState.Unlock()
+	d.mu.compact.l0Trajectory.record(s.current.Levels[0].Len(), len(s.current.L0Sublevels.Levels))
 	if checker != nil {
 		if err := checker(d); err != nil {
 			d.opts.Logger.Fatalf("checker failed with error: %s", err)
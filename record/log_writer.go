@@ -80,6 +80,13 @@ type syncQueue struct {
 	// blocked or can proceed. It is used by the implementation of
 	// min-sync-interval to block syncing until the min interval has passed.
 	blocked atomic.Bool
+
+	// pushedWhileBlocked is set by push if syncing is blocked and
+	// trackBlockedPushes is set, and cleared by the flush loop before it waits.
+	// It wakes the flush loop so that it enforces the sync deadline for the
+	// pushed sync request.
+	pushedWhileBlocked atomic.Bool
+	trackBlockedPushes bool
 }
 
 const dequeueBits = 32
@@ -105,6 +112,9 @@ func (q *syncQueue) push(wg *sync.WaitGroup, err *error) {
 	// Increment head. This passes ownership of slot to dequeue and acts as a
 	// store barrier for writing the slot.
 	q.headTail.Add(1 << dequeueBits)
+	if q.trackBlockedPushes && q.blocked.Load() {
+		q.pushedWhileBlocked.Store(true)
+	}
 }
 
 func (q *syncQueue) setBlocked() {
@@ -210,7 +220,7 @@ func (c *flusherCond) Lock() {
 
 func (c *flusherCond) Unlock() {
 	c.mu.Unlock()
-	if !c.q.empty() {
+	if !c.q.empty() || c.q.pushedWhileBlocked.Load() {
 		// If the current goroutine is about to block on sync.Cond.Wait, this call
 		// to Signal will prevent that. The comment in Wait above explains a bit
 		// about what is going on here, but it is worth reiterating:
@@ -288,6 +298,10 @@ type LogWriter struct {
 		pending         []*block
 		syncQ           syncQueue
 		metrics         *LogWriterMetrics
+
+		// syncDeadline is the maximum duration a sync request is deferred by
+		// minSyncInterval.
+		syncDeadline durationFunc
 	}
 
 	// afterFunc is a hook to allow tests to mock out the timer functionality
@@ -308,6 +322,9 @@ type LogWriterConfig struct {
 	// the syncQueue from overflowing (which will cause a panic). All production
 	// code ensures this is non-nil.
 	QueueSemChan chan struct{}
+	// WALSyncDeadline is an optional bound on the duration a sync request is
+	// deferred by WALMinSyncInterval.
+	WALSyncDeadline durationFunc
 }
 
 // initialAllocatedBlocksCap is the initial capacity of the various slices
@@ -349,6 +366,8 @@ func NewLogWriter(w io.Writer, logNum base.FileNum, logWriterConfig LogWriterCon
 
 	f := &r.flusher
 	f.minSyncInterval = logWriterConfig.WALMinSyncInterval
+	f.syncDeadline = logWriterConfig.WALSyncDeadline
+	f.syncQ.trackBlockedPushes = f.syncDeadline != nil
 	f.fsyncLatency = logWriterConfig.WALFsyncLatency
 
 	go func() {
@@ -364,6 +383,26 @@ func (w *LogWriter) flushLoop(context.Context) {
 	// Initialize idleStartTime to when the loop starts.
 	idleStartTime := time.Now()
 	var syncTimer syncTimer
+	// blockedUntil is when syncTimer unblocks syncing, while it is blocked.
+	var blockedUntil time.Time
+	// enforceSyncDeadline brings syncTimer forward if sync requests are
+	// waiting for the min sync interval to elapse, so that they do not wait
+	// for longer than the sync deadline.
+	enforceSyncDeadline := func() {
+		f.syncQ.pushedWhileBlocked.Store(false)
+		if f.syncDeadline == nil || syncTimer == nil || !f.syncQ.blocked.Load() {
+			return
+		}
+		if _, _, n := f.syncQ.load(); n == 0 {
+			return
+		}
+		if deadline := f.syncDeadline(); deadline > 0 {
+			if until := time.Now().Add(deadline); until.Before(blockedUntil) {
+				syncTimer.Reset(deadline)
+				blockedUntil = until
+			}
+		}
+	}
 	defer func() {
 		// Capture the idle duration between the last piece of work and when the
 		// loop terminated.
@@ -398,7 +437,9 @@ func (w *LogWriter) flushLoop(context.Context) {
 	//   -- all we need to ensure is that after any transition to blocked=1 there
 	//   is eventually a transition to blocked=0. syncTimer performs this
 	//   transition. Note that any change to min-sync-interval will not take
-	//   effect until the previous timer elapses.
+	//   effect until the previous timer elapses. When sync requests are
+	//   waiting while syncing is blocked, the timer is brought forward so that
+	//   no request waits for longer than the sync deadline.
 	//
 	// - Picking up the syncing work to perform requires coordination with
 	//   picking up the flushing work. Specifically, flushing work is queued
@@ -433,6 +474,7 @@ func (w *LogWriter) flushLoop(context.Context) {
 				}
 				return
 			}
+			enforceSyncDeadline()
 			f.ready.Wait()
 			continue
 		}
@@ -498,8 +540,10 @@ func (w *LogWriter) flushLoop(context.Context) {
 				} else {
 					syncTimer.Reset(min)
 				}
+				blockedUntil = time.Now().Add(min)
 			}
 		}
+		enforceSyncDeadline()
 		// Finished work, and started idling.
 		idleStartTime = time.Now()
 		workDuration := idleStartTime.Sub(workStartTime)
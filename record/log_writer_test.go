@@ -363,6 +363,46 @@ func TestMinSyncIntervalClose(t *testing.T) {
 	wg.Wait()
 }
 
+func TestSyncDeadline(t *testing.T) {
+	f := &syncFile{}
+	w := NewLogWriter(f, 0, LogWriterConfig{
+		WALMinSyncInterval: func() time.Duration {
+			return time.Hour
+		},
+		WALSyncDeadline: func() time.Duration {
+			return time.Millisecond
+		},
+		WALFsyncLatency: prometheus.NewHistogram(prometheus.HistogramOpts{}),
+	})
+	defer func() { require.NoError(t, w.Close()) }()
+
+	syncRecord := func() *sync.WaitGroup {
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		_, err := w.SyncRecord([]byte("a"), wg, new(error))
+		require.NoError(t, err)
+		return wg
+	}
+
+	// Sync one record which blocks syncing for the min sync interval. The
+	// following records are synced once they have waited for the deadline.
+	syncRecord().Wait()
+	for i := 0; i < 10; i++ {
+		done := make(chan struct{})
+		wg := syncRecord()
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("sync %d was not completed by its deadline", i)
+		}
+		require.Equal(t, f.writePos.Load(), f.syncPos.Load())
+	}
+}
+
 type syncFileWithWait struct {
 	f       syncFile
 	writeWG sync.WaitGroup
@@ -0,0 +1,491 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/keyspan"
+	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// RemoteCompactor runs compactions in an external worker process. Only
+// compactions whose input sstables all reside on shared storage are offloaded:
+// the worker reads the inputs directly from shared storage and writes its
+// outputs to shared storage, and the DB installs the outputs into its LSM
+// once Compact returns. The inputs remain referenced by the DB until the
+// compaction completes.
+//
+// A RemoteCompactor is typically implemented by an RPC client; the worker
+// process runs the job using a RemoteCompactionWorker.
+type RemoteCompactor interface {
+	// Compact runs the provided job and returns the sstables it produced, in
+	// key order. If Compact returns an error, the compaction is run locally.
+	Compact(ctx context.Context, job *RemoteCompactionJob) ([]RemoteCompactionOutput, error)
+	// Release is called once the outputs returned by Compact have been
+	// attached to the DB, or once the DB has decided not to use them. The
+	// worker may then drop its own references to the outputs.
+	Release(outputs []RemoteCompactionOutput)
+}
+
+// RemoteCompactionJob describes a compaction to be run by a remote worker.
+type RemoteCompactionJob struct {
+	// JobID is the DB's identifier for the compaction, for logging.
+	JobID int
+	// Inputs holds the input sstables, ordered from the highest level to the
+	// lowest.
+	Inputs []RemoteCompactionInput
+	// OutputLevel is the level into which the outputs are written.
+	OutputLevel int
+	// Snapshots holds the sequence numbers of the DB's open snapshots, in
+	// increasing order. Keys visible to a snapshot are not elided.
+	Snapshots []uint64
+	// ElideTombstones is true if no data below the output level overlaps the
+	// compaction, in which case point tombstones in the last snapshot stripe
+	// may be dropped.
+	ElideTombstones bool
+	// TargetFileSize is the size at which outputs are split.
+	TargetFileSize int64
+	// FormatMajorVersion is the DB's format major version, which determines
+	// the format of the output sstables.
+	FormatMajorVersion FormatMajorVersion
+}
+
+// RemoteCompactionInput describes an input sstable of a RemoteCompactionJob.
+type RemoteCompactionInput struct {
+	// Level is the level of the sstable in the DB.
+	Level int
+	// FileNum is the file number of the sstable in the DB, for logging.
+	FileNum FileNum
+	// Backing references the sstable on shared storage.
+	Backing objstorage.RemoteObjectBacking
+}
+
+// RemoteCompactionOutput describes an sstable written by a remote worker.
+type RemoteCompactionOutput struct {
+	// WorkerFileNum identifies the sstable within the worker. It is opaque to
+	// the DB.
+	WorkerFileNum FileNum
+	// Backing references the sstable on shared storage.
+	Backing objstorage.RemoteObjectBacking
+	// Size is the size of the sstable in bytes.
+	Size uint64
+	// Smallest and Largest are the bounds of the point keys in the sstable.
+	Smallest, Largest InternalKey
+	// SmallestSeqNum and LargestSeqNum are the bounds of the sequence numbers
+	// of the keys in the sstable.
+	SmallestSeqNum, LargestSeqNum uint64
+}
+
+// errRemoteCompactionIneligible is returned by runRemoteCompaction if the
+// compaction cannot be offloaded.
+var errRemoteCompactionIneligible = errors.New("pebble: compaction cannot be run remotely")
+
+// ErrRemoteCompactionUnsupported is returned by a RemoteCompactionWorker for
+// jobs with inputs containing range deletions or range keys, which workers do
+// not process. The DB runs such compactions locally.
+var ErrRemoteCompactionUnsupported = errors.New(
+	"pebble: remote compactions of range deletions and range keys are unsupported")
+
+// runRemoteCompaction runs the compaction c using the configured
+// RemoteCompactor and returns the edit that installs its outputs. It returns
+// errRemoteCompactionIneligible if c cannot be offloaded. d.mu must not be
+// held.
+func (d *DB) runRemoteCompaction(
+	jobID int, c *compaction, snapshots []uint64, formatVers FormatMajorVersion,
+) (ve *versionEdit, pendingOutputs []physicalMeta, retErr error) {
+	compactor := d.opts.Experimental.RemoteCompactor
+	// Compaction filters and TTLs are callbacks into this process, so
+	// compactions that apply them are not offloaded. Neither are compactions
+	// whose outputs belong on local disk.
+	if compactor == nil || len(c.flushing) != 0 || d.opts.CompactionFilter != nil || d.opts.TTL.enabled() ||
+		!d.opts.preferSharedStorage(c.outputLevel.level) {
+		return nil, nil, errRemoteCompactionIneligible
+	}
+
+	job := &RemoteCompactionJob{
+		JobID:              jobID,
+		OutputLevel:        c.outputLevel.level,
+		Snapshots:          snapshots,
+		ElideTombstones:    !c.inuseEntireRange && len(c.inuseKeyRanges) == 0,
+		TargetFileSize:     int64(c.maxOutputFileSize),
+		FormatMajorVersion: formatVers,
+	}
+	// The backing handles keep the inputs alive until the outputs have been
+	// installed.
+	var handles []objstorage.RemoteObjectBackingHandle
+	defer func() {
+		for _, h := range handles {
+			h.Close()
+		}
+	}()
+	for _, cl := range c.inputs {
+		iter := cl.files.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			// The values stored in blob files are only readable from this
+			// process.
+			if f.Virtual || f.HasRangeKeys || len(f.BlobReferences) > 0 {
+				return nil, nil, errRemoteCompactionIneligible
+			}
+			objMeta, err := d.objProvider.Lookup(fileTypeTable, f.FileBacking.DiskFileNum)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !objMeta.IsShared() {
+				return nil, nil, errRemoteCompactionIneligible
+			}
+			h, err := d.objProvider.RemoteObjectBacking(&objMeta)
+			if err != nil {
+				return nil, nil, err
+			}
+			handles = append(handles, h)
+			backing, err := h.Get()
+			if err != nil {
+				return nil, nil, err
+			}
+			job.Inputs = append(job.Inputs, RemoteCompactionInput{
+				Level:   cl.level,
+				FileNum: f.FileNum,
+				Backing: backing,
+			})
+		}
+	}
+
+	outputs, err := compactor.Compact(context.TODO(), job)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer compactor.Release(outputs)
+
+	var attached []base.DiskFileNum
+	defer func() {
+		if retErr != nil {
+			for _, fileNum := range attached {
+				_ = d.objProvider.Remove(fileTypeTable, fileNum)
+			}
+		}
+	}()
+
+	ve = &versionEdit{
+		DeletedFiles: map[deletedFileEntry]*fileMetadata{},
+	}
+	outputMetrics := &LevelMetrics{
+		BytesIn:   c.startLevel.files.SizeSum(),
+		BytesRead: c.outputLevel.files.SizeSum(),
+	}
+	if len(c.extraLevels) > 0 {
+		outputMetrics.BytesIn += c.extraLevels[0].files.SizeSum()
+	}
+	outputMetrics.BytesRead += outputMetrics.BytesIn
+	metrics := map[int]*LevelMetrics{
+		c.outputLevel.level: outputMetrics,
+	}
+	for _, cl := range c.inputs {
+		if metrics[cl.level] == nil {
+			metrics[cl.level] = &LevelMetrics{}
+		}
+	}
+
+	for _, o := range outputs {
+		if c.cancel.Load() {
+			return nil, pendingOutputs, errCancelledCompaction
+		}
+		meta := &fileMetadata{}
+		d.mu.Lock()
+		meta.FileNum = d.mu.versions.getNextFileNum()
+		pendingOutputs = append(pendingOutputs, meta.PhysicalMeta())
+		d.mu.Unlock()
+
+		objMetas, err := d.objProvider.AttachRemoteObjects([]objstorage.RemoteObjectToAttach{{
+			FileNum:  meta.FileNum.DiskFileNum(),
+			FileType: fileTypeTable,
+			Backing:  o.Backing,
+		}})
+		if err != nil {
+			return nil, pendingOutputs, err
+		}
+		attached = append(attached, meta.FileNum.DiskFileNum())
+		d.opts.EventListener.TableCreated(TableCreateInfo{
+			JobID:   jobID,
+			Reason:  "compacting",
+			Path:    d.objProvider.Path(objMetas[0]),
+			FileNum: meta.FileNum,
+		})
+
+		meta.Size = o.Size
+		meta.CreationTime = time.Now().Unix()
+		meta.SmallestSeqNum = o.SmallestSeqNum
+		meta.LargestSeqNum = o.LargestSeqNum
+		meta.InitPhysicalBacking()
+		meta.ExtendPointKeyBounds(d.cmp, o.Smallest.Clone(), o.Largest.Clone())
+		if err := meta.Validate(d.cmp, d.opts.Comparer.FormatKey); err != nil {
+			return nil, pendingOutputs, err
+		}
+		// Verify that the outputs are ordered and fall within the bounds of
+		// the compaction's inputs.
+		if n := len(ve.NewFiles); n > 0 && d.cmp(ve.NewFiles[n-1].Meta.Largest.UserKey, meta.Smallest.UserKey) >= 0 {
+			return nil, pendingOutputs, errors.Errorf("pebble: remote compaction outputs overlap: %s, %s",
+				ve.NewFiles[n-1].Meta, meta)
+		}
+		if d.cmp(meta.Smallest.UserKey, c.smallest.UserKey) < 0 || d.cmp(meta.Largest.UserKey, c.largest.UserKey) > 0 {
+			return nil, pendingOutputs, errors.Errorf("pebble: remote compaction output grew beyond bounds of input: %s", meta)
+		}
+		ve.NewFiles = append(ve.NewFiles, newFileEntry{
+			Level: c.outputLevel.level,
+			Meta:  meta,
+		})
+
+		outputMetrics.TablesCompacted++
+		outputMetrics.BytesCompacted += meta.Size
+		outputMetrics.Size += int64(meta.Size)
+		outputMetrics.NumFiles++
+	}
+
+	for _, cl := range c.inputs {
+		iter := cl.files.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			metrics[cl.level].NumFiles--
+			metrics[cl.level].Size -= int64(f.Size)
+			ve.DeletedFiles[deletedFileEntry{
+				Level:   cl.level,
+				FileNum: f.FileNum,
+			}] = f
+		}
+	}
+
+	// Persist the attached objects before the edit references them.
+	if err := d.objProvider.Sync(); err != nil {
+		return nil, pendingOutputs, err
+	}
+	c.metrics = metrics
+	return ve, pendingOutputs, nil
+}
+
+// RemoteCompactionWorker runs RemoteCompactionJobs in a worker process. The
+// worker uses its own objstorage.Provider, which must be configured with the
+// same remote storage as the DB, must create new objects on shared storage,
+// and must have a creator ID that differs from the DB's.
+//
+// Workers only process point keys; jobs whose inputs contain range deletions
+// or range keys fail with ErrRemoteCompactionUnsupported.
+type RemoteCompactionWorker struct {
+	opts        *Options
+	provider    objstorage.Provider
+	nextFileNum atomic.Uint64
+}
+
+// NewRemoteCompactionWorker returns a worker that reads and writes sstables
+// using the provided Provider. The Options must use the same Comparer and
+// Merger as the DB whose compactions are run.
+func NewRemoteCompactionWorker(
+	opts *Options, provider objstorage.Provider,
+) *RemoteCompactionWorker {
+	w := &RemoteCompactionWorker{
+		opts:     opts.Clone().EnsureDefaults(),
+		provider: provider,
+	}
+	var maxFileNum base.FileNum
+	for _, meta := range provider.List() {
+		if fileNum := meta.DiskFileNum.FileNum(); fileNum > maxFileNum {
+			maxFileNum = fileNum
+		}
+	}
+	w.nextFileNum.Store(uint64(maxFileNum))
+	return w
+}
+
+func (w *RemoteCompactionWorker) getNextFileNum() base.DiskFileNum {
+	return base.FileNum(w.nextFileNum.Add(1)).DiskFileNum()
+}
+
+// Run runs the job and returns its outputs. The worker retains a reference to
+// each output until it is passed to Release.
+func (w *RemoteCompactionWorker) Run(
+	ctx context.Context, job *RemoteCompactionJob,
+) (outputs []RemoteCompactionOutput, retErr error) {
+	// Attach the inputs to the worker's provider. The worker's references are
+	// dropped once the job completes.
+	toAttach := make([]objstorage.RemoteObjectToAttach, len(job.Inputs))
+	for i := range job.Inputs {
+		toAttach[i] = objstorage.RemoteObjectToAttach{
+			FileNum:  w.getNextFileNum(),
+			FileType: fileTypeTable,
+			Backing:  job.Inputs[i].Backing,
+		}
+	}
+	inputMetas, err := w.provider.AttachRemoteObjects(toAttach)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, meta := range inputMetas {
+			_ = w.provider.Remove(fileTypeTable, meta.DiskFileNum)
+		}
+	}()
+
+	var bufferPool sstable.BufferPool
+	bufferPool.Init(12)
+	defer bufferPool.Release()
+
+	var bytesIterated uint64
+	iters := make([]internalIterator, 0, len(inputMetas))
+	defer func() {
+		for _, iter := range iters {
+			retErr = firstError(retErr, iter.Close())
+		}
+	}()
+	for i, meta := range inputMetas {
+		readable, err := w.provider.OpenForReading(ctx, fileTypeTable, meta.DiskFileNum, objstorage.OpenOptions{})
+		if err != nil {
+			return nil, err
+		}
+		r, err := sstable.NewReader(readable, w.opts.MakeReaderOptions())
+		if err != nil {
+			return nil, err
+		}
+		if r.Properties.NumRangeDeletions > 0 || r.Properties.NumRangeKeys() > 0 {
+			_ = r.Close()
+			return nil, errors.Wrapf(ErrRemoteCompactionUnsupported, "input %s", job.Inputs[i].FileNum)
+		}
+		iter, err := r.NewCompactionIter(&bytesIterated, sstable.TrivialReaderProvider{Reader: r}, &bufferPool)
+		if err != nil {
+			_ = r.Close()
+			return nil, err
+		}
+		iters = append(iters, &readerClosingIter{Iterator: iter, r: r})
+	}
+
+	cmp := w.opts.Comparer.Compare
+	var rangeDelFrag, rangeKeyFrag keyspan.Fragmenter
+	elideTombstone := func(key []byte) bool { return job.ElideTombstones }
+	elideRangeTombstone := func(start, end []byte) bool { return false }
+	iter := newCompactionIter(cmp, w.opts.Comparer.Equal, w.opts.Comparer.FormatKey,
+		w.opts.Merger.Merge, newMergingIter(w.opts.Logger, &base.InternalIteratorStats{}, cmp, nil, iters...),
+		job.Snapshots, &rangeDelFrag, &rangeKeyFrag, false /* allowZeroSeqNum */, elideTombstone,
+		elideRangeTombstone, job.FormatMajorVersion)
+	// The compaction iterator closes the merging iterator, which closes the
+	// input iterators.
+	iters = nil
+	defer func() {
+		retErr = firstError(retErr, iter.Close())
+	}()
+
+	var created []base.DiskFileNum
+	defer func() {
+		if retErr != nil {
+			for _, fileNum := range created {
+				_ = w.provider.Remove(fileTypeTable, fileNum)
+			}
+			outputs = nil
+		}
+	}()
+
+	writerOpts := compactionWriterOptions(w.opts, job.FormatMajorVersion, job.OutputLevel)
+	var prevPointKey sstable.PreviousPointKeyOpt
+	var tw *sstable.Writer
+	var twFileNum base.DiskFileNum
+	defer func() {
+		if tw != nil {
+			retErr = firstError(retErr, tw.Close())
+		}
+	}()
+	finishOutput := func() error {
+		err := tw.Close()
+		writerMeta, metaErr := tw.Metadata()
+		tw = nil
+		if err = firstError(err, metaErr); err != nil {
+			return err
+		}
+		objMeta, err := w.provider.Lookup(fileTypeTable, twFileNum)
+		if err != nil {
+			return err
+		}
+		h, err := w.provider.RemoteObjectBacking(&objMeta)
+		if err != nil {
+			return err
+		}
+		defer h.Close()
+		backing, err := h.Get()
+		if err != nil {
+			return err
+		}
+		outputs = append(outputs, RemoteCompactionOutput{
+			WorkerFileNum:  twFileNum.FileNum(),
+			Backing:        append(objstorage.RemoteObjectBacking(nil), backing...),
+			Size:           writerMeta.Size,
+			Smallest:       writerMeta.SmallestPoint.Clone(),
+			Largest:        writerMeta.LargestPoint.Clone(),
+			SmallestSeqNum: writerMeta.SmallestSeqNum,
+			LargestSeqNum:  writerMeta.LargestSeqNum,
+		})
+		return nil
+	}
+
+	for key, val := iter.First(); key != nil; key, val = iter.Next() {
+		if tw != nil && tw.EstimatedSize() >= uint64(job.TargetFileSize) &&
+			cmp(prevPointKey.UnsafeKey().UserKey, key.UserKey) != 0 {
+			if err := finishOutput(); err != nil {
+				return nil, err
+			}
+		}
+		if tw == nil {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			twFileNum = w.getNextFileNum()
+			writable, objMeta, err := w.provider.Create(ctx, fileTypeTable, twFileNum,
+				objstorage.CreateOptions{PreferSharedStorage: true})
+			if err != nil {
+				return nil, err
+			}
+			if !objMeta.IsShared() {
+				writable.Abort()
+				return nil, errors.New("pebble: remote compaction worker must create outputs on shared storage")
+			}
+			created = append(created, twFileNum)
+			tw = sstable.NewWriter(writable, writerOpts, &prevPointKey)
+		}
+		if err := tw.AddWithForceObsolete(*key, val, iter.forceObsoleteDueToRangeDel); err != nil {
+			return nil, err
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	if tw != nil {
+		if err := finishOutput(); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.provider.Sync(); err != nil {
+		return nil, err
+	}
+	return outputs, nil
+}
+
+// Release drops the worker's references to outputs returned by Run.
+func (w *RemoteCompactionWorker) Release(outputs []RemoteCompactionOutput) error {
+	var err error
+	for _, o := range outputs {
+		err = firstError(err, w.provider.Remove(fileTypeTable, o.WorkerFileNum.DiskFileNum()))
+	}
+	return err
+}
+
+// readerClosingIter closes the sstable reader backing an iterator when the
+// iterator is closed.
+type readerClosingIter struct {
+	sstable.Iterator
+	r *sstable.Reader
+}
+
+func (i *readerClosingIter) Close() error {
+	return firstError(i.Iterator.Close(), i.r.Close())
+}
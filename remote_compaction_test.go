@@ -0,0 +1,151 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
+	"github.com/cockroachdb/pebble/objstorage/remote"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+// testRemoteCompactor runs jobs in-process using a RemoteCompactionWorker
+// with its own objstorage provider.
+type testRemoteCompactor struct {
+	worker *RemoteCompactionWorker
+	err    error
+
+	mu       sync.Mutex
+	jobs     int
+	outputs  int
+	released int
+	lastErr  error
+}
+
+func (c *testRemoteCompactor) Compact(
+	ctx context.Context, job *RemoteCompactionJob,
+) ([]RemoteCompactionOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.jobs++
+	if c.err != nil {
+		return nil, c.err
+	}
+	outputs, err := c.worker.Run(ctx, job)
+	c.lastErr = err
+	c.outputs += len(outputs)
+	return outputs, err
+}
+
+func (c *testRemoteCompactor) Release(outputs []RemoteCompactionOutput) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.released += len(outputs)
+	if err := c.worker.Release(outputs); err != nil {
+		panic(err)
+	}
+}
+
+func TestRemoteCompaction(t *testing.T) {
+	storage := remote.MakeSimpleFactory(map[remote.Locator]remote.Storage{
+		"": remote.NewInMem(),
+	})
+
+	// The worker has its own provider, configured with the same remote
+	// storage as the DB.
+	workerOpts := (&Options{FS: vfs.NewMem()}).EnsureDefaults()
+	settings := objstorageprovider.DefaultSettings(workerOpts.FS, "")
+	settings.Remote.StorageFactory = storage
+	settings.Remote.CreateOnShared = true
+	provider, err := objstorageprovider.Open(settings)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, provider.Close())
+	}()
+	require.NoError(t, provider.SetCreatorID(2))
+	compactor := &testRemoteCompactor{worker: NewRemoteCompactionWorker(workerOpts, provider)}
+
+	opts := &Options{
+		FS:                          vfs.NewMem(),
+		FormatMajorVersion:          ExperimentalFormatVirtualSSTables,
+		DisableAutomaticCompactions: true,
+	}
+	opts.Experimental.RemoteStorage = storage
+	opts.Experimental.CreateOnShared = true
+	opts.Experimental.RemoteCompactor = compactor
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+	require.NoError(t, d.SetCreatorID(1))
+
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 100; j++ {
+			key := []byte(fmt.Sprintf("key%03d", j))
+			value := []byte(fmt.Sprintf("val%d-%03d", i, j))
+			require.NoError(t, d.Set(key, value, nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+	require.NoError(t, d.Delete([]byte("key050"), nil))
+	require.NoError(t, d.Flush())
+
+	// The compaction is run by the worker and its outputs are installed into
+	// the DB.
+	require.NoError(t, d.Compact([]byte("key"), []byte("key999"), false))
+	compactor.mu.Lock()
+	require.Equal(t, 1, compactor.jobs)
+	require.NoError(t, compactor.lastErr)
+	require.Equal(t, 1, compactor.outputs)
+	require.Equal(t, 1, compactor.released)
+	compactor.mu.Unlock()
+
+	d.mu.Lock()
+	files := d.mu.versions.currentVersion().Levels[numLevels-1].Slice()
+	d.mu.Unlock()
+	require.Equal(t, 1, files.Len())
+	iter := files.Iter()
+	objMeta, err := d.objProvider.Lookup(fileTypeTable, iter.First().FileBacking.DiskFileNum)
+	require.NoError(t, err)
+	require.True(t, objMeta.IsShared())
+	require.Equal(t, objstorage.CreatorID(2), objMeta.Remote.CreatorID)
+	for j := 0; j < 100; j++ {
+		key := []byte(fmt.Sprintf("key%03d", j))
+		if j == 50 {
+			verifyGetNotFound(t, d, key)
+			continue
+		}
+		verifyGet(t, d, key, []byte(fmt.Sprintf("val1-%03d", j)))
+	}
+
+	// The worker does not process range deletions, so the DB compacts them
+	// locally.
+	require.NoError(t, d.DeleteRange([]byte("key000"), []byte("key010"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("key"), []byte("key999"), false))
+	compactor.mu.Lock()
+	require.Equal(t, 2, compactor.jobs)
+	require.True(t, errors.Is(compactor.lastErr, ErrRemoteCompactionUnsupported))
+	compactor.mu.Unlock()
+	verifyGetNotFound(t, d, []byte("key005"))
+	verifyGet(t, d, []byte("key010"), []byte("val1-010"))
+
+	// A failing compactor also falls back to local compactions.
+	compactor.mu.Lock()
+	compactor.err = errors.New("worker unavailable")
+	compactor.mu.Unlock()
+	require.NoError(t, d.Set([]byte("key020"), []byte("new"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("key"), []byte("key999"), false))
+	verifyGet(t, d, []byte("key020"), []byte("new"))
+}
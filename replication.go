@@ -0,0 +1,598 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/record"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// The ingestions and excises of a DB with a WAL archive are recorded in the
+// archive, alongside the archived WAL files: each is recorded in an event file
+// named replication-<WAL file number>-<sequence number>, and the sstables it
+// ingested are hard-linked into the archive as replication-<file number>.sst.
+const (
+	replicationFilePrefix  = "replication-"
+	replicationTableSuffix = ".sst"
+	replicationEventHeader = "pebble-replication-event v1"
+)
+
+// ReplicationSegment is a sealed WAL file of a primary DB, read by a
+// ReplicationSource, along with the ingestions and excises, which bypass the
+// WAL, that were applied while it was the current WAL file.
+type ReplicationSegment struct {
+	// FileNum is the file number of the WAL file.
+	FileNum base.DiskFileNum
+	// Data is the content of the WAL file. It is shipped as is, and is
+	// encrypted if the WAL of the primary is (see Options.WALKeyManager).
+	Data []byte
+	// Events are the ingestions and excises, in sequence number order.
+	Events []ReplicationEvent
+}
+
+// ReplicationEvent is an ingestion or an excise applied to a primary DB.
+type ReplicationEvent struct {
+	// SeqNum is the sequence number of the event. The ingested sstables are
+	// assigned consecutive sequence numbers starting at SeqNum; an excise
+	// without sstables consumes SeqNum alone.
+	SeqNum uint64
+	// Excise is the span excised by the event, if valid.
+	Excise KeyRange
+	// Tables are the ingested sstables.
+	Tables []ReplicationTable
+}
+
+// ReplicationTable is an sstable ingested by a ReplicationEvent.
+type ReplicationTable struct {
+	// Path is the path of the sstable in the WAL archive of the primary, on
+	// the primary's FS.
+	Path string
+	Size int64
+	// Start and End are the bounds of the slice of the sstable that was
+	// ingested (see DB.IngestSlices), if set.
+	Start, End []byte
+}
+
+// seqNumCount returns the number of sequence numbers consumed by the event.
+func (e *ReplicationEvent) seqNumCount() uint64 {
+	if len(e.Tables) == 0 {
+		return 1
+	}
+	return uint64(len(e.Tables))
+}
+
+// ReplicationSource reads the sealed WAL files of a primary DB, and the
+// ingestions and excises applied to it, for shipping to followers, which apply
+// them with DB.ApplyReplicationSegment. It reads the live WAL files of the
+// primary and the WAL files archived into Options.WALArchive.Dir, which must
+// be set: the ingestions and excises are recorded in the archive. A WAL file
+// is sealed, and returned, once a later WAL file exists; see DB.Flush to seal
+// the current WAL file.
+//
+// Batches committed with WriteOptions.DisableWAL, and ingestions of shared or
+// external sstables, can't be replicated; followers fail to apply the segments
+// that follow them.
+//
+// A ReplicationSource is not safe for concurrent use.
+type ReplicationSource struct {
+	d *DB
+	// next is the file number of the next WAL file to return. Before the first
+	// WAL file is returned, the WAL file must exist, unless next is zero, in
+	// which case the oldest available WAL file is returned first.
+	next    base.DiskFileNum
+	started bool
+}
+
+// NewReplicationSource returns a ReplicationSource that reads the sealed WAL
+// files of the DB starting with the WAL file numbered start, or with the
+// oldest available WAL file if start is zero. A follower resumes at the
+// FileNum of the last segment it applied: the batches it already applied are
+// skipped.
+func (d *DB) NewReplicationSource(start base.DiskFileNum) (*ReplicationSource, error) {
+	if d.opts.WALArchive.Dir == "" {
+		return nil, errors.New("pebble: replication requires Options.WALArchive.Dir")
+	}
+	return &ReplicationSource{d: d, next: start}, nil
+}
+
+// Next returns the next sealed WAL file. It returns nil if the WAL file isn't
+// sealed yet; the caller may call Next again later.
+func (s *ReplicationSource) Next() (*ReplicationSegment, error) {
+	d := s.d
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	// The WAL files are listed before the ingestions and excises being
+	// recorded: an event is recorded while its WAL file is the current one, so
+	// the events of a sealed WAL file are either being recorded, or recorded.
+	segs, err := d.walSegments()
+	if err != nil {
+		return nil, err
+	}
+	i := sort.Search(len(segs), func(i int) bool {
+		return segs[i].fileNum.FileNum() >= s.next.FileNum()
+	})
+	if i >= len(segs)-1 {
+		// The WAL file doesn't exist yet, or isn't sealed.
+		return nil, nil
+	}
+	seg := segs[i]
+	if !s.started && s.next.FileNum() != 0 && seg.fileNum != s.next {
+		return nil, errors.Errorf("pebble: WAL file %s is no longer available", errors.Safe(s.next))
+	}
+	d.mu.Lock()
+	for _, walNum := range d.mu.log.replicating {
+		if walNum.FileNum() <= seg.fileNum.FileNum() {
+			d.mu.Unlock()
+			return nil, nil
+		}
+	}
+	d.mu.Unlock()
+
+	data, err := readWALSegment(d, seg)
+	if err != nil {
+		return nil, err
+	}
+	events, err := readReplicationEvents(d.opts.FS, d.opts.WALArchive.Dir, seg.fileNum)
+	if err != nil {
+		return nil, err
+	}
+	s.started = true
+	s.next = base.FileNum(seg.fileNum.FileNum() + 1).DiskFileNum()
+	return &ReplicationSegment{FileNum: seg.fileNum, Data: data, Events: events}, nil
+}
+
+// readWALSegment reads the content of a WAL file.
+func readWALSegment(d *DB, seg walSegment) ([]byte, error) {
+	fs := d.opts.FS
+	f, err := fs.Open(seg.path)
+	if oserror.IsNotExist(err) {
+		// The WAL file was being archived, and was deleted once archived since
+		// it was listed. It's now listed in the archive.
+		var segs []walSegment
+		if segs, err = d.walSegments(); err != nil {
+			return nil, err
+		}
+		for i := range segs {
+			if segs[i].fileNum == seg.fileNum {
+				f, err = fs.Open(segs[i].path)
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// ApplyReplicationSegment applies a sealed WAL file of a primary DB, and the
+// ingestions and excises that bypassed it, to the DB, which is a follower of
+// the primary (see ReplicationSource). The batches and events are applied in
+// sequence number order with the sequence numbers they were assigned on the
+// primary, so the follower must have been created from the primary (e.g. as a
+// checkpoint of it, or as a new DB with the same options before the primary
+// was written to), and must not otherwise be written to. Those already
+// applied are skipped, and a gap in the sequence numbers, such as a segment
+// missing, is an error.
+//
+// The sstables ingested by the events must have been transferred to the
+// follower beforehand: tablePath returns the path of each on the follower's
+// FS, from which it's removed once ingested.
+//
+// Reads of the follower observe the state of the primary as of the sequence
+// number returned by ReplicatedSeqNum, which advances as the segment is
+// applied. The follower's WAL is synced once the segment is applied.
+func (d *DB) ApplyReplicationSegment(
+	seg *ReplicationSegment, tablePath func(ReplicationTable) string,
+) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	batches, err := decodeWALSegment(seg, d.opts.WALKeyManager)
+	if err != nil {
+		return err
+	}
+	events := seg.Events
+	for len(batches) > 0 || len(events) > 0 {
+		var seqNum, count uint64
+		var event *ReplicationEvent
+		var batch *WALBatch
+		if len(events) > 0 && (len(batches) == 0 || events[0].SeqNum <= batches[0].SeqNum) {
+			event = &events[0]
+			events = events[1:]
+			seqNum, count = event.SeqNum, event.seqNumCount()
+		} else {
+			batch = &batches[0]
+			batches = batches[1:]
+			seqNum, count = batch.SeqNum, uint64(batch.Count)
+		}
+		if count == 0 {
+			// A batch only holding LogData records.
+			continue
+		}
+		if batch != nil {
+			r := batch.Reader()
+			if kind, _, _, ok := r.Next(); ok && kind == InternalKeyKindIngestSST {
+				// The record of an ingestion applied as a flushable, which is
+				// replicated by its event. The event may belong to the next
+				// WAL file, as the WAL is rotated once the record is written.
+				continue
+			}
+		}
+		next := d.mu.versions.logSeqNum.Load()
+		if seqNum+count <= next {
+			// Already applied.
+			continue
+		}
+		if seqNum != next {
+			return errors.Errorf("pebble: replication gap: WAL file %s resumes at sequence number %d, expected %d",
+				errors.Safe(seg.FileNum), errors.Safe(seqNum), errors.Safe(next))
+		}
+		if event != nil {
+			err = d.applyReplicationEvent(event, tablePath)
+		} else {
+			err = d.applyReplicatedBatch(batch)
+		}
+		if err != nil {
+			return err
+		}
+		if next := d.mu.versions.logSeqNum.Load(); next != seqNum+count {
+			return errors.AssertionFailedf("pebble: replicated sequence numbers [%d, %d) were applied as [%d, %d)",
+				seqNum, seqNum+count, seqNum, next)
+		}
+	}
+	if d.opts.DisableWAL {
+		return nil
+	}
+	return d.LogData(nil, Sync)
+}
+
+// ReplicatedSeqNum returns the sequence number following the batches and
+// events applied to the DB: reads of the DB observe the state of the primary
+// it's a follower of (see ApplyReplicationSegment) as of that sequence number.
+func (d *DB) ReplicatedSeqNum() uint64 {
+	return d.mu.versions.visibleSeqNum.Load()
+}
+
+func (d *DB) applyReplicatedBatch(wb *WALBatch) error {
+	b := d.NewBatch()
+	if err := b.SetRepr(wb.Repr); err != nil {
+		return err
+	}
+	if err := d.Apply(b, NoSync); err != nil {
+		return err
+	}
+	return b.Close()
+}
+
+func (d *DB) applyReplicationEvent(
+	e *ReplicationEvent, tablePath func(ReplicationTable) string,
+) error {
+	paths := make([]string, len(e.Tables))
+	var spans []KeyRange
+	for i, t := range e.Tables {
+		paths[i] = tablePath(t)
+		if t.Start != nil {
+			if spans == nil {
+				spans = make([]KeyRange, len(e.Tables))
+			}
+			spans[i] = KeyRange{Start: t.Start, End: t.End}
+		}
+	}
+	// The sstables must not be ingested as a batch, which would consume a
+	// sequence number per key.
+	_, err := d.ingest(paths, spans, ingestTargetLevel, nil /* shared */, e.Excise, nil /* external */, IngestOptions{disableIngestAsBatch: true})
+	if err != nil || spans == nil {
+		return err
+	}
+	// The sstables slices were ingested from are left in place.
+	for _, path := range paths {
+		if err := d.opts.FS.Remove(path); err != nil {
+			d.opts.Logger.Infof("replication failed to remove ingested file: %s", err)
+		}
+	}
+	return nil
+}
+
+// decodeWALSegment decodes the batches of a WAL file.
+func decodeWALSegment(seg *ReplicationSegment, km WALKeyManager) ([]WALBatch, error) {
+	var batches []WALBatch
+	rr := record.NewReader(bytes.NewReader(seg.Data), seg.FileNum.FileNum())
+	dec := walDecoder{km: km}
+	var buf bytes.Buffer
+	for {
+		buf.Reset()
+		r, err := rr.Next()
+		if err == nil {
+			_, err = io.Copy(&buf, r)
+		}
+		if err != nil {
+			if err == io.EOF || record.IsInvalidRecord(err) || errors.Is(err, io.ErrUnexpectedEOF) {
+				// The end of the records written to the file.
+				return batches, nil
+			}
+			return nil, err
+		}
+		repr, err := dec.decode(buf.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := decodeWALCommitTime(repr); ok || repr == nil {
+			// The encryption header of the WAL file, or a commit time record.
+			continue
+		}
+		if len(repr) < batchHeaderLen {
+			return nil, base.CorruptionErrorf("pebble: corrupt WAL file %s", errors.Safe(seg.FileNum))
+		}
+		b := WALBatch{Repr: append([]byte(nil), repr...)}
+		b.SeqNum = binary.LittleEndian.Uint64(b.Repr[:batchCountOffset])
+		b.Count = binary.LittleEndian.Uint32(b.Repr[batchCountOffset:batchHeaderLen])
+		batches = append(batches, b)
+	}
+}
+
+// replicationEvent is an ingestion or an excise being recorded in the WAL
+// archive.
+type replicationEvent struct {
+	walNum base.DiskFileNum
+	ReplicationEvent
+}
+
+// linkReplicationTables hard-links the sstables of an ingestion into the WAL
+// archive, before they are ingested, and returns the event recording the
+// ingestion. It returns nil if the ingestion isn't recorded.
+func (d *DB) linkReplicationTables(
+	lr ingestLoadResult, spans []KeyRange, pending []base.DiskFileNum, exciseSpan KeyRange,
+) (*replicationEvent, error) {
+	dir := d.opts.WALArchive.Dir
+	if dir == "" || len(lr.sharedMeta) > 0 || len(lr.externalMeta) > 0 {
+		return nil, nil
+	}
+	fs := d.opts.FS
+	e := &replicationEvent{ReplicationEvent: ReplicationEvent{Excise: exciseSpan}}
+	if len(lr.localMeta) > 0 {
+		if err := fs.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	for i, m := range lr.localMeta {
+		fileNum := m.FileBacking.DiskFileNum
+		t := ReplicationTable{
+			Path: fs.PathJoin(dir, replicationFilePrefix+fileNum.String()+replicationTableSuffix),
+			Size: int64(m.FileBacking.Size),
+		}
+		if spans != nil {
+			for j := range pending {
+				if pending[j] == fileNum {
+					t.Start, t.End = spans[j].Start, spans[j].End
+				}
+			}
+		}
+		if err := vfs.LinkOrCopy(fs, lr.localPaths[i], t.Path); err != nil {
+			e.removeTables(fs)
+			return nil, err
+		}
+		e.Tables = append(e.Tables, t)
+	}
+	return e, nil
+}
+
+// beginReplicationEventLocked registers an event being recorded, once it's
+// assigned a sequence number. The WAL file being written to is the WAL file
+// of the event, and isn't sealed until the event is recorded.
+//
+// d.mu must be held when calling this.
+func (d *DB) beginReplicationEventLocked(e *replicationEvent, seqNum uint64) {
+	e.SeqNum = seqNum
+	if n := len(d.mu.log.queue); n > 0 {
+		e.walNum = d.mu.log.queue[n-1].fileNum
+	}
+	d.mu.log.replicating = append(d.mu.log.replicating, e.walNum)
+}
+
+// finishReplicationEvent records an event in the WAL archive once it's
+// applied, or removes its sstables from the WAL archive if it failed.
+func (d *DB) finishReplicationEvent(e *replicationEvent, applyErr error) {
+	fs := d.opts.FS
+	if applyErr != nil {
+		e.removeTables(fs)
+	} else if err := e.write(fs, d.opts.WALArchive.Dir); err != nil {
+		// Followers fail to apply the segments that follow the event.
+		d.opts.Logger.Infof("replication: failed to record the event at seqnum %d: %s", e.SeqNum, err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, walNum := range d.mu.log.replicating {
+		if walNum == e.walNum {
+			d.mu.log.replicating = append(d.mu.log.replicating[:i], d.mu.log.replicating[i+1:]...)
+			break
+		}
+	}
+}
+
+func (e *replicationEvent) removeTables(fs vfs.FS) {
+	for _, t := range e.Tables {
+		_ = fs.Remove(t.Path)
+	}
+}
+
+// write writes the event file, atomically.
+func (e *replicationEvent) write(fs vfs.FS, dir string) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s\nseqnum %d\n", replicationEventHeader, e.SeqNum)
+	if e.Excise.Valid() {
+		fmt.Fprintf(&buf, "excise %x %x\n", e.Excise.Start, e.Excise.End)
+	}
+	for _, t := range e.Tables {
+		fmt.Fprintf(&buf, "table %s %d", fs.PathBase(t.Path), t.Size)
+		if t.Start != nil {
+			fmt.Fprintf(&buf, " %x %x", t.Start, t.End)
+		}
+		buf.WriteByte('\n')
+	}
+	path := fs.PathJoin(dir, fmt.Sprintf("%s%s-%d", replicationFilePrefix, e.walNum, e.SeqNum))
+	if err := fs.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	f, err := fs.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return fs.Rename(tmpPath, path)
+}
+
+// parseReplicationEventName parses the name of an event file, returning the
+// file number of its WAL file.
+func parseReplicationEventName(name string) (walNum base.DiskFileNum, ok bool) {
+	if !strings.HasPrefix(name, replicationFilePrefix) || strings.HasSuffix(name, replicationTableSuffix) {
+		return base.DiskFileNum{}, false
+	}
+	walStr, seqNumStr, ok := strings.Cut(name[len(replicationFilePrefix):], "-")
+	if !ok {
+		return base.DiskFileNum{}, false
+	}
+	n, err := strconv.ParseUint(walStr, 10, 64)
+	if err != nil {
+		return base.DiskFileNum{}, false
+	}
+	if _, err := strconv.ParseUint(seqNumStr, 10, 64); err != nil {
+		return base.DiskFileNum{}, false
+	}
+	return base.FileNum(n).DiskFileNum(), true
+}
+
+// readReplicationEvents reads the events of a WAL file recorded in the WAL
+// archive, in sequence number order.
+func readReplicationEvents(
+	fs vfs.FS, dir string, walNum base.DiskFileNum,
+) ([]ReplicationEvent, error) {
+	ls, err := fs.List(dir)
+	if err != nil && !oserror.IsNotExist(err) {
+		return nil, err
+	}
+	var events []ReplicationEvent
+	for _, name := range ls {
+		if n, ok := parseReplicationEventName(name); !ok || n != walNum {
+			continue
+		}
+		e, err := readReplicationEvent(fs, dir, name)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].SeqNum < events[j].SeqNum })
+	return events, nil
+}
+
+func readReplicationEvent(fs vfs.FS, dir, name string) (ReplicationEvent, error) {
+	var e ReplicationEvent
+	path := fs.PathJoin(dir, name)
+	f, err := fs.Open(path)
+	if err != nil {
+		return e, err
+	}
+	defer f.Close()
+	corrupt := func() error {
+		return base.CorruptionErrorf("pebble: corrupt replication event %s", errors.Safe(path))
+	}
+	s := bufio.NewScanner(f)
+	if !s.Scan() || s.Text() != replicationEventHeader {
+		return e, corrupt()
+	}
+	decodeKey := func(str string) ([]byte, bool) {
+		b, err := hex.DecodeString(str)
+		// Keys may be empty, but are set.
+		return append([]byte{}, b...), err == nil
+	}
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		switch {
+		case len(fields) == 2 && fields[0] == "seqnum":
+			if e.SeqNum, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+				return e, corrupt()
+			}
+		case len(fields) == 3 && fields[0] == "excise":
+			var ok1, ok2 bool
+			e.Excise.Start, ok1 = decodeKey(fields[1])
+			e.Excise.End, ok2 = decodeKey(fields[2])
+			if !ok1 || !ok2 {
+				return e, corrupt()
+			}
+		case (len(fields) == 3 || len(fields) == 5) && fields[0] == "table":
+			t := ReplicationTable{Path: fs.PathJoin(dir, fields[1])}
+			if t.Size, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+				return e, corrupt()
+			}
+			if len(fields) == 5 {
+				var ok1, ok2 bool
+				t.Start, ok1 = decodeKey(fields[3])
+				t.End, ok2 = decodeKey(fields[4])
+				if !ok1 || !ok2 {
+					return e, corrupt()
+				}
+			}
+			e.Tables = append(e.Tables, t)
+		default:
+			return e, corrupt()
+		}
+	}
+	if err := s.Err(); err != nil {
+		return e, err
+	}
+	return e, nil
+}
+
+// pruneReplicationEvents deletes the events recorded in the WAL archive, and
+// their sstables, of the WAL files up to and including the given one, once
+// they're pruned from the archive.
+func pruneReplicationEvents(fs vfs.FS, dir string, ls []string, through base.DiskFileNum, logger Logger) {
+	for _, name := range ls {
+		walNum, ok := parseReplicationEventName(name)
+		if !ok || walNum.FileNum() > through.FileNum() {
+			continue
+		}
+		if e, err := readReplicationEvent(fs, dir, name); err == nil {
+			for _, t := range e.Tables {
+				if err := fs.Remove(t.Path); err != nil && !oserror.IsNotExist(err) {
+					logger.Infof("WAL archive: failed to remove %s: %s", t.Path, err)
+				}
+			}
+		}
+		path := fs.PathJoin(dir, name)
+		if err := fs.Remove(path); err != nil && !oserror.IsNotExist(err) {
+			logger.Infof("WAL archive: failed to remove %s: %s", path, err)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplication(t *testing.T) {
+	newOpts := func(fs vfs.FS) *Options {
+		opts := (&Options{
+			FS:                 fs,
+			FormatMajorVersion: internalFormatNewest,
+		}).WithFSDefaults()
+		opts.WALArchive.Dir = "archive"
+		return opts
+	}
+	primaryFS, followerFS := vfs.NewMem(), vfs.NewMem()
+	primary, err := Open("", newOpts(primaryFS))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, primary.Close()) }()
+	follower, err := Open("", newOpts(followerFS))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, follower.Close()) }()
+
+	writeSST := func(path string, keys ...string) {
+		f, err := primaryFS.Create(path)
+		require.NoError(t, err)
+		w := sstable.NewWriter(objstorageprovider.NewFileWritable(f), sstable.WriterOptions{
+			TableFormat: internalFormatNewest.MaxTableFormat(),
+		})
+		for _, k := range keys {
+			require.NoError(t, w.Set([]byte(k), []byte("ingested")))
+		}
+		require.NoError(t, w.Close())
+	}
+	// transfer copies the sstables of the events to the follower.
+	transfer := func(tbl ReplicationTable) string {
+		src, err := primaryFS.Open(tbl.Path)
+		require.NoError(t, err)
+		defer src.Close()
+		path := "transferred-" + primaryFS.PathBase(tbl.Path)
+		dst, err := followerFS.Create(path)
+		require.NoError(t, err)
+		_, err = io.Copy(dst, src)
+		require.NoError(t, err)
+		require.NoError(t, dst.Close())
+		return path
+	}
+	src, err := primary.NewReplicationSource(base.DiskFileNum{})
+	require.NoError(t, err)
+	var segs []*ReplicationSegment
+	// ship applies the sealed segments to the follower.
+	ship := func() error {
+		for {
+			seg, err := src.Next()
+			if err != nil || seg == nil {
+				return err
+			}
+			segs = append(segs, seg)
+			if err := follower.ApplyReplicationSegment(seg, transfer); err != nil {
+				return err
+			}
+		}
+	}
+	contents := func(d *DB) string {
+		iter, _ := d.NewIter(nil)
+		var s string
+		for valid := iter.First(); valid; valid = iter.Next() {
+			s += fmt.Sprintf("%s=%s ", iter.Key(), iter.Value())
+		}
+		require.NoError(t, iter.Close())
+		return s
+	}
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, primary.Set([]byte(fmt.Sprintf("a%d", i)), []byte("set"), nil))
+	}
+	require.NoError(t, primary.DeleteRange([]byte("a2"), []byte("a4"), nil))
+	// Nothing is shipped until the WAL file is sealed.
+	require.NoError(t, ship())
+	require.Empty(t, segs)
+	// An ingestion that doesn't overlap the memtable.
+	writeSST("ext1", "c1", "c2")
+	require.NoError(t, primary.Ingest([]string{"ext1"}))
+	// An ingestion that overlaps the memtable, which is ingested as a
+	// flushable and logged in the WAL.
+	writeSST("ext2", "a5", "a6")
+	require.NoError(t, primary.Ingest([]string{"ext2"}))
+	require.NoError(t, primary.Set([]byte("b"), []byte("set"), nil))
+	// A slice of an sstable, and an excise.
+	writeSST("ext3", "d1", "d2", "d3")
+	_, err = primary.IngestSlices([]IngestSlice{{Path: "ext3", Start: []byte("d2"), End: []byte("d9")}})
+	require.NoError(t, err)
+	require.NoError(t, primary.Excise(KeyRange{Start: []byte("a7"), End: []byte("a9")}))
+	require.NoError(t, primary.Set([]byte("e"), []byte("set"), nil))
+
+	require.NoError(t, primary.Flush())
+	require.NoError(t, ship())
+	require.NotEmpty(t, segs)
+	require.Equal(t, contents(primary), contents(follower))
+	require.Equal(t, primary.ReplicatedSeqNum(), follower.ReplicatedSeqNum())
+
+	// The events are recorded in the WAL archive.
+	var events int
+	for _, seg := range segs {
+		events += len(seg.Events)
+	}
+	require.Equal(t, 4, events)
+
+	// Reapplying a segment is a no-op.
+	require.NoError(t, follower.ApplyReplicationSegment(segs[0], transfer))
+	require.Equal(t, contents(primary), contents(follower))
+
+	// A source resumes at a segment.
+	resumed, err := primary.NewReplicationSource(segs[0].FileNum)
+	require.NoError(t, err)
+	seg, err := resumed.Next()
+	require.NoError(t, err)
+	require.Equal(t, segs[0].FileNum, seg.FileNum)
+
+	// Batches that aren't written to the WAL can't be replicated.
+	require.NoError(t, primary.Set([]byte("f"), []byte("set"), NoSync))
+	require.NoError(t, primary.Set([]byte("g"), []byte("set"), &WriteOptions{DisableWAL: true}))
+	require.NoError(t, primary.Set([]byte("h"), []byte("set"), NoSync))
+	require.NoError(t, primary.Flush())
+	err = ship()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "replication gap")
+
+	// A source requires a WAL archive.
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	_, err = d.NewReplicationSource(base.DiskFileNum{})
+	require.Error(t, err)
+}
@@ -0,0 +1,365 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"io"
+	"sort"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/record"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// restoreOptions hold the optional parameters of restores of backups.
+type restoreOptions struct {
+	// spans, if set, restrict the restore to the keys within them.
+	spans []KeyRange
+	// dbOpts are the options the restored DB is opened with to remove the keys
+	// outside of spans.
+	dbOpts *Options
+	// progress, if set, is invoked as files are restored.
+	progress func(RestoreProgress)
+}
+
+// RestoreOption sets optional parameters of RestoreBackup and
+// RestoreRemoteBackup.
+type RestoreOption func(*restoreOptions)
+
+// WithRestoreSpans restricts the restore to the keys within spans. The
+// sstables of the backup that don't overlap any of the spans aren't restored,
+// and the keys of the other sstables (and of the WALs) that are outside the
+// spans are then excised from the restored DB, which turns the sstables that
+// straddle the bounds of the spans into virtual sstables constrained to the
+// spans. The restored DB is opened with opts to do so, so opts must be
+// compatible with the backed up DB (in particular, its Comparer and Merger);
+// opts.FS is ignored.
+//
+// Restoring spans requires the backed up DB to have a format major version of
+// at least ExperimentalFormatVirtualSSTables.
+func WithRestoreSpans(opts *Options, spans ...KeyRange) RestoreOption {
+	return func(opt *restoreOptions) {
+		opt.spans = spans
+		opt.dbOpts = opts
+	}
+}
+
+// WithRestoreProgress sets a function invoked with the progress of the restore
+// after each file is restored, and periodically while files are restored. It's
+// invoked synchronously by the restore.
+func WithRestoreProgress(fn func(RestoreProgress)) RestoreOption {
+	return func(opt *restoreOptions) {
+		opt.progress = fn
+	}
+}
+
+// RestoreProgress is the progress of a restore (see WithRestoreProgress).
+type RestoreProgress struct {
+	// Files is the number of files restored so far, out of TotalFiles.
+	Files, TotalFiles int
+	// Bytes is the size of the files restored so far, out of TotalBytes. The
+	// files other than the sstables are restored first, and the sstables are
+	// only accounted for in TotalFiles and TotalBytes once they are, as the
+	// sstables to restore are then determined from the restored MANIFEST.
+	Bytes, TotalBytes int64
+}
+
+// restoreFile is a file of a backup to restore.
+type restoreFile struct {
+	// name is the name of the file in the restored DB.
+	name string
+	size int64
+	// copy copies the file to dst.
+	copy func(c *checkpointCopier, dst string) error
+}
+
+// restorer restores the files of a backup into a directory.
+type restorer struct {
+	fs      vfs.FS
+	destDir string
+	opt     *restoreOptions
+	c       *checkpointCopier
+}
+
+func newRestorer(fs vfs.FS, destDir string, opts []RestoreOption) (*restorer, error) {
+	r := &restorer{fs: fs, destDir: destDir, opt: &restoreOptions{}}
+	for _, fn := range opts {
+		fn(r.opt)
+	}
+	if len(r.opt.spans) > 0 {
+		if r.opt.dbOpts == nil {
+			r.opt.dbOpts = &Options{}
+		}
+		r.opt.dbOpts = r.opt.dbOpts.Clone()
+		r.opt.dbOpts.FS = fs
+		r.opt.dbOpts.EnsureDefaults()
+		for i := range r.opt.spans {
+			s := &r.opt.spans[i]
+			if !s.Valid() || r.opt.dbOpts.Comparer.Compare(s.Start, s.End) >= 0 {
+				return nil, errors.Errorf("pebble: invalid restore span [%s, %s)",
+					r.opt.dbOpts.Comparer.FormatKey(s.Start), r.opt.dbOpts.Comparer.FormatKey(s.End))
+			}
+		}
+	}
+	ckOpt := &checkpointOptions{}
+	if r.opt.progress != nil {
+		ckOpt.progress = func(p CheckpointProgress) {
+			r.opt.progress(RestoreProgress{
+				Files:      p.Files,
+				TotalFiles: p.TotalFiles,
+				Bytes:      p.Bytes,
+				TotalBytes: p.TotalBytes,
+			})
+		}
+	}
+	r.c = newCheckpointCopier(fs, ckOpt)
+	return r, nil
+}
+
+// restoreFiles restores the files of a backup other than its sstables and
+// blob files, and then the sstables and blob files, keyed by their file
+// number, that are needed by the version in the restored MANIFEST.
+func (r *restorer) restoreFiles(
+	files []restoreFile, tables, blobFiles map[base.DiskFileNum]restoreFile,
+) error {
+	var manifestFileNum base.DiskFileNum
+	var manifestSize int64
+	manifestFound := false
+	for _, f := range files {
+		if fileType, fileNum, ok := base.ParseFilename(r.fs, f.name); ok && fileType == fileTypeManifest {
+			manifestFileNum, manifestSize, manifestFound = fileNum, f.size, true
+		}
+		r.c.addFile(f.size)
+	}
+	if !manifestFound {
+		return errors.New("pebble: backup has no MANIFEST")
+	}
+	for _, f := range files {
+		if err := f.copy(r.c, r.fs.PathJoin(r.destDir, f.name)); err != nil {
+			return err
+		}
+	}
+
+	// Determine the sstables to restore.
+	manifestPath := base.MakeFilepath(r.fs, r.destDir, fileTypeManifest, manifestFileNum)
+	live, err := readManifestTables(r.fs, manifestPath, manifestFileNum)
+	if err != nil {
+		return err
+	}
+	cmp := base.DefaultComparer.Compare
+	if r.opt.dbOpts != nil {
+		cmp = r.opt.dbOpts.Comparer.Compare
+	}
+	required := make(map[base.DiskFileNum]struct{})
+	requiredBlobFiles := make(map[base.DiskFileNum]struct{})
+	virtualBackings := make(map[base.DiskFileNum]struct{})
+	var excludedFiles map[deletedFileEntry]*fileMetadata
+	for _, t := range live {
+		if t.meta.Virtual {
+			virtualBackings[t.backing] = struct{}{}
+		}
+		if len(r.opt.spans) > 0 && !overlapsSpans(t.meta, r.opt.spans, cmp) {
+			if excludedFiles == nil {
+				excludedFiles = make(map[deletedFileEntry]*fileMetadata)
+			}
+			excludedFiles[deletedFileEntry{Level: t.level, FileNum: t.meta.FileNum}] = t.meta
+			continue
+		}
+		required[t.backing] = struct{}{}
+		for _, ref := range t.meta.BlobReferences {
+			requiredBlobFiles[ref.FileNum] = struct{}{}
+		}
+	}
+	if len(excludedFiles) > 0 {
+		// Rewrite the MANIFEST with an edit removing the excluded sstables.
+		var removeBackingTables []base.DiskFileNum
+		for fileNum := range virtualBackings {
+			if _, ok := required[fileNum]; !ok {
+				removeBackingTables = append(removeBackingTables, fileNum)
+			}
+		}
+		tmpPath := manifestPath + ".tmp"
+		if err := r.fs.Rename(manifestPath, tmpPath); err != nil {
+			return err
+		}
+		err := copyManifest(r.fs, tmpPath, manifestPath, manifestFileNum, manifestSize, excludedFiles, removeBackingTables)
+		if err != nil {
+			return err
+		}
+		if err := r.fs.Remove(tmpPath); err != nil {
+			return err
+		}
+	}
+
+	var toRestore []restoreFile
+	for _, req := range []struct {
+		fileNums  map[base.DiskFileNum]struct{}
+		available map[base.DiskFileNum]restoreFile
+		kind      string
+	}{
+		{required, tables, "sstable"},
+		{requiredBlobFiles, blobFiles, "blob file"},
+	} {
+		fileNums := make([]base.DiskFileNum, 0, len(req.fileNums))
+		for fileNum := range req.fileNums {
+			if _, ok := req.available[fileNum]; !ok {
+				return errors.Errorf("pebble: backup has no %s %s", req.kind, fileNum)
+			}
+			fileNums = append(fileNums, fileNum)
+		}
+		sort.Slice(fileNums, func(i, j int) bool { return fileNums[i].FileNum() < fileNums[j].FileNum() })
+		for _, fileNum := range fileNums {
+			toRestore = append(toRestore, req.available[fileNum])
+			r.c.addFile(req.available[fileNum].size)
+		}
+	}
+	for _, t := range toRestore {
+		if err := t.copy(r.c, r.fs.PathJoin(r.destDir, t.name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// excise removes the keys outside of the restore spans from the restored DB,
+// once its files are restored.
+func (r *restorer) excise() error {
+	if len(r.opt.spans) == 0 {
+		return nil
+	}
+	vers, marker, err := lookupFormatMajorVersion(r.fs, r.destDir)
+	if err != nil {
+		return err
+	}
+	if err := marker.Close(); err != nil {
+		return err
+	}
+	if vers < ExperimentalFormatVirtualSSTables {
+		return errors.Errorf("pebble: restoring spans requires a format major version of at least %s, the backup has %s",
+			ExperimentalFormatVirtualSSTables, vers)
+	}
+	// Open the DB at its format major version, rather than ratcheting it.
+	r.opt.dbOpts.FormatMajorVersion = vers
+	d, err := Open(r.destDir, r.opt.dbOpts)
+	if err != nil {
+		return err
+	}
+	return errors.CombineErrors(d.exciseOutside(r.opt.spans), d.Close())
+}
+
+// exciseOutside excises all the keys of the DB outside of spans.
+func (d *DB) exciseOutside(spans []KeyRange) error {
+	// Flush the memtables, so that the bounds of the keys of the DB are those
+	// of its sstables.
+	if err := d.Flush(); err != nil {
+		return err
+	}
+	var smallest, largest []byte
+	rs := d.loadReadState()
+	for l := range rs.current.Levels {
+		iter := rs.current.Levels[l].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if smallest == nil || d.cmp(f.Smallest.UserKey, smallest) < 0 {
+				smallest = f.Smallest.UserKey
+			}
+			if largest == nil || d.cmp(f.Largest.UserKey, largest) > 0 {
+				largest = f.Largest.UserKey
+			}
+		}
+	}
+	rs.unref()
+	if smallest == nil {
+		return nil
+	}
+	smallest = append([]byte(nil), smallest...)
+	// The successor of the prefix of the largest key is larger than all the
+	// keys with that prefix.
+	prefixLen := len(largest)
+	if d.opts.Comparer.Split != nil {
+		prefixLen = d.opts.Comparer.Split(largest)
+	}
+	end := d.opts.Comparer.ImmediateSuccessor(nil, largest[:prefixLen])
+
+	spans = append([]KeyRange(nil), spans...)
+	sort.Slice(spans, func(i, j int) bool { return d.cmp(spans[i].Start, spans[j].Start) < 0 })
+	start := smallest
+	for _, s := range spans {
+		if d.cmp(start, end) >= 0 {
+			return nil
+		}
+		if d.cmp(start, s.Start) < 0 {
+			exciseEnd := s.Start
+			if d.cmp(exciseEnd, end) > 0 {
+				exciseEnd = end
+			}
+			if err := d.Excise(KeyRange{Start: start, End: exciseEnd}); err != nil {
+				return err
+			}
+		}
+		if d.cmp(s.End, start) > 0 {
+			start = s.End
+		}
+	}
+	if d.cmp(start, end) < 0 {
+		return d.Excise(KeyRange{Start: start, End: end})
+	}
+	return nil
+}
+
+func overlapsSpans(f *fileMetadata, spans []KeyRange, cmp Compare) bool {
+	for i := range spans {
+		if spans[i].Overlaps(cmp, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// manifestTable is an sstable of the version described by a MANIFEST.
+type manifestTable struct {
+	level   int
+	meta    *fileMetadata
+	backing base.DiskFileNum
+}
+
+// readManifestTables returns the sstables of the version described by the
+// MANIFEST at path.
+func readManifestTables(
+	fs vfs.FS, path string, fileNum base.DiskFileNum,
+) (map[base.FileNum]manifestTable, error) {
+	f, err := fs.Open(path, vfs.SequentialReadsOption)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	live := make(map[base.FileNum]manifestTable)
+	rr := record.NewReader(f, fileNum.FileNum())
+	for {
+		r, err := rr.Next()
+		if err == io.EOF {
+			return live, nil
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "pebble: reading %s", path)
+		}
+		var ve versionEdit
+		if err := ve.Decode(r); err != nil {
+			return nil, errors.Wrapf(err, "pebble: reading %s", path)
+		}
+		// An edit may move an sstable between levels, deleting it from one and
+		// adding it to the other.
+		for df := range ve.DeletedFiles {
+			delete(live, df.FileNum)
+		}
+		for _, nf := range ve.NewFiles {
+			t := manifestTable{level: nf.Level, meta: nf.Meta, backing: nf.Meta.FileNum.DiskFileNum()}
+			if nf.Meta.Virtual {
+				t.backing = nf.BackingFileNum
+			}
+			live[nf.Meta.FileNum] = t
+		}
+	}
+}
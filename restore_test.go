@@ -0,0 +1,85 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/pebble/objstorage/remote"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestoreSpans(t *testing.T) {
+	ctx := context.Background()
+	fs := vfs.NewMem()
+	opts := &Options{FS: fs, FormatMajorVersion: ExperimentalFormatVirtualSSTables, DisableAutomaticCompactions: true}
+	d, err := Open("db", opts)
+	require.NoError(t, err)
+	for _, prefix := range []string{"a", "b", "c"} {
+		for i := 0; i < 100; i++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("%s-%03d", prefix, i)), []byte(prefix), nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+	require.NoError(t, d.Set([]byte("b-500"), []byte("wal"), nil))
+	require.NoError(t, d.Set([]byte("d"), []byte("wal"), nil))
+	_, err = d.Backup("backup", WithFlushedWAL())
+	require.NoError(t, err)
+	storage := remote.NewInMem()
+	_, err = d.BackupToRemote(storage, "bk/", WithFlushedWAL())
+	require.NoError(t, err)
+	require.NoError(t, d.Close())
+
+	var progress []RestoreProgress
+	last := func() RestoreProgress {
+		require.NotEmpty(t, progress)
+		return progress[len(progress)-1]
+	}
+	onProgress := WithRestoreProgress(func(p RestoreProgress) { progress = append(progress, p) })
+	spans := []KeyRange{
+		{Start: []byte("b-010"), End: []byte("b-020")},
+		{Start: []byte("b-400"), End: []byte("c-050")},
+	}
+	check := func(dir string) {
+		r, err := Open(dir, &Options{FS: fs})
+		require.NoError(t, err)
+		defer func() { require.NoError(t, r.Close()) }()
+		for _, key := range []string{"b-010", "b-019", "b-500", "c-000", "c-049"} {
+			_, closer, err := r.Get([]byte(key))
+			require.NoError(t, err, key)
+			require.NoError(t, closer.Close())
+		}
+		for _, key := range []string{"a-050", "b-009", "b-020", "b-099", "c-050", "d"} {
+			_, _, err := r.Get([]byte(key))
+			require.ErrorIs(t, err, ErrNotFound, key)
+		}
+	}
+
+	// A full restore, for comparison.
+	require.NoError(t, RestoreBackup(fs, "backup", 1, "full", onProgress))
+	full := last()
+	require.Equal(t, full.TotalFiles, full.Files)
+	require.Equal(t, full.TotalBytes, full.Bytes)
+
+	// The restore of spans skips the sstable that doesn't overlap them.
+	progress = nil
+	require.NoError(t, RestoreBackup(fs, "backup", 1, "spans", onProgress, WithRestoreSpans(opts, spans...)))
+	p := last()
+	require.Equal(t, p.TotalFiles, p.Files)
+	require.Equal(t, full.TotalFiles-1, p.TotalFiles)
+	require.Less(t, p.TotalBytes, full.TotalBytes)
+	check("spans")
+
+	progress = nil
+	require.NoError(t, RestoreRemoteBackup(ctx, storage, "bk/", fs, "remote-spans", onProgress, WithRestoreSpans(opts, spans...)))
+	require.Equal(t, full.TotalFiles-1-2 /* the markers */, last().TotalFiles)
+	check("remote-spans")
+
+	// Invalid spans are rejected.
+	require.Error(t, RestoreBackup(fs, "backup", 1, "invalid", WithRestoreSpans(opts, KeyRange{Start: []byte("b")})))
+}
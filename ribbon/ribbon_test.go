@@ -0,0 +1,126 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package ribbon
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"testing"
+
+	"github.com/cockroachdb/pebble/bloom"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/stretchr/testify/require"
+)
+
+func newTableFilter(bitsPerKey int, keys ...[]byte) tableFilter {
+	w := FilterPolicy(bitsPerKey).NewWriter(base.TableFilter)
+	for _, key := range keys {
+		w.AddKey(key)
+	}
+	return tableFilter(w.Finish(nil))
+}
+
+func le32(i int) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, uint32(i))
+	return b
+}
+
+func TestRibbonFilter(t *testing.T) {
+	nextLength := func(x int) int {
+		if x < 10 {
+			return x + 1
+		}
+		if x < 100 {
+			return x + 10
+		}
+		if x < 1000 {
+			return x + 100
+		}
+		if x < 10000 {
+			return x + 1000
+		}
+		return x * 4
+	}
+
+	for length := 1; length <= 200000; length = nextLength(length) {
+		keys := make([][]byte, 0, length)
+		for i := 0; i < length; i++ {
+			keys = append(keys, le32(i))
+		}
+		f := newTableFilter(10, keys...)
+
+		// All added keys must match.
+		for _, key := range keys {
+			require.True(t, f.MayContain(key), "length=%d: did not contain key %q", length, key)
+		}
+
+		// Check the false positive rate, which matches a Bloom filter with 10
+		// bits per key.
+		nFalsePositive := 0
+		for i := 0; i < 10000; i++ {
+			if f.MayContain(le32(1e9 + i)) {
+				nFalsePositive++
+			}
+		}
+		require.LessOrEqual(t, nFalsePositive, 150, "length=%d", length)
+	}
+}
+
+func TestRibbonFilterSize(t *testing.T) {
+	const length = 100000
+	var keys [][]byte
+	for i := 0; i < length; i++ {
+		keys = append(keys, le32(i))
+	}
+	f := newTableFilter(10, keys...)
+
+	bw := bloom.FilterPolicy(10).NewWriter(base.TableFilter)
+	for _, key := range keys {
+		bw.AddKey(key)
+	}
+	b := bw.Finish(nil)
+	require.Less(t, len(f), len(b)*4/5)
+	t.Logf("ribbon: %.2f bits per key, bloom: %.2f bits per key",
+		float64(len(f)*8)/length, float64(len(b)*8)/length)
+}
+
+func TestRibbonFilterEmpty(t *testing.T) {
+	f := newTableFilter(10)
+	require.False(t, f.MayContain([]byte("hello")))
+	require.False(t, tableFilter(nil).MayContain([]byte("hello")))
+}
+
+func TestResultBits(t *testing.T) {
+	for _, tc := range []struct {
+		bitsPerKey, want int
+	}{
+		{1, 1},
+		{5, 3},
+		{10, 7},
+		{20, 14},
+	} {
+		require.Equal(t, tc.want, resultBits(tc.bitsPerKey), "bitsPerKey=%d", tc.bitsPerKey)
+	}
+}
+
+func BenchmarkRibbonFilter(b *testing.B) {
+	const keyLen = 128
+	const numKeys = 1024
+	keys := make([][]byte, numKeys)
+	for i := range keys {
+		keys[i] = make([]byte, keyLen)
+		_, _ = rand.Read(keys[i])
+	}
+	b.ResetTimer()
+	policy := FilterPolicy(10)
+	for i := 0; i < b.N; i++ {
+		w := policy.NewWriter(base.TableFilter)
+		for _, key := range keys {
+			w.AddKey(key)
+		}
+		w.Finish(nil)
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+const rowCacheShards = 16
+
+// rowCacheEntryOverhead approximates the memory used by an entry in addition
+// to its key and value, and is included in the size of the row cache.
+const rowCacheEntryOverhead = 64
+
+// rowCache caches the values returned by DB.Get, keyed by user key, so that
+// repeated lookups of hot keys don't search the memtables or decode sstable
+// blocks. See Options.RowCacheSize.
+//
+// Writes invalidate the entries of the keys they write. The keys of a write
+// are marked as pending from before the write is sequenced until after it has
+// become visible. A value read by Get isn't added to the cache if its key is
+// pending, or if its key's shard saw a write complete since the Get began,
+// which is detected by the shard's generation. Together these ensure that the
+// cache never holds a value older than the latest visible write of its key.
+// Writes that may affect a range of keys (range deletions, ingestions and
+// large batches) invalidate the entire cache in the same manner, as do flushes
+// and compactions that delete keys or change their values (through
+// DeleteRangeIf, a CompactionFilter or TTLs).
+type rowCache struct {
+	shards [rowCacheShards]rowCacheShard
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+type rowCacheEntry struct {
+	key, value []byte
+	prev, next *rowCacheEntry
+}
+
+func (e *rowCacheEntry) size() int64 {
+	return int64(len(e.key)+len(e.value)) + rowCacheEntryOverhead
+}
+
+type rowCacheShard struct {
+	mu      sync.Mutex
+	maxSize int64
+	size    int64
+	// gen is incremented whenever a write that invalidated keys in the shard
+	// completes.
+	gen     uint64
+	entries map[string]*rowCacheEntry
+	// lru is the sentinel of a circular list of the entries, from most to least
+	// recently used.
+	lru rowCacheEntry
+	// pending holds the number of in-progress writes of each key.
+	pending map[string]int
+	// pendingRanges is the number of in-progress writes that invalidated the
+	// entire cache.
+	pendingRanges int
+}
+
+func newRowCache(size int64) *rowCache {
+	c := &rowCache{}
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.maxSize = size / rowCacheShards
+		s.entries = make(map[string]*rowCacheEntry)
+		s.pending = make(map[string]int)
+		s.lru.prev, s.lru.next = &s.lru, &s.lru
+	}
+	return c
+}
+
+func (c *rowCache) shard(key []byte) *rowCacheShard {
+	return &c.shards[xxhash.Sum64(key)%rowCacheShards]
+}
+
+// get returns the cached value of the key. If the key isn't cached, get returns
+// the generation of the key's shard, which must be passed to the subsequent
+// add of the key's value.
+func (c *rowCache) get(key []byte) (value []byte, gen uint64, ok bool) {
+	s := c.shard(key)
+	s.mu.Lock()
+	e := s.entries[string(key)]
+	if e == nil {
+		gen = s.gen
+		s.mu.Unlock()
+		c.misses.Add(1)
+		return nil, gen, false
+	}
+	s.unlinkLocked(e)
+	s.pushLocked(e)
+	s.mu.Unlock()
+	c.hits.Add(1)
+	return e.value, 0, true
+}
+
+// add adds a value read by Get to the cache, unless a write of the key may
+// have raced with the read. The gen must have been returned by a get of the
+// key that preceded the read.
+func (c *rowCache) add(key, value []byte, gen uint64) {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if gen != s.gen || s.pendingRanges > 0 || s.pending[string(key)] > 0 {
+		return
+	}
+	if s.entries[string(key)] != nil {
+		return
+	}
+	buf := make([]byte, len(key)+len(value))
+	e := &rowCacheEntry{
+		key:   buf[:len(key):len(key)],
+		value: buf[len(key):],
+	}
+	copy(e.key, key)
+	copy(e.value, value)
+	if e.size() > s.maxSize {
+		return
+	}
+	s.entries[string(e.key)] = e
+	s.pushLocked(e)
+	s.size += e.size()
+	for s.size > s.maxSize {
+		s.removeLocked(s.lru.prev)
+	}
+}
+
+// beginWrite invalidates the keys written by the batch, which must not be
+// committed yet. It must be followed by a call to endWrite once the batch is
+// visible.
+func (c *rowCache) beginWrite(b *Batch) {
+	if rowCacheInvalidatesRange(b) {
+		c.beginRange()
+		return
+	}
+	for r := b.Reader(); ; {
+		kind, ukey, _, ok := r.Next()
+		if !ok {
+			break
+		}
+		if !rowCacheInvalidatesKey(kind) {
+			continue
+		}
+		s := c.shard(ukey)
+		s.mu.Lock()
+		s.pending[string(ukey)]++
+		if e := s.entries[string(ukey)]; e != nil {
+			s.removeLocked(e)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// endWrite completes the invalidation of the keys written by the batch begun
+// by beginWrite.
+func (c *rowCache) endWrite(b *Batch) {
+	if rowCacheInvalidatesRange(b) {
+		c.endRange()
+		return
+	}
+	for r := b.Reader(); ; {
+		kind, ukey, _, ok := r.Next()
+		if !ok {
+			break
+		}
+		if !rowCacheInvalidatesKey(kind) {
+			continue
+		}
+		s := c.shard(ukey)
+		s.mu.Lock()
+		if n := s.pending[string(ukey)]; n > 1 {
+			s.pending[string(ukey)] = n - 1
+		} else {
+			delete(s.pending, string(ukey))
+		}
+		s.gen++
+		s.mu.Unlock()
+	}
+}
+
+// beginRange invalidates the entire cache for a write that may affect any key.
+// It must be followed by a call to endRange once the write is visible.
+func (c *rowCache) beginRange() {
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		s.pendingRanges++
+		for _, e := range s.entries {
+			s.removeLocked(e)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// endRange completes the invalidation begun by beginRange.
+func (c *rowCache) endRange() {
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		s.pendingRanges--
+		s.gen++
+		s.mu.Unlock()
+	}
+}
+
+func (c *rowCache) metrics() CacheMetrics {
+	var m CacheMetrics
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		m.Size += s.size
+		m.Count += int64(len(s.entries))
+		s.mu.Unlock()
+	}
+	m.Hits = c.hits.Load()
+	m.Misses = c.misses.Load()
+	return m
+}
+
+func (s *rowCacheShard) pushLocked(e *rowCacheEntry) {
+	e.prev, e.next = &s.lru, s.lru.next
+	e.prev.next, e.next.prev = e, e
+}
+
+func (s *rowCacheShard) unlinkLocked(e *rowCacheEntry) {
+	e.prev.next, e.next.prev = e.next, e.prev
+	e.prev, e.next = nil, nil
+}
+
+func (s *rowCacheShard) removeLocked(e *rowCacheEntry) {
+	s.unlinkLocked(e)
+	delete(s.entries, string(e.key))
+	s.size -= e.size()
+}
+
+// rowCacheInvalidatesRange returns true if the batch's writes must invalidate
+// the entire row cache, rather than the keys it writes.
+func rowCacheInvalidatesRange(b *Batch) bool {
+	// A large batch's keys aren't tracked individually to bound the cost of
+	// invalidation.
+	return b.countRangeDels > 0 || b.ingestedSSTBatch || b.flushable != nil
+}
+
+// rowCacheInvalidatesKey returns true if a write of the given kind may change
+// the value returned by Get for its key.
+func rowCacheInvalidatesKey(kind InternalKeyKind) bool {
+	switch kind {
+	case InternalKeyKindLogData, InternalKeyKindRangeKeySet, InternalKeyKindRangeKeyUnset,
+		InternalKeyKindRangeKeyDelete:
+		return false
+	default:
+		return true
+	}
+}
+
+// rowCacheCloser is the io.Closer returned by Get for values found in the row
+// cache. The values are immutable, so there's nothing to release.
+type rowCacheCloser struct{}
+
+func (rowCacheCloser) Close() error { return nil }
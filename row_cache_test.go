@@ -0,0 +1,230 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRowCache(t *testing.T) {
+	c := newRowCache(rowCacheShards * (rowCacheEntryOverhead + 10))
+
+	get := func(key string) string {
+		v, _, ok := c.get([]byte(key))
+		if !ok {
+			return "<miss>"
+		}
+		return string(v)
+	}
+	add := func(key, value string) {
+		_, gen, _ := c.get([]byte(key))
+		c.add([]byte(key), []byte(value), gen)
+	}
+
+	add("a", "1")
+	require.Equal(t, "1", get("a"))
+
+	// A value read before a write of the key completed isn't added.
+	_, gen, _ := c.get([]byte("b"))
+	b := newBatch(nil)
+	require.NoError(t, b.Set([]byte("b"), []byte("2"), nil))
+	c.beginWrite(b)
+	c.add([]byte("b"), []byte("1"), gen)
+	require.Equal(t, "<miss>", get("b"))
+	c.endWrite(b)
+	c.add([]byte("b"), []byte("1"), gen)
+	require.Equal(t, "<miss>", get("b"))
+	add("b", "2")
+	require.Equal(t, "2", get("b"))
+
+	// Writes invalidate the keys they write.
+	b = newBatch(nil)
+	require.NoError(t, b.Delete([]byte("a"), nil))
+	c.beginWrite(b)
+	require.Equal(t, "<miss>", get("a"))
+	require.Equal(t, "2", get("b"))
+	c.endWrite(b)
+
+	// Range deletions invalidate the entire cache.
+	add("a", "3")
+	b = newBatch(nil)
+	require.NoError(t, b.DeleteRange([]byte("x"), []byte("y"), nil))
+	c.beginWrite(b)
+	add("c", "4")
+	require.Equal(t, "<miss>", get("a"))
+	require.Equal(t, "<miss>", get("b"))
+	require.Equal(t, "<miss>", get("c"))
+	c.endWrite(b)
+
+	// Entries larger than a shard aren't added, and the least recently used
+	// entries are evicted once a shard is full.
+	add("d", "0123456789")
+	require.Equal(t, "<miss>", get("d"))
+	for i := 0; i < 100; i++ {
+		add(strconv.Itoa(i), "v")
+	}
+	m := c.metrics()
+	require.LessOrEqual(t, m.Size, int64(rowCacheShards*(rowCacheEntryOverhead+10)))
+	require.Less(t, m.Count, int64(100))
+	require.Greater(t, m.Hits, int64(0))
+	require.Greater(t, m.Misses, int64(0))
+}
+
+func TestRowCacheDB(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{
+		FS:                 mem,
+		RowCacheSize:       1 << 20,
+		FormatMajorVersion: internalFormatNewest,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	get := func(key string) string {
+		v, closer, err := d.Get([]byte(key))
+		if err == ErrNotFound {
+			return "<not found>"
+		}
+		require.NoError(t, err)
+		defer closer.Close()
+		return string(v)
+	}
+
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.Equal(t, "1", get("a"))
+	require.Equal(t, "1", get("a"))
+	m := d.Metrics()
+	require.Equal(t, int64(1), m.RowCache.Count)
+	require.Equal(t, int64(1), m.RowCache.Hits)
+
+	// Writes, including merges, invalidate the cached value.
+	require.NoError(t, d.Set([]byte("a"), []byte("2"), nil))
+	require.Equal(t, "2", get("a"))
+	require.NoError(t, d.Merge([]byte("a"), []byte("3"), nil))
+	require.Equal(t, "23", get("a"))
+	require.NoError(t, d.Delete([]byte("a"), nil))
+	require.Equal(t, "<not found>", get("a"))
+
+	// Reads through snapshots bypass the row cache.
+	require.NoError(t, d.Set([]byte("b"), []byte("1"), nil))
+	snap := d.NewSnapshot()
+	require.NoError(t, d.Set([]byte("b"), []byte("2"), nil))
+	require.Equal(t, "2", get("b"))
+	v, closer, err := snap.Get([]byte("b"))
+	require.NoError(t, err)
+	require.Equal(t, "1", string(v))
+	require.NoError(t, closer.Close())
+	require.NoError(t, snap.Close())
+
+	// Range deletions invalidate the cached values.
+	require.NoError(t, d.DeleteRange([]byte("a"), []byte("c"), nil))
+	require.Equal(t, "<not found>", get("b"))
+
+	// Ingestions invalidate the cached values.
+	require.NoError(t, d.Set([]byte("c"), []byte("1"), nil))
+	require.Equal(t, "1", get("c"))
+	f, err := mem.Create("ext")
+	require.NoError(t, err)
+	w := sstable.NewWriter(objstorageprovider.NewFileWritable(f), sstable.WriterOptions{
+		TableFormat: d.FormatMajorVersion().MaxTableFormat(),
+	})
+	require.NoError(t, w.Set([]byte("c"), []byte("2")))
+	require.NoError(t, w.Close())
+	require.NoError(t, d.Ingest([]string{"ext"}))
+	require.Equal(t, "2", get("c"))
+}
+
+func TestRowCacheCompactionFilter(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:           vfs.NewMem(),
+		RowCacheSize: 1 << 20,
+		CompactionFilter: func(level int, key, value []byte) (CompactionFilterDecision, []byte) {
+			switch string(key) {
+			case "a":
+				return CompactionFilterRemove, nil
+			case "b":
+				return CompactionFilterChangeValue, []byte("changed")
+			}
+			return CompactionFilterKeep, nil
+		},
+		FormatMajorVersion: internalFormatNewest,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	get := func(key string) string {
+		v, closer, err := d.Get([]byte(key))
+		if err == ErrNotFound {
+			return "<not found>"
+		}
+		require.NoError(t, err)
+		defer closer.Close()
+		return string(v)
+	}
+
+	for _, k := range []string{"a", "b", "c"} {
+		require.NoError(t, d.Set([]byte(k), []byte("1"), nil))
+	}
+	require.NoError(t, d.Flush())
+	require.Equal(t, "1", get("a"))
+	require.Equal(t, "1", get("b"))
+	require.Equal(t, "1", get("c"))
+	require.Equal(t, int64(3), d.Metrics().RowCache.Count)
+
+	// A compaction that removes keys or changes their values invalidates the
+	// cached values.
+	require.NoError(t, d.Compact([]byte("a"), []byte("d"), false))
+	require.Equal(t, "<not found>", get("a"))
+	require.Equal(t, "changed", get("b"))
+	require.Equal(t, "1", get("c"))
+}
+
+func TestRowCacheConcurrentWrites(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:           vfs.NewMem(),
+		RowCacheSize: 1 << 20,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	key := []byte("k")
+	require.NoError(t, d.Set(key, []byte("0"), nil))
+
+	// Readers concurrently populate the row cache while a single writer
+	// updates the key. Once a write returns, Get must observe it.
+	var done atomic.Bool
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !done.Load() {
+				_, closer, err := d.Get(key)
+				if err == nil {
+					closer.Close()
+				}
+			}
+		}()
+	}
+	for i := 1; i <= 2000; i++ {
+		value := fmt.Sprint(i)
+		require.NoError(t, d.Set(key, []byte(value), nil))
+		v, closer, err := d.Get(key)
+		require.NoError(t, err)
+		require.Equal(t, value, string(v))
+		require.NoError(t, closer.Close())
+	}
+	done.Store(true)
+	wg.Wait()
+}
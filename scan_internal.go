@@ -738,7 +738,7 @@ func (i *scanInternalIterator) constructPointIter(memtables flushableList, buf *
 
 	// Next are the memtables.
 	for j := len(memtables) - 1; j >= 0; j-- {
-		mem := memtables[j]
+		mem := flushableView(i.comparer.Compare, i.readState.current, memtables[j])
 		mlevels = append(mlevels, mergingIterLevel{
 			iter: mem.newIter(&i.opts.IterOptions),
 		})
@@ -837,7 +837,8 @@ func (i *scanInternalIterator) constructRangeKeyIter() {
 			if logSeqNum := mem.logSeqNum; logSeqNum >= i.seqNum {
 				continue
 			}
-			if rki := mem.newRangeKeyIter(&i.opts.IterOptions); rki != nil {
+			f := flushableView(i.comparer.Compare, i.readState.current, mem)
+			if rki := f.newRangeKeyIter(&i.opts.IterOptions); rki != nil {
 				i.rangeKey.iterConfig.AddLevel(rki)
 			}
 		}
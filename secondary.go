@@ -0,0 +1,406 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"io"
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/record"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// defaultSecondaryCatchUpInterval is the default value of
+// Options.SecondaryCatchUpInterval.
+const defaultSecondaryCatchUpInterval = time.Second
+
+// maxSecondaryCatchUpAttempts is the number of times a catch-up is attempted
+// when files of the primary are removed while they're read.
+const maxSecondaryCatchUpAttempts = 10
+
+// OpenSecondary opens a secondary instance of the database in dirname, which
+// is opened by a primary instance, possibly in another process. The secondary
+// is read-only: it doesn't lock the directory, and never writes or removes any
+// of its files. It reads the state of the primary when it's opened, and then
+// catches up with the writes of the primary periodically (see
+// Options.SecondaryCatchUpInterval), by re-reading its MANIFEST and replaying
+// its WAL (see DB.CatchUpWithPrimary). This lets reporting workloads read the
+// data of the primary without going through it.
+//
+// The primary removes the sstables and WAL files it no longer needs without
+// regard to the secondary. Files that are open by the secondary remain
+// readable on most filesystems, but a read of an sstable that the secondary
+// hasn't opened yet fails if the primary has removed it since the last
+// catch-up. The primary should be configured to retain its obsolete files for
+// longer than the catch-up interval of the secondary if that's a concern.
+//
+// opts must be compatible with the options of the primary (in particular, its
+// Comparer and Merger).
+func OpenSecondary(dirname string, opts *Options) (*DB, error) {
+	opts = opts.Clone()
+	opts.ReadOnly = true
+	opts.private.secondary = true
+	d, err := Open(dirname, opts)
+	if err != nil {
+		return nil, err
+	}
+	if interval := d.opts.secondaryCatchUpInterval(); interval > 0 {
+		go d.secondaryCatchUpLoop(interval)
+	}
+	return d, nil
+}
+
+func (o *Options) secondaryCatchUpInterval() time.Duration {
+	if o.SecondaryCatchUpInterval == 0 {
+		return defaultSecondaryCatchUpInterval
+	}
+	return o.SecondaryCatchUpInterval
+}
+
+// initSecondaryLocked prepares a DB opened by OpenSecondary, once its version
+// set is loaded. The files of a secondary are owned by its primary: the
+// sstables that become obsolete as the secondary catches up are forgotten
+// rather than deleted.
+//
+// d.mu must be held when calling this.
+func (d *DB) initSecondaryLocked() {
+	d.mu.disableFileDeletions++
+	vs := d.mu.versions
+	vs.obsoleteFn = d.forgetObsoleteTablesLocked
+	vs.currentVersion().Deleted = vs.obsoleteFn
+}
+
+// forgetObsoleteTablesLocked releases the sstables of a secondary that are no
+// longer referenced by any of its versions.
+//
+// d.mu must be held when calling this.
+func (d *DB) forgetObsoleteTablesLocked(obsolete []*fileBacking) {
+	for _, b := range obsolete {
+		delete(d.mu.versions.zombieTables, b.DiskFileNum)
+		d.tableCache.evict(b.DiskFileNum)
+	}
+}
+
+func (d *DB) secondaryCatchUpLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.closedCh:
+			return
+		case <-ticker.C:
+			if err := d.catchUpWithPrimary(); err != nil && !errors.Is(err, ErrClosed) {
+				d.opts.EventListener.BackgroundError(err)
+			}
+		}
+	}
+}
+
+// CatchUpWithPrimary catches a DB opened by OpenSecondary up with the writes of
+// its primary: the sstables of the secondary are updated to those of the
+// current MANIFEST of the primary, and its memtables are rebuilt from the WAL
+// files of the primary that aren't flushed yet. The new state becomes visible
+// to the iterators created from then on, atomically.
+//
+// It's called periodically, unless Options.SecondaryCatchUpInterval is
+// negative.
+func (d *DB) CatchUpWithPrimary() error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if !d.opts.private.secondary {
+		return errors.New("pebble: not a secondary instance")
+	}
+	return d.catchUpWithPrimary()
+}
+
+func (d *DB) catchUpWithPrimary() error {
+	d.catchUpMu.Lock()
+	defer d.catchUpMu.Unlock()
+	for attempt := 1; ; attempt++ {
+		// The primary may remove its MANIFEST or WAL files as they're read, once
+		// they're superseded. Read its new state then.
+		err := d.catchUpWithPrimaryOnce()
+		if err == nil || attempt == maxSecondaryCatchUpAttempts || !d.objProvider.IsNotExistError(err) {
+			return err
+		}
+	}
+}
+
+func (d *DB) catchUpWithPrimaryOnce() error {
+	fs := d.opts.FS
+	vers, marker, err := lookupFormatMajorVersion(fs, d.dirname)
+	if err != nil {
+		return err
+	}
+	if err := marker.Close(); err != nil {
+		return err
+	}
+	marker, manifestFileNum, exists, err := findCurrentManifest(vers, fs, d.dirname)
+	if err != nil {
+		return err
+	}
+	if err := marker.Close(); err != nil {
+		return err
+	}
+	if !exists {
+		return errors.Wrapf(ErrDBDoesNotExist, "dirname=%q", d.dirname)
+	}
+	m, err := readPrimaryManifest(fs, base.MakeFilepath(fs, d.dirname, fileTypeManifest, manifestFileNum))
+	if err != nil {
+		return err
+	}
+	ls, err := fs.List(d.walDirname)
+	if err != nil {
+		return err
+	}
+	var logFiles []FileNum
+	for _, filename := range ls {
+		if ft, fn, ok := base.ParseFilename(fs, filename); ok && ft == fileTypeLog && fn.FileNum() >= m.minUnflushedLogNum {
+			logFiles = append(logFiles, fn.FileNum())
+		}
+	}
+	sort.Slice(logFiles, func(i, j int) bool { return logFiles[i] < logFiles[j] })
+	// Make the sstables created by the primary since the last catch-up known.
+	if err := d.objProvider.Rescan(); err != nil {
+		return err
+	}
+
+	if d.rowCache != nil {
+		// The writes of the primary since the last catch-up may contain any
+		// key.
+		d.rowCache.beginRange()
+		defer d.rowCache.endRange()
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.closed.Load(); err != nil {
+		return err.(error)
+	}
+	if vers > d.FormatMajorVersion() {
+		// The primary ratcheted its format major version.
+		d.mu.formatVers.vers.Store(uint64(vers))
+	}
+	jobID := d.mu.nextJobID
+	d.mu.nextJobID++
+
+	// Rebuild the memtables from the WAL files, and only install them, along
+	// with the new version, once all of them are replayed.
+	oldQueue := d.mu.mem.queue
+	oldMutable := d.mu.mem.mutable
+	d.mu.mem.queue, d.mu.mem.mutable = nil, nil
+	restoreMemTables := func() {
+		for _, mem := range d.mu.mem.queue {
+			mem.readerUnrefLocked(false)
+		}
+		d.mu.mem.queue, d.mu.mem.mutable = oldQueue, oldMutable
+	}
+	var maxSeqNum uint64
+	var tailCorruption *WALCorruptionInfo
+	for _, logNum := range logFiles {
+		if len(m.flushedSpans) > 0 {
+			// Replay each WAL into its own memtables, for the flushed spans
+			// to apply to them (see flushableView).
+			d.mu.mem.mutable = nil
+		}
+		var ve versionEdit
+		path := base.MakeFilepath(fs, d.walDirname, fileTypeLog, logNum.DiskFileNum())
+		_, seqNum, _, err := d.replayWAL(jobID, &ve, fs, path, logNum, false /* strictWALTail */, &tailCorruption)
+		if err != nil {
+			restoreMemTables()
+			return err
+		}
+		if maxSeqNum < seqNum {
+			maxSeqNum = seqNum
+		}
+	}
+
+	if err := d.installPrimaryVersionLocked(m); err != nil {
+		restoreMemTables()
+		return err
+	}
+	for _, mem := range oldQueue {
+		mem.readerUnrefLocked(false)
+	}
+	d.mu.versions.metrics.WAL.Files = int64(len(logFiles))
+
+	seqNum := m.lastSeqNum + 1
+	if seqNum < base.SeqNumStart {
+		seqNum = base.SeqNumStart
+	}
+	if seqNum < maxSeqNum {
+		seqNum = maxSeqNum
+	}
+	if seqNum > d.mu.versions.logSeqNum.Load() {
+		d.mu.versions.logSeqNum.Store(seqNum)
+		d.mu.versions.visibleSeqNum.Store(seqNum)
+	}
+	d.updateReadStateLocked(d.opts.DebugCheck)
+	return nil
+}
+
+// installPrimaryVersionLocked installs the version described by the MANIFEST
+// of the primary. The sstables that are in the current version at the same
+// level keep their metadata.
+//
+// d.mu must be held when calling this.
+func (d *DB) installPrimaryVersionLocked(m *primaryManifest) error {
+	vs := d.mu.versions
+	current := vs.currentVersion()
+	ve := &versionEdit{DeletedFiles: make(map[deletedFileEntry]*fileMetadata)}
+	unchanged := make(map[base.FileNum]bool)
+	removed := make(map[base.FileNum]*fileMetadata)
+	for level := range current.Levels {
+		iter := current.Levels[level].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if t, ok := m.tables[f.FileNum]; ok && t.level == level {
+				unchanged[f.FileNum] = true
+				continue
+			}
+			ve.DeletedFiles[deletedFileEntry{Level: level, FileNum: f.FileNum}] = f
+			removed[f.FileNum] = f
+		}
+	}
+	created := make(map[base.DiskFileNum]bool)
+	for fileNum, t := range m.tables {
+		if unchanged[fileNum] {
+			continue
+		}
+		nf := newFileEntry{Level: t.level, Meta: t.meta}
+		if f, ok := removed[fileNum]; ok {
+			// The sstable moved to another level.
+			nf.Meta = f
+		} else if nf.Meta.Virtual {
+			nf.BackingFileNum = t.backing
+			if b, ok := vs.fileBackingMap[t.backing]; ok {
+				nf.Meta.FileBacking = b
+			} else if b, ok := m.backings[t.backing]; ok {
+				if !created[t.backing] {
+					created[t.backing] = true
+					ve.CreatedBackingTables = append(ve.CreatedBackingTables, b)
+				}
+			} else {
+				return base.CorruptionErrorf("pebble: MANIFEST of the primary has no backing %s for sstable %s",
+					t.backing, fileNum)
+			}
+		}
+		ve.NewFiles = append(ve.NewFiles, nf)
+	}
+	if len(ve.DeletedFiles) == 0 && len(ve.NewFiles) == 0 {
+		vs.minUnflushedLogNum = m.minUnflushedLogNum
+		vs.markFileNumUsed(m.nextFileNum)
+		return nil
+	}
+
+	newVersion, zombies, err := manifest.AccumulateIncompleteAndApplySingleVE(
+		ve, current, vs.cmp, vs.opts.Comparer.FormatKey,
+		vs.opts.FlushSplitBytes, vs.opts.Experimental.ReadCompactionRate,
+		vs.fileBackingMap,
+	)
+	if err != nil {
+		return errors.Wrap(err, "pebble: applying the MANIFEST of the primary")
+	}
+	newVersion.L0Sublevels.InitCompactingFileInfo(nil /* in-progress compactions */)
+	// The zombie tables are forgotten once the versions referencing them are
+	// unreferenced (see forgetObsoleteTablesLocked).
+	for fileNum, size := range zombies {
+		vs.zombieTables[fileNum] = size
+	}
+	newVersion.FlushedSpans = liveFlushedSpans(m.flushedSpans, m.minUnflushedLogNum)
+	vs.append(newVersion)
+	vs.minUnflushedLogNum = m.minUnflushedLogNum
+	vs.markFileNumUsed(m.nextFileNum)
+
+	for i := range vs.metrics.Levels {
+		l := &vs.metrics.Levels[i]
+		l.NumFiles = int64(newVersion.Levels[i].Len())
+		files := newVersion.Levels[i].Slice()
+		l.Size = int64(files.SizeSum())
+		l.Sublevels = 0
+		if l.NumFiles > 0 {
+			l.Sublevels = 1
+		}
+	}
+	vs.metrics.Levels[0].Sublevels = int32(len(newVersion.L0SublevelFiles))
+	vs.picker = newCompactionPicker(newVersion, vs.opts, nil, vs.metrics.levelSizes(), vs.diskAvailBytes)
+	return nil
+}
+
+// primaryManifest is the state of a primary described by its MANIFEST.
+type primaryManifest struct {
+	// tables are the sstables of the current version of the primary.
+	tables map[base.FileNum]manifestTable
+	// backings are the backings of the virtual sstables of the primary.
+	backings           map[base.DiskFileNum]*fileBacking
+	minUnflushedLogNum base.FileNum
+	nextFileNum        base.FileNum
+	lastSeqNum         uint64
+	// flushedSpans holds the spans flushed out of the memtables of the
+	// primary, including spans no longer needed.
+	flushedSpans []manifest.FlushedSpan
+}
+
+// readPrimaryManifest reads the MANIFEST of a primary at path. The MANIFEST
+// may be written to as it's read, so a truncated last record is ignored.
+func readPrimaryManifest(fs vfs.FS, path string) (*primaryManifest, error) {
+	f, err := fs.Open(path, vfs.SequentialReadsOption)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m := &primaryManifest{
+		tables:   make(map[base.FileNum]manifestTable),
+		backings: make(map[base.DiskFileNum]*fileBacking),
+	}
+	rr := record.NewReader(f, 0 /* logNum */)
+	for {
+		r, err := rr.Next()
+		if err == io.EOF || record.IsInvalidRecord(err) {
+			return m, nil
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "pebble: reading %s", path)
+		}
+		var ve versionEdit
+		if err := ve.Decode(r); err != nil {
+			if err == io.EOF || record.IsInvalidRecord(err) {
+				return m, nil
+			}
+			return nil, errors.Wrapf(err, "pebble: reading %s", path)
+		}
+		m.flushedSpans = append(m.flushedSpans, ve.FlushedSpans...)
+		for _, b := range ve.CreatedBackingTables {
+			m.backings[b.DiskFileNum] = b
+		}
+		for _, fileNum := range ve.RemovedBackingTables {
+			delete(m.backings, fileNum)
+		}
+		// An edit may move an sstable between levels, deleting it from one and
+		// adding it to the other.
+		for df := range ve.DeletedFiles {
+			delete(m.tables, df.FileNum)
+		}
+		for _, nf := range ve.NewFiles {
+			t := manifestTable{level: nf.Level, meta: nf.Meta, backing: nf.Meta.FileNum.DiskFileNum()}
+			if nf.Meta.Virtual {
+				t.backing = nf.BackingFileNum
+			}
+			m.tables[nf.Meta.FileNum] = t
+		}
+		if ve.MinUnflushedLogNum != 0 {
+			m.minUnflushedLogNum = ve.MinUnflushedLogNum
+		}
+		if ve.NextFileNum != 0 {
+			m.nextFileNum = ve.NextFileNum
+		}
+		if ve.LastSeqNum != 0 {
+			m.lastSeqNum = ve.LastSeqNum
+		}
+	}
+}
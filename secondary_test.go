@@ -0,0 +1,135 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecondary(t *testing.T) {
+	fs := vfs.NewMem()
+	newOpts := func() *Options {
+		return &Options{
+			FS:                       fs,
+			FormatMajorVersion:       internalFormatNewest,
+			SecondaryCatchUpInterval: -1,
+		}
+	}
+	primary, err := Open("", newOpts())
+	require.NoError(t, err)
+	defer func() { require.NoError(t, primary.Close()) }()
+
+	contents := func(d *DB) string {
+		iter, _ := d.NewIter(nil)
+		var s string
+		for valid := iter.First(); valid; valid = iter.Next() {
+			s += fmt.Sprintf("%s=%s ", iter.Key(), iter.Value())
+		}
+		require.NoError(t, iter.Close())
+		return s
+	}
+	writeSST := func(path string, keys ...string) {
+		f, err := fs.Create(path)
+		require.NoError(t, err)
+		w := sstable.NewWriter(objstorageprovider.NewFileWritable(f), sstable.WriterOptions{
+			TableFormat: internalFormatNewest.MaxTableFormat(),
+		})
+		for _, k := range keys {
+			require.NoError(t, w.Set([]byte(k), []byte("ingested")))
+		}
+		require.NoError(t, w.Close())
+	}
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, primary.Set([]byte(fmt.Sprintf("a%d", i)), []byte("v1"), nil))
+	}
+	require.NoError(t, primary.Flush())
+	require.NoError(t, primary.Set([]byte("b"), []byte("v1"), nil))
+
+	// The secondary reads the sstables and the WAL of the primary.
+	secondary, err := OpenSecondary("", newOpts())
+	require.NoError(t, err)
+	defer func() { require.NoError(t, secondary.Close()) }()
+	require.Equal(t, contents(primary), contents(secondary))
+	require.ErrorIs(t, secondary.Set([]byte("c"), nil, nil), ErrReadOnly)
+
+	// The secondary only sees the writes of the primary once it catches up.
+	require.NoError(t, primary.Set([]byte("c"), []byte("v1"), nil))
+	require.NoError(t, primary.DeleteRange([]byte("a2"), []byte("a4"), nil))
+	require.NotEqual(t, contents(primary), contents(secondary))
+	require.NoError(t, secondary.CatchUpWithPrimary())
+	require.Equal(t, contents(primary), contents(secondary))
+
+	// Flushes, compactions, ingestions and excises, which remove some of the
+	// files the secondary read.
+	require.NoError(t, primary.Flush())
+	require.NoError(t, primary.Compact([]byte("a"), []byte("z"), false))
+	writeSST("ext1", "d1", "d2", "d3")
+	require.NoError(t, primary.Ingest([]string{"ext1"}))
+	require.NoError(t, primary.Excise(KeyRange{Start: []byte("a5"), End: []byte("a7")}))
+	require.NoError(t, primary.Set([]byte("a0"), []byte("v2"), nil))
+	require.NoError(t, secondary.CatchUpWithPrimary())
+	require.Equal(t, contents(primary), contents(secondary))
+	require.Equal(t, primary.Metrics().Total().NumFiles, secondary.Metrics().Total().NumFiles)
+
+	// Catching up without any write is a no-op.
+	require.NoError(t, secondary.CatchUpWithPrimary())
+	require.Equal(t, contents(primary), contents(secondary))
+
+	// A secondary catches up periodically.
+	opts := newOpts()
+	opts.SecondaryCatchUpInterval = time.Millisecond
+	periodic, err := OpenSecondary("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, periodic.Close()) }()
+	require.NoError(t, primary.Set([]byte("e"), []byte("v1"), nil))
+	require.NoError(t, primary.Flush())
+	require.Eventually(t, func() bool {
+		return contents(primary) == contents(periodic)
+	}, 10*time.Second, time.Millisecond)
+
+	// A DB opened by Open can't catch up.
+	require.Error(t, primary.CatchUpWithPrimary())
+}
+
+func TestSecondaryRowCache(t *testing.T) {
+	fs := vfs.NewMem()
+	newOpts := func() *Options {
+		return &Options{
+			FS:                       fs,
+			RowCacheSize:             1 << 20,
+			SecondaryCatchUpInterval: -1,
+		}
+	}
+	primary, err := Open("", newOpts())
+	require.NoError(t, err)
+	defer func() { require.NoError(t, primary.Close()) }()
+	secondary, err := OpenSecondary("", newOpts())
+	require.NoError(t, err)
+	defer func() { require.NoError(t, secondary.Close()) }()
+
+	// Values cached by the secondary are invalidated when it catches up with
+	// the overwrites of the primary.
+	require.NoError(t, primary.Set([]byte("k"), []byte("v1"), nil))
+	require.NoError(t, secondary.CatchUpWithPrimary())
+	verifyGet(t, secondary, []byte("k"), []byte("v1"))
+	verifyGet(t, secondary, []byte("k"), []byte("v1"))
+	require.NoError(t, primary.Set([]byte("k"), []byte("v2"), nil))
+	require.NoError(t, secondary.CatchUpWithPrimary())
+	verifyGet(t, secondary, []byte("k"), []byte("v2"))
+
+	// Likewise for the overwrites the primary flushed.
+	require.NoError(t, primary.Set([]byte("k"), []byte("v3"), nil))
+	require.NoError(t, primary.Flush())
+	require.NoError(t, secondary.CatchUpWithPrimary())
+	verifyGet(t, secondary, []byte("k"), []byte("v3"))
+}
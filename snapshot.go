@@ -111,8 +111,10 @@ func (s *Snapshot) closeLocked() error {
 	s.db.mu.snapshots.remove(s)
 
 	// If s was the previous earliest snapshot, we might be able to reclaim
-	// disk space by dropping obsolete records that were pinned by s.
-	if e := s.db.mu.snapshots.earliest(); e > s.seqNum {
+	// disk space by dropping obsolete records that were pinned by s. Records
+	// pinned only by snapshots below the garbage horizon are not pinned.
+	if e := s.db.earliestSnapshotLocked(); e > s.seqNum && s.seqNum >= s.db.mu.snapshots.garbageHorizon {
+		s.db.queueSnapshotElisionCompactionsLocked(s.seqNum, e)
 		s.db.maybeScheduleCompactionPicker(pickElisionOnly)
 	}
 	s.db = nil
@@ -135,6 +137,67 @@ func (s *Snapshot) Close() error {
 	return s.closeLocked()
 }
 
+// SetGarbageHorizon declares that no reader needs the versions of keys that
+// are shadowed at sequence number seqNum: reads at sequence numbers below
+// seqNum will not be performed. It allows embedders that implement their own
+// MVCC above Pebble, and that may hold Pebble snapshots older than any
+// version they still read, to have flushes and compactions drop shadowed
+// keys and tombstones as if the snapshots below seqNum did not exist. Reads
+// through such snapshots may observe keys being dropped once the horizon
+// passes them.
+//
+// The horizon only advances; calls with a seqNum below the current horizon
+// are ignored. Sequence numbers of committed writes are available through
+// Batch.SeqNum.
+func (d *DB) SetGarbageHorizon(seqNum uint64) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if seqNum <= d.mu.snapshots.garbageHorizon {
+		return
+	}
+	prevEarliest := d.earliestSnapshotLocked()
+	d.mu.snapshots.garbageHorizon = seqNum
+	// Advancing the horizon past open snapshots releases the records they
+	// pinned, as if they had been closed.
+	if e := d.earliestSnapshotLocked(); e > prevEarliest {
+		d.queueSnapshotElisionCompactionsLocked(prevEarliest, e)
+		d.maybeScheduleCompactionPicker(pickElisionOnly)
+	}
+}
+
+// compactionSnapshotsLocked returns the sequence numbers, in increasing order,
+// of the open snapshots whose views flushes and compactions must preserve:
+// those at or above the garbage horizon.
+//
+// d.mu must be held when calling this.
+func (d *DB) compactionSnapshotsLocked() []uint64 {
+	snapshots := d.mu.snapshots.toSlice()
+	for i, seqNum := range snapshots {
+		if seqNum >= d.mu.snapshots.garbageHorizon {
+			return snapshots[i:]
+		}
+	}
+	return nil
+}
+
+// earliestSnapshotLocked returns the sequence number of the earliest open
+// snapshot at or above the garbage horizon, or math.MaxUint64 if there is no
+// such snapshot.
+//
+// d.mu must be held when calling this.
+func (d *DB) earliestSnapshotLocked() uint64 {
+	l := &d.mu.snapshots.snapshotList
+	for s := l.root.next; s != &l.root; s = s.next {
+		if s.seqNum >= d.mu.snapshots.garbageHorizon {
+			return s.seqNum
+		}
+	}
+	return math.MaxUint64
+}
+
 type snapshotList struct {
 	root Snapshot
 }
@@ -242,6 +242,51 @@ func TestSnapshotClosed(t *testing.T) {
 	require.NoError(t, d.Close())
 }
 
+func TestGarbageHorizon(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	set := func(key, value string) uint64 {
+		b := d.NewBatch()
+		require.NoError(t, b.Set([]byte(key), []byte(value), nil))
+		require.NoError(t, b.Commit(nil))
+		return b.SeqNum()
+	}
+	numEntries := func() uint64 {
+		tables, err := d.SSTables(WithProperties())
+		require.NoError(t, err)
+		var n uint64
+		for _, level := range tables {
+			for _, tbl := range level {
+				n += tbl.Properties.NumEntries
+			}
+		}
+		return n
+	}
+
+	set("a", "1")
+	snap := d.NewSnapshot()
+	defer func() { require.NoError(t, snap.Close()) }()
+	seqNum := set("a", "2")
+
+	// The snapshot pins the shadowed version of a.
+	require.NoError(t, d.Compact([]byte("a"), []byte("b"), false))
+	require.Equal(t, uint64(2), numEntries())
+
+	// Once the garbage horizon passes the snapshot, the snapshot no longer
+	// pins the shadowed version, and the next compaction of a drops it. The
+	// horizon does not move backwards.
+	d.SetGarbageHorizon(seqNum + 1)
+	d.SetGarbageHorizon(seqNum)
+	set("a", "3")
+	require.NoError(t, d.Compact([]byte("a"), []byte("b"), false))
+	require.Equal(t, uint64(1), numEntries())
+}
+
 func TestSnapshotRangeDeletionStress(t *testing.T) {
 	const runs = 200
 	const middleKey = runs * runs
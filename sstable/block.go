@@ -793,7 +793,7 @@ func (i *blockIter) SeekGE(key []byte, flags base.SeekGEFlags) (*InternalKey, ba
 		if !i.lazyValueHandling.hasValuePrefix ||
 			base.TrailerKind(i.ikey.Trailer) != InternalKeyKindSet {
 			i.lazyValue = base.MakeInPlaceValue(i.val)
-		} else if i.lazyValueHandling.vbr == nil || !isValueHandle(valuePrefix(i.val[0])) {
+		} else if i.lazyValueHandling.vbr == nil || isInPlaceValue(valuePrefix(i.val[0])) {
 			i.lazyValue = base.MakeInPlaceValue(i.val[1:])
 		} else {
 			i.lazyValue = i.lazyValueHandling.vbr.getLazyValueForPrefixAndValueHandle(i.val)
@@ -981,7 +981,7 @@ func (i *blockIter) SeekLT(key []byte, flags base.SeekLTFlags) (*InternalKey, ba
 	if !i.lazyValueHandling.hasValuePrefix ||
 		base.TrailerKind(i.ikey.Trailer) != InternalKeyKindSet {
 		i.lazyValue = base.MakeInPlaceValue(i.val)
-	} else if i.lazyValueHandling.vbr == nil || !isValueHandle(valuePrefix(i.val[0])) {
+	} else if i.lazyValueHandling.vbr == nil || isInPlaceValue(valuePrefix(i.val[0])) {
 		i.lazyValue = base.MakeInPlaceValue(i.val[1:])
 	} else {
 		i.lazyValue = i.lazyValueHandling.vbr.getLazyValueForPrefixAndValueHandle(i.val)
@@ -1009,7 +1009,7 @@ func (i *blockIter) First() (*InternalKey, base.LazyValue) {
 	if !i.lazyValueHandling.hasValuePrefix ||
 		base.TrailerKind(i.ikey.Trailer) != InternalKeyKindSet {
 		i.lazyValue = base.MakeInPlaceValue(i.val)
-	} else if i.lazyValueHandling.vbr == nil || !isValueHandle(valuePrefix(i.val[0])) {
+	} else if i.lazyValueHandling.vbr == nil || isInPlaceValue(valuePrefix(i.val[0])) {
 		i.lazyValue = base.MakeInPlaceValue(i.val[1:])
 	} else {
 		i.lazyValue = i.lazyValueHandling.vbr.getLazyValueForPrefixAndValueHandle(i.val)
@@ -1051,7 +1051,7 @@ func (i *blockIter) Last() (*InternalKey, base.LazyValue) {
 	if !i.lazyValueHandling.hasValuePrefix ||
 		base.TrailerKind(i.ikey.Trailer) != InternalKeyKindSet {
 		i.lazyValue = base.MakeInPlaceValue(i.val)
-	} else if i.lazyValueHandling.vbr == nil || !isValueHandle(valuePrefix(i.val[0])) {
+	} else if i.lazyValueHandling.vbr == nil || isInPlaceValue(valuePrefix(i.val[0])) {
 		i.lazyValue = base.MakeInPlaceValue(i.val[1:])
 	} else {
 		i.lazyValue = i.lazyValueHandling.vbr.getLazyValueForPrefixAndValueHandle(i.val)
@@ -1103,7 +1103,7 @@ start:
 	if !i.lazyValueHandling.hasValuePrefix ||
 		base.TrailerKind(i.ikey.Trailer) != InternalKeyKindSet {
 		i.lazyValue = base.MakeInPlaceValue(i.val)
-	} else if i.lazyValueHandling.vbr == nil || !isValueHandle(valuePrefix(i.val[0])) {
+	} else if i.lazyValueHandling.vbr == nil || isInPlaceValue(valuePrefix(i.val[0])) {
 		i.lazyValue = base.MakeInPlaceValue(i.val[1:])
 	} else {
 		i.lazyValue = i.lazyValueHandling.vbr.getLazyValueForPrefixAndValueHandle(i.val)
@@ -1382,7 +1382,7 @@ func (i *blockIter) nextPrefixV3(succKey []byte) (*InternalKey, base.LazyValue)
 			}
 			if base.TrailerKind(i.ikey.Trailer) != InternalKeyKindSet {
 				i.lazyValue = base.MakeInPlaceValue(i.val)
-			} else if i.lazyValueHandling.vbr == nil || !isValueHandle(valuePrefix(i.val[0])) {
+			} else if i.lazyValueHandling.vbr == nil || isInPlaceValue(valuePrefix(i.val[0])) {
 				i.lazyValue = base.MakeInPlaceValue(i.val[1:])
 			} else {
 				i.lazyValue = i.lazyValueHandling.vbr.getLazyValueForPrefixAndValueHandle(i.val)
@@ -1429,7 +1429,7 @@ start:
 		if !i.lazyValueHandling.hasValuePrefix ||
 			base.TrailerKind(i.ikey.Trailer) != InternalKeyKindSet {
 			i.lazyValue = base.MakeInPlaceValue(i.val)
-		} else if i.lazyValueHandling.vbr == nil || !isValueHandle(valuePrefix(i.val[0])) {
+		} else if i.lazyValueHandling.vbr == nil || isInPlaceValue(valuePrefix(i.val[0])) {
 			i.lazyValue = base.MakeInPlaceValue(i.val[1:])
 		} else {
 			i.lazyValue = i.lazyValueHandling.vbr.getLazyValueForPrefixAndValueHandle(i.val)
@@ -1501,7 +1501,7 @@ start:
 	if !i.lazyValueHandling.hasValuePrefix ||
 		base.TrailerKind(i.ikey.Trailer) != InternalKeyKindSet {
 		i.lazyValue = base.MakeInPlaceValue(i.val)
-	} else if i.lazyValueHandling.vbr == nil || !isValueHandle(valuePrefix(i.val[0])) {
+	} else if i.lazyValueHandling.vbr == nil || isInPlaceValue(valuePrefix(i.val[0])) {
 		i.lazyValue = base.MakeInPlaceValue(i.val[1:])
 	} else {
 		i.lazyValue = i.lazyValueHandling.vbr.getLazyValueForPrefixAndValueHandle(i.val)
@@ -373,6 +373,19 @@ func (i *interval) decode(buf []byte) error {
 	return nil
 }
 
+// DecodeBlockInterval decodes the [lower, upper) interval of a property
+// encoded by a BlockIntervalCollector. An empty property decodes to an empty
+// interval with lower == upper == 0. When decoding a table-level property
+// from the table's user properties, the leading short ID byte must be
+// stripped first.
+func DecodeBlockInterval(prop []byte) (lower, upper uint64, err error) {
+	var i interval
+	if err := i.decode(prop); err != nil {
+		return 0, 0, err
+	}
+	return i.lower, i.upper, nil
+}
+
 func (i *interval) union(x interval) {
 	if x.lower >= x.upper {
 		// x is the empty set.
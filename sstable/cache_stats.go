@@ -0,0 +1,122 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// BlockKind is the kind of an sstable block, by which block cache statistics
+// are broken down.
+type BlockKind uint8
+
+const (
+	// BlockKindData is a data block.
+	BlockKindData BlockKind = iota
+	// BlockKindIndex is an index block, including the top-level index and the
+	// index partitions of tables with two-level indexes.
+	BlockKindIndex
+	// BlockKindFilter is a filter block.
+	BlockKindFilter
+	// BlockKindValue is a value block or the value block index.
+	BlockKindValue
+	// BlockKindOther is any other block, such as the range deletion and range
+	// key blocks and the properties block.
+	BlockKindOther
+	// NumBlockKinds is the number of block kinds.
+	NumBlockKinds
+)
+
+func (k BlockKind) String() string {
+	switch k {
+	case BlockKindData:
+		return "data"
+	case BlockKindIndex:
+		return "index"
+	case BlockKindFilter:
+		return "filter"
+	case BlockKindValue:
+		return "value"
+	case BlockKindOther:
+		return "other"
+	default:
+		return fmt.Sprintf("BlockKind(%d)", uint8(k))
+	}
+}
+
+// CacheKindMetrics holds the block cache lookups of a kind of block.
+type CacheKindMetrics struct {
+	// Hits and Misses are the number of lookups of blocks that were found and
+	// not found in the block cache.
+	Hits   int64
+	Misses int64
+	// HitBytes and MissBytes are the sizes of the blocks found and not found in
+	// the block cache, as stored in the sstable.
+	HitBytes  int64
+	MissBytes int64
+}
+
+// Add adds the lookups of o to m.
+func (m *CacheKindMetrics) Add(o CacheKindMetrics) {
+	m.Hits += o.Hits
+	m.Misses += o.Misses
+	m.HitBytes += o.HitBytes
+	m.MissBytes += o.MissBytes
+}
+
+// CacheMetricsByKind holds the block cache lookups of each kind of block,
+// indexed by BlockKind.
+type CacheMetricsByKind [NumBlockKinds]CacheKindMetrics
+
+// Add adds the lookups of o to m.
+func (m *CacheMetricsByKind) Add(o *CacheMetricsByKind) {
+	for k := range m {
+		m[k].Add(o[k])
+	}
+}
+
+// Total returns the lookups of all kinds of blocks.
+func (m *CacheMetricsByKind) Total() CacheKindMetrics {
+	var t CacheKindMetrics
+	for k := range m {
+		t.Add(m[k])
+	}
+	return t
+}
+
+// CacheStats accumulates the block cache lookups of sstable readers by block
+// kind. It's safe for concurrent use.
+type CacheStats struct {
+	kinds [NumBlockKinds]struct {
+		hits, misses, hitBytes, missBytes atomic.Int64
+	}
+}
+
+func (s *CacheStats) record(kind BlockKind, hit bool, n uint64) {
+	k := &s.kinds[kind]
+	if hit {
+		k.hits.Add(1)
+		k.hitBytes.Add(int64(n))
+	} else {
+		k.misses.Add(1)
+		k.missBytes.Add(int64(n))
+	}
+}
+
+// Metrics returns the lookups accumulated so far.
+func (s *CacheStats) Metrics() CacheMetricsByKind {
+	var m CacheMetricsByKind
+	for i := range s.kinds {
+		k := &s.kinds[i]
+		m[i] = CacheKindMetrics{
+			Hits:      k.hits.Load(),
+			Misses:    k.misses.Load(),
+			HitBytes:  k.hitBytes.Load(),
+			MissBytes: k.missBytes.Load(),
+		}
+	}
+	return m
+}
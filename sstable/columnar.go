@@ -0,0 +1,429 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"encoding/binary"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+)
+
+// ColumnEncoding is the encoding of a column of a columnar data block.
+type ColumnEncoding uint8
+
+const (
+	// ColumnEncodingRaw stores the values of a column as is.
+	ColumnEncodingRaw ColumnEncoding = iota
+	// ColumnEncodingDelta stores each value of a column, a big-endian unsigned
+	// integer of 1, 2, 4 or 8 bytes, as the varint-encoded difference from the
+	// value of the previous row. It suits counters, timestamps and other
+	// slowly changing integers.
+	ColumnEncodingDelta
+	// ColumnEncodingDictionary stores the distinct values of a column once,
+	// and the index of the value of each row. It suits columns with few
+	// distinct values, such as enums.
+	ColumnEncodingDictionary
+)
+
+// String implements fmt.Stringer.
+func (e ColumnEncoding) String() string {
+	switch e {
+	case ColumnEncodingRaw:
+		return "raw"
+	case ColumnEncodingDelta:
+		return "delta"
+	case ColumnEncodingDictionary:
+		return "dictionary"
+	default:
+		return "unknown"
+	}
+}
+
+// ColumnSpec describes a fixed-width column of values.
+type ColumnSpec struct {
+	// Width is the width of the column in bytes.
+	Width int
+	// Encoding is the encoding of the column.
+	Encoding ColumnEncoding
+}
+
+// ColumnarSchema describes values as the concatenation of fixed-width columns.
+// A Writer configured with a ColumnarSchema stores data blocks in a columnar
+// encoding: the keys, and each column of the values that conform to the
+// schema, are stored separately within the block, each with its own encoding,
+// before the block is compressed. Grouping similar bytes together improves the
+// compression of tables whose values have a fixed schema, such as the rows of
+// analytical workloads layered on Pebble.
+//
+// The columnar encoding only affects how data blocks are stored: columnar data
+// blocks are decoded back to the row-oriented layout when they are read,
+// before they are added to the block cache, and iterators only ever see the
+// row-oriented layout. The encoding reduces the size of tables, at the cost
+// of decoding blocks read from disk, but does not make reads of a subset of
+// the columns any cheaper. Values that do not conform to the schema, such as
+// values of a different length, are stored as is. Tables with columnar data
+// blocks cannot be read by versions of Pebble predating them.
+type ColumnarSchema struct {
+	// Name names the schema.
+	Name string
+	// Columns are the columns of values, in order.
+	Columns []ColumnSpec
+}
+
+// width returns the width of the values conforming to the schema.
+func (s *ColumnarSchema) width() int {
+	n := 0
+	for _, c := range s.Columns {
+		n += c.Width
+	}
+	return n
+}
+
+func (s *ColumnarSchema) validate() error {
+	if len(s.Columns) == 0 {
+		return errors.Newf("pebble: columnar schema %q has no columns", s.Name)
+	}
+	for i, c := range s.Columns {
+		switch c.Encoding {
+		case ColumnEncodingRaw, ColumnEncodingDictionary:
+			if c.Width <= 0 {
+				return errors.Newf("pebble: column %d of columnar schema %q has width %d", i, s.Name, c.Width)
+			}
+		case ColumnEncodingDelta:
+			switch c.Width {
+			case 1, 2, 4, 8:
+			default:
+				return errors.Newf("pebble: delta-encoded column %d of columnar schema %q has width %d",
+					i, s.Name, c.Width)
+			}
+		default:
+			return errors.Newf("pebble: column %d of columnar schema %q has unknown encoding %d",
+				i, s.Name, c.Encoding)
+		}
+	}
+	return nil
+}
+
+// columnarBlockTypeFlag is set in the block type of the trailer of a data
+// block stored in the columnar layout. The remaining bits of the block type
+// give the compression of the block.
+const columnarBlockTypeFlag blockType = 0x80
+
+const columnarBlockVersion = 1
+
+// The layout of the values of the rows of a columnar data block.
+const (
+	// The value is stored as is.
+	columnarRowVerbatim byte = iota
+	// The value conforms to the schema.
+	columnarRowColumns
+	// The value is a value prefix (see valuePrefix) followed by a value that
+	// conforms to the schema.
+	columnarRowPrefixedColumns
+)
+
+// The sections of a columnar data block following its header, each of which
+// is stored as its length followed by its data.
+const (
+	// The number of bytes each row shares with the key of the previous row in
+	// the row-oriented block, to rebuild it byte for byte.
+	columnarSectionShared = iota
+	// The user keys, each stored as the length of the prefix it shares with
+	// the previous user key, and the remainder.
+	columnarSectionUserKeys
+	// The differences between the trailers of consecutive keys.
+	columnarSectionTrailers
+	// The layout of the value of each row.
+	columnarSectionLayouts
+	// The values stored as is.
+	columnarSectionVerbatim
+	// The value prefixes of the rows with prefixed columns.
+	columnarSectionValuePrefixes
+	// The columns, one section per column of the schema.
+	columnarSectionColumns
+)
+
+// encodeColumnarBlock appends to dst the columnar layout of the finished
+// row-oriented data block b. If valuePrefix is true, the values of the block
+// may start with a valuePrefix.
+func encodeColumnarBlock(dst, b []byte, schema *ColumnarSchema, valuePrefix bool) []byte {
+	numRestarts := int(binary.LittleEndian.Uint32(b[len(b)-4:]))
+	restartsOffset := len(b) - 4*(numRestarts+1)
+	width := schema.width()
+
+	sections := make([][]byte, columnarSectionColumns+len(schema.Columns))
+	var key, prevUserKey []byte
+	var prevTrailer uint64
+	prevDelta := make([]uint64, len(schema.Columns))
+	dicts := make([]map[string]int, len(schema.Columns))
+	dictValues := make([][]byte, len(schema.Columns))
+	dictIndexes := make([][]byte, len(schema.Columns))
+	numRows := 0
+	for offset := 0; offset < restartsOffset; numRows++ {
+		shared, n := binary.Uvarint(b[offset:])
+		offset += n
+		unshared, n := binary.Uvarint(b[offset:])
+		offset += n
+		valueLen, n := binary.Uvarint(b[offset:])
+		offset += n
+		key = append(key[:shared], b[offset:offset+int(unshared)]...)
+		offset += int(unshared)
+		value := b[offset : offset+int(valueLen)]
+		offset += int(valueLen)
+
+		sections[columnarSectionShared] = binary.AppendUvarint(sections[columnarSectionShared], shared)
+		userKey, trailer := key[:len(key)-8], binary.LittleEndian.Uint64(key[len(key)-8:])
+		p := base.SharedPrefixLen(prevUserKey, userKey)
+		sections[columnarSectionUserKeys] = binary.AppendUvarint(sections[columnarSectionUserKeys], uint64(p))
+		sections[columnarSectionUserKeys] = binary.AppendUvarint(sections[columnarSectionUserKeys], uint64(len(userKey)-p))
+		sections[columnarSectionUserKeys] = append(sections[columnarSectionUserKeys], userKey[p:]...)
+		prevUserKey = append(prevUserKey[:0], userKey...)
+		sections[columnarSectionTrailers] = binary.AppendVarint(sections[columnarSectionTrailers], int64(trailer-prevTrailer))
+		prevTrailer = trailer
+
+		layout := columnarRowVerbatim
+		switch {
+		case len(value) == width:
+			layout = columnarRowColumns
+		case valuePrefix && len(value) == width+1:
+			layout = columnarRowPrefixedColumns
+			sections[columnarSectionValuePrefixes] = append(sections[columnarSectionValuePrefixes], value[0])
+			value = value[1:]
+		}
+		sections[columnarSectionLayouts] = append(sections[columnarSectionLayouts], layout)
+		if layout == columnarRowVerbatim {
+			sections[columnarSectionVerbatim] = binary.AppendUvarint(sections[columnarSectionVerbatim], uint64(len(value)))
+			sections[columnarSectionVerbatim] = append(sections[columnarSectionVerbatim], value...)
+			continue
+		}
+		for i, c := range schema.Columns {
+			v := value[:c.Width]
+			value = value[c.Width:]
+			s := &sections[columnarSectionColumns+i]
+			switch c.Encoding {
+			case ColumnEncodingRaw:
+				*s = append(*s, v...)
+			case ColumnEncodingDelta:
+				x := decodeBigEndian(v)
+				*s = binary.AppendVarint(*s, int64(x-prevDelta[i]))
+				prevDelta[i] = x
+			case ColumnEncodingDictionary:
+				if dicts[i] == nil {
+					dicts[i] = make(map[string]int)
+				}
+				idx, ok := dicts[i][string(v)]
+				if !ok {
+					idx = len(dicts[i])
+					dicts[i][string(v)] = idx
+					dictValues[i] = append(dictValues[i], v...)
+				}
+				dictIndexes[i] = binary.AppendUvarint(dictIndexes[i], uint64(idx))
+			}
+		}
+	}
+	for i, c := range schema.Columns {
+		if c.Encoding == ColumnEncodingDictionary {
+			s := binary.AppendUvarint(nil, uint64(len(dicts[i])))
+			s = append(s, dictValues[i]...)
+			sections[columnarSectionColumns+i] = append(s, dictIndexes[i]...)
+		}
+	}
+
+	dst = append(dst, columnarBlockVersion)
+	dst = binary.AppendUvarint(dst, uint64(len(b)))
+	dst = binary.AppendUvarint(dst, uint64(numRows))
+	dst = binary.AppendUvarint(dst, uint64(len(schema.Columns)))
+	for _, c := range schema.Columns {
+		dst = binary.AppendUvarint(dst, uint64(c.Width))
+		dst = append(dst, byte(c.Encoding))
+	}
+	dst = binary.AppendUvarint(dst, uint64(len(b)-restartsOffset))
+	dst = append(dst, b[restartsOffset:]...)
+	for _, s := range sections {
+		dst = binary.AppendUvarint(dst, uint64(len(s)))
+		dst = append(dst, s...)
+	}
+	return dst
+}
+
+// columnarDecoder reads the fields of a columnar data block.
+type columnarDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *columnarDecoder) fail() {
+	if d.err == nil {
+		d.err = base.CorruptionErrorf("pebble: corrupt columnar data block")
+	}
+	d.b = nil
+}
+
+func (d *columnarDecoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *columnarDecoder) varint() int64 {
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *columnarDecoder) bytes(n uint64) []byte {
+	if n > uint64(len(d.b)) {
+		d.fail()
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *columnarDecoder) byte() byte {
+	if v := d.bytes(1); v != nil {
+		return v[0]
+	}
+	return 0
+}
+
+// columnarBlockLen returns the length of the row-oriented data block encoded
+// by the columnar data block b.
+func columnarBlockLen(b []byte) (int, error) {
+	d := columnarDecoder{b: b}
+	if d.byte() != columnarBlockVersion {
+		return 0, base.CorruptionErrorf("pebble: unknown columnar data block version")
+	}
+	n := d.uvarint()
+	return int(n), d.err
+}
+
+// decodeColumnarBlock decodes the columnar data block b into dst, which must
+// have the length returned by columnarBlockLen.
+func decodeColumnarBlock(dst, b []byte) error {
+	d := columnarDecoder{b: b}
+	d.byte()
+	d.uvarint()
+	numRows := d.uvarint()
+	numColumns := d.uvarint()
+	if numColumns > uint64(len(d.b)) {
+		return base.CorruptionErrorf("pebble: corrupt columnar data block")
+	}
+	columns := make([]ColumnSpec, numColumns)
+	width := 0
+	for i := range columns {
+		columns[i].Width = int(d.uvarint())
+		columns[i].Encoding = ColumnEncoding(d.byte())
+		width += columns[i].Width
+	}
+	restarts := d.bytes(d.uvarint())
+	sections := make([]columnarDecoder, columnarSectionColumns+len(columns))
+	for i := range sections {
+		sections[i].b = d.bytes(d.uvarint())
+	}
+	if d.err != nil {
+		return d.err
+	}
+	dictValues := make([][]byte, len(columns))
+	for i, c := range columns {
+		if c.Encoding == ColumnEncodingDictionary {
+			s := &sections[columnarSectionColumns+i]
+			dictValues[i] = s.bytes(s.uvarint() * uint64(c.Width))
+		}
+	}
+
+	// Limit the capacity of dst, so that appending past its length moves the
+	// block, which is then reported as corrupt.
+	out := dst[:0:len(dst)]
+	var key, userKey, value []byte
+	var trailer uint64
+	prevDelta := make([]uint64, len(columns))
+	for row := uint64(0); row < numRows; row++ {
+		shared := sections[columnarSectionShared].uvarint()
+		keys := &sections[columnarSectionUserKeys]
+		p := keys.uvarint()
+		if p > uint64(len(userKey)) {
+			return base.CorruptionErrorf("pebble: corrupt columnar data block")
+		}
+		userKey = append(userKey[:p], keys.bytes(keys.uvarint())...)
+		trailer += uint64(sections[columnarSectionTrailers].varint())
+		key = append(key[:0], userKey...)
+		key = binary.LittleEndian.AppendUint64(key, trailer)
+
+		value = value[:0]
+		switch layout := sections[columnarSectionLayouts].byte(); layout {
+		case columnarRowVerbatim:
+			s := &sections[columnarSectionVerbatim]
+			value = append(value, s.bytes(s.uvarint())...)
+		case columnarRowColumns, columnarRowPrefixedColumns:
+			if layout == columnarRowPrefixedColumns {
+				value = append(value, sections[columnarSectionValuePrefixes].byte())
+			}
+			for i, c := range columns {
+				s := &sections[columnarSectionColumns+i]
+				switch c.Encoding {
+				case ColumnEncodingRaw:
+					value = append(value, s.bytes(uint64(c.Width))...)
+				case ColumnEncodingDelta:
+					prevDelta[i] += uint64(s.varint())
+					value = appendBigEndian(value, prevDelta[i], c.Width)
+				case ColumnEncodingDictionary:
+					idx := s.uvarint()
+					if (idx+1)*uint64(c.Width) > uint64(len(dictValues[i])) {
+						return base.CorruptionErrorf("pebble: corrupt columnar data block")
+					}
+					value = append(value, dictValues[i][idx*uint64(c.Width):(idx+1)*uint64(c.Width)]...)
+				default:
+					return base.CorruptionErrorf("pebble: unknown column encoding %d", errors.Safe(c.Encoding))
+				}
+			}
+		default:
+			return base.CorruptionErrorf("pebble: unknown columnar row layout %d", errors.Safe(layout))
+		}
+		if shared > uint64(len(key)) {
+			return base.CorruptionErrorf("pebble: corrupt columnar data block")
+		}
+		out = binary.AppendUvarint(out, shared)
+		out = binary.AppendUvarint(out, uint64(len(key))-shared)
+		out = binary.AppendUvarint(out, uint64(len(value)))
+		out = append(out, key[shared:]...)
+		out = append(out, value...)
+	}
+	out = append(out, restarts...)
+	for i := range sections {
+		if sections[i].err != nil {
+			return sections[i].err
+		}
+	}
+	if len(out) != len(dst) || len(out) == 0 || &out[0] != &dst[0] {
+		return base.CorruptionErrorf("pebble: corrupt columnar data block")
+	}
+	return nil
+}
+
+func decodeBigEndian(b []byte) uint64 {
+	var x uint64
+	for _, c := range b {
+		x = x<<8 | uint64(c)
+	}
+	return x
+}
+
+func appendBigEndian(b []byte, x uint64, width int) []byte {
+	for i := width - 1; i >= 0; i-- {
+		b = append(b, byte(x>>(8*uint(i))))
+	}
+	return b
+}
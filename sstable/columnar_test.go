@@ -0,0 +1,116 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/stretchr/testify/require"
+)
+
+var testColumnarSchema = &ColumnarSchema{
+	Name: "test-row",
+	Columns: []ColumnSpec{
+		{Width: 8, Encoding: ColumnEncodingDelta},      // timestamp
+		{Width: 4, Encoding: ColumnEncodingDictionary}, // region
+		{Width: 4, Encoding: ColumnEncodingRaw},        // measurement
+	},
+}
+
+func testColumnarValue(rng *rand.Rand, i int) []byte {
+	if i%50 == 0 {
+		// A value that does not conform to the schema.
+		return []byte(fmt.Sprintf("free-form value %d", i))
+	}
+	v := binary.BigEndian.AppendUint64(nil, uint64(1_700_000_000+i*10+rng.Intn(3)))
+	v = append(v, []string{"east", "west", "nort", "sout"}[rng.Intn(4)]...)
+	return binary.LittleEndian.AppendUint32(v, rng.Uint32())
+}
+
+func TestColumnarBlockRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, restartInterval := range []int{1, 16} {
+		w := blockWriter{restartInterval: restartInterval}
+		for i := 0; i < 500; i++ {
+			key := base.MakeInternalKey([]byte(fmt.Sprintf("row%06d", i/3)), uint64(1000-i), base.InternalKeyKindSet)
+			w.add(key, testColumnarValue(rng, i))
+		}
+		b := w.finish()
+		for _, valuePrefix := range []bool{false, true} {
+			enc := encodeColumnarBlock(nil, b, testColumnarSchema, valuePrefix)
+			n, err := columnarBlockLen(enc)
+			require.NoError(t, err)
+			require.Equal(t, len(b), n)
+			dec := make([]byte, n)
+			require.NoError(t, decodeColumnarBlock(dec, enc))
+			require.Equal(t, b, dec)
+
+			// Truncated blocks are reported as corrupt.
+			require.Error(t, decodeColumnarBlock(dec, enc[:len(enc)/2]))
+		}
+	}
+}
+
+func TestColumnarSchemaValidate(t *testing.T) {
+	require.Error(t, (&ColumnarSchema{Name: "empty"}).validate())
+	require.Error(t, (&ColumnarSchema{Columns: []ColumnSpec{{Width: 3, Encoding: ColumnEncodingDelta}}}).validate())
+	require.Error(t, (&ColumnarSchema{Columns: []ColumnSpec{{Width: 0}}}).validate())
+	require.NoError(t, testColumnarSchema.validate())
+
+	w := NewWriter(&memFile{}, WriterOptions{ColumnarSchema: &ColumnarSchema{Name: "empty"}})
+	require.Error(t, w.Close())
+}
+
+func TestColumnarDataBlocks(t *testing.T) {
+	for _, format := range []TableFormat{TableFormatPebblev2, TableFormatPebblev4} {
+		t.Run(format.String(), func(t *testing.T) {
+			write := func(schema *ColumnarSchema) ([]byte, [][]byte) {
+				rng := rand.New(rand.NewSource(1))
+				f := &memFile{}
+				w := NewWriter(f, WriterOptions{
+					BlockSize:      4 << 10,
+					Compression:    SnappyCompression,
+					TableFormat:    format,
+					ColumnarSchema: schema,
+				})
+				var values [][]byte
+				for i := 0; i < 10000; i++ {
+					v := testColumnarValue(rng, i)
+					require.NoError(t, w.Set([]byte(fmt.Sprintf("row%08d", i)), v))
+					values = append(values, v)
+				}
+				require.NoError(t, w.Close())
+				return f.Data(), values
+			}
+			plain, _ := write(nil)
+			sst, values := write(testColumnarSchema)
+			require.Less(t, len(sst), len(plain))
+			t.Logf("columnar: %d bytes, row-oriented: %d bytes", len(sst), len(plain))
+
+			r, err := NewMemReader(sst, ReaderOptions{})
+			require.NoError(t, err)
+			require.NoError(t, r.ValidateBlockChecksums())
+			iter, err := r.NewIter(nil, nil)
+			require.NoError(t, err)
+			i := 0
+			for k, v := iter.First(); k != nil; k, v = iter.Next() {
+				require.Equal(t, fmt.Sprintf("row%08d", i), string(k.UserKey))
+				got, _, err := v.Value(nil)
+				require.NoError(t, err)
+				require.Equal(t, values[i], got)
+				i++
+			}
+			require.Equal(t, len(values), i)
+			k, _ := iter.SeekGE([]byte("row00005000"), 0)
+			require.Equal(t, "row00005000", string(k.UserKey))
+			require.NoError(t, iter.Close())
+			require.NoError(t, r.Close())
+		})
+	}
+}
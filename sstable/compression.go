@@ -20,7 +20,7 @@ func decompressedLen(blockType blockType, b []byte) (int, int, error) {
 	case snappyCompressionBlockType:
 		l, err := snappy.DecodedLen(b)
 		return l, 0, err
-	case zstdCompressionBlockType:
+	case zstdCompressionBlockType, zstdDictCompressionBlockType:
 		// This will also be used by zlib, bzip2 and lz4 to retrieve the decodedLen
 		// if we implement these algorithms in the future.
 		decodedLenU64, varIntLen := binary.Uvarint(b)
@@ -33,7 +33,11 @@ func decompressedLen(blockType blockType, b []byte) (int, int, error) {
 	}
 }
 
-func decompressInto(blockType blockType, compressed []byte, buf []byte) ([]byte, error) {
+// decompressInto decompresses the block into buf, using the table's zstd
+// dictionary zstdDict for blocks compressed with a dictionary.
+func decompressInto(
+	blockType blockType, compressed []byte, buf []byte, zstdDict []byte,
+) ([]byte, error) {
 	var result []byte
 	var err error
 	switch blockType {
@@ -41,6 +45,11 @@ func decompressInto(blockType blockType, compressed []byte, buf []byte) ([]byte,
 		result, err = snappy.Decode(buf, compressed)
 	case zstdCompressionBlockType:
 		result, err = decodeZstd(buf, compressed)
+	case zstdDictCompressionBlockType:
+		if zstdDict == nil {
+			return nil, base.CorruptionErrorf("pebble/table: zstd dictionary block in table without a dictionary")
+		}
+		result, err = decodeZstdDict(buf, compressed, zstdDict)
 	}
 	if err != nil {
 		return nil, base.MarkCorruptionError(err)
@@ -68,7 +77,7 @@ func decompressBlock(blockType blockType, b []byte) (*cache.Value, error) {
 	// Allocate sufficient space from the cache.
 	decoded := cache.Alloc(decodedLen)
 	decodedBuf := decoded.Buf()
-	if _, err := decompressInto(blockType, b, decodedBuf); err != nil {
+	if _, err := decompressInto(blockType, b, decodedBuf, nil /* zstdDict */); err != nil {
 		cache.Free(decoded)
 		return nil, err
 	}
@@ -78,6 +87,15 @@ func decompressBlock(blockType blockType, b []byte) (*cache.Value, error) {
 // compressBlock compresses an SST block, using compressBuf as the desired destination.
 func compressBlock(
 	compression Compression, b []byte, compressedBuf []byte,
+) (blockType blockType, compressed []byte) {
+	return compressBlockWithDict(compression, nil /* zstdDict */, b, compressedBuf)
+}
+
+// compressBlockWithDict is like compressBlock, but compresses the block with
+// the zstd dictionary zstdDict if it is non-nil and the compression is
+// ZstdCompression.
+func compressBlockWithDict(
+	compression Compression, zstdDict []byte, b []byte, compressedBuf []byte,
 ) (blockType blockType, compressed []byte) {
 	switch compression {
 	case SnappyCompression:
@@ -92,6 +110,9 @@ func compressBlock(
 	varIntLen := binary.PutUvarint(compressedBuf, uint64(len(b)))
 	switch compression {
 	case ZstdCompression:
+		if zstdDict != nil {
+			return zstdDictCompressionBlockType, encodeZstdDict(compressedBuf, varIntLen, b, zstdDict)
+		}
 		return zstdCompressionBlockType, encodeZstd(compressedBuf, varIntLen, b)
 	default:
 		return noCompressionBlockType, b
@@ -9,6 +9,7 @@ package sstable
 
 import (
 	"bytes"
+	"io"
 
 	"github.com/DataDog/zstd"
 )
@@ -32,3 +33,24 @@ func encodeZstd(compressedBuf []byte, varIntLen int, b []byte) []byte {
 	writer.Close()
 	return buf.Bytes()
 }
+
+// decodeZstdDict is like decodeZstd, but decompresses b with the zstd
+// dictionary dict. decodedBuf must have the length of the decompressed block.
+func decodeZstdDict(decodedBuf, b, dict []byte) ([]byte, error) {
+	reader := zstd.NewReaderDict(bytes.NewReader(b), dict)
+	defer reader.Close()
+	if _, err := io.ReadFull(reader, decodedBuf); err != nil {
+		return nil, err
+	}
+	return decodedBuf, nil
+}
+
+// encodeZstdDict is like encodeZstd, but compresses b with the zstd
+// dictionary dict.
+func encodeZstdDict(compressedBuf []byte, varIntLen int, b, dict []byte) []byte {
+	buf := bytes.NewBuffer(compressedBuf[:varIntLen])
+	writer := zstd.NewWriterLevelDict(buf, 3, dict)
+	writer.Write(b)
+	writer.Close()
+	return buf.Bytes()
+}
@@ -7,7 +7,10 @@
 
 package sstable
 
-import "github.com/klauspost/compress/zstd"
+import (
+	"github.com/cockroachdb/errors"
+	"github.com/klauspost/compress/zstd"
+)
 
 // decodeZstd decompresses b with the Zstandard algorithm.
 // It reuses the preallocated capacity of decodedBuf if it is sufficient.
@@ -28,3 +31,27 @@ func encodeZstd(compressedBuf []byte, varIntLen int, b []byte) []byte {
 	defer encoder.Close()
 	return encoder.EncodeAll(b, compressedBuf[:varIntLen])
 }
+
+// decodeZstdDict is like decodeZstd, but decompresses b with the raw content
+// zstd dictionary dict.
+func decodeZstdDict(decodedBuf, b, dict []byte) ([]byte, error) {
+	// Raw content dictionaries have no ID, and frames compressed with them
+	// are decoded with the dictionary registered with ID 0.
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderDictRaw(0, dict))
+	if err != nil {
+		return nil, err
+	}
+	defer decoder.Close()
+	return decoder.DecodeAll(b, decodedBuf[:0])
+}
+
+// encodeZstdDict is like encodeZstd, but compresses b with the raw content
+// zstd dictionary dict.
+func encodeZstdDict(compressedBuf []byte, varIntLen int, b, dict []byte) []byte {
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderDictRaw(0, dict))
+	if err != nil {
+		panic(errors.Wrap(err, "pebble/table: invalid zstd dictionary"))
+	}
+	defer encoder.Close()
+	return encoder.EncodeAll(b, compressedBuf[:varIntLen])
+}
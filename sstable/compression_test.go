@@ -6,6 +6,7 @@ package sstable
 
 import (
 	"encoding/binary"
+	"encoding/hex"
 	"math/rand"
 	"testing"
 	"time"
@@ -58,3 +59,34 @@ func TestDecompressionError(t *testing.T) {
 	require.Error(t, err)
 	require.Nil(t, v)
 }
+
+// TestZstdDictInterop checks that blocks compressed with a zstd dictionary in
+// builds with and without cgo, which use different zstd implementations, can
+// be decompressed by either.
+func TestZstdDictInterop(t *testing.T) {
+	dict := []byte(`"status":"active","region":"us-east-1","plan":"premium"}{"user_id":`)
+	value := []byte(`{"user_id":12345,"status":"active","region":"us-east-1","plan":"premium"}`)
+	for _, tc := range []struct {
+		name       string
+		compressed string
+	}{
+		{"cgo", "28b52ffd00587d00003031323334352c0200bdc229c70508"},
+		{"nocgo", "28b52ffd04007d00003031323334352c0200bdc229c705080e61f964"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			compressed, err := hex.DecodeString(tc.compressed)
+			require.NoError(t, err)
+			buf := make([]byte, len(value))
+			got, err := decompressInto(zstdDictCompressionBlockType, compressed, buf, dict)
+			require.NoError(t, err)
+			require.Equal(t, value, got)
+		})
+	}
+
+	// Blocks compressed with the dictionary in this build round trip.
+	compressed := encodeZstdDict(nil, 0, value, dict)
+	buf := make([]byte, len(value))
+	got, err := decompressInto(zstdDictCompressionBlockType, compressed, buf, dict)
+	require.NoError(t, err)
+	require.Equal(t, value, got)
+}
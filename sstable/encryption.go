@@ -0,0 +1,139 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"sync/atomic"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+)
+
+// KeyManager wraps and unwraps the data keys of encrypted sstables. See
+// WriterOptions.KeyManager.
+//
+// Each encrypted sstable has its own randomly generated data key, with which
+// its blocks are encrypted with AES-256-GCM. The data key is stored in the
+// sstable in the wrapped form returned by the key manager, which typically
+// encrypts it with a key encryption key held outside of the sstable, such as
+// in a KMS, and identifies that key. Since the encryption is part of the
+// sstable, it protects sstables wherever they are stored, including on shared
+// object storage.
+type KeyManager interface {
+	// WrapKey returns the wrapped form of the data key of a new sstable, which
+	// is stored in the sstable.
+	WrapKey(dataKey []byte) (wrapped []byte, err error)
+	// UnwrapKey returns the data key of a wrapped key returned by WrapKey. It
+	// is called whenever an encrypted sstable is opened, so the keys needed
+	// to unwrap the data keys of sstables that may still be read must remain
+	// available.
+	UnwrapKey(wrapped []byte) (dataKey []byte, err error)
+}
+
+// encryptedBlockTypeFlag is set in the block type of the trailer of an
+// encrypted block. The remaining bits of the block type describe the block
+// once decrypted.
+const encryptedBlockTypeFlag blockType = 0x40
+
+// encryptionBlockVersion is the version of the encoding of the
+// metaEncryptionName meta block, which is the version followed by the wrapped
+// data key.
+const encryptionBlockVersion = 1
+
+const (
+	dataKeyLen = 32
+	// blockNonceLen is the length of the nonce stored at the start of each
+	// encrypted block. The nonce of the AEAD is the stored nonce padded with
+	// zeros.
+	blockNonceLen = 8
+)
+
+// blockCipher encrypts and decrypts the blocks of a table with its data key.
+// An encrypted block is a nonce followed by the sealed block, including the
+// AEAD's tag. Since every table has its own data key, the nonces are a
+// counter of the blocks encrypted by the writer.
+type blockCipher struct {
+	aead  cipher.AEAD
+	nonce atomic.Uint64
+}
+
+func newBlockCipher(dataKey []byte) (*blockCipher, error) {
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "pebble/table: invalid data key")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &blockCipher{aead: aead}, nil
+}
+
+// newWriterBlockCipher generates a data key for a new table and returns the
+// cipher encrypting its blocks, along with the contents of the table's
+// encryption block.
+func newWriterBlockCipher(km KeyManager) (*blockCipher, []byte, error) {
+	dataKey := make([]byte, dataKeyLen)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := km.WrapKey(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := newBlockCipher(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, append([]byte{encryptionBlockVersion}, wrapped...), nil
+}
+
+// newReaderBlockCipher returns the cipher decrypting the blocks of a table,
+// given the contents of its encryption block.
+func newReaderBlockCipher(km KeyManager, b []byte) (*blockCipher, error) {
+	if km == nil {
+		return nil, errors.New("pebble/table: sstable is encrypted, but no KeyManager is configured")
+	}
+	if len(b) == 0 || b[0] != encryptionBlockVersion {
+		return nil, base.CorruptionErrorf("pebble/table: unknown encryption block %x", b)
+	}
+	dataKey, err := km.UnwrapKey(b[1:])
+	if err != nil {
+		return nil, err
+	}
+	return newBlockCipher(dataKey)
+}
+
+// overhead returns the number of bytes encryption adds to a block.
+func (c *blockCipher) overhead() int {
+	return blockNonceLen + c.aead.Overhead()
+}
+
+// seal appends the encryption of the block b to dst. It's safe to call
+// concurrently.
+func (c *blockCipher) seal(dst, b []byte) []byte {
+	var nonce [12]byte
+	binary.LittleEndian.PutUint64(nonce[:], c.nonce.Add(1))
+	dst = append(dst, nonce[:blockNonceLen]...)
+	return c.aead.Seal(dst, nonce[:c.aead.NonceSize()], b, nil)
+}
+
+// open appends the decryption of the encrypted block b to dst.
+func (c *blockCipher) open(dst, b []byte) ([]byte, error) {
+	if len(b) < c.overhead() {
+		return nil, base.CorruptionErrorf("pebble/table: encrypted block too short")
+	}
+	var nonce [12]byte
+	copy(nonce[:], b[:blockNonceLen])
+	dst, err := c.aead.Open(dst, nonce[:c.aead.NonceSize()], b[blockNonceLen:], nil)
+	if err != nil {
+		return nil, base.CorruptionErrorf("pebble/table: failed to decrypt block: %v", err)
+	}
+	return dst, nil
+}
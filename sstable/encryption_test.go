@@ -0,0 +1,142 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/stretchr/testify/require"
+)
+
+// testKeyManager wraps data keys by XORing them with its key encryption key,
+// identified by the first byte of the wrapped key.
+type testKeyManager struct {
+	id  byte
+	kek byte
+}
+
+func (m testKeyManager) WrapKey(dataKey []byte) ([]byte, error) {
+	wrapped := append([]byte{m.id}, dataKey...)
+	for i := 1; i < len(wrapped); i++ {
+		wrapped[i] ^= m.kek
+	}
+	return wrapped, nil
+}
+
+func (m testKeyManager) UnwrapKey(wrapped []byte) ([]byte, error) {
+	if len(wrapped) == 0 || wrapped[0] != m.id {
+		return nil, errors.Newf("unknown key encryption key")
+	}
+	dataKey := append([]byte(nil), wrapped[1:]...)
+	for i := range dataKey {
+		dataKey[i] ^= m.kek
+	}
+	return dataKey, nil
+}
+
+func TestEncryption(t *testing.T) {
+	km := testKeyManager{id: 1, kek: 0x5a}
+	for _, format := range []TableFormat{TableFormatPebblev2, TableFormatPebblev4} {
+		for _, compression := range []Compression{NoCompression, SnappyCompression} {
+			t.Run(fmt.Sprintf("%s/%s", format, compression), func(t *testing.T) {
+				f := &memFile{}
+				w := NewWriter(f, WriterOptions{
+					BlockSize:      256,
+					IndexBlockSize: 256,
+					Comparer:       testkeys.Comparer,
+					Compression:    compression,
+					TableFormat:    format,
+					KeyManager:     km,
+				})
+				var keys, values []string
+				for i := 0; i < 200; i++ {
+					// The older version of each key is stored in a value block
+					// in formats with value blocks.
+					for j := 2; j >= 1; j-- {
+						keys = append(keys, fmt.Sprintf("key%04d@%d", i, j))
+						values = append(values, fmt.Sprintf("secret-value-%04d-%d", i, j))
+						require.NoError(t, w.Set([]byte(keys[len(keys)-1]), []byte(values[len(values)-1])))
+					}
+				}
+				require.NoError(t, w.DeleteRange([]byte("key0100"), []byte("key0150")))
+				require.NoError(t, w.RangeKeySet([]byte("key0000"), []byte("key0010"), []byte("@5"), []byte("secret-range-key")))
+				require.NoError(t, w.Close())
+				sst := f.Data()
+
+				// Neither keys nor values appear in the sstable.
+				require.False(t, bytes.Contains(sst, []byte("secret-")))
+				require.False(t, bytes.Contains(sst, []byte("key0")))
+
+				r, err := NewMemReader(sst, ReaderOptions{Comparer: testkeys.Comparer, KeyManager: km})
+				require.NoError(t, err)
+				defer r.Close()
+				require.NotNil(t, r.cipher)
+				require.Equal(t, format >= TableFormatPebblev3, r.Properties.NumValueBlocks > 0)
+				require.NoError(t, r.ValidateBlockChecksums())
+
+				iter, err := r.NewIter(nil, nil)
+				require.NoError(t, err)
+				i := 0
+				for k, v := iter.First(); k != nil; k, v = iter.Next() {
+					require.Equal(t, keys[i], string(k.UserKey))
+					got, _, err := v.Value(nil)
+					require.NoError(t, err)
+					require.Equal(t, values[i], string(got))
+					i++
+				}
+				require.Equal(t, len(keys), i)
+				require.NoError(t, iter.Close())
+
+				rangeDelIter, err := r.NewRawRangeDelIter()
+				require.NoError(t, err)
+				s := rangeDelIter.First()
+				require.NotNil(t, s)
+				require.Equal(t, "key0100", string(s.Start))
+				require.NoError(t, rangeDelIter.Close())
+
+				rangeKeyIter, err := r.NewRawRangeKeyIter()
+				require.NoError(t, err)
+				s = rangeKeyIter.First()
+				require.NotNil(t, s)
+				require.Equal(t, "secret-range-key", string(s.Keys[0].Value))
+				require.NoError(t, rangeKeyIter.Close())
+
+				// The sstable can't be opened without the key manager able to
+				// unwrap its data key.
+				_, err = NewMemReader(sst, ReaderOptions{Comparer: testkeys.Comparer})
+				require.Error(t, err)
+				_, err = NewMemReader(sst, ReaderOptions{
+					Comparer:   testkeys.Comparer,
+					KeyManager: testKeyManager{id: 2, kek: 0x5a},
+				})
+				require.Error(t, err)
+			})
+		}
+	}
+}
+
+func TestBlockCipher(t *testing.T) {
+	c, err := newBlockCipher(bytes.Repeat([]byte{1}, dataKeyLen))
+	require.NoError(t, err)
+	block := []byte("a block of an sstable")
+	sealed := c.seal(nil, block)
+	require.Equal(t, len(block)+c.overhead(), len(sealed))
+	// Each block is sealed with a distinct nonce.
+	require.NotEqual(t, sealed, c.seal(nil, block))
+
+	opened, err := c.open(nil, sealed)
+	require.NoError(t, err)
+	require.Equal(t, block, opened)
+
+	sealed[len(sealed)-1] ^= 1
+	_, err = c.open(nil, sealed)
+	require.Error(t, err)
+	_, err = c.open(nil, sealed[:c.overhead()-1])
+	require.Error(t, err)
+}
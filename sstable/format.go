@@ -188,7 +188,9 @@ func ParseTableFormat(magic []byte, version uint32) (TableFormat, error) {
 	case levelDBMagic:
 		return TableFormatLevelDB, nil
 	case rocksDBMagic:
-		if version != rocksDBFormatVersion2 {
+		// Tables written by RocksDB with format versions newer than 2 are read
+		// as TableFormatRocksDBv2; Pebble only ever writes version 2.
+		if version < rocksDBFormatVersion2 || version > rocksDBFormatVersion5 {
 			return TableFormatUnspecified, base.CorruptionErrorf(
 				"pebble/table: unsupported rocksdb format version %d", errors.Safe(version),
 			)
@@ -62,6 +62,12 @@ func TestTableFormat_RoundTrip(t *testing.T) {
 			version: 1,
 			wantErr: "pebble/table: unsupported rocksdb format version 1",
 		},
+		{
+			name:    "Unsupported RocksDB version",
+			magic:   rocksDBMagic,
+			version: 6,
+			wantErr: "pebble/table: unsupported rocksdb format version 6",
+		},
 		{
 			name:    "Invalid PebbleDB version",
 			magic:   pebbleDBMagic,
@@ -20,7 +20,7 @@ import (
 type Layout struct {
 	// NOTE: changes to fields in this struct should also be reflected in
 	// ValidateBlockChecksums, which validates a static list of BlockHandles
-	// referenced in this struct.
+	// referenced in this struct, and in Stats.
 
 	Data       []BlockHandleWithProperties
 	Index      []BlockHandle
@@ -30,6 +30,8 @@ type Layout struct {
 	RangeKey   BlockHandle
 	ValueBlock []BlockHandle
 	ValueIndex BlockHandle
+	ZstdDict   BlockHandle
+	Encryption BlockHandle
 	Properties BlockHandle
 	MetaIndex  BlockHandle
 	Footer     BlockHandle
@@ -72,6 +74,12 @@ func (l *Layout) Describe(
 	if l.ValueIndex.Length != 0 {
 		blocks = append(blocks, block{l.ValueIndex, "value-index"})
 	}
+	if l.ZstdDict.Length != 0 {
+		blocks = append(blocks, block{l.ZstdDict, "zstd-dict"})
+	}
+	if l.Encryption.Length != 0 {
+		blocks = append(blocks, block{l.Encryption, "encryption"})
+	}
 	if l.Properties.Length != 0 {
 		blocks = append(blocks, block{l.Properties, "properties"})
 	}
@@ -144,7 +152,8 @@ func (l *Layout) Describe(
 		}
 
 		h, err := r.readBlock(
-			context.Background(), b.BlockHandle, nil /* transform */, nil /* readHandle */, nil /* stats */, nil /* buffer pool */)
+			context.Background(), b.BlockHandle, BlockKindOther, nil /* transform */, nil, /* readHandle */
+			nil /* stats */, nil /* cacheStats */, nil /* buffer pool */)
 		if err != nil {
 			fmt.Fprintf(w, "  [err: %s]\n", err)
 			continue
@@ -219,8 +228,11 @@ func (l *Layout) Describe(
 						v := value.InPlaceValue()
 						if base.TrailerKind(key.Trailer) != InternalKeyKindSet {
 							fmtRecord(key, v)
-						} else if !isValueHandle(valuePrefix(v[0])) {
+						} else if isInPlaceValue(valuePrefix(v[0])) {
 							fmtRecord(key, v[1:])
+						} else if isBlobHandle(valuePrefix(v[0])) {
+							valLen, h := decodeLenFromValueHandle(v[1:])
+							fmtRecord(key, []byte(fmt.Sprintf("blob handle {valueLen:%d handle:%x}", valLen, h)))
 						} else {
 							vh := decodeValueHandle(v[1:])
 							fmtRecord(key, []byte(fmt.Sprintf("value handle %+v", vh)))
@@ -304,3 +316,151 @@ func (l *Layout) Describe(
 	last := blocks[len(blocks)-1]
 	fmt.Fprintf(w, "%10d  EOF\n", last.Offset+last.Length)
 }
+
+// BlockKindStats summarizes the blocks of one kind in an sstable.
+type BlockKindStats struct {
+	// Count is the number of blocks.
+	Count uint64
+	// CompressedCount is the number of blocks that are stored compressed.
+	CompressedCount uint64
+	// Size is the size of the blocks as stored in the sstable, excluding their
+	// trailers.
+	Size uint64
+	// UncompressedSize is the size of the blocks once decrypted and
+	// decompressed.
+	UncompressedSize uint64
+}
+
+// CompressionRatio returns the ratio of the uncompressed size to the stored
+// size of the blocks, or 0 if there are no blocks.
+func (s BlockKindStats) CompressionRatio() float64 {
+	if s.Size == 0 {
+		return 0
+	}
+	return float64(s.UncompressedSize) / float64(s.Size)
+}
+
+func (s *BlockKindStats) add(o BlockKindStats) {
+	s.Count += o.Count
+	s.CompressedCount += o.CompressedCount
+	s.Size += o.Size
+	s.UncompressedSize += o.UncompressedSize
+}
+
+// LayoutStats summarizes the blocks of an sstable by kind.
+type LayoutStats struct {
+	Data BlockKindStats
+	// Index includes both the top-level index block and the index partitions
+	// of two-level indexes.
+	Index      BlockKindStats
+	Filter     BlockKindStats
+	RangeDel   BlockKindStats
+	RangeKey   BlockKindStats
+	ValueBlock BlockKindStats
+	ValueIndex BlockKindStats
+	ZstdDict   BlockKindStats
+	Encryption BlockKindStats
+	Properties BlockKindStats
+	MetaIndex  BlockKindStats
+}
+
+// Total returns the summary of the blocks of all kinds.
+func (s *LayoutStats) Total() BlockKindStats {
+	var t BlockKindStats
+	for _, k := range []BlockKindStats{
+		s.Data, s.Index, s.Filter, s.RangeDel, s.RangeKey,
+		s.ValueBlock, s.ValueIndex, s.ZstdDict, s.Encryption, s.Properties, s.MetaIndex,
+	} {
+		t.add(k)
+	}
+	return t
+}
+
+// Stats returns the statistics of the blocks in the layout. The header of
+// each block is read from r, the reader the layout was retrieved from, to
+// determine its uncompressed size, but blocks aren't decompressed and the
+// block cache isn't used.
+func (l *Layout) Stats(r *Reader) (LayoutStats, error) {
+	var s LayoutStats
+	add := func(ks *BlockKindStats, bh BlockHandle) error {
+		if bh.Length == 0 {
+			return nil
+		}
+		bs, err := r.blockKindStats(bh)
+		if err != nil {
+			return err
+		}
+		ks.add(bs)
+		return nil
+	}
+	for i := range l.Data {
+		if err := add(&s.Data, l.Data[i].BlockHandle); err != nil {
+			return LayoutStats{}, err
+		}
+	}
+	for i := range l.Index {
+		if err := add(&s.Index, l.Index[i]); err != nil {
+			return LayoutStats{}, err
+		}
+	}
+	for i := range l.ValueBlock {
+		if err := add(&s.ValueBlock, l.ValueBlock[i]); err != nil {
+			return LayoutStats{}, err
+		}
+	}
+	for _, b := range []struct {
+		ks *BlockKindStats
+		bh BlockHandle
+	}{
+		{&s.Index, l.TopIndex},
+		{&s.Filter, l.Filter},
+		{&s.RangeDel, l.RangeDel},
+		{&s.RangeKey, l.RangeKey},
+		{&s.ValueIndex, l.ValueIndex},
+		{&s.ZstdDict, l.ZstdDict},
+		{&s.Encryption, l.Encryption},
+		{&s.Properties, l.Properties},
+		{&s.MetaIndex, l.MetaIndex},
+	} {
+		if err := add(b.ks, b.bh); err != nil {
+			return LayoutStats{}, err
+		}
+	}
+	return s, nil
+}
+
+// blockKindStats reads the block identified by bh, verifying its checksum,
+// and returns the statistics of the block.
+func (r *Reader) blockKindStats(bh BlockHandle) (BlockKindStats, error) {
+	b := make([]byte, bh.Length+blockTrailerLen)
+	if err := r.readable.ReadAt(context.TODO(), b, int64(bh.Offset)); err != nil {
+		return BlockKindStats{}, err
+	}
+	if err := checkChecksum(r.checksumType, b, bh, r.fileNum.FileNum()); err != nil {
+		return BlockKindStats{}, err
+	}
+	typ := blockType(b[bh.Length])
+	encrypted := typ&encryptedBlockTypeFlag != 0
+	typ &^= encryptedBlockTypeFlag | columnarBlockTypeFlag
+	b = b[:bh.Length]
+	if encrypted {
+		if r.cipher == nil || len(b) < r.cipher.overhead() {
+			return BlockKindStats{}, base.CorruptionErrorf(
+				"pebble/table: unexpected encrypted block in %s", r.fileNum)
+		}
+		var err error
+		if b, err = r.cipher.open(nil, b); err != nil {
+			return BlockKindStats{}, err
+		}
+	}
+	s := BlockKindStats{Count: 1, Size: bh.Length, UncompressedSize: uint64(len(b))}
+	if typ != noCompressionBlockType {
+		decodedLen, _, err := decompressedLen(typ, b)
+		if err != nil {
+			return BlockKindStats{}, err
+		}
+		s.CompressedCount = 1
+		s.UncompressedSize = uint64(decodedLen)
+	}
+	return s, nil
+}
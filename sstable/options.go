@@ -61,6 +61,9 @@ type FilterWriter = base.FilterWriter
 // FilterPolicy exports the base.FilterPolicy type.
 type FilterPolicy = base.FilterPolicy
 
+// PrefixExtractor exports the base.PrefixExtractor type.
+type PrefixExtractor = base.PrefixExtractor
+
 // TablePropertyCollector provides a hook for collecting user-defined
 // properties based on the keys and values stored in an sstable. A new
 // TablePropertyCollector is created for an sstable when the sstable is being
@@ -104,6 +107,25 @@ type SuffixReplaceableTableCollector interface {
 	UpdateKeySuffixes(oldProps map[string]string, oldSuffix, newSuffix []byte) error
 }
 
+// AggregatingTablePropertyCollector is an extension to the
+// TablePropertyCollector interface that allows the properties collected by a
+// table property collector to be combined across sstables, i.e. for a key
+// range of a DB.
+//
+// For example, a collector which counts the rows of each table would sum the
+// counts of the tables, while one which records the schema version of each
+// table would keep the minimum and maximum of their versions.
+type AggregatingTablePropertyCollector interface {
+	TablePropertyCollector
+
+	// Aggregate is called with the user properties of each table being
+	// aggregated, and combines the properties collected by this collector into
+	// aggregate. The aggregate is initially empty, and is shared with the other
+	// collectors being aggregated, so each collector must only modify its own
+	// properties.
+	Aggregate(aggregate map[string]string, props map[string]string) error
+}
+
 // ReaderOptions holds the parameters needed for reading an sstable.
 type ReaderOptions struct {
 	// Cache is used to cache uncompressed blocks from sstables.
@@ -111,6 +133,10 @@ type ReaderOptions struct {
 	// The default cache size is a zero-size cache.
 	Cache *cache.Cache
 
+	// CacheStats, if non-nil, accumulates the block cache lookups of the
+	// Reader, except those of iterators created with their own CacheStats.
+	CacheStats *CacheStats
+
 	// User properties specified in this map will not be added to sst.Properties.UserProperties.
 	DeniedUserProperties map[string]struct{}
 
@@ -121,6 +147,18 @@ type ReaderOptions struct {
 	// The default value uses the same ordering as bytes.Compare.
 	Comparer *Comparer
 
+	// KeyManager unwraps the data keys of encrypted sstables. Encrypted
+	// sstables can't be opened without it.
+	KeyManager KeyManager
+
+	// PinTopLevelIndex pins the top-level index block of tables with two-level
+	// indexes for the lifetime of the Reader, so that seeks only need to find
+	// the index partition they use in the block cache. The pinned blocks
+	// remain in memory while the tables are open even if they're evicted from
+	// the block cache, where they're no longer accounted for. The size of the
+	// index partitions is controlled by WriterOptions.IndexBlockSize.
+	PinTopLevelIndex bool
+
 	// Merge defines the Merge function in use for this keyspace.
 	Merge base.Merge
 
@@ -130,6 +168,14 @@ type ReaderOptions struct {
 	// map during normal usage of a DB.
 	Filters map[string]FilterPolicy
 
+	// PrefixExtractor extracts the prefixes looked up in table filters. The
+	// filters of sstables written with a prefix extractor are only used if it
+	// has the same name as PrefixExtractor.
+	//
+	// The default value means filters are looked up by the prefixes returned by
+	// Comparer.Split.
+	PrefixExtractor *PrefixExtractor
+
 	// Merger defines the associative merge operation to use for merging values
 	// written with {Batch,DB}.Merge. The MergerName is checked for consistency
 	// with the value stored in the sstable when it was written.
@@ -137,6 +183,11 @@ type ReaderOptions struct {
 
 	// Logger is an optional logger and tracer.
 	LoggerAndTracer base.LoggerAndTracer
+
+	// BlobValueFetcher fetches the values that are stored in blob files, given
+	// the blob handles stored in the sstable. The default value fails to
+	// fetch these values.
+	BlobValueFetcher base.ValueFetcher
 }
 
 func (o ReaderOptions) ensureDefaults() ReaderOptions {
@@ -155,6 +206,9 @@ func (o ReaderOptions) ensureDefaults() ReaderOptions {
 	if o.DeniedUserProperties == nil {
 		o.DeniedUserProperties = ignoredInternalProperties
 	}
+	if o.BlobValueFetcher == nil {
+		o.BlobValueFetcher = noBlobValueFetcher{}
+	}
 	return o
 }
 
@@ -166,6 +220,13 @@ type WriterOptions struct {
 	// The default value is 16.
 	BlockRestartInterval int
 
+	// DisableKeyDeltaEncoding stores every key of the data blocks in full,
+	// rather than only the suffix by which a key differs from the preceding
+	// key for keys between restart points. Seeks and point lookups then don't
+	// need to reassemble the keys they step over, at the cost of larger data
+	// blocks. Sstables written with it set are readable by all versions.
+	DisableKeyDeltaEncoding bool
+
 	// BlockSize is the target uncompressed size in bytes of each table block.
 	//
 	// The default value is 4096.
@@ -195,11 +256,59 @@ type WriterOptions struct {
 	// The default value (DefaultCompression) uses snappy compression.
 	Compression Compression
 
+	// ValueBlockCompression defines the compression of value blocks, which
+	// are only written in TableFormatPebblev3 and later. Values are read far
+	// less often than the keys of data blocks, which are read by every seek,
+	// so value blocks may be better off with a slower compression achieving a
+	// better ratio, such as ZstdCompression.
+	//
+	// The default value (DefaultCompression) uses the Compression of data
+	// blocks.
+	ValueBlockCompression Compression
+
+	// ValueBlockSize is the target uncompressed size in bytes of each value
+	// block.
+	//
+	// The default value is the value of BlockSize.
+	ValueBlockSize int
+
+	// ZstdDictionary, if set and Compression is ZstdCompression, is the zstd
+	// dictionary with which the data blocks are compressed, such as one
+	// trained by TrainZstdDictionary. The dictionary is stored in the sstable,
+	// which cannot be read by versions of Pebble predating dictionary
+	// compression.
+	ZstdDictionary []byte
+
+	// TrainZstdDictionarySize, if positive, makes the Writer sample its
+	// uncompressed data blocks and train a zstd dictionary of up to this size
+	// from them when it is closed, returned in
+	// WriterMetadata.TrainedZstdDictionary. The dictionary is meant for
+	// compressing later sstables holding similar data.
+	TrainZstdDictionarySize int
+
+	// ColumnarSchema, if set, is the fixed schema of values with which data
+	// blocks are stored in a columnar encoding, which improves their
+	// compression. See ColumnarSchema.
+	//
+	// The default value means data blocks are written in the row-oriented
+	// layout.
+	ColumnarSchema *ColumnarSchema
+
+	// KeyManager, if set, encrypts the sstable's blocks with a data key
+	// generated for the sstable, which is stored in the sstable wrapped by the
+	// KeyManager. All blocks are encrypted except for the metaindex block and
+	// the block holding the wrapped data key. Encrypted sstables can't be read
+	// by versions of Pebble predating encryption. See KeyManager.
+	//
+	// The default value means the sstable isn't encrypted.
+	KeyManager KeyManager
+
 	// FilterPolicy defines a filter algorithm (such as a Bloom filter) that can
 	// reduce disk reads for Get calls.
 	//
 	// One such implementation is bloom.FilterPolicy(10) from the pebble/bloom
-	// package.
+	// package. ribbon.FilterPolicy(10) from the pebble/ribbon package has the
+	// same false positive rate using ~30% less space.
 	//
 	// The default value means to use no filter.
 	FilterPolicy FilterPolicy
@@ -213,6 +322,12 @@ type WriterOptions struct {
 	// filters should be preferred except under constrained memory situations.
 	FilterType FilterType
 
+	// PrefixExtractor extracts the prefixes of keys added to the filter, in
+	// place of Comparer.Split.
+	//
+	// The default value means prefixes are extracted by Comparer.Split.
+	PrefixExtractor *PrefixExtractor
+
 	// IndexBlockSize is the target uncompressed size in bytes of each index
 	// block. When the index block size is larger than this target, two-level
 	// indexes are automatically enabled. Setting this option to a large value
@@ -254,7 +369,11 @@ type WriterOptions struct {
 	// built and lives for the lifetime of writing that table.
 	BlockPropertyCollectors []func() BlockPropertyCollector
 
-	// Checksum specifies which checksum to use.
+	// Checksum specifies which checksum to use. Tables in TableFormatLevelDB,
+	// whose footer doesn't record a checksum type, always use
+	// ChecksumTypeCRC32c.
+	//
+	// The default value is ChecksumTypeCRC32c.
 	Checksum ChecksumType
 
 	// Parallelism is used to indicate that the sstable Writer is allowed to
@@ -290,6 +409,12 @@ func (o WriterOptions) ensureDefaults() WriterOptions {
 	if o.IndexBlockSize <= 0 {
 		o.IndexBlockSize = o.BlockSize
 	}
+	if o.ValueBlockCompression <= DefaultCompression || o.ValueBlockCompression >= NCompression {
+		o.ValueBlockCompression = o.Compression
+	}
+	if o.ValueBlockSize <= 0 {
+		o.ValueBlockSize = o.BlockSize
+	}
 	if o.MergerName == "" {
 		o.MergerName = base.DefaultMerger.Name
 	}
@@ -301,5 +426,8 @@ func (o WriterOptions) ensureDefaults() WriterOptions {
 	if o.TableFormat == TableFormatUnspecified {
 		o.TableFormat = TableFormatRocksDBv2
 	}
+	if o.TableFormat == TableFormatLevelDB {
+		o.Checksum = ChecksumTypeCRC32c
+	}
 	return o
 }
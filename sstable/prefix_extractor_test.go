@@ -0,0 +1,105 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/pebble/bloom"
+	"github.com/stretchr/testify/require"
+)
+
+// testPrefixExtractor extracts the prefix of a key up to and including its
+// first '/'.
+var testPrefixExtractor = &PrefixExtractor{
+	Name: "test-slash",
+	Split: func(a []byte) int {
+		if i := bytes.IndexByte(a, '/'); i >= 0 {
+			return i + 1
+		}
+		return len(a)
+	},
+}
+
+func TestPrefixExtractor(t *testing.T) {
+	for _, indexBlockSize := range []int{0, 1} {
+		t.Run(fmt.Sprintf("index-block-size=%d", indexBlockSize), func(t *testing.T) {
+			f := &memFile{}
+			w := NewWriter(f, WriterOptions{
+				BlockSize:       64,
+				IndexBlockSize:  indexBlockSize,
+				FilterPolicy:    bloom.FilterPolicy(10),
+				PrefixExtractor: testPrefixExtractor,
+				TableFormat:     TableFormatPebblev2,
+			})
+			for _, tenant := range []string{"t1", "t3"} {
+				for i := 0; i < 20; i++ {
+					require.NoError(t, w.Set([]byte(fmt.Sprintf("%s/%03d", tenant, i)), []byte("v")))
+				}
+			}
+			require.NoError(t, w.Close())
+			sst := f.Data()
+
+			var tracker FilterMetricsTracker
+			r, err := NewReader(newMemReader(sst), ReaderOptions{
+				Filters:         map[string]FilterPolicy{bloom.FilterPolicy(10).Name(): bloom.FilterPolicy(10)},
+				PrefixExtractor: testPrefixExtractor,
+			}, &tracker)
+			require.NoError(t, err)
+			require.Equal(t, "test-slash", r.Properties.PrefixExtractorName)
+			require.NotNil(t, r.tableFilter)
+
+			seekGE := func(key, upper string) string {
+				iter, err := r.NewIter(nil, []byte(upper))
+				require.NoError(t, err)
+				defer func() { require.NoError(t, iter.Close()) }()
+				k, _ := iter.SeekGE([]byte(key), 0)
+				if k == nil {
+					return ""
+				}
+				return string(k.UserKey)
+			}
+			// The scan of a prefix that is in the table reads it.
+			require.Equal(t, "t1/000", seekGE("t1/", "t1/\xff"))
+			require.Equal(t, "t3/010", seekGE("t3/010", "t3/\xff"))
+			require.Equal(t, int64(0), tracker.Load().Hits)
+			// The scan of a prefix that is not in the table is excluded by the
+			// filter.
+			require.Equal(t, "", seekGE("t2/", "t2/\xff"))
+			require.Equal(t, int64(1), tracker.Load().Hits)
+			// The filter is not consulted if the upper bound has another prefix.
+			require.Equal(t, "t3/000", seekGE("t2/", "t4/"))
+			require.Equal(t, int64(1), tracker.Load().Hits)
+
+			// SeekPrefixGE looks up the extracted prefix of its prefix.
+			iter, err := r.NewIter(nil, nil)
+			require.NoError(t, err)
+			k, _ := iter.SeekPrefixGE([]byte("t1/005"), []byte("t1/005"), 0)
+			require.NotNil(t, k)
+			require.Equal(t, "t1/005", string(k.UserKey))
+			k, _ = iter.SeekPrefixGE([]byte("t2/005"), []byte("t2/005"), 0)
+			require.Nil(t, k)
+			require.Equal(t, int64(2), tracker.Load().Hits)
+			require.NoError(t, iter.Close())
+			require.NoError(t, r.Close())
+
+			// A reader without the prefix extractor ignores the filter.
+			r, err = NewReader(newMemReader(sst), ReaderOptions{
+				Filters: map[string]FilterPolicy{bloom.FilterPolicy(10).Name(): bloom.FilterPolicy(10)},
+			})
+			require.NoError(t, err)
+			require.Nil(t, r.tableFilter)
+			iter, err = r.NewIter(nil, nil)
+			require.NoError(t, err)
+			k, _ = iter.SeekPrefixGE([]byte("t1/005"), []byte("t1/005"), 0)
+			require.NotNil(t, k)
+			require.Equal(t, "t1/005", string(k.UserKey))
+			require.NoError(t, iter.Close())
+			require.NoError(t, r.Close())
+		})
+	}
+}
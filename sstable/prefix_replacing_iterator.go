@@ -0,0 +1,230 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/invariants"
+	"github.com/cockroachdb/pebble/internal/keyspan"
+	"github.com/cockroachdb/pebble/internal/manifest"
+)
+
+// prefixReplacingIterator wraps an iterator over the keys of a virtual sstable
+// whose keys are exposed with a synthetic prefix (see
+// manifest.PrefixReplacement). The keys passed to the iterator are translated
+// into the keyspace of the backing sstable, and the keys it returns are
+// translated back.
+type prefixReplacingIterator struct {
+	i  Iterator
+	pr *manifest.PrefixReplacement
+	// arg and arg2 hold the translated keys passed to i.
+	arg, arg2 []byte
+	// res is the key returned by the last positioning method, whose user key
+	// is held in resBuf.
+	res    InternalKey
+	resBuf []byte
+}
+
+var _ Iterator = (*prefixReplacingIterator)(nil)
+
+func newPrefixReplacingIterator(i Iterator, pr *manifest.PrefixReplacement) Iterator {
+	return &prefixReplacingIterator{i: i, pr: pr}
+}
+
+func (p *prefixReplacingIterator) result(
+	k *InternalKey, v base.LazyValue,
+) (*InternalKey, base.LazyValue) {
+	if k == nil {
+		return nil, v
+	}
+	if invariants.Enabled && !bytes.HasPrefix(k.UserKey, p.pr.ContentPrefix) {
+		panic(fmt.Sprintf("pebble: key %q doesn't have the content prefix %q", k.UserKey, p.pr.ContentPrefix))
+	}
+	p.resBuf = p.pr.ReplaceResult(p.resBuf[:0], k.UserKey)
+	p.res = InternalKey{UserKey: p.resBuf, Trailer: k.Trailer}
+	return &p.res, v
+}
+
+// SeekGE implements internalIterator.SeekGE, as documented in the pebble
+// package.
+func (p *prefixReplacingIterator) SeekGE(
+	key []byte, flags base.SeekGEFlags,
+) (*InternalKey, base.LazyValue) {
+	p.arg = p.pr.ReplaceArg(p.arg[:0], key)
+	return p.result(p.i.SeekGE(p.arg, flags))
+}
+
+// SeekPrefixGE implements internalIterator.SeekPrefixGE, as documented in the
+// pebble package. A prefix which doesn't start with the synthetic prefix can't
+// be translated, in which case the iterator is positioned like by SeekGE.
+func (p *prefixReplacingIterator) SeekPrefixGE(
+	prefix, key []byte, flags base.SeekGEFlags,
+) (*InternalKey, base.LazyValue) {
+	p.arg = p.pr.ReplaceArg(p.arg[:0], key)
+	if !bytes.HasPrefix(prefix, p.pr.SyntheticPrefix) {
+		return p.result(p.i.SeekGE(p.arg, flags))
+	}
+	p.arg2 = p.pr.ReplaceArg(p.arg2[:0], prefix)
+	return p.result(p.i.SeekPrefixGE(p.arg2, p.arg, flags))
+}
+
+// SeekLT implements internalIterator.SeekLT, as documented in the pebble
+// package.
+func (p *prefixReplacingIterator) SeekLT(
+	key []byte, flags base.SeekLTFlags,
+) (*InternalKey, base.LazyValue) {
+	p.arg = p.pr.ReplaceArg(p.arg[:0], key)
+	return p.result(p.i.SeekLT(p.arg, flags))
+}
+
+// First implements internalIterator.First, as documented in the pebble
+// package.
+func (p *prefixReplacingIterator) First() (*InternalKey, base.LazyValue) {
+	return p.result(p.i.First())
+}
+
+// Last implements internalIterator.Last, as documented in the pebble package.
+func (p *prefixReplacingIterator) Last() (*InternalKey, base.LazyValue) {
+	return p.result(p.i.Last())
+}
+
+// Next implements internalIterator.Next, as documented in the pebble package.
+func (p *prefixReplacingIterator) Next() (*InternalKey, base.LazyValue) {
+	return p.result(p.i.Next())
+}
+
+// NextPrefix implements (base.InternalIterator).NextPrefix.
+func (p *prefixReplacingIterator) NextPrefix(succKey []byte) (*InternalKey, base.LazyValue) {
+	p.arg = p.pr.ReplaceArg(p.arg[:0], succKey)
+	return p.result(p.i.NextPrefix(p.arg))
+}
+
+// Prev implements internalIterator.Prev, as documented in the pebble package.
+func (p *prefixReplacingIterator) Prev() (*InternalKey, base.LazyValue) {
+	return p.result(p.i.Prev())
+}
+
+// Error implements internalIterator.Error, as documented in the pebble
+// package.
+func (p *prefixReplacingIterator) Error() error {
+	return p.i.Error()
+}
+
+// Close implements internalIterator.Close, as documented in the pebble
+// package.
+func (p *prefixReplacingIterator) Close() error {
+	return p.i.Close()
+}
+
+// SetBounds implements internalIterator.SetBounds, as documented in the pebble
+// package.
+func (p *prefixReplacingIterator) SetBounds(lower, upper []byte) {
+	// The translated bounds are allocated rather than held in buffers, as the
+	// wrapped iterator compares the new bounds with the previous ones.
+	if lower != nil {
+		lower = p.pr.ReplaceArg(nil, lower)
+	}
+	if upper != nil {
+		upper = p.pr.ReplaceArg(nil, upper)
+	}
+	p.i.SetBounds(lower, upper)
+}
+
+// MaybeFilteredKeys implements Iterator.MaybeFilteredKeys.
+func (p *prefixReplacingIterator) MaybeFilteredKeys() bool {
+	return p.i.MaybeFilteredKeys()
+}
+
+// SetCloseHook implements Iterator.SetCloseHook. The hook is passed the
+// prefixReplacingIterator rather than the iterator it wraps.
+func (p *prefixReplacingIterator) SetCloseHook(fn func(i Iterator) error) {
+	p.i.SetCloseHook(func(Iterator) error { return fn(p) })
+}
+
+func (p *prefixReplacingIterator) String() string {
+	return p.i.String()
+}
+
+// prefixReplacingFragmentIterator is the keyspan.FragmentIterator counterpart
+// of prefixReplacingIterator, which translates the bounds of the spans of a
+// virtual sstable whose keys are exposed with a synthetic prefix.
+type prefixReplacingFragmentIterator struct {
+	i   keyspan.FragmentIterator
+	pr  *manifest.PrefixReplacement
+	arg []byte
+	// span is the span returned by the last positioning method, whose bounds
+	// are held in buf.
+	span keyspan.Span
+	buf  []byte
+}
+
+var _ keyspan.FragmentIterator = (*prefixReplacingFragmentIterator)(nil)
+
+func newPrefixReplacingFragmentIterator(
+	i keyspan.FragmentIterator, pr *manifest.PrefixReplacement,
+) keyspan.FragmentIterator {
+	return &prefixReplacingFragmentIterator{i: i, pr: pr}
+}
+
+func (p *prefixReplacingFragmentIterator) result(s *keyspan.Span) *keyspan.Span {
+	if s == nil {
+		return nil
+	}
+	p.buf = p.pr.ReplaceResult(p.buf[:0], s.Start)
+	n := len(p.buf)
+	p.buf = p.pr.ReplaceResult(p.buf, s.End)
+	p.span = keyspan.Span{
+		Start:     p.buf[:n:n],
+		End:       p.buf[n:],
+		Keys:      s.Keys,
+		KeysOrder: s.KeysOrder,
+	}
+	return &p.span
+}
+
+// SeekGE implements keyspan.FragmentIterator.
+func (p *prefixReplacingFragmentIterator) SeekGE(key []byte) *keyspan.Span {
+	p.arg = p.pr.ReplaceArg(p.arg[:0], key)
+	return p.result(p.i.SeekGE(p.arg))
+}
+
+// SeekLT implements keyspan.FragmentIterator.
+func (p *prefixReplacingFragmentIterator) SeekLT(key []byte) *keyspan.Span {
+	p.arg = p.pr.ReplaceArg(p.arg[:0], key)
+	return p.result(p.i.SeekLT(p.arg))
+}
+
+// First implements keyspan.FragmentIterator.
+func (p *prefixReplacingFragmentIterator) First() *keyspan.Span {
+	return p.result(p.i.First())
+}
+
+// Last implements keyspan.FragmentIterator.
+func (p *prefixReplacingFragmentIterator) Last() *keyspan.Span {
+	return p.result(p.i.Last())
+}
+
+// Next implements keyspan.FragmentIterator.
+func (p *prefixReplacingFragmentIterator) Next() *keyspan.Span {
+	return p.result(p.i.Next())
+}
+
+// Prev implements keyspan.FragmentIterator.
+func (p *prefixReplacingFragmentIterator) Prev() *keyspan.Span {
+	return p.result(p.i.Prev())
+}
+
+// Error implements keyspan.FragmentIterator.
+func (p *prefixReplacingFragmentIterator) Error() error {
+	return p.i.Error()
+}
+
+// Close implements keyspan.FragmentIterator.
+func (p *prefixReplacingFragmentIterator) Close() error {
+	return p.i.Close()
+}
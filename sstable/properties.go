@@ -48,6 +48,9 @@ func init() {
 // automatically populated during sstable creation and load from the properties
 // meta block when an sstable is opened.
 type Properties struct {
+	// Set to true if the data blocks of this table are stored in the columnar
+	// encoding (see WriterOptions.ColumnarSchema). Only serialized if true.
+	ColumnarDataBlocks bool `prop:"pebble.columnar-data-blocks"`
 	// The name of the comparer used in this table.
 	ComparerName string `prop:"rocksdb.comparator"`
 	// The compression algorithm used to compress blocks.
@@ -103,6 +106,9 @@ type Properties struct {
 	NumValueBlocks uint64 `prop:"pebble.num.value-blocks"`
 	// The number of values stored in value blocks. Only serialized if > 0.
 	NumValuesInValueBlocks uint64 `prop:"pebble.num.values.in.value-blocks"`
+	// The number of values stored in blob files, whose blob handles are
+	// stored in this table. Only serialized if > 0.
+	NumValuesInBlobFiles uint64 `prop:"pebble.num.values.in.blob-files"`
 	// The name of the prefix extractor used in this table. Empty if no prefix
 	// extractor is used.
 	PrefixExtractorName string `prop:"rocksdb.prefix.extractor.name"`
@@ -286,6 +292,9 @@ func (p *Properties) save(tblFormat TableFormat, w *rawBlockWriter) {
 		m[k] = []byte(v)
 	}
 
+	if p.ColumnarDataBlocks {
+		p.saveBool(m, unsafe.Offsetof(p.ColumnarDataBlocks), p.ColumnarDataBlocks)
+	}
 	if p.ComparerName != "" {
 		p.saveString(m, unsafe.Offsetof(p.ComparerName), p.ComparerName)
 	}
@@ -347,6 +356,9 @@ func (p *Properties) save(tblFormat TableFormat, w *rawBlockWriter) {
 	if p.NumValuesInValueBlocks > 0 {
 		p.saveUvarint(m, unsafe.Offsetof(p.NumValuesInValueBlocks), p.NumValuesInValueBlocks)
 	}
+	if p.NumValuesInBlobFiles > 0 {
+		p.saveUvarint(m, unsafe.Offsetof(p.NumValuesInBlobFiles), p.NumValuesInBlobFiles)
+	}
 	if p.PrefixExtractorName != "" {
 		p.saveString(m, unsafe.Offsetof(p.PrefixExtractorName), p.PrefixExtractorName)
 	}
@@ -61,6 +61,7 @@ func TestPropertiesLoad(t *testing.T) {
 
 func TestPropertiesSave(t *testing.T) {
 	expected := &Properties{
+		ColumnarDataBlocks:     true,
 		ComparerName:           "comparator name",
 		CompressionName:        "compression name",
 		CompressionOptions:     "compression option",
@@ -185,7 +185,10 @@ type Reader struct {
 	rangeDelBH        BlockHandle
 	rangeKeyBH        BlockHandle
 	rangeDelTransform blockTransform
+	indexTransform    blockTransform
 	valueBIH          valueBlocksIndexHandle
+	zstdDictBH        BlockHandle
+	encryptionBH      BlockHandle
 	propertiesBH      BlockHandle
 	metaIndexBH       BlockHandle
 	footerBH          BlockHandle
@@ -194,6 +197,18 @@ type Reader struct {
 	FormatKey         base.FormatKey
 	Split             Split
 	tableFilter       *tableFilterReader
+	// filterSplit is the Split of ReaderOptions.PrefixExtractor if the table
+	// filter was built with it, and nil otherwise.
+	filterSplit Split
+	// zstdDict is the zstd dictionary of the table's blocks compressed with a
+	// dictionary, if any.
+	zstdDict []byte
+	// cipher decrypts the table's encrypted blocks, if any.
+	cipher *blockCipher
+	// topLevelIndex holds a reference to the top-level index block of a table
+	// with a two-level index, if it's pinned. See
+	// ReaderOptions.PinTopLevelIndex.
+	topLevelIndex cache.Handle
 	// Keep types that are not multiples of 8 bytes at the end and with
 	// decreasing size.
 	Properties    Properties
@@ -201,6 +216,10 @@ type Reader struct {
 	rawTombstones bool
 	mergerOK      bool
 	checksumType  ChecksumType
+	// rocksDB holds the properties RocksDB writes to describe the encoding of
+	// the table's index and filter blocks, if the table was written by
+	// RocksDB.
+	rocksDB rocksDBProperties
 	// metaBufferPool is a buffer pool used exclusively when opening a table and
 	// loading its meta blocks. metaBufferPoolAlloc is used to batch-allocate
 	// the BufferPool.pool slice as a part of the Reader allocation. It's
@@ -213,6 +232,8 @@ type Reader struct {
 
 // Close implements DB.Close, as documented in the pebble package.
 func (r *Reader) Close() error {
+	r.topLevelIndex.Release()
+	r.topLevelIndex = cache.Handle{}
 	r.opts.Cache.Unref()
 
 	if r.readable != nil {
@@ -240,7 +261,7 @@ func (r *Reader) NewIterWithBlockPropertyFilters(
 ) (Iterator, error) {
 	return r.newIterWithBlockPropertyFiltersAndContext(
 		context.Background(),
-		lower, upper, filterer, false, useFilterBlock, stats, rp, nil,
+		lower, upper, filterer, false, useFilterBlock, stats, nil /* cacheStats */, rp, nil, nil, /* bufferPool */
 	)
 }
 
@@ -251,16 +272,25 @@ func (r *Reader) NewIterWithBlockPropertyFilters(
 // If hideObsoletePoints, the callee assumes that filterer already includes
 // obsoleteKeyBlockPropertyFilter. The caller can satisfy this contract by
 // first calling TryAddBlockPropertyFilterForHideObsoletePoints.
+//
+// If cacheStats is non-nil, the iterator's block cache lookups are recorded in
+// it rather than in ReaderOptions.CacheStats.
+//
+// If bufferPool is non-nil, blocks that aren't present in the block cache are
+// read into buffers allocated from the pool, and aren't added to the block
+// cache. The pool must outlive the iterator.
 func (r *Reader) NewIterWithBlockPropertyFiltersAndContextEtc(
 	ctx context.Context,
 	lower, upper []byte,
 	filterer *BlockPropertiesFilterer,
 	hideObsoletePoints, useFilterBlock bool,
 	stats *base.InternalIteratorStats,
+	cacheStats *CacheStats,
 	rp ReaderProvider,
+	bufferPool *BufferPool,
 ) (Iterator, error) {
 	return r.newIterWithBlockPropertyFiltersAndContext(
-		ctx, lower, upper, filterer, hideObsoletePoints, useFilterBlock, stats, rp, nil,
+		ctx, lower, upper, filterer, hideObsoletePoints, useFilterBlock, stats, cacheStats, rp, nil, bufferPool,
 	)
 }
 
@@ -287,15 +317,17 @@ func (r *Reader) newIterWithBlockPropertyFiltersAndContext(
 	hideObsoletePoints bool,
 	useFilterBlock bool,
 	stats *base.InternalIteratorStats,
+	cacheStats *CacheStats,
 	rp ReaderProvider,
 	v *virtualState,
+	bufferPool *BufferPool,
 ) (Iterator, error) {
 	// NB: pebble.tableCache wraps the returned iterator with one which performs
 	// reference counting on the Reader, preventing the Reader from being closed
 	// until the final iterator closes.
 	if r.Properties.IndexType == twoLevelIndex {
 		i := twoLevelIterPool.Get().(*twoLevelIterator)
-		err := i.init(ctx, r, v, lower, upper, filterer, useFilterBlock, hideObsoletePoints, stats, rp, nil /* bufferPool */)
+		err := i.init(ctx, r, v, lower, upper, filterer, useFilterBlock, hideObsoletePoints, stats, cacheStats, rp, bufferPool)
 		if err != nil {
 			return nil, err
 		}
@@ -303,7 +335,7 @@ func (r *Reader) newIterWithBlockPropertyFiltersAndContext(
 	}
 
 	i := singleLevelIterPool.Get().(*singleLevelIterator)
-	err := i.init(ctx, r, v, lower, upper, filterer, useFilterBlock, hideObsoletePoints, stats, rp, nil /* bufferPool */)
+	err := i.init(ctx, r, v, lower, upper, filterer, useFilterBlock, hideObsoletePoints, stats, cacheStats, rp, bufferPool)
 	if err != nil {
 		return nil, err
 	}
@@ -338,7 +370,7 @@ func (r *Reader) newCompactionIter(
 			context.Background(),
 			r, v, nil /* lower */, nil /* upper */, nil,
 			false /* useFilter */, false, /* hideObsoletePoints */
-			nil /* stats */, rp, bufferPool,
+			nil /* stats */, nil /* cacheStats */, rp, bufferPool,
 		)
 		if err != nil {
 			return nil, err
@@ -353,7 +385,7 @@ func (r *Reader) newCompactionIter(
 	err := i.init(
 		context.Background(), r, v, nil /* lower */, nil, /* upper */
 		nil, false /* useFilter */, false, /* hideObsoletePoints */
-		nil /* stats */, rp, bufferPool,
+		nil /* stats */, nil /* cacheStats */, rp, bufferPool,
 	)
 	if err != nil {
 		return nil, err
@@ -419,27 +451,30 @@ func (i *rangeKeyFragmentBlockIter) Close() error {
 }
 
 func (r *Reader) readIndex(
-	ctx context.Context, stats *base.InternalIteratorStats,
+	ctx context.Context, stats *base.InternalIteratorStats, cacheStats *CacheStats,
 ) (bufferHandle, error) {
+	if r.topLevelIndex.Get() != nil {
+		return bufferHandle{h: r.topLevelIndex.Acquire()}, nil
+	}
 	ctx = objiotracing.WithBlockType(ctx, objiotracing.MetadataBlock)
-	return r.readBlock(ctx, r.indexBH, nil, nil, stats, nil /* buffer pool */)
+	return r.readBlock(ctx, r.indexBH, BlockKindIndex, r.indexTransform, nil /* readHandle */, stats, cacheStats, nil /* buffer pool */)
 }
 
 func (r *Reader) readFilter(
-	ctx context.Context, stats *base.InternalIteratorStats,
+	ctx context.Context, stats *base.InternalIteratorStats, cacheStats *CacheStats,
 ) (bufferHandle, error) {
 	ctx = objiotracing.WithBlockType(ctx, objiotracing.FilterBlock)
-	return r.readBlock(ctx, r.filterBH, nil /* transform */, nil /* readHandle */, stats, nil /* buffer pool */)
+	return r.readBlock(ctx, r.filterBH, BlockKindFilter, nil /* transform */, nil /* readHandle */, stats, cacheStats, nil /* buffer pool */)
 }
 
 func (r *Reader) readRangeDel(stats *base.InternalIteratorStats) (bufferHandle, error) {
 	ctx := objiotracing.WithBlockType(context.Background(), objiotracing.MetadataBlock)
-	return r.readBlock(ctx, r.rangeDelBH, r.rangeDelTransform, nil /* readHandle */, stats, nil /* buffer pool */)
+	return r.readBlock(ctx, r.rangeDelBH, BlockKindOther, r.rangeDelTransform, nil /* readHandle */, stats, nil /* cacheStats */, nil /* buffer pool */)
 }
 
 func (r *Reader) readRangeKey(stats *base.InternalIteratorStats) (bufferHandle, error) {
 	ctx := objiotracing.WithBlockType(context.Background(), objiotracing.MetadataBlock)
-	return r.readBlock(ctx, r.rangeKeyBH, nil /* transform */, nil /* readHandle */, stats, nil /* buffer pool */)
+	return r.readBlock(ctx, r.rangeKeyBH, BlockKindOther, nil /* transform */, nil /* readHandle */, stats, nil /* cacheStats */, nil /* buffer pool */)
 }
 
 func checkChecksum(
@@ -452,6 +487,8 @@ func checkChecksum(
 		computedChecksum = crc.New(b[:bh.Length+1]).Value()
 	case ChecksumTypeXXHash64:
 		computedChecksum = uint32(xxhash.Sum64(b[:bh.Length+1]))
+	case ChecksumTypeXXH3:
+		computedChecksum = xxh3Checksum(b[:bh.Length], b[bh.Length])
 	default:
 		return errors.Errorf("unsupported checksum type: %d", checksumType)
 	}
@@ -494,16 +531,27 @@ func (b cacheValueOrBuf) truncate(n int) {
 	}
 }
 
+// readBlock reads the block from the block cache, or from the file if it isn't
+// cached. The lookup is recorded in cacheStats under the given kind, or in
+// ReaderOptions.CacheStats if cacheStats is nil.
 func (r *Reader) readBlock(
 	ctx context.Context,
 	bh BlockHandle,
+	kind BlockKind,
 	transform blockTransform,
 	readHandle objstorage.ReadHandle,
 	stats *base.InternalIteratorStats,
+	cacheStats *CacheStats,
 	bufferPool *BufferPool,
 ) (handle bufferHandle, _ error) {
+	if cacheStats == nil {
+		cacheStats = r.opts.CacheStats
+	}
 	if h := r.opts.Cache.Get(r.cacheID, r.fileNum, bh.Offset); h.Get() != nil {
 		// Cache hit.
+		if cacheStats != nil {
+			cacheStats.record(kind, true /* hit */, bh.Length)
+		}
 		if readHandle != nil {
 			readHandle.RecordCacheHit(ctx, int64(bh.Offset), int64(bh.Length+blockTrailerLen))
 		}
@@ -517,6 +565,9 @@ func (r *Reader) readBlock(
 	}
 
 	// Cache miss.
+	if cacheStats != nil {
+		cacheStats.record(kind, false /* hit */, bh.Length)
+	}
 	var compressed cacheValueOrBuf
 	if bufferPool != nil {
 		compressed = cacheValueOrBuf{
@@ -555,14 +606,100 @@ func (r *Reader) readBlock(
 		compressed.release()
 		return bufferHandle{}, err
 	}
+	return r.decodeBlock(bh, kind, transform, compressed, stats, bufferPool)
+}
+
+// prefetchBlocks adds the blocks that aren't in the block cache to it, reading
+// them from the file with a single batch of reads (see objstorage.ReadBatch).
+func (r *Reader) prefetchBlocks(
+	ctx context.Context,
+	bhs []BlockHandle,
+	kind BlockKind,
+	readHandle objstorage.ReadHandle,
+	stats *base.InternalIteratorStats,
+) error {
+	missing := make([]BlockHandle, 0, len(bhs))
+	values := make([]*cache.Value, 0, len(bhs))
+	reqs := make([]objstorage.ReadRequest, 0, len(bhs))
+	for _, bh := range bhs {
+		if h := r.opts.Cache.Get(r.cacheID, r.fileNum, bh.Offset); h.Get() != nil {
+			h.Release()
+			continue
+		}
+		v := cache.Alloc(int(bh.Length + blockTrailerLen))
+		missing = append(missing, bh)
+		values = append(values, v)
+		reqs = append(reqs, objstorage.ReadRequest{P: v.Buf(), Offset: int64(bh.Offset)})
+	}
+	if len(reqs) == 0 {
+		return nil
+	}
+	readStartTime := time.Now()
+	err := objstorage.ReadBatch(ctx, readHandle, reqs)
+	if stats != nil {
+		stats.BlockReadDuration += time.Since(readStartTime)
+	}
+	for i, bh := range missing {
+		compressed := cacheValueOrBuf{v: values[i]}
+		if err != nil {
+			compressed.release()
+			continue
+		}
+		// The blocks are accounted for in the stats when they're read from the
+		// cache.
+		var h bufferHandle
+		if h, err = r.decodeBlock(bh, kind, nil /* transform */, compressed, nil /* stats */, nil /* bufferPool */); err == nil {
+			h.Release()
+		}
+	}
+	return err
+}
+
+// decodeBlock verifies the checksum of the block read from the file into
+// compressed, and decrypts, decompresses and transforms it. Unless bufferPool
+// is set, the block is added to the block cache. decodeBlock takes ownership
+// of compressed.
+func (r *Reader) decodeBlock(
+	bh BlockHandle,
+	kind BlockKind,
+	transform blockTransform,
+	compressed cacheValueOrBuf,
+	stats *base.InternalIteratorStats,
+	bufferPool *BufferPool,
+) (bufferHandle, error) {
 	if err := checkChecksum(r.checksumType, compressed.get(), bh, r.fileNum.FileNum()); err != nil {
 		compressed.release()
 		return bufferHandle{}, err
 	}
 
 	typ := blockType(compressed.get()[bh.Length])
+	encrypted := typ&encryptedBlockTypeFlag != 0
+	columnar := typ&columnarBlockTypeFlag != 0
+	typ &^= encryptedBlockTypeFlag | columnarBlockTypeFlag
 	compressed.truncate(int(bh.Length))
 
+	if encrypted {
+		if r.cipher == nil || int(bh.Length) < r.cipher.overhead() {
+			compressed.release()
+			return bufferHandle{}, base.CorruptionErrorf(
+				"pebble/table: unexpected encrypted block in %s", r.fileNum)
+		}
+		n := int(bh.Length) - r.cipher.overhead()
+		var decrypted cacheValueOrBuf
+		if bufferPool != nil {
+			decrypted = cacheValueOrBuf{buf: bufferPool.Alloc(n)}
+		} else {
+			decrypted = cacheValueOrBuf{v: cache.Alloc(n)}
+		}
+		_, err := r.cipher.open(decrypted.get()[:0], compressed.get())
+		compressed.release()
+		if err != nil {
+			decrypted.release()
+			return bufferHandle{}, err
+		}
+		compressed = decrypted
+	}
+
 	var decompressed cacheValueOrBuf
 	if typ == noCompressionBlockType {
 		decompressed = compressed
@@ -579,13 +716,36 @@ func (r *Reader) readBlock(
 		} else {
 			decompressed = cacheValueOrBuf{v: cache.Alloc(decodedLen)}
 		}
-		if _, err := decompressInto(typ, compressed.get()[prefixLen:], decompressed.get()); err != nil {
+		if _, err := decompressInto(typ, compressed.get()[prefixLen:], decompressed.get(), r.zstdDict); err != nil {
 			compressed.release()
 			return bufferHandle{}, err
 		}
 		compressed.release()
 	}
 
+	if columnar {
+		// Convert columnar data blocks to the row-oriented layout, in which
+		// they are cached and iterated over.
+		n, err := columnarBlockLen(decompressed.get())
+		if err != nil {
+			decompressed.release()
+			return bufferHandle{}, err
+		}
+		var rows cacheValueOrBuf
+		if bufferPool != nil {
+			rows = cacheValueOrBuf{buf: bufferPool.Alloc(n)}
+		} else {
+			rows = cacheValueOrBuf{v: cache.Alloc(n)}
+		}
+		err = decodeColumnarBlock(rows.get(), decompressed.get())
+		decompressed.release()
+		if err != nil {
+			rows.release()
+			return bufferHandle{}, err
+		}
+		decompressed = rows
+	}
+
 	if transform != nil {
 		// Transforming blocks is very rare, so the extra copy of the
 		// transformed data is not problematic.
@@ -612,7 +772,19 @@ func (r *Reader) readBlock(
 	if decompressed.buf.Valid() {
 		return bufferHandle{b: decompressed.buf}, nil
 	}
-	h := r.opts.Cache.Set(r.cacheID, r.fileNum, bh.Offset, decompressed.v)
+	// Index and filter blocks are needed by every read of the table, so they're
+	// added to the high-priority region of the cache, if it has one.
+	pri := cache.LowPriority
+	if kind == BlockKindIndex || kind == BlockKindFilter {
+		pri = cache.HighPriority
+	}
+	if r.cipher != nil {
+		// The plaintext of an encrypted table's blocks must not be written to
+		// disk by the cache's secondary tier. The blocks read before the
+		// cipher is initialized aren't encrypted in the table either.
+		decompressed.v.SetNoSecondary()
+	}
+	h := r.opts.Cache.SetWithPriority(r.cacheID, r.fileNum, bh.Offset, decompressed.v, pri)
 	return bufferHandle{h: h}, nil
 }
 
@@ -674,7 +846,8 @@ func (r *Reader) readMetaindex(metaindexBH BlockHandle) error {
 	defer r.metaBufferPool.Release()
 
 	b, err := r.readBlock(
-		context.Background(), metaindexBH, nil /* transform */, nil /* readHandle */, nil /* stats */, &r.metaBufferPool)
+		context.Background(), metaindexBH, BlockKindOther, nil /* transform */, nil, /* readHandle */
+		nil /* stats */, nil /* cacheStats */, &r.metaBufferPool)
 	if err != nil {
 		return err
 	}
@@ -715,14 +888,33 @@ func (r *Reader) readMetaindex(metaindexBH BlockHandle) error {
 		return err
 	}
 
+	if bh, ok := meta[metaEncryptionName]; ok {
+		r.encryptionBH = bh
+		b, err = r.readBlock(
+			context.Background(), bh, BlockKindOther, nil /* transform */, nil, /* readHandle */
+			nil /* stats */, nil /* cacheStats */, nil /* buffer pool */)
+		if err != nil {
+			return err
+		}
+		r.cipher, err = newReaderBlockCipher(r.opts.KeyManager, b.Get())
+		b.Release()
+		if err != nil {
+			return err
+		}
+	}
+
 	if bh, ok := meta[metaPropertiesName]; ok {
 		b, err = r.readBlock(
-			context.Background(), bh, nil /* transform */, nil /* readHandle */, nil /* stats */, nil /* buffer pool */)
+			context.Background(), bh, BlockKindOther, nil /* transform */, nil, /* readHandle */
+			nil /* stats */, nil /* cacheStats */, nil /* buffer pool */)
 		if err != nil {
 			return err
 		}
 		r.propertiesBH = bh
 		err := r.Properties.load(b.Get(), bh.Offset, r.opts.DeniedUserProperties)
+		if err == nil && r.tableFormat == TableFormatRocksDBv2 {
+			err = r.rocksDB.load(b.Get())
+		}
 		b.Release()
 		if err != nil {
 			return err
@@ -742,6 +934,18 @@ func (r *Reader) readMetaindex(metaindexBH BlockHandle) error {
 		r.rangeKeyBH = bh
 	}
 
+	if bh, ok := meta[metaZstdDictName]; ok {
+		r.zstdDictBH = bh
+		b, err = r.readBlock(
+			context.Background(), bh, BlockKindOther, nil /* transform */, nil, /* readHandle */
+			nil /* stats */, nil /* cacheStats */, nil /* buffer pool */)
+		if err != nil {
+			return err
+		}
+		r.zstdDict = append([]byte(nil), b.Get()...)
+		b.Release()
+	}
+
 	for name, fp := range r.opts.Filters {
 		types := []struct {
 			ftype  FilterType
@@ -769,9 +973,29 @@ func (r *Reader) readMetaindex(metaindexBH BlockHandle) error {
 			break
 		}
 	}
+
+	if r.tableFilter != nil && r.Properties.PrefixFiltering &&
+		r.Properties.PrefixExtractorName != r.Properties.ComparerName {
+		// The filter was built over the prefixes of a prefix extractor, which
+		// can only be looked up with the same extractor.
+		if pe := r.opts.PrefixExtractor; pe != nil && pe.Name == r.Properties.PrefixExtractorName {
+			r.filterSplit = pe.Split
+		} else {
+			r.tableFilter = nil
+		}
+	}
 	return nil
 }
 
+// filterPrefix returns the prefix looked up in the table filter for a
+// SeekPrefixGE with the given prefix.
+func (r *Reader) filterPrefix(prefix []byte) []byte {
+	if r.filterSplit != nil {
+		return prefix[:r.filterSplit(prefix)]
+	}
+	return prefix
+}
+
 // Layout returns the layout (block organization) for an sstable.
 func (r *Reader) Layout() (*Layout, error) {
 	if r.err != nil {
@@ -784,13 +1008,15 @@ func (r *Reader) Layout() (*Layout, error) {
 		RangeDel:   r.rangeDelBH,
 		RangeKey:   r.rangeKeyBH,
 		ValueIndex: r.valueBIH.h,
+		ZstdDict:   r.zstdDictBH,
+		Encryption: r.encryptionBH,
 		Properties: r.propertiesBH,
 		MetaIndex:  r.metaIndexBH,
 		Footer:     r.footerBH,
 		Format:     r.tableFormat,
 	}
 
-	indexH, err := r.readIndex(context.Background(), nil)
+	indexH, err := r.readIndex(context.Background(), nil /* stats */, nil /* cacheStats */)
 	if err != nil {
 		return nil, err
 	}
@@ -822,8 +1048,8 @@ func (r *Reader) Layout() (*Layout, error) {
 			}
 			l.Index = append(l.Index, indexBH.BlockHandle)
 
-			subIndex, err := r.readBlock(context.Background(), indexBH.BlockHandle,
-				nil /* transform */, nil /* readHandle */, nil /* stats */, nil /* buffer pool */)
+			subIndex, err := r.readBlock(context.Background(), indexBH.BlockHandle, BlockKindIndex,
+				r.indexTransform, nil /* readHandle */, nil /* stats */, nil /* cacheStats */, nil /* buffer pool */)
 			if err != nil {
 				return nil, err
 			}
@@ -846,7 +1072,7 @@ func (r *Reader) Layout() (*Layout, error) {
 		}
 	}
 	if r.valueBIH.h.Length != 0 {
-		vbiH, err := r.readBlock(context.Background(), r.valueBIH.h, nil, nil, nil, nil /* buffer pool */)
+		vbiH, err := r.readBlock(context.Background(), r.valueBIH.h, BlockKindValue, nil, nil, nil, nil, nil /* buffer pool */)
 		if err != nil {
 			return nil, err
 		}
@@ -882,6 +1108,77 @@ func (r *Reader) Layout() (*Layout, error) {
 	return l, nil
 }
 
+// CacheResidency describes how much of the index and filter blocks of one or
+// more tables are resident in the block cache, measured by the uncompressed
+// sizes of the blocks.
+type CacheResidency struct {
+	// IndexBytes is the size of the index blocks, including both the top-level
+	// index blocks and the index partitions of two-level indexes, as recorded
+	// by the tables' IndexSize property.
+	IndexBytes uint64
+	// IndexBytesCached is the size of the index blocks resident in the block
+	// cache or pinned by their readers.
+	IndexBytesCached uint64
+	// FilterBytes is the size of the filter blocks.
+	FilterBytes uint64
+	// FilterBytesCached is the size of the filter blocks resident in the block
+	// cache.
+	FilterBytesCached uint64
+}
+
+// Add adds the residency of other tables to c.
+func (c *CacheResidency) Add(other CacheResidency) {
+	c.IndexBytes += other.IndexBytes
+	c.IndexBytesCached += other.IndexBytesCached
+	c.FilterBytes += other.FilterBytes
+	c.FilterBytesCached += other.FilterBytesCached
+}
+
+// CacheResidency returns the residency of the table's index and filter blocks
+// in the block cache. It inspects the block cache without reading any blocks
+// or affecting the cache's metrics and evictions. The index partitions of a
+// two-level index are found through its top-level index block, so they're
+// only counted as resident when it's resident too.
+func (r *Reader) CacheResidency() CacheResidency {
+	var res CacheResidency
+	if r.err != nil {
+		return res
+	}
+	cached := func(bh BlockHandle) uint64 {
+		h := r.opts.Cache.Peek(r.cacheID, r.fileNum, bh.Offset)
+		defer h.Release()
+		return uint64(len(h.Get()))
+	}
+	res.FilterBytes = r.filterBH.Length
+	if res.FilterBytes > 0 {
+		res.FilterBytesCached = cached(r.filterBH)
+	}
+
+	res.IndexBytes = r.Properties.IndexSize
+	if r.Properties.IndexPartitions == 0 {
+		res.IndexBytesCached = cached(r.indexBH)
+		return res
+	}
+	h := r.topLevelIndex.Acquire()
+	if h.Get() == nil {
+		h = r.opts.Cache.Peek(r.cacheID, r.fileNum, r.indexBH.Offset)
+	}
+	defer h.Release()
+	if h.Get() == nil {
+		return res
+	}
+	res.IndexBytesCached = uint64(len(h.Get()))
+	iter, _ := newBlockIter(r.Compare, h.Get())
+	for key, value := iter.First(); key != nil; key, value = iter.Next() {
+		bh, err := decodeBlockHandleWithProperties(value.InPlaceValue())
+		if err != nil {
+			break
+		}
+		res.IndexBytesCached += cached(bh.BlockHandle)
+	}
+	return res
+}
+
 // ValidateBlockChecksums validates the checksums for each block in the SSTable.
 func (r *Reader) ValidateBlockChecksums() error {
 	// Pre-compute the BlockHandles for the underlying file.
@@ -897,7 +1194,18 @@ func (r *Reader) ValidateBlockChecksums() error {
 		blocks[i] = l.Data[i].BlockHandle
 	}
 	blocks = append(blocks, l.Index...)
-	blocks = append(blocks, l.TopIndex, l.Filter, l.RangeDel, l.RangeKey, l.Properties, l.MetaIndex)
+	blocks = append(blocks, l.TopIndex, l.Filter, l.RangeDel, l.RangeKey, l.ZstdDict, l.Encryption,
+		l.Properties, l.MetaIndex)
+
+	// Index blocks must be read with the reader's index transform, if any, so
+	// that the block cache is never populated with untransformed index blocks.
+	indexBlocks := make(map[uint64]struct{}, len(l.Index)+1)
+	for _, bh := range l.Index {
+		indexBlocks[bh.Offset] = struct{}{}
+	}
+	if l.TopIndex.Length != 0 {
+		indexBlocks[l.TopIndex.Offset] = struct{}{}
+	}
 
 	// Sorting by offset ensures we are performing a sequential scan of the
 	// file.
@@ -917,7 +1225,13 @@ func (r *Reader) ValidateBlockChecksums() error {
 		}
 
 		// Read the block, which validates the checksum.
-		h, err := r.readBlock(context.Background(), bh, nil, rh, nil, nil /* buffer pool */)
+		var transform blockTransform
+		kind := BlockKindOther
+		if _, ok := indexBlocks[bh.Offset]; ok {
+			transform = r.indexTransform
+			kind = BlockKindIndex
+		}
+		h, err := r.readBlock(context.Background(), bh, kind, transform, rh, nil, nil, nil /* buffer pool */)
 		if err != nil {
 			return err
 		}
@@ -947,7 +1261,7 @@ func (r *Reader) EstimateDiskUsage(start, end []byte) (uint64, error) {
 		return 0, r.err
 	}
 
-	indexH, err := r.readIndex(context.Background(), nil)
+	indexH, err := r.readIndex(context.Background(), nil /* stats */, nil /* cacheStats */)
 	if err != nil {
 		return 0, err
 	}
@@ -979,8 +1293,8 @@ func (r *Reader) EstimateDiskUsage(start, end []byte) (uint64, error) {
 		if err != nil {
 			return 0, errCorruptIndexEntry
 		}
-		startIdxBlock, err := r.readBlock(context.Background(), startIdxBH.BlockHandle,
-			nil /* transform */, nil /* readHandle */, nil /* stats */, nil /* buffer pool */)
+		startIdxBlock, err := r.readBlock(context.Background(), startIdxBH.BlockHandle, BlockKindIndex,
+			r.indexTransform, nil /* readHandle */, nil /* stats */, nil /* cacheStats */, nil /* buffer pool */)
 		if err != nil {
 			return 0, err
 		}
@@ -1000,8 +1314,8 @@ func (r *Reader) EstimateDiskUsage(start, end []byte) (uint64, error) {
 			if err != nil {
 				return 0, errCorruptIndexEntry
 			}
-			endIdxBlock, err := r.readBlock(context.Background(),
-				endIdxBH.BlockHandle, nil /* transform */, nil /* readHandle */, nil /* stats */, nil /* buffer pool */)
+			endIdxBlock, err := r.readBlock(context.Background(), endIdxBH.BlockHandle, BlockKindIndex,
+				r.indexTransform, nil /* readHandle */, nil /* stats */, nil /* cacheStats */, nil /* buffer pool */)
 			if err != nil {
 				return 0, err
 			}
@@ -1067,6 +1381,22 @@ func (r *Reader) TableFormat() (TableFormat, error) {
 	return r.tableFormat, nil
 }
 
+// ChecksumType returns the checksum type of the table's blocks.
+func (r *Reader) ChecksumType() ChecksumType {
+	return r.checksumType
+}
+
+// HasZstdDictionary returns true if the table's blocks may be compressed with
+// a zstd dictionary stored in the table.
+func (r *Reader) HasZstdDictionary() bool {
+	return r.zstdDictBH.Length > 0
+}
+
+// IsEncrypted returns true if the table's blocks are encrypted.
+func (r *Reader) IsEncrypted() bool {
+	return r.encryptionBH.Length > 0
+}
+
 // NewReader returns a new table reader for the file. Closing the reader will
 // close the file.
 func NewReader(f objstorage.Readable, o ReaderOptions, extraOpts ...ReaderOption) (*Reader, error) {
@@ -1111,6 +1441,10 @@ func NewReader(f objstorage.Readable, o ReaderOptions, extraOpts ...ReaderOption
 		r.err = err
 		return nil, r.Close()
 	}
+	if err := r.initRocksDBCompat(footer.version); err != nil {
+		r.err = err
+		return nil, r.Close()
+	}
 	r.indexBH = footer.indexBH
 	r.metaIndexBH = footer.metaindexBH
 	r.footerBH = footer.footerBH
@@ -1147,6 +1481,17 @@ func NewReader(f objstorage.Readable, o ReaderOptions, extraOpts ...ReaderOption
 		return nil, r.Close()
 	}
 
+	if o.PinTopLevelIndex && r.Properties.IndexPartitions > 0 {
+		h, err := r.readIndex(context.Background(), nil /* stats */, nil /* cacheStats */)
+		if err != nil {
+			r.err = err
+			return nil, r.Close()
+		}
+		// The index is read through the block cache, so the handle refers to
+		// a cache value.
+		r.topLevelIndex = h.h
+	}
+
 	return r, nil
 }
 
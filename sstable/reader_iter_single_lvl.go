@@ -5,6 +5,7 @@
 package sstable
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"unsafe"
@@ -44,7 +45,18 @@ type singleLevelIterator struct {
 	// dataBH refers to the last data block that the iterator considered
 	// loading. It may not actually have loaded the block, due to an error or
 	// because it was considered irrelevant.
-	dataBH   BlockHandle
+	dataBH BlockHandle
+	// prefetch is the state of the prefetching of data blocks during forward
+	// iteration (see maybePrefetch).
+	prefetch struct {
+		// next is the offset following the last data block loaded, and
+		// sequential the number of consecutive data blocks loaded in order.
+		next       uint64
+		sequential int
+		// end is the offset following the last data block prefetched.
+		end     uint64
+		handles [dataBlockPrefetchCount]BlockHandle
+	}
 	vbReader *valueBlockReader
 	// vbRH is the read handle for value blocks, which are in a different
 	// part of the sstable than data blocks.
@@ -53,7 +65,10 @@ type singleLevelIterator struct {
 	err          error
 	closeHook    func(i Iterator) error
 	stats        *base.InternalIteratorStats
-	bufferPool   *BufferPool
+	// cacheStats, if non-nil, accumulates the iterator's block cache lookups
+	// in place of ReaderOptions.CacheStats.
+	cacheStats *CacheStats
+	bufferPool *BufferPool
 
 	// boundsCmp and positionedUsingLatestBounds are for optimizing iteration
 	// that uses multiple adjacent bounds. The seek after setting a new bound
@@ -174,13 +189,14 @@ func (i *singleLevelIterator) init(
 	filterer *BlockPropertiesFilterer,
 	useFilter, hideObsoletePoints bool,
 	stats *base.InternalIteratorStats,
+	cacheStats *CacheStats,
 	rp ReaderProvider,
 	bufferPool *BufferPool,
 ) error {
 	if r.err != nil {
 		return r.err
 	}
-	indexH, err := r.readIndex(ctx, stats)
+	indexH, err := r.readIndex(ctx, stats, cacheStats)
 	if err != nil {
 		return err
 	}
@@ -197,6 +213,7 @@ func (i *singleLevelIterator) init(
 	i.reader = r
 	i.cmp = r.Compare
 	i.stats = stats
+	i.cacheStats = cacheStats
 	i.hideObsoletePoints = hideObsoletePoints
 	i.bufferPool = bufferPool
 	err = i.index.initHandle(i.cmp, indexH, r.Properties.GlobalSeqNum, false)
@@ -207,7 +224,7 @@ func (i *singleLevelIterator) init(
 	}
 	i.dataRH = objstorageprovider.UsePreallocatedReadHandle(ctx, r.readable, &i.dataRHPrealloc)
 	if r.tableFormat >= TableFormatPebblev3 {
-		if r.Properties.NumValueBlocks > 0 {
+		if r.Properties.NumValueBlocks > 0 || r.Properties.NumValuesInBlobFiles > 0 {
 			// NB: we cannot avoid this ~248 byte allocation, since valueBlockReader
 			// can outlive the singleLevelIterator due to be being embedded in a
 			// LazyValue. This consumes ~2% in microbenchmark CPU profiles, but we
@@ -218,11 +235,12 @@ func (i *singleLevelIterator) init(
 			// separated to their callers, they can put this valueBlockReader into a
 			// sync.Pool.
 			i.vbReader = &valueBlockReader{
-				ctx:    ctx,
-				bpOpen: i,
-				rp:     rp,
-				vbih:   r.valueBIH,
-				stats:  stats,
+				ctx:         ctx,
+				bpOpen:      i,
+				rp:          rp,
+				vbih:        r.valueBIH,
+				stats:       stats,
+				blobFetcher: r.opts.BlobValueFetcher,
 			}
 			i.data.lazyValueHandling.vbr = i.vbReader
 			i.vbRH = objstorageprovider.UsePreallocatedReadHandle(ctx, r.readable, &i.vbRHPrealloc)
@@ -395,7 +413,12 @@ func (i *singleLevelIterator) loadBlock(dir int8) loadBlockResult {
 		// blockIntersects
 	}
 	ctx := objiotracing.WithBlockType(i.ctx, objiotracing.DataBlock)
-	block, err := i.reader.readBlock(ctx, i.dataBH, nil /* transform */, i.dataRH, i.stats, i.bufferPool)
+	if dir > 0 {
+		i.maybePrefetch(ctx)
+	} else {
+		i.prefetch.sequential = 0
+	}
+	block, err := i.reader.readBlock(ctx, i.dataBH, BlockKindData, nil /* transform */, i.dataRH, i.stats, i.cacheStats, i.bufferPool)
 	if err != nil {
 		i.err = err
 		return loadBlockFailed
@@ -410,13 +433,72 @@ func (i *singleLevelIterator) loadBlock(dir int8) loadBlockResult {
 	return loadBlockOK
 }
 
+// dataBlockPrefetchCount is the number of data blocks prefetched together, and
+// dataBlockPrefetchThreshold the number of consecutive data blocks a forward
+// iteration loads before it starts prefetching.
+const (
+	dataBlockPrefetchCount     = 8
+	dataBlockPrefetchThreshold = 2
+)
+
+// maybePrefetch is called by a forward iteration before it loads the data
+// block i.dataBH. If the iteration loads the data blocks in order, and the
+// reads of the sstable can be batched (see objstorage.BatchesReads), the
+// block and the following ones are prefetched into the block cache with a
+// single batch of reads, so that the iteration doesn't wait for the reads of
+// the following blocks one at a time.
+//
+// Iterators that read into a BufferPool (compactions) rely on the readahead of
+// the read handle instead, as do iterators with block property filters, which
+// may skip blocks.
+func (i *singleLevelIterator) maybePrefetch(ctx context.Context) {
+	p := &i.prefetch
+	bh := i.dataBH
+	if bh.Offset == p.next {
+		p.sequential++
+	} else {
+		p.sequential = 0
+	}
+	p.next = bh.Offset + bh.Length + blockTrailerLen
+	if p.sequential < dataBlockPrefetchThreshold || bh.Offset < p.end ||
+		i.bufferPool != nil || i.bpfs != nil || !objstorage.BatchesReads(i.dataRH) {
+		return
+	}
+
+	// Collect the handles of the following blocks from the index, without
+	// moving the index iterator, up to the first block that extends past the
+	// upper bound.
+	handles := append(i.prefetch.handles[:0], bh)
+	var peek blockIter
+	if err := peek.init(i.cmp, i.index.data, i.reader.Properties.GlobalSeqNum, false); err != nil {
+		return
+	}
+	for key, v := peek.SeekGE(i.index.Key().UserKey, base.SeekGEFlagsNone); key != nil && len(handles) < cap(handles); key, v = peek.Next() {
+		h, err := decodeBlockHandleWithProperties(v.InPlaceValue())
+		if err != nil {
+			return
+		}
+		if h.Offset <= bh.Offset {
+			continue
+		}
+		handles = append(handles, h.BlockHandle)
+		if i.upper != nil && i.cmp(key.UserKey, i.upper) >= 0 {
+			break
+		}
+	}
+	last := handles[len(handles)-1]
+	p.end = last.Offset + last.Length + blockTrailerLen
+	// An error is returned again by the read of the block that failed.
+	_ = i.reader.prefetchBlocks(ctx, handles, BlockKindData, i.dataRH, i.stats)
+}
+
 // readBlockForVBR implements the blockProviderWhenOpen interface for use by
 // the valueBlockReader.
 func (i *singleLevelIterator) readBlockForVBR(
 	ctx context.Context, h BlockHandle, stats *base.InternalIteratorStats,
 ) (bufferHandle, error) {
 	ctx = objiotracing.WithBlockType(ctx, objiotracing.ValueBlock)
-	return i.reader.readBlock(ctx, h, nil, i.vbRH, stats, i.bufferPool)
+	return i.reader.readBlock(ctx, h, BlockKindValue, nil, i.vbRH, stats, i.cacheStats, i.bufferPool)
 }
 
 // resolveMaybeExcluded is invoked when the block-property filterer has found
@@ -619,9 +701,39 @@ func (i *singleLevelIterator) SeekGE(
 	// Seek optimization only applies until iterator is first positioned after SetBounds.
 	i.boundsCmp = 0
 	i.positionedUsingLatestBounds = true
+	if excluded, err := i.filterExcludesSeekGE(key); excluded || err != nil {
+		i.err = err
+		i.maybeFilteredKeysSingleLevel = false
+		i.data.invalidate()
+		return nil, base.LazyValue{}
+	}
 	return i.seekGEHelper(key, boundsCmp, flags)
 }
 
+// filterExcludesSeekGE returns true if the table filter shows that the table
+// contains no point keys in [key, i.upper). The filter is only consulted if it
+// was built with a PrefixExtractor, and key and i.upper have the same
+// extracted prefix: as keys with the same extracted prefix are contiguous,
+// every key in [key, i.upper) then has that prefix. This allows scans of an
+// extracted prefix that span multiple Comparer.Split prefixes to skip tables
+// without reading their data blocks.
+func (i *singleLevelIterator) filterExcludesSeekGE(key []byte) (bool, error) {
+	split := i.reader.filterSplit
+	if !i.useFilter || i.reader.tableFilter == nil || split == nil || i.upper == nil {
+		return false, nil
+	}
+	prefix := key[:split(key)]
+	if !bytes.Equal(prefix, i.upper[:split(i.upper)]) {
+		return false, nil
+	}
+	dataH, err := i.reader.readFilter(i.ctx, i.stats, i.cacheStats)
+	if err != nil {
+		return false, err
+	}
+	defer dataH.Release()
+	return !i.reader.tableFilter.mayContain(dataH.Get(), prefix), nil
+}
+
 // seekGEHelper contains the common functionality for SeekGE and SeekPrefixGE.
 func (i *singleLevelIterator) seekGEHelper(
 	key []byte, boundsCmp int, flags base.SeekGEFlags,
@@ -782,12 +894,12 @@ func (i *singleLevelIterator) seekPrefixGE(
 		i.lastBloomFilterMatched = false
 		// Check prefix bloom filter.
 		var dataH bufferHandle
-		dataH, i.err = i.reader.readFilter(i.ctx, i.stats)
+		dataH, i.err = i.reader.readFilter(i.ctx, i.stats, i.cacheStats)
 		if i.err != nil {
 			i.data.invalidate()
 			return nil, base.LazyValue{}
 		}
-		mayContain := i.reader.tableFilter.mayContain(dataH.Get(), prefix)
+		mayContain := i.reader.tableFilter.mayContain(dataH.Get(), i.reader.filterPrefix(prefix))
 		dataH.Release()
 		if !mayContain {
 			// This invalidation may not be necessary for correctness, and may
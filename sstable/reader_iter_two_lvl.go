@@ -60,7 +60,7 @@ func (i *twoLevelIterator) loadIndex(dir int8) loadBlockResult {
 		// blockIntersects
 	}
 	ctx := objiotracing.WithBlockType(i.ctx, objiotracing.MetadataBlock)
-	indexBlock, err := i.reader.readBlock(ctx, bhp.BlockHandle, nil /* transform */, nil /* readHandle */, i.stats, i.bufferPool)
+	indexBlock, err := i.reader.readBlock(ctx, bhp.BlockHandle, BlockKindIndex, i.reader.indexTransform, nil /* readHandle */, i.stats, i.cacheStats, i.bufferPool)
 	if err != nil {
 		i.err = err
 		return loadBlockFailed
@@ -145,13 +145,14 @@ func (i *twoLevelIterator) init(
 	filterer *BlockPropertiesFilterer,
 	useFilter, hideObsoletePoints bool,
 	stats *base.InternalIteratorStats,
+	cacheStats *CacheStats,
 	rp ReaderProvider,
 	bufferPool *BufferPool,
 ) error {
 	if r.err != nil {
 		return r.err
 	}
-	topLevelIndexH, err := r.readIndex(ctx, stats)
+	topLevelIndexH, err := r.readIndex(ctx, stats, cacheStats)
 	if err != nil {
 		return err
 	}
@@ -169,6 +170,7 @@ func (i *twoLevelIterator) init(
 	i.reader = r
 	i.cmp = r.Compare
 	i.stats = stats
+	i.cacheStats = cacheStats
 	i.hideObsoletePoints = hideObsoletePoints
 	i.bufferPool = bufferPool
 	err = i.topLevelIndex.initHandle(i.cmp, topLevelIndexH, r.Properties.GlobalSeqNum, false)
@@ -179,13 +181,14 @@ func (i *twoLevelIterator) init(
 	}
 	i.dataRH = r.readable.NewReadHandle(ctx)
 	if r.tableFormat >= TableFormatPebblev3 {
-		if r.Properties.NumValueBlocks > 0 {
+		if r.Properties.NumValueBlocks > 0 || r.Properties.NumValuesInBlobFiles > 0 {
 			i.vbReader = &valueBlockReader{
-				ctx:    ctx,
-				bpOpen: i,
-				rp:     rp,
-				vbih:   r.valueBIH,
-				stats:  stats,
+				ctx:         ctx,
+				bpOpen:      i,
+				rp:          rp,
+				vbih:        r.valueBIH,
+				stats:       stats,
+				blobFetcher: r.opts.BlobValueFetcher,
 			}
 			i.data.lazyValueHandling.vbr = i.vbReader
 			i.vbRH = r.readable.NewReadHandle(ctx)
@@ -247,6 +250,20 @@ func (i *twoLevelIterator) SeekGE(
 		return nil, base.LazyValue{}
 	}
 
+	if excluded, err := i.filterExcludesSeekGE(key); excluded || err != nil {
+		// Invalidate the data and index blocks, so that a following SeekGE
+		// with TrySeekUsingNext sees the iterator as exhausted.
+		i.err = err
+		i.exhaustedBounds = 0
+		i.boundsCmp = 0
+		i.positionedUsingLatestBounds = true
+		i.maybeFilteredKeysSingleLevel = false
+		i.maybeFilteredKeysTwoLevel = false
+		i.data.invalidate()
+		i.index.invalidate()
+		return nil, base.LazyValue{}
+	}
+
 	// SeekGE performs various step-instead-of-seeking optimizations: eg enabled
 	// by trySeekUsingNext, or by monotonically increasing bounds (i.boundsCmp).
 	// Care must be taken to ensure that when performing these optimizations and
@@ -421,12 +438,12 @@ func (i *twoLevelIterator) SeekPrefixGE(
 		}
 		i.lastBloomFilterMatched = false
 		var dataH bufferHandle
-		dataH, i.err = i.reader.readFilter(i.ctx, i.stats)
+		dataH, i.err = i.reader.readFilter(i.ctx, i.stats, i.cacheStats)
 		if i.err != nil {
 			i.data.invalidate()
 			return nil, base.LazyValue{}
 		}
-		mayContain := i.reader.tableFilter.mayContain(dataH.Get(), prefix)
+		mayContain := i.reader.tableFilter.mayContain(dataH.Get(), i.reader.filterPrefix(prefix))
 		dataH.Release()
 		if !mayContain {
 			// This invalidation may not be necessary for correctness, and may
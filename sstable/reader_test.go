@@ -42,7 +42,7 @@ func (r *Reader) get(key []byte) (value []byte, err error) {
 	}
 
 	if r.tableFilter != nil {
-		dataH, err := r.readFilter(context.Background(), nil /* stats */)
+		dataH, err := r.readFilter(context.Background(), nil /* stats */, nil /* cacheStats */)
 		if err != nil {
 			return nil, err
 		}
@@ -241,6 +241,9 @@ func TestVirtualReader(t *testing.T) {
 		var b bytes.Buffer
 		fmt.Fprintf(&b, "bounds:  [%s-%s]\n", v.vState.lower, v.vState.upper)
 		fmt.Fprintf(&b, "filenum: %s\n", v.vState.fileNum.String())
+		if v.vState.prefixReplacement != nil {
+			fmt.Fprintf(&b, "prefix:  %s\n", v.vState.prefixReplacement)
+		}
 		fmt.Fprintf(
 			&b, "props:   %d,%d\n",
 			v.Properties.RawKeySize,
@@ -313,8 +316,21 @@ func TestVirtualReader(t *testing.T) {
 			vMeta.Smallest = base.ParseInternalKey(bounds[0])
 			vMeta.Largest = base.ParseInternalKey(bounds[1])
 			vMeta.FileNum = nextFileNum()
+			smallest, largest := vMeta.Smallest.UserKey, vMeta.Largest.UserKey
+			// The keys of the virtual sstable may be exposed with a synthetic
+			// prefix, with prefix=(<content prefix>,<synthetic prefix>).
+			if td.HasArg("prefix") {
+				var content, synthetic string
+				td.ScanArgs(t, "prefix", &content, &synthetic)
+				vMeta.PrefixReplacement = &manifest.PrefixReplacement{
+					ContentPrefix:   []byte(content),
+					SyntheticPrefix: []byte(synthetic),
+				}
+				smallest = vMeta.PrefixReplacement.ReplaceArg(nil, smallest)
+				largest = vMeta.PrefixReplacement.ReplaceArg(nil, largest)
+			}
 			var err error
-			vMeta.Size, err = r.EstimateDiskUsage(vMeta.Smallest.UserKey, vMeta.Largest.UserKey)
+			vMeta.Size, err = r.EstimateDiskUsage(smallest, largest)
 			if err != nil {
 				return err.Error()
 			}
@@ -420,7 +436,7 @@ func TestVirtualReader(t *testing.T) {
 			var stats base.InternalIteratorStats
 			iter, err := v.NewIterWithBlockPropertyFiltersAndContextEtc(
 				context.Background(), lower, upper, nil, false, false,
-				&stats, TrivialReaderProvider{Reader: r})
+				&stats, nil /* cacheStats */, TrivialReaderProvider{Reader: r}, nil /* bufferPool */)
 			if err != nil {
 				return err.Error()
 			}
@@ -697,7 +713,7 @@ func TestInvalidReader(t *testing.T) {
 }
 
 func indexLayoutString(t *testing.T, r *Reader) string {
-	indexH, err := r.readIndex(context.Background(), nil)
+	indexH, err := r.readIndex(context.Background(), nil, nil)
 	require.NoError(t, err)
 	defer indexH.Release()
 	var buf strings.Builder
@@ -714,7 +730,7 @@ func indexLayoutString(t *testing.T, r *Reader) string {
 		fmt.Fprintf(&buf, " %s: size %d\n", string(key.UserKey), bh.Length)
 		if twoLevelIndex {
 			b, err := r.readBlock(
-				context.Background(), bh.BlockHandle, nil, nil, nil, nil)
+				context.Background(), bh.BlockHandle, BlockKindIndex, nil, nil, nil, nil, nil)
 			require.NoError(t, err)
 			defer b.Release()
 			iter2, err := newBlockIter(r.Compare, b.Get())
@@ -808,7 +824,9 @@ func runTestReader(t *testing.T, o WriterOptions, dir string, r *Reader, printVa
 					hideObsoletePoints,
 					true, /* use filter block */
 					&stats,
+					nil, /* cacheStats */
 					TrivialReaderProvider{Reader: r},
+					nil, /* bufferPool */
 				)
 				if err != nil {
 					return err.Error()
@@ -1075,8 +1093,59 @@ func TestReadaheadSetupForV3TablesWithMultipleVersions(t *testing.T) {
 	}
 }
 
+func TestIteratorPrefetch(t *testing.T) {
+	settings := objstorageprovider.DefaultSettings(vfs.Default, t.TempDir())
+	settings.IOUringReads = true
+	provider, err := objstorageprovider.Open(settings)
+	require.NoError(t, err)
+	defer provider.Close()
+
+	const numEntries = 10000
+	for _, indexBlockSize := range []int{100, math.MaxInt32} {
+		t.Run(fmt.Sprintf("index-block-size=%d", indexBlockSize), func(t *testing.T) {
+			r := buildTestTableWithProvider(t, provider, numEntries, 100, indexBlockSize, DefaultCompression)
+			defer r.Close()
+			if !objstorage.BatchesReads(r.readable) {
+				t.Skip("io_uring not supported")
+			}
+			var cacheStats CacheStats
+			r.opts.CacheStats = &cacheStats
+
+			// A forward scan prefetches the data blocks in batches, so nearly
+			// all of its data block reads are served by the block cache.
+			iter, err := r.NewIter(nil, nil)
+			require.NoError(t, err)
+			n := 0
+			for key, _ := iter.First(); key != nil; key, _ = iter.Next() {
+				require.Equal(t, uint64(n), binary.BigEndian.Uint64(key.UserKey))
+				n++
+			}
+			require.Equal(t, numEntries, n)
+			m := cacheStats.Metrics()[BlockKindData]
+			require.Less(t, m.Misses, m.Hits/100)
+
+			// Prefetching stops at the upper bound, and doesn't disturb the
+			// position of the iterator.
+			upper := make([]byte, 8)
+			binary.BigEndian.PutUint64(upper, numEntries/2)
+			iter.SetBounds(nil, upper)
+			n = 0
+			for key, _ := iter.SeekGE(upper[:0], base.SeekGEFlagsNone); key != nil; key, _ = iter.Next() {
+				n++
+			}
+			require.Equal(t, numEntries/2, n)
+			n = 0
+			for key, _ := iter.SeekLT(upper, base.SeekLTFlagsNone); key != nil; key, _ = iter.Prev() {
+				n++
+			}
+			require.Equal(t, numEntries/2, n)
+			require.NoError(t, iter.Close())
+		})
+	}
+}
+
 func TestReaderChecksumErrors(t *testing.T) {
-	for _, checksumType := range []ChecksumType{ChecksumTypeCRC32c, ChecksumTypeXXHash64} {
+	for _, checksumType := range []ChecksumType{ChecksumTypeCRC32c, ChecksumTypeXXHash64, ChecksumTypeXXH3} {
 		t.Run(fmt.Sprintf("checksum-type=%d", checksumType), func(t *testing.T) {
 			for _, twoLevelIndex := range []bool{false, true} {
 				t.Run(fmt.Sprintf("two-level-index=%t", twoLevelIndex), func(t *testing.T) {
@@ -1361,6 +1430,146 @@ func TestValidateBlockChecksums(t *testing.T) {
 	}
 }
 
+func TestReaderCacheResidency(t *testing.T) {
+	f := &memFile{}
+	w := NewWriter(f, WriterOptions{
+		BlockSize:      64,
+		IndexBlockSize: 64,
+		Compression:    NoCompression,
+		FilterPolicy:   bloom.FilterPolicy(10),
+		TableFormat:    TableFormatPebblev2,
+	})
+	for i := 0; i < 100; i++ {
+		require.NoError(t, w.Set([]byte(fmt.Sprintf("key%03d", i)), []byte("value")))
+	}
+	require.NoError(t, w.Close())
+
+	for _, pin := range []bool{false, true} {
+		t.Run(fmt.Sprintf("pin=%t", pin), func(t *testing.T) {
+			c := cache.New(1 << 20)
+			defer c.Unref()
+			r, err := NewMemReader(f.Data(), ReaderOptions{Cache: c, PinTopLevelIndex: pin})
+			require.NoError(t, err)
+			defer r.Close()
+			require.Greater(t, r.Properties.IndexPartitions, uint64(1))
+
+			res := r.CacheResidency()
+			require.Equal(t, r.Properties.IndexSize, res.IndexBytes)
+			require.Equal(t, r.filterBH.Length, res.FilterBytes)
+			require.Zero(t, res.FilterBytesCached)
+			if pin {
+				require.Equal(t, r.Properties.TopLevelIndexSize, res.IndexBytesCached)
+			} else {
+				require.Zero(t, res.IndexBytesCached)
+			}
+
+			// Reading all of the table makes all of its index blocks resident,
+			// and a prefix seek makes its filter resident.
+			iter, err := r.NewIter(nil, nil)
+			require.NoError(t, err)
+			n := 0
+			for k, _ := iter.First(); k != nil; k, _ = iter.Next() {
+				n++
+			}
+			require.Equal(t, 100, n)
+			k, _ := iter.SeekPrefixGE([]byte("key050"), []byte("key050"), base.SeekGEFlagsNone)
+			require.NotNil(t, k)
+			require.NoError(t, iter.Close())
+
+			metrics := c.Metrics()
+			res = r.CacheResidency()
+			require.Equal(t, res.IndexBytes-blockTrailerLen, res.IndexBytesCached)
+			require.Equal(t, res.FilterBytes, res.FilterBytesCached)
+			// Inspecting the residency doesn't affect the cache's metrics.
+			require.Equal(t, metrics, c.Metrics())
+
+			// The pinned top-level index remains in use after it's evicted.
+			c.EvictFile(r.cacheID, r.fileNum)
+			res = r.CacheResidency()
+			if pin {
+				require.Equal(t, r.Properties.TopLevelIndexSize, res.IndexBytesCached)
+			} else {
+				require.Zero(t, res.IndexBytesCached)
+			}
+			iter, err = r.NewIter(nil, nil)
+			require.NoError(t, err)
+			k, _ = iter.SeekGE([]byte("key099"), base.SeekGEFlagsNone)
+			require.NotNil(t, k)
+			require.NoError(t, iter.Close())
+		})
+	}
+}
+
+func TestLayoutStats(t *testing.T) {
+	var dataStats BlockKindStats
+	for _, km := range []KeyManager{nil, testKeyManager{id: 1, kek: 0x5a}} {
+		t.Run(fmt.Sprintf("encrypted=%t", km != nil), func(t *testing.T) {
+			f := &memFile{}
+			w := NewWriter(f, WriterOptions{
+				BlockSize:      256,
+				IndexBlockSize: 256,
+				Comparer:       testkeys.Comparer,
+				Compression:    SnappyCompression,
+				FilterPolicy:   bloom.FilterPolicy(10),
+				TableFormat:    TableFormatPebblev4,
+				KeyManager:     km,
+			})
+			value := bytes.Repeat([]byte("compressible"), 10)
+			for i := 0; i < 200; i++ {
+				// The older version of each key is stored in a value block.
+				for j := 2; j >= 1; j-- {
+					require.NoError(t, w.Set([]byte(fmt.Sprintf("key%04d@%d", i, j)), value))
+				}
+			}
+			require.NoError(t, w.DeleteRange([]byte("key0100"), []byte("key0150")))
+			require.NoError(t, w.RangeKeySet([]byte("key0000"), []byte("key0010"), []byte("@5"), nil))
+			require.NoError(t, w.Close())
+
+			r, err := NewMemReader(f.Data(), ReaderOptions{
+				Comparer:   testkeys.Comparer,
+				Filters:    map[string]FilterPolicy{bloom.FilterPolicy(10).Name(): bloom.FilterPolicy(10)},
+				KeyManager: km,
+			})
+			require.NoError(t, err)
+			defer r.Close()
+			l, err := r.Layout()
+			require.NoError(t, err)
+			s, err := l.Stats(r)
+			require.NoError(t, err)
+
+			require.Equal(t, uint64(len(l.Data)), s.Data.Count)
+			require.Equal(t, uint64(len(l.Index)+1), s.Index.Count)
+			require.Equal(t, uint64(len(l.ValueBlock)), s.ValueBlock.Count)
+			require.Greater(t, s.ValueBlock.Count, uint64(0))
+			for _, ks := range []BlockKindStats{
+				s.Filter, s.RangeDel, s.RangeKey, s.ValueIndex, s.Properties, s.MetaIndex,
+			} {
+				require.Equal(t, uint64(1), ks.Count)
+			}
+			require.Equal(t, l.Filter.Length, s.Filter.Size)
+
+			// The data and value blocks are compressed, regardless of whether
+			// they're encrypted.
+			require.Greater(t, s.Data.CompressedCount, uint64(0))
+			require.Greater(t, s.Data.CompressionRatio(), 1.0)
+			require.Greater(t, s.ValueBlock.CompressionRatio(), 1.0)
+			require.Zero(t, s.Filter.CompressedCount)
+			require.Equal(t, km != nil, s.Encryption.Count == 1)
+			if km == nil {
+				dataStats = s.Data
+				require.Equal(t, s.Filter.Size, s.Filter.UncompressedSize)
+			} else {
+				require.Equal(t, dataStats.UncompressedSize, s.Data.UncompressedSize)
+				require.Greater(t, s.Filter.Size, s.Filter.UncompressedSize)
+			}
+
+			// The blocks, their trailers and the footer make up the sstable.
+			total := s.Total()
+			require.Equal(t, uint64(len(f.Data())), total.Size+total.Count*blockTrailerLen+l.Footer.Length)
+		})
+	}
+}
+
 func TestReader_TableFormat(t *testing.T) {
 	test := func(t *testing.T, want TableFormat) {
 		fs := vfs.NewMem()
@@ -2050,7 +2259,7 @@ func BenchmarkIteratorScanObsolete(b *testing.B) {
 								}
 								iter, err := r.NewIterWithBlockPropertyFiltersAndContextEtc(
 									context.Background(), nil, nil, filterer, hideObsoletePoints,
-									true, nil, TrivialReaderProvider{Reader: r})
+									true, nil, nil, TrivialReaderProvider{Reader: r}, nil /* bufferPool */)
 								require.NoError(b, err)
 								b.ResetTimer()
 								for i := 0; i < b.N; i++ {
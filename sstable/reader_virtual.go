@@ -32,11 +32,16 @@ type VirtualReader struct {
 }
 
 // Lightweight virtual sstable state which can be passed to sstable iterators.
+//
+// If the keys of the virtual sstable are exposed with a synthetic prefix, the
+// bounds are translated into the keyspace of the backing sstable, in which the
+// sstable iterators operate.
 type virtualState struct {
-	lower   InternalKey
-	upper   InternalKey
-	fileNum base.FileNum
-	Compare Compare
+	lower             InternalKey
+	upper             InternalKey
+	fileNum           base.FileNum
+	Compare           Compare
+	prefixReplacement *manifest.PrefixReplacement
 }
 
 // MakeVirtualReader is used to contruct a reader which can read from virtual
@@ -47,10 +52,15 @@ func MakeVirtualReader(reader *Reader, meta manifest.VirtualFileMeta) VirtualRea
 	}
 
 	vState := virtualState{
-		lower:   meta.Smallest,
-		upper:   meta.Largest,
-		fileNum: meta.FileNum,
-		Compare: reader.Compare,
+		lower:             meta.Smallest,
+		upper:             meta.Largest,
+		fileNum:           meta.FileNum,
+		Compare:           reader.Compare,
+		prefixReplacement: meta.PrefixReplacement,
+	}
+	if pr := meta.PrefixReplacement; pr != nil {
+		vState.lower.UserKey = pr.ReplaceArg(nil, vState.lower.UserKey)
+		vState.upper.UserKey = pr.ReplaceArg(nil, vState.upper.UserKey)
 	}
 	v := VirtualReader{
 		vState: vState,
@@ -69,7 +79,11 @@ func MakeVirtualReader(reader *Reader, meta manifest.VirtualFileMeta) VirtualRea
 func (v *VirtualReader) NewCompactionIter(
 	bytesIterated *uint64, rp ReaderProvider, bufferPool *BufferPool,
 ) (Iterator, error) {
-	return v.reader.newCompactionIter(bytesIterated, rp, &v.vState, bufferPool)
+	i, err := v.reader.newCompactionIter(bytesIterated, rp, &v.vState, bufferPool)
+	if err != nil || v.vState.prefixReplacement == nil {
+		return i, err
+	}
+	return newPrefixReplacingIterator(i, v.vState.prefixReplacement), nil
 }
 
 // NewIterWithBlockPropertyFiltersAndContextEtc wraps
@@ -82,11 +96,21 @@ func (v *VirtualReader) NewIterWithBlockPropertyFiltersAndContextEtc(
 	filterer *BlockPropertiesFilterer,
 	hideObsoletePoints, useFilterBlock bool,
 	stats *base.InternalIteratorStats,
+	cacheStats *CacheStats,
 	rp ReaderProvider,
+	bufferPool *BufferPool,
 ) (Iterator, error) {
-	return v.reader.newIterWithBlockPropertyFiltersAndContext(
-		ctx, lower, upper, filterer, hideObsoletePoints, useFilterBlock, stats, rp, &v.vState,
+	pr := v.vState.prefixReplacement
+	if pr != nil {
+		lower, upper = v.vState.replaceArgs(lower, upper)
+	}
+	i, err := v.reader.newIterWithBlockPropertyFiltersAndContext(
+		ctx, lower, upper, filterer, hideObsoletePoints, useFilterBlock, stats, cacheStats, rp, &v.vState, bufferPool,
 	)
+	if err != nil || pr == nil {
+		return i, err
+	}
+	return newPrefixReplacingIterator(i, pr), nil
 }
 
 // NewRawRangeDelIter wraps Reader.NewRawRangeDelIter.
@@ -109,10 +133,14 @@ func (v *VirtualReader) NewRawRangeDelIter() (keyspan.FragmentIterator, error) {
 	// allowed (as they exclude b.SET.3), or [a#2,SET-c#RANGEDELSENTINEL] (as it
 	// includes both point keys), but not [a#2,SET-b#3,SET] (as it would truncate
 	// the rangedel at b and lead to the point being uncovered).
-	return keyspan.Truncate(
+	iter = keyspan.Truncate(
 		v.reader.Compare, iter, v.vState.lower.UserKey, v.vState.upper.UserKey,
 		&v.vState.lower, &v.vState.upper, !v.vState.upper.IsExclusiveSentinel(), /* panicOnUpperTruncate */
-	), nil
+	)
+	if pr := v.vState.prefixReplacement; pr != nil {
+		iter = newPrefixReplacingFragmentIterator(iter, pr)
+	}
+	return iter, nil
 }
 
 // NewRawRangeKeyIter wraps Reader.NewRawRangeKeyIter.
@@ -135,10 +163,27 @@ func (v *VirtualReader) NewRawRangeKeyIter() (keyspan.FragmentIterator, error) {
 	// allowed (as they exclude b.SET.3), or [a#2,SET-c#RANGEKEYSENTINEL] (as it
 	// includes both point keys), but not [a#2,SET-b#3,SET] (as it would truncate
 	// the range key at b and lead to the point being uncovered).
-	return keyspan.Truncate(
+	iter = keyspan.Truncate(
 		v.reader.Compare, iter, v.vState.lower.UserKey, v.vState.upper.UserKey,
 		&v.vState.lower, &v.vState.upper, !v.vState.upper.IsExclusiveSentinel(), /* panicOnUpperTruncate */
-	), nil
+	)
+	if pr := v.vState.prefixReplacement; pr != nil {
+		iter = newPrefixReplacingFragmentIterator(iter, pr)
+	}
+	return iter, nil
+}
+
+// replaceArgs translates the start and end keys of a range of the keyspace of
+// a virtual sstable whose keys are exposed with a synthetic prefix into the
+// keyspace of its backing sstable. Nil keys remain nil.
+func (v *virtualState) replaceArgs(start, end []byte) ([]byte, []byte) {
+	if start != nil {
+		start = v.prefixReplacement.ReplaceArg(nil, start)
+	}
+	if end != nil {
+		end = v.prefixReplacement.ReplaceArg(nil, end)
+	}
+	return start, end
 }
 
 // Constrain bounds will narrow the start, end bounds if they do not fit within
@@ -178,6 +223,15 @@ func (v *virtualState) constrainBounds(
 // EstimateDiskUsage just calls VirtualReader.reader.EstimateDiskUsage after
 // enforcing the virtual sstable bounds.
 func (v *VirtualReader) EstimateDiskUsage(start, end []byte) (uint64, error) {
+	if v.vState.prefixReplacement != nil {
+		start, end = v.vState.replaceArgs(start, end)
+	}
 	_, f, l := v.vState.constrainBounds(start, end, true /* endInclusive */)
 	return v.reader.EstimateDiskUsage(f, l)
 }
+
+// CacheResidency returns the residency of the index and filter blocks of the
+// virtual sstable's backing table in the block cache.
+func (v *VirtualReader) CacheResidency() CacheResidency {
+	return v.reader.CacheResidency()
+}
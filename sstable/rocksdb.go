@@ -0,0 +1,179 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+)
+
+const (
+	rocksDBIndexKeyIsUserKeyName        = "rocksdb.index.key.is.user.key"
+	rocksDBIndexValueIsDeltaEncodedName = "rocksdb.index.value.is.delta.encoded"
+)
+
+// rocksDBProperties holds the properties RocksDB's BlockBasedTableBuilder
+// writes to describe how a table's index and filter blocks are encoded.
+// Tables written by Pebble, including those in TableFormatRocksDBv2, always
+// have the zero value.
+type rocksDBProperties struct {
+	// indexKeyIsUserKey is set if the keys of the index blocks are user keys
+	// rather than internal keys. RocksDB does this from format version 3 when
+	// no user key spans two data blocks.
+	indexKeyIsUserKey bool
+	// indexValueIsDeltaEncoded is set if the entries of the index blocks omit
+	// the value length, and entries other than restart points only encode the
+	// difference between their block's length and the previous block's
+	// length. RocksDB does this from format version 4.
+	indexValueIsDeltaEncoded bool
+}
+
+// load loads the properties from a table's properties block.
+func (p *rocksDBProperties) load(b block) error {
+	i, err := newRawBlockIter(bytes.Compare, b)
+	if err != nil {
+		return err
+	}
+	for valid := i.First(); valid; valid = i.Next() {
+		switch string(i.Key().UserKey) {
+		case rocksDBIndexKeyIsUserKeyName:
+			v, _ := binary.Uvarint(i.Value())
+			p.indexKeyIsUserKey = v != 0
+		case rocksDBIndexValueIsDeltaEncodedName:
+			v, _ := binary.Uvarint(i.Value())
+			p.indexValueIsDeltaEncoded = v != 0
+		}
+	}
+	return nil
+}
+
+// initRocksDBCompat configures the reader to read a table written by RocksDB
+// with a format version newer than 2, such as those produced by RocksDB's
+// SstFileWriter. The version is the format version recorded in the table's
+// footer. It must be called once the properties have been loaded.
+func (r *Reader) initRocksDBCompat(version uint32) error {
+	if r.tableFormat != TableFormatRocksDBv2 {
+		return nil
+	}
+	p := &r.rocksDB
+	switch r.Properties.IndexType {
+	case binarySearchIndex, twoLevelIndex:
+	default:
+		return errors.Newf("pebble/table: unsupported rocksdb index type %d",
+			errors.Safe(r.Properties.IndexType))
+	}
+	if version >= rocksDBFormatVersion5 {
+		// Format version 5 replaced RocksDB's full filter with a different bloom
+		// filter implementation under the same policy name, which the bloom
+		// package can't read. The filter is only an optimization, so ignore it.
+		r.tableFilter = nil
+	}
+	if p.indexKeyIsUserKey || p.indexValueIsDeltaEncoded {
+		r.indexTransform = r.transformRocksDBIndex
+	}
+	return nil
+}
+
+// transformRocksDBIndex converts an index block written by RocksDB with user
+// key separators or delta-encoded block handles into the index block encoding
+// Pebble reads, where every entry maps an internal key to a full block handle.
+// Both the single-level index and the blocks of a two-level index are
+// converted.
+func (r *Reader) transformRocksDBIndex(b []byte) ([]byte, error) {
+	p := &r.rocksDB
+	if len(b) < 4 {
+		return nil, errCorruptIndexEntry
+	}
+	numRestarts := int(binary.LittleEndian.Uint32(b[len(b)-4:]))
+	restartsOffset := len(b) - 4*(numRestarts+1)
+	if numRestarts == 0 || restartsOffset < 0 {
+		return nil, errCorruptIndexEntry
+	}
+	restarts := b[restartsOffset : len(b)-4]
+	data := b[:restartsOffset]
+
+	indexBlock := blockWriter{
+		restartInterval: 1,
+	}
+	var key []byte
+	var prevBH BlockHandle
+	var tmp [blockHandleMaxLenWithoutProperties]byte
+	for offset, nextRestart := 0, 0; offset < len(data); {
+		isRestart := nextRestart < numRestarts &&
+			int(binary.LittleEndian.Uint32(restarts[4*nextRestart:])) == offset
+		if isRestart {
+			nextRestart++
+		}
+
+		shared, n := binary.Uvarint(data[offset:])
+		if n <= 0 {
+			return nil, errCorruptIndexEntry
+		}
+		offset += n
+		unshared, n := binary.Uvarint(data[offset:])
+		if n <= 0 {
+			return nil, errCorruptIndexEntry
+		}
+		offset += n
+		var valueLen uint64
+		if !p.indexValueIsDeltaEncoded {
+			valueLen, n = binary.Uvarint(data[offset:])
+			if n <= 0 {
+				return nil, errCorruptIndexEntry
+			}
+			offset += n
+		}
+		if shared > uint64(len(key)) || unshared > uint64(len(data)-offset) {
+			return nil, errCorruptIndexEntry
+		}
+		key = append(key[:shared], data[offset:offset+int(unshared)]...)
+		offset += int(unshared)
+
+		var bh BlockHandle
+		switch {
+		case !p.indexValueIsDeltaEncoded:
+			if valueLen > uint64(len(data)-offset) {
+				return nil, errCorruptIndexEntry
+			}
+			if bh, n = decodeBlockHandle(data[offset : offset+int(valueLen)]); n == 0 {
+				return nil, errCorruptIndexEntry
+			}
+			offset += int(valueLen)
+		case isRestart:
+			if bh, n = decodeBlockHandle(data[offset:]); n == 0 {
+				return nil, errCorruptIndexEntry
+			}
+			offset += n
+		default:
+			// The block immediately follows the previous one, so only the
+			// difference in length is encoded.
+			delta, n := binary.Varint(data[offset:])
+			if n <= 0 {
+				return nil, errCorruptIndexEntry
+			}
+			offset += n
+			bh = BlockHandle{
+				Offset: prevBH.Offset + prevBH.Length + blockTrailerLen,
+				Length: uint64(int64(prevBH.Length) + delta),
+			}
+		}
+		prevBH = bh
+
+		var sep InternalKey
+		if p.indexKeyIsUserKey {
+			// RocksDB only uses user key separators when no user key spans two
+			// data blocks. A zero trailer sorts the separator after every key
+			// in the block with the same user key.
+			sep = base.MakeInternalKey(key, 0, InternalKeyKindDelete)
+		} else {
+			sep = base.DecodeInternalKey(key)
+		}
+		indexBlock.add(sep, tmp[:encodeBlockHandle(tmp[:], bh)])
+	}
+	return indexBlock.finish(), nil
+}
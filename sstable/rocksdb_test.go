@@ -0,0 +1,140 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/stretchr/testify/require"
+)
+
+type rocksDBIndexEntry struct {
+	key []byte
+	bh  BlockHandle
+}
+
+// buildRocksDBIndexBlock encodes entries the way RocksDB's BlockBuilder does
+// for index blocks with the given properties.
+func buildRocksDBIndexBlock(
+	p rocksDBProperties, restartInterval int, entries []rocksDBIndexEntry,
+) []byte {
+	var buf, prevKey []byte
+	var restarts []uint32
+	var prevBH BlockHandle
+	for i, e := range entries {
+		isRestart := i%restartInterval == 0
+		shared := 0
+		if isRestart {
+			restarts = append(restarts, uint32(len(buf)))
+		} else {
+			for shared < len(prevKey) && shared < len(e.key) && prevKey[shared] == e.key[shared] {
+				shared++
+			}
+		}
+		var value []byte
+		if p.indexValueIsDeltaEncoded && !isRestart {
+			value = binary.AppendVarint(nil, int64(e.bh.Length)-int64(prevBH.Length))
+		} else {
+			value = binary.AppendUvarint(nil, e.bh.Offset)
+			value = binary.AppendUvarint(value, e.bh.Length)
+		}
+		buf = binary.AppendUvarint(buf, uint64(shared))
+		buf = binary.AppendUvarint(buf, uint64(len(e.key)-shared))
+		if !p.indexValueIsDeltaEncoded {
+			buf = binary.AppendUvarint(buf, uint64(len(value)))
+		}
+		buf = append(buf, e.key[shared:]...)
+		buf = append(buf, value...)
+		prevKey, prevBH = e.key, e.bh
+	}
+	for _, r := range restarts {
+		buf = binary.LittleEndian.AppendUint32(buf, r)
+	}
+	return binary.LittleEndian.AppendUint32(buf, uint32(len(restarts)))
+}
+
+func TestRocksDBIndexTransform(t *testing.T) {
+	handles := []BlockHandle{
+		{Offset: 0, Length: 100},
+		{Offset: 105, Length: 50},
+		{Offset: 160, Length: 70},
+		{Offset: 235, Length: 70},
+		{Offset: 310, Length: 10},
+	}
+	userKeys := []string{"apple", "apricot", "banana", "blueberry", "cherry"}
+
+	testCases := []struct {
+		name            string
+		props           rocksDBProperties
+		restartInterval int
+	}{
+		{
+			name:            "user-keys",
+			props:           rocksDBProperties{indexKeyIsUserKey: true},
+			restartInterval: 1,
+		},
+		{
+			name:            "delta-encoded",
+			props:           rocksDBProperties{indexValueIsDeltaEncoded: true},
+			restartInterval: 3,
+		},
+		{
+			name: "user-keys-delta-encoded",
+			props: rocksDBProperties{
+				indexKeyIsUserKey:        true,
+				indexValueIsDeltaEncoded: true,
+			},
+			restartInterval: 2,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var entries []rocksDBIndexEntry
+			for i, k := range userKeys {
+				key := []byte(k)
+				if !tc.props.indexKeyIsUserKey {
+					ik := base.MakeInternalKey(key, 0, InternalKeyKindSet)
+					key = make([]byte, ik.Size())
+					ik.Encode(key)
+				}
+				entries = append(entries, rocksDBIndexEntry{key: key, bh: handles[i]})
+			}
+			b := buildRocksDBIndexBlock(tc.props, tc.restartInterval, entries)
+
+			r := &Reader{}
+			r.rocksDB = tc.props
+			transformed, err := r.transformRocksDBIndex(b)
+			require.NoError(t, err)
+
+			iter, err := newBlockIter(bytes.Compare, transformed)
+			require.NoError(t, err)
+			var i int
+			for key, value := iter.First(); key != nil; key, value = iter.Next() {
+				require.Equal(t, userKeys[i], string(key.UserKey))
+				bhp, err := decodeBlockHandleWithProperties(value.InPlaceValue())
+				require.NoError(t, err)
+				require.Equal(t, handles[i], bhp.BlockHandle)
+				i++
+			}
+			require.NoError(t, iter.Close())
+			require.Equal(t, len(userKeys), i)
+
+			// A block too short to hold its restart count is reported as corrupt.
+			_, err = r.transformRocksDBIndex(b[:3])
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestParseRocksDBFormatVersions(t *testing.T) {
+	for v := uint32(rocksDBFormatVersion2); v <= rocksDBFormatVersion5; v++ {
+		f, err := ParseTableFormat([]byte(rocksDBMagic), v)
+		require.NoError(t, err)
+		require.Equal(t, TableFormatRocksDBv2, f)
+	}
+}
@@ -105,6 +105,12 @@ func rewriteKeySuffixesInBlocks(
 		return nil, TableFormatUnspecified,
 			errors.New("sstable with a single suffix should not have value blocks")
 	}
+	// The blocks are read and rewritten directly rather than through the
+	// Reader and Writer, which would decrypt and encrypt them.
+	if r.cipher != nil || o.KeyManager != nil {
+		return nil, TableFormatUnspecified,
+			errors.New("cannot rewrite suffixes of encrypted sstables")
+	}
 
 	tableFormat := r.tableFormat
 	o.TableFormat = tableFormat
@@ -501,7 +507,7 @@ func RewriteKeySuffixesViaWriter(
 		if err != nil {
 			return nil, err
 		}
-		if w.addPoint(scratch, val, false); err != nil {
+		if w.addPoint(scratch, val, nil, false); err != nil {
 			return nil, err
 		}
 		k, v = i.Next()
@@ -529,19 +535,33 @@ func readBlockBuf(r *Reader, bh BlockHandle, buf []byte) ([]byte, []byte, error)
 		return nil, buf, err
 	}
 	typ := blockType(raw[bh.Length])
+	columnar := typ&columnarBlockTypeFlag != 0
+	typ &^= columnarBlockTypeFlag
 	raw = raw[:bh.Length]
-	if typ == noCompressionBlockType {
-		return raw, buf, nil
-	}
-	decompressedLen, prefix, err := decompressedLen(typ, raw)
-	if err != nil {
-		return nil, buf, err
+	if typ != noCompressionBlockType {
+		decompressedLen, prefix, err := decompressedLen(typ, raw)
+		if err != nil {
+			return nil, buf, err
+		}
+		if cap(buf) < decompressedLen {
+			buf = make([]byte, decompressedLen)
+		}
+		if raw, err = decompressInto(typ, raw[prefix:], buf[:decompressedLen], r.zstdDict); err != nil {
+			return nil, buf, err
+		}
 	}
-	if cap(buf) < decompressedLen {
-		buf = make([]byte, decompressedLen)
+	if columnar {
+		n, err := columnarBlockLen(raw)
+		if err != nil {
+			return nil, buf, err
+		}
+		rows := make([]byte, n)
+		if err := decodeColumnarBlock(rows, raw); err != nil {
+			return nil, buf, err
+		}
+		raw = rows
 	}
-	res, err := decompressInto(typ, raw[prefix:], buf[:decompressedLen])
-	return res, buf, err
+	return raw, buf, nil
 }
 
 // memReader is a thin wrapper around a []byte such that it can be passed to
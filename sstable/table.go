@@ -73,6 +73,7 @@ import (
 
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/xxh3"
 	"github.com/cockroachdb/pebble/objstorage"
 )
 
@@ -208,9 +209,16 @@ const (
 
 	levelDBFormatVersion  = 0
 	rocksDBFormatVersion2 = 2
+	// rocksDBFormatVersion5 is the newest RocksDB format version that can be
+	// read. Versions 3 through 5 share the footer of version 2 and differ only
+	// in the encoding of index and filter blocks, which is described by the
+	// table's properties (see rocksDBProperties).
+	rocksDBFormatVersion5 = 5
 
+	metaEncryptionName = "pebble.encryption"
 	metaRangeKeyName   = "pebble.range_key"
 	metaValueIndexName = "pebble.value_index"
+	metaZstdDictName   = "pebble.zstd_dict"
 	metaPropertiesName = "rocksdb.properties"
 	metaRangeDelName   = "rocksdb.range_del"
 	metaRangeDelV2Name = "rocksdb.range_del2"
@@ -240,6 +248,10 @@ const (
 	ChecksumTypeCRC32c   ChecksumType = 1
 	ChecksumTypeXXHash   ChecksumType = 2
 	ChecksumTypeXXHash64 ChecksumType = 3
+	// ChecksumTypeXXH3 checksums blocks with the 64-bit XXH3 hash, which is
+	// considerably cheaper to compute than CRC-32C on platforms without a
+	// hardware CRC instruction. It's compatible with RocksDB's kXXH3.
+	ChecksumTypeXXH3 ChecksumType = 4
 )
 
 // String implements fmt.Stringer.
@@ -253,11 +265,34 @@ func (t ChecksumType) String() string {
 		return "xxhash"
 	case ChecksumTypeXXHash64:
 		return "xxhash64"
+	case ChecksumTypeXXH3:
+		return "xxh3"
 	default:
 		panic(errors.Newf("sstable: unknown checksum type: %d", t))
 	}
 }
 
+// ParseChecksumType parses the string representation of a ChecksumType, as
+// returned by String, for the checksum types that can be used to write
+// sstables.
+func ParseChecksumType(s string) (ChecksumType, error) {
+	for _, t := range []ChecksumType{ChecksumTypeCRC32c, ChecksumTypeXXHash64, ChecksumTypeXXH3} {
+		if t.String() == s {
+			return t, nil
+		}
+	}
+	return ChecksumTypeNone, errors.Errorf("sstable: unknown checksum type %q", s)
+}
+
+// xxh3Checksum returns the ChecksumTypeXXH3 checksum of a block followed by
+// its block type byte. As in RocksDB, the block is hashed by itself and the
+// type byte is mixed into the truncated hash afterwards, which avoids
+// copying the block to append the byte.
+func xxh3Checksum(b []byte, blockType byte) uint32 {
+	const randomPrime = 0x6b9083d9
+	return uint32(xxh3.Hash64(b)) ^ uint32(blockType)*randomPrime
+}
+
 type blockType byte
 
 const (
@@ -275,10 +310,20 @@ const (
 	lz4hcCompressionBlockType  blockType = 5
 	xpressCompressionBlockType blockType = 6
 	zstdCompressionBlockType   blockType = 7
+	// zstdDictCompressionBlockType is a Pebble extension: the block is
+	// compressed with zstd using the dictionary stored in the table's
+	// metaZstdDictName meta block.
+	zstdDictCompressionBlockType blockType = 8
 )
 
 // String implements fmt.Stringer.
 func (t blockType) String() string {
+	if t&encryptedBlockTypeFlag != 0 {
+		return (t &^ encryptedBlockTypeFlag).String() + "+encrypted"
+	}
+	if t&columnarBlockTypeFlag != 0 {
+		return (t &^ columnarBlockTypeFlag).String() + "+columnar"
+	}
 	switch t {
 	case 0:
 		return "none"
@@ -296,6 +341,8 @@ func (t blockType) String() string {
 		return "xpress"
 	case 7:
 		return "zstd"
+	case 8:
+		return "zstd-dict"
 	default:
 		panic(errors.Newf("sstable: unknown block type: %d", t))
 	}
@@ -318,6 +365,7 @@ func (t blockType) String() string {
 //	table_magic_number (8 bytes)
 type footer struct {
 	format      TableFormat
+	version     uint32
 	checksum    ChecksumType
 	metaindexBH BlockHandle
 	indexBH     BlockHandle
@@ -369,12 +417,15 @@ func readFooter(f objstorage.Readable) (footer, error) {
 			return footer, err
 		}
 		footer.format = format
+		footer.version = version
 
 		switch ChecksumType(buf[0]) {
 		case ChecksumTypeCRC32c:
 			footer.checksum = ChecksumTypeCRC32c
 		case ChecksumTypeXXHash64:
 			footer.checksum = ChecksumTypeXXHash64
+		case ChecksumTypeXXH3:
+			footer.checksum = ChecksumTypeXXH3
 		default:
 			return footer, base.CorruptionErrorf("pebble/table: unsupported checksum type %d", errors.Safe(footer.checksum))
 		}
@@ -427,6 +478,8 @@ func (f footer) encode(buf []byte) []byte {
 			buf[0] = byte(ChecksumTypeXXHash)
 		case ChecksumTypeXXHash64:
 			buf[0] = byte(ChecksumTypeXXHash64)
+		case ChecksumTypeXXH3:
+			buf[0] = byte(ChecksumTypeXXH3)
 		default:
 			panic("unknown checksum type")
 		}
@@ -694,7 +694,7 @@ func TestMetaIndexEntriesSorted(t *testing.T) {
 	r, err := newReader(f, ReaderOptions{})
 	require.NoError(t, err)
 
-	b, err := r.readBlock(context.Background(), r.metaIndexBH, nil, nil, nil, nil)
+	b, err := r.readBlock(context.Background(), r.metaIndexBH, BlockKindOther, nil, nil, nil, nil, nil)
 	require.NoError(t, err)
 	defer b.Release()
 
@@ -719,7 +719,7 @@ func TestFooterRoundTrip(t *testing.T) {
 		t.Run(fmt.Sprintf("format=%s", format), func(t *testing.T) {
 			checksums := []ChecksumType{ChecksumTypeCRC32c}
 			if format != TableFormatLevelDB {
-				checksums = []ChecksumType{ChecksumTypeCRC32c, ChecksumTypeXXHash64}
+				checksums = []ChecksumType{ChecksumTypeCRC32c, ChecksumTypeXXHash64, ChecksumTypeXXH3}
 			}
 			for _, checksum := range checksums {
 				t.Run(fmt.Sprintf("checksum=%d", checksum), func(t *testing.T) {
@@ -729,6 +729,7 @@ func TestFooterRoundTrip(t *testing.T) {
 						metaindexBH: BlockHandle{Offset: 1, Length: 2},
 						indexBH:     BlockHandle{Offset: 3, Length: 4},
 					}
+					_, footer.version = format.AsTuple()
 					for _, offset := range []int64{0, 1, 100} {
 						t.Run(fmt.Sprintf("offset=%d", offset), func(t *testing.T) {
 							mem := vfs.NewMem()
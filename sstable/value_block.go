@@ -111,9 +111,9 @@ import (
 // | value-kind 2b | SET-same-prefix 1b | unused 2b | short-attribute 3b |
 // +---------------+--------------------+-----------+--------------------+
 //
-// The 2 bit value-kind specifies whether this is an in-place value or a value
-// handle pointing to a value block. We use 2 bits here for future
-// representation of values that are in separate files. The 1 bit
+// The 2 bit value-kind specifies whether this is an in-place value, a value
+// handle pointing to a value block, or a blob handle pointing to a value in a
+// separate blob file (see "Blob Handles" below). The 1 bit
 // SET-same-prefix is true if this key is a SET and is immediately preceded by
 // a SET that shares the same prefix. The 3 bit short-attribute is described
 // in base.ShortAttribute -- it stores user-defined attributes about the
@@ -179,6 +179,16 @@ import (
 // above example, the
 // valueBlockIndexHandle.{blockNumByteLength,blockOffsetByteLength,blockLengthByteLength}
 // will be (2,4,2).
+//
+// Blob Handles:
+// In sstables written by a DB that separates large values into blob files
+// (see internal/blob), the value of a SET may be a blob handle, which is the
+// varint encoded value length followed by a handle that is opaque to this
+// package. The reader returns such values as a LazyValue whose fetcher is
+// ReaderOptions.BlobValueFetcher, and the blob handle as the
+// LazyValue.ValueOrHandle. Blob handles are written with
+// Writer.AddBlobHandle, and are counted by the
+// pebble.num.values.in.blob-files property.
 
 // valueHandle is stored with a key when the value is in a value block. This
 // handle is the pointer to that value.
@@ -196,6 +206,7 @@ const (
 	// 2 most-significant bits of valuePrefix encodes the value-kind.
 	valueKindMask           valuePrefix = '\xC0'
 	valueKindIsValueHandle  valuePrefix = '\x80'
+	valueKindIsBlobHandle   valuePrefix = '\x40'
 	valueKindIsInPlaceValue valuePrefix = '\x00'
 
 	// 1 bit indicates SET has same key prefix as immediately preceding key that
@@ -246,11 +257,27 @@ func makePrefixForInPlaceValue(setHasSameKeyPrefix bool) valuePrefix {
 	return prefix
 }
 
+func makePrefixForBlobHandle(setHasSameKeyPrefix bool, attribute base.ShortAttribute) valuePrefix {
+	prefix := valueKindIsBlobHandle | valuePrefix(attribute)
+	if setHasSameKeyPrefix {
+		prefix = prefix | setHasSameKeyPrefixMask
+	}
+	return prefix
+}
+
 func isValueHandle(b valuePrefix) bool {
 	return b&valueKindMask == valueKindIsValueHandle
 }
 
-// REQUIRES: isValueHandle(b)
+func isBlobHandle(b valuePrefix) bool {
+	return b&valueKindMask == valueKindIsBlobHandle
+}
+
+func isInPlaceValue(b valuePrefix) bool {
+	return b&valueKindMask == valueKindIsInPlaceValue
+}
+
+// REQUIRES: isValueHandle(b) || isBlobHandle(b)
 func getShortAttribute(b valuePrefix) base.ShortAttribute {
 	return base.ShortAttribute(b & userDefinedShortAttributeMask)
 }
@@ -392,6 +419,8 @@ type valueBlockWriter struct {
 	compression Compression
 	// checksummer with configured checksum type.
 	checksummer checksummer
+	// cipher, if set, encrypts the value blocks.
+	cipher *blockCipher
 	// Block finished callback.
 	blockFinishedFunc func(compressedSize int)
 
@@ -461,6 +490,7 @@ func newValueBlockWriter(
 	blockSizeThreshold int,
 	compression Compression,
 	checksumType ChecksumType,
+	cipher *blockCipher,
 	// compressedSize should exclude the block trailer.
 	blockFinishedFunc func(compressedSize int),
 ) *valueBlockWriter {
@@ -472,6 +502,7 @@ func newValueBlockWriter(
 		checksummer: checksummer{
 			checksumType: checksumType,
 		},
+		cipher:            cipher,
 		blockFinishedFunc: blockFinishedFunc,
 		buf:               uncompressedValueBlockBufPool.Get().(*blockBuffer),
 		compressedBuf:     compressedValueBlockBufPool.Get().(*blockBuffer),
@@ -562,6 +593,14 @@ func (w *valueBlockWriter) compressAndFlush() {
 			blockType = noCompressionBlockType
 		}
 	}
+	if w.cipher != nil {
+		// Like compressed blocks, encrypted blocks are held in buffers from
+		// compressedValueBlockBufPool.
+		e := compressedValueBlockBufPool.Get().(*blockBuffer)
+		e.b = w.cipher.seal(e.b[:0], b.b)
+		b = e
+		blockType |= encryptedBlockTypeFlag
+	}
 	n := len(b.b)
 	if n+blockTrailerLen > cap(b.b) {
 		block := make([]byte, n+blockTrailerLen)
@@ -576,16 +615,16 @@ func (w *valueBlockWriter) compressAndFlush() {
 	w.totalBlockBytes += uint64(len(b.b))
 	// blockFinishedFunc length excludes the block trailer.
 	w.blockFinishedFunc(n)
-	compressed := blockType != noCompressionBlockType
 	w.blocks = append(w.blocks, blockAndHandle{
 		block:      b,
 		handle:     bh,
-		compressed: compressed,
+		compressed: b != w.buf,
 	})
 	// Handed off a buffer to w.blocks, so need get a new one.
-	if compressed {
+	switch b {
+	case w.compressedBuf:
 		w.compressedBuf = compressedValueBlockBufPool.Get().(*blockBuffer)
-	} else {
+	case w.buf:
 		w.buf = uncompressedValueBlockBufPool.Get().(*blockBuffer)
 	}
 	w.buf.b = w.buf.b[:0]
@@ -753,7 +792,7 @@ func (bpwc blockProviderWhenClosed) readBlockForVBR(
 	// TODO(jackson,sumeer): Consider whether to use a buffer pool in this case.
 	// The bpwc is not allowed to outlive the iterator tree, so it cannot
 	// outlive the buffer pool.
-	return bpwc.r.readBlock(ctx, h, nil, nil, stats, nil /* buffer pool */)
+	return bpwc.r.readBlock(ctx, h, BlockKindValue, nil, nil, stats, nil /* cacheStats */, nil /* buffer pool */)
 }
 
 // ReaderProvider supports the implementation of blockProviderWhenClosed.
@@ -788,6 +827,8 @@ type valueBlockReader struct {
 	rp     ReaderProvider
 	vbih   valueBlocksIndexHandle
 	stats  *base.InternalIteratorStats
+	// blobFetcher fetches the values of blob handles.
+	blobFetcher base.ValueFetcher
 
 	// The value blocks index is lazily retrieved the first time the reader
 	// needs to read a value that resides in a value block.
@@ -810,6 +851,19 @@ type valueBlockReader struct {
 func (r *valueBlockReader) getLazyValueForPrefixAndValueHandle(handle []byte) base.LazyValue {
 	fetcher := &r.lazyFetcher
 	valLen, h := decodeLenFromValueHandle(handle[1:])
+	if isBlobHandle(valuePrefix(handle[0])) {
+		*fetcher = base.LazyFetcher{
+			Fetcher: r.blobFetcher,
+			Attribute: base.AttributeAndLen{
+				ValueLen:       int32(valLen),
+				ShortAttribute: getShortAttribute(valuePrefix(handle[0])),
+			},
+		}
+		return base.LazyValue{
+			ValueOrHandle: h,
+			Fetcher:       fetcher,
+		}
+	}
 	*fetcher = base.LazyFetcher{
 		Fetcher: r,
 		Attribute: base.AttributeAndLen{
@@ -948,3 +1002,14 @@ func (r *valueBlockReader) getBlockHandle(blockNum uint32) (BlockHandle, error)
 	blockLen := littleEndianGet(b, n)
 	return BlockHandle{Offset: blockOffset, Length: blockLen}, nil
 }
+
+// noBlobValueFetcher is the blob value fetcher of readers opened without
+// ReaderOptions.BlobValueFetcher.
+type noBlobValueFetcher struct{}
+
+// Fetch implements base.ValueFetcher.
+func (noBlobValueFetcher) Fetch(
+	handle []byte, valLen int32, buf []byte,
+) (val []byte, callerOwned bool, err error) {
+	return nil, false, errors.New("pebble: value is in a blob file, but no blob value fetcher is configured")
+}
@@ -51,6 +51,10 @@ type WriterMetadata struct {
 	SmallestSeqNum   uint64
 	LargestSeqNum    uint64
 	Properties       Properties
+	// TrainedZstdDictionary is the zstd dictionary trained from the data
+	// blocks of the sstable if WriterOptions.TrainZstdDictionarySize is
+	// positive, or nil if the sstable holds too little data to train one.
+	TrainedZstdDictionary []byte
 }
 
 // SetSmallestPointKey sets the smallest point key to the given key.
@@ -136,7 +140,23 @@ type Writer struct {
 	writingToLowestLevel    bool
 	cache                   *cache.Cache
 	restartInterval         int
+	disableKeyDeltaEncoding bool
 	checksumType            ChecksumType
+	// zstdDict is the zstd dictionary with which data blocks are compressed,
+	// if any. zstdDictSamples holds the data blocks sampled for training a
+	// dictionary, up to zstdDictSampleBudget bytes.
+	zstdDict             []byte
+	zstdDictTrainSize    int
+	zstdDictSamples      [][]byte
+	zstdDictSampleBudget int
+	// columnarSchema, if set, is the schema of the values of the data blocks,
+	// which are written in the columnar layout.
+	columnarSchema *ColumnarSchema
+	// cipher, if set, encrypts the blocks of the table with its data key.
+	// encryptionBlock is the contents of the block holding the wrapped data
+	// key.
+	cipher          *blockCipher
+	encryptionBlock []byte
 	// disableKeyOrderChecks disables the checks that keys are added to an
 	// sstable in order. It is intended for internal use only in the construction
 	// of invalid sstables for testing. See tool/make_test_sstables.go.
@@ -171,9 +191,12 @@ type Writer struct {
 	obsoleteCollector   obsoleteKeyBlockPropertyCollector
 	blockPropsEncoder   blockPropertiesEncoder
 	// filter accumulates the filter block. If populated, the filter ingests
-	// either the output of w.split (i.e. a prefix extractor) if w.split is not
-	// nil, or the full keys otherwise.
-	filter          filterWriter
+	// either the output of w.filterSplit (i.e. a prefix extractor) if
+	// w.filterSplit is not nil, or the full keys otherwise.
+	filter filterWriter
+	// filterSplit is the Split of WriterOptions.PrefixExtractor if set, and
+	// w.split otherwise.
+	filterSplit     Split
 	indexPartitions []indexBlockAndBlockProperties
 
 	// indexBlockAlloc is used to bulk-allocate byte slices used to store index
@@ -209,6 +232,8 @@ type Writer struct {
 	shortAttributeExtractor   base.ShortAttributeExtractor
 	requiredInPlaceValueBound UserKeyPrefixBound
 	valueBlockWriter          *valueBlockWriter
+	// blobHandleBuf is the scratch buffer of the encoded blob handles.
+	blobHandleBuf []byte
 }
 
 type pointKeyInfo struct {
@@ -563,6 +588,8 @@ func (c *checksummer) checksum(block []byte, blockType []byte) (checksum uint32)
 		c.xxHasher.Write(block)
 		c.xxHasher.Write(blockType)
 		checksum = uint32(c.xxHasher.Sum64())
+	case ChecksumTypeXXH3:
+		checksum = xxh3Checksum(block, blockType[0])
 	default:
 		panic(errors.Newf("unsupported checksum type: %d", c.checksumType))
 	}
@@ -578,6 +605,9 @@ type blockBuf struct {
 	// lifetime of the blockBuf, avoiding the allocation of a temporary buffer for each block.
 	compressedBuf []byte
 	checksummer   checksummer
+	// cipher, if set, encrypts blocks after compression, into encryptedBuf.
+	cipher       *blockCipher
+	encryptedBuf []byte
 }
 
 func (b *blockBuf) clear() {
@@ -586,6 +616,7 @@ func (b *blockBuf) clear() {
 	// to make an allocation.
 	*b = blockBuf{
 		compressedBuf: b.compressedBuf, checksummer: b.checksummer,
+		encryptedBuf: b.encryptedBuf,
 	}
 }
 
@@ -618,6 +649,14 @@ type dataBlockBuf struct {
 
 	// sepScratch is reusable scratch space for computing separator keys.
 	sepScratch []byte
+
+	// columnarBuf is reusable space for the columnar layout of the data block.
+	// When the data block is written in the columnar layout, uncompressed is
+	// backed by columnarBuf.
+	columnarBuf []byte
+	// columnar is set when uncompressed holds the columnar layout of the data
+	// block.
+	columnar bool
 }
 
 func (d *dataBlockBuf) clear() {
@@ -636,19 +675,31 @@ var dataBlockBufPool = sync.Pool{
 	},
 }
 
-func newDataBlockBuf(restartInterval int, checksumType ChecksumType) *dataBlockBuf {
+func newDataBlockBuf(
+	restartInterval int, checksumType ChecksumType, cipher *blockCipher,
+) *dataBlockBuf {
 	d := dataBlockBufPool.Get().(*dataBlockBuf)
 	d.dataBlock.restartInterval = restartInterval
 	d.checksummer.checksumType = checksumType
+	d.cipher = cipher
 	return d
 }
 
-func (d *dataBlockBuf) finish() {
+func (d *dataBlockBuf) finish(schema *ColumnarSchema, valuePrefix bool) {
 	d.uncompressed = d.dataBlock.finish()
+	d.columnar = schema != nil
+	if d.columnar {
+		d.columnarBuf = encodeColumnarBlock(d.columnarBuf[:0], d.uncompressed, schema, valuePrefix)
+		d.uncompressed = d.columnarBuf
+	}
 }
 
-func (d *dataBlockBuf) compressAndChecksum(c Compression) {
-	d.compressed = compressAndChecksum(d.uncompressed, c, &d.blockBuf)
+func (d *dataBlockBuf) compressAndChecksum(c Compression, zstdDict []byte) {
+	var flags blockType
+	if d.columnar {
+		flags = columnarBlockTypeFlag
+	}
+	d.compressed = compressWithDictAndChecksum(d.uncompressed, c, zstdDict, flags, &d.blockBuf)
 }
 
 func (d *dataBlockBuf) shouldFlush(
@@ -683,7 +734,7 @@ func (w *Writer) Set(key, value []byte) error {
 	}
 	// forceObsolete is false based on the assumption that no RANGEDELs in the
 	// sstable delete the added points.
-	return w.addPoint(base.MakeInternalKey(key, 0, InternalKeyKindSet), value, false)
+	return w.addPoint(base.MakeInternalKey(key, 0, InternalKeyKindSet), value, nil, false)
 }
 
 // Delete deletes the value for the given key. The sequence number is set to
@@ -700,7 +751,7 @@ func (w *Writer) Delete(key []byte) error {
 	}
 	// forceObsolete is false based on the assumption that no RANGEDELs in the
 	// sstable delete the added points.
-	return w.addPoint(base.MakeInternalKey(key, 0, InternalKeyKindDelete), nil, false)
+	return w.addPoint(base.MakeInternalKey(key, 0, InternalKeyKindDelete), nil, nil, false)
 }
 
 // DeleteRange deletes all of the keys (and values) in the range [start,end)
@@ -732,7 +783,7 @@ func (w *Writer) Merge(key, value []byte) error {
 	// forceObsolete is false based on the assumption that no RANGEDELs in the
 	// sstable that delete the added points. If the user configured this writer
 	// to be strict-obsolete, addPoint will reject the addition of this MERGE.
-	return w.addPoint(base.MakeInternalKey(key, 0, InternalKeyKindMerge), value, false)
+	return w.addPoint(base.MakeInternalKey(key, 0, InternalKeyKindMerge), value, nil, false)
 }
 
 // Add adds a key/value pair to the table being written. For a given Writer,
@@ -774,7 +825,74 @@ func (w *Writer) AddWithForceObsolete(key InternalKey, value []byte, forceObsole
 			"pebble: range keys must be added via one of the RangeKey* functions")
 		return w.err
 	}
-	return w.addPoint(key, value, forceObsolete)
+	return w.addPoint(key, value, nil, forceObsolete)
+}
+
+// AddBlobHandle adds a SET whose value is stored in a blob file to the table
+// being written. The handle locates the value within the blob files of the
+// DB, and is stored as is. It is only interpreted by the
+// ReaderOptions.BlobValueFetcher of the readers of the table. The value
+// length and the short attribute of the value are stored alongside the
+// handle, so that readers can access them without fetching the value.
+//
+// Blob handles require TableFormatPebblev3 or higher. Table property
+// collectors are passed nil values for the keys added with AddBlobHandle.
+func (w *Writer) AddBlobHandle(
+	key InternalKey, handle []byte, attr base.AttributeAndLen, forceObsolete bool,
+) error {
+	if w.err != nil {
+		return w.err
+	}
+	if key.Kind() != InternalKeyKindSet {
+		w.err = errors.Errorf("pebble: blob handles are only supported for SETs, not %s", key.Kind())
+		return w.err
+	}
+	if w.valueBlockWriter == nil {
+		w.err = errors.Errorf("pebble: blob handles are not supported in table format %s", w.tableFormat)
+		return w.err
+	}
+	return w.addPoint(key, nil, &blobValue{handle: handle, attr: attr}, forceObsolete)
+}
+
+// addBlobHandleWithValue is like AddBlobHandle, but also passes the value
+// referenced by the handle to the block property collectors that observe
+// values. It gets installed in private.
+func addBlobHandleWithValue(
+	w *Writer,
+	key InternalKey,
+	handle []byte,
+	attr base.AttributeAndLen,
+	value []byte,
+	forceObsolete bool,
+) error {
+	if w.err != nil {
+		return w.err
+	}
+	if key.Kind() != InternalKeyKindSet {
+		w.err = errors.Errorf("pebble: blob handles are only supported for SETs, not %s", key.Kind())
+		return w.err
+	}
+	if w.valueBlockWriter == nil {
+		w.err = errors.Errorf("pebble: blob handles are not supported in table format %s", w.tableFormat)
+		return w.err
+	}
+	return w.addPoint(key, nil, &blobValue{handle: handle, attr: attr, value: value}, forceObsolete)
+}
+
+// blobValue is the value of a point whose value is stored in a blob file.
+// value holds the value itself if the caller provided it, and is only passed
+// to the block property collectors that observe values.
+type blobValue struct {
+	handle []byte
+	attr   base.AttributeAndLen
+	value  []byte
+}
+
+// valueBlockPropertyCollector wraps a BlockPropertyCollector that is passed
+// the values of SETs by the Writer, even if the values are not stored in
+// place. See private.SSTableValueBlockPropertyCollector.
+type valueBlockPropertyCollector struct {
+	BlockPropertyCollector
 }
 
 func (w *Writer) makeAddPointDecisionV2(key InternalKey) error {
@@ -924,10 +1042,16 @@ func (w *Writer) makeAddPointDecisionV3(
 	return setHasSamePrefix, considerWriteToValueBlock, isObsolete, nil
 }
 
-func (w *Writer) addPoint(key InternalKey, value []byte, forceObsolete bool) error {
+// addPoint adds a point to the table. If blob is non-nil, the value of the
+// point is stored in a blob file, and value is nil.
+func (w *Writer) addPoint(key InternalKey, value []byte, blob *blobValue, forceObsolete bool) error {
 	if w.isStrictObsolete && key.Kind() == InternalKeyKindMerge {
 		return errors.Errorf("MERGE not supported in a strict-obsolete sstable")
 	}
+	valueLen := len(value)
+	if blob != nil {
+		valueLen = int(blob.attr.ValueLen)
+	}
 	var err error
 	var setHasSameKeyPrefix, writeToValueBlock, addPrefixToValueStoredWithKey bool
 	var isObsolete bool
@@ -938,7 +1062,7 @@ func (w *Writer) addPoint(key InternalKey, value []byte, forceObsolete bool) err
 		// ignore this maxSharedKeyLen.
 		maxSharedKeyLen = w.lastPointKeyInfo.prefixLen
 		setHasSameKeyPrefix, writeToValueBlock, isObsolete, err =
-			w.makeAddPointDecisionV3(key, len(value))
+			w.makeAddPointDecisionV3(key, valueLen)
 		addPrefixToValueStoredWithKey = base.TrailerKind(key.Trailer) == InternalKeyKindSet
 	} else {
 		err = w.makeAddPointDecisionV2(key)
@@ -946,12 +1070,21 @@ func (w *Writer) addPoint(key InternalKey, value []byte, forceObsolete bool) err
 	if err != nil {
 		return err
 	}
+	if w.disableKeyDeltaEncoding {
+		maxSharedKeyLen = 0
+	}
 	isObsolete = w.tableFormat >= TableFormatPebblev4 && (isObsolete || forceObsolete)
 	w.lastPointKeyInfo.isObsolete = isObsolete
 	var valueStoredWithKey []byte
 	var prefix valuePrefix
 	var valueStoredWithKeyLen int
-	if writeToValueBlock {
+	if blob != nil {
+		w.blobHandleBuf = binary.AppendUvarint(w.blobHandleBuf[:0], uint64(valueLen))
+		w.blobHandleBuf = append(w.blobHandleBuf, blob.handle...)
+		valueStoredWithKey = w.blobHandleBuf
+		valueStoredWithKeyLen = len(valueStoredWithKey) + 1
+		prefix = makePrefixForBlobHandle(setHasSameKeyPrefix, blob.attr.ShortAttribute)
+	} else if writeToValueBlock {
 		vh, err := w.valueBlockWriter.addValue(value)
 		if err != nil {
 			return err
@@ -992,7 +1125,11 @@ func (w *Writer) addPoint(key InternalKey, value []byte, forceObsolete bool) err
 	}
 	for i := range w.blockPropCollectors {
 		v := value
-		if addPrefixToValueStoredWithKey {
+		if _, ok := w.blockPropCollectors[i].(valueBlockPropertyCollector); ok {
+			if blob != nil {
+				v = blob.value
+			}
+		} else if addPrefixToValueStoredWithKey {
 			// Values for SET are not required to be in-place, and in the future may
 			// not even be read by the compaction, so pass nil values. Block
 			// property collectors in such Pebble DB's must not look at the value.
@@ -1050,8 +1187,11 @@ func (w *Writer) addPoint(key InternalKey, value []byte, forceObsolete bool) err
 	case InternalKeyKindMerge:
 		w.props.NumMergeOperands++
 	}
+	if blob != nil {
+		w.props.NumValuesInBlobFiles++
+	}
 	w.props.RawKeySize += uint64(key.Size())
-	w.props.RawValueSize += uint64(len(value))
+	w.props.RawValueSize += uint64(valueLen)
 	return nil
 }
 
@@ -1382,8 +1522,8 @@ func (w *Writer) tempRangeKeyCopy(k []byte) []byte {
 
 func (w *Writer) maybeAddToFilter(key []byte) {
 	if w.filter != nil {
-		if w.split != nil {
-			prefix := key[:w.split(key)]
+		if w.filterSplit != nil {
+			prefix := key[:w.filterSplit(key)]
 			w.filter.addKey(prefix)
 		} else {
 			w.filter.addKey(key)
@@ -1397,8 +1537,16 @@ func (w *Writer) flush(key InternalKey) error {
 	if err != nil {
 		return err
 	}
-	w.dataBlockBuf.finish()
-	w.dataBlockBuf.compressAndChecksum(w.compression)
+	w.dataBlockBuf.finish(w.columnarSchema, w.valueBlockWriter != nil)
+	if w.zstdDictSampleBudget > 0 {
+		n := len(w.dataBlockBuf.uncompressed)
+		if n > w.zstdDictSampleBudget {
+			n = w.zstdDictSampleBudget
+		}
+		w.zstdDictSamples = append(w.zstdDictSamples, append([]byte(nil), w.dataBlockBuf.uncompressed[:n]...))
+		w.zstdDictSampleBudget -= n
+	}
+	w.dataBlockBuf.compressAndChecksum(w.compression, w.zstdDict)
 	// Since dataBlockEstimates.addInflightDataBlock was never called, the
 	// inflightSize is set to 0.
 	w.coordination.sizeEstimate.dataBlockCompressed(len(w.dataBlockBuf.compressed), 0)
@@ -1462,7 +1610,7 @@ func (w *Writer) flush(key InternalKey) error {
 	} else {
 		err = w.coordination.writeQueue.addSync(writeTask)
 	}
-	w.dataBlockBuf = newDataBlockBuf(w.restartInterval, w.checksumType)
+	w.dataBlockBuf = newDataBlockBuf(w.restartInterval, w.checksumType, w.cipher)
 
 	return err
 }
@@ -1752,9 +1900,19 @@ func (w *Writer) writeTwoLevelIndex() (BlockHandle, error) {
 }
 
 func compressAndChecksum(b []byte, compression Compression, blockBuf *blockBuf) []byte {
+	return compressWithDictAndChecksum(b, compression, nil /* zstdDict */, 0 /* flags */, blockBuf)
+}
+
+// compressWithDictAndChecksum is like compressAndChecksum, but compresses the
+// block with the zstd dictionary zstdDict if it is non-nil and the compression
+// is ZstdCompression. The block is encrypted after compression if
+// blockBuf.cipher is set.
+func compressWithDictAndChecksum(
+	b []byte, compression Compression, zstdDict []byte, flags blockType, blockBuf *blockBuf,
+) []byte {
 	// Compress the buffer, discarding the result if the improvement isn't at
 	// least 12.5%.
-	blockType, compressed := compressBlock(compression, b, blockBuf.compressedBuf)
+	blockType, compressed := compressBlockWithDict(compression, zstdDict, b, blockBuf.compressedBuf)
 	if blockType != noCompressionBlockType && cap(compressed) > cap(blockBuf.compressedBuf) {
 		blockBuf.compressedBuf = compressed[:cap(compressed)]
 	}
@@ -1763,8 +1921,13 @@ func compressAndChecksum(b []byte, compression Compression, blockBuf *blockBuf)
 	} else {
 		blockType = noCompressionBlockType
 	}
+	if blockBuf.cipher != nil {
+		blockBuf.encryptedBuf = blockBuf.cipher.seal(blockBuf.encryptedBuf[:0], b)
+		b = blockBuf.encryptedBuf
+		flags |= encryptedBlockTypeFlag
+	}
 
-	blockBuf.tmp[0] = byte(blockType)
+	blockBuf.tmp[0] = byte(blockType | flags)
 
 	// Calculate the checksum.
 	checksum := blockBuf.checksummer.checksum(b, blockBuf.tmp[:1])
@@ -1824,6 +1987,16 @@ func (w *Writer) writeBlock(
 	return w.writeCompressedBlock(b, blockBuf.tmp[:])
 }
 
+// writeUnencryptedBlock is like writeBlock with NoCompression, but doesn't
+// encrypt the block even if the table is encrypted. It's used for the blocks
+// read to obtain the table's data key.
+func (w *Writer) writeUnencryptedBlock(b []byte) (BlockHandle, error) {
+	c := w.blockBuf.cipher
+	w.blockBuf.cipher = nil
+	defer func() { w.blockBuf.cipher = c }()
+	return w.writeBlock(b, NoCompression, &w.blockBuf)
+}
+
 // assertFormatCompatibility ensures that the features present on the table are
 // compatible with the table format version.
 func (w *Writer) assertFormatCompatibility() error {
@@ -2013,6 +2186,17 @@ func (w *Writer) Close() (err error) {
 		}
 	}
 
+	// Write the encryption block, whose name sorts before the other pebble
+	// meta blocks.
+	if w.cipher != nil {
+		bh, err := w.writeUnencryptedBlock(w.encryptionBlock)
+		if err != nil {
+			return err
+		}
+		n := encodeBlockHandle(w.blockBuf.tmp[:], bh)
+		metaindex.add(InternalKey{UserKey: []byte(metaEncryptionName)}, w.blockBuf.tmp[:n])
+	}
+
 	if w.valueBlockWriter != nil {
 		vbiHandle, vbStats, err := w.valueBlockWriter.finish(w, w.meta.Size)
 		if err != nil {
@@ -2036,6 +2220,17 @@ func (w *Writer) Close() (err error) {
 		metaindex.add(InternalKey{UserKey: []byte(metaRangeKeyName)}, w.blockBuf.tmp[:n])
 	}
 
+	// Write the zstd dictionary block, which sorts after the other pebble meta
+	// blocks.
+	if w.zstdDict != nil {
+		bh, err := w.writeBlock(w.zstdDict, NoCompression, &w.blockBuf)
+		if err != nil {
+			return err
+		}
+		n := encodeBlockHandle(w.blockBuf.tmp[:], bh)
+		metaindex.add(InternalKey{UserKey: []byte(metaZstdDictName)}, w.blockBuf.tmp[:n])
+	}
+
 	{
 		userProps := make(map[string]string)
 		for i := range w.propCollectors {
@@ -2097,8 +2292,9 @@ func (w *Writer) Close() (err error) {
 	// Write the metaindex block. It might be an empty block, if the filter
 	// policy is nil. NoCompression is specified because a) RocksDB never
 	// compresses the meta-index block and b) RocksDB has some code paths which
-	// expect the meta-index block to not be compressed.
-	metaindexBH, err := w.writeBlock(metaindex.blockWriter.finish(), NoCompression, &w.blockBuf)
+	// expect the meta-index block to not be compressed. It's never encrypted,
+	// as it locates the encryption block.
+	metaindexBH, err := w.writeUnencryptedBlock(metaindex.blockWriter.finish())
 	if err != nil {
 		return err
 	}
@@ -2136,6 +2332,11 @@ func (w *Writer) Close() (err error) {
 	indexBlockBufPool.Put(w.indexBlock)
 	w.indexBlock = nil
 
+	if w.zstdDictTrainSize > 0 {
+		w.meta.TrainedZstdDictionary = TrainZstdDictionary(w.zstdDictSamples, w.zstdDictTrainSize)
+		w.zstdDictSamples = nil
+	}
+
 	// Make any future calls to Set or Close return an error.
 	w.err = errWriterClosed
 	return nil
@@ -2218,6 +2419,7 @@ func NewWriter(writable objstorage.Writable, o WriterOptions, extraOpts ...Write
 		writingToLowestLevel:    o.WritingToLowestLevel,
 		cache:                   o.Cache,
 		restartInterval:         o.BlockRestartInterval,
+		disableKeyDeltaEncoding: o.DisableKeyDeltaEncoding,
 		checksumType:            o.Checksum,
 		indexBlock:              newIndexBlockBuf(o.Parallelism),
 		rangeDelBlock: blockWriter{
@@ -2234,19 +2436,41 @@ func NewWriter(writable objstorage.Writable, o WriterOptions, extraOpts ...Write
 			Format: o.Comparer.FormatKey,
 		},
 	}
+	if o.KeyManager != nil {
+		var err error
+		if w.cipher, w.encryptionBlock, err = newWriterBlockCipher(o.KeyManager); err != nil {
+			w.err = err
+		}
+	}
 	if w.tableFormat >= TableFormatPebblev3 {
 		w.shortAttributeExtractor = o.ShortAttributeExtractor
 		w.requiredInPlaceValueBound = o.RequiredInPlaceValueBound
 		w.valueBlockWriter = newValueBlockWriter(
-			w.blockSize, w.blockSizeThreshold, w.compression, w.checksumType, func(compressedSize int) {
+			o.ValueBlockSize, w.blockSizeThreshold, o.ValueBlockCompression, w.checksumType, w.cipher, func(compressedSize int) {
 				w.coordination.sizeEstimate.dataBlockCompressed(compressedSize, 0)
 			})
 	}
 
-	w.dataBlockBuf = newDataBlockBuf(w.restartInterval, w.checksumType)
+	if o.Compression == ZstdCompression && len(o.ZstdDictionary) > 0 {
+		w.zstdDict = o.ZstdDictionary
+	}
+	if o.TrainZstdDictionarySize > 0 {
+		w.zstdDictTrainSize = o.TrainZstdDictionarySize
+		w.zstdDictSampleBudget = zstdDictSampleRatio * o.TrainZstdDictionarySize
+	}
+	if o.ColumnarSchema != nil {
+		if err := o.ColumnarSchema.validate(); err != nil {
+			w.err = err
+		}
+		w.columnarSchema = o.ColumnarSchema
+		w.props.ColumnarDataBlocks = true
+	}
+
+	w.dataBlockBuf = newDataBlockBuf(w.restartInterval, w.checksumType, w.cipher)
 
 	w.blockBuf = blockBuf{
 		checksummer: checksummer{checksumType: o.Checksum},
+		cipher:      w.cipher,
 	}
 
 	w.coordination.init(o.Parallelism, w)
@@ -2271,7 +2495,12 @@ func NewWriter(writable objstorage.Writable, o WriterOptions, extraOpts ...Write
 		switch o.FilterType {
 		case TableFilter:
 			w.filter = newTableFilterWriter(o.FilterPolicy)
-			if w.split != nil {
+			w.filterSplit = w.split
+			if o.PrefixExtractor != nil {
+				w.filterSplit = o.PrefixExtractor.Split
+				w.props.PrefixExtractorName = o.PrefixExtractor.Name
+				w.props.PrefixFiltering = true
+			} else if w.split != nil {
 				w.props.PrefixExtractorName = o.Comparer.Name
 				w.props.PrefixFiltering = true
 			} else {
@@ -2365,6 +2594,10 @@ func init() {
 		w.disableKeyOrderChecks = true
 	}
 	private.SSTableInternalProperties = internalGetProperties
+	private.SSTableValueBlockPropertyCollector = func(c BlockPropertyCollector) BlockPropertyCollector {
+		return valueBlockPropertyCollector{c}
+	}
+	private.SSTableWriterAddBlobHandle = addBlobHandleWithValue
 }
 
 type obsoleteKeyBlockPropertyCollector struct {
@@ -412,6 +412,198 @@ func testBlockBufClear(t *testing.T, b1, b2 *blockBuf) {
 	require.Equal(t, b1.tmp, b2.tmp)
 }
 
+func TestWriterDisableKeyDeltaEncoding(t *testing.T) {
+	for _, format := range []TableFormat{TableFormatPebblev2, TableFormatPebblev4} {
+		t.Run(format.String(), func(t *testing.T) {
+			write := func(disable bool) []byte {
+				f := &memFile{}
+				w := NewWriter(f, WriterOptions{
+					BlockSize:               256,
+					Comparer:                testkeys.Comparer,
+					DisableKeyDeltaEncoding: disable,
+					TableFormat:             format,
+				})
+				for i := 0; i < 100; i++ {
+					for j := 3; j >= 1; j-- {
+						k := []byte(fmt.Sprintf("a-long-shared-key-prefix-%03d@%d", i, j))
+						require.NoError(t, w.Set(k, []byte("value")))
+					}
+				}
+				require.NoError(t, w.Close())
+				return f.Data()
+			}
+			delta, full := write(false), write(true)
+			require.Greater(t, len(full), len(delta))
+
+			r, err := NewMemReader(full, ReaderOptions{Comparer: testkeys.Comparer})
+			require.NoError(t, err)
+			defer r.Close()
+			iter, err := r.NewIter(nil, nil)
+			require.NoError(t, err)
+			defer iter.Close()
+			// Step through the versions of every other key, skipping to the
+			// next prefix for the rest.
+			i := 0
+			for k, _ := iter.First(); k != nil; i++ {
+				require.Equal(t, fmt.Sprintf("a-long-shared-key-prefix-%03d@3", i), string(k.UserKey))
+				if i%2 == 0 {
+					k, _ = iter.NextPrefix([]byte(fmt.Sprintf("a-long-shared-key-prefix-%03d\x00", i)))
+					continue
+				}
+				for j := 2; j >= 1; j-- {
+					k, _ = iter.Next()
+					require.Equal(t, fmt.Sprintf("a-long-shared-key-prefix-%03d@%d", i, j), string(k.UserKey))
+				}
+				k, _ = iter.Next()
+			}
+			require.Equal(t, 100, i)
+			k, _ := iter.SeekGE([]byte("a-long-shared-key-prefix-050@2"), base.SeekGEFlagsNone)
+			require.Equal(t, "a-long-shared-key-prefix-050@2", string(k.UserKey))
+		})
+	}
+}
+
+func TestWriterValueBlockCompression(t *testing.T) {
+	f := &memFile{}
+	w := NewWriter(f, WriterOptions{
+		BlockSize:             4096,
+		Comparer:              testkeys.Comparer,
+		Compression:           SnappyCompression,
+		ValueBlockCompression: ZstdCompression,
+		ValueBlockSize:        1024,
+		TableFormat:           TableFormatPebblev3,
+	})
+	value := bytes.Repeat([]byte("compressible value "), 10)
+	for i := 0; i < 100; i++ {
+		// The older version of each key is stored in a value block.
+		for j := 2; j >= 1; j-- {
+			k := []byte(fmt.Sprintf("key%03d@%d", i, j))
+			require.NoError(t, w.Set(k, value))
+		}
+	}
+	require.NoError(t, w.Close())
+
+	sst := f.Data()
+	r, err := NewMemReader(sst, ReaderOptions{Comparer: testkeys.Comparer})
+	require.NoError(t, err)
+	defer r.Close()
+	layout, err := r.Layout()
+	require.NoError(t, err)
+	blockTypeOf := func(bh BlockHandle) blockType {
+		return blockType(sst[bh.Offset+bh.Length])
+	}
+	for _, bh := range layout.Data {
+		require.Equal(t, snappyCompressionBlockType, blockTypeOf(bh.BlockHandle))
+	}
+	// The value blocks are compressed with zstd, and are smaller than the data
+	// blocks.
+	require.Greater(t, len(layout.ValueBlock), len(layout.Data))
+	for _, bh := range layout.ValueBlock {
+		require.Equal(t, zstdCompressionBlockType, blockTypeOf(bh))
+	}
+
+	iter, err := r.NewIter(nil, nil)
+	require.NoError(t, err)
+	defer iter.Close()
+	n := 0
+	for k, v := iter.First(); k != nil; k, v = iter.Next() {
+		got, _, err := v.Value(nil)
+		require.NoError(t, err)
+		require.Equal(t, value, got)
+		n++
+	}
+	require.Equal(t, 200, n)
+}
+
+// testBlobValueFetcher fetches the values of the blob handles of
+// TestWriterBlobHandles, which are the keys of its map.
+type testBlobValueFetcher map[string][]byte
+
+func (f testBlobValueFetcher) Fetch(
+	handle []byte, valLen int32, buf []byte,
+) (val []byte, callerOwned bool, err error) {
+	v, ok := f[string(handle)]
+	if !ok || len(v) != int(valLen) {
+		return nil, false, errors.Newf("unknown blob handle %q", handle)
+	}
+	return append(buf[:0], v...), true, nil
+}
+
+func TestWriterBlobHandles(t *testing.T) {
+	f := &memFile{}
+	w := NewWriter(f, WriterOptions{
+		BlockSize:   256,
+		Comparer:    testkeys.Comparer,
+		TableFormat: TableFormatPebblev3,
+	})
+	values := make(map[string][]byte)
+	fetcher := make(testBlobValueFetcher)
+	for i := 0; i < 100; i++ {
+		// Alternate between in-place values, values in value blocks and values
+		// in blob files.
+		for j := 2; j >= 1; j-- {
+			k := fmt.Sprintf("key%03d@%d", i, j)
+			v := []byte(fmt.Sprintf("value%03d-%d", i, j))
+			values[k] = v
+			if (i+j)%2 == 0 {
+				require.NoError(t, w.Set([]byte(k), v))
+				continue
+			}
+			handle := fmt.Sprintf("handle-%s", k)
+			fetcher[handle] = v
+			require.NoError(t, w.AddBlobHandle(base.MakeInternalKey([]byte(k), 0, InternalKeyKindSet),
+				[]byte(handle), base.AttributeAndLen{ValueLen: int32(len(v)), ShortAttribute: 5}, false))
+		}
+	}
+	require.NoError(t, w.Close())
+
+	read := func(opts ReaderOptions) (n int, err error) {
+		opts.Comparer = testkeys.Comparer
+		r, err := NewMemReader(f.Data(), opts)
+		require.NoError(t, err)
+		defer r.Close()
+		require.Equal(t, uint64(100), r.Properties.NumValuesInBlobFiles)
+		iter, err := r.NewIter(nil, nil)
+		require.NoError(t, err)
+		defer iter.Close()
+		for k, v := iter.First(); k != nil; k, v = iter.Next() {
+			require.Equal(t, len(values[string(k.UserKey)]), v.Len())
+			if _, ok := fetcher["handle-"+string(k.UserKey)]; ok {
+				attr, ok := v.TryGetShortAttribute()
+				require.True(t, ok)
+				require.Equal(t, base.ShortAttribute(5), attr)
+			}
+			got, _, err := v.Value(nil)
+			if err != nil {
+				return n, err
+			}
+			require.Equal(t, values[string(k.UserKey)], got)
+			n++
+		}
+		return n, nil
+	}
+	n, err := read(ReaderOptions{BlobValueFetcher: fetcher})
+	require.NoError(t, err)
+	require.Equal(t, 200, n)
+	// The values in blob files can't be read without a blob value fetcher.
+	_, err = read(ReaderOptions{})
+	require.Error(t, err)
+
+	// Blob handles are only supported for SETs, and require value prefixes.
+	for _, tc := range []struct {
+		format TableFormat
+		kind   InternalKeyKind
+	}{
+		{TableFormatPebblev3, InternalKeyKindMerge},
+		{TableFormatPebblev2, InternalKeyKindSet},
+	} {
+		w = NewWriter(&memFile{}, WriterOptions{TableFormat: tc.format})
+		require.Error(t, w.AddBlobHandle(base.MakeInternalKey([]byte("a"), 0, tc.kind),
+			[]byte("handle"), base.AttributeAndLen{ValueLen: 1}, false))
+		require.Error(t, w.Close())
+	}
+}
+
 func TestBlockBufClear(t *testing.T) {
 	b1 := &blockBuf{}
 	b1.tmp[0] = 1
@@ -421,7 +613,7 @@ func TestBlockBufClear(t *testing.T) {
 }
 
 func TestClearDataBlockBuf(t *testing.T) {
-	d := newDataBlockBuf(1, ChecksumTypeCRC32c)
+	d := newDataBlockBuf(1, ChecksumTypeCRC32c, nil /* cipher */)
 	d.blockBuf.compressedBuf = make([]byte, 1)
 	d.dataBlock.add(ikey("apple"), nil)
 	d.dataBlock.add(ikey("banana"), nil)
@@ -0,0 +1,96 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+const (
+	// zstdDictSegmentLen is the length of the segments of the samples from
+	// which TrainZstdDictionary assembles a dictionary.
+	zstdDictSegmentLen = 64
+	// zstdDictDmerLen is the length of the substrings whose frequency across
+	// the samples scores the segments.
+	zstdDictDmerLen = 8
+	// zstdDictSampleRatio is the ratio of the size of the samples to the size
+	// of the dictionary trained from them: Writer samples up to
+	// zstdDictSampleRatio times WriterOptions.TrainZstdDictionarySize bytes
+	// of its data blocks.
+	zstdDictSampleRatio = 100
+)
+
+// TrainZstdDictionary trains a zstd dictionary of up to size bytes from the
+// samples, which are typically uncompressed data blocks. The dictionary is a
+// raw content dictionary: the concatenation of the segments of the samples
+// whose substrings are the most frequent across the samples, with the most
+// frequent last, where zstd finds them at the smallest offsets. It returns
+// nil if the samples are too small to train a dictionary.
+func TrainZstdDictionary(samples [][]byte, size int) []byte {
+	// Count the occurrences of each dmer across the samples.
+	freqs := make(map[uint64]int)
+	for _, s := range samples {
+		for i := 0; i+zstdDictDmerLen <= len(s); i++ {
+			freqs[binary.LittleEndian.Uint64(s[i:])]++
+		}
+	}
+
+	type segment struct {
+		data  []byte
+		score int
+	}
+	var segments []segment
+	for _, s := range samples {
+		for i := 0; i+zstdDictSegmentLen <= len(s); i += zstdDictSegmentLen {
+			data := s[i : i+zstdDictSegmentLen]
+			score := 0
+			for j := 0; j+zstdDictDmerLen <= len(data); j++ {
+				// Dmers occurring once across the samples are of no use to
+				// a dictionary.
+				if f := freqs[binary.LittleEndian.Uint64(data[j:])]; f > 1 {
+					score += f
+				}
+			}
+			if score > 0 {
+				segments = append(segments, segment{data: data, score: score})
+			}
+		}
+	}
+	sort.SliceStable(segments, func(i, j int) bool {
+		return segments[i].score > segments[j].score
+	})
+
+	// Select the segments with the highest scores, skipping segments whose
+	// dmers are all covered by the segments already selected.
+	covered := make(map[uint64]struct{})
+	var selected [][]byte
+	n := 0
+	for _, seg := range segments {
+		if n+len(seg.data) > size {
+			break
+		}
+		novel := false
+		for j := 0; j+zstdDictDmerLen <= len(seg.data); j++ {
+			dmer := binary.LittleEndian.Uint64(seg.data[j:])
+			if _, ok := covered[dmer]; !ok {
+				covered[dmer] = struct{}{}
+				novel = true
+			}
+		}
+		if novel {
+			selected = append(selected, seg.data)
+			n += len(seg.data)
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+	dict := make([]byte, 0, n)
+	for i := len(selected) - 1; i >= 0; i-- {
+		dict = append(dict, selected[i]...)
+	}
+	return dict
+}
@@ -0,0 +1,75 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestZstdDictionary(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	// Small values that are similar to one another compress poorly within a
+	// block, but well with a dictionary trained from other blocks.
+	value := func() []byte {
+		return []byte(fmt.Sprintf(`{"user_id":%d,"status":"active","region":"us-east-%d","plan":"premium"}`,
+			rng.Intn(1e9), rng.Intn(4)))
+	}
+	write := func(opts WriterOptions) (*WriterMetadata, []byte, [][]byte) {
+		f := &memFile{}
+		w := NewWriter(f, opts)
+		var values [][]byte
+		for i := 0; i < 20000; i++ {
+			v := value()
+			require.NoError(t, w.Set([]byte(fmt.Sprintf("key%08d", i)), v))
+			values = append(values, v)
+		}
+		require.NoError(t, w.Close())
+		meta, err := w.Metadata()
+		require.NoError(t, err)
+		return meta, f.Data(), values
+	}
+
+	opts := WriterOptions{
+		BlockSize:               1 << 10,
+		Compression:             ZstdCompression,
+		TableFormat:             TableFormatPebblev2,
+		TrainZstdDictionarySize: 16 << 10,
+	}
+	meta, _, _ := write(opts)
+	dict := meta.TrainedZstdDictionary
+	require.NotNil(t, dict)
+	require.LessOrEqual(t, len(dict), 16<<10)
+
+	opts.TrainZstdDictionarySize = 0
+	_, plain, _ := write(opts)
+	opts.ZstdDictionary = dict
+	_, sst, values := write(opts)
+	require.Less(t, len(sst), len(plain)*9/10)
+
+	r, err := NewMemReader(sst, ReaderOptions{})
+	require.NoError(t, err)
+	require.Equal(t, dict, r.zstdDict)
+	iter, err := r.NewIter(nil, nil)
+	require.NoError(t, err)
+	i := 0
+	for k, v := iter.First(); k != nil; k, v = iter.Next() {
+		got, _, err := v.Value(nil)
+		require.NoError(t, err)
+		require.Equal(t, values[i], got)
+		i++
+	}
+	require.Equal(t, len(values), i)
+	require.NoError(t, iter.Close())
+	require.NoError(t, r.Close())
+}
+
+func TestTrainZstdDictionaryTooSmall(t *testing.T) {
+	require.Nil(t, TrainZstdDictionary(nil, 1<<10))
+	require.Nil(t, TrainZstdDictionary([][]byte{[]byte("short")}, 1<<10))
+}
@@ -0,0 +1,282 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultSubscriptionBufferSize is the default value of
+// Options.SubscriptionBufferSize.
+const defaultSubscriptionBufferSize = 16 << 20 /* 16MB */
+
+// CommittedBatch is a batch committed to a DB, returned by a Subscription.
+type CommittedBatch struct {
+	// SeqNum is the sequence number of the first entry of the batch. The
+	// entries of the batch have consecutive sequence numbers.
+	SeqNum uint64
+	// Count is the number of entries in the batch.
+	Count uint32
+	// Repr is the batch representation (see Batch.Repr). It may be shared
+	// with other subscriptions, and must not be modified.
+	Repr []byte
+}
+
+// Reader returns a BatchReader over the entries of the batch. The entries are
+// point writes, range deletions and range keys; the values of range keys are
+// encoded as in the memtable (see the rangekey package).
+func (b *CommittedBatch) Reader() BatchReader {
+	r, _ := ReadBatch(b.Repr)
+	return r
+}
+
+// subscriptionBuffer buffers the batches recently committed to a DB while
+// subscriptions are open, so that subscriptions that keep up with the commits
+// don't read them back from the WAL.
+type subscriptionBuffer struct {
+	// open is the number of open subscriptions. Batches are only buffered, and
+	// subscriptions only notified, while it's positive.
+	open    atomic.Int32
+	maxSize int64
+
+	mu struct {
+		sync.Mutex
+		// batches are the buffered batches, in sequence number order. All the
+		// batches committed with a sequence number of at least start, other
+		// than those applied directly to sstables (such as ingestions), are
+		// buffered.
+		batches []*CommittedBatch
+		start   uint64
+		size    int64
+		// notifyCh is closed, and cleared, when batches become visible.
+		notifyCh chan struct{}
+	}
+}
+
+// add buffers a batch being committed. It's called before the batch is
+// published, so that by the time a batch is visible, all the batches that
+// precede it are buffered.
+func (s *subscriptionBuffer) add(b *Batch) {
+	if s.open.Load() == 0 || s.maxSize <= 0 || b.Count() == 0 {
+		// Batches without entries, such as those only holding LogData
+		// records, aren't returned by subscriptions.
+		return
+	}
+	repr := b.Repr()
+	cb := &CommittedBatch{SeqNum: b.SeqNum(), Count: b.Count(), Repr: append([]byte(nil), repr...)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cb.SeqNum < s.mu.start {
+		// A batch that was being committed when buffering started.
+		return
+	}
+	// Batches are applied concurrently, so they're nearly, but not
+	// necessarily, added in order.
+	i := len(s.mu.batches)
+	for i > 0 && s.mu.batches[i-1].SeqNum > cb.SeqNum {
+		i--
+	}
+	s.mu.batches = append(s.mu.batches, nil)
+	copy(s.mu.batches[i+1:], s.mu.batches[i:])
+	s.mu.batches[i] = cb
+	s.mu.size += int64(len(cb.Repr))
+	// Drop the oldest batches beyond the maximum size. Subscriptions that
+	// haven't read them yet read them from the WAL.
+	n := 0
+	for s.mu.size > s.maxSize && n < len(s.mu.batches)-1 {
+		old := s.mu.batches[n]
+		s.mu.size -= int64(len(old.Repr))
+		s.mu.start = old.SeqNum + uint64(old.Count)
+		s.mu.batches[n] = nil
+		n++
+	}
+	s.mu.batches = s.mu.batches[n:]
+}
+
+// covers returns true if all the batches with a sequence number of at least
+// seqNum are buffered.
+func (s *subscriptionBuffer) covers(seqNum uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maxSize > 0 && seqNum >= s.mu.start
+}
+
+// notify wakes the subscriptions waiting for batches, once a batch is visible.
+func (s *subscriptionBuffer) notify() {
+	if s.open.Load() == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.mu.notifyCh != nil {
+		close(s.mu.notifyCh)
+		s.mu.notifyCh = nil
+	}
+}
+
+// Subscribe returns a Subscription to the batches committed to the DB with a
+// sequence number of at least startSeqNum, which are returned in sequence
+// number order. A startSeqNum of zero subscribes to all the batches still in
+// the WAL. The batches committed while subscriptions are open are buffered in
+// memory (see Options.SubscriptionBufferSize); subscriptions to earlier
+// batches, or that fall behind the buffer, catch up by reading the WAL and the
+// WAL archive (see WALTailer), and then continue with the buffered batches.
+//
+// The catch-up only returns the batches written to the WAL: batches committed
+// with WriteOptions.DisableWAL are only returned if they're buffered, and
+// batches in WAL files that are no longer available are skipped. Without a
+// WAL archive, WAL files are deleted once flushed, and a subscription that
+// falls behind the flushes and the buffer fails. Ingestions, excises and
+// other operations that apply sstables directly consume sequence numbers
+// without any batch being returned for them.
+func (d *DB) Subscribe(startSeqNum uint64) *Subscription {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	s := &d.subscriptions
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.open.Add(1) == 1 {
+		// Start buffering. The batches that are allocated a sequence number
+		// from now on see the open subscription when they're applied, and are
+		// buffered.
+		s.mu.start = d.mu.versions.logSeqNum.Load()
+	}
+	return &Subscription{d: d, next: startSeqNum}
+}
+
+// Subscription is a subscription to the batches committed to a DB (see
+// DB.Subscribe). A Subscription is not safe for concurrent use, and must be
+// closed.
+type Subscription struct {
+	d *DB
+	// next is the lowest sequence number of the next batch to return.
+	next uint64
+	// tailer, if set, reads the batches from the WAL, while the subscription
+	// is behind the buffered batches.
+	tailer *WALTailer
+	closed bool
+}
+
+// Next returns the next committed batch, waiting for one to be committed if
+// needed. It returns ctx.Err() if ctx is done first, and ErrClosed if the DB
+// is closed first.
+func (s *Subscription) Next(ctx context.Context) (*CommittedBatch, error) {
+	d := s.d
+	buf := &d.subscriptions
+	for {
+		if err := d.closed.Load(); err != nil {
+			return nil, ErrClosed
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		// Grab the notification channel before checking for batches, so that
+		// batches published in between aren't missed.
+		buf.mu.Lock()
+		if buf.mu.notifyCh == nil {
+			buf.mu.notifyCh = make(chan struct{})
+		}
+		notifyCh := buf.mu.notifyCh
+		buf.mu.Unlock()
+
+		if b, err := s.read(); b != nil || err != nil {
+			return b, err
+		}
+		select {
+		case <-notifyCh:
+		case <-ctx.Done():
+		case <-d.closedCh:
+		}
+	}
+}
+
+// read returns the next batch that's visible, if any.
+func (s *Subscription) read() (*CommittedBatch, error) {
+	buf := &s.d.subscriptions
+	visible := s.d.mu.versions.visibleSeqNum.Load()
+	if s.tailer == nil {
+		buf.mu.Lock()
+		if buf.maxSize > 0 && s.next >= buf.mu.start {
+			defer buf.mu.Unlock()
+			batches := buf.mu.batches
+			i := sort.Search(len(batches), func(i int) bool { return batches[i].SeqNum >= s.next })
+			if i == len(batches) || batches[i].SeqNum+uint64(batches[i].Count) > visible {
+				return nil, nil
+			}
+			b := batches[i]
+			s.next = b.SeqNum + uint64(b.Count)
+			return b, nil
+		}
+		buf.mu.Unlock()
+		// The batches aren't buffered; read them from the WAL.
+		s.tailer = s.d.NewWALTailer(WALPosition{})
+	}
+	for retried := false; ; {
+		b, err := s.tailer.Next()
+		if err != nil {
+			return nil, err
+		}
+		if b == nil && !retried {
+			// The tailer stops at the end of a WAL file that was the last one
+			// when it was opened, even if a WAL file was created since. Reading
+			// again moves on to that WAL file.
+			retried = true
+			continue
+		}
+		if b == nil {
+			// All the visible batches in the WAL were read. Continue with the
+			// buffered batches if they follow.
+			if buf.covers(s.next) {
+				err := s.tailer.Close()
+				s.tailer = nil
+				if err != nil {
+					return nil, err
+				}
+				return s.read()
+			}
+			return nil, nil
+		}
+		if b.SeqNum < s.next || b.Count == 0 {
+			continue
+		}
+		r := b.Reader()
+		if kind, _, _, ok := r.Next(); ok && kind == InternalKeyKindIngestSST {
+			// The record of an ingestion of sstables applied as a flushable.
+			s.next = b.SeqNum + uint64(b.Count)
+			continue
+		}
+		s.next = b.SeqNum + uint64(b.Count)
+		return &CommittedBatch{
+			SeqNum: b.SeqNum,
+			Count:  b.Count,
+			Repr:   append([]byte(nil), b.Repr...),
+		}, nil
+	}
+}
+
+// Close closes the Subscription.
+func (s *Subscription) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	var err error
+	if s.tailer != nil {
+		err = s.tailer.Close()
+	}
+	buf := &s.d.subscriptions
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+	if buf.open.Add(-1) == 0 {
+		// Stop buffering.
+		buf.mu.batches = nil
+		buf.mu.size = 0
+	}
+	return err
+}
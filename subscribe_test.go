@@ -0,0 +1,129 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscribe(t *testing.T) {
+	for _, bufferSize := range []int64{0, 64, -1} {
+		t.Run(fmt.Sprintf("buffer=%d", bufferSize), func(t *testing.T) {
+			opts := &Options{
+				FS:                     vfs.NewMem(),
+				Comparer:               testkeys.Comparer,
+				FormatMajorVersion:     FormatNewest,
+				SubscriptionBufferSize: bufferSize,
+			}
+			opts.WALArchive.Dir = "archive"
+			d, err := Open("", opts)
+			require.NoError(t, err)
+			defer func() { require.NoError(t, d.Close()) }()
+			d.testingAlwaysWaitForCleanup = true
+
+			// Each batch i holds a set of key i, and every third batch also
+			// holds a range deletion and a range key.
+			var seqNums []uint64
+			write := func(i int) {
+				b := d.NewBatch()
+				key := []byte(fmt.Sprintf("%04d", i))
+				require.NoError(t, b.Set(key, key, nil))
+				if i%3 == 0 {
+					require.NoError(t, b.DeleteRange(key, append(key, 'a'), nil))
+					require.NoError(t, b.RangeKeySet(key, append(key, 'a'), nil, key, nil))
+				}
+				require.NoError(t, b.Commit(nil))
+				seqNums = append(seqNums, b.SeqNum())
+				if i%10 == 9 {
+					// Flush, so that the subscriptions catching up read the
+					// archived WAL files.
+					require.NoError(t, d.Flush())
+				}
+			}
+			// check checks the contents of batch i, and records its sequence
+			// number, which is checked once the writes are done.
+			check := func(b *CommittedBatch, i int, got *[]uint64) {
+				t.Helper()
+				*got = append(*got, b.SeqNum)
+				var kinds []InternalKeyKind
+				r := b.Reader()
+				for {
+					kind, ukey, _, ok := r.Next()
+					if !ok {
+						break
+					}
+					require.Equal(t, fmt.Sprintf("%04d", i), string(ukey))
+					kinds = append(kinds, kind)
+				}
+				if i%3 == 0 {
+					require.Equal(t, []InternalKeyKind{
+						InternalKeyKindSet, InternalKeyKindRangeDelete, InternalKeyKindRangeKeySet,
+					}, kinds)
+				} else {
+					require.Equal(t, []InternalKeyKind{InternalKeyKindSet}, kinds)
+				}
+			}
+
+			for i := 0; i < 25; i++ {
+				write(i)
+			}
+			ctx := context.Background()
+			var allSeqNums, laterSeqNums []uint64
+			// A subscription from the start catches up from the WAL.
+			all := d.Subscribe(0)
+			defer func() { require.NoError(t, all.Close()) }()
+			// A subscription from a later batch skips the earlier ones.
+			later := d.Subscribe(seqNums[20])
+			defer func() { require.NoError(t, later.Close()) }()
+			for i := 0; i < 25; i++ {
+				b, err := all.Next(ctx)
+				require.NoError(t, err)
+				check(b, i, &allSeqNums)
+			}
+			for i := 20; i < 25; i++ {
+				b, err := later.Next(ctx)
+				require.NoError(t, err)
+				check(b, i, &laterSeqNums)
+			}
+
+			// The subscriptions wait for batches committed concurrently.
+			done := make(chan struct{})
+			// Wait for the writes before closing the DB, even on failures.
+			defer func() { <-done }()
+			go func() {
+				defer close(done)
+				for i := 25; i < 100; i++ {
+					write(i)
+				}
+			}()
+			for i := 25; i < 100; i++ {
+				b, err := all.Next(ctx)
+				require.NoError(t, err)
+				check(b, i, &allSeqNums)
+			}
+			<-done
+			for i := 25; i < 100; i++ {
+				b, err := later.Next(ctx)
+				require.NoError(t, err)
+				check(b, i, &laterSeqNums)
+			}
+			require.Equal(t, seqNums, allSeqNums)
+			require.Equal(t, seqNums[20:], laterSeqNums)
+
+			// Next returns once the context is done.
+			ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+			defer cancel()
+			_, err = all.Next(ctx)
+			require.ErrorIs(t, err, context.DeadlineExceeded)
+		})
+	}
+}
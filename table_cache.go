@@ -13,7 +13,6 @@ import (
 	"runtime/pprof"
 	"sync"
 	"sync/atomic"
-	"unsafe"
 
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/base"
@@ -68,6 +67,18 @@ type tableCacheOpts struct {
 	objProvider     objstorage.Provider
 	opts            sstable.ReaderOptions
 	filterMetrics   *sstable.FilterMetricsTracker
+	// cacheStats accumulates the block cache lookups of the DB's sstable
+	// reads. The lookups of iterators over a level of the LSM are recorded in
+	// the level's element, and all others in the last element.
+	cacheStats *[numLevels + 1]sstable.CacheStats
+	// ioScheduler, if set, grants bandwidth to sstable reads.
+	ioScheduler IOScheduler
+	// memoryMonitor, if set, is notified of the sstable readers opened and
+	// closed for the DB.
+	memoryMonitor MemoryMonitor
+	// recordRead, if set, is called when an iterator for a read other than a
+	// compaction is opened on a table.
+	recordRead func(*manifest.FileMetadata)
 }
 
 // tableCacheContainer contains the table cache and
@@ -104,7 +115,11 @@ func newTableCacheContainer(
 	t.dbOpts.objProvider = objProvider
 	t.dbOpts.opts = opts.MakeReaderOptions()
 	t.dbOpts.filterMetrics = &sstable.FilterMetricsTracker{}
+	t.dbOpts.cacheStats = new([numLevels + 1]sstable.CacheStats)
+	t.dbOpts.opts.CacheStats = &t.dbOpts.cacheStats[numLevels]
 	t.dbOpts.iterCount = new(atomic.Int32)
+	t.dbOpts.ioScheduler = opts.IOScheduler
+	t.dbOpts.memoryMonitor = opts.MemoryMonitor
 	return t
 }
 
@@ -163,11 +178,37 @@ func (c *tableCacheContainer) metrics() (CacheMetrics, FilterMetrics) {
 		m.Hits += s.hits.Load()
 		m.Misses += s.misses.Load()
 	}
-	m.Size = m.Count * int64(unsafe.Sizeof(sstable.Reader{}))
+	m.Size = m.Count * tableCacheReaderSize
 	f := c.dbOpts.filterMetrics.Load()
 	return m, f
 }
 
+// blockCacheMetrics returns the block cache lookups of the DB's sstable reads
+// by level, with the lookups not made by iterators over a level in the last
+// element.
+func (c *tableCacheContainer) blockCacheMetrics() [numLevels + 1]BlockCacheMetricsByKind {
+	var m [numLevels + 1]BlockCacheMetricsByKind
+	for i := range m {
+		m[i] = c.dbOpts.cacheStats[i].Metrics()
+	}
+	return m
+}
+
+// shrink closes readers of the DB held open by the table cache, until the
+// estimated memory of the closed readers is at least n bytes, and returns the
+// estimated memory of the closed readers. Readers in use by iterators are
+// closed once the iterators are closed.
+func (c *tableCacheContainer) shrink(n int64) int64 {
+	var released int64
+	for _, s := range c.tableCache.shards {
+		if released >= n {
+			break
+		}
+		released += s.shrink(n-released, &c.dbOpts)
+	}
+	return released
+}
+
 func (c *tableCacheContainer) estimateSize(
 	meta *fileMetadata, lower, upper []byte,
 ) (size uint64, err error) {
@@ -194,6 +235,29 @@ func (c *tableCacheContainer) estimateSize(
 	return size, nil
 }
 
+// cacheResidency returns the residency of the index and filter blocks of the
+// file's backing sstable in the block cache.
+func (c *tableCacheContainer) cacheResidency(meta *fileMetadata) (res CacheResidency, err error) {
+	if meta.Virtual {
+		err = c.withVirtualReader(
+			meta.VirtualMeta(),
+			func(r sstable.VirtualReader) error {
+				res = r.CacheResidency()
+				return nil
+			},
+		)
+	} else {
+		err = c.withReader(
+			meta.PhysicalMeta(),
+			func(r *sstable.Reader) error {
+				res = r.CacheResidency()
+				return nil
+			},
+		)
+	}
+	return res, err
+}
+
 func (c *tableCacheContainer) withReader(meta physicalMeta, fn func(*sstable.Reader) error) error {
 	s := c.tableCache.getShard(meta.FileBacking.DiskFileNum)
 	v := s.findNode(meta.FileMetadata, &c.dbOpts)
@@ -227,6 +291,8 @@ type TableCache struct {
 
 	cache  *Cache
 	shards []*tableCacheShard
+	// resizeMu serializes calls to SetSize.
+	resizeMu sync.Mutex
 }
 
 // Ref adds a reference to the table cache. Once tableCache.init returns,
@@ -290,6 +356,22 @@ func NewTableCache(cache *Cache, numShards int, size int) *TableCache {
 	return c
 }
 
+// SetSize sets the number of sstables the table cache may hold open, which may
+// be used to rebalance memory between the table cache and other uses while the
+// cache is in use by DBs. If the cache holds more sstables than the new size,
+// sstables are evicted until it fits. Evicted sstables in use by iterators are
+// closed once the iterators are closed.
+func (c *TableCache) SetSize(size int) {
+	if size == 0 {
+		panic("pebble: cannot set the size of a table cache to 0")
+	}
+	c.resizeMu.Lock()
+	defer c.resizeMu.Unlock()
+	for i := range c.shards {
+		c.shards[i].setSize(size / len(c.shards))
+	}
+}
+
 func (c *TableCache) getShard(fileNum base.DiskFileNum) *tableCacheShard {
 	return c.shards[uint64(fileNum.FileNum())%uint64(len(c.shards))]
 }
@@ -304,6 +386,8 @@ type tableCacheShard struct {
 	misses    atomic.Int64
 	iterCount atomic.Int32
 
+	// size is the number of sstables the shard may hold open. It's protected
+	// by mu once the shard is initialized.
 	size int
 
 	mu struct {
@@ -424,8 +508,16 @@ func (c *tableCacheShard) newIters(
 	var filterer *sstable.BlockPropertiesFilterer
 	var err error
 	if opts != nil {
+		boundLimitedFilter := internalOpts.boundLimitedFilter
+		if file.PrefixReplacement != nil {
+			// The bound-limited filter compares the keys of the index blocks of
+			// the sstable, which aren't translated into the keyspace of the
+			// virtual sstable, with the bounds of the iterator. It only
+			// optimizes away blocks, so it can be ignored.
+			boundLimitedFilter = nil
+		}
 		ok, filterer, err = c.checkAndIntersectFilters(v, opts.TableFilter,
-			pointKeyFilters, internalOpts.boundLimitedFilter)
+			pointKeyFilters, boundLimitedFilter)
 	}
 	if err != nil {
 		c.unrefValue(v)
@@ -434,7 +526,7 @@ func (c *tableCacheShard) newIters(
 
 	type iterCreator interface {
 		NewRawRangeDelIter() (keyspan.FragmentIterator, error)
-		NewIterWithBlockPropertyFiltersAndContextEtc(ctx context.Context, lower, upper []byte, filterer *sstable.BlockPropertiesFilterer, hideObsoletePoints, useFilterBlock bool, stats *base.InternalIteratorStats, rp sstable.ReaderProvider) (sstable.Iterator, error)
+		NewIterWithBlockPropertyFiltersAndContextEtc(ctx context.Context, lower, upper []byte, filterer *sstable.BlockPropertiesFilterer, hideObsoletePoints, useFilterBlock bool, stats *base.InternalIteratorStats, cacheStats *sstable.CacheStats, rp sstable.ReaderProvider, bufferPool *sstable.BufferPool) (sstable.Iterator, error)
 		NewCompactionIter(
 			bytesIterated *uint64,
 			rp sstable.ReaderProvider,
@@ -484,9 +576,11 @@ func (c *tableCacheShard) newIters(
 
 	var iter sstable.Iterator
 	useFilter := true
+	var cacheStats *sstable.CacheStats
 	if opts != nil {
 		useFilter = manifest.LevelToInt(opts.level) != 6 || opts.UseL6Filters
 		ctx = objiotracing.WithLevel(ctx, manifest.LevelToInt(opts.level))
+		cacheStats = &dbOpts.cacheStats[manifest.LevelToInt(opts.level)]
 	}
 	tableFormat, err := v.reader.TableFormat()
 	if err != nil {
@@ -508,7 +602,7 @@ func (c *tableCacheShard) newIters(
 	} else {
 		iter, err = ic.NewIterWithBlockPropertyFiltersAndContextEtc(
 			ctx, opts.GetLowerBound(), opts.GetUpperBound(), filterer, hideObsoletePoints, useFilter,
-			internalOpts.stats, rp)
+			internalOpts.stats, cacheStats, rp, internalOpts.bufferPool)
 	}
 	if err != nil {
 		if rangeDelIter != nil {
@@ -520,6 +614,9 @@ func (c *tableCacheShard) newIters(
 	// NB: v.closeHook takes responsibility for calling unrefValue(v) here. Take
 	// care to avoid introducing an allocation here by adding a closure.
 	iter.SetCloseHook(v.closeHook)
+	if internalOpts.bytesIterated == nil && dbOpts.recordRead != nil {
+		dbOpts.recordRead(file)
+	}
 
 	c.iterCount.Add(1)
 	dbOpts.iterCount.Add(1)
@@ -845,6 +942,19 @@ func (c *tableCacheShard) addNode(n *tableCacheNode, dbOpts *tableCacheOpts) {
 	}
 }
 
+func (c *tableCacheShard) setSize(size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.size = size
+	if c.mu.coldTarget > size {
+		c.mu.coldTarget = size
+	}
+	c.evictNodes()
+	for c.size < c.mu.sizeTest && c.mu.handTest != nil {
+		c.runHandTest()
+	}
+}
+
 func (c *tableCacheShard) evictNodes() {
 	for c.size <= c.mu.sizeHot+c.mu.sizeCold && c.mu.handCold != nil {
 		c.runHandCold()
@@ -952,6 +1062,29 @@ func (c *tableCacheShard) evict(fileNum base.DiskFileNum, dbOpts *tableCacheOpts
 	dbOpts.opts.Cache.EvictFile(dbOpts.cacheID, fileNum)
 }
 
+// shrink releases nodes of the DB associated with dbOpts.cacheID holding an
+// open reader, until the estimated memory of the released readers is at least
+// n bytes, and returns the estimated memory of the released readers.
+func (c *tableCacheShard) shrink(n int64, dbOpts *tableCacheOpts) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var nodes []*tableCacheNode
+	var released int64
+	for _, node := range c.mu.nodes {
+		if released >= n {
+			break
+		}
+		if node.cacheID == dbOpts.cacheID && node.value != nil {
+			nodes = append(nodes, node)
+			released += tableCacheReaderSize
+		}
+	}
+	for _, node := range nodes {
+		c.releaseNode(node)
+	}
+	return released
+}
+
 // removeDB evicts any nodes which have a reference to the DB
 // associated with dbOpts.cacheID. Make sure that there will
 // be no more accesses to the files associated with the DB.
@@ -1041,6 +1174,9 @@ type tableCacheValue struct {
 	// Reference count for the value. The reader is closed when the reference
 	// count drops to zero.
 	refCount atomic.Int32
+	// memoryMonitor, if set, was notified of the opening of the reader, and
+	// is notified of its closing.
+	memoryMonitor MemoryMonitor
 }
 
 type loadInfo struct {
@@ -1057,6 +1193,9 @@ func (v *tableCacheValue) load(loadInfo loadInfo, c *tableCacheShard, dbOpts *ta
 		context.TODO(), fileTypeTable, loadInfo.backingFileNum, objstorage.OpenOptions{MustExist: true},
 	)
 	if err == nil {
+		if dbOpts.ioScheduler != nil {
+			f = &ioScheduledReadable{Readable: f, scheduler: dbOpts.ioScheduler}
+		}
 		cacheOpts := private.SSTableCacheOpts(dbOpts.cacheID, loadInfo.backingFileNum).(sstable.ReaderOption)
 		v.reader, err = sstable.NewReader(f, dbOpts.opts, cacheOpts, dbOpts.filterMetrics)
 	}
@@ -1067,6 +1206,10 @@ func (v *tableCacheValue) load(loadInfo loadInfo, c *tableCacheShard, dbOpts *ta
 	if v.err == nil && loadInfo.smallestSeqNum == loadInfo.largestSeqNum {
 		v.reader.Properties.GlobalSeqNum = loadInfo.largestSeqNum
 	}
+	if v.err == nil && dbOpts.memoryMonitor != nil {
+		v.memoryMonitor = dbOpts.memoryMonitor
+		v.memoryMonitor.Reserve(MemoryKindTableCache, tableCacheReaderSize)
+	}
 	if v.err != nil {
 		c.mu.Lock()
 		defer c.mu.Unlock()
@@ -1087,6 +1230,9 @@ func (v *tableCacheValue) release(c *tableCacheShard) {
 	// open.
 	if v.reader != nil {
 		_ = v.reader.Close()
+		if v.memoryMonitor != nil {
+			v.memoryMonitor.Release(MemoryKindTableCache, tableCacheReaderSize)
+		}
 	}
 	c.releasing.Done()
 }
@@ -1021,6 +1021,56 @@ func TestTableCacheErrorBadMagicNumber(t *testing.T) {
 		err.Error())
 }
 
+func TestTableCacheSetSize(t *testing.T) {
+	c, fs, err := newTableCacheContainerTest(nil, "")
+	require.NoError(t, err)
+
+	numOpen := func() (n int) {
+		fs.mu.Lock()
+		defer fs.mu.Unlock()
+		for name, o := range fs.openCounts {
+			if o > fs.closeCounts[name] {
+				n++
+			}
+		}
+		return n
+	}
+	open := func(n int) {
+		for i := 0; i < n; i++ {
+			m := &fileMetadata{FileNum: FileNum(i)}
+			m.InitPhysicalBacking()
+			iter, _, err := c.newIters(context.Background(), m, nil, internalIterOpts{})
+			require.NoError(t, err)
+			require.NoError(t, iter.Close())
+		}
+	}
+
+	open(tableCacheTestCacheSize)
+	require.Equal(t, tableCacheTestCacheSize, numOpen())
+
+	// Shrinking the cache closes tables until it fits.
+	c.tableCache.SetSize(10)
+	require.NoError(t, try(100*time.Microsecond, 20*time.Second, func() error {
+		if n := numOpen(); n > 10 {
+			return errors.Errorf("%d tables open, want <= 10", n)
+		}
+		return nil
+	}))
+	open(tableCacheTestCacheSize)
+	require.NoError(t, try(100*time.Microsecond, 20*time.Second, func() error {
+		if n := numOpen(); n > 10 {
+			return errors.Errorf("%d tables open, want <= 10", n)
+		}
+		return nil
+	}))
+
+	// Growing the cache allows more tables to be held open again.
+	c.tableCache.SetSize(tableCacheTestCacheSize)
+	open(tableCacheTestCacheSize / 2)
+	require.Greater(t, numOpen(), 10)
+	fs.validate(t, c, nil)
+}
+
 func TestTableCacheEvictClose(t *testing.T) {
 	errs := make(chan error, 10)
 	db, err := Open("test",
@@ -1082,6 +1132,7 @@ func TestTableCacheClockPro(t *testing.T) {
 	dbOpts.cacheID = 0
 	dbOpts.objProvider = objProvider
 	dbOpts.opts = opts.MakeReaderOptions()
+	dbOpts.cacheStats = new([numLevels + 1]sstable.CacheStats)
 
 	scanner := bufio.NewScanner(f)
 	tables := make(map[int]bool)
@@ -284,6 +284,7 @@ func (d *DB) loadTableStats(
 		meta, func(r *sstable.Reader) (err error) {
 			stats.NumEntries = r.Properties.NumEntries
 			stats.NumDeletions = r.Properties.NumDeletions
+			stats.NumRangeDeletions = r.Properties.NumRangeDeletions
 			if r.Properties.NumPointDeletions() > 0 {
 				if err = d.loadTablePointKeyStats(r, v, level, meta, &stats); err != nil {
 					return
@@ -301,6 +302,7 @@ func (d *DB) loadTableStats(
 			// picking.
 			stats.NumRangeKeySets = r.Properties.NumRangeKeySets
 			stats.ValueBlocksSize = r.Properties.ValueBlocksSize
+			stats.TimestampUpperBound, err = loadTableTimestampUpperBound(&r.Properties)
 			return
 		})
 	if err != nil {
@@ -631,6 +633,13 @@ func maybeSetStatsFromProperties(meta physicalMeta, props *sstable.Properties) b
 		return false
 	}
 
+	// A corrupt timestamp property is left to the table stats collector,
+	// which reports the error.
+	timestampUpperBound, err := loadTableTimestampUpperBound(props)
+	if err != nil {
+		return false
+	}
+
 	var pointEstimate uint64
 	if props.NumEntries > 0 {
 		// Use the file's own average key and value sizes as an estimate. This
@@ -643,10 +652,12 @@ func maybeSetStatsFromProperties(meta physicalMeta, props *sstable.Properties) b
 
 	meta.Stats.NumEntries = props.NumEntries
 	meta.Stats.NumDeletions = props.NumDeletions
+	meta.Stats.NumRangeDeletions = props.NumRangeDeletions
 	meta.Stats.NumRangeKeySets = props.NumRangeKeySets
 	meta.Stats.PointDeletionsBytesEstimate = pointEstimate
 	meta.Stats.RangeDeletionsBytesEstimate = 0
 	meta.Stats.ValueBlocksSize = props.ValueBlocksSize
+	meta.Stats.TimestampUpperBound = timestampUpperBound
 	meta.StatsMarkValid()
 	return true
 }
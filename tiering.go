@@ -0,0 +1,118 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import "time"
+
+// TieringOptions configures the automatic migration of sstables in the
+// bottommost level between local storage and remote storage
+// (Options.Experimental.RemoteStorage), based on how they are read.
+//
+// Local sstables that have not been read for DemoteAfter are demoted: a
+// compaction rewrites them in place, creating its outputs on remote storage.
+// Remote sstables that have been read PromoteAfterReads times are promoted
+// back onto local storage the same way. Since a migration is a compaction,
+// the MANIFEST records the replacement of the migrated sstables, and the
+// remote object catalog records which of them reside on remote storage.
+// Other compactions into the bottommost level create their outputs on local
+// storage, which are demoted once they go unread.
+//
+// Reads are tracked in memory: an sstable is read when an iterator for any
+// read other than a compaction is opened on it. After the DB is reopened, no
+// sstable is demoted until it has gone unread for DemoteAfter since the DB
+// was opened, and the read counts of remote sstables start from zero.
+// Sstables shared by other DBs, and external sstables, are never migrated.
+//
+// Remote sstables can only be created once the DB's creator ID has been set
+// with DB.SetCreatorID. Tiering cannot be combined with
+// Experimental.CreateOnShared for the bottommost level, which already places
+// all of its sstables on remote storage.
+type TieringOptions struct {
+	// DemoteAfter is the duration for which a local sstable in the bottommost
+	// level must go unread, and have existed, before it is demoted. Tiering is
+	// enabled only if DemoteAfter is positive.
+	DemoteAfter time.Duration
+
+	// PromoteAfterReads is the number of reads of a remote sstable in the
+	// bottommost level after which it is promoted. If zero, sstables are
+	// never promoted.
+	PromoteAfterReads int64
+
+	// CheckInterval is the interval at which the DB checks for sstables to
+	// migrate. Checking scans the metadata of every sstable in the bottommost
+	// level.
+	//
+	// The default value is 1 minute.
+	CheckInterval time.Duration
+}
+
+func (o *TieringOptions) enabled() bool {
+	return o.DemoteAfter > 0
+}
+
+// tieringEnv holds the state used by the compaction picker to pick tiering
+// compactions.
+type tieringEnv struct {
+	// readsTrackedSince is the time at which the DB began tracking the reads
+	// of sstables.
+	readsTrackedSince time.Time
+	// isRemote returns whether the sstable resides on remote storage, and
+	// false for ok if the sstable may not be migrated.
+	isRemote func(f *fileMetadata) (remote, ok bool)
+	// scanned is set by the picker when it found no sstable to migrate.
+	scanned bool
+}
+
+// demotable returns true if the local sstable should be demoted at the
+// provided time.
+func (o *TieringOptions) demotable(f *fileMetadata, readsTrackedSince, now time.Time) bool {
+	lastAccess := readsTrackedSince.Unix()
+	if f.CreationTime > lastAccess {
+		lastAccess = f.CreationTime
+	}
+	if t := f.LastReadTime.Load(); t > lastAccess {
+		lastAccess = t
+	}
+	return !time.Unix(lastAccess, 0).Add(o.DemoteAfter).After(now)
+}
+
+// promotable returns true if the remote sstable should be promoted.
+func (o *TieringOptions) promotable(f *fileMetadata) bool {
+	return o.PromoteAfterReads > 0 && f.ReadCount.Load() >= o.PromoteAfterReads
+}
+
+// recordTableRead records a read of the sstable for
+// Options.Experimental.Tiering.
+func (d *DB) recordTableRead(f *fileMetadata) {
+	f.LastReadTime.Store(d.timeNow().Unix())
+	f.ReadCount.Add(1)
+}
+
+// tableIsRemote implements tieringEnv.isRemote.
+func (d *DB) tableIsRemote(f *fileMetadata) (remote, ok bool) {
+	meta, err := d.objProvider.Lookup(fileTypeTable, f.FileBacking.DiskFileNum)
+	if err != nil {
+		return false, false
+	}
+	if meta.IsExternal() || (meta.IsShared() && d.objProvider.IsSharedForeign(meta)) {
+		return false, false
+	}
+	return meta.IsRemote(), true
+}
+
+// tieringEnvLocked returns the tieringEnv for picking compactions at the
+// provided time, or nil if the DB is not due to check for sstables to
+// migrate.
+//
+// d.mu must be held when calling this.
+func (d *DB) tieringEnvLocked(now time.Time) *tieringEnv {
+	if !d.opts.Experimental.Tiering.enabled() || now.Before(d.mu.compact.nextTieringCheck) {
+		return nil
+	}
+	return &tieringEnv{
+		readsTrackedSince: d.openedAt,
+		isRemote:          d.tableIsRemote,
+	}
+}
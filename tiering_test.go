@@ -0,0 +1,115 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/objstorage/remote"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTieringCompaction(t *testing.T) {
+	var opts Options
+	opts.FS = vfs.NewMem()
+	opts.Experimental.RemoteStorage = remote.MakeSimpleFactory(map[remote.Locator]remote.Storage{
+		"": remote.NewInMem(),
+	})
+	opts.Experimental.Tiering = TieringOptions{
+		DemoteAfter:       24 * time.Hour,
+		PromoteAfterReads: 3,
+	}
+	d, err := Open("", &opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	require.NoError(t, d.SetCreatorID(1))
+	var offset atomic.Int64
+	d.mu.Lock()
+	d.timeNow = func() time.Time { return time.Now().Add(time.Duration(offset.Load())) }
+	d.mu.Unlock()
+
+	require.NoError(t, d.Set([]byte("a"), []byte("a"), nil))
+	require.NoError(t, d.Set([]byte("b"), []byte("b"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("a"), []byte("c"), false))
+
+	// isRemote returns whether the single file in the bottommost level is on
+	// remote storage.
+	isRemote := func() bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		files := d.mu.versions.currentVersion().Levels[numLevels-1].Slice()
+		require.Equal(t, 1, files.Len())
+		iter := files.Iter()
+		remote, ok := d.tableIsRemote(iter.First())
+		require.True(t, ok)
+		return remote
+	}
+	// check forces a check for files to migrate, and waits for any tiering
+	// compaction it schedules.
+	check := func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.mu.compact.nextTieringCheck = time.Time{}
+		d.maybeScheduleCompaction()
+		for d.mu.compact.compactingCount > 0 {
+			d.mu.compact.cond.Wait()
+		}
+	}
+
+	// A recently written file is not demoted.
+	check()
+	require.Zero(t, d.Metrics().Compact.TieringCount)
+	require.False(t, isRemote())
+
+	// A file that is read is not demoted until it goes unread for
+	// DemoteAfter.
+	offset.Store(int64(20 * time.Hour))
+	verifyGet(t, d, []byte("a"), []byte("a"))
+	offset.Store(int64(30 * time.Hour))
+	check()
+	require.Zero(t, d.Metrics().Compact.TieringCount)
+	require.False(t, isRemote())
+
+	offset.Store(int64(45 * time.Hour))
+	check()
+	require.EqualValues(t, 1, d.Metrics().Compact.TieringCount)
+	require.True(t, isRemote())
+	verifyGet(t, d, []byte("a"), []byte("a"))
+	verifyGet(t, d, []byte("b"), []byte("b"))
+
+	// The demoted file is promoted once it has been read PromoteAfterReads
+	// times. The promoted file is recorded with the real creation time, so
+	// stop the clock from running ahead to keep it from being demoted again.
+	offset.Store(0)
+	check()
+	require.EqualValues(t, 1, d.Metrics().Compact.TieringCount)
+	verifyGet(t, d, []byte("a"), []byte("a"))
+	check()
+	require.EqualValues(t, 2, d.Metrics().Compact.TieringCount)
+	require.False(t, isRemote())
+	verifyGet(t, d, []byte("a"), []byte("a"))
+	verifyGet(t, d, []byte("b"), []byte("b"))
+}
+
+func TestTieringOptionsValidate(t *testing.T) {
+	opts := &Options{}
+	opts.Experimental.Tiering.DemoteAfter = time.Hour
+	opts.EnsureDefaults()
+	require.Error(t, opts.Validate())
+
+	opts.Experimental.RemoteStorage = remote.MakeSimpleFactory(map[remote.Locator]remote.Storage{
+		"": remote.NewInMem(),
+	})
+	require.NoError(t, opts.Validate())
+
+	opts.Experimental.CreateOnShared = true
+	require.Error(t, opts.Validate())
+	opts.Experimental.CreateOnSharedMinLevel = numLevels
+	require.NoError(t, opts.Validate())
+}
@@ -339,6 +339,10 @@ func (f *findT) searchLogs(stdout io.Writer, searchKey []byte, refs []findRef) [
 					return err
 				}
 
+				if _, ok := pebble.DecodeWALCommitTime(buf.Bytes()); ok {
+					continue
+				}
+
 				b = pebble.Batch{}
 				if err := b.SetRepr(buf.Bytes()); err != nil {
 					fmt.Fprintf(stdout, "%s: corrupt log file: %v", path, err)
@@ -9,6 +9,7 @@ import (
 	"github.com/cockroachdb/pebble/bloom"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/objstorage/remote"
+	"github.com/cockroachdb/pebble/ribbon"
 	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/spf13/cobra"
@@ -103,7 +104,7 @@ func New(opts ...Option) *T {
 
 	opts = append(opts,
 		Comparers(base.DefaultComparer),
-		Filters(bloom.FilterPolicy(10)),
+		Filters(bloom.FilterPolicy(10), ribbon.FilterPolicy(10)),
 		Mergers(base.DefaultMerger))
 
 	for _, opt := range opts {
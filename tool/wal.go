@@ -9,6 +9,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/cockroachdb/pebble"
 	"github.com/cockroachdb/pebble/internal/base"
@@ -117,6 +118,12 @@ func (w *walT) runDump(cmd *cobra.Command, args []string) {
 					return
 				}
 
+				if t, ok := pebble.DecodeWALCommitTime(buf.Bytes()); ok {
+					fmt.Fprintf(stdout, "%d(%d) commit-time=%s\n",
+						offset, buf.Len(), t.UTC().Format(time.RFC3339Nano))
+					continue
+				}
+
 				b = pebble.Batch{}
 				if err := b.SetRepr(buf.Bytes()); err != nil {
 					fmt.Fprintf(stdout, "corrupt log file %q: %v", arg, err)
@@ -0,0 +1,193 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/private"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// ttlTimestampPropertyName is the name of the block property collector that
+// records the interval of timestamps extracted by TTLOptions.TimestampExtractor.
+const ttlTimestampPropertyName = "pebble.ttl.timestamp"
+
+var wrapValueBlockPropertyCollector = private.SSTableValueBlockPropertyCollector.(func(sstable.BlockPropertyCollector) sstable.BlockPropertyCollector)
+
+// TTLOptions configures the expiration of keys based on a timestamp extracted
+// from each key-value pair.
+//
+// Keys whose timestamp is at least TTL in the past are removed when a
+// compaction rewrites them, subject to the same restrictions as a
+// CompactionFilter: keys visible to an open snapshot, MERGE operands and keys
+// in memtables are never removed. A block property collector records the
+// timestamps in each sstable, and sstables in L1 and below whose newest
+// timestamp has expired are compacted promptly rather than waiting for a
+// compaction to rewrite them. Expired keys remain visible to readers until
+// then. Recording the timestamps requires a FormatMajorVersion of at least
+// FormatBlockPropertyCollector; with older formats, expired keys are only
+// removed by compactions that rewrite them for other reasons. A compaction
+// that would move an sstable whose keys have all expired to another level
+// rewrites it instead, so that its keys are removed.
+type TTLOptions struct {
+	// TimestampExtractor returns the timestamp of a point key-value pair, or
+	// false if the pair has no timestamp and never expires. Timestamps before
+	// the Unix epoch are treated as the Unix epoch. The key and value must not
+	// be retained or modified.
+	TimestampExtractor func(key, value []byte) (timestamp time.Time, ok bool)
+
+	// TTL is the duration after its timestamp at which a key expires. Keys
+	// are only expired if both TTL and TimestampExtractor are set.
+	TTL time.Duration
+
+	// CheckInterval is the interval at which the DB checks for sstables whose
+	// keys have expired when no other event schedules a compaction.
+	//
+	// The default value is 1 minute.
+	CheckInterval time.Duration
+}
+
+func (o *TTLOptions) enabled() bool {
+	return o.TimestampExtractor != nil && o.TTL > 0
+}
+
+// expired returns true if all of the keys of an sstable whose timestamps
+// have the provided exclusive upper bound, in seconds since the Unix epoch,
+// have expired at the provided time.
+func (o *TTLOptions) expired(timestampUpperBound uint64, now time.Time) bool {
+	if timestampUpperBound == 0 {
+		return false
+	}
+	return !time.Unix(int64(timestampUpperBound), 0).Add(o.TTL).After(now)
+}
+
+// ttlUnixSeconds converts a timestamp to seconds since the Unix epoch.
+func ttlUnixSeconds(t time.Time) uint64 {
+	if s := t.Unix(); s > 0 {
+		return uint64(s)
+	}
+	return 0
+}
+
+// ttlIntervalCollector implements sstable.DataBlockIntervalCollector,
+// collecting the interval of timestamps, in seconds since the Unix epoch, of
+// the point keys in each data block.
+type ttlIntervalCollector struct {
+	extract     func(key, value []byte) (time.Time, bool)
+	lower       uint64
+	upper       uint64
+	initialized bool
+}
+
+var _ sstable.DataBlockIntervalCollector = (*ttlIntervalCollector)(nil)
+
+// newTTLBlockPropertyCollector returns the block property collector that
+// records the timestamps of the point keys of the sstables written by the DB.
+// Writers don't pass the values of SETs to block property collectors in table
+// formats that store values outside of data blocks, so the collector is
+// wrapped to observe the values regardless, including the values that blob
+// outputs separate into blob files.
+func newTTLBlockPropertyCollector(o *TTLOptions) sstable.BlockPropertyCollector {
+	return wrapValueBlockPropertyCollector(sstable.NewBlockIntervalCollector(ttlTimestampPropertyName,
+		&ttlIntervalCollector{extract: o.TimestampExtractor}, nil /* rangeCollector */))
+}
+
+// Add implements sstable.DataBlockIntervalCollector.
+func (c *ttlIntervalCollector) Add(key InternalKey, value []byte) error {
+	switch key.Kind() {
+	case InternalKeyKindSet, InternalKeyKindSetWithDelete:
+	default:
+		return nil
+	}
+	t, ok := c.extract(key.UserKey, value)
+	if !ok {
+		return nil
+	}
+	s := ttlUnixSeconds(t)
+	if !c.initialized || s < c.lower {
+		c.lower = s
+	}
+	if !c.initialized || s+1 > c.upper {
+		c.upper = s + 1
+	}
+	c.initialized = true
+	return nil
+}
+
+// FinishDataBlock implements sstable.DataBlockIntervalCollector.
+func (c *ttlIntervalCollector) FinishDataBlock() (lower, upper uint64, err error) {
+	lower, upper = c.lower, c.upper
+	*c = ttlIntervalCollector{extract: c.extract}
+	return lower, upper, nil
+}
+
+// loadTableTimestampUpperBound returns the exclusive upper bound of the
+// timestamps recorded by the TTL block property collector in the table's
+// properties, or zero if the table has no timestamped keys or was written
+// without the collector.
+func loadTableTimestampUpperBound(props *sstable.Properties) (uint64, error) {
+	prop, ok := props.UserProperties[ttlTimestampPropertyName]
+	if !ok {
+		return 0, nil
+	}
+	if len(prop) < 1 {
+		return 0, base.CorruptionErrorf("block properties for %s is corrupted", ttlTimestampPropertyName)
+	}
+	_, upper, err := sstable.DecodeBlockInterval([]byte(prop[1:]))
+	if err != nil {
+		return 0, errors.Wrapf(err, "decoding %s", ttlTimestampPropertyName)
+	}
+	return upper, nil
+}
+
+// expiredInputsLocked returns true if all of the keys of one of the input
+// files of the compaction c have expired under Options.TTL, per the table
+// stats of the files. Files whose stats haven't been loaded yet are assumed
+// not to have expired.
+//
+// d.mu must be held when calling this.
+func (d *DB) expiredInputsLocked(c *compaction) bool {
+	ttl := &d.opts.TTL
+	if !ttl.enabled() {
+		return false
+	}
+	for _, cl := range c.inputs {
+		iter := cl.files.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if f.StatsValid() && ttl.expired(f.Stats.TimestampUpperBound, c.beganAt) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// compactionFilter returns the filter applied to the point keys rewritten by
+// the compaction c. It combines the expiration of keys configured by
+// Options.TTL with Options.CompactionFilter, and is nil if neither is
+// configured.
+func (d *DB) compactionFilter(c *compaction) CompactionFilter {
+	if c.kind == compactionKindFlush {
+		return nil
+	}
+	filter := d.opts.CompactionFilter
+	ttl := &d.opts.TTL
+	if !ttl.enabled() {
+		return filter
+	}
+	now := d.timeNow()
+	return func(level int, key, value []byte) (CompactionFilterDecision, []byte) {
+		if t, ok := ttl.TimestampExtractor(key, value); ok && !t.Add(ttl.TTL).After(now) {
+			return CompactionFilterRemove, nil
+		}
+		if filter == nil {
+			return CompactionFilterKeep, nil
+		}
+		return filter(level, key, value)
+	}
+}
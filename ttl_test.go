@@ -0,0 +1,181 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+// ttlTestExtractor interprets values as decimal timestamps in seconds since
+// the Unix epoch.
+func ttlTestExtractor(key, value []byte) (time.Time, bool) {
+	s, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(s, 0), true
+}
+
+func TestTTLCompaction(t *testing.T) {
+	testCases := []struct {
+		name         string
+		fmv          FormatMajorVersion
+		minValueSize int
+	}{
+		{name: "block-property-collector", fmv: FormatBlockPropertyCollector},
+		// Table formats at Pebblev3 and above don't pass the values of SETs to
+		// block property collectors.
+		{name: "newest", fmv: internalFormatNewest},
+		{name: "value-separation", fmv: internalFormatNewest, minValueSize: 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var now atomic.Int64
+			now.Store(1_000_000)
+			opts := &Options{
+				FS:                 vfs.NewMem(),
+				FormatMajorVersion: tc.fmv,
+				TTL: TTLOptions{
+					TimestampExtractor: ttlTestExtractor,
+					TTL:                time.Hour,
+					CheckInterval:      time.Millisecond,
+				},
+			}
+			opts.Experimental.ValueSeparation.MinValueSize = tc.minValueSize
+			d, err := Open("", opts)
+			require.NoError(t, err)
+			defer func() {
+				require.NoError(t, d.Close())
+			}()
+			d.mu.Lock()
+			d.timeNow = func() time.Time { return time.Unix(now.Load(), 0) }
+			d.mu.Unlock()
+
+			// The keys are flushed to two overlapping files, so that the
+			// compaction rewrites them (carrying over any blob handles) rather
+			// than moving them.
+			require.NoError(t, d.Set([]byte("a"), []byte("999000"), nil))
+			require.NoError(t, d.Set([]byte("c"), []byte("never"), nil))
+			require.NoError(t, d.Flush())
+			require.NoError(t, d.Set([]byte("b"), []byte("1000000"), nil))
+			require.NoError(t, d.Flush())
+			require.NoError(t, d.Compact([]byte("a"), []byte("d"), false))
+
+			// The bottommost file records the newest timestamp of its keys,
+			// none of which have expired yet.
+			d.mu.Lock()
+			d.waitTableStats()
+			files := d.mu.versions.currentVersion().Levels[numLevels-1].Slice()
+			d.mu.Unlock()
+			require.Equal(t, 1, files.Len())
+			iter := files.Iter()
+			f := iter.First()
+			require.Equal(t, uint64(1_000_001), f.Stats.TimestampUpperBound)
+			require.Equal(t, tc.minValueSize > 0, len(f.BlobReferences) > 0)
+			verifyGet(t, d, []byte("a"), []byte("999000"))
+
+			// Once the newest timestamp expires, the periodic check schedules
+			// a TTL compaction that removes the expired keys.
+			now.Store(1_000_000 + int64(time.Hour/time.Second) + 1)
+			require.Eventually(t, func() bool {
+				return d.Metrics().Compact.TTLCount == 1
+			}, 10*time.Second, time.Millisecond)
+			verifyGetNotFound(t, d, []byte("a"))
+			verifyGetNotFound(t, d, []byte("b"))
+			verifyGet(t, d, []byte("c"), []byte("never"))
+		})
+	}
+}
+
+func TestTTLCompactionFilter(t *testing.T) {
+	var filtered []string
+	d, err := Open("", &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+		CompactionFilter: func(level int, key, value []byte) (CompactionFilterDecision, []byte) {
+			filtered = append(filtered, string(key))
+			return CompactionFilterKeep, nil
+		},
+		TTL: TTLOptions{
+			TimestampExtractor: ttlTestExtractor,
+			TTL:                time.Hour,
+		},
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	// Expired keys are removed by any compaction that rewrites them, without
+	// being passed to the compaction filter.
+	expired := strconv.FormatInt(time.Now().Add(-2*time.Hour).Unix(), 10)
+	live := strconv.FormatInt(time.Now().Unix(), 10)
+	require.NoError(t, d.Set([]byte("a"), []byte(expired), nil))
+	require.NoError(t, d.Set([]byte("b"), []byte(live), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("a"), []byte("c"), false))
+	verifyGetNotFound(t, d, []byte("a"))
+	verifyGet(t, d, []byte("b"), []byte(live))
+	require.Equal(t, []string{"b"}, filtered)
+}
+
+func TestTTLCompactionMove(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                          vfs.NewMem(),
+		FormatMajorVersion:          internalFormatNewest,
+		DisableAutomaticCompactions: true,
+		TTL: TTLOptions{
+			TimestampExtractor: ttlTestExtractor,
+			TTL:                time.Hour,
+		},
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+	waitTableStats := func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.waitTableStats()
+	}
+
+	// The flushed files don't overlap any other file, so compacting them
+	// would move them to the next level. The file whose keys have all expired
+	// is rewritten instead, removing its keys.
+	expired := strconv.FormatInt(time.Now().Add(-2*time.Hour).Unix(), 10)
+	require.NoError(t, d.Set([]byte("a"), []byte(expired), nil))
+	require.NoError(t, d.Flush())
+	waitTableStats()
+	require.NoError(t, d.Compact([]byte("a"), []byte("b"), false))
+	verifyGetNotFound(t, d, []byte("a"))
+	require.Equal(t, int64(0), d.Metrics().Compact.MoveCount)
+
+	// The file with a live key is moved, along with its expired key.
+	live := strconv.FormatInt(time.Now().Unix(), 10)
+	require.NoError(t, d.Set([]byte("b"), []byte(live), nil))
+	require.NoError(t, d.Set([]byte("c"), []byte(expired), nil))
+	require.NoError(t, d.Flush())
+	waitTableStats()
+	require.NoError(t, d.Compact([]byte("b"), []byte("d"), false))
+	verifyGet(t, d, []byte("b"), []byte(live))
+	verifyGet(t, d, []byte("c"), []byte(expired))
+	require.Equal(t, int64(1), d.Metrics().Compact.MoveCount)
+}
+
+func TestTTLOptionsExpired(t *testing.T) {
+	o := TTLOptions{TimestampExtractor: ttlTestExtractor, TTL: time.Minute}
+	require.True(t, o.enabled())
+	require.False(t, (&TTLOptions{TTL: time.Minute}).enabled())
+	// An upper bound of zero indicates a file without timestamped keys.
+	require.False(t, o.expired(0, time.Unix(1<<40, 0)))
+	require.False(t, o.expired(100, time.Unix(159, 0)))
+	require.True(t, o.expired(100, time.Unix(160, 0)))
+}
@@ -0,0 +1,472 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/blob"
+	"github.com/cockroachdb/pebble/internal/cache"
+	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/internal/private"
+	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// ValueSeparationOptions configures the separation of large values into blob
+// files (see docs/RFCS/20231016_blob_files.md).
+//
+// When a flush or compaction writes the value of a SET that is at least
+// MinValueSize long, it writes the value to a blob file, and stores a handle
+// to the value in the sstable in its place. Compactions carry the handles
+// over to their outputs without reading or rewriting the values, which
+// reduces the write amplification of workloads with large values to that of
+// the keys. Reading a separated value costs an additional read from the blob
+// file.
+//
+// A blob file is deleted once no sstable references it. Since the values of
+// a blob file are not all deleted at once, a blob file whose fraction of live
+// values falls below MinLiveRatio is garbage collected: the sstables
+// referencing it are rewritten, writing the values they reference to new blob
+// files.
+//
+// Only the values of SETs are separated: the values of MERGEs, of SETs that
+// are written as SETWITHDELs, and of keys within
+// Experimental.RequiredInPlaceValueBound are always stored in the sstables.
+// Values are not separated when the outputs of a compaction are created on
+// shared storage, since blob files always reside on local storage. Table
+// property collectors and block property collectors are passed nil values for
+// the separated values, though Options.TTL observes them. Value separation requires the
+// ExperimentalFormatBlobFiles format major version, and is ignored below it.
+type ValueSeparationOptions struct {
+	// MinValueSize is the length at or above which a value is separated.
+	// Value separation is enabled only if MinValueSize is positive.
+	MinValueSize int
+
+	// TargetBlobFileSize is the size at which a flush or compaction finishes
+	// the blob file it's writing and starts a new one.
+	//
+	// The default value is 128 MB.
+	TargetBlobFileSize int64
+
+	// MinLiveRatio is the fraction of the values of a blob file that must be
+	// referenced by the sstables of the current version for the blob file to
+	// not be garbage collected. If negative, blob files are never garbage
+	// collected, and are only deleted once no sstable references them.
+	//
+	// The default value is 0.5.
+	MinLiveRatio float64
+}
+
+func (o *ValueSeparationOptions) enabled() bool {
+	return o.MinValueSize > 0
+}
+
+// separateValues returns true if the flush or compaction c separates values
+// into blob files, and carries over the blob handles of its inputs.
+func (d *DB) separateValues(c *compaction, formatVers FormatMajorVersion) bool {
+	if !d.opts.Experimental.ValueSeparation.enabled() || formatVers < ExperimentalFormatBlobFiles {
+		return false
+	}
+	if c.kind == compactionKindTiering && c.demote {
+		return false
+	}
+	return !d.opts.preferSharedStorage(c.outputLevel.level)
+}
+
+// blobFileState is the state of a blob file referenced by the sstables of
+// the DB.
+type blobFileState struct {
+	meta manifest.BlobFileMetadata
+	// backingRefs is the number of physical sstables referencing the blob
+	// file that are part of a version that is still in use. The blob file is
+	// obsolete once it drops to zero.
+	backingRefs int
+	// liveValueSize is the sum of the BlobReference.ValueSize of the
+	// sstables of the current version that reference the blob file.
+	liveValueSize uint64
+}
+
+// liveRatio returns the fraction of the values of the blob file referenced
+// by the current version.
+func (s *blobFileState) liveRatio() float64 {
+	if s.meta.ValueSize == 0 {
+		return 1
+	}
+	return float64(s.liveValueSize) / float64(s.meta.ValueSize)
+}
+
+// blobFileCache opens the blob files of a DB, and fetches the values of the
+// blob handles stored in its sstables. Blob files are opened the first time a
+// value is read from them, and remain open until they're deleted or the DB is
+// closed.
+type blobFileCache struct {
+	objProvider objstorage.Provider
+	readerOpts  blob.ReaderOptions
+	mu          struct {
+		sync.Mutex
+		files map[base.DiskFileNum]*blobFileCacheEntry
+	}
+}
+
+// blobFileCacheEntry is an open, or opening, blob file.
+type blobFileCacheEntry struct {
+	// refs is the number of references to the entry: one held by the cache
+	// while the entry is in blobFileCache.mu.files, and one by each reader.
+	// Protected by blobFileCache.mu.
+	refs int
+	// loaded is closed once the blob file has been opened, or has failed to
+	// open.
+	loaded chan struct{}
+	r      *blob.Reader
+	err    error
+}
+
+var _ base.ValueFetcher = (*blobFileCache)(nil)
+
+func newBlobFileCache(
+	objProvider objstorage.Provider, c *cache.Cache, cacheID uint64,
+) *blobFileCache {
+	bc := &blobFileCache{
+		objProvider: objProvider,
+		readerOpts:  blob.ReaderOptions{Cache: c, CacheID: cacheID},
+	}
+	bc.mu.files = make(map[base.DiskFileNum]*blobFileCacheEntry)
+	return bc
+}
+
+// Fetch implements base.ValueFetcher.
+func (bc *blobFileCache) Fetch(
+	handle []byte, valLen int32, buf []byte,
+) (val []byte, callerOwned bool, err error) {
+	h, err := blob.DecodeHandle(handle)
+	if err != nil {
+		return nil, false, err
+	}
+	ctx := context.TODO()
+	e, err := bc.acquire(ctx, h.FileNum)
+	if err != nil {
+		return nil, false, err
+	}
+	defer bc.release(e)
+	val, err = e.r.ReadValue(ctx, h, int(valLen), buf[:0])
+	if err != nil {
+		return nil, false, err
+	}
+	return val, true, nil
+}
+
+// acquire returns a reference to the open blob file with the given number,
+// opening it if necessary. The reference must be released with release.
+func (bc *blobFileCache) acquire(
+	ctx context.Context, fileNum base.DiskFileNum,
+) (*blobFileCacheEntry, error) {
+	bc.mu.Lock()
+	e, ok := bc.mu.files[fileNum]
+	if !ok {
+		e = &blobFileCacheEntry{refs: 1, loaded: make(chan struct{})}
+		bc.mu.files[fileNum] = e
+	}
+	e.refs++
+	bc.mu.Unlock()
+
+	if !ok {
+		readable, err := bc.objProvider.OpenForReading(ctx, fileTypeBlob, fileNum, objstorage.OpenOptions{})
+		if err == nil {
+			e.r, e.err = blob.NewReader(ctx, readable, fileNum, bc.readerOpts)
+		} else {
+			e.err = err
+		}
+		close(e.loaded)
+		if e.err != nil {
+			// Don't cache the error, so that the next read retries opening the
+			// file.
+			bc.evict(fileNum)
+		}
+	}
+	<-e.loaded
+	if e.err != nil {
+		err := e.err
+		bc.release(e)
+		return nil, err
+	}
+	return e, nil
+}
+
+// release releases a reference to an entry returned by acquire.
+func (bc *blobFileCache) release(e *blobFileCacheEntry) {
+	bc.mu.Lock()
+	e.refs--
+	refs := e.refs
+	bc.mu.Unlock()
+	if refs == 0 && e.r != nil {
+		_ = e.r.Close()
+	}
+}
+
+// evict closes the blob file with the given number once it's no longer being
+// read from. It's called when the blob file becomes obsolete.
+func (bc *blobFileCache) evict(fileNum base.DiskFileNum) {
+	bc.mu.Lock()
+	e, ok := bc.mu.files[fileNum]
+	if ok {
+		delete(bc.mu.files, fileNum)
+	}
+	bc.mu.Unlock()
+	if ok {
+		bc.release(e)
+	}
+}
+
+// close closes the open blob files. There must be no concurrent reads.
+func (bc *blobFileCache) close() error {
+	bc.mu.Lock()
+	files := bc.mu.files
+	bc.mu.files = make(map[base.DiskFileNum]*blobFileCacheEntry)
+	bc.mu.Unlock()
+	var err error
+	for _, e := range files {
+		<-e.loaded
+		bc.mu.Lock()
+		e.refs--
+		refs := e.refs
+		bc.mu.Unlock()
+		if refs != 0 {
+			err = firstError(err, errors.AssertionFailedf("pebble: blob file read in progress while closing"))
+		} else if e.r != nil {
+			err = firstError(err, e.r.Close())
+		}
+	}
+	return err
+}
+
+// blobFileCount returns the number of open blob files.
+func (bc *blobFileCache) blobFileCount() int {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return len(bc.mu.files)
+}
+
+// isBlobHandle returns true if v is the handle of a value stored in a blob
+// file of the DB.
+func (bc *blobFileCache) isBlobHandle(v base.LazyValue) bool {
+	return v.Fetcher != nil && v.Fetcher.Fetcher == base.ValueFetcher(bc)
+}
+
+var addBlobHandleWithValue = private.SSTableWriterAddBlobHandle.(func(
+	*sstable.Writer, InternalKey, []byte, base.AttributeAndLen, []byte, bool) error)
+
+// blobOutputs writes the values separated by a flush or compaction to blob
+// files, and tracks the blob files referenced by the sstable being written.
+type blobOutputs struct {
+	d           *DB
+	opts        *ValueSeparationOptions
+	compression blob.Compression
+	// The sstable writer options that determine which values are separated,
+	// and their attribute.
+	cmp                       Compare
+	split                     Split
+	requiredInPlaceValueBound UserKeyPrefixBound
+	shortAttributeExtractor   ShortAttributeExtractor
+	// w is the blob file being written, if any.
+	w       *blob.Writer
+	fileNum base.DiskFileNum
+	// created holds the numbers of the blob files created, including the
+	// one being written, which are removed if the compaction fails.
+	created []base.DiskFileNum
+	// finished holds the blob files that have been written.
+	finished  []manifest.BlobFileMetadata
+	handleBuf []byte
+	valueBuf  []byte
+	// fetchValues is true if the values carried over as blob handles are
+	// fetched, so that the block property collectors that observe values
+	// (i.e. the collector of Options.TTL) are passed them.
+	fetchValues bool
+	// refs holds the sum of the lengths of the values referenced by the
+	// sstable being written, per blob file.
+	refs map[base.DiskFileNum]uint64
+}
+
+func newBlobOutputs(d *DB, writerOpts sstable.WriterOptions) *blobOutputs {
+	o := &blobOutputs{
+		d:                         d,
+		opts:                      &d.opts.Experimental.ValueSeparation,
+		compression:               blob.SnappyCompression,
+		cmp:                       writerOpts.Comparer.Compare,
+		split:                     writerOpts.Comparer.Split,
+		requiredInPlaceValueBound: writerOpts.RequiredInPlaceValueBound,
+		shortAttributeExtractor:   writerOpts.ShortAttributeExtractor,
+		fetchValues:               d.opts.TTL.enabled(),
+	}
+	if writerOpts.Compression == NoCompression {
+		o.compression = blob.NoCompression
+	}
+	return o
+}
+
+// add adds the point key returned by the compaction iterator iter to the
+// sstable being written by tw. A SET whose value is at least
+// ValueSeparationOptions.MinValueSize long is separated into the current
+// blob file, and a blob handle carried over by iter is added as is.
+func (o *blobOutputs) add(tw *sstable.Writer, iter *compactionIter, key InternalKey, value []byte) error {
+	forceObsolete := iter.forceObsoleteDueToRangeDel
+	if iter.valueIsBlob {
+		if key.Kind() != InternalKeyKindSet {
+			// Only SETs hold blob handles, so the value of a SET that the
+			// compaction turned into a SETWITHDEL is moved back into the
+			// sstable.
+			v, callerOwned, err := o.d.blobFiles.Fetch(value, iter.valueAttr.ValueLen, o.valueBuf)
+			if err != nil {
+				return err
+			}
+			if callerOwned {
+				o.valueBuf = v[:0]
+			}
+			return tw.AddWithForceObsolete(key, v, forceObsolete)
+		}
+		fileNum, err := blob.DecodeHandleFileNum(value)
+		if err != nil {
+			return err
+		}
+		o.addReference(fileNum, int(iter.valueAttr.ValueLen))
+		if !o.fetchValues {
+			return tw.AddBlobHandle(key, value, iter.valueAttr, forceObsolete)
+		}
+		v, callerOwned, err := o.d.blobFiles.Fetch(value, iter.valueAttr.ValueLen, o.valueBuf)
+		if err != nil {
+			return err
+		}
+		if callerOwned {
+			o.valueBuf = v[:0]
+		}
+		return addBlobHandleWithValue(tw, key, value, iter.valueAttr, v, forceObsolete)
+	}
+	if key.Kind() != InternalKeyKindSet || len(value) < o.opts.MinValueSize || o.requiredInPlace(key.UserKey) {
+		return tw.AddWithForceObsolete(key, value, forceObsolete)
+	}
+	attr := base.AttributeAndLen{ValueLen: int32(len(value))}
+	if o.shortAttributeExtractor != nil {
+		var err error
+		attr.ShortAttribute, err = o.shortAttributeExtractor(key.UserKey, o.prefixLen(key.UserKey), value)
+		if err != nil {
+			return err
+		}
+	}
+	handle, err := o.addValue(value)
+	if err != nil {
+		return err
+	}
+	return addBlobHandleWithValue(tw, key, handle, attr, value, forceObsolete)
+}
+
+func (o *blobOutputs) prefixLen(key []byte) int {
+	if o.split == nil {
+		return len(key)
+	}
+	return o.split(key)
+}
+
+// requiredInPlace returns true if the value of the key must be stored in the
+// sstable, per Options.Experimental.RequiredInPlaceValueBound.
+func (o *blobOutputs) requiredInPlace(key []byte) bool {
+	b := &o.requiredInPlaceValueBound
+	if b.IsEmpty() {
+		return false
+	}
+	prefix := key[:o.prefixLen(key)]
+	return o.cmp(prefix, b.Lower) >= 0 && o.cmp(b.Upper, prefix) > 0
+}
+
+// addValue writes a value to the current blob file, creating it if
+// necessary, and returns the encoded blob handle of the value. The handle is
+// valid until the next call to addValue.
+func (o *blobOutputs) addValue(value []byte) ([]byte, error) {
+	if o.w == nil {
+		o.d.mu.Lock()
+		o.fileNum = o.d.mu.versions.getNextFileNum().DiskFileNum()
+		o.d.mu.Unlock()
+		writable, _, err := o.d.objProvider.Create(context.TODO(), fileTypeBlob, o.fileNum, objstorage.CreateOptions{})
+		if err != nil {
+			return nil, err
+		}
+		o.created = append(o.created, o.fileNum)
+		o.w = blob.NewWriter(writable, o.fileNum, blob.WriterOptions{
+			Compression: o.compression,
+		})
+	}
+	h, err := o.w.Add(value)
+	if err != nil {
+		return nil, err
+	}
+	o.handleBuf = h.Encode(o.handleBuf[:0])
+	o.addReference(o.fileNum, len(value))
+	if int64(o.w.EstimatedSize()) >= o.opts.TargetBlobFileSize {
+		if err := o.finishBlobFile(); err != nil {
+			return nil, err
+		}
+	}
+	return o.handleBuf, nil
+}
+
+// addReference records that the sstable being written references a value of
+// length valueLen in the given blob file.
+func (o *blobOutputs) addReference(fileNum base.DiskFileNum, valueLen int) {
+	if o.refs == nil {
+		o.refs = make(map[base.DiskFileNum]uint64)
+	}
+	o.refs[fileNum] += uint64(valueLen)
+}
+
+// finishTable returns the blob references of the sstable being written, and
+// resets them for the next sstable.
+func (o *blobOutputs) finishTable() []manifest.BlobReference {
+	if len(o.refs) == 0 {
+		return nil
+	}
+	refs := make([]manifest.BlobReference, 0, len(o.refs))
+	for fileNum, valueSize := range o.refs {
+		refs = append(refs, manifest.BlobReference{FileNum: fileNum, ValueSize: valueSize})
+		delete(o.refs, fileNum)
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].FileNum.FileNum() < refs[j].FileNum.FileNum()
+	})
+	return refs
+}
+
+// finishBlobFile finishes the blob file being written, if any.
+func (o *blobOutputs) finishBlobFile() error {
+	if o.w == nil {
+		return nil
+	}
+	w := o.w
+	o.w = nil
+	stats, err := w.Close()
+	if err != nil {
+		return err
+	}
+	o.finished = append(o.finished, manifest.BlobFileMetadata{
+		FileNum:   o.fileNum,
+		Size:      stats.Size,
+		ValueSize: stats.ValueSize,
+	})
+	return nil
+}
+
+// abort gives up on the blob file being written, and removes the blob files
+// created. It's called if the compaction fails.
+func (o *blobOutputs) abort() {
+	if o.w != nil {
+		o.w.Abort()
+		o.w = nil
+	}
+	for _, fileNum := range o.created {
+		_ = o.d.objProvider.Remove(fileTypeBlob, fileNum)
+	}
+}
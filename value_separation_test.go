@@ -0,0 +1,276 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/objstorage/remote"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValueSeparation(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{
+		FS:                 mem,
+		FormatMajorVersion: ExperimentalFormatBlobFiles,
+	}
+	opts.Experimental.ValueSeparation = ValueSeparationOptions{
+		MinValueSize:       100,
+		TargetBlobFileSize: 4 << 10,
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() {
+		if d != nil {
+			require.NoError(t, d.Close())
+		}
+	}()
+
+	key := func(i int) []byte { return []byte(fmt.Sprintf("k%03d", i)) }
+	largeValue := func(i int) []byte { return bytes.Repeat([]byte{byte('a' + i%26)}, 200) }
+	blobFiles := func() []base.DiskFileNum {
+		ls, err := mem.List("")
+		require.NoError(t, err)
+		var fileNums []base.DiskFileNum
+		for _, name := range ls {
+			if fileType, fileNum, ok := base.ParseFilename(mem, name); ok && fileType == fileTypeBlob {
+				fileNums = append(fileNums, fileNum)
+			}
+		}
+		sort.Slice(fileNums, func(i, j int) bool {
+			return fileNums[i].FileNum() < fileNums[j].FileNum()
+		})
+		return fileNums
+	}
+	waitForCompactions := func() {
+		d.mu.Lock()
+		for d.mu.compact.compactingCount > 0 {
+			d.mu.compact.cond.Wait()
+		}
+		d.mu.Unlock()
+		d.cleanupManager.Wait()
+	}
+	verify := func(overwritten func(i int) bool) {
+		for i := 0; i < 100; i++ {
+			if overwritten(i) {
+				verifyGet(t, d, key(i), []byte("small"))
+			} else {
+				verifyGet(t, d, key(i), largeValue(i))
+			}
+		}
+		iter, _ := d.NewIter(nil)
+		i := 0
+		for valid := iter.First(); valid; valid = iter.Next() {
+			require.Equal(t, key(i), iter.Key())
+			if overwritten(i) {
+				require.Equal(t, []byte("small"), iter.Value())
+			} else {
+				require.Equal(t, largeValue(i), iter.Value())
+			}
+			i++
+		}
+		require.NoError(t, iter.Close())
+		require.Equal(t, 100, i)
+	}
+
+	// Values at least MinValueSize long are separated into blob files by
+	// flushes, and read back through the sstables referencing them.
+	for i := 0; i < 100; i++ {
+		require.NoError(t, d.Set(key(i), largeValue(i), nil))
+	}
+	require.NoError(t, d.Flush())
+	initial := blobFiles()
+	require.Greater(t, len(initial), 1)
+	m := d.Metrics()
+	require.Equal(t, int64(len(initial)), m.BlobFiles.Count)
+	require.Equal(t, uint64(100*200), m.BlobFiles.ValueSize)
+	require.Equal(t, m.BlobFiles.ValueSize, m.BlobFiles.LiveValueSize)
+	verify(func(int) bool { return false })
+
+	// Compactions carry blob handles over without rewriting the values.
+	require.NoError(t, d.Compact(key(0), key(100), false))
+	waitForCompactions()
+	require.Equal(t, initial, blobFiles())
+	verify(func(int) bool { return false })
+
+	// Overwriting most of the values leaves the blob files mostly
+	// unreferenced once the overwritten values are compacted away. Blob
+	// garbage collection then rewrites the sstables referencing them, and
+	// the blob files are deleted.
+	overwritten := func(i int) bool { return i%10 != 0 }
+	for i := 0; i < 100; i++ {
+		if overwritten(i) {
+			require.NoError(t, d.Set(key(i), []byte("small"), nil))
+		}
+	}
+	require.NoError(t, d.Compact(key(0), key(100), false))
+	waitForCompactions()
+	m = d.Metrics()
+	require.Greater(t, m.Compact.BlobRewriteCount, int64(0))
+	require.Equal(t, uint64(10*200), m.BlobFiles.LiveValueSize)
+	for _, fileNum := range blobFiles() {
+		for _, old := range initial {
+			require.NotEqual(t, old, fileNum)
+		}
+	}
+	verify(overwritten)
+
+	// Checkpoints include the blob files referenced by their sstables.
+	require.NoError(t, d.Checkpoint("checkpoint"))
+	require.NoError(t, d.Close())
+	d, err = Open("checkpoint", opts)
+	require.NoError(t, err)
+	verify(overwritten)
+
+	// Separated values survive reopening the DB.
+	require.NoError(t, d.Close())
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	verify(overwritten)
+	require.Equal(t, int64(len(blobFiles())), d.Metrics().BlobFiles.Count)
+}
+
+func TestValueSeparationFormatMajorVersion(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{
+		FS:                 mem,
+		FormatMajorVersion: ExperimentalFormatBlobFiles - 1,
+	}
+	opts.Experimental.ValueSeparation = ValueSeparationOptions{MinValueSize: 10}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	// Values aren't separated below ExperimentalFormatBlobFiles.
+	require.NoError(t, d.Set([]byte("a"), bytes.Repeat([]byte("v"), 100), nil))
+	require.NoError(t, d.Flush())
+	require.Equal(t, int64(0), d.Metrics().BlobFiles.Count)
+
+	require.NoError(t, d.RatchetFormatMajorVersion(ExperimentalFormatBlobFiles))
+	require.NoError(t, d.Set([]byte("b"), bytes.Repeat([]byte("v"), 100), nil))
+	require.NoError(t, d.Flush())
+	require.Equal(t, int64(1), d.Metrics().BlobFiles.Count)
+	verifyGet(t, d, []byte("a"), bytes.Repeat([]byte("v"), 100))
+	verifyGet(t, d, []byte("b"), bytes.Repeat([]byte("v"), 100))
+}
+
+func TestValueSeparationBackup(t *testing.T) {
+	ctx := context.Background()
+	fs := vfs.NewMem()
+	opts := &Options{
+		FS:                 fs,
+		FormatMajorVersion: ExperimentalFormatBlobFiles,
+	}
+	opts.Experimental.ValueSeparation = ValueSeparationOptions{MinValueSize: 100}
+	d, err := Open("db", opts)
+	require.NoError(t, err)
+	defer func() {
+		if d != nil {
+			require.NoError(t, d.Close())
+		}
+	}()
+
+	key := func(i int) []byte { return []byte(fmt.Sprintf("k%03d", i)) }
+	largeValue := func(i int) []byte { return bytes.Repeat([]byte{byte('a' + i%26)}, 200) }
+	for i := 0; i < 100; i++ {
+		require.NoError(t, d.Set(key(i), largeValue(i), nil))
+	}
+	require.NoError(t, d.Flush())
+	require.Greater(t, d.Metrics().BlobFiles.Count, int64(0))
+	verifyRestored := func(dir string) {
+		r, err := Open(dir, opts)
+		require.NoError(t, err)
+		for i := 0; i < 100; i++ {
+			verifyGet(t, r, key(i), largeValue(i))
+		}
+		require.NoError(t, r.Close())
+	}
+
+	// Backups include the blob files referenced by their sstables.
+	gen, err := d.Backup("backup")
+	require.NoError(t, err)
+	require.Len(t, gen.BlobFiles, 1)
+	require.NoError(t, VerifyBackup(fs, "backup", gen.Num))
+	require.NoError(t, RestoreBackup(fs, "backup", gen.Num, "restored"))
+	verifyRestored("restored")
+
+	// So do remote backups.
+	storage := remote.NewInMem()
+	_, err = d.BackupToRemote(storage, "bk/")
+	require.NoError(t, err)
+	require.NoError(t, VerifyRemoteBackup(ctx, storage, "bk/"))
+	require.NoError(t, RestoreRemoteBackup(ctx, storage, "bk/", fs, "restored-remote"))
+	verifyRestored("restored-remote")
+
+	// A blob file missing from the backup fails the restore, rather than
+	// restoring a DB whose values can't be read.
+	blobName := base.MakeFilename(fileTypeBlob, gen.BlobFiles[0].FileNum)
+	require.NoError(t, fs.Remove(fs.PathJoin("backup", backupTablesDir, blobName)))
+	require.Error(t, VerifyBackup(fs, "backup", gen.Num))
+	require.Error(t, RestoreBackup(fs, "backup", gen.Num, "restored-missing"))
+	require.NoError(t, storage.Delete("bk/"+remoteBackupTablesPrefix+blobName))
+	require.Error(t, RestoreRemoteBackup(ctx, storage, "bk/", fs, "restored-remote-missing"))
+}
+
+func TestValueSeparationCorruptBlobFile(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{
+		FS:                 mem,
+		FormatMajorVersion: ExperimentalFormatBlobFiles,
+	}
+	opts.Experimental.ValueSeparation = ValueSeparationOptions{MinValueSize: 10}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	require.NoError(t, d.Set([]byte("a"), bytes.Repeat([]byte("v"), 100), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Close())
+
+	// Corrupt the values of the blob file.
+	ls, err := mem.List("")
+	require.NoError(t, err)
+	var blobPath string
+	for _, name := range ls {
+		if fileType, _, ok := base.ParseFilename(mem, name); ok && fileType == fileTypeBlob {
+			blobPath = name
+		}
+	}
+	require.NotEmpty(t, blobPath)
+	f, err := mem.OpenReadWrite(blobPath)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("corrupt"), 0)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// Get fails rather than returning an empty value.
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	_, _, err = d.Get([]byte("a"))
+	require.True(t, errors.Is(err, base.ErrCorruption), "%+v", err)
+}
+
+func TestValueSeparationTableKeyManager(t *testing.T) {
+	// Blob files aren't encrypted, so value separation can't be combined with
+	// the encryption of sstables.
+	opts := &Options{
+		FS:                 vfs.NewMem(),
+		FormatMajorVersion: ExperimentalFormatBlobFiles,
+		TableKeyManager:    testTableKeyManager{},
+	}
+	opts.Experimental.ValueSeparation = ValueSeparationOptions{MinValueSize: 10}
+	_, err := Open("", opts)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "ValueSeparation cannot be combined with TableKeyManager")
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"sort"
 	"sync"
 	"sync/atomic"
 
@@ -100,6 +101,15 @@ type versionSet struct {
 	// load.
 	fileBackingMap map[base.DiskFileNum]*fileBacking
 
+	// blobFiles holds the state of the blob files referenced by the sstables
+	// of the versions in use. A blob file is removed from blobFiles and added
+	// to obsoleteBlobFiles once no sstable of a version in use references it.
+	blobFiles map[base.DiskFileNum]*blobFileState
+	// blobBackings maps the backing sstables of the versions in use that
+	// reference blob files to the blob files they reference.
+	blobBackings      map[base.DiskFileNum][]base.DiskFileNum
+	obsoleteBlobFiles []fileInfo
+
 	// minUnflushedLogNum is the smallest WAL log file number corresponding to
 	// mutations that have not been flushed to an sstable.
 	minUnflushedLogNum FileNum
@@ -141,6 +151,8 @@ func (vs *versionSet) init(
 	vs.obsoleteFn = vs.addObsoleteLocked
 	vs.zombieTables = make(map[base.DiskFileNum]uint64)
 	vs.fileBackingMap = make(map[base.DiskFileNum]*fileBacking)
+	vs.blobFiles = make(map[base.DiskFileNum]*blobFileState)
+	vs.blobBackings = make(map[base.DiskFileNum][]base.DiskFileNum)
 	vs.nextFileNum = 1
 	vs.manifestMarker = marker
 	vs.setCurrent = setCurrent
@@ -168,7 +180,7 @@ func (vs *versionSet) create(
 	// Note that a "snapshot" version edit is written to the manifest when it is
 	// created.
 	vs.manifestFileNum = vs.getNextFileNum()
-	err = vs.createManifest(vs.dirname, vs.manifestFileNum, vs.minUnflushedLogNum, vs.nextFileNum)
+	err = vs.createManifest(vs.dirname, vs.manifestFileNum, vs.minUnflushedLogNum, vs.nextFileNum, nil /* blobFiles */)
 	if err == nil {
 		if err = vs.manifest.Flush(); err != nil {
 			vs.opts.Logger.Fatalf("MANIFEST flush failed: %v", err)
@@ -216,6 +228,7 @@ func (vs *versionSet) load(
 	// Read the versionEdits in the manifest file.
 	var bve bulkVersionEdit
 	bve.AddedByFileNum = make(map[base.FileNum]*fileMetadata)
+	var flushedSpans []manifest.FlushedSpan
 	manifest, err := vs.fs.Open(manifestPath)
 	if err != nil {
 		return errors.Wrapf(err, "pebble: could not open manifest file %q for DB %q",
@@ -252,6 +265,7 @@ func (vs *versionSet) load(
 		if err := bve.Accumulate(&ve); err != nil {
 			return err
 		}
+		flushedSpans = append(flushedSpans, ve.FlushedSpans...)
 		if ve.MinUnflushedLogNum != 0 {
 			vs.minUnflushedLogNum = ve.MinUnflushedLogNum
 		}
@@ -311,6 +325,10 @@ func (vs *versionSet) load(
 		return err
 	}
 	newVersion.L0Sublevels.InitCompactingFileInfo(nil /* in-progress compactions */)
+	newVersion.FlushedSpans = liveFlushedSpans(flushedSpans, vs.minUnflushedLogNum)
+	if err := vs.loadBlobFiles(newVersion, bve.AddedBlobFiles); err != nil {
+		return errors.Wrapf(err, "pebble: manifest file %q for DB %q", errors.Safe(manifestFilename), dirname)
+	}
 	vs.append(newVersion)
 
 	for i := range vs.metrics.Levels {
@@ -483,6 +501,10 @@ func (vs *versionSet) logAndApply(
 	// to be called.
 	minUnflushedLogNum := vs.minUnflushedLogNum
 	nextFileNum := vs.nextFileNum
+	var blobFiles []manifest.BlobFileMetadata
+	if newManifestFileNum != 0 {
+		blobFiles = vs.blobFileMetadataLocked()
+	}
 
 	var zombies map[base.DiskFileNum]uint64
 	if err := func() error {
@@ -503,7 +525,7 @@ func (vs *versionSet) logAndApply(
 		}
 
 		if newManifestFileNum != 0 {
-			if err := vs.createManifest(vs.dirname, newManifestFileNum, minUnflushedLogNum, nextFileNum); err != nil {
+			if err := vs.createManifest(vs.dirname, newManifestFileNum, minUnflushedLogNum, nextFileNum, blobFiles); err != nil {
 				vs.opts.EventListener.ManifestCreated(ManifestCreateInfo{
 					JobID:   jobID,
 					Path:    base.MakeFilepath(vs.fs, vs.dirname, fileTypeManifest, newManifestFileNum.DiskFileNum()),
@@ -572,6 +594,22 @@ func (vs *versionSet) logAndApply(
 	for fileNum, size := range zombies {
 		vs.zombieTables[fileNum] = size
 	}
+	// Likewise, reference the blob files referenced by the new sstables
+	// before installing the new version.
+	vs.applyBlobFilesLocked(ve)
+
+	// Carry the flushed spans over to the new version. With the WAL disabled,
+	// the log numbers of the memtables don't order them, and the spans are
+	// only dropped when the DB is reopened.
+	newVersion.FlushedSpans = currentVersion.FlushedSpans
+	if ve.MinUnflushedLogNum != 0 && !vs.opts.DisableWAL {
+		newVersion.FlushedSpans = liveFlushedSpans(newVersion.FlushedSpans, ve.MinUnflushedLogNum)
+	}
+	if len(ve.FlushedSpans) > 0 {
+		newVersion.FlushedSpans = append(
+			newVersion.FlushedSpans[:len(newVersion.FlushedSpans):len(newVersion.FlushedSpans)],
+			ve.FlushedSpans...)
+	}
 
 	// Install the new version.
 	vs.append(newVersion)
@@ -644,6 +682,26 @@ func (vs *versionSet) incrementCompactions(kind compactionKind, extraLevels []*c
 	case compactionKindRewrite:
 		vs.metrics.Compact.Count++
 		vs.metrics.Compact.RewriteCount++
+
+	case compactionKindTTL:
+		vs.metrics.Compact.Count++
+		vs.metrics.Compact.TTLCount++
+
+	case compactionKindPeriodic:
+		vs.metrics.Compact.Count++
+		vs.metrics.Compact.PeriodicCount++
+
+	case compactionKindTombstoneDensity:
+		vs.metrics.Compact.Count++
+		vs.metrics.Compact.TombstoneDensityCount++
+
+	case compactionKindTiering:
+		vs.metrics.Compact.Count++
+		vs.metrics.Compact.TieringCount++
+
+	case compactionKindBlobRewrite:
+		vs.metrics.Compact.Count++
+		vs.metrics.Compact.BlobRewriteCount++
 	}
 	if len(extraLevels) > 0 {
 		vs.metrics.Compact.MultiLevelCount++
@@ -655,8 +713,12 @@ func (vs *versionSet) incrementCompactionBytes(numBytes int64) {
 }
 
 // createManifest creates a manifest file that contains a snapshot of vs.
+// blobFiles holds the blob files referenced by the current version, which
+// must be gathered while DB.mu is held.
 func (vs *versionSet) createManifest(
-	dirname string, fileNum, minUnflushedLogNum, nextFileNum FileNum,
+	dirname string,
+	fileNum, minUnflushedLogNum, nextFileNum FileNum,
+	blobFiles []manifest.BlobFileMetadata,
 ) (err error) {
 	var (
 		filename     = base.MakeFilepath(vs.fs, dirname, fileTypeManifest, fileNum.DiskFileNum())
@@ -701,6 +763,9 @@ func (vs *versionSet) createManifest(
 		}
 	}
 
+	snapshot.NewBlobFiles = blobFiles
+	snapshot.FlushedSpans = vs.currentVersion().FlushedSpans
+
 	// When creating a version snapshot for an existing DB, this snapshot VersionEdit will be
 	// immediately followed by another VersionEdit (being written in logAndApply()). That
 	// VersionEdit always contains a LastSeqNum, so we don't need to include that in the snapshot.
@@ -734,6 +799,21 @@ func (vs *versionSet) createManifest(
 	return nil
 }
 
+// liveFlushedSpans returns the flushed spans still needed once the WALs below
+// minUnflushedLogNum are flushed: the memtables that remain hold no key below
+// the sequence number of the other spans.
+func liveFlushedSpans(
+	spans []manifest.FlushedSpan, minUnflushedLogNum FileNum,
+) []manifest.FlushedSpan {
+	var live []manifest.FlushedSpan
+	for _, s := range spans {
+		if s.LogNum >= minUnflushedLogNum {
+			live = append(live, s)
+		}
+	}
+	return live
+}
+
 func (vs *versionSet) markFileNumUsed(fileNum FileNum) {
 	if vs.nextFileNum <= fileNum {
 		vs.nextFileNum = fileNum + 1
@@ -775,6 +855,9 @@ func (vs *versionSet) addLiveFileNums(m map[base.DiskFileNum]struct{}) {
 			break
 		}
 	}
+	for fileNum := range vs.blobFiles {
+		m[fileNum] = struct{}{}
+	}
 }
 
 // addObsoleteLocked will add the fileInfo associated with obsolete backing
@@ -815,6 +898,21 @@ func (vs *versionSet) addObsoleteLocked(obsolete []*fileBacking) {
 
 	vs.obsoleteTables = append(vs.obsoleteTables, obsoleteFileInfo...)
 	vs.updateObsoleteTableMetricsLocked()
+
+	for _, bs := range obsolete {
+		blobFileNums, ok := vs.blobBackings[bs.DiskFileNum]
+		if !ok {
+			continue
+		}
+		delete(vs.blobBackings, bs.DiskFileNum)
+		for _, blobFileNum := range blobFileNums {
+			s := vs.blobFiles[blobFileNum]
+			s.backingRefs--
+			if s.backingRefs == 0 {
+				vs.addObsoleteBlobFileLocked(s)
+			}
+		}
+	}
 }
 
 // addObsolete will acquire DB.mu, so DB.mu must not be held when this is
@@ -833,6 +931,146 @@ func (vs *versionSet) updateObsoleteTableMetricsLocked() {
 	}
 }
 
+// loadBlobFiles initializes the state of the blob files referenced by v,
+// the version loaded from the manifest. added holds every blob file added by
+// the manifest. Blob files that v doesn't reference are obsolete, and are
+// deleted by the scan of obsolete files that follows Open.
+func (vs *versionSet) loadBlobFiles(
+	v *version, added map[base.DiskFileNum]manifest.BlobFileMetadata,
+) error {
+	for _, lm := range v.Levels {
+		iter := lm.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			for _, ref := range f.BlobReferences {
+				s, ok := vs.blobFiles[ref.FileNum]
+				if !ok {
+					meta, ok := added[ref.FileNum]
+					if !ok {
+						return base.CorruptionErrorf("sstable %s references unknown blob file %s",
+							f.FileNum, ref.FileNum)
+					}
+					s = &blobFileState{meta: meta}
+					vs.blobFiles[ref.FileNum] = s
+				}
+				s.liveValueSize += ref.ValueSize
+			}
+			if _, ok := vs.blobBackings[f.FileBacking.DiskFileNum]; !ok && len(f.BlobReferences) > 0 {
+				vs.addBlobBackingLocked(f)
+			}
+		}
+	}
+	return nil
+}
+
+// applyBlobFilesLocked updates the state of the blob files with a version
+// edit that is about to be installed.
+//
+// DB.mu must be held when calling this method.
+func (vs *versionSet) applyBlobFilesLocked(ve *versionEdit) {
+	for _, meta := range ve.NewBlobFiles {
+		vs.blobFiles[meta.FileNum] = &blobFileState{meta: meta}
+	}
+	for _, f := range ve.DeletedFiles {
+		for _, ref := range f.BlobReferences {
+			s := vs.blobFiles[ref.FileNum]
+			// NB: The references of virtual sstables are estimates which may
+			// not add up to those of their backing sstable.
+			if ref.ValueSize < s.liveValueSize {
+				s.liveValueSize -= ref.ValueSize
+			} else {
+				s.liveValueSize = 0
+			}
+		}
+	}
+	for _, nf := range ve.NewFiles {
+		f := nf.Meta
+		if len(f.BlobReferences) == 0 {
+			continue
+		}
+		for _, ref := range f.BlobReferences {
+			s, ok := vs.blobFiles[ref.FileNum]
+			if !ok {
+				vs.opts.Logger.Fatalf("MANIFEST sstable %s references unknown blob file %s", f.FileNum, ref.FileNum)
+			}
+			s.liveValueSize += ref.ValueSize
+		}
+		if _, ok := vs.blobBackings[f.FileBacking.DiskFileNum]; !ok {
+			vs.addBlobBackingLocked(f)
+		}
+	}
+	for _, meta := range ve.NewBlobFiles {
+		// A blob file that no sstable references, which a failed compaction
+		// might leave behind, is immediately obsolete.
+		if s := vs.blobFiles[meta.FileNum]; s.backingRefs == 0 {
+			vs.addObsoleteBlobFileLocked(s)
+		}
+	}
+}
+
+// addBlobBackingLocked records that the backing sstable of f, which is not
+// yet tracked, references the blob files referenced by f.
+func (vs *versionSet) addBlobBackingLocked(f *fileMetadata) {
+	blobFileNums := make([]base.DiskFileNum, len(f.BlobReferences))
+	for i, ref := range f.BlobReferences {
+		blobFileNums[i] = ref.FileNum
+		vs.blobFiles[ref.FileNum].backingRefs++
+	}
+	vs.blobBackings[f.FileBacking.DiskFileNum] = blobFileNums
+}
+
+// addObsoleteBlobFileLocked marks a blob file that is no longer referenced
+// as obsolete.
+func (vs *versionSet) addObsoleteBlobFileLocked(s *blobFileState) {
+	delete(vs.blobFiles, s.meta.FileNum)
+	vs.obsoleteBlobFiles = append(vs.obsoleteBlobFiles, fileInfo{
+		fileNum:  s.meta.FileNum,
+		fileSize: s.meta.Size,
+	})
+}
+
+// addUnappliedBlobFilesLocked marks the blob files created by a flush or
+// compaction whose version edit could not be applied as obsolete.
+func (vs *versionSet) addUnappliedBlobFilesLocked(ve *versionEdit) {
+	if ve == nil {
+		return
+	}
+	for _, meta := range ve.NewBlobFiles {
+		vs.obsoleteBlobFiles = append(vs.obsoleteBlobFiles, fileInfo{
+			fileNum:  meta.FileNum,
+			fileSize: meta.Size,
+		})
+	}
+}
+
+// blobFileMetadataLocked returns the metadata of the blob files referenced
+// by the versions in use.
+func (vs *versionSet) blobFileMetadataLocked() []manifest.BlobFileMetadata {
+	if len(vs.blobFiles) == 0 {
+		return nil
+	}
+	metas := make([]manifest.BlobFileMetadata, 0, len(vs.blobFiles))
+	for _, s := range vs.blobFiles {
+		metas = append(metas, s.meta)
+	}
+	sort.Slice(metas, func(i, j int) bool { return metas[i].FileNum.FileNum() < metas[j].FileNum.FileNum() })
+	return metas
+}
+
+// blobFilesToRewriteLocked returns the blob files whose fraction of values
+// referenced by the current version is below minLiveRatio.
+func (vs *versionSet) blobFilesToRewriteLocked(minLiveRatio float64) map[base.DiskFileNum]struct{} {
+	var m map[base.DiskFileNum]struct{}
+	for fileNum, s := range vs.blobFiles {
+		if s.liveRatio() < minLiveRatio {
+			if m == nil {
+				m = make(map[base.DiskFileNum]struct{})
+			}
+			m[fileNum] = struct{}{}
+		}
+	}
+	return m
+}
+
 func setCurrentFunc(
 	vers FormatMajorVersion, marker *atomicfs.Marker, fs vfs.FS, dirname string, dir vfs.File,
 ) func(FileNum) error {
@@ -0,0 +1,47 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package vfs
+
+import (
+	"unsafe"
+
+	"github.com/cockroachdb/errors"
+)
+
+// DirectIOAlignment is the alignment required of the file offsets, the
+// lengths and the memory addresses of reads and writes of files with direct
+// I/O enabled. It's the page size of the common platforms, which is a multiple
+// of the logical block size of the common devices.
+const DirectIOAlignment = 4096
+
+// ErrDirectIONotSupported is returned by SetDirectIO when direct I/O isn't
+// supported by the platform or the File implementation.
+var ErrDirectIONotSupported = errors.New("pebble: direct I/O not supported")
+
+// SetDirectIO enables or disables direct I/O on the file, so that its reads
+// and writes bypass the OS page cache (O_DIRECT on Linux). While direct I/O is
+// enabled, the offsets, lengths and buffers of all reads and writes must be
+// aligned to DirectIOAlignment (see AlignedBuffer).
+//
+// An error is returned if direct I/O isn't supported by the platform, the File
+// implementation or the file system; the file is then unchanged.
+func SetDirectIO(f File, enabled bool) error {
+	fd := f.Fd()
+	if fd == InvalidFd {
+		return ErrDirectIONotSupported
+	}
+	return setDirectIO(fd, enabled)
+}
+
+// AlignedBuffer returns a buffer of n bytes (and of capacity n) whose address
+// is aligned to DirectIOAlignment.
+func AlignedBuffer(n int) []byte {
+	buf := make([]byte, n+DirectIOAlignment)
+	off := 0
+	if rem := int(uintptr(unsafe.Pointer(&buf[0])) & (DirectIOAlignment - 1)); rem != 0 {
+		off = DirectIOAlignment - rem
+	}
+	return buf[off : off+n : off+n]
+}
@@ -0,0 +1,30 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package vfs
+
+import (
+	"github.com/cockroachdb/errors"
+	"golang.org/x/sys/unix"
+)
+
+func setDirectIO(fd uintptr, enabled bool) error {
+	flags, err := unix.FcntlInt(fd, unix.F_GETFL, 0)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if enabled {
+		flags |= unix.O_DIRECT
+	} else {
+		flags &^= unix.O_DIRECT
+	}
+	// F_SETFL fails with EINVAL if the file system doesn't support O_DIRECT.
+	if _, err := unix.FcntlInt(fd, unix.F_SETFL, flags); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
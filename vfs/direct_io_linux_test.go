@@ -0,0 +1,64 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package vfs
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestAlignedBuffer(t *testing.T) {
+	for _, n := range []int{1, DirectIOAlignment, 3*DirectIOAlignment + 7} {
+		buf := AlignedBuffer(n)
+		require.Equal(t, n, len(buf))
+		require.Equal(t, n, cap(buf))
+		require.Zero(t, uintptr(unsafe.Pointer(&buf[0]))%DirectIOAlignment)
+	}
+}
+
+func TestSetDirectIO(t *testing.T) {
+	require.ErrorIs(t, SetDirectIO(&memFile{}, true), ErrDirectIONotSupported)
+
+	f, err := Default.Create(filepath.Join(t.TempDir(), "foo"))
+	require.NoError(t, err)
+	defer f.Close()
+	if err := SetDirectIO(f, true); err != nil {
+		t.Skipf("direct I/O not supported: %v", err)
+	}
+	flags, err := unix.FcntlInt(f.Fd(), unix.F_GETFL, 0)
+	require.NoError(t, err)
+	require.NotZero(t, flags&unix.O_DIRECT)
+
+	data := AlignedBuffer(2 * DirectIOAlignment)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	_, err = f.Write(data)
+	require.NoError(t, err)
+	// An unaligned write fails with direct I/O enabled, and succeeds once it's
+	// disabled.
+	_, err = f.Write(data[:100])
+	require.Error(t, err)
+	require.NoError(t, SetDirectIO(f, false))
+	_, err = f.Write(data[:100])
+	require.NoError(t, err)
+
+	require.NoError(t, SetDirectIO(f, true))
+	buf := AlignedBuffer(3 * DirectIOAlignment)
+	n, err := f.ReadAt(buf, 0)
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, len(data)+100, n)
+	require.True(t, bytes.Equal(data, buf[:len(data)]))
+	require.True(t, bytes.Equal(data[:100], buf[len(data):n]))
+}
@@ -47,7 +47,8 @@ var (
 type OpType uint8
 
 // The following OpTypes is limited to the subset of file system operations that
-// a diskHealthCheckingFile supports (namely writes and syncs).
+// a diskHealthCheckingFile supports (namely writes and syncs), and reads, which
+// are only observed by TraceDiskOps.
 const (
 	OpTypeUnknown OpType = iota
 	OpTypeWrite
@@ -62,6 +63,7 @@ const (
 	OpTypeRemoveAll
 	OpTypeRename
 	OpTypeReuseForWrite
+	OpTypeRead
 	// Note: opTypeMax is just used in tests. It must appear last in the list
 	// of OpTypes.
 	opTypeMax
@@ -94,6 +96,8 @@ func (o OpType) String() string {
 		return "rename"
 	case OpTypeReuseForWrite:
 		return "reuseforwrite"
+	case OpTypeRead:
+		return "read"
 	case OpTypeUnknown:
 		return "unknown"
 	default:
@@ -0,0 +1,291 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package vfs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cockroachdb/redact"
+)
+
+// DiskOpInfo describes a completed filesystem operation, observed by an FS
+// returned by OnDiskOp or TraceDiskOps.
+type DiskOpInfo struct {
+	// Path is the path of the file (or directory) the operation was performed
+	// on. For operations involving two paths, like Rename, Path is the new
+	// path.
+	Path string
+	// OpType is the type of the operation.
+	OpType OpType
+	// Duration is the time the operation took.
+	Duration time.Duration
+	// Err is the error returned by the operation, if any.
+	Err error
+}
+
+func (i DiskOpInfo) String() string {
+	return redact.StringWithoutMarkers(i)
+}
+
+// SafeFormat implements redact.SafeFormatter.
+func (i DiskOpInfo) SafeFormat(w redact.SafePrinter, _ rune) {
+	w.Printf("disk %s of file %s (%s)",
+		redact.Safe(i.OpType.String()), redact.Safe(filepath.Base(i.Path)),
+		redact.Safe(i.Duration.String()))
+	if i.Err != nil {
+		w.Printf(": %v", i.Err)
+	}
+}
+
+// OnDiskOp wraps the provided FS with an FS that times the write-oriented
+// operations (the operations enumerated by OpType) performed through it, and
+// invokes the provided callback once each of them completes, successfully or
+// not. Reads are not observed.
+//
+// The callback is invoked synchronously by the goroutine performing the
+// operation, which may hold locks of its own, so it must return quickly and
+// must not perform IO itself.
+func OnDiskOp(fs FS, fn func(DiskOpInfo)) FS {
+	return &diskOpFS{inner: fs, onDiskOp: fn}
+}
+
+// TraceDiskOps is like OnDiskOp, except that the reads of files (Read and
+// ReadAt, with OpTypeRead) are observed too.
+func TraceDiskOps(fs FS, fn func(DiskOpInfo)) FS {
+	return &diskOpFS{inner: fs, onDiskOp: fn, reads: true}
+}
+
+type diskOpFS struct {
+	inner    FS
+	onDiskOp func(DiskOpInfo)
+	// reads is set if reads are observed.
+	reads bool
+}
+
+var _ FS = (*diskOpFS)(nil)
+
+// Unwrap returns the underlying FS. This may be called by vfs.Root to access
+// the underlying filesystem.
+func (fs *diskOpFS) Unwrap() FS {
+	return fs.inner
+}
+
+func (fs *diskOpFS) observe(path string, opType OpType, start time.Time, err error) {
+	fs.onDiskOp(DiskOpInfo{
+		Path:     path,
+		OpType:   opType,
+		Duration: time.Since(start),
+		Err:      err,
+	})
+}
+
+func (fs *diskOpFS) wrapFile(f File, name string) File {
+	return &diskOpFile{fs: fs, inner: f, name: name}
+}
+
+func (fs *diskOpFS) Create(name string) (File, error) {
+	start := time.Now()
+	f, err := fs.inner.Create(name)
+	fs.observe(name, OpTypeCreate, start, err)
+	if err != nil {
+		return nil, err
+	}
+	return fs.wrapFile(f, name), nil
+}
+
+func (fs *diskOpFS) Link(oldname, newname string) error {
+	start := time.Now()
+	err := fs.inner.Link(oldname, newname)
+	fs.observe(newname, OpTypeLink, start, err)
+	return err
+}
+
+func (fs *diskOpFS) Open(name string, opts ...OpenOption) (File, error) {
+	f, err := fs.inner.Open(name, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return fs.wrapFile(f, name), nil
+}
+
+func (fs *diskOpFS) OpenReadWrite(name string, opts ...OpenOption) (File, error) {
+	f, err := fs.inner.OpenReadWrite(name, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return fs.wrapFile(f, name), nil
+}
+
+func (fs *diskOpFS) OpenDir(name string) (File, error) {
+	f, err := fs.inner.OpenDir(name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.wrapFile(f, name), nil
+}
+
+func (fs *diskOpFS) Remove(name string) error {
+	start := time.Now()
+	err := fs.inner.Remove(name)
+	fs.observe(name, OpTypeRemove, start, err)
+	return err
+}
+
+func (fs *diskOpFS) RemoveAll(name string) error {
+	start := time.Now()
+	err := fs.inner.RemoveAll(name)
+	fs.observe(name, OpTypeRemoveAll, start, err)
+	return err
+}
+
+func (fs *diskOpFS) Rename(oldname, newname string) error {
+	start := time.Now()
+	err := fs.inner.Rename(oldname, newname)
+	fs.observe(newname, OpTypeRename, start, err)
+	return err
+}
+
+func (fs *diskOpFS) ReuseForWrite(oldname, newname string) (File, error) {
+	start := time.Now()
+	f, err := fs.inner.ReuseForWrite(oldname, newname)
+	fs.observe(newname, OpTypeReuseForWrite, start, err)
+	if err != nil {
+		return nil, err
+	}
+	return fs.wrapFile(f, newname), nil
+}
+
+func (fs *diskOpFS) MkdirAll(dir string, perm os.FileMode) error {
+	start := time.Now()
+	err := fs.inner.MkdirAll(dir, perm)
+	fs.observe(dir, OpTypeMkdirAll, start, err)
+	return err
+}
+
+func (fs *diskOpFS) Lock(name string) (io.Closer, error) {
+	return fs.inner.Lock(name)
+}
+
+func (fs *diskOpFS) List(dir string) ([]string, error) {
+	return fs.inner.List(dir)
+}
+
+func (fs *diskOpFS) Stat(name string) (os.FileInfo, error) {
+	return fs.inner.Stat(name)
+}
+
+func (fs *diskOpFS) PathBase(path string) string {
+	return fs.inner.PathBase(path)
+}
+
+func (fs *diskOpFS) PathJoin(elem ...string) string {
+	return fs.inner.PathJoin(elem...)
+}
+
+func (fs *diskOpFS) PathDir(path string) string {
+	return fs.inner.PathDir(path)
+}
+
+func (fs *diskOpFS) GetDiskUsage(path string) (DiskUsage, error) {
+	return fs.inner.GetDiskUsage(path)
+}
+
+type diskOpFile struct {
+	fs    *diskOpFS
+	inner File
+	name  string
+}
+
+var _ File = (*diskOpFile)(nil)
+
+func (f *diskOpFile) Close() error {
+	return f.inner.Close()
+}
+
+func (f *diskOpFile) Read(p []byte) (n int, err error) {
+	if !f.fs.reads {
+		return f.inner.Read(p)
+	}
+	start := time.Now()
+	n, err = f.inner.Read(p)
+	f.fs.observe(f.name, OpTypeRead, start, readErr(err))
+	return n, err
+}
+
+func (f *diskOpFile) ReadAt(p []byte, off int64) (n int, err error) {
+	if !f.fs.reads {
+		return f.inner.ReadAt(p, off)
+	}
+	start := time.Now()
+	n, err = f.inner.ReadAt(p, off)
+	f.fs.observe(f.name, OpTypeRead, start, readErr(err))
+	return n, err
+}
+
+// readErr returns the error of a read, as observed: reaching the end of the
+// file isn't an error of the disk.
+func readErr(err error) error {
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+func (f *diskOpFile) Write(p []byte) (n int, err error) {
+	start := time.Now()
+	n, err = f.inner.Write(p)
+	f.fs.observe(f.name, OpTypeWrite, start, err)
+	return n, err
+}
+
+func (f *diskOpFile) WriteAt(p []byte, ofs int64) (n int, err error) {
+	start := time.Now()
+	n, err = f.inner.WriteAt(p, ofs)
+	f.fs.observe(f.name, OpTypeWrite, start, err)
+	return n, err
+}
+
+func (f *diskOpFile) Prefetch(offset, length int64) error {
+	return f.inner.Prefetch(offset, length)
+}
+
+func (f *diskOpFile) Preallocate(offset, length int64) error {
+	start := time.Now()
+	err := f.inner.Preallocate(offset, length)
+	f.fs.observe(f.name, OpTypePreallocate, start, err)
+	return err
+}
+
+func (f *diskOpFile) Stat() (os.FileInfo, error) {
+	return f.inner.Stat()
+}
+
+func (f *diskOpFile) Sync() error {
+	start := time.Now()
+	err := f.inner.Sync()
+	f.fs.observe(f.name, OpTypeSync, start, err)
+	return err
+}
+
+func (f *diskOpFile) SyncData() error {
+	start := time.Now()
+	err := f.inner.SyncData()
+	f.fs.observe(f.name, OpTypeSyncData, start, err)
+	return err
+}
+
+func (f *diskOpFile) SyncTo(length int64) (fullSync bool, err error) {
+	start := time.Now()
+	fullSync, err = f.inner.SyncTo(length)
+	f.fs.observe(f.name, OpTypeSyncTo, start, err)
+	return fullSync, err
+}
+
+func (f *diskOpFile) Fd() uintptr {
+	return f.inner.Fd()
+}
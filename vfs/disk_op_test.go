@@ -0,0 +1,98 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package vfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors/oserror"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOnDiskOp(t *testing.T) {
+	var ops []string
+	mem := NewMem()
+	fs := OnDiskOp(mem, func(info DiskOpInfo) {
+		require.GreaterOrEqual(t, info.Duration.Nanoseconds(), int64(0))
+		op := fmt.Sprintf("%s %s", info.OpType, info.Path)
+		if info.Err != nil {
+			op += " error"
+		}
+		ops = append(ops, op)
+	})
+	require.Equal(t, FS(mem), Root(fs))
+
+	require.NoError(t, fs.MkdirAll("dir", os.ModePerm))
+	f, err := fs.Create("dir/a")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("foo"))
+	require.NoError(t, err)
+	require.NoError(t, f.Sync())
+	require.NoError(t, f.Close())
+	require.NoError(t, fs.Rename("dir/a", "dir/b"))
+	require.NoError(t, fs.Link("dir/b", "dir/c"))
+
+	// Reads aren't observed.
+	f, err = fs.Open("dir/b")
+	require.NoError(t, err)
+	_, err = f.ReadAt(make([]byte, 3), 0)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	_, err = fs.List("dir")
+	require.NoError(t, err)
+
+	require.NoError(t, fs.Remove("dir/b"))
+	require.True(t, oserror.IsNotExist(fs.Remove("dir/b")))
+
+	require.Equal(t, strings.TrimSpace(`
+mkdirall dir
+create dir/a
+write dir/a
+sync dir/a
+rename dir/b
+link dir/c
+remove dir/b
+remove dir/b error
+`), strings.Join(ops, "\n"))
+}
+
+func TestTraceDiskOps(t *testing.T) {
+	var ops []string
+	fs := TraceDiskOps(NewMem(), func(info DiskOpInfo) {
+		op := fmt.Sprintf("%s %s", info.OpType, info.Path)
+		if info.Err != nil {
+			op += " error"
+		}
+		ops = append(ops, op)
+	})
+	f, err := fs.Create("a")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("foo"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// Reads are observed, and reaching the end of the file isn't an error.
+	f, err = fs.Open("a")
+	require.NoError(t, err)
+	_, err = f.ReadAt(make([]byte, 3), 0)
+	require.NoError(t, err)
+	_, err = f.Read(make([]byte, 4))
+	require.NoError(t, err)
+	_, err = f.Read(make([]byte, 4))
+	require.Equal(t, io.EOF, err)
+	require.NoError(t, f.Close())
+
+	require.Equal(t, strings.TrimSpace(`
+create a
+write a
+read a
+read a
+read a
+`), strings.Join(ops, "\n"))
+}
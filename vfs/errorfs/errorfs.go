@@ -2,6 +2,14 @@
 // of this source code is governed by a BSD-style license that can be found in
 // the LICENSE file.
 
+// Package errorfs provides a vfs.FS that injects faults into the operations
+// of an underlying FS, for testing how the users of the FS (Pebble, or
+// applications using Pebble) handle errors and slow disks. The faults are
+// decided by an Injector, built from the provided injectors and combinators
+// (OnIndex, Randomly, WithLatency, If, Any, Toggle) or parsed from a script
+// (ParseScript). Crashes, including torn writes, can be simulated by wrapping
+// a strict vfs.MemFS (see vfs.NewStrictMem and
+// vfs.MemFS.ResetToSyncedStateWithTornWrites).
 package errorfs
 
 import (
@@ -9,6 +17,7 @@ import (
 	"io"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -71,6 +80,39 @@ const (
 	OpFileFlush
 )
 
+var opNames = [...]string{
+	OpCreate:          "create",
+	OpLink:            "link",
+	OpOpen:            "open",
+	OpOpenDir:         "open-dir",
+	OpRemove:          "remove",
+	OpRemoveAll:       "remove-all",
+	OpRename:          "rename",
+	OpReuseForRewrite: "reuse-for-rewrite",
+	OpMkdirAll:        "mkdir-all",
+	OpLock:            "lock",
+	OpList:            "list",
+	OpFilePreallocate: "file-preallocate",
+	OpStat:            "stat",
+	OpGetDiskUsage:    "get-disk-usage",
+	OpFileClose:       "file-close",
+	OpFileRead:        "file-read",
+	OpFileReadAt:      "file-read-at",
+	OpFileWrite:       "file-write",
+	OpFileWriteAt:     "file-write-at",
+	OpFileStat:        "file-stat",
+	OpFileSync:        "file-sync",
+	OpFileFlush:       "file-flush",
+}
+
+// String implements fmt.Stringer.
+func (o Op) String() string {
+	if o >= 0 && int(o) < len(opNames) {
+		return opNames[o]
+	}
+	return fmt.Sprintf("Op(%d)", int(o))
+}
+
 // OpKind returns the operation's kind.
 func (o Op) OpKind() OpKind {
 	switch o {
@@ -139,6 +181,139 @@ func WithProbability(op OpKind, p float64) Injector {
 	})
 }
 
+// Randomly returns an injector that returns an error with probability p,
+// using a random number generator seeded with seed so that the sequence of
+// injected errors is reproducible for a given sequence of operations. It may be
+// combined with If to restrict the operations it applies to.
+func Randomly(p float64, seed int64) Injector {
+	mu := new(sync.Mutex)
+	rnd := rand.New(rand.NewSource(seed))
+	return InjectorFunc(func(Op, string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if rnd.Float64() < p {
+			return errors.WithStack(ErrInjected)
+		}
+		return nil
+	})
+}
+
+// WithLatency returns an injector that delays every operation by d, without
+// injecting errors. It may be combined with If to delay some operations only,
+// e.g. If(Writes, WithLatency(d)) to simulate a slow disk for writes.
+func WithLatency(d time.Duration) Injector {
+	return InjectorFunc(func(Op, string) error {
+		time.Sleep(d)
+		return nil
+	})
+}
+
+// Predicate selects operations, by their type and path.
+type Predicate func(op Op, path string) bool
+
+var (
+	// Reads selects the operations of kind OpKindRead.
+	Reads Predicate = func(op Op, _ string) bool { return op.OpKind() == OpKindRead }
+	// Writes selects the operations of kind OpKindWrite.
+	Writes Predicate = func(op Op, _ string) bool { return op.OpKind() == OpKindWrite }
+)
+
+// OnOps returns a predicate selecting the provided operations.
+func OnOps(ops ...Op) Predicate {
+	return func(op Op, _ string) bool {
+		for _, o := range ops {
+			if o == op {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// PathMatches returns a predicate selecting the operations whose path has a
+// base name (the last element, as returned by filepath.Base) matching the
+// pattern, using the syntax of filepath.Match; e.g. "*.sst" or "MANIFEST-*".
+// It returns an error if the pattern is malformed.
+func PathMatches(pattern string) (Predicate, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, errors.Wrapf(err, "errorfs: invalid path pattern %q", pattern)
+	}
+	return func(_ Op, path string) bool {
+		ok, _ := filepath.Match(pattern, filepath.Base(path))
+		return ok
+	}, nil
+}
+
+// And returns a predicate selecting the operations selected by all the
+// provided predicates.
+func And(preds ...Predicate) Predicate {
+	return func(op Op, path string) bool {
+		for _, pred := range preds {
+			if !pred(op, path) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// If returns an injector that consults inj for the operations selected by
+// pred, and never injects errors into the other operations. The stateful
+// injectors (e.g. OnIndex) only count the selected operations.
+func If(pred Predicate, inj Injector) Injector {
+	return InjectorFunc(func(op Op, path string) error {
+		if !pred(op, path) {
+			return nil
+		}
+		return inj.MaybeError(op, path)
+	})
+}
+
+// Any returns an injector that consults each of the provided injectors in
+// order, returning the first error injected.
+func Any(injs ...Injector) Injector {
+	return InjectorFunc(func(op Op, path string) error {
+		for _, inj := range injs {
+			if err := inj.MaybeError(op, path); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Toggle wraps an injector so that it can be enabled and disabled at runtime,
+// e.g. to inject errors only during a phase of a test. A disabled Toggle
+// doesn't consult the wrapped injector. A Toggle is enabled when created.
+type Toggle struct {
+	inj      Injector
+	disabled atomic.Bool
+}
+
+var _ Injector = (*Toggle)(nil)
+
+// NewToggle returns an enabled Toggle wrapping inj.
+func NewToggle(inj Injector) *Toggle {
+	return &Toggle{inj: inj}
+}
+
+// Enable enables the injection of errors.
+func (t *Toggle) Enable() { t.disabled.Store(false) }
+
+// Disable disables the injection of errors.
+func (t *Toggle) Disable() { t.disabled.Store(true) }
+
+// Enabled returns true if the injection of errors is enabled.
+func (t *Toggle) Enabled() bool { return !t.disabled.Load() }
+
+// MaybeError implements the Injector interface.
+func (t *Toggle) MaybeError(op Op, path string) error {
+	if t.disabled.Load() {
+		return nil
+	}
+	return t.inj.MaybeError(op, path)
+}
+
 // InjectorFunc implements the Injector interface for a function with
 // MaybeError's signature.
 type InjectorFunc func(Op, string) error
@@ -412,12 +587,16 @@ func (f *errorFile) Sync() error {
 }
 
 func (f *errorFile) SyncData() error {
-	// TODO(jackson): Consider error injection.
+	if err := f.inj.MaybeError(OpFileSync, f.path); err != nil {
+		return err
+	}
 	return f.file.SyncData()
 }
 
 func (f *errorFile) SyncTo(length int64) (fullSync bool, err error) {
-	// TODO(jackson): Consider error injection.
+	if err := f.inj.MaybeError(OpFileSync, f.path); err != nil {
+		return false, err
+	}
 	return f.file.SyncTo(length)
 }
 
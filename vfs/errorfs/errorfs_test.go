@@ -0,0 +1,139 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package errorfs
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+// injected returns the results of consulting inj for the ops, as a string
+// with a 'x' for each injected error and a '.' otherwise.
+func injected(inj Injector, path string, ops ...Op) string {
+	var b strings.Builder
+	for _, op := range ops {
+		if err := inj.MaybeError(op, path); err != nil {
+			if !errors.Is(err, ErrInjected) {
+				panic(err)
+			}
+			b.WriteByte('x')
+		} else {
+			b.WriteByte('.')
+		}
+	}
+	return b.String()
+}
+
+func repeat(op Op, n int) []Op {
+	ops := make([]Op, n)
+	for i := range ops {
+		ops[i] = op
+	}
+	return ops
+}
+
+func TestInjectors(t *testing.T) {
+	// Predicates restrict the operations that stateful injectors count.
+	inj := If(OnOps(OpFileSync), OnIndex(1))
+	require.Equal(t, "...x..", injected(inj, "000001.log",
+		OpFileWrite, OpFileSync, OpFileWrite, OpFileSync, OpFileWrite, OpFileSync))
+
+	sst, err := PathMatches("*.sst")
+	require.NoError(t, err)
+	inj = If(And(Writes, sst), OnIndex(0))
+	require.Equal(t, "...", injected(inj, "dir/000001.log", OpFileWrite, OpFileWrite, OpFileRead))
+	require.Equal(t, ".x.", injected(inj, "dir/000002.sst", OpFileRead, OpFileWrite, OpFileWrite))
+	_, err = PathMatches("[")
+	require.Error(t, err)
+
+	// Randomly is reproducible for a given seed.
+	a := injected(Randomly(0.5, 1), "", repeat(OpFileRead, 100)...)
+	require.Equal(t, a, injected(Randomly(0.5, 1), "", repeat(OpFileRead, 100)...))
+	require.Contains(t, a, "x")
+	require.Contains(t, a, ".")
+	require.Equal(t, strings.Repeat(".", 100), injected(Randomly(0, 1), "", repeat(OpFileRead, 100)...))
+
+	// Any returns the first error.
+	inj = Any(If(Reads, OnIndex(0)), If(Writes, OnIndex(1)))
+	require.Equal(t, "x.x..", injected(inj, "", OpFileRead, OpFileWrite, OpFileWrite, OpFileRead, OpFileWrite))
+
+	// A Toggle injects errors only while it's enabled.
+	toggle := NewToggle(Randomly(1, 1))
+	require.Equal(t, "xx", injected(toggle, "", OpFileRead, OpFileWrite))
+	toggle.Disable()
+	require.False(t, toggle.Enabled())
+	require.Equal(t, "..", injected(toggle, "", OpFileRead, OpFileWrite))
+	toggle.Enable()
+	require.Equal(t, "x", injected(toggle, "", OpFileRead))
+
+	// Latency delays operations without injecting errors.
+	start := time.Now()
+	require.Equal(t, "..", injected(If(Writes, WithLatency(10*time.Millisecond)), "", OpFileRead, OpFileWrite))
+	require.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond)
+}
+
+func TestParseScript(t *testing.T) {
+	inj, err := ParseScript(`
+# Comments and blank lines are ignored.
+
+file-sync path=*.log index=1
+writes path=*.sst p=1 seed=3
+file-read,file-read-at path=MANIFEST-* latency=0s
+`)
+	require.NoError(t, err)
+	require.Equal(t, "..x.", injected(inj, "000001.log", OpFileWrite, OpFileSync, OpFileSync, OpFileSync))
+	require.Equal(t, ".xx", injected(inj, "000002.sst", OpFileRead, OpFileWrite, OpCreate))
+	require.Equal(t, "..", injected(inj, "MANIFEST-000001", OpFileRead, OpFileReadAt))
+
+	inj, err = ParseScript("all index=2\nreads latency=1ms")
+	require.NoError(t, err)
+	require.Equal(t, "..x.", injected(inj, "", OpCreate, OpFileRead, OpFileWrite, OpStat))
+
+	for _, op := range []Op{OpCreate, OpFileSync, OpOpenDir, OpFileFlush} {
+		inj, err := ParseScript(fmt.Sprintf("%s index=0", op))
+		require.NoError(t, err)
+		require.Equal(t, ".x", injected(inj, "", OpLock, op))
+	}
+
+	for _, script := range []string{
+		"sync",
+		"reads index=-1",
+		"reads p=2",
+		"reads seed=1",
+		"reads index=1 p=0.5",
+		"reads error latency=1s",
+		"reads latency=soon",
+		"reads path=[",
+		"reads foo=bar",
+	} {
+		_, err := ParseScript(script)
+		require.Error(t, err, script)
+	}
+}
+
+func TestFS(t *testing.T) {
+	index := OnIndex(0)
+	toggle := NewToggle(If(OnOps(OpFileSync), index))
+	toggle.Disable()
+	fs := Wrap(vfs.NewMem(), toggle)
+	f, err := fs.Create("foo")
+	require.NoError(t, err)
+	require.NoError(t, f.Sync())
+
+	// All the flavors of syncs are subject to OpFileSync errors.
+	toggle.Enable()
+	require.True(t, errors.Is(f.SyncData(), ErrInjected))
+	index.SetIndex(0)
+	_, err = f.SyncTo(1)
+	require.True(t, errors.Is(err, ErrInjected))
+	require.NoError(t, f.Sync())
+	require.NoError(t, f.Close())
+}
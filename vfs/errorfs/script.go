@@ -0,0 +1,167 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package errorfs
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// ParseScript parses a script of fault injection rules into an Injector, so
+// that the faults injected into an FS can be configured without code (e.g.
+// from a test's data file or a flag). Each line of the script that isn't
+// blank or a comment (starting with '#') is a rule:
+//
+//	<ops> [path=<pattern>] [index=<n> | p=<probability> [seed=<n>]] [error | latency=<duration>]
+//
+// <ops> selects the operations the rule applies to: "all", "reads", "writes",
+// or a comma-separated list of operation names (see Op.String). path=<pattern>
+// further selects the operations on paths matching the pattern (see
+// PathMatches).
+//
+// By default, the rule is triggered by all the operations it selects. With
+// index=<n>, it's triggered only by the (n+1)-th operation it selects (see
+// OnIndex). With p=<probability>, it's triggered by the operations it selects
+// with the probability, using a random number generator seeded with seed=<n>
+// (0 by default; see Randomly).
+//
+// A triggered rule injects ErrInjected ("error", the default), or delays the
+// operation by the duration ("latency=<duration>", in the syntax of
+// time.ParseDuration).
+//
+// For each operation, the rules are consulted in order, until one injects an
+// error. For example:
+//
+//	# Slow down all reads, and fail the third sync of a WAL.
+//	reads latency=5ms
+//	file-sync path=*.log index=2
+//	# Fail 1% of the writes of sstables.
+//	file-write,file-write-at path=*.sst p=0.01 seed=7
+func ParseScript(script string) (Injector, error) {
+	var injs []Injector
+	for i, line := range strings.Split(script, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		inj, err := parseRule(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "errorfs: line %d", i+1)
+		}
+		injs = append(injs, inj)
+	}
+	return Any(injs...), nil
+}
+
+func parseRule(line string) (Injector, error) {
+	fields := strings.Fields(line)
+	pred, err := parseOps(fields[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var trigger Injector = InjectorFunc(func(Op, string) error {
+		return errors.WithStack(ErrInjected)
+	})
+	var latency time.Duration
+	var p float64
+	var seed int64
+	var hasP, hasSeed, hasIndex, hasAction, hasLatency bool
+	for _, field := range fields[1:] {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "path":
+			pathPred, err := PathMatches(value)
+			if err != nil {
+				return nil, err
+			}
+			pred = And(pred, pathPred)
+		case "index":
+			index, err := strconv.ParseInt(value, 10, 32)
+			if err != nil || index < 0 {
+				return nil, errors.Errorf("invalid index %q", value)
+			}
+			trigger = OnIndex(int32(index))
+			hasIndex = true
+		case "p":
+			if p, err = strconv.ParseFloat(value, 64); err != nil || p < 0 || p > 1 {
+				return nil, errors.Errorf("invalid probability %q", value)
+			}
+			hasP = true
+		case "seed":
+			if seed, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return nil, errors.Errorf("invalid seed %q", value)
+			}
+			hasSeed = true
+		case "error":
+			if hasAction {
+				return nil, errors.Errorf("multiple actions")
+			}
+			hasAction = true
+		case "latency":
+			if hasAction {
+				return nil, errors.Errorf("multiple actions")
+			}
+			if latency, err = time.ParseDuration(value); err != nil || latency < 0 {
+				return nil, errors.Errorf("invalid latency %q", value)
+			}
+			hasAction, hasLatency = true, true
+		default:
+			return nil, errors.Errorf("unknown field %q", field)
+		}
+	}
+	switch {
+	case hasP && hasIndex:
+		return nil, errors.Errorf("index and p are mutually exclusive")
+	case hasSeed && !hasP:
+		return nil, errors.Errorf("seed requires p")
+	case hasP:
+		trigger = Randomly(p, seed)
+	}
+
+	inj := trigger
+	if hasLatency {
+		inj = InjectorFunc(func(op Op, path string) error {
+			if trigger.MaybeError(op, path) != nil {
+				time.Sleep(latency)
+			}
+			return nil
+		})
+	}
+	return If(pred, inj), nil
+}
+
+// parseOps parses the comma-separated operations selected by a rule.
+func parseOps(s string) (Predicate, error) {
+	switch s {
+	case "all":
+		return func(Op, string) bool { return true }, nil
+	case "reads":
+		return Reads, nil
+	case "writes":
+		return Writes, nil
+	}
+	var ops []Op
+	for _, name := range strings.Split(s, ",") {
+		op, ok := parseOp(name)
+		if !ok {
+			return nil, errors.Errorf("unknown operation %q", name)
+		}
+		ops = append(ops, op)
+	}
+	return OnOps(ops...), nil
+}
+
+func parseOp(name string) (Op, bool) {
+	for op, n := range opNames {
+		if n == name {
+			return Op(op), true
+		}
+	}
+	return 0, false
+}
@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"math/rand"
 	"os"
 	"path"
 	"sort"
@@ -127,7 +128,22 @@ func (y *MemFS) ResetToSyncedState() {
 		return
 	}
 	y.mu.Lock()
-	y.root.resetToSyncedState()
+	y.root.resetToSyncedState(nil)
+	y.mu.Unlock()
+}
+
+// ResetToSyncedStateWithTornWrites is like ResetToSyncedState, but simulates
+// the torn writes of a crash: a file whose unsynced data extends its synced
+// data keeps a random prefix of the unsynced data, as if the OS had written
+// back part of it before the crash. The prefix of each file is picked with
+// rng.
+func (y *MemFS) ResetToSyncedStateWithTornWrites(rng *rand.Rand) {
+	if !y.strict {
+		// noop
+		return
+	}
+	y.mu.Lock()
+	y.root.resetToSyncedState(rng)
 	y.mu.Unlock()
 }
 
@@ -614,18 +630,28 @@ func (f *memNode) dump(w *bytes.Buffer, level int) {
 	}
 }
 
-func (f *memNode) resetToSyncedState() {
+// resetToSyncedState discards the unsynced state of the node and of its
+// children. If rng is non-nil, files keep a random prefix of the unsynced data
+// appended to their synced data.
+func (f *memNode) resetToSyncedState(rng *rand.Rand) {
 	if f.isDir {
 		f.children = make(map[string]*memNode)
 		for k, v := range f.syncedChildren {
 			f.children[k] = v
 		}
 		for _, v := range f.children {
-			v.resetToSyncedState()
+			v.resetToSyncedState(rng)
 		}
 	} else {
 		f.mu.Lock()
-		f.mu.data = append([]byte(nil), f.mu.syncedData...)
+		data := append([]byte(nil), f.mu.syncedData...)
+		if rng != nil && len(f.mu.data) > len(data) && bytes.HasPrefix(f.mu.data, data) {
+			unsynced := f.mu.data[len(data):]
+			data = append(data, unsynced[:rng.Intn(len(unsynced)+1)]...)
+			// The prefix is durable from now on.
+			f.mu.syncedData = append([]byte(nil), data...)
+		}
+		f.mu.data = data
 		f.mu.Unlock()
 	}
 }
@@ -6,6 +6,7 @@ package vfs
 
 import (
 	"io"
+	"math/rand"
 	"os"
 	"sort"
 	"strconv"
@@ -393,3 +394,44 @@ func TestStrictFS(t *testing.T) {
 	}
 	runTestCases(t, testCases, fs)
 }
+
+func TestStrictFSTornWrites(t *testing.T) {
+	fs := NewStrictMem()
+	rng := rand.New(rand.NewSource(1))
+	readAll := func(name string) string {
+		f, err := fs.Open(name)
+		require.NoError(t, err)
+		defer f.Close()
+		b, err := io.ReadAll(f)
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	f, err := fs.Create("foo")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("synced"))
+	require.NoError(t, err)
+	require.NoError(t, f.Sync())
+	d, err := fs.OpenDir("")
+	require.NoError(t, err)
+	require.NoError(t, d.Sync())
+	require.NoError(t, d.Close())
+
+	// Each crash keeps a random prefix of the unsynced data, which is durable
+	// afterwards.
+	lengths := make(map[int]bool)
+	for i := 0; i < 20; i++ {
+		_, err = f.WriteAt([]byte("unsynced"), int64(len(readAll("foo"))))
+		require.NoError(t, err)
+		before := readAll("foo")
+		fs.ResetToSyncedStateWithTornWrites(rng)
+		after := readAll("foo")
+		require.True(t, strings.HasPrefix(before, after))
+		require.True(t, strings.HasPrefix(after, "synced"))
+		lengths[len(before)-len(after)] = true
+		fs.ResetToSyncedState()
+		require.Equal(t, after, readAll("foo"))
+	}
+	require.Greater(t, len(lengths), 1)
+	require.NoError(t, f.Close())
+}
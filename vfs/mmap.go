@@ -0,0 +1,51 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package vfs
+
+// MmapAdvice is a hint about the expected accesses to a range of memory mapped
+// by Mmap (see Madvise).
+type MmapAdvice int8
+
+const (
+	// MmapAdviceNormal is the default: the OS reads ahead moderately around
+	// the page faults.
+	MmapAdviceNormal MmapAdvice = iota
+	// MmapAdviceRandom indicates that the range is accessed in random order:
+	// the OS doesn't read ahead.
+	MmapAdviceRandom
+	// MmapAdviceSequential indicates that the range is accessed sequentially:
+	// the OS reads ahead aggressively.
+	MmapAdviceSequential
+	// MmapAdviceWillNeed indicates that the range will be accessed soon: the
+	// OS reads it ahead asynchronously.
+	MmapAdviceWillNeed
+)
+
+// Mmap maps the first size bytes of the file into memory, read-only. The
+// mapping remains valid after the file is closed, until it's unmapped with
+// Munmap. It returns ErrUnsupported if mmap isn't supported by the platform or
+// the File implementation, or if size is zero.
+//
+// An I/O error while accessing the mapping raises SIGBUS, which crashes the
+// process, rather than being returned.
+func Mmap(f File, size int) ([]byte, error) {
+	fd := f.Fd()
+	if fd == InvalidFd || size == 0 {
+		return nil, ErrUnsupported
+	}
+	return mmap(fd, size)
+}
+
+// Munmap unmaps memory mapped by Mmap.
+func Munmap(b []byte) error {
+	return munmap(b)
+}
+
+// Madvise passes the advice about the expected accesses to b to the OS. The
+// address of b must be aligned to the page size (os.Getpagesize), and b must
+// be within memory mapped by Mmap.
+func Madvise(b []byte, advice MmapAdvice) error {
+	return madvise(b, advice)
+}
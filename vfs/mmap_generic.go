@@ -0,0 +1,20 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package vfs
+
+func mmap(fd uintptr, size int) ([]byte, error) {
+	return nil, ErrUnsupported
+}
+
+func munmap(b []byte) error {
+	return ErrUnsupported
+}
+
+func madvise(b []byte, advice MmapAdvice) error {
+	return ErrUnsupported
+}
@@ -0,0 +1,42 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package vfs
+
+import (
+	"github.com/cockroachdb/errors"
+	"golang.org/x/sys/unix"
+)
+
+func mmap(fd uintptr, size int) ([]byte, error) {
+	b, err := unix.Mmap(int(fd), 0, size, unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return b, nil
+}
+
+func munmap(b []byte) error {
+	return errors.WithStack(unix.Munmap(b))
+}
+
+func madvise(b []byte, advice MmapAdvice) error {
+	var a int
+	switch advice {
+	case MmapAdviceNormal:
+		a = unix.MADV_NORMAL
+	case MmapAdviceRandom:
+		a = unix.MADV_RANDOM
+	case MmapAdviceSequential:
+		a = unix.MADV_SEQUENTIAL
+	case MmapAdviceWillNeed:
+		a = unix.MADV_WILLNEED
+	default:
+		return errors.AssertionFailedf("unknown mmap advice %d", advice)
+	}
+	return errors.WithStack(unix.Madvise(b, a))
+}
@@ -0,0 +1,56 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package vfs
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMmap(t *testing.T) {
+	// Files without a file descriptor can't be mapped.
+	mem := NewMem()
+	f, err := mem.Create("foo")
+	require.NoError(t, err)
+	_, err = Mmap(f, 1)
+	require.True(t, errors.Is(err, ErrUnsupported))
+	require.NoError(t, f.Close())
+
+	path := Default.PathJoin(t.TempDir(), "foo")
+	f, err = Default.Create(path)
+	require.NoError(t, err)
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	_, err = f.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	f, err = Default.Open(path)
+	require.NoError(t, err)
+	_, err = Mmap(f, 0)
+	require.True(t, errors.Is(err, ErrUnsupported))
+	b, err := Mmap(f, len(data))
+	if errors.Is(err, ErrUnsupported) {
+		require.NoError(t, f.Close())
+		t.Skip("mmap not supported")
+	}
+	require.NoError(t, err)
+	// The mapping remains valid after the file is closed.
+	require.NoError(t, f.Close())
+	require.Equal(t, data, b)
+
+	pageSize := os.Getpagesize()
+	for _, advice := range []MmapAdvice{MmapAdviceRandom, MmapAdviceSequential, MmapAdviceWillNeed, MmapAdviceNormal} {
+		require.NoError(t, Madvise(b, advice))
+		if len(b) > pageSize {
+			require.NoError(t, Madvise(b[pageSize:], advice))
+		}
+	}
+	require.Equal(t, data, b)
+	require.NoError(t, Munmap(b))
+}
@@ -0,0 +1,31 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package vfs
+
+import "os"
+
+// PunchHole deallocates the byte range [offset, offset+length) of the file,
+// which must be open for writing, without changing its size: the range reads
+// as zeros afterwards, and the disk space it occupied is freed. It returns
+// ErrUnsupported if hole punching isn't supported by the platform, the File
+// implementation or the file system.
+//
+// Hole punching frees the space of the file for all its hard links: the caller
+// must check that the file has no other link (see LinkCount) before punching
+// holes in a file it is about to delete.
+func PunchHole(f File, offset, length int64) error {
+	fd := f.Fd()
+	if fd == InvalidFd {
+		return ErrUnsupported
+	}
+	return punchHole(fd, offset, length)
+}
+
+// LinkCount returns the number of hard links of the file described by info,
+// as returned by File.Stat or FS.Stat. It returns false if the count isn't
+// known, as is the case for the files of the in-memory FS.
+func LinkCount(info os.FileInfo) (uint64, bool) {
+	return linkCount(info)
+}
@@ -0,0 +1,18 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package vfs
+
+import "os"
+
+func punchHole(fd uintptr, offset, length int64) error {
+	return ErrUnsupported
+}
+
+func linkCount(info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,65 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package vfs
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPunchHole(t *testing.T) {
+	// Files without a file descriptor don't support hole punching, and their
+	// link count is unknown.
+	mem := NewMem()
+	f, err := mem.Create("foo")
+	require.NoError(t, err)
+	require.True(t, errors.Is(PunchHole(f, 0, 1), ErrUnsupported))
+	info, err := f.Stat()
+	require.NoError(t, err)
+	_, ok := LinkCount(info)
+	require.False(t, ok)
+	require.NoError(t, f.Close())
+
+	dir := t.TempDir()
+	path := Default.PathJoin(dir, "foo")
+	f, err = Default.Create(path)
+	require.NoError(t, err)
+	data := bytes.Repeat([]byte("x"), 3*DirectIOAlignment)
+	_, err = f.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Sync())
+
+	err = PunchHole(f, DirectIOAlignment, DirectIOAlignment)
+	if errors.Is(err, ErrUnsupported) {
+		require.NoError(t, f.Close())
+		t.Skip("hole punching not supported")
+	}
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// The hole reads as zeros, and the size is unchanged.
+	f, err = Default.Open(path)
+	require.NoError(t, err)
+	b, err := io.ReadAll(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	copy(data[DirectIOAlignment:], make([]byte, DirectIOAlignment))
+	require.Equal(t, data, b)
+
+	info, err = Default.Stat(path)
+	require.NoError(t, err)
+	n, ok := LinkCount(info)
+	require.True(t, ok)
+	require.Equal(t, uint64(1), n)
+	require.NoError(t, Default.Link(path, Default.PathJoin(dir, "bar")))
+	info, err = Default.Stat(path)
+	require.NoError(t, err)
+	n, _ = LinkCount(info)
+	require.Equal(t, uint64(2), n)
+}
@@ -0,0 +1,150 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// WALArchiveOptions configures the archiving of obsolete WAL files. A WAL file
+// becomes obsolete once all of its contents have been flushed to sstables.
+// Obsolete WAL files are normally deleted or recycled; when archiving is
+// enabled they are first archived, which supports point-in-time recovery
+// and the replication of the WAL by external pipelines.
+//
+// Archived WAL files are never recycled, so enabling archiving disables the
+// recycling of WAL files.
+type WALArchiveOptions struct {
+	// Dir, if set, is the directory into which obsolete WAL files are archived.
+	// Each WAL file is hard-linked into Dir, or copied if hard-linking fails,
+	// before it is deleted. Dir is created if it does not exist. The
+	// ingestions and excises applied to the DB, which bypass the WAL, are also
+	// recorded in Dir for replication (see ReplicationSource), and are pruned
+	// along with the WAL files they were applied during.
+	Dir string
+
+	// Archive, if set, is called with each obsolete WAL file before it is
+	// deleted, in place of archiving it into Dir. Archive is called from a
+	// background goroutine, in the order the WAL files were written, and the
+	// WAL file is deleted once it returns. Archive may, for example, hand the
+	// file to a replication pipeline, or link it elsewhere.
+	Archive func(fs vfs.FS, info WALArchiveInfo) error
+
+	// MaxFiles, if positive, is the number of archived WAL files retained in
+	// Dir. Once more WAL files are archived, the oldest are deleted.
+	MaxFiles int
+
+	// MaxAge, if positive, is the duration for which archived WAL files are
+	// retained in Dir, measured from the last modification of the WAL file.
+	// Older archived WAL files are deleted when WAL files are archived.
+	MaxAge time.Duration
+}
+
+// enabled returns true if obsolete WAL files are archived.
+func (o *WALArchiveOptions) enabled() bool {
+	return o.Dir != "" || o.Archive != nil
+}
+
+// WALArchiveInfo describes an obsolete WAL file handed to
+// WALArchiveOptions.Archive.
+type WALArchiveInfo struct {
+	// JobID is the ID of the job that made the WAL file obsolete.
+	JobID int
+	// Path is the path of the WAL file.
+	Path string
+	// FileNum is the file number of the WAL file.
+	FileNum base.DiskFileNum
+	// Size is the size of the WAL file in bytes.
+	Size uint64
+}
+
+// archiveWAL archives the obsolete WAL file at path according to
+// Options.WALArchive. It is called from the cleanup goroutine before the WAL
+// file is deleted. If archiving fails, the WAL file must not be deleted, so
+// that it is archived again the next time the DB is opened.
+func (cm *cleanupManager) archiveWAL(
+	jobID int, path string, fileNum base.DiskFileNum, fileSize uint64,
+) error {
+	o := &cm.opts.WALArchive
+	fs := cm.opts.FS
+	if o.Archive != nil {
+		return o.Archive(fs, WALArchiveInfo{
+			JobID:   jobID,
+			Path:    path,
+			FileNum: fileNum,
+			Size:    fileSize,
+		})
+	}
+	if err := fs.MkdirAll(o.Dir, 0755); err != nil {
+		return err
+	}
+	destPath := fs.PathJoin(o.Dir, fs.PathBase(path))
+	if err := vfs.LinkOrCopy(fs, path, destPath); err != nil && !oserror.IsExist(err) {
+		// A WAL file that already exists in the archive was archived by a
+		// previous attempt that failed to delete the WAL file.
+		return err
+	}
+	cm.pruneWALArchive()
+	return nil
+}
+
+// pruneWALArchive deletes the archived WAL files in Options.WALArchive.Dir
+// that exceed its retention policy.
+func (cm *cleanupManager) pruneWALArchive() {
+	o := &cm.opts.WALArchive
+	if o.MaxFiles <= 0 && o.MaxAge <= 0 {
+		return
+	}
+	fs := cm.opts.FS
+	ls, err := fs.List(o.Dir)
+	if err != nil {
+		cm.opts.Logger.Infof("WAL archive: failed to list %s: %s", o.Dir, err)
+		return
+	}
+	type archivedWAL struct {
+		fileNum base.DiskFileNum
+		path    string
+	}
+	var archived []archivedWAL
+	for _, filename := range ls {
+		if ft, fileNum, ok := base.ParseFilename(fs, filename); ok && ft == fileTypeLog {
+			archived = append(archived, archivedWAL{fileNum: fileNum, path: fs.PathJoin(o.Dir, filename)})
+		}
+	}
+	sort.Slice(archived, func(i, j int) bool {
+		return archived[i].fileNum.FileNum() < archived[j].fileNum.FileNum()
+	})
+
+	now := time.Now()
+	var prunedThrough base.DiskFileNum
+	for i, a := range archived {
+		expired := o.MaxFiles > 0 && len(archived)-i > o.MaxFiles
+		if !expired && o.MaxAge > 0 {
+			info, err := fs.Stat(a.path)
+			if err != nil {
+				cm.opts.Logger.Infof("WAL archive: failed to stat %s: %s", a.path, err)
+				continue
+			}
+			expired = now.Sub(info.ModTime()) > o.MaxAge
+		}
+		if !expired {
+			continue
+		}
+		if err := fs.Remove(a.path); err != nil && !oserror.IsNotExist(err) {
+			cm.opts.Logger.Infof("WAL archive: failed to remove %s: %s", a.path, err)
+		}
+		prunedThrough = a.fileNum
+	}
+	if prunedThrough.FileNum() > 0 {
+		// The ingestions and excises recorded for replication are pruned with
+		// their WAL files.
+		pruneReplicationEvents(fs, o.Dir, ls, prunedThrough, cm.opts.Logger)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+// listWALs returns the file numbers of the WAL files in dir.
+func listWALs(t *testing.T, fs vfs.FS, dir string) []base.DiskFileNum {
+	ls, err := fs.List(dir)
+	require.NoError(t, err)
+	var fileNums []base.DiskFileNum
+	for _, filename := range ls {
+		if ft, fileNum, ok := base.ParseFilename(fs, filename); ok && ft == fileTypeLog {
+			fileNums = append(fileNums, fileNum)
+		}
+	}
+	sort.Slice(fileNums, func(i, j int) bool {
+		return fileNums[i].FileNum() < fileNums[j].FileNum()
+	})
+	return fileNums
+}
+
+func TestWALArchiveDir(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{FS: mem}
+	opts.WALArchive.Dir = "archive"
+	opts.WALArchive.MaxFiles = 2
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	d.testingAlwaysWaitForCleanup = true
+
+	var obsolete []base.DiskFileNum
+	for i := 0; i < 4; i++ {
+		obsolete = append(obsolete, listWALs(t, mem, "")...)
+		require.NoError(t, d.Set([]byte("a"), []byte("a"), nil))
+		require.NoError(t, d.Flush())
+	}
+	// The most recent obsolete WAL files are retained in the archive, and
+	// none of the obsolete WAL files remain in the WAL directory.
+	require.Equal(t, obsolete[len(obsolete)-2:], listWALs(t, mem, "archive"))
+	for _, fileNum := range listWALs(t, mem, "") {
+		require.NotContains(t, obsolete, fileNum)
+	}
+	require.NoError(t, d.Close())
+}
+
+func TestWALArchiveCallback(t *testing.T) {
+	mem := vfs.NewMem()
+	var archived []base.DiskFileNum
+	var fail bool
+	opts := &Options{FS: mem}
+	opts.WALArchive.Archive = func(fs vfs.FS, info WALArchiveInfo) error {
+		if fail {
+			return errors.New("injected error")
+		}
+		require.Less(t, uint64(0), info.Size)
+		_, err := fs.Stat(info.Path)
+		require.NoError(t, err)
+		archived = append(archived, info.FileNum)
+		return nil
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	d.testingAlwaysWaitForCleanup = true
+
+	var obsolete []base.DiskFileNum
+	for i := 0; i < 3; i++ {
+		obsolete = append(obsolete, listWALs(t, mem, "")...)
+		require.NoError(t, d.Set([]byte("a"), []byte("a"), nil))
+		require.NoError(t, d.Flush())
+	}
+	require.Equal(t, obsolete, archived)
+
+	// A WAL file that fails to be archived is not deleted, and is archived
+	// when the DB is reopened.
+	fail = true
+	failed := listWALs(t, mem, "")
+	require.NoError(t, d.Set([]byte("a"), []byte("a"), nil))
+	require.NoError(t, d.Flush())
+	require.Subset(t, listWALs(t, mem, ""), failed)
+	require.NoError(t, d.Close())
+
+	fail = false
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	require.NoError(t, d.Close())
+	require.Subset(t, archived, failed)
+}
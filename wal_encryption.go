@@ -0,0 +1,166 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/record"
+)
+
+// WALKeyManager provides the keys with which the WAL is encrypted. See
+// Options.WALKeyManager.
+//
+// Each WAL file is encrypted with a single key, identified in the WAL file by
+// its ID, so that keys are rotated at WAL file boundaries: a WAL file created
+// after the key manager starts returning a new key from CurrentKey is
+// encrypted with the new key. Keys must be 16, 24 or 32 bytes long, selecting
+// AES-128, AES-192 or AES-256.
+type WALKeyManager interface {
+	// CurrentKey returns the ID of the key with which new WAL files are
+	// encrypted, and the key. It is called when a WAL file is created.
+	CurrentKey() (id string, key []byte, err error)
+	// Key returns the key with the given ID. It is called to decrypt a WAL
+	// file encrypted with the key, when the WAL is replayed by Open or read by
+	// a WALTailer. A key must remain available for as long as a WAL file
+	// encrypted with it may be read, including archived WAL files (see
+	// Options.WALArchive).
+	Key(id string) ([]byte, error)
+}
+
+// walEncryptionMagic starts the header record written at the start of each
+// encrypted WAL file. Read as the sequence number of a batch, it exceeds
+// InternalKeySeqNumMax, so the header record is never confused with a batch.
+var walEncryptionMagic = []byte("pwalenc\xff")
+
+// walEncryptionVersion is the version of the encryption of a WAL file, which
+// follows walEncryptionMagic in the header record.
+const walEncryptionVersion = 1
+
+// encodeWALEncryptionHeader returns the header record of a WAL file encrypted
+// with the key with the given ID.
+func encodeWALEncryptionHeader(keyID string) []byte {
+	buf := make([]byte, 0, len(walEncryptionMagic)+1+len(keyID))
+	buf = append(buf, walEncryptionMagic...)
+	buf = append(buf, walEncryptionVersion)
+	return append(buf, keyID...)
+}
+
+// decodeWALEncryptionHeader returns the key ID of a header record, or false if
+// the record is not a header record.
+func decodeWALEncryptionHeader(rec []byte) (keyID string, ok bool, err error) {
+	if !bytes.HasPrefix(rec, walEncryptionMagic) {
+		return "", false, nil
+	}
+	rec = rec[len(walEncryptionMagic):]
+	if len(rec) == 0 || rec[0] != walEncryptionVersion {
+		return "", false, base.CorruptionErrorf("pebble: unknown WAL encryption header %x", rec)
+	}
+	return string(rec[1:]), true, nil
+}
+
+// walCipher encrypts and decrypts the records of a WAL file with AES-GCM.
+// Each encrypted record is a random nonce followed by the sealed record.
+type walCipher struct {
+	aead cipher.AEAD
+	buf  []byte
+}
+
+func newWALCipher(key []byte) (*walCipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "pebble: invalid WAL encryption key")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &walCipher{aead: aead}, nil
+}
+
+// seal encrypts the record. The returned slice is only valid until the next
+// call to seal.
+func (c *walCipher) seal(rec []byte) ([]byte, error) {
+	n := c.aead.NonceSize()
+	c.buf = append(c.buf[:0], make([]byte, n)...)
+	if _, err := rand.Read(c.buf[:n]); err != nil {
+		return nil, err
+	}
+	c.buf = c.aead.Seal(c.buf, c.buf[:n], rec, nil)
+	return c.buf, nil
+}
+
+// open decrypts the record. The returned slice is only valid until the next
+// call to open.
+func (c *walCipher) open(rec []byte) ([]byte, error) {
+	n := c.aead.NonceSize()
+	if len(rec) < n {
+		return nil, base.CorruptionErrorf("pebble: encrypted WAL record too short")
+	}
+	var err error
+	c.buf, err = c.aead.Open(c.buf[:0], rec[:n], rec[n:], nil)
+	if err != nil {
+		return nil, base.CorruptionErrorf("pebble: failed to decrypt WAL record: %v", err)
+	}
+	return c.buf, nil
+}
+
+// startWALEncryption returns the cipher with which the records of a new WAL
+// file are encrypted, after writing the header record identifying the key to
+// the WAL file. It returns nil if the WAL is not encrypted.
+func (d *DB) startWALEncryption(w *record.LogWriter) (*walCipher, error) {
+	km := d.opts.WALKeyManager
+	if km == nil {
+		return nil, nil
+	}
+	id, key, err := km.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	c, err := newWALCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.WriteRecord(encodeWALEncryptionHeader(id)); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// walDecoder decodes the records of a WAL file, which are batches, optionally
+// preceded by a header record if the WAL file is encrypted.
+type walDecoder struct {
+	km     WALKeyManager
+	cipher *walCipher
+}
+
+// decode returns the batch of a record read from the WAL file, or nil if the
+// record is the header record. The returned slice may be rec, or is only
+// valid until the next call to decode.
+func (w *walDecoder) decode(rec []byte) ([]byte, error) {
+	if w.cipher != nil {
+		return w.cipher.open(rec)
+	}
+	id, ok, err := decodeWALEncryptionHeader(rec)
+	if err != nil || !ok {
+		return rec, err
+	}
+	if w.km == nil {
+		return nil, errors.New("pebble: WAL file is encrypted, but Options.WALKeyManager is not set")
+	}
+	key, err := w.km.Key(id)
+	if err != nil {
+		return nil, errors.Wrapf(err, "pebble: WAL encryption key %q", errors.Safe(id))
+	}
+	if w.cipher, err = newWALCipher(key); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
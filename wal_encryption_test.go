@@ -0,0 +1,112 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+type testWALKeyManager struct {
+	current string
+	keys    map[string][]byte
+}
+
+func (m *testWALKeyManager) rotate(id string) {
+	m.current = id
+	m.keys[id] = bytes.Repeat([]byte(id[:1]), 32)
+}
+
+func (m *testWALKeyManager) CurrentKey() (string, []byte, error) {
+	return m.current, m.keys[m.current], nil
+}
+
+func (m *testWALKeyManager) Key(id string) ([]byte, error) {
+	key, ok := m.keys[id]
+	if !ok {
+		return nil, errors.Newf("unknown key %s", id)
+	}
+	return key, nil
+}
+
+func TestWALEncryption(t *testing.T) {
+	mem := vfs.NewMem()
+	km := &testWALKeyManager{keys: make(map[string][]byte)}
+	km.rotate("a")
+	opts := &Options{FS: mem, WALKeyManager: km}
+	opts.WALArchive.Dir = "archive"
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	d.testingAlwaysWaitForCleanup = true
+
+	// Each WAL file is encrypted with the key current when it is created.
+	for i, id := range []string{"b", "c"} {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("secret-%d", i)), []byte("plaintext"), nil))
+		km.rotate(id)
+		require.NoError(t, d.Flush())
+	}
+	require.NoError(t, d.Set([]byte("secret-2"), []byte("plaintext"), nil))
+	large := d.NewBatch()
+	for i := 0; i < 2000; i++ {
+		require.NoError(t, large.Set([]byte(fmt.Sprintf("large-%04d", i)), []byte("plaintext"), nil))
+	}
+	require.NoError(t, large.Commit(nil))
+
+	// The batches are read through a WALTailer, including from the archived
+	// WAL files, and from a position within an encrypted WAL file.
+	tailer := d.NewWALTailer(WALPosition{})
+	var positions []WALPosition
+	var keys int
+	for {
+		b, err := tailer.Next()
+		require.NoError(t, err)
+		if b == nil {
+			break
+		}
+		keys += int(b.Count)
+		positions = append(positions, b.Next)
+	}
+	require.NoError(t, tailer.Close())
+	require.Equal(t, 2003, keys)
+	tailer = d.NewWALTailer(positions[2])
+	b, err := tailer.Next()
+	require.NoError(t, err)
+	require.Equal(t, uint32(2000), b.Count)
+	require.NoError(t, tailer.Close())
+	require.NoError(t, d.Close())
+
+	// None of the WAL files contain the plaintext.
+	for _, dir := range []string{"", "archive"} {
+		for _, fileNum := range listWALs(t, mem, dir) {
+			f, err := mem.Open(base.MakeFilepath(mem, dir, fileTypeLog, fileNum))
+			require.NoError(t, err)
+			data, err := io.ReadAll(f)
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+			require.False(t, bytes.Contains(data, []byte("plaintext")), "WAL file %s", fileNum)
+		}
+	}
+
+	// The encrypted WAL is replayed when the DB is reopened, which requires
+	// the key manager.
+	_, err = Open("", &Options{FS: mem})
+	require.Error(t, err)
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	for _, key := range []string{"secret-0", "secret-1", "secret-2", "large-1999"} {
+		v, closer, err := d.Get([]byte(key))
+		require.NoError(t, err)
+		require.Equal(t, "plaintext", string(v))
+		require.NoError(t, closer.Close())
+	}
+	require.NoError(t, d.Close())
+}
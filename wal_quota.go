@@ -0,0 +1,149 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// walQuotaMaxDelay is the delay of a write when the un-flushed WAL data is
+// just below Options.MaxUnflushedWALBytes. Writes are delayed once the
+// un-flushed WAL data exceeds three quarters of the cap, in proportion to how
+// far it exceeds it.
+const walQuotaMaxDelay = 100 * time.Millisecond
+
+// unflushedWALBytesLocked returns the size of the WAL data that has not been
+// flushed yet: the WAL files of the immutable memtables and the current WAL
+// file. Requires d.mu and commitPipeline.mu to be held.
+func (d *DB) unflushedWALBytesLocked() uint64 {
+	size := uint64(d.mu.log.Size())
+	for i, n := 0, len(d.mu.mem.queue)-1; i < n; i++ {
+		size += d.mu.mem.queue[i].logSize
+	}
+	return size
+}
+
+// flushForWALQuotaLocked flushes the immutable memtables, regardless of their
+// size, and rotates the mutable memtable for flushing if the current WAL file
+// holds at least a quarter of the un-flushed WAL bytes allowed. The time spent
+// rotating the WAL is attributed to the batch b. Requires d.mu and
+// commitPipeline.mu to be held.
+func (d *DB) flushForWALQuotaLocked(b *Batch, limit uint64) {
+	for i, n := 0, len(d.mu.mem.queue)-1; i < n; i++ {
+		d.mu.mem.queue[i].flushForced = true
+	}
+	if size := uint64(d.mu.log.Size()); size == 0 || size < limit/4 {
+		d.maybeScheduleFlush()
+		return
+	}
+	now := time.Now()
+	newLogNum, prevLogSize := d.recycleWAL()
+	b.commitStats.WALRotationDuration += time.Since(now)
+	imm := d.mu.mem.queue[len(d.mu.mem.queue)-1]
+	imm.logSize = prevLogSize
+	imm.flushForced = true
+	d.rotateMemtable(newLogNum, d.mu.versions.logSeqNum.Load(), d.mu.mem.mutable)
+}
+
+// applyWALQuota applies backpressure to a write before it enters the commit
+// pipeline as the un-flushed WAL data approaches Options.MaxUnflushedWALBytes:
+// the memtables are flushed once it reaches half of the cap, the write is
+// delayed once it exceeds three quarters of the cap, and the write stalls
+// while it exceeds the cap. Like waitForWriteStall, the stall is bounded by
+// Options.MaxWriteStallDuration, and is abandoned if the DB enters degraded
+// mode or is closed. If noWait is set, applyWALQuota returns errWriteWouldWait
+// instead of delaying or stalling the write.
+func (d *DB) applyWALQuota(b *Batch, noWait bool) error {
+	limit := uint64(d.opts.MaxUnflushedWALBytes)
+	var delay time.Duration
+	var deadline time.Time
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	for {
+		d.commit.mu.Lock()
+		d.mu.Lock()
+		size := d.unflushedWALBytesLocked()
+		if size >= limit/2 {
+			d.flushForWALQuotaLocked(b, limit)
+		}
+		d.commit.mu.Unlock()
+		if size < limit {
+			if d.mu.writeStall.active && d.mu.writeStall.cause == WriteStallWALQuota {
+				d.writeStallEndLocked()
+			}
+			if threshold := limit / 4 * 3; size > threshold {
+				if noWait {
+					d.mu.Unlock()
+					return errWriteWouldWait
+				}
+				delay = time.Duration(float64(walQuotaMaxDelay) * float64(size-threshold) / float64(limit-threshold))
+				d.mu.versions.metrics.WAL.QuotaDelayCount++
+				d.mu.versions.metrics.WAL.QuotaDelayDuration += delay
+			}
+			d.mu.Unlock()
+			break
+		}
+		if err := d.walQuotaStallErrLocked(deadline); err != nil {
+			// Writers only wait for the stall while the quota is exceeded, so
+			// end it rather than leave it to a writer that may never come.
+			if d.mu.writeStall.active && d.mu.writeStall.cause == WriteStallWALQuota {
+				d.writeStallEndLocked()
+			}
+			d.mu.Unlock()
+			return err
+		}
+		if noWait {
+			d.mu.Unlock()
+			return errWriteWouldWait
+		}
+		if !d.mu.writeStall.active {
+			d.mu.versions.metrics.WAL.QuotaStallCount++
+			d.writeStallBeginLocked(WriteStallWALQuota)
+		}
+		if timer == nil && d.opts.MaxWriteStallDuration > 0 {
+			deadline = time.Now().Add(d.opts.MaxWriteStallDuration)
+			// Wake this writer at the deadline, as nothing else may signal
+			// the condition variable while the stall persists.
+			timer = time.AfterFunc(d.opts.MaxWriteStallDuration, func() {
+				d.mu.Lock()
+				defer d.mu.Unlock()
+				d.mu.compact.cond.Broadcast()
+			})
+		}
+		now := time.Now()
+		d.mu.compact.cond.Wait()
+		d.mu.Unlock()
+		b.commitStats.WALQuotaWaitDuration += time.Since(now)
+	}
+
+	if delay > 0 {
+		time.Sleep(delay)
+		b.commitStats.WALQuotaWaitDuration += delay
+	}
+	return nil
+}
+
+// walQuotaStallErrLocked returns the error with which a write stalled by
+// Options.MaxUnflushedWALBytes gives up: the DB is closed or degraded, or the
+// provided deadline, if set, has passed. Requires d.mu to be held.
+func (d *DB) walQuotaStallErrLocked(deadline time.Time) error {
+	if err := d.closed.Load(); err != nil {
+		return err.(error)
+	}
+	if err := d.degradedErr(); err != nil {
+		return err
+	}
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return errors.Wrapf(ErrWriteStallTimeout, "%s", WriteStallWALQuota)
+	}
+	return nil
+}
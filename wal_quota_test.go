@@ -0,0 +1,107 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWALQuota(t *testing.T) {
+	const quota = 1 << 20
+	stalls := make(chan WriteStallBeginInfo, 1)
+	fs := blockSSTCreateFS{FS: vfs.NewMem(), unblock: make(chan struct{})}
+	d, err := Open("", &Options{
+		FS:                   fs,
+		MemTableSize:         64 << 20,
+		MaxUnflushedWALBytes: quota,
+		EventListener: &EventListener{
+			WriteStallBegin: func(info WriteStallBeginInfo) {
+				select {
+				case stalls <- info:
+				default:
+				}
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	// With flushes blocked, the memtables are far from full when the
+	// un-flushed WAL data reaches the cap, so that writes are delayed and
+	// then stall.
+	done := make(chan error, 1)
+	go func() {
+		value := make([]byte, 64<<10)
+		for i := 0; i < 2*quota/len(value); i++ {
+			if err := d.Set([]byte(fmt.Sprintf("k%06d", i)), value, nil); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	info := <-stalls
+	require.Equal(t, WriteStallWALQuota, info.Cause)
+	m := d.Metrics()
+	require.EqualValues(t, quota, m.WAL.QuotaSize)
+	require.GreaterOrEqual(t, m.WAL.Size, uint64(quota))
+	require.Greater(t, m.WAL.QuotaDelayCount, int64(0))
+	require.Greater(t, m.WAL.QuotaDelayDuration, time.Duration(0))
+	require.EqualValues(t, 1, m.WAL.QuotaStallCount)
+
+	// Writes resume once the memtables flush.
+	close(fs.unblock)
+	require.NoError(t, <-done)
+	m = d.Metrics()
+	require.Greater(t, m.Flush.Count, int64(0))
+	require.Less(t, m.WAL.Size, uint64(quota))
+	require.NoError(t, d.Close())
+}
+
+func TestWALQuotaMaxWriteStallDuration(t *testing.T) {
+	const quota = 1 << 20
+	fs := blockSSTCreateFS{FS: vfs.NewMem(), unblock: make(chan struct{})}
+	d, err := Open("", &Options{
+		FS:                    fs,
+		MemTableSize:          64 << 20,
+		MaxUnflushedWALBytes:  quota,
+		MaxWriteStallDuration: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	// With flushes blocked, the un-flushed WAL data reaches the cap and the
+	// stalled write gives up once MaxWriteStallDuration elapses, without
+	// having entered the commit pipeline.
+	value := make([]byte, 64<<10)
+	var i int
+	for ; ; i++ {
+		err = d.Set([]byte(fmt.Sprintf("k%06d", i)), value, nil)
+		if err != nil {
+			break
+		}
+		require.Less(t, i, 2*quota/len(value))
+	}
+	require.ErrorIs(t, err, ErrWriteStallTimeout)
+	require.EqualValues(t, 1, d.Metrics().WAL.QuotaStallCount)
+	verifyGetNotFound(t, d, []byte(fmt.Sprintf("k%06d", i)))
+
+	// Writes resume once the memtables flush.
+	close(fs.unblock)
+	require.Eventually(t, func() bool {
+		return d.Set([]byte(fmt.Sprintf("k%06d", i)), value, nil) == nil
+	}, 10*time.Second, time.Millisecond)
+	require.NoError(t, d.Close())
+}
+
+func TestWALQuotaOption(t *testing.T) {
+	opts := (&Options{MaxUnflushedWALBytes: 64 << 20}).EnsureDefaults()
+	parsed := &Options{}
+	require.NoError(t, parsed.Parse(opts.String(), nil))
+	require.EqualValues(t, 64<<20, parsed.MaxUnflushedWALBytes)
+}
@@ -0,0 +1,69 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+
+	"github.com/cockroachdb/errors"
+)
+
+// WALRecoveryMode controls the behavior of Open when the replay of a WAL file
+// encounters a corrupt record, such as a record failing its checksum. See
+// Options.WALRecoveryMode.
+//
+// Corruption at the tail of a WAL file, which is not followed by any valid
+// batch, is the expected result of a crash during a write, and ends the replay
+// of the WAL file in every mode. Corruption followed by valid batches within
+// the WAL file loses the batches between them: the range of their sequence
+// numbers is reported to EventListener.WALCorruption.
+type WALRecoveryMode int8
+
+const (
+	// WALRecoveryTolerateCorruptTail ends the replay of a WAL file at its first
+	// corrupt record, discarding any valid batches following it. This is the
+	// default.
+	WALRecoveryTolerateCorruptTail WALRecoveryMode = iota
+	// WALRecoveryStrict fails Open if a corrupt record is followed by valid
+	// batches, or if a WAL file other than the most recent has a corrupt tail,
+	// reporting the range of sequence numbers lost.
+	WALRecoveryStrict
+	// WALRecoverySkipAndReport skips over corrupt records, continuing the
+	// replay of a WAL file with the valid batches following them. A corrupt
+	// tail of a WAL file other than the most recent is reported along with the
+	// first batch of the following WAL file, and fails Open if none follows.
+	WALRecoverySkipAndReport
+)
+
+// String implements fmt.Stringer.
+func (m WALRecoveryMode) String() string {
+	switch m {
+	case WALRecoveryTolerateCorruptTail:
+		return "tolerate-corrupt-tail"
+	case WALRecoveryStrict:
+		return "strict"
+	case WALRecoverySkipAndReport:
+		return "skip-and-report"
+	default:
+		return fmt.Sprintf("WALRecoveryMode(%d)", int8(m))
+	}
+}
+
+// parseWALRecoveryMode parses the string representation of a WALRecoveryMode.
+func parseWALRecoveryMode(s string) (WALRecoveryMode, error) {
+	for m := WALRecoveryTolerateCorruptTail; m <= WALRecoverySkipAndReport; m++ {
+		if m.String() == s {
+			return m, nil
+		}
+	}
+	return 0, errors.Errorf("unknown WAL recovery mode %q", s)
+}
+
+// walCorruptionError returns the error with which Open fails in
+// WALRecoveryStrict mode.
+func walCorruptionError(info WALCorruptionInfo) error {
+	return errors.Wrapf(info.Err, "pebble: corrupt WAL file %q at offset %d, losing seqnums [%d,%d)",
+		info.Path, errors.Safe(info.Offset), errors.Safe(info.LostSeqNums[0]), errors.Safe(info.LostSeqNums[1]))
+}
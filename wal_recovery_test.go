@@ -0,0 +1,187 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWALRecoveryMode(t *testing.T) {
+	keys := []string{"a", "b", "c", "d", "e", "f"}
+	// setup writes a WAL file in which each batch holds a 20KB value, so that
+	// the batches straddle the 32KB blocks of the WAL, and corrupts the first
+	// batch. The reader recovers from the corruption at the next block, whose
+	// first complete batch is c, so that a and b are lost.
+	setup := func() (vfs.FS, []uint64) {
+		mem := vfs.NewMem()
+		d, err := Open("", &Options{FS: mem})
+		require.NoError(t, err)
+		var seqNums []uint64
+		for _, key := range keys {
+			b := d.NewBatch()
+			require.NoError(t, b.Set([]byte(key), bytes.Repeat([]byte(key), 20<<10), nil))
+			require.NoError(t, b.Commit(nil))
+			seqNums = append(seqNums, b.SeqNum())
+		}
+		require.NoError(t, d.Close())
+
+		wals := listWALs(t, mem, "")
+		path := base.MakeFilepath(mem, "", fileTypeLog, wals[len(wals)-1])
+		f, err := mem.Open(path)
+		require.NoError(t, err)
+		data, err := io.ReadAll(f)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		data[100] ^= 0xff
+		f, err = mem.Create(path)
+		require.NoError(t, err)
+		_, err = f.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		return mem, seqNums
+	}
+	present := func(d *DB) []string {
+		var got []string
+		for _, key := range keys {
+			_, closer, err := d.Get([]byte(key))
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			require.NoError(t, err)
+			require.NoError(t, closer.Close())
+			got = append(got, key)
+		}
+		return got
+	}
+
+	for _, tc := range []struct {
+		mode WALRecoveryMode
+		want []string
+	}{
+		{mode: WALRecoveryTolerateCorruptTail, want: nil},
+		{mode: WALRecoveryStrict},
+		{mode: WALRecoverySkipAndReport, want: []string{"c", "d", "e", "f"}},
+	} {
+		t.Run(tc.mode.String(), func(t *testing.T) {
+			mem, seqNums := setup()
+			var infos []WALCorruptionInfo
+			opts := &Options{FS: mem, WALRecoveryMode: tc.mode}
+			opts.EventListener = &EventListener{
+				WALCorruption: func(info WALCorruptionInfo) {
+					infos = append(infos, info)
+				},
+			}
+			d, err := Open("", opts)
+			require.Len(t, infos, 1)
+			require.Equal(t, [2]uint64{seqNums[0], seqNums[2]}, infos[0].LostSeqNums)
+			require.Equal(t, tc.mode == WALRecoverySkipAndReport, infos[0].Skipped)
+			if tc.mode == WALRecoveryStrict {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, present(d))
+			require.NoError(t, d.Close())
+		})
+	}
+}
+
+// TestWALRecoveryModeOlderWAL tests that a corruption that runs to the end of
+// a WAL file that isn't the most recent is reported, with the lost sequence
+// numbers ending at the first batch of the following WAL file.
+func TestWALRecoveryModeOlderWAL(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("db", &Options{FS: mem})
+	require.NoError(t, err)
+
+	// Prevent flushes so that the WAL files aren't deleted.
+	d.mu.Lock()
+	d.mu.compact.flushing = true
+	d.mu.Unlock()
+
+	var seqNums []uint64
+	set := func(key string) {
+		b := d.NewBatch()
+		require.NoError(t, b.Set([]byte(key), bytes.Repeat([]byte(key), 20<<10), nil))
+		require.NoError(t, b.Commit(nil))
+		seqNums = append(seqNums, b.SeqNum())
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		set(key)
+	}
+	d.AsyncFlush()
+	for _, key := range []string{"d", "e"} {
+		set(key)
+	}
+
+	// Copy the files while both WAL files are unflushed.
+	require.NoError(t, mem.MkdirAll("replay", 0755))
+	ls, err := mem.List("db")
+	require.NoError(t, err)
+	for _, f := range ls {
+		require.NoError(t, vfs.Copy(mem, mem.PathJoin("db", f), mem.PathJoin("replay", f)))
+	}
+	d.mu.Lock()
+	d.mu.compact.flushing = false
+	d.mu.Unlock()
+	require.NoError(t, d.Close())
+
+	// Corrupt the last batch of the older WAL file, which holds c, so that the
+	// corruption runs to the end of the file.
+	wals := listWALs(t, mem, "replay")
+	require.Len(t, wals, 2)
+	path := base.MakeFilepath(mem, "replay", fileTypeLog, wals[0])
+	f, err := mem.Open(path)
+	require.NoError(t, err)
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	data[50<<10] ^= 0xff
+	f, err = mem.Create(path)
+	require.NoError(t, err)
+	_, err = f.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	var infos []WALCorruptionInfo
+	opts := &Options{FS: mem, WALRecoveryMode: WALRecoverySkipAndReport}
+	opts.EventListener = &EventListener{
+		WALCorruption: func(info WALCorruptionInfo) {
+			infos = append(infos, info)
+		},
+	}
+	d, err = Open("replay", opts)
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	require.Equal(t, wals[0], infos[0].FileNum.DiskFileNum())
+	require.Equal(t, [2]uint64{seqNums[2], seqNums[3]}, infos[0].LostSeqNums)
+	require.True(t, infos[0].Skipped)
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		_, closer, err := d.Get([]byte(key))
+		if key == "c" {
+			require.ErrorIs(t, err, ErrNotFound)
+			continue
+		}
+		require.NoError(t, err)
+		require.NoError(t, closer.Close())
+	}
+	require.NoError(t, d.Close())
+}
+
+func TestWALRecoveryModeParse(t *testing.T) {
+	for m := WALRecoveryTolerateCorruptTail; m <= WALRecoverySkipAndReport; m++ {
+		opts := (&Options{WALRecoveryMode: m}).EnsureDefaults()
+		parsed := &Options{}
+		require.NoError(t, parsed.Parse(opts.String(), nil))
+		require.Equal(t, m, parsed.WALRecoveryMode)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// WALReplayTarget is the point in the history of a DB at which Open stops
+// replaying the WAL, for point-in-time recovery: for example, to recover the
+// DB to just before a faulty deployment wrote garbage to it. See
+// Options.WALReplayTarget.
+//
+// Only the WAL files that have not been flushed yet are replayed, so the
+// target must follow all of the data already flushed or ingested into
+// sstables; otherwise Open returns an error. Batches following the target are
+// discarded: unless the DB is opened with Options.ReadOnly, the WAL files
+// containing them become obsolete and are deleted (or archived, see
+// Options.WALArchive) once the DB is opened. Opening the DB with
+// Options.ReadOnly allows inspecting the recovered state without discarding
+// anything.
+type WALReplayTarget struct {
+	// SeqNum, if nonzero, stops replay at the first batch containing a
+	// sequence number at or above SeqNum.
+	SeqNum uint64
+
+	// Time, if nonzero, stops replay at the first batch committed after Time.
+	// Commit times are recorded in the WAL with millisecond granularity when
+	// Options.WALRecordCommitTimes is set, so batches committed within the
+	// millisecond of Time are replayed. Open returns an error if a WAL file
+	// replayed before reaching the target does not record commit times.
+	Time time.Time
+}
+
+// walCommitTimeMagic starts the commit time records written to the WAL when
+// Options.WALRecordCommitTimes is set. Like walEncryptionMagic, it is never
+// confused with a batch.
+var walCommitTimeMagic = []byte("pwaltim\xff")
+
+// encodeWALCommitTime returns a commit time record holding the given time in
+// milliseconds since the Unix epoch.
+func encodeWALCommitTime(millis int64) []byte {
+	buf := make([]byte, len(walCommitTimeMagic)+8)
+	copy(buf, walCommitTimeMagic)
+	binary.LittleEndian.PutUint64(buf[len(walCommitTimeMagic):], uint64(millis))
+	return buf
+}
+
+// decodeWALCommitTime returns the time held by a commit time record, or false
+// if the record is not a commit time record.
+func decodeWALCommitTime(rec []byte) (millis int64, ok bool) {
+	if len(rec) != len(walCommitTimeMagic)+8 || !bytes.HasPrefix(rec, walCommitTimeMagic) {
+		return 0, false
+	}
+	return int64(binary.LittleEndian.Uint64(rec[len(walCommitTimeMagic):])), true
+}
+
+// DecodeWALCommitTime returns the commit time held by the WAL record rec, or
+// false if rec isn't a commit time record. Commit time records are written to
+// the WAL between batches when Options.WALRecordCommitTimes is set, and must
+// be skipped by tools that read the batches of WAL files directly.
+func DecodeWALCommitTime(rec []byte) (time.Time, bool) {
+	millis, ok := decodeWALCommitTime(rec)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(millis), true
+}
+
+// writeWALCommitTime writes a commit time record to the WAL, unless the
+// current time (in milliseconds) was the last written to the WAL file. Each
+// commit time record applies to the batches that follow it in the WAL file.
+// Requires commitPipeline.mu to be held.
+func (d *DB) writeWALCommitTime() error {
+	millis := d.timeNow().UnixMilli()
+	if millis == d.mu.log.commitTime {
+		return nil
+	}
+	if _, err := d.appendWALRecord(encodeWALCommitTime(millis), nil, nil); err != nil {
+		return err
+	}
+	d.mu.log.commitTime = millis
+	return nil
+}
+
+// writeWALRecord writes the batch representation to the WAL, preceded by its
+// commit time if Options.WALRecordCommitTimes is set. Requires
+// commitPipeline.mu to be held.
+func (d *DB) writeWALRecord(
+	repr []byte, syncWG *sync.WaitGroup, syncErr *error,
+) (int64, error) {
+	if d.opts.WALRecordCommitTimes {
+		if err := d.writeWALCommitTime(); err != nil {
+			return 0, err
+		}
+	}
+	return d.appendWALRecord(repr, syncWG, syncErr)
+}
+
+// checkWALReplayTarget returns an error if data following the WAL replay
+// target, which begins at the given sequence number, was already flushed or
+// ingested into sstables. Requires d.mu to be held.
+func (d *DB) checkWALReplayTarget(seqNum uint64) error {
+	v := d.mu.versions.currentVersion()
+	for level := range v.Levels {
+		iter := v.Levels[level].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if f.LargestSeqNum >= seqNum {
+				return errors.Errorf("pebble: WAL replay target precedes table %s, "+
+					"which contains sequence number %d following the target",
+					errors.Safe(f.FileNum), errors.Safe(f.LargestSeqNum))
+			}
+		}
+	}
+	return nil
+}
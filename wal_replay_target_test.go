@@ -0,0 +1,107 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+// requireKeys verifies which of the keys a, b, c and d are present in the DB.
+func requireKeys(t *testing.T, d *DB, want ...string) {
+	t.Helper()
+	var got []string
+	for _, key := range []string{"a", "b", "c", "d"} {
+		_, closer, err := d.Get([]byte(key))
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		require.NoError(t, err)
+		require.NoError(t, closer.Close())
+		got = append(got, key)
+	}
+	require.Equal(t, want, got)
+}
+
+func TestWALReplayTargetSeqNum(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{FS: mem})
+	require.NoError(t, err)
+	var seqNums []uint64
+	for _, key := range []string{"a", "b", "c", "d"} {
+		b := d.NewBatch()
+		require.NoError(t, b.Set([]byte(key), nil, nil))
+		require.NoError(t, b.Commit(nil))
+		seqNums = append(seqNums, b.SeqNum())
+	}
+	require.NoError(t, d.Close())
+
+	// Opening the DB read-only recovers the target without discarding the
+	// batches following it.
+	opts := &Options{FS: mem, ReadOnly: true}
+	opts.WALReplayTarget.SeqNum = seqNums[1]
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	requireKeys(t, d, "a")
+	require.NoError(t, d.Close())
+
+	opts = &Options{FS: mem}
+	opts.WALReplayTarget.SeqNum = seqNums[2]
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	requireKeys(t, d, "a", "b")
+	require.NoError(t, d.Set([]byte("d"), nil, nil))
+	require.NoError(t, d.Close())
+
+	// The batches following the target were discarded.
+	d, err = Open("", &Options{FS: mem})
+	require.NoError(t, err)
+	requireKeys(t, d, "a", "b", "d")
+
+	// A target preceding flushed data cannot be recovered.
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Set([]byte("c"), nil, nil))
+	require.NoError(t, d.Close())
+	opts = &Options{FS: mem}
+	opts.WALReplayTarget.SeqNum = 1
+	_, err = Open("", opts)
+	require.Error(t, err)
+}
+
+func TestWALReplayTargetTime(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{FS: mem}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	require.NoError(t, d.Set([]byte("a"), nil, nil))
+	require.NoError(t, d.Close())
+
+	// Commit times are required to stop at a point in time.
+	start := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	opts.WALReplayTarget.Time = start
+	_, err = Open("", opts)
+	require.Error(t, err)
+
+	opts = &Options{FS: mem, WALRecordCommitTimes: true}
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	now := start
+	d.timeNow = func() time.Time { return now }
+	for _, key := range []string{"b", "c", "d"} {
+		require.NoError(t, d.Set([]byte(key), nil, nil))
+		now = now.Add(time.Second)
+	}
+	require.NoError(t, d.Close())
+
+	opts.WALReplayTarget.Time = start.Add(1500 * time.Millisecond)
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	requireKeys(t, d, "a", "b", "c")
+	require.NoError(t, d.Close())
+}
@@ -0,0 +1,316 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sort"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/record"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// walBlockSize is the size of the blocks of the WAL record format. Records
+// never start within the trailer of a block, so a record reader may start
+// reading at any block boundary.
+const walBlockSize = 32 << 10
+
+// WALPosition is a position in the WAL of a DB, from which a WALTailer reads.
+// The zero WALPosition is the start of the oldest WAL file still available.
+type WALPosition struct {
+	// FileNum is the file number of the WAL file.
+	FileNum base.DiskFileNum
+	// Offset is the offset within the WAL file of the next record to read.
+	Offset int64
+}
+
+// WALBatch is a committed batch read from the WAL by a WALTailer.
+type WALBatch struct {
+	// SeqNum is the sequence number of the first entry of the batch. The
+	// entries of the batch have consecutive sequence numbers.
+	SeqNum uint64
+	// Count is the number of entries in the batch.
+	Count uint32
+	// Repr is the batch representation (see Batch.Repr), decrypted if the WAL
+	// is encrypted. It is only valid until the next call to WALTailer.Next.
+	Repr []byte
+	// Next is the position following the batch. A WALTailer created at Next
+	// resumes reading with the batch that follows this one.
+	Next WALPosition
+}
+
+// Reader returns a BatchReader over the entries of the batch.
+func (b *WALBatch) Reader() BatchReader {
+	r, _ := ReadBatch(b.Repr)
+	return r
+}
+
+// WALTailer reads the batches committed to a DB from its WAL, in sequence
+// number order, for change data capture and replication. It reads the live
+// WAL files of the DB, and the WAL files archived into
+// Options.WALArchive.Dir, so that a WALTailer that falls behind the flushing
+// of memtables continues reading from the archive. Without a WAL archive,
+// WAL files are deleted or recycled once flushed, and a WALTailer that falls
+// behind returns an error.
+//
+// Only batches that are visible to readers are returned. Batches committed
+// without Sync may be returned before they are durable, and may be lost if
+// the process crashes.
+//
+// A WALTailer is not safe for concurrent use, and must be closed.
+type WALTailer struct {
+	d   *DB
+	pos WALPosition
+	buf bytes.Buffer
+
+	// The WAL file being read, if any. The record reader reads the file from
+	// blockStart, the start of the 32KB block containing the position the file
+	// was opened at.
+	f          vfs.File
+	rr         *record.Reader
+	blockStart int64
+	dec        walDecoder
+	// complete is set if a later WAL file existed when the file was opened,
+	// in which case the file is no longer written to.
+	complete bool
+}
+
+// NewWALTailer returns a WALTailer that reads the batches committed to the DB
+// from the given position.
+func (d *DB) NewWALTailer(start WALPosition) *WALTailer {
+	return &WALTailer{d: d, pos: start}
+}
+
+// Position returns the position of the next batch read by Next.
+func (t *WALTailer) Position() WALPosition {
+	return t.pos
+}
+
+// Next returns the next committed batch. It returns nil if all of the batches
+// committed so far have been read; the caller may call Next again later to
+// read batches committed since.
+func (t *WALTailer) Next() (*WALBatch, error) {
+	if err := t.d.closed.Load(); err != nil {
+		panic(err)
+	}
+	for {
+		if t.rr == nil {
+			if ok, err := t.open(); err != nil || !ok {
+				return nil, err
+			}
+		}
+
+		t.buf.Reset()
+		r, err := t.rr.Next()
+		if err == nil {
+			_, err = io.Copy(&t.buf, r)
+		}
+		if err != nil {
+			if err != io.EOF && !record.IsInvalidRecord(err) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, err
+			}
+			// The end of the records written to the file.
+			complete := t.complete
+			t.closeFile()
+			if !complete {
+				return nil, nil
+			}
+			if err := t.advance(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		end := t.blockStart + t.rr.Offset()
+		if end <= t.pos.Offset {
+			// The record precedes the position within its block, and was
+			// returned before.
+			continue
+		}
+		repr, err := t.dec.decode(t.buf.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := decodeWALCommitTime(repr); ok || repr == nil {
+			// The encryption header of the WAL file, or a commit time record.
+			t.pos.Offset = end
+			continue
+		}
+		if len(repr) < batchHeaderLen {
+			return nil, base.CorruptionErrorf("pebble: corrupt WAL file %s at offset %d",
+				errors.Safe(t.pos.FileNum), errors.Safe(t.pos.Offset))
+		}
+		b := &WALBatch{Repr: repr}
+		b.SeqNum = binary.LittleEndian.Uint64(b.Repr[:batchCountOffset])
+		b.Count = binary.LittleEndian.Uint32(b.Repr[batchCountOffset:batchHeaderLen])
+		if b.SeqNum+uint64(b.Count) > t.d.mu.versions.visibleSeqNum.Load() {
+			// The batch is not visible yet. It is read again by a later call.
+			t.closeFile()
+			return nil, nil
+		}
+		t.pos.Offset = end
+		b.Next = t.pos
+		return b, nil
+	}
+}
+
+// Close closes the WALTailer.
+func (t *WALTailer) Close() error {
+	if t.f == nil {
+		return nil
+	}
+	err := t.f.Close()
+	t.f, t.rr = nil, nil
+	return err
+}
+
+func (t *WALTailer) closeFile() {
+	// The file was only read, so an error closing it is irrelevant.
+	_ = t.Close()
+}
+
+// walSegment is a WAL file readable by a WALTailer or a ReplicationSource.
+type walSegment struct {
+	fileNum base.DiskFileNum
+	path    string
+}
+
+// walSegments returns the live and archived WAL files of the DB, in file
+// number order.
+func (d *DB) walSegments() ([]walSegment, error) {
+	fs := d.opts.FS
+	// The live WAL files are listed before the archive: an obsolete WAL file
+	// is archived before it's no longer listed as being archived, so it's
+	// listed either way.
+	var archiving, live []walSegment
+	d.mu.Lock()
+	for _, fi := range d.mu.log.archiving {
+		archiving = append(archiving, walSegment{
+			fileNum: fi.fileNum,
+			path:    base.MakeFilepath(fs, d.walDirname, fileTypeLog, fi.fileNum),
+		})
+	}
+	for _, fi := range d.mu.log.queue {
+		live = append(live, walSegment{
+			fileNum: fi.fileNum,
+			path:    base.MakeFilepath(fs, d.walDirname, fileTypeLog, fi.fileNum),
+		})
+	}
+	d.mu.Unlock()
+	segs := archiving
+	if dir := d.opts.WALArchive.Dir; dir != "" {
+		ls, err := fs.List(dir)
+		if err != nil && !oserror.IsNotExist(err) {
+			return nil, err
+		}
+		for _, filename := range ls {
+			if ft, fileNum, ok := base.ParseFilename(fs, filename); ok && ft == fileTypeLog {
+				segs = append(segs, walSegment{fileNum: fileNum, path: fs.PathJoin(dir, filename)})
+			}
+		}
+	}
+	segs = append(segs, live...)
+	// A WAL file may be listed more than once. The last listing is preferred:
+	// a live file over an archived one, and an archived file over one being
+	// archived, which may be deleted once archived.
+	sort.SliceStable(segs, func(i, j int) bool {
+		return segs[i].fileNum.FileNum() < segs[j].fileNum.FileNum()
+	})
+	n := 0
+	for i := range segs {
+		if n > 0 && segs[n-1].fileNum == segs[i].fileNum {
+			n--
+		}
+		segs[n] = segs[i]
+		n++
+	}
+	return segs[:n], nil
+}
+
+// open opens the WAL file at the tailer's position. It returns false if there
+// is no such file yet.
+func (t *WALTailer) open() (bool, error) {
+	ok, err := t.openSegment()
+	if oserror.IsNotExist(err) {
+		// The WAL file was being archived, and was deleted once archived since
+		// it was listed. It's now listed in the archive.
+		ok, err = t.openSegment()
+	}
+	return ok, err
+}
+
+func (t *WALTailer) openSegment() (bool, error) {
+	segs, err := t.d.walSegments()
+	if err != nil {
+		return false, err
+	}
+	i := sort.Search(len(segs), func(i int) bool {
+		return segs[i].fileNum.FileNum() >= t.pos.FileNum.FileNum()
+	})
+	if i == len(segs) {
+		return false, nil
+	}
+	if segs[i].fileNum != t.pos.FileNum {
+		if t.pos != (WALPosition{}) {
+			return false, errors.Errorf("pebble: WAL file %s is no longer available", errors.Safe(t.pos.FileNum))
+		}
+		t.pos.FileNum = segs[i].fileNum
+	}
+	f, err := t.d.opts.FS.Open(segs[i].path)
+	if err != nil {
+		return false, err
+	}
+	t.dec = walDecoder{km: t.d.opts.WALKeyManager}
+	if t.pos.Offset > 0 {
+		// The encryption header of the WAL file, if any, precedes the position
+		// and is read first.
+		if err := t.readHeader(f); err != nil {
+			_ = f.Close()
+			return false, err
+		}
+	}
+	t.f = f
+	t.blockStart = t.pos.Offset &^ (walBlockSize - 1)
+	t.complete = i < len(segs)-1
+	t.rr = record.NewReader(io.NewSectionReader(f, t.blockStart, 1<<62), t.pos.FileNum.FileNum())
+	return true, nil
+}
+
+// readHeader decodes the first record of the WAL file, which is the
+// encryption header if the WAL file is encrypted.
+func (t *WALTailer) readHeader(f vfs.File) error {
+	rr := record.NewReader(io.NewSectionReader(f, 0, 1<<62), t.pos.FileNum.FileNum())
+	r, err := rr.Next()
+	if err != nil {
+		return err
+	}
+	t.buf.Reset()
+	if _, err := io.Copy(&t.buf, r); err != nil {
+		return err
+	}
+	_, err = t.dec.decode(t.buf.Bytes())
+	return err
+}
+
+// advance moves the tailer's position to the start of the WAL file following
+// the one at its position.
+func (t *WALTailer) advance() error {
+	segs, err := t.d.walSegments()
+	if err != nil {
+		return err
+	}
+	for _, seg := range segs {
+		if seg.fileNum.FileNum() > t.pos.FileNum.FileNum() {
+			t.pos = WALPosition{FileNum: seg.fileNum}
+			return nil
+		}
+	}
+	return errors.AssertionFailedf("pebble: no WAL file follows the complete WAL file %s", t.pos.FileNum)
+}
@@ -0,0 +1,129 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWALTailer(t *testing.T) {
+	opts := &Options{FS: vfs.NewMem()}
+	opts.WALArchive.Dir = "archive"
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+	d.testingAlwaysWaitForCleanup = true
+
+	tailer := d.NewWALTailer(WALPosition{})
+	defer func() {
+		require.NoError(t, tailer.Close())
+	}()
+
+	var keys []string
+	var positions []WALPosition
+	// readAll reads the batches committed since the last call, verifying that
+	// they contain the expected keys in sequence number order.
+	var nextSeqNum uint64
+	readAll := func() {
+		t.Helper()
+		for {
+			b, err := tailer.Next()
+			require.NoError(t, err)
+			if b == nil {
+				break
+			}
+			if nextSeqNum != 0 {
+				require.Equal(t, nextSeqNum, b.SeqNum)
+			}
+			nextSeqNum = b.SeqNum + uint64(b.Count)
+			r := b.Reader()
+			for {
+				kind, ukey, _, ok := r.Next()
+				if !ok {
+					break
+				}
+				require.Equal(t, InternalKeyKindSet, kind)
+				require.Equal(t, keys[0], string(ukey))
+				keys = keys[1:]
+			}
+			positions = append(positions, b.Next)
+		}
+		require.Empty(t, keys)
+	}
+
+	value := bytes.Repeat([]byte("v"), 100)
+	for i := 0; i < 100; i++ {
+		b := d.NewBatch()
+		// Every tenth batch is larger than a block of the WAL.
+		n := 1
+		if i%10 == 9 {
+			n = 500
+		}
+		for j := 0; j < n; j++ {
+			key := fmt.Sprintf("%03d-%03d", i, j)
+			require.NoError(t, b.Set([]byte(key), value, nil))
+			keys = append(keys, key)
+		}
+		require.NoError(t, b.Commit(nil))
+		if i%20 == 19 {
+			require.NoError(t, d.Flush())
+		}
+		if i%7 == 0 {
+			readAll()
+		}
+	}
+	readAll()
+	require.Len(t, positions, 100)
+
+	// The obsolete WAL files were archived, and a tailer resumes from the
+	// position following any batch.
+	for _, i := range []int{0, 9, 40, 98} {
+		resumed := d.NewWALTailer(positions[i])
+		var n int
+		for {
+			b, err := resumed.Next()
+			require.NoError(t, err)
+			if b == nil {
+				break
+			}
+			require.Equal(t, positions[i+1+n], b.Next)
+			n++
+		}
+		require.Equal(t, 99-i, n)
+		require.NoError(t, resumed.Close())
+	}
+}
+
+func TestWALTailerUnavailable(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+	d.testingAlwaysWaitForCleanup = true
+
+	require.NoError(t, d.Set([]byte("a"), nil, nil))
+	tailer := d.NewWALTailer(WALPosition{})
+	b, err := tailer.Next()
+	require.NoError(t, err)
+	require.NotNil(t, b)
+	require.NoError(t, tailer.Close())
+
+	// Without a WAL archive, the WAL file is deleted or recycled once flushed.
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Set([]byte("b"), nil, nil))
+	require.NoError(t, d.Flush())
+	tailer = d.NewWALTailer(b.Next)
+	_, err = tailer.Next()
+	require.Error(t, err)
+	require.NoError(t, tailer.Close())
+}
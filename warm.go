@@ -0,0 +1,268 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/rate"
+	"github.com/cockroachdb/pebble/objstorage"
+)
+
+// warmChunkSize bounds the size of the chunks in which sstables are read into
+// the secondary cache, between which the rate limit is applied and the
+// cancelation is checked.
+const warmChunkSize = 1 << 20 /* 1MB */
+
+// warmChunkAlignment is the granularity of the chunks in which sstables are
+// read into the secondary cache, which matches the default block size of the
+// cache so that no block is read from remote storage twice.
+const warmChunkAlignment = 32 << 10 /* 32KB */
+
+// WarmOptions configures DB.WarmSecondaryCache.
+type WarmOptions struct {
+	// Span, if valid, restricts warming to the sstables that overlap the span
+	// [Start, End). Otherwise all of the remote sstables of the DB are warmed.
+	Span KeyRange
+
+	// BytesPerSecond, if positive, limits the rate at which sstables are read
+	// from remote storage.
+	BytesPerSecond int64
+
+	// Progress, if set, is called after each chunk of an sstable is read into
+	// the secondary cache, or once if there are no sstables to warm.
+	Progress func(WarmProgress)
+}
+
+// WarmProgress describes the progress of DB.WarmSecondaryCache.
+type WarmProgress struct {
+	// Tables is the number of sstables warmed, out of TotalTables.
+	Tables, TotalTables int
+	// Bytes is the number of bytes of the sstables read into the secondary
+	// cache, out of TotalBytes.
+	Bytes, TotalBytes int64
+}
+
+// WarmSecondaryCache reads the sstables of the DB that reside on remote
+// storage into the secondary cache (see
+// Options.Experimental.SecondaryCacheSizeBytes), so that later reads of them
+// do not have to wait on remote storage. It is intended to be used ahead of
+// reads of data that is new to the DB, such as after the ingestion of shared
+// sstables when a range of keys is rebalanced onto this DB. Blocks already in
+// the cache are not read again. Warming more data than fits in the cache
+// evicts the data warmed first.
+//
+// The sstables are those of the current version of the LSM, and sstables
+// removed by compactions while warming is in progress are skipped. Warming
+// stops with the context's error if the context is canceled.
+func (d *DB) WarmSecondaryCache(ctx context.Context, opts WarmOptions) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.Experimental.SecondaryCacheSizeBytes <= 0 {
+		return errors.New("pebble: no secondary cache configured")
+	}
+	if opts.Span.Valid() && d.cmp(opts.Span.Start, opts.Span.End) >= 0 {
+		return errors.Errorf("pebble: invalid warm span [%s, %s)",
+			d.opts.Comparer.FormatKey(opts.Span.Start), d.opts.Comparer.FormatKey(opts.Span.End))
+	}
+
+	rs := d.loadReadState()
+	defer rs.unref()
+	var backings []*fileBacking
+	for level := range rs.current.Levels {
+		files := rs.current.Levels[level].Slice()
+		if opts.Span.Valid() {
+			files = rs.current.Overlaps(level, d.cmp, opts.Span.Start, opts.Span.End, true /* exclusiveEnd */)
+		}
+		iter := files.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			backings = append(backings, f.FileBacking)
+		}
+	}
+	w := d.newCacheWarmer(ctx, opts.BytesPerSecond, opts.Progress)
+	return w.warm(backings)
+}
+
+// cacheWarmer reads remote sstables into the secondary cache, applying a
+// rate limit and reporting progress.
+type cacheWarmer struct {
+	d        *DB
+	ctx      context.Context
+	limiter  *rate.Limiter
+	buf      []byte
+	report   func(WarmProgress)
+	progress WarmProgress
+}
+
+func (d *DB) newCacheWarmer(
+	ctx context.Context, bytesPerSec int64, report func(WarmProgress),
+) *cacheWarmer {
+	w := &cacheWarmer{d: d, ctx: ctx, report: report}
+	chunkSize := int64(warmChunkSize)
+	if bytesPerSec > 0 {
+		// Read in chunks of a quarter of a second's worth of bytes, so that the
+		// limit is applied smoothly.
+		if chunkSize > bytesPerSec/4 {
+			chunkSize = bytesPerSec / 4 / warmChunkAlignment * warmChunkAlignment
+		}
+		if chunkSize < warmChunkAlignment {
+			chunkSize = warmChunkAlignment
+		}
+		w.limiter = rate.NewLimiter(float64(bytesPerSec), float64(chunkSize))
+	}
+	w.buf = make([]byte, chunkSize)
+	return w
+}
+
+// err returns the error that stops warming: the error of the context if it's
+// done, or ErrClosed once the DB is being closed.
+func (w *cacheWarmer) err() error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	if err := w.d.closed.Load(); err != nil {
+		return err.(error)
+	}
+	return nil
+}
+
+// warm reads the remote sstables among the backings into the secondary
+// cache. Backings that are listed more than once, such as those of virtual
+// sstables, are warmed once.
+func (w *cacheWarmer) warm(backings []*fileBacking) error {
+	seen := make(map[base.DiskFileNum]struct{}, len(backings))
+	var remote []objstorage.ObjectMetadata
+	for _, b := range backings {
+		if _, ok := seen[b.DiskFileNum]; ok {
+			continue
+		}
+		seen[b.DiskFileNum] = struct{}{}
+		meta, err := w.d.objProvider.Lookup(fileTypeTable, b.DiskFileNum)
+		if err != nil || !meta.IsRemote() {
+			// The sstable was deleted since it was listed, or is local.
+			continue
+		}
+		remote = append(remote, meta)
+		w.progress.TotalTables++
+		w.progress.TotalBytes += int64(b.Size)
+	}
+	for _, meta := range remote {
+		if err := w.warmTable(meta); err != nil {
+			return err
+		}
+	}
+	if len(remote) == 0 && w.report != nil {
+		w.report(w.progress)
+	}
+	return nil
+}
+
+func (w *cacheWarmer) warmTable(meta objstorage.ObjectMetadata) error {
+	if err := w.err(); err != nil {
+		return err
+	}
+	r, err := w.d.objProvider.OpenForReading(w.ctx, fileTypeTable, meta.DiskFileNum, objstorage.OpenOptions{})
+	if err != nil {
+		if _, lookupErr := w.d.objProvider.Lookup(fileTypeTable, meta.DiskFileNum); lookupErr != nil {
+			// The sstable was deleted by a compaction.
+			w.progress.Tables++
+			return nil
+		}
+		return err
+	}
+	defer r.Close()
+
+	size := r.Size()
+	for ofs := int64(0); ofs < size; {
+		n := int64(len(w.buf))
+		if n > size-ofs {
+			n = size - ofs
+		}
+		if w.limiter != nil {
+			w.limiter.Wait(float64(n))
+		}
+		if err := r.ReadAt(w.ctx, w.buf[:n], ofs); err != nil {
+			return err
+		}
+		ofs += n
+		w.progress.Bytes += n
+		w.d.mu.Lock()
+		w.d.mu.tableWarming.metrics.Bytes += n
+		if ofs == size {
+			w.d.mu.tableWarming.metrics.Tables++
+		}
+		w.d.mu.Unlock()
+		if ofs == size {
+			w.progress.Tables++
+		}
+		if w.report != nil {
+			w.report(w.progress)
+		}
+		if ofs < size {
+			if err := w.err(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// maybeWarmSSTablesLocked adds the remote sstables among the new files of an
+// ingestion to the queue of sstables to read into the secondary cache, if
+// Options.Experimental.WarmSecondaryCacheOnIngest is set.
+//
+// d.mu must be held when calling this.
+func (d *DB) maybeWarmSSTablesLocked(newFiles []newFileEntry) {
+	if !d.opts.Experimental.WarmSecondaryCacheOnIngest || d.opts.Experimental.SecondaryCacheSizeBytes <= 0 {
+		return
+	}
+	for _, f := range newFiles {
+		meta, err := d.objProvider.Lookup(fileTypeTable, f.Meta.FileBacking.DiskFileNum)
+		if err == nil && meta.IsRemote() {
+			d.mu.tableWarming.pending = append(d.mu.tableWarming.pending, f.Meta.FileBacking)
+		}
+	}
+	if d.shouldWarmSSTablesLocked() {
+		go d.warmSSTables()
+	}
+}
+
+// shouldWarmSSTablesLocked returns true if sstables should be read into the
+// secondary cache. DB.mu must be locked when calling.
+func (d *DB) shouldWarmSSTablesLocked() bool {
+	return !d.mu.tableWarming.warming &&
+		d.closed.Load() == nil &&
+		len(d.mu.tableWarming.pending) > 0
+}
+
+// warmSSTables reads the sstables in the pending queue into the secondary
+// cache, at the rate of Options.Experimental.SecondaryCacheWarmRate.
+func (d *DB) warmSSTables() {
+	d.mu.Lock()
+	if !d.shouldWarmSSTablesLocked() {
+		d.mu.Unlock()
+		return
+	}
+	pending := d.mu.tableWarming.pending
+	d.mu.tableWarming.pending = nil
+	d.mu.tableWarming.warming = true
+	d.mu.Unlock()
+
+	w := d.newCacheWarmer(context.Background(), d.opts.Experimental.SecondaryCacheWarmRate, nil /* report */)
+	if err := w.warm(pending); err != nil && d.closed.Load() == nil {
+		d.opts.Logger.Infof("pebble: failed to warm secondary cache: %s", err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.mu.tableWarming.warming = false
+	d.mu.tableWarming.cond.Broadcast()
+	if d.shouldWarmSSTablesLocked() {
+		go d.warmSSTables()
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2024 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/objstorage/objstorageprovider"
+	"github.com/cockroachdb/pebble/objstorage/remote"
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+// readCountingStorage wraps a remote.Storage, counting the bytes read from
+// its objects.
+type readCountingStorage struct {
+	remote.Storage
+	bytesRead *atomic.Int64
+}
+
+func (s readCountingStorage) ReadObject(
+	ctx context.Context, objName string,
+) (remote.ObjectReader, int64, error) {
+	r, size, err := s.Storage.ReadObject(ctx, objName)
+	if err != nil {
+		return nil, 0, err
+	}
+	return readCountingReader{ObjectReader: r, bytesRead: s.bytesRead}, size, nil
+}
+
+type readCountingReader struct {
+	remote.ObjectReader
+	bytesRead *atomic.Int64
+}
+
+func (r readCountingReader) ReadAt(ctx context.Context, p []byte, offset int64) error {
+	r.bytesRead.Add(int64(len(p)))
+	return r.ObjectReader.ReadAt(ctx, p, offset)
+}
+
+func TestWarmSecondaryCache(t *testing.T) {
+	var bytesRead atomic.Int64
+	var opts Options
+	opts.FS = vfs.NewMem()
+	opts.Experimental.RemoteStorage = remote.MakeSimpleFactory(map[remote.Locator]remote.Storage{
+		"": readCountingStorage{Storage: remote.NewInMem(), bytesRead: &bytesRead},
+	})
+	opts.Experimental.CreateOnShared = true
+	opts.Experimental.SecondaryCacheSizeBytes = 32 << 20
+	opts.DisableAutomaticCompactions = true
+	d, err := Open("", &opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	require.NoError(t, d.SetCreatorID(1))
+
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 1000; j++ {
+			key := []byte(fmt.Sprintf("%d-%04d", i, j))
+			require.NoError(t, d.Set(key, make([]byte, 100), nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+
+	var progress []WarmProgress
+	warm := func(span KeyRange) {
+		progress = progress[:0]
+		require.NoError(t, d.WarmSecondaryCache(context.Background(), WarmOptions{
+			Span:     span,
+			Progress: func(p WarmProgress) { progress = append(progress, p) },
+		}))
+	}
+	last := func() WarmProgress {
+		require.NotEmpty(t, progress)
+		return progress[len(progress)-1]
+	}
+	// waitCached waits for the sstables overlapping the span to be served by
+	// the secondary cache, which is written to asynchronously.
+	waitCached := func(span KeyRange) {
+		require.Eventually(t, func() bool {
+			before := bytesRead.Load()
+			warm(span)
+			return bytesRead.Load() == before
+		}, 10*time.Second, time.Millisecond)
+	}
+
+	// Warming a span only reads the sstables that overlap it.
+	before := bytesRead.Load()
+	warm(KeyRange{Start: []byte("0"), End: []byte("1")})
+	p := last()
+	require.Equal(t, 1, p.Tables)
+	require.Equal(t, p.TotalTables, p.Tables)
+	require.Equal(t, p.TotalBytes, p.Bytes)
+	require.Equal(t, p.Bytes, bytesRead.Load()-before)
+	waitCached(KeyRange{Start: []byte("0"), End: []byte("1")})
+
+	// Warming the sstable again is served by the secondary cache, while the
+	// other sstable is read from remote storage.
+	before = bytesRead.Load()
+	warm(KeyRange{})
+	p = last()
+	require.Equal(t, 2, p.Tables)
+	require.Equal(t, p.TotalBytes, p.Bytes)
+	require.Less(t, bytesRead.Load()-before, p.Bytes)
+	require.Greater(t, bytesRead.Load()-before, int64(0))
+	waitCached(KeyRange{})
+	require.GreaterOrEqual(t, d.Metrics().SecondaryCacheWarm.Tables, int64(5))
+
+	// Warming stops once the context is canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, d.WarmSecondaryCache(ctx, WarmOptions{}), context.Canceled)
+}
+
+func TestWarmSecondaryCacheOnIngest(t *testing.T) {
+	var bytesRead atomic.Int64
+	var opts Options
+	opts.FS = vfs.NewMem()
+	opts.Experimental.RemoteStorage = remote.MakeSimpleFactory(map[remote.Locator]remote.Storage{
+		"": readCountingStorage{Storage: remote.NewInMem(), bytesRead: &bytesRead},
+	})
+	opts.Experimental.CreateOnShared = true
+	opts.Experimental.SecondaryCacheSizeBytes = 32 << 20
+	opts.Experimental.WarmSecondaryCacheOnIngest = true
+	opts.Experimental.SecondaryCacheWarmRate = 100 << 20
+	opts.FormatMajorVersion = FormatNewest
+	d, err := Open("", &opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	require.NoError(t, d.SetCreatorID(1))
+
+	// Ingested sstables are created on shared storage, and warmed in the
+	// background.
+	f, err := opts.FS.Create("ext")
+	require.NoError(t, err)
+	w := sstable.NewWriter(objstorageprovider.NewFileWritable(f), sstable.WriterOptions{
+		TableFormat: d.FormatMajorVersion().MaxTableFormat(),
+	})
+	for j := 0; j < 1000; j++ {
+		require.NoError(t, w.Set([]byte(fmt.Sprintf("%04d", j)), make([]byte, 100)))
+	}
+	require.NoError(t, w.Close())
+	require.NoError(t, d.Ingest([]string{"ext"}))
+
+	d.mu.Lock()
+	for d.mu.tableWarming.warming || len(d.mu.tableWarming.pending) > 0 {
+		d.mu.tableWarming.cond.Wait()
+	}
+	d.mu.Unlock()
+	require.EqualValues(t, 1, d.Metrics().SecondaryCacheWarm.Tables)
+
+	// The cache is written to asynchronously, so the sstable is eventually
+	// served by the cache.
+	require.Eventually(t, func() bool {
+		before := bytesRead.Load()
+		require.NoError(t, d.WarmSecondaryCache(context.Background(), WarmOptions{}))
+		return bytesRead.Load() == before
+	}, 10*time.Second, time.Millisecond)
+}
@@ -0,0 +1,149 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// WritePriority classifies writes for admission control. Writes of a lower
+// priority are delayed earlier as the LSM falls behind, so that they yield to
+// higher priority writes before all writes are stalled.
+type WritePriority int8
+
+const (
+	// WritePriorityUser is the default priority, used for foreground writes.
+	// User writes are only subject to the write stalls imposed by
+	// Options.MemTableStopWritesThreshold and Options.L0StopWritesThreshold.
+	WritePriorityUser WritePriority = iota
+	// WritePriorityBackground is used for background writes that can tolerate
+	// being delayed, such as garbage collection.
+	WritePriorityBackground
+	// WritePriorityBulk is used for bulk writes that should yield to all other
+	// writes, such as data imports.
+	WritePriorityBulk
+
+	// NumWritePriorities is the number of write priorities.
+	NumWritePriorities
+)
+
+// String implements fmt.Stringer.
+func (p WritePriority) String() string {
+	switch p {
+	case WritePriorityUser:
+		return "user"
+	case WritePriorityBackground:
+		return "background"
+	case WritePriorityBulk:
+		return "bulk"
+	default:
+		return "unknown"
+	}
+}
+
+// WriteAdmissionThresholds configures the LSM state at which writes of a
+// priority are delayed. A write is delayed while any threshold is met or
+// exceeded, until flushes and compactions bring the LSM back under the
+// thresholds.
+type WriteAdmissionThresholds struct {
+	// L0ReadAmp is the L0 read amplification (the number of L0 sublevels) at
+	// which writes are delayed.
+	L0ReadAmp int
+	// CompactionDebt is the estimated compaction debt in bytes at which writes
+	// are delayed. If zero, compaction debt does not delay writes.
+	CompactionDebt uint64
+}
+
+// ensureWriteAdmissionDefaults sets the default write admission thresholds.
+// The L0ReadAmp thresholds are clamped to be no lower than
+// L0CompactionThreshold, and the thresholds of lower priorities are clamped to
+// be no higher than those of higher priorities, so that a lower priority write
+// is never admitted when a higher priority write would be delayed.
+func (o *Options) ensureWriteAdmissionDefaults() {
+	t := &o.Experimental.WriteAdmission
+	if t[WritePriorityBackground].L0ReadAmp <= 0 {
+		t[WritePriorityBackground].L0ReadAmp = o.L0StopWritesThreshold * 3 / 4
+	}
+	if t[WritePriorityBulk].L0ReadAmp <= 0 {
+		t[WritePriorityBulk].L0ReadAmp = o.L0StopWritesThreshold / 2
+	}
+	for p := WritePriorityBackground; p < NumWritePriorities; p++ {
+		if t[p].L0ReadAmp < o.L0CompactionThreshold {
+			t[p].L0ReadAmp = o.L0CompactionThreshold
+		}
+		if p == WritePriorityBackground {
+			continue
+		}
+		higher := &t[p-1]
+		if t[p].L0ReadAmp > higher.L0ReadAmp {
+			t[p].L0ReadAmp = higher.L0ReadAmp
+		}
+		if higher.CompactionDebt != 0 && (t[p].CompactionDebt == 0 || t[p].CompactionDebt > higher.CompactionDebt) {
+			t[p].CompactionDebt = higher.CompactionDebt
+		}
+	}
+}
+
+// admitWrite blocks until a write of the provided priority is admitted, and
+// returns the time spent waiting. If the write is still waiting after
+// Options.MaxWriteStallDuration, admitWrite returns an error wrapping
+// ErrWriteStallTimeout. If the DB enters degraded mode during the wait, it
+// returns an error wrapping ErrDegraded. If noWait is set, admitWrite returns
+// errWriteWouldWait instead of waiting.
+func (d *DB) admitWrite(priority WritePriority, noWait bool) (time.Duration, error) {
+	if priority == WritePriorityUser {
+		return 0, nil
+	}
+	if priority < 0 || priority >= NumWritePriorities {
+		return 0, errors.Errorf("pebble: invalid write priority %d", errors.Safe(priority))
+	}
+	t := d.opts.Experimental.WriteAdmission[priority]
+
+	var start time.Time
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for {
+		if err := d.closed.Load(); err != nil {
+			return 0, err.(error)
+		}
+		throttled := d.mu.versions.currentVersion().L0Sublevels.ReadAmplification() >= t.L0ReadAmp
+		if !throttled && t.CompactionDebt > 0 {
+			throttled = d.mu.versions.picker.estimatedCompactionDebt(0) >= t.CompactionDebt
+		}
+		if !throttled {
+			break
+		}
+		if noWait {
+			return 0, errWriteWouldWait
+		}
+		if err := d.degradedErr(); err != nil {
+			return 0, err
+		}
+		if start.IsZero() {
+			start = time.Now()
+			if maxWait := d.opts.MaxWriteStallDuration; maxWait > 0 {
+				// Wake this writer at the deadline, as nothing may signal the
+				// condition variable if no flushes or compactions run.
+				timer := time.AfterFunc(maxWait, func() {
+					d.mu.Lock()
+					defer d.mu.Unlock()
+					d.mu.compact.cond.Broadcast()
+				})
+				defer timer.Stop()
+			}
+		} else if maxWait := d.opts.MaxWriteStallDuration; maxWait > 0 && time.Since(start) >= maxWait {
+			return 0, errors.Wrapf(ErrWriteStallTimeout,
+				"%s write not admitted", errors.Safe(priority))
+		}
+		// Flushes and compactions signal the condition variable on completion.
+		d.mu.compact.cond.Wait()
+	}
+	if start.IsZero() {
+		return 0, nil
+	}
+	return time.Since(start), nil
+}
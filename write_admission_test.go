@@ -0,0 +1,117 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteAdmissionDefaults(t *testing.T) {
+	opts := (&Options{}).EnsureDefaults()
+	require.Equal(t, 9, opts.Experimental.WriteAdmission[WritePriorityBackground].L0ReadAmp)
+	require.Equal(t, 6, opts.Experimental.WriteAdmission[WritePriorityBulk].L0ReadAmp)
+
+	opts = &Options{L0CompactionThreshold: 8, L0StopWritesThreshold: 10}
+	opts.Experimental.WriteAdmission[WritePriorityBulk].L0ReadAmp = 2
+	opts.EnsureDefaults()
+	require.Equal(t, 8, opts.Experimental.WriteAdmission[WritePriorityBackground].L0ReadAmp)
+	require.Equal(t, 8, opts.Experimental.WriteAdmission[WritePriorityBulk].L0ReadAmp)
+
+	// Lower priorities are clamped to the thresholds of higher priorities.
+	opts = &Options{}
+	opts.Experimental.WriteAdmission[WritePriorityBackground] = WriteAdmissionThresholds{
+		L0ReadAmp: 6, CompactionDebt: 100,
+	}
+	opts.Experimental.WriteAdmission[WritePriorityBulk] = WriteAdmissionThresholds{
+		L0ReadAmp: 10,
+	}
+	opts.EnsureDefaults()
+	require.Equal(t, WriteAdmissionThresholds{L0ReadAmp: 6, CompactionDebt: 100},
+		opts.Experimental.WriteAdmission[WritePriorityBulk])
+	opts.Experimental.WriteAdmission[WritePriorityBulk].CompactionDebt = 50
+	opts.EnsureDefaults()
+	require.Equal(t, uint64(50), opts.Experimental.WriteAdmission[WritePriorityBulk].CompactionDebt)
+}
+
+func TestWriteAdmission(t *testing.T) {
+	opts := &Options{
+		FS:                          vfs.NewMem(),
+		L0CompactionThreshold:       1,
+		DisableAutomaticCompactions: true,
+	}
+	opts.Experimental.WriteAdmission[WritePriorityBackground].L0ReadAmp = 3
+	opts.Experimental.WriteAdmission[WritePriorityBulk].L0ReadAmp = 2
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Create two overlapping L0 sublevels.
+	for i := 0; i < 2; i++ {
+		require.NoError(t, d.Set([]byte("a"), nil, nil))
+		require.NoError(t, d.Set([]byte("z"), nil, nil))
+		require.NoError(t, d.Flush())
+	}
+	require.Equal(t, int32(2), d.Metrics().Levels[0].Sublevels)
+
+	// User and background writes are admitted.
+	require.NoError(t, d.Set([]byte("b"), nil, nil))
+	require.NoError(t, d.Set([]byte("c"), nil, &WriteOptions{Priority: WritePriorityBackground}))
+	require.Error(t, d.Set([]byte("c"), nil, &WriteOptions{Priority: NumWritePriorities}))
+
+	// Bulk writes are delayed until L0 is compacted.
+	b := d.NewBatch()
+	require.NoError(t, b.Set([]byte("d"), nil, nil))
+	done := make(chan error, 1)
+	go func() { done <- d.Apply(b, &WriteOptions{Priority: WritePriorityBulk}) }()
+	select {
+	case err := <-done:
+		t.Fatalf("bulk write was not delayed: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	require.NoError(t, d.Compact([]byte("a"), []byte("zz"), false))
+	require.NoError(t, <-done)
+	require.Greater(t, b.CommitStats().AdmissionWaitDuration, time.Duration(0))
+	require.NoError(t, b.Close())
+	verifyGet(t, d, []byte("d"), nil)
+}
+
+func TestWriteAdmissionTimeout(t *testing.T) {
+	opts := &Options{
+		FS:                          vfs.NewMem(),
+		L0CompactionThreshold:       1,
+		DisableAutomaticCompactions: true,
+		MaxWriteStallDuration:       20 * time.Millisecond,
+	}
+	opts.Experimental.WriteAdmission[WritePriorityBulk].L0ReadAmp = 2
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, d.Set([]byte("a"), nil, nil))
+		require.NoError(t, d.Set([]byte("z"), nil, nil))
+		require.NoError(t, d.Flush())
+	}
+
+	// With automatic compactions disabled, nothing reduces L0 read
+	// amplification, and the bulk write gives up after MaxWriteStallDuration.
+	start := time.Now()
+	err = d.Set([]byte("b"), nil, &WriteOptions{Priority: WritePriorityBulk})
+	require.True(t, errors.Is(err, ErrWriteStallTimeout), "%v", err)
+	require.GreaterOrEqual(t, time.Since(start), opts.MaxWriteStallDuration)
+	verifyGetNotFound(t, d, []byte("b"))
+
+	// User writes are not subject to write admission.
+	require.NoError(t, d.Set([]byte("b"), nil, nil))
+
+	// Bulk writes are admitted again once L0 is compacted.
+	require.NoError(t, d.Compact([]byte("a"), []byte("zz"), false))
+	require.NoError(t, d.Set([]byte("c"), nil, &WriteOptions{Priority: WritePriorityBulk}))
+}
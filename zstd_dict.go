@@ -0,0 +1,30 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import "sync"
+
+// zstdDictionaries holds the zstd dictionary of each level, trained from the
+// data blocks of the sstable most recently written to the level, with which
+// the next sstable written to the level is compressed. See
+// LevelOptions.ZstdDictionarySize. The dictionaries are not persisted: the
+// first sstable written to each level after Open is compressed without a
+// dictionary.
+type zstdDictionaries struct {
+	mu    sync.Mutex
+	dicts [numLevels][]byte
+}
+
+func (z *zstdDictionaries) get(level int) []byte {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.dicts[level]
+}
+
+func (z *zstdDictionaries) set(level int, dict []byte) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.dicts[level] = dict
+}
@@ -0,0 +1,58 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZstdDictionaryLevelOption(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{FS: mem, FormatMajorVersion: ExperimentalFormatZstdDictionaries - 1}
+	opts.Levels = []LevelOptions{{Compression: ZstdCompression, ZstdDictionarySize: 4 << 10}}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	flush := func(round int) {
+		for i := 0; i < 5000; i++ {
+			key := fmt.Sprintf("key%d-%06d", round, i)
+			value := fmt.Sprintf(`{"id":%d,"status":"active","plan":"premium"}`, i*7919)
+			require.NoError(t, d.Set([]byte(key), []byte(value), nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+
+	// Dictionaries aren't used below ExperimentalFormatZstdDictionaries.
+	flush(0)
+	require.Nil(t, d.zstdDicts.get(0))
+	require.NoError(t, d.RatchetFormatMajorVersion(ExperimentalFormatZstdDictionaries))
+
+	// The first flush trains the dictionary with which the second flush
+	// compresses its sstable.
+	for round := 1; round < 3; round++ {
+		flush(round)
+		require.NotNil(t, d.zstdDicts.get(0))
+	}
+	require.NoError(t, d.Close())
+
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	iter, _ := d.NewIter(nil)
+	n := 0
+	for valid := iter.First(); valid; valid = iter.Next() {
+		n++
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, 15000, n)
+	require.NoError(t, d.Close())
+
+	parsed := &Options{}
+	require.NoError(t, parsed.Parse(opts.EnsureDefaults().String(), nil))
+	require.Equal(t, 4<<10, parsed.Levels[0].ZstdDictionarySize)
+}